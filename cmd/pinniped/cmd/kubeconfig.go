@@ -150,11 +150,14 @@ func kubeconfigCommand(deps kubeconfigDeps) *cobra.Command {
 		"upstream-identity-provider-type",
 		"",
 		fmt.Sprintf(
-			"The type of the upstream identity provider used during login with a Supervisor (e.g. '%s', '%s', '%s', '%s')",
+			"The type of the upstream identity provider used during login with a Supervisor (e.g. '%s', '%s', '%s', '%s', '%s', '%s', '%s')",
 			idpdiscoveryv1alpha1.IDPTypeOIDC,
 			idpdiscoveryv1alpha1.IDPTypeLDAP,
 			idpdiscoveryv1alpha1.IDPTypeActiveDirectory,
 			idpdiscoveryv1alpha1.IDPTypeGitHub,
+			idpdiscoveryv1alpha1.IDPTypeSAML,
+			idpdiscoveryv1alpha1.IDPTypeGitLab,
+			idpdiscoveryv1alpha1.IDPTypeOAuth2,
 		),
 	)
 	f.StringVar(&flags.oidc.upstreamIDPFlow, "upstream-identity-provider-flow", "", fmt.Sprintf("The type of client flow to use with the upstream identity provider during login with a Supervisor (e.g. '%s', '%s')", idpdiscoveryv1alpha1.IDPFlowCLIPassword, idpdiscoveryv1alpha1.IDPFlowBrowserAuthcode))
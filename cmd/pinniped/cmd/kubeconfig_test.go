@@ -135,7 +135,7 @@ func TestGetKubeconfig(t *testing.T) {
 			  --timeout duration                         Timeout for autodiscovery and validation (default 10m0s)
 			  --upstream-identity-provider-flow string   The type of client flow to use with the upstream identity provider during login with a Supervisor (e.g. 'cli_password', 'browser_authcode')
 			  --upstream-identity-provider-name string   The name of the upstream identity provider used during login with a Supervisor
-			  --upstream-identity-provider-type string   The type of the upstream identity provider used during login with a Supervisor (e.g. 'oidc', 'ldap', 'activedirectory', 'github')
+			  --upstream-identity-provider-type string   The type of the upstream identity provider used during login with a Supervisor (e.g. 'oidc', 'ldap', 'activedirectory', 'github', 'saml', 'gitlab', 'oauth2')
 	`)
 
 	tests := []struct {
@@ -27,6 +27,7 @@ import (
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/pkg/conciergeclient"
 	"go.pinniped.dev/pkg/oidcclient"
+	"go.pinniped.dev/pkg/oidcclient/dpop"
 	"go.pinniped.dev/pkg/oidcclient/filesession"
 	"go.pinniped.dev/pkg/oidcclient/oidctypes"
 )
@@ -94,6 +95,7 @@ type oidcLoginFlags struct {
 	conciergeCABundle            string
 	conciergeAPIGroupSuffix      string
 	credentialCachePath          string
+	dpopKeyPath                  string
 	upstreamIdentityProviderName string
 	upstreamIdentityProviderType string
 	upstreamIdentityProviderFlow string
@@ -140,16 +142,20 @@ func oidcLoginCommand(deps oidcLoginCommandDeps) *cobra.Command {
 	cmd.Flags().StringVar(&flags.conciergeCABundle, "concierge-ca-bundle-data", "", "CA bundle to use when connecting to the Concierge")
 	cmd.Flags().StringVar(&flags.conciergeAPIGroupSuffix, "concierge-api-group-suffix", groupsuffix.PinnipedDefaultSuffix, "Concierge API group suffix")
 	cmd.Flags().StringVar(&flags.credentialCachePath, "credential-cache", filepath.Join(mustGetConfigDir(), "credentials.yaml"), "Path to cluster-specific credentials cache (\"\" disables the cache)")
+	cmd.Flags().StringVar(&flags.dpopKeyPath, "dpop-key", filepath.Join(mustGetConfigDir(), "dpop-key.pem"), "Path to the private key which binds Supervisor tokens to this client using DPoP (\"\" disables DPoP)")
 	cmd.Flags().StringVar(&flags.upstreamIdentityProviderName, "upstream-identity-provider-name", "", "The name of the upstream identity provider used during login with a Supervisor")
 	cmd.Flags().StringVar(&flags.upstreamIdentityProviderType,
 		"upstream-identity-provider-type",
 		idpdiscoveryv1alpha1.IDPTypeOIDC.String(),
 		fmt.Sprintf(
-			"The type of the upstream identity provider used during login with a Supervisor (e.g. '%s', '%s', '%s', '%s')",
+			"The type of the upstream identity provider used during login with a Supervisor (e.g. '%s', '%s', '%s', '%s', '%s', '%s', '%s')",
 			idpdiscoveryv1alpha1.IDPTypeOIDC,
 			idpdiscoveryv1alpha1.IDPTypeLDAP,
 			idpdiscoveryv1alpha1.IDPTypeActiveDirectory,
 			idpdiscoveryv1alpha1.IDPTypeGitHub,
+			idpdiscoveryv1alpha1.IDPTypeSAML,
+			idpdiscoveryv1alpha1.IDPTypeGitLab,
+			idpdiscoveryv1alpha1.IDPTypeOAuth2,
 		))
 	cmd.Flags().StringVar(&flags.upstreamIdentityProviderFlow, "upstream-identity-provider-flow", "", fmt.Sprintf("The type of client flow to use with the upstream identity provider during login with a Supervisor (e.g. '%s', '%s')", idpdiscoveryv1alpha1.IDPFlowBrowserAuthcode, idpdiscoveryv1alpha1.IDPFlowCLIPassword))
 
@@ -203,6 +209,11 @@ func runOIDCLogin(cmd *cobra.Command, deps oidcLoginCommandDeps, flags oidcLogin
 		opts = append(opts, deps.optionsFactory.WithRequestAudience(flags.requestAudience))
 	}
 
+	// The key is only used when the issuer supports DPoP, so it is only created upon first use with such an issuer.
+	if flags.dpopKeyPath != "" {
+		opts = append(opts, deps.optionsFactory.WithDPoPKeyStore(dpop.NewFileKeyStore(flags.dpopKeyPath)))
+	}
+
 	if flags.upstreamIdentityProviderName != "" {
 		opts = append(opts, deps.optionsFactory.WithUpstreamIdentityProvider(
 			flags.upstreamIdentityProviderName, flags.upstreamIdentityProviderType))
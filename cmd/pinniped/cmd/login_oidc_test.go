@@ -51,6 +51,7 @@ func TestLoginOIDCCommand(t *testing.T) {
 		f.EXPECT().WithLoginLogger(gomock.Any())
 		f.EXPECT().WithScopes([]string{oidcapi.ScopeOfflineAccess, oidcapi.ScopeOpenID, oidcapi.ScopeRequestAudience, oidcapi.ScopeUsername, oidcapi.ScopeGroups})
 		f.EXPECT().WithSessionCache(gomock.Any())
+		f.EXPECT().WithDPoPKeyStore(gomock.Any())
 	}
 
 	tests := []struct {
@@ -93,6 +94,7 @@ func TestLoginOIDCCommand(t *testing.T) {
 				      --concierge-ca-bundle-data string          CA bundle to use when connecting to the Concierge
 				      --concierge-endpoint string                API base for the Concierge endpoint
 				      --credential-cache string                  Path to cluster-specific credentials cache ("" disables the cache) (default "` + cfgDir + `/credentials.yaml")
+				      --dpop-key string                          Path to the private key which binds Supervisor tokens to this client using DPoP ("" disables DPoP) (default "` + cfgDir + `/dpop-key.pem")
 				      --enable-concierge                         Use the Concierge to login
 				  -h, --help                                     help for oidc
 				      --issuer string                            OpenID Connect issuer URL
@@ -103,7 +105,7 @@ func TestLoginOIDCCommand(t *testing.T) {
 				      --skip-browser                             Skip opening the browser (just print the URL)
 					  --upstream-identity-provider-flow string   The type of client flow to use with the upstream identity provider during login with a Supervisor (e.g. 'browser_authcode', 'cli_password')
 					  --upstream-identity-provider-name string   The name of the upstream identity provider used during login with a Supervisor
-					  --upstream-identity-provider-type string   The type of the upstream identity provider used during login with a Supervisor (e.g. 'oidc', 'ldap', 'activedirectory', 'github') (default "oidc")
+					  --upstream-identity-provider-type string   The type of the upstream identity provider used during login with a Supervisor (e.g. 'oidc', 'ldap', 'activedirectory', 'github', 'saml', 'gitlab', 'oauth2') (default "oidc")
 			`),
 		},
 		{
@@ -178,7 +180,7 @@ func TestLoginOIDCCommand(t *testing.T) {
 				"--credential-cache", "", // must specify --credential-cache or else the cache file on disk causes test pollution
 			},
 			wantOptions:      defaultWantedOptions,
-			wantOptionsCount: 4,
+			wantOptionsCount: 5,
 			wantStdout:       `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{"interactive":false},"status":{"expirationTimestamp":"3020-10-12T13:14:15Z","token":"test-id-token"}}` + "\n",
 		},
 		{
@@ -194,7 +196,7 @@ func TestLoginOIDCCommand(t *testing.T) {
 				defaultWantedOptions(f)
 				f.EXPECT().WithSkipPrintLoginURL()
 			},
-			wantOptionsCount: 5,
+			wantOptionsCount: 6,
 			wantStdout:       `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{"interactive":false},"status":{"expirationTimestamp":"3020-10-12T13:14:15Z","token":"test-id-token"}}` + "\n",
 		},
 		{
@@ -209,7 +211,7 @@ func TestLoginOIDCCommand(t *testing.T) {
 				defaultWantedOptions(f)
 				f.EXPECT().WithLoginFlow(idpdiscoveryv1alpha1.IDPFlowCLIPassword, "--upstream-identity-provider-flow")
 			},
-			wantOptionsCount: 5,
+			wantOptionsCount: 6,
 			wantStdout:       `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{"interactive":false},"status":{"expirationTimestamp":"3020-10-12T13:14:15Z","token":"test-id-token"}}` + "\n",
 		},
 		{
@@ -225,7 +227,7 @@ func TestLoginOIDCCommand(t *testing.T) {
 				defaultWantedOptions(f)
 				f.EXPECT().WithLoginFlow(idpdiscoveryv1alpha1.IDPFlow("actual-value-from-env"), "PINNIPED_UPSTREAM_IDENTITY_PROVIDER_FLOW")
 			},
-			wantOptionsCount: 5,
+			wantOptionsCount: 6,
 			wantStdout:       `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{"interactive":false},"status":{"expirationTimestamp":"3020-10-12T13:14:15Z","token":"test-id-token"}}` + "\n",
 		},
 		{
@@ -237,7 +239,7 @@ func TestLoginOIDCCommand(t *testing.T) {
 			},
 			loginErr:         fmt.Errorf("some login error"),
 			wantOptions:      defaultWantedOptions,
-			wantOptionsCount: 4,
+			wantOptionsCount: 5,
 			wantError:        true,
 			wantStderr: here.Doc(`
 				Error: could not complete Pinniped login: some login error
@@ -256,12 +258,29 @@ func TestLoginOIDCCommand(t *testing.T) {
 			},
 			conciergeErr:     fmt.Errorf("some concierge error"),
 			wantOptions:      defaultWantedOptions,
-			wantOptionsCount: 4,
+			wantOptionsCount: 5,
 			wantError:        true,
 			wantStderr: here.Doc(`
 				Error: could not complete Concierge credential exchange: some concierge error
 			`),
 		},
+		{
+			name: "--dpop-key with an empty value disables DPoP",
+			args: []string{
+				"--client-id", "test-client-id",
+				"--issuer", "test-issuer",
+				"--dpop-key", "",
+				"--credential-cache", "", // must specify --credential-cache or else the cache file on disk causes test pollution
+			},
+			wantOptions: func(f *mockoidcclientoptions.MockOIDCClientOptions) {
+				f.EXPECT().WithContext(gomock.Any())
+				f.EXPECT().WithLoginLogger(gomock.Any())
+				f.EXPECT().WithScopes([]string{oidcapi.ScopeOfflineAccess, oidcapi.ScopeOpenID, oidcapi.ScopeRequestAudience, oidcapi.ScopeUsername, oidcapi.ScopeGroups})
+				f.EXPECT().WithSessionCache(gomock.Any())
+			},
+			wantOptionsCount: 4,
+			wantStdout:       `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{"interactive":false},"status":{"expirationTimestamp":"3020-10-12T13:14:15Z","token":"test-id-token"}}` + "\n",
+		},
 		{
 			name: "success with minimal options",
 			args: []string{
@@ -271,11 +290,11 @@ func TestLoginOIDCCommand(t *testing.T) {
 			},
 			env:              map[string]string{"PINNIPED_DEBUG": "true"},
 			wantOptions:      defaultWantedOptions,
-			wantOptionsCount: 4,
+			wantOptionsCount: 5,
 			wantStdout:       `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{"interactive":false},"status":{"expirationTimestamp":"3020-10-12T13:14:15Z","token":"test-id-token"}}` + "\n",
 			wantLogs: []string{
-				nowStr + `  cmd/login_oidc.go:267  Performing OIDC login  {"issuer": "test-issuer", "client id": "test-client-id"}`,
-				nowStr + `  cmd/login_oidc.go:287  No concierge configured, skipping token credential exchange`,
+				nowStr + `  cmd/login_oidc.go:278  Performing OIDC login  {"issuer": "test-issuer", "client id": "test-client-id"}`,
+				nowStr + `  cmd/login_oidc.go:298  No concierge configured, skipping token credential exchange`,
 			},
 		},
 		{
@@ -300,6 +319,7 @@ func TestLoginOIDCCommand(t *testing.T) {
 				"--upstream-identity-provider-name", "some-upstream-name",
 				"--upstream-identity-provider-type", "ldap",
 				"--upstream-identity-provider-flow", "some-flow-type",
+				"--dpop-key", t.TempDir() + "/dpop-key.pem",
 			},
 			env: map[string]string{"PINNIPED_DEBUG": "true", "PINNIPED_SKIP_PRINT_LOGIN_URL": "true"},
 			wantOptions: func(f *mockoidcclientoptions.MockOIDCClientOptions) {
@@ -313,16 +333,17 @@ func TestLoginOIDCCommand(t *testing.T) {
 				f.EXPECT().WithSkipPrintLoginURL()
 				f.EXPECT().WithClient(gomock.Any())
 				f.EXPECT().WithRequestAudience("cluster-1234")
+				f.EXPECT().WithDPoPKeyStore(gomock.Any())
 				f.EXPECT().WithLoginFlow(idpdiscoveryv1alpha1.IDPFlow("some-flow-type"), "--upstream-identity-provider-flow")
 				f.EXPECT().WithUpstreamIdentityProvider("some-upstream-name", "ldap")
 			},
-			wantOptionsCount: 12,
+			wantOptionsCount: 13,
 			wantStdout:       `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1beta1","spec":{"interactive":false},"status":{"token":"exchanged-token"}}` + "\n",
 			wantLogs: []string{
-				nowStr + `  cmd/login_oidc.go:267  Performing OIDC login  {"issuer": "test-issuer", "client id": "test-client-id"}`,
-				nowStr + `  cmd/login_oidc.go:277  Exchanging token for cluster credential  {"endpoint": "https://127.0.0.1:1234/", "authenticator type": "webhook", "authenticator name": "test-authenticator"}`,
-				nowStr + `  cmd/login_oidc.go:285  Successfully exchanged token for cluster credential.`,
-				nowStr + `  cmd/login_oidc.go:292  caching cluster credential for future use.`,
+				nowStr + `  cmd/login_oidc.go:278  Performing OIDC login  {"issuer": "test-issuer", "client id": "test-client-id"}`,
+				nowStr + `  cmd/login_oidc.go:288  Exchanging token for cluster credential  {"endpoint": "https://127.0.0.1:1234/", "authenticator type": "webhook", "authenticator name": "test-authenticator"}`,
+				nowStr + `  cmd/login_oidc.go:296  Successfully exchanged token for cluster credential.`,
+				nowStr + `  cmd/login_oidc.go:303  caching cluster credential for future use.`,
 			},
 		},
 	}
@@ -9,6 +9,7 @@ import (
 
 	"go.pinniped.dev/generated/latest/apis/supervisor/idpdiscovery/v1alpha1"
 	"go.pinniped.dev/pkg/oidcclient"
+	"go.pinniped.dev/pkg/oidcclient/dpop"
 )
 
 // OIDCClientOptions is an interface that wraps the creation of Options for the purpose of making them
@@ -28,6 +29,7 @@ type OIDCClientOptions interface {
 	WithRequestAudience(audience string) oidcclient.Option
 	WithLoginFlow(loginFlow v1alpha1.IDPFlow, flowSource string) oidcclient.Option
 	WithUpstreamIdentityProvider(upstreamName, upstreamType string) oidcclient.Option
+	WithDPoPKeyStore(keyStore dpop.KeyStore) oidcclient.Option
 }
 
 // clientOptions implements OIDCClientOptions for production use.
@@ -82,3 +84,7 @@ func (o *clientOptions) WithLoginFlow(loginFlow v1alpha1.IDPFlow, flowSource str
 func (o *clientOptions) WithUpstreamIdentityProvider(upstreamName, upstreamType string) oidcclient.Option {
 	return oidcclient.WithUpstreamIdentityProvider(upstreamName, upstreamType)
 }
+
+func (o *clientOptions) WithDPoPKeyStore(keyStore dpop.KeyStore) oidcclient.Option {
+	return oidcclient.WithDPoPKeyStore(keyStore)
+}
@@ -66,6 +66,16 @@ type JWTTokenClaims struct {
 	// username from the JWT token. When not specified, it will default to "username".
 	// +optional
 	Username string `json:"username"`
+
+	// Actor is the name of the claim which should be read to extract the identity of the actor which is
+	// acting on behalf of the user, as described by RFC 8693. The value of the claim must be an object
+	// with "sub" and "username" claims, such as the "act" claim of the tokens issued by a delegated
+	// token exchange of the Pinniped Supervisor. The actor is added to the user's extra info using the
+	// "authentication.concierge.pinniped.dev/actor-subject" and "authentication.concierge.pinniped.dev/actor-username"
+	// keys. Since client certificates cannot hold extra info, TokenCredentialRequests for tokens which
+	// have an actor are refused. When not specified, the actor is ignored.
+	// +optional
+	Actor string `json:"actor"`
 }
 
 // JWTAuthenticator describes the configuration of a JWT authenticator.
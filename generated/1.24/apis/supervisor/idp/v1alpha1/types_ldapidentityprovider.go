@@ -20,6 +20,19 @@ const (
 	LDAPPhaseError LDAPIdentityProviderPhase = "Error"
 )
 
+type LDAPBindMethod string
+
+const (
+	// LDAPBindMethodSimple binds using the username and password of the bind account.
+	LDAPBindMethodSimple LDAPBindMethod = "Simple"
+
+	// LDAPBindMethodGSSAPI binds using SASL GSSAPI (Kerberos) with the keytab of the bind account.
+	LDAPBindMethodGSSAPI LDAPBindMethod = "GSSAPI"
+
+	// LDAPBindMethodExternal binds using SASL EXTERNAL with the TLS client certificate of the bind account.
+	LDAPBindMethodExternal LDAPBindMethod = "External"
+)
+
 // Status of an LDAP identity provider.
 type LDAPIdentityProviderStatus struct {
 	// Phase summarizes the overall status of the LDAPIdentityProvider.
@@ -36,13 +49,46 @@ type LDAPIdentityProviderStatus struct {
 }
 
 type LDAPIdentityProviderBind struct {
-	// SecretName contains the name of a namespace-local Secret object that provides the username and
-	// password for an LDAP bind user. This account will be used to perform LDAP searches. The Secret should be
-	// of type "kubernetes.io/basic-auth" which includes "username" and "password" keys. The username value
-	// should be the full dn (distinguished name) of your bind account, e.g. "cn=bind-account,ou=users,dc=example,dc=com".
-	// The password must be non-empty.
+	// Method determines how the Supervisor binds as the bind account. "Simple" uses a username and password.
+	// "GSSAPI" uses a SASL GSSAPI (Kerberos) bind using a keytab. "External" uses a SASL EXTERNAL bind, where the
+	// LDAP server identifies the bind account using the client certificate which is presented during the TLS
+	// handshake.
+	// Optional. When not specified, defaults to "Simple".
+	// +kubebuilder:validation:Enum=Simple;GSSAPI;External
+	// +kubebuilder:default=Simple
+	// +optional
+	Method LDAPBindMethod `json:"method,omitempty"`
+
+	// SecretName contains the name of a namespace-local Secret object that provides the credentials of the bind
+	// account. This account will be used to perform LDAP searches.
+	// When the Method is "Simple", the Secret should be of type "kubernetes.io/basic-auth" which includes "username"
+	// and "password" keys. The username value should be the full dn (distinguished name) of your bind account,
+	// e.g. "cn=bind-account,ou=users,dc=example,dc=com". The password must be non-empty.
+	// When the Method is "GSSAPI", the Secret should be of type "Opaque" which includes "username", "keytab", and
+	// "krb5.conf" keys. The username value should be the Kerberos principal name of your bind account, e.g.
+	// "bind-account", the keytab value should be a keytab file which contains the keys of that principal, and the
+	// krb5.conf value should be a Kerberos configuration file which describes how to find the KDCs of the realm.
+	// When the Method is "External", the Secret should be of type "kubernetes.io/tls" which includes "tls.crt" and
+	// "tls.key" keys, which contain the PEM-encoded client certificate and private key of your bind account.
 	// +kubebuilder:validation:MinLength=1
 	SecretName string `json:"secretName"`
+
+	// GSSAPI contains additional settings for the "GSSAPI" Method.
+	// +optional
+	GSSAPI *LDAPIdentityProviderGSSAPIBind `json:"gssapi,omitempty"`
+}
+
+// LDAPIdentityProviderGSSAPIBind contains the settings for SASL GSSAPI (Kerberos) binds.
+type LDAPIdentityProviderGSSAPIBind struct {
+	// Realm is the Kerberos realm of the bind account's principal, e.g. "EXAMPLE.COM".
+	// Optional. When not specified, the default_realm of the krb5.conf from the bind Secret is used.
+	// +optional
+	Realm string `json:"realm,omitempty"`
+
+	// ServicePrincipalName is the Kerberos service principal name of the LDAP server, e.g. "ldap/dc1.example.com".
+	// Optional. When not specified, it will be "ldap/" followed by the hostname of the server which is being used.
+	// +optional
+	ServicePrincipalName string `json:"servicePrincipalName,omitempty"`
 }
 
 type LDAPIdentityProviderUserSearchAttributes struct {
@@ -62,6 +108,19 @@ type LDAPIdentityProviderUserSearchAttributes struct {
 	// server in the user's entry. Distinguished names can be used by specifying lower-case "dn".
 	// +kubebuilder:validation:MinLength=1
 	UID string `json:"uid,omitempty"`
+
+	// AdditionalClaimMappings allows for additional arbitrary attribute values of the user's LDAP entry to be mapped
+	// into the "additionalClaims" claim of the ID tokens generated by the Supervisor. This should be specified as a map
+	// of new claim names as the keys, and LDAP attribute names as the values, e.g. "email": "mail". The values of
+	// the attributes are fetched during the user search and are fetched again during each session refresh. A single
+	// valued attribute becomes a string claim, and a multi-valued attribute becomes a list of strings. These new claim
+	// names will be nested under the top-level "additionalClaims" claim in ID tokens generated by the Supervisor when
+	// this LDAPIdentityProvider was used for user authentication. These claims will be made available to all clients.
+	// The attribute names are case-sensitive and must match the case of the attribute names returned by the LDAP
+	// server in the user's entry. When this map is empty or the attributes are not available, the "additionalClaims"
+	// claim will be excluded from the ID tokens generated by the Supervisor.
+	// +optional
+	AdditionalClaimMappings map[string]string `json:"additionalClaimMappings,omitempty"`
 }
 
 type LDAPIdentityProviderGroupSearchAttributes struct {
@@ -131,6 +190,26 @@ type LDAPIdentityProviderGroupSearch struct {
 	// +optional
 	Attributes LDAPIdentityProviderGroupSearchAttributes `json:"attributes,omitempty"`
 
+	// NestedGroups configures the resolution of nested group membership, i.e. groups which are members of
+	// other groups. When not enabled, users will only belong to the groups which directly contain them.
+	// +optional
+	NestedGroups LDAPIdentityProviderNestedGroupSearch `json:"nestedGroups,omitempty"`
+
+	// CacheTTLSeconds is the number of seconds for which the result of the group search for a user is cached
+	// during refreshes. While a user's groups are cached, refreshes for any of the user's sessions on any of the
+	// Supervisor's pods will reuse the cached groups instead of searching for the user's groups again. The user
+	// entry is still searched for during each refresh, so a user who was deleted or whose password was changed
+	// will still fail to refresh. Changes to the user's group memberships, including removals, are reflected
+	// within this amount of time. Caching the groups reduces the load on the LDAP server compared to
+	// searching during each refresh, without keeping the groups static like SkipGroupRefresh.
+	// The cache entries are stored as Secrets in the Supervisor's namespace.
+	// Optional. When not specified, or when set to 0, the groups are not cached and are searched for during
+	// each refresh.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=86400
+	// +optional
+	CacheTTLSeconds int32 `json:"cacheTTLSeconds,omitempty"`
+
 	// The user's group membership is refreshed as they interact with the supervisor
 	// to obtain new credentials (as their old credentials expire).  This allows group
 	// membership changes to be quickly reflected into Kubernetes clusters.  Since
@@ -157,12 +236,50 @@ type LDAPIdentityProviderGroupSearch struct {
 	SkipGroupRefresh bool `json:"skipGroupRefresh,omitempty"`
 }
 
+// LDAPIdentityProviderNestedGroupSearch configures the resolution of nested group membership.
+type LDAPIdentityProviderNestedGroupSearch struct {
+	// Enabled turns on the resolution of nested group membership. After the group search finds the groups which
+	// contain the user, the group search will be performed again for each of those groups, replacing the "{}"
+	// placeholder(s) in the Filter with the dn (distinguished name) of the group, to find the groups which contain
+	// those groups, and so on. The user will belong to all the groups which were found. The dn of the group is
+	// used regardless of the value of UserAttributeForFilter, so the Filter must also match groups which contain
+	// other groups by their dn, e.g. "&(objectClass=groupOfNames)(member={})". Note that this performs one more
+	// group search for each group that was found, during each login and each refresh.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// MaxDepth is the maximum number of levels of nested groups to resolve, not counting the groups which
+	// directly contain the user. Groups which are nested more deeply will be ignored. Each group is only searched
+	// once, so groups which contain each other in a cycle do not cause more searches.
+	// Optional. When not specified, the default is 5.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=20
+	// +optional
+	MaxDepth int32 `json:"maxDepth,omitempty"`
+}
+
 // Spec for configuring an LDAP identity provider.
 type LDAPIdentityProviderSpec struct {
 	// Host is the hostname of this LDAP identity provider, i.e., where to connect. For example: ldap.example.com:636.
 	// +kubebuilder:validation:MinLength=1
 	Host string `json:"host"`
 
+	// AdditionalHosts is an ordered list of other hostnames of this LDAP identity provider, for example replicas
+	// of the server at the Host. For example: ldap2.example.com:636. When the Host cannot be reached, or it fails
+	// to bind as the bind account, then each additional host will be tried in order. Hosts which have recently
+	// failed will be tried after the hosts which have not. The Host is always used to identify users, so all of
+	// these hosts must serve the same directory. The connection settings in TLS are used for all of these hosts.
+	// +optional
+	// +listType=set
+	AdditionalHosts []string `json:"additionalHosts,omitempty"`
+
+	// SRVRecordName is the name of a DNS SRV record which is used to discover more hosts of this LDAP
+	// identity provider. For example: _ldap._tcp.example.com. The record is resolved each time a connection is needed,
+	// and the discovered hosts are tried after the Host and the AdditionalHosts, in the order of their priorities
+	// and weights. The Host is always used to identify users, so all discovered hosts must serve the same directory.
+	// +optional
+	SRVRecordName string `json:"srvRecordName,omitempty"`
+
 	// TLS contains the connection settings for how to establish the connection to the Host.
 	TLS *TLSSpec `json:"tls,omitempty"`
 
@@ -47,6 +47,31 @@ type GitHubIdentityProviderStatus struct {
 	// +listType=map
 	// +listMapKey=type
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// RateLimit reports the remaining GitHub API quota of the end user's access token which has the least quota
+	// remaining, as most recently reported by the GitHub API during the logins and refreshes which were handled by the
+	// Supervisor pod which updates this status. Logins and refreshes which were handled by other Supervisor pods are
+	// not reflected, so the quotas of access tokens used by other pods may be lower than reported here.
+	// It is empty when no quota has been reported since the Supervisor started, or when the quotas of all
+	// recently observed access tokens have since been reset.
+	//
+	// +optional
+	RateLimit *GitHubRateLimitStatus `json:"rateLimit,omitempty"`
+}
+
+// GitHubRateLimitStatus describes the GitHub API rate limit of an access token.
+type GitHubRateLimitStatus struct {
+	// Limit is the maximum number of requests which the access token may make per hour.
+	Limit int `json:"limit"`
+
+	// Remaining is the number of requests which the access token may make before the quota is reset.
+	Remaining int `json:"remaining"`
+
+	// ResetTime is the time at which the quota will be reset.
+	ResetTime metav1.Time `json:"resetTime"`
+
+	// ObservedTime is the time at which the GitHub API reported this quota.
+	ObservedTime metav1.Time `json:"observedTime"`
 }
 
 // GitHubAPIConfig allows configuration for GitHub Enterprise Server
@@ -104,6 +129,20 @@ const (
 	GitHubUseTeamSlugForGroupName GitHubGroupNameAttribute = "slug"
 )
 
+// GitHubAdditionalClaimAttribute allows the user to specify which information about the GitHub user shall be
+// mapped into an additional claim of the ID tokens generated by the Supervisor.
+// +kubebuilder:validation:Enum={"samlNameID","organizationRoles"}
+type GitHubAdditionalClaimAttribute string
+
+const (
+	// GitHubAdditionalClaimSAMLNameID specifies using the NameID of the SAML identity which the GitHub user has linked
+	// to their account by using SAML single sign-on for an organization, e.g. their corporate email address.
+	GitHubAdditionalClaimSAMLNameID GitHubAdditionalClaimAttribute = "samlNameID"
+
+	// GitHubAdditionalClaimOrganizationRoles specifies using the roles of the GitHub user in their organizations.
+	GitHubAdditionalClaimOrganizationRoles GitHubAdditionalClaimAttribute = "organizationRoles"
+)
+
 // GitHubClaims allows customization of the username and groups claims.
 type GitHubClaims struct {
 	// Username configures which property of the GitHub user record shall determine the username in Kubernetes.
@@ -156,6 +195,47 @@ type GitHubClaims struct {
 	// +kubebuilder:validation:Enum=name;slug
 	// +optional
 	Groups *GitHubGroupNameAttribute `json:"groups"`
+
+	// OrganizationRoleGroups, when true, adds a group for each of the user's organizations which names the user's role
+	// in that organization, in addition to the groups for the user's team memberships.
+	//
+	// These group names are the organization login name followed by a colon and the role (e.g. "my-org:admin").
+	// The role is "admin" for owners of the organization, "member" for other members of the organization, and
+	// "outside_collaborator" for users who are not members of the organization but who are collaborators on
+	// one or more of its repositories. When allowAuthentication.organizations.allowed is configured, then only
+	// the roles in those organizations are added. The roles are fetched using the GitHub GraphQL API during login
+	// and are fetched again during each session refresh.
+	//
+	// Defaults to false.
+	//
+	// +optional
+	OrganizationRoleGroups bool `json:"organizationRoleGroups,omitempty"`
+
+	// AdditionalClaimMappings allows for additional information about the GitHub user to be mapped into the
+	// "additionalClaims" claim of the ID tokens generated by the Supervisor. This should be specified as a map of
+	// new claim names as the keys, and GitHub user attributes as the values, e.g. "email": "samlNameID". The values
+	// are fetched using the GitHub GraphQL API during login and are fetched again during each session refresh.
+	// These new claim names will be nested under the top-level "additionalClaims" claim in ID tokens generated by
+	// the Supervisor when this GitHubIdentityProvider was used for user authentication. These claims will be made
+	// available to all clients. When this map is empty or the values are not available, the "additionalClaims"
+	// claim will be excluded from the ID tokens generated by the Supervisor.
+	//
+	// Can be either "samlNameID" or "organizationRoles".
+	//
+	// The "samlNameID" attribute becomes a string claim holding the NameID of the SAML identity which the user has
+	// linked to their GitHub account by using SAML single sign-on for one of their organizations, which is typically
+	// their corporate email address. When the user has linked SAML identities in several organizations, then the
+	// organization whose login name sorts first is used. When allowAuthentication.organizations.allowed is configured,
+	// then only those organizations are considered. GitHub only reveals linked SAML identities to users who are
+	// allowed to read them, so the claim is excluded when the user's identity is not available.
+	//
+	// The "organizationRoles" attribute becomes a claim holding a map of the user's organization login names to their
+	// roles in those organizations, using the same roles and organizations as described for organizationRoleGroups.
+	//
+	// See [Using the GraphQL API for Enterprises](https://docs.github.com/en/graphql/guides/managing-enterprise-accounts).
+	//
+	// +optional
+	AdditionalClaimMappings map[string]GitHubAdditionalClaimAttribute `json:"additionalClaimMappings,omitempty"`
 }
 
 // GitHubClientSpec contains information about the GitHub client that this identity provider will use
@@ -157,10 +157,52 @@ type OIDCClient struct {
 	// SecretName contains the name of a namespace-local Secret object that provides the clientID and
 	// clientSecret for an OIDC client. If only the SecretName is specified in an OIDCClient
 	// struct, then it is expected that the Secret is of type "secrets.pinniped.dev/oidc-client" with keys
-	// "clientID" and "clientSecret".
+	// "clientID" and "clientSecret". When the AuthenticationMethod is "private_key_jwt" or "tls_client_auth",
+	// then the "clientSecret" key is not required.
 	SecretName string `json:"secretName"`
+
+	// AuthenticationMethod is how the Supervisor authenticates itself as a client to the OIDC provider's token
+	// endpoint, for authorization code exchanges, password grants, and refreshes, and to the OIDC provider's
+	// revocation endpoint, if there is one. Allowed values are:
+	//
+	// "client_secret" uses the "clientSecret" from the Secret named by SecretName, sent either with HTTP basic
+	// authentication or in the request body.
+	//
+	// "private_key_jwt" sends a short-lived JWT, signed by the private key from the Secret named by
+	// CertificateSecretName, as described by https://openid.net/specs/openid-connect-core-1_0.html#ClientAuthentication
+	// and RFC 7523. The JWT headers include the thumbprints of the certificate, so the OIDC provider may identify
+	// the key either by a registered certificate or by a registered public key.
+	//
+	// "tls_client_auth" presents the certificate from the Secret named by CertificateSecretName during TLS handshakes
+	// with the OIDC provider, as described by RFC 8705.
+	//
+	// Defaults to "client_secret".
+	// +kubebuilder:validation:Enum=client_secret;private_key_jwt;tls_client_auth
+	// +optional
+	AuthenticationMethod OIDCClientAuthenticationMethod `json:"authenticationMethod,omitempty"`
+
+	// CertificateSecretName contains the name of a namespace-local Secret object of type "kubernetes.io/tls"
+	// which provides a PEM-encoded certificate in the "tls.crt" key and the corresponding PEM-encoded RSA or ECDSA
+	// private key in the "tls.key" key. It is required when the AuthenticationMethod is "private_key_jwt" or
+	// "tls_client_auth", and is not allowed otherwise.
+	// +optional
+	CertificateSecretName string `json:"certificateSecretName,omitempty"`
 }
 
+type OIDCClientAuthenticationMethod string
+
+const (
+	// OIDCClientAuthenticationMethodClientSecret means that the client authenticates using a client secret.
+	OIDCClientAuthenticationMethodClientSecret OIDCClientAuthenticationMethod = "client_secret"
+
+	// OIDCClientAuthenticationMethodPrivateKeyJWT means that the client authenticates using a JWT signed by
+	// its private key.
+	OIDCClientAuthenticationMethodPrivateKeyJWT OIDCClientAuthenticationMethod = "private_key_jwt"
+
+	// OIDCClientAuthenticationMethodTLSClientAuth means that the client authenticates using a TLS client certificate.
+	OIDCClientAuthenticationMethodTLSClientAuth OIDCClientAuthenticationMethod = "tls_client_auth"
+)
+
 // OIDCIdentityProviderSpec is the spec for configuring an OIDC identity provider.
 type OIDCIdentityProviderSpec struct {
 	// Issuer is the issuer URL of this OIDC identity provider, i.e., where to fetch
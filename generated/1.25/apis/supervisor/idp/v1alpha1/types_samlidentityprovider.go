@@ -0,0 +1,169 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type SAMLIdentityProviderPhase string
+
+const (
+	// SAMLPhasePending is the default phase for newly-created SAMLIdentityProvider resources.
+	SAMLPhasePending SAMLIdentityProviderPhase = "Pending"
+
+	// SAMLPhaseReady is the phase for an SAMLIdentityProvider resource in a healthy state.
+	SAMLPhaseReady SAMLIdentityProviderPhase = "Ready"
+
+	// SAMLPhaseError is the phase for an SAMLIdentityProvider in an unhealthy state.
+	SAMLPhaseError SAMLIdentityProviderPhase = "Error"
+)
+
+// SAMLIdentityProviderStatus is the status of an SAML identity provider.
+type SAMLIdentityProviderStatus struct {
+	// Phase summarizes the overall status of the SAMLIdentityProvider.
+	//
+	// +kubebuilder:default=Pending
+	// +kubebuilder:validation:Enum=Pending;Ready;Error
+	Phase SAMLIdentityProviderPhase `json:"phase,omitempty"`
+
+	// Conditions represents the observations of an identity provider's current state.
+	//
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// SAMLIdentityProviderMetadataSpec describes where to find the SAML metadata document of the identity provider.
+// Exactly one of url or xml must be specified.
+//
+// +kubebuilder:validation:XValidation:message="exactly one of spec.idpMetadata.url or spec.idpMetadata.xml must be specified",rule="has(self.url) != has(self.xml)"
+type SAMLIdentityProviderMetadataSpec struct {
+	// URL is the location from which the SAML metadata document of the identity provider can be downloaded,
+	// e.g. "https://adfs.example.com/FederationMetadata/2007-06/FederationMetadata.xml".
+	// The metadata will be downloaded again periodically, so changes to the identity provider's signing
+	// certificates will be noticed by the Supervisor.
+	//
+	// +kubebuilder:validation:Pattern=`^https://`
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// TLS configuration for downloading the metadata from the URL. Only used when url is specified.
+	//
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// XML is the SAML metadata document of the identity provider, provided inline.
+	// The document must be either an EntityDescriptor or an EntitiesDescriptor which contains an EntityDescriptor
+	// with an IDPSSODescriptor. When the identity provider rotates its signing certificates, this document must be
+	// updated.
+	//
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	XML string `json:"xml,omitempty"`
+}
+
+// SAMLClaims allows customization of the username and groups claims.
+type SAMLClaims struct {
+	// Username is the name of the attribute in the SAML assertion that will be used to determine the username in
+	// Kubernetes. The attribute will be matched by either its Name or its FriendlyName. When this attribute appears
+	// with multiple values, the first value will be used.
+	//
+	// When not set, the value of the NameID of the subject of the SAML assertion will be used as the username.
+	// Note that some identity providers issue transient NameIDs, which are different for every login, unless they
+	// are configured to issue a persistent NameID format. Please check the configuration of your identity provider.
+	//
+	// +optional
+	Username string `json:"username,omitempty"`
+
+	// Groups is the name of the attribute in the SAML assertion that will be used to determine the groups to which an
+	// identity belongs. The attribute will be matched by either its Name or its FriendlyName. Every value of the
+	// attribute will become a group name.
+	//
+	// When not set, the identities will not include any group memberships.
+	//
+	// +optional
+	Groups string `json:"groups,omitempty"`
+}
+
+// SAMLServiceProviderSpec configures how the Supervisor identifies itself to the identity provider
+// as a SAML service provider.
+type SAMLServiceProviderSpec struct {
+	// EntityID is the SAML entity ID of the Supervisor as a service provider. It will be sent as the issuer of
+	// authentication requests, and it must appear as the audience of SAML assertions issued by the identity provider.
+	//
+	// When not set, the issuer URL of each FederationDomain which uses this identity provider will be used as the
+	// entity ID. In that case, each FederationDomain must be registered as a separate service provider in your
+	// identity provider.
+	//
+	// The assertion consumer service (ACS) URL for each FederationDomain is always the issuer URL of the
+	// FederationDomain followed by "/saml/acs", and it must be registered with your identity provider using the
+	// HTTP-POST binding.
+	//
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	EntityID string `json:"entityID,omitempty"`
+
+	// SigningSecretName contains the name of a namespace-local Secret object that provides the private key and
+	// certificate which the Supervisor uses to sign its authentication requests. The same key will be used to
+	// decrypt assertions, when the identity provider encrypts its assertions.
+	//
+	// This secret must be of type "kubernetes.io/tls" with keys "tls.crt" and "tls.key". The key must be an RSA key.
+	//
+	// +kubebuilder:validation:MinLength=1
+	SigningSecretName string `json:"signingSecretName"`
+}
+
+// SAMLIdentityProviderSpec is the spec for configuring an SAML identity provider.
+type SAMLIdentityProviderSpec struct {
+	// IDPMetadata describes where to find the SAML metadata of the identity provider, which includes its single sign-on
+	// URL and the certificates used to sign its assertions.
+	IDPMetadata SAMLIdentityProviderMetadataSpec `json:"idpMetadata"`
+
+	// Claims allows customization of the username and groups claims.
+	//
+	// +optional
+	Claims SAMLClaims `json:"claims,omitempty"`
+
+	// ServiceProvider configures how the Supervisor identifies itself to the identity provider.
+	ServiceProvider SAMLServiceProviderSpec `json:"serviceProvider"`
+}
+
+// SAMLIdentityProvider describes the configuration of an upstream SAML 2.0 identity provider,
+// e.g. Active Directory Federation Services (ADFS) or Shibboleth.
+//
+// Only web-based logins are supported, using the HTTP-Redirect binding for authentication requests
+// and the HTTP-POST binding for responses. Because SAML does not offer a way to refresh a user's
+// session with the identity provider, the user's username and group memberships will not change
+// during downstream refreshes.
+//
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:categories=pinniped;pinniped-idp;pinniped-idps
+// +kubebuilder:printcolumn:name="Metadata URL",type=string,JSONPath=`.spec.idpMetadata.url`
+// +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// +kubebuilder:subresource:status
+type SAMLIdentityProvider struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec for configuring the identity provider.
+	Spec SAMLIdentityProviderSpec `json:"spec"`
+
+	// Status of the identity provider.
+	Status SAMLIdentityProviderStatus `json:"status,omitempty"`
+}
+
+// SAMLIdentityProviderList lists SAMLIdentityProvider objects.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type SAMLIdentityProviderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []SAMLIdentityProvider `json:"items"`
+}
@@ -16,6 +16,9 @@ const (
 	IDPTypeLDAP            IDPType = "ldap"
 	IDPTypeActiveDirectory IDPType = "activedirectory"
 	IDPTypeGitHub          IDPType = "github"
+	IDPTypeSAML            IDPType = "saml"
+	IDPTypeGitLab          IDPType = "gitlab"
+	IDPTypeOAuth2          IDPType = "oauth2"
 
 	IDPFlowCLIPassword     IDPFlow = "cli_password"
 	IDPFlowBrowserAuthcode IDPFlow = "browser_authcode"
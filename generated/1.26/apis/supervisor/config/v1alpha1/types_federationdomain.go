@@ -45,6 +45,78 @@ type FederationDomainTLSSpec struct {
 	SecretName string `json:"secretName,omitempty"`
 }
 
+// FederationDomainIdentityProviderChooser configures the page which asks end users to choose an identity provider.
+type FederationDomainIdentityProviderChooser struct {
+	// RememberLastChosen, when true, remembers the identity provider which an end user last chose in a signed
+	// browser cookie.
+	// +optional
+	RememberLastChosen bool `json:"rememberLastChosen,omitempty"`
+
+	// AutoRedirect, when true, skips the page when the end user does not need to choose, i.e. when the
+	// FederationDomain has only one identity provider, or when RememberLastChosen is true and the end user has chosen
+	// an identity provider before. The end user may still choose another identity provider using the link on the
+	// login page, and clients may ask for the page to be shown by sending the prompt=select_account parameter.
+	// +optional
+	AutoRedirect bool `json:"autoRedirect,omitempty"`
+}
+
+// FederationDomainClientRegistration configures the OAuth 2.0 Dynamic Client Registration endpoint of a
+// FederationDomain, which lets applications create their own OIDCClients.
+type FederationDomainClientRegistration struct {
+	// Enabled, when true, serves the registration endpoint at "<issuer>/oauth2/register", as described by RFC 7591.
+	// Each registration request must be authenticated by a bearer token, which is either one of the initial access
+	// tokens from the Secret named by InitialAccessTokensSecretName, or a Kubernetes service account token. A service
+	// account token is validated using a TokenReview, and the service account must be allowed by Kubernetes RBAC to
+	// create OIDCClients in the Supervisor's namespace. Each registration creates an OIDCClient in the Supervisor's
+	// namespace, along with a client secret which is returned only once.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// InitialAccessTokensSecretName is the name of a Secret in the same namespace as the FederationDomain. The value
+	// of each key of the Secret is accepted as an initial access token. Changes to the Secret take effect immediately.
+	// When empty, only Kubernetes service account tokens are accepted.
+	// +optional
+	InitialAccessTokensSecretName string `json:"initialAccessTokensSecretName,omitempty"`
+}
+
+// FederationDomainBrandingKind is the kind of the object which holds the branding of a FederationDomain.
+// +kubebuilder:validation:Enum=ConfigMap;Secret
+type FederationDomainBrandingKind string
+
+const (
+	FederationDomainBrandingKindConfigMap FederationDomainBrandingKind = "ConfigMap"
+	FederationDomainBrandingKindSecret    FederationDomainBrandingKind = "Secret"
+)
+
+// FederationDomainBranding refers to a ConfigMap or Secret which customizes the web pages of a FederationDomain.
+type FederationDomainBranding struct {
+	// Kind is the kind of the object which holds the branding, either "ConfigMap" or "Secret".
+	// +kubebuilder:default=ConfigMap
+	// +optional
+	Kind FederationDomainBrandingKind `json:"kind,omitempty"`
+
+	// Name is the name of the ConfigMap or Secret, which must be in the same namespace as the FederationDomain.
+	// All of its keys are optional. For a ConfigMap, each key may be in either data or binaryData.
+	//
+	// The following keys are recognized:
+	//
+	// "logo" is a PNG, JPEG, GIF, or SVG image which is shown at the top of each page.
+	// "favicon" is a PNG, ICO, or SVG image which is used as the icon of each page.
+	// "primaryColor" and "backgroundColor" are colors in the #RRGGBB format, used for buttons and for the page
+	// background.
+	// "customCSS" is additional CSS which is added to the style of each page. It must not contain "<".
+	// "supportURL" is an https URL of a help page, which is shown as a link on each page.
+	// "messages.<locale>.json" is a JSON object which maps message names to translated text for a BCP 47 locale,
+	// e.g. "messages.de.json" or "messages.pt-BR.json". The locale of each page is chosen from the Accept-Language
+	// header sent by the browser. Messages which are missing from a catalog fall back to the built-in English text.
+	// "defaultLocale" is the locale used when none of the locales from the Accept-Language header are available.
+	// It defaults to "en", which is always available.
+	//
+	// Changes to the ConfigMap or Secret take effect without restarting the Supervisor.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
 // FederationDomainTransformsConstant defines a constant variable and its value which will be made available to
 // the transform expressions. This is a union type, and Type is the discriminator field.
 type FederationDomainTransformsConstant struct {
@@ -179,6 +251,7 @@ type FederationDomainTransforms struct {
 }
 
 // FederationDomainIdentityProvider describes how an identity provider is made available in this FederationDomain.
+// +kubebuilder:validation:XValidation:message="totp may only be configured for LDAPIdentityProvider and ActiveDirectoryIdentityProvider identity providers",rule="!has(self.totp) || self.objectRef.kind == 'LDAPIdentityProvider' || self.objectRef.kind == 'ActiveDirectoryIdentityProvider'"
 type FederationDomainIdentityProvider struct {
 	// DisplayName is the name of this identity provider as it will appear to clients. This name ends up in the
 	// kubeconfig of end users, so changing the name of an identity provider that is in use by end users will be a
@@ -196,6 +269,79 @@ type FederationDomainIdentityProvider struct {
 	// session refresh.
 	// +optional
 	Transforms FederationDomainTransforms `json:"transforms,omitempty"`
+
+	// GroupEnrichment is an optional way to add group memberships from a secondary identity provider to the
+	// identity of users who authenticate using this identity provider. For example, users could authenticate
+	// using a GitHubIdentityProvider while their group memberships are also looked up in a corporate LDAP directory.
+	// +optional
+	GroupEnrichment *FederationDomainGroupEnrichment `json:"groupEnrichment,omitempty"`
+
+	// TOTP is an optional way to require end users to provide a time-based one-time password (TOTP) from an
+	// authenticator app as a second factor, after their username and password have been accepted by the identity
+	// provider. This is only supported for LDAPIdentityProvider and ActiveDirectoryIdentityProvider identity providers.
+	// +optional
+	TOTP *FederationDomainTOTP `json:"totp,omitempty"`
+}
+
+// FederationDomainTOTPPolicy describes when end users must provide a time-based one-time password.
+// +kubebuilder:validation:Enum=Required;WhenEnrolled
+type FederationDomainTOTPPolicy string
+
+const (
+	// FederationDomainTOTPPolicyRequired requires all end users to provide a one-time password. End users who have
+	// not yet enrolled an authenticator app will not be able to log in until they enroll.
+	FederationDomainTOTPPolicyRequired FederationDomainTOTPPolicy = "Required"
+
+	// FederationDomainTOTPPolicyWhenEnrolled requires a one-time password only from those end users who have
+	// enrolled an authenticator app.
+	FederationDomainTOTPPolicyWhenEnrolled FederationDomainTOTPPolicy = "WhenEnrolled"
+)
+
+// FederationDomainTOTP describes how time-based one-time passwords are used as a second factor for an identity provider.
+type FederationDomainTOTP struct {
+	// Policy decides which end users must provide a one-time password during login.
+	// "Required" means that all end users must provide one, so end users must enroll an authenticator app before
+	// they can log in. "WhenEnrolled" means that only those end users who have enrolled an authenticator app must
+	// provide one. End users enroll using the self-service page at the "/totp/enroll" path of the FederationDomain's
+	// issuer. Enrollments are stored encrypted by the Supervisor, and they belong to both the FederationDomain and
+	// the identity of the end user, so an end user must enroll separately for each identity provider.
+	// +kubebuilder:default=Required
+	// +optional
+	Policy FederationDomainTOTPPolicy `json:"policy,omitempty"`
+}
+
+// FederationDomainGroupEnrichment describes how to look up additional group memberships for a user
+// from a secondary identity provider.
+type FederationDomainGroupEnrichment struct {
+	// ObjectRef is a reference to the secondary Pinniped identity provider resource which will be searched for
+	// the user's group memberships. It must refer to an LDAPIdentityProvider or an ActiveDirectoryIdentityProvider
+	// in the same namespace as this FederationDomain, because those are able to search for any user's groups using
+	// their configured bind account. OIDCIdentityProviders are not supported because an OIDC userinfo endpoint can
+	// only be queried using an access token which was issued to the user by that provider.
+	// The referenced identity provider does not need to be listed in spec.identityProviders.
+	ObjectRef corev1.TypedLocalObjectReference `json:"objectRef"`
+
+	// KeyExpression is a CEL expression which computes the value used to find the user in the secondary
+	// identity provider. The result is used in place of the username in the secondary identity provider's
+	// userSearch.filter, so it is typically an attribute shared by both identity providers, such as an email address.
+	//
+	// The expression is evaluated after all of the transforms.expressions, and it may use the same variables as
+	// those expressions (`username`, `groups`, `strConst.varName`, and `strListConst.varName`) with the values
+	// resulting from those expressions. It must return a string. When it returns an empty string, or when no
+	// user is found by the search, then no groups are added.
+	//
+	// The expression may also use the `additionalClaims` variable, which is a map of the additional claims that
+	// the identity provider determined for the user, e.g. from the claims.additionalClaimMappings of an
+	// OIDCIdentityProvider or GitHubIdentityProvider. For example, to look up a GitHub user by the email address of
+	// their linked SAML identity, map the `samlNameID` attribute to an additional claim called `email`, and use the
+	// expression `has(additionalClaims.email) ? additionalClaims.email : ""`.
+	//
+	// The lookup happens during every authentication attempt, including during every session refresh.
+	// The groups found by the lookup are added to the groups of the user. When the secondary identity provider is
+	// not currently available, or the lookup fails, then the authentication attempt or session refresh will fail.
+	// Note that transforms.examples do not perform the lookup.
+	// +kubebuilder:validation:MinLength=1
+	KeyExpression string `json:"keyExpression"`
 }
 
 // FederationDomainSpec is a struct that describes an OIDC Provider.
@@ -240,6 +386,22 @@ type FederationDomainSpec struct {
 	//
 	// +optional
 	IdentityProviders []FederationDomainIdentityProvider `json:"identityProviders,omitempty"`
+
+	// Branding optionally customizes the appearance and the language of the web pages which the Supervisor shows
+	// to the end users of this FederationDomain, i.e. the login page, the identity provider chooser page, and the
+	// page which finishes a browser-based login.
+	// +optional
+	Branding *FederationDomainBranding `json:"branding,omitempty"`
+
+	// IdentityProviderChooser optionally configures how end users choose one of the identity providers of this
+	// FederationDomain during a browser-based login, when the client did not request a specific identity provider.
+	// +optional
+	IdentityProviderChooser *FederationDomainIdentityProviderChooser `json:"identityProviderChooser,omitempty"`
+
+	// ClientRegistration optionally enables the OAuth 2.0 Dynamic Client Registration endpoint of this
+	// FederationDomain.
+	// +optional
+	ClientRegistration *FederationDomainClientRegistration `json:"clientRegistration,omitempty"`
 }
 
 // FederationDomainSecrets holds information about this OIDC Provider's secrets.
@@ -264,6 +426,11 @@ type FederationDomainSecrets struct {
 	// encrypting state parameters is stored.
 	// +optional
 	StateEncryptionKey corev1.LocalObjectReference `json:"stateEncryptionKey,omitempty"`
+
+	// TOTPEncryptionKey holds the name of the corev1.Secret in which this OIDC Provider's key for
+	// encrypting the stored TOTP enrollments of end users is stored.
+	// +optional
+	TOTPEncryptionKey corev1.LocalObjectReference `json:"totpEncryptionKey,omitempty"`
 }
 
 // FederationDomainStatus is a struct that describes the actual state of an OIDC Provider.
@@ -75,6 +75,136 @@ type OIDCClientSpec struct {
 	// tokenLifetimes are the optional overrides of token lifetimes for an OIDCClient.
 	// +optional
 	TokenLifetimes OIDCClientTokenLifetimes `json:"tokenLifetimes,omitempty"`
+
+	// allowedIdentityProviders is an optional list of the identity providers which may be used to log in with this
+	// client. Each item refers to the displayName of one of the identityProviders of the FederationDomain on which the
+	// client is used. Identity providers not listed here will not be offered on the identity provider chooser page, and
+	// authorization requests for them will be rejected. When empty, all identity providers of the FederationDomain may
+	// be used.
+	// +listType=set
+	// +optional
+	AllowedIdentityProviders []string `json:"allowedIdentityProviders,omitempty"`
+
+	// admissionPolicy optionally restricts which users may log in with this client. It is evaluated against the user's
+	// username and groups after the FederationDomain's identity transformations have been applied, both during the
+	// initial login and during each refresh. When not specified, all users may log in with this client.
+	// +optional
+	AdmissionPolicy *OIDCClientAdmissionPolicy `json:"admissionPolicy,omitempty"`
+
+	// displayName is the name of the client which is shown to users on the consent page, such as the name of the
+	// web application. When not specified, the consent page shows the name of the OIDCClient.
+	// +optional
+	// +kubebuilder:validation:MaxLength=100
+	DisplayName string `json:"displayName,omitempty"`
+
+	// requireConsent, when true, causes the Supervisor to ask each user to approve the scopes requested by this client
+	// before the client receives any tokens for that user. The user's approval is remembered, so they are only asked
+	// again when the client requests scopes which they have not approved yet, when the client requests the consent
+	// prompt, or after their approval was revoked. This is intended for third-party clients which should not
+	// implicitly receive the identities of all users who log in. When false, users are not asked for their consent.
+	// +optional
+	RequireConsent bool `json:"requireConsent,omitempty"`
+
+	// requirePushedAuthorizationRequests, when true, causes the Supervisor to reject authorization requests from this
+	// client unless their parameters were first sent to the FederationDomain's pushed authorization request endpoint,
+	// as described by RFC 9126. This keeps the parameters out of the browser's history and the logs of proxies, and
+	// prevents them from being changed by the end user. When false, the client may use either kind of request.
+	// +optional
+	RequirePushedAuthorizationRequests bool `json:"requirePushedAuthorizationRequests,omitempty"`
+
+	// requestObjects optionally allows this client to send its authorization request parameters as a signed JWT,
+	// as described by RFC 9101. The request object may be sent to either the authorization endpoint or the pushed
+	// authorization request endpoint using the "request" parameter. When not specified, the client may not use
+	// request objects.
+	// +optional
+	RequestObjects *OIDCClientRequestObjects `json:"requestObjects,omitempty"`
+
+	// tokenExchange optionally configures the RFC8693 token exchanges which this client may perform. It may only be
+	// specified when allowedGrantTypes lists urn:ietf:params:oauth:grant-type:token-exchange. When not specified,
+	// the client may exchange its access tokens and Supervisor ID tokens for any audience which is not reserved
+	// by the Supervisor, and may not exchange JWTs from other issuers.
+	// +optional
+	TokenExchange *OIDCClientTokenExchange `json:"tokenExchange,omitempty"`
+}
+
+// OIDCClientTokenExchange describes the RFC8693 token exchanges which an OIDCClient may perform.
+type OIDCClientTokenExchange struct {
+	// allowedAudiences optionally restricts which audiences this client may request during token exchange.
+	// Each item is a pattern in which "*" matches any sequence of characters, including an empty sequence,
+	// e.g. "*.clusters.example.com". All other characters only match themselves. When empty, the client may
+	// request any audience. Audiences which are reserved by the Supervisor, i.e. "pinniped-cli" and audiences
+	// which contain ".pinniped.dev", may never be requested, even when they match one of these patterns.
+	// +listType=set
+	// +optional
+	AllowedAudiences []string `json:"allowedAudiences,omitempty"`
+
+	// trustedIssuers is an optional list of external issuers whose JWTs this client may exchange, using the
+	// subject_token_type urn:ietf:params:oauth:token-type:jwt. The identity in the resulting token is taken
+	// from the claims of the external JWT, so only list issuers which are trusted to assert the identities of
+	// this FederationDomain's users.
+	// +listType=map
+	// +listMapKey=issuer
+	// +optional
+	TrustedIssuers []OIDCClientTrustedIssuer `json:"trustedIssuers,omitempty"`
+}
+
+// OIDCClientTrustedIssuer describes an external issuer whose JWTs an OIDCClient may exchange.
+type OIDCClientTrustedIssuer struct {
+	// issuer is the required value of the iss claim of the JWTs.
+	// +kubebuilder:validation:MinLength=1
+	Issuer string `json:"issuer"`
+
+	// audience is the value which the aud claim of the JWTs must contain.
+	// +kubebuilder:validation:MinLength=1
+	Audience string `json:"audience"`
+
+	// jwks is a JSON Web Key Set, as described by RFC 7517, which contains the public keys of the issuer that
+	// verify the signatures of its JWTs. It must only contain public keys.
+	// +kubebuilder:validation:MinLength=1
+	JWKS string `json:"jwks"`
+
+	// claims describes which claims of the JWTs hold the identity of the user.
+	Claims OIDCClientTrustedIssuerClaims `json:"claims"`
+}
+
+// OIDCClientTrustedIssuerClaims describes which claims of the JWTs of a trusted issuer hold the identity of the user.
+type OIDCClientTrustedIssuerClaims struct {
+	// username is the name of the claim which holds the username of the user. Its value must be a non-empty string.
+	// +kubebuilder:validation:MinLength=1
+	Username string `json:"username"`
+
+	// groups is the name of the claim which holds the group memberships of the user. Its value may be a string
+	// or a list of strings. When not specified, the resulting token does not contain any groups.
+	// +optional
+	Groups string `json:"groups,omitempty"`
+}
+
+// OIDCClientRequestObjects describes how the Supervisor verifies the signed request objects of an OIDCClient.
+type OIDCClientRequestObjects struct {
+	// signingAlgorithm is the JWS algorithm which the client uses to sign its request objects. Request objects which
+	// are signed by any other algorithm, or which are not signed, will be rejected.
+	// +kubebuilder:validation:Enum=RS256;RS384;RS512;PS256;PS384;PS512;ES256;ES384;ES512
+	SigningAlgorithm string `json:"signingAlgorithm"`
+
+	// jwks is a JSON Web Key Set, as described by RFC 7517, which contains the public keys of the client that verify
+	// the signatures of its request objects. It must only contain public keys.
+	// +kubebuilder:validation:MinLength=1
+	JWKS string `json:"jwks"`
+}
+
+// OIDCClientAdmissionPolicy describes which users may log in with an OIDCClient. A user is admitted when their
+// username is listed in allowedUsernames, or when they are a member of at least one group listed in allowedGroups.
+// At least one of allowedUsernames or allowedGroups must be specified.
+type OIDCClientAdmissionPolicy struct {
+	// allowedUsernames is a list of usernames which may log in with this client.
+	// +listType=set
+	// +optional
+	AllowedUsernames []string `json:"allowedUsernames,omitempty"`
+
+	// allowedGroups is a list of group names. Members of any of these groups may log in with this client.
+	// +listType=set
+	// +optional
+	AllowedGroups []string `json:"allowedGroups,omitempty"`
 }
 
 // OIDCClientTokenLifetimes describes the optional overrides of token lifetimes for an OIDCClient.
@@ -38,6 +38,12 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&ActiveDirectoryIdentityProviderList{},
 		&GitHubIdentityProvider{},
 		&GitHubIdentityProviderList{},
+		&SAMLIdentityProvider{},
+		&SAMLIdentityProviderList{},
+		&GitLabIdentityProvider{},
+		&GitLabIdentityProviderList{},
+		&OAuth2IdentityProvider{},
+		&OAuth2IdentityProviderList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
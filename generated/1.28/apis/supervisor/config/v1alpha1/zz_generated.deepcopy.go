@@ -41,11 +41,70 @@ func (in *FederationDomain) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FederationDomainBranding) DeepCopyInto(out *FederationDomainBranding) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FederationDomainBranding.
+func (in *FederationDomainBranding) DeepCopy() *FederationDomainBranding {
+	if in == nil {
+		return nil
+	}
+	out := new(FederationDomainBranding)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FederationDomainClientRegistration) DeepCopyInto(out *FederationDomainClientRegistration) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FederationDomainClientRegistration.
+func (in *FederationDomainClientRegistration) DeepCopy() *FederationDomainClientRegistration {
+	if in == nil {
+		return nil
+	}
+	out := new(FederationDomainClientRegistration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FederationDomainGroupEnrichment) DeepCopyInto(out *FederationDomainGroupEnrichment) {
+	*out = *in
+	in.ObjectRef.DeepCopyInto(&out.ObjectRef)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FederationDomainGroupEnrichment.
+func (in *FederationDomainGroupEnrichment) DeepCopy() *FederationDomainGroupEnrichment {
+	if in == nil {
+		return nil
+	}
+	out := new(FederationDomainGroupEnrichment)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FederationDomainIdentityProvider) DeepCopyInto(out *FederationDomainIdentityProvider) {
 	*out = *in
 	in.ObjectRef.DeepCopyInto(&out.ObjectRef)
 	in.Transforms.DeepCopyInto(&out.Transforms)
+	if in.GroupEnrichment != nil {
+		in, out := &in.GroupEnrichment, &out.GroupEnrichment
+		*out = new(FederationDomainGroupEnrichment)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TOTP != nil {
+		in, out := &in.TOTP, &out.TOTP
+		*out = new(FederationDomainTOTP)
+		**out = **in
+	}
 	return
 }
 
@@ -59,6 +118,22 @@ func (in *FederationDomainIdentityProvider) DeepCopy() *FederationDomainIdentity
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FederationDomainIdentityProviderChooser) DeepCopyInto(out *FederationDomainIdentityProviderChooser) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FederationDomainIdentityProviderChooser.
+func (in *FederationDomainIdentityProviderChooser) DeepCopy() *FederationDomainIdentityProviderChooser {
+	if in == nil {
+		return nil
+	}
+	out := new(FederationDomainIdentityProviderChooser)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FederationDomainList) DeepCopyInto(out *FederationDomainList) {
 	*out = *in
@@ -99,6 +174,7 @@ func (in *FederationDomainSecrets) DeepCopyInto(out *FederationDomainSecrets) {
 	out.TokenSigningKey = in.TokenSigningKey
 	out.StateSigningKey = in.StateSigningKey
 	out.StateEncryptionKey = in.StateEncryptionKey
+	out.TOTPEncryptionKey = in.TOTPEncryptionKey
 	return
 }
 
@@ -127,6 +203,21 @@ func (in *FederationDomainSpec) DeepCopyInto(out *FederationDomainSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Branding != nil {
+		in, out := &in.Branding, &out.Branding
+		*out = new(FederationDomainBranding)
+		**out = **in
+	}
+	if in.IdentityProviderChooser != nil {
+		in, out := &in.IdentityProviderChooser, &out.IdentityProviderChooser
+		*out = new(FederationDomainIdentityProviderChooser)
+		**out = **in
+	}
+	if in.ClientRegistration != nil {
+		in, out := &in.ClientRegistration, &out.ClientRegistration
+		*out = new(FederationDomainClientRegistration)
+		**out = **in
+	}
 	return
 }
 
@@ -180,6 +271,22 @@ func (in *FederationDomainTLSSpec) DeepCopy() *FederationDomainTLSSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FederationDomainTOTP) DeepCopyInto(out *FederationDomainTOTP) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FederationDomainTOTP.
+func (in *FederationDomainTOTP) DeepCopy() *FederationDomainTOTP {
+	if in == nil {
+		return nil
+	}
+	out := new(FederationDomainTOTP)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *FederationDomainTransforms) DeepCopyInto(out *FederationDomainTransforms) {
 	*out = *in
@@ -323,6 +430,32 @@ func (in *OIDCClient) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OIDCClientAdmissionPolicy) DeepCopyInto(out *OIDCClientAdmissionPolicy) {
+	*out = *in
+	if in.AllowedUsernames != nil {
+		in, out := &in.AllowedUsernames, &out.AllowedUsernames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedGroups != nil {
+		in, out := &in.AllowedGroups, &out.AllowedGroups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OIDCClientAdmissionPolicy.
+func (in *OIDCClientAdmissionPolicy) DeepCopy() *OIDCClientAdmissionPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(OIDCClientAdmissionPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OIDCClientList) DeepCopyInto(out *OIDCClientList) {
 	*out = *in
@@ -356,6 +489,22 @@ func (in *OIDCClientList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OIDCClientRequestObjects) DeepCopyInto(out *OIDCClientRequestObjects) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OIDCClientRequestObjects.
+func (in *OIDCClientRequestObjects) DeepCopy() *OIDCClientRequestObjects {
+	if in == nil {
+		return nil
+	}
+	out := new(OIDCClientRequestObjects)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OIDCClientSpec) DeepCopyInto(out *OIDCClientSpec) {
 	*out = *in
@@ -375,6 +524,26 @@ func (in *OIDCClientSpec) DeepCopyInto(out *OIDCClientSpec) {
 		copy(*out, *in)
 	}
 	in.TokenLifetimes.DeepCopyInto(&out.TokenLifetimes)
+	if in.AllowedIdentityProviders != nil {
+		in, out := &in.AllowedIdentityProviders, &out.AllowedIdentityProviders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AdmissionPolicy != nil {
+		in, out := &in.AdmissionPolicy, &out.AdmissionPolicy
+		*out = new(OIDCClientAdmissionPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RequestObjects != nil {
+		in, out := &in.RequestObjects, &out.RequestObjects
+		*out = new(OIDCClientRequestObjects)
+		**out = **in
+	}
+	if in.TokenExchange != nil {
+		in, out := &in.TokenExchange, &out.TokenExchange
+		*out = new(OIDCClientTokenExchange)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -411,6 +580,32 @@ func (in *OIDCClientStatus) DeepCopy() *OIDCClientStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OIDCClientTokenExchange) DeepCopyInto(out *OIDCClientTokenExchange) {
+	*out = *in
+	if in.AllowedAudiences != nil {
+		in, out := &in.AllowedAudiences, &out.AllowedAudiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TrustedIssuers != nil {
+		in, out := &in.TrustedIssuers, &out.TrustedIssuers
+		*out = make([]OIDCClientTrustedIssuer, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OIDCClientTokenExchange.
+func (in *OIDCClientTokenExchange) DeepCopy() *OIDCClientTokenExchange {
+	if in == nil {
+		return nil
+	}
+	out := new(OIDCClientTokenExchange)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OIDCClientTokenLifetimes) DeepCopyInto(out *OIDCClientTokenLifetimes) {
 	*out = *in
@@ -431,3 +626,36 @@ func (in *OIDCClientTokenLifetimes) DeepCopy() *OIDCClientTokenLifetimes {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OIDCClientTrustedIssuer) DeepCopyInto(out *OIDCClientTrustedIssuer) {
+	*out = *in
+	out.Claims = in.Claims
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OIDCClientTrustedIssuer.
+func (in *OIDCClientTrustedIssuer) DeepCopy() *OIDCClientTrustedIssuer {
+	if in == nil {
+		return nil
+	}
+	out := new(OIDCClientTrustedIssuer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OIDCClientTrustedIssuerClaims) DeepCopyInto(out *OIDCClientTrustedIssuerClaims) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OIDCClientTrustedIssuerClaims.
+func (in *OIDCClientTrustedIssuerClaims) DeepCopy() *OIDCClientTrustedIssuerClaims {
+	if in == nil {
+		return nil
+	}
+	out := new(OIDCClientTrustedIssuerClaims)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -20,6 +20,19 @@ const (
 	ActiveDirectoryPhaseError ActiveDirectoryIdentityProviderPhase = "Error"
 )
 
+type ActiveDirectoryBindMethod string
+
+const (
+	// ActiveDirectoryBindMethodSimple binds using the username and password of the bind account.
+	ActiveDirectoryBindMethodSimple ActiveDirectoryBindMethod = "Simple"
+
+	// ActiveDirectoryBindMethodGSSAPI binds using SASL GSSAPI (Kerberos) with the keytab of the bind account.
+	ActiveDirectoryBindMethodGSSAPI ActiveDirectoryBindMethod = "GSSAPI"
+
+	// ActiveDirectoryBindMethodExternal binds using SASL EXTERNAL with the TLS client certificate of the bind account.
+	ActiveDirectoryBindMethodExternal ActiveDirectoryBindMethod = "External"
+)
+
 // Status of an Active Directory identity provider.
 type ActiveDirectoryIdentityProviderStatus struct {
 	// Phase summarizes the overall status of the ActiveDirectoryIdentityProvider.
@@ -36,13 +49,46 @@ type ActiveDirectoryIdentityProviderStatus struct {
 }
 
 type ActiveDirectoryIdentityProviderBind struct {
-	// SecretName contains the name of a namespace-local Secret object that provides the username and
-	// password for an Active Directory bind user. This account will be used to perform LDAP searches. The Secret should be
-	// of type "kubernetes.io/basic-auth" which includes "username" and "password" keys. The username value
-	// should be the full dn (distinguished name) of your bind account, e.g. "cn=bind-account,ou=users,dc=example,dc=com".
-	// The password must be non-empty.
+	// Method determines how the Supervisor binds as the bind account. "Simple" uses a username and password.
+	// "GSSAPI" uses a SASL GSSAPI (Kerberos) bind using a keytab. "External" uses a SASL EXTERNAL bind, where the
+	// Active Directory server identifies the bind account using the client certificate which is presented during the TLS
+	// handshake.
+	// Optional. When not specified, defaults to "Simple".
+	// +kubebuilder:validation:Enum=Simple;GSSAPI;External
+	// +kubebuilder:default=Simple
+	// +optional
+	Method ActiveDirectoryBindMethod `json:"method,omitempty"`
+
+	// SecretName contains the name of a namespace-local Secret object that provides the credentials of the bind
+	// account. This account will be used to perform LDAP searches.
+	// When the Method is "Simple", the Secret should be of type "kubernetes.io/basic-auth" which includes "username"
+	// and "password" keys. The username value should be the full dn (distinguished name) of your bind account,
+	// e.g. "cn=bind-account,ou=users,dc=example,dc=com". The password must be non-empty.
+	// When the Method is "GSSAPI", the Secret should be of type "Opaque" which includes "username", "keytab", and
+	// "krb5.conf" keys. The username value should be the Kerberos principal name of your bind account, e.g.
+	// "bind-account", the keytab value should be a keytab file which contains the keys of that principal, and the
+	// krb5.conf value should be a Kerberos configuration file which describes how to find the KDCs of the realm.
+	// When the Method is "External", the Secret should be of type "kubernetes.io/tls" which includes "tls.crt" and
+	// "tls.key" keys, which contain the PEM-encoded client certificate and private key of your bind account.
 	// +kubebuilder:validation:MinLength=1
 	SecretName string `json:"secretName"`
+
+	// GSSAPI contains additional settings for the "GSSAPI" Method.
+	// +optional
+	GSSAPI *ActiveDirectoryIdentityProviderGSSAPIBind `json:"gssapi,omitempty"`
+}
+
+// ActiveDirectoryIdentityProviderGSSAPIBind contains the settings for SASL GSSAPI (Kerberos) binds.
+type ActiveDirectoryIdentityProviderGSSAPIBind struct {
+	// Realm is the Kerberos realm of the bind account's principal, e.g. "EXAMPLE.COM".
+	// Optional. When not specified, the default_realm of the krb5.conf from the bind Secret is used.
+	// +optional
+	Realm string `json:"realm,omitempty"`
+
+	// ServicePrincipalName is the Kerberos service principal name of the Active Directory server, e.g. "ldap/dc1.example.com".
+	// Optional. When not specified, it will be "ldap/" followed by the hostname of the server which is being used.
+	// +optional
+	ServicePrincipalName string `json:"servicePrincipalName,omitempty"`
 }
 
 type ActiveDirectoryIdentityProviderUserSearchAttributes struct {
@@ -57,6 +103,19 @@ type ActiveDirectoryIdentityProviderUserSearchAttributes struct {
 	// Optional, when empty this defaults to "objectGUID".
 	// +optional
 	UID string `json:"uid,omitempty"`
+
+	// AdditionalClaimMappings allows for additional arbitrary attribute values of the user's Active Directory entry to be mapped
+	// into the "additionalClaims" claim of the ID tokens generated by the Supervisor. This should be specified as a map
+	// of new claim names as the keys, and Active Directory attribute names as the values, e.g. "email": "mail". The values of
+	// the attributes are fetched during the user search and are fetched again during each session refresh. A single
+	// valued attribute becomes a string claim, and a multi-valued attribute becomes a list of strings. These new claim
+	// names will be nested under the top-level "additionalClaims" claim in ID tokens generated by the Supervisor when
+	// this ActiveDirectoryIdentityProvider was used for user authentication. These claims will be made available to all clients.
+	// The attribute names are case-sensitive and must match the case of the attribute names returned by the Active Directory
+	// server in the user's entry. When this map is empty or the attributes are not available, the "additionalClaims"
+	// claim will be excluded from the ID tokens generated by the Supervisor.
+	// +optional
+	AdditionalClaimMappings map[string]string `json:"additionalClaimMappings,omitempty"`
 }
 
 type ActiveDirectoryIdentityProviderGroupSearchAttributes struct {
@@ -144,6 +203,21 @@ type ActiveDirectoryIdentityProviderGroupSearch struct {
 	// +optional
 	Attributes ActiveDirectoryIdentityProviderGroupSearchAttributes `json:"attributes,omitempty"`
 
+	// CacheTTLSeconds is the number of seconds for which the result of the group search for a user is cached
+	// during refreshes. While a user's groups are cached, refreshes for any of the user's sessions on any of the
+	// Supervisor's pods will reuse the cached groups instead of searching for the user's groups again. The user
+	// entry is still searched for during each refresh, so a user who was deleted or whose password was changed
+	// will still fail to refresh. Changes to the user's group memberships, including removals, are reflected
+	// within this amount of time. Caching the groups reduces the load on the Active Directory server compared to
+	// searching during each refresh, without keeping the groups static like SkipGroupRefresh.
+	// The cache entries are stored as Secrets in the Supervisor's namespace.
+	// Optional. When not specified, or when set to 0, the groups are not cached and are searched for during
+	// each refresh.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=86400
+	// +optional
+	CacheTTLSeconds int32 `json:"cacheTTLSeconds,omitempty"`
+
 	// The user's group membership is refreshed as they interact with the supervisor
 	// to obtain new credentials (as their old credentials expire).  This allows group
 	// membership changes to be quickly reflected into Kubernetes clusters.  Since
@@ -176,6 +250,22 @@ type ActiveDirectoryIdentityProviderSpec struct {
 	// +kubebuilder:validation:MinLength=1
 	Host string `json:"host"`
 
+	// AdditionalHosts is an ordered list of other hostnames of this Active Directory identity provider, for example replicas
+	// of the server at the Host. For example: ldap2.example.com:636. When the Host cannot be reached, or it fails
+	// to bind as the bind account, then each additional host will be tried in order. Hosts which have recently
+	// failed will be tried after the hosts which have not. The Host is always used to identify users, so all of
+	// these hosts must serve the same directory. The connection settings in TLS are used for all of these hosts.
+	// +optional
+	// +listType=set
+	AdditionalHosts []string `json:"additionalHosts,omitempty"`
+
+	// SRVRecordName is the name of a DNS SRV record which is used to discover more hosts of this Active Directory
+	// identity provider. For example: _ldap._tcp.dc._msdcs.corp.example.com. The record is resolved each time a connection is needed,
+	// and the discovered hosts are tried after the Host and the AdditionalHosts, in the order of their priorities
+	// and weights. The Host is always used to identify users, so all discovered hosts must serve the same directory.
+	// +optional
+	SRVRecordName string `json:"srvRecordName,omitempty"`
+
 	// TLS contains the connection settings for how to establish the connection to the Host.
 	TLS *TLSSpec `json:"tls,omitempty"`
 
@@ -21,6 +21,14 @@ type GitHubIdentityProviderListerExpansion interface{}
 // GitHubIdentityProviderNamespaceLister.
 type GitHubIdentityProviderNamespaceListerExpansion interface{}
 
+// GitLabIdentityProviderListerExpansion allows custom methods to be added to
+// GitLabIdentityProviderLister.
+type GitLabIdentityProviderListerExpansion interface{}
+
+// GitLabIdentityProviderNamespaceListerExpansion allows custom methods to be added to
+// GitLabIdentityProviderNamespaceLister.
+type GitLabIdentityProviderNamespaceListerExpansion interface{}
+
 // LDAPIdentityProviderListerExpansion allows custom methods to be added to
 // LDAPIdentityProviderLister.
 type LDAPIdentityProviderListerExpansion interface{}
@@ -29,6 +37,14 @@ type LDAPIdentityProviderListerExpansion interface{}
 // LDAPIdentityProviderNamespaceLister.
 type LDAPIdentityProviderNamespaceListerExpansion interface{}
 
+// OAuth2IdentityProviderListerExpansion allows custom methods to be added to
+// OAuth2IdentityProviderLister.
+type OAuth2IdentityProviderListerExpansion interface{}
+
+// OAuth2IdentityProviderNamespaceListerExpansion allows custom methods to be added to
+// OAuth2IdentityProviderNamespaceLister.
+type OAuth2IdentityProviderNamespaceListerExpansion interface{}
+
 // OIDCIdentityProviderListerExpansion allows custom methods to be added to
 // OIDCIdentityProviderLister.
 type OIDCIdentityProviderListerExpansion interface{}
@@ -36,3 +52,11 @@ type OIDCIdentityProviderListerExpansion interface{}
 // OIDCIdentityProviderNamespaceListerExpansion allows custom methods to be added to
 // OIDCIdentityProviderNamespaceLister.
 type OIDCIdentityProviderNamespaceListerExpansion interface{}
+
+// SAMLIdentityProviderListerExpansion allows custom methods to be added to
+// SAMLIdentityProviderLister.
+type SAMLIdentityProviderListerExpansion interface{}
+
+// SAMLIdentityProviderNamespaceListerExpansion allows custom methods to be added to
+// SAMLIdentityProviderNamespaceLister.
+type SAMLIdentityProviderNamespaceListerExpansion interface{}
@@ -0,0 +1,259 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type GitLabIdentityProviderPhase string
+
+const (
+	// GitLabPhasePending is the default phase for newly-created GitLabIdentityProvider resources.
+	GitLabPhasePending GitLabIdentityProviderPhase = "Pending"
+
+	// GitLabPhaseReady is the phase for an GitLabIdentityProvider resource in a healthy state.
+	GitLabPhaseReady GitLabIdentityProviderPhase = "Ready"
+
+	// GitLabPhaseError is the phase for an GitLabIdentityProvider in an unhealthy state.
+	GitLabPhaseError GitLabIdentityProviderPhase = "Error"
+)
+
+type GitLabAllowedAuthGroupsPolicy string
+
+const (
+	// GitLabAllowedAuthGroupsPolicyAllGitLabUsers means any GitLab user is allowed to log in using this identity
+	// provider, regardless of their group membership or lack thereof.
+	GitLabAllowedAuthGroupsPolicyAllGitLabUsers GitLabAllowedAuthGroupsPolicy = "AllGitLabUsers"
+
+	// GitLabAllowedAuthGroupsPolicyOnlyUsersFromAllowedGroups means only those users with membership in
+	// the listed GitLab groups (or in any of their subgroups) are allowed to log in.
+	GitLabAllowedAuthGroupsPolicyOnlyUsersFromAllowedGroups GitLabAllowedAuthGroupsPolicy = "OnlyUsersFromAllowedGroups"
+)
+
+// GitLabIdentityProviderStatus is the status of an GitLab identity provider.
+type GitLabIdentityProviderStatus struct {
+	// Phase summarizes the overall status of the GitLabIdentityProvider.
+	//
+	// +kubebuilder:default=Pending
+	// +kubebuilder:validation:Enum=Pending;Ready;Error
+	Phase GitLabIdentityProviderPhase `json:"phase,omitempty"`
+
+	// Conditions represents the observations of an identity provider's current state.
+	//
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// GitLabAPIConfig allows configuration for self-managed GitLab.
+type GitLabAPIConfig struct {
+	// Host is required only for self-managed GitLab.
+	// Defaults to using GitLab's SaaS offering ("gitlab.com").
+	// Do not specify a protocol or scheme since "https://" will always be used.
+	// Port is optional. Do not specify a path, query, fragment, or userinfo.
+	// Only specify domain name or IP address, subdomains (optional), and port (optional).
+	// IPv4 and IPv6 are supported. If using an IPv6 address with a port, you must enclose the IPv6 address
+	// in square brackets. Example: "[::1]:443".
+	//
+	// +kubebuilder:default="gitlab.com"
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	Host *string `json:"host"`
+
+	// TLS configuration for self-managed GitLab.
+	// Note that this field should not be needed when using GitLab's SaaS offering ("gitlab.com").
+	// However, if you choose to specify this field when using GitLab's SaaS offering, you must
+	// specify a CA bundle that will verify connections to "gitlab.com".
+	//
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+}
+
+// GitLabUsernameAttribute allows the user to specify which attribute(s) from GitLab to use for the username to present
+// to Kubernetes. See the response schema for
+// [Get the current user](https://docs.gitlab.com/ee/api/users.html#for-non-administrator-users).
+type GitLabUsernameAttribute string
+
+const (
+	// GitLabUsernameID specifies using the `id` attribute from the GitLab user for the username to present to Kubernetes.
+	GitLabUsernameID GitLabUsernameAttribute = "id"
+
+	// GitLabUsernameLogin specifies using the `username` attribute from the GitLab user as the username to present
+	// to Kubernetes.
+	GitLabUsernameLogin GitLabUsernameAttribute = "login"
+
+	// GitLabUsernameLoginAndID specifies combining the `username` and `id` attributes from the GitLab user as the
+	// username to present to Kubernetes, separated by a colon. Example: "my-login:1234"
+	GitLabUsernameLoginAndID GitLabUsernameAttribute = "login:id"
+)
+
+// GitLabGroupNameAttribute allows the user to specify which attribute from GitLab to use for the group
+// names to present to Kubernetes. See the response schema for
+// [List groups](https://docs.gitlab.com/ee/api/groups.html#list-groups).
+type GitLabGroupNameAttribute string
+
+const (
+	// GitLabUseFullPathForGroupName specifies using the GitLab group's `full_path` attribute as the group name to
+	// present to Kubernetes. The full path of a subgroup includes the paths of all of its ancestor groups,
+	// separated by forward slashes (e.g. "my-group/my-subgroup").
+	GitLabUseFullPathForGroupName GitLabGroupNameAttribute = "fullPath"
+)
+
+// GitLabClaims allows customization of the username and groups claims.
+type GitLabClaims struct {
+	// Username configures which property of the GitLab user record shall determine the username in Kubernetes.
+	//
+	// Can be either "id", "login", or "login:id". Defaults to "login:id".
+	//
+	// The "login" value refers to the `username` attribute of the GitLab user. GitLab users are allowed to change
+	// their username. If a GitLab user changed their username from "foo" to "bar", then a second user might change
+	// their username from "baz" to "foo" in order to take the old username of the first user. For this reason,
+	// it is not as safe to make authorization decisions based only on the user's username attribute.
+	//
+	// If desired, an admin could configure identity transformation expressions on the Pinniped Supervisor's
+	// FederationDomain to further customize how these usernames are presented to Kubernetes.
+	//
+	// Defaults to "login:id", which is the user's username attribute, followed by a colon, followed by the unique and
+	// unchanging integer ID number attribute. This blends human-readable usernames with the unchanging ID value
+	// from GitLab. Colons are not allowed in GitLab usernames or ID numbers, so this is a reasonable
+	// choice to concatenate the two values.
+	//
+	// See the response schema for
+	// [Get the current user](https://docs.gitlab.com/ee/api/users.html#for-non-administrator-users).
+	//
+	// +kubebuilder:default="login:id"
+	// +kubebuilder:validation:Enum={"id","login","login:id"}
+	// +optional
+	Username *GitLabUsernameAttribute `json:"username"`
+
+	// Groups configures which property of the GitLab group record shall determine the group names in Kubernetes.
+	//
+	// Can only be "fullPath" at this time. Defaults to "fullPath".
+	//
+	// The full path of a group is the unique path of the group within the GitLab instance, including the paths of
+	// all of its ancestor groups, separated by forward slashes (e.g. "my-group/my-subgroup/my-nested-subgroup").
+	//
+	// If desired, an admin could configure identity transformation expressions on the Pinniped Supervisor's
+	// FederationDomain to further customize how these group names are presented to Kubernetes.
+	//
+	// See the response schema for
+	// [List groups](https://docs.gitlab.com/ee/api/groups.html#list-groups).
+	//
+	// +kubebuilder:default=fullPath
+	// +kubebuilder:validation:Enum=fullPath
+	// +optional
+	Groups *GitLabGroupNameAttribute `json:"groups"`
+
+	// FlattenSubgroups, when true, causes each subgroup membership to also be presented to Kubernetes as membership
+	// in each of the subgroup's ancestor groups. For example, a user who is a member of "my-group/my-subgroup" would
+	// also be presented as a member of "my-group". This can be useful to allow RBAC policies which are written for
+	// a top-level group to also apply to members of its subgroups. Defaults to false.
+	//
+	// +optional
+	FlattenSubgroups bool `json:"flattenSubgroups,omitempty"`
+}
+
+// GitLabClientSpec contains information about the GitLab client that this identity provider will use
+// for web-based login flows.
+type GitLabClientSpec struct {
+	// SecretName contains the name of a namespace-local Secret object that provides the clientID and
+	// clientSecret for a GitLab OAuth2 application.
+	//
+	// This secret must be of type "secrets.pinniped.dev/gitlab-client" with keys "clientID" and "clientSecret".
+	//
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+}
+
+type GitLabGroupsSpec struct {
+	// Allowed values are "OnlyUsersFromAllowedGroups" or "AllGitLabUsers".
+	// Defaults to "OnlyUsersFromAllowedGroups".
+	//
+	// Must be set to "AllGitLabUsers" if the allowed field is empty.
+	//
+	// This field only exists to ensure that Pinniped administrators are aware that an empty list of
+	// allowed groups means all GitLab users are allowed to log in.
+	//
+	// +kubebuilder:default=OnlyUsersFromAllowedGroups
+	// +kubebuilder:validation:Enum=OnlyUsersFromAllowedGroups;AllGitLabUsers
+	// +optional
+	Policy *GitLabAllowedAuthGroupsPolicy `json:"policy"`
+
+	// Allowed, when specified, indicates that only users with membership in at least one of the listed
+	// GitLab groups (or in any subgroup of the listed groups) may log in. Groups must be listed by their full path
+	// (e.g. "my-group/my-subgroup"). In addition, the group membership presented to Kubernetes will only include
+	// the listed groups and their subgroups. Additional login rules or group filtering can optionally be
+	// provided as policy expression on any Pinniped Supervisor FederationDomain that includes this IDP.
+	//
+	// If no groups are listed, you must set policy: AllGitLabUsers.
+	//
+	// +kubebuilder:validation:MaxItems=64
+	// +listType=set
+	// +optional
+	Allowed []string `json:"allowed,omitempty"`
+}
+
+// GitLabAllowAuthenticationSpec allows customization of who can authenticate using this IDP and how.
+type GitLabAllowAuthenticationSpec struct {
+	// Groups allows customization of which groups can authenticate using this IDP.
+	// +kubebuilder:validation:XValidation:message="spec.allowAuthentication.groups.policy must be 'OnlyUsersFromAllowedGroups' when spec.allowAuthentication.groups.allowed has groups listed",rule="!(has(self.allowed) && size(self.allowed) > 0 && self.policy == 'AllGitLabUsers')"
+	// +kubebuilder:validation:XValidation:message="spec.allowAuthentication.groups.policy must be 'AllGitLabUsers' when spec.allowAuthentication.groups.allowed is empty",rule="!((!has(self.allowed) || size(self.allowed) == 0) && self.policy == 'OnlyUsersFromAllowedGroups')"
+	Groups GitLabGroupsSpec `json:"groups"`
+}
+
+// GitLabIdentityProviderSpec is the spec for configuring an GitLab identity provider.
+type GitLabIdentityProviderSpec struct {
+	// GitLabAPI allows configuration for self-managed GitLab.
+	//
+	// +kubebuilder:default={}
+	GitLabAPI GitLabAPIConfig `json:"gitlabAPI,omitempty"`
+
+	// Claims allows customization of the username and groups claims.
+	//
+	// +kubebuilder:default={}
+	Claims GitLabClaims `json:"claims,omitempty"`
+
+	// AllowAuthentication allows customization of who can authenticate using this IDP and how.
+	AllowAuthentication GitLabAllowAuthenticationSpec `json:"allowAuthentication"`
+
+	// Client identifies the secret with credentials for a GitLab OAuth2 application (a GitLab client).
+	Client GitLabClientSpec `json:"client"`
+}
+
+// GitLabIdentityProvider describes the configuration of an upstream GitLab identity provider.
+// Both GitLab's SaaS offering (gitlab.com) and self-managed GitLab are supported.
+//
+// Right now, only web-based logins are supported, for both the pinniped-cli client and clients configured
+// as OIDCClients.
+//
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:categories=pinniped;pinniped-idp;pinniped-idps
+// +kubebuilder:printcolumn:name="Host",type=string,JSONPath=`.spec.gitlabAPI.host`
+// +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// +kubebuilder:subresource:status
+type GitLabIdentityProvider struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec for configuring the identity provider.
+	Spec GitLabIdentityProviderSpec `json:"spec"`
+
+	// Status of the identity provider.
+	Status GitLabIdentityProviderStatus `json:"status,omitempty"`
+}
+
+// GitLabIdentityProviderList lists GitLabIdentityProvider objects.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type GitLabIdentityProviderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []GitLabIdentityProvider `json:"items"`
+}
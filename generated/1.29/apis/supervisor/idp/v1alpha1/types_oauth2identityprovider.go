@@ -0,0 +1,198 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type OAuth2IdentityProviderPhase string
+
+const (
+	// OAuth2PhasePending is the default phase for newly-created OAuth2IdentityProvider resources.
+	OAuth2PhasePending OAuth2IdentityProviderPhase = "Pending"
+
+	// OAuth2PhaseReady is the phase for an OAuth2IdentityProvider resource in a healthy state.
+	OAuth2PhaseReady OAuth2IdentityProviderPhase = "Ready"
+
+	// OAuth2PhaseError is the phase for an OAuth2IdentityProvider in an unhealthy state.
+	OAuth2PhaseError OAuth2IdentityProviderPhase = "Error"
+)
+
+// OAuth2IdentityProviderStatus is the status of an OAuth2 identity provider.
+type OAuth2IdentityProviderStatus struct {
+	// Phase summarizes the overall status of the OAuth2IdentityProvider.
+	//
+	// +kubebuilder:default=Pending
+	// +kubebuilder:validation:Enum=Pending;Ready;Error
+	Phase OAuth2IdentityProviderPhase `json:"phase,omitempty"`
+
+	// Conditions represents the observations of an identity provider's current state.
+	//
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// OAuth2UserInfoSpec configures the HTTPS API calls which are made to the upstream provider to learn about
+// the identity of the user after they have logged in.
+type OAuth2UserInfoSpec struct {
+	// UserEndpoint is the HTTPS URL of a JSON API endpoint which returns information about the authenticated user.
+	// It will be called using an HTTP GET request with the user's access token as a bearer token in the
+	// Authorization header. For example, "https://api.example.com/user".
+	// The JSON response body will be used to determine the username, UID, and (unless GroupsEndpoint is
+	// specified) the groups of the user.
+	//
+	// +kubebuilder:validation:Pattern=`^https://`
+	UserEndpoint string `json:"userEndpoint"`
+
+	// GroupsEndpoint is the HTTPS URL of an optional JSON API endpoint which returns information about the
+	// group memberships of the authenticated user. It will be called in the same way as UserEndpoint.
+	// When specified, the groups claim mapping will be applied to the JSON response body of this endpoint
+	// instead of to the response of UserEndpoint.
+	//
+	// +kubebuilder:validation:Pattern=`^https://`
+	// +optional
+	GroupsEndpoint string `json:"groupsEndpoint,omitempty"`
+}
+
+// OAuth2ClaimMapping describes how to extract a value from the JSON response of a user info API call.
+// Exactly one of JSONPath or Expression must be specified.
+//
+// +kubebuilder:validation:XValidation:message="exactly one of jsonPath or expression must be specified",rule="has(self.jsonPath) != has(self.expression)"
+type OAuth2ClaimMapping struct {
+	// JSONPath is a Kubernetes-style JSONPath template which will be evaluated against the JSON response body,
+	// e.g. "{.login}" or "{.teams[*].slug}".
+	// See https://kubernetes.io/docs/reference/kubectl/jsonpath for the supported syntax.
+	//
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	JSONPath string `json:"jsonPath,omitempty"`
+
+	// Expression is a CEL expression which will be evaluated against the JSON response body, which is available
+	// to the expression as the variable named "response". For example, `response.login` or
+	// `response.teams.map(t, t.org + "/" + t.slug)`. JSON numbers are made available as integers when they
+	// have no fractional part, and as doubles otherwise.
+	// See https://github.com/google/cel-spec/blob/master/doc/langdef.md for the CEL language.
+	//
+	// +kubebuilder:validation:MinLength=1
+	// +optional
+	Expression string `json:"expression,omitempty"`
+}
+
+// OAuth2Claims configures how to determine the identity of the user from the user info API calls.
+type OAuth2Claims struct {
+	// Username configures how to determine the username which will be presented to Kubernetes.
+	// The result must be a single string or number.
+	Username OAuth2ClaimMapping `json:"username"`
+
+	// UID configures how to determine a unique and unchanging identifier for the user, such as an ID number.
+	// The result must be a single string or number. It will be used to compute the subject of the
+	// downstream ID token, which allows the Supervisor to detect when the user's identity changes
+	// during a session refresh.
+	UID OAuth2ClaimMapping `json:"uid"`
+
+	// Groups configures how to determine the group names which will be presented to Kubernetes.
+	// The result must be a list of strings, or a single string. When not specified, the user will not
+	// be presented as a member of any groups.
+	//
+	// +optional
+	Groups *OAuth2ClaimMapping `json:"groups,omitempty"`
+}
+
+// OAuth2AuthorizationConfig provides information about how to form the OAuth2 requests to the upstream provider.
+type OAuth2AuthorizationConfig struct {
+	// Scopes are the OAuth2 scopes that will be requested as part of the authorization request flow.
+	// These are specific to the upstream provider. Some providers require particular scopes to allow
+	// the user info API calls to succeed.
+	//
+	// +listType=set
+	// +optional
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// OAuth2ClientSpec contains information about the OAuth2 client that this identity provider will use
+// for web-based login flows.
+type OAuth2ClientSpec struct {
+	// SecretName contains the name of a namespace-local Secret object that provides the clientID and
+	// clientSecret for an OAuth2 client registered with the upstream provider.
+	//
+	// This secret must be of type "secrets.pinniped.dev/oauth2-client" with keys "clientID" and "clientSecret".
+	//
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+}
+
+// OAuth2IdentityProviderSpec is the spec for configuring a generic OAuth2 identity provider.
+type OAuth2IdentityProviderSpec struct {
+	// AuthorizationEndpoint is the HTTPS URL of the upstream provider's OAuth2 authorization endpoint,
+	// to which the user's browser will be redirected to log in.
+	//
+	// +kubebuilder:validation:Pattern=`^https://`
+	AuthorizationEndpoint string `json:"authorizationEndpoint"`
+
+	// TokenEndpoint is the HTTPS URL of the upstream provider's OAuth2 token endpoint, which will be used
+	// to exchange authorization codes for tokens and to perform refreshes.
+	//
+	// +kubebuilder:validation:Pattern=`^https://`
+	TokenEndpoint string `json:"tokenEndpoint"`
+
+	// UserInfo configures the API calls used to learn the identity of the user.
+	UserInfo OAuth2UserInfoSpec `json:"userInfo"`
+
+	// TLS configuration for all HTTPS calls made by the Supervisor to the upstream provider.
+	//
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// AuthorizationConfig holds information about how to form the OAuth2 requests to the upstream provider.
+	//
+	// +optional
+	AuthorizationConfig OAuth2AuthorizationConfig `json:"authorizationConfig,omitempty"`
+
+	// Claims configures how to determine the identity of the user.
+	Claims OAuth2Claims `json:"claims"`
+
+	// Client identifies the secret with credentials for an OAuth2 client registered with the upstream provider.
+	Client OAuth2ClientSpec `json:"client"`
+}
+
+// OAuth2IdentityProvider describes the configuration of an upstream OAuth2 identity provider which does not
+// implement OpenID Connect. The identity of the user is determined by calling JSON APIs of the upstream provider
+// using the user's access token.
+//
+// For upstream providers which implement OpenID Connect, use an OIDCIdentityProvider instead.
+//
+// Right now, only web-based logins are supported, for both the pinniped-cli client and clients configured
+// as OIDCClients.
+//
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:categories=pinniped;pinniped-idp;pinniped-idps
+// +kubebuilder:printcolumn:name="Authorization Endpoint",type=string,JSONPath=`.spec.authorizationEndpoint`
+// +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// +kubebuilder:subresource:status
+type OAuth2IdentityProvider struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec for configuring the identity provider.
+	Spec OAuth2IdentityProviderSpec `json:"spec"`
+
+	// Status of the identity provider.
+	Status OAuth2IdentityProviderStatus `json:"status,omitempty"`
+}
+
+// OAuth2IdentityProviderList lists OAuth2IdentityProvider objects.
+//
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type OAuth2IdentityProviderList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []OAuth2IdentityProvider `json:"items"`
+}
@@ -15,10 +15,16 @@ type Interface interface {
 	ActiveDirectoryIdentityProviders() ActiveDirectoryIdentityProviderInformer
 	// GitHubIdentityProviders returns a GitHubIdentityProviderInformer.
 	GitHubIdentityProviders() GitHubIdentityProviderInformer
+	// GitLabIdentityProviders returns a GitLabIdentityProviderInformer.
+	GitLabIdentityProviders() GitLabIdentityProviderInformer
 	// LDAPIdentityProviders returns a LDAPIdentityProviderInformer.
 	LDAPIdentityProviders() LDAPIdentityProviderInformer
+	// OAuth2IdentityProviders returns a OAuth2IdentityProviderInformer.
+	OAuth2IdentityProviders() OAuth2IdentityProviderInformer
 	// OIDCIdentityProviders returns a OIDCIdentityProviderInformer.
 	OIDCIdentityProviders() OIDCIdentityProviderInformer
+	// SAMLIdentityProviders returns a SAMLIdentityProviderInformer.
+	SAMLIdentityProviders() SAMLIdentityProviderInformer
 }
 
 type version struct {
@@ -42,12 +48,27 @@ func (v *version) GitHubIdentityProviders() GitHubIdentityProviderInformer {
 	return &gitHubIdentityProviderInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
 
+// GitLabIdentityProviders returns a GitLabIdentityProviderInformer.
+func (v *version) GitLabIdentityProviders() GitLabIdentityProviderInformer {
+	return &gitLabIdentityProviderInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // LDAPIdentityProviders returns a LDAPIdentityProviderInformer.
 func (v *version) LDAPIdentityProviders() LDAPIdentityProviderInformer {
 	return &lDAPIdentityProviderInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
 
+// OAuth2IdentityProviders returns a OAuth2IdentityProviderInformer.
+func (v *version) OAuth2IdentityProviders() OAuth2IdentityProviderInformer {
+	return &oAuth2IdentityProviderInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // OIDCIdentityProviders returns a OIDCIdentityProviderInformer.
 func (v *version) OIDCIdentityProviders() OIDCIdentityProviderInformer {
 	return &oIDCIdentityProviderInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// SAMLIdentityProviders returns a SAMLIdentityProviderInformer.
+func (v *version) SAMLIdentityProviders() SAMLIdentityProviderInformer {
+	return &sAMLIdentityProviderInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
@@ -15,6 +15,18 @@ const (
 	// or an LDAPIdentityProvider.
 	AuthorizePasswordHeaderName = "Pinniped-Password" //nolint:gosec // this is not a credential
 
+	// AuthorizeTOTPCodeHeaderName is the name of the HTTP header which can be used to transmit a time-based
+	// one-time password to the authorize endpoint when using a password flow with an identity provider which
+	// requires one as a second factor. When a one-time password is required but was not sent, then the authorize
+	// endpoint returns an error response with the error code AuthorizeTOTPCodeRequiredErrorCode.
+	AuthorizeTOTPCodeHeaderName = "Pinniped-TOTP-Code"
+
+	// AuthorizeTOTPCodeRequiredErrorCode is the error code returned by the authorize endpoint when using a password
+	// flow with an identity provider which requires a time-based one-time password as a second factor, but it was
+	// not sent using the AuthorizeTOTPCodeHeaderName header. The client may ask the user for the one-time password
+	// and then try again.
+	AuthorizeTOTPCodeRequiredErrorCode = "interaction_required"
+
 	// AuthorizeUpstreamIDPNameParamName is the name of the HTTP request parameter which can be used to help select
 	// which identity provider should be used for authentication by sending the name of the desired identity provider.
 	AuthorizeUpstreamIDPNameParamName = "pinniped_idp_name"
@@ -23,6 +35,12 @@ const (
 	// which identity provider should be used for authentication by sending the type of the desired identity provider.
 	AuthorizeUpstreamIDPTypeParamName = "pinniped_idp_type"
 
+	// AuthorizeUpstreamIDPHintParamName is the name of the HTTP request parameter which can be used by a browser-based
+	// client to suggest which identity provider should be used for authentication by sending the name of the identity
+	// provider. Unlike AuthorizeUpstreamIDPNameParamName, an unknown name is ignored, and the end user may still choose
+	// another identity provider.
+	AuthorizeUpstreamIDPHintParamName = "idp_hint"
+
 	// IDTokenClaimIssuer is name of the issuer claim defined by the OIDC spec.
 	IDTokenClaimIssuer = "iss"
 
@@ -48,6 +66,11 @@ const (
 	// token, if any claims are present.
 	IDTokenClaimAdditionalClaims = "additionalClaims"
 
+	// IDTokenClaimActor is the name of the actor claim defined by RFC 8693. It is present in the ID tokens issued by
+	// a delegated token exchange, and its value is an object whose "sub" and "username" claims identify the actor
+	// which is acting on behalf of the subject of the ID token.
+	IDTokenClaimActor = "act"
+
 	// GrantTypeAuthorizationCode is the name of the grant type for authorization code flows defined by the OIDC spec.
 	GrantTypeAuthorizationCode = "authorization_code"
 
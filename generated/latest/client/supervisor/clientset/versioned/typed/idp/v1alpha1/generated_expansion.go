@@ -9,6 +9,12 @@ type ActiveDirectoryIdentityProviderExpansion interface{}
 
 type GitHubIdentityProviderExpansion interface{}
 
+type GitLabIdentityProviderExpansion interface{}
+
 type LDAPIdentityProviderExpansion interface{}
 
+type OAuth2IdentityProviderExpansion interface{}
+
 type OIDCIdentityProviderExpansion interface{}
+
+type SAMLIdentityProviderExpansion interface{}
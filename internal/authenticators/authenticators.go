@@ -38,4 +38,5 @@ type Response struct {
 	User                   user.Info
 	DN                     string
 	ExtraRefreshAttributes map[string]string
+	AdditionalClaims       map[string]any
 }
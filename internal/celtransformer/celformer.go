@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -24,10 +25,11 @@ import (
 )
 
 const (
-	usernameVariableName        = "username"
-	groupsVariableName          = "groups"
-	constStringVariableName     = "strConst"
-	constStringListVariableName = "strListConst"
+	usernameVariableName         = "username"
+	groupsVariableName           = "groups"
+	constStringVariableName      = "strConst"
+	constStringListVariableName  = "strListConst"
+	additionalClaimsVariableName = "additionalClaims"
 
 	DefaultPolicyRejectedAuthMessage = "authentication was rejected by a configured policy"
 )
@@ -35,8 +37,9 @@ const (
 // CELTransformer can compile any number of transformation expression pipelines.
 // Each compiled pipeline can be cached in memory for later thread-safe evaluation.
 type CELTransformer struct {
-	compiler             *cel.Env
-	maxExpressionRuntime time.Duration
+	compiler                   *cel.Env
+	groupEnrichmentKeyCompiler *cel.Env
+	maxExpressionRuntime       time.Duration
 }
 
 // NewCELTransformer returns a CELTransformer.
@@ -46,7 +49,18 @@ func NewCELTransformer(maxExpressionRuntime time.Duration) (*CELTransformer, err
 	if err != nil {
 		return nil, err
 	}
-	return &CELTransformer{compiler: env, maxExpressionRuntime: maxExpressionRuntime}, nil
+	// Group enrichment keys may also use the upstream identity provider's additional claims for the user.
+	groupEnrichmentKeyEnv, err := env.Extend(
+		cel.Variable(additionalClaimsVariableName, cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &CELTransformer{
+		compiler:                   env,
+		groupEnrichmentKeyCompiler: groupEnrichmentKeyEnv,
+		maxExpressionRuntime:       maxExpressionRuntime,
+	}, nil
 }
 
 // TransformationConstants can be used to make more variables available to compiled CEL expressions for convenience.
@@ -91,6 +105,32 @@ func (c *CELTransformer) CompileTransformation(t CELTransformation, consts *Tran
 	return t.compile(c, consts)
 }
 
+// CompileGroupEnrichmentKey compiles a CEL-based group enrichment key expression.
+// The compiled key can be cached in memory and evaluated repeatedly and in a thread-safe way.
+// The same restrictions on the consts param apply as for CompileTransformation.
+func (c *CELTransformer) CompileGroupEnrichmentKey(k *GroupEnrichmentKey, consts *TransformationConstants) (idtransform.GroupEnrichmentKey, error) {
+	if consts == nil {
+		consts = &TransformationConstants{}
+	}
+	if err := consts.validateVariableNames(); err != nil {
+		return nil, err
+	}
+	// The values of additionalClaims have a dynamic type, so allow expressions of dynamic type which return a string
+	// at runtime. The result is converted to a string during evaluation, which fails for any other type.
+	program, err := compileProgramAllowingTypes(c.groupEnrichmentKeyCompiler, []*cel.Type{cel.StringType, cel.DynType}, k.Expression)
+	if err != nil {
+		return nil, err
+	}
+	return &compiledGroupEnrichmentKey{
+		baseCompiledTransformation: &baseCompiledTransformation{
+			program:              program,
+			consts:               consts,
+			maxExpressionRuntime: c.maxExpressionRuntime,
+		},
+		sourceKey: k,
+	}, nil
+}
+
 // CELTransformation can be compiled into an IdentityTransformation.
 type CELTransformation interface {
 	compile(transformer *CELTransformer, consts *TransformationConstants) (idtransform.IdentityTransformation, error)
@@ -121,7 +161,20 @@ type AllowAuthenticationPolicy struct {
 	RejectedAuthenticationMessage string
 }
 
-func compileProgram(transformer *CELTransformer, expectedExpressionType *cel.Type, expr string) (cel.Program, error) {
+// GroupEnrichmentKey is a CEL expression which computes the key used to look up a user's additional group
+// memberships in a secondary identity provider. It must return a string. It is not a CELTransformation because
+// it does not change the identity by itself.
+type GroupEnrichmentKey struct {
+	Expression string
+}
+
+func compileProgram(compiler *cel.Env, expectedExpressionType *cel.Type, expr string) (cel.Program, error) {
+	return compileProgramAllowingTypes(compiler, []*cel.Type{expectedExpressionType}, expr)
+}
+
+// compileProgramAllowingTypes is like compileProgram, but allows the expression to return any of the given types.
+// The first type is the one which is named in the error message when the expression returns another type.
+func compileProgramAllowingTypes(compiler *cel.Env, allowedExpressionTypes []*cel.Type, expr string) (cel.Program, error) {
 	if strings.TrimSpace(expr) == "" {
 		return nil, fmt.Errorf("cannot compile empty CEL expression")
 	}
@@ -129,19 +182,19 @@ func compileProgram(transformer *CELTransformer, expectedExpressionType *cel.Typ
 	// compile does both parsing and type checking. The parsing phase indicates whether the expression is
 	// syntactically valid and expands any macros present within the environment. Parsing and checking are
 	// more computationally expensive than evaluation, so parsing and checking are done in advance.
-	ast, issues := transformer.compiler.Compile(expr)
+	ast, issues := compiler.Compile(expr)
 	if issues != nil {
 		return nil, fmt.Errorf("CEL expression compile error: %s", issues.String())
 	}
 
 	// The compiler's type checker has determined the type of the expression's result.
 	// Check that it matches the type that we expect.
-	if ast.OutputType().String() != expectedExpressionType.String() {
-		return nil, fmt.Errorf("CEL expression should return type %q but returns type %q", expectedExpressionType, ast.OutputType())
+	if !slices.ContainsFunc(allowedExpressionTypes, func(t *cel.Type) bool { return ast.OutputType().String() == t.String() }) {
+		return nil, fmt.Errorf("CEL expression should return type %q but returns type %q", allowedExpressionTypes[0], ast.OutputType())
 	}
 
 	// The cel.Program is stateless, thread-safe, and cachable.
-	program, err := transformer.compiler.Program(ast,
+	program, err := compiler.Program(ast,
 		cel.InterruptCheckFrequency(100), // Kubernetes uses 100 here, so we'll copy that setting.
 		cel.EvalOptions(cel.OptOptimize), // Optimize certain things now rather than at evaluation time.
 	)
@@ -152,7 +205,7 @@ func compileProgram(transformer *CELTransformer, expectedExpressionType *cel.Typ
 }
 
 func (t *UsernameTransformation) compile(transformer *CELTransformer, consts *TransformationConstants) (idtransform.IdentityTransformation, error) {
-	program, err := compileProgram(transformer, cel.StringType, t.Expression)
+	program, err := compileProgram(transformer.compiler, cel.StringType, t.Expression)
 	if err != nil {
 		return nil, err
 	}
@@ -167,7 +220,7 @@ func (t *UsernameTransformation) compile(transformer *CELTransformer, consts *Tr
 }
 
 func (t *GroupsTransformation) compile(transformer *CELTransformer, consts *TransformationConstants) (idtransform.IdentityTransformation, error) {
-	program, err := compileProgram(transformer, cel.ListType(cel.StringType), t.Expression)
+	program, err := compileProgram(transformer.compiler, cel.ListType(cel.StringType), t.Expression)
 	if err != nil {
 		return nil, err
 	}
@@ -182,7 +235,7 @@ func (t *GroupsTransformation) compile(transformer *CELTransformer, consts *Tran
 }
 
 func (t *AllowAuthenticationPolicy) compile(transformer *CELTransformer, consts *TransformationConstants) (idtransform.IdentityTransformation, error) {
-	program, err := compileProgram(transformer, cel.BoolType, t.Expression)
+	program, err := compileProgram(transformer.compiler, cel.BoolType, t.Expression)
 	if err != nil {
 		return nil, err
 	}
@@ -221,7 +274,18 @@ type compiledAllowAuthenticationPolicy struct {
 	rejectedAuthenticationMessage string
 }
 
-func (c *baseCompiledTransformation) evalProgram(ctx context.Context, username string, groups []string) (ref.Val, error) {
+// Implements idtransform.GroupEnrichmentKey.
+type compiledGroupEnrichmentKey struct {
+	*baseCompiledTransformation
+	sourceKey *GroupEnrichmentKey
+}
+
+func (c *baseCompiledTransformation) evalProgram(
+	ctx context.Context,
+	username string,
+	groups []string,
+	additionalClaims map[string]any,
+) (ref.Val, error) {
 	// Limit the runtime of a CEL expression to avoid accidental very expensive expressions.
 	timeoutCtx, cancel := context.WithTimeout(ctx, c.maxExpressionRuntime)
 	defer cancel()
@@ -230,16 +294,17 @@ func (c *baseCompiledTransformation) evalProgram(ctx context.Context, username s
 	// and if fields are present in the input, but not referenced in the expression, they are ignored.
 	// The argument to Eval may either be an `interpreter.Activation` or a `map[string]any`.
 	val, _, err := c.program.ContextEval(timeoutCtx, map[string]any{
-		usernameVariableName:        username,
-		groupsVariableName:          groups,
-		constStringVariableName:     c.consts.StringConstants,
-		constStringListVariableName: c.consts.StringListConstants,
+		usernameVariableName:         username,
+		groupsVariableName:           groups,
+		constStringVariableName:      c.consts.StringConstants,
+		constStringListVariableName:  c.consts.StringListConstants,
+		additionalClaimsVariableName: additionalClaims,
 	})
 	return val, err
 }
 
-func (c *compiledUsernameTransformation) Evaluate(ctx context.Context, username string, groups []string) (*idtransform.TransformationResult, error) {
-	val, err := c.evalProgram(ctx, username, groups)
+func (c *compiledUsernameTransformation) Evaluate(ctx context.Context, username string, groups []string, additionalClaims map[string]any) (*idtransform.TransformationResult, error) {
+	val, err := c.evalProgram(ctx, username, groups, additionalClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -258,8 +323,8 @@ func (c *compiledUsernameTransformation) Evaluate(ctx context.Context, username
 	}, nil
 }
 
-func (c *compiledGroupsTransformation) Evaluate(ctx context.Context, username string, groups []string) (*idtransform.TransformationResult, error) {
-	val, err := c.evalProgram(ctx, username, groups)
+func (c *compiledGroupsTransformation) Evaluate(ctx context.Context, username string, groups []string, additionalClaims map[string]any) (*idtransform.TransformationResult, error) {
+	val, err := c.evalProgram(ctx, username, groups, additionalClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -278,8 +343,8 @@ func (c *compiledGroupsTransformation) Evaluate(ctx context.Context, username st
 	}, nil
 }
 
-func (c *compiledAllowAuthenticationPolicy) Evaluate(ctx context.Context, username string, groups []string) (*idtransform.TransformationResult, error) {
-	val, err := c.evalProgram(ctx, username, groups)
+func (c *compiledAllowAuthenticationPolicy) Evaluate(ctx context.Context, username string, groups []string, additionalClaims map[string]any) (*idtransform.TransformationResult, error) {
+	val, err := c.evalProgram(ctx, username, groups, additionalClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -306,11 +371,36 @@ func (c *compiledAllowAuthenticationPolicy) Evaluate(ctx context.Context, userna
 	return result, nil
 }
 
+func (c *compiledGroupEnrichmentKey) Evaluate(ctx context.Context, username string, groups []string, additionalClaims map[string]any) (string, error) {
+	val, err := c.evalProgram(ctx, username, groups, additionalClaims)
+	if err != nil {
+		return "", err
+	}
+	nativeValue, err := val.ConvertToNative(reflect.TypeOf(""))
+	if err != nil {
+		return "", fmt.Errorf("could not convert expression result to string: %w", err)
+	}
+	stringValue, ok := nativeValue.(string)
+	if !ok {
+		return "", fmt.Errorf("could not convert expression result to string")
+	}
+	return stringValue, nil
+}
+
 type CELTransformationSource struct {
 	Expr   CELTransformation
 	Consts *TransformationConstants
 }
 
+type CELGroupEnrichmentKeySource struct {
+	Key    *GroupEnrichmentKey
+	Consts *TransformationConstants
+}
+
+func (c *compiledGroupEnrichmentKey) Source() any {
+	return &CELGroupEnrichmentKeySource{Key: c.sourceKey, Consts: c.consts}
+}
+
 func (c *compiledUsernameTransformation) Source() any {
 	return &CELTransformationSource{Expr: c.sourceExpr, Consts: c.consts}
 }
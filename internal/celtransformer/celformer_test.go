@@ -813,7 +813,7 @@ func TestTransformer(t *testing.T) {
 				ctx = tt.ctx
 			}
 
-			result, err := pipeline.Evaluate(ctx, tt.username, tt.groups)
+			result, err := pipeline.Evaluate(ctx, tt.username, tt.groups, nil)
 			if tt.wantEvaluationErr != "" {
 				require.EqualError(t, err, tt.wantEvaluationErr)
 				return // the rest of the test doesn't make sense when there was an evaluation error
@@ -830,6 +830,103 @@ func TestTransformer(t *testing.T) {
 	}
 }
 
+func TestGroupEnrichmentKey(t *testing.T) {
+	tests := []struct {
+		name              string
+		expression        string
+		consts            *TransformationConstants
+		additionalClaims  map[string]any
+		wantKey           string
+		wantCompileErr    string
+		wantEvaluationErr string
+	}{
+		{
+			name:       "simple expression",
+			expression: `username + "@example.com"`,
+			wantKey:    "ryan@example.com",
+		},
+		{
+			name:       "expression using groups and constants",
+			expression: `groups.exists(g, g == "admins") ? strConst.domain : ""`,
+			consts:     &TransformationConstants{StringConstants: map[string]string{"domain": "example.com"}},
+			wantKey:    "example.com",
+		},
+		{
+			name:             "expression using additional claims",
+			expression:       `has(additionalClaims.email) ? additionalClaims.email : ""`,
+			additionalClaims: map[string]any{"email": "ryan@example.com"},
+			wantKey:          "ryan@example.com",
+		},
+		{
+			name:       "expression using additional claims when the claim is not present",
+			expression: `has(additionalClaims.email) ? additionalClaims.email : ""`,
+			wantKey:    "",
+		},
+		{
+			name:             "expression using nested additional claims",
+			expression:       `additionalClaims.roles["some-org"]`,
+			additionalClaims: map[string]any{"roles": map[string]any{"some-org": "admin"}},
+			wantKey:          "admin",
+		},
+		{
+			name:              "expression using additional claims which is not a string at runtime",
+			expression:        `additionalClaims.count`,
+			additionalClaims:  map[string]any{"count": 42},
+			wantEvaluationErr: "could not convert expression result to string: unsupported type conversion from 'int' to string",
+		},
+		{
+			name:           "expression which does not return a string",
+			expression:     `username == "ryan"`,
+			wantCompileErr: `CEL expression should return type "string" but returns type "bool"`,
+		},
+		{
+			name:           "empty expression",
+			expression:     `   `,
+			wantCompileErr: "cannot compile empty CEL expression",
+		},
+		{
+			name:              "expression which errors at runtime",
+			expression:        `string(1 / (size(groups) - 2))`,
+			wantEvaluationErr: "division by zero",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			transformer, err := NewCELTransformer(100 * time.Millisecond)
+			require.NoError(t, err)
+
+			key := &GroupEnrichmentKey{Expression: tt.expression}
+			compiled, err := transformer.CompileGroupEnrichmentKey(key, tt.consts)
+			if tt.wantCompileErr != "" {
+				require.EqualError(t, err, tt.wantCompileErr)
+				return
+			}
+			require.NoError(t, err)
+
+			wantConsts := tt.consts
+			if wantConsts == nil {
+				wantConsts = &TransformationConstants{}
+			}
+			require.Equal(t, &CELGroupEnrichmentKeySource{Key: key, Consts: wantConsts}, compiled.Source())
+
+			additionalClaims := tt.additionalClaims
+			if additionalClaims == nil {
+				additionalClaims = map[string]any{}
+			}
+			result, err := compiled.Evaluate(context.Background(), "ryan", []string{"admins", "developers"}, additionalClaims)
+			if tt.wantEvaluationErr != "" {
+				require.EqualError(t, err, tt.wantEvaluationErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantKey, result)
+		})
+	}
+}
+
 func TestTypicalPerformanceAndThreadSafety(t *testing.T) {
 	t.Parallel()
 
@@ -858,7 +955,7 @@ func TestTypicalPerformanceAndThreadSafety(t *testing.T) {
 	sort.Strings(wantGroups)
 
 	// Before looking at performance, check that the behavior of the function is correct.
-	result, err := pipeline.Evaluate(context.Background(), "ryan", groups)
+	result, err := pipeline.Evaluate(context.Background(), "ryan", groups, nil)
 	require.NoError(t, err)
 	require.Equal(t, "username_prefix:ryan", result.Username)
 	require.Equal(t, wantGroups, result.Groups)
@@ -870,7 +967,7 @@ func TestTypicalPerformanceAndThreadSafety(t *testing.T) {
 	iterations := 1000
 	start := time.Now()
 	for range iterations {
-		_, _ = pipeline.Evaluate(context.Background(), "ryan", groups)
+		_, _ = pipeline.Evaluate(context.Background(), "ryan", groups, nil)
 	}
 	elapsed := time.Since(start)
 	t.Logf("TestTypicalPerformanceAndThreadSafety %d iterations of Evaluate took %s; average runtime %s", iterations, elapsed, elapsed/time.Duration(iterations))
@@ -889,7 +986,7 @@ func TestTypicalPerformanceAndThreadSafety(t *testing.T) {
 		go func() {
 			defer wg.Done() // decrement WaitGroup counter when this goroutine finishes
 			for range iterations * 2 {
-				localResult, localErr := pipeline.Evaluate(context.Background(), "ryan", groups)
+				localResult, localErr := pipeline.Evaluate(context.Background(), "ryan", groups, nil)
 				require.NoError(t, localErr)
 				require.Equal(t, "username_prefix:ryan", localResult.Username)
 				require.Equal(t, wantGroups, localResult.Groups)
@@ -31,6 +31,14 @@ const (
 	// allow traffic from the control plane to most ports, but do allow traffic to port 10250. This allows
 	// the Concierge to work without additional configuration on these types of clusters.
 	aggregatedAPIServerPortDefault = 10250
+
+	// By default, each username may fail to log in 5 times in a row, and each client IP address may fail to log in
+	// 50 times in a row, before their logins are delayed. The delays start at one second and double with each
+	// further failure, up to 15 minutes.
+	loginRateLimitPerUsernameAllowedFailuresDefault = 5
+	loginRateLimitPerSourceIPAllowedFailuresDefault = 50
+	loginRateLimitInitialBackoffSecondsDefault      = 1
+	loginRateLimitMaxBackoffSecondsDefault          = 15 * 60
 )
 
 // FromPath loads an Config from a provided local file path, inserts any
@@ -100,6 +108,13 @@ func FromPath(ctx context.Context, path string, setAllowedCiphers ptls.SetAllowe
 		return nil, fmt.Errorf("validate tls: %w", err)
 	}
 
+	maybeSetLoginRateLimitDefaults(&config.LoginRateLimits.PerUsername, loginRateLimitPerUsernameAllowedFailuresDefault)
+	maybeSetLoginRateLimitDefaults(&config.LoginRateLimits.PerSourceIP, loginRateLimitPerSourceIPAllowedFailuresDefault)
+
+	if err := validateLoginRateLimits(config.LoginRateLimits); err != nil {
+		return nil, fmt.Errorf("validate loginRateLimits: %w", err)
+	}
+
 	return &config, nil
 }
 
@@ -126,6 +141,46 @@ func maybeSetAggregatedAPIServerPortDefaults(port **int64) {
 	}
 }
 
+func maybeSetLoginRateLimitDefaults(limit *LoginRateLimitSpec, allowedFailuresDefault int64) {
+	if limit.AllowedFailures == nil {
+		limit.AllowedFailures = ptr.To(allowedFailuresDefault)
+	}
+	if limit.InitialBackoffSeconds == nil {
+		limit.InitialBackoffSeconds = ptr.To[int64](loginRateLimitInitialBackoffSecondsDefault)
+	}
+	if limit.MaxBackoffSeconds == nil {
+		limit.MaxBackoffSeconds = ptr.To[int64](loginRateLimitMaxBackoffSecondsDefault)
+	}
+}
+
+func validateLoginRateLimits(limits LoginRateLimitsSpec) error {
+	if err := validateLoginRateLimit(limits.PerUsername); err != nil {
+		return fmt.Errorf("perUsername: %w", err)
+	}
+	if err := validateLoginRateLimit(limits.PerSourceIP); err != nil {
+		return fmt.Errorf("perSourceIP: %w", err)
+	}
+	for _, cidr := range limits.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("trustedProxies: %w", err)
+		}
+	}
+	return nil
+}
+
+func validateLoginRateLimit(limit LoginRateLimitSpec) error {
+	if *limit.AllowedFailures < 0 {
+		return constable.Error("allowedFailures must not be negative")
+	}
+	if *limit.InitialBackoffSeconds < 1 {
+		return constable.Error("initialBackoffSeconds must be at least 1")
+	}
+	if *limit.MaxBackoffSeconds < *limit.InitialBackoffSeconds {
+		return constable.Error("maxBackoffSeconds must not be less than initialBackoffSeconds")
+	}
+	return nil
+}
+
 func validateNames(names *NamesConfigSpec) error {
 	missingNames := []string{}
 	if names.DefaultTLSCertificateSecret == "" {
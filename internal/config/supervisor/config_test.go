@@ -52,6 +52,16 @@ func TestFromPath(t *testing.T) {
 				    - foo
 				    - bar
 				    - TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305
+				loginRateLimits:
+				  perUsername:
+				    allowedFailures: 3
+				    initialBackoffSeconds: 2
+				    maxBackoffSeconds: 60
+				  perSourceIP:
+				    allowedFailures: 0
+				  trustedProxies:
+				  - 10.0.0.0/8
+				  - fd00::/8
 			`),
 			wantConfig: &Config{
 				APIGroupSuffix: ptr.To("some.suffix.com"),
@@ -86,6 +96,19 @@ func TestFromPath(t *testing.T) {
 						},
 					},
 				},
+				LoginRateLimits: LoginRateLimitsSpec{
+					PerUsername: LoginRateLimitSpec{
+						AllowedFailures:       ptr.To[int64](3),
+						InitialBackoffSeconds: ptr.To[int64](2),
+						MaxBackoffSeconds:     ptr.To[int64](60),
+					},
+					PerSourceIP: LoginRateLimitSpec{
+						AllowedFailures:       ptr.To[int64](0),
+						InitialBackoffSeconds: ptr.To[int64](1),
+						MaxBackoffSeconds:     ptr.To[int64](900),
+					},
+					TrustedProxies: []string{"10.0.0.0/8", "fd00::/8"},
+				},
 			},
 		},
 		{
@@ -123,8 +146,69 @@ func TestFromPath(t *testing.T) {
 					},
 				},
 				AggregatedAPIServerPort: ptr.To[int64](10250),
+				LoginRateLimits: LoginRateLimitsSpec{
+					PerUsername: LoginRateLimitSpec{
+						AllowedFailures:       ptr.To[int64](5),
+						InitialBackoffSeconds: ptr.To[int64](1),
+						MaxBackoffSeconds:     ptr.To[int64](900),
+					},
+					PerSourceIP: LoginRateLimitSpec{
+						AllowedFailures:       ptr.To[int64](50),
+						InitialBackoffSeconds: ptr.To[int64](1),
+						MaxBackoffSeconds:     ptr.To[int64](900),
+					},
+				},
 			},
 		},
+		{
+			name: "negative allowed failures of login rate limit",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				loginRateLimits:
+				  perUsername:
+				    allowedFailures: -1
+			`),
+			wantError: "validate loginRateLimits: perUsername: allowedFailures must not be negative",
+		},
+		{
+			name: "zero initial backoff of login rate limit",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				loginRateLimits:
+				  perSourceIP:
+				    initialBackoffSeconds: 0
+			`),
+			wantError: "validate loginRateLimits: perSourceIP: initialBackoffSeconds must be at least 1",
+		},
+		{
+			name: "max backoff of login rate limit is less than initial backoff",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				loginRateLimits:
+				  perUsername:
+				    initialBackoffSeconds: 10
+				    maxBackoffSeconds: 5
+			`),
+			wantError: "validate loginRateLimits: perUsername: maxBackoffSeconds must not be less than initialBackoffSeconds",
+		},
+		{
+			name: "invalid trusted proxy of login rate limits",
+			yaml: here.Doc(`
+				---
+				names:
+				  defaultTLSCertificateSecret: my-secret-name
+				loginRateLimits:
+				  trustedProxies:
+				  - 10.0.0.1
+			`),
+			wantError: "validate loginRateLimits: trustedProxies: invalid CIDR address: 10.0.0.1",
+		},
 		{
 			name: "all endpoints disabled",
 			yaml: here.Doc(`
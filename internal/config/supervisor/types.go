@@ -9,13 +9,14 @@ import (
 
 // Config contains knobs to setup an instance of the Pinniped Supervisor.
 type Config struct {
-	APIGroupSuffix          *string           `json:"apiGroupSuffix,omitempty"`
-	Labels                  map[string]string `json:"labels"`
-	NamesConfig             NamesConfigSpec   `json:"names"`
-	Log                     plog.LogSpec      `json:"log"`
-	Endpoints               *Endpoints        `json:"endpoints"`
-	AggregatedAPIServerPort *int64            `json:"aggregatedAPIServerPort"`
-	TLS                     TLSSpec           `json:"tls"`
+	APIGroupSuffix          *string             `json:"apiGroupSuffix,omitempty"`
+	Labels                  map[string]string   `json:"labels"`
+	NamesConfig             NamesConfigSpec     `json:"names"`
+	Log                     plog.LogSpec        `json:"log"`
+	Endpoints               *Endpoints          `json:"endpoints"`
+	AggregatedAPIServerPort *int64              `json:"aggregatedAPIServerPort"`
+	TLS                     TLSSpec             `json:"tls"`
+	LoginRateLimits         LoginRateLimitsSpec `json:"loginRateLimits"`
 }
 
 type TLSSpec struct {
@@ -29,6 +30,30 @@ type TLSProtocolSpec struct {
 	AllowedCiphers []string `json:"allowedCiphers"`
 }
 
+// LoginRateLimitsSpec configures how logins with a username and password are delayed after failed logins,
+// which slows down password guessing.
+type LoginRateLimitsSpec struct {
+	// Disabled turns off the delays.
+	Disabled bool `json:"disabled"`
+	// PerUsername limits the failed logins of each username.
+	PerUsername LoginRateLimitSpec `json:"perUsername"`
+	// PerSourceIP limits the failed logins from each client IP address.
+	PerSourceIP LoginRateLimitSpec `json:"perSourceIP"`
+	// TrustedProxies are the CIDRs of the proxies in front of the Supervisor which set the X-Forwarded-For header.
+	// When a request comes from one of these, then the client IP address is read from that header.
+	TrustedProxies []string `json:"trustedProxies"`
+}
+
+type LoginRateLimitSpec struct {
+	// AllowedFailures is the number of consecutive failed logins which are not delayed.
+	AllowedFailures *int64 `json:"allowedFailures"`
+	// InitialBackoffSeconds is the delay after the first failed login beyond the allowed failures.
+	// It doubles with each further failed login.
+	InitialBackoffSeconds *int64 `json:"initialBackoffSeconds"`
+	// MaxBackoffSeconds is the maximum delay.
+	MaxBackoffSeconds *int64 `json:"maxBackoffSeconds"`
+}
+
 // NamesConfigSpec configures the names of some Kubernetes resources for the Supervisor.
 type NamesConfigSpec struct {
 	DefaultTLSCertificateSecret string `json:"defaultTLSCertificateSecret"`
@@ -11,3 +11,13 @@ package authenticator
 type Closer interface {
 	Close()
 }
+
+const (
+	// ActorSubjectExtraKey is the key of the user's extra info which holds the subject of the actor which is acting
+	// on behalf of the user, when a JWTAuthenticator is configured to read the actor claim of its tokens.
+	ActorSubjectExtraKey = "authentication.concierge.pinniped.dev/actor-subject"
+
+	// ActorUsernameExtraKey is the key of the user's extra info which holds the username of the actor which is acting
+	// on behalf of the user, when a JWTAuthenticator is configured to read the actor claim of its tokens.
+	ActorUsernameExtraKey = "authentication.concierge.pinniped.dev/actor-username"
+)
@@ -13,6 +13,7 @@ import (
 	"net/url"
 	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -674,6 +675,7 @@ func (c *jwtCacheFillerController) newCachedJWTAuthenticator(
 					Claim:  groupsClaim,
 					Prefix: ptr.To(""),
 				},
+				Extra: actorExtraMappings(spec.Claims.Actor),
 			},
 		},
 		KeySet:               keySet,
@@ -707,6 +709,24 @@ func (c *jwtCacheFillerController) newCachedJWTAuthenticator(
 	}, conditions, nil
 }
 
+// actorExtraMappings returns the mappings which copy the "sub" and "username" of the actor claim into the user's
+// extra info, or nil when no actor claim was configured. Tokens without an actor claim do not get any extra info,
+// because the oidc authenticator skips extra values which are empty.
+func actorExtraMappings(actorClaim string) []apiserver.ExtraMapping {
+	if actorClaim == "" {
+		return nil
+	}
+	valueExpression := func(field string) string {
+		claim := fmt.Sprintf("claims[%s]", strconv.Quote(actorClaim))
+		return fmt.Sprintf("%s in claims && %s in %s ? %s[%s] : \"\"",
+			strconv.Quote(actorClaim), strconv.Quote(field), claim, claim, strconv.Quote(field))
+	}
+	return []apiserver.ExtraMapping{
+		{Key: pinnipedauthenticator.ActorSubjectExtraKey, ValueExpression: valueExpression(oidcapi.IDTokenClaimSubject)},
+		{Key: pinnipedauthenticator.ActorUsernameExtraKey, ValueExpression: valueExpression(oidcapi.IDTokenClaimUsername)},
+	}
+}
+
 func (c *jwtCacheFillerController) updateStatus(
 	ctx context.Context,
 	original *authenticationv1alpha1.JWTAuthenticator,
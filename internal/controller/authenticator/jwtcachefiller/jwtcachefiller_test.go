@@ -362,6 +362,14 @@ func TestController(t *testing.T) {
 			Groups: customGroupsClaim,
 		},
 	}
+	someJWTAuthenticatorSpecWithActorClaim := &authenticationv1alpha1.JWTAuthenticatorSpec{
+		Issuer:   goodIssuer,
+		Audience: goodAudience,
+		TLS:      goodOIDCIssuerServerTLSSpec,
+		Claims: authenticationv1alpha1.JWTTokenClaims{
+			Actor: "act",
+		},
+	}
 	otherJWTAuthenticatorSpec := &authenticationv1alpha1.JWTAuthenticatorSpec{
 		Issuer:   someOtherIssuer,
 		Audience: goodAudience,
@@ -719,6 +727,7 @@ func TestController(t *testing.T) {
 		wantActions                         func() []coretesting.Action
 		wantUsernameClaim                   string
 		wantGroupsClaim                     string
+		wantActorClaim                      string
 		wantNamesOfJWTAuthenticatorsInCache []string
 		skipTestingCachedAuthenticator      bool
 	}{
@@ -1110,6 +1119,41 @@ func TestController(t *testing.T) {
 			wantGroupsClaim:                     someJWTAuthenticatorSpecWithGroupsClaim.Claims.Groups,
 			wantNamesOfJWTAuthenticatorsInCache: []string{"test-name"},
 		},
+		{
+			name: "Sync: JWTAuthenticator with actor claim: loop will complete successfully and update status conditions",
+			jwtAuthenticators: []runtime.Object{
+				&authenticationv1alpha1.JWTAuthenticator{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-name",
+					},
+					Spec: *someJWTAuthenticatorSpecWithActorClaim,
+				},
+			},
+			wantLogLines: []string{
+				fmt.Sprintf(`{"level":"debug","timestamp":"2099-08-08T13:57:36.123456Z","logger":"jwtcachefiller-controller","caller":"jwtcachefiller/jwtcachefiller.go:<line>$jwtcachefiller.(*jwtCacheFillerController).updateStatus","message":"jwtauthenticator status successfully updated","jwtAuthenticator":"test-name","issuer":"%s","phase":"Ready"}`, goodIssuer),
+				fmt.Sprintf(`{"level":"info","timestamp":"2099-08-08T13:57:36.123456Z","logger":"jwtcachefiller-controller","caller":"jwtcachefiller/jwtcachefiller.go:<line>$jwtcachefiller.(*jwtCacheFillerController).syncIndividualJWTAuthenticator","message":"added or updated jwt authenticator in cache","jwtAuthenticator":"test-name","issuer":"%s","isOverwrite":false}`, goodIssuer),
+			},
+			wantActions: func() []coretesting.Action {
+				updateStatusAction := coretesting.NewUpdateAction(jwtAuthenticatorsGVR, "", &authenticationv1alpha1.JWTAuthenticator{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-name",
+					},
+					Spec: *someJWTAuthenticatorSpecWithActorClaim,
+					Status: authenticationv1alpha1.JWTAuthenticatorStatus{
+						Conditions: allHappyConditionsSuccess(goodIssuer, frozenMetav1Now, 0),
+						Phase:      "Ready",
+					},
+				})
+				updateStatusAction.Subresource = "status"
+				return []coretesting.Action{
+					coretesting.NewListAction(jwtAuthenticatorsGVR, jwtAUthenticatorGVK, "", metav1.ListOptions{}),
+					coretesting.NewWatchAction(jwtAuthenticatorsGVR, "", metav1.ListOptions{}),
+					updateStatusAction,
+				}
+			},
+			wantActorClaim:                      someJWTAuthenticatorSpecWithActorClaim.Claims.Actor,
+			wantNamesOfJWTAuthenticatorsInCache: []string{"test-name"},
+		},
 		{
 			name: "Sync: JWTAuthenticator with new spec fields: loop will close previous instance of JWTAuthenticator and complete successfully and update status conditions",
 			cache: func(t *testing.T, cache *authncache.Cache, wantClose bool) {
@@ -2426,6 +2470,49 @@ func TestController(t *testing.T) {
 						}
 					})
 				}
+
+				if tt.wantActorClaim != "" {
+					t.Run("good token with actor", func(t *testing.T) {
+						t.Parallel()
+
+						sig, err := jose.NewSigner(
+							jose.SigningKey{Algorithm: goodECSigningAlgo, Key: goodECSigningKey},
+							(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", goodECSigningKeyID),
+						)
+						require.NoError(t, err)
+						jwt, err := josejwt.Signed(sig).Claims(josejwt.Claims{
+							Issuer:   goodIssuer,
+							Subject:  goodSubject,
+							Audience: []string{goodAudience},
+							Expiry:   josejwt.NewNumericDate(time.Now().Add(time.Hour)),
+							IssuedAt: josejwt.NewNumericDate(time.Now().Add(-time.Hour)),
+						}).Claims(map[string]any{
+							tt.wantUsernameClaim: goodUsername,
+							tt.wantActorClaim:    map[string]any{"sub": "some-actor-subject", "username": "some-actor"},
+						}).Serialize()
+						require.NoError(t, err)
+
+						var (
+							rsp           *authenticator.Response
+							authenticated bool
+						)
+						_ = wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(ctx context.Context) (bool, error) {
+							rsp, authenticated, err = cachedAuthenticator.AuthenticateToken(context.Background(), jwt)
+							return !isNotInitialized(err), nil
+						})
+						require.NoError(t, err)
+						require.True(t, authenticated)
+						require.Equal(t, &authenticator.Response{
+							User: &user.DefaultInfo{
+								Name: goodUsername,
+								Extra: map[string][]string{
+									"authentication.concierge.pinniped.dev/actor-subject":  {"some-actor-subject"},
+									"authentication.concierge.pinniped.dev/actor-username": {"some-actor"},
+								},
+							},
+						}, rsp)
+					})
+				}
 			}
 		})
 	}
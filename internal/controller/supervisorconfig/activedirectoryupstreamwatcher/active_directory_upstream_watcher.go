@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/google/uuid"
@@ -18,6 +19,8 @@ import (
 	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 
 	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
@@ -230,6 +233,8 @@ type activeDirectoryWatcherController struct {
 	cache                                   UpstreamActiveDirectoryIdentityProviderICache
 	validatedSettingsCache                  upstreamwatchers.ValidatedSettingsCacheI
 	ldapDialer                              upstreamldap.LDAPDialer
+	connectionPools                         *upstreamwatchers.ConnectionPools
+	groupCache                              upstreamldap.GroupCache
 	client                                  supervisorclientset.Interface
 	activeDirectoryIdentityProviderInformer idpinformers.ActiveDirectoryIdentityProviderInformer
 	secretInformer                          corev1informers.SecretInformer
@@ -243,6 +248,7 @@ func New(
 	activeDirectoryIdentityProviderInformer idpinformers.ActiveDirectoryIdentityProviderInformer,
 	secretInformer corev1informers.SecretInformer,
 	configMapInformer corev1informers.ConfigMapInformer,
+	groupCache upstreamldap.GroupCache,
 	withInformer pinnipedcontroller.WithInformerOptionFunc,
 ) controllerlib.Controller {
 	return newInternal(
@@ -251,6 +257,7 @@ func New(
 		upstreamwatchers.NewValidatedSettingsCache(),
 		// nil means to use a real production dialer when creating objects to add to the cache
 		nil,
+		groupCache,
 		client,
 		activeDirectoryIdentityProviderInformer,
 		secretInformer,
@@ -264,6 +271,7 @@ func newInternal(
 	idpCache UpstreamActiveDirectoryIdentityProviderICache,
 	validatedSettingsCache upstreamwatchers.ValidatedSettingsCacheI,
 	ldapDialer upstreamldap.LDAPDialer,
+	groupCache upstreamldap.GroupCache,
 	client supervisorclientset.Interface,
 	activeDirectoryIdentityProviderInformer idpinformers.ActiveDirectoryIdentityProviderInformer,
 	secretInformer corev1informers.SecretInformer,
@@ -273,7 +281,9 @@ func newInternal(
 	c := activeDirectoryWatcherController{
 		cache:                                   idpCache,
 		validatedSettingsCache:                  validatedSettingsCache,
+		connectionPools:                         upstreamwatchers.NewConnectionPools(),
 		ldapDialer:                              ldapDialer,
+		groupCache:                              groupCache,
 		client:                                  client,
 		activeDirectoryIdentityProviderInformer: activeDirectoryIdentityProviderInformer,
 		secretInformer:                          secretInformer,
@@ -314,7 +324,9 @@ func (c *activeDirectoryWatcherController) Sync(ctx controllerlib.Context) error
 
 	requeue := false
 	validatedUpstreams := make([]upstreamprovider.UpstreamLDAPIdentityProviderI, 0, len(actualUpstreams))
+	uids := sets.New[types.UID]()
 	for _, upstream := range actualUpstreams {
+		uids.Insert(upstream.UID)
 		valid, requestedRequeue := c.validateUpstream(ctx.Context, upstream)
 		if valid != nil {
 			validatedUpstreams = append(validatedUpstreams, valid)
@@ -325,6 +337,7 @@ func (c *activeDirectoryWatcherController) Sync(ctx controllerlib.Context) error
 	}
 
 	c.cache.SetActiveDirectoryIdentityProviders(validatedUpstreams)
+	c.connectionPools.CloseAllExcept(uids)
 
 	if requeue {
 		return controllerlib.ErrSyntheticRequeue
@@ -338,14 +351,20 @@ func (c *activeDirectoryWatcherController) validateUpstream(ctx context.Context,
 	adUpstreamImpl := &activeDirectoryUpstreamGenericLDAPImpl{activeDirectoryIdentityProvider: *upstream}
 
 	config := &upstreamldap.ProviderConfig{
-		Name:        upstream.Name,
-		ResourceUID: upstream.UID,
-		Host:        spec.Host,
+		Name:            upstream.Name,
+		ResourceUID:     upstream.UID,
+		Host:            spec.Host,
+		AdditionalHosts: spec.AdditionalHosts,
+		SRVRecordName:   spec.SRVRecordName,
+		BindMethod:      upstreamldap.BindMethod(spec.Bind.Method),
+		GSSAPI:          gssapiConfig(spec.Bind.GSSAPI),
 		UserSearch: upstreamldap.UserSearchConfig{
 			Base:              spec.UserSearch.Base,
 			Filter:            adUpstreamImpl.Spec().UserSearch().Filter(),
 			UsernameAttribute: adUpstreamImpl.Spec().UserSearch().UsernameAttribute(),
 			UIDAttribute:      adUpstreamImpl.Spec().UserSearch().UIDAttribute(),
+
+			AdditionalClaimMappings: spec.UserSearch.Attributes.AdditionalClaimMappings,
 		},
 		GroupSearch: upstreamldap.GroupSearchConfig{
 			Base:                   spec.GroupSearch.Base,
@@ -353,8 +372,11 @@ func (c *activeDirectoryWatcherController) validateUpstream(ctx context.Context,
 			UserAttributeForFilter: adUpstreamImpl.Spec().GroupSearch().UserAttributeForFilter(),
 			GroupNameAttribute:     adUpstreamImpl.Spec().GroupSearch().GroupNameAttribute(),
 			SkipGroupRefresh:       spec.GroupSearch.SkipGroupRefresh,
+			CacheTTL:               time.Duration(spec.GroupSearch.CacheTTLSeconds) * time.Second,
 		},
-		Dialer: c.ldapDialer,
+		Dialer:         c.ldapDialer,
+		ConnectionPool: c.connectionPools.PoolFor(upstream.UID),
+		GroupCache:     c.groupCache,
 		UIDAttributeParsingOverrides: map[string]func(*ldap.Entry) (string, error){
 			"objectGUID": microsoftUUIDFromBinaryAttr("objectGUID"),
 		},
@@ -378,6 +400,15 @@ func (c *activeDirectoryWatcherController) validateUpstream(ctx context.Context,
 	return upstreamwatchers.EvaluateConditions(conditions, config)
 }
 
+// gssapiConfig returns the settings from the spec which are used for the GSSAPI bind method. The keytab and the
+// krb5.conf are loaded from the bind Secret.
+func gssapiConfig(gssapi *idpv1alpha1.ActiveDirectoryIdentityProviderGSSAPIBind) upstreamldap.GSSAPIConfig {
+	if gssapi == nil {
+		return upstreamldap.GSSAPIConfig{}
+	}
+	return upstreamldap.GSSAPIConfig{Realm: gssapi.Realm, ServicePrincipalName: gssapi.ServicePrincipalName}
+}
+
 func (c *activeDirectoryWatcherController) updateStatus(ctx context.Context, upstream *idpv1alpha1.ActiveDirectoryIdentityProvider, conditions []*metav1.Condition) {
 	log := plog.WithValues("namespace", upstream.Namespace, "name", upstream.Name)
 	updated := upstream.DeepCopy()
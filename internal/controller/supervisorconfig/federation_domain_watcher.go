@@ -19,6 +19,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
+	corev1informers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/utils/clock"
 
 	supervisorconfigv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
@@ -29,9 +30,11 @@ import (
 	pinnipedcontroller "go.pinniped.dev/internal/controller"
 	"go.pinniped.dev/internal/controller/conditionsutil"
 	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/federationdomain/branding"
 	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
 	"go.pinniped.dev/internal/idtransform"
 	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/totp"
 )
 
 const (
@@ -47,6 +50,8 @@ const (
 	typeIdentityProvidersObjectRefKindValid  = "IdentityProvidersObjectRefKindValid"
 	typeTransformsExpressionsValid           = "TransformsExpressionsValid"
 	typeTransformsExamplesPassed             = "TransformsExamplesPassed"
+	typeGroupEnrichmentValid                 = "IdentityProvidersGroupEnrichmentValid"
+	typeBrandingValid                        = "BrandingValid"
 
 	reasonDuplicateIssuer                             = "DuplicateIssuer"
 	reasonDifferentSecretRefsFound                    = "DifferentSecretRefsFound"
@@ -59,11 +64,17 @@ const (
 	reasonKindUnrecognized                            = "KindUnrecognized"
 	reasonInvalidTransformsExpressions                = "InvalidTransformsExpressions"
 	reasonTransformsExamplesFailed                    = "TransformsExamplesFailed"
+	reasonInvalidGroupEnrichment                      = "InvalidGroupEnrichment"
+	reasonBrandingNotFound                            = "BrandingNotFound"
+	reasonInvalidBranding                             = "InvalidBranding"
 
 	kindLDAPIdentityProvider            = "LDAPIdentityProvider"
 	kindOIDCIdentityProvider            = "OIDCIdentityProvider"
 	kindActiveDirectoryIdentityProvider = "ActiveDirectoryIdentityProvider"
 	kindGitHubIdentityProvider          = "GitHubIdentityProvider"
+	kindSAMLIdentityProvider            = "SAMLIdentityProvider"
+	kindGitLabIdentityProvider          = "GitLabIdentityProvider"
+	kindOAuth2IdentityProvider          = "OAuth2IdentityProvider"
 
 	celTransformerMaxExpressionRuntime = 5 * time.Second
 )
@@ -86,6 +97,11 @@ type federationDomainWatcherController struct {
 	ldapIdentityProviderInformer            idpinformers.LDAPIdentityProviderInformer
 	activeDirectoryIdentityProviderInformer idpinformers.ActiveDirectoryIdentityProviderInformer
 	githubIdentityProviderInformer          idpinformers.GitHubIdentityProviderInformer
+	samlIdentityProviderInformer            idpinformers.SAMLIdentityProviderInformer
+	gitlabIdentityProviderInformer          idpinformers.GitLabIdentityProviderInformer
+	oauth2IdentityProviderInformer          idpinformers.OAuth2IdentityProviderInformer
+	configMapInformer                       corev1informers.ConfigMapInformer
+	secretInformer                          corev1informers.SecretInformer
 
 	celTransformer *celtransformer.CELTransformer
 	allowedKinds   sets.Set[string]
@@ -103,9 +119,14 @@ func NewFederationDomainWatcherController(
 	ldapIdentityProviderInformer idpinformers.LDAPIdentityProviderInformer,
 	activeDirectoryIdentityProviderInformer idpinformers.ActiveDirectoryIdentityProviderInformer,
 	githubProviderInformer idpinformers.GitHubIdentityProviderInformer,
+	samlIdentityProviderInformer idpinformers.SAMLIdentityProviderInformer,
+	gitlabIdentityProviderInformer idpinformers.GitLabIdentityProviderInformer,
+	oauth2IdentityProviderInformer idpinformers.OAuth2IdentityProviderInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	secretInformer corev1informers.SecretInformer,
 	withInformer pinnipedcontroller.WithInformerOptionFunc,
 ) controllerlib.Controller {
-	allowedKinds := sets.New(kindActiveDirectoryIdentityProvider, kindLDAPIdentityProvider, kindOIDCIdentityProvider, kindGitHubIdentityProvider)
+	allowedKinds := sets.New(kindActiveDirectoryIdentityProvider, kindLDAPIdentityProvider, kindOIDCIdentityProvider, kindGitHubIdentityProvider, kindSAMLIdentityProvider, kindGitLabIdentityProvider, kindOAuth2IdentityProvider)
 	return controllerlib.New(
 		controllerlib.Config{
 			Name: controllerName,
@@ -119,6 +140,11 @@ func NewFederationDomainWatcherController(
 				ldapIdentityProviderInformer:            ldapIdentityProviderInformer,
 				activeDirectoryIdentityProviderInformer: activeDirectoryIdentityProviderInformer,
 				githubIdentityProviderInformer:          githubProviderInformer,
+				samlIdentityProviderInformer:            samlIdentityProviderInformer,
+				gitlabIdentityProviderInformer:          gitlabIdentityProviderInformer,
+				oauth2IdentityProviderInformer:          oauth2IdentityProviderInformer,
+				configMapInformer:                       configMapInformer,
+				secretInformer:                          secretInformer,
 				allowedKinds:                            allowedKinds,
 			},
 		},
@@ -155,9 +181,73 @@ func NewFederationDomainWatcherController(
 			pinnipedcontroller.MatchAnythingIgnoringUpdatesFilter(pinnipedcontroller.SingletonQueue()),
 			controllerlib.InformerOption{},
 		),
+		withInformer(
+			samlIdentityProviderInformer,
+			// Since this controller only cares about IDP metadata names and UIDs (immutable fields),
+			// we only need to trigger Sync on creates and deletes.
+			pinnipedcontroller.MatchAnythingIgnoringUpdatesFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			gitlabIdentityProviderInformer,
+			// Since this controller only cares about IDP metadata names and UIDs (immutable fields),
+			// we only need to trigger Sync on creates and deletes.
+			pinnipedcontroller.MatchAnythingIgnoringUpdatesFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			oauth2IdentityProviderInformer,
+			// Since this controller only cares about IDP metadata names and UIDs (immutable fields),
+			// we only need to trigger Sync on creates and deletes.
+			pinnipedcontroller.MatchAnythingIgnoringUpdatesFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			configMapInformer,
+			// Only watch the ConfigMaps which hold the branding of a FederationDomain.
+			pinnipedcontroller.SimpleFilterWithSingletonQueue(isBrandingOfAnyFederationDomain(
+				federationDomainInformer, supervisorconfigv1alpha1.FederationDomainBrandingKindConfigMap)),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			secretInformer,
+			// Only watch the Secrets which hold the branding of a FederationDomain.
+			pinnipedcontroller.SimpleFilterWithSingletonQueue(isBrandingOfAnyFederationDomain(
+				federationDomainInformer, supervisorconfigv1alpha1.FederationDomainBrandingKindSecret)),
+			controllerlib.InformerOption{},
+		),
 	)
 }
 
+// isBrandingOfAnyFederationDomain returns a filter which matches the objects of the given kind that are referenced
+// by the branding of any FederationDomain.
+func isBrandingOfAnyFederationDomain(
+	federationDomainInformer configinformers.FederationDomainInformer,
+	kind supervisorconfigv1alpha1.FederationDomainBrandingKind,
+) func(obj metav1.Object) bool {
+	return func(obj metav1.Object) bool {
+		federationDomains, err := federationDomainInformer.Lister().FederationDomains(obj.GetNamespace()).List(labels.Everything())
+		if err != nil {
+			return false
+		}
+		for _, federationDomain := range federationDomains {
+			b := federationDomain.Spec.Branding
+			if b != nil && brandingKind(b) == kind && b.Name == obj.GetName() {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// brandingKind returns the kind of the branding, which defaults to ConfigMap.
+func brandingKind(b *supervisorconfigv1alpha1.FederationDomainBranding) supervisorconfigv1alpha1.FederationDomainBrandingKind {
+	if b.Kind == "" {
+		return supervisorconfigv1alpha1.FederationDomainBrandingKindConfigMap
+	}
+	return b.Kind
+}
+
 // Sync implements controllerlib.Syncer.
 func (c *federationDomainWatcherController) Sync(ctx controllerlib.Context) error {
 	federationDomains, err := c.federationDomainInformer.Lister().List(labels.Everything())
@@ -249,9 +339,100 @@ func (c *federationDomainWatcherController) makeFederationDomainIssuer(
 		}
 	}
 
+	fdBranding, conditions, err := c.makeBranding(federationDomain, conditions)
+	if err != nil {
+		return nil, nil, err
+	}
+	if federationDomainIssuer != nil {
+		federationDomainIssuer.SetBranding(fdBranding)
+		if chooser := federationDomain.Spec.IdentityProviderChooser; chooser != nil {
+			federationDomainIssuer.SetIdentityProviderChooser(federationdomainproviders.IdentityProviderChooser{
+				RememberLastChosen: chooser.RememberLastChosen,
+				AutoRedirect:       chooser.AutoRedirect,
+			})
+		}
+		if registration := federationDomain.Spec.ClientRegistration; registration != nil {
+			federationDomainIssuer.SetClientRegistration(federationdomainproviders.ClientRegistration{
+				Enabled:                       registration.Enabled,
+				InitialAccessTokensSecretName: registration.InitialAccessTokensSecretName,
+			})
+		}
+	}
+
 	return federationDomainIssuer, conditions, nil
 }
 
+// makeBranding reads and validates the ConfigMap or Secret which is referenced by the optional branding of the
+// FederationDomain.
+func (c *federationDomainWatcherController) makeBranding(
+	federationDomain *supervisorconfigv1alpha1.FederationDomain,
+	conditions []*metav1.Condition,
+) (*branding.Branding, []*metav1.Condition, error) {
+	ref := federationDomain.Spec.Branding
+	if ref == nil {
+		return nil, append(conditions, &metav1.Condition{
+			Type:    typeBrandingValid,
+			Status:  metav1.ConditionTrue,
+			Reason:  conditionsutil.ReasonSuccess,
+			Message: "no branding is specified by .spec.branding",
+		}), nil
+	}
+
+	kind := brandingKind(ref)
+	var data map[string][]byte
+	var err error
+	switch kind {
+	case supervisorconfigv1alpha1.FederationDomainBrandingKindSecret:
+		var secret *corev1.Secret
+		secret, err = c.secretInformer.Lister().Secrets(federationDomain.Namespace).Get(ref.Name)
+		if err == nil {
+			data = secret.Data
+		}
+	default:
+		var configMap *corev1.ConfigMap
+		configMap, err = c.configMapInformer.Lister().ConfigMaps(federationDomain.Namespace).Get(ref.Name)
+		if err == nil {
+			// The keys of data and binaryData cannot overlap, so they can be combined.
+			data = make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+			for key, value := range configMap.Data {
+				data[key] = []byte(value)
+			}
+			for key, value := range configMap.BinaryData {
+				data[key] = value
+			}
+		}
+	}
+
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil, append(conditions, &metav1.Condition{
+			Type:    typeBrandingValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonBrandingNotFound,
+			Message: fmt.Sprintf("the %s %q specified by .spec.branding was not found", kind, ref.Name),
+		}), nil
+	case err != nil:
+		return nil, nil, err // unexpected error from the informer
+	}
+
+	fdBranding, err := branding.Parse(data)
+	if err != nil {
+		return nil, append(conditions, &metav1.Condition{
+			Type:    typeBrandingValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonInvalidBranding,
+			Message: fmt.Sprintf("the %s %q specified by .spec.branding is invalid: %s", kind, ref.Name, err.Error()),
+		}), nil
+	}
+
+	return fdBranding, append(conditions, &metav1.Condition{
+		Type:    typeBrandingValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  conditionsutil.ReasonSuccess,
+		Message: fmt.Sprintf("the %s %q specified by .spec.branding is valid", kind, ref.Name),
+	}), nil
+}
+
 func (c *federationDomainWatcherController) makeLegacyFederationDomainIssuer(
 	federationDomain *supervisorconfigv1alpha1.FederationDomain,
 	conditions []*metav1.Condition,
@@ -275,8 +456,20 @@ func (c *federationDomainWatcherController) makeLegacyFederationDomainIssuer(
 	if err != nil {
 		return nil, nil, err
 	}
+	samlIdentityProviders, err := c.samlIdentityProviderInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+	gitlabIdentityProviders, err := c.gitlabIdentityProviderInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
+	oauth2IdentityProviders, err := c.oauth2IdentityProviderInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return nil, nil, err
+	}
 	// Check if that there is exactly one IDP defined in the Supervisor namespace of any IDP CRD type.
-	idpCRsCount := len(oidcIdentityProviders) + len(ldapIdentityProviders) + len(activeDirectoryIdentityProviders) + len(githubIdentityProviders)
+	idpCRsCount := len(oidcIdentityProviders) + len(ldapIdentityProviders) + len(activeDirectoryIdentityProviders) + len(githubIdentityProviders) + len(samlIdentityProviders) + len(gitlabIdentityProviders) + len(oauth2IdentityProviders)
 
 	switch {
 	case idpCRsCount == 1:
@@ -300,6 +493,18 @@ func (c *federationDomainWatcherController) makeLegacyFederationDomainIssuer(
 			defaultFederationDomainIdentityProvider.DisplayName = githubIdentityProviders[0].Name
 			defaultFederationDomainIdentityProvider.UID = githubIdentityProviders[0].UID
 			foundIDPName = githubIdentityProviders[0].Name
+		case len(samlIdentityProviders) == 1:
+			defaultFederationDomainIdentityProvider.DisplayName = samlIdentityProviders[0].Name
+			defaultFederationDomainIdentityProvider.UID = samlIdentityProviders[0].UID
+			foundIDPName = samlIdentityProviders[0].Name
+		case len(gitlabIdentityProviders) == 1:
+			defaultFederationDomainIdentityProvider.DisplayName = gitlabIdentityProviders[0].Name
+			defaultFederationDomainIdentityProvider.UID = gitlabIdentityProviders[0].UID
+			foundIDPName = gitlabIdentityProviders[0].Name
+		case len(oauth2IdentityProviders) == 1:
+			defaultFederationDomainIdentityProvider.DisplayName = oauth2IdentityProviders[0].Name
+			defaultFederationDomainIdentityProvider.UID = oauth2IdentityProviders[0].UID
+			foundIDPName = oauth2IdentityProviders[0].Name
 		}
 		// Backwards compatibility mode always uses an empty identity transformation pipeline since no
 		// transformations are defined on the FederationDomain.
@@ -344,6 +549,7 @@ func (c *federationDomainWatcherController) makeLegacyFederationDomainIssuer(
 	conditions = appendIdentityProviderObjectRefKindCondition(c.sortedAllowedKinds(), []string{}, conditions)
 	conditions = appendTransformsExpressionsValidCondition([]string{}, conditions)
 	conditions = appendTransformsExamplesPassedCondition([]string{}, conditions)
+	conditions = appendGroupEnrichmentValidCondition([]string{}, conditions)
 
 	return federationDomainIssuer, conditions, nil
 }
@@ -361,6 +567,7 @@ func (c *federationDomainWatcherController) makeFederationDomainIssuerWithExplic
 	badAPIGroupNames := []string{}
 	badKinds := []string{}
 	validationErrorMessages := &transformsValidationErrorMessages{}
+	groupEnrichmentErrorMessages := []string{}
 
 	for index, idp := range federationDomain.Spec.IdentityProviders {
 		idpIsValid := true
@@ -420,16 +627,28 @@ func (c *federationDomainWatcherController) makeFederationDomainIssuerWithExplic
 			idpIsValid = false
 		}
 
+		groupEnrichment, groupEnrichmentErrors, err := c.makeGroupEnrichmentForIdentityProvider(idp, index, federationDomain.Namespace)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(groupEnrichmentErrors) > 0 {
+			groupEnrichmentErrorMessages = append(groupEnrichmentErrorMessages, groupEnrichmentErrors)
+			idpIsValid = false
+		}
+
 		if !idpIsValid {
 			// Something about the IDP was not valid. Don't add it.
 			continue
 		}
 
-		// For a valid IDP (unique displayName, valid objectRef, valid transforms), add it to the list.
+		// For a valid IDP (unique displayName, valid objectRef, valid transforms, valid group enrichment),
+		// add it to the list.
 		federationDomainIdentityProviders = append(federationDomainIdentityProviders, &federationdomainproviders.FederationDomainIdentityProvider{
-			DisplayName: idp.DisplayName,
-			UID:         idpResourceUID,
-			Transforms:  pipeline,
+			DisplayName:     idp.DisplayName,
+			UID:             idpResourceUID,
+			Transforms:      pipeline,
+			GroupEnrichment: groupEnrichment,
+			TOTPPolicy:      totpPolicyForIdentityProvider(idp),
 		})
 	}
 
@@ -444,6 +663,7 @@ func (c *federationDomainWatcherController) makeFederationDomainIssuerWithExplic
 
 	conditions = appendTransformsExpressionsValidCondition(validationErrorMessages.errorsForExpressions, conditions)
 	conditions = appendTransformsExamplesPassedCondition(validationErrorMessages.errorsForExamples, conditions)
+	conditions = appendGroupEnrichmentValidCondition(groupEnrichmentErrorMessages, conditions)
 
 	return federationDomainIssuer, conditions, nil
 }
@@ -462,6 +682,12 @@ func (c *federationDomainWatcherController) findIDPsUIDByObjectRef(objectRef cor
 		foundIDP, err = c.oidcIdentityProviderInformer.Lister().OIDCIdentityProviders(namespace).Get(objectRef.Name)
 	case kindGitHubIdentityProvider:
 		foundIDP, err = c.githubIdentityProviderInformer.Lister().GitHubIdentityProviders(namespace).Get(objectRef.Name)
+	case kindSAMLIdentityProvider:
+		foundIDP, err = c.samlIdentityProviderInformer.Lister().SAMLIdentityProviders(namespace).Get(objectRef.Name)
+	case kindGitLabIdentityProvider:
+		foundIDP, err = c.gitlabIdentityProviderInformer.Lister().GitLabIdentityProviders(namespace).Get(objectRef.Name)
+	case kindOAuth2IdentityProvider:
+		foundIDP, err = c.oauth2IdentityProviderInformer.Lister().OAuth2IdentityProviders(namespace).Get(objectRef.Name)
 	default:
 		// This shouldn't happen because this helper function is not called when the kind is invalid.
 		return "", false, fmt.Errorf("unexpected kind: %s", objectRef.Kind)
@@ -578,6 +804,85 @@ func (c *federationDomainWatcherController) makeTransformationPipelineForIdentit
 	return pipeline, "", nil
 }
 
+// makeGroupEnrichmentForIdentityProvider validates the optional groupEnrichment of an identity provider.
+// It returns nil when there is no groupEnrichment configured, or a validation message when it is invalid.
+func (c *federationDomainWatcherController) makeGroupEnrichmentForIdentityProvider(
+	idp supervisorconfigv1alpha1.FederationDomainIdentityProvider,
+	idpIndex int,
+	namespace string,
+) (*federationdomainproviders.GroupEnrichment, string, error) {
+	if idp.GroupEnrichment == nil {
+		return nil, "", nil
+	}
+
+	objectRef := idp.GroupEnrichment.ObjectRef
+	errs := []string{}
+
+	// Only LDAP and ActiveDirectory identity providers can look up the groups of a user without that user's credentials.
+	canTryToFindSource := true
+	apiGroup := ""
+	if objectRef.APIGroup != nil {
+		apiGroup = *objectRef.APIGroup
+	}
+	if apiGroup != c.apiGroup {
+		errs = append(errs, fmt.Sprintf(".spec.identityProviders[%d].groupEnrichment.objectRef.apiGroup %q is not recognized (should be %q)",
+			idpIndex, apiGroup, c.apiGroup))
+		canTryToFindSource = false
+	}
+	if objectRef.Kind != kindLDAPIdentityProvider && objectRef.Kind != kindActiveDirectoryIdentityProvider {
+		errs = append(errs, fmt.Sprintf(".spec.identityProviders[%d].groupEnrichment.objectRef.kind %q is not supported (should be one of %s)",
+			idpIndex, objectRef.Kind, strings.Join(sortAndQuote([]string{kindLDAPIdentityProvider, kindActiveDirectoryIdentityProvider}), ", ")))
+		canTryToFindSource = false
+	}
+
+	var sourceUID types.UID
+	if canTryToFindSource {
+		var sourceWasFound bool
+		var err error
+		sourceUID, sourceWasFound, err = c.findIDPsUIDByObjectRef(objectRef, namespace)
+		if err != nil {
+			return nil, "", err
+		}
+		if !sourceWasFound {
+			errs = append(errs, fmt.Sprintf("cannot find resource specified by .spec.identityProviders[%d].groupEnrichment.objectRef (with name %q)",
+				idpIndex, objectRef.Name))
+		}
+	}
+
+	// The key expression may use the same constants as the transformation expressions of this identity provider.
+	consts, err := c.makeTransformsConstantsForIdentityProvider(idp)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := c.celTransformer.CompileGroupEnrichmentKey(&celtransformer.GroupEnrichmentKey{Expression: idp.GroupEnrichment.KeyExpression}, consts)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf(".spec.identityProviders[%d].groupEnrichment.keyExpression was invalid:\n%s",
+			idpIndex, err.Error()))
+	}
+
+	if len(errs) > 0 {
+		return nil, strings.Join(errs, "\n\n"), nil
+	}
+
+	return &federationdomainproviders.GroupEnrichment{
+		SourceUID:  sourceUID,
+		SourceName: objectRef.Name,
+		Key:        key,
+	}, "", nil
+}
+
+// totpPolicyForIdentityProvider returns the policy from the optional totp of an identity provider. The CRD only
+// allows totp for LDAP and ActiveDirectory identity providers, and the policy defaults to Required.
+func totpPolicyForIdentityProvider(idp supervisorconfigv1alpha1.FederationDomainIdentityProvider) totp.Policy {
+	if idp.TOTP == nil {
+		return totp.PolicyNone
+	}
+	if idp.TOTP.Policy == supervisorconfigv1alpha1.FederationDomainTOTPPolicyWhenEnrolled {
+		return totp.PolicyWhenEnrolled
+	}
+	return totp.PolicyRequired
+}
+
 func (c *federationDomainWatcherController) evaluateExamplesForIdentityProvider(
 	ctx context.Context,
 	idp supervisorconfigv1alpha1.FederationDomainIdentityProvider,
@@ -596,7 +901,7 @@ func (c *federationDomainWatcherController) evaluateExamplesForIdentityProvider(
 
 	// Run all the provided transform examples. If any fail, put errors on the FederationDomain status.
 	for exIndex, e := range idp.Transforms.Examples {
-		result, err := pipeline.Evaluate(ctx, e.Username, e.Groups)
+		result, err := pipeline.Evaluate(ctx, e.Username, e.Groups, nil)
 		if err != nil {
 			examplesErrors = append(examplesErrors, fmt.Sprintf(errorFmt, idpIndex, exIndex,
 				"no transformation errors",
@@ -762,6 +1067,25 @@ func appendTransformsExamplesPassedCondition(messages []string, conditions []*me
 	return conditions
 }
 
+func appendGroupEnrichmentValidCondition(messages []string, conditions []*metav1.Condition) []*metav1.Condition {
+	if len(messages) > 0 {
+		conditions = append(conditions, &metav1.Condition{
+			Type:    typeGroupEnrichmentValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonInvalidGroupEnrichment,
+			Message: strings.Join(messages, "\n\n"),
+		})
+	} else {
+		conditions = append(conditions, &metav1.Condition{
+			Type:    typeGroupEnrichmentValid,
+			Status:  metav1.ConditionTrue,
+			Reason:  conditionsutil.ReasonSuccess,
+			Message: "the group enrichment configurations specified by .spec.identityProviders[].groupEnrichment are valid",
+		})
+	}
+	return conditions
+}
+
 func appendIdentityProviderDuplicateDisplayNamesCondition(duplicateDisplayNames sets.Set[string], conditions []*metav1.Condition) []*metav1.Condition {
 	if duplicateDisplayNames.Len() > 0 {
 		conditions = append(conditions, &metav1.Condition{
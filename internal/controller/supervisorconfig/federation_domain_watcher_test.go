@@ -7,6 +7,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"sort"
 	"testing"
@@ -18,6 +20,8 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	kubeinformers "k8s.io/client-go/informers"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
 	coretesting "k8s.io/client-go/testing"
 	clocktesting "k8s.io/utils/clock/testing"
 	"k8s.io/utils/ptr"
@@ -28,11 +32,13 @@ import (
 	supervisorinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions"
 	"go.pinniped.dev/internal/celtransformer"
 	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/federationdomain/branding"
 	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
 	"go.pinniped.dev/internal/here"
 	"go.pinniped.dev/internal/idtransform"
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/internal/testutil/conditionstestutil"
+	"go.pinniped.dev/internal/totp"
 )
 
 func TestFederationDomainWatcherControllerInformerFilters(t *testing.T) {
@@ -43,6 +49,35 @@ func TestFederationDomainWatcherControllerInformerFilters(t *testing.T) {
 	ldapIdentityProviderInformer := supervisorinformers.NewSharedInformerFactoryWithOptions(nil, 0).IDP().V1alpha1().LDAPIdentityProviders()
 	adIdentityProviderInformer := supervisorinformers.NewSharedInformerFactoryWithOptions(nil, 0).IDP().V1alpha1().ActiveDirectoryIdentityProviders()
 	githubIdentityProviderInformer := supervisorinformers.NewSharedInformerFactoryWithOptions(nil, 0).IDP().V1alpha1().GitHubIdentityProviders()
+	samlIdentityProviderInformer := supervisorinformers.NewSharedInformerFactoryWithOptions(nil, 0).IDP().V1alpha1().SAMLIdentityProviders()
+	gitlabIdentityProviderInformer := supervisorinformers.NewSharedInformerFactoryWithOptions(nil, 0).IDP().V1alpha1().GitLabIdentityProviders()
+	oauth2IdentityProviderInformer := supervisorinformers.NewSharedInformerFactoryWithOptions(nil, 0).IDP().V1alpha1().OAuth2IdentityProviders()
+	configMapInformer := kubeinformers.NewSharedInformerFactoryWithOptions(nil, 0).Core().V1().ConfigMaps()
+	secretInformer := kubeinformers.NewSharedInformerFactoryWithOptions(nil, 0).Core().V1().Secrets()
+
+	// The filters of ConfigMaps and Secrets look for the FederationDomains which reference them.
+	for _, fd := range []*supervisorconfigv1alpha1.FederationDomain{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "fd-with-configmap-branding", Namespace: "some-namespace"},
+			Spec: supervisorconfigv1alpha1.FederationDomainSpec{
+				Branding: &supervisorconfigv1alpha1.FederationDomainBranding{Name: "some-configmap-branding"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "fd-with-secret-branding", Namespace: "some-namespace"},
+			Spec: supervisorconfigv1alpha1.FederationDomainSpec{
+				Branding: &supervisorconfigv1alpha1.FederationDomainBranding{
+					Kind: supervisorconfigv1alpha1.FederationDomainBrandingKindSecret,
+					Name: "some-secret-branding",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "fd-without-branding", Namespace: "some-namespace"},
+		},
+	} {
+		require.NoError(t, federationDomainInformer.Informer().GetIndexer().Add(fd))
+	}
 
 	tests := []struct {
 		name       string
@@ -91,6 +126,71 @@ func TestFederationDomainWatcherControllerInformerFilters(t *testing.T) {
 			wantUpdate: false,
 			wantDelete: true,
 		},
+		{
+			name:       "any SAMLIdentityProvider adds or deletes, but updates are ignored",
+			obj:        &idpv1alpha1.SAMLIdentityProvider{},
+			informer:   samlIdentityProviderInformer,
+			wantAdd:    true,
+			wantUpdate: false,
+			wantDelete: true,
+		},
+		{
+			name:       "any GitLabIdentityProvider adds or deletes, but updates are ignored",
+			obj:        &idpv1alpha1.GitLabIdentityProvider{},
+			informer:   gitlabIdentityProviderInformer,
+			wantAdd:    true,
+			wantUpdate: false,
+			wantDelete: true,
+		},
+		{
+			name:       "any OAuth2IdentityProvider adds or deletes, but updates are ignored",
+			obj:        &idpv1alpha1.OAuth2IdentityProvider{},
+			informer:   oauth2IdentityProviderInformer,
+			wantAdd:    true,
+			wantUpdate: false,
+			wantDelete: true,
+		},
+		{
+			name:       "a ConfigMap which is the branding of a FederationDomain",
+			obj:        &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "some-configmap-branding", Namespace: "some-namespace"}},
+			informer:   configMapInformer,
+			wantAdd:    true,
+			wantUpdate: true,
+			wantDelete: true,
+		},
+		{
+			name:     "a ConfigMap which has the same name as the branding of a FederationDomain in another namespace",
+			obj:      &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "some-configmap-branding", Namespace: "other-namespace"}},
+			informer: configMapInformer,
+		},
+		{
+			name:     "a ConfigMap which has the same name as the Secret branding of a FederationDomain",
+			obj:      &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "some-secret-branding", Namespace: "some-namespace"}},
+			informer: configMapInformer,
+		},
+		{
+			name:     "an unrelated ConfigMap",
+			obj:      &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "some-configmap", Namespace: "some-namespace"}},
+			informer: configMapInformer,
+		},
+		{
+			name:       "a Secret which is the branding of a FederationDomain",
+			obj:        &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "some-secret-branding", Namespace: "some-namespace"}},
+			informer:   secretInformer,
+			wantAdd:    true,
+			wantUpdate: true,
+			wantDelete: true,
+		},
+		{
+			name:     "a Secret which has the same name as the ConfigMap branding of a FederationDomain",
+			obj:      &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "some-configmap-branding", Namespace: "some-namespace"}},
+			informer: secretInformer,
+		},
+		{
+			name:     "an unrelated Secret",
+			obj:      &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "some-secret", Namespace: "some-namespace"}},
+			informer: secretInformer,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -108,6 +208,11 @@ func TestFederationDomainWatcherControllerInformerFilters(t *testing.T) {
 				ldapIdentityProviderInformer,
 				adIdentityProviderInformer,
 				githubIdentityProviderInformer,
+				samlIdentityProviderInformer,
+				gitlabIdentityProviderInformer,
+				oauth2IdentityProviderInformer,
+				configMapInformer,
+				secretInformer,
 				withInformer.WithInformer, // make it possible to observe the behavior of the Filters
 			)
 
@@ -121,6 +226,9 @@ func TestFederationDomainWatcherControllerInformerFilters(t *testing.T) {
 	}
 }
 
+// testBrandingLogo is the start of a PNG image, which is enough for its content type to be detected.
+var testBrandingLogo = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR") //nolint:gochecknoglobals // This is a test fixture.
+
 type fakeFederationDomainsSetter struct {
 	SetFederationDomainsWasCalled bool
 	FederationDomainsReceived     []*federationdomainproviders.FederationDomainIssuer
@@ -179,6 +287,30 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 		},
 	}
 
+	samlIdentityProvider := &idpv1alpha1.SAMLIdentityProvider{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-saml-idp",
+			Namespace: namespace,
+			UID:       "some-saml-idp",
+		},
+	}
+
+	gitLabIdentityProvider := &idpv1alpha1.GitLabIdentityProvider{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-gitlab-idp",
+			Namespace: namespace,
+			UID:       "some-gitlab-idp",
+		},
+	}
+
+	oauth2IdentityProvider := &idpv1alpha1.OAuth2IdentityProvider{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-oauth2-idp",
+			Namespace: namespace,
+			UID:       "some-oauth2-idp",
+		},
+	}
+
 	federationDomain1 := &supervisorconfigv1alpha1.FederationDomain{
 		ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
 		Spec:       supervisorconfigv1alpha1.FederationDomainSpec{Issuer: "https://issuer1.com"},
@@ -211,6 +343,41 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 		return fdIssuer
 	}
 
+	federationDomainWithBranding := func(fdBranding supervisorconfigv1alpha1.FederationDomainBranding) *supervisorconfigv1alpha1.FederationDomain {
+		return &supervisorconfigv1alpha1.FederationDomain{
+			ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+			Spec: supervisorconfigv1alpha1.FederationDomainSpec{
+				Issuer: "https://issuer1.com",
+				IdentityProviders: []supervisorconfigv1alpha1.FederationDomainIdentityProvider{
+					{
+						DisplayName: "can-find-me",
+						ObjectRef: corev1.TypedLocalObjectReference{
+							APIGroup: ptr.To(apiGroupSupervisor),
+							Kind:     "OIDCIdentityProvider",
+							Name:     oidcIdentityProvider.Name,
+						},
+					},
+				},
+				Branding: &fdBranding,
+			},
+		}
+	}
+
+	federationDomainIssuerWithBranding := func(t *testing.T, brandingData map[string][]byte) *federationdomainproviders.FederationDomainIssuer {
+		fdIssuer := federationDomainIssuerWithIDPs(t, "https://issuer1.com",
+			[]*federationdomainproviders.FederationDomainIdentityProvider{
+				{
+					DisplayName: "can-find-me",
+					UID:         oidcIdentityProvider.UID,
+					Transforms:  idtransform.NewTransformationPipeline(),
+				},
+			})
+		fdBranding, err := branding.Parse(brandingData)
+		require.NoError(t, err)
+		fdIssuer.SetBranding(fdBranding)
+		return fdIssuer
+	}
+
 	happyReadyCondition := func(issuer string, time metav1.Time, observedGeneration int64) metav1.Condition {
 		return metav1.Condition{
 			Type:               "Ready",
@@ -461,6 +628,50 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 		}
 	}
 
+	happyGroupEnrichmentCondition := func(time metav1.Time, observedGeneration int64) metav1.Condition {
+		return metav1.Condition{
+			Type:               "IdentityProvidersGroupEnrichmentValid",
+			Status:             "True",
+			ObservedGeneration: observedGeneration,
+			LastTransitionTime: time,
+			Reason:             "Success",
+			Message:            "the group enrichment configurations specified by .spec.identityProviders[].groupEnrichment are valid",
+		}
+	}
+
+	sadGroupEnrichmentCondition := func(errorMessages string, time metav1.Time, observedGeneration int64) metav1.Condition {
+		return metav1.Condition{
+			Type:               "IdentityProvidersGroupEnrichmentValid",
+			Status:             "False",
+			ObservedGeneration: observedGeneration,
+			LastTransitionTime: time,
+			Reason:             "InvalidGroupEnrichment",
+			Message:            errorMessages,
+		}
+	}
+
+	happyBrandingCondition := func(message string, time metav1.Time, observedGeneration int64) metav1.Condition {
+		return metav1.Condition{
+			Type:               "BrandingValid",
+			Status:             "True",
+			ObservedGeneration: observedGeneration,
+			LastTransitionTime: time,
+			Reason:             "Success",
+			Message:            message,
+		}
+	}
+
+	sadBrandingCondition := func(reason, message string, time metav1.Time, observedGeneration int64) metav1.Condition {
+		return metav1.Condition{
+			Type:               "BrandingValid",
+			Status:             "False",
+			ObservedGeneration: observedGeneration,
+			LastTransitionTime: time,
+			Reason:             reason,
+			Message:            message,
+		}
+	}
+
 	happyAPIGroupSuffixCondition := func(time metav1.Time, observedGeneration int64) metav1.Condition {
 		return metav1.Condition{
 			Type:               "IdentityProvidersObjectRefAPIGroupSuffixValid",
@@ -503,7 +714,7 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 			LastTransitionTime: time,
 			Reason:             "KindUnrecognized",
 			Message: fmt.Sprintf(`some kinds specified by .spec.identityProviders[].objectRef.kind are `+
-				`not recognized (should be one of "ActiveDirectoryIdentityProvider", "GitHubIdentityProvider", "LDAPIdentityProvider", "OIDCIdentityProvider"): %s`, badKinds),
+				`not recognized (should be one of "ActiveDirectoryIdentityProvider", "GitHubIdentityProvider", "GitLabIdentityProvider", "LDAPIdentityProvider", "OAuth2IdentityProvider", "OIDCIdentityProvider", "SAMLIdentityProvider"): %s`, badKinds),
 		}
 	}
 
@@ -511,6 +722,8 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 		return conditionstestutil.SortByType([]metav1.Condition{
 			happyTransformationExamplesCondition(frozenMetav1Now, 123),
 			happyTransformationExpressionsCondition(frozenMetav1Now, 123),
+			happyGroupEnrichmentCondition(frozenMetav1Now, 123),
+			happyBrandingCondition("no branding is specified by .spec.branding", frozenMetav1Now, 123),
 			happyKindCondition(frozenMetav1Now, 123),
 			happyAPIGroupSuffixCondition(frozenMetav1Now, 123),
 			happyDisplayNamesUniqueCondition(frozenMetav1Now, 123),
@@ -538,6 +751,7 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 	tests := []struct {
 		name              string
 		inputObjects      []runtime.Object
+		kubeInputObjects  []runtime.Object
 		configClient      func(*supervisorfake.Clientset)
 		wantErr           string
 		wantStatusUpdates []*supervisorconfigv1alpha1.FederationDomain
@@ -644,6 +858,78 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 				),
 			},
 		},
+		{
+			name: "legacy config: when no identity provider is specified on federation domains, but exactly one SAML identity " +
+				"provider resource exists on cluster, the controller will set a default IDP on each federation domain " +
+				"matching the only identity provider found",
+			inputObjects: []runtime.Object{
+				federationDomain1,
+				federationDomain2,
+				samlIdentityProvider,
+			},
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{
+				federationDomainIssuerWithDefaultIDP(t, federationDomain1.Spec.Issuer, samlIdentityProvider.ObjectMeta),
+				federationDomainIssuerWithDefaultIDP(t, federationDomain2.Spec.Issuer, samlIdentityProvider.ObjectMeta),
+			},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(federationDomain1,
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					allHappyConditionsLegacyConfigurationSuccess(federationDomain1.Spec.Issuer, samlIdentityProvider.Name, frozenMetav1Now, 123),
+				),
+				expectedFederationDomainStatusUpdate(federationDomain2,
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					allHappyConditionsLegacyConfigurationSuccess(federationDomain2.Spec.Issuer, samlIdentityProvider.Name, frozenMetav1Now, 123),
+				),
+			},
+		},
+		{
+			name: "legacy config: when no identity provider is specified on federation domains, but exactly one GitLab identity " +
+				"provider resource exists on cluster, the controller will set a default IDP on each federation domain " +
+				"matching the only identity provider found",
+			inputObjects: []runtime.Object{
+				federationDomain1,
+				federationDomain2,
+				gitLabIdentityProvider,
+			},
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{
+				federationDomainIssuerWithDefaultIDP(t, federationDomain1.Spec.Issuer, gitLabIdentityProvider.ObjectMeta),
+				federationDomainIssuerWithDefaultIDP(t, federationDomain2.Spec.Issuer, gitLabIdentityProvider.ObjectMeta),
+			},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(federationDomain1,
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					allHappyConditionsLegacyConfigurationSuccess(federationDomain1.Spec.Issuer, gitLabIdentityProvider.Name, frozenMetav1Now, 123),
+				),
+				expectedFederationDomainStatusUpdate(federationDomain2,
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					allHappyConditionsLegacyConfigurationSuccess(federationDomain2.Spec.Issuer, gitLabIdentityProvider.Name, frozenMetav1Now, 123),
+				),
+			},
+		},
+		{
+			name: "legacy config: when no identity provider is specified on federation domains, but exactly one OAuth2 identity " +
+				"provider resource exists on cluster, the controller will set a default IDP on each federation domain " +
+				"matching the only identity provider found",
+			inputObjects: []runtime.Object{
+				federationDomain1,
+				federationDomain2,
+				oauth2IdentityProvider,
+			},
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{
+				federationDomainIssuerWithDefaultIDP(t, federationDomain1.Spec.Issuer, oauth2IdentityProvider.ObjectMeta),
+				federationDomainIssuerWithDefaultIDP(t, federationDomain2.Spec.Issuer, oauth2IdentityProvider.ObjectMeta),
+			},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(federationDomain1,
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					allHappyConditionsLegacyConfigurationSuccess(federationDomain1.Spec.Issuer, oauth2IdentityProvider.Name, frozenMetav1Now, 123),
+				),
+				expectedFederationDomainStatusUpdate(federationDomain2,
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					allHappyConditionsLegacyConfigurationSuccess(federationDomain2.Spec.Issuer, oauth2IdentityProvider.Name, frozenMetav1Now, 123),
+				),
+			},
+		},
 		{
 			name: "when there are two valid FederationDomains, but one is already up to date, the sync loop only updates " +
 				"the out-of-date FederationDomain",
@@ -2021,16 +2307,18 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 			},
 		},
 		{
-			name: "the federation domain specifies illegal const type, which shouldn't really happen since the CRD validates it",
+			name: "the federation domain has valid group enrichment configurations",
 			inputObjects: []runtime.Object{
 				oidcIdentityProvider,
+				ldapIdentityProvider,
+				adIdentityProvider,
 				&supervisorconfigv1alpha1.FederationDomain{
 					ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
 					Spec: supervisorconfigv1alpha1.FederationDomainSpec{
 						Issuer: "https://issuer1.com",
 						IdentityProviders: []supervisorconfigv1alpha1.FederationDomainIdentityProvider{
 							{
-								DisplayName: "can-find-me",
+								DisplayName: "name1",
 								ObjectRef: corev1.TypedLocalObjectReference{
 									APIGroup: ptr.To(apiGroupSupervisor),
 									Kind:     "OIDCIdentityProvider",
@@ -2038,50 +2326,530 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 								},
 								Transforms: supervisorconfigv1alpha1.FederationDomainTransforms{
 									Constants: []supervisorconfigv1alpha1.FederationDomainTransformsConstant{
-										{
-											Type: "this is illegal",
-										},
+										{Name: "suffix", Type: "string", StringValue: "@example.com"},
+									},
+								},
+								GroupEnrichment: &supervisorconfigv1alpha1.FederationDomainGroupEnrichment{
+									ObjectRef: corev1.TypedLocalObjectReference{
+										APIGroup: ptr.To(apiGroupSupervisor),
+										Kind:     "LDAPIdentityProvider",
+										Name:     ldapIdentityProvider.Name,
+									},
+									KeyExpression: `username + strConst.suffix`,
+								},
+							},
+							{
+								DisplayName: "name2",
+								ObjectRef: corev1.TypedLocalObjectReference{
+									APIGroup: ptr.To(apiGroupSupervisor),
+									Kind:     "LDAPIdentityProvider",
+									Name:     ldapIdentityProvider.Name,
+								},
+								GroupEnrichment: &supervisorconfigv1alpha1.FederationDomainGroupEnrichment{
+									ObjectRef: corev1.TypedLocalObjectReference{
+										APIGroup: ptr.To(apiGroupSupervisor),
+										Kind:     "ActiveDirectoryIdentityProvider",
+										Name:     adIdentityProvider.Name,
 									},
+									KeyExpression: `username`,
 								},
 							},
 						},
 					},
 				},
 			},
-			wantErr: `one of spec.identityProvider[].transforms.constants[].type is invalid: "this is illegal"`,
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{
+				federationDomainIssuerWithIDPs(t, "https://issuer1.com", []*federationdomainproviders.FederationDomainIdentityProvider{
+					{
+						DisplayName: "name1",
+						UID:         oidcIdentityProvider.UID,
+						Transforms:  idtransform.NewTransformationPipeline(),
+						GroupEnrichment: &federationdomainproviders.GroupEnrichment{
+							SourceUID:  ldapIdentityProvider.UID,
+							SourceName: ldapIdentityProvider.Name,
+							Key: newGroupEnrichmentKey(t, &celtransformer.TransformationConstants{
+								StringConstants: map[string]string{"suffix": "@example.com"},
+							}, `username + strConst.suffix`),
+						},
+					},
+					{
+						DisplayName: "name2",
+						UID:         ldapIdentityProvider.UID,
+						Transforms:  idtransform.NewTransformationPipeline(),
+						GroupEnrichment: &federationdomainproviders.GroupEnrichment{
+							SourceUID:  adIdentityProvider.UID,
+							SourceName: adIdentityProvider.Name,
+							Key:        newGroupEnrichmentKey(t, &celtransformer.TransformationConstants{}, `username`),
+						},
+					},
+				}),
+			},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(
+					&supervisorconfigv1alpha1.FederationDomain{
+						ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					},
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					allHappyConditionsSuccess("https://issuer1.com", frozenMetav1Now, 123),
+				),
+			},
 		},
 		{
-			name: "the federation domain specifies illegal expression type, which shouldn't really happen since the CRD validates it",
+			name: "the federation domain has totp configurations",
 			inputObjects: []runtime.Object{
 				oidcIdentityProvider,
+				ldapIdentityProvider,
+				adIdentityProvider,
 				&supervisorconfigv1alpha1.FederationDomain{
 					ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
 					Spec: supervisorconfigv1alpha1.FederationDomainSpec{
 						Issuer: "https://issuer1.com",
 						IdentityProviders: []supervisorconfigv1alpha1.FederationDomainIdentityProvider{
 							{
-								DisplayName: "can-find-me",
+								DisplayName: "name1",
 								ObjectRef: corev1.TypedLocalObjectReference{
 									APIGroup: ptr.To(apiGroupSupervisor),
 									Kind:     "OIDCIdentityProvider",
 									Name:     oidcIdentityProvider.Name,
 								},
-								Transforms: supervisorconfigv1alpha1.FederationDomainTransforms{
-									Expressions: []supervisorconfigv1alpha1.FederationDomainTransformsExpression{
-										{
-											Type: "this is illegal",
-										},
-									},
+							},
+							{
+								DisplayName: "name2",
+								ObjectRef: corev1.TypedLocalObjectReference{
+									APIGroup: ptr.To(apiGroupSupervisor),
+									Kind:     "LDAPIdentityProvider",
+									Name:     ldapIdentityProvider.Name,
+								},
+								TOTP: &supervisorconfigv1alpha1.FederationDomainTOTP{
+									Policy: supervisorconfigv1alpha1.FederationDomainTOTPPolicyWhenEnrolled,
+								},
+							},
+							{
+								DisplayName: "name3",
+								ObjectRef: corev1.TypedLocalObjectReference{
+									APIGroup: ptr.To(apiGroupSupervisor),
+									Kind:     "ActiveDirectoryIdentityProvider",
+									Name:     adIdentityProvider.Name,
 								},
+								// The CRD defaults the policy, but an empty policy also means Required.
+								TOTP: &supervisorconfigv1alpha1.FederationDomainTOTP{},
 							},
 						},
 					},
 				},
 			},
-			wantErr: `one of spec.identityProvider[].transforms.expressions[].type is invalid: "this is illegal"`,
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{
+				federationDomainIssuerWithIDPs(t, "https://issuer1.com", []*federationdomainproviders.FederationDomainIdentityProvider{
+					{
+						DisplayName: "name1",
+						UID:         oidcIdentityProvider.UID,
+						Transforms:  idtransform.NewTransformationPipeline(),
+					},
+					{
+						DisplayName: "name2",
+						UID:         ldapIdentityProvider.UID,
+						Transforms:  idtransform.NewTransformationPipeline(),
+						TOTPPolicy:  totp.PolicyWhenEnrolled,
+					},
+					{
+						DisplayName: "name3",
+						UID:         adIdentityProvider.UID,
+						Transforms:  idtransform.NewTransformationPipeline(),
+						TOTPPolicy:  totp.PolicyRequired,
+					},
+				}),
+			},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(
+					&supervisorconfigv1alpha1.FederationDomain{
+						ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					},
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					allHappyConditionsSuccess("https://issuer1.com", frozenMetav1Now, 123),
+				),
+			},
 		},
-	}
-
+		{
+			name: "the federation domain has invalid group enrichment configurations",
+			inputObjects: []runtime.Object{
+				oidcIdentityProvider,
+				ldapIdentityProvider,
+				&supervisorconfigv1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					Spec: supervisorconfigv1alpha1.FederationDomainSpec{
+						Issuer: "https://issuer1.com",
+						IdentityProviders: []supervisorconfigv1alpha1.FederationDomainIdentityProvider{
+							{
+								DisplayName: "name1",
+								ObjectRef: corev1.TypedLocalObjectReference{
+									APIGroup: ptr.To(apiGroupSupervisor),
+									Kind:     "OIDCIdentityProvider",
+									Name:     oidcIdentityProvider.Name,
+								},
+								GroupEnrichment: &supervisorconfigv1alpha1.FederationDomainGroupEnrichment{
+									ObjectRef: corev1.TypedLocalObjectReference{
+										APIGroup: ptr.To("wrong.example.com"),
+										Kind:     "OIDCIdentityProvider",
+										Name:     oidcIdentityProvider.Name,
+									},
+									KeyExpression: `username`,
+								},
+							},
+							{
+								DisplayName: "name2",
+								ObjectRef: corev1.TypedLocalObjectReference{
+									APIGroup: ptr.To(apiGroupSupervisor),
+									Kind:     "OIDCIdentityProvider",
+									Name:     oidcIdentityProvider.Name,
+								},
+								GroupEnrichment: &supervisorconfigv1alpha1.FederationDomainGroupEnrichment{
+									ObjectRef: corev1.TypedLocalObjectReference{
+										APIGroup: ptr.To(apiGroupSupervisor),
+										Kind:     "LDAPIdentityProvider",
+										Name:     "cant-find-me",
+									},
+									KeyExpression: `groups`,
+								},
+							},
+							{
+								DisplayName: "name3",
+								ObjectRef: corev1.TypedLocalObjectReference{
+									APIGroup: ptr.To(apiGroupSupervisor),
+									Kind:     "OIDCIdentityProvider",
+									Name:     oidcIdentityProvider.Name,
+								},
+								GroupEnrichment: &supervisorconfigv1alpha1.FederationDomainGroupEnrichment{
+									ObjectRef: corev1.TypedLocalObjectReference{
+										APIGroup: ptr.To(apiGroupSupervisor),
+										Kind:     "LDAPIdentityProvider",
+										Name:     ldapIdentityProvider.Name,
+									},
+									KeyExpression: `username`,
+								},
+							},
+						},
+					},
+				},
+			},
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(
+					&supervisorconfigv1alpha1.FederationDomain{
+						ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					},
+					supervisorconfigv1alpha1.FederationDomainPhaseError,
+					conditionstestutil.Replace(
+						allHappyConditionsSuccess("https://issuer1.com", frozenMetav1Now, 123),
+						[]metav1.Condition{
+							sadGroupEnrichmentCondition(fmt.Sprintf(here.Doc(
+								`.spec.identityProviders[0].groupEnrichment.objectRef.apiGroup "wrong.example.com" is not recognized (should be %q)
+
+								 .spec.identityProviders[0].groupEnrichment.objectRef.kind "OIDCIdentityProvider" is not supported (should be one of "ActiveDirectoryIdentityProvider", "LDAPIdentityProvider")
+
+								 cannot find resource specified by .spec.identityProviders[1].groupEnrichment.objectRef (with name "cant-find-me")
+
+								 .spec.identityProviders[1].groupEnrichment.keyExpression was invalid:
+								 CEL expression should return type "string" but returns type "list(string)"`,
+							), apiGroupSupervisor), frozenMetav1Now, 123),
+							sadReadyCondition(frozenMetav1Now, 123),
+						}),
+				),
+			},
+		},
+		{
+			name: "the federation domain configures its identity provider chooser",
+			inputObjects: []runtime.Object{
+				oidcIdentityProvider,
+				&supervisorconfigv1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					Spec: supervisorconfigv1alpha1.FederationDomainSpec{
+						Issuer: "https://issuer1.com",
+						IdentityProviders: []supervisorconfigv1alpha1.FederationDomainIdentityProvider{
+							{
+								DisplayName: "can-find-me",
+								ObjectRef: corev1.TypedLocalObjectReference{
+									APIGroup: ptr.To(apiGroupSupervisor),
+									Kind:     "OIDCIdentityProvider",
+									Name:     oidcIdentityProvider.Name,
+								},
+							},
+						},
+						IdentityProviderChooser: &supervisorconfigv1alpha1.FederationDomainIdentityProviderChooser{
+							RememberLastChosen: true,
+							AutoRedirect:       true,
+						},
+					},
+				},
+			},
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{
+				func() *federationdomainproviders.FederationDomainIssuer {
+					fdIssuer := federationDomainIssuerWithIDPs(t, "https://issuer1.com",
+						[]*federationdomainproviders.FederationDomainIdentityProvider{
+							{
+								DisplayName: "can-find-me",
+								UID:         oidcIdentityProvider.UID,
+								Transforms:  idtransform.NewTransformationPipeline(),
+							},
+						})
+					fdIssuer.SetIdentityProviderChooser(federationdomainproviders.IdentityProviderChooser{
+						RememberLastChosen: true,
+						AutoRedirect:       true,
+					})
+					return fdIssuer
+				}(),
+			},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(
+					&supervisorconfigv1alpha1.FederationDomain{
+						ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					},
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					allHappyConditionsSuccess("https://issuer1.com", frozenMetav1Now, 123),
+				),
+			},
+		},
+		{
+			name: "the federation domain configures its client registration endpoint",
+			inputObjects: []runtime.Object{
+				oidcIdentityProvider,
+				&supervisorconfigv1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					Spec: supervisorconfigv1alpha1.FederationDomainSpec{
+						Issuer: "https://issuer1.com",
+						IdentityProviders: []supervisorconfigv1alpha1.FederationDomainIdentityProvider{
+							{
+								DisplayName: "can-find-me",
+								ObjectRef: corev1.TypedLocalObjectReference{
+									APIGroup: ptr.To(apiGroupSupervisor),
+									Kind:     "OIDCIdentityProvider",
+									Name:     oidcIdentityProvider.Name,
+								},
+							},
+						},
+						ClientRegistration: &supervisorconfigv1alpha1.FederationDomainClientRegistration{
+							Enabled:                       true,
+							InitialAccessTokensSecretName: "some-initial-access-tokens",
+						},
+					},
+				},
+			},
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{
+				func() *federationdomainproviders.FederationDomainIssuer {
+					fdIssuer := federationDomainIssuerWithIDPs(t, "https://issuer1.com",
+						[]*federationdomainproviders.FederationDomainIdentityProvider{
+							{
+								DisplayName: "can-find-me",
+								UID:         oidcIdentityProvider.UID,
+								Transforms:  idtransform.NewTransformationPipeline(),
+							},
+						})
+					fdIssuer.SetClientRegistration(federationdomainproviders.ClientRegistration{
+						Enabled:                       true,
+						InitialAccessTokensSecretName: "some-initial-access-tokens",
+					})
+					return fdIssuer
+				}(),
+			},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(
+					&supervisorconfigv1alpha1.FederationDomain{
+						ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					},
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					allHappyConditionsSuccess("https://issuer1.com", frozenMetav1Now, 123),
+				),
+			},
+		},
+		{
+			name: "the federation domain specifies a valid ConfigMap as its branding",
+			inputObjects: []runtime.Object{
+				oidcIdentityProvider,
+				federationDomainWithBranding(supervisorconfigv1alpha1.FederationDomainBranding{Name: "some-branding"}),
+			},
+			kubeInputObjects: []runtime.Object{
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "some-branding", Namespace: namespace},
+					Data: map[string]string{
+						"primaryColor":     "#112233",
+						"messages.de.json": `{"username": "Benutzername"}`,
+					},
+					BinaryData: map[string][]byte{"logo": testBrandingLogo},
+				},
+			},
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{
+				federationDomainIssuerWithBranding(t, map[string][]byte{
+					"primaryColor":     []byte("#112233"),
+					"messages.de.json": []byte(`{"username": "Benutzername"}`),
+					"logo":             testBrandingLogo,
+				}),
+			},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(
+					&supervisorconfigv1alpha1.FederationDomain{
+						ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					},
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					conditionstestutil.Replace(
+						allHappyConditionsSuccess("https://issuer1.com", frozenMetav1Now, 123),
+						[]metav1.Condition{
+							happyBrandingCondition(`the ConfigMap "some-branding" specified by .spec.branding is valid`, frozenMetav1Now, 123),
+						}),
+				),
+			},
+		},
+		{
+			name: "the federation domain specifies a valid Secret as its branding",
+			inputObjects: []runtime.Object{
+				oidcIdentityProvider,
+				federationDomainWithBranding(supervisorconfigv1alpha1.FederationDomainBranding{
+					Kind: supervisorconfigv1alpha1.FederationDomainBrandingKindSecret,
+					Name: "some-branding",
+				}),
+			},
+			kubeInputObjects: []runtime.Object{
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "some-branding", Namespace: namespace},
+					Data:       map[string][]byte{"supportURL": []byte("https://help.example.com")},
+				},
+			},
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{
+				federationDomainIssuerWithBranding(t, map[string][]byte{"supportURL": []byte("https://help.example.com")}),
+			},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(
+					&supervisorconfigv1alpha1.FederationDomain{
+						ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					},
+					supervisorconfigv1alpha1.FederationDomainPhaseReady,
+					conditionstestutil.Replace(
+						allHappyConditionsSuccess("https://issuer1.com", frozenMetav1Now, 123),
+						[]metav1.Condition{
+							happyBrandingCondition(`the Secret "some-branding" specified by .spec.branding is valid`, frozenMetav1Now, 123),
+						}),
+				),
+			},
+		},
+		{
+			name: "the federation domain specifies a branding which cannot be found in its namespace",
+			inputObjects: []runtime.Object{
+				oidcIdentityProvider,
+				federationDomainWithBranding(supervisorconfigv1alpha1.FederationDomainBranding{Name: "some-branding"}),
+			},
+			kubeInputObjects: []runtime.Object{
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "some-branding", Namespace: "other-namespace"}},
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "some-branding", Namespace: namespace}},
+			},
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(
+					&supervisorconfigv1alpha1.FederationDomain{
+						ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					},
+					supervisorconfigv1alpha1.FederationDomainPhaseError,
+					conditionstestutil.Replace(
+						allHappyConditionsSuccess("https://issuer1.com", frozenMetav1Now, 123),
+						[]metav1.Condition{
+							sadBrandingCondition("BrandingNotFound",
+								`the ConfigMap "some-branding" specified by .spec.branding was not found`, frozenMetav1Now, 123),
+							sadReadyCondition(frozenMetav1Now, 123),
+						}),
+				),
+			},
+		},
+		{
+			name: "the federation domain specifies an invalid branding",
+			inputObjects: []runtime.Object{
+				oidcIdentityProvider,
+				federationDomainWithBranding(supervisorconfigv1alpha1.FederationDomainBranding{
+					Kind: supervisorconfigv1alpha1.FederationDomainBrandingKindConfigMap,
+					Name: "some-branding",
+				}),
+			},
+			kubeInputObjects: []runtime.Object{
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: "some-branding", Namespace: namespace},
+					Data:       map[string]string{"supportURL": "http://help.example.com"},
+				},
+			},
+			wantFDIssuers: []*federationdomainproviders.FederationDomainIssuer{},
+			wantStatusUpdates: []*supervisorconfigv1alpha1.FederationDomain{
+				expectedFederationDomainStatusUpdate(
+					&supervisorconfigv1alpha1.FederationDomain{
+						ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					},
+					supervisorconfigv1alpha1.FederationDomainPhaseError,
+					conditionstestutil.Replace(
+						allHappyConditionsSuccess("https://issuer1.com", frozenMetav1Now, 123),
+						[]metav1.Condition{
+							sadBrandingCondition("InvalidBranding",
+								`the ConfigMap "some-branding" specified by .spec.branding is invalid: supportURL must be an https URL`, frozenMetav1Now, 123),
+							sadReadyCondition(frozenMetav1Now, 123),
+						}),
+				),
+			},
+		},
+		{
+			name: "the federation domain specifies illegal const type, which shouldn't really happen since the CRD validates it",
+			inputObjects: []runtime.Object{
+				oidcIdentityProvider,
+				&supervisorconfigv1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					Spec: supervisorconfigv1alpha1.FederationDomainSpec{
+						Issuer: "https://issuer1.com",
+						IdentityProviders: []supervisorconfigv1alpha1.FederationDomainIdentityProvider{
+							{
+								DisplayName: "can-find-me",
+								ObjectRef: corev1.TypedLocalObjectReference{
+									APIGroup: ptr.To(apiGroupSupervisor),
+									Kind:     "OIDCIdentityProvider",
+									Name:     oidcIdentityProvider.Name,
+								},
+								Transforms: supervisorconfigv1alpha1.FederationDomainTransforms{
+									Constants: []supervisorconfigv1alpha1.FederationDomainTransformsConstant{
+										{
+											Type: "this is illegal",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			wantErr: `one of spec.identityProvider[].transforms.constants[].type is invalid: "this is illegal"`,
+		},
+		{
+			name: "the federation domain specifies illegal expression type, which shouldn't really happen since the CRD validates it",
+			inputObjects: []runtime.Object{
+				oidcIdentityProvider,
+				&supervisorconfigv1alpha1.FederationDomain{
+					ObjectMeta: metav1.ObjectMeta{Name: "config1", Namespace: namespace, Generation: 123},
+					Spec: supervisorconfigv1alpha1.FederationDomainSpec{
+						Issuer: "https://issuer1.com",
+						IdentityProviders: []supervisorconfigv1alpha1.FederationDomainIdentityProvider{
+							{
+								DisplayName: "can-find-me",
+								ObjectRef: corev1.TypedLocalObjectReference{
+									APIGroup: ptr.To(apiGroupSupervisor),
+									Kind:     "OIDCIdentityProvider",
+									Name:     oidcIdentityProvider.Name,
+								},
+								Transforms: supervisorconfigv1alpha1.FederationDomainTransforms{
+									Expressions: []supervisorconfigv1alpha1.FederationDomainTransformsExpression{
+										{
+											Type: "this is illegal",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			wantErr: `one of spec.identityProvider[].transforms.expressions[].type is invalid: "this is illegal"`,
+		},
+	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
@@ -2097,6 +2865,7 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 				tt.configClient(pinnipedAPIClient)
 			}
 			pinnipedInformers := supervisorinformers.NewSharedInformerFactory(pinnipedInformerClient, 0)
+			kubeInformers := kubeinformers.NewSharedInformerFactory(kubernetesfake.NewSimpleClientset(tt.kubeInputObjects...), 0)
 
 			controller := NewFederationDomainWatcherController(
 				federationDomainsSetter,
@@ -2108,6 +2877,11 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 				pinnipedInformers.IDP().V1alpha1().LDAPIdentityProviders(),
 				pinnipedInformers.IDP().V1alpha1().ActiveDirectoryIdentityProviders(),
 				pinnipedInformers.IDP().V1alpha1().GitHubIdentityProviders(),
+				pinnipedInformers.IDP().V1alpha1().SAMLIdentityProviders(),
+				pinnipedInformers.IDP().V1alpha1().GitLabIdentityProviders(),
+				pinnipedInformers.IDP().V1alpha1().OAuth2IdentityProviders(),
+				kubeInformers.Core().V1().ConfigMaps(),
+				kubeInformers.Core().V1().Secrets(),
 				controllerlib.WithInformer,
 			)
 
@@ -2115,6 +2889,7 @@ func TestTestFederationDomainWatcherControllerSync(t *testing.T) {
 			defer cancel()
 
 			pinnipedInformers.Start(ctx.Done())
+			kubeInformers.Start(ctx.Done())
 			controllerlib.TestRunSynchronously(t, controller)
 
 			syncCtx := controllerlib.Context{Context: ctx, Key: controllerlib.Key{Namespace: namespace, Name: "config-name"}}
@@ -2160,22 +2935,43 @@ type comparableFederationDomainIssuer struct {
 	issuer                  string
 	identityProviders       []*comparableFederationDomainIdentityProvider
 	defaultIdentityProvider *comparableFederationDomainIdentityProvider
+	brandingPage            *branding.Page
+	identityProviderChooser federationdomainproviders.IdentityProviderChooser
+	clientRegistration      federationdomainproviders.ClientRegistration
 }
 
 type comparableFederationDomainIdentityProvider struct {
 	DisplayName      string
 	UID              types.UID
 	TransformsSource []any
+	GroupEnrichment  *comparableGroupEnrichment
+	TOTPPolicy       totp.Policy
+}
+
+type comparableGroupEnrichment struct {
+	SourceUID  types.UID
+	SourceName string
+	KeySource  any
 }
 
 func makeFederationDomainIdentityProviderComparable(fdi *federationdomainproviders.FederationDomainIdentityProvider) *comparableFederationDomainIdentityProvider {
 	if fdi == nil {
 		return nil
 	}
+	var groupEnrichment *comparableGroupEnrichment
+	if fdi.GroupEnrichment != nil {
+		groupEnrichment = &comparableGroupEnrichment{
+			SourceUID:  fdi.GroupEnrichment.SourceUID,
+			SourceName: fdi.GroupEnrichment.SourceName,
+			KeySource:  fdi.GroupEnrichment.Key.Source(),
+		}
+	}
 	return &comparableFederationDomainIdentityProvider{
 		DisplayName:      fdi.DisplayName,
 		UID:              fdi.UID,
 		TransformsSource: fdi.Transforms.Source(),
+		GroupEnrichment:  groupEnrichment,
+		TOTPPolicy:       fdi.TOTPPolicy,
 	}
 }
 
@@ -2191,6 +2987,10 @@ func convertToComparableType(fdis []*federationdomainproviders.FederationDomainI
 			issuer:                  fdi.Issuer(),
 			identityProviders:       comparableFDIs,
 			defaultIdentityProvider: makeFederationDomainIdentityProviderComparable(fdi.DefaultIdentityProvider()),
+			// The branding holds a compiled language matcher, so compare the branding of a page instead.
+			brandingPage:            fdi.Branding().PageFor(httptest.NewRequest(http.MethodGet, "/", nil)),
+			identityProviderChooser: fdi.IdentityProviderChooser(),
+			clientRegistration:      fdi.ClientRegistration(),
 		}
 		result = append(result, converted)
 	}
@@ -2356,3 +3156,24 @@ func TestTransformationPipelinesCanBeTestedForEqualityUsingSourceToMakeTestingEa
 	require.NotEqual(t, pipeline.Source(), differentPipeline1.Source())
 	require.NotEqual(t, pipeline.Source(), differentPipeline2.Source())
 }
+
+func newGroupEnrichmentKey(
+	t *testing.T,
+	consts *celtransformer.TransformationConstants,
+	expression string,
+) idtransform.GroupEnrichmentKey {
+	compiler, err := celtransformer.NewCELTransformer(celTransformerMaxExpressionRuntime)
+	require.NoError(t, err)
+
+	if consts.StringConstants == nil {
+		consts.StringConstants = map[string]string{}
+	}
+	if consts.StringListConstants == nil {
+		consts.StringListConstants = map[string][]string{}
+	}
+
+	key, err := compiler.CompileGroupEnrichmentKey(&celtransformer.GroupEnrichmentKey{Expression: expression}, consts)
+	require.NoError(t, err)
+
+	return key
+}
@@ -39,6 +39,9 @@ const (
 	// FederationDomainStateEncryptionKeyType for the Secret storing the FederationDomain state encryption key.
 	FederationDomainStateEncryptionKeyType corev1.SecretType = "secrets.pinniped.dev/federation-domain-state-encryption-key"
 
+	// FederationDomainTOTPEncryptionKeyType for the Secret storing the FederationDomain TOTP enrollment encryption key.
+	FederationDomainTOTPEncryptionKeyType corev1.SecretType = "secrets.pinniped.dev/federation-domain-totp-encryption-key"
+
 	federationDomainKind = "FederationDomain"
 
 	// symmetricSecretDataKey is the corev1.Secret.Data key for the symmetric key value generated by this helper.
@@ -58,6 +61,7 @@ const (
 	SecretUsageTokenSigningKey SecretUsage = iota
 	SecretUsageStateSigningKey
 	SecretUsageStateEncryptionKey
+	SecretUsageTOTPEncryptionKey
 )
 
 // NewSymmetricSecretHelper returns a SecretHelper that has been parameterized with common symmetric secret generation
@@ -150,6 +154,8 @@ func (s *symmetricSecretHelper) ObserveActiveSecretAndUpdateParentFederationDoma
 		federationDomain.Status.Secrets.StateSigningKey.Name = secret.Name
 	case SecretUsageStateEncryptionKey:
 		federationDomain.Status.Secrets.StateEncryptionKey.Name = secret.Name
+	case SecretUsageTOTPEncryptionKey:
+		federationDomain.Status.Secrets.TOTPEncryptionKey.Name = secret.Name
 	default:
 		panic(fmt.Sprintf("unknown secret usage enum value: %d", s.secretUsage))
 	}
@@ -165,6 +171,8 @@ func (s *symmetricSecretHelper) secretType() corev1.SecretType {
 		return FederationDomainStateSigningKeyType
 	case SecretUsageStateEncryptionKey:
 		return FederationDomainStateEncryptionKeyType
+	case SecretUsageTOTPEncryptionKey:
+		return FederationDomainTOTPEncryptionKeyType
 	default:
 		panic(fmt.Sprintf("unknown secret usage enum value: %d", s.secretUsage))
 	}
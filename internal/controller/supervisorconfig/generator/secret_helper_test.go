@@ -50,6 +50,14 @@ func TestSymmetricSecretHelper(t *testing.T) {
 				return federationDomain.Status.Secrets.StateEncryptionKey.Name
 			},
 		},
+		{
+			name:           "totp encryption key",
+			secretUsage:    SecretUsageTOTPEncryptionKey,
+			wantSecretType: "secrets.pinniped.dev/federation-domain-totp-encryption-key",
+			wantSetFederationDomainField: func(federationDomain *supervisorconfigv1alpha1.FederationDomain) string {
+				return federationDomain.Status.Secrets.TOTPEncryptionKey.Name
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
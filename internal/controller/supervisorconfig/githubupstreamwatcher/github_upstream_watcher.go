@@ -21,8 +21,10 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/cache"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/utils/clock"
 
@@ -37,6 +39,7 @@ import (
 	"go.pinniped.dev/internal/crypto/ptls"
 	"go.pinniped.dev/internal/endpointaddr"
 	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/githubclient"
 	"go.pinniped.dev/internal/net/phttp"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/setutil"
@@ -119,6 +122,7 @@ type gitHubWatcherController struct {
 	clock                          clock.Clock
 	dialFunc                       func(network, addr string, config *tls.Config) (*tls.Conn, error)
 	validatedCache                 GitHubValidatedAPICacheI
+	responseCaches                 *githubclient.ResponseCaches
 }
 
 // New instantiates a new controllerlib.Controller which will populate the provided UpstreamGitHubIdentityProviderICache.
@@ -134,6 +138,7 @@ func New(
 	clock clock.Clock,
 	dialFunc func(network, addr string, config *tls.Config) (*tls.Conn, error),
 	validatedCache *cache.Expiring,
+	responseCaches *githubclient.ResponseCaches,
 ) controllerlib.Controller {
 	c := gitHubWatcherController{
 		namespace:                      namespace,
@@ -146,6 +151,7 @@ func New(
 		clock:                          clock,
 		dialFunc:                       dialFunc,
 		validatedCache:                 NewGitHubValidatedAPICache(validatedCache),
+		responseCaches:                 responseCaches,
 	}
 
 	return controllerlib.New(
@@ -189,7 +195,9 @@ func (c *gitHubWatcherController) Sync(ctx controllerlib.Context) error {
 
 	var applicationErrors []error
 	validatedUpstreams := make([]upstreamprovider.UpstreamGithubIdentityProviderI, 0, len(actualUpstreams))
+	uids := sets.New[types.UID]()
 	for _, upstream := range actualUpstreams {
+		uids.Insert(upstream.UID)
 		validatedUpstream, applicationErr := c.validateUpstreamAndUpdateConditions(ctx, upstream)
 		if applicationErr != nil {
 			applicationErrors = append(applicationErrors, applicationErr)
@@ -201,6 +209,7 @@ func (c *gitHubWatcherController) Sync(ctx controllerlib.Context) error {
 		// This controller should take no action until the user has reconfigured the upstream.
 	}
 	c.cache.SetGitHubIdentityProviders(validatedUpstreams)
+	c.responseCaches.ForgetAllExcept(uids)
 
 	// If we have recoverable application errors, let's do a requeue and capture all the applicationErrors too
 	if len(applicationErrors) > 0 {
@@ -350,7 +359,9 @@ func (c *gitHubWatcherController) validateUpstreamAndUpdateConditions(ctx contro
 		applicationErrors = append(applicationErrors, fmt.Errorf("expected %d conditions but found %d conditions", countExpectedConditions, len(conditions)))
 		return nil, utilerrors.NewAggregate(applicationErrors)
 	}
-	hadErrorCondition, updateStatusErr := c.updateStatus(ctx.Context, upstream, conditions)
+	responseCache := c.responseCaches.CacheFor(upstream.UID)
+
+	hadErrorCondition, updateStatusErr := c.updateStatus(ctx.Context, upstream, conditions, responseCache.LowestRateLimit())
 	if updateStatusErr != nil {
 		applicationErrors = append(applicationErrors, updateStatusErr)
 	}
@@ -363,11 +374,13 @@ func (c *gitHubWatcherController) validateUpstreamAndUpdateConditions(ctx contro
 
 	provider := upstreamgithub.New(
 		upstreamgithub.ProviderConfig{
-			Name:               upstream.Name,
-			ResourceUID:        upstream.UID,
-			APIBaseURL:         apiBaseUrl(apiHostPort),
-			GroupNameAttribute: groupNameAttribute,
-			UsernameAttribute:  usernameAttribute,
+			Name:                    upstream.Name,
+			ResourceUID:             upstream.UID,
+			APIBaseURL:              apiBaseUrl(apiHostPort),
+			GroupNameAttribute:      groupNameAttribute,
+			UsernameAttribute:       usernameAttribute,
+			OrganizationRoleGroups:  upstream.Spec.Claims.OrganizationRoleGroups,
+			AdditionalClaimMappings: upstream.Spec.Claims.AdditionalClaimMappings,
 			OAuth2Config: &oauth2.Config{
 				ClientID:     clientID,
 				ClientSecret: clientSecret,
@@ -383,6 +396,7 @@ func (c *gitHubWatcherController) validateUpstreamAndUpdateConditions(ctx contro
 			},
 			AllowedOrganizations: setutil.NewCaseInsensitiveSet(upstream.Spec.AllowAuthentication.Organizations.Allowed...),
 			HttpClient:           httpClient,
+			ResponseCache:        responseCache,
 		},
 	)
 	return provider, utilerrors.NewAggregate(applicationErrors)
@@ -563,7 +577,8 @@ func validateUserAndGroupAttributes(upstream *idpv1alpha1.GitHubIdentityProvider
 func (c *gitHubWatcherController) updateStatus(
 	ctx context.Context,
 	upstream *idpv1alpha1.GitHubIdentityProvider,
-	conditions []*metav1.Condition) (bool, error) {
+	conditions []*metav1.Condition,
+	rateLimit *githubclient.RateLimit) (bool, error) {
 	log := c.log.WithValues("namespace", upstream.Namespace, "name", upstream.Name)
 	updated := upstream.DeepCopy()
 
@@ -580,6 +595,17 @@ func (c *gitHubWatcherController) updateStatus(
 		updated.Status.Phase = idpv1alpha1.GitHubPhaseError
 	}
 
+	updated.Status.RateLimit = nil
+	if rateLimit != nil {
+		updated.Status.RateLimit = &idpv1alpha1.GitHubRateLimitStatus{
+			Limit:     rateLimit.Limit,
+			Remaining: rateLimit.Remaining,
+			ResetTime: metav1.NewTime(rateLimit.Reset),
+			// Kubernetes stores times with a precision of seconds, so truncate to avoid needless updates.
+			ObservedTime: metav1.NewTime(rateLimit.ObservedAt.Truncate(time.Second)),
+		}
+	}
+
 	if equality.Semantic.DeepEqual(upstream, updated) {
 		return hadErrorCondition, nil
 	}
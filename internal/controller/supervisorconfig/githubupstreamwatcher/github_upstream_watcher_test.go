@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"slices"
 	"strings"
 	"testing"
@@ -43,6 +44,7 @@ import (
 	"go.pinniped.dev/internal/controllerlib"
 	"go.pinniped.dev/internal/federationdomain/dynamicupstreamprovider"
 	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/githubclient"
 	"go.pinniped.dev/internal/net/phttp"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/setutil"
@@ -173,8 +175,12 @@ func TestController(t *testing.T) {
 				},
 			},
 			Claims: idpv1alpha1.GitHubClaims{
-				Username: ptr.To(idpv1alpha1.GitHubUsernameID),
-				Groups:   ptr.To(idpv1alpha1.GitHubUseTeamNameForGroupName),
+				Username:               ptr.To(idpv1alpha1.GitHubUsernameID),
+				Groups:                 ptr.To(idpv1alpha1.GitHubUseTeamNameForGroupName),
+				OrganizationRoleGroups: true,
+				AdditionalClaimMappings: map[string]idpv1alpha1.GitHubAdditionalClaimAttribute{
+					"email": idpv1alpha1.GitHubAdditionalClaimSAMLNameID,
+				},
 			},
 			AllowAuthentication: idpv1alpha1.GitHubAllowAuthenticationSpec{
 				Organizations: idpv1alpha1.GitHubOrganizationsSpec{
@@ -428,11 +434,15 @@ func TestController(t *testing.T) {
 			},
 			wantResultingCache: []*upstreamgithub.ProviderConfig{
 				{
-					Name:               "some-idp-name",
-					ResourceUID:        "some-resource-uid",
-					APIBaseURL:         fmt.Sprintf("https://%s/api/v3", *validFilledOutIDP.Spec.GitHubAPI.Host),
-					UsernameAttribute:  "id",
-					GroupNameAttribute: "name",
+					Name:                   "some-idp-name",
+					ResourceUID:            "some-resource-uid",
+					APIBaseURL:             fmt.Sprintf("https://%s/api/v3", *validFilledOutIDP.Spec.GitHubAPI.Host),
+					UsernameAttribute:      "id",
+					GroupNameAttribute:     "name",
+					OrganizationRoleGroups: true,
+					AdditionalClaimMappings: map[string]idpv1alpha1.GitHubAdditionalClaimAttribute{
+						"email": "samlNameID",
+					},
 					OAuth2Config: &oauth2.Config{
 						ClientID:     "some-client-id",
 						ClientSecret: "some-client-secret",
@@ -1068,11 +1078,15 @@ func TestController(t *testing.T) {
 			},
 			wantResultingCache: []*upstreamgithub.ProviderConfig{
 				{
-					Name:               "some-idp-name",
-					ResourceUID:        "some-resource-uid",
-					APIBaseURL:         fmt.Sprintf("https://%s/api/v3", *validFilledOutIDP.Spec.GitHubAPI.Host),
-					UsernameAttribute:  "id",
-					GroupNameAttribute: "name",
+					Name:                   "some-idp-name",
+					ResourceUID:            "some-resource-uid",
+					APIBaseURL:             fmt.Sprintf("https://%s/api/v3", *validFilledOutIDP.Spec.GitHubAPI.Host),
+					UsernameAttribute:      "id",
+					GroupNameAttribute:     "name",
+					OrganizationRoleGroups: true,
+					AdditionalClaimMappings: map[string]idpv1alpha1.GitHubAdditionalClaimAttribute{
+						"email": "samlNameID",
+					},
 					OAuth2Config: &oauth2.Config{
 						ClientID:     "some-client-id",
 						ClientSecret: "some-client-secret",
@@ -1249,11 +1263,15 @@ func TestController(t *testing.T) {
 			},
 			wantResultingCache: []*upstreamgithub.ProviderConfig{
 				{
-					Name:               "idp-with-tls-in-secret",
-					ResourceUID:        "some-resource-uid",
-					APIBaseURL:         fmt.Sprintf("https://%s/api/v3", *validFilledOutIDP.Spec.GitHubAPI.Host),
-					UsernameAttribute:  "id",
-					GroupNameAttribute: "name",
+					Name:                   "idp-with-tls-in-secret",
+					ResourceUID:            "some-resource-uid",
+					APIBaseURL:             fmt.Sprintf("https://%s/api/v3", *validFilledOutIDP.Spec.GitHubAPI.Host),
+					UsernameAttribute:      "id",
+					GroupNameAttribute:     "name",
+					OrganizationRoleGroups: true,
+					AdditionalClaimMappings: map[string]idpv1alpha1.GitHubAdditionalClaimAttribute{
+						"email": "samlNameID",
+					},
 					OAuth2Config: &oauth2.Config{
 						ClientID:     "some-client-id",
 						ClientSecret: "some-client-secret",
@@ -1270,11 +1288,15 @@ func TestController(t *testing.T) {
 					HttpClient:           phttp.Default(goodServerCertPool),
 				},
 				{
-					Name:               "idp-with-tls-in-config-map",
-					ResourceUID:        "some-resource-uid",
-					APIBaseURL:         fmt.Sprintf("https://%s/api/v3", *validFilledOutIDP.Spec.GitHubAPI.Host),
-					UsernameAttribute:  "id",
-					GroupNameAttribute: "name",
+					Name:                   "idp-with-tls-in-config-map",
+					ResourceUID:            "some-resource-uid",
+					APIBaseURL:             fmt.Sprintf("https://%s/api/v3", *validFilledOutIDP.Spec.GitHubAPI.Host),
+					UsernameAttribute:      "id",
+					GroupNameAttribute:     "name",
+					OrganizationRoleGroups: true,
+					AdditionalClaimMappings: map[string]idpv1alpha1.GitHubAdditionalClaimAttribute{
+						"email": "samlNameID",
+					},
 					OAuth2Config: &oauth2.Config{
 						ClientID:     "some-client-id",
 						ClientSecret: "some-client-secret",
@@ -1396,11 +1418,15 @@ func TestController(t *testing.T) {
 			},
 			wantResultingCache: []*upstreamgithub.ProviderConfig{
 				{
-					Name:               "some-idp-name",
-					ResourceUID:        "some-resource-uid",
-					APIBaseURL:         fmt.Sprintf("https://%s/api/v3", *validFilledOutIDP.Spec.GitHubAPI.Host),
-					UsernameAttribute:  "id",
-					GroupNameAttribute: "name",
+					Name:                   "some-idp-name",
+					ResourceUID:            "some-resource-uid",
+					APIBaseURL:             fmt.Sprintf("https://%s/api/v3", *validFilledOutIDP.Spec.GitHubAPI.Host),
+					UsernameAttribute:      "id",
+					GroupNameAttribute:     "name",
+					OrganizationRoleGroups: true,
+					AdditionalClaimMappings: map[string]idpv1alpha1.GitHubAdditionalClaimAttribute{
+						"email": "samlNameID",
+					},
 					OAuth2Config: &oauth2.Config{
 						ClientID:     "some-client-id",
 						ClientSecret: "some-client-secret",
@@ -2501,6 +2527,7 @@ func TestController(t *testing.T) {
 				frozenClockForLastTransitionTime,
 				dialer,
 				validatedCache,
+				githubclient.NewResponseCaches(frozenClockForLastTransitionTime),
 			)
 
 			ctx, cancel := context.WithCancel(context.Background())
@@ -2884,6 +2911,7 @@ func TestController_OnlyWantActions(t *testing.T) {
 				frozenClockForLastTransitionTime,
 				tls.Dial,
 				cache.NewExpiring(),
+				githubclient.NewResponseCaches(frozenClockForLastTransitionTime),
 			)
 
 			ctx, cancel := context.WithCancel(context.Background())
@@ -2906,6 +2934,160 @@ func TestController_OnlyWantActions(t *testing.T) {
 	}
 }
 
+func TestController_ReportsRateLimit(t *testing.T) {
+	goodServer, goodServerCA := tlsserver.TestServerIPv4(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	}), tlsserver.RecordTLSHello)
+	goodServerDomain, _ := strings.CutPrefix(goodServer.URL, "https://")
+	goodServerCAB64 := base64.StdEncoding.EncodeToString(goodServerCA)
+
+	// This fake GitHub API reports the rate limit which is given by the query params of each request.
+	fakeGitHubAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", r.URL.Query().Get("remaining"))
+		w.Header().Set("X-RateLimit-Reset", r.URL.Query().Get("reset"))
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(fakeGitHubAPI.Close)
+
+	namespace := "some-namespace"
+	frozenTime := time.Date(2024, time.June, 1, 12, 30, 45, 123456789, time.UTC)
+	frozenClock := clocktesting.NewFakeClock(frozenTime)
+	inOneHour := time.Unix(frozenTime.Add(time.Hour).Unix(), 0)
+
+	goodSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "some-secret-name", Namespace: namespace},
+		Type:       "secrets.pinniped.dev/github-client",
+		Data: map[string][]byte{
+			"clientID":     []byte("some-client-id"),
+			"clientSecret": []byte("some-client-secret"),
+		},
+	}
+
+	validIDP := &idpv1alpha1.GitHubIdentityProvider{
+		ObjectMeta: metav1.ObjectMeta{Name: "some-idp-name", Namespace: namespace, UID: "some-uid", Generation: 1},
+		Spec: idpv1alpha1.GitHubIdentityProviderSpec{
+			GitHubAPI: idpv1alpha1.GitHubAPIConfig{
+				Host: ptr.To(goodServerDomain),
+				TLS:  &idpv1alpha1.TLSSpec{CertificateAuthorityData: goodServerCAB64},
+			},
+			Claims: idpv1alpha1.GitHubClaims{
+				Username: ptr.To(idpv1alpha1.GitHubUsernameLogin),
+				Groups:   ptr.To(idpv1alpha1.GitHubUseTeamSlugForGroupName),
+			},
+			Client: idpv1alpha1.GitHubClientSpec{SecretName: goodSecret.Name},
+			AllowAuthentication: idpv1alpha1.GitHubAllowAuthenticationSpec{
+				Organizations: idpv1alpha1.GitHubOrganizationsSpec{
+					Policy: ptr.To(idpv1alpha1.GitHubAllowedAuthOrganizationsPolicyAllGitHubUsers),
+				},
+			},
+		},
+	}
+
+	idpWithPreviousRateLimit := validIDP.DeepCopy()
+	idpWithPreviousRateLimit.Status.RateLimit = &idpv1alpha1.GitHubRateLimitStatus{
+		Limit:        5000,
+		Remaining:    1,
+		ResetTime:    metav1.NewTime(frozenTime.Add(-time.Minute).Truncate(time.Second)),
+		ObservedTime: metav1.NewTime(frozenTime.Add(-time.Hour).Truncate(time.Second)),
+	}
+
+	type observation struct {
+		token     string
+		remaining int
+		reset     time.Time
+	}
+
+	tests := []struct {
+		name          string
+		idp           *idpv1alpha1.GitHubIdentityProvider
+		observations  []observation
+		wantRateLimit *idpv1alpha1.GitHubRateLimitStatus
+	}{
+		{
+			name: "no quota observed",
+			idp:  validIDP,
+		},
+		{
+			name: "reports the lowest remaining quota",
+			idp:  validIDP,
+			observations: []observation{
+				{token: "token-1", remaining: 4000, reset: inOneHour},
+				{token: "token-2", remaining: 12, reset: inOneHour},
+				{token: "token-3", remaining: 4999, reset: inOneHour},
+			},
+			wantRateLimit: &idpv1alpha1.GitHubRateLimitStatus{
+				Limit:        5000,
+				Remaining:    12,
+				ResetTime:    metav1.NewTime(inOneHour),
+				ObservedTime: metav1.NewTime(frozenTime.Truncate(time.Second)),
+			},
+		},
+		{
+			name: "does not report quotas which have been reset",
+			idp:  validIDP,
+			observations: []observation{
+				{token: "token-1", remaining: 0, reset: frozenTime.Add(-time.Minute)},
+			},
+		},
+		{
+			name: "removes a previously reported quota which has been reset",
+			idp:  idpWithPreviousRateLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeSupervisorClient := supervisorfake.NewSimpleClientset(tt.idp)
+			supervisorInformers := supervisorinformers.NewSharedInformerFactory(fakeSupervisorClient, 0)
+			kubeInformers := k8sinformers.NewSharedInformerFactoryWithOptions(kubernetesfake.NewSimpleClientset(goodSecret), 0)
+
+			responseCaches := githubclient.NewResponseCaches(frozenClock)
+			httpClient := responseCaches.CacheFor(tt.idp.UID).HTTPClient(fakeGitHubAPI.Client())
+			for _, o := range tt.observations {
+				req, err := http.NewRequestWithContext(context.Background(), http.MethodGet,
+					fmt.Sprintf("%s/user?remaining=%d&reset=%d", fakeGitHubAPI.URL, o.remaining, o.reset.Unix()), nil)
+				require.NoError(t, err)
+				req.Header.Set("Authorization", "Bearer "+o.token)
+				resp, err := httpClient.Do(req)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+			}
+
+			controller := New(
+				namespace,
+				dynamicupstreamprovider.NewDynamicUpstreamIDPProvider(),
+				fakeSupervisorClient,
+				supervisorInformers.IDP().V1alpha1().GitHubIdentityProviders(),
+				kubeInformers.Core().V1().Secrets(),
+				kubeInformers.Core().V1().ConfigMaps(),
+				plog.TestLogger(t, &bytes.Buffer{}),
+				controllerlib.WithInformer,
+				frozenClock,
+				tls.Dial,
+				cache.NewExpiring(),
+				responseCaches,
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			supervisorInformers.Start(ctx.Done())
+			kubeInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, controller)
+
+			syncCtx := controllerlib.Context{Context: ctx, Key: controllerlib.Key{}}
+			require.NoError(t, controllerlib.TestSync(t, controller, syncCtx))
+
+			actualIDP, err := fakeSupervisorClient.IDPV1alpha1().GitHubIdentityProviders(namespace).Get(ctx, tt.idp.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, idpv1alpha1.GitHubPhaseReady, actualIDP.Status.Phase)
+			require.Equal(t, tt.wantRateLimit, actualIDP.Status.RateLimit)
+		})
+	}
+}
+
 func compareTLSClientConfigWithinHttpClients(t *testing.T, expected *http.Client, actual *http.Client) {
 	t.Helper()
 
@@ -3008,6 +3190,7 @@ func TestGitHubUpstreamWatcherControllerFilterSecret(t *testing.T) {
 				clock.RealClock{},
 				tls.Dial,
 				cache.NewExpiring(),
+				githubclient.NewResponseCaches(clock.RealClock{}),
 			)
 
 			unrelated := &corev1.Secret{}
@@ -3065,6 +3248,7 @@ func TestGitHubUpstreamWatcherControllerFilterConfigMaps(t *testing.T) {
 				clock.RealClock{},
 				tls.Dial,
 				cache.NewExpiring(),
+				githubclient.NewResponseCaches(clock.RealClock{}),
 			)
 
 			unrelated := &corev1.ConfigMap{}
@@ -3122,6 +3306,7 @@ func TestGitHubUpstreamWatcherControllerFilterGitHubIDP(t *testing.T) {
 				clock.RealClock{},
 				tls.Dial,
 				cache.NewExpiring(),
+				githubclient.NewResponseCaches(clock.RealClock{}),
 			)
 
 			unrelated := &idpv1alpha1.GitHubIdentityProvider{}
@@ -0,0 +1,1161 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package gitlabupstreamwatcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/cache"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	k8sinformers "k8s.io/client-go/informers"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	coretesting "k8s.io/client-go/testing"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	supervisorfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	supervisorinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions"
+	"go.pinniped.dev/internal/controller/conditionsutil"
+	"go.pinniped.dev/internal/controller/supervisorconfig/upstreamwatchers"
+	"go.pinniped.dev/internal/controller/tlsconfigutil"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/federationdomain/dynamicupstreamprovider"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/net/phttp"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/internal/testutil/tlsserver"
+	"go.pinniped.dev/internal/upstreamgitlab"
+)
+
+var (
+	gitlabIDPGVR = schema.GroupVersionResource{
+		Group:    idpv1alpha1.SchemeGroupVersion.Group,
+		Version:  idpv1alpha1.SchemeGroupVersion.Version,
+		Resource: "gitlabidentityproviders",
+	}
+
+	gitlabIDPKind = idpv1alpha1.SchemeGroupVersion.WithKind("GitLabIdentityProvider")
+)
+
+func TestController(t *testing.T) {
+	require.Equal(t, 6, countExpectedConditions)
+
+	goodServer, goodServerCA := tlsserver.TestServerIPv4(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	}), tlsserver.RecordTLSHello)
+	goodServerDomain, _ := strings.CutPrefix(goodServer.URL, "https://")
+	goodServerCAB64 := base64.StdEncoding.EncodeToString(goodServerCA)
+	goodServerCertPool := x509.NewCertPool()
+	goodServerCertPool.AppendCertsFromPEM(goodServerCA)
+
+	wantObservedGeneration := int64(1234)
+	namespace := "some-namespace"
+
+	wantFrozenTime := time.Date(1122, time.September, 33, 4, 55, 56, 778899, time.Local)
+	frozenClockForLastTransitionTime := clocktesting.NewFakeClock(wantFrozenTime)
+	wantLastTransitionTime := metav1.Time{Time: wantFrozenTime}
+
+	goodClientCredentialsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "some-secret-name",
+			Namespace: namespace,
+		},
+		Type: "secrets.pinniped.dev/gitlab-client",
+		Data: map[string][]byte{
+			"clientID":     []byte("some-client-id"),
+			"clientSecret": []byte("some-client-secret"),
+		},
+	}
+
+	validMinimalIDP := &idpv1alpha1.GitLabIdentityProvider{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "minimal-idp-name",
+			Namespace:  namespace,
+			UID:        types.UID("minimal-uid"),
+			Generation: wantObservedGeneration,
+		},
+		Spec: idpv1alpha1.GitLabIdentityProviderSpec{
+			GitLabAPI: idpv1alpha1.GitLabAPIConfig{
+				Host: ptr.To(goodServerDomain),
+				TLS: &idpv1alpha1.TLSSpec{
+					CertificateAuthorityData: goodServerCAB64,
+				},
+			},
+			Client: idpv1alpha1.GitLabClientSpec{
+				SecretName: goodClientCredentialsSecret.Name,
+			},
+			// These claims are optional when using the actual Kubernetes CRD.
+			// However, they are required here because CRD defaulting/validation does not occur during testing.
+			Claims: idpv1alpha1.GitLabClaims{
+				Username: ptr.To(idpv1alpha1.GitLabUsernameLogin),
+				Groups:   ptr.To(idpv1alpha1.GitLabUseFullPathForGroupName),
+			},
+			AllowAuthentication: idpv1alpha1.GitLabAllowAuthenticationSpec{
+				Groups: idpv1alpha1.GitLabGroupsSpec{
+					Policy: ptr.To(idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+				},
+			},
+		},
+	}
+
+	validFilledOutIDP := &idpv1alpha1.GitLabIdentityProvider{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "some-idp-name",
+			Namespace:  namespace,
+			UID:        types.UID("some-resource-uid"),
+			Generation: wantObservedGeneration,
+		},
+		Spec: idpv1alpha1.GitLabIdentityProviderSpec{
+			GitLabAPI: idpv1alpha1.GitLabAPIConfig{
+				Host: ptr.To(goodServerDomain),
+				TLS: &idpv1alpha1.TLSSpec{
+					CertificateAuthorityData: goodServerCAB64,
+				},
+			},
+			Claims: idpv1alpha1.GitLabClaims{
+				Username:         ptr.To(idpv1alpha1.GitLabUsernameID),
+				Groups:           ptr.To(idpv1alpha1.GitLabUseFullPathForGroupName),
+				FlattenSubgroups: true,
+			},
+			AllowAuthentication: idpv1alpha1.GitLabAllowAuthenticationSpec{
+				Groups: idpv1alpha1.GitLabGroupsSpec{
+					Policy:  ptr.To(idpv1alpha1.GitLabAllowedAuthGroupsPolicyOnlyUsersFromAllowedGroups),
+					Allowed: []string{"group1", "group2/subgroup"},
+				},
+			},
+			Client: idpv1alpha1.GitLabClientSpec{
+				SecretName: goodClientCredentialsSecret.Name,
+			},
+		},
+	}
+
+	buildHostValidTrue := func(t *testing.T, host string) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               HostValid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             conditionsutil.ReasonSuccess,
+			Message:            fmt.Sprintf("spec.gitlabAPI.host (%q) is valid", host),
+		}
+	}
+
+	buildHostValidFalse := func(t *testing.T, host, message string) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               HostValid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             "InvalidHost",
+			Message:            fmt.Sprintf(`spec.gitlabAPI.host (%q) is not valid: %s`, host, message),
+		}
+	}
+
+	buildTLSConfigurationValidTrue := func(t *testing.T) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               TLSConfigurationValid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             conditionsutil.ReasonSuccess,
+			Message:            "spec.gitlabAPI.tls is valid: using configured CA bundle",
+		}
+	}
+
+	buildTLSConfigurationValidFalse := func(t *testing.T, message string) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               TLSConfigurationValid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             "InvalidTLSConfig",
+			Message:            message,
+		}
+	}
+
+	buildGroupsPolicyValidTrue := func(t *testing.T, policy idpv1alpha1.GitLabAllowedAuthGroupsPolicy) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               GroupsPolicyValid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             conditionsutil.ReasonSuccess,
+			Message:            fmt.Sprintf("spec.allowAuthentication.groups.policy (%q) is valid", policy),
+		}
+	}
+
+	buildGroupsPolicyValidFalse := func(t *testing.T, message string) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               GroupsPolicyValid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             "Invalid",
+			Message:            message,
+		}
+	}
+
+	buildClientCredentialsSecretValidTrue := func(t *testing.T, secretName string) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               ClientCredentialsSecretValid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             conditionsutil.ReasonSuccess,
+			Message:            fmt.Sprintf("clientID and clientSecret have been read from spec.client.SecretName (%q)", secretName),
+		}
+	}
+
+	buildClientCredentialsSecretValidFalse := func(t *testing.T, prefix, secretName string) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               ClientCredentialsSecretValid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             upstreamwatchers.ReasonNotFound,
+			Message: fmt.Sprintf(
+				`%s: secret from spec.client.SecretName (%q) must be found in namespace %q with type "secrets.pinniped.dev/gitlab-client" and keys "clientID" and "clientSecret"`,
+				prefix,
+				secretName,
+				namespace,
+			),
+		}
+	}
+
+	buildClaimsValidatedTrue := func(t *testing.T) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               ClaimsValid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             conditionsutil.ReasonSuccess,
+			Message:            "spec.claims are valid",
+		}
+	}
+
+	buildClaimsValidatedFalse := func(t *testing.T, message string) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               ClaimsValid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             "Invalid",
+			Message:            message,
+		}
+	}
+
+	buildGitLabConnectionValidTrue := func(t *testing.T, hostDialed string, hostSpecified string) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               GitLabConnectionValid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             conditionsutil.ReasonSuccess,
+			Message:            fmt.Sprintf("dialed %q for spec.gitlabAPI.host (%q): host is reachable and TLS verification succeeds", hostDialed, hostSpecified),
+		}
+	}
+
+	buildGitLabConnectionValidFalse := func(t *testing.T, message string) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               GitLabConnectionValid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             "UnableToDialServer",
+			Message:            message,
+		}
+	}
+
+	buildGitLabConnectionValidUnknown := func(t *testing.T) metav1.Condition {
+		t.Helper()
+
+		return metav1.Condition{
+			Type:               GitLabConnectionValid,
+			Status:             metav1.ConditionUnknown,
+			ObservedGeneration: wantObservedGeneration,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             "UnableToValidate",
+			Message:            "unable to validate; see other conditions for details",
+		}
+	}
+
+	tests := []struct {
+		name                      string
+		gitlabIdentityProviders   []runtime.Object
+		secretsAndConfigMaps      []runtime.Object
+		mockDialer                func(t *testing.T) func(network, addr string, config *tls.Config) (*tls.Conn, error)
+		preexistingValidatedCache []GitLabValidatedAPICacheKey
+		wantErr                   string
+		wantResultingCache        []*upstreamgitlab.ProviderConfig
+		wantResultingUpstreams    []idpv1alpha1.GitLabIdentityProvider
+		wantValidatedCache        []GitLabValidatedAPICacheKey
+	}{
+		{
+			name:                   "no GitLabIdentityProviders",
+			wantResultingCache:     []*upstreamgitlab.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{},
+		},
+		{
+			name:                    "happy path with all fields",
+			secretsAndConfigMaps:    []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{validFilledOutIDP},
+			wantResultingCache: []*upstreamgitlab.ProviderConfig{
+				{
+					Name:               "some-idp-name",
+					ResourceUID:        "some-resource-uid",
+					APIBaseURL:         fmt.Sprintf("https://%s/api/v4", goodServerDomain),
+					UsernameAttribute:  "id",
+					GroupNameAttribute: "fullPath",
+					OAuth2Config: &oauth2.Config{
+						ClientID:     "some-client-id",
+						ClientSecret: "some-client-secret",
+						Endpoint: oauth2.Endpoint{
+							AuthURL:       fmt.Sprintf("https://%s/oauth/authorize", goodServerDomain),
+							DeviceAuthURL: "", // not used
+							TokenURL:      fmt.Sprintf("https://%s/oauth/token", goodServerDomain),
+							AuthStyle:     oauth2.AuthStyleInParams,
+						},
+						RedirectURL: "", // not used
+						Scopes:      []string{"read_api"},
+					},
+					AllowedGroups:    []string{"group1", "group2/subgroup"},
+					FlattenSubgroups: true,
+					HttpClient:       phttp.Default(goodServerCertPool),
+				},
+			},
+			wantValidatedCache: []GitLabValidatedAPICacheKey{
+				{
+					address:      goodServerDomain,
+					caBundleHash: tlsconfigutil.NewCABundleHash(goodServerCA),
+				},
+			},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validFilledOutIDP.ObjectMeta,
+					Spec:       validFilledOutIDP.Spec,
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseReady,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidTrue(t, validFilledOutIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidTrue(t, goodServerDomain, goodServerDomain),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyOnlyUsersFromAllowedGroups),
+							buildHostValidTrue(t, goodServerDomain),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:                    "happy path with minimal fields",
+			secretsAndConfigMaps:    []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{validMinimalIDP},
+			wantResultingCache: []*upstreamgitlab.ProviderConfig{
+				{
+					Name:               "minimal-idp-name",
+					ResourceUID:        "minimal-uid",
+					APIBaseURL:         fmt.Sprintf("https://%s/api/v4", goodServerDomain),
+					UsernameAttribute:  "login",
+					GroupNameAttribute: "fullPath",
+					OAuth2Config: &oauth2.Config{
+						ClientID:     "some-client-id",
+						ClientSecret: "some-client-secret",
+						Endpoint: oauth2.Endpoint{
+							AuthURL:       fmt.Sprintf("https://%s/oauth/authorize", goodServerDomain),
+							DeviceAuthURL: "", // not used
+							TokenURL:      fmt.Sprintf("https://%s/oauth/token", goodServerDomain),
+							AuthStyle:     oauth2.AuthStyleInParams,
+						},
+						RedirectURL: "", // not used
+						Scopes:      []string{"read_api"},
+					},
+					HttpClient: phttp.Default(goodServerCertPool),
+				},
+			},
+			wantValidatedCache: []GitLabValidatedAPICacheKey{
+				{
+					address:      goodServerDomain,
+					caBundleHash: tlsconfigutil.NewCABundleHash(goodServerCA),
+				},
+			},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec:       validMinimalIDP.Spec,
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseReady,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidTrue(t, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidTrue(t, goodServerDomain, goodServerDomain),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+							buildHostValidTrue(t, goodServerDomain),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:                 "happy path: specifying gitlab.com:443 should be treated as if they had specified gitlab.com",
+			secretsAndConfigMaps: []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{
+				func() runtime.Object {
+					gitlabIDP := validMinimalIDP.DeepCopy()
+					gitlabIDP.Spec.GitLabAPI.Host = ptr.To("gitlab.com:443")
+					// don't change the CA because we are not really going to dial gitlab.com in this test
+					return gitlabIDP
+				}(),
+			},
+			mockDialer: func(t *testing.T) func(network, addr string, config *tls.Config) (*tls.Conn, error) {
+				t.Helper()
+
+				return func(network, addr string, config *tls.Config) (*tls.Conn, error) {
+					require.Equal(t, "gitlab.com:443", addr)
+					// don't actually dial gitlab.com to avoid making external network calls in unit test
+					configClone := config.Clone()
+					configClone.RootCAs = goodServerCertPool
+					return tls.Dial(network, goodServerDomain, configClone)
+				}
+			},
+			wantResultingCache: []*upstreamgitlab.ProviderConfig{
+				{
+					Name:               "minimal-idp-name",
+					ResourceUID:        "minimal-uid",
+					APIBaseURL:         "https://gitlab.com/api/v4",
+					UsernameAttribute:  "login",
+					GroupNameAttribute: "fullPath",
+					OAuth2Config: &oauth2.Config{
+						ClientID:     "some-client-id",
+						ClientSecret: "some-client-secret",
+						Endpoint: oauth2.Endpoint{
+							AuthURL:       "https://gitlab.com/oauth/authorize",
+							DeviceAuthURL: "", // not used
+							TokenURL:      "https://gitlab.com/oauth/token",
+							AuthStyle:     oauth2.AuthStyleInParams,
+						},
+						RedirectURL: "", // not used
+						Scopes:      []string{"read_api"},
+					},
+					HttpClient: phttp.Default(goodServerCertPool),
+				},
+			},
+			wantValidatedCache: []GitLabValidatedAPICacheKey{
+				{
+					address:      "gitlab.com:443",
+					caBundleHash: tlsconfigutil.NewCABundleHash(goodServerCA),
+				},
+			},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec: func() idpv1alpha1.GitLabIdentityProviderSpec {
+						spec := validMinimalIDP.Spec.DeepCopy()
+						spec.GitLabAPI.Host = ptr.To("gitlab.com:443")
+						return *spec
+					}(),
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseReady,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidTrue(t, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidTrue(t, "gitlab.com:443", "gitlab.com:443"),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+							buildHostValidTrue(t, "gitlab.com:443"),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:                    "happy path with previously validated address/CA Bundle does not validate again",
+			secretsAndConfigMaps:    []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{validMinimalIDP},
+			mockDialer: func(t *testing.T) func(network, addr string, config *tls.Config) (*tls.Conn, error) {
+				t.Helper()
+
+				return func(network, addr string, config *tls.Config) (*tls.Conn, error) {
+					t.Errorf("this test should not perform dial")
+					t.FailNow()
+					return nil, nil
+				}
+			},
+			preexistingValidatedCache: []GitLabValidatedAPICacheKey{
+				{
+					address:      goodServerDomain,
+					caBundleHash: tlsconfigutil.NewCABundleHash(goodServerCA),
+				},
+			},
+			wantResultingCache: []*upstreamgitlab.ProviderConfig{
+				{
+					Name:               "minimal-idp-name",
+					ResourceUID:        "minimal-uid",
+					APIBaseURL:         fmt.Sprintf("https://%s/api/v4", goodServerDomain),
+					UsernameAttribute:  "login",
+					GroupNameAttribute: "fullPath",
+					OAuth2Config: &oauth2.Config{
+						ClientID:     "some-client-id",
+						ClientSecret: "some-client-secret",
+						Endpoint: oauth2.Endpoint{
+							AuthURL:       fmt.Sprintf("https://%s/oauth/authorize", goodServerDomain),
+							DeviceAuthURL: "", // not used
+							TokenURL:      fmt.Sprintf("https://%s/oauth/token", goodServerDomain),
+							AuthStyle:     oauth2.AuthStyleInParams,
+						},
+						RedirectURL: "", // not used
+						Scopes:      []string{"read_api"},
+					},
+					HttpClient: phttp.Default(goodServerCertPool),
+				},
+			},
+			wantValidatedCache: []GitLabValidatedAPICacheKey{
+				{
+					address:      goodServerDomain,
+					caBundleHash: tlsconfigutil.NewCABundleHash(goodServerCA),
+				},
+			},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec:       validMinimalIDP.Spec,
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseReady,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidTrue(t, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidTrue(t, goodServerDomain, goodServerDomain),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+							buildHostValidTrue(t, goodServerDomain),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:                 "Host error - missing spec.gitlabAPI.host",
+			secretsAndConfigMaps: []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{
+				func() runtime.Object {
+					badIDP := validMinimalIDP.DeepCopy()
+					badIDP.Spec.GitLabAPI.Host = nil
+					return badIDP
+				}(),
+			},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec: func() idpv1alpha1.GitLabIdentityProviderSpec {
+						badSpec := validMinimalIDP.Spec.DeepCopy()
+						badSpec.GitLabAPI.Host = nil
+						return *badSpec
+					}(),
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseError,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidTrue(t, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidUnknown(t),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+							buildHostValidFalse(t, "", "must not be empty"),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:                 "Host error - protocol/schema is specified",
+			secretsAndConfigMaps: []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{
+				func() runtime.Object {
+					badIDP := validMinimalIDP.DeepCopy()
+					badIDP.Spec.GitLabAPI.Host = ptr.To("https://example.com")
+					return badIDP
+				}(),
+			},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec: func() idpv1alpha1.GitLabIdentityProviderSpec {
+						badSpec := validMinimalIDP.Spec.DeepCopy()
+						badSpec.GitLabAPI.Host = ptr.To("https://example.com")
+						return *badSpec
+					}(),
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseError,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidTrue(t, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidUnknown(t),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+							buildHostValidFalse(t, "https://example.com", `invalid port "//example.com"`),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:                 "TLS error - invalid bundle",
+			secretsAndConfigMaps: []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{
+				func() runtime.Object {
+					badIDP := validMinimalIDP.DeepCopy()
+					badIDP.Spec.GitLabAPI.TLS = &idpv1alpha1.TLSSpec{
+						CertificateAuthorityData: base64.StdEncoding.EncodeToString([]byte("foo")),
+					}
+					return badIDP
+				}(),
+			},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec: func() idpv1alpha1.GitLabIdentityProviderSpec {
+						badSpec := validMinimalIDP.Spec.DeepCopy()
+						badSpec.GitLabAPI.TLS = &idpv1alpha1.TLSSpec{
+							CertificateAuthorityData: base64.StdEncoding.EncodeToString([]byte("foo")),
+						}
+						return *badSpec
+					}(),
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseError,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidTrue(t, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidUnknown(t),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+							buildHostValidTrue(t, goodServerDomain),
+							buildTLSConfigurationValidFalse(t, `spec.gitlabAPI.tls.certificateAuthorityData is invalid: no base64-encoded PEM certificates found in 4 bytes of data (PEM certificates must begin with "-----BEGIN CERTIFICATE-----")`),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:                 "Connection error - dial fails",
+			secretsAndConfigMaps: []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{
+				func() runtime.Object {
+					badIDP := validMinimalIDP.DeepCopy()
+					badIDP.Spec.GitLabAPI.Host = ptr.To("gitlab.example.com")
+					return badIDP
+				}(),
+			},
+			mockDialer: func(t *testing.T) func(network, addr string, config *tls.Config) (*tls.Conn, error) {
+				t.Helper()
+
+				return func(network, addr string, config *tls.Config) (*tls.Conn, error) {
+					require.Equal(t, "gitlab.example.com:443", addr)
+					return nil, errors.New("some dial error")
+				}
+			},
+			wantErr: "some dial error",
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec: func() idpv1alpha1.GitLabIdentityProviderSpec {
+						badSpec := validMinimalIDP.Spec.DeepCopy()
+						badSpec.GitLabAPI.Host = ptr.To("gitlab.example.com")
+						return *badSpec
+					}(),
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseError,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidTrue(t, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidFalse(t, `cannot dial "gitlab.example.com:443" for spec.gitlabAPI.host ("gitlab.example.com"): some dial error`),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+							buildHostValidTrue(t, "gitlab.example.com"),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+		},
+		{
+			name:                 "Groups Policy error - spec.allowAuthentication.groups.policy must be 'OnlyUsersFromAllowedGroups' when spec.allowAuthentication.groups.allowed has groups listed",
+			secretsAndConfigMaps: []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{
+				func() runtime.Object {
+					badIDP := validFilledOutIDP.DeepCopy()
+					badIDP.Spec.AllowAuthentication.Groups.Policy = ptr.To(idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers)
+					return badIDP
+				}(),
+			},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validFilledOutIDP.ObjectMeta,
+					Spec: func() idpv1alpha1.GitLabIdentityProviderSpec {
+						badSpec := validFilledOutIDP.Spec.DeepCopy()
+						badSpec.AllowAuthentication.Groups.Policy = ptr.To(idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers)
+						return *badSpec
+					}(),
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseError,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidTrue(t, validFilledOutIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidTrue(t, goodServerDomain, goodServerDomain),
+							buildGroupsPolicyValidFalse(t, "spec.allowAuthentication.groups.policy must be 'OnlyUsersFromAllowedGroups' when spec.allowAuthentication.groups.allowed has groups listed"),
+							buildHostValidTrue(t, goodServerDomain),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+			wantValidatedCache: []GitLabValidatedAPICacheKey{
+				{
+					address:      goodServerDomain,
+					caBundleHash: tlsconfigutil.NewCABundleHash(goodServerCA),
+				},
+			},
+		},
+		{
+			name:                 "Groups Policy error - spec.allowAuthentication.groups.policy must be 'AllGitLabUsers' when spec.allowAuthentication.groups.allowed is empty",
+			secretsAndConfigMaps: []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{
+				func() runtime.Object {
+					badIDP := validMinimalIDP.DeepCopy()
+					badIDP.Spec.AllowAuthentication.Groups.Policy = ptr.To(idpv1alpha1.GitLabAllowedAuthGroupsPolicyOnlyUsersFromAllowedGroups)
+					return badIDP
+				}(),
+			},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec: func() idpv1alpha1.GitLabIdentityProviderSpec {
+						badSpec := validMinimalIDP.Spec.DeepCopy()
+						badSpec.AllowAuthentication.Groups.Policy = ptr.To(idpv1alpha1.GitLabAllowedAuthGroupsPolicyOnlyUsersFromAllowedGroups)
+						return *badSpec
+					}(),
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseError,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidTrue(t, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidTrue(t, goodServerDomain, goodServerDomain),
+							buildGroupsPolicyValidFalse(t, "spec.allowAuthentication.groups.policy must be 'AllGitLabUsers' when spec.allowAuthentication.groups.allowed is empty"),
+							buildHostValidTrue(t, goodServerDomain),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+			wantValidatedCache: []GitLabValidatedAPICacheKey{
+				{
+					address:      goodServerDomain,
+					caBundleHash: tlsconfigutil.NewCABundleHash(goodServerCA),
+				},
+			},
+		},
+		{
+			name:                 "Invalid Claims - invalid spec.claims.username",
+			secretsAndConfigMaps: []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{
+				func() runtime.Object {
+					badIDP := validMinimalIDP.DeepCopy()
+					badIDP.Spec.Claims.Username = ptr.To[idpv1alpha1.GitLabUsernameAttribute]("a")
+					return badIDP
+				}(),
+			},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec: func() idpv1alpha1.GitLabIdentityProviderSpec {
+						badSpec := validMinimalIDP.Spec.DeepCopy()
+						badSpec.Claims.Username = ptr.To[idpv1alpha1.GitLabUsernameAttribute]("a")
+						return *badSpec
+					}(),
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseError,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedFalse(t, `spec.claims.username ("a") is not valid`),
+							buildClientCredentialsSecretValidTrue(t, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidTrue(t, goodServerDomain, goodServerDomain),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+							buildHostValidTrue(t, goodServerDomain),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+			wantValidatedCache: []GitLabValidatedAPICacheKey{
+				{
+					address:      goodServerDomain,
+					caBundleHash: tlsconfigutil.NewCABundleHash(goodServerCA),
+				},
+			},
+		},
+		{
+			name:                 "Invalid Claims - missing spec.claims.groups",
+			secretsAndConfigMaps: []runtime.Object{goodClientCredentialsSecret},
+			gitlabIdentityProviders: []runtime.Object{
+				func() runtime.Object {
+					badIDP := validMinimalIDP.DeepCopy()
+					badIDP.Spec.Claims.Groups = nil
+					return badIDP
+				}(),
+			},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec: func() idpv1alpha1.GitLabIdentityProviderSpec {
+						badSpec := validMinimalIDP.Spec.DeepCopy()
+						badSpec.Claims.Groups = nil
+						return *badSpec
+					}(),
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseError,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedFalse(t, "spec.claims.groups is required"),
+							buildClientCredentialsSecretValidTrue(t, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidTrue(t, goodServerDomain, goodServerDomain),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+							buildHostValidTrue(t, goodServerDomain),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+			wantValidatedCache: []GitLabValidatedAPICacheKey{
+				{
+					address:      goodServerDomain,
+					caBundleHash: tlsconfigutil.NewCABundleHash(goodServerCA),
+				},
+			},
+		},
+		{
+			name: "Client Secret error - wrong type",
+			secretsAndConfigMaps: []runtime.Object{
+				func() runtime.Object {
+					badSecret := goodClientCredentialsSecret.DeepCopy()
+					badSecret.Type = "other-type"
+					return badSecret
+				}(),
+			},
+			gitlabIdentityProviders: []runtime.Object{validMinimalIDP},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec:       validMinimalIDP.Spec,
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseError,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidFalse(t, `wrong secret type "other-type"`, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidTrue(t, goodServerDomain, goodServerDomain),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+							buildHostValidTrue(t, goodServerDomain),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+			wantValidatedCache: []GitLabValidatedAPICacheKey{
+				{
+					address:      goodServerDomain,
+					caBundleHash: tlsconfigutil.NewCABundleHash(goodServerCA),
+				},
+			},
+		},
+		{
+			name: "Client Secret error - missing clientSecret",
+			secretsAndConfigMaps: []runtime.Object{
+				func() runtime.Object {
+					badSecret := goodClientCredentialsSecret.DeepCopy()
+					delete(badSecret.Data, "clientSecret")
+					return badSecret
+				}(),
+			},
+			gitlabIdentityProviders: []runtime.Object{validMinimalIDP},
+			wantResultingUpstreams: []idpv1alpha1.GitLabIdentityProvider{
+				{
+					ObjectMeta: validMinimalIDP.ObjectMeta,
+					Spec:       validMinimalIDP.Spec,
+					Status: idpv1alpha1.GitLabIdentityProviderStatus{
+						Phase: idpv1alpha1.GitLabPhaseError,
+						Conditions: []metav1.Condition{
+							buildClaimsValidatedTrue(t),
+							buildClientCredentialsSecretValidFalse(t, `missing key "clientSecret"`, validMinimalIDP.Spec.Client.SecretName),
+							buildGitLabConnectionValidTrue(t, goodServerDomain, goodServerDomain),
+							buildGroupsPolicyValidTrue(t, idpv1alpha1.GitLabAllowedAuthGroupsPolicyAllGitLabUsers),
+							buildHostValidTrue(t, goodServerDomain),
+							buildTLSConfigurationValidTrue(t),
+						},
+					},
+				},
+			},
+			wantValidatedCache: []GitLabValidatedAPICacheKey{
+				{
+					address:      goodServerDomain,
+					caBundleHash: tlsconfigutil.NewCABundleHash(goodServerCA),
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeSupervisorClient := supervisorfake.NewSimpleClientset(tt.gitlabIdentityProviders...)
+			supervisorInformers := supervisorinformers.NewSharedInformerFactory(fakeSupervisorClient, 0)
+
+			fakeKubeClient := kubernetesfake.NewSimpleClientset(tt.secretsAndConfigMaps...)
+			kubeInformers := k8sinformers.NewSharedInformerFactoryWithOptions(fakeKubeClient, 0)
+
+			idpCache := dynamicupstreamprovider.NewDynamicUpstreamIDPProvider()
+			idpCache.SetGitLabIdentityProviders([]upstreamprovider.UpstreamGitLabIdentityProviderI{
+				upstreamgitlab.New(
+					upstreamgitlab.ProviderConfig{Name: "initial-entry-to-remove"},
+				),
+			})
+
+			var log bytes.Buffer
+			logger := plog.TestLogger(t, &log)
+
+			gitLabIdentityProviderInformer := supervisorInformers.IDP().V1alpha1().GitLabIdentityProviders()
+
+			dialer := tls.Dial
+			if tt.mockDialer != nil {
+				dialer = tt.mockDialer(t)
+			}
+
+			validatedCache := cache.NewExpiring()
+			for _, item := range tt.preexistingValidatedCache {
+				validatedCache.Set(item, nil, 1*time.Hour)
+			}
+
+			controller := New(
+				namespace,
+				idpCache,
+				fakeSupervisorClient,
+				gitLabIdentityProviderInformer,
+				kubeInformers.Core().V1().Secrets(),
+				kubeInformers.Core().V1().ConfigMaps(),
+				logger,
+				controllerlib.WithInformer,
+				frozenClockForLastTransitionTime,
+				dialer,
+				validatedCache,
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			supervisorInformers.Start(ctx.Done())
+			kubeInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, controller)
+
+			syncCtx := controllerlib.Context{Context: ctx, Key: controllerlib.Key{}}
+
+			if err := controllerlib.TestSync(t, controller, syncCtx); len(tt.wantErr) > 0 {
+				require.ErrorContains(t, err, controllerlib.ErrSyntheticRequeue.Error())
+				require.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			// Verify what's in the IDP cache
+			actualIDPList := idpCache.GetGitLabIdentityProviders()
+			require.Equal(t, len(tt.wantResultingCache), len(actualIDPList))
+			for i := range len(tt.wantResultingCache) {
+				actualProvider, ok := actualIDPList[i].(*upstreamgitlab.Provider)
+				require.True(t, ok)
+
+				require.Equal(t, tt.wantResultingCache[i].Name, actualProvider.GetResourceName())
+				require.Equal(t, tt.wantResultingCache[i].ResourceUID, actualProvider.GetResourceUID())
+				require.Equal(t, tt.wantResultingCache[i].OAuth2Config.ClientID, actualProvider.GetClientID())
+				require.Equal(t, tt.wantResultingCache[i].GroupNameAttribute, actualProvider.GetGroupNameAttribute())
+				require.Equal(t, tt.wantResultingCache[i].UsernameAttribute, actualProvider.GetUsernameAttribute())
+				require.Equal(t, tt.wantResultingCache[i].AllowedGroups, actualProvider.GetAllowedGroups())
+				require.Equal(t, tt.wantResultingCache[i].FlattenSubgroups, actualProvider.GetFlattenSubgroups())
+
+				compareTLSClientConfigWithinHttpClients(t, tt.wantResultingCache[i].HttpClient, actualProvider.GetConfig().HttpClient)
+				require.Equal(t, tt.wantResultingCache[i].OAuth2Config, actualProvider.GetConfig().OAuth2Config)
+				require.Equal(t, tt.wantResultingCache[i].APIBaseURL, actualProvider.GetConfig().APIBaseURL)
+			}
+
+			// Verify what's in the validated cache
+			for _, item := range tt.wantValidatedCache {
+				_, ok := validatedCache.Get(item)
+				require.True(t, ok, "item with address %q must be found in the validated cache", item.address)
+			}
+
+			// Verify the status conditions as reported in Kubernetes
+			allGitLabIDPs, err := fakeSupervisorClient.IDPV1alpha1().GitLabIdentityProviders(namespace).List(ctx, metav1.ListOptions{})
+			require.NoError(t, err)
+
+			require.Equal(t, len(tt.wantResultingUpstreams), len(allGitLabIDPs.Items))
+			for i := range len(tt.wantResultingUpstreams) {
+				require.Len(t, tt.wantResultingUpstreams[i].Status.Conditions, countExpectedConditions)
+				require.Equal(t, tt.wantResultingUpstreams[i], allGitLabIDPs.Items[i])
+			}
+
+			wantActions := make([]coretesting.Action, 3+len(tt.wantResultingUpstreams))
+			wantActions[0] = coretesting.NewListAction(gitlabIDPGVR, gitlabIDPKind, "", metav1.ListOptions{})
+			wantActions[1] = coretesting.NewWatchAction(gitlabIDPGVR, "", metav1.ListOptions{})
+			for i, want := range tt.wantResultingUpstreams {
+				wantActions[2+i] = coretesting.NewUpdateSubresourceAction(gitlabIDPGVR, "status", want.Namespace, ptr.To(want))
+			}
+			// This List action is coming from the test code when it pulls the GitLabIdentityProviders from K8s
+			wantActions[len(wantActions)-1] = coretesting.NewListAction(gitlabIDPGVR, gitlabIDPKind, namespace, metav1.ListOptions{})
+			require.Equal(t, wantActions, fakeSupervisorClient.Actions())
+		})
+	}
+}
+
+func compareTLSClientConfigWithinHttpClients(t *testing.T, expected *http.Client, actual *http.Client) {
+	t.Helper()
+
+	require.NotEmpty(t, expected)
+	require.NotEmpty(t, actual)
+
+	require.Equal(t, expected.Timeout, actual.Timeout)
+
+	expectedConfig, err := utilnet.TLSClientConfig(expected.Transport)
+	require.NoError(t, err)
+
+	actualConfig, err := utilnet.TLSClientConfig(actual.Transport)
+	require.NoError(t, err)
+
+	require.True(t, actualConfig.RootCAs.Equal(expectedConfig.RootCAs))
+	actualConfig.RootCAs = expectedConfig.RootCAs
+	require.Equal(t, expectedConfig, actualConfig)
+}
+
+func TestGitLabUpstreamWatcherControllerFilterSecret(t *testing.T) {
+	goodSecret := &corev1.Secret{
+		Type: "secrets.pinniped.dev/gitlab-client",
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "some-name",
+		},
+	}
+
+	tests := []struct {
+		name       string
+		secret     metav1.Object
+		wantAdd    bool
+		wantUpdate bool
+		wantDelete bool
+	}{
+		{
+			name:       "should return true for a secret of the type secrets.pinniped.dev/gitlab-client",
+			secret:     goodSecret,
+			wantAdd:    true,
+			wantUpdate: true,
+			wantDelete: true,
+		},
+		{
+			name: "should return true for a secret of the type Opaque",
+			secret: func() *corev1.Secret {
+				otherSecret := goodSecret.DeepCopy()
+				otherSecret.Type = corev1.SecretTypeOpaque
+				return otherSecret
+			}(),
+			wantAdd:    true,
+			wantUpdate: true,
+			wantDelete: true,
+		},
+		{
+			name: "should return true for a secret of the type TLS",
+			secret: func() *corev1.Secret {
+				otherSecret := goodSecret.DeepCopy()
+				otherSecret.Type = corev1.SecretTypeTLS
+				return otherSecret
+			}(),
+			wantAdd:    true,
+			wantUpdate: true,
+			wantDelete: true,
+		},
+		{
+			name: "should return false for a secret of the wrong type",
+			secret: func() *corev1.Secret {
+				otherSecret := goodSecret.DeepCopy()
+				otherSecret.Type = "secrets.pinniped.dev/github-client"
+				return otherSecret
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			kubeInformers := k8sinformers.NewSharedInformerFactoryWithOptions(kubernetesfake.NewSimpleClientset(), 0)
+
+			var log bytes.Buffer
+			logger := plog.TestLogger(t, &log)
+
+			observableInformers := testutil.NewObservableWithInformerOption()
+			secretInformer := kubeInformers.Core().V1().Secrets()
+
+			_ = New(
+				"some-namespace",
+				dynamicupstreamprovider.NewDynamicUpstreamIDPProvider(),
+				supervisorfake.NewSimpleClientset(),
+				supervisorinformers.NewSharedInformerFactory(supervisorfake.NewSimpleClientset(), 0).IDP().V1alpha1().GitLabIdentityProviders(),
+				secretInformer,
+				kubeInformers.Core().V1().ConfigMaps(),
+				logger,
+				observableInformers.WithInformer,
+				clock.RealClock{},
+				tls.Dial,
+				cache.NewExpiring(),
+			)
+
+			unrelated := &corev1.Secret{}
+			filter := observableInformers.GetFilterForInformer(secretInformer)
+			require.Equal(t, tt.wantAdd, filter.Add(tt.secret))
+			require.Equal(t, tt.wantUpdate, filter.Update(unrelated, tt.secret))
+			require.Equal(t, tt.wantUpdate, filter.Update(tt.secret, unrelated))
+			require.Equal(t, tt.wantDelete, filter.Delete(tt.secret))
+		})
+	}
+}
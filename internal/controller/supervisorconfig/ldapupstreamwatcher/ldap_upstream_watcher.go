@@ -7,11 +7,14 @@ package ldapupstreamwatcher
 import (
 	"context"
 	"fmt"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 
 	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
@@ -28,6 +31,9 @@ import (
 
 const (
 	ldapControllerName = "ldap-upstream-observer"
+
+	// defaultNestedGroupsMaxDepth is used when nested groups are enabled without specifying a max depth.
+	defaultNestedGroupsMaxDepth = 5
 )
 
 type ldapUpstreamGenericLDAPImpl struct {
@@ -133,6 +139,8 @@ type ldapWatcherController struct {
 	cache                        UpstreamLDAPIdentityProviderICache
 	validatedSettingsCache       upstreamwatchers.ValidatedSettingsCacheI
 	ldapDialer                   upstreamldap.LDAPDialer
+	connectionPools              *upstreamwatchers.ConnectionPools
+	groupCache                   upstreamldap.GroupCache
 	client                       supervisorclientset.Interface
 	ldapIdentityProviderInformer idpinformers.LDAPIdentityProviderInformer
 	secretInformer               corev1informers.SecretInformer
@@ -146,6 +154,7 @@ func New(
 	ldapIdentityProviderInformer idpinformers.LDAPIdentityProviderInformer,
 	secretInformer corev1informers.SecretInformer,
 	configMapInformer corev1informers.ConfigMapInformer,
+	groupCache upstreamldap.GroupCache,
 	withInformer pinnipedcontroller.WithInformerOptionFunc,
 ) controllerlib.Controller {
 	return newInternal(
@@ -154,6 +163,7 @@ func New(
 		upstreamwatchers.NewValidatedSettingsCache(),
 		// nil means to use a real production dialer when creating objects to add to the cache
 		nil,
+		groupCache,
 		client,
 		ldapIdentityProviderInformer,
 		secretInformer,
@@ -167,6 +177,7 @@ func newInternal(
 	idpCache UpstreamLDAPIdentityProviderICache,
 	validatedSettingsCache upstreamwatchers.ValidatedSettingsCacheI,
 	ldapDialer upstreamldap.LDAPDialer,
+	groupCache upstreamldap.GroupCache,
 	client supervisorclientset.Interface,
 	ldapIdentityProviderInformer idpinformers.LDAPIdentityProviderInformer,
 	secretInformer corev1informers.SecretInformer,
@@ -176,7 +187,9 @@ func newInternal(
 	c := ldapWatcherController{
 		cache:                        idpCache,
 		validatedSettingsCache:       validatedSettingsCache,
+		connectionPools:              upstreamwatchers.NewConnectionPools(),
 		ldapDialer:                   ldapDialer,
+		groupCache:                   groupCache,
 		client:                       client,
 		ldapIdentityProviderInformer: ldapIdentityProviderInformer,
 		secretInformer:               secretInformer,
@@ -216,7 +229,9 @@ func (c *ldapWatcherController) Sync(ctx controllerlib.Context) error {
 
 	requeue := false
 	validatedUpstreams := make([]upstreamprovider.UpstreamLDAPIdentityProviderI, 0, len(actualUpstreams))
+	uids := sets.New[types.UID]()
 	for _, upstream := range actualUpstreams {
+		uids.Insert(upstream.UID)
 		validProvider, requestedRequeue := c.validateUpstream(ctx.Context, upstream)
 		if validProvider != nil {
 			validatedUpstreams = append(validatedUpstreams, validProvider)
@@ -227,6 +242,7 @@ func (c *ldapWatcherController) Sync(ctx controllerlib.Context) error {
 	}
 
 	c.cache.SetLDAPIdentityProviders(validatedUpstreams)
+	c.connectionPools.CloseAllExcept(uids)
 
 	if requeue {
 		return controllerlib.ErrSyntheticRequeue
@@ -238,14 +254,20 @@ func (c *ldapWatcherController) validateUpstream(ctx context.Context, upstream *
 	spec := upstream.Spec
 
 	config := &upstreamldap.ProviderConfig{
-		Name:        upstream.Name,
-		ResourceUID: upstream.UID,
-		Host:        spec.Host,
+		Name:            upstream.Name,
+		ResourceUID:     upstream.UID,
+		Host:            spec.Host,
+		AdditionalHosts: spec.AdditionalHosts,
+		SRVRecordName:   spec.SRVRecordName,
+		BindMethod:      upstreamldap.BindMethod(spec.Bind.Method),
+		GSSAPI:          gssapiConfig(spec.Bind.GSSAPI),
 		UserSearch: upstreamldap.UserSearchConfig{
 			Base:              spec.UserSearch.Base,
 			Filter:            spec.UserSearch.Filter,
 			UsernameAttribute: spec.UserSearch.Attributes.Username,
 			UIDAttribute:      spec.UserSearch.Attributes.UID,
+
+			AdditionalClaimMappings: spec.UserSearch.Attributes.AdditionalClaimMappings,
 		},
 		GroupSearch: upstreamldap.GroupSearchConfig{
 			Base:                   spec.GroupSearch.Base,
@@ -253,8 +275,12 @@ func (c *ldapWatcherController) validateUpstream(ctx context.Context, upstream *
 			UserAttributeForFilter: spec.GroupSearch.UserAttributeForFilter,
 			GroupNameAttribute:     spec.GroupSearch.Attributes.GroupName,
 			SkipGroupRefresh:       spec.GroupSearch.SkipGroupRefresh,
+			CacheTTL:               time.Duration(spec.GroupSearch.CacheTTLSeconds) * time.Second,
+			NestedGroupsMaxDepth:   nestedGroupsMaxDepth(spec.GroupSearch.NestedGroups),
 		},
-		Dialer: c.ldapDialer,
+		Dialer:         c.ldapDialer,
+		ConnectionPool: c.connectionPools.PoolFor(upstream.UID),
+		GroupCache:     c.groupCache,
 	}
 
 	conditions := upstreamwatchers.ValidateGenericLDAP(ctx, &ldapUpstreamGenericLDAPImpl{*upstream}, c.secretInformer, c.configMapInformer, c.validatedSettingsCache, config)
@@ -264,6 +290,26 @@ func (c *ldapWatcherController) validateUpstream(ctx context.Context, upstream *
 	return upstreamwatchers.EvaluateConditions(conditions, config)
 }
 
+// gssapiConfig returns the settings from the spec which are used for the GSSAPI bind method. The keytab and the
+// krb5.conf are loaded from the bind Secret.
+func gssapiConfig(gssapi *idpv1alpha1.LDAPIdentityProviderGSSAPIBind) upstreamldap.GSSAPIConfig {
+	if gssapi == nil {
+		return upstreamldap.GSSAPIConfig{}
+	}
+	return upstreamldap.GSSAPIConfig{Realm: gssapi.Realm, ServicePrincipalName: gssapi.ServicePrincipalName}
+}
+
+func nestedGroupsMaxDepth(nestedGroups idpv1alpha1.LDAPIdentityProviderNestedGroupSearch) int {
+	switch {
+	case !nestedGroups.Enabled:
+		return 0
+	case nestedGroups.MaxDepth == 0:
+		return defaultNestedGroupsMaxDepth
+	default:
+		return int(nestedGroups.MaxDepth)
+	}
+}
+
 func (c *ldapWatcherController) updateStatus(ctx context.Context, upstream *idpv1alpha1.LDAPIdentityProvider, conditions []*metav1.Condition) {
 	log := plog.WithValues("namespace", upstream.Namespace, "name", upstream.Name)
 	updated := upstream.DeepCopy()
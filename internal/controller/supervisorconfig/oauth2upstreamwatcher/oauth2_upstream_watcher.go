@@ -0,0 +1,559 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package oauth2upstreamwatcher implements a controller which watches OAuth2IdentityProviders.
+package oauth2upstreamwatcher
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/cache"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/utils/clock"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	supervisorclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
+	idpinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions/idp/v1alpha1"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controller/conditionsutil"
+	"go.pinniped.dev/internal/controller/supervisorconfig/upstreamwatchers"
+	"go.pinniped.dev/internal/controller/tlsconfigutil"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/crypto/ptls"
+	"go.pinniped.dev/internal/endpointaddr"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/net/phttp"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/upstreamoauth2"
+)
+
+const (
+	controllerName = "oauth2-upstream-observer"
+
+	// Constants related to the client credentials Secret.
+	oauth2ClientSecretType               corev1.SecretType = "secrets.pinniped.dev/oauth2-client"
+	clientIDDataKey, clientSecretDataKey string            = "clientID", "clientSecret"
+
+	countExpectedConditions = 5
+
+	EndpointsValid               string = "EndpointsValid"
+	TLSConfigurationValid        string = "TLSConfigurationValid"
+	ClientCredentialsSecretValid string = "ClientCredentialsSecretValid" //nolint:gosec // this is not a credential
+	ClaimsValid                  string = "ClaimsValid"
+	OAuth2ConnectionValid        string = "OAuth2ConnectionValid"
+
+	reasonInvalid         = "Invalid"
+	reasonInvalidEndpoint = "InvalidEndpoint"
+)
+
+// UpstreamOAuth2IdentityProviderICache is a thread safe cache that holds a list of validated upstream OAuth2 IDP configurations.
+type UpstreamOAuth2IdentityProviderICache interface {
+	SetOAuth2IdentityProviders([]upstreamprovider.UpstreamOAuth2IdentityProviderI)
+}
+
+type OAuth2ValidatedAPICacheI interface {
+	MarkAsValidated(address string, caBundleHash tlsconfigutil.CABundleHash)
+	IsValid(address string, caBundleHash tlsconfigutil.CABundleHash) bool
+}
+
+type OAuth2ValidatedAPICache struct {
+	cache *cache.Expiring
+}
+
+type OAuth2ValidatedAPICacheKey struct {
+	address      string
+	caBundleHash tlsconfigutil.CABundleHash
+}
+
+func (o *OAuth2ValidatedAPICache) MarkAsValidated(address string, caBundleHash tlsconfigutil.CABundleHash) {
+	key := OAuth2ValidatedAPICacheKey{
+		address:      address,
+		caBundleHash: caBundleHash,
+	}
+	// Existence in the cache means it has been validated.
+	// The TTL in the cache is not important, it's just a "really long time".
+	o.cache.Set(key, nil, 365*24*time.Hour)
+}
+
+func (o *OAuth2ValidatedAPICache) IsValid(address string, caBundleHash tlsconfigutil.CABundleHash) bool {
+	key := OAuth2ValidatedAPICacheKey{
+		address:      address,
+		caBundleHash: caBundleHash,
+	}
+	_, ok := o.cache.Get(key)
+	return ok
+}
+
+func NewOAuth2ValidatedAPICache(cache *cache.Expiring) OAuth2ValidatedAPICacheI {
+	return &OAuth2ValidatedAPICache{cache: cache}
+}
+
+type oauth2WatcherController struct {
+	namespace                      string
+	cache                          UpstreamOAuth2IdentityProviderICache
+	log                            plog.Logger
+	client                         supervisorclientset.Interface
+	oauth2IdentityProviderInformer idpinformers.OAuth2IdentityProviderInformer
+	secretInformer                 corev1informers.SecretInformer
+	configMapInformer              corev1informers.ConfigMapInformer
+	clock                          clock.Clock
+	dialFunc                       func(network, addr string, config *tls.Config) (*tls.Conn, error)
+	validatedCache                 OAuth2ValidatedAPICacheI
+}
+
+// New instantiates a new controllerlib.Controller which will populate the provided UpstreamOAuth2IdentityProviderICache.
+func New(
+	namespace string,
+	idpCache UpstreamOAuth2IdentityProviderICache,
+	client supervisorclientset.Interface,
+	oauth2IdentityProviderInformer idpinformers.OAuth2IdentityProviderInformer,
+	secretInformer corev1informers.SecretInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	log plog.Logger,
+	withInformer pinnipedcontroller.WithInformerOptionFunc,
+	clock clock.Clock,
+	dialFunc func(network, addr string, config *tls.Config) (*tls.Conn, error),
+	validatedCache *cache.Expiring,
+) controllerlib.Controller {
+	c := oauth2WatcherController{
+		namespace:                      namespace,
+		cache:                          idpCache,
+		client:                         client,
+		log:                            log.WithName(controllerName),
+		oauth2IdentityProviderInformer: oauth2IdentityProviderInformer,
+		secretInformer:                 secretInformer,
+		configMapInformer:              configMapInformer,
+		clock:                          clock,
+		dialFunc:                       dialFunc,
+		validatedCache:                 NewOAuth2ValidatedAPICache(validatedCache),
+	}
+
+	return controllerlib.New(
+		controllerlib.Config{Name: controllerName, Syncer: &c},
+		withInformer(
+			oauth2IdentityProviderInformer,
+			pinnipedcontroller.MatchAnythingFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			secretInformer,
+			pinnipedcontroller.MatchAnySecretOfTypesFilter(
+				[]corev1.SecretType{
+					oauth2ClientSecretType,
+					corev1.SecretTypeOpaque,
+					corev1.SecretTypeTLS,
+				},
+				pinnipedcontroller.SingletonQueue(),
+			),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			configMapInformer,
+			pinnipedcontroller.MatchAnythingFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+	)
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *oauth2WatcherController) Sync(ctx controllerlib.Context) error {
+	actualUpstreams, err := c.oauth2IdentityProviderInformer.Lister().List(labels.Everything())
+	if err != nil { // untested
+		return fmt.Errorf("failed to list OAuth2IdentityProviders: %w", err)
+	}
+
+	// Sort them by name just so that the logs output is consistent
+	slices.SortStableFunc(actualUpstreams, func(a, b *idpv1alpha1.OAuth2IdentityProvider) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	var applicationErrors []error
+	validatedUpstreams := make([]upstreamprovider.UpstreamOAuth2IdentityProviderI, 0, len(actualUpstreams))
+	for _, upstream := range actualUpstreams {
+		validatedUpstream, applicationErr := c.validateUpstreamAndUpdateConditions(ctx, upstream)
+		if applicationErr != nil {
+			applicationErrors = append(applicationErrors, applicationErr)
+		} else if validatedUpstream != nil {
+			validatedUpstreams = append(validatedUpstreams, validatedUpstream)
+		}
+		// Else:
+		// If both validatedUpstream and applicationErr are nil, this must be because the upstream had configuration errors.
+		// This controller should take no action until the user has reconfigured the upstream.
+	}
+	c.cache.SetOAuth2IdentityProviders(validatedUpstreams)
+
+	// If we have recoverable application errors, let's do a requeue and capture all the applicationErrors too
+	if len(applicationErrors) > 0 {
+		applicationErrors = append([]error{controllerlib.ErrSyntheticRequeue}, applicationErrors...)
+	}
+
+	return utilerrors.NewAggregate(applicationErrors)
+}
+
+func (c *oauth2WatcherController) validateClientSecret(secretName string) (*metav1.Condition, string, string, error) {
+	secret, unableToRetrieveSecretErr := c.secretInformer.Lister().Secrets(c.namespace).Get(secretName)
+
+	// This error requires user interaction, so ignore it.
+	if apierrors.IsNotFound(unableToRetrieveSecretErr) {
+		unableToRetrieveSecretErr = nil
+	}
+
+	buildFalseCondition := func(prefix string) (*metav1.Condition, string, string, error) {
+		return &metav1.Condition{
+			Type:   ClientCredentialsSecretValid,
+			Status: metav1.ConditionFalse,
+			Reason: upstreamwatchers.ReasonNotFound,
+			Message: fmt.Sprintf("%s: secret from spec.client.SecretName (%q) must be found in namespace %q with type %q and keys %q and %q",
+				prefix,
+				secretName,
+				c.namespace,
+				oauth2ClientSecretType,
+				clientIDDataKey,
+				clientSecretDataKey),
+		}, "", "", unableToRetrieveSecretErr
+	}
+
+	if unableToRetrieveSecretErr != nil || secret == nil {
+		return buildFalseCondition(fmt.Sprintf("secret %q not found", secretName))
+	}
+
+	if secret.Type != oauth2ClientSecretType {
+		return buildFalseCondition(fmt.Sprintf("wrong secret type %q", secret.Type))
+	}
+
+	clientID := string(secret.Data[clientIDDataKey])
+	if len(clientID) < 1 {
+		return buildFalseCondition(fmt.Sprintf("missing key %q", clientIDDataKey))
+	}
+
+	clientSecret := string(secret.Data[clientSecretDataKey])
+	if len(clientSecret) < 1 {
+		return buildFalseCondition(fmt.Sprintf("missing key %q", clientSecretDataKey))
+	}
+
+	if len(secret.Data) != 2 {
+		return buildFalseCondition("extra keys found")
+	}
+
+	return &metav1.Condition{
+		Type:    ClientCredentialsSecretValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  conditionsutil.ReasonSuccess,
+		Message: fmt.Sprintf("clientID and clientSecret have been read from spec.client.SecretName (%q)", secretName),
+	}, clientID, clientSecret, nil
+}
+
+func (c *oauth2WatcherController) validateUpstreamAndUpdateConditions(ctx controllerlib.Context, upstream *idpv1alpha1.OAuth2IdentityProvider) (
+	*upstreamoauth2.Provider, // If validated, returns the config
+	error, // This error will only refer to programmatic errors such as inability to perform a Dial or dereference a pointer, not configuration errors
+) {
+	conditions := make([]*metav1.Condition, 0)
+	applicationErrors := make([]error, 0)
+
+	clientSecretCondition, clientID, clientSecret, clientSecretErr := c.validateClientSecret(upstream.Spec.Client.SecretName)
+	conditions = append(conditions, clientSecretCondition)
+	if clientSecretErr != nil { // untested
+		applicationErrors = append(applicationErrors, clientSecretErr)
+	}
+
+	claimsCondition, usernameClaim, uidClaim, groupsClaim := validateClaims(&upstream.Spec.Claims)
+	conditions = append(conditions, claimsCondition)
+
+	endpointsCondition, apiHostPorts := validateEndpoints(&upstream.Spec)
+	conditions = append(conditions, endpointsCondition)
+
+	tlsConfigCondition, caBundle := tlsconfigutil.ValidateTLSConfig(
+		tlsconfigutil.TLSSpecForSupervisor(upstream.Spec.TLS),
+		"spec.tls",
+		c.namespace,
+		c.secretInformer,
+		c.configMapInformer)
+	conditions = append(conditions, tlsConfigCondition)
+
+	connectionCondition, httpClient, connectionErr := c.validateOAuth2Connection(
+		apiHostPorts,
+		caBundle,
+		endpointsCondition.Status == metav1.ConditionTrue,
+		tlsConfigCondition.Status == metav1.ConditionTrue,
+	)
+	if connectionErr != nil {
+		applicationErrors = append(applicationErrors, connectionErr)
+	}
+	conditions = append(conditions, connectionCondition)
+
+	// The critical pattern to maintain is that every run of the sync loop will populate the exact number of the exact
+	// same set of conditions.  Conditions depending on other conditions should get Status: metav1.ConditionUnknown, or
+	// Status: metav1.ConditionFalse, never be omitted.
+	if len(conditions) != countExpectedConditions { // untested since all code paths return the same number of conditions
+		applicationErrors = append(applicationErrors, fmt.Errorf("expected %d conditions but found %d conditions", countExpectedConditions, len(conditions)))
+		return nil, utilerrors.NewAggregate(applicationErrors)
+	}
+	hadErrorCondition, updateStatusErr := c.updateStatus(ctx.Context, upstream, conditions)
+	if updateStatusErr != nil {
+		applicationErrors = append(applicationErrors, updateStatusErr)
+	}
+	// Any error condition means we will not add the IDP to the cache, so just return nil here
+	if hadErrorCondition {
+		return nil, utilerrors.NewAggregate(applicationErrors)
+	}
+
+	provider := upstreamoauth2.New(
+		upstreamoauth2.ProviderConfig{
+			Name:          upstream.Name,
+			ResourceUID:   upstream.UID,
+			UserInfoURL:   upstream.Spec.UserInfo.UserEndpoint,
+			GroupsURL:     upstream.Spec.UserInfo.GroupsEndpoint,
+			UsernameClaim: usernameClaim,
+			UIDClaim:      uidClaim,
+			GroupsClaim:   groupsClaim,
+			OAuth2Config: &oauth2.Config{
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+				Endpoint: oauth2.Endpoint{
+					AuthURL:       upstream.Spec.AuthorizationEndpoint,
+					DeviceAuthURL: "", // we do not use device code flow
+					TokenURL:      upstream.Spec.TokenEndpoint,
+					// Let the oauth2 library auto-detect whether the token endpoint wants the client credentials
+					// in the Authorization header or in the request body, since this varies by provider.
+					AuthStyle: oauth2.AuthStyleAutoDetect,
+				},
+				RedirectURL: "", // this will be different for each FederationDomain, so we do not set it here
+				Scopes:      upstream.Spec.AuthorizationConfig.Scopes,
+			},
+			HttpClient: httpClient,
+		},
+	)
+	return provider, utilerrors.NewAggregate(applicationErrors)
+}
+
+func validateClaims(claims *idpv1alpha1.OAuth2Claims) (*metav1.Condition, *upstreamoauth2.ClaimMapping, *upstreamoauth2.ClaimMapping, *upstreamoauth2.ClaimMapping) {
+	var errs []string
+
+	usernameClaim, err := upstreamoauth2.CompileClaimMapping(claims.Username)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("spec.claims.username is invalid: %s", err.Error()))
+	}
+
+	uidClaim, err := upstreamoauth2.CompileClaimMapping(claims.UID)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("spec.claims.uid is invalid: %s", err.Error()))
+	}
+
+	var groupsClaim *upstreamoauth2.ClaimMapping
+	if claims.Groups != nil {
+		groupsClaim, err = upstreamoauth2.CompileClaimMapping(*claims.Groups)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("spec.claims.groups is invalid: %s", err.Error()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &metav1.Condition{
+			Type:    ClaimsValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonInvalid,
+			Message: strings.Join(errs, "\n\n"),
+		}, nil, nil, nil
+	}
+
+	return &metav1.Condition{
+		Type:    ClaimsValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  conditionsutil.ReasonSuccess,
+		Message: "spec.claims are valid",
+	}, usernameClaim, uidClaim, groupsClaim
+}
+
+// validateEndpoints validates all configured endpoint URLs, and returns the distinct host and port of each endpoint
+// which the Supervisor will call directly. The authorization endpoint is only used by the user's browser,
+// so it is not included in the returned list.
+func validateEndpoints(spec *idpv1alpha1.OAuth2IdentityProviderSpec) (*metav1.Condition, []*endpointaddr.HostPort) {
+	type endpoint struct {
+		field       string
+		value       string
+		calledByAPI bool
+	}
+	endpoints := []endpoint{
+		{field: "spec.authorizationEndpoint", value: spec.AuthorizationEndpoint},
+		{field: "spec.tokenEndpoint", value: spec.TokenEndpoint, calledByAPI: true},
+		{field: "spec.userInfo.userEndpoint", value: spec.UserInfo.UserEndpoint, calledByAPI: true},
+	}
+	if len(spec.UserInfo.GroupsEndpoint) > 0 {
+		endpoints = append(endpoints, endpoint{field: "spec.userInfo.groupsEndpoint", value: spec.UserInfo.GroupsEndpoint, calledByAPI: true})
+	}
+
+	var errs []string
+	var hostPorts []*endpointaddr.HostPort
+	for _, e := range endpoints {
+		hostPort, err := validateEndpointURL(e.value)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s (%q) is not valid: %s", e.field, e.value, err.Error()))
+			continue
+		}
+		if e.calledByAPI && !slices.ContainsFunc(hostPorts, func(hp *endpointaddr.HostPort) bool { return *hp == *hostPort }) {
+			hostPorts = append(hostPorts, hostPort)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &metav1.Condition{
+			Type:    EndpointsValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonInvalidEndpoint,
+			Message: strings.Join(errs, "\n\n"),
+		}, nil
+	}
+
+	return &metav1.Condition{
+		Type:    EndpointsValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  conditionsutil.ReasonSuccess,
+		Message: "spec endpoints are valid",
+	}, hostPorts
+}
+
+func validateEndpointURL(endpoint string) (*endpointaddr.HostPort, error) {
+	// Should not happen due to CRD validation
+	if len(endpoint) == 0 {
+		return nil, errors.New("must not be empty")
+	}
+
+	parsedURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if parsedURL.Scheme != "https" {
+		return nil, errors.New(`scheme must be "https"`)
+	}
+	if parsedURL.User != nil {
+		return nil, errors.New("must not contain username or password")
+	}
+	if len(parsedURL.RawQuery) > 0 || len(parsedURL.Fragment) > 0 {
+		return nil, errors.New("must not contain query or fragment")
+	}
+
+	hostPort, err := endpointaddr.Parse(parsedURL.Host, 443)
+	if err != nil {
+		return nil, err
+	}
+	return &hostPort, nil
+}
+
+func (c *oauth2WatcherController) validateOAuth2Connection(
+	apiHostPorts []*endpointaddr.HostPort,
+	caBundle *tlsconfigutil.CABundle,
+	endpointsConditionOk, tlsConfigConditionOk bool,
+) (*metav1.Condition, *http.Client, error) {
+	if !endpointsConditionOk || !tlsConfigConditionOk {
+		return &metav1.Condition{
+			Type:    OAuth2ConnectionValid,
+			Status:  metav1.ConditionUnknown,
+			Reason:  conditionsutil.ReasonUnableToValidate,
+			Message: conditionsutil.MessageUnableToValidate,
+		}, nil, nil
+	}
+
+	apiAddresses := make([]string, 0, len(apiHostPorts))
+	for _, apiHostPort := range apiHostPorts {
+		apiAddress := apiHostPort.Endpoint()
+		apiAddresses = append(apiAddresses, apiAddress)
+
+		if c.validatedCache.IsValid(apiAddress, caBundle.Hash()) {
+			continue
+		}
+
+		conn, tlsDialErr := c.dialFunc("tcp", apiAddress, ptls.Default(caBundle.CertPool()))
+		if tlsDialErr != nil {
+			return &metav1.Condition{
+				Type:    OAuth2ConnectionValid,
+				Status:  metav1.ConditionFalse,
+				Reason:  conditionsutil.ReasonUnableToDialServer,
+				Message: fmt.Sprintf("cannot dial %q: %s", apiAddress, buildDialErrorMessage(tlsDialErr)),
+			}, nil, tlsDialErr
+		}
+		// Any error should be ignored. We have performed a successful Dial, so no need to requeue this Sync.
+		_ = conn.Close()
+
+		c.validatedCache.MarkAsValidated(apiAddress, caBundle.Hash())
+	}
+
+	return &metav1.Condition{
+		Type:   OAuth2ConnectionValid,
+		Status: metav1.ConditionTrue,
+		Reason: conditionsutil.ReasonSuccess,
+		Message: fmt.Sprintf("dialed %s: hosts are reachable and TLS verification succeeds",
+			strings.Join(quoteAll(apiAddresses), ", ")),
+	}, phttp.Default(caBundle.CertPool()), nil
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return quoted
+}
+
+// buildDialErrorMessage standardizes DNS error messages that appear differently on different platforms, so that tests and log grepping is uniform.
+func buildDialErrorMessage(tlsDialErr error) string {
+	reason := tlsDialErr.Error()
+
+	var opError *net.OpError
+	var dnsError *net.DNSError
+	if errors.As(tlsDialErr, &opError) && errors.As(tlsDialErr, &dnsError) {
+		dnsError.Server = ""
+		opError.Err = dnsError
+		return opError.Error()
+	}
+
+	return reason
+}
+
+func (c *oauth2WatcherController) updateStatus(
+	ctx context.Context,
+	upstream *idpv1alpha1.OAuth2IdentityProvider,
+	conditions []*metav1.Condition) (bool, error) {
+	log := c.log.WithValues("namespace", upstream.Namespace, "name", upstream.Name)
+	updated := upstream.DeepCopy()
+
+	hadErrorCondition := conditionsutil.MergeConditions(
+		conditions,
+		&updated.Status.Conditions,
+		upstream.Generation,
+		metav1.NewTime(c.clock.Now()),
+		log,
+	)
+
+	updated.Status.Phase = idpv1alpha1.OAuth2PhaseReady
+	if hadErrorCondition {
+		updated.Status.Phase = idpv1alpha1.OAuth2PhaseError
+	}
+
+	if equality.Semantic.DeepEqual(upstream, updated) {
+		return hadErrorCondition, nil
+	}
+
+	log.Info("updating OAuth2IdentityProvider status", "phase", updated.Status.Phase)
+
+	_, updateStatusError := c.client.
+		IDPV1alpha1().
+		OAuth2IdentityProviders(upstream.Namespace).
+		UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	return hadErrorCondition, updateStatusError
+}
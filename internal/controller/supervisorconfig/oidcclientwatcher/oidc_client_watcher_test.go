@@ -246,6 +246,94 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 		}
 	}
 
+	happyAllowedIdentityProvidersCondition := func(time metav1.Time, observedGeneration int64) metav1.Condition {
+		return metav1.Condition{
+			Type:               "AllowedIdentityProvidersValid",
+			Status:             "True",
+			LastTransitionTime: time,
+			Reason:             "Success",
+			Message:            `"allowedIdentityProviders" is valid`,
+			ObservedGeneration: observedGeneration,
+		}
+	}
+
+	sadAllowedIdentityProvidersCondition := func(time metav1.Time, observedGeneration int64, message string) metav1.Condition {
+		return metav1.Condition{
+			Type:               "AllowedIdentityProvidersValid",
+			Status:             "False",
+			LastTransitionTime: time,
+			Reason:             "InvalidValue",
+			Message:            message,
+			ObservedGeneration: observedGeneration,
+		}
+	}
+
+	happyAdmissionPolicyCondition := func(time metav1.Time, observedGeneration int64) metav1.Condition {
+		return metav1.Condition{
+			Type:               "AdmissionPolicyValid",
+			Status:             "True",
+			LastTransitionTime: time,
+			Reason:             "Success",
+			Message:            `"admissionPolicy" is valid`,
+			ObservedGeneration: observedGeneration,
+		}
+	}
+
+	sadAdmissionPolicyCondition := func(time metav1.Time, observedGeneration int64, reason string, message string) metav1.Condition {
+		return metav1.Condition{
+			Type:               "AdmissionPolicyValid",
+			Status:             "False",
+			LastTransitionTime: time,
+			Reason:             reason,
+			Message:            message,
+			ObservedGeneration: observedGeneration,
+		}
+	}
+
+	happyRequestObjectsCondition := func(time metav1.Time, observedGeneration int64) metav1.Condition {
+		return metav1.Condition{
+			Type:               "RequestObjectsValid",
+			Status:             "True",
+			LastTransitionTime: time,
+			Reason:             "Success",
+			Message:            `"requestObjects" is valid`,
+			ObservedGeneration: observedGeneration,
+		}
+	}
+
+	sadRequestObjectsCondition := func(time metav1.Time, observedGeneration int64, message string) metav1.Condition {
+		return metav1.Condition{
+			Type:               "RequestObjectsValid",
+			Status:             "False",
+			LastTransitionTime: time,
+			Reason:             "InvalidValue",
+			Message:            message,
+			ObservedGeneration: observedGeneration,
+		}
+	}
+
+	happyTokenExchangeCondition := func(time metav1.Time, observedGeneration int64) metav1.Condition {
+		return metav1.Condition{
+			Type:               "TokenExchangeValid",
+			Status:             "True",
+			LastTransitionTime: time,
+			Reason:             "Success",
+			Message:            `"tokenExchange" is valid`,
+			ObservedGeneration: observedGeneration,
+		}
+	}
+
+	sadTokenExchangeCondition := func(time metav1.Time, observedGeneration int64, message string) metav1.Condition {
+		return metav1.Condition{
+			Type:               "TokenExchangeValid",
+			Status:             "False",
+			LastTransitionTime: time,
+			Reason:             "InvalidValue",
+			Message:            message,
+			ObservedGeneration: observedGeneration,
+		}
+	}
+
 	tests := []struct {
 		name                     string
 		inputObjects             []runtime.Object
@@ -293,9 +381,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 					Status: supervisorconfigv1alpha1.OIDCClientStatus{
 						Phase: "Ready",
 						Conditions: []metav1.Condition{
+							happyAdmissionPolicyCondition(now, 1234),
 							happyAllowedGrantTypesCondition(now, 1234),
+							happyAllowedIdentityProvidersCondition(now, 1234),
 							happyAllowedScopesCondition(now, 1234),
 							happyClientSecretsCondition(1, now, 1234),
+							happyRequestObjectsCondition(now, 1234),
+							happyTokenExchangeCondition(now, 1234),
 						},
 						TotalClientSecrets: 1,
 					},
@@ -318,9 +410,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						happyClientSecretsCondition(2, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 2,
 				},
@@ -337,9 +433,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(earlier, 1234),
 						happyAllowedGrantTypesCondition(earlier, 1234),
+						happyAllowedIdentityProvidersCondition(earlier, 1234),
 						happyAllowedScopesCondition(earlier, 1234),
 						happyClientSecretsCondition(1, earlier, 1234),
+						happyRequestObjectsCondition(earlier, 1234),
+						happyTokenExchangeCondition(earlier, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -351,9 +451,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(earlier, 1234),
 						happyAllowedGrantTypesCondition(earlier, 1234),
+						happyAllowedIdentityProvidersCondition(earlier, 1234),
 						happyAllowedScopesCondition(earlier, 1234),
 						happyClientSecretsCondition(1, earlier, 1234),
+						happyRequestObjectsCondition(earlier, 1234),
+						happyTokenExchangeCondition(earlier, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -371,9 +475,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						sadAllowedGrantTypesCondition(now, 1234, `"authorization_code" must always be included in "allowedGrantTypes"`),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						sadAllowedScopesCondition(now, 1234, `"openid" must always be included in "allowedScopes"`),
 						sadNoClientSecretsCondition(now, 1234, "no client secret found (no Secret storage found)"),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 				},
 			}},
@@ -394,9 +502,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						sadNoClientSecretsCondition(now, 1234, "error reading client secret storage: OIDC client secret storage data has wrong version: OIDC client secret storage has version wrong-version instead of 1"),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 				},
 			}},
@@ -417,9 +529,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						sadNoClientSecretsCondition(now, 1234, "no client secret found (empty list in storage)"),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 0,
 				},
@@ -444,12 +560,16 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						sadInvalidClientSecretsCondition(now, 1234,
 							"3 stored client secrets found, but some were invalid, so none will be used: "+
 								"hashed client secret at index 1: bcrypt cost 11 is below the required minimum of 12; "+
 								"hashed client secret at index 2: crypto/bcrypt: hashedSecret too short to be a bcrypted password"),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 0,
 				},
@@ -478,9 +598,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 					Status: supervisorconfigv1alpha1.OIDCClientStatus{
 						Phase: "Ready",
 						Conditions: []metav1.Condition{
+							happyAdmissionPolicyCondition(now, 1234),
 							happyAllowedGrantTypesCondition(now, 1234),
+							happyAllowedIdentityProvidersCondition(now, 1234),
 							happyAllowedScopesCondition(now, 1234),
 							happyClientSecretsCondition(1, now, 1234),
+							happyRequestObjectsCondition(now, 1234),
+							happyTokenExchangeCondition(now, 1234),
 						},
 						TotalClientSecrets: 1,
 					},
@@ -490,9 +614,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 					Status: supervisorconfigv1alpha1.OIDCClientStatus{
 						Phase: "Error",
 						Conditions: []metav1.Condition{
+							happyAdmissionPolicyCondition(now, 4567),
 							sadAllowedGrantTypesCondition(now, 4567, `"authorization_code" must always be included in "allowedGrantTypes"`),
+							happyAllowedIdentityProvidersCondition(now, 4567),
 							sadAllowedScopesCondition(now, 4567, `"openid" must always be included in "allowedScopes"`),
 							sadNoClientSecretsCondition(now, 4567, "no client secret found (no Secret storage found)"),
+							happyRequestObjectsCondition(now, 4567),
+							happyTokenExchangeCondition(now, 4567),
 						},
 						TotalClientSecrets: 0,
 					},
@@ -511,9 +639,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(earlier, 1234),
 						sadAllowedGrantTypesCondition(earlier, 1234, `"authorization_code" must always be included in "allowedGrantTypes"`),
+						happyAllowedIdentityProvidersCondition(earlier, 1234),
 						sadAllowedScopesCondition(earlier, 1234, `"openid" must always be included in "allowedScopes"`),
 						happyClientSecretsCondition(1, earlier, 1234),
+						happyRequestObjectsCondition(earlier, 1234),
+						happyTokenExchangeCondition(earlier, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -526,9 +658,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(earlier, 4567), // was already validated earlier
 						happyAllowedGrantTypesCondition(now, 4567),
+						happyAllowedIdentityProvidersCondition(earlier, 4567), // was already validated earlier
 						happyAllowedScopesCondition(now, 4567),
 						happyClientSecretsCondition(1, earlier, 4567), // was already validated earlier
+						happyRequestObjectsCondition(earlier, 4567),
+						happyTokenExchangeCondition(earlier, 4567),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -550,9 +686,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						sadAllowedGrantTypesCondition(now, 1234, `"refresh_token" must be included in "allowedGrantTypes" when "offline_access" is included in "allowedScopes"`),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -574,14 +714,18 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						sadAllowedGrantTypesCondition(now, 1234,
 							`"authorization_code" must always be included in "allowedGrantTypes"; `+
 								`"urn:ietf:params:oauth:grant-type:token-exchange" must be included in "allowedGrantTypes" when "pinniped:request-audience" is included in "allowedScopes"`),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						sadAllowedScopesCondition(now, 1234,
 							`"openid" must always be included in "allowedScopes"; `+
 								`"offline_access" must be included in "allowedScopes" when "refresh_token" is included in "allowedGrantTypes"; `+
 								`"username" and "groups" must be included in "allowedScopes" when "pinniped:request-audience" is included in "allowedScopes"`),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -603,13 +747,17 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						sadAllowedGrantTypesCondition(now, 1234,
 							`"authorization_code" must always be included in "allowedGrantTypes"; `+
 								`"refresh_token" must be included in "allowedGrantTypes" when "offline_access" is included in "allowedScopes"`),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						sadAllowedScopesCondition(now, 1234,
 							`"openid" must always be included in "allowedScopes"; `+
 								`"pinniped:request-audience" must be included in "allowedScopes" when "urn:ietf:params:oauth:grant-type:token-exchange" is included in "allowedGrantTypes"`),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -631,9 +779,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						sadAllowedGrantTypesCondition(now, 1234, `"urn:ietf:params:oauth:grant-type:token-exchange" must be included in "allowedGrantTypes" when "pinniped:request-audience" is included in "allowedScopes"`),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -655,9 +807,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						sadAllowedScopesCondition(now, 1234, `"offline_access" must be included in "allowedScopes" when "refresh_token" is included in "allowedGrantTypes"`),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -679,9 +835,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						sadAllowedScopesCondition(now, 1234, `"username" and "groups" must be included in "allowedScopes" when "pinniped:request-audience" is included in "allowedScopes"`),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -703,9 +863,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						sadAllowedScopesCondition(now, 1234, `"username" and "groups" must be included in "allowedScopes" when "pinniped:request-audience" is included in "allowedScopes"`),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -727,9 +891,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						sadAllowedScopesCondition(now, 1234, `"username" and "groups" must be included in "allowedScopes" when "pinniped:request-audience" is included in "allowedScopes"`),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -751,9 +919,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Error",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						sadAllowedScopesCondition(now, 1234, `"pinniped:request-audience" must be included in "allowedScopes" when "urn:ietf:params:oauth:grant-type:token-exchange" is included in "allowedGrantTypes"`),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -775,9 +947,381 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
+						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
+						happyAllowedScopesCondition(now, 1234),
+						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
+					},
+					TotalClientSecrets: 1,
+				},
+			}},
+		},
+		{
+			name: "successfully validate an OIDCClient with allowedIdentityProviders and an admissionPolicy",
+			inputObjects: []runtime.Object{&supervisorconfigv1alpha1.OIDCClient{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+					AllowedGrantTypes:        []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+					AllowedScopes:            []supervisorconfigv1alpha1.Scope{"openid"},
+					AllowedIdentityProviders: []string{"okta"},
+					AdmissionPolicy: &supervisorconfigv1alpha1.OIDCClientAdmissionPolicy{
+						AllowedUsernames: []string{"admin@example.com"},
+						AllowedGroups:    []string{"billing-users"},
+					},
+				},
+			}},
+			inputSecrets:   []runtime.Object{testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost})},
+			wantAPIActions: 1, // one update
+			wantResultingOIDCClients: []supervisorconfigv1alpha1.OIDCClient{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Status: supervisorconfigv1alpha1.OIDCClientStatus{
+					Phase: "Ready",
+					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
+						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
+						happyAllowedScopesCondition(now, 1234),
+						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
+					},
+					TotalClientSecrets: 1,
+				},
+			}},
+		},
+		{
+			name: "allowedIdentityProviders must not contain empty names",
+			inputObjects: []runtime.Object{&supervisorconfigv1alpha1.OIDCClient{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+					AllowedGrantTypes:        []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+					AllowedScopes:            []supervisorconfigv1alpha1.Scope{"openid"},
+					AllowedIdentityProviders: []string{"okta", ""},
+				},
+			}},
+			inputSecrets:   []runtime.Object{testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost})},
+			wantAPIActions: 1, // one update
+			wantResultingOIDCClients: []supervisorconfigv1alpha1.OIDCClient{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Status: supervisorconfigv1alpha1.OIDCClientStatus{
+					Phase: "Error",
+					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
+						happyAllowedGrantTypesCondition(now, 1234),
+						sadAllowedIdentityProvidersCondition(now, 1234, `"allowedIdentityProviders" must not contain empty names`),
+						happyAllowedScopesCondition(now, 1234),
+						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
+					},
+					TotalClientSecrets: 1,
+				},
+			}},
+		},
+		{
+			name: "successfully validate requestObjects with a JSON Web Key Set of public keys",
+			inputObjects: []runtime.Object{&supervisorconfigv1alpha1.OIDCClient{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+					AllowedGrantTypes: []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+					AllowedScopes:     []supervisorconfigv1alpha1.Scope{"openid"},
+					RequestObjects: &supervisorconfigv1alpha1.OIDCClientRequestObjects{
+						SigningAlgorithm: "ES256",
+						JWKS:             `{"keys":[{"use":"sig","kty":"EC","kid":"key-1","crv":"P-256","alg":"ES256","x":"-YM6MaaeLcKna0tz0qszVU_uiZSBz-9Jhue79TRNnxc","y":"YeIa02fZAML3KwbjSTZiiArrZfUElMgrORdysYCNmDI"}]}`,
+					},
+				},
+			}},
+			inputSecrets:   []runtime.Object{testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost})},
+			wantAPIActions: 1, // one update
+			wantResultingOIDCClients: []supervisorconfigv1alpha1.OIDCClient{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Status: supervisorconfigv1alpha1.OIDCClientStatus{
+					Phase: "Ready",
+					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
+						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
+						happyAllowedScopesCondition(now, 1234),
+						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
+					},
+					TotalClientSecrets: 1,
+				},
+			}},
+		},
+		{
+			name: "requestObjects must not contain private keys",
+			inputObjects: []runtime.Object{&supervisorconfigv1alpha1.OIDCClient{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+					AllowedGrantTypes: []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+					AllowedScopes:     []supervisorconfigv1alpha1.Scope{"openid"},
+					RequestObjects: &supervisorconfigv1alpha1.OIDCClientRequestObjects{
+						SigningAlgorithm: "ES256",
+						JWKS:             `{"keys":[{"use":"sig","kty":"EC","kid":"key-1","crv":"P-256","alg":"ES256","x":"-YM6MaaeLcKna0tz0qszVU_uiZSBz-9Jhue79TRNnxc","y":"YeIa02fZAML3KwbjSTZiiArrZfUElMgrORdysYCNmDI","d":"hGT4iW0t8eHxiMlJN9I7h1WwYlfLVwtFCn65ki499GU"}]}`,
+					},
+				},
+			}},
+			inputSecrets:   []runtime.Object{testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost})},
+			wantAPIActions: 1, // one update
+			wantResultingOIDCClients: []supervisorconfigv1alpha1.OIDCClient{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Status: supervisorconfigv1alpha1.OIDCClientStatus{
+					Phase: "Error",
+					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
+						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
+						happyAllowedScopesCondition(now, 1234),
+						happyClientSecretsCondition(1, now, 1234),
+						sadRequestObjectsCondition(now, 1234, `"requestObjects.jwks" is invalid: key 0 of JSON Web Key Set must be a public key`),
+						happyTokenExchangeCondition(now, 1234),
+					},
+					TotalClientSecrets: 1,
+				},
+			}},
+		},
+		{
+			name: "requestObjects must contain at least one key",
+			inputObjects: []runtime.Object{&supervisorconfigv1alpha1.OIDCClient{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+					AllowedGrantTypes: []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+					AllowedScopes:     []supervisorconfigv1alpha1.Scope{"openid"},
+					RequestObjects: &supervisorconfigv1alpha1.OIDCClientRequestObjects{
+						SigningAlgorithm: "ES256",
+						JWKS:             `{"keys":[]}`,
+					},
+				},
+			}},
+			inputSecrets:   []runtime.Object{testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost})},
+			wantAPIActions: 1, // one update
+			wantResultingOIDCClients: []supervisorconfigv1alpha1.OIDCClient{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Status: supervisorconfigv1alpha1.OIDCClientStatus{
+					Phase: "Error",
+					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
+						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
+						happyAllowedScopesCondition(now, 1234),
+						happyClientSecretsCondition(1, now, 1234),
+						sadRequestObjectsCondition(now, 1234, `"requestObjects.jwks" is invalid: JSON Web Key Set must contain at least one key`),
+						happyTokenExchangeCondition(now, 1234),
+					},
+					TotalClientSecrets: 1,
+				},
+			}},
+		},
+		{
+			name: "requestObjects must contain a JSON Web Key Set",
+			inputObjects: []runtime.Object{&supervisorconfigv1alpha1.OIDCClient{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+					AllowedGrantTypes: []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+					AllowedScopes:     []supervisorconfigv1alpha1.Scope{"openid"},
+					RequestObjects: &supervisorconfigv1alpha1.OIDCClientRequestObjects{
+						SigningAlgorithm: "ES256",
+						JWKS:             `not-json`,
+					},
+				},
+			}},
+			inputSecrets:   []runtime.Object{testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost})},
+			wantAPIActions: 1, // one update
+			wantResultingOIDCClients: []supervisorconfigv1alpha1.OIDCClient{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Status: supervisorconfigv1alpha1.OIDCClientStatus{
+					Phase: "Error",
+					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
+						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
+						happyAllowedScopesCondition(now, 1234),
+						happyClientSecretsCondition(1, now, 1234),
+						sadRequestObjectsCondition(now, 1234, `"requestObjects.jwks" is invalid: could not parse JSON Web Key Set: invalid character 'o' in literal null (expecting 'u')`),
+						happyTokenExchangeCondition(now, 1234),
+					},
+					TotalClientSecrets: 1,
+				},
+			}},
+		},
+		{
+			name: "successfully validate tokenExchange with allowed audiences and trusted issuers",
+			inputObjects: []runtime.Object{&supervisorconfigv1alpha1.OIDCClient{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+					AllowedGrantTypes: []supervisorconfigv1alpha1.GrantType{"authorization_code", "urn:ietf:params:oauth:grant-type:token-exchange"},
+					AllowedScopes:     []supervisorconfigv1alpha1.Scope{"openid", "pinniped:request-audience", "username", "groups"},
+					TokenExchange: &supervisorconfigv1alpha1.OIDCClientTokenExchange{
+						AllowedAudiences: []string{"*.clusters.example.com"},
+						TrustedIssuers: []supervisorconfigv1alpha1.OIDCClientTrustedIssuer{{
+							Issuer:   "https://ci.example.com",
+							Audience: "some-audience",
+							JWKS:     `{"keys":[{"use":"sig","kty":"EC","kid":"key-1","crv":"P-256","alg":"ES256","x":"-YM6MaaeLcKna0tz0qszVU_uiZSBz-9Jhue79TRNnxc","y":"YeIa02fZAML3KwbjSTZiiArrZfUElMgrORdysYCNmDI"}]}`,
+							Claims:   supervisorconfigv1alpha1.OIDCClientTrustedIssuerClaims{Username: "email"},
+						}},
+					},
+				},
+			}},
+			inputSecrets:   []runtime.Object{testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost})},
+			wantAPIActions: 1, // one update
+			wantResultingOIDCClients: []supervisorconfigv1alpha1.OIDCClient{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Status: supervisorconfigv1alpha1.OIDCClientStatus{
+					Phase: "Ready",
+					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
+						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
+						happyAllowedScopesCondition(now, 1234),
+						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
+					},
+					TotalClientSecrets: 1,
+				},
+			}},
+		},
+		{
+			name: "tokenExchange may only be specified when token exchange is an allowed grant type",
+			inputObjects: []runtime.Object{&supervisorconfigv1alpha1.OIDCClient{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+					AllowedGrantTypes: []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+					AllowedScopes:     []supervisorconfigv1alpha1.Scope{"openid"},
+					TokenExchange: &supervisorconfigv1alpha1.OIDCClientTokenExchange{
+						AllowedAudiences: []string{"some-cluster"},
+					},
+				},
+			}},
+			inputSecrets:   []runtime.Object{testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost})},
+			wantAPIActions: 1, // one update
+			wantResultingOIDCClients: []supervisorconfigv1alpha1.OIDCClient{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Status: supervisorconfigv1alpha1.OIDCClientStatus{
+					Phase: "Error",
+					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
+						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
+						happyAllowedScopesCondition(now, 1234),
+						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						sadTokenExchangeCondition(now, 1234, `"tokenExchange" may only be specified when "urn:ietf:params:oauth:grant-type:token-exchange" is included in "allowedGrantTypes"`),
+					},
+					TotalClientSecrets: 1,
+				},
+			}},
+		},
+		{
+			name: "tokenExchange must not contain empty audiences or invalid trusted issuer keys",
+			inputObjects: []runtime.Object{&supervisorconfigv1alpha1.OIDCClient{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+					AllowedGrantTypes: []supervisorconfigv1alpha1.GrantType{"authorization_code", "urn:ietf:params:oauth:grant-type:token-exchange"},
+					AllowedScopes:     []supervisorconfigv1alpha1.Scope{"openid", "pinniped:request-audience", "username", "groups"},
+					TokenExchange: &supervisorconfigv1alpha1.OIDCClientTokenExchange{
+						AllowedAudiences: []string{"some-cluster", ""},
+						TrustedIssuers: []supervisorconfigv1alpha1.OIDCClientTrustedIssuer{
+							{
+								Issuer:   "https://ci.example.com",
+								Audience: "some-audience",
+								JWKS:     `{"keys":[{"use":"sig","kty":"EC","kid":"key-1","crv":"P-256","alg":"ES256","x":"-YM6MaaeLcKna0tz0qszVU_uiZSBz-9Jhue79TRNnxc","y":"YeIa02fZAML3KwbjSTZiiArrZfUElMgrORdysYCNmDI"}]}`,
+								Claims:   supervisorconfigv1alpha1.OIDCClientTrustedIssuerClaims{Username: "email"},
+							},
+							{
+								Issuer:   "https://other-ci.example.com",
+								Audience: "some-audience",
+								JWKS:     `{"keys":[]}`,
+								Claims:   supervisorconfigv1alpha1.OIDCClientTrustedIssuerClaims{Username: "email"},
+							},
+						},
+					},
+				},
+			}},
+			inputSecrets:   []runtime.Object{testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost})},
+			wantAPIActions: 1, // one update
+			wantResultingOIDCClients: []supervisorconfigv1alpha1.OIDCClient{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Status: supervisorconfigv1alpha1.OIDCClientStatus{
+					Phase: "Error",
+					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
+						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
+						happyAllowedScopesCondition(now, 1234),
+						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						sadTokenExchangeCondition(now, 1234,
+							`"tokenExchange.allowedAudiences" must not contain empty audiences; `+
+								`"tokenExchange.trustedIssuers[1].jwks" is invalid: JSON Web Key Set must contain at least one key`),
+					},
+					TotalClientSecrets: 1,
+				},
+			}},
+		},
+		{
+			name: "admissionPolicy must list at least one username or group",
+			inputObjects: []runtime.Object{&supervisorconfigv1alpha1.OIDCClient{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+					AllowedGrantTypes: []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+					AllowedScopes:     []supervisorconfigv1alpha1.Scope{"openid"},
+					AdmissionPolicy:   &supervisorconfigv1alpha1.OIDCClientAdmissionPolicy{},
+				},
+			}},
+			inputSecrets:   []runtime.Object{testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost})},
+			wantAPIActions: 1, // one update
+			wantResultingOIDCClients: []supervisorconfigv1alpha1.OIDCClient{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Status: supervisorconfigv1alpha1.OIDCClientStatus{
+					Phase: "Error",
+					Conditions: []metav1.Condition{
+						sadAdmissionPolicyCondition(now, 1234, "MissingRequiredValue",
+							`"admissionPolicy" must list at least one of "allowedUsernames" or "allowedGroups"`),
+						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
+						happyAllowedScopesCondition(now, 1234),
+						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
+					},
+					TotalClientSecrets: 1,
+				},
+			}},
+		},
+		{
+			name: "admissionPolicy must not contain empty usernames or group names",
+			inputObjects: []runtime.Object{&supervisorconfigv1alpha1.OIDCClient{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+					AllowedGrantTypes: []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+					AllowedScopes:     []supervisorconfigv1alpha1.Scope{"openid"},
+					AdmissionPolicy: &supervisorconfigv1alpha1.OIDCClientAdmissionPolicy{
+						AllowedUsernames: []string{""},
+						AllowedGroups:    []string{"billing-users", ""},
+					},
+				},
+			}},
+			inputSecrets:   []runtime.Object{testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost})},
+			wantAPIActions: 1, // one update
+			wantResultingOIDCClients: []supervisorconfigv1alpha1.OIDCClient{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+				Status: supervisorconfigv1alpha1.OIDCClientStatus{
+					Phase: "Error",
+					Conditions: []metav1.Condition{
+						sadAdmissionPolicyCondition(now, 1234, "InvalidValue",
+							`"admissionPolicy.allowedUsernames" must not contain empty usernames; "admissionPolicy.allowedGroups" must not contain empty group names`),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -799,9 +1343,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -823,9 +1371,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -847,9 +1399,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -871,9 +1427,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -895,9 +1455,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -919,9 +1483,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -943,9 +1511,13 @@ func TestOIDCClientWatcherControllerSync(t *testing.T) {
 				Status: supervisorconfigv1alpha1.OIDCClientStatus{
 					Phase: "Ready",
 					Conditions: []metav1.Condition{
+						happyAdmissionPolicyCondition(now, 1234),
 						happyAllowedGrantTypesCondition(now, 1234),
+						happyAllowedIdentityProvidersCondition(now, 1234),
 						happyAllowedScopesCondition(now, 1234),
 						happyClientSecretsCondition(1, now, 1234),
+						happyRequestObjectsCondition(now, 1234),
+						happyTokenExchangeCondition(now, 1234),
 					},
 					TotalClientSecrets: 1,
 				},
@@ -5,7 +5,10 @@
 package oidcupstreamwatcher
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"net/http"
@@ -56,10 +59,11 @@ const (
 	typeAdditionalAuthorizeParametersValid = "AdditionalAuthorizeParametersValid"
 	typeOIDCDiscoverySucceeded             = "OIDCDiscoverySucceeded"
 
-	reasonUnreachable             = "Unreachable"
-	reasonInvalidResponse         = "InvalidResponse"
-	reasonDisallowedParameterName = "DisallowedParameterName"
-	allParamNamesAllowedMsg       = "additionalAuthorizeParameters parameter names are allowed"
+	reasonUnreachable                 = "Unreachable"
+	reasonInvalidResponse             = "InvalidResponse"
+	reasonDisallowedParameterName     = "DisallowedParameterName"
+	reasonInvalidClientAuthentication = "InvalidClientAuthentication"
+	allParamNamesAllowedMsg           = "additionalAuthorizeParameters parameter names are allowed"
 )
 
 var (
@@ -93,6 +97,8 @@ type UpstreamOIDCIdentityProviderICache interface {
 type oidcDiscoveryCacheKey struct {
 	issuer       string
 	caBundleHash tlsconfigutil.CABundleHash
+	// clientCertificateHash is the zero value when the http Client does not use a TLS client certificate.
+	clientCertificateHash [sha256.Size]byte
 }
 
 // oidcDiscoveryCacheValue is the type of cache entries in an oidcDiscoveryCache.
@@ -237,10 +243,9 @@ func (c *oidcWatcherController) validateUpstream(ctx controllerlib.Context, upst
 		ResourceUID:              upstream.UID,
 	}
 
-	conditions := []*metav1.Condition{
-		c.validateSecret(upstream, &result),
-	}
-	conditions = append(conditions, c.validateIssuer(ctx.Context, upstream, &result)...)
+	secretCondition, tlsClientCertificate := c.validateSecret(upstream, &result)
+	conditions := []*metav1.Condition{secretCondition}
+	conditions = append(conditions, c.validateIssuer(ctx.Context, upstream, tlsClientCertificate, &result)...)
 
 	if len(rejectedAuthcodeAuthorizeParameters) > 0 {
 		conditions = append(conditions, &metav1.Condition{
@@ -267,9 +272,16 @@ func (c *oidcWatcherController) validateUpstream(ctx controllerlib.Context, upst
 	return &result
 }
 
-// validateSecret validates the .spec.client.secretName field and returns the appropriate ClientCredentialsSecretValid condition.
-func (c *oidcWatcherController) validateSecret(upstream *idpv1alpha1.OIDCIdentityProvider, result *upstreamoidc.ProviderConfig) *metav1.Condition {
+// validateSecret validates the .spec.client.secretName field, and the .spec.client.certificateSecretName field
+// when required by the .spec.client.authenticationMethod, and returns the appropriate ClientCredentialsSecretValid
+// condition. When the authentication method is "tls_client_auth", it also returns the TLS client certificate.
+func (c *oidcWatcherController) validateSecret(upstream *idpv1alpha1.OIDCIdentityProvider, result *upstreamoidc.ProviderConfig) (*metav1.Condition, *tls.Certificate) {
 	secretName := upstream.Spec.Client.SecretName
+	authMethod := upstream.Spec.Client.AuthenticationMethod
+	if authMethod == "" {
+		authMethod = idpv1alpha1.OIDCClientAuthenticationMethodClientSecret
+	}
+	usesClientSecret := authMethod == idpv1alpha1.OIDCClientAuthenticationMethodClientSecret
 
 	// Fetch the Secret from informer cache.
 	secret, err := c.secretInformer.Lister().Secrets(upstream.Namespace).Get(secretName)
@@ -279,7 +291,7 @@ func (c *oidcWatcherController) validateSecret(upstream *idpv1alpha1.OIDCIdentit
 			Status:  metav1.ConditionFalse,
 			Reason:  upstreamwatchers.ReasonNotFound,
 			Message: err.Error(),
-		}
+		}, nil
 	}
 
 	// Validate the secret .type field.
@@ -289,34 +301,142 @@ func (c *oidcWatcherController) validateSecret(upstream *idpv1alpha1.OIDCIdentit
 			Status:  metav1.ConditionFalse,
 			Reason:  upstreamwatchers.ReasonWrongType,
 			Message: fmt.Sprintf("referenced Secret %q has wrong type %q (should be %q)", secretName, secret.Type, oidcClientSecretType),
-		}
+		}, nil
 	}
 
-	// Validate the secret .data field.
+	// Validate the secret .data field. The client secret is only required when it is used to authenticate.
 	clientID := secret.Data[clientIDDataKey]
 	clientSecret := secret.Data[clientSecretDataKey]
-	if len(clientID) == 0 || len(clientSecret) == 0 {
+	if usesClientSecret && (len(clientID) == 0 || len(clientSecret) == 0) {
 		return &metav1.Condition{
 			Type:    typeClientCredentialsSecretValid,
 			Status:  metav1.ConditionFalse,
 			Reason:  upstreamwatchers.ReasonMissingKeys,
 			Message: fmt.Sprintf("referenced Secret %q is missing required keys %q", secretName, []string{clientIDDataKey, clientSecretDataKey}),
+		}, nil
+	}
+	if len(clientID) == 0 {
+		return &metav1.Condition{
+			Type:    typeClientCredentialsSecretValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  upstreamwatchers.ReasonMissingKeys,
+			Message: fmt.Sprintf("referenced Secret %q is missing required keys %q", secretName, []string{clientIDDataKey}),
+		}, nil
+	}
+
+	var tlsClientCertificate *tls.Certificate
+	if usesClientSecret {
+		if upstream.Spec.Client.CertificateSecretName != "" {
+			return &metav1.Condition{
+				Type:   typeClientCredentialsSecretValid,
+				Status: metav1.ConditionFalse,
+				Reason: reasonInvalidClientAuthentication,
+				Message: fmt.Sprintf("spec.client.certificateSecretName is not allowed when spec.client.authenticationMethod is %q",
+					authMethod),
+			}, nil
+		}
+		result.Config.ClientSecret = string(clientSecret)
+	} else {
+		certificate, condition := c.validateCertificateSecret(upstream, authMethod)
+		if condition != nil {
+			return condition, nil
+		}
+		switch authMethod {
+		case idpv1alpha1.OIDCClientAuthenticationMethodPrivateKeyJWT:
+			signer, err := upstreamoidc.NewClientAssertionSigner(string(clientID), *certificate)
+			if err != nil {
+				return &metav1.Condition{
+					Type:   typeClientCredentialsSecretValid,
+					Status: metav1.ConditionFalse,
+					Reason: reasonInvalidClientAuthentication,
+					Message: fmt.Sprintf("referenced Secret %q cannot be used for %q client authentication: %s",
+						upstream.Spec.Client.CertificateSecretName, authMethod, err.Error()),
+				}, nil
+			}
+			result.ClientAssertionSigner = signer
+		case idpv1alpha1.OIDCClientAuthenticationMethodTLSClientAuth:
+			tlsClientCertificate = certificate
 		}
 	}
 
 	// If everything is valid, update the result and set the condition to true.
 	result.Config.ClientID = string(clientID)
-	result.Config.ClientSecret = string(clientSecret)
 	return &metav1.Condition{
 		Type:    typeClientCredentialsSecretValid,
 		Status:  metav1.ConditionTrue,
 		Reason:  conditionsutil.ReasonSuccess,
 		Message: "loaded client credentials",
+	}, tlsClientCertificate
+}
+
+// validateCertificateSecret validates the .spec.client.certificateSecretName field. It returns the certificate,
+// or a ClientCredentialsSecretValid condition when the certificate is not valid.
+func (c *oidcWatcherController) validateCertificateSecret(
+	upstream *idpv1alpha1.OIDCIdentityProvider,
+	authMethod idpv1alpha1.OIDCClientAuthenticationMethod,
+) (*tls.Certificate, *metav1.Condition) {
+	secretName := upstream.Spec.Client.CertificateSecretName
+	if secretName == "" {
+		return nil, &metav1.Condition{
+			Type:   typeClientCredentialsSecretValid,
+			Status: metav1.ConditionFalse,
+			Reason: reasonInvalidClientAuthentication,
+			Message: fmt.Sprintf("spec.client.certificateSecretName is required when spec.client.authenticationMethod is %q",
+				authMethod),
+		}
+	}
+
+	secret, err := c.secretInformer.Lister().Secrets(upstream.Namespace).Get(secretName)
+	if err != nil {
+		return nil, &metav1.Condition{
+			Type:    typeClientCredentialsSecretValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  upstreamwatchers.ReasonNotFound,
+			Message: err.Error(),
+		}
+	}
+
+	if secret.Type != corev1.SecretTypeTLS {
+		return nil, &metav1.Condition{
+			Type:    typeClientCredentialsSecretValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  upstreamwatchers.ReasonWrongType,
+			Message: fmt.Sprintf("referenced Secret %q has wrong type %q (should be %q)", secretName, secret.Type, corev1.SecretTypeTLS),
+		}
+	}
+
+	certPEM := secret.Data[corev1.TLSCertKey]
+	keyPEM := secret.Data[corev1.TLSPrivateKeyKey]
+	if len(certPEM) == 0 || len(keyPEM) == 0 {
+		return nil, &metav1.Condition{
+			Type:    typeClientCredentialsSecretValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  upstreamwatchers.ReasonMissingKeys,
+			Message: fmt.Sprintf("referenced Secret %q is missing required keys %q", secretName, []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey}),
+		}
 	}
+
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, &metav1.Condition{
+			Type:    typeClientCredentialsSecretValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonInvalidClientAuthentication,
+			Message: fmt.Sprintf("referenced Secret %q does not contain a valid certificate and private key: %s", secretName, err.Error()),
+		}
+	}
+
+	return &certificate, nil
 }
 
 // validateIssuer validates the .spec.issuer field, performs OIDC discovery, and returns the appropriate OIDCDiscoverySucceeded condition.
-func (c *oidcWatcherController) validateIssuer(ctx context.Context, upstream *idpv1alpha1.OIDCIdentityProvider, result *upstreamoidc.ProviderConfig) []*metav1.Condition {
+// When the tlsClientCertificate is not nil, then the resulting http Client presents it to the OIDC provider.
+func (c *oidcWatcherController) validateIssuer(
+	ctx context.Context,
+	upstream *idpv1alpha1.OIDCIdentityProvider,
+	tlsClientCertificate *tls.Certificate,
+	result *upstreamoidc.ProviderConfig,
+) []*metav1.Condition {
 	tlsCondition, caBundle := tlsconfigutil.ValidateTLSConfig(
 		tlsconfigutil.TLSSpecForSupervisor(upstream.Spec.TLS),
 		"spec.tls",
@@ -345,6 +465,9 @@ func (c *oidcWatcherController) validateIssuer(ctx context.Context, upstream *id
 		issuer:       upstream.Spec.Issuer,
 		caBundleHash: caBundle.Hash(),
 	}
+	if tlsClientCertificate != nil {
+		cacheKey.clientCertificateHash = sha256.Sum256(bytes.Join(tlsClientCertificate.Certificate, nil))
+	}
 	if cacheEntry := c.validatorCache.getProvider(cacheKey); cacheEntry != nil {
 		discoveredProvider = cacheEntry.provider
 		httpClient = cacheEntry.client
@@ -357,7 +480,7 @@ func (c *oidcWatcherController) validateIssuer(ctx context.Context, upstream *id
 
 	// If the provider does not exist in the cache, do a fresh discovery lookup and save to the cache.
 	if discoveredProvider == nil {
-		httpClient = defaultClientShortTimeout(caBundle.CertPool())
+		httpClient = defaultClientShortTimeout(caBundle.CertPool(), tlsClientCertificate)
 
 		_, issuerURLCondition := validateHTTPSURL(upstream.Spec.Issuer, "issuer", reasonUnreachable)
 		if issuerURLCondition != nil {
@@ -439,6 +562,11 @@ func (c *oidcWatcherController) validateIssuer(ctx context.Context, upstream *id
 
 	// If everything is valid, update the result and set the condition to true.
 	result.Config.Endpoint = discoveredProvider.Endpoint()
+	if result.Config.ClientSecret == "" {
+		// When there is no client secret, then the client authenticates in some other way, and the only client
+		// information which must be sent in the request is the client ID as a parameter.
+		result.Config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
 	result.Provider = discoveredProvider
 	result.Client = httpClient
 	return []*metav1.Condition{
@@ -488,8 +616,13 @@ func (c *oidcWatcherController) updateStatus(
 	return hadErrorCondition
 }
 
-func defaultClientShortTimeout(rootCAs *x509.CertPool) *http.Client {
-	c := phttp.Default(rootCAs)
+func defaultClientShortTimeout(rootCAs *x509.CertPool, clientCertificate *tls.Certificate) *http.Client {
+	var c *http.Client
+	if clientCertificate != nil {
+		c = phttp.DefaultWithClientCertificate(rootCAs, *clientCertificate)
+	} else {
+		c = phttp.Default(rootCAs)
+	}
 	c.Timeout = time.Minute
 	return c
 }
@@ -0,0 +1,435 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package samlupstreamwatcher implements a controller which watches SAMLIdentityProviders.
+package samlupstreamwatcher
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/cache"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/utils/clock"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	supervisorclientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned"
+	idpinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions/idp/v1alpha1"
+	pinnipedcontroller "go.pinniped.dev/internal/controller"
+	"go.pinniped.dev/internal/controller/conditionsutil"
+	"go.pinniped.dev/internal/controller/supervisorconfig/upstreamwatchers"
+	"go.pinniped.dev/internal/controller/tlsconfigutil"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/net/phttp"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/upstreamsaml"
+)
+
+const (
+	controllerName = "saml-upstream-observer"
+
+	countExpectedConditions = 3
+
+	MetadataValid         string = "MetadataValid"
+	TLSConfigurationValid string = "TLSConfigurationValid"
+	SigningSecretValid    string = "SigningSecretValid"
+
+	reasonInvalidMetadata = "InvalidMetadata"
+	reasonUnreachable     = "Unreachable"
+	reasonInvalidKey      = "InvalidKey"
+
+	// metadataCacheTTL is how long downloaded metadata is used before it is downloaded again,
+	// so that rotations of the identity provider's signing certificates are eventually noticed.
+	metadataCacheTTL = 15 * time.Minute
+
+	// metadataMaxBytes limits the size of a downloaded metadata document.
+	metadataMaxBytes = 10 * 1024 * 1024
+)
+
+// UpstreamSAMLIdentityProviderICache is a thread safe cache that holds a list of validated upstream SAML IDP configurations.
+type UpstreamSAMLIdentityProviderICache interface {
+	SetSAMLIdentityProviders([]upstreamprovider.UpstreamSAMLIdentityProviderI)
+}
+
+// metadataCacheKey is the cache key for downloaded metadata. The metadata is downloaded again when either
+// the URL or the CA bundle used to download it has changed.
+type metadataCacheKey struct {
+	url          string
+	caBundleHash tlsconfigutil.CABundleHash
+}
+
+type samlWatcherController struct {
+	namespace                    string
+	cache                        UpstreamSAMLIdentityProviderICache
+	log                          plog.Logger
+	client                       supervisorclientset.Interface
+	samlIdentityProviderInformer idpinformers.SAMLIdentityProviderInformer
+	secretInformer               corev1informers.SecretInformer
+	configMapInformer            corev1informers.ConfigMapInformer
+	clock                        clock.Clock
+	metadataCache                *cache.Expiring
+}
+
+// New instantiates a new controllerlib.Controller which will populate the provided UpstreamSAMLIdentityProviderICache.
+func New(
+	namespace string,
+	idpCache UpstreamSAMLIdentityProviderICache,
+	client supervisorclientset.Interface,
+	samlIdentityProviderInformer idpinformers.SAMLIdentityProviderInformer,
+	secretInformer corev1informers.SecretInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	log plog.Logger,
+	withInformer pinnipedcontroller.WithInformerOptionFunc,
+	clock clock.Clock,
+	metadataCache *cache.Expiring,
+) controllerlib.Controller {
+	c := samlWatcherController{
+		namespace:                    namespace,
+		cache:                        idpCache,
+		client:                       client,
+		log:                          log.WithName(controllerName),
+		samlIdentityProviderInformer: samlIdentityProviderInformer,
+		secretInformer:               secretInformer,
+		configMapInformer:            configMapInformer,
+		clock:                        clock,
+		metadataCache:                metadataCache,
+	}
+
+	return controllerlib.New(
+		controllerlib.Config{Name: controllerName, Syncer: &c},
+		withInformer(
+			samlIdentityProviderInformer,
+			pinnipedcontroller.MatchAnythingFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			secretInformer,
+			pinnipedcontroller.MatchAnySecretOfTypesFilter(
+				[]corev1.SecretType{
+					corev1.SecretTypeOpaque,
+					corev1.SecretTypeTLS,
+				},
+				pinnipedcontroller.SingletonQueue(),
+			),
+			controllerlib.InformerOption{},
+		),
+		withInformer(
+			configMapInformer,
+			pinnipedcontroller.MatchAnythingFilter(pinnipedcontroller.SingletonQueue()),
+			controllerlib.InformerOption{},
+		),
+	)
+}
+
+// Sync implements controllerlib.Syncer.
+func (c *samlWatcherController) Sync(ctx controllerlib.Context) error {
+	actualUpstreams, err := c.samlIdentityProviderInformer.Lister().List(labels.Everything())
+	if err != nil { // untested
+		return fmt.Errorf("failed to list SAMLIdentityProviders: %w", err)
+	}
+
+	// Sort them by name just so that the logs output is consistent
+	slices.SortStableFunc(actualUpstreams, func(a, b *idpv1alpha1.SAMLIdentityProvider) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	var applicationErrors []error
+	validatedUpstreams := make([]upstreamprovider.UpstreamSAMLIdentityProviderI, 0, len(actualUpstreams))
+	for _, upstream := range actualUpstreams {
+		validatedUpstream, applicationErr := c.validateUpstreamAndUpdateConditions(ctx, upstream)
+		if applicationErr != nil {
+			applicationErrors = append(applicationErrors, applicationErr)
+		} else if validatedUpstream != nil {
+			validatedUpstreams = append(validatedUpstreams, validatedUpstream)
+		}
+		// Else:
+		// If both validatedUpstream and applicationErr are nil, this must be because the upstream had configuration errors.
+		// This controller should take no action until the user has reconfigured the upstream.
+	}
+	c.cache.SetSAMLIdentityProviders(validatedUpstreams)
+
+	// If we have recoverable application errors, let's do a requeue and capture all the applicationErrors too
+	if len(applicationErrors) > 0 {
+		applicationErrors = append([]error{controllerlib.ErrSyntheticRequeue}, applicationErrors...)
+	}
+
+	return utilerrors.NewAggregate(applicationErrors)
+}
+
+func (c *samlWatcherController) validateUpstreamAndUpdateConditions(ctx controllerlib.Context, upstream *idpv1alpha1.SAMLIdentityProvider) (
+	*upstreamsaml.Provider, // If validated, returns the config
+	error, // This error will only refer to programmatic errors such as inability to download the metadata, not configuration errors
+) {
+	conditions := make([]*metav1.Condition, 0)
+	applicationErrors := make([]error, 0)
+
+	signingSecretCondition, key, certificate, signingSecretErr := c.validateSigningSecret(upstream.Spec.ServiceProvider.SigningSecretName)
+	conditions = append(conditions, signingSecretCondition)
+	if signingSecretErr != nil { // untested
+		applicationErrors = append(applicationErrors, signingSecretErr)
+	}
+
+	tlsConfigCondition, caBundle := tlsconfigutil.ValidateTLSConfig(
+		tlsconfigutil.TLSSpecForSupervisor(upstream.Spec.IDPMetadata.TLS),
+		"spec.idpMetadata.tls",
+		c.namespace,
+		c.secretInformer,
+		c.configMapInformer)
+	conditions = append(conditions, tlsConfigCondition)
+
+	metadataCondition, metadata, metadataErr := c.validateMetadata(
+		ctx.Context,
+		&upstream.Spec.IDPMetadata,
+		caBundle,
+		tlsConfigCondition.Status == metav1.ConditionTrue,
+	)
+	if metadataErr != nil {
+		applicationErrors = append(applicationErrors, metadataErr)
+	}
+	conditions = append(conditions, metadataCondition)
+
+	// The critical pattern to maintain is that every run of the sync loop will populate the exact number of the exact
+	// same set of conditions.  Conditions depending on other conditions should get Status: metav1.ConditionUnknown, or
+	// Status: metav1.ConditionFalse, never be omitted.
+	if len(conditions) != countExpectedConditions { // untested since all code paths return the same number of conditions
+		applicationErrors = append(applicationErrors, fmt.Errorf("expected %d conditions but found %d conditions", countExpectedConditions, len(conditions)))
+		return nil, utilerrors.NewAggregate(applicationErrors)
+	}
+	hadErrorCondition, updateStatusErr := c.updateStatus(ctx.Context, upstream, conditions)
+	if updateStatusErr != nil {
+		applicationErrors = append(applicationErrors, updateStatusErr)
+	}
+	// Any error condition means we will not add the IDP to the cache, so just return nil here
+	if hadErrorCondition {
+		return nil, utilerrors.NewAggregate(applicationErrors)
+	}
+
+	provider := upstreamsaml.New(
+		upstreamsaml.ProviderConfig{
+			Name:              upstream.Name,
+			ResourceUID:       upstream.UID,
+			IDPMetadata:       metadata,
+			EntityID:          upstream.Spec.ServiceProvider.EntityID,
+			UsernameAttribute: upstream.Spec.Claims.Username,
+			GroupsAttribute:   upstream.Spec.Claims.Groups,
+			Key:               key,
+			Certificate:       certificate,
+		},
+	)
+	return provider, utilerrors.NewAggregate(applicationErrors)
+}
+
+func (c *samlWatcherController) validateSigningSecret(secretName string) (*metav1.Condition, *rsa.PrivateKey, *x509.Certificate, error) {
+	secret, unableToRetrieveSecretErr := c.secretInformer.Lister().Secrets(c.namespace).Get(secretName)
+
+	// This error requires user interaction, so ignore it.
+	if apierrors.IsNotFound(unableToRetrieveSecretErr) {
+		unableToRetrieveSecretErr = nil
+	}
+
+	buildFalseCondition := func(reason, prefix string) (*metav1.Condition, *rsa.PrivateKey, *x509.Certificate, error) {
+		return &metav1.Condition{
+			Type:   SigningSecretValid,
+			Status: metav1.ConditionFalse,
+			Reason: reason,
+			Message: fmt.Sprintf("%s: secret from spec.serviceProvider.signingSecretName (%q) must be found in namespace %q with type %q and keys %q and %q",
+				prefix,
+				secretName,
+				c.namespace,
+				corev1.SecretTypeTLS,
+				corev1.TLSCertKey,
+				corev1.TLSPrivateKeyKey),
+		}, nil, nil, unableToRetrieveSecretErr
+	}
+
+	if unableToRetrieveSecretErr != nil || secret == nil {
+		return buildFalseCondition(upstreamwatchers.ReasonNotFound, fmt.Sprintf("secret %q not found", secretName))
+	}
+
+	if secret.Type != corev1.SecretTypeTLS {
+		return buildFalseCondition(upstreamwatchers.ReasonWrongType, fmt.Sprintf("wrong secret type %q", secret.Type))
+	}
+
+	keyPair, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return buildFalseCondition(reasonInvalidKey, fmt.Sprintf("could not load key pair: %s", err.Error()))
+	}
+
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return buildFalseCondition(reasonInvalidKey, fmt.Sprintf("key must be an RSA key, but was %T", keyPair.PrivateKey))
+	}
+
+	// X509KeyPair already parsed the leaf certificate successfully, so this should not fail.
+	certificate, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil { // untested
+		return buildFalseCondition(reasonInvalidKey, fmt.Sprintf("could not parse certificate: %s", err.Error()))
+	}
+
+	return &metav1.Condition{
+		Type:    SigningSecretValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  conditionsutil.ReasonSuccess,
+		Message: fmt.Sprintf("key and certificate have been read from spec.serviceProvider.signingSecretName (%q)", secretName),
+	}, key, certificate, nil
+}
+
+func (c *samlWatcherController) validateMetadata(
+	ctx context.Context,
+	spec *idpv1alpha1.SAMLIdentityProviderMetadataSpec,
+	caBundle *tlsconfigutil.CABundle,
+	tlsConfigConditionOk bool,
+) (*metav1.Condition, *saml.EntityDescriptor, error) {
+	buildFalseCondition := func(reason, message string) *metav1.Condition {
+		return &metav1.Condition{
+			Type:    MetadataValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  reason,
+			Message: message,
+		}
+	}
+
+	var source string
+	var metadata *saml.EntityDescriptor
+
+	switch {
+	case spec.XML != "" && spec.URL == "":
+		source = "spec.idpMetadata.xml"
+		var err error
+		metadata, err = upstreamsaml.ParseMetadata([]byte(spec.XML))
+		if err != nil {
+			return buildFalseCondition(reasonInvalidMetadata,
+				fmt.Sprintf("spec.idpMetadata.xml is not a valid SAML metadata document: %s", err.Error())), nil, nil
+		}
+
+	case spec.URL != "" && spec.XML == "":
+		source = fmt.Sprintf("spec.idpMetadata.url (%q)", spec.URL)
+		if !tlsConfigConditionOk {
+			return &metav1.Condition{
+				Type:    MetadataValid,
+				Status:  metav1.ConditionUnknown,
+				Reason:  conditionsutil.ReasonUnableToValidate,
+				Message: conditionsutil.MessageUnableToValidate,
+			}, nil, nil
+		}
+
+		key := metadataCacheKey{url: spec.URL, caBundleHash: caBundle.Hash()}
+		if cached, ok := c.metadataCache.Get(key); ok {
+			metadata = cached.(*saml.EntityDescriptor)
+			break
+		}
+
+		body, err := fetchMetadata(ctx, spec.URL, caBundle)
+		if err != nil {
+			// This might be a temporary network problem, so return the error to cause a retry.
+			return buildFalseCondition(reasonUnreachable,
+				fmt.Sprintf("failed to download metadata from %s: %s", source, pinnipedcontroller.TruncateMostLongErr(err))), nil, err
+		}
+
+		metadata, err = upstreamsaml.ParseMetadata(body)
+		if err != nil {
+			return buildFalseCondition(reasonInvalidMetadata,
+				fmt.Sprintf("metadata downloaded from %s is not a valid SAML metadata document: %s", source, err.Error())), nil, nil
+		}
+
+		c.metadataCache.Set(key, metadata, metadataCacheTTL)
+
+	default:
+		// Should not happen due to CRD validation.
+		return buildFalseCondition(reasonInvalidMetadata, "exactly one of spec.idpMetadata.url or spec.idpMetadata.xml must be specified"), nil, nil
+	}
+
+	if !hasRedirectBindingSSOService(metadata) {
+		return buildFalseCondition(reasonInvalidMetadata,
+			fmt.Sprintf("metadata from %s does not include a single sign-on service with the HTTP-Redirect binding", source)), nil, nil
+	}
+
+	return &metav1.Condition{
+		Type:    MetadataValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  conditionsutil.ReasonSuccess,
+		Message: fmt.Sprintf("metadata from %s is valid for identity provider entity ID %q", source, metadata.EntityID),
+	}, metadata, nil
+}
+
+func fetchMetadata(ctx context.Context, metadataURL string, caBundle *tlsconfigutil.CABundle) ([]byte, error) {
+	httpClient := phttp.Default(caBundle.CertPool())
+	httpClient.Timeout = time.Minute
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %q", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, metadataMaxBytes))
+}
+
+func hasRedirectBindingSSOService(metadata *saml.EntityDescriptor) bool {
+	for _, idpSSODescriptor := range metadata.IDPSSODescriptors {
+		for _, ssoService := range idpSSODescriptor.SingleSignOnServices {
+			if ssoService.Binding == saml.HTTPRedirectBinding {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *samlWatcherController) updateStatus(
+	ctx context.Context,
+	upstream *idpv1alpha1.SAMLIdentityProvider,
+	conditions []*metav1.Condition) (bool, error) {
+	log := c.log.WithValues("namespace", upstream.Namespace, "name", upstream.Name)
+	updated := upstream.DeepCopy()
+
+	hadErrorCondition := conditionsutil.MergeConditions(
+		conditions,
+		&updated.Status.Conditions,
+		upstream.Generation,
+		metav1.NewTime(c.clock.Now()),
+		log,
+	)
+
+	updated.Status.Phase = idpv1alpha1.SAMLPhaseReady
+	if hadErrorCondition {
+		updated.Status.Phase = idpv1alpha1.SAMLPhaseError
+	}
+
+	if equality.Semantic.DeepEqual(upstream, updated) {
+		return hadErrorCondition, nil
+	}
+
+	log.Info("updating SAMLIdentityProvider status", "phase", updated.Status.Phase)
+
+	_, updateStatusError := c.client.
+		IDPV1alpha1().
+		SAMLIdentityProviders(upstream.Namespace).
+		UpdateStatus(ctx, updated, metav1.UpdateOptions{})
+	return hadErrorCondition, updateStatusError
+}
@@ -0,0 +1,709 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package samlupstreamwatcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/cache"
+	k8sinformers "k8s.io/client-go/informers"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	coretesting "k8s.io/client-go/testing"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	supervisorfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	supervisorinformers "go.pinniped.dev/generated/latest/client/supervisor/informers/externalversions"
+	"go.pinniped.dev/internal/controller/conditionsutil"
+	"go.pinniped.dev/internal/controller/tlsconfigutil"
+	"go.pinniped.dev/internal/controllerlib"
+	"go.pinniped.dev/internal/federationdomain/dynamicupstreamprovider"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/testutil/samltestutil"
+	"go.pinniped.dev/internal/testutil/tlsserver"
+	"go.pinniped.dev/internal/upstreamsaml"
+)
+
+var (
+	samlIDPGVR = schema.GroupVersionResource{
+		Group:    idpv1alpha1.SchemeGroupVersion.Group,
+		Version:  idpv1alpha1.SchemeGroupVersion.Version,
+		Resource: "samlidentityproviders",
+	}
+
+	samlIDPKind = idpv1alpha1.SchemeGroupVersion.WithKind("SAMLIdentityProvider")
+)
+
+func TestController(t *testing.T) {
+	require.Equal(t, 3, countExpectedConditions)
+
+	namespace := "some-namespace"
+
+	testIDP := samltestutil.NewTestIDP(t)
+	goodMetadataXML := testIDP.MetadataXML()
+
+	metadataWithoutRedirectBinding := testIDP.Metadata()
+	for i := range metadataWithoutRedirectBinding.IDPSSODescriptors {
+		metadataWithoutRedirectBinding.IDPSSODescriptors[i].SingleSignOnServices = []saml.Endpoint{
+			{Binding: saml.HTTPPostBinding, Location: samltestutil.IDPSSOURL},
+		}
+	}
+	metadataWithoutRedirectBindingXML, err := xmlMarshal(metadataWithoutRedirectBinding)
+	require.NoError(t, err)
+
+	metadataServer, metadataServerCA := tlsserver.TestServerIPv4(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata":
+			_, _ = w.Write(goodMetadataXML)
+		case "/not-metadata":
+			_, _ = w.Write([]byte("this is not xml"))
+		default:
+			http.NotFound(w, r)
+		}
+	}), nil)
+	metadataServerCAB64 := base64.StdEncoding.EncodeToString(metadataServerCA)
+	goodMetadataURL := metadataServer.URL + "/metadata"
+
+	spKeyPair := samltestutil.NewKeyPair(t, "some-sp")
+
+	goodSigningSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "some-signing-secret", Namespace: namespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       spKeyPair.CertPEM,
+			corev1.TLSPrivateKeyKey: spKeyPair.KeyPEM,
+		},
+	}
+
+	wrongTypeSigningSecret := goodSigningSecret.DeepCopy()
+	wrongTypeSigningSecret.Type = corev1.SecretTypeOpaque
+
+	invalidSigningSecret := goodSigningSecret.DeepCopy()
+	invalidSigningSecret.Data[corev1.TLSPrivateKeyKey] = []byte("not a key")
+
+	ecdsaCertPEM, ecdsaKeyPEM := newECDSAKeyPair(t)
+	ecdsaSigningSecret := goodSigningSecret.DeepCopy()
+	ecdsaSigningSecret.Data = map[string][]byte{
+		corev1.TLSCertKey:       ecdsaCertPEM,
+		corev1.TLSPrivateKeyKey: ecdsaKeyPEM,
+	}
+
+	wantFrozenTime := time.Date(1122, time.September, 33, 4, 55, 56, 778899, time.Local)
+	frozenClockForLastTransitionTime := clocktesting.NewFakeClock(wantFrozenTime)
+	wantLastTransitionTime := metav1.Time{Time: wantFrozenTime}
+
+	validIDPWithInlineMetadata := &idpv1alpha1.SAMLIdentityProvider{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "some-idp-name",
+			Namespace:  namespace,
+			UID:        types.UID("some-resource-uid"),
+			Generation: 1234,
+		},
+		Spec: idpv1alpha1.SAMLIdentityProviderSpec{
+			IDPMetadata: idpv1alpha1.SAMLIdentityProviderMetadataSpec{
+				XML: string(goodMetadataXML),
+			},
+			Claims: idpv1alpha1.SAMLClaims{
+				Username: "uid",
+				Groups:   "eduPersonAffiliation",
+			},
+			ServiceProvider: idpv1alpha1.SAMLServiceProviderSpec{
+				EntityID:          "https://some-sp-entity-id",
+				SigningSecretName: goodSigningSecret.Name,
+			},
+		},
+	}
+
+	validIDPWithMetadataURL := validIDPWithInlineMetadata.DeepCopy()
+	validIDPWithMetadataURL.Spec.IDPMetadata = idpv1alpha1.SAMLIdentityProviderMetadataSpec{
+		URL: goodMetadataURL,
+		TLS: &idpv1alpha1.TLSSpec{CertificateAuthorityData: metadataServerCAB64},
+	}
+
+	buildSigningSecretValidTrue := func(t *testing.T) metav1.Condition {
+		t.Helper()
+		return metav1.Condition{
+			Type:               SigningSecretValid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: 1234,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             conditionsutil.ReasonSuccess,
+			Message:            `key and certificate have been read from spec.serviceProvider.signingSecretName ("some-signing-secret")`,
+		}
+	}
+
+	buildSigningSecretValidFalse := func(t *testing.T, reason, prefix string) metav1.Condition {
+		t.Helper()
+		return metav1.Condition{
+			Type:               SigningSecretValid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: 1234,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             reason,
+			Message: fmt.Sprintf(`%s: secret from spec.serviceProvider.signingSecretName ("some-signing-secret") `+
+				`must be found in namespace %q with type "kubernetes.io/tls" and keys "tls.crt" and "tls.key"`, prefix, namespace),
+		}
+	}
+
+	buildTLSConfigurationValidTrue := func(t *testing.T, message string) metav1.Condition {
+		t.Helper()
+		return metav1.Condition{
+			Type:               TLSConfigurationValid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: 1234,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             conditionsutil.ReasonSuccess,
+			Message:            "spec.idpMetadata.tls is valid: " + message,
+		}
+	}
+
+	buildMetadataValidTrue := func(t *testing.T, source string) metav1.Condition {
+		t.Helper()
+		return metav1.Condition{
+			Type:               MetadataValid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: 1234,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             conditionsutil.ReasonSuccess,
+			Message:            fmt.Sprintf("metadata from %s is valid for identity provider entity ID %q", source, samltestutil.IDPMetadataURL),
+		}
+	}
+
+	buildMetadataValidFalse := func(t *testing.T, reason, message string) metav1.Condition {
+		t.Helper()
+		return metav1.Condition{
+			Type:               MetadataValid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: 1234,
+			LastTransitionTime: wantLastTransitionTime,
+			Reason:             reason,
+			Message:            message,
+		}
+	}
+
+	withStatus := func(idp *idpv1alpha1.SAMLIdentityProvider, phase idpv1alpha1.SAMLIdentityProviderPhase, conditions ...metav1.Condition) idpv1alpha1.SAMLIdentityProvider {
+		updated := idp.DeepCopy()
+		updated.Status = idpv1alpha1.SAMLIdentityProviderStatus{Phase: phase, Conditions: conditions}
+		return *updated
+	}
+
+	withMetadataSpec := func(idp *idpv1alpha1.SAMLIdentityProvider, spec idpv1alpha1.SAMLIdentityProviderMetadataSpec) *idpv1alpha1.SAMLIdentityProvider {
+		updated := idp.DeepCopy()
+		updated.Spec.IDPMetadata = spec
+		return updated
+	}
+
+	tests := []struct {
+		name                     string
+		secrets                  []runtime.Object
+		samlIdentityProviders    []runtime.Object
+		preexistingMetadataCache map[metadataCacheKey]*saml.EntityDescriptor
+		wantErr                  string
+		wantResultingCache       []*upstreamsaml.ProviderConfig
+		wantResultingUpstreams   []idpv1alpha1.SAMLIdentityProvider
+		wantMetadataCacheEntries []metadataCacheKey
+	}{
+		{
+			name:                   "no SAMLIdentityProviders",
+			wantResultingCache:     []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{},
+		},
+		{
+			name:                  "happy path with inline metadata",
+			secrets:               []runtime.Object{goodSigningSecret},
+			samlIdentityProviders: []runtime.Object{validIDPWithInlineMetadata},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{
+				{
+					Name:              "some-idp-name",
+					ResourceUID:       "some-resource-uid",
+					EntityID:          "https://some-sp-entity-id",
+					UsernameAttribute: "uid",
+					GroupsAttribute:   "eduPersonAffiliation",
+					Key:               spKeyPair.Key,
+					Certificate:       spKeyPair.Certificate,
+				},
+			},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(validIDPWithInlineMetadata, idpv1alpha1.SAMLPhaseReady,
+					buildMetadataValidTrue(t, "spec.idpMetadata.xml"),
+					buildSigningSecretValidTrue(t),
+					buildTLSConfigurationValidTrue(t, "no TLS configuration provided: using default root CA bundle from container image"),
+				),
+			},
+		},
+		{
+			name:                  "happy path with metadata URL",
+			secrets:               []runtime.Object{goodSigningSecret},
+			samlIdentityProviders: []runtime.Object{validIDPWithMetadataURL},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{
+				{
+					Name:              "some-idp-name",
+					ResourceUID:       "some-resource-uid",
+					EntityID:          "https://some-sp-entity-id",
+					UsernameAttribute: "uid",
+					GroupsAttribute:   "eduPersonAffiliation",
+					Key:               spKeyPair.Key,
+					Certificate:       spKeyPair.Certificate,
+				},
+			},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(validIDPWithMetadataURL, idpv1alpha1.SAMLPhaseReady,
+					buildMetadataValidTrue(t, fmt.Sprintf("spec.idpMetadata.url (%q)", goodMetadataURL)),
+					buildSigningSecretValidTrue(t),
+					buildTLSConfigurationValidTrue(t, "using configured CA bundle"),
+				),
+			},
+			wantMetadataCacheEntries: []metadataCacheKey{
+				{url: goodMetadataURL, caBundleHash: tlsconfigutil.NewCABundleHash(metadataServerCA)},
+			},
+		},
+		{
+			name:                  "metadata URL which was already downloaded is read from the cache",
+			secrets:               []runtime.Object{goodSigningSecret},
+			samlIdentityProviders: []runtime.Object{withMetadataSpec(validIDPWithMetadataURL, idpv1alpha1.SAMLIdentityProviderMetadataSpec{URL: "https://unreachable.example.com/metadata"})},
+			preexistingMetadataCache: map[metadataCacheKey]*saml.EntityDescriptor{
+				{url: "https://unreachable.example.com/metadata", caBundleHash: tlsconfigutil.NewCABundleHash(nil)}: testIDP.Metadata(),
+			},
+			wantResultingCache: []*upstreamsaml.ProviderConfig{
+				{
+					Name:              "some-idp-name",
+					ResourceUID:       "some-resource-uid",
+					EntityID:          "https://some-sp-entity-id",
+					UsernameAttribute: "uid",
+					GroupsAttribute:   "eduPersonAffiliation",
+					Key:               spKeyPair.Key,
+					Certificate:       spKeyPair.Certificate,
+				},
+			},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(
+					withMetadataSpec(validIDPWithMetadataURL, idpv1alpha1.SAMLIdentityProviderMetadataSpec{URL: "https://unreachable.example.com/metadata"}),
+					idpv1alpha1.SAMLPhaseReady,
+					buildMetadataValidTrue(t, `spec.idpMetadata.url ("https://unreachable.example.com/metadata")`),
+					buildSigningSecretValidTrue(t),
+					buildTLSConfigurationValidTrue(t, "no TLS configuration provided: using default root CA bundle from container image"),
+				),
+			},
+			wantMetadataCacheEntries: []metadataCacheKey{
+				{url: "https://unreachable.example.com/metadata", caBundleHash: tlsconfigutil.NewCABundleHash(nil)},
+			},
+		},
+		{
+			name:                  "metadata URL returns an error response",
+			secrets:               []runtime.Object{goodSigningSecret},
+			samlIdentityProviders: []runtime.Object{withMetadataSpec(validIDPWithMetadataURL, idpv1alpha1.SAMLIdentityProviderMetadataSpec{URL: metadataServer.URL + "/not-found", TLS: &idpv1alpha1.TLSSpec{CertificateAuthorityData: metadataServerCAB64}})},
+			wantErr:               `unexpected response status "404 Not Found"`,
+			wantResultingCache:    []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(
+					withMetadataSpec(validIDPWithMetadataURL, idpv1alpha1.SAMLIdentityProviderMetadataSpec{URL: metadataServer.URL + "/not-found", TLS: &idpv1alpha1.TLSSpec{CertificateAuthorityData: metadataServerCAB64}}),
+					idpv1alpha1.SAMLPhaseError,
+					buildMetadataValidFalse(t, "Unreachable",
+						fmt.Sprintf(`failed to download metadata from spec.idpMetadata.url ("%s/not-found"): unexpected response status "404 Not Found"`, metadataServer.URL)),
+					buildSigningSecretValidTrue(t),
+					buildTLSConfigurationValidTrue(t, "using configured CA bundle"),
+				),
+			},
+		},
+		{
+			name:                  "metadata URL returns something which is not metadata",
+			secrets:               []runtime.Object{goodSigningSecret},
+			samlIdentityProviders: []runtime.Object{withMetadataSpec(validIDPWithMetadataURL, idpv1alpha1.SAMLIdentityProviderMetadataSpec{URL: metadataServer.URL + "/not-metadata", TLS: &idpv1alpha1.TLSSpec{CertificateAuthorityData: metadataServerCAB64}})},
+			wantResultingCache:    []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(
+					withMetadataSpec(validIDPWithMetadataURL, idpv1alpha1.SAMLIdentityProviderMetadataSpec{URL: metadataServer.URL + "/not-metadata", TLS: &idpv1alpha1.TLSSpec{CertificateAuthorityData: metadataServerCAB64}}),
+					idpv1alpha1.SAMLPhaseError,
+					buildMetadataValidFalse(t, "InvalidMetadata",
+						fmt.Sprintf(`metadata downloaded from spec.idpMetadata.url ("%s/not-metadata") is not a valid SAML metadata document: EOF`, metadataServer.URL)),
+					buildSigningSecretValidTrue(t),
+					buildTLSConfigurationValidTrue(t, "using configured CA bundle"),
+				),
+			},
+		},
+		{
+			name:                  "metadata URL with a CA bundle which does not trust the server",
+			secrets:               []runtime.Object{goodSigningSecret},
+			samlIdentityProviders: []runtime.Object{withMetadataSpec(validIDPWithMetadataURL, idpv1alpha1.SAMLIdentityProviderMetadataSpec{URL: goodMetadataURL})},
+			wantErr:               "tls: failed to verify certificate: x509: certificate signed by unknown authority",
+			wantResultingCache:    []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(
+					withMetadataSpec(validIDPWithMetadataURL, idpv1alpha1.SAMLIdentityProviderMetadataSpec{URL: goodMetadataURL}),
+					idpv1alpha1.SAMLPhaseError,
+					buildMetadataValidFalse(t, "Unreachable",
+						fmt.Sprintf(`failed to download metadata from spec.idpMetadata.url (%q): Get %q: tls: failed to verify certificate: x509: certificate signed by unknown authority`, goodMetadataURL, goodMetadataURL)),
+					buildSigningSecretValidTrue(t),
+					buildTLSConfigurationValidTrue(t, "no TLS configuration provided: using default root CA bundle from container image"),
+				),
+			},
+		},
+		{
+			name:                  "metadata URL with an invalid TLS configuration",
+			secrets:               []runtime.Object{goodSigningSecret},
+			samlIdentityProviders: []runtime.Object{withMetadataSpec(validIDPWithMetadataURL, idpv1alpha1.SAMLIdentityProviderMetadataSpec{URL: goodMetadataURL, TLS: &idpv1alpha1.TLSSpec{CertificateAuthorityData: "this is not base64"}})},
+			wantResultingCache:    []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(
+					withMetadataSpec(validIDPWithMetadataURL, idpv1alpha1.SAMLIdentityProviderMetadataSpec{URL: goodMetadataURL, TLS: &idpv1alpha1.TLSSpec{CertificateAuthorityData: "this is not base64"}}),
+					idpv1alpha1.SAMLPhaseError,
+					metav1.Condition{
+						Type:               MetadataValid,
+						Status:             metav1.ConditionUnknown,
+						ObservedGeneration: 1234,
+						LastTransitionTime: wantLastTransitionTime,
+						Reason:             conditionsutil.ReasonUnableToValidate,
+						Message:            conditionsutil.MessageUnableToValidate,
+					},
+					buildSigningSecretValidTrue(t),
+					metav1.Condition{
+						Type:               TLSConfigurationValid,
+						Status:             metav1.ConditionFalse,
+						ObservedGeneration: 1234,
+						LastTransitionTime: wantLastTransitionTime,
+						Reason:             tlsconfigutil.ReasonInvalidTLSConfig,
+						Message:            "spec.idpMetadata.tls.certificateAuthorityData is invalid: illegal base64 data at input byte 4",
+					},
+				),
+			},
+		},
+		{
+			name:                  "invalid inline metadata",
+			secrets:               []runtime.Object{goodSigningSecret},
+			samlIdentityProviders: []runtime.Object{withMetadataSpec(validIDPWithInlineMetadata, idpv1alpha1.SAMLIdentityProviderMetadataSpec{XML: "<EntityDescriptor></EntityDescriptor>"})},
+			wantResultingCache:    []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(
+					withMetadataSpec(validIDPWithInlineMetadata, idpv1alpha1.SAMLIdentityProviderMetadataSpec{XML: "<EntityDescriptor></EntityDescriptor>"}),
+					idpv1alpha1.SAMLPhaseError,
+					buildMetadataValidFalse(t, "InvalidMetadata",
+						"spec.idpMetadata.xml is not a valid SAML metadata document: expected element <EntityDescriptor> in name space urn:oasis:names:tc:SAML:2.0:metadata but have no name space"),
+					buildSigningSecretValidTrue(t),
+					buildTLSConfigurationValidTrue(t, "no TLS configuration provided: using default root CA bundle from container image"),
+				),
+			},
+		},
+		{
+			name:                  "inline metadata without an HTTP-Redirect binding",
+			secrets:               []runtime.Object{goodSigningSecret},
+			samlIdentityProviders: []runtime.Object{withMetadataSpec(validIDPWithInlineMetadata, idpv1alpha1.SAMLIdentityProviderMetadataSpec{XML: metadataWithoutRedirectBindingXML})},
+			wantResultingCache:    []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(
+					withMetadataSpec(validIDPWithInlineMetadata, idpv1alpha1.SAMLIdentityProviderMetadataSpec{XML: metadataWithoutRedirectBindingXML}),
+					idpv1alpha1.SAMLPhaseError,
+					buildMetadataValidFalse(t, "InvalidMetadata",
+						"metadata from spec.idpMetadata.xml does not include a single sign-on service with the HTTP-Redirect binding"),
+					buildSigningSecretValidTrue(t),
+					buildTLSConfigurationValidTrue(t, "no TLS configuration provided: using default root CA bundle from container image"),
+				),
+			},
+		},
+		{
+			name:                  "signing secret not found",
+			samlIdentityProviders: []runtime.Object{validIDPWithInlineMetadata},
+			wantResultingCache:    []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(validIDPWithInlineMetadata, idpv1alpha1.SAMLPhaseError,
+					buildMetadataValidTrue(t, "spec.idpMetadata.xml"),
+					buildSigningSecretValidFalse(t, "SecretNotFound", `secret "some-signing-secret" not found`),
+					buildTLSConfigurationValidTrue(t, "no TLS configuration provided: using default root CA bundle from container image"),
+				),
+			},
+		},
+		{
+			name:                  "signing secret has the wrong type",
+			secrets:               []runtime.Object{wrongTypeSigningSecret},
+			samlIdentityProviders: []runtime.Object{validIDPWithInlineMetadata},
+			wantResultingCache:    []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(validIDPWithInlineMetadata, idpv1alpha1.SAMLPhaseError,
+					buildMetadataValidTrue(t, "spec.idpMetadata.xml"),
+					buildSigningSecretValidFalse(t, "SecretWrongType", `wrong secret type "Opaque"`),
+					buildTLSConfigurationValidTrue(t, "no TLS configuration provided: using default root CA bundle from container image"),
+				),
+			},
+		},
+		{
+			name:                  "signing secret has an invalid key",
+			secrets:               []runtime.Object{invalidSigningSecret},
+			samlIdentityProviders: []runtime.Object{validIDPWithInlineMetadata},
+			wantResultingCache:    []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(validIDPWithInlineMetadata, idpv1alpha1.SAMLPhaseError,
+					buildMetadataValidTrue(t, "spec.idpMetadata.xml"),
+					buildSigningSecretValidFalse(t, "InvalidKey", "could not load key pair: tls: failed to find any PEM data in key input"),
+					buildTLSConfigurationValidTrue(t, "no TLS configuration provided: using default root CA bundle from container image"),
+				),
+			},
+		},
+		{
+			name:                  "signing secret has a key which is not an RSA key",
+			secrets:               []runtime.Object{ecdsaSigningSecret},
+			samlIdentityProviders: []runtime.Object{validIDPWithInlineMetadata},
+			wantResultingCache:    []*upstreamsaml.ProviderConfig{},
+			wantResultingUpstreams: []idpv1alpha1.SAMLIdentityProvider{
+				withStatus(validIDPWithInlineMetadata, idpv1alpha1.SAMLPhaseError,
+					buildMetadataValidTrue(t, "spec.idpMetadata.xml"),
+					buildSigningSecretValidFalse(t, "InvalidKey", "key must be an RSA key, but was *ecdsa.PrivateKey"),
+					buildTLSConfigurationValidTrue(t, "no TLS configuration provided: using default root CA bundle from container image"),
+				),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSupervisorClient := supervisorfake.NewSimpleClientset(tt.samlIdentityProviders...)
+			supervisorInformers := supervisorinformers.NewSharedInformerFactory(fakeSupervisorClient, 0)
+
+			fakeKubeClient := kubernetesfake.NewSimpleClientset(tt.secrets...)
+			kubeInformers := k8sinformers.NewSharedInformerFactoryWithOptions(fakeKubeClient, 0)
+
+			idpCache := dynamicupstreamprovider.NewDynamicUpstreamIDPProvider()
+			idpCache.SetSAMLIdentityProviders([]upstreamprovider.UpstreamSAMLIdentityProviderI{
+				upstreamsaml.New(upstreamsaml.ProviderConfig{Name: "initial-entry-to-remove"}),
+			})
+
+			var log bytes.Buffer
+			logger := plog.TestLogger(t, &log)
+
+			metadataCache := cache.NewExpiring()
+			for key, value := range tt.preexistingMetadataCache {
+				metadataCache.Set(key, value, time.Hour)
+			}
+
+			controller := New(
+				namespace,
+				idpCache,
+				fakeSupervisorClient,
+				supervisorInformers.IDP().V1alpha1().SAMLIdentityProviders(),
+				kubeInformers.Core().V1().Secrets(),
+				kubeInformers.Core().V1().ConfigMaps(),
+				logger,
+				controllerlib.WithInformer,
+				frozenClockForLastTransitionTime,
+				metadataCache,
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			supervisorInformers.Start(ctx.Done())
+			kubeInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, controller)
+
+			syncCtx := controllerlib.Context{Context: ctx, Key: controllerlib.Key{}}
+
+			if err := controllerlib.TestSync(t, controller, syncCtx); len(tt.wantErr) > 0 {
+				require.ErrorContains(t, err, controllerlib.ErrSyntheticRequeue.Error())
+				require.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			// Verify what's in the IDP cache
+			actualIDPList := idpCache.GetSAMLIdentityProviders()
+			require.Equal(t, len(tt.wantResultingCache), len(actualIDPList))
+			for i := range len(tt.wantResultingCache) {
+				actualProvider, ok := actualIDPList[i].(*upstreamsaml.Provider)
+				require.True(t, ok)
+				actualConfig := actualProvider.GetConfig()
+
+				require.Equal(t, tt.wantResultingCache[i].Name, actualConfig.Name)
+				require.Equal(t, tt.wantResultingCache[i].ResourceUID, actualConfig.ResourceUID)
+				require.Equal(t, tt.wantResultingCache[i].EntityID, actualConfig.EntityID)
+				require.Equal(t, tt.wantResultingCache[i].UsernameAttribute, actualConfig.UsernameAttribute)
+				require.Equal(t, tt.wantResultingCache[i].GroupsAttribute, actualConfig.GroupsAttribute)
+				require.True(t, tt.wantResultingCache[i].Key.Equal(actualConfig.Key))
+				require.True(t, tt.wantResultingCache[i].Certificate.Equal(actualConfig.Certificate))
+				require.Equal(t, samltestutil.IDPMetadataURL, actualProvider.GetIDPEntityID())
+			}
+
+			// Verify what's in the metadata cache
+			require.Equal(t, len(tt.wantMetadataCacheEntries), metadataCache.Len())
+			for _, key := range tt.wantMetadataCacheEntries {
+				_, ok := metadataCache.Get(key)
+				require.True(t, ok, "metadata for %q must be found in the metadata cache", key.url)
+			}
+
+			// Verify the status conditions as reported in Kubernetes
+			allSAMLIDPs, err := fakeSupervisorClient.IDPV1alpha1().SAMLIdentityProviders(namespace).List(ctx, metav1.ListOptions{})
+			require.NoError(t, err)
+
+			require.Equal(t, len(tt.wantResultingUpstreams), len(allSAMLIDPs.Items))
+			for i := range len(tt.wantResultingUpstreams) {
+				require.Len(t, tt.wantResultingUpstreams[i].Status.Conditions, countExpectedConditions)
+				require.Equal(t, tt.wantResultingUpstreams[i], allSAMLIDPs.Items[i])
+			}
+
+			// This needs to happen after the expected condition LastTransitionTime has been updated.
+			wantActions := make([]coretesting.Action, 3+len(tt.wantResultingUpstreams))
+			wantActions[0] = coretesting.NewListAction(samlIDPGVR, samlIDPKind, "", metav1.ListOptions{})
+			wantActions[1] = coretesting.NewWatchAction(samlIDPGVR, "", metav1.ListOptions{})
+			for i, want := range tt.wantResultingUpstreams {
+				wantActions[2+i] = coretesting.NewUpdateSubresourceAction(samlIDPGVR, "status", want.Namespace, ptr.To(want))
+			}
+			// This List action is coming from the test code when it pulls the SAMLIdentityProviders from K8s
+			wantActions[len(wantActions)-1] = coretesting.NewListAction(samlIDPGVR, samlIDPKind, namespace, metav1.ListOptions{})
+			require.Equal(t, wantActions, fakeSupervisorClient.Actions())
+		})
+	}
+}
+
+func TestController_OnlyWantActions(t *testing.T) {
+	namespace := "some-namespace"
+	oneHourAgo := metav1.Time{Time: time.Now().Add(-1 * time.Hour)}
+
+	alreadyInvalidExistingIDP := &idpv1alpha1.SAMLIdentityProvider{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "already-existing-invalid-idp-name",
+			Namespace:  namespace,
+			UID:        types.UID("some-resource-uid"),
+			Generation: 333,
+		},
+		Spec: idpv1alpha1.SAMLIdentityProviderSpec{
+			IDPMetadata: idpv1alpha1.SAMLIdentityProviderMetadataSpec{
+				XML: "<EntityDescriptor></EntityDescriptor>",
+			},
+			ServiceProvider: idpv1alpha1.SAMLServiceProviderSpec{
+				SigningSecretName: "unknown-secret",
+			},
+		},
+		Status: idpv1alpha1.SAMLIdentityProviderStatus{
+			Phase: idpv1alpha1.SAMLPhaseError,
+			Conditions: []metav1.Condition{
+				{
+					Type:               MetadataValid,
+					Status:             metav1.ConditionFalse,
+					ObservedGeneration: 333,
+					LastTransitionTime: oneHourAgo,
+					Reason:             "InvalidMetadata",
+					Message:            "spec.idpMetadata.xml is not a valid SAML metadata document: expected element <EntityDescriptor> in name space urn:oasis:names:tc:SAML:2.0:metadata but have no name space",
+				},
+				{
+					Type:               SigningSecretValid,
+					Status:             metav1.ConditionFalse,
+					ObservedGeneration: 333,
+					LastTransitionTime: oneHourAgo,
+					Reason:             "SecretNotFound",
+					Message: fmt.Sprintf(`secret "unknown-secret" not found: secret from spec.serviceProvider.signingSecretName ("unknown-secret") `+
+						`must be found in namespace %q with type "kubernetes.io/tls" and keys "tls.crt" and "tls.key"`, namespace),
+				},
+				{
+					Type:               TLSConfigurationValid,
+					Status:             metav1.ConditionTrue,
+					ObservedGeneration: 333,
+					LastTransitionTime: oneHourAgo,
+					Reason:             conditionsutil.ReasonSuccess,
+					Message:            "spec.idpMetadata.tls is valid: no TLS configuration provided: using default root CA bundle from container image",
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name                  string
+		samlIdentityProviders []runtime.Object
+		wantActions           []coretesting.Action
+	}{
+		{
+			name:        "no SAMLIdentityProviders",
+			wantActions: make([]coretesting.Action, 0),
+		},
+		{
+			name:                  "already existing idp with appropriate conditions does not issue actions",
+			samlIdentityProviders: []runtime.Object{alreadyInvalidExistingIDP},
+			wantActions:           make([]coretesting.Action, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSupervisorClient := supervisorfake.NewSimpleClientset(tt.samlIdentityProviders...)
+			supervisorInformers := supervisorinformers.NewSharedInformerFactory(fakeSupervisorClient, 0)
+			kubeInformers := k8sinformers.NewSharedInformerFactoryWithOptions(kubernetesfake.NewSimpleClientset(), 0)
+
+			controller := New(
+				namespace,
+				dynamicupstreamprovider.NewDynamicUpstreamIDPProvider(),
+				fakeSupervisorClient,
+				supervisorInformers.IDP().V1alpha1().SAMLIdentityProviders(),
+				kubeInformers.Core().V1().Secrets(),
+				kubeInformers.Core().V1().ConfigMaps(),
+				plog.New(),
+				controllerlib.WithInformer,
+				clocktesting.NewFakeClock(time.Now()),
+				cache.NewExpiring(),
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			supervisorInformers.Start(ctx.Done())
+			kubeInformers.Start(ctx.Done())
+			controllerlib.TestRunSynchronously(t, controller)
+
+			// The informers' own list and watch actions are not interesting for this test.
+			fakeSupervisorClient.ClearActions()
+
+			syncCtx := controllerlib.Context{Context: ctx, Key: controllerlib.Key{}}
+			require.NoError(t, controllerlib.TestSync(t, controller, syncCtx))
+			require.Equal(t, tt.wantActions, fakeSupervisorClient.Actions())
+		})
+	}
+}
+
+func xmlMarshal(metadata *saml.EntityDescriptor) (string, error) {
+	b, err := xml.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func newECDSAKeyPair(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "some-ecdsa-sp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
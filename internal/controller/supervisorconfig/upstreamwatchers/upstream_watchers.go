@@ -5,11 +5,17 @@ package upstreamwatchers
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 
 	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
@@ -21,18 +27,26 @@ import (
 )
 
 const (
-	ReasonNotFound    = "SecretNotFound"
-	ReasonWrongType   = "SecretWrongType"
-	ReasonMissingKeys = "SecretMissingKeys"
+	ReasonNotFound           = "SecretNotFound"
+	ReasonWrongType          = "SecretWrongType"
+	ReasonMissingKeys        = "SecretMissingKeys"
+	ReasonInvalidCertificate = "SecretInvalidCertificate"
 
 	LDAPBindAccountSecretType = corev1.SecretTypeBasicAuth
-	probeLDAPTimeout          = 90 * time.Second
+
+	// Keys of the bind Secret for the GSSAPI bind method, which is of type Opaque.
+	GSSAPIBindSecretUsernameKey   = "username"
+	GSSAPIBindSecretKeytabKey     = "keytab"
+	GSSAPIBindSecretKrb5ConfigKey = "krb5.conf"
+
+	probeLDAPTimeout = 90 * time.Second
 
 	// Constants related to conditions.
-	typeBindSecretValid       = "BindSecretValid"
-	typeLDAPConnectionValid   = "LDAPConnectionValid"
-	TypeSearchBaseFound       = "SearchBaseFound"
-	reasonLDAPConnectionError = "LDAPConnectionError"
+	typeBindSecretValid        = "BindSecretValid"
+	typeLDAPConnectionValid    = "LDAPConnectionValid"
+	TypeSearchBaseFound        = "SearchBaseFound"
+	reasonLDAPConnectionError  = "LDAPConnectionError"
+	reasonSomeHostsUnreachable = "SomeLDAPHostsUnreachable"
 
 	ReasonUsingConfigurationFromSpec = "UsingConfigurationFromSpec"
 	ReasonErrorFetchingSearchBase    = "ErrorFetchingSearchBase"
@@ -93,6 +107,36 @@ func (s *ValidatedSettingsCache) Set(upstreamName string, settings ValidatedSett
 	s.ValidatedSettingsByName[upstreamName] = settings
 }
 
+// ConnectionPools keeps an LDAP connection pool for each upstream, so the pooled connections can still be used
+// after the upstream's provider is replaced during a future Sync. It is not safe for concurrent use.
+type ConnectionPools struct {
+	poolsByUID map[types.UID]*upstreamldap.ConnectionPool
+}
+
+func NewConnectionPools() *ConnectionPools {
+	return &ConnectionPools{poolsByUID: map[types.UID]*upstreamldap.ConnectionPool{}}
+}
+
+// PoolFor returns the pool for the upstream, creating it when needed.
+func (c *ConnectionPools) PoolFor(uid types.UID) *upstreamldap.ConnectionPool {
+	pool, found := c.poolsByUID[uid]
+	if !found {
+		pool = upstreamldap.NewConnectionPool(upstreamldap.DefaultConnectionPoolSize)
+		c.poolsByUID[uid] = pool
+	}
+	return pool
+}
+
+// CloseAllExcept closes and forgets the pools of the upstreams which no longer exist.
+func (c *ConnectionPools) CloseAllExcept(uids sets.Set[types.UID]) {
+	for uid, pool := range c.poolsByUID {
+		if !uids.Has(uid) {
+			pool.Close()
+			delete(c.poolsByUID, uid)
+		}
+	}
+}
+
 // UpstreamGenericLDAPIDP is a read-only interface for abstracting the differences between LDAP and Active Directory IDP types.
 type UpstreamGenericLDAPIDP interface {
 	Spec() UpstreamGenericLDAPSpec
@@ -129,6 +173,9 @@ type UpstreamGenericLDAPStatus interface {
 	Conditions() []metav1.Condition
 }
 
+// TestConnection tests the connection to each of the hosts of the provider, first using TLS, and then using StartTLS
+// when TLS fails for every host. The resulting condition is successful when at least one host works, and its message
+// describes each host which does not work.
 func TestConnection(
 	ctx context.Context,
 	bindSecretName string,
@@ -137,19 +184,18 @@ func TestConnection(
 ) *metav1.Condition {
 	// First try using TLS.
 	config.ConnectionProtocol = upstreamldap.TLS
-	tlsLDAPProvider := upstreamldap.New(*config)
-	err := tlsLDAPProvider.TestConnection(ctx)
+	results, err := testConnectionToEachHost(ctx, config)
 	if err != nil {
 		plog.InfoErr("testing LDAP connection using TLS failed, so trying again with StartTLS", err, "host", config.Host)
 		// If there was any error, try again with StartTLS instead.
 		config.ConnectionProtocol = upstreamldap.StartTLS
-		startTLSLDAPProvider := upstreamldap.New(*config)
-		startTLSErr := startTLSLDAPProvider.TestConnection(ctx)
+		startTLSResults, startTLSErr := testConnectionToEachHost(ctx, config)
 		if startTLSErr == nil {
 			plog.Info("testing LDAP connection using StartTLS succeeded", "host", config.Host)
 			// Successfully able to fall back to using StartTLS, so clear the original
 			// error and consider the connection test to be successful.
 			err = nil
+			results = startTLSResults
 		} else {
 			plog.InfoErr("testing LDAP connection using StartTLS also failed", err, "host", config.Host)
 			// Falling back to StartTLS also failed, so put TLS back into the config
@@ -158,23 +204,81 @@ func TestConnection(
 		}
 	}
 
+	multipleHosts := len(config.AdditionalHosts) > 0 || config.SRVRecordName != ""
+
 	if err != nil {
+		hosts := fmt.Sprintf(`"%s"`, config.Host)
+		if multipleHosts {
+			hosts = "any of the hosts"
+		}
 		return &metav1.Condition{
 			Type:   typeLDAPConnectionValid,
 			Status: metav1.ConditionFalse,
 			Reason: reasonLDAPConnectionError,
-			Message: fmt.Sprintf(`could not successfully connect to "%s" and bind as user "%s": %s`,
-				config.Host, config.BindUsername, err.Error()),
+			Message: fmt.Sprintf(`could not successfully connect to %s and bind as user "%s": %s`,
+				hosts, config.BindUsername, err.Error()),
+		}
+	}
+
+	if !multipleHosts {
+		return &metav1.Condition{
+			Type:   typeLDAPConnectionValid,
+			Status: metav1.ConditionTrue,
+			Reason: conditionsutil.ReasonSuccess,
+			Message: fmt.Sprintf(`successfully able to connect to "%s" and bind as user "%s" [validated with Secret "%s" at version "%s"]`,
+				config.Host, config.BindUsername, bindSecretName, currentSecretVersion),
+		}
+	}
+
+	var reachable []string
+	var unreachable []string
+	for _, result := range results {
+		if result.Err != nil {
+			unreachable = append(unreachable, result.Err.Error())
+			continue
+		}
+		reachable = append(reachable, fmt.Sprintf("%q", result.Host))
+	}
+
+	message := fmt.Sprintf(`successfully able to connect to %s and bind as user "%s" [validated with Secret "%s" at version "%s"]`,
+		strings.Join(reachable, ", "), config.BindUsername, bindSecretName, currentSecretVersion)
+	if len(unreachable) == 0 {
+		return &metav1.Condition{
+			Type:    typeLDAPConnectionValid,
+			Status:  metav1.ConditionTrue,
+			Reason:  conditionsutil.ReasonSuccess,
+			Message: message,
 		}
 	}
 
+	// At least one host works, so the provider can still be used, but show which hosts do not work.
 	return &metav1.Condition{
-		Type:   typeLDAPConnectionValid,
-		Status: metav1.ConditionTrue,
-		Reason: conditionsutil.ReasonSuccess,
-		Message: fmt.Sprintf(`successfully able to connect to "%s" and bind as user "%s" [validated with Secret "%s" at version "%s"]`,
-			config.Host, config.BindUsername, bindSecretName, currentSecretVersion),
+		Type:    typeLDAPConnectionValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  reasonSomeHostsUnreachable,
+		Message: fmt.Sprintf("%s, but other hosts failed: %s", message, strings.Join(unreachable, "; ")),
+	}
+}
+
+// testConnectionToEachHost returns the result of testing each of the hosts of the provider, and returns an error when
+// none of the hosts work, or when the list of hosts could not be fully determined.
+func testConnectionToEachHost(ctx context.Context, config *upstreamldap.ProviderConfig) ([]upstreamldap.HostConnectionResult, error) {
+	results, err := upstreamldap.New(*config).TestConnectionToEachHost(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, 0, len(results))
+	for _, result := range results {
+		if result.Err == nil {
+			return results, nil
+		}
+		errs = append(errs, result.Err)
 	}
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, errors.Join(errs...)
 }
 
 func ValidateSecret(secretInformer corev1informers.SecretInformer, secretName string, secretNamespace string, config *upstreamldap.ProviderConfig) (*metav1.Condition, string) {
@@ -188,26 +292,72 @@ func ValidateSecret(secretInformer corev1informers.SecretInformer, secretName st
 		}, ""
 	}
 
-	if secret.Type != corev1.SecretTypeBasicAuth {
+	var wantType corev1.SecretType
+	var requiredKeys []string
+	switch config.BindMethod {
+	case upstreamldap.GSSAPIBind:
+		wantType = corev1.SecretTypeOpaque
+		requiredKeys = []string{GSSAPIBindSecretUsernameKey, GSSAPIBindSecretKeytabKey, GSSAPIBindSecretKrb5ConfigKey}
+	case upstreamldap.ExternalBind:
+		wantType = corev1.SecretTypeTLS
+		requiredKeys = []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey}
+	case upstreamldap.SimpleBind, "":
+		fallthrough
+	default:
+		wantType = LDAPBindAccountSecretType
+		requiredKeys = []string{corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey}
+	}
+
+	if secret.Type != wantType {
 		return &metav1.Condition{
 			Type:   typeBindSecretValid,
 			Status: metav1.ConditionFalse,
 			Reason: ReasonWrongType,
 			Message: fmt.Sprintf("referenced Secret %q has wrong type %q (should be %q)",
-				secretName, secret.Type, corev1.SecretTypeBasicAuth),
+				secretName, secret.Type, wantType),
 		}, secret.ResourceVersion
 	}
 
-	config.BindUsername = string(secret.Data[corev1.BasicAuthUsernameKey])
-	config.BindPassword = string(secret.Data[corev1.BasicAuthPasswordKey])
-	if len(config.BindUsername) == 0 || len(config.BindPassword) == 0 {
-		return &metav1.Condition{
-			Type:   typeBindSecretValid,
-			Status: metav1.ConditionFalse,
-			Reason: ReasonMissingKeys,
-			Message: fmt.Sprintf("referenced Secret %q is missing required keys %q",
-				secretName, []string{corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey}),
-		}, secret.ResourceVersion
+	for _, key := range requiredKeys {
+		if len(secret.Data[key]) == 0 {
+			return &metav1.Condition{
+				Type:   typeBindSecretValid,
+				Status: metav1.ConditionFalse,
+				Reason: ReasonMissingKeys,
+				Message: fmt.Sprintf("referenced Secret %q is missing required keys %q",
+					secretName, requiredKeys),
+			}, secret.ResourceVersion
+		}
+	}
+
+	switch config.BindMethod {
+	case upstreamldap.GSSAPIBind:
+		config.BindUsername = string(secret.Data[GSSAPIBindSecretUsernameKey])
+		config.GSSAPI.Keytab = secret.Data[GSSAPIBindSecretKeytabKey]
+		config.GSSAPI.Krb5Config = string(secret.Data[GSSAPIBindSecretKrb5ConfigKey])
+	case upstreamldap.ExternalBind:
+		cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		var leaf *x509.Certificate
+		if err == nil {
+			leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		}
+		if err != nil {
+			return &metav1.Condition{
+				Type:    typeBindSecretValid,
+				Status:  metav1.ConditionFalse,
+				Reason:  ReasonInvalidCertificate,
+				Message: fmt.Sprintf("referenced Secret %q has an invalid client certificate: %s", secretName, err.Error()),
+			}, secret.ResourceVersion
+		}
+		// The server identifies the bind account using the certificate, so describe the account by its subject.
+		config.BindUsername = leaf.Subject.String()
+		config.BindClientCertificate = secret.Data[corev1.TLSCertKey]
+		config.BindClientKey = secret.Data[corev1.TLSPrivateKeyKey]
+	case upstreamldap.SimpleBind, "":
+		fallthrough
+	default:
+		config.BindUsername = string(secret.Data[corev1.BasicAuthUsernameKey])
+		config.BindPassword = string(secret.Data[corev1.BasicAuthPasswordKey])
 	}
 
 	return &metav1.Condition{
@@ -315,7 +465,9 @@ func validateAndSetLDAPServerConnectivityAndSearchBase(
 		// When there were no failures, write the newly validated settings to the cache.
 		// It's okay for the search base condition to be nil, since it's only used by Active Directory providers,
 		// but if it exists make sure it was not a failure.
+		// Do not cache when some hosts did not work, so they will be tested again during the next sync.
 		if ldapConnectionValidCondition.Status == metav1.ConditionTrue &&
+			ldapConnectionValidCondition.Reason == conditionsutil.ReasonSuccess &&
 			(searchBaseFoundCondition == nil || (searchBaseFoundCondition.Status == metav1.ConditionTrue)) {
 			// Remember (in-memory for this pod) that the controller has successfully validated the LDAP or AD provider
 			// using this version of the Secret. This is for performance reasons, to avoid attempting to connect to
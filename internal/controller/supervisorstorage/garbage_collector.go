@@ -21,13 +21,17 @@ import (
 	pinnipedcontroller "go.pinniped.dev/internal/controller"
 	"go.pinniped.dev/internal/controllerlib"
 	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
 	"go.pinniped.dev/internal/federationdomain/dynamicupstreamprovider"
+	"go.pinniped.dev/internal/federationdomain/loginlimiter"
 	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
 	"go.pinniped.dev/internal/fositestorage/accesstoken"
 	"go.pinniped.dev/internal/fositestorage/authorizationcode"
 	"go.pinniped.dev/internal/fositestorage/openidconnect"
 	"go.pinniped.dev/internal/fositestorage/pkce"
+	"go.pinniped.dev/internal/fositestorage/pushedauthorization"
 	"go.pinniped.dev/internal/fositestorage/refreshtoken"
+	"go.pinniped.dev/internal/ldapgroupcache"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/psession"
 )
@@ -231,6 +235,32 @@ func (c *garbageCollectorController) maybeRevokeUpstreamOIDCToken(ctx context.Co
 		// be revoked by one of the other cases above.
 		return nil
 
+	case pushedauthorization.TypeLabelValue:
+		// A pushed authorization request is saved before the end user logs in, so it does not hold any upstream tokens.
+		return nil
+
+	case ldapgroupcache.TypeLabelValue:
+		// The LDAP group cache storage is not a downstream session, so it does not hold any upstream tokens.
+		return nil
+
+	case loginlimiter.TypeLabelValue:
+		// The failed login storage is not a downstream session, so it does not hold any upstream tokens.
+		return nil
+
+	case consentgrants.PendingTypeLabelValue:
+		// A pending consent holds the downstream session of a login for which the end user never gave or refused
+		// their consent, so the downstream authcode was never issued. Its upstream token is the latest one.
+		pending, err := consentgrants.ReadPendingFromSecret(secret)
+		if err != nil {
+			return err
+		}
+		return c.tryRevokeUpstreamOIDCToken(ctx, pending.Session.Custom, secret)
+
+	case consentgrants.TypeLabelValue:
+		// The consent grant storage is not a downstream session, so it does not hold any upstream tokens.
+		// These are never garbage collected anyway, since they do not expire.
+		return nil
+
 	default:
 		// There are no other storage types, so this should never happen in practice.
 		return errors.New("garbage collector saw invalid label on Secret when trying to determine if upstream revocation was needed")
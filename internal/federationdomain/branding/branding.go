@@ -0,0 +1,347 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package branding customizes the appearance and the language of the web pages of a FederationDomain.
+package branding
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+
+	"go.pinniped.dev/internal/federationdomain/csp"
+)
+
+// These are the keys of the ConfigMap or Secret which holds the branding.
+const (
+	LogoKey            = "logo"
+	FaviconKey         = "favicon"
+	PrimaryColorKey    = "primaryColor"
+	BackgroundColorKey = "backgroundColor"
+	CustomCSSKey       = "customCSS"
+	SupportURLKey      = "supportURL"
+	DefaultLocaleKey   = "defaultLocale"
+
+	messagesKeyPrefix = "messages."
+	messagesKeySuffix = ".json"
+)
+
+//nolint:gochecknoglobals // These are effectively constants.
+var (
+	colorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+	// The image types which browsers can show from data URLs. SVG is safe here, because scripts inside images
+	// are not run by browsers.
+	imageContentTypes = map[string]bool{
+		"image/png":     true,
+		"image/jpeg":    true,
+		"image/gif":     true,
+		"image/x-icon":  true,
+		"image/svg+xml": true,
+	}
+
+	defaultPage = &Page{
+		Lang:     language.English.String(),
+		Messages: &englishMessages,
+	}
+)
+
+// Branding is the parsed branding of a FederationDomain. A nil *Branding is valid and means that the
+// FederationDomain uses the built-in appearance and English text.
+type Branding struct {
+	logoURL    template.URL
+	faviconURL template.URL
+	supportURL string
+	css        string
+	cssHash    string
+
+	// catalogs has the same order as the tags used to create the matcher, with the default locale first.
+	catalogs []*catalog
+	matcher  language.Matcher
+}
+
+type catalog struct {
+	lang     string
+	messages *Messages
+}
+
+// Parse validates the keys of a ConfigMap or Secret which holds the branding of a FederationDomain.
+// Unknown keys are ignored.
+func Parse(data map[string][]byte) (*Branding, error) {
+	b := &Branding{}
+
+	var err error
+	if b.logoURL, err = imageDataURL(LogoKey, data[LogoKey]); err != nil {
+		return nil, err
+	}
+	if b.faviconURL, err = imageDataURL(FaviconKey, data[FaviconKey]); err != nil {
+		return nil, err
+	}
+
+	if supportURL := strings.TrimSpace(string(data[SupportURLKey])); supportURL != "" {
+		u, err := url.Parse(supportURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("%s must be an https URL", SupportURLKey)
+		}
+		b.supportURL = u.String()
+	}
+
+	if b.css, err = b.makeCSS(data); err != nil {
+		return nil, err
+	}
+	if b.css != "" {
+		b.cssHash = csp.Hash(b.css)
+	}
+
+	if err := b.parseCatalogs(data); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func imageDataURL(key string, image []byte) (template.URL, error) {
+	if len(image) == 0 {
+		return "", nil
+	}
+
+	contentType, _, _ := strings.Cut(http.DetectContentType(image), ";")
+	if strings.Contains(string(image), "<svg") && (contentType == "text/xml" || contentType == "text/plain") {
+		contentType = "image/svg+xml"
+	}
+	if !imageContentTypes[contentType] {
+		return "", fmt.Errorf("%s must be a PNG, JPEG, GIF, ICO, or SVG image", key)
+	}
+
+	//nolint:gosec // The content type was checked above, and the image is base64 encoded, so this is a safe URL.
+	return template.URL("data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(image)), nil
+}
+
+// makeCSS returns the CSS which is added to each page after the built-in CSS of the page.
+func (b *Branding) makeCSS(data map[string][]byte) (string, error) {
+	var css strings.Builder
+
+	if b.logoURL != "" {
+		css.WriteString(`.logo{justify-content:center}.logo img{max-width:100%;max-height:80px}`)
+	}
+	if b.supportURL != "" {
+		css.WriteString(`.support{justify-content:center}`)
+	}
+
+	if color := strings.TrimSpace(string(data[BackgroundColorKey])); color != "" {
+		if !colorRegexp.MatchString(color) {
+			return "", fmt.Errorf("%s must be a color in the #RRGGBB format", BackgroundColorKey)
+		}
+		css.WriteString(`body{background:` + color + `}`)
+	}
+
+	if color := strings.TrimSpace(string(data[PrimaryColorKey])); color != "" {
+		if !colorRegexp.MatchString(color) {
+			return "", fmt.Errorf("%s must be a color in the #RRGGBB format", PrimaryColorKey)
+		}
+		css.WriteString(`.form-field input[type="submit"],.form-field button{background-color:` + color + `}`)
+		css.WriteString(`.form-field input[type="submit"]:focus,.form-field input[type="submit"]:hover,` +
+			`.form-field button:focus,.form-field button:hover{background-color:` + color + `;filter:brightness(110%)}`)
+		css.WriteString(`a{color:` + color + `}`)
+	}
+
+	if customCSS := strings.TrimSpace(string(data[CustomCSSKey])); customCSS != "" {
+		// The CSS is written into a <style> element, so it must not be able to close that element.
+		if strings.Contains(customCSS, "<") {
+			return "", fmt.Errorf(`%s must not contain "<"`, CustomCSSKey)
+		}
+		css.WriteString(customCSS)
+	}
+
+	return css.String(), nil
+}
+
+func (b *Branding) parseCatalogs(data map[string][]byte) error {
+	messagesByTag := map[language.Tag]*Messages{language.English: &englishMessages}
+
+	for key, value := range data {
+		if !strings.HasPrefix(key, messagesKeyPrefix) || !strings.HasSuffix(key, messagesKeySuffix) {
+			continue
+		}
+		locale := strings.TrimSuffix(strings.TrimPrefix(key, messagesKeyPrefix), messagesKeySuffix)
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a valid locale", key, locale)
+		}
+		messages, err := parseMessages(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		messagesByTag[tag] = messages
+	}
+
+	defaultTag := language.English
+	if defaultLocale := strings.TrimSpace(string(data[DefaultLocaleKey])); defaultLocale != "" {
+		tag, err := language.Parse(defaultLocale)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a valid locale", DefaultLocaleKey, defaultLocale)
+		}
+		if _, ok := messagesByTag[tag]; !ok {
+			return fmt.Errorf("%s: there is no message catalog for %q", DefaultLocaleKey, defaultLocale)
+		}
+		defaultTag = tag
+	}
+
+	// The matcher returns the first tag when nothing else matches, so the default locale must be first.
+	tags := make([]language.Tag, 0, len(messagesByTag))
+	for tag := range messagesByTag {
+		if tag != defaultTag {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].String() < tags[j].String() })
+	tags = append([]language.Tag{defaultTag}, tags...)
+
+	for _, tag := range tags {
+		b.catalogs = append(b.catalogs, &catalog{lang: tag.String(), messages: messagesByTag[tag]})
+	}
+	b.matcher = language.NewMatcher(tags)
+
+	return nil
+}
+
+// PageFor returns the branding of a page which is rendered for the request, using the locale which best matches the
+// Accept-Language header of the request.
+func (b *Branding) PageFor(r *http.Request) *Page {
+	if b == nil {
+		return defaultPage
+	}
+
+	wanted, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	_, index, _ := b.matcher.Match(wanted...)
+	c := b.catalogs[index]
+
+	return &Page{
+		Lang:       c.lang,
+		Messages:   c.messages,
+		LogoURL:    b.logoURL,
+		FaviconURL: b.faviconURL,
+		SupportURL: b.supportURL,
+		CSS:        template.CSS(b.css), //nolint:gosec // This was validated by Parse().
+		cssHash:    b.cssHash,
+	}
+}
+
+// Wrap returns a handler which makes the branding of each request available to the pages rendered by handler
+// via FromContext().
+func (b *Branding) Wrap(handler http.Handler) http.Handler {
+	if b == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(NewContext(r.Context(), b.PageFor(r))))
+	})
+}
+
+// Page is the branding of one rendered page, which is used as an input to the page templates.
+type Page struct {
+	// Lang is the BCP 47 language tag of the Messages.
+	Lang     string
+	Messages *Messages
+
+	// These are empty when they are not customized.
+	LogoURL    template.URL
+	FaviconURL template.URL
+	SupportURL string
+	CSS        template.CSS
+
+	cssHash string
+}
+
+// LoginHeading returns the heading of the login page for an identity provider.
+func (p *Page) LoginHeading(idpName string) string {
+	return strings.ReplaceAll(p.Messages.LoginHeading, "{idp}", idpName)
+}
+
+// ConsentHeading returns the heading of the consent page for a client.
+func (p *Page) ConsentHeading(clientName string) string {
+	return strings.ReplaceAll(p.Messages.ConsentHeading, "{client}", clientName)
+}
+
+// ConsentLoggedInAs returns the text of the consent page which tells the end user who they are logged in as.
+func (p *Page) ConsentLoggedInAs(username string) string {
+	return strings.ReplaceAll(p.Messages.ConsentLoggedInAs, "{username}", username)
+}
+
+// ConsentScopesIntro returns the text of the consent page which introduces the list of requested scopes.
+func (p *Page) ConsentScopesIntro(clientName string) string {
+	return strings.ReplaceAll(p.Messages.ConsentScopesIntro, "{client}", clientName)
+}
+
+// ConsentRedirectNotice returns the text of the consent page which tells the end user where they will be returned to.
+func (p *Page) ConsentRedirectNotice(host string) string {
+	return strings.ReplaceAll(p.Messages.ConsentRedirectNotice, "{host}", host)
+}
+
+// TOTPEnrollmentRequired returns the alert message which tells the end user to enroll at the given URL.
+func (p *Page) TOTPEnrollmentRequired(enrollURL string) string {
+	return strings.ReplaceAll(p.Messages.TOTPEnrollmentRequired, "{url}", enrollURL)
+}
+
+// TOTPEnrollDone returns the text of the enrollment page which tells the end user that their authenticator app
+// is enrolled for an identity provider.
+func (p *Page) TOTPEnrollDone(idpName string) string {
+	return strings.ReplaceAll(p.Messages.TOTPEnrollDone, "{idp}", idpName)
+}
+
+// ContentSecurityPolicy returns the Content-Security-Policy header value cspValue of a page, with additional
+// sources which allow the CSS and the images of this branding. The page's template must render the CSS in its own
+// <style> element.
+func (p *Page) ContentSecurityPolicy(cspValue string) string {
+	hasImages := p.LogoURL != "" || p.FaviconURL != ""
+	if p.cssHash == "" && !hasImages {
+		return cspValue
+	}
+
+	directives := strings.Split(cspValue, "; ")
+	hasImgSrc := false
+	for i, directive := range directives {
+		switch {
+		case strings.HasPrefix(directive, "style-src ") && p.cssHash != "":
+			directives[i] = directive + ` '` + p.cssHash + `'`
+		case strings.HasPrefix(directive, "img-src "):
+			hasImgSrc = true
+			if hasImages && !strings.Contains(directive, " data:") {
+				directives[i] = directive + ` data:`
+			}
+		}
+	}
+	if hasImages && !hasImgSrc {
+		directives = append(directives, `img-src data:`)
+	}
+
+	return strings.Join(directives, "; ")
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx which holds the branding of a page.
+func NewContext(ctx context.Context, page *Page) context.Context {
+	return context.WithValue(ctx, contextKey{}, page)
+}
+
+// FromContext returns the branding of a page from ctx, or the built-in branding when ctx does not hold any.
+func FromContext(ctx context.Context) *Page {
+	if page, ok := ctx.Value(contextKey{}).(*Page); ok {
+		return page
+	}
+	return defaultPage
+}
+
+// Default returns the built-in branding, which uses English text.
+func Default() *Page {
+	return defaultPage
+}
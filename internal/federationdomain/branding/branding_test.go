@@ -0,0 +1,293 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package branding
+
+import (
+	"context"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/internal/federationdomain/csp"
+)
+
+const (
+	testLoginCSP    = `default-src 'none'; style-src 'sha256-abc'; frame-ancestors 'none'`
+	testFormPostCSP = `default-src 'none'; script-src 'sha256-def'; style-src 'sha256-abc'; img-src data:; connect-src *; frame-ancestors 'none'`
+)
+
+//nolint:gochecknoglobals // These are test fixtures.
+var (
+	testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	testSVG = []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><circle r="5"/></svg>`)
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        map[string][]byte
+		wantErr     string
+		wantLogo    template.URL
+		wantFavicon template.URL
+		wantSupport string
+		wantCSS     template.CSS
+	}{
+		{
+			name: "empty",
+			data: map[string][]byte{},
+		},
+		{
+			name: "unknown keys are ignored",
+			data: map[string][]byte{"foo": []byte("bar")},
+		},
+		{
+			name: "everything",
+			data: map[string][]byte{
+				LogoKey:            testPNG,
+				FaviconKey:         testSVG,
+				PrimaryColorKey:    []byte("#112233"),
+				BackgroundColorKey: []byte(" #AABBCC\n"),
+				CustomCSSKey:       []byte("h1 { font-size: 30px; }"),
+				SupportURLKey:      []byte("https://help.example.com/login?q=1"),
+			},
+			wantLogo:    template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(testPNG)),
+			wantFavicon: template.URL("data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(testSVG)),
+			wantSupport: "https://help.example.com/login?q=1",
+			wantCSS: `.logo{justify-content:center}.logo img{max-width:100%;max-height:80px}` +
+				`.support{justify-content:center}` +
+				`body{background:#AABBCC}` +
+				`.form-field input[type="submit"],.form-field button{background-color:#112233}` +
+				`.form-field input[type="submit"]:focus,.form-field input[type="submit"]:hover,` +
+				`.form-field button:focus,.form-field button:hover{background-color:#112233;filter:brightness(110%)}` +
+				`a{color:#112233}` +
+				`h1 { font-size: 30px; }`,
+		},
+		{
+			name:    "logo is not an image",
+			data:    map[string][]byte{LogoKey: []byte("hello world")},
+			wantErr: "logo must be a PNG, JPEG, GIF, ICO, or SVG image",
+		},
+		{
+			name:    "favicon is not an image",
+			data:    map[string][]byte{FaviconKey: []byte("<html><body></body></html>")},
+			wantErr: "favicon must be a PNG, JPEG, GIF, ICO, or SVG image",
+		},
+		{
+			name:    "bad primary color",
+			data:    map[string][]byte{PrimaryColorKey: []byte("red")},
+			wantErr: "primaryColor must be a color in the #RRGGBB format",
+		},
+		{
+			name:    "bad background color",
+			data:    map[string][]byte{BackgroundColorKey: []byte("#12345")},
+			wantErr: "backgroundColor must be a color in the #RRGGBB format",
+		},
+		{
+			name:    "custom CSS tries to close the style element",
+			data:    map[string][]byte{CustomCSSKey: []byte("h1{}</style><script>alert(1)</script>")},
+			wantErr: `customCSS must not contain "<"`,
+		},
+		{
+			name:    "support URL is not https",
+			data:    map[string][]byte{SupportURLKey: []byte("http://help.example.com")},
+			wantErr: "supportURL must be an https URL",
+		},
+		{
+			name:    "support URL is a script",
+			data:    map[string][]byte{SupportURLKey: []byte("javascript:alert(1)")},
+			wantErr: "supportURL must be an https URL",
+		},
+		{
+			name:    "bad locale of message catalog",
+			data:    map[string][]byte{"messages.not_a_locale!.json": []byte(`{}`)},
+			wantErr: `messages.not_a_locale!.json: "not_a_locale!" is not a valid locale`,
+		},
+		{
+			name:    "message catalog is not JSON",
+			data:    map[string][]byte{"messages.de.json": []byte(`hello`)},
+			wantErr: "messages.de.json: could not parse message catalog: invalid character 'h' looking for beginning of value",
+		},
+		{
+			name:    "message catalog has an unknown message",
+			data:    map[string][]byte{"messages.de.json": []byte(`{"usrename": "Benutzername"}`)},
+			wantErr: `messages.de.json: could not parse message catalog: json: unknown field "usrename"`,
+		},
+		{
+			name:    "message catalog has an empty message",
+			data:    map[string][]byte{"messages.de.json": []byte(`{"username": ""}`)},
+			wantErr: `messages.de.json: message "username" must not be empty`,
+		},
+		{
+			name:    "bad default locale",
+			data:    map[string][]byte{DefaultLocaleKey: []byte("!!")},
+			wantErr: `defaultLocale: "!!" is not a valid locale`,
+		},
+		{
+			name:    "default locale without a catalog",
+			data:    map[string][]byte{DefaultLocaleKey: []byte("fr")},
+			wantErr: `defaultLocale: there is no message catalog for "fr"`,
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Parse(tt.data)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, b)
+				return
+			}
+			require.NoError(t, err)
+
+			page := b.PageFor(httptest.NewRequest(http.MethodGet, "/", nil))
+			require.Equal(t, "en", page.Lang)
+			require.Equal(t, &englishMessages, page.Messages)
+			require.Equal(t, tt.wantLogo, page.LogoURL)
+			require.Equal(t, tt.wantFavicon, page.FaviconURL)
+			require.Equal(t, tt.wantSupport, page.SupportURL)
+			require.Equal(t, tt.wantCSS, page.CSS)
+		})
+	}
+}
+
+func TestPageFor(t *testing.T) {
+	b, err := Parse(map[string][]byte{
+		"messages.de.json":    []byte(`{"username": "Benutzername", "loginHeading": "Bei {idp} anmelden"}`),
+		"messages.pt-BR.json": []byte(`{"username": "Nome de usuário"}`),
+		"messages.en.json":    []byte(`{"username": "User name"}`),
+		DefaultLocaleKey:      []byte("de"),
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		wantLang       string
+		wantUsername   string
+	}{
+		{
+			name:         "no Accept-Language header uses the default locale",
+			wantLang:     "de",
+			wantUsername: "Benutzername",
+		},
+		{
+			name:           "no matching locale uses the default locale",
+			acceptLanguage: "ja, ko;q=0.5",
+			wantLang:       "de",
+			wantUsername:   "Benutzername",
+		},
+		{
+			name:           "exact match",
+			acceptLanguage: "pt-BR",
+			wantLang:       "pt-BR",
+			wantUsername:   "Nome de usuário",
+		},
+		{
+			name:           "regional variant of a locale",
+			acceptLanguage: "en-GB,en;q=0.9",
+			wantLang:       "en",
+			wantUsername:   "User name",
+		},
+		{
+			name:           "preferred locale by quality",
+			acceptLanguage: "en;q=0.5, pt-BR;q=0.8",
+			wantLang:       "pt-BR",
+			wantUsername:   "Nome de usuário",
+		},
+		{
+			name:           "malformed header uses the default locale",
+			acceptLanguage: ";;;===",
+			wantLang:       "de",
+			wantUsername:   "Benutzername",
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			page := b.PageFor(r)
+			require.Equal(t, tt.wantLang, page.Lang)
+			require.Equal(t, tt.wantUsername, page.Messages.Username)
+			// Messages which are missing from a catalog are in English.
+			require.Equal(t, "Password", page.Messages.Password)
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.Equal(t, "Bei my-ldap anmelden", b.PageFor(r).LoginHeading("my-ldap"))
+	require.Equal(t,
+		"Your authenticator app is enrolled. You will be asked for a one-time password from it when you log in to my-ldap.",
+		b.PageFor(r).TOTPEnrollDone("my-ldap"))
+}
+
+func TestNilBranding(t *testing.T) {
+	var b *Branding
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Language", "de")
+
+	page := b.PageFor(r)
+	require.Same(t, Default(), page)
+	require.Equal(t, "en", page.Lang)
+	require.Equal(t, "Log in to my-ldap", page.LoginHeading("my-ldap"))
+	require.Equal(t, "A one-time password is required, but you have not enrolled an authenticator app. Please enroll at https://example.com/totp/enroll and try again.",
+		page.TOTPEnrollmentRequired("https://example.com/totp/enroll"))
+	require.Equal(t, "my-client is requesting access to your account", page.ConsentHeading("my-client"))
+	require.Equal(t, "You are logged in as my-user.", page.ConsentLoggedInAs("my-user"))
+	require.Equal(t, "If you allow access, my-client will be able to:", page.ConsentScopesIntro("my-client"))
+	require.Equal(t, "You will be returned to app.example.com.", page.ConsentRedirectNotice("app.example.com"))
+	require.Equal(t, testLoginCSP, page.ContentSecurityPolicy(testLoginCSP))
+
+	handler := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	require.NotNil(t, b.Wrap(handler))
+}
+
+func TestContentSecurityPolicy(t *testing.T) {
+	cssOnly, err := Parse(map[string][]byte{PrimaryColorKey: []byte("#112233")})
+	require.NoError(t, err)
+	cssOnlyPage := cssOnly.PageFor(httptest.NewRequest(http.MethodGet, "/", nil))
+	cssHash := csp.Hash(string(cssOnlyPage.CSS))
+
+	require.Equal(t,
+		`default-src 'none'; style-src 'sha256-abc' '`+cssHash+`'; frame-ancestors 'none'`,
+		cssOnlyPage.ContentSecurityPolicy(testLoginCSP))
+	require.Equal(t,
+		`default-src 'none'; script-src 'sha256-def'; style-src 'sha256-abc' '`+cssHash+`'; img-src data:; connect-src *; frame-ancestors 'none'`,
+		cssOnlyPage.ContentSecurityPolicy(testFormPostCSP))
+
+	favicon, err := Parse(map[string][]byte{FaviconKey: testPNG})
+	require.NoError(t, err)
+	faviconPage := favicon.PageFor(httptest.NewRequest(http.MethodGet, "/", nil))
+
+	require.Equal(t,
+		`default-src 'none'; style-src 'sha256-abc'; frame-ancestors 'none'; img-src data:`,
+		faviconPage.ContentSecurityPolicy(testLoginCSP))
+	require.Equal(t, testFormPostCSP, faviconPage.ContentSecurityPolicy(testFormPostCSP))
+}
+
+func TestContext(t *testing.T) {
+	require.Same(t, Default(), FromContext(context.Background()))
+
+	b, err := Parse(map[string][]byte{"messages.de.json": []byte(`{"username": "Benutzername"}`)})
+	require.NoError(t, err)
+
+	var gotPage *Page
+	handler := b.Wrap(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		gotPage = FromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Language", "de-CH")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	require.NotNil(t, gotPage)
+	require.Equal(t, "de", gotPage.Lang)
+	require.Equal(t, "Benutzername", gotPage.Messages.Username)
+}
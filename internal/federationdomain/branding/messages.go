@@ -0,0 +1,168 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package branding
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Messages holds all the text which is shown to end users on the web pages of a FederationDomain.
+// The JSON names of the fields are the message names used in the "messages.<locale>.json" catalogs.
+type Messages struct {
+	LoginTitle   string `json:"loginTitle"`
+	LoginHeading string `json:"loginHeading"` // "{idp}" is replaced by the name of the identity provider
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	LoginButton  string `json:"loginButton"`
+
+	TOTPInstructions string `json:"totpInstructions"`
+	TOTPCode         string `json:"totpCode"`
+	TOTPButton       string `json:"totpButton"`
+
+	TOTPEnrollTitle                   string `json:"totpEnrollTitle"`
+	TOTPEnrollHeading                 string `json:"totpEnrollHeading"`
+	TOTPEnrollLoginInstructions       string `json:"totpEnrollLoginInstructions"`
+	TOTPEnrollIdentityProvider        string `json:"totpEnrollIdentityProvider"`
+	TOTPEnrollCurrentCode             string `json:"totpEnrollCurrentCode"`
+	TOTPEnrollContinueButton          string `json:"totpEnrollContinueButton"`
+	TOTPEnrollConfirmInstructions     string `json:"totpEnrollConfirmInstructions"`
+	TOTPEnrollKeyURIInstructions      string `json:"totpEnrollKeyURIInstructions"`
+	TOTPEnrollButton                  string `json:"totpEnrollButton"`
+	TOTPEnrollDone                    string `json:"totpEnrollDone"` // "{idp}" is replaced by the name of the identity provider
+	TOTPEnrollReplaceRequiresCode     string `json:"totpEnrollReplaceRequiresCode"`
+	TOTPEnrollExpired                 string `json:"totpEnrollExpired"`
+	TOTPEnrollIncorrectCode           string `json:"totpEnrollIncorrectCode"`
+	TOTPEnrollUnknownIdentityProvider string `json:"totpEnrollUnknownIdentityProvider"`
+
+	ChooseIDPTitle   string `json:"chooseIDPTitle"`
+	ChooseIDPHeading string `json:"chooseIDPHeading"`
+
+	UseDifferentAccount string `json:"useDifferentAccount"`
+
+	ConsentTitle                string `json:"consentTitle"`
+	ConsentHeading              string `json:"consentHeading"`     // "{client}" is replaced by the name of the client
+	ConsentLoggedInAs           string `json:"consentLoggedInAs"`  // "{username}" is replaced by the username
+	ConsentScopesIntro          string `json:"consentScopesIntro"` // "{client}" is replaced by the name of the client
+	ConsentScopeOpenID          string `json:"consentScopeOpenID"`
+	ConsentScopeOfflineAccess   string `json:"consentScopeOfflineAccess"`
+	ConsentScopeRequestAudience string `json:"consentScopeRequestAudience"`
+	ConsentScopeUsername        string `json:"consentScopeUsername"`
+	ConsentScopeGroups          string `json:"consentScopeGroups"`
+	ConsentRedirectNotice       string `json:"consentRedirectNotice"` // "{host}" is replaced by the host of the client
+	ConsentAllowButton          string `json:"consentAllowButton"`
+	ConsentDenyButton           string `json:"consentDenyButton"`
+
+	FormPostLoadingTitle string `json:"formPostLoadingTitle"`
+	FormPostSuccessTitle string `json:"formPostSuccessTitle"`
+	FormPostSuccessText  string `json:"formPostSuccessText"`
+	FormPostManualTitle  string `json:"formPostManualTitle"`
+	FormPostManualText   string `json:"formPostManualText"`
+	FormPostErrorTitle   string `json:"formPostErrorTitle"`
+	FormPostErrorText    string `json:"formPostErrorText"`
+
+	SupportLink string `json:"supportLink"`
+
+	InternalError               string `json:"internalError"`
+	IncorrectUsernameOrPassword string `json:"incorrectUsernameOrPassword"`
+	PasswordExpired             string `json:"passwordExpired"`
+	PasswordMustChange          string `json:"passwordMustChange"`
+	AccountLocked               string `json:"accountLocked"`
+	TOTPEnrollmentRequired      string `json:"totpEnrollmentRequired"` // "{url}" is replaced by the enrollment URL
+	TOTPLoginExpired            string `json:"totpLoginExpired"`
+	IncorrectTOTPCode           string `json:"incorrectTOTPCode"`
+	TooManyLoginAttempts        string `json:"tooManyLoginAttempts"`
+}
+
+// englishMessages are the built-in messages, which are used for any message that is missing from a catalog.
+//
+//nolint:gochecknoglobals // This is effectively a constant.
+var englishMessages = Messages{
+	LoginTitle:   "Pinniped Login",
+	LoginHeading: "Log in to {idp}",
+	Username:     "Username",
+	Password:     "Password",
+	LoginButton:  "Log in",
+
+	TOTPInstructions: "Enter the one-time password from your authenticator app.",
+	TOTPCode:         "One-time password",
+	TOTPButton:       "Verify",
+
+	TOTPEnrollTitle:                   "Pinniped One-Time Password Enrollment",
+	TOTPEnrollHeading:                 "Set up one-time passwords",
+	TOTPEnrollLoginInstructions:       "Log in to choose the account which will use one-time passwords from your authenticator app.",
+	TOTPEnrollIdentityProvider:        "Identity provider",
+	TOTPEnrollCurrentCode:             "Current one-time password (only when replacing an enrollment)",
+	TOTPEnrollContinueButton:          "Continue",
+	TOTPEnrollConfirmInstructions:     "Add this key to your authenticator app, then enter the one-time password which it shows.",
+	TOTPEnrollKeyURIInstructions:      "Some authenticator apps can import this URI instead:",
+	TOTPEnrollButton:                  "Enroll",
+	TOTPEnrollDone:                    "Your authenticator app is enrolled. You will be asked for a one-time password from it when you log in to {idp}.",
+	TOTPEnrollReplaceRequiresCode:     "You have already enrolled an authenticator app. Enter a current one-time password from it to replace the enrollment.",
+	TOTPEnrollExpired:                 "Your enrollment has expired. Please start again.",
+	TOTPEnrollIncorrectCode:           "Incorrect one-time password. Check that the key was added to your authenticator app and try again.",
+	TOTPEnrollUnknownIdentityProvider: "The identity provider does not use one-time passwords.",
+
+	ChooseIDPTitle:   "Choose Identity Provider",
+	ChooseIDPHeading: "Choose an identity provider to log in",
+
+	UseDifferentAccount: "Use a different account",
+
+	ConsentTitle:                "Allow Access",
+	ConsentHeading:              "{client} is requesting access to your account",
+	ConsentLoggedInAs:           "You are logged in as {username}.",
+	ConsentScopesIntro:          "If you allow access, {client} will be able to:",
+	ConsentScopeOpenID:          "Verify your identity",
+	ConsentScopeOfflineAccess:   "Stay logged in on your behalf without asking you again",
+	ConsentScopeRequestAudience: "Get tokens for other applications, such as Kubernetes clusters, on your behalf",
+	ConsentScopeUsername:        "See your username",
+	ConsentScopeGroups:          "See the groups that you belong to",
+	ConsentRedirectNotice:       "You will be returned to {host}.",
+	ConsentAllowButton:          "Allow",
+	ConsentDenyButton:           "Deny",
+
+	FormPostLoadingTitle: "Logging in...",
+	FormPostSuccessTitle: "Login succeeded",
+	FormPostSuccessText:  "You have successfully logged in. You may now close this tab.",
+	FormPostManualTitle:  "Finish your login",
+	FormPostManualText:   "To finish logging in, paste this authorization code into your command-line session:",
+	FormPostErrorTitle:   "Error during login",
+	FormPostErrorText:    "Please try again.",
+
+	SupportLink: "Need help?",
+
+	InternalError:               "An internal error occurred. Please contact your administrator for help.",
+	IncorrectUsernameOrPassword: "Incorrect username or password.",
+	PasswordExpired:             "Your password has expired. Please change your password and try again.",
+	PasswordMustChange:          "You must change your password before logging in. Please change your password and try again.",
+	AccountLocked:               "Your account is locked. Please try again later, or contact your administrator for help.",
+	TOTPEnrollmentRequired:      "A one-time password is required, but you have not enrolled an authenticator app. Please enroll at {url} and try again.",
+	TOTPLoginExpired:            "Your login has expired. Please log in again.",
+	IncorrectTOTPCode:           "Incorrect one-time password.",
+	TooManyLoginAttempts:        "Too many failed login attempts. Please wait a few minutes and try again.",
+}
+
+// parseMessages reads a message catalog, which is a JSON object of message names to text. Messages which are
+// missing from the catalog are copied from the English messages. Unknown message names and empty text are errors,
+// since they are most likely typos.
+func parseMessages(catalog []byte) (*Messages, error) {
+	messages := englishMessages
+
+	decoder := json.NewDecoder(bytes.NewReader(catalog))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&messages); err != nil {
+		return nil, fmt.Errorf("could not parse message catalog: %w", err)
+	}
+
+	v := reflect.ValueOf(messages)
+	for i := range v.NumField() {
+		if v.Field(i).String() == "" {
+			return nil, fmt.Errorf("message %q must not be empty", v.Type().Field(i).Tag.Get("json"))
+		}
+	}
+
+	return &messages, nil
+}
@@ -7,13 +7,16 @@ package clientregistry
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v3"
 	"github.com/ory/fosite"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
 	supervisorconfigv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
 	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
@@ -33,12 +36,188 @@ type Client struct {
 	// via RFC8693 token exchange. When zero, the ID token lifetime will be determined by the defaults
 	// for the FederationDomain.
 	IDTokenLifetimeConfiguration time.Duration
+
+	// Optionally restrict which FederationDomain identity providers, by display name, may be used with this client.
+	// When empty, all identity providers may be used. These restrictions are unexported so they are not saved along
+	// with stored sessions, because they should always be read from the current OIDCClient.
+	allowedIdentityProviders []string
+
+	// Optionally restrict which users may use this client. When nil, all users may use this client.
+	admissionPolicy *supervisorconfigv1alpha1.OIDCClientAdmissionPolicy
+
+	// Optionally require the end user to approve the scopes granted to this client.
+	requireConsent bool
+
+	// Optionally provide the name of the client which is shown to end users on the consent page.
+	displayName string
+
+	// The UID of the OIDCClient of a dynamic client, which owns the stored consent of end users.
+	uid types.UID
+
+	// Optionally require the client to push its authorization requests to the pushed authorization request endpoint.
+	requirePushedAuthorizationRequests bool
+
+	// Optionally allow the client to send signed request objects, which must be signed by one of these keys
+	// using this algorithm.
+	requestObjectKeys             *jose.JSONWebKeySet
+	requestObjectSigningAlgorithm string
+
+	// Optionally restrict which audiences the client may request during token exchange. Each item is a pattern in
+	// which "*" matches any sequence of characters. When empty, all audiences may be requested.
+	allowedTokenExchangeAudiences []string
+
+	// Optionally allow the client to exchange JWTs from these external issuers during token exchange.
+	trustedIssuers []*TrustedIssuer
+}
+
+// TrustedIssuer is an external issuer whose JWTs a client may exchange during token exchange.
+type TrustedIssuer struct {
+	// Issuer is the required value of the iss claim.
+	Issuer string
+	// Audience is the value which the aud claim must contain.
+	Audience string
+	// Keys verify the signatures of the JWTs.
+	Keys *jose.JSONWebKeySet
+	// UsernameClaim is the name of the claim which holds the username.
+	UsernameClaim string
+	// GroupsClaim is the name of the claim which holds the groups, or empty when the JWTs do not assert groups.
+	GroupsClaim string
 }
 
 func (c *Client) GetIDTokenLifetimeConfiguration() time.Duration {
 	return c.IDTokenLifetimeConfiguration
 }
 
+// GetJSONWebKeys returns the keys which verify the signatures of the client's request objects.
+// The embedded DefaultOpenIDConnectClient's JSONWebKeys field is not used, so the keys are not saved
+// along with stored sessions.
+func (c *Client) GetJSONWebKeys() *jose.JSONWebKeySet {
+	return c.requestObjectKeys
+}
+
+// GetRequestObjectSigningAlgorithm returns the algorithm which the client must use to sign its request objects.
+func (c *Client) GetRequestObjectSigningAlgorithm() string {
+	return c.requestObjectSigningAlgorithm
+}
+
+// IdentityProviderAllowed returns true when the given client may be used with the FederationDomain identity provider
+// which has the given display name. Only clients of type *Client can have restrictions.
+func IdentityProviderAllowed(client fosite.Client, idpDisplayName string) bool {
+	c, ok := client.(*Client)
+	if !ok || len(c.allowedIdentityProviders) == 0 {
+		return true
+	}
+	return slices.Contains(c.allowedIdentityProviders, idpDisplayName)
+}
+
+// RequiresConsent returns true when the end user must approve the scopes granted to the given client before
+// the client receives tokens. Only clients of type *Client can require consent.
+func RequiresConsent(client fosite.Client) bool {
+	c, ok := client.(*Client)
+	return ok && c.requireConsent
+}
+
+// DisplayName returns the name of the given client which is shown to end users, which is the client ID unless
+// the client has a display name.
+func DisplayName(client fosite.Client) string {
+	if c, ok := client.(*Client); ok && c.displayName != "" {
+		return c.displayName
+	}
+	return client.GetID()
+}
+
+// RequiresPushedAuthorizationRequests returns true when the given client must use the pushed authorization request
+// endpoint before starting an authorization request. Only clients of type *Client can require them.
+func RequiresPushedAuthorizationRequests(client fosite.Client) bool {
+	c, ok := client.(*Client)
+	return ok && c.requirePushedAuthorizationRequests
+}
+
+// TokenExchangeAudienceAllowed returns true when the given client may request the given audience during token
+// exchange. Only clients of type *Client can have restrictions.
+func TokenExchangeAudienceAllowed(client fosite.Client, audience string) bool {
+	c, ok := client.(*Client)
+	if !ok || len(c.allowedTokenExchangeAudiences) == 0 {
+		return true
+	}
+	for _, pattern := range c.allowedTokenExchangeAudiences {
+		if audienceMatchesPattern(audience, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustedIssuerFor returns the issuer with the given iss claim value whose JWTs the given client may exchange during
+// token exchange, or nil when the client does not trust that issuer. Only clients of type *Client can trust issuers.
+func TrustedIssuerFor(client fosite.Client, issuer string) *TrustedIssuer {
+	c, ok := client.(*Client)
+	if !ok {
+		return nil
+	}
+	for _, trustedIssuer := range c.trustedIssuers {
+		if trustedIssuer.Issuer == issuer {
+			return trustedIssuer
+		}
+	}
+	return nil
+}
+
+// audienceMatchesPattern returns true when the audience matches the pattern, in which "*" matches any sequence of
+// characters and all other characters only match themselves.
+func audienceMatchesPattern(audience string, pattern string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return audience == pattern
+	}
+	// The first part must be a prefix and the last part must be a suffix, without overlapping.
+	first, last := parts[0], parts[len(parts)-1]
+	if len(audience) < len(first)+len(last) || !strings.HasPrefix(audience, first) || !strings.HasSuffix(audience, last) {
+		return false
+	}
+	// The parts in between must appear in order. Matching each at its earliest position leaves the most room for the rest.
+	remaining := audience[len(first) : len(audience)-len(last)]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(remaining, part)
+		if i < 0 {
+			return false
+		}
+		remaining = remaining[i+len(part):]
+	}
+	return true
+}
+
+// UID returns the UID of the OIDCClient of the given dynamic client, or an empty UID for any other client.
+func UID(client fosite.Client) types.UID {
+	c, ok := client.(*Client)
+	if !ok {
+		return ""
+	}
+	return c.uid
+}
+
+// AdmitIdentity returns an error when the given client may not be used by the given downstream identity, which
+// is the identity after the FederationDomain's identity transformations were applied.
+func AdmitIdentity(client fosite.Client, idpDisplayName string, username string, groups []string) error {
+	if !IdentityProviderAllowed(client, idpDisplayName) {
+		return fmt.Errorf("client %q is not allowed to use identity provider %q", client.GetID(), idpDisplayName)
+	}
+
+	c, ok := client.(*Client)
+	if !ok || c.admissionPolicy == nil {
+		return nil
+	}
+	if slices.Contains(c.admissionPolicy.AllowedUsernames, username) {
+		return nil
+	}
+	for _, group := range groups {
+		if slices.Contains(c.admissionPolicy.AllowedGroups, group) {
+			return nil
+		}
+	}
+	return fmt.Errorf("client %q admission policy does not allow this user", c.GetID())
+}
+
 // Client implements the base, OIDC, and response_mode client interfaces of Fosite.
 var (
 	_ fosite.Client              = (*Client)(nil)
@@ -188,6 +367,37 @@ func oidcClientCRToFositeClient(oidcClient *supervisorconfigv1alpha1.OIDCClient,
 		idTokenLifetime = time.Duration(*(idTokenLifetimeOverrideInSeconds)) * time.Second
 	}
 
+	var requestObjectKeys *jose.JSONWebKeySet
+	var requestObjectSigningAlgorithm string
+	if oidcClient.Spec.RequestObjects != nil {
+		// The keys were already validated by the validator, so this should not fail.
+		keys, err := oidcclientvalidator.ParsePublicSigningKeys(oidcClient.Spec.RequestObjects.JWKS)
+		if err == nil {
+			requestObjectKeys = keys
+			requestObjectSigningAlgorithm = oidcClient.Spec.RequestObjects.SigningAlgorithm
+		}
+	}
+
+	var allowedTokenExchangeAudiences []string
+	var trustedIssuers []*TrustedIssuer
+	if oidcClient.Spec.TokenExchange != nil {
+		allowedTokenExchangeAudiences = oidcClient.Spec.TokenExchange.AllowedAudiences
+		for _, issuer := range oidcClient.Spec.TokenExchange.TrustedIssuers {
+			// The keys were already validated by the validator, so this should not fail.
+			keys, err := oidcclientvalidator.ParsePublicSigningKeys(issuer.JWKS)
+			if err != nil {
+				continue
+			}
+			trustedIssuers = append(trustedIssuers, &TrustedIssuer{
+				Issuer:        issuer.Issuer,
+				Audience:      issuer.Audience,
+				Keys:          keys,
+				UsernameClaim: issuer.Claims.Username,
+				GroupsClaim:   issuer.Claims.Groups,
+			})
+		}
+	}
+
 	return &Client{
 		DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
 			DefaultClient: &fosite.DefaultClient{
@@ -212,6 +422,17 @@ func oidcClientCRToFositeClient(oidcClient *supervisorconfigv1alpha1.OIDCClient,
 			TokenEndpointAuthMethod:           "client_secret_basic",
 		},
 		IDTokenLifetimeConfiguration: idTokenLifetime,
+		allowedIdentityProviders:     oidcClient.Spec.AllowedIdentityProviders,
+		admissionPolicy:              oidcClient.Spec.AdmissionPolicy,
+		requireConsent:               oidcClient.Spec.RequireConsent,
+		displayName:                  oidcClient.Spec.DisplayName,
+		uid:                          oidcClient.UID,
+
+		requirePushedAuthorizationRequests: oidcClient.Spec.RequirePushedAuthorizationRequests,
+		requestObjectKeys:                  requestObjectKeys,
+		requestObjectSigningAlgorithm:      requestObjectSigningAlgorithm,
+		allowedTokenExchangeAudiences:      allowedTokenExchangeAudiences,
+		trustedIssuers:                     trustedIssuers,
 	}
 }
 
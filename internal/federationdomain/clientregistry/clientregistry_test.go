@@ -16,11 +16,13 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
 	coretesting "k8s.io/client-go/testing"
 	"k8s.io/utils/ptr"
 
 	supervisorconfigv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
 	supervisorfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
 	"go.pinniped.dev/internal/federationdomain/oidcclientvalidator"
 	"go.pinniped.dev/internal/oidcclientsecretstorage"
@@ -250,6 +252,148 @@ func TestClientManager(t *testing.T) {
 				)
 			},
 		},
+		{
+			name: "find a valid dynamic client with allowed identity providers and an admission policy",
+			oidcClients: []*supervisorconfigv1alpha1.OIDCClient{
+				{
+					ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+					Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+						AllowedGrantTypes:        []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+						AllowedScopes:            []supervisorconfigv1alpha1.Scope{"openid"},
+						AllowedRedirectURIs:      []supervisorconfigv1alpha1.RedirectURI{"http://localhost:8080"},
+						AllowedIdentityProviders: []string{"okta"},
+						AdmissionPolicy: &supervisorconfigv1alpha1.OIDCClientAdmissionPolicy{
+							AllowedGroups: []string{"billing-users"},
+						},
+					},
+				},
+			},
+			secrets: []*corev1.Secret{
+				testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost}),
+			},
+			run: func(t *testing.T, subject *ClientManager) {
+				got, err := subject.GetClient(ctx, testName)
+				require.NoError(t, err)
+				require.IsType(t, &Client{}, got)
+				c := got.(*Client)
+
+				require.Equal(t, []string{"okta"}, c.allowedIdentityProviders)
+				require.Equal(t, &supervisorconfigv1alpha1.OIDCClientAdmissionPolicy{AllowedGroups: []string{"billing-users"}}, c.admissionPolicy)
+			},
+		},
+		{
+			name: "find a valid dynamic client which requires consent",
+			oidcClients: []*supervisorconfigv1alpha1.OIDCClient{
+				{
+					ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+					Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+						AllowedGrantTypes:   []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+						AllowedScopes:       []supervisorconfigv1alpha1.Scope{"openid"},
+						AllowedRedirectURIs: []supervisorconfigv1alpha1.RedirectURI{"http://localhost:8080"},
+						RequireConsent:      true,
+						DisplayName:         "Some Web App",
+					},
+				},
+			},
+			secrets: []*corev1.Secret{
+				testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost}),
+			},
+			run: func(t *testing.T, subject *ClientManager) {
+				got, err := subject.GetClient(ctx, testName)
+				require.NoError(t, err)
+				require.True(t, RequiresConsent(got))
+				require.Equal(t, types.UID(testUID), UID(got))
+				require.Equal(t, "Some Web App", DisplayName(got))
+
+				got, err = subject.GetClient(ctx, oidcapi.ClientIDPinnipedCLI)
+				require.NoError(t, err)
+				require.False(t, RequiresConsent(got))
+				require.Empty(t, UID(got))
+				require.Equal(t, oidcapi.ClientIDPinnipedCLI, DisplayName(got))
+			},
+		},
+		{
+			name: "find a valid dynamic client which requires pushed authorization requests and has request object keys",
+			oidcClients: []*supervisorconfigv1alpha1.OIDCClient{
+				{
+					ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+					Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+						AllowedGrantTypes:                  []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+						AllowedScopes:                      []supervisorconfigv1alpha1.Scope{"openid"},
+						AllowedRedirectURIs:                []supervisorconfigv1alpha1.RedirectURI{"http://localhost:8080"},
+						RequirePushedAuthorizationRequests: true,
+						RequestObjects: &supervisorconfigv1alpha1.OIDCClientRequestObjects{
+							SigningAlgorithm: "ES256",
+							JWKS:             `{"keys":[{"use":"sig","kty":"EC","kid":"key-1","crv":"P-256","alg":"ES256","x":"-YM6MaaeLcKna0tz0qszVU_uiZSBz-9Jhue79TRNnxc","y":"YeIa02fZAML3KwbjSTZiiArrZfUElMgrORdysYCNmDI"}]}`,
+						},
+					},
+				},
+			},
+			secrets: []*corev1.Secret{
+				testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost}),
+			},
+			run: func(t *testing.T, subject *ClientManager) {
+				got, err := subject.GetClient(ctx, testName)
+				require.NoError(t, err)
+				require.True(t, RequiresPushedAuthorizationRequests(got))
+				c := got.(*Client)
+				require.Equal(t, "ES256", c.GetRequestObjectSigningAlgorithm())
+				require.Len(t, c.GetJSONWebKeys().Keys, 1)
+				require.Equal(t, "key-1", c.GetJSONWebKeys().Keys[0].KeyID)
+				// The keys are not saved along with stored sessions.
+				require.Nil(t, c.JSONWebKeys)
+
+				got, err = subject.GetClient(ctx, oidcapi.ClientIDPinnipedCLI)
+				require.NoError(t, err)
+				require.False(t, RequiresPushedAuthorizationRequests(got))
+				require.Nil(t, got.(*Client).GetJSONWebKeys())
+				require.Empty(t, got.(*Client).GetRequestObjectSigningAlgorithm())
+			},
+		},
+		{
+			name: "find a valid dynamic client which has token exchange audiences and trusted issuers",
+			oidcClients: []*supervisorconfigv1alpha1.OIDCClient{
+				{
+					ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: testName, Generation: 1234, UID: testUID},
+					Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+						AllowedGrantTypes:   []supervisorconfigv1alpha1.GrantType{"authorization_code", "urn:ietf:params:oauth:grant-type:token-exchange"},
+						AllowedScopes:       []supervisorconfigv1alpha1.Scope{"openid", "pinniped:request-audience", "username", "groups"},
+						AllowedRedirectURIs: []supervisorconfigv1alpha1.RedirectURI{"http://localhost:8080"},
+						TokenExchange: &supervisorconfigv1alpha1.OIDCClientTokenExchange{
+							AllowedAudiences: []string{"*.clusters.example.com"},
+							TrustedIssuers: []supervisorconfigv1alpha1.OIDCClientTrustedIssuer{{
+								Issuer:   "https://ci.example.com",
+								Audience: "some-audience",
+								JWKS:     `{"keys":[{"use":"sig","kty":"EC","kid":"key-1","crv":"P-256","alg":"ES256","x":"-YM6MaaeLcKna0tz0qszVU_uiZSBz-9Jhue79TRNnxc","y":"YeIa02fZAML3KwbjSTZiiArrZfUElMgrORdysYCNmDI"}]}`,
+								Claims:   supervisorconfigv1alpha1.OIDCClientTrustedIssuerClaims{Username: "email", Groups: "teams"},
+							}},
+						},
+					},
+				},
+			},
+			secrets: []*corev1.Secret{
+				testutil.OIDCClientSecretStorageSecretForUID(t, testNamespace, testUID, []string{testutil.HashedPassword1AtSupervisorMinCost}),
+			},
+			run: func(t *testing.T, subject *ClientManager) {
+				got, err := subject.GetClient(ctx, testName)
+				require.NoError(t, err)
+				require.True(t, TokenExchangeAudienceAllowed(got, "prod.clusters.example.com"))
+				require.False(t, TokenExchangeAudienceAllowed(got, "some-other-cluster"))
+				require.Nil(t, TrustedIssuerFor(got, "https://other.example.com"))
+				trustedIssuer := TrustedIssuerFor(got, "https://ci.example.com")
+				require.NotNil(t, trustedIssuer)
+				require.Equal(t, "some-audience", trustedIssuer.Audience)
+				require.Equal(t, "email", trustedIssuer.UsernameClaim)
+				require.Equal(t, "teams", trustedIssuer.GroupsClaim)
+				require.Len(t, trustedIssuer.Keys.Keys, 1)
+				require.Equal(t, "key-1", trustedIssuer.Keys.Keys[0].KeyID)
+
+				got, err = subject.GetClient(ctx, oidcapi.ClientIDPinnipedCLI)
+				require.NoError(t, err)
+				require.True(t, TokenExchangeAudienceAllowed(got, "some-other-cluster"))
+				require.Nil(t, TrustedIssuerFor(got, "https://ci.example.com"))
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -378,3 +522,200 @@ func requireDynamicOIDCClient(
 	require.Equal(t, "RS256", c.GetTokenEndpointAuthSigningAlgorithm())
 	require.Equal(t, []fosite.ResponseModeType{"", "query"}, c.GetResponseModes())
 }
+
+func TestAdmitIdentity(t *testing.T) {
+	restrictedClient := &Client{
+		DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+			DefaultClient: &fosite.DefaultClient{ID: "client.oauth.pinniped.dev-billing"},
+		},
+		allowedIdentityProviders: []string{"okta"},
+		admissionPolicy: &supervisorconfigv1alpha1.OIDCClientAdmissionPolicy{
+			AllowedUsernames: []string{"admin@example.com"},
+			AllowedGroups:    []string{"billing-users", "billing-admins"},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		client         fosite.Client
+		idpDisplayName string
+		username       string
+		groups         []string
+		wantIDPAllowed bool
+		wantErr        string
+	}{
+		{
+			name:           "pinniped-cli has no restrictions",
+			client:         PinnipedCLI(),
+			idpDisplayName: "any-idp",
+			username:       "any-user",
+			wantIDPAllowed: true,
+		},
+		{
+			name:           "clients which are not a *Client have no restrictions",
+			client:         &fosite.DefaultClient{ID: "some-client"},
+			idpDisplayName: "any-idp",
+			username:       "any-user",
+			wantIDPAllowed: true,
+		},
+		{
+			name:           "user in an allowed group",
+			client:         restrictedClient,
+			idpDisplayName: "okta",
+			username:       "someone@example.com",
+			groups:         []string{"other-group", "billing-admins"},
+			wantIDPAllowed: true,
+		},
+		{
+			name:           "user with an allowed username",
+			client:         restrictedClient,
+			idpDisplayName: "okta",
+			username:       "admin@example.com",
+			wantIDPAllowed: true,
+		},
+		{
+			name:           "user which is neither allowed by username nor group",
+			client:         restrictedClient,
+			idpDisplayName: "okta",
+			username:       "someone@example.com",
+			groups:         []string{"other-group"},
+			wantIDPAllowed: true,
+			wantErr:        `client "client.oauth.pinniped.dev-billing" admission policy does not allow this user`,
+		},
+		{
+			name:           "identity provider which is not allowed",
+			client:         restrictedClient,
+			idpDisplayName: "ldap",
+			username:       "admin@example.com",
+			groups:         []string{"billing-users"},
+			wantIDPAllowed: false,
+			wantErr:        `client "client.oauth.pinniped.dev-billing" is not allowed to use identity provider "ldap"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, test.wantIDPAllowed, IdentityProviderAllowed(test.client, test.idpDisplayName))
+
+			err := AdmitIdentity(test.client, test.idpDisplayName, test.username, test.groups)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTokenExchangeAudienceAllowed(t *testing.T) {
+	clientWithAllowedAudiences := func(patterns ...string) *Client {
+		return &Client{
+			DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{
+				DefaultClient: &fosite.DefaultClient{ID: "client.oauth.pinniped.dev-deployer"},
+			},
+			allowedTokenExchangeAudiences: patterns,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		client   fosite.Client
+		audience string
+		want     bool
+	}{
+		{
+			name:     "pinniped-cli has no restrictions",
+			client:   PinnipedCLI(),
+			audience: "any-cluster",
+			want:     true,
+		},
+		{
+			name:     "clients which are not a *Client have no restrictions",
+			client:   &fosite.DefaultClient{ID: "some-client"},
+			audience: "any-cluster",
+			want:     true,
+		},
+		{
+			name:     "client without allowed audiences has no restrictions",
+			client:   clientWithAllowedAudiences(),
+			audience: "any-cluster",
+			want:     true,
+		},
+		{
+			name:     "exact match",
+			client:   clientWithAllowedAudiences("other-cluster", "some-cluster"),
+			audience: "some-cluster",
+			want:     true,
+		},
+		{
+			name:     "no exact match",
+			client:   clientWithAllowedAudiences("some-cluster"),
+			audience: "some-cluster-2",
+			want:     false,
+		},
+		{
+			name:     "wildcard prefix",
+			client:   clientWithAllowedAudiences("*.clusters.example.com"),
+			audience: "prod.clusters.example.com",
+			want:     true,
+		},
+		{
+			name:     "wildcard matches slashes",
+			client:   clientWithAllowedAudiences("https://clusters.example.com/*"),
+			audience: "https://clusters.example.com/prod/us-east",
+			want:     true,
+		},
+		{
+			name:     "wildcard matches an empty sequence",
+			client:   clientWithAllowedAudiences("cluster-*"),
+			audience: "cluster-",
+			want:     true,
+		},
+		{
+			name:     "wildcard in the middle",
+			client:   clientWithAllowedAudiences("team-*-prod"),
+			audience: "team-billing-prod",
+			want:     true,
+		},
+		{
+			name:     "wildcard in the middle does not match a different suffix",
+			client:   clientWithAllowedAudiences("team-*-prod"),
+			audience: "team-billing-staging",
+			want:     false,
+		},
+		{
+			name:     "prefix and suffix must not overlap",
+			client:   clientWithAllowedAudiences("ab*ba"),
+			audience: "aba",
+			want:     false,
+		},
+		{
+			name:     "several wildcards",
+			client:   clientWithAllowedAudiences("*-*-prod-*"),
+			audience: "team-billing-prod-us-east",
+			want:     true,
+		},
+		{
+			name:     "several wildcards which do not match",
+			client:   clientWithAllowedAudiences("*-prod-*-east"),
+			audience: "team-staging-us-east",
+			want:     false,
+		},
+		{
+			name:     "other glob characters only match themselves",
+			client:   clientWithAllowedAudiences("cluster-?", "cluster-[ab]"),
+			audience: "cluster-a",
+			want:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, test.want, TokenExchangeAudienceAllowed(test.client, test.audience))
+		})
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package consentgrants stores the consent which end users gave to OIDCClients that require consent, and the
+// logins which are waiting for the end user to give their consent. Both are stored in Secrets, so they can be
+// used by all the Supervisor's pods.
+package consentgrants
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	supervisorconfigv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/federationdomain/csrftoken"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	TypeLabelValue        = "consent-grant"
+	PendingTypeLabelValue = "consent-pending"
+
+	// ClientUIDLabelName is the label of each stored grant which holds the UID of the OIDCClient, so all the grants
+	// of one client can be found and revoked by an administrator.
+	ClientUIDLabelName = "storage.pinniped.dev/client-uid"
+
+	// UsernameHashLabelName is the label of each stored grant which holds the first 40 characters of the hex encoded
+	// SHA-256 hash of the downstream username, so all the grants of one end user can be found and revoked by an
+	// administrator. The username itself cannot be used, since it may be too long or contain characters which are
+	// not allowed in label values.
+	UsernameHashLabelName = "storage.pinniped.dev/username-hash"
+
+	ErrInvalidGrantVersion   = constable.Error("consent grant data has wrong version")
+	ErrInvalidPendingVersion = constable.Error("pending consent data has wrong version")
+	ErrPendingNotFound       = constable.Error("pending consent not found")
+
+	// Version 1 was the initial release of storage.
+	grantStorageVersion   = "1"
+	pendingStorageVersion = "1"
+
+	// pendingLifetime is how long the end user has to give their consent after they logged in.
+	pendingLifetime = 10 * time.Minute
+
+	usernameHashLength = 40
+)
+
+// Store stores the consent grants of end users for a FederationDomain. The grants do not expire, so they are never
+// deleted by the garbage collector. They are owned by the OIDCClient, so they are deleted along with it.
+type Store struct {
+	storage        crud.Storage
+	pendingStorage crud.Storage
+	issuer         string
+	rand           io.Reader
+}
+
+type grant struct {
+	ClientUID types.UID `json:"clientUID"`
+	Scopes    []string  `json:"scopes"`
+	GrantedAt time.Time `json:"grantedAt"`
+	Version   string    `json:"version"`
+}
+
+// Pending is a login which is waiting for the end user to give their consent. It holds everything which is
+// needed to finish the login after the end user gave their consent, without logging in to the upstream again.
+type Pending struct {
+	// AuthParams are the original params of the downstream authorization request.
+	AuthParams string `json:"authParams"`
+	// CSRFToken is the value of the CSRF cookie of the browser which logged in, so the consent can only be
+	// given from the same browser.
+	CSRFToken csrftoken.CSRFToken `json:"csrfToken"`
+	// GrantedScopes are the downstream scopes which will be granted when the end user gives their consent.
+	GrantedScopes []string `json:"grantedScopes"`
+	// ClientDisplayName is the name of the client which is shown on the consent page.
+	ClientDisplayName string `json:"clientDisplayName"`
+	// Session is the downstream session which was created for the end user.
+	Session *psession.PinnipedSession `json:"session"`
+	Version string                    `json:"version"`
+}
+
+// New returns the Store of the FederationDomain with the given issuer.
+func New(issuer string, secrets corev1client.SecretInterface, rand io.Reader, clock func() time.Time) *Store {
+	return &Store{
+		storage:        crud.New(TypeLabelValue, secrets, clock),
+		pendingStorage: crud.New(PendingTypeLabelValue, secrets, clock),
+		issuer:         issuer,
+		rand:           rand,
+	}
+}
+
+// IsGranted returns true when the end user with the given downstream subject has consented to all the given scopes
+// for the client with the given ID and OIDCClient UID.
+func (s *Store) IsGranted(ctx context.Context, clientID string, clientUID types.UID, subject string, scopes []string) (bool, error) {
+	stored, err := s.get(ctx, clientID, subject)
+	if err != nil || stored == nil {
+		return false, err
+	}
+	// A grant for an OIDCClient which was deleted and created again with the same name does not count.
+	if stored.ClientUID != clientUID {
+		return false, nil
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(stored.Scopes, scope) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Grant stores the consent of the end user with the given downstream subject and username for the given scopes,
+// in addition to any scopes which they consented to before.
+func (s *Store) Grant(
+	ctx context.Context,
+	clientID string,
+	clientUID types.UID,
+	subject string,
+	username string,
+	scopes []string,
+	grantedAt time.Time,
+) error {
+	stored, err := s.get(ctx, clientID, subject)
+	if err != nil {
+		return err
+	}
+
+	updated := &grant{ClientUID: clientUID, Scopes: slices.Clone(scopes), GrantedAt: grantedAt, Version: grantStorageVersion}
+	if stored != nil && stored.ClientUID == clientUID {
+		for _, scope := range stored.Scopes {
+			if !slices.Contains(updated.Scopes, scope) {
+				updated.Scopes = append(updated.Scopes, scope)
+			}
+		}
+	}
+	slices.Sort(updated.Scopes)
+
+	labels := map[string]string{
+		ClientUIDLabelName:    string(clientUID),
+		UsernameHashLabelName: UsernameHash(username),
+	}
+	// Setup an owner reference for garbage collection purposes. When the OIDCClient is deleted, then the consent
+	// which was given to it should also be automatically deleted (by Kube garbage collection).
+	ownerReferences := []metav1.OwnerReference{{
+		APIVersion: supervisorconfigv1alpha1.SchemeGroupVersion.String(),
+		Kind:       "OIDCClient",
+		Name:       clientID,
+		UID:        clientUID,
+	}}
+
+	// Replace any previous grant, since the labels and the owner of a Secret cannot be changed by an update.
+	// Using a lifetime of zero means that the Secret will not be garbage collected.
+	signature := s.signature(clientID, subject)
+	_, err = s.storage.Create(ctx, signature, updated, labels, ownerReferences, 0)
+	if apierrors.IsAlreadyExists(err) {
+		if err = s.storage.Delete(ctx, signature); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete previous consent grant: %w", err)
+		}
+		_, err = s.storage.Create(ctx, signature, updated, labels, ownerReferences, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store consent grant: %w", err)
+	}
+	return nil
+}
+
+// Revoke deletes the consent of the end user with the given downstream subject for the client with the given ID.
+// It is not an error when the end user had not consented.
+func (s *Store) Revoke(ctx context.Context, clientID string, subject string) error {
+	if err := s.storage.Delete(ctx, s.signature(clientID, subject)); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to revoke consent grant: %w", err)
+	}
+	return nil
+}
+
+// SavePending stores a login which is waiting for the consent of the end user, and returns its random ID.
+func (s *Store) SavePending(ctx context.Context, pending *Pending) (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := io.ReadFull(s.rand, randomBytes); err != nil {
+		return "", fmt.Errorf("could not generate pending consent ID: %w", err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	pending.Version = pendingStorageVersion
+	if _, err := s.pendingStorage.Create(ctx, s.pendingSignature(id), pending, nil, nil, pendingLifetime); err != nil {
+		return "", fmt.Errorf("failed to store pending consent: %w", err)
+	}
+	return id, nil
+}
+
+// GetPending returns the login with the given ID which is waiting for the consent of the end user. It returns
+// ErrPendingNotFound when there is no such login, e.g. because the consent was already given.
+func (s *Store) GetPending(ctx context.Context, id string) (*Pending, error) {
+	pending := newEmptyPending()
+	if _, err := s.pendingStorage.Get(ctx, s.pendingSignature(id), pending); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, ErrPendingNotFound
+		}
+		return nil, fmt.Errorf("failed to get pending consent: %w", err)
+	}
+	if pending.Version != pendingStorageVersion {
+		return nil, fmt.Errorf("%w: pending consent has version %s instead of %s",
+			ErrInvalidPendingVersion, pending.Version, pendingStorageVersion)
+	}
+	return pending, nil
+}
+
+// DeletePending deletes the login with the given ID, so the end user can only decide once. It returns
+// ErrPendingNotFound when it was already deleted, e.g. by a concurrent request.
+func (s *Store) DeletePending(ctx context.Context, id string) error {
+	if err := s.pendingStorage.Delete(ctx, s.pendingSignature(id)); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ErrPendingNotFound
+		}
+		return fmt.Errorf("failed to delete pending consent: %w", err)
+	}
+	return nil
+}
+
+// ReadPendingFromSecret reads the pending consent which is stored in the given Secret.
+func ReadPendingFromSecret(secret *corev1.Secret) (*Pending, error) {
+	pending := newEmptyPending()
+	if err := crud.FromSecret(PendingTypeLabelValue, secret, pending); err != nil {
+		return nil, err
+	}
+	if pending.Version != pendingStorageVersion {
+		return nil, fmt.Errorf("%w: pending consent has version %s instead of %s",
+			ErrInvalidPendingVersion, pending.Version, pendingStorageVersion)
+	}
+	return pending, nil
+}
+
+// newEmptyPending returns a Pending with an empty session of the concrete type which is needed to read it from JSON.
+func newEmptyPending() *Pending {
+	return &Pending{Session: psession.NewPinnipedSession()}
+}
+
+// UsernameHash returns the value of the UsernameHashLabelName label for the given downstream username.
+func UsernameHash(username string) string {
+	hash := sha256.Sum256([]byte(username))
+	return hex.EncodeToString(hash[:])[:usernameHashLength]
+}
+
+func (s *Store) get(ctx context.Context, clientID string, subject string) (*grant, error) {
+	stored := &grant{}
+	_, err := s.storage.Get(ctx, s.signature(clientID, subject), stored)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get consent grant: %w", err)
+	}
+	if stored.Version != grantStorageVersion {
+		return nil, fmt.Errorf("%w: grant has version %s instead of %s",
+			ErrInvalidGrantVersion, stored.Version, grantStorageVersion)
+	}
+	return stored, nil
+}
+
+// signature hashes the issuer, client ID and subject, since the subject may be too long or contain characters
+// which cannot be used in the name of a Secret, and since it identifies the user.
+func (s *Store) signature(clientID string, subject string) string {
+	hash := sha256.Sum256([]byte(s.issuer + "\n" + clientID + "\n" + subject))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// pendingSignature hashes the random ID, so the ID which is known to the end user's browser cannot be found by
+// listing Secrets.
+func (s *Store) pendingSignature(id string) string {
+	hash := sha256.Sum256([]byte(s.issuer + "\n" + id))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package consentgrants
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	namespace = "test-ns"
+	issuer    = "https://issuer.example.com/some/path"
+	clientID  = "client.oauth.pinniped.dev-test"
+	clientUID = types.UID("test-client-uid")
+	subject   = "https://upstream.example.com?idpName=some-idp&sub=some-subject"
+	username  = "some-username"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	setup := func(t *testing.T) (*fake.Clientset, *Store) {
+		t.Helper()
+		client := fake.NewSimpleClientset()
+		return client, New(issuer, client.CoreV1().Secrets(namespace), rand.Reader, func() time.Time { return now })
+	}
+
+	requireGranted := func(t *testing.T, store *Store, uid types.UID, scopes []string, want bool) {
+		t.Helper()
+		granted, err := store.IsGranted(ctx, clientID, uid, subject, scopes)
+		require.NoError(t, err)
+		require.Equal(t, want, granted)
+	}
+
+	listSecrets := func(t *testing.T, client *fake.Clientset, typeLabelValue string) []corev1.Secret {
+		t.Helper()
+		list, err := client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: crud.SecretLabelKey + "=" + typeLabelValue,
+		})
+		require.NoError(t, err)
+		return list.Items
+	}
+
+	t.Run("nothing is granted before the end user consents", func(t *testing.T) {
+		_, store := setup(t)
+		requireGranted(t, store, clientUID, []string{"openid"}, false)
+		requireGranted(t, store, clientUID, nil, false)
+	})
+
+	t.Run("grants accumulate scopes and can be found by client UID and username hash", func(t *testing.T) {
+		client, store := setup(t)
+		require.NoError(t, store.Grant(ctx, clientID, clientUID, subject, username, []string{"openid", "username"}, now))
+		requireGranted(t, store, clientUID, []string{"openid", "username"}, true)
+		requireGranted(t, store, clientUID, []string{"openid", "groups"}, false)
+
+		require.NoError(t, store.Grant(ctx, clientID, clientUID, subject, username, []string{"groups"}, now))
+		requireGranted(t, store, clientUID, []string{"openid", "username", "groups"}, true)
+
+		secrets := listSecrets(t, client, TypeLabelValue)
+		require.Len(t, secrets, 1)
+		require.Equal(t, string(clientUID), secrets[0].Labels[ClientUIDLabelName])
+		require.Equal(t, UsernameHash(username), secrets[0].Labels[UsernameHashLabelName])
+		require.Equal(t, []metav1.OwnerReference{{
+			APIVersion: "config.supervisor.pinniped.dev/v1alpha1",
+			Kind:       "OIDCClient",
+			Name:       clientID,
+			UID:        clientUID,
+		}}, secrets[0].OwnerReferences)
+		require.NotContains(t, secrets[0].Annotations, "storage.pinniped.dev/garbage-collect-after")
+	})
+
+	t.Run("grants of a previous OIDCClient with the same name do not count and are replaced", func(t *testing.T) {
+		_, store := setup(t)
+		require.NoError(t, store.Grant(ctx, clientID, "old-client-uid", subject, username, []string{"openid", "groups"}, now))
+		requireGranted(t, store, clientUID, []string{"openid"}, false)
+
+		require.NoError(t, store.Grant(ctx, clientID, clientUID, subject, username, []string{"openid"}, now))
+		requireGranted(t, store, clientUID, []string{"openid"}, true)
+		requireGranted(t, store, clientUID, []string{"groups"}, false)
+		requireGranted(t, store, "old-client-uid", []string{"openid"}, false)
+	})
+
+	t.Run("grants are per end user and per FederationDomain", func(t *testing.T) {
+		client, store := setup(t)
+		require.NoError(t, store.Grant(ctx, clientID, clientUID, subject, username, []string{"openid"}, now))
+
+		granted, err := store.IsGranted(ctx, clientID, clientUID, subject+"-other", []string{"openid"})
+		require.NoError(t, err)
+		require.False(t, granted)
+
+		otherStore := New(issuer+"/other", client.CoreV1().Secrets(namespace), rand.Reader, time.Now)
+		granted, err = otherStore.IsGranted(ctx, clientID, clientUID, subject, []string{"openid"})
+		require.NoError(t, err)
+		require.False(t, granted)
+	})
+
+	t.Run("revoke deletes the grant and is not an error when nothing was granted", func(t *testing.T) {
+		client, store := setup(t)
+		require.NoError(t, store.Revoke(ctx, clientID, subject))
+
+		require.NoError(t, store.Grant(ctx, clientID, clientUID, subject, username, []string{"openid"}, now))
+		require.NoError(t, store.Revoke(ctx, clientID, subject))
+		requireGranted(t, store, clientUID, []string{"openid"}, false)
+		require.Empty(t, listSecrets(t, client, TypeLabelValue))
+	})
+
+	t.Run("pending consent can be read and deleted only once", func(t *testing.T) {
+		client, store := setup(t)
+		session := psession.NewPinnipedSession()
+		session.Fosite.Claims.Subject = subject
+		session.Custom.Username = username
+
+		id, err := store.SavePending(ctx, &Pending{
+			AuthParams:    "client_id=" + clientID,
+			CSRFToken:     "test-csrf",
+			GrantedScopes: []string{"openid"},
+			Session:       session,
+		})
+		require.NoError(t, err)
+		require.Len(t, id, 43)
+
+		pending, err := store.GetPending(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, "client_id="+clientID, pending.AuthParams)
+		require.Equal(t, []string{"openid"}, pending.GrantedScopes)
+		require.Equal(t, subject, pending.Session.Fosite.Claims.Subject)
+		require.Equal(t, username, pending.Session.Custom.Username)
+
+		secrets := listSecrets(t, client, PendingTypeLabelValue)
+		require.Len(t, secrets, 1)
+		require.NotContains(t, secrets[0].Name, id)
+		require.Equal(t, now.Add(pendingLifetime).Format(time.RFC3339),
+			secrets[0].Annotations["storage.pinniped.dev/garbage-collect-after"])
+		fromSecret, err := ReadPendingFromSecret(&secrets[0])
+		require.NoError(t, err)
+		require.Equal(t, pending, fromSecret)
+
+		require.NoError(t, store.DeletePending(ctx, id))
+		require.ErrorIs(t, store.DeletePending(ctx, id), ErrPendingNotFound)
+		_, err = store.GetPending(ctx, id)
+		require.ErrorIs(t, err, ErrPendingNotFound)
+	})
+
+	t.Run("saving pending consent fails when no random ID can be generated", func(t *testing.T) {
+		client := fake.NewSimpleClientset()
+		store := New(issuer, client.CoreV1().Secrets(namespace), &bytes.Buffer{}, time.Now)
+		_, err := store.SavePending(ctx, &Pending{Session: psession.NewPinnipedSession()})
+		require.EqualError(t, err, "could not generate pending consent ID: EOF")
+	})
+}
+
+func TestUsernameHash(t *testing.T) {
+	require.Equal(t, "b1304316434228eb4498f45c4249856c3c65be39", UsernameHash("some-username"))
+	require.Len(t, UsernameHash("a-very-long-username-which-is-longer-than-any-label-value-may-be@example.com"), 40)
+}
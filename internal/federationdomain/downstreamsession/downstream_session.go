@@ -16,6 +16,7 @@ import (
 
 	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
 	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/federationdomain/clientregistry"
 	"go.pinniped.dev/internal/federationdomain/oidc"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
 	"go.pinniped.dev/internal/idtransform"
@@ -30,14 +31,14 @@ const idTransformUnexpectedErr = constable.Error("configured identity transforma
 type SessionConfig struct {
 	UpstreamIdentity    *resolvedprovider.Identity
 	UpstreamLoginExtras *resolvedprovider.IdentityLoginExtras
-	// The ID of the client who started the new downstream session.
-	ClientID string
+	// The client who started the new downstream session.
+	Client fosite.Client
 	// The scopes that were granted for the new downstream session.
 	GrantedScopes []string
 }
 
-// NewPinnipedSession applies the configured FederationDomain identity transformations
-// and creates a downstream Pinniped session.
+// NewPinnipedSession applies the configured FederationDomain identity transformations, checks that the client
+// allows the resulting identity, and creates a downstream Pinniped session.
 func NewPinnipedSession(
 	ctx context.Context,
 	idp resolvedprovider.FederationDomainResolvedIdentityProvider,
@@ -45,12 +46,20 @@ func NewPinnipedSession(
 ) (*psession.PinnipedSession, error) {
 	now := time.Now().UTC()
 
+	// Group enrichment may use the additional claims of the user to find them in another identity provider.
 	downstreamUsername, downstreamGroups, err := applyIdentityTransformations(ctx,
-		idp.GetTransforms(), c.UpstreamIdentity.UpstreamUsername, c.UpstreamIdentity.UpstreamGroups)
+		idp.GetTransforms(), c.UpstreamIdentity.UpstreamUsername, c.UpstreamIdentity.UpstreamGroups,
+		c.UpstreamLoginExtras.DownstreamAdditionalClaims)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := clientregistry.AdmitIdentity(c.Client, idp.GetDisplayName(), downstreamUsername, downstreamGroups); err != nil {
+		plog.Debug("authentication rejected by client", "clientID", c.Client.GetID(),
+			"identityProviderDisplayName", idp.GetDisplayName(), "reason", err.Error())
+		return nil, err
+	}
+
 	customSessionData := &psession.CustomSessionData{
 		Username:         downstreamUsername,
 		UpstreamUsername: c.UpstreamIdentity.UpstreamUsername,
@@ -75,7 +84,7 @@ func NewPinnipedSession(
 
 	extras := map[string]any{}
 
-	extras[oidcapi.IDTokenClaimAuthorizedParty] = c.ClientID
+	extras[oidcapi.IDTokenClaimAuthorizedParty] = c.Client.GetID()
 
 	if slices.Contains(c.GrantedScopes, oidcapi.ScopeUsername) {
 		extras[oidcapi.IDTokenClaimUsername] = downstreamUsername
@@ -130,8 +139,9 @@ func applyIdentityTransformations(
 	transforms *idtransform.TransformationPipeline,
 	username string,
 	groups []string,
+	additionalClaims map[string]any,
 ) (string, []string, error) {
-	transformationResult, err := transforms.Evaluate(ctx, username, groups)
+	transformationResult, err := transforms.Evaluate(ctx, username, groups, additionalClaims)
 	if err != nil {
 		plog.Error("unexpected identity transformation error during authentication", err, "inputUsername", username)
 		return "", nil, idTransformUnexpectedErr
@@ -8,10 +8,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ory/fosite"
 	"github.com/stretchr/testify/require"
 
+	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
 	"go.pinniped.dev/internal/celtransformer"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedgithub"
 	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
 )
 
 func TestApplyIdentityTransformations(t *testing.T) {
@@ -82,7 +88,7 @@ func TestApplyIdentityTransformations(t *testing.T) {
 				pipeline.AppendTransformation(compiledTransform)
 			}
 
-			gotUsername, gotGroups, err := applyIdentityTransformations(context.Background(), pipeline, tt.username, tt.groups)
+			gotUsername, gotGroups, err := applyIdentityTransformations(context.Background(), pipeline, tt.username, tt.groups, nil)
 			if tt.wantErr != "" {
 				require.EqualError(t, err, tt.wantErr)
 				require.Empty(t, gotUsername)
@@ -95,3 +101,44 @@ func TestApplyIdentityTransformations(t *testing.T) {
 		})
 	}
 }
+
+func TestNewPinnipedSessionWithGroupEnrichmentUsingAdditionalClaims(t *testing.T) {
+	transformer, err := celtransformer.NewCELTransformer(5 * time.Second)
+	require.NoError(t, err)
+	key, err := transformer.CompileGroupEnrichmentKey(&celtransformer.GroupEnrichmentKey{
+		Expression: `has(additionalClaims.email) ? additionalClaims.email : ""`,
+	}, nil)
+	require.NoError(t, err)
+
+	var lookupKeys []string
+	enrichment := idtransform.NewGroupEnrichmentTransformation(key, func(_ context.Context, key string) ([]string, error) {
+		lookupKeys = append(lookupKeys, key)
+		return []string{"ldap-group"}, nil
+	})
+
+	// A GitHub user who is found in an LDAP directory by the email address of their linked SAML identity.
+	idp := &resolvedgithub.FederationDomainResolvedGitHubIdentityProvider{
+		DisplayName:         "my-github",
+		Provider:            oidctestutil.NewTestUpstreamGitHubIdentityProviderBuilder().WithName("my-github").Build(),
+		SessionProviderType: psession.ProviderTypeGitHub,
+		Transforms:          idtransform.NewTransformationPipeline().WithTransformation(enrichment),
+	}
+
+	session, err := NewPinnipedSession(context.Background(), idp, &SessionConfig{
+		UpstreamIdentity: &resolvedprovider.Identity{
+			UpstreamUsername:       "ryan",
+			UpstreamGroups:         []string{"some-org/some-team"},
+			DownstreamSubject:      "https://api.github.com?idpName=my-github&sub=1234",
+			IDPSpecificSessionData: &psession.GitHubSessionData{},
+		},
+		UpstreamLoginExtras: &resolvedprovider.IdentityLoginExtras{
+			DownstreamAdditionalClaims: map[string]any{"email": "ryan@example.com"},
+		},
+		Client:        &fosite.DefaultClient{ID: "some-client"},
+		GrantedScopes: []string{oidcapi.ScopeOpenID, oidcapi.ScopeUsername, oidcapi.ScopeGroups},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"ryan@example.com"}, lookupKeys)
+	require.Equal(t, []string{"ldap-group", "some-org/some-team"}, session.Fosite.Claims.Extra[oidcapi.IDTokenClaimGroups])
+}
@@ -32,3 +32,34 @@ func GitHub(apiBaseURL, idpDisplayName, login, id string) string {
 		url.QueryEscape(id),
 	)
 }
+
+func SAML(upstreamIDPEntityID string, upstreamNameID string, idpDisplayName string) string {
+	return fmt.Sprintf("%s?%s=%s&%s=%s", upstreamIDPEntityID,
+		oidc.IDTokenSubClaimIDPNameQueryParam, url.QueryEscape(idpDisplayName),
+		oidc.IDTokenClaimSubject, url.QueryEscape(upstreamNameID),
+	)
+}
+
+func GitLab(apiBaseURL, idpDisplayName, login, id string) string {
+	return fmt.Sprintf("%s?%s=%s&login=%s&id=%s", apiBaseURL,
+		oidc.IDTokenSubClaimIDPNameQueryParam, url.QueryEscape(idpDisplayName),
+		url.QueryEscape(login),
+		url.QueryEscape(id),
+	)
+}
+
+func OAuth2(userInfoURL, idpDisplayName, uid string) string {
+	return fmt.Sprintf("%s?%s=%s&%s=%s", userInfoURL,
+		oidc.IDTokenSubClaimIDPNameQueryParam, url.QueryEscape(idpDisplayName),
+		oidc.IDTokenClaimSubject, url.QueryEscape(uid),
+	)
+}
+
+// TrustedIssuer returns the downstream subject of a user whose identity was asserted by a JWT from an external
+// issuer which an OIDCClient trusts during token exchange. There is no identity provider display name, because
+// these users did not log in using any of the FederationDomain's identity providers.
+func TrustedIssuer(issuer string, subject string) string {
+	return fmt.Sprintf("%s?%s=%s", issuer,
+		oidc.IDTokenClaimSubject, url.QueryEscape(subject),
+	)
+}
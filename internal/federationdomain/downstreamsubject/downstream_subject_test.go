@@ -127,3 +127,143 @@ func TestGitHub(t *testing.T) {
 		})
 	}
 }
+
+func TestSAML(t *testing.T) {
+	tests := []struct {
+		name                string
+		upstreamIDPEntityID string
+		upstreamNameID      string
+		idpDisplayName      string
+		wantSubject         string
+	}{
+		{
+			name:                "simple display name",
+			upstreamIDPEntityID: "https://server.example.com:1234/saml/metadata",
+			upstreamNameID:      "some@name.id",
+			idpDisplayName:      "simpleName",
+			wantSubject:         "https://server.example.com:1234/saml/metadata?idpName=simpleName&sub=some%40name.id",
+		},
+		{
+			name:                "non-URL entity ID and interesting display name",
+			upstreamIDPEntityID: "urn:example:idp",
+			upstreamNameID:      "some name id",
+			idpDisplayName:      "this is a 👍 display name that 🦭 can handle",
+			wantSubject:         "urn:example:idp?idpName=this+is+a+%F0%9F%91%8D+display+name+that+%F0%9F%A6%AD+can+handle&sub=some+name+id",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual := SAML(test.upstreamIDPEntityID, test.upstreamNameID, test.idpDisplayName)
+
+			require.Equal(t, test.wantSubject, actual)
+		})
+	}
+}
+
+func TestGitLab(t *testing.T) {
+	tests := []struct {
+		name           string
+		apiBaseURL     string
+		idpDisplayName string
+		login          string
+		id             string
+		wantSubject    string
+	}{
+		{
+			name:           "simple display name",
+			apiBaseURL:     "https://gitlab.com/api/v4",
+			idpDisplayName: "simpleName",
+			login:          "some login",
+			id:             "some id",
+			wantSubject:    "https://gitlab.com/api/v4?idpName=simpleName&login=some+login&id=some+id",
+		},
+		{
+			name:           "interesting display name",
+			apiBaseURL:     "https://server.example.com:1234/api/v4",
+			idpDisplayName: "this is a 👍 display name that 🦭 can handle",
+			login:          "some other login",
+			id:             "some other id",
+			wantSubject:    "https://server.example.com:1234/api/v4?idpName=this+is+a+%F0%9F%91%8D+display+name+that+%F0%9F%A6%AD+can+handle&login=some+other+login&id=some+other+id",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual := GitLab(test.apiBaseURL, test.idpDisplayName, test.login, test.id)
+
+			require.Equal(t, test.wantSubject, actual)
+		})
+	}
+}
+
+func TestOAuth2(t *testing.T) {
+	tests := []struct {
+		name           string
+		userInfoURL    string
+		idpDisplayName string
+		uid            string
+		wantSubject    string
+	}{
+		{
+			name:           "simple display name",
+			userInfoURL:    "https://api.example.com/user",
+			idpDisplayName: "simpleName",
+			uid:            "some uid",
+			wantSubject:    "https://api.example.com/user?idpName=simpleName&sub=some+uid",
+		},
+		{
+			name:           "interesting display name",
+			userInfoURL:    "https://server.example.com:1234/api/v1/me",
+			idpDisplayName: "this is a 👍 display name that 🦭 can handle",
+			uid:            "some/other&uid",
+			wantSubject:    "https://server.example.com:1234/api/v1/me?idpName=this+is+a+%F0%9F%91%8D+display+name+that+%F0%9F%A6%AD+can+handle&sub=some%2Fother%26uid",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual := OAuth2(test.userInfoURL, test.idpDisplayName, test.uid)
+
+			require.Equal(t, test.wantSubject, actual)
+		})
+	}
+}
+
+func TestTrustedIssuer(t *testing.T) {
+	tests := []struct {
+		name        string
+		issuer      string
+		subject     string
+		wantSubject string
+	}{
+		{
+			name:        "simple subject",
+			issuer:      "https://ci.example.com",
+			subject:     "some-subject",
+			wantSubject: "https://ci.example.com?sub=some-subject",
+		},
+		{
+			name:        "interesting subject",
+			issuer:      "https://ci.example.com/path",
+			subject:     "repo:some-org/some-repo:ref:refs/heads/main",
+			wantSubject: "https://ci.example.com/path?sub=repo%3Asome-org%2Fsome-repo%3Aref%3Arefs%2Fheads%2Fmain",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			actual := TrustedIssuer(test.issuer, test.subject)
+
+			require.Equal(t, test.wantSubject, actual)
+		})
+	}
+}
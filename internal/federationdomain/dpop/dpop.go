@@ -0,0 +1,244 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package dpop validates the proofs of possession which clients may send to the token endpoint to bind their tokens
+// to one of their keys, as described by RFC 9449 (OAuth 2.0 Demonstrating Proof of Possession).
+package dpop
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/internal/federationdomain/jwsalgorithms"
+)
+
+const (
+	// HeaderName is the name of the HTTP request header which holds the DPoP proof.
+	HeaderName = "DPoP"
+
+	// TokenType is the token_type of token responses which contain DPoP-bound access tokens.
+	TokenType = "DPoP"
+
+	// proofType is the required value of the typ header of a DPoP proof.
+	proofType = "dpop+jwt"
+
+	// maxProofAge is how long after its iat claim that a DPoP proof is accepted. The jti of an accepted proof is
+	// remembered by a ReplayCache until the proof expires, so each proof may only be used once.
+	maxProofAge = 5 * time.Minute
+
+	// maxClockSkew is how far in the future the iat claim of a DPoP proof may be, to allow for small differences
+	// between the clocks of the client and the Supervisor.
+	maxClockSkew = time.Minute
+
+	// maxReplayCacheSize bounds the memory used by a ReplayCache.
+	maxReplayCacheSize = 10_000
+)
+
+// SupportedSigningAlgorithms are the asymmetric algorithms which may be used to sign DPoP proofs. Unlike request
+// objects, DPoP proofs are verified without fosite, so EdDSA is also supported.
+var SupportedSigningAlgorithms = append(slices.Clone(jwsalgorithms.Asymmetric), jose.EdDSA) //nolint:gochecknoglobals // This is effectively a constant.
+
+// contextKey type is unexported to prevent collisions.
+type contextKey int
+
+const thumbprintKey contextKey = iota
+
+// WithThumbprint returns a copy of the context which holds the JWK thumbprint of a validated DPoP proof, so that
+// fosite handlers which do not have access to the HTTP request may check the binding of tokens.
+func WithThumbprint(ctx context.Context, thumbprint string) context.Context {
+	return context.WithValue(ctx, thumbprintKey, thumbprint)
+}
+
+// ThumbprintFromContext returns the JWK thumbprint which was added by WithThumbprint,
+// or an empty string when the request did not have a DPoP proof.
+func ThumbprintFromContext(ctx context.Context) string {
+	thumbprint, _ := ctx.Value(thumbprintKey).(string)
+	return thumbprint
+}
+
+func errInvalidDPoPProof() *fosite.RFC6749Error {
+	return &fosite.RFC6749Error{
+		ErrorField:       "invalid_dpop_proof",
+		DescriptionField: "The DPoP proof is invalid.",
+		CodeField:        http.StatusBadRequest,
+	}
+}
+
+// ReplayCache remembers the DPoP proofs which were accepted by ValidateProof until they expire, so that a proof
+// which was observed by an attacker cannot be used again. It is thread-safe. Each Supervisor pod has its own
+// cache, so a proof could still be replayed to a different pod, but only along with the token to which it is bound.
+type ReplayCache struct {
+	mu      sync.Mutex
+	maxSize int
+	expires map[replayKey]time.Time
+}
+
+// replayKey identifies a proof by the thumbprint of its key and its jti, since the jti is chosen by the client
+// and is only unique per key.
+type replayKey struct {
+	thumbprint string
+	jti        string
+}
+
+// NewReplayCache returns an empty ReplayCache.
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{
+		maxSize: maxReplayCacheSize,
+		expires: make(map[replayKey]time.Time),
+	}
+}
+
+// markUsed remembers the proof until it expires. It returns false when the proof was already used.
+func (c *ReplayCache) markUsed(key replayKey, expires time.Time, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if previousExpires, ok := c.expires[key]; ok && now.Before(previousExpires) {
+		return false
+	}
+
+	if len(c.expires) >= c.maxSize {
+		c.evict(now)
+	}
+	c.expires[key] = expires
+	return true
+}
+
+// evict forgets all expired proofs. When none have expired, it forgets the proof which will expire the soonest
+// to stay within the maximum size, since that proof is the one which could be replayed for the shortest time.
+func (c *ReplayCache) evict(now time.Time) {
+	var soonestKey replayKey
+	var soonestExpires time.Time
+	for key, expires := range c.expires {
+		if !now.Before(expires) {
+			delete(c.expires, key)
+			continue
+		}
+		if soonestExpires.IsZero() || expires.Before(soonestExpires) {
+			soonestKey, soonestExpires = key, expires
+		}
+	}
+	if len(c.expires) >= c.maxSize {
+		delete(c.expires, soonestKey)
+	}
+}
+
+type proofClaims struct {
+	JTI string `json:"jti"`
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	IAT *int64 `json:"iat"`
+}
+
+// ValidateProof validates the DPoP proof of the request, if it has one, as described by RFC 9449 section 4.3.
+// The wantURL is the URL of the endpoint which is handling the request. It returns the base64url-encoded
+// SHA-256 JWK thumbprint (RFC 7638) of the public key which signed the proof, or an empty string when the
+// request does not have a DPoP proof. Proofs which were already accepted by the same ReplayCache are rejected.
+func ValidateProof(r *http.Request, wantURL string, now time.Time, replays *ReplayCache) (string, error) {
+	proofs := r.Header.Values(HeaderName)
+	switch len(proofs) {
+	case 0:
+		return "", nil
+	case 1:
+	default:
+		return "", errInvalidDPoPProof().WithHint("Only one DPoP header is allowed.")
+	}
+
+	jws, err := jose.ParseSigned(proofs[0], SupportedSigningAlgorithms)
+	if err != nil {
+		return "", errInvalidDPoPProof().WithHint("The DPoP proof could not be parsed as a JWT signed by a supported algorithm.").WithWrap(err).WithDebug(err.Error())
+	}
+	if len(jws.Signatures) != 1 {
+		return "", errInvalidDPoPProof().WithHint("The DPoP proof must have exactly one signature.")
+	}
+	header := jws.Signatures[0].Protected
+
+	if typ, _ := header.ExtraHeaders[jose.HeaderType].(string); typ != proofType {
+		return "", errInvalidDPoPProof().WithHintf("The 'typ' header of the DPoP proof must be %q.", proofType)
+	}
+	// A jwk header which contains a private key was already rejected by the parser.
+	jwk := header.JSONWebKey
+	if jwk == nil || !jwk.Valid() {
+		return "", errInvalidDPoPProof().WithHint("The 'jwk' header of the DPoP proof must be a valid public key.")
+	}
+
+	payload, err := jws.Verify(jwk)
+	if err != nil {
+		return "", errInvalidDPoPProof().WithHint("The signature of the DPoP proof could not be verified.").WithWrap(err).WithDebug(err.Error())
+	}
+
+	var claims proofClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", errInvalidDPoPProof().WithHint("The claims of the DPoP proof could not be parsed.").WithWrap(err).WithDebug(err.Error())
+	}
+	if claims.JTI == "" {
+		return "", errInvalidDPoPProof().WithHint("The DPoP proof must have a 'jti' claim.")
+	}
+	if claims.HTM != r.Method {
+		return "", errInvalidDPoPProof().WithHint("The 'htm' claim of the DPoP proof must match the method of the request.")
+	}
+	if !sameURL(claims.HTU, wantURL) {
+		return "", errInvalidDPoPProof().WithHint("The 'htu' claim of the DPoP proof must match the URL of the request.")
+	}
+	if claims.IAT == nil {
+		return "", errInvalidDPoPProof().WithHint("The DPoP proof must have an 'iat' claim.")
+	}
+	issuedAt := time.Unix(*claims.IAT, 0)
+	if issuedAt.After(now.Add(maxClockSkew)) || issuedAt.Before(now.Add(-maxProofAge)) {
+		return "", errInvalidDPoPProof().WithHint("The DPoP proof was not issued recently.")
+	}
+
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", errInvalidDPoPProof().WithHint("Unable to compute the thumbprint of the DPoP proof's key.").WithWrap(err).WithDebug(err.Error())
+	}
+	encodedThumbprint := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	// The proof is no longer accepted after maxProofAge, so there is no need to remember it any longer than that.
+	if !replays.markUsed(replayKey{thumbprint: encodedThumbprint, jti: claims.JTI}, issuedAt.Add(maxProofAge), now) {
+		return "", errInvalidDPoPProof().WithHint("The DPoP proof was already used.")
+	}
+	return encodedThumbprint, nil
+}
+
+// CheckBinding checks that the DPoP proof of a request was signed by the key to which a token was bound.
+// A token which was not bound may be used with or without a DPoP proof.
+func CheckBinding(boundThumbprint string, proofThumbprint string) error {
+	switch {
+	case boundThumbprint == "":
+		return nil
+	case proofThumbprint == "":
+		return errInvalidDPoPProof().WithHint("A DPoP proof is required because the token is bound to a DPoP key.")
+	case proofThumbprint != boundThumbprint:
+		return errInvalidDPoPProof().WithHint("The DPoP proof was not signed by the key to which the token is bound.")
+	default:
+		return nil
+	}
+}
+
+// sameURL compares the htu claim of a DPoP proof to the URL of the endpoint, ignoring any query and fragment,
+// and ignoring the case of the scheme and host, as described by RFC 9449 section 4.3.
+func sameURL(htu string, want string) bool {
+	htuURL, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	wantURL, err := url.Parse(want)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(htuURL.Scheme, wantURL.Scheme) &&
+		strings.EqualFold(htuURL.Host, wantURL.Host) &&
+		htuURL.Path == wantURL.Path
+}
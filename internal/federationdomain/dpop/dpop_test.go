@@ -0,0 +1,337 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package dpop
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+
+	clientdpop "go.pinniped.dev/pkg/oidcclient/dpop"
+)
+
+const tokenURL = "https://issuer.example.com/some/path/oauth2/token"
+
+func TestValidateProof(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwkThumbprint, err := (&jose.JSONWebKey{Key: key.Public()}).Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	wantThumbprint := base64.RawURLEncoding.EncodeToString(jwkThumbprint)
+
+	now := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	newProof := func(t *testing.T, method string, targetURL string, iat time.Time) string {
+		proof, err := clientdpop.NewProof(key, method, targetURL, iat)
+		require.NoError(t, err)
+		return proof
+	}
+
+	signProof := func(t *testing.T, opts *jose.SignerOptions, signingKey any, claims map[string]any) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: signingKey}, opts)
+		require.NoError(t, err)
+		proof, err := jwt.Signed(signer).Claims(claims).Serialize()
+		require.NoError(t, err)
+		return proof
+	}
+
+	validClaims := func() map[string]any {
+		return map[string]any{"jti": "some-jti", "htm": "POST", "htu": tokenURL, "iat": now.Unix()}
+	}
+
+	tests := []struct {
+		name           string
+		proofs         func(t *testing.T) []string
+		wantThumbprint string
+		wantErrHint    string
+	}{
+		{
+			name:   "no proof",
+			proofs: func(t *testing.T) []string { return nil },
+		},
+		{
+			name:           "valid proof",
+			proofs:         func(t *testing.T) []string { return []string{newProof(t, http.MethodPost, tokenURL, now)} },
+			wantThumbprint: wantThumbprint,
+		},
+		{
+			name: "valid proof with differently cased scheme and host",
+			proofs: func(t *testing.T) []string {
+				return []string{newProof(t, http.MethodPost, "HTTPS://ISSUER.example.com/some/path/oauth2/token", now)}
+			},
+			wantThumbprint: wantThumbprint,
+		},
+		{
+			name: "valid proof with some clock skew",
+			proofs: func(t *testing.T) []string {
+				return []string{newProof(t, http.MethodPost, tokenURL, now.Add(30*time.Second))}
+			},
+			wantThumbprint: wantThumbprint,
+		},
+		{
+			name: "more than one proof",
+			proofs: func(t *testing.T) []string {
+				return []string{newProof(t, http.MethodPost, tokenURL, now), newProof(t, http.MethodPost, tokenURL, now)}
+			},
+			wantErrHint: "Only one DPoP header is allowed.",
+		},
+		{
+			name:        "not a JWT",
+			proofs:      func(t *testing.T) []string { return []string{"not-a-jwt"} },
+			wantErrHint: "The DPoP proof could not be parsed as a JWT signed by a supported algorithm.",
+		},
+		{
+			name: "wrong typ header",
+			proofs: func(t *testing.T) []string {
+				return []string{signProof(t, (&jose.SignerOptions{EmbedJWK: true}).WithType("JWT"), key, validClaims())}
+			},
+			wantErrHint: `The 'typ' header of the DPoP proof must be "dpop+jwt".`,
+		},
+		{
+			name: "no jwk header",
+			proofs: func(t *testing.T) []string {
+				return []string{signProof(t, (&jose.SignerOptions{}).WithType("dpop+jwt"), key, validClaims())}
+			},
+			wantErrHint: "The 'jwk' header of the DPoP proof must be a valid public key.",
+		},
+		{
+			name: "private key in jwk header",
+			proofs: func(t *testing.T) []string {
+				return []string{signProof(t, (&jose.SignerOptions{}).WithType("dpop+jwt").WithHeader("jwk", &jose.JSONWebKey{Key: key}), key, validClaims())}
+			},
+			wantErrHint: "The DPoP proof could not be parsed as a JWT signed by a supported algorithm.",
+		},
+		{
+			name: "signed by a different key than the jwk header",
+			proofs: func(t *testing.T) []string {
+				otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+				require.NoError(t, err)
+				return []string{signProof(t, (&jose.SignerOptions{}).WithType("dpop+jwt").WithHeader("jwk", &jose.JSONWebKey{Key: key.Public()}), otherKey, validClaims())}
+			},
+			wantErrHint: "The signature of the DPoP proof could not be verified.",
+		},
+		{
+			name: "missing jti",
+			proofs: func(t *testing.T) []string {
+				claims := validClaims()
+				delete(claims, "jti")
+				return []string{signProof(t, (&jose.SignerOptions{EmbedJWK: true}).WithType("dpop+jwt"), key, claims)}
+			},
+			wantErrHint: "The DPoP proof must have a 'jti' claim.",
+		},
+		{
+			name: "missing iat",
+			proofs: func(t *testing.T) []string {
+				claims := validClaims()
+				delete(claims, "iat")
+				return []string{signProof(t, (&jose.SignerOptions{EmbedJWK: true}).WithType("dpop+jwt"), key, claims)}
+			},
+			wantErrHint: "The DPoP proof must have an 'iat' claim.",
+		},
+		{
+			name:        "wrong method",
+			proofs:      func(t *testing.T) []string { return []string{newProof(t, http.MethodGet, tokenURL, now)} },
+			wantErrHint: "The 'htm' claim of the DPoP proof must match the method of the request.",
+		},
+		{
+			name: "wrong URL",
+			proofs: func(t *testing.T) []string {
+				return []string{newProof(t, http.MethodPost, "https://issuer.example.com/other", now)}
+			},
+			wantErrHint: "The 'htu' claim of the DPoP proof must match the URL of the request.",
+		},
+		{
+			name: "issued too long ago",
+			proofs: func(t *testing.T) []string {
+				return []string{newProof(t, http.MethodPost, tokenURL, now.Add(-6*time.Minute))}
+			},
+			wantErrHint: "The DPoP proof was not issued recently.",
+		},
+		{
+			name: "issued too far in the future",
+			proofs: func(t *testing.T) []string {
+				return []string{newProof(t, http.MethodPost, tokenURL, now.Add(2*time.Minute))}
+			},
+			wantErrHint: "The DPoP proof was not issued recently.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tokenURL, nil)
+			for _, proof := range tt.proofs(t) {
+				req.Header.Add(HeaderName, proof)
+			}
+
+			thumbprint, err := ValidateProof(req, tokenURL, now, NewReplayCache())
+
+			if tt.wantErrHint != "" {
+				require.Error(t, err)
+				rfcErr := fosite.ErrorToRFC6749Error(err)
+				require.Equal(t, "invalid_dpop_proof", rfcErr.ErrorField)
+				require.Equal(t, http.StatusBadRequest, rfcErr.CodeField)
+				require.Equal(t, tt.wantErrHint, rfcErr.HintField)
+				require.Empty(t, thumbprint)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantThumbprint, thumbprint)
+		})
+	}
+}
+
+func TestValidateProofRejectsReplays(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	now := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	signProof := func(t *testing.T, signingKey *ecdsa.PrivateKey, jti string, iat time.Time) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: signingKey}, (&jose.SignerOptions{EmbedJWK: true}).WithType("dpop+jwt"))
+		require.NoError(t, err)
+		proof, err := jwt.Signed(signer).Claims(map[string]any{"jti": jti, "htm": "POST", "htu": tokenURL, "iat": iat.Unix()}).Serialize()
+		require.NoError(t, err)
+		return proof
+	}
+
+	validate := func(t *testing.T, replays *ReplayCache, proof string, now time.Time) error {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, tokenURL, nil)
+		req.Header.Set(HeaderName, proof)
+		_, err := ValidateProof(req, tokenURL, now, replays)
+		return err
+	}
+
+	requireReplayErr := func(t *testing.T, err error) {
+		t.Helper()
+		require.Error(t, err)
+		require.Equal(t, "The DPoP proof was already used.", fosite.ErrorToRFC6749Error(err).HintField)
+	}
+
+	t.Run("the same proof is only accepted once", func(t *testing.T) {
+		replays := NewReplayCache()
+		proof := signProof(t, key, "some-jti", now)
+		require.NoError(t, validate(t, replays, proof, now))
+		requireReplayErr(t, validate(t, replays, proof, now))
+		requireReplayErr(t, validate(t, replays, proof, now.Add(maxProofAge-time.Second)))
+	})
+
+	t.Run("a different proof with the same jti and key is rejected", func(t *testing.T) {
+		replays := NewReplayCache()
+		require.NoError(t, validate(t, replays, signProof(t, key, "some-jti", now), now))
+		requireReplayErr(t, validate(t, replays, signProof(t, key, "some-jti", now.Add(time.Second)), now.Add(time.Second)))
+	})
+
+	t.Run("proofs with the same jti signed by different keys are both accepted", func(t *testing.T) {
+		replays := NewReplayCache()
+		require.NoError(t, validate(t, replays, signProof(t, key, "some-jti", now), now))
+		require.NoError(t, validate(t, replays, signProof(t, otherKey, "some-jti", now), now))
+	})
+
+	t.Run("a proof issued in the future is remembered until it expires", func(t *testing.T) {
+		replays := NewReplayCache()
+		proof := signProof(t, key, "some-jti", now.Add(maxClockSkew))
+		require.NoError(t, validate(t, replays, proof, now))
+		requireReplayErr(t, validate(t, replays, proof, now.Add(maxClockSkew+maxProofAge-time.Second)))
+	})
+
+	t.Run("a replayed proof which has expired is rejected for its age", func(t *testing.T) {
+		replays := NewReplayCache()
+		proof := signProof(t, key, "some-jti", now)
+		require.NoError(t, validate(t, replays, proof, now))
+		err := validate(t, replays, proof, now.Add(maxProofAge+time.Second))
+		require.Error(t, err)
+		require.Equal(t, "The DPoP proof was not issued recently.", fosite.ErrorToRFC6749Error(err).HintField)
+	})
+
+	t.Run("proofs are not remembered when they are rejected for another reason", func(t *testing.T) {
+		replays := NewReplayCache()
+		req := httptest.NewRequest(http.MethodPost, tokenURL, nil)
+		req.Header.Set(HeaderName, signProof(t, key, "some-jti", now))
+		_, err := ValidateProof(req, "https://issuer.example.com/other", now, replays)
+		require.Error(t, err)
+		require.Empty(t, replays.expires)
+	})
+}
+
+func TestReplayCacheSizeIsBounded(t *testing.T) {
+	now := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	replays := NewReplayCache()
+	replays.maxSize = 3
+
+	key := func(jti string) replayKey { return replayKey{thumbprint: "some-thumbprint", jti: jti} }
+
+	require.True(t, replays.markUsed(key("expired"), now.Add(-time.Second), now.Add(-time.Minute)))
+	require.True(t, replays.markUsed(key("expires-soon"), now.Add(time.Minute), now))
+	require.True(t, replays.markUsed(key("expires-later"), now.Add(2*time.Minute), now))
+	require.Len(t, replays.expires, 3)
+
+	// When the cache is full, expired proofs are forgotten first.
+	require.True(t, replays.markUsed(key("new-1"), now.Add(3*time.Minute), now))
+	require.Len(t, replays.expires, 3)
+	require.NotContains(t, replays.expires, key("expired"))
+
+	// When nothing has expired, the proof which expires the soonest is forgotten.
+	require.True(t, replays.markUsed(key("new-2"), now.Add(4*time.Minute), now))
+	require.Len(t, replays.expires, 3)
+	require.NotContains(t, replays.expires, key("expires-soon"))
+
+	require.False(t, replays.markUsed(key("expires-later"), now.Add(2*time.Minute), now))
+	require.False(t, replays.markUsed(key("new-1"), now.Add(3*time.Minute), now))
+	require.False(t, replays.markUsed(key("new-2"), now.Add(4*time.Minute), now))
+}
+
+func TestCheckBinding(t *testing.T) {
+	tests := []struct {
+		name        string
+		bound       string
+		proof       string
+		wantErrHint string
+	}{
+		{name: "unbound token without proof"},
+		{name: "unbound token with proof", proof: "some-thumbprint"},
+		{name: "bound token with proof from the same key", bound: "some-thumbprint", proof: "some-thumbprint"},
+		{
+			name:        "bound token without proof",
+			bound:       "some-thumbprint",
+			wantErrHint: "A DPoP proof is required because the token is bound to a DPoP key.",
+		},
+		{
+			name:        "bound token with proof from a different key",
+			bound:       "some-thumbprint",
+			proof:       "other-thumbprint",
+			wantErrHint: "The DPoP proof was not signed by the key to which the token is bound.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckBinding(tt.bound, tt.proof)
+			if tt.wantErrHint == "" {
+				require.NoError(t, err)
+				return
+			}
+			rfcErr := fosite.ErrorToRFC6749Error(err)
+			require.Equal(t, "invalid_dpop_proof", rfcErr.ErrorField)
+			require.Equal(t, tt.wantErrHint, rfcErr.HintField)
+		})
+	}
+}
+
+func TestThumbprintContext(t *testing.T) {
+	require.Empty(t, ThumbprintFromContext(context.Background()))
+	require.Equal(t, "some-thumbprint", ThumbprintFromContext(WithThumbprint(context.Background(), "some-thumbprint")))
+}
@@ -6,8 +6,12 @@ package auth
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ory/fosite"
@@ -15,28 +19,33 @@ import (
 	fositejwt "github.com/ory/fosite/token/jwt"
 
 	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
+	"go.pinniped.dev/internal/federationdomain/clientregistry"
 	"go.pinniped.dev/internal/federationdomain/csrftoken"
 	"go.pinniped.dev/internal/federationdomain/downstreamsession"
 	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
 	"go.pinniped.dev/internal/federationdomain/formposthtml"
+	"go.pinniped.dev/internal/federationdomain/loginlimiter"
 	"go.pinniped.dev/internal/federationdomain/oidc"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedldap"
 	"go.pinniped.dev/internal/httputil/responseutil"
 	"go.pinniped.dev/internal/httputil/securityheader"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/totp"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/pkce"
 )
 
 const (
-	promptParamName = "prompt"
-	promptParamNone = "none"
+	promptParamName          = "prompt"
+	promptParamNone          = "none"
+	promptParamSelectAccount = "select_account"
 )
 
 type authorizeHandler struct {
 	downstreamIssuerURL       string
-	idpFinder                 federationdomainproviders.FederationDomainIdentityProvidersFinderI
+	idpFinder                 federationdomainproviders.FederationDomainIdentityProvidersListerFinderI
 	oauthHelperWithoutStorage fosite.OAuth2Provider
 	oauthHelperWithStorage    fosite.OAuth2Provider
 	generateCSRF              func() (csrftoken.CSRFToken, error)
@@ -44,11 +53,17 @@ type authorizeHandler struct {
 	generateNonce             func() (nonce.Nonce, error)
 	upstreamStateEncoder      oidc.Encoder
 	cookieCodec               oidc.Codec
+	totpEnrollments           *totp.Enrollments
+	loginLimiter              *loginlimiter.Limiter
+	idpChooser                federationdomainproviders.IdentityProviderChooser
+	lastIDPCookieCodec        oidc.Codec
+	lastIDPCookiePath         string
+	clients                   fosite.ClientManager
 }
 
 func NewHandler(
 	downstreamIssuerURL string,
-	idpFinder federationdomainproviders.FederationDomainIdentityProvidersFinderI,
+	idpFinder federationdomainproviders.FederationDomainIdentityProvidersListerFinderI,
 	oauthHelperWithoutStorage fosite.OAuth2Provider,
 	oauthHelperWithStorage fosite.OAuth2Provider,
 	generateCSRF func() (csrftoken.CSRFToken, error),
@@ -56,6 +71,11 @@ func NewHandler(
 	generateNonce func() (nonce.Nonce, error),
 	upstreamStateEncoder oidc.Encoder,
 	cookieCodec oidc.Codec,
+	totpEnrollments *totp.Enrollments,
+	loginLimiter *loginlimiter.Limiter,
+	idpChooser federationdomainproviders.IdentityProviderChooser,
+	lastIDPCookieCodec oidc.Codec,
+	clients fosite.ClientManager,
 ) http.Handler {
 	h := &authorizeHandler{
 		downstreamIssuerURL:       downstreamIssuerURL,
@@ -67,11 +87,17 @@ func NewHandler(
 		generateNonce:             generateNonce,
 		upstreamStateEncoder:      upstreamStateEncoder,
 		cookieCodec:               cookieCodec,
+		totpEnrollments:           totpEnrollments,
+		loginLimiter:              loginLimiter,
+		idpChooser:                idpChooser,
+		lastIDPCookieCodec:        lastIDPCookieCodec,
+		lastIDPCookiePath:         lastIDPCookiePath(downstreamIssuerURL),
+		clients:                   clients,
 	}
 	// During a response_mode=form_post auth request using the browser flow, the custom form_post html page may
 	// be used to post certain errors back to the CLI from this handler's response, so allow the form_post
 	// page's CSS and JS to run.
-	return securityheader.WrapWithCustomCSP(h, formposthtml.ContentSecurityPolicy())
+	return securityheader.WrapWithCustomCSPFunc(h, formposthtml.ContentSecurityPolicyForRequest)
 }
 
 func (h *authorizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -120,13 +146,16 @@ func (h *authorizeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Check if we are in a special case where we should inject an interstitial page to ask the user
 	// which IDP they would like to use.
 	if shouldShowIDPChooser(h.idpFinder, idpNameQueryParamValue, requestedBrowserlessFlow) {
-		// Redirect to the IDP chooser page with all the same query/form params. When the user chooses an IDP,
-		// it will redirect back to here with all the same params again, with the pinniped_idp_name param added.
-		http.Redirect(w, r,
-			fmt.Sprintf("%s%s?%s", h.downstreamIssuerURL, oidc.ChooseIDPEndpointPath, r.Form.Encode()),
-			http.StatusSeeOther,
-		)
-		return
+		idpNameQueryParamValue = h.preselectIDP(r, h.lookupClient(r))
+		if idpNameQueryParamValue == "" {
+			// Redirect to the IDP chooser page with all the same query/form params. When the user chooses an IDP,
+			// it will redirect back to here with all the same params again, with the pinniped_idp_name param added.
+			http.Redirect(w, r,
+				fmt.Sprintf("%s%s?%s", h.downstreamIssuerURL, oidc.ChooseIDPEndpointPath, r.Form.Encode()),
+				http.StatusSeeOther,
+			)
+			return
+		}
 	}
 
 	idp, err := chooseUpstreamIDP(idpNameQueryParamValue, h.idpFinder)
@@ -158,12 +187,41 @@ func (h *authorizeHandler) authorize(
 		oauthHelper = h.oauthHelperWithStorage
 	}
 
+	// Remember what the client sent to this endpoint, because fosite will add the params from the client's pushed
+	// authorization request or request object to the request form.
+	usedPushedAuthorizationRequest := strings.HasPrefix(r.Form.Get("request_uri"), oidc.PushedAuthorizationRequestURIPrefix)
+	requestObject := r.Form.Get(oidc.RequestObjectParamName)
+
 	authorizeRequester, err := oauthHelper.NewAuthorizeRequest(r.Context(), r)
 	if err != nil {
 		oidc.WriteAuthorizeError(r, w, oauthHelper, authorizeRequester, err, requestedBrowserlessFlow)
 		return
 	}
 
+	if usedPushedAuthorizationRequest {
+		// Fosite ignores any request object sent along with a request_uri. Any request object sent to the pushed
+		// authorization request endpoint was already validated there.
+		requestObject = ""
+	} else if clientregistry.RequiresPushedAuthorizationRequests(authorizeRequester.GetClient()) {
+		oidc.WriteAuthorizeError(r, w, oauthHelper, authorizeRequester,
+			fosite.ErrInvalidRequest.WithHintf("This client must use the pushed authorization request endpoint %s.",
+				h.downstreamIssuerURL+oidc.PushedAuthorizationRequestEndpointPath),
+			requestedBrowserlessFlow)
+		return
+	}
+
+	if err := oidc.ValidateRequestObject(requestObject, authorizeRequester, h.downstreamIssuerURL); err != nil {
+		oidc.WriteAuthorizeError(r, w, oauthHelper, authorizeRequester, err, requestedBrowserlessFlow)
+		return
+	}
+
+	if !clientregistry.IdentityProviderAllowed(authorizeRequester.GetClient(), idp.GetDisplayName()) {
+		oidc.WriteAuthorizeError(r, w, oauthHelper, authorizeRequester,
+			fosite.ErrAccessDenied.WithHintf("This client is not allowed to use the identity provider %q.", idp.GetDisplayName()),
+			requestedBrowserlessFlow)
+		return
+	}
+
 	// Automatically grant certain scopes, but only if they were requested.
 	// Grant the openid scope (for now) if they asked for it so that `NewAuthorizeResponse` will perform its OIDC validations.
 	// There don't seem to be any validations inside `NewAuthorizeResponse` related to the offline_access scope
@@ -198,15 +256,50 @@ func (h *authorizeHandler) authorizeWithoutBrowser(
 		return err
 	}
 
+	// Refuse the login before contacting the upstream when there were too many recent failed logins.
+	// Otherwise, reserve the attempt, so that concurrent attempts are counted before the password is checked.
+	reservation, wait, err := h.loginLimiter.Reserve(r.Context(), r, submittedUsername)
+	if err != nil {
+		plog.WarningErr("error checking failed logins", err, "upstreamName", idp.GetProvider().GetResourceName())
+		return fosite.ErrServerError.WithHint("Could not check for failed logins.").WithWrap(err)
+	}
+	if wait > 0 {
+		plog.Info("refusing login due to too many failed logins",
+			"upstreamName", idp.GetProvider().GetResourceName(), "retryAfter", wait)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		responseutil.HTTPErrorf(w, http.StatusTooManyRequests, "too many failed login attempts, try again later")
+		return nil
+	}
+	// Release the reservation when the attempt was neither counted as a failure nor as a success.
+	defer func() {
+		if err := reservation.Release(r.Context()); err != nil {
+			plog.WarningErr("error releasing login attempt", err, "upstreamName", idp.GetProvider().GetResourceName())
+		}
+	}()
+
 	identity, loginExtras, err := idp.Login(r.Context(), submittedUsername, submittedPassword)
 	if err != nil {
+		if loginlimiter.IsFailedLogin(err) {
+			if recordErr := reservation.RecordFailure(r.Context()); recordErr != nil {
+				plog.WarningErr("error recording failed login", recordErr, "upstreamName", idp.GetProvider().GetResourceName())
+			}
+		}
+		return err
+	}
+
+	// The failed logins are only forgotten once the one-time password was also accepted, so that knowing the
+	// password does not allow unlimited guessing of one-time passwords.
+	if err := h.checkTOTPCodeHeader(r, idp, identity, reservation); err != nil {
 		return err
 	}
+	if err := reservation.RecordSuccess(r.Context()); err != nil {
+		plog.WarningErr("error forgetting failed logins", err, "upstreamName", idp.GetProvider().GetResourceName())
+	}
 
 	session, err := downstreamsession.NewPinnipedSession(r.Context(), idp, &downstreamsession.SessionConfig{
 		UpstreamIdentity:    identity,
 		UpstreamLoginExtras: loginExtras,
-		ClientID:            authorizeRequester.GetClient().GetID(),
+		Client:              authorizeRequester.GetClient(),
 		GrantedScopes:       authorizeRequester.GetGrantedScopes(),
 	})
 	if err != nil {
@@ -245,6 +338,13 @@ func (h *authorizeHandler) authorizeWithBrowser(
 		return err
 	}
 
+	if h.idpChooser.RememberLastChosen {
+		// Remembering the identity provider is only a convenience, so do not fail the login when it cannot be done.
+		if err := addLastIDPSetCookieHeader(w, idp.GetDisplayName(), h.lastIDPCookiePath, h.lastIDPCookieCodec); err != nil {
+			plog.WarningErr("error setting last chosen identity provider cookie", err)
+		}
+	}
+
 	http.Redirect(w, r, redirectURL,
 		http.StatusSeeOther, // match fosite and https://tools.ietf.org/id/draft-ietf-oauth-security-topics-18.html#section-4.11
 	)
@@ -252,6 +352,46 @@ func (h *authorizeHandler) authorizeWithBrowser(
 	return nil
 }
 
+// checkTOTPCodeHeader enforces the TOTP policy of LDAP and ActiveDirectory identity providers after the end user's
+// username and password were accepted. When the end user must provide a one-time password but did not send one,
+// the interaction_required error tells the CLI to prompt for one and try again.
+func (h *authorizeHandler) checkTOTPCodeHeader(
+	r *http.Request,
+	idp resolvedprovider.FederationDomainResolvedIdentityProvider,
+	identity *resolvedprovider.Identity,
+	reservation *loginlimiter.Reservation,
+) error {
+	ldapIDP, ok := idp.(*resolvedldap.FederationDomainResolvedLDAPIdentityProvider)
+	if !ok {
+		return nil
+	}
+
+	result, err := h.totpEnrollments.Check(r.Context(), ldapIDP.TOTPPolicy, identity.DownstreamSubject,
+		r.Header.Get(oidcapi.AuthorizeTOTPCodeHeaderName))
+	if err != nil {
+		plog.WarningErr("error checking one-time password", err, "upstreamName", idp.GetProvider().GetResourceName())
+		return fosite.ErrServerError.WithHint("Unable to check one-time password.").WithWrap(err)
+	}
+
+	switch result {
+	case totp.CheckPassed:
+		return nil
+	case totp.CheckCodeRequired:
+		return fosite.ErrInteractionRequired.WithHint("A one-time password from your authenticator app is required.")
+	case totp.CheckEnrollmentRequired:
+		return fosite.ErrAccessDenied.WithHintf(
+			"A one-time password is required, but you have not enrolled an authenticator app. Enroll at %s and try again.",
+			h.downstreamIssuerURL+oidc.PinnipedTOTPEnrollPath)
+	case totp.CheckCodeNotAccepted:
+		fallthrough
+	default:
+		if recordErr := reservation.RecordFailure(r.Context()); recordErr != nil {
+			plog.WarningErr("error recording failed login", recordErr, "upstreamName", idp.GetProvider().GetResourceName())
+		}
+		return fosite.ErrAccessDenied.WithHint("One-time password not accepted.")
+	}
+}
+
 func shouldShowIDPChooser(
 	idpFinder federationdomainproviders.FederationDomainIdentityProvidersFinderI,
 	idpNameQueryParamValue string,
@@ -266,6 +406,55 @@ func shouldShowIDPChooser(
 		!inBackwardsCompatMode && federationDomainSpecHasSomeValidIDPs
 }
 
+// preselectIDP returns the display name of the identity provider which should be used without showing the IDP
+// chooser page, or an empty string when the end user should choose. Only identity providers which the client
+// is allowed to use are considered.
+func (h *authorizeHandler) preselectIDP(r *http.Request, client fosite.Client) string {
+	if slices.Contains(strings.Fields(r.Form.Get(promptParamName)), promptParamSelectAccount) {
+		// The client asked for the end user to choose.
+		return ""
+	}
+
+	// Unknown hints are ignored, since the end user can still choose.
+	if hint := r.Form.Get(oidcapi.AuthorizeUpstreamIDPHintParamName); hint != "" && h.idpEligible(hint, client) {
+		return hint
+	}
+
+	if !h.idpChooser.AutoRedirect {
+		return ""
+	}
+	if h.idpChooser.RememberLastChosen {
+		if lastIDP := readLastIDPCookie(r, h.lastIDPCookieCodec); lastIDP != "" && h.idpEligible(lastIDP, client) {
+			return lastIDP
+		}
+	}
+	var eligibleIDPs []string
+	for _, idp := range h.idpFinder.GetIdentityProviders() {
+		if clientregistry.IdentityProviderAllowed(client, idp.GetDisplayName()) {
+			eligibleIDPs = append(eligibleIDPs, idp.GetDisplayName())
+		}
+	}
+	if len(eligibleIDPs) == 1 {
+		return eligibleIDPs[0]
+	}
+	return ""
+}
+
+func (h *authorizeHandler) idpEligible(displayName string, client fosite.Client) bool {
+	_, err := h.idpFinder.FindUpstreamIDPByDisplayName(displayName)
+	return err == nil && clientregistry.IdentityProviderAllowed(client, displayName)
+}
+
+// lookupClient returns the client which made the authorization request, or nil when it cannot be found.
+// Unknown or invalid clients will be rejected by fosite later, so lookup errors are not reported here.
+func (h *authorizeHandler) lookupClient(r *http.Request) fosite.Client {
+	client, err := h.clients.GetClient(r.Context(), r.Form.Get("client_id"))
+	if err != nil {
+		return nil
+	}
+	return client
+}
+
 func requireStaticClientForUsernameAndPasswordHeaders(authorizeRequester fosite.AuthorizeRequester) error {
 	if !(authorizeRequester.GetClient().GetID() == oidcapi.ClientIDPinnipedCLI) {
 		return fosite.ErrAccessDenied.WithHint("This client is not allowed to submit username or password headers to this endpoint.")
@@ -301,6 +490,22 @@ func readCSRFCookie(r *http.Request, codec oidc.Decoder) csrftoken.CSRFToken {
 	return csrfFromCookie
 }
 
+func readLastIDPCookie(r *http.Request, codec oidc.Decoder) string {
+	receivedCookie, err := r.Cookie(oidc.LastIDPCookieName)
+	if err != nil {
+		// Error means that the cookie was not found
+		return ""
+	}
+
+	var lastIDP string
+	if err := codec.Decode(oidc.LastIDPCookieEncodingName, receivedCookie.Value, &lastIDP); err != nil {
+		// An expired or otherwise invalid cookie is ignored, and will be replaced after the end user chooses again.
+		return ""
+	}
+
+	return lastIDP
+}
+
 // chooseUpstreamIDP selects an upstream IDP, or returns an error.
 func chooseUpstreamIDP(idpDisplayName string, idpLister federationdomainproviders.FederationDomainIdentityProvidersFinderI) (
 	resolvedprovider.FederationDomainResolvedIdentityProvider,
@@ -432,7 +637,9 @@ func upstreamStateParam(
 		// that are reading from the encoded upstream state param being built here.
 		// The UpstreamName and UpstreamType struct fields can be used instead.
 		// Remove those params here to avoid potential confusion about which should be used later.
-		AuthParams:    removeCustomIDPParams(authorizeRequester.GetRequestForm()).Encode(),
+		// Also remove the request_uri and request params, because the params of the pushed authorization request
+		// or request object were already added to the auth params, and the request_uri may only be used once.
+		AuthParams:    removeUnneededParams(authorizeRequester.GetRequestForm()).Encode(),
 		UpstreamName:  upstreamDisplayName,
 		UpstreamType:  upstreamType,
 		Nonce:         nonceValue,
@@ -447,7 +654,7 @@ func upstreamStateParam(
 	return encodedStateParamValue, nil
 }
 
-func removeCustomIDPParams(params url.Values) url.Values {
+func removeUnneededParams(params url.Values) url.Values {
 	p := url.Values{}
 	// Copy all params.
 	for k, v := range params {
@@ -456,6 +663,8 @@ func removeCustomIDPParams(params url.Values) url.Values {
 	// Remove the unnecessary params.
 	delete(p, oidcapi.AuthorizeUpstreamIDPNameParamName)
 	delete(p, oidcapi.AuthorizeUpstreamIDPTypeParamName)
+	delete(p, "request_uri")
+	delete(p, oidc.RequestObjectParamName)
 	return p
 }
 
@@ -476,3 +685,35 @@ func addCSRFSetCookieHeader(w http.ResponseWriter, csrfValue csrftoken.CSRFToken
 
 	return nil
 }
+
+// addLastIDPSetCookieHeader remembers the identity provider which the end user chose. Unlike the CSRF cookie, it
+// is specific to the FederationDomain's issuer path.
+func addLastIDPSetCookieHeader(w http.ResponseWriter, displayName string, path string, codec oidc.Encoder) error {
+	encodedDisplayName, err := codec.Encode(oidc.LastIDPCookieEncodingName, displayName)
+	if err != nil {
+		return fmt.Errorf("error encoding last chosen identity provider cookie: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidc.LastIDPCookieName,
+		Value:    encodedDisplayName,
+		MaxAge:   int(oidc.LastIDPCookieLifespan.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   true,
+		Path:     path,
+	})
+
+	return nil
+}
+
+// lastIDPCookiePath returns the path of the last chosen identity provider cookie, which covers all the
+// endpoints of the FederationDomain.
+func lastIDPCookiePath(downstreamIssuerURL string) string {
+	issuerURL, err := url.Parse(downstreamIssuerURL)
+	if err != nil {
+		// The issuer was already validated, so this should not happen.
+		return "/"
+	}
+	return strings.TrimSuffix(issuerURL.Path, "/") + "/"
+}
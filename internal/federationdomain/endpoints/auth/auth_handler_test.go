@@ -5,6 +5,7 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"html"
@@ -30,17 +31,24 @@ import (
 	supervisorfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
 	"go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/typed/config/v1alpha1"
 	"go.pinniped.dev/internal/authenticators"
+	"go.pinniped.dev/internal/federationdomain/clientregistry"
 	"go.pinniped.dev/internal/federationdomain/csrftoken"
 	"go.pinniped.dev/internal/federationdomain/endpoints/jwks"
+	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
+	"go.pinniped.dev/internal/federationdomain/loginlimiter"
 	"go.pinniped.dev/internal/federationdomain/oidc"
 	"go.pinniped.dev/internal/federationdomain/oidcclientvalidator"
 	"go.pinniped.dev/internal/federationdomain/storage"
+	"go.pinniped.dev/internal/fositestorage/pushedauthorization"
 	"go.pinniped.dev/internal/here"
 	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/internal/testutil/oidctestutil"
 	"go.pinniped.dev/internal/testutil/testidplister"
+	testtotp "go.pinniped.dev/internal/testutil/totp"
 	"go.pinniped.dev/internal/testutil/transformtestutil"
+	"go.pinniped.dev/internal/totp"
+	"go.pinniped.dev/internal/upstreamldap"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/pkce"
 )
@@ -162,12 +170,66 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 			"state":             happyState,
 		}
 
+		fositeAccessDeniedWithIDPNotAllowedForClientHintErrorQuery = map[string]string{
+			"error":             "access_denied",
+			"error_description": "The resource owner or authorization server denied the request. This client is not allowed to use the identity provider 'some-oidc-idp'.",
+			"state":             happyState,
+		}
+
+		fositeMustUsePushedAuthorizationRequestErrorQuery = map[string]string{
+			"error":             "invalid_request",
+			"error_description": "The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed. This client must use the pushed authorization request endpoint https://my-downstream-issuer.com/some-path/oauth2/par.",
+			"state":             happyState,
+		}
+
+		fositeInvalidRequestObjectAudienceErrorQuery = map[string]string{
+			"error":             "invalid_request_object",
+			"error_description": "The request parameter contains an invalid Request Object. The 'aud' claim of the request object must contain the issuer.",
+			"state":             happyState,
+		}
+
+		fositeRequestObjectWithoutOpenIDScopeErrorQuery = map[string]string{
+			"error":             "invalid_request",
+			"error_description": "The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed. The 'scope' parameter must include 'openid' when a request object is used.",
+			"state":             happyState,
+		}
+
 		fositeAccessDeniedWithBadUsernamePasswordHintErrorQuery = map[string]string{
 			"error":             "access_denied",
 			"error_description": "The resource owner or authorization server denied the request. Username/password not accepted by LDAP provider.",
 			"state":             happyState,
 		}
 
+		fositeAccessDeniedWithAccountLockedHintErrorQuery = map[string]string{
+			"error":             "access_denied",
+			"error_description": "The resource owner or authorization server denied the request. Account is locked according to LDAP provider. Wait and try again later, or contact your administrator.",
+			"state":             happyState,
+		}
+
+		fositeInteractionRequiredWithTOTPCodeRequiredHintErrorQuery = map[string]string{
+			"error":             "interaction_required",
+			"error_description": "The Authorization Server requires End-User interaction of some form to proceed. A one-time password from your authenticator app is required.",
+			"state":             happyState,
+		}
+
+		fositeAccessDeniedWithTOTPCodeNotAcceptedHintErrorQuery = map[string]string{
+			"error":             "access_denied",
+			"error_description": "The resource owner or authorization server denied the request. One-time password not accepted.",
+			"state":             happyState,
+		}
+
+		fositeAccessDeniedWithTOTPEnrollmentRequiredHintErrorQuery = map[string]string{
+			"error":             "access_denied",
+			"error_description": "The resource owner or authorization server denied the request. A one-time password is required, but you have not enrolled an authenticator app. Enroll at " + downstreamIssuer + "/totp/enroll and try again.",
+			"state":             happyState,
+		}
+
+		fositeAccessDeniedWithPasswordExpiredHintErrorQuery = map[string]string{
+			"error":             "access_denied",
+			"error_description": "The resource owner or authorization server denied the request. Password has expired according to LDAP provider. Change your password and try again.",
+			"state":             happyState,
+		}
+
 		fositeAccessDeniedWithMissingUsernamePasswordHintErrorQuery = map[string]string{
 			"error":             "access_denied",
 			"error_description": "The resource owner or authorization server denied the request. Missing or blank username or password.",
@@ -274,7 +336,7 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 	createOauthHelperWithNullStorage := func(secretsClient v1.SecretInterface, oidcClientsClient v1alpha1.OIDCClientInterface) (fosite.OAuth2Provider, *storage.NullStorage) {
 		// Configure fosite the same way that the production code would, using NullStorage to turn off storage.
 		// Use lower minimum required bcrypt cost than we would use in production to keep unit the tests fast.
-		nullOauthStore := storage.NewNullStorage(secretsClient, oidcClientsClient, bcrypt.MinCost)
+		nullOauthStore := storage.NewNullStorage(secretsClient, oidcClientsClient, timeoutsConfiguration, bcrypt.MinCost)
 		return oidc.FositeOauth2Helper(nullOauthStore, downstreamIssuer, hmacSecretFunc, jwksProviderIsUnused, timeoutsConfiguration), nullOauthStore
 	}
 
@@ -336,6 +398,13 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 	happyLDAPPassword := "some-ldap-password" //nolint:gosec
 	happyLDAPUID := "some-ldap-uid"
 	happyLDAPUserDN := "cn=foo,dn=bar"
+	happyLDAPDownstreamSubject := upstreamLDAPURL + "&idpName=" + ldapUpstreamName + "&sub=" + happyLDAPUID
+	happyTOTPSecret := "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	totpEncryptionKey := []byte("0123456789abcdef0123456789abcdef")
+	validTOTPCode := func(t *testing.T) string {
+		code, _ := testtotp.GenerateOTPCode(t, happyTOTPSecret, time.Now())
+		return code
+	}
 	happyLDAPGroups := []string{"group1", "group2", "group3"}
 	happyLDAPExtraRefreshAttribute := "some-refresh-attribute"
 	happyLDAPExtraRefreshValue := "some-refresh-attribute-value"
@@ -386,6 +455,15 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 			return nil, false, fmt.Errorf("some ldap upstream auth error")
 		}).Build()
 
+	accountStatusErroringUpstreamLDAPIdentityProvider := func(reason upstreamldap.AccountStatusReason) *oidctestutil.TestUpstreamLDAPIdentityProvider {
+		return oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+			WithName(ldapUpstreamName).
+			WithResourceUID(ldapUpstreamResourceUID).
+			WithAuthenticateFunc(func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+				return nil, false, &upstreamldap.AccountStatusError{Reason: reason}
+			}).Build()
+	}
+
 	happyCSRF := "test-csrf"
 	happyPKCE := "test-pkce"
 	happyNonce := "test-nonce"
@@ -646,6 +724,89 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 		require.NoError(t, kubeClient.Tracker().Add(secret))
 	}
 
+	addDynamicClientAllowedToUseOnlyLDAPAndSecretToKubeResources := func(t *testing.T, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset) {
+		oidcClient, secret := testutil.FullyCapableOIDCClientAndStorageSecret(t,
+			"some-namespace", dynamicClientID, dynamicClientUID, downstreamRedirectURI, nil,
+			[]string{testutil.HashedPassword1AtGoMinCost}, oidcclientvalidator.Validate)
+		oidcClient.Spec.AllowedIdentityProviders = []string{ldapUpstreamName}
+		require.NoError(t, supervisorClient.Tracker().Add(oidcClient))
+		require.NoError(t, kubeClient.Tracker().Add(secret))
+	}
+
+	addDynamicClientWhichRequiresPushedAuthorizationRequestsAndSecretToKubeResources := func(t *testing.T, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset) {
+		oidcClient, secret := testutil.FullyCapableOIDCClientAndStorageSecret(t,
+			"some-namespace", dynamicClientID, dynamicClientUID, downstreamRedirectURI, nil,
+			[]string{testutil.HashedPassword1AtGoMinCost}, oidcclientvalidator.Validate)
+		oidcClient.Spec.RequirePushedAuthorizationRequests = true
+		require.NoError(t, supervisorClient.Tracker().Add(oidcClient))
+		require.NoError(t, kubeClient.Tracker().Add(secret))
+	}
+
+	requestObjectSigningKey, requestObjectsConfig := testutil.NewRequestObjectSigningKey(t)
+
+	addDynamicClientWithRequestObjectsAndSecretToKubeResources := func(t *testing.T, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset) {
+		oidcClient, secret := testutil.FullyCapableOIDCClientAndStorageSecret(t,
+			"some-namespace", dynamicClientID, dynamicClientUID, downstreamRedirectURI, nil,
+			[]string{testutil.HashedPassword1AtGoMinCost}, oidcclientvalidator.Validate)
+		oidcClient.Spec.RequestObjects = requestObjectsConfig
+		require.NoError(t, supervisorClient.Tracker().Add(oidcClient))
+		require.NoError(t, kubeClient.Tracker().Add(secret))
+	}
+
+	happyRequestObjectClaims := func(claimOverrides map[string]any) map[string]any {
+		claims := map[string]any{
+			"iss":                   dynamicClientID,
+			"aud":                   downstreamIssuer,
+			"response_type":         "code",
+			"scope":                 testutil.AllDynamicClientScopesSpaceSep,
+			"client_id":             dynamicClientID,
+			"state":                 happyState,
+			"nonce":                 downstreamNonce,
+			"code_challenge":        downstreamPKCEChallenge,
+			"code_challenge_method": downstreamPKCEChallengeMethod,
+			"redirect_uri":          downstreamRedirectURI,
+		}
+		for k, v := range claimOverrides {
+			claims[k] = v
+		}
+		return claims
+	}
+
+	pushedAuthorizationRequestURI := oidc.PushedAuthorizationRequestURIPrefix + "some-pushed-authorization-request"
+
+	addPushedAuthorizationRequestForDynamicClientToKubeResources := func(t *testing.T, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset) {
+		addDynamicClientWhichRequiresPushedAuthorizationRequestsAndSecretToKubeResources(t, supervisorClient, kubeClient)
+
+		redirectURI, err := url.Parse(downstreamRedirectURI)
+		require.NoError(t, err)
+		form := url.Values{}
+		for k, v := range modifiedHappyGetRequestQueryMap(map[string]string{"client_id": dynamicClientID, "scope": testutil.AllDynamicClientScopesSpaceSep}) {
+			form.Set(k, v)
+		}
+		session := psession.NewPinnipedSession()
+		session.SetExpiresAt(fosite.PushedAuthorizeRequestContext, time.Now().Add(time.Minute))
+		pushedRequest := fosite.NewAuthorizeRequest()
+		pushedRequest.ResponseTypes = fosite.Arguments{"code"}
+		pushedRequest.RedirectURI = redirectURI
+		pushedRequest.State = happyState
+		pushedRequest.SetDefaultResponseMode(fosite.ResponseModeQuery)
+		pushedRequest.Client = &clientregistry.Client{
+			DefaultOpenIDConnectClient: fosite.DefaultOpenIDConnectClient{DefaultClient: &fosite.DefaultClient{ID: dynamicClientID}},
+		}
+		pushedRequest.SetRequestedScopes(strings.Split(testutil.AllDynamicClientScopesSpaceSep, " "))
+		pushedRequest.Form = form
+		pushedRequest.Session = session
+
+		// Create the Secret using a different client, so the Kube client used by the test does not record the create action.
+		parSecretsClient := fake.NewSimpleClientset().CoreV1().Secrets("some-namespace")
+		parStorage := pushedauthorization.New(parSecretsClient, time.Now, func(fosite.Requester) time.Duration { return time.Minute })
+		require.NoError(t, parStorage.CreatePARSession(context.Background(), pushedAuthorizationRequestURI, pushedRequest))
+		parSecrets, err := parSecretsClient.List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, parSecrets.Items, 1)
+		require.NoError(t, kubeClient.Tracker().Add(&parSecrets.Items[0]))
+	}
+
 	// Note that fosite puts the granted scopes as a param in the redirect URI even though the spec doesn't seem to require it
 	happyAuthcodeDownstreamRedirectLocationRegexp := downstreamRedirectURI + `\?code=([^&]+)&scope=openid\+username\+groups&state=` + happyState
 
@@ -673,13 +834,20 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 		csrfCookie           string
 		customUsernameHeader *string // nil means do not send header, empty means send header with empty value
 		customPasswordHeader *string // nil means do not send header, empty means send header with empty value
+		customTOTPCodeHeader func(t *testing.T) string
+		totpEnrolledSubject  string
+		priorFailedLogins    int // failed logins of the username which were recorded before the request
+		idpChooser           federationdomainproviders.IdentityProviderChooser
+		lastIDPCookie        string // display name of the IDP which is remembered by the request's cookie
 
 		wantStatus                             int
 		wantContentType                        string
+		wantRetryAfterHeader                   string
 		wantBodyString                         string
 		wantBodyRegex                          string
 		wantBodyJSON                           string
 		wantCSRFValueInCookieHeader            string
+		wantLastIDPInCookieHeader              string
 		wantBodyStringWithLocationInHref       bool
 		wantLocationHeader                     string
 		wantUpstreamStateParamInLocationHeader bool
@@ -701,6 +869,7 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 		wantPasswordGrantCall             *expectedPasswordGrant
 		wantDownstreamCustomSessionData   *psession.CustomSessionData
 		wantDownstreamAdditionalClaims    map[string]any
+		wantLoginBlockedAfterRequest      bool
 	}
 	tests := []testCase{
 		{
@@ -738,6 +907,120 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 			wantUpstreamStateParamInLocationHeader: true,
 			wantBodyStringWithLocationInHref:       true,
 		},
+		{
+			name:          "OIDC upstream browser flow happy path using a pushed authorization request from a dynamic client",
+			idps:          testidplister.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()),
+			kubeResources: addPushedAuthorizationRequestForDynamicClientToKubeResources,
+			generateCSRF:  happyCSRFGenerator,
+			generatePKCE:  happyPKCEGenerator,
+			generateNonce: happyNonceGenerator,
+			stateEncoder:  happyStateEncoder,
+			cookieEncoder: happyCookieEncoder,
+			method:        http.MethodGet,
+			path: pathWithQuery("/some/path", map[string]string{
+				"client_id":         dynamicClientID,
+				"request_uri":       pushedAuthorizationRequestURI,
+				"pinniped_idp_name": oidcUpstreamName,
+			}),
+			wantStatus:                             http.StatusSeeOther,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamOIDC(expectedUpstreamStateParam(map[string]string{"client_id": dynamicClientID, "scope": testutil.AllDynamicClientScopesSpaceSep}, "", oidcUpstreamName, "oidc"), nil),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:          "OIDC upstream browser flow using a pushed authorization request which does not exist",
+			idps:          testidplister.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()),
+			kubeResources: addDynamicClientWhichRequiresPushedAuthorizationRequestsAndSecretToKubeResources,
+			generateCSRF:  happyCSRFGenerator,
+			generatePKCE:  happyPKCEGenerator,
+			generateNonce: happyNonceGenerator,
+			stateEncoder:  happyStateEncoder,
+			cookieEncoder: happyCookieEncoder,
+			method:        http.MethodGet,
+			path: pathWithQuery("/some/path", map[string]string{
+				"client_id":         dynamicClientID,
+				"request_uri":       pushedAuthorizationRequestURI,
+				"pinniped_idp_name": oidcUpstreamName,
+			}),
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: jsonContentType,
+			wantBodyJSON:    `{"error":"invalid_request_uri","error_description":"The request_uri in the Authorization Request returns an error or contains invalid data. Invalid PAR session"}`,
+		},
+		{
+			name:               "OIDC upstream browser flow without a pushed authorization request from a dynamic client which requires them",
+			idps:               testidplister.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()),
+			kubeResources:      addDynamicClientWhichRequiresPushedAuthorizationRequestsAndSecretToKubeResources,
+			method:             http.MethodGet,
+			path:               modifiedHappyGetRequestPathForOIDCUpstream(map[string]string{"client_id": dynamicClientID, "scope": testutil.AllDynamicClientScopesSpaceSep}),
+			wantStatus:         http.StatusSeeOther,
+			wantContentType:    jsonContentType,
+			wantLocationHeader: urlWithQuery(downstreamRedirectURI, fositeMustUsePushedAuthorizationRequestErrorQuery),
+			wantBodyString:     "",
+		},
+		{
+			name:          "OIDC upstream browser flow happy path using a request object from a dynamic client",
+			idps:          testidplister.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()),
+			kubeResources: addDynamicClientWithRequestObjectsAndSecretToKubeResources,
+			generateCSRF:  happyCSRFGenerator,
+			generatePKCE:  happyPKCEGenerator,
+			generateNonce: happyNonceGenerator,
+			stateEncoder:  happyStateEncoder,
+			cookieEncoder: happyCookieEncoder,
+			method:        http.MethodGet,
+			path: pathWithQuery("/some/path", map[string]string{
+				"client_id":         dynamicClientID,
+				"response_type":     "code",
+				"scope":             "openid",
+				"request":           testutil.SignRequestObject(t, requestObjectSigningKey, happyRequestObjectClaims(nil)),
+				"pinniped_idp_name": oidcUpstreamName,
+			}),
+			wantStatus:                  http.StatusSeeOther,
+			wantContentType:             htmlContentType,
+			wantCSRFValueInCookieHeader: happyCSRF,
+			// The claims of the request object, including iss and aud, are used as the params of the authorization request.
+			wantLocationHeader: expectedRedirectLocationForUpstreamOIDC(expectedUpstreamStateParam(map[string]string{
+				"client_id": dynamicClientID,
+				"scope":     testutil.AllDynamicClientScopesSpaceSep,
+				"iss":       dynamicClientID,
+				"aud":       downstreamIssuer,
+			}, "", oidcUpstreamName, "oidc"), nil),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:          "OIDC upstream browser flow using a request object with the wrong audience",
+			idps:          testidplister.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()),
+			kubeResources: addDynamicClientWithRequestObjectsAndSecretToKubeResources,
+			method:        http.MethodGet,
+			path: pathWithQuery("/some/path", map[string]string{
+				"client_id":         dynamicClientID,
+				"response_type":     "code",
+				"scope":             "openid",
+				"request":           testutil.SignRequestObject(t, requestObjectSigningKey, happyRequestObjectClaims(map[string]any{"aud": "https://some-other-issuer.example.com"})),
+				"pinniped_idp_name": oidcUpstreamName,
+			}),
+			wantStatus:         http.StatusSeeOther,
+			wantContentType:    jsonContentType,
+			wantLocationHeader: urlWithQuery(downstreamRedirectURI, fositeInvalidRequestObjectAudienceErrorQuery),
+			wantBodyString:     "",
+		},
+		{
+			name:          "OIDC upstream browser flow using a request object without the openid scope outside of the request object",
+			idps:          testidplister.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()),
+			kubeResources: addDynamicClientWithRequestObjectsAndSecretToKubeResources,
+			method:        http.MethodGet,
+			path: modifiedHappyGetRequestPathForOIDCUpstream(map[string]string{
+				"client_id": dynamicClientID,
+				"scope":     "username groups",
+				"request":   testutil.SignRequestObject(t, requestObjectSigningKey, happyRequestObjectClaims(nil)),
+			}),
+			wantStatus:         http.StatusSeeOther,
+			wantContentType:    jsonContentType,
+			wantLocationHeader: urlWithQuery(downstreamRedirectURI, fositeRequestObjectWithoutOpenIDScopeErrorQuery),
+			wantBodyString:     "",
+		},
 		{
 			name:                                   "GitHub upstream browser flow happy path using GET without a CSRF cookie",
 			idps:                                   testidplister.NewUpstreamIDPListerBuilder().WithGitHub(upstreamGitHubIdentityProviderBuilder().Build()),
@@ -865,6 +1148,237 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 			wantUpstreamStateParamInLocationHeader: true,
 			wantBodyStringWithLocationInHref:       true,
 		},
+		{
+			name: "with multiple IDPs available and remembering the last chosen IDP, request chooses to use OIDC browser flow",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()).
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			idpChooser:                             federationdomainproviders.IdentityProviderChooser{RememberLastChosen: true},
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPathForOIDCUpstream,
+			wantStatus:                             http.StatusSeeOther,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLastIDPInCookieHeader:              oidcUpstreamName,
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamOIDC(expectedUpstreamStateParam(nil, "", oidcUpstreamName, "oidc"), nil),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name: "with multiple IDPs available and auto-redirect, request does not choose which IDP to use, so the remembered IDP is used",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()).
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			idpChooser:                             federationdomainproviders.IdentityProviderChooser{RememberLastChosen: true, AutoRedirect: true},
+			lastIDPCookie:                          ldapUpstreamName,
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPath, // does not include pinniped_idp_name param
+			wantStatus:                             http.StatusSeeOther,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLastIDPInCookieHeader:              ldapUpstreamName,
+			wantLocationHeader:                     urlWithQuery(downstreamIssuer+"/login", map[string]string{"state": expectedUpstreamStateParam(nil, "", ldapUpstreamName, "ldap")}),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name: "with multiple IDPs available and auto-redirect, the remembered IDP no longer exists, so the IDP chooser is shown",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()).
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			idpChooser:                             federationdomainproviders.IdentityProviderChooser{RememberLastChosen: true, AutoRedirect: true},
+			lastIDPCookie:                          "some-deleted-idp",
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPath,
+			wantStatus:                             http.StatusSeeOther,
+			wantContentType:                        htmlContentType,
+			wantLocationHeader:                     urlWithQuery(downstreamIssuer+"/choose_identity_provider", happyGetRequestQueryMap),
+			wantUpstreamStateParamInLocationHeader: false,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name: "with multiple IDPs available and auto-redirect, request asks for prompt=select_account, so the IDP chooser is shown",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()).
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			idpChooser:                             federationdomainproviders.IdentityProviderChooser{RememberLastChosen: true, AutoRedirect: true},
+			lastIDPCookie:                          ldapUpstreamName,
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   modifiedHappyGetRequestPath(map[string]string{"prompt": "select_account", "idp_hint": ldapUpstreamName}),
+			wantStatus:                             http.StatusSeeOther,
+			wantContentType:                        htmlContentType,
+			wantLocationHeader:                     urlWithQuery(downstreamIssuer+"/choose_identity_provider", modifiedQueryMap(happyGetRequestQueryMap, map[string]string{"prompt": "select_account", "idp_hint": ldapUpstreamName})),
+			wantUpstreamStateParamInLocationHeader: false,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name: "with multiple IDPs available but without auto-redirect, the remembered IDP is not used",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()).
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			idpChooser:                             federationdomainproviders.IdentityProviderChooser{RememberLastChosen: true},
+			lastIDPCookie:                          ldapUpstreamName,
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPath,
+			wantStatus:                             http.StatusSeeOther,
+			wantContentType:                        htmlContentType,
+			wantLocationHeader:                     urlWithQuery(downstreamIssuer+"/choose_identity_provider", happyGetRequestQueryMap),
+			wantUpstreamStateParamInLocationHeader: false,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:                                   "with one IDP available, request does not choose which IDP to use",
+			idps:                                   testidplister.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPath,
+			wantStatus:                             http.StatusSeeOther,
+			wantContentType:                        htmlContentType,
+			wantLocationHeader:                     urlWithQuery(downstreamIssuer+"/choose_identity_provider", happyGetRequestQueryMap),
+			wantUpstreamStateParamInLocationHeader: false,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:                                   "with one IDP available and auto-redirect, request does not choose which IDP to use, so the only IDP is used",
+			idps:                                   testidplister.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()),
+			idpChooser:                             federationdomainproviders.IdentityProviderChooser{AutoRedirect: true},
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   happyGetRequestPath,
+			wantStatus:                             http.StatusSeeOther,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     expectedRedirectLocationForUpstreamOIDC(expectedUpstreamStateParam(nil, "", oidcUpstreamName, "oidc"), nil),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name: "with multiple IDPs available, request hints which IDP to use",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()).
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   modifiedHappyGetRequestPath(map[string]string{"idp_hint": ldapUpstreamName}),
+			wantStatus:                             http.StatusSeeOther,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     urlWithQuery(downstreamIssuer+"/login", map[string]string{"state": expectedUpstreamStateParam(map[string]string{"idp_hint": ldapUpstreamName}, "", ldapUpstreamName, "ldap")}),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name: "with multiple IDPs available, request hints an unknown IDP, so the IDP chooser is shown",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()).
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   modifiedHappyGetRequestPath(map[string]string{"idp_hint": "some-unknown-idp"}),
+			wantStatus:                             http.StatusSeeOther,
+			wantContentType:                        htmlContentType,
+			wantLocationHeader:                     urlWithQuery(downstreamIssuer+"/choose_identity_provider", modifiedQueryMap(happyGetRequestQueryMap, map[string]string{"idp_hint": "some-unknown-idp"})),
+			wantUpstreamStateParamInLocationHeader: false,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name:               "OIDC upstream browser flow using a dynamic client which is not allowed to use the requested IDP",
+			idps:               testidplister.NewUpstreamIDPListerBuilder().WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()).WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			kubeResources:      addDynamicClientAllowedToUseOnlyLDAPAndSecretToKubeResources,
+			generateCSRF:       happyCSRFGenerator,
+			generatePKCE:       happyPKCEGenerator,
+			generateNonce:      happyNonceGenerator,
+			stateEncoder:       happyStateEncoder,
+			cookieEncoder:      happyCookieEncoder,
+			method:             http.MethodGet,
+			path:               modifiedHappyGetRequestPathForOIDCUpstream(map[string]string{"client_id": dynamicClientID, "scope": testutil.AllDynamicClientScopesSpaceSep}),
+			wantStatus:         http.StatusSeeOther,
+			wantContentType:    jsonContentType,
+			wantLocationHeader: urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithIDPNotAllowedForClientHintErrorQuery),
+			wantBodyString:     "",
+		},
+		{
+			name: "with multiple IDPs available and auto-redirect, the only IDP which the dynamic client is allowed to use is used",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()).
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			kubeResources:                          addDynamicClientAllowedToUseOnlyLDAPAndSecretToKubeResources,
+			idpChooser:                             federationdomainproviders.IdentityProviderChooser{AutoRedirect: true},
+			generateCSRF:                           happyCSRFGenerator,
+			generatePKCE:                           happyPKCEGenerator,
+			generateNonce:                          happyNonceGenerator,
+			stateEncoder:                           happyStateEncoder,
+			cookieEncoder:                          happyCookieEncoder,
+			method:                                 http.MethodGet,
+			path:                                   modifiedHappyGetRequestPath(map[string]string{"client_id": dynamicClientID, "scope": testutil.AllDynamicClientScopesSpaceSep}),
+			wantStatus:                             http.StatusSeeOther,
+			wantContentType:                        htmlContentType,
+			wantCSRFValueInCookieHeader:            happyCSRF,
+			wantLocationHeader:                     urlWithQuery(downstreamIssuer+"/login", map[string]string{"state": expectedUpstreamStateParam(map[string]string{"client_id": dynamicClientID, "scope": testutil.AllDynamicClientScopesSpaceSep}, "", ldapUpstreamName, "ldap")}),
+			wantUpstreamStateParamInLocationHeader: true,
+			wantBodyStringWithLocationInHref:       true,
+		},
+		{
+			name: "with multiple IDPs available, request hints an IDP which the dynamic client is not allowed to use, so the IDP chooser is shown",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(upstreamOIDCIdentityProviderBuilder().Build()).
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			kubeResources:   addDynamicClientAllowedToUseOnlyLDAPAndSecretToKubeResources,
+			generateCSRF:    happyCSRFGenerator,
+			generatePKCE:    happyPKCEGenerator,
+			generateNonce:   happyNonceGenerator,
+			stateEncoder:    happyStateEncoder,
+			cookieEncoder:   happyCookieEncoder,
+			method:          http.MethodGet,
+			path:            modifiedHappyGetRequestPath(map[string]string{"client_id": dynamicClientID, "scope": testutil.AllDynamicClientScopesSpaceSep, "idp_hint": oidcUpstreamName}),
+			wantStatus:      http.StatusSeeOther,
+			wantContentType: htmlContentType,
+			wantLocationHeader: urlWithQuery(downstreamIssuer+"/choose_identity_provider",
+				modifiedQueryMap(happyGetRequestQueryMap, map[string]string{"client_id": dynamicClientID, "scope": testutil.AllDynamicClientScopesSpaceSep, "idp_hint": oidcUpstreamName})),
+			wantUpstreamStateParamInLocationHeader: false,
+			wantBodyStringWithLocationInHref:       true,
+		},
 		{
 			name:                                   "LDAP upstream browser flow happy path using GET without a CSRF cookie using a dynamic client",
 			idps:                                   testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
@@ -1065,6 +1579,35 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
 			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
 		},
+		{
+			name: "LDAP cli upstream happy path using GET with additional claims",
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderBuilder().
+				WithAuthenticateFunc(func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+					response, authenticated, err := ldapAuthenticateFunc(ctx, username, password)
+					if response != nil {
+						response.AdditionalClaims = map[string]any{"email": "some-email@example.com", "types": []string{"type1", "type2"}}
+					}
+					return response, authenticated, err
+				}).Build()),
+			method:                            http.MethodGet,
+			path:                              happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader:              ptr.To(happyLDAPUsername),
+			customPasswordHeader:              ptr.To(happyLDAPPassword),
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      upstreamLDAPURL + "&idpName=" + ldapUpstreamName + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+			wantDownstreamAdditionalClaims:    map[string]any{"email": "some-email@example.com", "types": []any{"type1", "type2"}},
+		},
 		{
 			name: "LDAP cli upstream happy path using GET with identity transformations which change username and groups",
 			idps: testidplister.NewUpstreamIDPListerBuilder().
@@ -1105,6 +1648,137 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithConfiguredPolicyRejectionHintErrorQuery),
 			wantBodyString:       "",
 		},
+		{
+			name: "LDAP cli upstream happy path using GET with a valid one-time password when required",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().WithTOTPPolicyForFederationDomain(totp.PolicyRequired).Build()),
+			method:                            http.MethodGet,
+			path:                              happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader:              ptr.To(happyLDAPUsername),
+			customPasswordHeader:              ptr.To(happyLDAPPassword),
+			customTOTPCodeHeader:              validTOTPCode,
+			totpEnrolledSubject:               happyLDAPDownstreamSubject,
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      happyLDAPDownstreamSubject,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+		},
+		{
+			name: "LDAP cli upstream happy path using GET when a one-time password is only required when enrolled and the user has not enrolled",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().WithTOTPPolicyForFederationDomain(totp.PolicyWhenEnrolled).Build()),
+			method:                            http.MethodGet,
+			path:                              happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader:              ptr.To(happyLDAPUsername),
+			customPasswordHeader:              ptr.To(happyLDAPPassword),
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      happyLDAPDownstreamSubject,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+		},
+		{
+			name: "LDAP cli upstream when the user has enrolled but did not send a one-time password",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().WithTOTPPolicyForFederationDomain(totp.PolicyWhenEnrolled).Build()),
+			method:               http.MethodGet,
+			path:                 happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader: ptr.To(happyLDAPUsername),
+			customPasswordHeader: ptr.To(happyLDAPPassword),
+			totpEnrolledSubject:  happyLDAPDownstreamSubject,
+			wantStatus:           http.StatusFound,
+			wantContentType:      jsonContentType,
+			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeInteractionRequiredWithTOTPCodeRequiredHintErrorQuery),
+			wantBodyString:       "",
+		},
+		{
+			name: "LDAP cli upstream when the user sent an incorrect one-time password",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().WithTOTPPolicyForFederationDomain(totp.PolicyRequired).Build()),
+			method:               http.MethodGet,
+			path:                 happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader: ptr.To(happyLDAPUsername),
+			customPasswordHeader: ptr.To(happyLDAPPassword),
+			customTOTPCodeHeader: func(_ *testing.T) string { return "abcdef" },
+			totpEnrolledSubject:  happyLDAPDownstreamSubject,
+			wantStatus:           http.StatusFound,
+			wantContentType:      jsonContentType,
+			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithTOTPCodeNotAcceptedHintErrorQuery),
+			wantBodyString:       "",
+		},
+		{
+			name: "LDAP cli upstream when the user sent the correct password but an incorrect one-time password after the allowed failed logins delays the next login",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().WithTOTPPolicyForFederationDomain(totp.PolicyRequired).Build()),
+			method:                       http.MethodGet,
+			path:                         happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader:         ptr.To(happyLDAPUsername),
+			customPasswordHeader:         ptr.To(happyLDAPPassword),
+			customTOTPCodeHeader:         func(_ *testing.T) string { return "abcdef" },
+			totpEnrolledSubject:          happyLDAPDownstreamSubject,
+			priorFailedLogins:            2,
+			wantStatus:                   http.StatusFound,
+			wantContentType:              jsonContentType,
+			wantLocationHeader:           urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithTOTPCodeNotAcceptedHintErrorQuery),
+			wantBodyString:               "",
+			wantLoginBlockedAfterRequest: true,
+		},
+		{
+			name: "LDAP cli upstream when the user sent the correct password and one-time password after failed logins forgets the failed logins",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().WithTOTPPolicyForFederationDomain(totp.PolicyRequired).Build()),
+			method:                            http.MethodGet,
+			path:                              happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader:              ptr.To(happyLDAPUsername),
+			customPasswordHeader:              ptr.To(happyLDAPPassword),
+			customTOTPCodeHeader:              validTOTPCode,
+			totpEnrolledSubject:               happyLDAPDownstreamSubject,
+			priorFailedLogins:                 2,
+			wantStatus:                        http.StatusFound,
+			wantContentType:                   htmlContentType,
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      happyLDAPDownstreamSubject,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+		},
+		{
+			name: "LDAP cli upstream when a one-time password is required but the user has not enrolled",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithLDAP(upstreamLDAPIdentityProviderBuilder().WithTOTPPolicyForFederationDomain(totp.PolicyRequired).Build()),
+			method:               http.MethodGet,
+			path:                 happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader: ptr.To(happyLDAPUsername),
+			customPasswordHeader: ptr.To(happyLDAPPassword),
+			customTOTPCodeHeader: validTOTPCode,
+			wantStatus:           http.StatusFound,
+			wantContentType:      jsonContentType,
+			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithTOTPEnrollmentRequiredHintErrorQuery),
+			wantBodyString:       "",
+		},
 		{
 			name:                              "ActiveDirectory cli upstream happy path using GET",
 			idps:                              testidplister.NewUpstreamIDPListerBuilder().WithActiveDirectory(upstreamActiveDirectoryIdentityProviderBuilder().Build()),
@@ -1666,6 +2340,30 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeUpstreamAuthErrorQuery),
 			wantBodyString:       "",
 		},
+		{
+			name:                 "locked account during upstream LDAP authentication",
+			idps:                 testidplister.NewUpstreamIDPListerBuilder().WithLDAP(accountStatusErroringUpstreamLDAPIdentityProvider(upstreamldap.AccountStatusAccountLocked)),
+			method:               http.MethodGet,
+			path:                 happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader: ptr.To(happyLDAPUsername),
+			customPasswordHeader: ptr.To(happyLDAPPassword),
+			wantStatus:           http.StatusFound,
+			wantContentType:      jsonContentType,
+			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithAccountLockedHintErrorQuery),
+			wantBodyString:       "",
+		},
+		{
+			name:                 "expired password during upstream Active Directory authentication",
+			idps:                 testidplister.NewUpstreamIDPListerBuilder().WithActiveDirectory(accountStatusErroringUpstreamLDAPIdentityProvider(upstreamldap.AccountStatusPasswordExpired)),
+			method:               http.MethodGet,
+			path:                 happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader: ptr.To(happyLDAPUsername),
+			customPasswordHeader: ptr.To(happyLDAPPassword),
+			wantStatus:           http.StatusFound,
+			wantContentType:      jsonContentType,
+			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithPasswordExpiredHintErrorQuery),
+			wantBodyString:       "",
+		},
 		{
 			name: "wrong upstream credentials for OIDC password grant authentication",
 			idps: testidplister.NewUpstreamIDPListerBuilder().WithOIDC(
@@ -1701,6 +2399,48 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 			wantLocationHeader:   urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithBadUsernamePasswordHintErrorQuery),
 			wantBodyString:       "",
 		},
+		{
+			name:                         "wrong upstream password for LDAP authentication after the allowed failed logins delays the next login",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			method:                       http.MethodGet,
+			path:                         happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader:         ptr.To(happyLDAPUsername),
+			customPasswordHeader:         ptr.To("wrong-password"),
+			priorFailedLogins:            2,
+			wantStatus:                   http.StatusFound,
+			wantContentType:              jsonContentType,
+			wantLocationHeader:           urlWithQuery(downstreamRedirectURI, fositeAccessDeniedWithBadUsernamePasswordHintErrorQuery),
+			wantBodyString:               "",
+			wantLoginBlockedAfterRequest: true,
+		},
+		{
+			name:                         "LDAP login is refused without contacting the upstream after too many failed logins",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderBuilder().Build()),
+			method:                       http.MethodGet,
+			path:                         happyGetRequestPathForLDAPUpstream,
+			customUsernameHeader:         ptr.To(happyLDAPUsername),
+			customPasswordHeader:         ptr.To(happyLDAPPassword),
+			priorFailedLogins:            3,
+			wantStatus:                   http.StatusTooManyRequests,
+			wantContentType:              plainContentType,
+			wantRetryAfterHeader:         "60",
+			wantBodyString:               "Too Many Requests: too many failed login attempts, try again later\n",
+			wantLoginBlockedAfterRequest: true,
+		},
+		{
+			name:                         "OIDC upstream password grant is refused without contacting the upstream after too many failed logins",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithOIDC(passwordGrantUpstreamOIDCIdentityProviderBuilder().Build()),
+			method:                       http.MethodGet,
+			path:                         happyGetRequestPathForOIDCPasswordGrantUpstream,
+			customUsernameHeader:         ptr.To(oidcUpstreamUsername),
+			customPasswordHeader:         ptr.To(oidcUpstreamPassword),
+			priorFailedLogins:            3,
+			wantStatus:                   http.StatusTooManyRequests,
+			wantContentType:              plainContentType,
+			wantRetryAfterHeader:         "60",
+			wantBodyString:               "Too Many Requests: too many failed login attempts, try again later\n",
+			wantLoginBlockedAfterRequest: true,
+		},
 		{
 			name:                 "wrong upstream password for Active Directory authentication",
 			idps:                 testidplister.NewUpstreamIDPListerBuilder().WithActiveDirectory(upstreamActiveDirectoryIdentityProviderBuilder().Build()),
@@ -3478,7 +4218,36 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 		},
 	}
 
-	runOneTestCase := func(t *testing.T, test testCase, subject http.Handler, kubeOauthStore *storage.KubeStorage, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset, secretsClient v1.SecretInterface) {
+	// The TOTP enrollments use their own fake clientset, so they do not change the actions which the test cases
+	// expect to be performed on the fake clientset used by the session storage.
+	newTOTPEnrollments := func(t *testing.T, enrolledSubject string) *totp.Enrollments {
+		enrollments := totp.NewEnrollments(downstreamIssuer, fake.NewSimpleClientset().CoreV1().Secrets("some-namespace"),
+			func() []byte { return totpEncryptionKey }, rand.Reader, time.Now)
+		if enrolledSubject != "" {
+			require.NoError(t, enrollments.Enroll(context.Background(), enrolledSubject, happyTOTPSecret, 0))
+		}
+		return enrollments
+	}
+
+	// The login limiter uses its own fake clientset, so it does not change the actions which the test cases
+	// expect to be performed on the fake clientset used by the session storage.
+	newLoginLimiter := func(t *testing.T, test testCase) *loginlimiter.Limiter {
+		limiter := loginlimiter.New(downstreamIssuer, fake.NewSimpleClientset().CoreV1().Secrets("some-namespace"),
+			loginlimiter.Config{
+				PerUsername: loginlimiter.Limit{AllowedFailures: 2, InitialBackoff: time.Minute, MaxBackoff: time.Hour},
+				PerSourceIP: loginlimiter.Limit{AllowedFailures: 100, InitialBackoff: time.Minute, MaxBackoff: time.Hour},
+			}, time.Now)
+		for range test.priorFailedLogins {
+			reservation, wait, err := limiter.Reserve(context.Background(),
+				httptest.NewRequest(http.MethodGet, "/", nil), *test.customUsernameHeader)
+			require.NoError(t, err)
+			require.Zero(t, wait)
+			require.NoError(t, reservation.RecordFailure(context.Background()))
+		}
+		return limiter
+	}
+
+	runOneTestCase := func(t *testing.T, test testCase, subject http.Handler, loginLimiter *loginlimiter.Limiter, kubeOauthStore *storage.KubeStorage, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset, secretsClient v1.SecretInterface) {
 		if test.kubeResources != nil {
 			test.kubeResources(t, supervisorClient, kubeClient)
 		}
@@ -3489,12 +4258,20 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 		if test.csrfCookie != "" {
 			req.Header.Set("Cookie", test.csrfCookie)
 		}
+		if test.lastIDPCookie != "" {
+			encodedLastIDP, err := happyCookieEncoder.Encode("idp", test.lastIDPCookie)
+			require.NoError(t, err)
+			req.AddCookie(&http.Cookie{Name: "__Secure-pinniped-idp", Value: encodedLastIDP})
+		}
 		if test.customUsernameHeader != nil {
 			req.Header.Set("Pinniped-Username", *test.customUsernameHeader)
 		}
 		if test.customPasswordHeader != nil {
 			req.Header.Set("Pinniped-Password", *test.customPasswordHeader)
 		}
+		if test.customTOTPCodeHeader != nil {
+			req.Header.Set("Pinniped-TOTP-Code", test.customTOTPCodeHeader(t))
+		}
 		rsp := httptest.NewRecorder()
 		subject.ServeHTTP(rsp, req)
 		t.Logf("response: %#v", rsp)
@@ -3502,6 +4279,15 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 
 		require.Equal(t, test.wantStatus, rsp.Code)
 		testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
+		require.Equal(t, test.wantRetryAfterHeader, rsp.Header().Get("Retry-After"))
+
+		if test.customUsernameHeader != nil {
+			// The handler must have released its reservation, so another attempt is only refused when blocked.
+			reservation, wait, err := loginLimiter.Reserve(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil), *test.customUsernameHeader)
+			require.NoError(t, err)
+			require.Equal(t, test.wantLoginBlockedAfterRequest, wait > 0)
+			require.NoError(t, reservation.Release(context.Background()))
+		}
 
 		// Use form_post page's CSPs because sometimes errors are sent to the client via the form_post page.
 		testutil.RequireSecurityHeadersWithFormPostPageCSPs(t, rsp)
@@ -3581,9 +4367,18 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 			require.Equal(t, test.wantBodyString, rsp.Body.String())
 		}
 
+		var csrfSetCookies, lastIDPSetCookies []string
+		for _, setCookie := range rsp.Header().Values("Set-Cookie") {
+			if strings.HasPrefix(setCookie, "__Secure-pinniped-idp=") {
+				lastIDPSetCookies = append(lastIDPSetCookies, setCookie)
+			} else {
+				csrfSetCookies = append(csrfSetCookies, setCookie)
+			}
+		}
+
 		if test.wantCSRFValueInCookieHeader != "" {
-			require.Len(t, rsp.Header().Values("Set-Cookie"), 1)
-			actualCookie := rsp.Header().Get("Set-Cookie")
+			require.Len(t, csrfSetCookies, 1)
+			actualCookie := csrfSetCookies[0]
 			regex := regexp.MustCompile("__Host-pinniped-csrf=([^;]+); Path=/; HttpOnly; Secure; SameSite=Lax")
 			submatches := regex.FindStringSubmatch(actualCookie)
 			require.Len(t, submatches, 2)
@@ -3593,7 +4388,19 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 			require.NoError(t, err)
 			require.Equal(t, test.wantCSRFValueInCookieHeader, decodedCSRFCookieValue)
 		} else {
-			require.Empty(t, rsp.Header().Values("Set-Cookie"))
+			require.Empty(t, csrfSetCookies)
+		}
+
+		if test.wantLastIDPInCookieHeader != "" {
+			require.Len(t, lastIDPSetCookies, 1)
+			regex := regexp.MustCompile("__Secure-pinniped-idp=([^;]+); Path=/some-path/; Max-Age=7776000; HttpOnly; Secure; SameSite=Lax")
+			submatches := regex.FindStringSubmatch(lastIDPSetCookies[0])
+			require.Len(t, submatches, 2)
+			var decodedLastIDP string
+			require.NoError(t, happyCookieEncoder.Decode("idp", submatches[1], &decodedLastIDP))
+			require.Equal(t, test.wantLastIDPInCookieHeader, decodedLastIDP)
+		} else {
+			require.Empty(t, lastIDPSetCookies)
 		}
 	}
 
@@ -3604,28 +4411,35 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 			secretsClient := kubeClient.CoreV1().Secrets("some-namespace")
 			oidcClientsClient := supervisorClient.ConfigV1alpha1().OIDCClients("some-namespace")
 			oauthHelperWithRealStorage, kubeOauthStore := createOauthHelperWithRealStorage(secretsClient, oidcClientsClient)
-			oauthHelperWithNullStorage, _ := createOauthHelperWithNullStorage(secretsClient, oidcClientsClient)
+			oauthHelperWithNullStorage, nullOauthStore := createOauthHelperWithNullStorage(secretsClient, oidcClientsClient)
 
 			idps := test.idps.BuildFederationDomainIdentityProvidersListerFinder()
 
-			oidcIDPsCount := 0
+			additionalClaimsIDPsCount := 0
 			for _, p := range idps.GetIdentityProviders() {
-				if p.GetSessionProviderType() == psession.ProviderTypeOIDC {
-					oidcIDPsCount++
+				providerType := p.GetSessionProviderType()
+				if providerType == psession.ProviderTypeOIDC || providerType == psession.ProviderTypeLDAP || providerType == psession.ProviderTypeActiveDirectory {
+					additionalClaimsIDPsCount++
 				}
 			}
 			if len(test.wantDownstreamAdditionalClaims) > 0 {
-				require.True(t, oidcIDPsCount > 0, "wantDownstreamAdditionalClaims requires at least one OIDC IDP")
+				require.True(t, additionalClaimsIDPsCount > 0, "wantDownstreamAdditionalClaims requires at least one OIDC, LDAP, or ActiveDirectory IDP")
 			}
 
+			loginLimiter := newLoginLimiter(t, test)
 			subject := NewHandler(
 				downstreamIssuer,
 				idps,
 				oauthHelperWithNullStorage, oauthHelperWithRealStorage,
 				test.generateCSRF, test.generatePKCE, test.generateNonce,
 				test.stateEncoder, test.cookieEncoder,
+				newTOTPEnrollments(t, test.totpEnrolledSubject),
+				loginLimiter,
+				test.idpChooser,
+				happyCookieEncoder,
+				nullOauthStore,
 			)
-			runOneTestCase(t, test, subject, kubeOauthStore, supervisorClient, kubeClient, secretsClient)
+			runOneTestCase(t, test, subject, loginLimiter, kubeOauthStore, supervisorClient, kubeClient, secretsClient)
 		})
 	}
 
@@ -3639,17 +4453,23 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 		secretsClient := kubeClient.CoreV1().Secrets("some-namespace")
 		oidcClientsClient := supervisorClient.ConfigV1alpha1().OIDCClients("some-namespace")
 		oauthHelperWithRealStorage, kubeOauthStore := createOauthHelperWithRealStorage(secretsClient, oidcClientsClient)
-		oauthHelperWithNullStorage, _ := createOauthHelperWithNullStorage(secretsClient, oidcClientsClient)
+		oauthHelperWithNullStorage, nullOauthStore := createOauthHelperWithNullStorage(secretsClient, oidcClientsClient)
 		idpLister := test.idps.BuildFederationDomainIdentityProvidersListerFinder()
+		loginLimiter := newLoginLimiter(t, test)
 		subject := NewHandler(
 			downstreamIssuer,
 			idpLister,
 			oauthHelperWithNullStorage, oauthHelperWithRealStorage,
 			test.generateCSRF, test.generatePKCE, test.generateNonce,
 			test.stateEncoder, test.cookieEncoder,
+			newTOTPEnrollments(t, test.totpEnrolledSubject),
+			loginLimiter,
+			test.idpChooser,
+			happyCookieEncoder,
+			nullOauthStore,
 		)
 
-		runOneTestCase(t, test, subject, kubeOauthStore, supervisorClient, kubeClient, secretsClient)
+		runOneTestCase(t, test, subject, loginLimiter, kubeOauthStore, supervisorClient, kubeClient, secretsClient)
 
 		// Call the idpLister's setter to change the upstream IDP settings.
 		newProviderSettings := oidctestutil.NewTestUpstreamOIDCIdentityProviderBuilder().
@@ -3692,7 +4512,7 @@ func TestAuthorizationEndpoint(t *testing.T) { //nolint:gocyclo
 		// modified expectations. This should ensure that the implementation is using the in-memory cache
 		// of upstream IDP settings appropriately in terms of always getting the values from the cache
 		// on every request.
-		runOneTestCase(t, test, subject, kubeOauthStore, supervisorClient, kubeClient, secretsClient)
+		runOneTestCase(t, test, subject, loginLimiter, kubeOauthStore, supervisorClient, kubeClient, secretsClient)
 	})
 }
 
@@ -10,7 +10,9 @@ import (
 
 	"github.com/ory/fosite"
 
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
 	"go.pinniped.dev/internal/federationdomain/downstreamsession"
+	"go.pinniped.dev/internal/federationdomain/endpoints/consent"
 	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
 	"go.pinniped.dev/internal/federationdomain/formposthtml"
 	"go.pinniped.dev/internal/federationdomain/oidc"
@@ -24,6 +26,8 @@ func NewHandler(
 	oauthHelper fosite.OAuth2Provider,
 	stateDecoder, cookieDecoder oidc.Decoder,
 	redirectURI string,
+	consentURL string,
+	consentGrants *consentgrants.Store,
 ) http.Handler {
 	handler := httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
 		state, err := validateRequest(r, stateDecoder, cookieDecoder)
@@ -31,73 +35,102 @@ func NewHandler(
 			return err
 		}
 
-		idp, err := upstreamIDPs.FindUpstreamIDPByDisplayName(state.UpstreamName)
-		if err != nil || idp == nil {
-			plog.Warning("upstream provider not found")
-			return httperr.New(http.StatusUnprocessableEntity, "upstream provider not found")
-		}
+		return completeLogin(w, r, upstreamIDPs, oauthHelper, state, authcode(r), redirectURI, consentURL, consentGrants)
+	})
+	return securityheader.WrapWithCustomCSPFunc(handler, formposthtml.ContentSecurityPolicyForRequest)
+}
 
-		downstreamAuthParams, err := url.ParseQuery(state.AuthParams)
-		if err != nil {
-			plog.Error("error reading state downstream auth params", err)
-			return httperr.New(http.StatusBadRequest, "error reading state downstream auth params")
-		}
+// completeLogin finishes a browser-based login after the user has returned from the upstream identity provider
+// and the state param has been validated. It completes the login with the upstream identity provider and responds
+// with the downstream authorization response, or redirects to the consent page when the client requires consent.
+func completeLogin(
+	w http.ResponseWriter,
+	r *http.Request,
+	upstreamIDPs federationdomainproviders.FederationDomainIdentityProvidersFinderI,
+	oauthHelper fosite.OAuth2Provider,
+	state *oidc.UpstreamStateParamData,
+	authCode string,
+	redirectURI string,
+	consentURL string,
+	consentGrants *consentgrants.Store,
+) error {
+	idp, err := upstreamIDPs.FindUpstreamIDPByDisplayName(state.UpstreamName)
+	if err != nil || idp == nil {
+		plog.Warning("upstream provider not found")
+		return httperr.New(http.StatusUnprocessableEntity, "upstream provider not found")
+	}
 
-		// Recreate enough of the original authorize request so we can pass it to NewAuthorizeRequest().
-		reconstitutedAuthRequest := &http.Request{Form: downstreamAuthParams}
-		authorizeRequester, err := oauthHelper.NewAuthorizeRequest(r.Context(), reconstitutedAuthRequest)
-		if err != nil {
-			plog.Error("error using state downstream auth params", err,
-				"identityProviderDisplayName", idp.GetDisplayName(),
-				"identityProviderResourceName", idp.GetProvider().GetResourceName(),
-				"supervisorCallbackURL", redirectURI,
-				"fositeErr", oidc.FositeErrorForLog(err))
-			return httperr.New(http.StatusBadRequest, "error using state downstream auth params")
-		}
+	downstreamAuthParams, err := url.ParseQuery(state.AuthParams)
+	if err != nil {
+		plog.Error("error reading state downstream auth params", err)
+		return httperr.New(http.StatusBadRequest, "error reading state downstream auth params")
+	}
 
-		// Automatically grant certain scopes, but only if they were requested.
-		// This is instead of asking the user to approve these scopes. Note that `NewAuthorizeRequest` would have returned
-		// an error if the client requested a scope that they are not allowed to request, so we don't need to worry about that here.
-		downstreamsession.AutoApproveScopes(authorizeRequester)
+	// Recreate enough of the original authorize request so we can pass it to NewAuthorizeRequest().
+	reconstitutedAuthRequest := &http.Request{Form: downstreamAuthParams}
+	authorizeRequester, err := oauthHelper.NewAuthorizeRequest(r.Context(), reconstitutedAuthRequest)
+	if err != nil {
+		plog.Error("error using state downstream auth params", err,
+			"identityProviderDisplayName", idp.GetDisplayName(),
+			"identityProviderResourceName", idp.GetProvider().GetResourceName(),
+			"supervisorCallbackURL", redirectURI,
+			"fositeErr", oidc.FositeErrorForLog(err))
+		return httperr.New(http.StatusBadRequest, "error using state downstream auth params")
+	}
 
-		identity, loginExtras, err := idp.LoginFromCallback(r.Context(), authcode(r), state.PKCECode, state.Nonce, redirectURI)
-		if err != nil {
-			plog.InfoErr("unable to complete login from callback", err,
-				"identityProviderDisplayName", idp.GetDisplayName(),
-				"identityProviderResourceName", idp.GetProvider().GetResourceName(),
-				"supervisorCallbackURL", redirectURI)
-			return err
-		}
+	// Automatically grant certain scopes, but only if they were requested.
+	// This is instead of asking the user to approve these scopes. Note that `NewAuthorizeRequest` would have returned
+	// an error if the client requested a scope that they are not allowed to request, so we don't need to worry about that here.
+	downstreamsession.AutoApproveScopes(authorizeRequester)
 
-		session, err := downstreamsession.NewPinnipedSession(r.Context(), idp, &downstreamsession.SessionConfig{
-			UpstreamIdentity:    identity,
-			UpstreamLoginExtras: loginExtras,
-			ClientID:            authorizeRequester.GetClient().GetID(),
-			GrantedScopes:       authorizeRequester.GetGrantedScopes(),
-		})
-		if err != nil {
-			plog.InfoErr("unable to create a Pinniped session", err,
-				"identityProviderDisplayName", idp.GetDisplayName(),
-				"identityProviderResourceName", idp.GetProvider().GetResourceName(),
-				"supervisorCallbackURL", redirectURI)
-			return httperr.Wrap(http.StatusUnprocessableEntity, err.Error(), err)
-		}
-
-		authorizeResponder, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, session)
-		if err != nil {
-			plog.WarningErr("error while generating and saving authcode", err,
-				"identityProviderDisplayName", idp.GetDisplayName(),
-				"identityProviderResourceName", idp.GetProvider().GetResourceName(),
-				"supervisorCallbackURL", redirectURI,
-				"fositeErr", oidc.FositeErrorForLog(err))
-			return httperr.Wrap(http.StatusInternalServerError, "error while generating and saving authcode", err)
-		}
+	identity, loginExtras, err := idp.LoginFromCallback(r.Context(), authCode, state.PKCECode, state.Nonce, redirectURI)
+	if err != nil {
+		plog.InfoErr("unable to complete login from callback", err,
+			"identityProviderDisplayName", idp.GetDisplayName(),
+			"identityProviderResourceName", idp.GetProvider().GetResourceName(),
+			"supervisorCallbackURL", redirectURI)
+		return err
+	}
 
-		oauthHelper.WriteAuthorizeResponse(r.Context(), w, authorizeRequester, authorizeResponder)
+	session, err := downstreamsession.NewPinnipedSession(r.Context(), idp, &downstreamsession.SessionConfig{
+		UpstreamIdentity:    identity,
+		UpstreamLoginExtras: loginExtras,
+		Client:              authorizeRequester.GetClient(),
+		GrantedScopes:       authorizeRequester.GetGrantedScopes(),
+	})
+	if err != nil {
+		plog.InfoErr("unable to create a Pinniped session", err,
+			"identityProviderDisplayName", idp.GetDisplayName(),
+			"identityProviderResourceName", idp.GetProvider().GetResourceName(),
+			"supervisorCallbackURL", redirectURI)
+		return httperr.Wrap(http.StatusUnprocessableEntity, err.Error(), err)
+	}
 
+	redirected, err := consent.RedirectIfRequired(r, w, consentURL, consentGrants, authorizeRequester, session, state)
+	if err != nil {
+		plog.WarningErr("error checking consent", err,
+			"identityProviderDisplayName", idp.GetDisplayName(),
+			"identityProviderResourceName", idp.GetProvider().GetResourceName(),
+			"supervisorCallbackURL", redirectURI)
+		return httperr.Wrap(http.StatusInternalServerError, "error checking consent", err)
+	}
+	if redirected {
 		return nil
-	})
-	return securityheader.WrapWithCustomCSP(handler, formposthtml.ContentSecurityPolicy())
+	}
+
+	authorizeResponder, err := oauthHelper.NewAuthorizeResponse(r.Context(), authorizeRequester, session)
+	if err != nil {
+		plog.WarningErr("error while generating and saving authcode", err,
+			"identityProviderDisplayName", idp.GetDisplayName(),
+			"identityProviderResourceName", idp.GetProvider().GetResourceName(),
+			"supervisorCallbackURL", redirectURI,
+			"fositeErr", oidc.FositeErrorForLog(err))
+		return httperr.Wrap(http.StatusInternalServerError, "error while generating and saving authcode", err)
+	}
+
+	oauthHelper.WriteAuthorizeResponse(r.Context(), w, authorizeRequester, authorizeResponder)
+
+	return nil
 }
 
 func authcode(r *http.Request) string {
@@ -5,11 +5,13 @@ package callback
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -18,10 +20,13 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes/fake"
 
 	supervisorconfigv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
 	supervisorfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
 	"go.pinniped.dev/internal/federationdomain/endpoints/jwks"
 	"go.pinniped.dev/internal/federationdomain/oidc"
 	"go.pinniped.dev/internal/federationdomain/oidcclientvalidator"
@@ -131,6 +136,10 @@ var (
 			copyOfGitHub := *(startingSessionData.GitHub)
 			copyOfCustomSession.GitHub = &copyOfGitHub
 		}
+		if startingSessionData.SAML != nil {
+			copyOfSAML := *(startingSessionData.SAML)
+			copyOfCustomSession.SAML = &copyOfSAML
+		}
 		copyOfCustomSession.Username = wantDownstreamUsername
 		copyOfCustomSession.UpstreamUsername = wantUpstreamUsername
 		copyOfCustomSession.UpstreamGroups = wantUpstreamGroups
@@ -215,6 +224,25 @@ func TestCallbackEndpoint(t *testing.T) {
 		require.NoError(t, kubeClient.Tracker().Add(secret))
 	}
 
+	addDynamicClientWhichRequiresConsentAndSecretToKubeResources := func(t *testing.T, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset) {
+		oidcClient, secret := testutil.FullyCapableOIDCClientAndStorageSecret(t,
+			"some-namespace", downstreamDynamicClientID, downstreamDynamicClientUID, downstreamRedirectURI, nil,
+			[]string{testutil.HashedPassword1AtGoMinCost}, oidcclientvalidator.Validate)
+		oidcClient.Spec.RequireConsent = true
+		require.NoError(t, supervisorClient.Tracker().Add(oidcClient))
+		require.NoError(t, kubeClient.Tracker().Add(secret))
+	}
+
+	addDynamicClientWhichRequiresConsentAndConsentGrantToKubeResources := func(t *testing.T, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset) {
+		addDynamicClientWhichRequiresConsentAndSecretToKubeResources(t, supervisorClient, kubeClient)
+		store := consentgrants.New(downstreamIssuer, kubeClient.CoreV1().Secrets("some-namespace"), rand.Reader, time.Now)
+		require.NoError(t, store.Grant(context.Background(), downstreamDynamicClientID, downstreamDynamicClientUID,
+			oidcUpstreamIssuer+"?idpName="+happyOIDCUpstreamIDPName+"&sub="+oidcUpstreamSubjectQueryEscaped,
+			oidcUpstreamUsername, happyDownstreamScopesGranted, time.Now()))
+		// The test only wants to see the Secrets which are created by the handler.
+		kubeClient.ClearActions()
+	}
+
 	prefixUsernameAndGroupsPipeline := transformtestutil.NewPrefixingPipeline(t, transformationUsernamePrefix, transformationGroupsPrefix)
 	rejectAuthPipeline := transformtestutil.NewRejectAllAuthPipeline(t)
 
@@ -245,6 +273,7 @@ func TestCallbackEndpoint(t *testing.T) {
 		wantDownstreamAdditionalClaims    map[string]any
 		wantOIDCAuthcodeExchangeCall      *expectedOIDCAuthcodeExchange
 		wantGitHubAuthcodeExchangeCall    *expectedGitHubAuthcodeExchange
+		wantConsentRedirect               bool
 	}{
 		{
 			name:   "OIDC: GET with good state and cookie and successful upstream token exchange with response_mode=form_post returns 200 with HTML+JS form",
@@ -400,6 +429,46 @@ func TestCallbackEndpoint(t *testing.T) {
 				args:                    happyOIDCUpstreamExchangeAuthcodeAndValidateTokenArgs,
 			},
 		},
+		{
+			name:                "GET with good state and cookie when using dynamic client which requires consent redirects to the consent page",
+			idps:                testidplister.NewUpstreamIDPListerBuilder().WithOIDC(happyOIDCUpstream().Build()),
+			kubeResources:       addDynamicClientWhichRequiresConsentAndSecretToKubeResources,
+			method:              http.MethodGet,
+			path:                newRequestPath().WithState(happyOIDCStateForDynamicClient).String(),
+			csrfCookie:          happyCSRFCookie,
+			wantStatus:          http.StatusSeeOther,
+			wantContentType:     htmlContentType,
+			wantConsentRedirect: true,
+			wantOIDCAuthcodeExchangeCall: &expectedOIDCAuthcodeExchange{
+				performedByUpstreamName: happyOIDCUpstreamIDPName,
+				args:                    happyOIDCUpstreamExchangeAuthcodeAndValidateTokenArgs,
+			},
+		},
+		{
+			name:                              "GET with good state and cookie when using dynamic client which requires consent and the end user already consented returns 303 to downstream client callback with its state and code",
+			idps:                              testidplister.NewUpstreamIDPListerBuilder().WithOIDC(happyOIDCUpstream().Build()),
+			kubeResources:                     addDynamicClientWhichRequiresConsentAndConsentGrantToKubeResources,
+			method:                            http.MethodGet,
+			path:                              newRequestPath().WithState(happyOIDCStateForDynamicClient).String(),
+			csrfCookie:                        happyCSRFCookie,
+			wantStatus:                        http.StatusSeeOther,
+			wantRedirectLocationRegexp:        happyDownstreamRedirectLocationRegexp,
+			wantBody:                          "",
+			wantDownstreamIDTokenSubject:      oidcUpstreamIssuer + "?idpName=" + happyOIDCUpstreamIDPName + "&sub=" + oidcUpstreamSubjectQueryEscaped,
+			wantDownstreamIDTokenUsername:     oidcUpstreamUsername,
+			wantDownstreamIDTokenGroups:       oidcUpstreamGroupMembership,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamClientID:            downstreamDynamicClientID,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   happyDownstreamCustomSessionDataForOIDCUpstream,
+			wantOIDCAuthcodeExchangeCall: &expectedOIDCAuthcodeExchange{
+				performedByUpstreamName: happyOIDCUpstreamIDPName,
+				args:                    happyOIDCUpstreamExchangeAuthcodeAndValidateTokenArgs,
+			},
+		},
 		{
 			name:                              "GET with authcode exchange that returns an access token but no refresh token when there is a userinfo endpoint returns 303 to downstream client callback with its state and code",
 			idps:                              testidplister.NewUpstreamIDPListerBuilder().WithOIDC(happyOIDCUpstream().WithEmptyRefreshToken().WithAccessToken(oidcUpstreamAccessToken, metav1.NewTime(time.Now().Add(9*time.Hour))).WithUserInfoURL().Build()),
@@ -1757,7 +1826,10 @@ func TestCallbackEndpoint(t *testing.T) {
 			jwksProviderIsUnused := jwks.NewDynamicJWKSProvider()
 			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, hmacSecretFunc, jwksProviderIsUnused, timeoutsConfiguration)
 
-			subject := NewHandler(test.idps.BuildFederationDomainIdentityProvidersListerFinder(), oauthHelper, happyStateCodec, happyCookieCodec, happyUpstreamRedirectURI)
+			consentGrants := consentgrants.New(downstreamIssuer, secrets, rand.Reader, time.Now)
+
+			subject := NewHandler(test.idps.BuildFederationDomainIdentityProvidersListerFinder(), oauthHelper, happyStateCodec, happyCookieCodec,
+				happyUpstreamRedirectURI, downstreamIssuer+oidc.ConsentEndpointPath, consentGrants)
 			reqContext := context.WithValue(context.Background(), struct{ name string }{name: "test"}, "request-context")
 			req := httptest.NewRequest(test.method, test.path, nil).WithContext(reqContext)
 			if test.csrfCookie != "" {
@@ -1791,6 +1863,21 @@ func TestCallbackEndpoint(t *testing.T) {
 			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
 
 			switch {
+			// If we want a redirect to the consent page, assert that the login is waiting for consent.
+			case test.wantConsentRedirect:
+				require.Regexp(t, "^"+regexp.QuoteMeta(downstreamIssuer+oidc.ConsentEndpointPath+"?id=")+"[A-Za-z0-9_-]{43}$",
+					rsp.Header().Get("Location"))
+				pendingSecrets, err := secrets.List(context.Background(), metav1.ListOptions{LabelSelector: crud.SecretLabelKey + "=" + consentgrants.PendingTypeLabelValue})
+				require.NoError(t, err)
+				require.Len(t, pendingSecrets.Items, 1)
+				pending, err := consentgrants.ReadPendingFromSecret(&pendingSecrets.Items[0])
+				require.NoError(t, err)
+				require.Equal(t, happyDownstreamCSRF, string(pending.CSRFToken))
+				require.Equal(t, happyDownstreamScopesGranted, pending.GrantedScopes)
+				require.Equal(t, oidcUpstreamUsername, pending.Session.Custom.Username)
+				// No authcode was issued yet.
+				testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secrets, labels.Set{crud.SecretLabelKey: "authcode"}, 0)
+
 			// If we want a specific static response body, assert that.
 			case test.wantBody != "":
 				require.Equal(t, test.wantBody, rsp.Body.String())
@@ -0,0 +1,84 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package callback
+
+import (
+	"net/http"
+
+	"github.com/ory/fosite"
+
+	idpdiscoveryv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idpdiscovery/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
+	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
+	"go.pinniped.dev/internal/federationdomain/formposthtml"
+	"go.pinniped.dev/internal/federationdomain/oidc"
+	"go.pinniped.dev/internal/federationdomain/samlacshtml"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	samlResponseParamName = "SAMLResponse"
+	relayStateParamName   = "RelayState"
+)
+
+// NewSAMLACSHandler returns a handler for the SAML assertion consumer service (ACS) endpoint.
+//
+// SAML identity providers use the HTTP-POST binding to send the SAMLResponse and RelayState to this endpoint.
+// That is a cross-site POST, so the browser will not send the Supervisor's CSRF cookie along with it. To be able
+// to validate the CSRF cookie, the first POST responds with a page which immediately re-posts the SAMLResponse and
+// the state param to this same endpoint as a same-site POST, which does include the CSRF cookie.
+func NewSAMLACSHandler(
+	upstreamIDPs federationdomainproviders.FederationDomainIdentityProvidersFinderI,
+	oauthHelper fosite.OAuth2Provider,
+	stateDecoder, cookieDecoder oidc.Decoder,
+	acsURL string,
+	consentURL string,
+	consentGrants *consentgrants.Store,
+) http.Handler {
+	handler := httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodPost {
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try POST)", r.Method)
+		}
+
+		samlResponse := r.PostFormValue(samlResponseParamName)
+		if samlResponse == "" {
+			plog.Info("SAMLResponse param not found")
+			return httperr.New(http.StatusBadRequest, "SAMLResponse param not found")
+		}
+
+		if relayState := r.PostFormValue(relayStateParamName); relayState != "" {
+			// This is the cross-site POST from the identity provider.
+			return writeRepostPage(w, acsURL, samlResponse, relayState)
+		}
+
+		// This is the same-site POST from our own re-post page.
+		_, state, err := oidc.ReadStateParamAndValidateCSRFCookie(r, cookieDecoder, stateDecoder)
+		if err != nil {
+			plog.InfoErr("state or CSRF error", err)
+			return err
+		}
+
+		if state.UpstreamType != string(idpdiscoveryv1alpha1.IDPTypeSAML) {
+			return httperr.Newf(http.StatusBadRequest, "not a supported upstream IDP type for this endpoint: %q", state.UpstreamType)
+		}
+
+		return completeLogin(w, r, upstreamIDPs, oauthHelper, state, samlResponse, acsURL, consentURL, consentGrants)
+	})
+	return securityheader.WrapWithCustomCSPFunc(handler, formposthtml.ContentSecurityPolicyForRequest)
+}
+
+func writeRepostPage(w http.ResponseWriter, acsURL, samlResponse, relayState string) error {
+	// Replace the CSP header which was set by the wrapper, since this page needs to run its own script.
+	w.Header().Set("Content-Security-Policy", samlacshtml.ContentSecurityPolicy())
+	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	return samlacshtml.Template().Execute(w, &samlacshtml.PageData{
+		ACSURL:       acsURL,
+		SAMLResponse: samlResponse,
+		State:        relayState,
+	})
+}
@@ -0,0 +1,390 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package callback
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"k8s.io/client-go/kubernetes/fake"
+
+	supervisorfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
+	"go.pinniped.dev/internal/federationdomain/endpoints/jwks"
+	"go.pinniped.dev/internal/federationdomain/oidc"
+	"go.pinniped.dev/internal/federationdomain/samlacshtml"
+	"go.pinniped.dev/internal/federationdomain/storage"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+	"go.pinniped.dev/internal/testutil/testidplister"
+	"go.pinniped.dev/internal/testutil/transformtestutil"
+)
+
+const (
+	happySAMLIDPName        = "upstream-saml-idp-name"
+	happySAMLIDPResourceUID = "upstream-saml-idp-resource-uid"
+	samlIDPEntityID         = "https://idp.example.com/saml/metadata"
+	samlUpstreamNameID      = "some-name-id"
+	samlUpstreamUsername    = "some-saml-username"
+	happySAMLResponse       = "some-base64-encoded-saml-response"
+	happyACSURL             = downstreamIssuer + "/saml/acs"
+)
+
+var (
+	samlUpstreamGroupMembership     = []string{"saml-group-1", "saml-group-2"}
+	samlDownstreamSubject           = fmt.Sprintf("%s?idpName=%s&sub=%s", samlIDPEntityID, happySAMLIDPName, samlUpstreamNameID)
+	samlUpstreamSessionNotOnOrAfter = time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	happyDownstreamCustomSessionDataForSAMLUpstream = &psession.CustomSessionData{
+		Username:         samlUpstreamUsername,
+		UpstreamUsername: samlUpstreamUsername,
+		UpstreamGroups:   samlUpstreamGroupMembership,
+		ProviderUID:      happySAMLIDPResourceUID,
+		ProviderName:     happySAMLIDPName,
+		ProviderType:     psession.ProviderTypeSAML,
+		SAML: &psession.SAMLSessionData{
+			NameID:              samlUpstreamNameID,
+			SessionNotOnOrAfter: samlUpstreamSessionNotOnOrAfter,
+		},
+	}
+)
+
+func TestSAMLACSEndpoint(t *testing.T) {
+	var stateEncoderHashKey = []byte("fake-hash-secret")
+	var stateEncoderBlockKey = []byte("0123456789ABCDEF") // block encryption requires 16/24/32 bytes for AES
+	var cookieEncoderHashKey = []byte("fake-hash-secret2")
+	var cookieEncoderBlockKey = []byte("0123456789ABCDE2") // block encryption requires 16/24/32 bytes for AES
+
+	var happyStateCodec = securecookie.New(stateEncoderHashKey, stateEncoderBlockKey)
+	happyStateCodec.SetSerializer(securecookie.JSONEncoder{})
+	var happyCookieCodec = securecookie.New(cookieEncoderHashKey, cookieEncoderBlockKey)
+	happyCookieCodec.SetSerializer(securecookie.JSONEncoder{})
+
+	happySAMLState := happySAMLUpstreamStateParam().Build(t, happyStateCodec)
+
+	encodedIncomingCookieCSRFValue, err := happyCookieCodec.Encode("csrf", happyDownstreamCSRF)
+	require.NoError(t, err)
+	happyCSRFCookie := "__Host-pinniped-csrf=" + encodedIncomingCookieCSRFValue
+
+	happyParseResponseArgs := &oidctestutil.ParseResponseArgs{
+		EncodedResponse:     happySAMLResponse,
+		RequestID:           "id-" + happyDownstreamNonce,
+		DownstreamIssuerURL: downstreamIssuer,
+		ACSURL:              happyACSURL,
+		IDPDisplayName:      happySAMLIDPName,
+	}
+
+	// Note that fosite puts the granted scopes as a param in the redirect URI even though the spec doesn't seem to require it
+	happyDownstreamRedirectLocationRegexp := downstreamRedirectURI + `\?code=([^&]+)&scope=openid\+username\+groups&state=` + happyDownstreamState
+
+	tests := []struct {
+		name string
+
+		idps       *testidplister.UpstreamIDPListerBuilder
+		method     string
+		form       url.Values
+		csrfCookie string
+
+		wantStatus                      int
+		wantContentType                 string
+		wantBody                        string
+		wantRepostPage                  bool
+		wantRedirectLocationRegexp      string
+		wantBodyFormResponseRegexp      string
+		wantDownstreamIDTokenSubject    string
+		wantDownstreamIDTokenUsername   string
+		wantDownstreamIDTokenGroups     []string
+		wantDownstreamCustomSessionData *psession.CustomSessionData
+		wantParseResponseCall           *oidctestutil.ParseResponseArgs
+	}{
+		{
+			name:            "cross-site POST from the identity provider with SAMLResponse and RelayState renders a page which re-posts the form",
+			idps:            testidplister.NewUpstreamIDPListerBuilder().WithSAML(happySAMLUpstream().Build()),
+			method:          http.MethodPost,
+			form:            url.Values{"SAMLResponse": {happySAMLResponse}, "RelayState": {happySAMLState}},
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/html;charset=UTF-8",
+			wantRepostPage:  true,
+		},
+		{
+			name:                            "same-site POST with good state and cookie and successful SAMLResponse validation returns 303 to downstream client callback",
+			idps:                            testidplister.NewUpstreamIDPListerBuilder().WithSAML(happySAMLUpstream().Build()),
+			method:                          http.MethodPost,
+			form:                            url.Values{"SAMLResponse": {happySAMLResponse}, "state": {happySAMLState}},
+			csrfCookie:                      happyCSRFCookie,
+			wantStatus:                      http.StatusSeeOther,
+			wantRedirectLocationRegexp:      happyDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:    samlDownstreamSubject,
+			wantDownstreamIDTokenUsername:   samlUpstreamUsername,
+			wantDownstreamIDTokenGroups:     samlUpstreamGroupMembership,
+			wantDownstreamCustomSessionData: happyDownstreamCustomSessionDataForSAMLUpstream,
+			wantParseResponseCall:           happyParseResponseArgs,
+		},
+		{
+			name:   "same-site POST with good state and cookie and successful SAMLResponse validation with response_mode=form_post returns 200 with HTML+JS form",
+			idps:   testidplister.NewUpstreamIDPListerBuilder().WithSAML(happySAMLUpstream().Build()),
+			method: http.MethodPost,
+			form: url.Values{"SAMLResponse": {happySAMLResponse}, "state": {
+				happySAMLUpstreamStateParam().WithAuthorizeRequestParams(
+					shallowCopyAndModifyQuery(
+						happyDownstreamRequestParamsQuery,
+						map[string]string{"response_mode": "form_post"},
+					).Encode(),
+				).Build(t, happyStateCodec),
+			}},
+			csrfCookie:                      happyCSRFCookie,
+			wantStatus:                      http.StatusOK,
+			wantContentType:                 "text/html;charset=UTF-8",
+			wantBodyFormResponseRegexp:      `<code id="manual-auth-code">(.+)</code>`,
+			wantDownstreamIDTokenSubject:    samlDownstreamSubject,
+			wantDownstreamIDTokenUsername:   samlUpstreamUsername,
+			wantDownstreamIDTokenGroups:     samlUpstreamGroupMembership,
+			wantDownstreamCustomSessionData: happyDownstreamCustomSessionDataForSAMLUpstream,
+			wantParseResponseCall:           happyParseResponseArgs,
+		},
+		{
+			name: "using identity transformations which modify the username and group names",
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithSAML(
+				happySAMLUpstream().WithTransformsForFederationDomain(
+					transformtestutil.NewPrefixingPipeline(t, transformationUsernamePrefix, transformationGroupsPrefix),
+				).Build(),
+			),
+			method:                        http.MethodPost,
+			form:                          url.Values{"SAMLResponse": {happySAMLResponse}, "state": {happySAMLState}},
+			csrfCookie:                    happyCSRFCookie,
+			wantStatus:                    http.StatusSeeOther,
+			wantRedirectLocationRegexp:    happyDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:  samlDownstreamSubject,
+			wantDownstreamIDTokenUsername: transformationUsernamePrefix + samlUpstreamUsername,
+			wantDownstreamIDTokenGroups:   testutil.AddPrefixToEach(transformationGroupsPrefix, samlUpstreamGroupMembership),
+			wantDownstreamCustomSessionData: happyDownstreamCustomSessionDataWithUsernameAndGroups(
+				happyDownstreamCustomSessionDataForSAMLUpstream,
+				transformationUsernamePrefix+samlUpstreamUsername,
+				samlUpstreamUsername,
+				samlUpstreamGroupMembership,
+			),
+			wantParseResponseCall: happyParseResponseArgs,
+		},
+		{
+			name: "using identity transformations which reject the authentication",
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithSAML(
+				happySAMLUpstream().WithTransformsForFederationDomain(transformtestutil.NewRejectAllAuthPipeline(t)).Build(),
+			),
+			method:                http.MethodPost,
+			form:                  url.Values{"SAMLResponse": {happySAMLResponse}, "state": {happySAMLState}},
+			csrfCookie:            happyCSRFCookie,
+			wantStatus:            http.StatusUnprocessableEntity,
+			wantContentType:       htmlContentType,
+			wantBody:              "Unprocessable Entity: configured identity policy rejected this authentication: authentication was rejected by a configured policy\n",
+			wantParseResponseCall: happyParseResponseArgs,
+		},
+		{
+			name:                  "SAMLResponse validation fails",
+			idps:                  testidplister.NewUpstreamIDPListerBuilder().WithSAML(happySAMLUpstream().WithParseResponseError(errors.New("some error")).Build()),
+			method:                http.MethodPost,
+			form:                  url.Values{"SAMLResponse": {happySAMLResponse}, "state": {happySAMLState}},
+			csrfCookie:            happyCSRFCookie,
+			wantStatus:            http.StatusUnprocessableEntity,
+			wantContentType:       htmlContentType,
+			wantBody:              "Unprocessable Entity: failed to validate SAML response\n",
+			wantParseResponseCall: happyParseResponseArgs,
+		},
+		{
+			name:            "GET is not allowed",
+			idps:            testidplister.NewUpstreamIDPListerBuilder().WithSAML(happySAMLUpstream().Build()),
+			method:          http.MethodGet,
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusMethodNotAllowed,
+			wantContentType: htmlContentType,
+			wantBody:        "Method Not Allowed: GET (try POST)\n",
+		},
+		{
+			name:            "POST without SAMLResponse is an error",
+			idps:            testidplister.NewUpstreamIDPListerBuilder().WithSAML(happySAMLUpstream().Build()),
+			method:          http.MethodPost,
+			form:            url.Values{"RelayState": {happySAMLState}},
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: htmlContentType,
+			wantBody:        "Bad Request: SAMLResponse param not found\n",
+		},
+		{
+			name:            "same-site POST without CSRF cookie is an error",
+			idps:            testidplister.NewUpstreamIDPListerBuilder().WithSAML(happySAMLUpstream().Build()),
+			method:          http.MethodPost,
+			form:            url.Values{"SAMLResponse": {happySAMLResponse}, "state": {happySAMLState}},
+			wantStatus:      http.StatusForbidden,
+			wantContentType: htmlContentType,
+			wantBody:        "Forbidden: CSRF cookie is missing\n",
+		},
+		{
+			name:            "same-site POST without state is an error",
+			idps:            testidplister.NewUpstreamIDPListerBuilder().WithSAML(happySAMLUpstream().Build()),
+			method:          http.MethodPost,
+			form:            url.Values{"SAMLResponse": {happySAMLResponse}},
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: htmlContentType,
+			wantBody:        "Bad Request: state param not found\n",
+		},
+		{
+			name:            "same-site POST with state for a different type of identity provider is an error",
+			idps:            testidplister.NewUpstreamIDPListerBuilder().WithOIDC(happyOIDCUpstream().Build()),
+			method:          http.MethodPost,
+			form:            url.Values{"SAMLResponse": {happySAMLResponse}, "state": {happyOIDCUpstreamStateParam().Build(t, happyStateCodec)}},
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: htmlContentType,
+			wantBody:        "Bad Request: not a supported upstream IDP type for this endpoint: \"oidc\"\n",
+		},
+		{
+			name:            "same-site POST with state for an identity provider which no longer exists is an error",
+			idps:            testidplister.NewUpstreamIDPListerBuilder(),
+			method:          http.MethodPost,
+			form:            url.Values{"SAMLResponse": {happySAMLResponse}, "state": {happySAMLState}},
+			csrfCookie:      happyCSRFCookie,
+			wantStatus:      http.StatusUnprocessableEntity,
+			wantContentType: htmlContentType,
+			wantBody:        "Unprocessable Entity: upstream provider not found\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			supervisorClient := supervisorfake.NewSimpleClientset()
+			secrets := kubeClient.CoreV1().Secrets("some-namespace")
+			oidcClientsClient := supervisorClient.ConfigV1alpha1().OIDCClients("some-namespace")
+
+			// Configure fosite the same way that the production code would.
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			// Use lower minimum required bcrypt cost than we would use in production to keep unit the tests fast.
+			oauthStore := storage.NewKubeStorage(secrets, oidcClientsClient, timeoutsConfiguration, bcrypt.MinCost)
+			hmacSecretFunc := func() []byte { return []byte("some secret - must have at least 32 bytes") }
+			jwksProviderIsUnused := jwks.NewDynamicJWKSProvider()
+			oauthHelper := oidc.FositeOauth2Helper(oauthStore, downstreamIssuer, hmacSecretFunc, jwksProviderIsUnused, timeoutsConfiguration)
+
+			subject := NewSAMLACSHandler(test.idps.BuildFederationDomainIdentityProvidersListerFinder(), oauthHelper, happyStateCodec, happyCookieCodec, happyACSURL,
+				downstreamIssuer+oidc.ConsentEndpointPath, consentgrants.New(downstreamIssuer, secrets, rand.Reader, time.Now))
+			reqContext := context.WithValue(context.Background(), struct{ name string }{name: "test"}, "request-context")
+			req := httptest.NewRequest(test.method, "/downstream-provider-name/saml/acs", strings.NewReader(test.form.Encode())).WithContext(reqContext)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.csrfCookie != "" {
+				req.Header.Set("Cookie", test.csrfCookie)
+			}
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			if test.wantRepostPage {
+				require.Equal(t, samlacshtml.ContentSecurityPolicy(), rsp.Header().Get("Content-Security-Policy"))
+			} else {
+				testutil.RequireSecurityHeadersWithFormPostPageCSPs(t, rsp)
+			}
+
+			if test.wantParseResponseCall != nil {
+				test.idps.RequireExactlyOneSAMLParseResponse(t, happySAMLIDPName, test.wantParseResponseCall)
+			} else {
+				test.idps.RequireExactlyZeroAuthcodeExchanges(t)
+			}
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
+
+			switch {
+			case test.wantBody != "":
+				require.Equal(t, test.wantBody, rsp.Body.String())
+			case test.wantRepostPage:
+				require.Contains(t, rsp.Body.String(), `<form method="post" action="`+happyACSURL+`">`)
+				require.Contains(t, rsp.Body.String(), `<input type="hidden" name="SAMLResponse" value="`+happySAMLResponse+`"/>`)
+				require.Contains(t, rsp.Body.String(), `<input type="hidden" name="state" value="`+happySAMLState+`"/>`)
+			case test.wantBodyFormResponseRegexp != "":
+				oidctestutil.RequireAuthCodeRegexpMatch(
+					t,
+					rsp.Body.String(),
+					test.wantBodyFormResponseRegexp,
+					kubeClient,
+					secrets,
+					oauthStore,
+					happyDownstreamScopesGranted,
+					test.wantDownstreamIDTokenSubject,
+					test.wantDownstreamIDTokenUsername,
+					test.wantDownstreamIDTokenGroups,
+					happyDownstreamScopesRequested,
+					downstreamPKCEChallenge,
+					downstreamPKCEChallengeMethod,
+					downstreamNonce,
+					downstreamPinnipedClientID,
+					downstreamRedirectURI,
+					test.wantDownstreamCustomSessionData,
+					nil,
+				)
+			default:
+				require.Empty(t, rsp.Body.String())
+			}
+
+			if test.wantRedirectLocationRegexp != "" {
+				require.Len(t, rsp.Header().Values("Location"), 1)
+				oidctestutil.RequireAuthCodeRegexpMatch(
+					t,
+					rsp.Header().Get("Location"),
+					test.wantRedirectLocationRegexp,
+					kubeClient,
+					secrets,
+					oauthStore,
+					happyDownstreamScopesGranted,
+					test.wantDownstreamIDTokenSubject,
+					test.wantDownstreamIDTokenUsername,
+					test.wantDownstreamIDTokenGroups,
+					happyDownstreamScopesRequested,
+					downstreamPKCEChallenge,
+					downstreamPKCEChallengeMethod,
+					downstreamNonce,
+					downstreamPinnipedClientID,
+					downstreamRedirectURI,
+					test.wantDownstreamCustomSessionData,
+					nil,
+				)
+			}
+		})
+	}
+}
+
+func happySAMLUpstreamStateParam() *oidctestutil.UpstreamStateParamBuilder {
+	return &oidctestutil.UpstreamStateParamBuilder{
+		U: happySAMLIDPName,
+		P: happyDownstreamRequestParams,
+		T: "saml",
+		N: happyDownstreamNonce,
+		C: happyDownstreamCSRF,
+		V: happyDownstreamStateVersion,
+	}
+}
+
+func happySAMLUpstream() *oidctestutil.TestUpstreamSAMLIdentityProviderBuilder {
+	return oidctestutil.NewTestUpstreamSAMLIdentityProviderBuilder().
+		WithName(happySAMLIDPName).
+		WithResourceUID(happySAMLIDPResourceUID).
+		WithIDPEntityID(samlIDPEntityID).
+		WithUser(&upstreamprovider.SAMLUser{
+			Username:            samlUpstreamUsername,
+			Groups:              samlUpstreamGroupMembership,
+			NameID:              samlUpstreamNameID,
+			DownstreamSubject:   samlDownstreamSubject,
+			SessionNotOnOrAfter: samlUpstreamSessionNotOnOrAfter,
+		})
+}
@@ -9,7 +9,11 @@ import (
 	"net/url"
 	"sort"
 
+	"github.com/ory/fosite"
+
 	"go.pinniped.dev/generated/latest/apis/supervisor/oidc"
+	"go.pinniped.dev/internal/federationdomain/branding"
+	"go.pinniped.dev/internal/federationdomain/clientregistry"
 	"go.pinniped.dev/internal/federationdomain/endpoints/chooseidp/chooseidphtml"
 	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
 	"go.pinniped.dev/internal/httputil/httperr"
@@ -20,8 +24,13 @@ import (
 // to this page, copying all the same parameters from the original authorization request. Each button on this page
 // simply adds the IDP's name as an additional request parameter to the original authorization request's parameters,
 // and sends the user back to the authorization endpoint, where the authorization flow can start from scratch using
-// the original params with the extra pinniped_idp_name param added.
-func NewHandler(authURL string, upstreamIDPs federationdomainproviders.FederationDomainIdentityProvidersListerI) http.Handler {
+// the original params with the extra pinniped_idp_name param added. Only the IDPs which the client is allowed to use
+// are shown.
+func NewHandler(
+	authURL string,
+	upstreamIDPs federationdomainproviders.FederationDomainIdentityProvidersListerI,
+	clients fosite.ClientManager,
+) http.Handler {
 	handler := httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
 		if r.Method != http.MethodGet {
 			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET)", r.Method)
@@ -42,22 +51,35 @@ func NewHandler(authURL string, upstreamIDPs federationdomainproviders.Federatio
 			}
 		}
 
+		// Unknown or invalid clients will be rejected by the authorization endpoint, so lookup errors can be ignored.
+		client, _ := clients.GetClient(r.Context(), query.Get("client_id"))
+
+		allIDPs := upstreamIDPs.GetIdentityProviders()
 		var idps []chooseidphtml.IdentityProvider
-		for _, p := range upstreamIDPs.GetIdentityProviders() {
-			idps = append(idps, newIDPForPageData(p.GetDisplayName()))
+		for _, p := range allIDPs {
+			if clientregistry.IdentityProviderAllowed(client, p.GetDisplayName()) {
+				idps = append(idps, newIDPForPageData(p.GetDisplayName()))
+			}
 		}
 
 		sort.SliceStable(idps, func(i, j int) bool {
 			return idps[i].DisplayName < idps[j].DisplayName
 		})
 
+		if len(idps) == 0 && len(allIDPs) > 0 {
+			return httperr.New(http.StatusForbidden,
+				"this client is not allowed to use any of the identity providers of this FederationDomain")
+		}
 		if len(idps) == 0 {
 			// This shouldn't normally happen in practice because the auth endpoint would not have redirected to here.
 			return httperr.New(http.StatusInternalServerError,
 				"please check the server's configuration: no valid identity providers found for this FederationDomain")
 		}
 
-		return chooseidphtml.Template().Execute(w, &chooseidphtml.PageData{IdentityProviders: idps})
+		return chooseidphtml.Template().Execute(w, &chooseidphtml.PageData{
+			IdentityProviders: idps,
+			Branding:          branding.FromContext(r.Context()),
+		})
 	})
 
 	return wrapSecurityHeaders(handler)
@@ -65,7 +87,7 @@ func NewHandler(authURL string, upstreamIDPs federationdomainproviders.Federatio
 
 func wrapSecurityHeaders(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		wrapped := securityheader.WrapWithCustomCSP(handler, chooseidphtml.ContentSecurityPolicy())
+		wrapped := securityheader.WrapWithCustomCSPFunc(handler, chooseidphtml.ContentSecurityPolicyForRequest)
 		wrapped.ServeHTTP(w, r)
 	})
 }
@@ -10,17 +10,27 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"k8s.io/client-go/kubernetes/fake"
 
+	supervisorfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	"go.pinniped.dev/internal/federationdomain/clientregistry"
 	"go.pinniped.dev/internal/federationdomain/endpoints/chooseidp/chooseidphtml"
 	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
 	"go.pinniped.dev/internal/federationdomain/oidc"
+	"go.pinniped.dev/internal/federationdomain/oidcclientvalidator"
+	"go.pinniped.dev/internal/oidcclientsecretstorage"
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/internal/testutil/oidctestutil"
 	"go.pinniped.dev/internal/testutil/testidplister"
 )
 
 func TestChooseIDPHandler(t *testing.T) {
-	const testIssuer = "https://pinniped.dev/issuer"
+	const (
+		testIssuer      = "https://pinniped.dev/issuer"
+		testNamespace   = "some-namespace"
+		dynamicClientID = "client.oauth.pinniped.dev-test-name"
+	)
 
 	testReqQuery := url.Values{
 		"client_id":     []string{"foo"},
@@ -30,6 +40,14 @@ func TestChooseIDPHandler(t *testing.T) {
 	}
 	testIssuerWithTestReqQuery := testIssuer + "?" + testReqQuery.Encode()
 
+	dynamicClientReqQuery := url.Values{
+		"client_id":     []string{dynamicClientID},
+		"redirect_uri":  []string{"bar"},
+		"scope":         []string{"baz"},
+		"response_type": []string{"bat"},
+	}
+	testIssuerWithDynamicClientReqQuery := testIssuer + "?" + dynamicClientReqQuery.Encode()
+
 	tests := []struct {
 		name string
 
@@ -37,6 +55,9 @@ func TestChooseIDPHandler(t *testing.T) {
 		reqTarget string
 		idps      federationdomainproviders.FederationDomainIdentityProvidersListerI
 
+		// When not nil, a dynamic client which may only use these IDPs is created.
+		dynamicClientAllowedIDPs []string
+
 		wantStatus      int
 		wantContentType string
 		wantBodyString  string
@@ -87,6 +108,35 @@ func TestChooseIDPHandler(t *testing.T) {
 				},
 			}),
 		},
+		{
+			name:      "only the IDPs which the dynamic client is allowed to use are shown",
+			method:    http.MethodGet,
+			reqTarget: "/some/path" + oidc.ChooseIDPEndpointPath + "?" + dynamicClientReqQuery.Encode(),
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(oidctestutil.NewTestUpstreamOIDCIdentityProviderBuilder().WithName("oidc1").Build()).
+				WithLDAP(oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().WithName("ldap1").Build()).
+				WithOIDC(oidctestutil.NewTestUpstreamOIDCIdentityProviderBuilder().WithName("oidc2").Build()).
+				BuildFederationDomainIdentityProvidersListerFinder(),
+			dynamicClientAllowedIDPs: []string{"oidc2", "ldap1", "not-on-this-federation-domain"},
+			wantStatus:               http.StatusOK,
+			wantContentType:          "text/html; charset=utf-8",
+			wantBodyString: testutil.ExpectedChooseIDPPageHTML(chooseidphtml.CSS(), chooseidphtml.JS(), []testutil.ChooseIDPPageExpectedValue{
+				{DisplayName: "ldap1", URL: testIssuerWithDynamicClientReqQuery + "&pinniped_idp_name=ldap1"},
+				{DisplayName: "oidc2", URL: testIssuerWithDynamicClientReqQuery + "&pinniped_idp_name=oidc2"},
+			}),
+		},
+		{
+			name:      "the dynamic client is not allowed to use any of the IDPs",
+			method:    http.MethodGet,
+			reqTarget: "/some/path" + oidc.ChooseIDPEndpointPath + "?" + dynamicClientReqQuery.Encode(),
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(oidctestutil.NewTestUpstreamOIDCIdentityProviderBuilder().WithName("oidc1").Build()).
+				BuildFederationDomainIdentityProvidersListerFinder(),
+			dynamicClientAllowedIDPs: []string{"not-on-this-federation-domain"},
+			wantStatus:               http.StatusForbidden,
+			wantContentType:          "text/plain; charset=utf-8",
+			wantBodyString:           "Forbidden: this client is not allowed to use any of the identity providers of this FederationDomain\n",
+		},
 		{
 			name:      "no valid IDPs are configured on the FederationDomain",
 			method:    http.MethodGet,
@@ -135,7 +185,23 @@ func TestChooseIDPHandler(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
-			handler := NewHandler(testIssuer, test.idps)
+			kubeClient := fake.NewSimpleClientset()
+			supervisorClient := supervisorfake.NewSimpleClientset()
+			if test.dynamicClientAllowedIDPs != nil {
+				oidcClient, secret := testutil.FullyCapableOIDCClientAndStorageSecret(t,
+					testNamespace, dynamicClientID, "some-uid", "https://example.com/callback", nil,
+					[]string{testutil.HashedPassword1AtGoMinCost}, oidcclientvalidator.Validate)
+				oidcClient.Spec.AllowedIdentityProviders = test.dynamicClientAllowedIDPs
+				require.NoError(t, supervisorClient.Tracker().Add(oidcClient))
+				require.NoError(t, kubeClient.Tracker().Add(secret))
+			}
+			clients := clientregistry.NewClientManager(
+				supervisorClient.ConfigV1alpha1().OIDCClients(testNamespace),
+				oidcclientsecretstorage.New(kubeClient.CoreV1().Secrets(testNamespace)),
+				bcrypt.MinCost,
+			)
+
+			handler := NewHandler(testIssuer, test.idps, clients)
 
 			req := httptest.NewRequest(test.method, test.reqTarget, nil)
 			rsp := httptest.NewRecorder()
@@ -1,4 +1,4 @@
-// Copyright 2023 the Pinniped contributors. All Rights Reserved.
+// Copyright 2023-2024 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package chooseidphtml
@@ -6,10 +6,12 @@ package chooseidphtml
 import (
 	_ "embed" // Needed to trigger //go:embed directives below.
 	"html/template"
+	"net/http"
 	"strings"
 
 	"github.com/tdewolff/minify/v2/minify"
 
+	"go.pinniped.dev/internal/federationdomain/branding"
 	"go.pinniped.dev/internal/federationdomain/csp"
 )
 
@@ -54,6 +56,12 @@ func panicOnError(s string, err error) string {
 // See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Security-Policy.
 func ContentSecurityPolicy() string { return cspValue }
 
+// ContentSecurityPolicyForRequest returns the ContentSecurityPolicy() with the additional sources needed by the
+// branding of the request's FederationDomain.
+func ContentSecurityPolicyForRequest(r *http.Request) string {
+	return branding.FromContext(r.Context()).ContentSecurityPolicy(cspValue)
+}
+
 // Template returns the html/template.Template for rendering the login page.
 func Template() *template.Template { return parsedHTMLTemplate }
 
@@ -71,4 +79,5 @@ type IdentityProvider struct {
 // PageData represents the inputs to the template.
 type PageData struct {
 	IdentityProviders []IdentityProvider
+	Branding          *branding.Page
 }
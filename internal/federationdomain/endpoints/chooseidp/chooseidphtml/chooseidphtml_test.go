@@ -1,4 +1,4 @@
-// Copyright 2023 the Pinniped contributors. All Rights Reserved.
+// Copyright 2023-2024 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package chooseidphtml
@@ -6,10 +6,14 @@ package chooseidphtml
 import (
 	"bytes"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"go.pinniped.dev/internal/federationdomain/branding"
+	"go.pinniped.dev/internal/federationdomain/csp"
 	"go.pinniped.dev/internal/testutil"
 )
 
@@ -40,6 +44,7 @@ func TestTemplate(t *testing.T) {
 			{DisplayName: testUpstreamName1, URL: testURL1},
 			{DisplayName: testUpstreamName2, URL: testURL2},
 		},
+		Branding: branding.Default(),
 	}
 
 	expectedHTML := testutil.ExpectedChooseIDPPageHTML(testExpectedCSS, testExpectedJS, []testutil.ChooseIDPPageExpectedValue{
@@ -52,10 +57,50 @@ func TestTemplate(t *testing.T) {
 	require.Equal(t, expectedHTML, buf.String())
 }
 
+func TestTemplateWithBranding(t *testing.T) {
+	b, err := branding.Parse(map[string][]byte{
+		branding.LogoKey:            []byte("\x89PNG\r\n\x1a\n"),
+		branding.BackgroundColorKey: []byte("#112233"),
+		branding.SupportURLKey:      []byte("https://help.example.com"),
+		"messages.fr.json":          []byte(`{"chooseIDPTitle": "Choisir", "chooseIDPHeading": "Choisissez un fournisseur d'identité"}`),
+	})
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Language", "fr-CA, en;q=0.5")
+	page := b.PageFor(r)
+
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		IdentityProviders: []IdentityProvider{{DisplayName: "test-idp-name", URL: "https://pinniped.dev/path"}},
+		Branding:          page,
+	}))
+	require.Contains(t, buf.String(), `<html lang="fr">`)
+	require.Contains(t, buf.String(), "<title>Choisir</title>")
+	require.Contains(t, buf.String(), "<style>"+string(page.CSS)+"</style>")
+	require.Contains(t, buf.String(), `<div class="form-field logo"><img src="data:image/png;base64,iVBORw0KGgo=" alt=""></div>`)
+	require.Contains(t, buf.String(), "<h1>Choisissez un fournisseur d&#39;identité</h1>")
+	require.Contains(t, buf.String(), `<div class="form-field support"><a href="https://help.example.com">Need help?</a></div>`)
+}
+
 func TestContentSecurityPolicy(t *testing.T) {
 	require.Equal(t, testExpectedCSP, ContentSecurityPolicy())
 }
 
+func TestContentSecurityPolicyForRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.Equal(t, testExpectedCSP, ContentSecurityPolicyForRequest(r))
+
+	b, err := branding.Parse(map[string][]byte{branding.CustomCSSKey: []byte("h1{color:red}")})
+	require.NoError(t, err)
+	r = r.WithContext(branding.NewContext(r.Context(), b.PageFor(r)))
+	require.Equal(t, `default-src 'none'; `+
+		`script-src 'sha256-eyuE+qQfuMn4WbDizGOp1wSGReaMYRYmRMXpyEo+8ps='; `+
+		`style-src 'sha256-SgeTG5HEbHNFgjH+EvLrC+VKZRZQ6iAI3oFnW7i/Tm4=' '`+csp.Hash("h1{color:red}")+`'; `+
+		`img-src data:; `+
+		`frame-ancestors 'none'`,
+		ContentSecurityPolicyForRequest(r))
+}
+
 func TestCSS(t *testing.T) {
 	require.Equal(t, testExpectedCSS, CSS())
 }
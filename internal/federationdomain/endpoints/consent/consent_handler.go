@@ -0,0 +1,285 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package consent provides a handler for the page where end users approve the scopes requested by OIDCClients
+// which require consent, and the check which sends browser-based logins to that page.
+package consent
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+
+	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
+	"go.pinniped.dev/internal/federationdomain/branding"
+	"go.pinniped.dev/internal/federationdomain/clientregistry"
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
+	"go.pinniped.dev/internal/federationdomain/endpoints/consent/consenthtml"
+	"go.pinniped.dev/internal/federationdomain/formposthtml"
+	"go.pinniped.dev/internal/federationdomain/oidc"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	idParamName       = "id"
+	decisionParamName = "decision"
+	decisionAllow     = "allow"
+	decisionDeny      = "deny"
+
+	promptParamName    = "prompt"
+	promptParamConsent = "consent"
+)
+
+// RedirectIfRequired redirects the browser to the consent page at consentURL when the client of a finished
+// browser-based login requires consent, and the end user has not yet consented to all the granted scopes or
+// the client asked for the end user's consent again with "prompt=consent". The login is stored until the end user
+// decides. It returns false, without writing a response, when no consent is needed and the caller should finish
+// the login as usual.
+func RedirectIfRequired(
+	r *http.Request,
+	w http.ResponseWriter,
+	consentURL string,
+	store *consentgrants.Store,
+	authorizeRequester fosite.AuthorizeRequester,
+	session *psession.PinnipedSession,
+	state *oidc.UpstreamStateParamData,
+) (bool, error) {
+	client := authorizeRequester.GetClient()
+	if !clientregistry.RequiresConsent(client) {
+		return false, nil
+	}
+
+	grantedScopes := []string(authorizeRequester.GetGrantedScopes())
+	if !slices.Contains(strings.Fields(authorizeRequester.GetRequestForm().Get(promptParamName)), promptParamConsent) {
+		granted, err := store.IsGranted(r.Context(), client.GetID(), clientregistry.UID(client), session.Fosite.Claims.Subject, grantedScopes)
+		if err != nil {
+			return false, err
+		}
+		if granted {
+			return false, nil
+		}
+	}
+
+	id, err := store.SavePending(r.Context(), &consentgrants.Pending{
+		AuthParams:        state.AuthParams,
+		CSRFToken:         state.CSRFToken,
+		GrantedScopes:     grantedScopes,
+		ClientDisplayName: clientregistry.DisplayName(client),
+		Session:           session,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	http.Redirect(w, r,
+		consentURL+"?"+url.Values{idParamName: []string{id}}.Encode(),
+		http.StatusSeeOther, // match fosite and https://tools.ietf.org/id/draft-ietf-oauth-security-topics-18.html#section-4.11
+	)
+	return true, nil
+}
+
+// NewHandler returns a http.Handler that serves the consent page. A GET request shows the client and the scopes
+// of a login which is waiting for consent. A POST request stores the end user's decision and finishes the login.
+// When the end user allows access, their consent is remembered for future logins to the same client. When the
+// end user denies access, any consent which they gave to the client before is revoked, so the client can no
+// longer refresh its tokens.
+func NewHandler(
+	postPath string,
+	oauthHelper fosite.OAuth2Provider,
+	cookieDecoder oidc.Decoder,
+	store *consentgrants.Store,
+) http.Handler {
+	handler := httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		switch r.Method {
+		case http.MethodGet:
+			return showConsentPage(w, r, postPath, cookieDecoder, store)
+		case http.MethodPost:
+			return handleDecision(w, r, oauthHelper, cookieDecoder, store)
+		default:
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET or POST)", r.Method)
+		}
+	})
+
+	return securityheader.WrapWithCustomCSPFunc(handler, contentSecurityPolicyForRequest)
+}
+
+// contentSecurityPolicyForRequest returns the policy of the consent page for GET requests, and the policy of the
+// form_post response mode page for POST requests, since they may respond with the downstream authorization response.
+func contentSecurityPolicyForRequest(r *http.Request) string {
+	if r.Method == http.MethodPost {
+		return formposthtml.ContentSecurityPolicyForRequest(r)
+	}
+	return consenthtml.ContentSecurityPolicyForRequest(r)
+}
+
+func showConsentPage(
+	w http.ResponseWriter,
+	r *http.Request,
+	postPath string,
+	cookieDecoder oidc.Decoder,
+	store *consentgrants.Store,
+) error {
+	id := r.URL.Query().Get(idParamName)
+	pending, err := readPending(r, id, cookieDecoder, store)
+	if err != nil {
+		return err
+	}
+
+	authParams, err := url.ParseQuery(pending.AuthParams)
+	if err != nil {
+		plog.Error("error reading pending consent downstream auth params", err)
+		return httperr.New(http.StatusBadRequest, "error reading pending consent downstream auth params")
+	}
+
+	redirectHost := ""
+	if redirectURL, err := url.Parse(authParams.Get("redirect_uri")); err == nil {
+		redirectHost = redirectURL.Host
+	}
+
+	clientName := pending.ClientDisplayName
+	if clientName == "" {
+		clientName = authParams.Get("client_id")
+	}
+
+	page := branding.FromContext(r.Context())
+	return consenthtml.Template().Execute(w, &consenthtml.PageData{
+		PostPath:     postPath,
+		ID:           id,
+		ClientName:   clientName,
+		RedirectHost: redirectHost,
+		Username:     pending.Session.Custom.Username,
+		Scopes:       scopesForPage(page, pending.GrantedScopes),
+		Branding:     page,
+	})
+}
+
+func handleDecision(
+	w http.ResponseWriter,
+	r *http.Request,
+	oauthHelper fosite.OAuth2Provider,
+	cookieDecoder oidc.Decoder,
+	store *consentgrants.Store,
+) error {
+	if err := r.ParseForm(); err != nil {
+		return httperr.New(http.StatusBadRequest, "error parsing request params")
+	}
+
+	decision := r.PostFormValue(decisionParamName)
+	if decision != decisionAllow && decision != decisionDeny {
+		return httperr.New(http.StatusBadRequest, "decision param is invalid")
+	}
+
+	id := r.PostFormValue(idParamName)
+	pending, err := readPending(r, id, cookieDecoder, store)
+	if err != nil {
+		return err
+	}
+
+	// The end user may only decide once, even when they submit the form twice.
+	if err := store.DeletePending(r.Context(), id); err != nil {
+		return pendingError(err)
+	}
+
+	authParams, err := url.ParseQuery(pending.AuthParams)
+	if err != nil {
+		plog.Error("error reading pending consent downstream auth params", err)
+		return httperr.New(http.StatusBadRequest, "error reading pending consent downstream auth params")
+	}
+
+	// Recreate enough of the original authorize request so we can pass it to NewAuthorizeRequest().
+	reconstitutedAuthRequest := &http.Request{Form: authParams}
+	authorizeRequester, err := oauthHelper.NewAuthorizeRequest(r.Context(), reconstitutedAuthRequest)
+	if err != nil {
+		plog.Error("error using pending consent downstream auth params", err,
+			"fositeErr", oidc.FositeErrorForLog(err))
+		return httperr.New(http.StatusBadRequest, "error using pending consent downstream auth params")
+	}
+	for _, scope := range pending.GrantedScopes {
+		authorizeRequester.GrantScope(scope)
+	}
+
+	client := authorizeRequester.GetClient()
+	subject := pending.Session.Fosite.Claims.Subject
+
+	if decision == decisionDeny {
+		if err := store.Revoke(r.Context(), client.GetID(), subject); err != nil {
+			plog.WarningErr("error revoking consent grant", err, "clientID", client.GetID())
+			return httperr.Wrap(http.StatusInternalServerError, "error revoking consent grant", err)
+		}
+		plog.Info("end user denied consent", "clientID", client.GetID())
+		oidc.WriteAuthorizeError(r, w, oauthHelper, authorizeRequester,
+			fosite.ErrAccessDenied.WithHint("The end user did not consent to this client."), false)
+		return nil
+	}
+
+	if err := store.Grant(r.Context(), client.GetID(), clientregistry.UID(client), subject,
+		pending.Session.Custom.Username, pending.GrantedScopes, time.Now()); err != nil {
+		plog.WarningErr("error storing consent grant", err, "clientID", client.GetID())
+		return httperr.Wrap(http.StatusInternalServerError, "error storing consent grant", err)
+	}
+	plog.Info("end user gave consent", "clientID", client.GetID())
+
+	oidc.PerformAuthcodeRedirect(r, w, oauthHelper, authorizeRequester, pending.Session, false)
+	return nil
+}
+
+// readPending reads the login which is waiting for consent, and checks that it was started by the same browser.
+func readPending(
+	r *http.Request,
+	id string,
+	cookieDecoder oidc.Decoder,
+	store *consentgrants.Store,
+) (*consentgrants.Pending, error) {
+	if id == "" {
+		return nil, httperr.New(http.StatusBadRequest, "id param not found")
+	}
+
+	pending, err := store.GetPending(r.Context(), id)
+	if err != nil {
+		return nil, pendingError(err)
+	}
+
+	if err := oidc.ValidateCSRFCookie(r, cookieDecoder, pending.CSRFToken); err != nil {
+		plog.InfoErr("CSRF error", err)
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+func pendingError(err error) error {
+	if errors.Is(err, consentgrants.ErrPendingNotFound) {
+		return httperr.New(http.StatusBadRequest, "consent request not found or expired, please log in again")
+	}
+	plog.WarningErr("error reading pending consent", err)
+	return httperr.Wrap(http.StatusInternalServerError, "error reading pending consent", err)
+}
+
+// scopesForPage returns the scopes along with the descriptions which are shown to the end user.
+func scopesForPage(page *branding.Page, scopes []string) []consenthtml.Scope {
+	descriptions := map[string]string{
+		oidcapi.ScopeOpenID:          page.Messages.ConsentScopeOpenID,
+		oidcapi.ScopeOfflineAccess:   page.Messages.ConsentScopeOfflineAccess,
+		oidcapi.ScopeRequestAudience: page.Messages.ConsentScopeRequestAudience,
+		oidcapi.ScopeUsername:        page.Messages.ConsentScopeUsername,
+		oidcapi.ScopeGroups:          page.Messages.ConsentScopeGroups,
+	}
+
+	result := make([]consenthtml.Scope, 0, len(scopes))
+	for _, scope := range scopes {
+		description, ok := descriptions[scope]
+		if !ok {
+			description = scope
+		}
+		result = append(result, consenthtml.Scope{Name: scope, Description: description})
+	}
+	return result
+}
@@ -0,0 +1,484 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package consent
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	supervisorfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
+	"go.pinniped.dev/internal/federationdomain/csrftoken"
+	"go.pinniped.dev/internal/federationdomain/endpoints/consent/consenthtml"
+	"go.pinniped.dev/internal/federationdomain/endpoints/jwks"
+	"go.pinniped.dev/internal/federationdomain/formposthtml"
+	"go.pinniped.dev/internal/federationdomain/oidc"
+	"go.pinniped.dev/internal/federationdomain/oidcclientvalidator"
+	"go.pinniped.dev/internal/federationdomain/storage"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil"
+)
+
+const (
+	downstreamIssuer              = "https://my-downstream-issuer.com/path"
+	downstreamRedirectURI         = "http://127.0.0.1/callback"
+	downstreamPinnipedCLIClientID = "pinniped-cli"
+	downstreamDynamicClientID     = "client.oauth.pinniped.dev-test-name"
+	downstreamDynamicClientUID    = "fake-client-uid"
+	downstreamDynamicClientName   = "Some Web App"
+	downstreamSubject             = "https://my-upstream-issuer.com?idpName=some-idp&sub=some-subject"
+	downstreamUsername            = "some-username"
+	downstreamState               = "8b-state"
+	downstreamCSRF                = "test-csrf"
+
+	consentPostPath = "/path/consent"
+	htmlContentType = "text/html; charset=utf-8"
+)
+
+var (
+	happyScopes = []string{"openid", "username", "groups"}
+
+	happyAuthParamsForDynamicClient = url.Values{
+		"response_type":         []string{"code"},
+		"scope":                 []string{strings.Join(happyScopes, " ")},
+		"client_id":             []string{downstreamDynamicClientID},
+		"state":                 []string{downstreamState},
+		"nonce":                 []string{"some-nonce-value"},
+		"code_challenge":        []string{"some-challenge"},
+		"code_challenge_method": []string{"S256"},
+		"redirect_uri":          []string{downstreamRedirectURI},
+	}
+)
+
+type testEnv struct {
+	kubeClient  *fake.Clientset
+	secrets     v1.SecretInterface
+	oauthHelper fosite.OAuth2Provider
+	cookieCodec *securecookie.SecureCookie
+	store       *consentgrants.Store
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	kubeClient := fake.NewSimpleClientset()
+	supervisorClient := supervisorfake.NewSimpleClientset()
+	secrets := kubeClient.CoreV1().Secrets("some-namespace")
+
+	oidcClient, secret := testutil.FullyCapableOIDCClientAndStorageSecret(t,
+		"some-namespace", downstreamDynamicClientID, downstreamDynamicClientUID, downstreamRedirectURI, nil,
+		[]string{testutil.HashedPassword1AtGoMinCost}, oidcclientvalidator.Validate)
+	oidcClient.Spec.RequireConsent = true
+	oidcClient.Spec.DisplayName = downstreamDynamicClientName
+	require.NoError(t, supervisorClient.Tracker().Add(oidcClient))
+	require.NoError(t, kubeClient.Tracker().Add(secret))
+
+	// Configure fosite the same way that the production code would.
+	timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+	// Use lower minimum required bcrypt cost than we would use in production to keep unit the tests fast.
+	kubeOauthStore := storage.NewKubeStorage(secrets, supervisorClient.ConfigV1alpha1().OIDCClients("some-namespace"), timeoutsConfiguration, bcrypt.MinCost)
+	hmacSecretFunc := func() []byte { return []byte("some secret - must have at least 32 bytes") }
+	jwksProviderIsUnused := jwks.NewDynamicJWKSProvider()
+	oauthHelper := oidc.FositeOauth2Helper(kubeOauthStore, downstreamIssuer, hmacSecretFunc, jwksProviderIsUnused, timeoutsConfiguration)
+
+	cookieCodec := securecookie.New([]byte("fake-hash-secret"), []byte("0123456789ABCDEF"))
+	cookieCodec.SetSerializer(securecookie.JSONEncoder{})
+
+	return &testEnv{
+		kubeClient:  kubeClient,
+		secrets:     secrets,
+		oauthHelper: oauthHelper,
+		cookieCodec: cookieCodec,
+		store:       consentgrants.New(downstreamIssuer, secrets, rand.Reader, time.Now),
+	}
+}
+
+func (e *testEnv) csrfCookie(t *testing.T, csrf string) string {
+	t.Helper()
+	encoded, err := e.cookieCodec.Encode(oidc.CSRFCookieEncodingName, csrf)
+	require.NoError(t, err)
+	return oidc.CSRFCookieName + "=" + encoded
+}
+
+func (e *testEnv) savePending(t *testing.T) string {
+	t.Helper()
+	id, err := e.store.SavePending(context.Background(), &consentgrants.Pending{
+		AuthParams:        happyAuthParamsForDynamicClient.Encode(),
+		CSRFToken:         downstreamCSRF,
+		GrantedScopes:     happyScopes,
+		ClientDisplayName: downstreamDynamicClientName,
+		Session:           happySession(),
+	})
+	require.NoError(t, err)
+	return id
+}
+
+func (e *testEnv) isGranted(t *testing.T) bool {
+	t.Helper()
+	granted, err := e.store.IsGranted(context.Background(), downstreamDynamicClientID, downstreamDynamicClientUID, downstreamSubject, happyScopes)
+	require.NoError(t, err)
+	return granted
+}
+
+func happySession() *psession.PinnipedSession {
+	session := psession.NewPinnipedSession()
+	session.Fosite.Claims.Subject = downstreamSubject
+	session.Fosite.Claims.Extra = map[string]any{
+		"username": downstreamUsername,
+		"groups":   []any{"group1", "group2"},
+	}
+	session.Custom.Username = downstreamUsername
+	session.Custom.ProviderName = "some-idp"
+	session.Custom.ProviderType = psession.ProviderTypeOIDC
+	return session
+}
+
+func TestConsentEndpoint(t *testing.T) {
+	tests := []struct {
+		name string
+
+		method     string
+		id         string // defaults to the id of the pending consent
+		csrfCookie string // defaults to a cookie with the CSRF value of the pending consent
+		decision   string
+		preGranted bool
+
+		wantStatus           int
+		wantContentType      string
+		wantCSP              string
+		wantBody             string
+		wantBodyContains     []string
+		wantLocationRegexp   string
+		wantGranted          bool
+		wantPendingRemaining int
+		wantAuthcodes        int
+	}{
+		{
+			name:            "GET shows the consent page",
+			method:          http.MethodGet,
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantCSP:         consenthtml.ContentSecurityPolicy(),
+			wantBodyContains: []string{
+				"<h1>" + downstreamDynamicClientName + " is requesting access to your account</h1>",
+				`<p id="redirect-host">You will be returned to 127.0.0.1.</p>`,
+				`<p id="username">You are logged in as ` + downstreamUsername + `.</p>`,
+				`<li title="openid">`,
+				`<li title="username">`,
+				`<li title="groups">`,
+				`<form action="` + consentPostPath + `" method="post">`,
+			},
+			wantPendingRemaining: 1,
+		},
+		{
+			name:                 "GET without id param",
+			method:               http.MethodGet,
+			id:                   "-",
+			wantStatus:           http.StatusBadRequest,
+			wantBody:             "Bad Request: id param not found\n",
+			wantPendingRemaining: 1,
+		},
+		{
+			name:                 "GET with unknown id",
+			method:               http.MethodGet,
+			id:                   "some-unknown-id",
+			wantStatus:           http.StatusBadRequest,
+			wantBody:             "Bad Request: consent request not found or expired, please log in again\n",
+			wantPendingRemaining: 1,
+		},
+		{
+			name:                 "GET without CSRF cookie",
+			method:               http.MethodGet,
+			csrfCookie:           "-",
+			wantStatus:           http.StatusForbidden,
+			wantBody:             "Forbidden: CSRF cookie is missing\n",
+			wantPendingRemaining: 1,
+		},
+		{
+			name:                 "GET with CSRF cookie of another browser",
+			method:               http.MethodGet,
+			csrfCookie:           "other-csrf",
+			wantStatus:           http.StatusForbidden,
+			wantBody:             "Forbidden: CSRF value does not match\n",
+			wantPendingRemaining: 1,
+		},
+		{
+			name:                 "PUT is not allowed",
+			method:               http.MethodPut,
+			wantStatus:           http.StatusMethodNotAllowed,
+			wantBody:             "Method Not Allowed: PUT (try GET or POST)\n",
+			wantPendingRemaining: 1,
+		},
+		{
+			name:               "POST allow stores the grant and returns 303 to downstream client callback with its state and code",
+			method:             http.MethodPost,
+			decision:           decisionAllow,
+			wantStatus:         http.StatusSeeOther,
+			wantCSP:            formposthtml.ContentSecurityPolicy(),
+			wantLocationRegexp: "^" + regexp.QuoteMeta(downstreamRedirectURI) + `\?code=pin_ac_[^&]+&scope=openid\+username\+groups&state=` + downstreamState + "$",
+			wantGranted:        true,
+			wantAuthcodes:      1,
+		},
+		{
+			name:               "POST deny revokes the grant and returns 303 to downstream client callback with an error",
+			method:             http.MethodPost,
+			decision:           decisionDeny,
+			preGranted:         true,
+			wantStatus:         http.StatusSeeOther,
+			wantCSP:            formposthtml.ContentSecurityPolicy(),
+			wantLocationRegexp: "^" + regexp.QuoteMeta(downstreamRedirectURI) + `\?error=access_denied&error_description=.+&state=` + downstreamState + "$",
+			wantGranted:        false,
+		},
+		{
+			name:                 "POST with invalid decision",
+			method:               http.MethodPost,
+			decision:             "maybe",
+			wantStatus:           http.StatusBadRequest,
+			wantBody:             "Bad Request: decision param is invalid\n",
+			wantPendingRemaining: 1,
+		},
+		{
+			name:                 "POST with CSRF cookie of another browser",
+			method:               http.MethodPost,
+			decision:             decisionAllow,
+			csrfCookie:           "other-csrf",
+			wantStatus:           http.StatusForbidden,
+			wantBody:             "Forbidden: CSRF value does not match\n",
+			wantPendingRemaining: 1,
+		},
+	}
+
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			env := newTestEnv(t)
+			id := env.savePending(t)
+			if tt.preGranted {
+				require.NoError(t, env.store.Grant(context.Background(), downstreamDynamicClientID, downstreamDynamicClientUID,
+					downstreamSubject, downstreamUsername, happyScopes, time.Now()))
+			}
+
+			switch tt.id {
+			case "":
+			case "-":
+				id = ""
+			default:
+				id = tt.id
+			}
+
+			var req *http.Request
+			params := url.Values{}
+			if id != "" {
+				params.Set(idParamName, id)
+			}
+			if tt.method == http.MethodPost {
+				params.Set(decisionParamName, tt.decision)
+				req = httptest.NewRequest(tt.method, consentPostPath, strings.NewReader(params.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(tt.method, consentPostPath+"?"+params.Encode(), nil)
+			}
+
+			switch tt.csrfCookie {
+			case "":
+				req.Header.Set("Cookie", env.csrfCookie(t, downstreamCSRF))
+			case "-":
+			default:
+				req.Header.Set("Cookie", env.csrfCookie(t, tt.csrfCookie))
+			}
+
+			rsp := httptest.NewRecorder()
+			NewHandler(consentPostPath, env.oauthHelper, env.cookieCodec, env.store).ServeHTTP(rsp, req)
+			t.Logf("response: %#v", rsp)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			require.Equal(t, tt.wantStatus, rsp.Code)
+			if tt.wantContentType != "" {
+				testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), tt.wantContentType)
+			}
+			if tt.wantCSP != "" {
+				require.Equal(t, tt.wantCSP, rsp.Header().Get("Content-Security-Policy"))
+			}
+			if tt.wantBody != "" {
+				require.Equal(t, tt.wantBody, rsp.Body.String())
+			}
+			for _, want := range tt.wantBodyContains {
+				require.Contains(t, rsp.Body.String(), want)
+			}
+			if tt.wantLocationRegexp != "" {
+				require.Regexp(t, tt.wantLocationRegexp, rsp.Header().Get("Location"))
+			} else {
+				require.Empty(t, rsp.Header().Get("Location"))
+			}
+
+			require.Equal(t, tt.wantGranted, env.isGranted(t))
+			testutil.RequireNumberOfSecretsMatchingLabelSelector(t, env.secrets,
+				labels.Set{crud.SecretLabelKey: consentgrants.PendingTypeLabelValue}, tt.wantPendingRemaining)
+			testutil.RequireNumberOfSecretsMatchingLabelSelector(t, env.secrets,
+				labels.Set{crud.SecretLabelKey: "authcode"}, tt.wantAuthcodes)
+		})
+	}
+}
+
+func TestConsentEndpointWhenDecisionIsSubmittedTwice(t *testing.T) {
+	env := newTestEnv(t)
+	id := env.savePending(t)
+	subject := NewHandler(consentPostPath, env.oauthHelper, env.cookieCodec, env.store)
+
+	post := func(decision string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, consentPostPath,
+			strings.NewReader(url.Values{idParamName: {id}, decisionParamName: {decision}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Cookie", env.csrfCookie(t, downstreamCSRF))
+		rsp := httptest.NewRecorder()
+		subject.ServeHTTP(rsp, req)
+		return rsp
+	}
+
+	require.Equal(t, http.StatusSeeOther, post(decisionAllow).Code)
+	require.True(t, env.isGranted(t))
+
+	// The second decision must not change the first one.
+	rsp := post(decisionDeny)
+	require.Equal(t, http.StatusBadRequest, rsp.Code)
+	require.Equal(t, "Bad Request: consent request not found or expired, please log in again\n", rsp.Body.String())
+	require.True(t, env.isGranted(t))
+}
+
+func TestConsentPageWithoutClientDisplayName(t *testing.T) {
+	env := newTestEnv(t)
+	// A pending consent which was stored without the client's display name shows the client ID instead.
+	id, err := env.store.SavePending(context.Background(), &consentgrants.Pending{
+		AuthParams:    happyAuthParamsForDynamicClient.Encode(),
+		CSRFToken:     downstreamCSRF,
+		GrantedScopes: happyScopes,
+		Session:       happySession(),
+	})
+	require.NoError(t, err)
+	subject := NewHandler(consentPostPath, env.oauthHelper, env.cookieCodec, env.store)
+
+	req := httptest.NewRequest(http.MethodGet, consentPostPath+"?"+url.Values{idParamName: {id}}.Encode(), nil)
+	req.Header.Set("Cookie", env.csrfCookie(t, downstreamCSRF))
+	rsp := httptest.NewRecorder()
+	subject.ServeHTTP(rsp, req)
+
+	require.Equal(t, http.StatusOK, rsp.Code)
+	require.Contains(t, rsp.Body.String(), "<h1>"+downstreamDynamicClientID+" is requesting access to your account</h1>")
+}
+
+func TestRedirectIfRequired(t *testing.T) {
+	const consentURL = downstreamIssuer + oidc.ConsentEndpointPath
+
+	tests := []struct {
+		name          string
+		clientID      string
+		prompt        string
+		grantedScopes []string
+
+		wantRedirect bool
+	}{
+		{
+			name:     "client which does not require consent",
+			clientID: downstreamPinnipedCLIClientID,
+		},
+		{
+			name:         "client which requires consent when the end user has not consented",
+			clientID:     downstreamDynamicClientID,
+			wantRedirect: true,
+		},
+		{
+			name:          "client which requires consent when the end user has consented to all the scopes",
+			clientID:      downstreamDynamicClientID,
+			grantedScopes: happyScopes,
+		},
+		{
+			name:          "client which requires consent when the end user has consented to fewer scopes",
+			clientID:      downstreamDynamicClientID,
+			grantedScopes: []string{"openid", "username"},
+			wantRedirect:  true,
+		},
+		{
+			name:          "client which requires consent when the end user has consented and the client asks for consent again",
+			clientID:      downstreamDynamicClientID,
+			prompt:        "login consent",
+			grantedScopes: happyScopes,
+			wantRedirect:  true,
+		},
+	}
+
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			env := newTestEnv(t)
+			if tt.grantedScopes != nil {
+				require.NoError(t, env.store.Grant(context.Background(), downstreamDynamicClientID, downstreamDynamicClientUID,
+					downstreamSubject, downstreamUsername, tt.grantedScopes, time.Now()))
+			}
+
+			authParams := url.Values{}
+			for k, v := range happyAuthParamsForDynamicClient {
+				authParams[k] = v
+			}
+			authParams.Set("client_id", tt.clientID)
+			if tt.prompt != "" {
+				authParams.Set("prompt", tt.prompt)
+			}
+			authorizeRequester, err := env.oauthHelper.NewAuthorizeRequest(context.Background(), &http.Request{Form: authParams})
+			require.NoError(t, err)
+			for _, scope := range happyScopes {
+				authorizeRequester.GrantScope(scope)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/path/callback", nil)
+			rsp := httptest.NewRecorder()
+			state := &oidc.UpstreamStateParamData{AuthParams: authParams.Encode(), CSRFToken: csrftoken.CSRFToken(downstreamCSRF)}
+
+			redirected, err := RedirectIfRequired(req, rsp, consentURL, env.store, authorizeRequester, happySession(), state)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantRedirect, redirected)
+
+			if !tt.wantRedirect {
+				require.Empty(t, rsp.Header().Get("Location"))
+				testutil.RequireNumberOfSecretsMatchingLabelSelector(t, env.secrets,
+					labels.Set{crud.SecretLabelKey: consentgrants.PendingTypeLabelValue}, 0)
+				return
+			}
+
+			require.Equal(t, http.StatusSeeOther, rsp.Code)
+			location := rsp.Header().Get("Location")
+			require.Regexp(t, "^"+regexp.QuoteMeta(consentURL+"?id=")+"[A-Za-z0-9_-]{43}$", location)
+			parsedLocation, err := url.Parse(location)
+			require.NoError(t, err)
+
+			pending, err := env.store.GetPending(context.Background(), parsedLocation.Query().Get(idParamName))
+			require.NoError(t, err)
+			require.Equal(t, authParams.Encode(), pending.AuthParams)
+			require.Equal(t, csrftoken.CSRFToken(downstreamCSRF), pending.CSRFToken)
+			require.Equal(t, happyScopes, pending.GrantedScopes)
+			require.Equal(t, downstreamDynamicClientName, pending.ClientDisplayName)
+			require.Equal(t, downstreamSubject, pending.Session.Fosite.Claims.Subject)
+			require.Equal(t, downstreamUsername, pending.Session.Custom.Username)
+		})
+	}
+}
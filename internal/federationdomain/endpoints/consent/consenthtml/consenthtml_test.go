@@ -0,0 +1,111 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package consenthtml
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/internal/federationdomain/branding"
+	"go.pinniped.dev/internal/federationdomain/csp"
+)
+
+var (
+	testExpectedCSS = `html{height:100%}body{font-family:metropolis-light,Helvetica,sans-serif;display:flex;flex-flow:column wrap;justify-content:flex-start;align-items:center;background:linear-gradient(to top,#f8f8f8,white);min-height:100%}h1{font-size:20px;margin:0}.box{display:flex;flex-direction:column;flex-wrap:nowrap;border-radius:4px;border-color:#ddd;border-width:1px;border-style:solid;width:400px;padding:30px 30px 0;margin:60px 20px 0;background:#fff;font-size:14px}ul{margin:0;padding-left:1.5em}li{margin-bottom:.5em}button{color:inherit;font:inherit;border:0;margin:0;outline:0;padding:0}.form-field{display:flex;margin-bottom:30px}.form-field p{margin:0}.buttons{gap:10px}.form-field button{width:100%;padding:1em;background-color:#218fcf;color:#eee;font-weight:700;cursor:pointer;transition:all .3s}.form-field button:focus,.form-field button:hover{background-color:#1abfd3}.form-field button.deny{background-color:#a6a6a6}.form-field button.deny:focus,.form-field button.deny:hover{background-color:#8c8c8c}.form-field button:active{transform:scale(.99)}`
+
+	// It's okay if this changes in the future, but this gives us a chance to eyeball the formatting.
+	// Our browser-based integration tests should find any incompatibilities.
+	testExpectedCSP = `default-src 'none'; ` +
+		`style-src 'sha256-FwFA+LB0h/TYP1epetewr/4UO5VSomBxjWGQuSOKam0='; ` +
+		`img-src data:; ` +
+		`frame-ancestors 'none'`
+)
+
+func TestTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		PostPath:     "/test-path/consent",
+		ID:           "test-id",
+		ClientName:   "client.oauth.pinniped.dev-test",
+		RedirectHost: "app.example.com",
+		Username:     "test-username",
+		Scopes: []Scope{
+			{Name: "openid", Description: "test-openid-description"},
+			{Name: "groups", Description: "test-groups-description"},
+		},
+		Branding: branding.Default(),
+	}))
+
+	for _, want := range []string{
+		`<html lang="en">`,
+		"<title>Allow Access</title>",
+		"<style>" + testExpectedCSS + "</style>",
+		"<h1>client.oauth.pinniped.dev-test is requesting access to your account</h1>",
+		`<p id="redirect-host">You will be returned to app.example.com.</p>`,
+		`<p id="username">You are logged in as test-username.</p>`,
+		"<p>If you allow access, client.oauth.pinniped.dev-test will be able to:</p>",
+		`<li title="openid">test-openid-description</li>`,
+		`<li title="groups">test-groups-description</li>`,
+		`<form action="/test-path/consent" method="post">`,
+		`<input type="hidden" name="id" id="id" value="test-id">`,
+		`<button type="submit" name="decision" value="deny" class="deny">Deny</button>`,
+		`<button type="submit" name="decision" value="allow" class="allow">Allow</button>`,
+	} {
+		require.Contains(t, buf.String(), want)
+	}
+	require.NotContains(t, buf.String(), `class="form-field logo"`)
+	require.NotContains(t, buf.String(), `class="form-field support"`)
+}
+
+func TestTemplateWithBranding(t *testing.T) {
+	b, err := branding.Parse(map[string][]byte{
+		branding.LogoKey:       []byte("\x89PNG\r\n\x1a\n"),
+		branding.SupportURLKey: []byte("https://help.example.com"),
+		"messages.fr.json":     []byte(`{"consentHeading": "{client} demande l'accès à votre compte", "consentAllowButton": "Autoriser"}`),
+	})
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Language", "fr-CA, en;q=0.5")
+
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{ClientName: "test-client", Branding: b.PageFor(r)}))
+	require.Contains(t, buf.String(), `<html lang="fr">`)
+	require.Contains(t, buf.String(), `<div class="form-field logo"><img src="data:image/png;base64,iVBORw0KGgo=" alt=""></div>`)
+	require.Contains(t, buf.String(), "<h1>test-client demande l&#39;accès à votre compte</h1>")
+	require.Contains(t, buf.String(), `value="allow" class="allow">Autoriser</button>`)
+	require.Contains(t, buf.String(), `value="deny" class="deny">Deny</button>`)
+	require.Contains(t, buf.String(), `<div class="form-field support"><a href="https://help.example.com">Need help?</a></div>`)
+}
+
+func TestContentSecurityPolicy(t *testing.T) {
+	require.Equal(t, testExpectedCSP, ContentSecurityPolicy())
+}
+
+func TestContentSecurityPolicyForRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.Equal(t, testExpectedCSP, ContentSecurityPolicyForRequest(r))
+
+	b, err := branding.Parse(map[string][]byte{branding.CustomCSSKey: []byte("h1{color:red}")})
+	require.NoError(t, err)
+	r = r.WithContext(branding.NewContext(r.Context(), b.PageFor(r)))
+	require.Equal(t, `default-src 'none'; `+
+		`style-src 'sha256-FwFA+LB0h/TYP1epetewr/4UO5VSomBxjWGQuSOKam0=' '`+csp.Hash("h1{color:red}")+`'; `+
+		`img-src data:; `+
+		`frame-ancestors 'none'`,
+		ContentSecurityPolicyForRequest(r))
+}
+
+func TestCSS(t *testing.T) {
+	require.Equal(t, testExpectedCSS, CSS())
+}
+
+func TestHelpers(t *testing.T) {
+	require.Equal(t, "test", panicOnError("test", nil))
+	require.PanicsWithError(t, "some error", func() { panicOnError("", fmt.Errorf("some error")) })
+}
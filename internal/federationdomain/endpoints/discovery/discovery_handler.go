@@ -1,4 +1,4 @@
-// Copyright 2020-2023 the Pinniped contributors. All Rights Reserved.
+// Copyright 2020-2024 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 // Package discovery provides a handler for the OIDC discovery endpoint.
@@ -11,6 +11,8 @@ import (
 
 	"go.pinniped.dev/generated/latest/apis/supervisor/idpdiscovery/v1alpha1"
 	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
+	"go.pinniped.dev/internal/federationdomain/dpop"
+	"go.pinniped.dev/internal/federationdomain/jwsalgorithms"
 	"go.pinniped.dev/internal/federationdomain/oidc"
 )
 
@@ -42,6 +44,21 @@ type Metadata struct {
 	// https://datatracker.ietf.org/doc/html/rfc8414#section-2 says, “If omitted, the authorization server does not support PKCE.”
 	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
 
+	// https://datatracker.ietf.org/doc/html/rfc8414#section-2 says that this is only present when the
+	// authorization server supports dynamic client registration.
+	RegistrationEndpoint string `json:"registration_endpoint,omitempty"`
+
+	// See https://datatracker.ietf.org/doc/html/rfc9126#section-5.
+	PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint"`
+	RequirePushedAuthorizationRequests bool   `json:"require_pushed_authorization_requests"`
+
+	// See https://datatracker.ietf.org/doc/html/rfc9101#section-10.5.
+	RequestParameterSupported              bool     `json:"request_parameter_supported"`
+	RequestObjectSigningAlgValuesSupported []string `json:"request_object_signing_alg_values_supported"`
+
+	// See https://datatracker.ietf.org/doc/html/rfc9449#section-5.1.
+	DPoPSigningAlgValuesSupported []string `json:"dpop_signing_alg_values_supported"`
+
 	// ^^^ Optional ^^^
 
 	// vvv Custom vvv
@@ -52,7 +69,8 @@ type Metadata struct {
 }
 
 // NewHandler returns an http.Handler that serves an OIDC discovery endpoint.
-func NewHandler(issuerURL string) http.Handler {
+// registrationEndpoint should be empty when dynamic client registration is not enabled.
+func NewHandler(issuerURL string, registrationEndpoint string) http.Handler {
 	oidcConfig := Metadata{
 		Issuer:                issuerURL,
 		AuthorizationEndpoint: issuerURL + oidc.AuthorizationEndpointPath,
@@ -71,6 +89,13 @@ func NewHandler(issuerURL string) http.Handler {
 		CodeChallengeMethodsSupported:     []string{"S256"},
 		ScopesSupported:                   []string{oidcapi.ScopeOpenID, oidcapi.ScopeOfflineAccess, oidcapi.ScopeRequestAudience, oidcapi.ScopeUsername, oidcapi.ScopeGroups},
 		ClaimsSupported:                   []string{oidcapi.IDTokenClaimUsername, oidcapi.IDTokenClaimGroups, oidcapi.IDTokenClaimAdditionalClaims},
+		RegistrationEndpoint:              registrationEndpoint,
+		// Only some OIDCClients may be configured to require pushed authorization requests.
+		PushedAuthorizationRequestEndpoint:     issuerURL + oidc.PushedAuthorizationRequestEndpointPath,
+		RequirePushedAuthorizationRequests:     false,
+		RequestParameterSupported:              true,
+		RequestObjectSigningAlgValuesSupported: jwsalgorithms.Names(jwsalgorithms.Asymmetric),
+		DPoPSigningAlgValuesSupported:          jwsalgorithms.Names(dpop.SupportedSigningAlgorithms),
 	}
 
 	var b bytes.Buffer
@@ -18,9 +18,10 @@ func TestDiscovery(t *testing.T) {
 	tests := []struct {
 		name string
 
-		issuer string
-		method string
-		path   string
+		issuer               string
+		registrationEndpoint string
+		method               string
+		path                 string
 
 		wantStatus      int
 		wantContentType string
@@ -48,6 +49,45 @@ func TestDiscovery(t *testing.T) {
 				"scopes_supported": ["openid", "offline_access", "pinniped:request-audience", "username", "groups"],
 				"code_challenge_methods_supported": ["S256"],
 				"claims_supported": ["username", "groups", "additionalClaims"],
+				"pushed_authorization_request_endpoint": "https://some-issuer.com/some/path/oauth2/par",
+				"require_pushed_authorization_requests": false,
+				"request_parameter_supported": true,
+				"request_object_signing_alg_values_supported": ["RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"],
+				"dpop_signing_alg_values_supported": ["RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"],
+				"discovery.supervisor.pinniped.dev/v1alpha1": {
+					"pinniped_identity_providers_endpoint": "https://some-issuer.com/some/path/v1alpha1/pinniped_identity_providers"
+				}
+			}
+			`),
+		},
+		{
+			name:                 "with dynamic client registration",
+			issuer:               "https://some-issuer.com/some/path",
+			registrationEndpoint: "https://some-issuer.com/some/path/oauth2/register",
+			method:               http.MethodGet,
+			path:                 "/some/path" + oidc.WellKnownEndpointPath,
+			wantStatus:           http.StatusOK,
+			wantContentType:      "application/json",
+			wantBodyJSON: here.Doc(`
+			{
+				"issuer": "https://some-issuer.com/some/path",
+				"authorization_endpoint": "https://some-issuer.com/some/path/oauth2/authorize",
+				"token_endpoint": "https://some-issuer.com/some/path/oauth2/token",
+				"jwks_uri": "https://some-issuer.com/some/path/jwks.json",
+				"registration_endpoint": "https://some-issuer.com/some/path/oauth2/register",
+				"response_types_supported": ["code"],
+				"response_modes_supported": ["query", "form_post"],
+				"subject_types_supported": ["public"],
+				"id_token_signing_alg_values_supported": ["ES256"],
+				"token_endpoint_auth_methods_supported": ["client_secret_basic"],
+				"scopes_supported": ["openid", "offline_access", "pinniped:request-audience", "username", "groups"],
+				"code_challenge_methods_supported": ["S256"],
+				"claims_supported": ["username", "groups", "additionalClaims"],
+				"pushed_authorization_request_endpoint": "https://some-issuer.com/some/path/oauth2/par",
+				"require_pushed_authorization_requests": false,
+				"request_parameter_supported": true,
+				"request_object_signing_alg_values_supported": ["RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"],
+				"dpop_signing_alg_values_supported": ["RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"],
 				"discovery.supervisor.pinniped.dev/v1alpha1": {
 					"pinniped_identity_providers_endpoint": "https://some-issuer.com/some/path/v1alpha1/pinniped_identity_providers"
 				}
@@ -66,7 +106,7 @@ func TestDiscovery(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			handler := NewHandler(test.issuer)
+			handler := NewHandler(test.issuer, test.registrationEndpoint)
 			req := httptest.NewRequest(test.method, test.path, nil)
 			rsp := httptest.NewRecorder()
 			handler.ServeHTTP(rsp, req)
@@ -41,8 +41,11 @@ func responseAsJSON(upstreamIDPs federationdomainproviders.FederationDomainIdent
 		PinnipedSupportedIDPTypes: []v1alpha1.PinnipedSupportedIDPType{
 			{Type: v1alpha1.IDPTypeActiveDirectory},
 			{Type: v1alpha1.IDPTypeGitHub},
+			{Type: v1alpha1.IDPTypeGitLab},
 			{Type: v1alpha1.IDPTypeLDAP},
+			{Type: v1alpha1.IDPTypeOAuth2},
 			{Type: v1alpha1.IDPTypeOIDC},
+			{Type: v1alpha1.IDPTypeSAML},
 		},
 	}
 
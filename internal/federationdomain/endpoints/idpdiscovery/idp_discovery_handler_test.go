@@ -62,8 +62,11 @@ func TestIDPDiscovery(t *testing.T) {
 				"pinniped_supported_identity_provider_types": [
 					{"type": "activedirectory"},
 					{"type": "github"},
+					{"type": "gitlab"},
 					{"type": "ldap"},
-					{"type": "oidc"}
+					{"type": "oauth2"},
+					{"type": "oidc"},
+					{"type": "saml"}
 				]
 			}`),
 			wantSecondResponseBodyJSON: here.Doc(`{
@@ -79,8 +82,11 @@ func TestIDPDiscovery(t *testing.T) {
 				"pinniped_supported_identity_provider_types": [
 					{"type": "activedirectory"},
 					{"type": "github"},
+					{"type": "gitlab"},
 					{"type": "ldap"},
-					{"type": "oidc"}
+					{"type": "oauth2"},
+					{"type": "oidc"},
+					{"type": "saml"}
 				]
 			}`),
 		},
@@ -97,8 +103,11 @@ func TestIDPDiscovery(t *testing.T) {
 				"pinniped_supported_identity_provider_types": [
 					{"type": "activedirectory"},
 					{"type": "github"},
+					{"type": "gitlab"},
 					{"type": "ldap"},
-					{"type": "oidc"}
+					{"type": "oauth2"},
+					{"type": "oidc"},
+					{"type": "saml"}
 				]
 			}`),
 			wantSecondResponseBodyJSON: here.Doc(`{
@@ -113,8 +122,11 @@ func TestIDPDiscovery(t *testing.T) {
 				"pinniped_supported_identity_provider_types": [
 					{"type": "activedirectory"},
 					{"type": "github"},
+					{"type": "gitlab"},
 					{"type": "ldap"},
-					{"type": "oidc"}
+					{"type": "oauth2"},
+					{"type": "oidc"},
+					{"type": "saml"}
 				]
 			}`),
 		},
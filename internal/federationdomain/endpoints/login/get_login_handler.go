@@ -5,20 +5,35 @@ package login
 
 import (
 	"net/http"
+	"net/url"
 
+	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
+	"go.pinniped.dev/internal/federationdomain/branding"
 	"go.pinniped.dev/internal/federationdomain/endpoints/login/loginhtml"
 	"go.pinniped.dev/internal/federationdomain/endpoints/loginurl"
 	"go.pinniped.dev/internal/federationdomain/oidc"
 )
 
-const (
-	internalErrorMessage                    = "An internal error occurred. Please contact your administrator for help."
-	incorrectUsernameOrPasswordErrorMessage = "Incorrect username or password."
-)
-
-func NewGetHandler(loginPath string) HandlerFunc {
+// NewGetHandler returns a HandlerFunc which renders the login page. After the username and password were accepted,
+// the POST handler may redirect back to here with the totp_state param, and then the page which asks for the
+// one-time password is rendered instead. When chooseIDPURL is not empty, the login page links to the IDP chooser page,
+// so the end user can log in using another identity provider.
+func NewGetHandler(loginPath string, totpEnrollURL string, chooseIDPURL string) HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request, encodedState string, decodedState *oidc.UpstreamStateParamData) error {
-		alertMessage, hasAlert := getAlert(r)
+		page := branding.FromContext(r.Context())
+		alertMessage, hasAlert := getAlert(r, totpEnrollURL, page)
+
+		if encodedTOTPState := r.URL.Query().Get(loginurl.TOTPStateParamName); encodedTOTPState != "" {
+			return loginhtml.TOTPTemplate().Execute(w, &loginhtml.TOTPPageData{
+				PostPath:      loginPath,
+				State:         encodedState,
+				TOTPState:     encodedTOTPState,
+				IDPName:       decodedState.UpstreamName,
+				HasAlertError: hasAlert,
+				AlertMessage:  alertMessage,
+				Branding:      page,
+			})
+		}
 
 		pageInputs := &loginhtml.PageData{
 			PostPath:      loginPath,
@@ -26,17 +41,55 @@ func NewGetHandler(loginPath string) HandlerFunc {
 			IDPName:       decodedState.UpstreamName,
 			HasAlertError: hasAlert,
 			AlertMessage:  alertMessage,
+			Branding:      page,
+			ChooseIDPURL:  chooseIDPLink(chooseIDPURL, decodedState),
 		}
 		return loginhtml.Template().Execute(w, pageInputs)
 	}
 }
 
-func getAlert(r *http.Request) (string, bool) {
+// chooseIDPLink returns the URL of the IDP chooser page for the original authorization request, without the
+// identity provider which the request asked for, or an empty string when there is no chooser page.
+func chooseIDPLink(chooseIDPURL string, decodedState *oidc.UpstreamStateParamData) string {
+	if chooseIDPURL == "" {
+		return ""
+	}
+	authParams, err := url.ParseQuery(decodedState.AuthParams)
+	if err != nil {
+		// The state was signed by this server, so this should not happen. Simply do not show the link.
+		return ""
+	}
+	authParams.Del(oidcapi.AuthorizeUpstreamIDPNameParamName)
+	authParams.Del(oidcapi.AuthorizeUpstreamIDPTypeParamName)
+	return chooseIDPURL + "?" + authParams.Encode()
+}
+
+// getAlert returns the alert message for the error param of the request, in the language of the page.
+func getAlert(r *http.Request, totpEnrollURL string, page *branding.Page) (string, bool) {
 	errorParamValue := r.URL.Query().Get(loginurl.ErrParamName)
 
-	message := internalErrorMessage
-	if errorParamValue == string(loginurl.ShowBadUserPassErr) {
-		message = incorrectUsernameOrPasswordErrorMessage
+	var message string
+	switch loginurl.ErrorParamValue(errorParamValue) {
+	case loginurl.ShowBadUserPassErr:
+		message = page.Messages.IncorrectUsernameOrPassword
+	case loginurl.ShowPasswordExpiredErr:
+		message = page.Messages.PasswordExpired
+	case loginurl.ShowPasswordMustChangeErr:
+		message = page.Messages.PasswordMustChange
+	case loginurl.ShowAccountLockedErr:
+		message = page.Messages.AccountLocked
+	case loginurl.ShowTOTPEnrollmentRequiredErr:
+		message = page.TOTPEnrollmentRequired(totpEnrollURL)
+	case loginurl.ShowTOTPLoginExpiredErr:
+		message = page.Messages.TOTPLoginExpired
+	case loginurl.ShowIncorrectTOTPCodeErr:
+		message = page.Messages.IncorrectTOTPCode
+	case loginurl.ShowTooManyLoginAttemptsErr:
+		message = page.Messages.TooManyLoginAttempts
+	case loginurl.ShowNoError, loginurl.ShowInternalError: // this is just here to avoid a lint error about not handling all cases
+		fallthrough
+	default:
+		message = page.Messages.InternalError
 	}
 
 	return message, errorParamValue != ""
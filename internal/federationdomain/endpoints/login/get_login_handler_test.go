@@ -1,15 +1,17 @@
-// Copyright 2022-2023 the Pinniped contributors. All Rights Reserved.
+// Copyright 2022-2024 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package login
 
 import (
+	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"go.pinniped.dev/internal/federationdomain/branding"
 	"go.pinniped.dev/internal/federationdomain/endpoints/login/loginhtml"
 	"go.pinniped.dev/internal/federationdomain/idplister"
 	"go.pinniped.dev/internal/federationdomain/oidc"
@@ -22,13 +24,44 @@ func TestGetLogin(t *testing.T) {
 		testUpstreamName = "some-ldap-idp"
 		testUpstreamType = "ldap"
 		testEncodedState = "fake-encoded-state-value"
+		testTOTPState    = "fake-encoded-totp-state-value"
+		testEnrollURL    = "https://my-issuer.com/totp/enroll"
+		testChooseIDPURL = "https://my-issuer.com/choose_identity_provider"
 	)
 
+	expectedTOTPPageHTML := func(alertMessage string) string {
+		var buf bytes.Buffer
+		require.NoError(t, loginhtml.TOTPTemplate().Execute(&buf, &loginhtml.TOTPPageData{
+			PostPath:      testPath,
+			State:         testEncodedState,
+			TOTPState:     testTOTPState,
+			IDPName:       testUpstreamName,
+			HasAlertError: alertMessage != "",
+			AlertMessage:  alertMessage,
+			Branding:      branding.Default(),
+		}))
+		return buf.String()
+	}
+
+	expectedLoginPageHTMLWithChooseIDPLink := func(chooseIDPURL string) string {
+		var buf bytes.Buffer
+		require.NoError(t, loginhtml.Template().Execute(&buf, &loginhtml.PageData{
+			PostPath:     testPath,
+			State:        testEncodedState,
+			IDPName:      testUpstreamName,
+			Branding:     branding.Default(),
+			ChooseIDPURL: chooseIDPURL,
+		}))
+		return buf.String()
+	}
+
 	tests := []struct {
 		name            string
 		decodedState    *oidc.UpstreamStateParamData
 		encodedState    string
 		errParam        string
+		totpState       string
+		chooseIDPURL    string
 		idps            idplister.UpstreamIdentityProvidersLister
 		wantStatus      int
 		wantContentType string
@@ -73,6 +106,48 @@ func TestGetLogin(t *testing.T) {
 				"An internal error occurred. Please contact your administrator for help.",
 			),
 		},
+		{
+			name: "displays error banner when err=password_expired param is sent",
+			decodedState: &oidc.UpstreamStateParamData{
+				UpstreamName: testUpstreamName,
+				UpstreamType: testUpstreamType,
+			},
+			encodedState:    testEncodedState,
+			errParam:        "password_expired",
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody: testutil.ExpectedLoginPageHTML(loginhtml.CSS(), testUpstreamName, testPath, testEncodedState,
+				"Your password has expired. Please change your password and try again.",
+			),
+		},
+		{
+			name: "displays error banner when err=password_must_change param is sent",
+			decodedState: &oidc.UpstreamStateParamData{
+				UpstreamName: testUpstreamName,
+				UpstreamType: testUpstreamType,
+			},
+			encodedState:    testEncodedState,
+			errParam:        "password_must_change",
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody: testutil.ExpectedLoginPageHTML(loginhtml.CSS(), testUpstreamName, testPath, testEncodedState,
+				"You must change your password before logging in. Please change your password and try again.",
+			),
+		},
+		{
+			name: "displays error banner when err=account_locked param is sent",
+			decodedState: &oidc.UpstreamStateParamData{
+				UpstreamName: testUpstreamName,
+				UpstreamType: testUpstreamType,
+			},
+			encodedState:    testEncodedState,
+			errParam:        "account_locked",
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody: testutil.ExpectedLoginPageHTML(loginhtml.CSS(), testUpstreamName, testPath, testEncodedState,
+				"Your account is locked. Please try again later, or contact your administrator for help.",
+			),
+		},
 		{
 			// If we get an error that we don't recognize, that's also an error, so we
 			// should probably just tell you to contact your administrator...
@@ -89,6 +164,86 @@ func TestGetLogin(t *testing.T) {
 				"An internal error occurred. Please contact your administrator for help.",
 			),
 		},
+		{
+			name: "displays error banner when err=totp_enrollment_required param is sent",
+			decodedState: &oidc.UpstreamStateParamData{
+				UpstreamName: testUpstreamName,
+				UpstreamType: testUpstreamType,
+			},
+			encodedState:    testEncodedState,
+			errParam:        "totp_enrollment_required",
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody: testutil.ExpectedLoginPageHTML(loginhtml.CSS(), testUpstreamName, testPath, testEncodedState,
+				"A one-time password is required, but you have not enrolled an authenticator app. Please enroll at https://my-issuer.com/totp/enroll and try again.",
+			),
+		},
+		{
+			name: "displays error banner when err=totp_login_expired param is sent",
+			decodedState: &oidc.UpstreamStateParamData{
+				UpstreamName: testUpstreamName,
+				UpstreamType: testUpstreamType,
+			},
+			encodedState:    testEncodedState,
+			errParam:        "totp_login_expired",
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody: testutil.ExpectedLoginPageHTML(loginhtml.CSS(), testUpstreamName, testPath, testEncodedState,
+				"Your login has expired. Please log in again.",
+			),
+		},
+		{
+			name: "displays error banner when err=too_many_attempts param is sent",
+			decodedState: &oidc.UpstreamStateParamData{
+				UpstreamName: testUpstreamName,
+				UpstreamType: testUpstreamType,
+			},
+			encodedState:    testEncodedState,
+			errParam:        "too_many_attempts",
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody: testutil.ExpectedLoginPageHTML(loginhtml.CSS(), testUpstreamName, testPath, testEncodedState,
+				"Too many failed login attempts. Please wait a few minutes and try again.",
+			),
+		},
+		{
+			name: "links to the IDP chooser page without the chosen IDP when the FederationDomain has other IDPs",
+			decodedState: &oidc.UpstreamStateParamData{
+				AuthParams:   "client_id=some-client&pinniped_idp_name=some-ldap-idp&pinniped_idp_type=ldap&scope=openid",
+				UpstreamName: testUpstreamName,
+				UpstreamType: testUpstreamType,
+			},
+			encodedState:    testEncodedState,
+			chooseIDPURL:    testChooseIDPURL,
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        expectedLoginPageHTMLWithChooseIDPLink(testChooseIDPURL + "?client_id=some-client&scope=openid"),
+		},
+		{
+			name: "displays the one-time password page when the totp_state param is sent",
+			decodedState: &oidc.UpstreamStateParamData{
+				UpstreamName: testUpstreamName,
+				UpstreamType: testUpstreamType,
+			},
+			encodedState:    testEncodedState,
+			totpState:       testTOTPState,
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        expectedTOTPPageHTML(""),
+		},
+		{
+			name: "displays the one-time password page with an error banner when err=totp_error param is sent",
+			decodedState: &oidc.UpstreamStateParamData{
+				UpstreamName: testUpstreamName,
+				UpstreamType: testUpstreamType,
+			},
+			encodedState:    testEncodedState,
+			totpState:       testTOTPState,
+			errParam:        "totp_error",
+			wantStatus:      http.StatusOK,
+			wantContentType: htmlContentType,
+			wantBody:        expectedTOTPPageHTML("Incorrect one-time password."),
+		},
 	}
 
 	for _, test := range tests {
@@ -97,11 +252,14 @@ func TestGetLogin(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			handler := NewGetHandler(testPath)
+			handler := NewGetHandler(testPath, testEnrollURL, tt.chooseIDPURL)
 			target := testPath + "?state=" + tt.encodedState
 			if tt.errParam != "" {
 				target += "&err=" + tt.errParam
 			}
+			if tt.totpState != "" {
+				target += "&totp_state=" + tt.totpState
+			}
 			req := httptest.NewRequest(http.MethodGet, target, nil)
 			rsp := httptest.NewRecorder()
 			err := handler(rsp, req, tt.encodedState, tt.decodedState)
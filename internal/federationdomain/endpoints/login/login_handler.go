@@ -71,10 +71,10 @@ func NewHandler(
 
 func wrapSecurityHeaders(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		wrapped := securityheader.WrapWithCustomCSP(handler, loginhtml.ContentSecurityPolicy())
+		wrapped := securityheader.WrapWithCustomCSPFunc(handler, loginhtml.ContentSecurityPolicyForRequest)
 		if r.Method == http.MethodPost {
 			// POST requests can result in the form_post html page, so allow it with CSP headers.
-			wrapped = securityheader.WrapWithCustomCSP(handler, formposthtml.ContentSecurityPolicy())
+			wrapped = securityheader.WrapWithCustomCSPFunc(handler, formposthtml.ContentSecurityPolicyForRequest)
 		}
 		wrapped.ServeHTTP(w, r)
 	})
@@ -1,4 +1,4 @@
-// Copyright 2022-2023 the Pinniped contributors. All Rights Reserved.
+// Copyright 2022-2024 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 // Package loginhtml defines HTML templates used by the Supervisor.
@@ -7,10 +7,12 @@ package loginhtml
 import (
 	_ "embed" // Needed to trigger //go:embed directives below.
 	"html/template"
+	"net/http"
 	"strings"
 
 	"github.com/tdewolff/minify/v2/minify"
 
+	"go.pinniped.dev/internal/federationdomain/branding"
 	"go.pinniped.dev/internal/federationdomain/csp"
 )
 
@@ -28,6 +30,14 @@ var (
 		"minifiedCSS": func() template.CSS { return template.CSS(CSS()) },
 	}).Parse(rawHTMLTemplate))
 
+	//go:embed totp_form.gohtml
+	rawTOTPHTMLTemplate string
+
+	// The one-time password page uses the same CSS as the login page.
+	parsedTOTPHTMLTemplate = template.Must(template.New("totp_form.gohtml").Funcs(template.FuncMap{
+		"minifiedCSS": func() template.CSS { return template.CSS(CSS()) },
+	}).Parse(rawTOTPHTMLTemplate))
+
 	// Generate the CSP header value once since it's effectively constant.
 	cspValue = strings.Join([]string{
 		`default-src 'none'`,
@@ -48,9 +58,19 @@ func panicOnError(s string, err error) string {
 // See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Security-Policy.
 func ContentSecurityPolicy() string { return cspValue }
 
+// ContentSecurityPolicyForRequest returns the ContentSecurityPolicy() with the additional sources needed by the
+// branding of the request's FederationDomain.
+func ContentSecurityPolicyForRequest(r *http.Request) string {
+	return branding.FromContext(r.Context()).ContentSecurityPolicy(cspValue)
+}
+
 // Template returns the html/template.Template for rendering the login page.
 func Template() *template.Template { return parsedHTMLTemplate }
 
+// TOTPTemplate returns the html/template.Template for rendering the page which asks for a one-time password
+// after the username and password were accepted. It uses the same ContentSecurityPolicy() as Template().
+func TOTPTemplate() *template.Template { return parsedTOTPHTMLTemplate }
+
 // CSS returns the minified CSS that will be embedded into the page template.
 func CSS() string { return minifiedCSS }
 
@@ -62,4 +82,19 @@ type PageData struct {
 	AlertMessage  string
 	MinifiedCSS   template.CSS
 	PostPath      string
+	Branding      *branding.Page
+	// ChooseIDPURL links to the IDP chooser page, so the end user can use another identity provider. It is empty
+	// when there is no other identity provider to choose.
+	ChooseIDPURL string
+}
+
+// TOTPPageData represents the inputs to the TOTPTemplate.
+type TOTPPageData struct {
+	State         string
+	TOTPState     string
+	IDPName       string
+	HasAlertError bool
+	AlertMessage  string
+	PostPath      string
+	Branding      *branding.Page
 }
@@ -1,4 +1,4 @@
-// Copyright 2022-2023 the Pinniped contributors. All Rights Reserved.
+// Copyright 2022-2024 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package loginhtml
@@ -6,10 +6,14 @@ package loginhtml
 import (
 	"bytes"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"go.pinniped.dev/internal/federationdomain/branding"
+	"go.pinniped.dev/internal/federationdomain/csp"
 	"go.pinniped.dev/internal/testutil"
 )
 
@@ -38,6 +42,7 @@ func TestTemplate(t *testing.T) {
 		IDPName:       testUpstreamName,
 		HasAlertError: true,
 		AlertMessage:  testAlert,
+		Branding:      branding.Default(),
 	}
 
 	// Render with an alert.
@@ -52,12 +57,108 @@ func TestTemplate(t *testing.T) {
 	buf = bytes.Buffer{} // clear previous result from buffer
 	require.NoError(t, Template().Execute(&buf, pageInputs))
 	require.Equal(t, expectedHTMLWithoutAlert, buf.String())
+
+	// Render again with a link to the IDP chooser page.
+	pageInputs.ChooseIDPURL = "https://example.com/choose_identity_provider?client_id=a&scope=b"
+	buf = bytes.Buffer{} // clear previous result from buffer
+	require.NoError(t, Template().Execute(&buf, pageInputs))
+	require.Contains(t, buf.String(),
+		`<div class="form-field"><a href="https://example.com/choose_identity_provider?client_id=a&amp;scope=b" id="choose-idp">Use a different account</a></div>`)
+}
+
+func TestTOTPTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	pageInputs := &TOTPPageData{
+		PostPath:      "test-post-path",
+		State:         "test-encoded-state",
+		TOTPState:     "test-encoded-totp-state",
+		IDPName:       "test-idp-name",
+		HasAlertError: true,
+		AlertMessage:  "test-alert-message",
+		Branding:      branding.Default(),
+	}
+
+	// Render with an alert.
+	require.NoError(t, TOTPTemplate().Execute(&buf, pageInputs))
+	require.Contains(t, buf.String(), "<style>"+testExpectedCSS+"</style>")
+	require.Contains(t, buf.String(), "<h1>Log in to test-idp-name</h1>")
+	require.Contains(t, buf.String(), `<form action="test-post-path" method="post">`)
+	require.Contains(t, buf.String(), `<input type="hidden" name="state" id="state" value="test-encoded-state">`)
+	require.Contains(t, buf.String(), `<input type="hidden" name="totp_state" id="totp_state" value="test-encoded-totp-state">`)
+	require.Contains(t, buf.String(), `name="totp_code"`)
+	require.Contains(t, buf.String(), `autocomplete="one-time-code"`)
+	require.Contains(t, buf.String(), `id="alert">test-alert-message</span>`)
+
+	// Render again without an alert.
+	pageInputs.HasAlertError = false
+	buf = bytes.Buffer{} // clear previous result from buffer
+	require.NoError(t, TOTPTemplate().Execute(&buf, pageInputs))
+	require.NotContains(t, buf.String(), "test-alert-message")
+}
+
+func TestTemplateWithBranding(t *testing.T) {
+	b, err := branding.Parse(map[string][]byte{
+		branding.LogoKey:         []byte("\x89PNG\r\n\x1a\n"),
+		branding.PrimaryColorKey: []byte("#112233"),
+		branding.SupportURLKey:   []byte("https://help.example.com"),
+		"messages.de.json":       []byte(`{"loginHeading": "Bei {idp} anmelden", "username": "Benutzername", "totpButton": "Bestätigen"}`),
+	})
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Language", "de")
+	page := b.PageFor(r)
+
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		PostPath: "test-post-path",
+		State:    "test-encoded-state",
+		IDPName:  "test-idp-name",
+		Branding: page,
+	}))
+	require.Contains(t, buf.String(), `<html lang="de">`)
+	require.Contains(t, buf.String(), "<style>"+testExpectedCSS+"</style>\n    <style>"+string(page.CSS)+"</style>")
+	require.Contains(t, buf.String(), `<div class="form-field logo"><img src="data:image/png;base64,iVBORw0KGgo=" alt=""></div>`)
+	require.Contains(t, buf.String(), "<h1>Bei test-idp-name anmelden</h1>")
+	require.Contains(t, buf.String(), `placeholder="Benutzername"`)
+	require.Contains(t, buf.String(), `placeholder="Password"`)
+	require.Contains(t, buf.String(), `<div class="form-field support"><a href="https://help.example.com">Need help?</a></div>`)
+
+	buf = bytes.Buffer{} // clear previous result from buffer
+	require.NoError(t, TOTPTemplate().Execute(&buf, &TOTPPageData{
+		PostPath:  "test-post-path",
+		State:     "test-encoded-state",
+		TOTPState: "test-encoded-totp-state",
+		IDPName:   "test-idp-name",
+		Branding:  page,
+	}))
+	require.Contains(t, buf.String(), `<html lang="de">`)
+	require.Contains(t, buf.String(), "<h1>Bei test-idp-name anmelden</h1>")
+	require.Contains(t, buf.String(), `value="Bestätigen"/>`)
+	require.Contains(t, buf.String(), `<a href="https://help.example.com">Need help?</a>`)
 }
 
 func TestContentSecurityPolicy(t *testing.T) {
 	require.Equal(t, testExpectedCSP, ContentSecurityPolicy())
 }
 
+func TestContentSecurityPolicyForRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.Equal(t, testExpectedCSP, ContentSecurityPolicyForRequest(r))
+
+	b, err := branding.Parse(map[string][]byte{
+		branding.LogoKey:         []byte("\x89PNG\r\n\x1a\n"),
+		branding.PrimaryColorKey: []byte("#112233"),
+	})
+	require.NoError(t, err)
+	page := b.PageFor(r)
+	r = r.WithContext(branding.NewContext(r.Context(), page))
+	require.Equal(t, `default-src 'none'; `+
+		`style-src 'sha256-QC9ckaUFAdcN0Ysmu8q8iqCazYFgrJSQDJPa/przPXU=' '`+csp.Hash(string(page.CSS))+`'; `+
+		`frame-ancestors 'none'; `+
+		`img-src data:`,
+		ContentSecurityPolicyForRequest(r))
+}
+
 func TestCSS(t *testing.T) {
 	require.Equal(t, testExpectedCSS, CSS())
 }
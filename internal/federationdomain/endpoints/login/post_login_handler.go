@@ -4,22 +4,60 @@
 package login
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/ory/fosite"
 
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
 	"go.pinniped.dev/internal/federationdomain/downstreamsession"
+	"go.pinniped.dev/internal/federationdomain/endpoints/consent"
 	"go.pinniped.dev/internal/federationdomain/endpoints/loginurl"
 	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
+	"go.pinniped.dev/internal/federationdomain/loginlimiter"
 	"go.pinniped.dev/internal/federationdomain/oidc"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedldap"
 	"go.pinniped.dev/internal/httputil/httperr"
 	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/totp"
 )
 
-func NewPostHandler(issuerURL string, upstreamIDPs federationdomainproviders.FederationDomainIdentityProvidersFinderI, oauthHelper fosite.OAuth2Provider) HandlerFunc {
+const (
+	// totpLoginStateLifetime is how long the end user has to enter their one-time password after their
+	// username and password were accepted.
+	totpLoginStateLifetime = 5 * time.Minute
+)
+
+// totpLoginState is the data that is passed to the page which asks for the one-time password, so the login can
+// continue after the one-time password is submitted without asking for the end user's password again. It is
+// encrypted, so it cannot be read or changed by the end user.
+type totpLoginState struct {
+	// Username is the username that was submitted by the end user, and accepted along with their password.
+	Username string `json:"u"`
+	// Subject is the downstream subject of the end user, which is used to find their TOTP enrollment.
+	Subject string `json:"s"`
+	// StateHash is a hash of the encoded state param of the login, which prevents this data from being used
+	// in any other login.
+	StateHash string `json:"h"`
+	// IssuedAt is the time at which the username and password were accepted.
+	IssuedAt time.Time `json:"i"`
+}
+
+func NewPostHandler(
+	issuerURL string,
+	upstreamIDPs federationdomainproviders.FederationDomainIdentityProvidersFinderI,
+	oauthHelper fosite.OAuth2Provider,
+	totpEnrollments *totp.Enrollments,
+	totpStateCodec oidc.Codec,
+	loginLimiter *loginlimiter.Limiter,
+	consentGrants *consentgrants.Store,
+) HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request, encodedState string, decodedState *oidc.UpstreamStateParamData) error {
 		// Note that the login handler prevents this handler from being called with OIDC upstreams.
 		idp, err := upstreamIDPs.FindUpstreamIDPByDisplayName(decodedState.UpstreamName)
@@ -54,6 +92,18 @@ func NewPostHandler(issuerURL string, upstreamIDPs federationdomainproviders.Fed
 		// an error if the client requested a scope that they are not allowed to request, so we don't need to worry about that here.
 		downstreamsession.AutoApproveScopes(authorizeRequester)
 
+		// When the TOTP state param was submitted, then the username and password were already accepted,
+		// and the end user is submitting their one-time password.
+		if encodedTOTPState := r.PostFormValue(loginurl.TOTPStateParamName); encodedTOTPState != "" {
+			ldapIDP, ok := idp.(*resolvedldap.FederationDomainResolvedLDAPIdentityProvider)
+			if !ok {
+				// This shouldn't really happen because the login handler only allows LDAP and ActiveDirectory upstreams.
+				return httperr.New(http.StatusBadRequest, "one-time passwords are not supported for this upstream IDP")
+			}
+			return handleTOTPCode(r, w, issuerURL, encodedState, decodedState, encodedTOTPState, ldapIDP,
+				oauthHelper, authorizeRequester, totpEnrollments, totpStateCodec, loginLimiter, consentGrants)
+		}
+
 		// Get the username and password form params from the POST body.
 		submittedUsername := r.PostFormValue(loginurl.UsernameParamName)
 		submittedPassword := r.PostFormValue(loginurl.PasswordParamName)
@@ -65,41 +115,241 @@ func NewPostHandler(issuerURL string, upstreamIDPs federationdomainproviders.Fed
 			return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowBadUserPassErr)
 		}
 
+		// Refuse the login before contacting the upstream when there were too many recent failed logins.
+		// Otherwise, reserve the attempt, so that concurrent attempts are counted before the password is checked.
+		reservation, wait, err := loginLimiter.Reserve(r.Context(), r, submittedUsername)
+		if err != nil {
+			plog.WarningErr("error checking failed logins", err, "upstreamName", idp.GetProvider().GetResourceName())
+			return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowInternalError)
+		}
+		if wait > 0 {
+			plog.Info("refusing login due to too many failed logins",
+				"upstreamName", idp.GetProvider().GetResourceName(), "retryAfter", wait)
+			return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowTooManyLoginAttemptsErr)
+		}
+		// Release the reservation when the attempt was neither counted as a failure nor as a success.
+		defer func() {
+			if err := reservation.Release(r.Context()); err != nil {
+				plog.WarningErr("error releasing login attempt", err, "upstreamName", idp.GetProvider().GetResourceName())
+			}
+		}()
+
 		// Attempt to authenticate the user with the upstream IDP.
 		identity, loginExtras, err := idp.Login(r.Context(), submittedUsername, submittedPassword)
 		if err != nil {
-			switch {
-			case errors.Is(err, resolvedldap.ErrUnexpectedUpstreamLDAPError):
-				// There was some problem during authentication with the upstream, aside from bad username/password.
-				// The user may try to log in again if they'd like, so redirect back to the login page with an error.
+			if loginlimiter.IsFailedLogin(err) {
+				if recordErr := reservation.RecordFailure(r.Context()); recordErr != nil {
+					plog.WarningErr("error recording failed login", recordErr, "upstreamName", idp.GetProvider().GetResourceName())
+				}
+			}
+			return handleLoginError(r, w, issuerURL, encodedState, oauthHelper, authorizeRequester, err)
+		}
+
+		// When the upstream requires a one-time password, then ask for it before finishing the login.
+		// The failed logins are only forgotten once the one-time password was also accepted, so that knowing the
+		// password does not allow unlimited guessing of one-time passwords.
+		if ldapIDP, ok := idp.(*resolvedldap.FederationDomainResolvedLDAPIdentityProvider); ok {
+			result, err := totpEnrollments.Check(r.Context(), ldapIDP.TOTPPolicy, identity.DownstreamSubject, "")
+			if err != nil {
+				plog.WarningErr("error checking one-time password", err, "upstreamName", idp.GetProvider().GetResourceName())
 				return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowInternalError)
-			case err == resolvedldap.ErrAccessDeniedDueToUsernamePasswordNotAccepted:
-				// The upstream did not accept the username/password combination.
-				// The user may try to log in again if they'd like, so redirect back to the login page with an error.
-				return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowBadUserPassErr)
-			default:
-				// Some other error happened.
-				oidc.WriteAuthorizeError(r, w, oauthHelper, authorizeRequester, err, false)
-				return nil
 			}
+			switch result {
+			case totp.CheckPassed:
+				// The end user does not need to provide a one-time password, so continue.
+			case totp.CheckEnrollmentRequired:
+				return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowTOTPEnrollmentRequiredErr)
+			case totp.CheckCodeRequired, totp.CheckCodeNotAccepted:
+				encodedTOTPState, err := totpStateCodec.Encode(oidc.TOTPLoginStateEncodingName, &totpLoginState{
+					Username:  submittedUsername,
+					Subject:   identity.DownstreamSubject,
+					StateHash: hashState(encodedState),
+					IssuedAt:  time.Now(),
+				})
+				if err != nil {
+					plog.Error("error encoding totp state", err)
+					return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowInternalError)
+				}
+				return redirectToTOTPPage(r, w, issuerURL, encodedState, encodedTOTPState, loginurl.ShowNoError)
+			}
+		}
+		if err := reservation.RecordSuccess(r.Context()); err != nil {
+			plog.WarningErr("error forgetting failed logins", err, "upstreamName", idp.GetProvider().GetResourceName())
 		}
 
-		session, err := downstreamsession.NewPinnipedSession(r.Context(), idp, &downstreamsession.SessionConfig{
-			UpstreamIdentity:    identity,
-			UpstreamLoginExtras: loginExtras,
-			ClientID:            authorizeRequester.GetClient().GetID(),
-			GrantedScopes:       authorizeRequester.GetGrantedScopes(),
-		})
-		if err != nil {
-			err = fosite.ErrAccessDenied.WithHintf("Reason: %s.", err.Error())
-			oidc.WriteAuthorizeError(r, w, oauthHelper, authorizeRequester, err, false)
-			return nil
+		return finishLogin(r, w, issuerURL, encodedState, decodedState, oauthHelper, authorizeRequester, idp, identity, loginExtras,
+			consentGrants)
+	}
+}
+
+// handleTOTPCode continues a login after the end user submitted their one-time password. The username and
+// password were accepted by an earlier request, which is proven by the TOTP state param.
+func handleTOTPCode(
+	r *http.Request,
+	w http.ResponseWriter,
+	issuerURL string,
+	encodedState string,
+	decodedState *oidc.UpstreamStateParamData,
+	encodedTOTPState string,
+	idp *resolvedldap.FederationDomainResolvedLDAPIdentityProvider,
+	oauthHelper fosite.OAuth2Provider,
+	authorizeRequester fosite.AuthorizeRequester,
+	totpEnrollments *totp.Enrollments,
+	totpStateCodec oidc.Codec,
+	loginLimiter *loginlimiter.Limiter,
+	consentGrants *consentgrants.Store,
+) error {
+	var decodedTOTPState totpLoginState
+	if err := totpStateCodec.Decode(oidc.TOTPLoginStateEncodingName, encodedTOTPState, &decodedTOTPState); err != nil {
+		plog.InfoErr("error decoding totp state", err)
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowTOTPLoginExpiredErr)
+	}
+	if subtle.ConstantTimeCompare([]byte(decodedTOTPState.StateHash), []byte(hashState(encodedState))) != 1 {
+		plog.Info("totp state does not belong to this login")
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowTOTPLoginExpiredErr)
+	}
+	if time.Since(decodedTOTPState.IssuedAt) > totpLoginStateLifetime {
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowTOTPLoginExpiredErr)
+	}
+
+	// Incorrect one-time passwords count as failed logins, so they are limited in the same way as passwords.
+	reservation, wait, err := loginLimiter.Reserve(r.Context(), r, decodedTOTPState.Username)
+	if err != nil {
+		plog.WarningErr("error checking failed logins", err, "upstreamName", idp.Provider.GetResourceName())
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowInternalError)
+	}
+	if wait > 0 {
+		plog.Info("refusing login due to too many failed logins",
+			"upstreamName", idp.Provider.GetResourceName(), "retryAfter", wait)
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowTooManyLoginAttemptsErr)
+	}
+	defer func() {
+		if err := reservation.Release(r.Context()); err != nil {
+			plog.WarningErr("error releasing login attempt", err, "upstreamName", idp.Provider.GetResourceName())
+		}
+	}()
+
+	result, err := totpEnrollments.Check(r.Context(), idp.TOTPPolicy, decodedTOTPState.Subject, r.PostFormValue(loginurl.TOTPCodeParamName))
+	if err != nil {
+		plog.WarningErr("error checking one-time password", err, "upstreamName", idp.Provider.GetResourceName())
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowInternalError)
+	}
+	switch result {
+	case totp.CheckPassed:
+		// The one-time password was accepted, so continue.
+	case totp.CheckEnrollmentRequired:
+		// The enrollment was removed after the username and password were accepted.
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowTOTPEnrollmentRequiredErr)
+	case totp.CheckCodeNotAccepted:
+		if recordErr := reservation.RecordFailure(r.Context()); recordErr != nil {
+			plog.WarningErr("error recording failed login", recordErr, "upstreamName", idp.Provider.GetResourceName())
 		}
+		// The end user may try again, until the TOTP state expires.
+		return redirectToTOTPPage(r, w, issuerURL, encodedState, encodedTOTPState, loginurl.ShowIncorrectTOTPCodeErr)
+	case totp.CheckCodeRequired:
+		// The end user may try again, until the TOTP state expires.
+		return redirectToTOTPPage(r, w, issuerURL, encodedState, encodedTOTPState, loginurl.ShowIncorrectTOTPCodeErr)
+	}
+	if err := reservation.RecordSuccess(r.Context()); err != nil {
+		plog.WarningErr("error forgetting failed logins", err, "upstreamName", idp.Provider.GetResourceName())
+	}
+
+	// The password was already checked by the earlier request, so find the end user again without it.
+	identity, loginExtras, err := idp.LoginWithoutPasswordCheck(r.Context(), decodedTOTPState.Username)
+	if err != nil {
+		return handleLoginError(r, w, issuerURL, encodedState, oauthHelper, authorizeRequester, err)
+	}
+	if identity.DownstreamSubject != decodedTOTPState.Subject {
+		// This shouldn't really happen, unless the upstream user changed during the login.
+		plog.Warning("upstream user changed during one-time password login", "upstreamName", idp.Provider.GetResourceName())
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowInternalError)
+	}
+
+	return finishLogin(r, w, issuerURL, encodedState, decodedState, oauthHelper, authorizeRequester, idp, identity, loginExtras,
+		consentGrants)
+}
+
+// handleLoginError redirects back to the login page or writes an authorize error response for an error
+// returned by the Login of an LDAP or ActiveDirectory upstream.
+func handleLoginError(
+	r *http.Request,
+	w http.ResponseWriter,
+	issuerURL string,
+	encodedState string,
+	oauthHelper fosite.OAuth2Provider,
+	authorizeRequester fosite.AuthorizeRequester,
+	err error,
+) error {
+	switch {
+	case errors.Is(err, resolvedldap.ErrUnexpectedUpstreamLDAPError):
+		// There was some problem during authentication with the upstream, aside from bad username/password.
+		// The user may try to log in again if they'd like, so redirect back to the login page with an error.
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowInternalError)
+	case err == resolvedldap.ErrAccessDeniedDueToUsernamePasswordNotAccepted:
+		// The upstream did not accept the username/password combination.
+		// The user may try to log in again if they'd like, so redirect back to the login page with an error.
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowBadUserPassErr)
+	case err == resolvedldap.ErrAccessDeniedDueToPasswordExpired:
+		// The upstream reported that the user's password has expired, so tell the user to change it.
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowPasswordExpiredErr)
+	case err == resolvedldap.ErrAccessDeniedDueToPasswordMustChange:
+		// The upstream reported that the user must change their password before logging in.
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowPasswordMustChangeErr)
+	case err == resolvedldap.ErrAccessDeniedDueToAccountLocked:
+		// The upstream reported that the user's account is locked, so retrying right away will not help.
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowAccountLockedErr)
+	default:
+		// Some other error happened.
+		oidc.WriteAuthorizeError(r, w, oauthHelper, authorizeRequester, err, false)
+		return nil
+	}
+}
 
-		oidc.PerformAuthcodeRedirect(r, w, oauthHelper, authorizeRequester, session, false)
+// finishLogin creates the downstream session and redirects back to the client with an authcode, or redirects to
+// the consent page when the client requires consent.
+func finishLogin(
+	r *http.Request,
+	w http.ResponseWriter,
+	issuerURL string,
+	encodedState string,
+	decodedState *oidc.UpstreamStateParamData,
+	oauthHelper fosite.OAuth2Provider,
+	authorizeRequester fosite.AuthorizeRequester,
+	idp resolvedprovider.FederationDomainResolvedIdentityProvider,
+	identity *resolvedprovider.Identity,
+	loginExtras *resolvedprovider.IdentityLoginExtras,
+	consentGrants *consentgrants.Store,
+) error {
+	session, err := downstreamsession.NewPinnipedSession(r.Context(), idp, &downstreamsession.SessionConfig{
+		UpstreamIdentity:    identity,
+		UpstreamLoginExtras: loginExtras,
+		Client:              authorizeRequester.GetClient(),
+		GrantedScopes:       authorizeRequester.GetGrantedScopes(),
+	})
+	if err != nil {
+		err = fosite.ErrAccessDenied.WithHintf("Reason: %s.", err.Error())
+		oidc.WriteAuthorizeError(r, w, oauthHelper, authorizeRequester, err, false)
+		return nil
+	}
 
+	redirected, err := consent.RedirectIfRequired(r, w, issuerURL+oidc.ConsentEndpointPath, consentGrants,
+		authorizeRequester, session, decodedState)
+	if err != nil {
+		plog.WarningErr("error checking consent", err, "upstreamName", idp.GetProvider().GetResourceName())
+		return redirectToLoginPage(r, w, issuerURL, encodedState, loginurl.ShowInternalError)
+	}
+	if redirected {
 		return nil
 	}
+
+	oidc.PerformAuthcodeRedirect(r, w, oauthHelper, authorizeRequester, session, false)
+
+	return nil
+}
+
+func hashState(encodedState string) string {
+	hash := sha256.Sum256([]byte(encodedState))
+	return hex.EncodeToString(hash[:])
 }
 
 // redirectToLoginPage redirects to the GET /login page of the specified issuer.
@@ -122,3 +372,25 @@ func redirectToLoginPage(
 
 	return nil
 }
+
+// redirectToTOTPPage redirects to the GET /login page of the specified issuer, which will ask for the one-time password.
+func redirectToTOTPPage(
+	r *http.Request,
+	w http.ResponseWriter,
+	downstreamIssuer string,
+	encodedStateParamValue string,
+	encodedTOTPStateParamValue string,
+	errToDisplay loginurl.ErrorParamValue,
+) error {
+	totpURL, err := loginurl.TOTPURL(downstreamIssuer, encodedStateParamValue, encodedTOTPStateParamValue, errToDisplay)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r,
+		totpURL,
+		http.StatusSeeOther, // match fosite and https://tools.ietf.org/id/draft-ietf-oauth-security-topics-18.html#section-4.11
+	)
+
+	return nil
+}
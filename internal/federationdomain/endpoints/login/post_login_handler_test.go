@@ -5,15 +5,21 @@ package login
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/securecookie"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/client-go/kubernetes/fake"
 
@@ -21,7 +27,10 @@ import (
 	supervisorfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
 	"go.pinniped.dev/internal/authenticators"
 	"go.pinniped.dev/internal/celtransformer"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
 	"go.pinniped.dev/internal/federationdomain/endpoints/jwks"
+	"go.pinniped.dev/internal/federationdomain/loginlimiter"
 	"go.pinniped.dev/internal/federationdomain/oidc"
 	"go.pinniped.dev/internal/federationdomain/oidcclientvalidator"
 	"go.pinniped.dev/internal/federationdomain/storage"
@@ -29,7 +38,10 @@ import (
 	"go.pinniped.dev/internal/testutil"
 	"go.pinniped.dev/internal/testutil/oidctestutil"
 	"go.pinniped.dev/internal/testutil/testidplister"
+	testtotp "go.pinniped.dev/internal/testutil/totp"
 	"go.pinniped.dev/internal/testutil/transformtestutil"
+	"go.pinniped.dev/internal/totp"
+	"go.pinniped.dev/internal/upstreamldap"
 )
 
 func TestPostLoginEndpoint(t *testing.T) {
@@ -60,10 +72,21 @@ func TestPostLoginEndpoint(t *testing.T) {
 		activeDirectoryUpstreamResourceUID = "active-directory-resource-uid"
 		upstreamLDAPURL                    = "ldaps://some-ldap-host:123?base=ou%3Dusers%2Cdc%3Dpinniped%2Cdc%3Ddev"
 
-		userParam                = "username"
-		passParam                = "password"
-		badUserPassErrParamValue = "login_error"
-		internalErrParamValue    = "internal_error"
+		userParam                        = "username"
+		passParam                        = "password"
+		badUserPassErrParamValue         = "login_error"
+		internalErrParamValue            = "internal_error"
+		passwordExpiredErrParamValue     = "password_expired"
+		passwordMustChangeErrParamValue  = "password_must_change"
+		accountLockedErrParamValue       = "account_locked"
+		totpEnrollmentRequiredParamValue = "totp_enrollment_required"
+		totpLoginExpiredErrParamValue    = "totp_login_expired"
+		incorrectTOTPCodeErrParamValue   = "totp_error"
+		tooManyLoginAttemptsParamValue   = "too_many_attempts"
+		totpStateParam                   = "totp_state"
+		totpCodeParam                    = "totp_code"
+
+		happyTOTPSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
 
 		transformationUsernamePrefix = "username_prefix:"
 		transformationGroupsPrefix   = "groups_prefix:"
@@ -141,6 +164,12 @@ func TestPostLoginEndpoint(t *testing.T) {
 		data.AuthParams = happyDownstreamRequestParamsForDynamicClient
 	})
 
+	happyLDAPDecodedStateForDynamicClientWithPromptConsent := modifyHappyLDAPDecodedState(func(data *oidc.UpstreamStateParamData) {
+		data.AuthParams = shallowCopyAndModifyQuery(happyDownstreamRequestParamsQueryForDynamicClient,
+			map[string]string{"prompt": "consent"},
+		).Encode()
+	})
+
 	happyActiveDirectoryDecodedState := modifyHappyLDAPDecodedState(func(data *oidc.UpstreamStateParamData) {
 		data.UpstreamName = activeDirectoryUpstreamName
 		data.UpstreamType = activeDirectoryUpstreamType
@@ -200,6 +229,45 @@ func TestPostLoginEndpoint(t *testing.T) {
 
 	upstreamActiveDirectoryIdentityProvider := upstreamActiveDirectoryIdentityProviderBuilder.Build()
 
+	happyLDAPDownstreamSubject := upstreamLDAPURL + "&idpName=" + ldapUpstreamName + "&sub=" + happyLDAPUID
+
+	ldapDryRunAuthenticateFunc := func(ctx context.Context, username string) (*authenticators.Response, bool, error) {
+		return ldapAuthenticateFunc(ctx, username, happyLDAPPassword)
+	}
+
+	upstreamLDAPIdentityProviderWithTOTPPolicy := func(policy totp.Policy) *oidctestutil.TestUpstreamLDAPIdentityProvider {
+		return oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+			WithName(ldapUpstreamName).
+			WithResourceUID(ldapUpstreamResourceUID).
+			WithURL(parsedUpstreamLDAPURL).
+			WithAuthenticateFunc(ldapAuthenticateFunc).
+			WithDryRunAuthenticateFunc(ldapDryRunAuthenticateFunc).
+			WithTOTPPolicyForFederationDomain(policy).
+			Build()
+	}
+
+	totpEncryptionKey := []byte("0123456789abcdef0123456789abcdef")
+	totpStateCodec := securecookie.New([]byte("fake-totp-state-hash-secret"), []byte("0123456789ABCDEF"))
+	totpStateCodec.SetSerializer(securecookie.JSONEncoder{})
+
+	encodeTOTPState := func(state *totpLoginState) string {
+		encoded, err := totpStateCodec.Encode(oidc.TOTPLoginStateEncodingName, state)
+		require.NoError(t, err)
+		return encoded
+	}
+
+	happyTOTPState := encodeTOTPState(&totpLoginState{
+		Username:  happyLDAPUsername,
+		Subject:   happyLDAPDownstreamSubject,
+		StateHash: hashState(happyEncodedUpstreamState),
+		IssuedAt:  time.Now(),
+	})
+
+	validTOTPCode := func() string {
+		code, _ := testtotp.GenerateOTPCode(t, happyTOTPSecret, time.Now())
+		return code
+	}
+
 	erroringUpstreamLDAPIdentityProvider := oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
 		WithName(ldapUpstreamName).
 		WithResourceUID(ldapUpstreamResourceUID).
@@ -208,6 +276,16 @@ func TestPostLoginEndpoint(t *testing.T) {
 		}).
 		Build()
 
+	accountStatusErroringUpstreamLDAPIdentityProvider := func(reason upstreamldap.AccountStatusReason) *oidctestutil.TestUpstreamLDAPIdentityProvider {
+		return oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+			WithName(ldapUpstreamName).
+			WithResourceUID(ldapUpstreamResourceUID).
+			WithAuthenticateFunc(func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+				return nil, false, &upstreamldap.AccountStatusError{Reason: reason}
+			}).
+			Build()
+	}
+
 	expectedHappyActiveDirectoryUpstreamCustomSession := &psession.CustomSessionData{
 		Username:         happyLDAPUsernameFromAuthenticator,
 		ProviderUID:      activeDirectoryUpstreamResourceUID,
@@ -286,6 +364,15 @@ func TestPostLoginEndpoint(t *testing.T) {
 		require.NoError(t, kubeClient.Tracker().Add(secret))
 	}
 
+	addDynamicClientWhichRequiresConsentAndSecretToKubeResources := func(t *testing.T, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset) {
+		oidcClient, secret := testutil.FullyCapableOIDCClientAndStorageSecret(t,
+			"some-namespace", downstreamDynamicClientID, downstreamDynamicClientUID, downstreamRedirectURI, nil,
+			[]string{testutil.HashedPassword1AtGoMinCost}, oidcclientvalidator.Validate)
+		oidcClient.Spec.RequireConsent = true
+		require.NoError(t, supervisorClient.Tracker().Add(oidcClient))
+		require.NoError(t, kubeClient.Tracker().Add(secret))
+	}
+
 	prefixUsernameAndGroupsPipeline := transformtestutil.NewPrefixingPipeline(t, transformationUsernamePrefix, transformationGroupsPrefix)
 	rejectAuthPipeline := transformtestutil.NewRejectAllAuthPipeline(t)
 
@@ -302,9 +389,27 @@ func TestPostLoginEndpoint(t *testing.T) {
 		wantBodyString  string
 		wantErr         string
 
+		// The downstream subject of the end user who has enrolled an authenticator app, if any.
+		totpEnrolledSubject string
+
+		// The number of failed logins of the submitted username which were recorded before the request.
+		priorFailedLogins int
+		// Whether the next login of the submitted username should be delayed after the request.
+		wantLoginBlockedAfterRequest bool
+
 		// Assertion that the response should be a redirect to the login page with an error param.
 		wantRedirectToLoginPageError string
 
+		// Assertions that the response should be a redirect to the page which asks for the one-time password,
+		// optionally with an error param.
+		wantRedirectToTOTPPage      bool
+		wantRedirectToTOTPPageError string
+
+		// The scopes which the end user already consented to for the dynamic client, if any.
+		consentGrantedScopes []string
+		// Assertion that the response should be a redirect to the consent page.
+		wantRedirectToConsentPage bool
+
 		// Assertions for when an authcode should be returned, i.e. the request was authenticated by an
 		// upstream LDAP or AD provider.
 		wantRedirectLocationRegexp        string // for loose matching
@@ -404,6 +509,65 @@ func TestPostLoginEndpoint(t *testing.T) {
 			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
 			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
 		},
+		{
+			name: "LDAP login with dynamic client which requires consent redirects to the consent page",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithLDAP(upstreamLDAPIdentityProvider),
+			kubeResources:             addDynamicClientWhichRequiresConsentAndSecretToKubeResources,
+			decodedState:              happyLDAPDecodedStateForDynamicClient,
+			formParams:                happyUsernamePasswordFormParams,
+			wantStatus:                http.StatusSeeOther,
+			wantContentType:           htmlContentType,
+			wantRedirectToConsentPage: true,
+		},
+		{
+			name: "LDAP login with dynamic client which requires consent when the end user already consented to the scopes",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithLDAP(upstreamLDAPIdentityProvider),
+			kubeResources:                     addDynamicClientWhichRequiresConsentAndSecretToKubeResources,
+			consentGrantedScopes:              []string{"openid", "offline_access", "pinniped:request-audience", "username", "groups"},
+			decodedState:                      happyLDAPDecodedStateForDynamicClient,
+			formParams:                        happyUsernamePasswordFormParams,
+			wantStatus:                        http.StatusSeeOther,
+			wantContentType:                   htmlContentType,
+			wantBodyString:                    "",
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      upstreamLDAPURL + "&idpName=" + ldapUpstreamName + "&sub=" + happyLDAPUID,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamClient:              downstreamDynamicClientID,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+		},
+		{
+			name: "LDAP login with dynamic client which requires consent when the end user consented to fewer scopes redirects to the consent page",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithLDAP(upstreamLDAPIdentityProvider),
+			kubeResources:             addDynamicClientWhichRequiresConsentAndSecretToKubeResources,
+			consentGrantedScopes:      []string{"openid"},
+			decodedState:              happyLDAPDecodedStateForDynamicClient,
+			formParams:                happyUsernamePasswordFormParams,
+			wantStatus:                http.StatusSeeOther,
+			wantContentType:           htmlContentType,
+			wantRedirectToConsentPage: true,
+		},
+		{
+			name: "LDAP login with dynamic client which requires consent and prompt=consent redirects to the consent page even when the end user already consented",
+			idps: testidplister.NewUpstreamIDPListerBuilder().
+				WithLDAP(upstreamLDAPIdentityProvider),
+			kubeResources:             addDynamicClientWhichRequiresConsentAndSecretToKubeResources,
+			consentGrantedScopes:      []string{"openid", "offline_access", "pinniped:request-audience", "username", "groups"},
+			decodedState:              happyLDAPDecodedStateForDynamicClientWithPromptConsent,
+			formParams:                happyUsernamePasswordFormParams,
+			wantStatus:                http.StatusSeeOther,
+			wantContentType:           htmlContentType,
+			wantRedirectToConsentPage: true,
+		},
 		{
 			name: "happy AD login",
 			idps: testidplister.NewUpstreamIDPListerBuilder().
@@ -791,6 +955,30 @@ func TestPostLoginEndpoint(t *testing.T) {
 			wantBodyString:               "",
 			wantRedirectToLoginPageError: badUserPassErrParamValue,
 		},
+		{
+			name:                         "bad password LDAP login after the allowed failed logins delays the next login",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProvider),
+			decodedState:                 happyLDAPDecodedState,
+			formParams:                   url.Values{userParam: []string{happyLDAPUsername}, passParam: []string{"wrong!"}},
+			priorFailedLogins:            2,
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: badUserPassErrParamValue,
+			wantLoginBlockedAfterRequest: true,
+		},
+		{
+			name:                         "LDAP login is refused without contacting the upstream after too many failed logins",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(erroringUpstreamLDAPIdentityProvider),
+			decodedState:                 happyLDAPDecodedState,
+			formParams:                   happyUsernamePasswordFormParams,
+			priorFailedLogins:            3,
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: tooManyLoginAttemptsParamValue,
+			wantLoginBlockedAfterRequest: true,
+		},
 		{
 			name:                         "blank username LDAP login",
 			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProvider),
@@ -831,6 +1019,47 @@ func TestPostLoginEndpoint(t *testing.T) {
 			wantBodyString:               "",
 			wantRedirectToLoginPageError: internalErrParamValue,
 		},
+		{
+			name:                         "expired password during upstream LDAP authentication",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(accountStatusErroringUpstreamLDAPIdentityProvider(upstreamldap.AccountStatusPasswordExpired)),
+			decodedState:                 happyLDAPDecodedState,
+			formParams:                   happyUsernamePasswordFormParams,
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: passwordExpiredErrParamValue,
+		},
+		{
+			name:                         "expired password during upstream LDAP authentication is not counted as a failed login",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(accountStatusErroringUpstreamLDAPIdentityProvider(upstreamldap.AccountStatusPasswordExpired)),
+			decodedState:                 happyLDAPDecodedState,
+			formParams:                   happyUsernamePasswordFormParams,
+			priorFailedLogins:            2,
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: passwordExpiredErrParamValue,
+		},
+		{
+			name:                         "password must be changed during upstream LDAP authentication",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(accountStatusErroringUpstreamLDAPIdentityProvider(upstreamldap.AccountStatusPasswordMustChange)),
+			decodedState:                 happyLDAPDecodedState,
+			formParams:                   happyUsernamePasswordFormParams,
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: passwordMustChangeErrParamValue,
+		},
+		{
+			name:                         "locked account during upstream LDAP authentication",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(accountStatusErroringUpstreamLDAPIdentityProvider(upstreamldap.AccountStatusAccountLocked)),
+			decodedState:                 happyLDAPDecodedState,
+			formParams:                   happyUsernamePasswordFormParams,
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: accountLockedErrParamValue,
+		},
 		{
 			name: "downstream redirect uri does not match what is configured for client",
 			idps: testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProvider),
@@ -1097,6 +1326,214 @@ func TestPostLoginEndpoint(t *testing.T) {
 			formParams: happyUsernamePasswordFormParams,
 			wantErr:    "error using state downstream auth params",
 		},
+		{
+			name:                   "LDAP login with required one-time password for user who has enrolled asks for the one-time password",
+			idps:                   testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			totpEnrolledSubject:    happyLDAPDownstreamSubject,
+			decodedState:           happyLDAPDecodedState,
+			formParams:             happyUsernamePasswordFormParams,
+			wantStatus:             http.StatusSeeOther,
+			wantContentType:        htmlContentType,
+			wantBodyString:         "",
+			wantRedirectToTOTPPage: true,
+		},
+		{
+			name:                   "LDAP login with one-time password when enrolled for user who has enrolled asks for the one-time password",
+			idps:                   testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyWhenEnrolled)),
+			totpEnrolledSubject:    happyLDAPDownstreamSubject,
+			decodedState:           happyLDAPDecodedState,
+			formParams:             happyUsernamePasswordFormParams,
+			wantStatus:             http.StatusSeeOther,
+			wantContentType:        htmlContentType,
+			wantBodyString:         "",
+			wantRedirectToTOTPPage: true,
+		},
+		{
+			name:                         "LDAP login with required one-time password for user who has not enrolled",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			decodedState:                 happyLDAPDecodedState,
+			formParams:                   happyUsernamePasswordFormParams,
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: totpEnrollmentRequiredParamValue,
+		},
+		{
+			name:                              "LDAP login with one-time password when enrolled for user who has not enrolled",
+			idps:                              testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyWhenEnrolled)),
+			decodedState:                      happyLDAPDecodedState,
+			formParams:                        happyUsernamePasswordFormParams,
+			wantStatus:                        http.StatusSeeOther,
+			wantContentType:                   htmlContentType,
+			wantBodyString:                    "",
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      happyLDAPDownstreamSubject,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamClient:              downstreamPinnipedCLIClientID,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+		},
+		{
+			name:                              "happy LDAP login with one-time password",
+			idps:                              testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			totpEnrolledSubject:               happyLDAPDownstreamSubject,
+			decodedState:                      happyLDAPDecodedState,
+			formParams:                        url.Values{totpStateParam: []string{happyTOTPState}, totpCodeParam: []string{validTOTPCode()}},
+			wantStatus:                        http.StatusSeeOther,
+			wantContentType:                   htmlContentType,
+			wantBodyString:                    "",
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      happyLDAPDownstreamSubject,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamClient:              downstreamPinnipedCLIClientID,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+		},
+		{
+			name:                        "incorrect one-time password asks for the one-time password again",
+			idps:                        testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			totpEnrolledSubject:         happyLDAPDownstreamSubject,
+			decodedState:                happyLDAPDecodedState,
+			formParams:                  url.Values{totpStateParam: []string{happyTOTPState}, totpCodeParam: []string{"000000"}},
+			wantStatus:                  http.StatusSeeOther,
+			wantContentType:             htmlContentType,
+			wantBodyString:              "",
+			wantRedirectToTOTPPage:      true,
+			wantRedirectToTOTPPageError: incorrectTOTPCodeErrParamValue,
+		},
+		{
+			name:                         "incorrect one-time password after the allowed failed logins delays the next login",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			totpEnrolledSubject:          happyLDAPDownstreamSubject,
+			decodedState:                 happyLDAPDecodedState,
+			formParams:                   url.Values{totpStateParam: []string{happyTOTPState}, totpCodeParam: []string{"000000"}},
+			priorFailedLogins:            2,
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToTOTPPage:       true,
+			wantRedirectToTOTPPageError:  incorrectTOTPCodeErrParamValue,
+			wantLoginBlockedAfterRequest: true,
+		},
+		{
+			name:                         "one-time password is refused without checking it after too many failed logins",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			totpEnrolledSubject:          happyLDAPDownstreamSubject,
+			decodedState:                 happyLDAPDecodedState,
+			formParams:                   url.Values{totpStateParam: []string{happyTOTPState}, totpCodeParam: []string{validTOTPCode()}},
+			priorFailedLogins:            3,
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: tooManyLoginAttemptsParamValue,
+			wantLoginBlockedAfterRequest: true,
+		},
+		{
+			name:                              "correct one-time password after failed logins forgets the failed logins",
+			idps:                              testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			totpEnrolledSubject:               happyLDAPDownstreamSubject,
+			decodedState:                      happyLDAPDecodedState,
+			formParams:                        url.Values{totpStateParam: []string{happyTOTPState}, totpCodeParam: []string{validTOTPCode()}},
+			priorFailedLogins:                 2,
+			wantStatus:                        http.StatusSeeOther,
+			wantContentType:                   htmlContentType,
+			wantBodyString:                    "",
+			wantRedirectLocationRegexp:        happyAuthcodeDownstreamRedirectLocationRegexp,
+			wantDownstreamIDTokenSubject:      happyLDAPDownstreamSubject,
+			wantDownstreamIDTokenUsername:     happyLDAPUsernameFromAuthenticator,
+			wantDownstreamIDTokenGroups:       happyLDAPGroups,
+			wantDownstreamRequestedScopes:     happyDownstreamScopesRequested,
+			wantDownstreamRedirectURI:         downstreamRedirectURI,
+			wantDownstreamGrantedScopes:       happyDownstreamScopesGranted,
+			wantDownstreamNonce:               downstreamNonce,
+			wantDownstreamClient:              downstreamPinnipedCLIClientID,
+			wantDownstreamPKCEChallenge:       downstreamPKCEChallenge,
+			wantDownstreamPKCEChallengeMethod: downstreamPKCEChallengeMethod,
+			wantDownstreamCustomSessionData:   expectedHappyLDAPUpstreamCustomSession,
+		},
+		{
+			name:                        "blank one-time password asks for the one-time password again",
+			idps:                        testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			totpEnrolledSubject:         happyLDAPDownstreamSubject,
+			decodedState:                happyLDAPDecodedState,
+			formParams:                  url.Values{totpStateParam: []string{happyTOTPState}},
+			wantStatus:                  http.StatusSeeOther,
+			wantContentType:             htmlContentType,
+			wantBodyString:              "",
+			wantRedirectToTOTPPage:      true,
+			wantRedirectToTOTPPageError: incorrectTOTPCodeErrParamValue,
+		},
+		{
+			name:                "one-time password with expired totp state",
+			idps:                testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			totpEnrolledSubject: happyLDAPDownstreamSubject,
+			decodedState:        happyLDAPDecodedState,
+			formParams: url.Values{totpStateParam: []string{encodeTOTPState(&totpLoginState{
+				Username:  happyLDAPUsername,
+				Subject:   happyLDAPDownstreamSubject,
+				StateHash: hashState(happyEncodedUpstreamState),
+				IssuedAt:  time.Now().Add(-6 * time.Minute),
+			})}, totpCodeParam: []string{validTOTPCode()}},
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: totpLoginExpiredErrParamValue,
+		},
+		{
+			name:                "one-time password with totp state from a different login",
+			idps:                testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			totpEnrolledSubject: happyLDAPDownstreamSubject,
+			decodedState:        happyLDAPDecodedState,
+			formParams: url.Values{totpStateParam: []string{encodeTOTPState(&totpLoginState{
+				Username:  happyLDAPUsername,
+				Subject:   happyLDAPDownstreamSubject,
+				StateHash: hashState("some-other-encoded-state"),
+				IssuedAt:  time.Now(),
+			})}, totpCodeParam: []string{validTOTPCode()}},
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: totpLoginExpiredErrParamValue,
+		},
+		{
+			name:                         "one-time password with totp state which cannot be decoded",
+			idps:                         testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			totpEnrolledSubject:          happyLDAPDownstreamSubject,
+			decodedState:                 happyLDAPDecodedState,
+			formParams:                   url.Values{totpStateParam: []string{"this-is-not-a-valid-totp-state"}, totpCodeParam: []string{validTOTPCode()}},
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: totpLoginExpiredErrParamValue,
+		},
+		{
+			name:                "one-time password when the user can no longer be found in the upstream",
+			idps:                testidplister.NewUpstreamIDPListerBuilder().WithLDAP(upstreamLDAPIdentityProviderWithTOTPPolicy(totp.PolicyRequired)),
+			totpEnrolledSubject: "some-other-subject",
+			decodedState:        happyLDAPDecodedState,
+			formParams: url.Values{totpStateParam: []string{encodeTOTPState(&totpLoginState{
+				Username:  "some-deleted-user",
+				Subject:   "some-other-subject",
+				StateHash: hashState(happyEncodedUpstreamState),
+				IssuedAt:  time.Now(),
+			})}, totpCodeParam: []string{validTOTPCode()}},
+			wantStatus:                   http.StatusSeeOther,
+			wantContentType:              htmlContentType,
+			wantBodyString:               "",
+			wantRedirectToLoginPageError: badUserPassErrParamValue,
+		},
 		{
 			name:         "no upstream providers are configured or provider cannot be found by name",
 			idps:         testidplister.NewUpstreamIDPListerBuilder(), // empty
@@ -1146,7 +1583,43 @@ func TestPostLoginEndpoint(t *testing.T) {
 
 			rsp := httptest.NewRecorder()
 
-			subject := NewPostHandler(downstreamIssuer, tt.idps.BuildFederationDomainIdentityProvidersListerFinder(), oauthHelper)
+			// The TOTP enrollments use their own fake clientset, so they do not change the actions which the test cases
+			// expect to be performed on the fake clientset used by the session storage.
+			totpEnrollments := totp.NewEnrollments(downstreamIssuer, fake.NewSimpleClientset().CoreV1().Secrets("some-namespace"),
+				func() []byte { return totpEncryptionKey }, rand.Reader, time.Now)
+			if tt.totpEnrolledSubject != "" {
+				require.NoError(t, totpEnrollments.Enroll(context.Background(), tt.totpEnrolledSubject, happyTOTPSecret, 0))
+			}
+
+			// The login limiter also uses its own fake clientset.
+			loginLimiter := loginlimiter.New(downstreamIssuer, fake.NewSimpleClientset().CoreV1().Secrets("some-namespace"),
+				loginlimiter.Config{
+					PerUsername: loginlimiter.Limit{AllowedFailures: 2, InitialBackoff: time.Minute, MaxBackoff: time.Hour},
+					PerSourceIP: loginlimiter.Limit{AllowedFailures: 100, InitialBackoff: time.Minute, MaxBackoff: time.Hour},
+				}, time.Now)
+			submittedUsername := tt.formParams.Get(userParam)
+			if tt.formParams.Has(totpStateParam) {
+				// The one-time password is submitted without the username, which is in the TOTP state.
+				submittedUsername = happyLDAPUsername
+			}
+			for range tt.priorFailedLogins {
+				reservation, wait, err := loginLimiter.Reserve(context.Background(), req, submittedUsername)
+				require.NoError(t, err)
+				require.Zero(t, wait)
+				require.NoError(t, reservation.RecordFailure(context.Background()))
+			}
+
+			consentGrants := consentgrants.New(downstreamIssuer, secretsClient, rand.Reader, time.Now)
+			if tt.consentGrantedScopes != nil {
+				require.NoError(t, consentGrants.Grant(context.Background(), downstreamDynamicClientID, downstreamDynamicClientUID,
+					upstreamLDAPURL+"&idpName="+ldapUpstreamName+"&sub="+happyLDAPUID, happyLDAPUsernameFromAuthenticator,
+					tt.consentGrantedScopes, time.Now()))
+				// The test cases only want to see the Secrets which are created by the handler.
+				kubeClient.ClearActions()
+			}
+
+			subject := NewPostHandler(downstreamIssuer, tt.idps.BuildFederationDomainIdentityProvidersListerFinder(), oauthHelper,
+				totpEnrollments, totpStateCodec, loginLimiter, consentGrants)
 
 			err := subject(rsp, req, happyEncodedUpstreamState, tt.decodedState)
 			if tt.wantErr != "" {
@@ -1157,6 +1630,14 @@ func TestPostLoginEndpoint(t *testing.T) {
 			// Otherwise, expect no error.
 			require.NoError(t, err)
 
+			if submittedUsername != "" {
+				// The handler must have released its reservation, so another attempt is only refused when blocked.
+				reservation, wait, err := loginLimiter.Reserve(context.Background(), req, submittedUsername)
+				require.NoError(t, err)
+				require.Equal(t, tt.wantLoginBlockedAfterRequest, wait > 0)
+				require.NoError(t, reservation.Release(context.Background()))
+			}
+
 			require.Equal(t, tt.wantStatus, rsp.Code)
 			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), tt.wantContentType)
 
@@ -1187,6 +1668,21 @@ func TestPostLoginEndpoint(t *testing.T) {
 					tt.wantDownstreamCustomSessionData,
 					map[string]any{},
 				)
+			case tt.wantRedirectToConsentPage:
+				// Expecting a redirect to the consent page, and the login to be waiting for the end user's consent.
+				require.Regexp(t, "^"+regexp.QuoteMeta(downstreamIssuer+oidc.ConsentEndpointPath+"?id=")+"[A-Za-z0-9_-]{43}$", actualLocation)
+				pendingSecrets, err := secretsClient.List(context.Background(), metav1.ListOptions{
+					LabelSelector: crud.SecretLabelKey + "=" + consentgrants.PendingTypeLabelValue,
+				})
+				require.NoError(t, err)
+				require.Len(t, pendingSecrets.Items, 1)
+				pending, err := consentgrants.ReadPendingFromSecret(&pendingSecrets.Items[0])
+				require.NoError(t, err)
+				require.Equal(t, tt.decodedState.AuthParams, pending.AuthParams)
+				require.Equal(t, tt.decodedState.CSRFToken, pending.CSRFToken)
+				require.Equal(t, happyDownstreamScopesGranted, pending.GrantedScopes)
+				require.Equal(t, happyLDAPUsernameFromAuthenticator, pending.Session.Custom.Username)
+				testutil.RequireNumberOfSecretsMatchingLabelSelector(t, secretsClient, labels.Set{crud.SecretLabelKey: "authcode"}, 0)
 			case tt.wantRedirectToLoginPageError != "":
 				// Expecting an error redirect to the login UI page.
 				require.Equal(t, tt.wantBodyString, rsp.Body.String())
@@ -1194,6 +1690,21 @@ func TestPostLoginEndpoint(t *testing.T) {
 					"?err=" + tt.wantRedirectToLoginPageError + "&state=" + happyEncodedUpstreamState
 				require.Equal(t, expectedLocation, actualLocation)
 				require.Len(t, oidctestutil.FilterClientSecretCreateActions(kubeClient.Actions()), tt.wantUnnecessaryStoredRecords)
+			case tt.wantRedirectToTOTPPage:
+				// Expecting a redirect to the page which asks for the one-time password.
+				require.Equal(t, tt.wantBodyString, rsp.Body.String())
+				parsedLocation, err := url.Parse(actualLocation)
+				require.NoError(t, err)
+				require.Equal(t, downstreamIssuer+oidc.PinnipedLoginPath, parsedLocation.Scheme+"://"+parsedLocation.Host+parsedLocation.Path)
+				require.Equal(t, tt.wantRedirectToTOTPPageError, parsedLocation.Query().Get("err"))
+				require.Equal(t, happyEncodedUpstreamState, parsedLocation.Query().Get("state"))
+				var decodedTOTPState totpLoginState
+				require.NoError(t, totpStateCodec.Decode(oidc.TOTPLoginStateEncodingName, parsedLocation.Query().Get(totpStateParam), &decodedTOTPState))
+				require.Equal(t, happyLDAPUsername, decodedTOTPState.Username)
+				require.Equal(t, happyLDAPDownstreamSubject, decodedTOTPState.Subject)
+				require.Equal(t, hashState(happyEncodedUpstreamState), decodedTOTPState.StateHash)
+				require.WithinDuration(t, time.Now(), decodedTOTPState.IssuedAt, time.Minute)
+				require.Empty(t, oidctestutil.FilterClientSecretCreateActions(kubeClient.Actions()))
 			case tt.wantRedirectLocationString != "":
 				// Expecting an error redirect to the client.
 				require.Equal(t, tt.wantBodyString, rsp.Body.String())
@@ -10,14 +10,23 @@ import (
 )
 
 const (
-	UsernameParamName = "username"
-	PasswordParamName = "password"
-	StateParamName    = "state"
-	ErrParamName      = "err"
-
-	ShowNoError        ErrorParamValue = ""
-	ShowInternalError  ErrorParamValue = "internal_error"
-	ShowBadUserPassErr ErrorParamValue = "login_error"
+	UsernameParamName  = "username"
+	PasswordParamName  = "password"
+	StateParamName     = "state"
+	ErrParamName       = "err"
+	TOTPCodeParamName  = "totp_code"
+	TOTPStateParamName = "totp_state"
+
+	ShowNoError                   ErrorParamValue = ""
+	ShowInternalError             ErrorParamValue = "internal_error"
+	ShowBadUserPassErr            ErrorParamValue = "login_error"
+	ShowPasswordExpiredErr        ErrorParamValue = "password_expired"
+	ShowPasswordMustChangeErr     ErrorParamValue = "password_must_change"
+	ShowAccountLockedErr          ErrorParamValue = "account_locked"
+	ShowTOTPEnrollmentRequiredErr ErrorParamValue = "totp_enrollment_required"
+	ShowTOTPLoginExpiredErr       ErrorParamValue = "totp_login_expired"
+	ShowIncorrectTOTPCodeErr      ErrorParamValue = "totp_error"
+	ShowTooManyLoginAttemptsErr   ErrorParamValue = "too_many_attempts"
 )
 
 type ErrorParamValue string
@@ -44,3 +53,27 @@ func URL(
 
 	return loginURL.String(), nil
 }
+
+// TOTPURL returns the URL for the GET /login page of the specified issuer, for the step of the login which asks for
+// the one-time password after the username and password were accepted.
+func TOTPURL(
+	downstreamIssuer string,
+	encodedStateParamValue string,
+	encodedTOTPStateParamValue string,
+	errToDisplay ErrorParamValue,
+) (string, error) {
+	loginURL, err := URL(downstreamIssuer, encodedStateParamValue, errToDisplay)
+	if err != nil {
+		return "", err
+	}
+
+	parsedURL, err := url.Parse(loginURL)
+	if err != nil {
+		return "", err
+	}
+	q := parsedURL.Query()
+	q.Set(TOTPStateParamName, encodedTOTPStateParamValue)
+	parsedURL.RawQuery = q.Encode()
+
+	return parsedURL.String(), nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package par provides a handler for the OAuth 2.0 pushed authorization request endpoint (RFC 9126).
+package par
+
+import (
+	"net/http"
+
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/internal/federationdomain/oidc"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/psession"
+)
+
+// NewHandler returns a handler which validates and saves the authorization request parameters which a client
+// pushes directly to the Supervisor. The client then starts the authorization request at the authorization
+// endpoint using only its client_id and the returned request_uri.
+func NewHandler(issuerURL string, oauthHelper fosite.OAuth2Provider) http.Handler {
+	return httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		// Read the request object before fosite copies its claims into the request form.
+		requestObject := r.FormValue(oidc.RequestObjectParamName)
+
+		pushedRequest, err := oauthHelper.NewPushedAuthorizeRequest(r.Context(), r)
+		if err == nil {
+			err = oidc.ValidateRequestObject(requestObject, pushedRequest, issuerURL)
+		}
+		if err != nil {
+			plog.Info("pushed authorization request error", oidc.FositeErrorForLog(err)...)
+			oauthHelper.WritePushedAuthorizeError(r.Context(), w, pushedRequest, err)
+			return nil
+		}
+
+		// The session is empty because the end user has not logged in yet. The authorization endpoint will
+		// replace it after the end user logs in.
+		response, err := oauthHelper.NewPushedAuthorizeResponse(r.Context(), pushedRequest, psession.NewPinnipedSession())
+		if err != nil {
+			plog.Info("pushed authorization response error", oidc.FositeErrorForLog(err)...)
+			oauthHelper.WritePushedAuthorizeError(r.Context(), w, pushedRequest, err)
+			return nil
+		}
+
+		oauthHelper.WritePushedAuthorizeResponse(r.Context(), w, pushedRequest, response)
+		return nil
+	})
+}
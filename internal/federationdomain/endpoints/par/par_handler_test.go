@@ -0,0 +1,233 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package par
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	supervisorfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	"go.pinniped.dev/internal/federationdomain/endpoints/jwks"
+	"go.pinniped.dev/internal/federationdomain/oidc"
+	"go.pinniped.dev/internal/federationdomain/oidcclientvalidator"
+	"go.pinniped.dev/internal/federationdomain/storage"
+	"go.pinniped.dev/internal/testutil"
+)
+
+const (
+	downstreamIssuer           = "https://my-downstream-issuer.com/path"
+	downstreamRedirectURI      = "http://127.0.0.1/callback"
+	downstreamDynamicClientID  = "client.oauth.pinniped.dev-test-name"
+	downstreamDynamicClientUID = "fake-client-uid"
+
+	jsonContentType = "application/json; charset=utf-8"
+)
+
+func TestPushedAuthorizationRequestHandler(t *testing.T) {
+	requestObjectSigningKey, requestObjectsConfig := testutil.NewRequestObjectSigningKey(t)
+
+	happyParams := func(overrides map[string]string) url.Values {
+		params := url.Values{
+			"response_type":         []string{"code"},
+			"scope":                 []string{"openid username groups"},
+			"client_id":             []string{"pinniped-cli"},
+			"state":                 []string{"8b-state"},
+			"nonce":                 []string{"some-nonce-value"},
+			"code_challenge":        []string{"n4bQgYhMfWWaL-qgxVrQFaO_TxsrC4Is0V1sFbDwCgg"},
+			"code_challenge_method": []string{"S256"},
+			"redirect_uri":          []string{downstreamRedirectURI},
+		}
+		for k, v := range overrides {
+			if v == "" {
+				params.Del(k)
+			} else {
+				params.Set(k, v)
+			}
+		}
+		return params
+	}
+
+	happyRequestObjectClaims := func(overrides map[string]any) map[string]any {
+		claims := map[string]any{
+			"iss":                   downstreamDynamicClientID,
+			"aud":                   downstreamIssuer,
+			"response_type":         "code",
+			"scope":                 "openid username groups",
+			"client_id":             downstreamDynamicClientID,
+			"state":                 "8b-state",
+			"nonce":                 "some-nonce-value",
+			"code_challenge":        "n4bQgYhMfWWaL-qgxVrQFaO_TxsrC4Is0V1sFbDwCgg",
+			"code_challenge_method": "S256",
+			"redirect_uri":          downstreamRedirectURI,
+		}
+		for k, v := range overrides {
+			claims[k] = v
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name              string
+		method            string
+		params            url.Values
+		basicAuthPassword string
+		wantStatus        int
+		wantBodyJSON      string
+		wantStored        bool
+	}{
+		{
+			name:       "happy path for the pinniped-cli client",
+			method:     http.MethodPost,
+			params:     happyParams(nil),
+			wantStatus: http.StatusCreated,
+			wantStored: true,
+		},
+		{
+			name:              "happy path for a dynamic client",
+			method:            http.MethodPost,
+			params:            happyParams(map[string]string{"client_id": downstreamDynamicClientID}),
+			basicAuthPassword: testutil.PlaintextPassword1,
+			wantStatus:        http.StatusCreated,
+			wantStored:        true,
+		},
+		{
+			name:   "happy path for a dynamic client using a request object",
+			method: http.MethodPost,
+			params: url.Values{
+				"client_id": []string{downstreamDynamicClientID},
+				"scope":     []string{"openid"},
+				"request":   []string{testutil.SignRequestObject(t, requestObjectSigningKey, happyRequestObjectClaims(nil))},
+			},
+			basicAuthPassword: testutil.PlaintextPassword1,
+			wantStatus:        http.StatusCreated,
+			wantStored:        true,
+		},
+		{
+			name:         "wrong HTTP method",
+			method:       http.MethodGet,
+			params:       happyParams(nil),
+			wantStatus:   http.StatusBadRequest,
+			wantBodyJSON: `{"error":"invalid_request","error_description":"The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed. HTTP method is 'GET', expected 'POST'."}`,
+		},
+		{
+			name:         "unknown client",
+			method:       http.MethodPost,
+			params:       happyParams(map[string]string{"client_id": "client.oauth.pinniped.dev-does-not-exist"}),
+			wantStatus:   http.StatusUnauthorized,
+			wantBodyJSON: `{"error":"invalid_client","error_description":"Client authentication failed (e.g., unknown client, no client authentication included, or unsupported authentication method)."}`,
+		},
+		{
+			name:              "dynamic client with the wrong client secret",
+			method:            http.MethodPost,
+			params:            happyParams(map[string]string{"client_id": downstreamDynamicClientID}),
+			basicAuthPassword: testutil.PlaintextPassword2,
+			wantStatus:        http.StatusUnauthorized,
+			wantBodyJSON:      `{"error":"invalid_client","error_description":"Client authentication failed (e.g., unknown client, no client authentication included, or unsupported authentication method)."}`,
+		},
+		{
+			name:         "request_uri param is not allowed",
+			method:       http.MethodPost,
+			params:       happyParams(map[string]string{"request_uri": oidc.PushedAuthorizationRequestURIPrefix + "some-request-uri"}),
+			wantStatus:   http.StatusBadRequest,
+			wantBodyJSON: `{"error":"invalid_request","error_description":"The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed. The request must not contain 'request_uri'."}`,
+		},
+		{
+			name:   "request object with the wrong issuer",
+			method: http.MethodPost,
+			params: url.Values{
+				"client_id": []string{downstreamDynamicClientID},
+				"scope":     []string{"openid"},
+				"request":   []string{testutil.SignRequestObject(t, requestObjectSigningKey, happyRequestObjectClaims(map[string]any{"iss": "some-other-client"}))},
+			},
+			basicAuthPassword: testutil.PlaintextPassword1,
+			wantStatus:        http.StatusBadRequest,
+			wantBodyJSON:      `{"error":"invalid_request_object","error_description":"The request parameter contains an invalid Request Object. The 'iss' claim of the request object must be the client ID."}`,
+		},
+		{
+			name:   "request object with the wrong audience",
+			method: http.MethodPost,
+			params: url.Values{
+				"client_id": []string{downstreamDynamicClientID},
+				"scope":     []string{"openid"},
+				"request":   []string{testutil.SignRequestObject(t, requestObjectSigningKey, happyRequestObjectClaims(map[string]any{"aud": "https://some-other-issuer.example.com"}))},
+			},
+			basicAuthPassword: testutil.PlaintextPassword1,
+			wantStatus:        http.StatusBadRequest,
+			wantBodyJSON:      `{"error":"invalid_request_object","error_description":"The request parameter contains an invalid Request Object. The 'aud' claim of the request object must contain the issuer."}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			supervisorClient := supervisorfake.NewSimpleClientset()
+			secrets := kubeClient.CoreV1().Secrets("some-namespace")
+
+			oidcClient, secret := testutil.FullyCapableOIDCClientAndStorageSecret(t,
+				"some-namespace", downstreamDynamicClientID, downstreamDynamicClientUID, downstreamRedirectURI, nil,
+				[]string{testutil.HashedPassword1AtGoMinCost}, oidcclientvalidator.Validate)
+			oidcClient.Spec.RequestObjects = requestObjectsConfig
+			require.NoError(t, supervisorClient.Tracker().Add(oidcClient))
+			require.NoError(t, kubeClient.Tracker().Add(secret))
+
+			// Configure fosite the same way that the production code would.
+			timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
+			// Use lower minimum required bcrypt cost than we would use in production to keep unit the tests fast.
+			kubeOauthStore := storage.NewKubeStorage(secrets, supervisorClient.ConfigV1alpha1().OIDCClients("some-namespace"), timeoutsConfiguration, bcrypt.MinCost)
+			hmacSecretFunc := func() []byte { return []byte("some secret - must have at least 32 bytes") }
+			jwksProviderIsUnused := jwks.NewDynamicJWKSProvider()
+			oauthHelper := oidc.FositeOauth2Helper(kubeOauthStore, downstreamIssuer, hmacSecretFunc, jwksProviderIsUnused, timeoutsConfiguration)
+
+			subject := NewHandler(downstreamIssuer, oauthHelper)
+
+			var req *http.Request
+			if test.method == http.MethodGet {
+				req = httptest.NewRequest(test.method, "/path/oauth2/par?"+test.params.Encode(), nil)
+			} else {
+				req = httptest.NewRequest(test.method, "/path/oauth2/par", strings.NewReader(test.params.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if test.basicAuthPassword != "" {
+				req.SetBasicAuth(url.QueryEscape(test.params.Get("client_id")), url.QueryEscape(test.basicAuthPassword))
+			}
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+			t.Logf("response body: %q", rsp.Body.String())
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), jsonContentType)
+
+			if test.wantBodyJSON != "" {
+				require.JSONEq(t, test.wantBodyJSON, rsp.Body.String())
+			} else {
+				var body struct {
+					RequestURI string `json:"request_uri"`
+					ExpiresIn  int    `json:"expires_in"`
+				}
+				require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &body))
+				require.True(t, strings.HasPrefix(body.RequestURI, oidc.PushedAuthorizationRequestURIPrefix), "unexpected request_uri %q", body.RequestURI)
+				require.Equal(t, 300, body.ExpiresIn)
+			}
+
+			storedRequests, err := secrets.List(context.Background(), metav1.ListOptions{
+				LabelSelector: "storage.pinniped.dev/type=pushed-authorization-request",
+			})
+			require.NoError(t, err)
+			if test.wantStored {
+				require.Len(t, storedRequests.Items, 1)
+			} else {
+				require.Empty(t, storedRequests.Items)
+			}
+		})
+	}
+}
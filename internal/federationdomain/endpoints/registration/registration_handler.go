@@ -0,0 +1,462 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package registration provides a handler for the OAuth 2.0 Dynamic Client Registration endpoint, which lets
+// applications create their own OIDCClients, as described by https://datatracker.ietf.org/doc/html/rfc7591.
+package registration
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	authenticationv1client "k8s.io/client-go/kubernetes/typed/authentication/v1"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	supervisorconfigv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
+	configv1alpha1clientset "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/typed/config/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/oidcclientvalidator"
+	"go.pinniped.dev/internal/oidcclientsecretstorage"
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	// RegisteredByAnnotation is the annotation of each registered OIDCClient which describes who registered it.
+	RegisteredByAnnotation = "supervisor.pinniped.dev/registered-by"
+
+	oidcClientNamePrefix = "client.oauth.pinniped.dev-"
+
+	tokenEndpointAuthMethodClientSecretBasic = "client_secret_basic"
+	responseTypeCode                         = "code"
+
+	errInvalidRedirectURI    = "invalid_redirect_uri"
+	errInvalidClientMetadata = "invalid_client_metadata"
+	errInvalidRequest        = "invalid_request"
+	errInvalidToken          = "invalid_token"
+	errInsufficientScope     = "insufficient_scope"
+	errServerError           = "server_error"
+
+	maxRequestBodyBytes = 64 * 1024
+)
+
+// redirectURIRegexp matches the redirect URIs which are allowed by the OIDCClient CRD.
+var redirectURIRegexp = regexp.MustCompile(`^https://.+|^http://(127\.0\.0\.1|\[::1\])(:\d+)?/`)
+
+// Clients are the Kubernetes clients which are used by the registration endpoint. Registrations may be handled
+// by any Supervisor pod, so these clients must be allowed to write when the pod is not the leader.
+type Clients struct {
+	OIDCClients          configv1alpha1clientset.OIDCClientInterface
+	Secrets              corev1client.SecretInterface
+	TokenReviews         authenticationv1client.TokenReviewInterface
+	SubjectAccessReviews authorizationv1client.SubjectAccessReviewInterface
+
+	// Namespace is the Supervisor's namespace, in which the OIDCClients are created.
+	Namespace string
+	// OIDCClientsAPIGroup is the API group of OIDCClients, including any API group suffix, which is used
+	// to check whether a service account may create OIDCClients.
+	OIDCClientsAPIGroup string
+}
+
+// clientMetadata is the subset of the client metadata from https://datatracker.ietf.org/doc/html/rfc7591#section-2
+// which is supported by OIDCClients. Any other metadata in a registration request is ignored.
+type clientMetadata struct {
+	RedirectURIs            []string `json:"redirect_uris"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+}
+
+// clientInformation is the response to a successful registration request, as described by
+// https://datatracker.ietf.org/doc/html/rfc7591#section-3.2.1.
+type clientInformation struct {
+	ClientID              string `json:"client_id"`
+	ClientSecret          string `json:"client_secret"`
+	ClientIDIssuedAt      int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt int64  `json:"client_secret_expires_at"`
+	clientMetadata
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type registrationError struct {
+	status      int
+	code        string
+	description string
+}
+
+func (e *registrationError) Error() string {
+	return e.code + ": " + e.description
+}
+
+func newRegistrationError(status int, code string, format string, args ...any) *registrationError {
+	return &registrationError{status: status, code: code, description: fmt.Sprintf(format, args...)}
+}
+
+// NewHandler returns a http.Handler that serves the registration endpoint of a FederationDomain. Each registration
+// request must be authenticated by a bearer token, which is either one of the initial access tokens from the Secret
+// with the given name, or a Kubernetes service account token of a service account which is allowed to create
+// OIDCClients in the Supervisor's namespace. The metadata of the new client is validated like any other OIDCClient.
+// The client secret is only returned in the response, and only its hash is stored.
+func NewHandler(
+	issuerURL string,
+	clients *Clients,
+	initialAccessTokensSecretName string,
+	bcryptCost int,
+	rand io.Reader,
+	clock func() time.Time,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed (try POST)", http.StatusMethodNotAllowed)
+			return
+		}
+
+		response, err := register(r, issuerURL, clients, initialAccessTokensSecretName, bcryptCost, rand, clock)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, response)
+	})
+}
+
+func register(
+	r *http.Request,
+	issuerURL string,
+	clients *Clients,
+	initialAccessTokensSecretName string,
+	bcryptCost int,
+	rand io.Reader,
+	clock func() time.Time,
+) (*clientInformation, error) {
+	registeredBy, err := authorize(r, issuerURL, clients, initialAccessTokensSecretName)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := readMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := generateRandomHex(rand, 16)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate client ID: %w", err)
+	}
+	oidcClient := &supervisorconfigv1alpha1.OIDCClient{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        oidcClientNamePrefix + name,
+			Namespace:   clients.Namespace,
+			Annotations: map[string]string{RegisteredByAnnotation: registeredBy},
+		},
+		Spec: supervisorconfigv1alpha1.OIDCClientSpec{
+			AllowedRedirectURIs: toRedirectURIs(metadata.RedirectURIs),
+			AllowedGrantTypes:   toGrantTypes(metadata.GrantTypes),
+			AllowedScopes:       toScopes(strings.Fields(metadata.Scope)),
+			// Registered clients are not trusted by the admin, so users must approve what each of them receives.
+			// Only an admin may relax this by editing the OIDCClient.
+			RequireConsent: true,
+		},
+	}
+	if valid, conditions := oidcclientvalidator.ValidateSpec(oidcClient); !valid {
+		messages := make([]string, 0, len(conditions))
+		for _, condition := range conditions {
+			if condition.Status != metav1.ConditionTrue {
+				messages = append(messages, condition.Message)
+			}
+		}
+		return nil, newRegistrationError(http.StatusBadRequest, errInvalidClientMetadata, "%s", strings.Join(messages, "; "))
+	}
+
+	secret, err := generateRandomHex(rand, 32)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate client secret: %w", err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcryptCost)
+	if err != nil {
+		return nil, fmt.Errorf("could not hash client secret: %w", err)
+	}
+
+	created, err := clients.OIDCClients.Create(r.Context(), oidcClient, metav1.CreateOptions{})
+	if err != nil {
+		if apierrors.IsInvalid(err) {
+			return nil, newRegistrationError(http.StatusBadRequest, errInvalidClientMetadata, "%s", err.Error())
+		}
+		return nil, fmt.Errorf("could not create OIDCClient: %w", err)
+	}
+
+	if err := oidcclientsecretstorage.New(clients.Secrets).Set(r.Context(), "", created.Name, created.UID, []string{string(hash)}); err != nil {
+		// Do not leave behind an OIDCClient without a client secret, which the registrant could never use.
+		if deleteErr := clients.OIDCClients.Delete(context.WithoutCancel(r.Context()), created.Name, metav1.DeleteOptions{}); deleteErr != nil {
+			plog.WarningErr("could not delete registered OIDCClient after failing to store its client secret", deleteErr,
+				"clientID", created.Name)
+		}
+		return nil, fmt.Errorf("could not store client secret: %w", err)
+	}
+
+	plog.Info("registered OIDCClient", "clientID", created.Name, "registeredBy", registeredBy)
+
+	return &clientInformation{
+		ClientID:              created.Name,
+		ClientSecret:          secret,
+		ClientIDIssuedAt:      clock().Unix(),
+		ClientSecretExpiresAt: 0, // never expires
+		clientMetadata:        *metadata,
+	}, nil
+}
+
+// authorize checks the bearer token of the request, and returns a description of who is registering the client.
+// Service account tokens must have been issued for the audience of the FederationDomain's issuer URL, so that a
+// token which was given to some other service cannot be replayed here.
+func authorize(r *http.Request, issuerURL string, clients *Clients, initialAccessTokensSecretName string) (string, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return "", newRegistrationError(http.StatusUnauthorized, errInvalidToken,
+			"registration requires an initial access token or a service account token as a bearer token")
+	}
+
+	if initialAccessTokensSecretName != "" {
+		tokenName, err := matchInitialAccessToken(r.Context(), clients.Secrets, initialAccessTokensSecretName, token)
+		if err != nil {
+			return "", err
+		}
+		if tokenName != "" {
+			return "initial access token " + tokenName, nil
+		}
+	}
+
+	tokenReview, err := clients.TokenReviews.Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: []string{issuerURL}},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not review token: %w", err)
+	}
+	userInfo := tokenReview.Status.User
+	if !tokenReview.Status.Authenticated || !strings.HasPrefix(userInfo.Username, serviceaccount.ServiceAccountUsernamePrefix) {
+		return "", newRegistrationError(http.StatusUnauthorized, errInvalidToken,
+			"the bearer token is neither an initial access token nor a valid service account token")
+	}
+	// Authenticators which do not support audiences may authenticate the token anyway, without returning the audience.
+	if !slices.Contains(tokenReview.Status.Audiences, issuerURL) {
+		return "", newRegistrationError(http.StatusUnauthorized, errInvalidToken,
+			"the service account token must have the audience %s", issuerURL)
+	}
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(userInfo.Extra))
+	for key, value := range userInfo.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	accessReview, err := clients.SubjectAccessReviews.Create(r.Context(), &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: clients.Namespace,
+				Verb:      "create",
+				Group:     clients.OIDCClientsAPIGroup,
+				Resource:  "oidcclients",
+			},
+			User:   userInfo.Username,
+			Groups: userInfo.Groups,
+			Extra:  extra,
+			UID:    userInfo.UID,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not review access: %w", err)
+	}
+	if !accessReview.Status.Allowed {
+		return "", newRegistrationError(http.StatusForbidden, errInsufficientScope,
+			"%s is not allowed to create OIDCClients in namespace %s", userInfo.Username, clients.Namespace)
+	}
+
+	return userInfo.Username, nil
+}
+
+// matchInitialAccessToken returns the key of the initial access token which matches the given token, or an empty
+// string when none matches.
+func matchInitialAccessToken(ctx context.Context, secrets corev1client.SecretInterface, secretName string, token string) (string, error) {
+	secret, err := secrets.Get(ctx, secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		plog.Warning("initial access tokens Secret of client registration was not found", "secretName", secretName)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not get initial access tokens: %w", err)
+	}
+
+	// Compare against every token, so the time taken does not reveal which one matched.
+	matched := ""
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		value := secret.Data[key]
+		if len(value) > 0 && subtle.ConstantTimeCompare(value, []byte(token)) == 1 && matched == "" {
+			matched = key
+		}
+	}
+	return matched, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// readMetadata reads the client metadata of the request, and applies the defaults from RFC 7591.
+func readMetadata(r *http.Request) (*clientMetadata, error) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return nil, newRegistrationError(http.StatusBadRequest, errInvalidRequest, "the request must have content type application/json")
+	}
+
+	metadata := &clientMetadata{}
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestBodyBytes)).Decode(metadata); err != nil {
+		return nil, newRegistrationError(http.StatusBadRequest, errInvalidClientMetadata, "could not read client metadata: %s", err.Error())
+	}
+
+	if len(metadata.RedirectURIs) == 0 {
+		return nil, newRegistrationError(http.StatusBadRequest, errInvalidRedirectURI, "redirect_uris is required")
+	}
+	for _, redirectURI := range metadata.RedirectURIs {
+		if !redirectURIRegexp.MatchString(redirectURI) {
+			return nil, newRegistrationError(http.StatusBadRequest, errInvalidRedirectURI,
+				"redirect URI %q must be an https URL, or an http URL of 127.0.0.1 or [::1]", redirectURI)
+		}
+	}
+
+	if metadata.TokenEndpointAuthMethod == "" {
+		metadata.TokenEndpointAuthMethod = tokenEndpointAuthMethodClientSecretBasic
+	}
+	if metadata.TokenEndpointAuthMethod != tokenEndpointAuthMethodClientSecretBasic {
+		return nil, newRegistrationError(http.StatusBadRequest, errInvalidClientMetadata,
+			"token_endpoint_auth_method must be %q", tokenEndpointAuthMethodClientSecretBasic)
+	}
+
+	if len(metadata.ResponseTypes) == 0 {
+		metadata.ResponseTypes = []string{responseTypeCode}
+	}
+	if slices.ContainsFunc(metadata.ResponseTypes, func(responseType string) bool { return responseType != responseTypeCode }) {
+		return nil, newRegistrationError(http.StatusBadRequest, errInvalidClientMetadata,
+			"response_types must only contain %q", responseTypeCode)
+	}
+
+	if len(metadata.GrantTypes) == 0 {
+		metadata.GrantTypes = []string{oidcapi.GrantTypeAuthorizationCode}
+	}
+	for _, grantType := range metadata.GrantTypes {
+		if grantType != oidcapi.GrantTypeAuthorizationCode &&
+			grantType != oidcapi.GrantTypeRefreshToken &&
+			grantType != oidcapi.GrantTypeTokenExchange {
+			return nil, newRegistrationError(http.StatusBadRequest, errInvalidClientMetadata, "grant type %q is not supported", grantType)
+		}
+	}
+
+	if metadata.Scope == "" {
+		metadata.Scope = defaultScope(metadata.GrantTypes)
+	}
+	for _, scope := range strings.Fields(metadata.Scope) {
+		if scope != oidcapi.ScopeOpenID &&
+			scope != oidcapi.ScopeOfflineAccess &&
+			scope != oidcapi.ScopeRequestAudience &&
+			scope != oidcapi.ScopeUsername &&
+			scope != oidcapi.ScopeGroups {
+			return nil, newRegistrationError(http.StatusBadRequest, errInvalidClientMetadata, "scope %q is not supported", scope)
+		}
+	}
+
+	return metadata, nil
+}
+
+// defaultScope returns the scopes which are needed for the given grant types.
+func defaultScope(grantTypes []string) string {
+	scopes := []string{oidcapi.ScopeOpenID}
+	if slices.Contains(grantTypes, oidcapi.GrantTypeRefreshToken) {
+		scopes = append(scopes, oidcapi.ScopeOfflineAccess)
+	}
+	if slices.Contains(grantTypes, oidcapi.GrantTypeTokenExchange) {
+		scopes = append(scopes, oidcapi.ScopeRequestAudience, oidcapi.ScopeUsername, oidcapi.ScopeGroups)
+	}
+	return strings.Join(scopes, " ")
+}
+
+func toRedirectURIs(values []string) []supervisorconfigv1alpha1.RedirectURI {
+	result := make([]supervisorconfigv1alpha1.RedirectURI, 0, len(values))
+	for _, value := range values {
+		result = append(result, supervisorconfigv1alpha1.RedirectURI(value))
+	}
+	return result
+}
+
+func toGrantTypes(values []string) []supervisorconfigv1alpha1.GrantType {
+	result := make([]supervisorconfigv1alpha1.GrantType, 0, len(values))
+	for _, value := range values {
+		result = append(result, supervisorconfigv1alpha1.GrantType(value))
+	}
+	return result
+}
+
+func toScopes(values []string) []supervisorconfigv1alpha1.Scope {
+	result := make([]supervisorconfigv1alpha1.Scope, 0, len(values))
+	for _, value := range values {
+		result = append(result, supervisorconfigv1alpha1.Scope(value))
+	}
+	return result
+}
+
+func generateRandomHex(rand io.Reader, numBytes int) (string, error) {
+	buf := make([]byte, numBytes)
+	if _, err := io.ReadFull(rand, buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var regErr *registrationError
+	if !errors.As(err, &regErr) {
+		plog.Error("client registration failed", err)
+		regErr = newRegistrationError(http.StatusInternalServerError, errServerError, "client registration failed")
+	}
+	if regErr.status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error=%q`, regErr.code))
+	}
+	writeJSON(w, regErr.status, &errorResponse{Error: regErr.code, ErrorDescription: regErr.description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	// The response may contain the client secret, so it must not be cached.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		plog.Error("could not write client registration response", err)
+	}
+}
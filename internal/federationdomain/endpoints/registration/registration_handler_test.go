@@ -0,0 +1,455 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package registration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	kubetesting "k8s.io/client-go/testing"
+
+	supervisorconfigv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+	supervisorfake "go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/fake"
+	"go.pinniped.dev/internal/oidcclientsecretstorage"
+)
+
+const (
+	namespace               = "some-namespace"
+	initialAccessSecretName = "some-initial-access-tokens"
+	initialAccessToken      = "some-initial-access-token"
+	issuerURL               = "https://issuer.example.com/some/path"
+	serviceAccountToken     = "some-service-account-token"
+	// The API server authenticates this token, but only for another audience.
+	wrongAudienceServiceAccountToken = "some-service-account-token-for-another-audience"
+	// An authenticator which does not support audiences authenticates this token without returning any audience.
+	noAudienceServiceAccountToken = "some-service-account-token-without-audience"
+	otherUserToken                = "some-other-user-token"
+	serviceAccountUsername        = "system:serviceaccount:some-app-namespace:some-app"
+	otherUsername                 = "some-human"
+	oidcClientsAPIGroup           = "config.supervisor.pinniped.dev"
+	oidcClientUID                 = types.UID("some-oidc-client-uid")
+
+	// The test random reader returns 0x01 bytes for the client ID and 0x02 bytes for the client secret.
+	wantClientID     = "client.oauth.pinniped.dev-01010101010101010101010101010101"
+	wantClientSecret = "0202020202020202020202020202020202020202020202020202020202020202"
+)
+
+func TestRegistrationHandler(t *testing.T) {
+	now := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	initialAccessTokensSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: initialAccessSecretName, Namespace: namespace},
+		Data: map[string][]byte{
+			"first-app":  []byte(initialAccessToken),
+			"second-app": []byte("another-initial-access-token"),
+		},
+	}
+
+	tests := []struct {
+		name                          string
+		method                        string
+		authorization                 string
+		contentType                   string
+		body                          string
+		initialAccessTokensSecretName string
+		sarAllowed                    bool
+		kubeResources                 func(t *testing.T, kubeClient *fake.Clientset)
+
+		wantStatus          int
+		wantWWWAuthenticate string
+		wantBodyJSON        string
+		wantRegisteredBy    string
+		wantSpec            *supervisorconfigv1alpha1.OIDCClientSpec
+		wantSAR             bool
+	}{
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:                "missing bearer token",
+			body:                `{"redirect_uris":["https://app.example.com/callback"]}`,
+			wantStatus:          http.StatusUnauthorized,
+			wantWWWAuthenticate: `Bearer error="invalid_token"`,
+			wantBodyJSON:        `{"error":"invalid_token","error_description":"registration requires an initial access token or a service account token as a bearer token"}`,
+		},
+		{
+			name:                "wrong authorization scheme",
+			authorization:       "Basic " + initialAccessToken,
+			body:                `{"redirect_uris":["https://app.example.com/callback"]}`,
+			wantStatus:          http.StatusUnauthorized,
+			wantWWWAuthenticate: `Bearer error="invalid_token"`,
+			wantBodyJSON:        `{"error":"invalid_token","error_description":"registration requires an initial access token or a service account token as a bearer token"}`,
+		},
+		{
+			name:                          "registered by an initial access token with default metadata",
+			authorization:                 "Bearer " + initialAccessToken,
+			body:                          `{"redirect_uris":["https://app.example.com/callback"],"client_name":"ignored"}`,
+			initialAccessTokensSecretName: initialAccessSecretName,
+			wantStatus:                    http.StatusCreated,
+			wantBodyJSON: `{
+				"client_id": "` + wantClientID + `",
+				"client_secret": "` + wantClientSecret + `",
+				"client_id_issued_at": 1893553445,
+				"client_secret_expires_at": 0,
+				"redirect_uris": ["https://app.example.com/callback"],
+				"token_endpoint_auth_method": "client_secret_basic",
+				"grant_types": ["authorization_code"],
+				"response_types": ["code"],
+				"scope": "openid"
+			}`,
+			wantRegisteredBy: "initial access token first-app",
+			wantSpec: &supervisorconfigv1alpha1.OIDCClientSpec{
+				AllowedRedirectURIs: []supervisorconfigv1alpha1.RedirectURI{"https://app.example.com/callback"},
+				AllowedGrantTypes:   []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+				AllowedScopes:       []supervisorconfigv1alpha1.Scope{"openid"},
+				RequireConsent:      true,
+			},
+		},
+		{
+			name:          "registered by an allowed service account with default scopes for the grant types",
+			authorization: "Bearer " + serviceAccountToken,
+			body: `{
+				"redirect_uris": ["http://127.0.0.1:1234/callback"],
+				"grant_types": ["authorization_code", "refresh_token", "urn:ietf:params:oauth:grant-type:token-exchange"]
+			}`,
+			sarAllowed: true,
+			wantStatus: http.StatusCreated,
+			wantBodyJSON: `{
+				"client_id": "` + wantClientID + `",
+				"client_secret": "` + wantClientSecret + `",
+				"client_id_issued_at": 1893553445,
+				"client_secret_expires_at": 0,
+				"redirect_uris": ["http://127.0.0.1:1234/callback"],
+				"token_endpoint_auth_method": "client_secret_basic",
+				"grant_types": ["authorization_code", "refresh_token", "urn:ietf:params:oauth:grant-type:token-exchange"],
+				"response_types": ["code"],
+				"scope": "openid offline_access pinniped:request-audience username groups"
+			}`,
+			wantRegisteredBy: serviceAccountUsername,
+			wantSpec: &supervisorconfigv1alpha1.OIDCClientSpec{
+				AllowedRedirectURIs: []supervisorconfigv1alpha1.RedirectURI{"http://127.0.0.1:1234/callback"},
+				AllowedGrantTypes: []supervisorconfigv1alpha1.GrantType{
+					"authorization_code", "refresh_token", "urn:ietf:params:oauth:grant-type:token-exchange",
+				},
+				AllowedScopes: []supervisorconfigv1alpha1.Scope{
+					"openid", "offline_access", "pinniped:request-audience", "username", "groups",
+				},
+				RequireConsent: true,
+			},
+			wantSAR: true,
+		},
+		{
+			name:                          "service account token when initial access tokens are also configured",
+			authorization:                 "Bearer " + serviceAccountToken,
+			body:                          `{"redirect_uris":["https://app.example.com/callback"],"scope":"openid username"}`,
+			initialAccessTokensSecretName: initialAccessSecretName,
+			sarAllowed:                    true,
+			wantStatus:                    http.StatusCreated,
+			wantRegisteredBy:              serviceAccountUsername,
+			wantSpec: &supervisorconfigv1alpha1.OIDCClientSpec{
+				AllowedRedirectURIs: []supervisorconfigv1alpha1.RedirectURI{"https://app.example.com/callback"},
+				AllowedGrantTypes:   []supervisorconfigv1alpha1.GrantType{"authorization_code"},
+				AllowedScopes:       []supervisorconfigv1alpha1.Scope{"openid", "username"},
+				RequireConsent:      true,
+			},
+			wantSAR: true,
+		},
+		{
+			name:                          "initial access tokens Secret does not exist",
+			authorization:                 "Bearer " + initialAccessToken,
+			body:                          `{"redirect_uris":["https://app.example.com/callback"]}`,
+			initialAccessTokensSecretName: "does-not-exist",
+			wantStatus:                    http.StatusUnauthorized,
+			wantWWWAuthenticate:           `Bearer error="invalid_token"`,
+			wantBodyJSON:                  `{"error":"invalid_token","error_description":"the bearer token is neither an initial access token nor a valid service account token"}`,
+		},
+		{
+			name:                "initial access token when no initial access tokens are configured",
+			authorization:       "Bearer " + initialAccessToken,
+			body:                `{"redirect_uris":["https://app.example.com/callback"]}`,
+			wantStatus:          http.StatusUnauthorized,
+			wantWWWAuthenticate: `Bearer error="invalid_token"`,
+			wantBodyJSON:        `{"error":"invalid_token","error_description":"the bearer token is neither an initial access token nor a valid service account token"}`,
+		},
+		{
+			name:                "token of a user who is not a service account",
+			authorization:       "Bearer " + otherUserToken,
+			body:                `{"redirect_uris":["https://app.example.com/callback"]}`,
+			sarAllowed:          true,
+			wantStatus:          http.StatusUnauthorized,
+			wantWWWAuthenticate: `Bearer error="invalid_token"`,
+			wantBodyJSON:        `{"error":"invalid_token","error_description":"the bearer token is neither an initial access token nor a valid service account token"}`,
+		},
+		{
+			name:                "service account token with the wrong audience",
+			authorization:       "Bearer " + wrongAudienceServiceAccountToken,
+			body:                `{"redirect_uris":["https://app.example.com/callback"]}`,
+			sarAllowed:          true,
+			wantStatus:          http.StatusUnauthorized,
+			wantWWWAuthenticate: `Bearer error="invalid_token"`,
+			wantBodyJSON:        `{"error":"invalid_token","error_description":"the bearer token is neither an initial access token nor a valid service account token"}`,
+		},
+		{
+			name:                "service account token which was authenticated without the audience",
+			authorization:       "Bearer " + noAudienceServiceAccountToken,
+			body:                `{"redirect_uris":["https://app.example.com/callback"]}`,
+			sarAllowed:          true,
+			wantStatus:          http.StatusUnauthorized,
+			wantWWWAuthenticate: `Bearer error="invalid_token"`,
+			wantBodyJSON:        `{"error":"invalid_token","error_description":"the service account token must have the audience https://issuer.example.com/some/path"}`,
+		},
+		{
+			name:          "service account which may not create OIDCClients",
+			authorization: "Bearer " + serviceAccountToken,
+			body:          `{"redirect_uris":["https://app.example.com/callback"]}`,
+			sarAllowed:    false,
+			wantStatus:    http.StatusForbidden,
+			wantBodyJSON:  `{"error":"insufficient_scope","error_description":"system:serviceaccount:some-app-namespace:some-app is not allowed to create OIDCClients in namespace some-namespace"}`,
+			wantSAR:       true,
+		},
+		{
+			name:          "wrong content type",
+			authorization: "Bearer " + serviceAccountToken,
+			contentType:   "application/x-www-form-urlencoded",
+			body:          `redirect_uris=https://app.example.com/callback`,
+			sarAllowed:    true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyJSON:  `{"error":"invalid_request","error_description":"the request must have content type application/json"}`,
+			wantSAR:       true,
+		},
+		{
+			name:          "body is not JSON",
+			authorization: "Bearer " + serviceAccountToken,
+			body:          `not json`,
+			sarAllowed:    true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyJSON:  `{"error":"invalid_client_metadata","error_description":"could not read client metadata: invalid character 'o' in literal null (expecting 'u')"}`,
+			wantSAR:       true,
+		},
+		{
+			name:          "missing redirect URIs",
+			authorization: "Bearer " + serviceAccountToken,
+			body:          `{"grant_types":["authorization_code"]}`,
+			sarAllowed:    true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyJSON:  `{"error":"invalid_redirect_uri","error_description":"redirect_uris is required"}`,
+			wantSAR:       true,
+		},
+		{
+			name:          "insecure redirect URI",
+			authorization: "Bearer " + serviceAccountToken,
+			body:          `{"redirect_uris":["http://app.example.com/callback"]}`,
+			sarAllowed:    true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyJSON:  `{"error":"invalid_redirect_uri","error_description":"redirect URI \"http://app.example.com/callback\" must be an https URL, or an http URL of 127.0.0.1 or [::1]"}`,
+			wantSAR:       true,
+		},
+		{
+			name:          "unsupported token endpoint auth method",
+			authorization: "Bearer " + serviceAccountToken,
+			body:          `{"redirect_uris":["https://app.example.com/callback"],"token_endpoint_auth_method":"none"}`,
+			sarAllowed:    true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyJSON:  `{"error":"invalid_client_metadata","error_description":"token_endpoint_auth_method must be \"client_secret_basic\""}`,
+			wantSAR:       true,
+		},
+		{
+			name:          "unsupported response type",
+			authorization: "Bearer " + serviceAccountToken,
+			body:          `{"redirect_uris":["https://app.example.com/callback"],"response_types":["code","token"]}`,
+			sarAllowed:    true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyJSON:  `{"error":"invalid_client_metadata","error_description":"response_types must only contain \"code\""}`,
+			wantSAR:       true,
+		},
+		{
+			name:          "unsupported grant type",
+			authorization: "Bearer " + serviceAccountToken,
+			body:          `{"redirect_uris":["https://app.example.com/callback"],"grant_types":["authorization_code","implicit"]}`,
+			sarAllowed:    true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyJSON:  `{"error":"invalid_client_metadata","error_description":"grant type \"implicit\" is not supported"}`,
+			wantSAR:       true,
+		},
+		{
+			name:          "unsupported scope",
+			authorization: "Bearer " + serviceAccountToken,
+			body:          `{"redirect_uris":["https://app.example.com/callback"],"scope":"openid email"}`,
+			sarAllowed:    true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyJSON:  `{"error":"invalid_client_metadata","error_description":"scope \"email\" is not supported"}`,
+			wantSAR:       true,
+		},
+		{
+			name:          "metadata which is not valid for an OIDCClient",
+			authorization: "Bearer " + serviceAccountToken,
+			body:          `{"redirect_uris":["https://app.example.com/callback"],"grant_types":["refresh_token"],"scope":"openid"}`,
+			sarAllowed:    true,
+			wantStatus:    http.StatusBadRequest,
+			wantBodyJSON: `{"error":"invalid_client_metadata","error_description":` +
+				`"\"authorization_code\" must always be included in \"allowedGrantTypes\"; ` +
+				`\"offline_access\" must be included in \"allowedScopes\" when \"refresh_token\" is included in \"allowedGrantTypes\""}`,
+			wantSAR: true,
+		},
+		{
+			name:          "failure to store the client secret deletes the OIDCClient",
+			authorization: "Bearer " + serviceAccountToken,
+			body:          `{"redirect_uris":["https://app.example.com/callback"]}`,
+			sarAllowed:    true,
+			kubeResources: func(t *testing.T, kubeClient *fake.Clientset) {
+				kubeClient.PrependReactor("create", "secrets", func(_ kubetesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("some secrets error")
+				})
+			},
+			wantStatus:   http.StatusInternalServerError,
+			wantBodyJSON: `{"error":"server_error","error_description":"client registration failed"}`,
+			wantSAR:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset(initialAccessTokensSecret)
+			supervisorClient := supervisorfake.NewSimpleClientset()
+
+			kubeClient.PrependReactor("create", "tokenreviews", func(action kubetesting.Action) (bool, runtime.Object, error) {
+				tokenReview := action.(kubetesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+				require.Equal(t, []string{issuerURL}, tokenReview.Spec.Audiences)
+				serviceAccountUser := authenticationv1.UserInfo{
+					Username: serviceAccountUsername,
+					UID:      "some-sa-uid",
+					Groups:   []string{"system:serviceaccounts", "system:authenticated"},
+				}
+				switch tokenReview.Spec.Token {
+				case serviceAccountToken:
+					tokenReview.Status = authenticationv1.TokenReviewStatus{
+						Authenticated: true,
+						User:          serviceAccountUser,
+						Audiences:     []string{issuerURL},
+					}
+				case wrongAudienceServiceAccountToken:
+					tokenReview.Status = authenticationv1.TokenReviewStatus{
+						Authenticated: false,
+						Error:         "token audiences [\"https://kubernetes.default.svc\"] is invalid for the target audiences",
+					}
+				case noAudienceServiceAccountToken:
+					tokenReview.Status = authenticationv1.TokenReviewStatus{
+						Authenticated: true,
+						User:          serviceAccountUser,
+					}
+				case otherUserToken:
+					tokenReview.Status = authenticationv1.TokenReviewStatus{
+						Authenticated: true,
+						User:          authenticationv1.UserInfo{Username: otherUsername},
+						Audiences:     []string{issuerURL},
+					}
+				}
+				return true, tokenReview, nil
+			})
+			var reviewedAccess *authorizationv1.SubjectAccessReview
+			kubeClient.PrependReactor("create", "subjectaccessreviews", func(action kubetesting.Action) (bool, runtime.Object, error) {
+				reviewedAccess = action.(kubetesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+				result := reviewedAccess.DeepCopy()
+				result.Status.Allowed = test.sarAllowed
+				return true, result, nil
+			})
+			supervisorClient.PrependReactor("create", "oidcclients", func(action kubetesting.Action) (bool, runtime.Object, error) {
+				// The fake clientset does not set UIDs, so set one here before the fake stores the object.
+				action.(kubetesting.CreateAction).GetObject().(*supervisorconfigv1alpha1.OIDCClient).UID = oidcClientUID
+				return false, nil, nil
+			})
+			if test.kubeResources != nil {
+				test.kubeResources(t, kubeClient)
+			}
+
+			clients := &Clients{
+				OIDCClients:          supervisorClient.ConfigV1alpha1().OIDCClients(namespace),
+				Secrets:              kubeClient.CoreV1().Secrets(namespace),
+				TokenReviews:         kubeClient.AuthenticationV1().TokenReviews(),
+				SubjectAccessReviews: kubeClient.AuthorizationV1().SubjectAccessReviews(),
+				Namespace:            namespace,
+				OIDCClientsAPIGroup:  oidcClientsAPIGroup,
+			}
+			randReader := bytes.NewReader(append(bytes.Repeat([]byte{0x01}, 16), bytes.Repeat([]byte{0x02}, 32)...))
+			subject := NewHandler(issuerURL, clients, test.initialAccessTokensSecretName, bcrypt.MinCost, randReader, func() time.Time { return now })
+
+			method := test.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, "/some/path/oauth2/register", strings.NewReader(test.body))
+			contentType := test.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			req.Header.Set("Content-Type", contentType)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			rsp := httptest.NewRecorder()
+			subject.ServeHTTP(rsp, req)
+
+			require.Equal(t, test.wantStatus, rsp.Code, rsp.Body.String())
+			require.Equal(t, test.wantWWWAuthenticate, rsp.Header().Get("WWW-Authenticate"))
+			if test.wantBodyJSON != "" {
+				require.Equal(t, "application/json", rsp.Header().Get("Content-Type"))
+				require.Equal(t, "no-store", rsp.Header().Get("Cache-Control"))
+				require.JSONEq(t, test.wantBodyJSON, rsp.Body.String())
+			}
+
+			if test.wantSAR {
+				require.NotNil(t, reviewedAccess)
+				require.Equal(t, authorizationv1.SubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace: namespace,
+						Verb:      "create",
+						Group:     oidcClientsAPIGroup,
+						Resource:  "oidcclients",
+					},
+					User:   serviceAccountUsername,
+					Groups: []string{"system:serviceaccounts", "system:authenticated"},
+					Extra:  map[string]authorizationv1.ExtraValue{},
+					UID:    "some-sa-uid",
+				}, reviewedAccess.Spec)
+			} else {
+				require.Nil(t, reviewedAccess)
+			}
+
+			oidcClients, err := supervisorClient.ConfigV1alpha1().OIDCClients(namespace).List(context.Background(), metav1.ListOptions{})
+			require.NoError(t, err)
+			if test.wantSpec == nil {
+				require.Empty(t, oidcClients.Items)
+				return
+			}
+
+			require.Len(t, oidcClients.Items, 1)
+			oidcClient := oidcClients.Items[0]
+			require.Equal(t, wantClientID, oidcClient.Name)
+			require.Equal(t, map[string]string{RegisteredByAnnotation: test.wantRegisteredBy}, oidcClient.Annotations)
+			require.Equal(t, *test.wantSpec, oidcClient.Spec)
+
+			// Only the hash of the client secret is stored.
+			_, hashes, err := oidcclientsecretstorage.New(clients.Secrets).Get(context.Background(), oidcClientUID)
+			require.NoError(t, err)
+			require.Len(t, hashes, 1)
+			require.NoError(t, bcrypt.CompareHashAndPassword([]byte(hashes[0]), []byte(wantClientSecret)))
+		})
+	}
+}
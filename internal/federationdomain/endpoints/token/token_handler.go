@@ -19,6 +19,9 @@ import (
 	"k8s.io/apiserver/pkg/warning"
 
 	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
+	"go.pinniped.dev/internal/federationdomain/clientregistry"
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
+	"go.pinniped.dev/internal/federationdomain/dpop"
 	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
 	"go.pinniped.dev/internal/federationdomain/idtokenlifespan"
 	"go.pinniped.dev/internal/federationdomain/oidc"
@@ -31,10 +34,13 @@ import (
 )
 
 func NewHandler(
+	issuerURL string,
 	idpLister federationdomainproviders.FederationDomainIdentityProvidersListerI,
 	oauthHelper fosite.OAuth2Provider,
 	overrideAccessTokenLifespan timeouts.OverrideLifespan,
 	overrideIDTokenLifespan timeouts.OverrideLifespan,
+	consentGrants *consentgrants.Store,
+	dpopReplays *dpop.ReplayCache,
 ) http.Handler {
 	return httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
 		session := psession.NewPinnipedSession()
@@ -45,12 +51,30 @@ func NewHandler(
 			return nil
 		}
 
+		// Validate the client's DPoP proof, if any, before issuing any tokens. See RFC 9449.
+		dpopThumbprint, err := dpop.ValidateProof(r, issuerURL+oidc.TokenEndpointPath, time.Now(), dpopReplays)
+		if err == nil {
+			err = bindToDPoPKey(accessRequest, dpopThumbprint)
+		}
+		if err != nil {
+			plog.Info("DPoP proof error", oidc.FositeErrorForLog(err)...)
+			oauthHelper.WriteAccessError(r.Context(), w, accessRequest, err)
+			return nil
+		}
+
 		// Check if we are performing a refresh grant.
 		if accessRequest.GetGrantTypes().ExactOne(oidcapi.GrantTypeRefreshToken) {
 			// The above call to NewAccessRequest has loaded the session from storage into the accessRequest variable.
 			// The session, requested scopes, and requested audience from the original authorize request was retrieved
 			// from the Kube storage layer and added to the accessRequest. Additionally, the audience and scopes may
 			// have already been granted on the accessRequest.
+			err = checkConsent(r.Context(), accessRequest, consentGrants)
+			if err != nil {
+				plog.Info("consent check error", oidc.FositeErrorForLog(err)...)
+				oauthHelper.WriteAccessError(r.Context(), w, accessRequest, err)
+				return nil
+			}
+
 			err = upstreamRefresh(r.Context(), accessRequest, idpLister)
 			if err != nil {
 				plog.Info("upstream refresh error", oidc.FositeErrorForLog(err)...)
@@ -79,8 +103,9 @@ func NewHandler(
 		// The lifetime of the ID token will be determined inside the call NewAccessResponse.
 		// Depending on the request, sometimes override the default ID token lifespan by putting
 		// the override value onto the context.
+		// The thumbprint of the DPoP proof is also put onto the context for the token exchange handler.
 		accessResponse, err := oauthHelper.NewAccessResponse(
-			maybeOverrideDefaultIDTokenLifetime(r.Context(), overrideIDTokenLifespan, accessRequest),
+			dpop.WithThumbprint(maybeOverrideDefaultIDTokenLifetime(r.Context(), overrideIDTokenLifespan, accessRequest), dpopThumbprint),
 			accessRequest)
 		if err != nil {
 			plog.Info("token response error", oidc.FositeErrorForLog(err)...)
@@ -88,12 +113,53 @@ func NewHandler(
 			return nil
 		}
 
+		if isBoundToDPoPKey(accessRequest) {
+			accessResponse.SetTokenType(dpop.TokenType)
+		}
+
 		oauthHelper.WriteAccessResponse(r.Context(), w, accessRequest, accessResponse)
 
 		return nil
 	})
 }
 
+// bindToDPoPKey binds the tokens which will be issued by an authorization code grant to the key which signed the
+// client's DPoP proof, if any. For a refresh grant, it checks that a refresh token which was bound to a key is
+// only used along with a DPoP proof signed by that key.
+func bindToDPoPKey(accessRequest fosite.AccessRequester, proofThumbprint string) error {
+	grantTypes := accessRequest.GetGrantTypes()
+	if !grantTypes.ExactOne(oidcapi.GrantTypeAuthorizationCode) && !grantTypes.ExactOne(oidcapi.GrantTypeRefreshToken) {
+		// Token exchanges check the binding of their subject token in the token exchange handler.
+		return nil
+	}
+
+	session := accessRequest.GetSession().(*psession.PinnipedSession)
+
+	if grantTypes.ExactOne(oidcapi.GrantTypeRefreshToken) {
+		if session.Custom == nil {
+			// The upstream refresh will reject this session.
+			return nil
+		}
+		return dpop.CheckBinding(session.Custom.DPoPJWKThumbprint, proofThumbprint)
+	}
+
+	if proofThumbprint == "" {
+		return nil
+	}
+	if session.Custom == nil {
+		return errorsx.WithStack(errMissingUpstreamSessionInternalError())
+	}
+	// The new access and refresh tokens will be stored along with a copy of this session.
+	session.Custom.DPoPJWKThumbprint = proofThumbprint
+	return nil
+}
+
+func isBoundToDPoPKey(accessRequest fosite.AccessRequester) bool {
+	session, ok := accessRequest.GetSession().(*psession.PinnipedSession)
+	return ok && session.Custom != nil && session.Custom.DPoPJWKThumbprint != "" &&
+		!accessRequest.GetGrantTypes().ExactOne(oidcapi.GrantTypeTokenExchange)
+}
+
 func maybeOverrideDefaultAccessTokenLifetime(overrideAccessTokenLifespan timeouts.OverrideLifespan, accessRequest fosite.AccessRequester) {
 	if newLifespan, doOverride := overrideAccessTokenLifespan(accessRequest); doOverride {
 		accessRequest.GetSession().SetExpiresAt(fosite.AccessToken, time.Now().UTC().Add(newLifespan).Round(time.Second))
@@ -124,6 +190,26 @@ func errUpstreamRefreshError() *fosite.RFC6749Error {
 	}
 }
 
+// checkConsent rejects the refresh when the client requires consent and the end user's consent to the granted scopes
+// was revoked after the initial login, either by the end user or by an administrator.
+func checkConsent(ctx context.Context, accessRequest fosite.AccessRequester, consentGrants *consentgrants.Store) error {
+	client := accessRequest.GetClient()
+	if !clientregistry.RequiresConsent(client) {
+		return nil
+	}
+
+	session := accessRequest.GetSession().(*psession.PinnipedSession)
+	granted, err := consentGrants.IsGranted(ctx, client.GetID(), clientregistry.UID(client),
+		session.Fosite.Claims.Subject, accessRequest.GetGrantedScopes())
+	if err != nil {
+		return fosite.ErrServerError.WithHint("Could not check consent.").WithWrap(err)
+	}
+	if !granted {
+		return errUpstreamRefreshError().WithHint("The end user's consent for this client was revoked.")
+	}
+	return nil
+}
+
 func upstreamRefresh(
 	ctx context.Context,
 	accessRequest fosite.AccessRequester,
@@ -203,11 +289,19 @@ func upstreamRefresh(
 		refreshedIdentity.UpstreamGroups = oldUntransformedGroups
 	}
 
+	// Group enrichment may use the additional claims of the user to find them in another identity provider.
+	// When the idp did not refresh the additional claims, then use the old value from the user's session.
+	additionalClaims := refreshedIdentity.DownstreamAdditionalClaims
+	if additionalClaims == nil {
+		additionalClaims, _ = session.Fosite.Claims.Extra[oidcapi.IDTokenClaimAdditionalClaims].(map[string]any)
+	}
+
 	refreshedTransformedGroups, err := applyIdentityTransformationsDuringRefresh(ctx,
 		idp.GetTransforms(),
 		oldTransformedUsername, // this function validates that the old and new transformed usernames match
 		refreshedIdentity.UpstreamUsername,
 		refreshedIdentity.UpstreamGroups,
+		additionalClaims,
 		session.Custom.ProviderName,
 		session.Custom.ProviderType,
 	)
@@ -215,12 +309,27 @@ func upstreamRefresh(
 		return err
 	}
 
+	// The client's restrictions may have changed since the initial login, so check them again using the current client.
+	if err := clientregistry.AdmitIdentity(accessRequest.GetClient(), idp.GetDisplayName(), oldTransformedUsername, refreshedTransformedGroups); err != nil {
+		return errUpstreamRefreshError().WithHintf("Upstream refresh rejected by client: %s.", err.Error()).
+			WithDebugf("provider name: %q, provider type: %q", session.Custom.ProviderName, session.Custom.ProviderType)
+	}
+
 	if !skipGroups {
 		warnIfGroupsChanged(ctx, oldTransformedGroups, refreshedTransformedGroups, oldTransformedUsername, accessRequest.GetClient().GetID())
 		// Replace the old value for the downstream groups in the user's session with the new value.
 		session.Fosite.Claims.Extra[oidcapi.IDTokenClaimGroups] = refreshedTransformedGroups
 	}
 
+	// If the idp refreshed the additional claims, then replace the old value in the user's session with the new value.
+	if refreshedIdentity.DownstreamAdditionalClaims != nil {
+		if len(refreshedIdentity.DownstreamAdditionalClaims) > 0 {
+			session.Fosite.Claims.Extra[oidcapi.IDTokenClaimAdditionalClaims] = refreshedIdentity.DownstreamAdditionalClaims
+		} else {
+			delete(session.Fosite.Claims.Extra, oidcapi.IDTokenClaimAdditionalClaims)
+		}
+	}
+
 	return nil
 }
 
@@ -262,10 +371,11 @@ func applyIdentityTransformationsDuringRefresh(
 	oldTransformedUsername string,
 	upstreamUsername string,
 	upstreamGroups []string,
+	additionalClaims map[string]any,
 	providerName string,
 	providerType psession.ProviderType,
 ) ([]string, error) {
-	transformationResult, err := transforms.Evaluate(ctx, upstreamUsername, upstreamGroups)
+	transformationResult, err := transforms.Evaluate(ctx, upstreamUsername, upstreamGroups, additionalClaims)
 	if err != nil {
 		return nil, errUpstreamRefreshError().WithHintf(
 			"Upstream refresh error while applying configured identity transformations.").
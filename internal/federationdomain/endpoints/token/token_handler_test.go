@@ -5,6 +5,7 @@ package token
 
 import (
 	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -46,6 +47,8 @@ import (
 	"go.pinniped.dev/internal/celtransformer"
 	"go.pinniped.dev/internal/crud"
 	"go.pinniped.dev/internal/federationdomain/clientregistry"
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
+	"go.pinniped.dev/internal/federationdomain/dpop"
 	"go.pinniped.dev/internal/federationdomain/endpoints/jwks"
 	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
 	"go.pinniped.dev/internal/federationdomain/oidc"
@@ -66,6 +69,7 @@ import (
 	"go.pinniped.dev/internal/testutil/oidctestutil"
 	"go.pinniped.dev/internal/testutil/testidplister"
 	"go.pinniped.dev/internal/testutil/transformtestutil"
+	clientdpop "go.pinniped.dev/pkg/oidcclient/dpop"
 	"go.pinniped.dev/pkg/oidcclient/oidctypes"
 )
 
@@ -355,6 +359,38 @@ func addFullyCapableDynamicClientWithCustomIDTokenLifetimeAndSecretToKubeResourc
 	}
 }
 
+func addDynamicClientWhichRequiresConsentAndSecretToKubeResources(t *testing.T, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset) {
+	oidcClient, secret := testutil.FullyCapableOIDCClientAndStorageSecret(t,
+		"some-namespace",
+		dynamicClientID,
+		dynamicClientUID,
+		goodRedirectURI,
+		nil, // no custom ID token lifetime
+		[]string{testutil.HashedPassword1AtGoMinCost, testutil.HashedPassword2AtGoMinCost},
+		oidcclientvalidator.Validate,
+	)
+	oidcClient.Spec.RequireConsent = true
+	require.NoError(t, supervisorClient.Tracker().Add(oidcClient))
+	require.NoError(t, kubeClient.Tracker().Add(secret))
+}
+
+func addDynamicClientWithTokenExchangeConfigAndSecretToKubeResources(tokenExchange *supervisorconfigv1alpha1.OIDCClientTokenExchange) func(t *testing.T, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset) {
+	return func(t *testing.T, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset) {
+		oidcClient, secret := testutil.FullyCapableOIDCClientAndStorageSecret(t,
+			"some-namespace",
+			dynamicClientID,
+			dynamicClientUID,
+			goodRedirectURI,
+			nil, // no custom ID token lifetime
+			[]string{testutil.HashedPassword1AtGoMinCost, testutil.HashedPassword2AtGoMinCost},
+			oidcclientvalidator.Validate,
+		)
+		oidcClient.Spec.TokenExchange = tokenExchange
+		require.NoError(t, supervisorClient.Tracker().Add(oidcClient))
+		require.NoError(t, kubeClient.Tracker().Add(secret))
+	}
+}
+
 func modifyAuthcodeTokenRequestWithDynamicClientAuth(r *http.Request, authCode string) {
 	r.Body = happyAuthcodeRequestBody(authCode).WithClientID("").ReadCloser() // No client_id in body.
 	r.SetBasicAuth(dynamicClientID, testutil.PlaintextPassword1)              // Use basic auth header instead.
@@ -853,6 +889,27 @@ func TestTokenEndpointAuthcodeExchange(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "DPoP proof is for a different URL",
+			authcodeExchange: authcodeExchangeInputs{
+				modifyTokenRequest: func(r *http.Request, authCode string) {
+					key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+					require.NoError(t, err)
+					proof, err := clientdpop.NewProof(key, http.MethodPost, goodIssuer+"/some/other/path", time.Now())
+					require.NoError(t, err)
+					r.Header.Set(clientdpop.HeaderName, proof)
+				},
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus: http.StatusBadRequest,
+					wantErrorResponseBody: here.Doc(`
+						{
+							"error":             "invalid_dpop_proof",
+							"error_description": "The DPoP proof is invalid. The 'htu' claim of the DPoP proof must match the URL of the request."
+						}
+					`),
+				},
+			},
+		},
 		{
 			name: "auth code is missing in request",
 			authcodeExchange: authcodeExchangeInputs{
@@ -1061,6 +1118,90 @@ func TestTokenEndpointTokenExchange(t *testing.T) { // tests for grant_type "urn
 		}
 	}
 
+	dpopKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherDPoPKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	customSessionDataBoundToDPoPKey := func() *psession.CustomSessionData {
+		return &psession.CustomSessionData{
+			Username:          goodUsername,
+			UpstreamUsername:  goodUsername,
+			UpstreamGroups:    goodGroups,
+			ProviderName:      "some-ldap-idp",
+			ProviderUID:       "ldap-resource-uid",
+			ProviderType:      "ldap",
+			LDAP:              &psession.LDAPSessionData{UserDN: "some-ldap-user-dn"},
+			DPoPJWKThumbprint: dpopJWKThumbprint(t, dpopKey),
+		}
+	}
+
+	doValidAuthCodeExchangeBoundToDPoPKey := func() authcodeExchangeInputs {
+		want := successfulAuthCodeExchange
+		want.wantCustomSessionDataStored = customSessionDataBoundToDPoPKey()
+		customSessionData := customSessionDataBoundToDPoPKey()
+		customSessionData.DPoPJWKThumbprint = "" // the thumbprint is stored by the authcode exchange
+		return authcodeExchangeInputs{
+			modifyAuthRequest: func(authRequest *http.Request) {
+				authRequest.Form.Set("scope", "openid pinniped:request-audience username groups")
+			},
+			modifyTokenRequest: func(tokenRequest *http.Request, authCode string) {
+				addDPoPProof(t, tokenRequest, dpopKey)
+			},
+			customSessionData: customSessionData,
+			want:              want,
+		}
+	}
+
+	const (
+		trustedIssuer         = "https://ci.example.com"
+		trustedIssuerAudience = "some-ci-audience"
+	)
+	trustedIssuerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherTrustedIssuerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	trustedIssuerJWKS, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: trustedIssuerKey.Public(), KeyID: "ci-key", Algorithm: "ES256", Use: "sig"}}})
+	require.NoError(t, err)
+
+	addDynamicClientWhichTrustsIssuer := addDynamicClientWithTokenExchangeConfigAndSecretToKubeResources(&supervisorconfigv1alpha1.OIDCClientTokenExchange{
+		TrustedIssuers: []supervisorconfigv1alpha1.OIDCClientTrustedIssuer{{
+			Issuer:   trustedIssuer,
+			Audience: trustedIssuerAudience,
+			JWKS:     string(trustedIssuerJWKS),
+			Claims:   supervisorconfigv1alpha1.OIDCClientTrustedIssuerClaims{Username: "email", Groups: "teams"},
+		}},
+	})
+
+	signTrustedIssuerJWT := func(key *ecdsa.PrivateKey, modifyClaims func(claims map[string]any)) func(t *testing.T) string {
+		return func(t *testing.T) string {
+			claims := map[string]any{
+				"iss":   trustedIssuer,
+				"aud":   trustedIssuerAudience,
+				"sub":   "repo:some-org/some-repo:ref:refs/heads/main",
+				"exp":   time.Now().Add(5 * time.Minute).Unix(),
+				"iat":   time.Now().Unix(),
+				"email": "deployer@example.com",
+				"teams": []string{"team-a", "team-b"},
+			}
+			if modifyClaims != nil {
+				modifyClaims(claims)
+			}
+			signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "ci-key"))
+			require.NoError(t, err)
+			signed, err := josejwt.Signed(signer).Claims(claims).Serialize()
+			require.NoError(t, err)
+			return signed
+		}
+	}
+
+	exchangeWithDynamicClient := func(t *testing.T, params url.Values) {
+		params.Del("client_id") // client auth for dynamic clients must be in basic auth header
+	}
+	dynamicClientBasicAuth := func(r *http.Request) {
+		r.SetBasicAuth(dynamicClientID, testutil.PlaintextPassword1)
+	}
+
 	tests := []struct {
 		name string
 
@@ -1071,9 +1212,22 @@ func TestTokenEndpointTokenExchange(t *testing.T) { // tests for grant_type "urn
 		requestedAudience    string
 		kubeResources        func(t *testing.T, supervisorClient *supervisorfake.Clientset, kubeClient *fake.Clientset)
 
+		// When true, exchange the ID token from the authcode exchange instead of its access token.
+		exchangeIDToken bool
+		// When set, exchange this JWT from a trusted issuer instead of the access token from the authcode exchange.
+		trustedIssuerJWT func(t *testing.T) string
+		// When set, send this JWT from a trusted issuer as the actor token.
+		actorJWT func(t *testing.T) string
+
 		wantStatus            int
 		wantErrorType         string
 		wantErrorDescContains string
+		// The expected identity in the new token when a JWT from a trusted issuer was exchanged.
+		wantTrustedIssuerSubject  string
+		wantTrustedIssuerUsername string
+		wantTrustedIssuerGroups   []string
+		// The expected value of the "act" claim in the new token when an actor token was sent.
+		wantActor map[string]any
 	}{
 		{
 			name:              "happy path",
@@ -1081,6 +1235,34 @@ func TestTokenEndpointTokenExchange(t *testing.T) { // tests for grant_type "urn
 			requestedAudience: "some-workload-cluster",
 			wantStatus:        http.StatusOK,
 		},
+		{
+			name:              "happy path with access token bound to a DPoP key and a DPoP proof signed by that key",
+			authcodeExchange:  doValidAuthCodeExchangeBoundToDPoPKey(),
+			requestedAudience: "some-workload-cluster",
+			modifyRequestHeaders: func(r *http.Request) {
+				addDPoPProof(t, r, dpopKey)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:                  "access token bound to a DPoP key without a DPoP proof",
+			authcodeExchange:      doValidAuthCodeExchangeBoundToDPoPKey(),
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusBadRequest,
+			wantErrorType:         "invalid_dpop_proof",
+			wantErrorDescContains: "A DPoP proof is required because the token is bound to a DPoP key.",
+		},
+		{
+			name:              "access token bound to a DPoP key with a DPoP proof signed by a different key",
+			authcodeExchange:  doValidAuthCodeExchangeBoundToDPoPKey(),
+			requestedAudience: "some-workload-cluster",
+			modifyRequestHeaders: func(r *http.Request) {
+				addDPoPProof(t, r, otherDPoPKey)
+			},
+			wantStatus:            http.StatusBadRequest,
+			wantErrorType:         "invalid_dpop_proof",
+			wantErrorDescContains: "The DPoP proof was not signed by the key to which the token is bound.",
+		},
 		{
 			name: "happy path with additional claims",
 			authcodeExchange: authcodeExchangeInputs{
@@ -1385,6 +1567,269 @@ func TestTokenEndpointTokenExchange(t *testing.T) { // tests for grant_type "urn
 			wantErrorType:         "access_denied",
 			wantErrorDescContains: `The resource owner or authorization server denied the request. No username found in session. Ensure that the 'username' scope was requested and granted at the authorization endpoint.`,
 		},
+		{
+			name:                 "happy path exchanging an ID token with dynamic client",
+			kubeResources:        addFullyCapableDynamicClientAndSecretToKubeResources,
+			authcodeExchange:     doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:  exchangeWithDynamicClient,
+			modifyRequestHeaders: dynamicClientBasicAuth,
+			exchangeIDToken:      true,
+			requestedAudience:    "some-workload-cluster",
+			wantStatus:           http.StatusOK,
+		},
+		{
+			name:                  "exchanging an ID token with the public pinniped-cli client",
+			authcodeExchange:      doValidAuthCodeExchange,
+			exchangeIDToken:       true,
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusBadRequest,
+			wantErrorType:         "unauthorized_client",
+			wantErrorDescContains: `Public OAuth 2.0 Clients may not exchange tokens of type 'urn:ietf:params:oauth:token-type:id_token'.`,
+		},
+		{
+			name:                  "exchanging an ID token which was issued to a different client",
+			kubeResources:         addFullyCapableDynamicClientAndSecretToKubeResources,
+			authcodeExchange:      doValidAuthCodeExchange,
+			modifyRequestParams:   exchangeWithDynamicClient,
+			modifyRequestHeaders:  dynamicClientBasicAuth,
+			exchangeIDToken:       true,
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusUnauthorized,
+			wantErrorType:         "request_unauthorized",
+			wantErrorDescContains: `The request could not be authorized. Invalid 'subject_token' parameter value.`,
+		},
+		{
+			name:             "exchanging an ID token which is not a valid JWT",
+			kubeResources:    addFullyCapableDynamicClientAndSecretToKubeResources,
+			authcodeExchange: doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams: func(t *testing.T, params url.Values) {
+				exchangeWithDynamicClient(t, params)
+				params.Set("subject_token", "some-bogus-value")
+			},
+			modifyRequestHeaders:  dynamicClientBasicAuth,
+			exchangeIDToken:       true,
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusUnauthorized,
+			wantErrorType:         "request_unauthorized",
+			wantErrorDescContains: `The request could not be authorized. Invalid 'subject_token' parameter value.`,
+		},
+		{
+			name:                      "happy path exchanging a JWT from a trusted issuer",
+			kubeResources:             addDynamicClientWhichTrustsIssuer,
+			authcodeExchange:          doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:       exchangeWithDynamicClient,
+			modifyRequestHeaders:      dynamicClientBasicAuth,
+			trustedIssuerJWT:          signTrustedIssuerJWT(trustedIssuerKey, nil),
+			requestedAudience:         "some-workload-cluster",
+			wantStatus:                http.StatusOK,
+			wantTrustedIssuerSubject:  "https://ci.example.com?sub=repo%3Asome-org%2Fsome-repo%3Aref%3Arefs%2Fheads%2Fmain",
+			wantTrustedIssuerUsername: "deployer@example.com",
+			wantTrustedIssuerGroups:   []string{"team-a", "team-b"},
+		},
+		{
+			name:                 "happy path exchanging a JWT from a trusted issuer whose groups claim is a string",
+			kubeResources:        addDynamicClientWhichTrustsIssuer,
+			authcodeExchange:     doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:  exchangeWithDynamicClient,
+			modifyRequestHeaders: dynamicClientBasicAuth,
+			trustedIssuerJWT: signTrustedIssuerJWT(trustedIssuerKey, func(claims map[string]any) {
+				claims["teams"] = "team-a"
+			}),
+			requestedAudience:         "some-workload-cluster",
+			wantStatus:                http.StatusOK,
+			wantTrustedIssuerSubject:  "https://ci.example.com?sub=repo%3Asome-org%2Fsome-repo%3Aref%3Arefs%2Fheads%2Fmain",
+			wantTrustedIssuerUsername: "deployer@example.com",
+			wantTrustedIssuerGroups:   []string{"team-a"},
+		},
+		{
+			name:                 "exchanging a JWT from an issuer which the client does not trust",
+			kubeResources:        addDynamicClientWhichTrustsIssuer,
+			authcodeExchange:     doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:  exchangeWithDynamicClient,
+			modifyRequestHeaders: dynamicClientBasicAuth,
+			trustedIssuerJWT: signTrustedIssuerJWT(trustedIssuerKey, func(claims map[string]any) {
+				claims["iss"] = "https://other-ci.example.com"
+			}),
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusUnauthorized,
+			wantErrorType:         "request_unauthorized",
+			wantErrorDescContains: `The request could not be authorized. Invalid 'subject_token' parameter value.`,
+		},
+		{
+			name:                  "exchanging a JWT from a trusted issuer which was signed by a different key",
+			kubeResources:         addDynamicClientWhichTrustsIssuer,
+			authcodeExchange:      doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:   exchangeWithDynamicClient,
+			modifyRequestHeaders:  dynamicClientBasicAuth,
+			trustedIssuerJWT:      signTrustedIssuerJWT(otherTrustedIssuerKey, nil),
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusUnauthorized,
+			wantErrorType:         "request_unauthorized",
+			wantErrorDescContains: `The request could not be authorized. Invalid 'subject_token' parameter value.`,
+		},
+		{
+			name:                 "exchanging a JWT from a trusted issuer which has the wrong audience",
+			kubeResources:        addDynamicClientWhichTrustsIssuer,
+			authcodeExchange:     doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:  exchangeWithDynamicClient,
+			modifyRequestHeaders: dynamicClientBasicAuth,
+			trustedIssuerJWT: signTrustedIssuerJWT(trustedIssuerKey, func(claims map[string]any) {
+				claims["aud"] = "some-other-audience"
+			}),
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusUnauthorized,
+			wantErrorType:         "request_unauthorized",
+			wantErrorDescContains: `The request could not be authorized. Invalid 'subject_token' parameter value.`,
+		},
+		{
+			name:                 "exchanging an expired JWT from a trusted issuer",
+			kubeResources:        addDynamicClientWhichTrustsIssuer,
+			authcodeExchange:     doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:  exchangeWithDynamicClient,
+			modifyRequestHeaders: dynamicClientBasicAuth,
+			trustedIssuerJWT: signTrustedIssuerJWT(trustedIssuerKey, func(claims map[string]any) {
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			}),
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusUnauthorized,
+			wantErrorType:         "request_unauthorized",
+			wantErrorDescContains: `The request could not be authorized. Invalid 'subject_token' parameter value.`,
+		},
+		{
+			name:                 "exchanging a JWT from a trusted issuer which has no username claim",
+			kubeResources:        addDynamicClientWhichTrustsIssuer,
+			authcodeExchange:     doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:  exchangeWithDynamicClient,
+			modifyRequestHeaders: dynamicClientBasicAuth,
+			trustedIssuerJWT: signTrustedIssuerJWT(trustedIssuerKey, func(claims map[string]any) {
+				delete(claims, "email")
+			}),
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusUnauthorized,
+			wantErrorType:         "request_unauthorized",
+			wantErrorDescContains: `The request could not be authorized. Invalid 'subject_token' parameter value.`,
+		},
+		{
+			name:                 "exchanging a JWT from a trusted issuer whose groups claim is not a list of strings",
+			kubeResources:        addDynamicClientWhichTrustsIssuer,
+			authcodeExchange:     doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:  exchangeWithDynamicClient,
+			modifyRequestHeaders: dynamicClientBasicAuth,
+			trustedIssuerJWT: signTrustedIssuerJWT(trustedIssuerKey, func(claims map[string]any) {
+				claims["teams"] = []any{"team-a", 42}
+			}),
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusUnauthorized,
+			wantErrorType:         "request_unauthorized",
+			wantErrorDescContains: `The request could not be authorized. Invalid 'subject_token' parameter value.`,
+		},
+		{
+			name:                 "happy path exchanging an access token with an actor token from a trusted issuer",
+			kubeResources:        addDynamicClientWhichTrustsIssuer,
+			authcodeExchange:     doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:  exchangeWithDynamicClient,
+			modifyRequestHeaders: dynamicClientBasicAuth,
+			actorJWT:             signTrustedIssuerJWT(trustedIssuerKey, nil),
+			requestedAudience:    "some-workload-cluster",
+			wantStatus:           http.StatusOK,
+			wantActor: map[string]any{
+				"sub":      "https://ci.example.com?sub=repo%3Asome-org%2Fsome-repo%3Aref%3Arefs%2Fheads%2Fmain",
+				"username": "deployer@example.com",
+			},
+		},
+		{
+			name:                 "actor token from an issuer which the client does not trust",
+			kubeResources:        addDynamicClientWhichTrustsIssuer,
+			authcodeExchange:     doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:  exchangeWithDynamicClient,
+			modifyRequestHeaders: dynamicClientBasicAuth,
+			actorJWT: signTrustedIssuerJWT(trustedIssuerKey, func(claims map[string]any) {
+				claims["iss"] = "https://other-ci.example.com"
+			}),
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusUnauthorized,
+			wantErrorType:         "request_unauthorized",
+			wantErrorDescContains: `The request could not be authorized. Invalid 'actor_token' parameter value.`,
+		},
+		{
+			name:                  "actor token from a trusted issuer which was signed by a different key",
+			kubeResources:         addDynamicClientWhichTrustsIssuer,
+			authcodeExchange:      doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:   exchangeWithDynamicClient,
+			modifyRequestHeaders:  dynamicClientBasicAuth,
+			actorJWT:              signTrustedIssuerJWT(otherTrustedIssuerKey, nil),
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusUnauthorized,
+			wantErrorType:         "request_unauthorized",
+			wantErrorDescContains: `The request could not be authorized. Invalid 'actor_token' parameter value.`,
+		},
+		{
+			name:                  "actor token when the client does not trust any issuers",
+			authcodeExchange:      doValidAuthCodeExchange,
+			actorJWT:              signTrustedIssuerJWT(trustedIssuerKey, nil),
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusUnauthorized,
+			wantErrorType:         "request_unauthorized",
+			wantErrorDescContains: `The request could not be authorized. Invalid 'actor_token' parameter value.`,
+		},
+		{
+			name:             "actor token without actor_token_type",
+			authcodeExchange: doValidAuthCodeExchange,
+			modifyRequestParams: func(t *testing.T, params url.Values) {
+				params.Set("actor_token", "some-actor-token")
+			},
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusBadRequest,
+			wantErrorType:         "invalid_request",
+			wantErrorDescContains: `Missing 'actor_token_type' parameter.`,
+		},
+		{
+			name:             "actor token with unsupported actor_token_type",
+			authcodeExchange: doValidAuthCodeExchange,
+			modifyRequestParams: func(t *testing.T, params url.Values) {
+				params.Set("actor_token", "some-actor-token")
+				params.Set("actor_token_type", "urn:ietf:params:oauth:token-type:access_token")
+			},
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusBadRequest,
+			wantErrorType:         "invalid_request",
+			wantErrorDescContains: `Unsupported 'actor_token_type' parameter value, must be 'urn:ietf:params:oauth:token-type:jwt'.`,
+		},
+		{
+			name:             "actor_token_type without actor token",
+			authcodeExchange: doValidAuthCodeExchange,
+			modifyRequestParams: func(t *testing.T, params url.Values) {
+				params.Set("actor_token_type", "urn:ietf:params:oauth:token-type:jwt")
+			},
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusBadRequest,
+			wantErrorType:         "invalid_request",
+			wantErrorDescContains: `Missing 'actor_token' parameter.`,
+		},
+		{
+			name: "happy path with dynamic client which is allowed to request the audience",
+			kubeResources: addDynamicClientWithTokenExchangeConfigAndSecretToKubeResources(&supervisorconfigv1alpha1.OIDCClientTokenExchange{
+				AllowedAudiences: []string{"*.clusters.example.com"},
+			}),
+			authcodeExchange:     doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:  exchangeWithDynamicClient,
+			modifyRequestHeaders: dynamicClientBasicAuth,
+			requestedAudience:    "prod.clusters.example.com",
+			wantStatus:           http.StatusOK,
+		},
+		{
+			name: "dynamic client which is not allowed to request the audience",
+			kubeResources: addDynamicClientWithTokenExchangeConfigAndSecretToKubeResources(&supervisorconfigv1alpha1.OIDCClientTokenExchange{
+				AllowedAudiences: []string{"*.clusters.example.com"},
+			}),
+			authcodeExchange:      doValidAuthCodeExchangeUsingDynamicClient(),
+			modifyRequestParams:   exchangeWithDynamicClient,
+			modifyRequestHeaders:  dynamicClientBasicAuth,
+			requestedAudience:     "some-workload-cluster",
+			wantStatus:            http.StatusBadRequest,
+			wantErrorType:         "invalid_target",
+			wantErrorDescContains: `The OAuth 2.0 Client is not allowed to request the audience 'some-workload-cluster'.`,
+		},
 		{
 			name:                  "missing audience",
 			authcodeExchange:      doValidAuthCodeExchange,
@@ -1437,7 +1882,7 @@ func TestTokenEndpointTokenExchange(t *testing.T) { // tests for grant_type "urn
 			},
 			wantStatus:            http.StatusBadRequest,
 			wantErrorType:         "invalid_request",
-			wantErrorDescContains: `Unsupported 'subject_token_type' parameter value, must be 'urn:ietf:params:oauth:token-type:access_token'.`,
+			wantErrorDescContains: `Unsupported 'subject_token_type' parameter value, must be one of 'urn:ietf:params:oauth:token-type:access_token', 'urn:ietf:params:oauth:token-type:id_token', or 'urn:ietf:params:oauth:token-type:jwt'.`,
 		},
 		{
 			name:              "wrong requested_token_type",
@@ -1663,6 +2108,18 @@ func TestTokenEndpointTokenExchange(t *testing.T) { // tests for grant_type "urn
 			require.NoError(t, json.Unmarshal(rsp.Body.Bytes(), &parsedAuthcodeExchangeResponseBody))
 
 			request := happyTokenExchangeRequest(test.requestedAudience, parsedAuthcodeExchangeResponseBody["access_token"].(string))
+			if test.exchangeIDToken {
+				request.Form.Set("subject_token", parsedAuthcodeExchangeResponseBody["id_token"].(string))
+				request.Form.Set("subject_token_type", "urn:ietf:params:oauth:token-type:id_token")
+			}
+			if test.trustedIssuerJWT != nil {
+				request.Form.Set("subject_token", test.trustedIssuerJWT(t))
+				request.Form.Set("subject_token_type", "urn:ietf:params:oauth:token-type:jwt")
+			}
+			if test.actorJWT != nil {
+				request.Form.Set("actor_token", test.actorJWT(t))
+				request.Form.Set("actor_token_type", "urn:ietf:params:oauth:token-type:jwt")
+			}
 			if test.modifyStorage != nil {
 				test.modifyStorage(t, storage, secrets, request)
 			}
@@ -1730,14 +2187,22 @@ func TestTokenEndpointTokenExchange(t *testing.T) { // tests for grant_type "urn
 			var tokenClaims map[string]any
 			require.NoError(t, json.Unmarshal(parsedJWT.UnsafePayloadWithoutVerification(), &tokenClaims))
 
+			wantSubject, wantUsername, wantGroups := goodSubject, test.authcodeExchange.want.wantUsername, test.authcodeExchange.want.wantGroups
+			if test.trustedIssuerJWT != nil {
+				wantSubject, wantUsername, wantGroups = test.wantTrustedIssuerSubject, test.wantTrustedIssuerUsername, test.wantTrustedIssuerGroups
+			}
+
 			// Make sure that these are the only fields in the token.
 			idTokenFields := []string{"sub", "aud", "iss", "jti", "auth_time", "exp", "iat", "rat", "username", "azp"}
-			if test.authcodeExchange.want.wantGroups != nil {
+			if wantGroups != nil {
 				idTokenFields = append(idTokenFields, "groups")
 			}
 			if len(test.authcodeExchange.want.wantAdditionalClaims) > 0 {
 				idTokenFields = append(idTokenFields, "additionalClaims")
 			}
+			if test.wantActor != nil {
+				idTokenFields = append(idTokenFields, "act")
+			}
 			require.ElementsMatch(t, idTokenFields, getMapKeys(tokenClaims))
 
 			// Assert that the returned token has expected claims values.
@@ -1749,18 +2214,21 @@ func TestTokenEndpointTokenExchange(t *testing.T) { // tests for grant_type "urn
 			require.Len(t, tokenClaims["aud"], 1)
 			require.Contains(t, tokenClaims["aud"], test.requestedAudience)
 			require.Equal(t, test.authcodeExchange.want.wantClientID, tokenClaims["azp"])
-			require.Equal(t, goodSubject, tokenClaims["sub"])
+			require.Equal(t, wantSubject, tokenClaims["sub"])
 			require.Equal(t, goodIssuer, tokenClaims["iss"])
-			if test.authcodeExchange.want.wantUsername != "" {
-				require.Equal(t, test.authcodeExchange.want.wantUsername, tokenClaims["username"])
+			if wantUsername != "" {
+				require.Equal(t, wantUsername, tokenClaims["username"])
 			} else {
 				require.Nil(t, tokenClaims["username"])
 			}
-			if test.authcodeExchange.want.wantGroups != nil {
-				require.Equal(t, toSliceOfInterface(test.authcodeExchange.want.wantGroups), tokenClaims["groups"])
+			if wantGroups != nil {
+				require.Equal(t, toSliceOfInterface(wantGroups), tokenClaims["groups"])
 			} else {
 				require.Nil(t, tokenClaims["groups"])
 			}
+			if test.wantActor != nil {
+				require.Equal(t, test.wantActor, tokenClaims["act"])
+			}
 
 			if len(test.authcodeExchange.want.wantAdditionalClaims) > 0 {
 				require.Equal(t, test.authcodeExchange.want.wantAdditionalClaims, tokenClaims["additionalClaims"])
@@ -1771,10 +2239,12 @@ func TestTokenEndpointTokenExchange(t *testing.T) { // tests for grant_type "urn
 			}
 
 			// Also assert that some are the same as the original downstream ID token.
-			requireClaimsAreEqual(t, "iss", claimsOfFirstIDToken, tokenClaims)       // issuer
-			requireClaimsAreEqual(t, "sub", claimsOfFirstIDToken, tokenClaims)       // subject
-			requireClaimsAreEqual(t, "rat", claimsOfFirstIDToken, tokenClaims)       // requested at
-			requireClaimsAreEqual(t, "auth_time", claimsOfFirstIDToken, tokenClaims) // auth time
+			requireClaimsAreEqual(t, "iss", claimsOfFirstIDToken, tokenClaims) // issuer
+			if test.trustedIssuerJWT == nil {
+				requireClaimsAreEqual(t, "sub", claimsOfFirstIDToken, tokenClaims)       // subject
+				requireClaimsAreEqual(t, "rat", claimsOfFirstIDToken, tokenClaims)       // requested at
+				requireClaimsAreEqual(t, "auth_time", claimsOfFirstIDToken, tokenClaims) // auth time
+			}
 			if len(test.authcodeExchange.want.wantAdditionalClaims) > 0 {
 				requireClaimsAreEqual(t, "additionalClaims", claimsOfFirstIDToken, tokenClaims)
 			}
@@ -1932,6 +2402,16 @@ func TestRefreshGrant(t *testing.T) {
 		return sessionData
 	}
 
+	dpopKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherDPoPKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	withDPoPJWKThumbprint := func(sessionData *psession.CustomSessionData) *psession.CustomSessionData {
+		sessionData.DPoPJWKThumbprint = dpopJWKThumbprint(t, dpopKey)
+		return sessionData
+	}
+
 	upstreamOIDCCustomSessionDataWithNewRefreshTokenWithUsername := func(newRefreshToken string, downstreamUsername string) *psession.CustomSessionData {
 		sessionData := initialUpstreamOIDCRefreshTokenCustomSessionDataWithUsername(downstreamUsername)
 		sessionData.OIDC.UpstreamRefreshToken = newRefreshToken
@@ -2199,6 +2679,138 @@ func TestRefreshGrant(t *testing.T) {
 				),
 			},
 		},
+		{
+			name: "happy path refresh grant with tokens bound to a DPoP key",
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithOIDC(
+				upstreamOIDCIdentityProviderBuilder().WithValidatedAndMergedWithUserInfoTokens(&oidctypes.Token{
+					IDToken: &oidctypes.IDToken{
+						Claims: map[string]any{
+							"sub": goodUpstreamSubject,
+						},
+					},
+				}).WithRefreshedTokens(refreshedUpstreamTokensWithIDAndRefreshTokens()).Build()),
+			authcodeExchange: authcodeExchangeInputs{
+				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access username groups") },
+				modifyTokenRequest: func(r *http.Request, authCode string) {
+					addDPoPProof(t, r, dpopKey)
+				},
+				customSessionData: initialUpstreamOIDCRefreshTokenCustomSessionData(),
+				want: happyAuthcodeExchangeTokenResponseForOpenIDAndOfflineAccess(
+					withDPoPJWKThumbprint(initialUpstreamOIDCRefreshTokenCustomSessionData()),
+				),
+			},
+			refreshRequest: refreshRequestInputs{
+				modifyTokenRequest: func(r *http.Request, refreshToken string, accessToken string) {
+					addDPoPProof(t, r, dpopKey)
+				},
+				want: happyRefreshTokenResponseForOpenIDAndOfflineAccess(
+					withDPoPJWKThumbprint(upstreamOIDCCustomSessionDataWithNewRefreshToken(oidcUpstreamRefreshedRefreshToken)),
+					refreshedUpstreamTokensWithIDAndRefreshTokens(),
+				),
+			},
+		},
+		{
+			name: "refresh grant without a DPoP proof when tokens are bound to a DPoP key",
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithOIDC(
+				upstreamOIDCIdentityProviderBuilder().WithValidatedAndMergedWithUserInfoTokens(&oidctypes.Token{
+					IDToken: &oidctypes.IDToken{
+						Claims: map[string]any{
+							"sub": goodUpstreamSubject,
+						},
+					},
+				}).WithRefreshedTokens(refreshedUpstreamTokensWithIDAndRefreshTokens()).Build()),
+			authcodeExchange: authcodeExchangeInputs{
+				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access username groups") },
+				modifyTokenRequest: func(r *http.Request, authCode string) {
+					addDPoPProof(t, r, dpopKey)
+				},
+				customSessionData: initialUpstreamOIDCRefreshTokenCustomSessionData(),
+				want: happyAuthcodeExchangeTokenResponseForOpenIDAndOfflineAccess(
+					withDPoPJWKThumbprint(initialUpstreamOIDCRefreshTokenCustomSessionData()),
+				),
+			},
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus: http.StatusBadRequest,
+					wantErrorResponseBody: here.Doc(`
+						{
+							"error":             "invalid_dpop_proof",
+							"error_description": "The DPoP proof is invalid. A DPoP proof is required because the token is bound to a DPoP key."
+						}
+					`),
+				},
+			},
+		},
+		{
+			name: "refresh grant with a DPoP proof signed by a different key than the one to which tokens are bound",
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithOIDC(
+				upstreamOIDCIdentityProviderBuilder().WithValidatedAndMergedWithUserInfoTokens(&oidctypes.Token{
+					IDToken: &oidctypes.IDToken{
+						Claims: map[string]any{
+							"sub": goodUpstreamSubject,
+						},
+					},
+				}).WithRefreshedTokens(refreshedUpstreamTokensWithIDAndRefreshTokens()).Build()),
+			authcodeExchange: authcodeExchangeInputs{
+				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access username groups") },
+				modifyTokenRequest: func(r *http.Request, authCode string) {
+					addDPoPProof(t, r, dpopKey)
+				},
+				customSessionData: initialUpstreamOIDCRefreshTokenCustomSessionData(),
+				want: happyAuthcodeExchangeTokenResponseForOpenIDAndOfflineAccess(
+					withDPoPJWKThumbprint(initialUpstreamOIDCRefreshTokenCustomSessionData()),
+				),
+			},
+			refreshRequest: refreshRequestInputs{
+				modifyTokenRequest: func(r *http.Request, refreshToken string, accessToken string) {
+					addDPoPProof(t, r, otherDPoPKey)
+				},
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus: http.StatusBadRequest,
+					wantErrorResponseBody: here.Doc(`
+						{
+							"error":             "invalid_dpop_proof",
+							"error_description": "The DPoP proof is invalid. The DPoP proof was not signed by the key to which the token is bound."
+						}
+					`),
+				},
+			},
+		},
+		{
+			name: "refresh grant with an invalid DPoP proof when tokens are bound to a DPoP key",
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithOIDC(
+				upstreamOIDCIdentityProviderBuilder().WithValidatedAndMergedWithUserInfoTokens(&oidctypes.Token{
+					IDToken: &oidctypes.IDToken{
+						Claims: map[string]any{
+							"sub": goodUpstreamSubject,
+						},
+					},
+				}).WithRefreshedTokens(refreshedUpstreamTokensWithIDAndRefreshTokens()).Build()),
+			authcodeExchange: authcodeExchangeInputs{
+				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access username groups") },
+				modifyTokenRequest: func(r *http.Request, authCode string) {
+					addDPoPProof(t, r, dpopKey)
+				},
+				customSessionData: initialUpstreamOIDCRefreshTokenCustomSessionData(),
+				want: happyAuthcodeExchangeTokenResponseForOpenIDAndOfflineAccess(
+					withDPoPJWKThumbprint(initialUpstreamOIDCRefreshTokenCustomSessionData()),
+				),
+			},
+			refreshRequest: refreshRequestInputs{
+				modifyTokenRequest: func(r *http.Request, refreshToken string, accessToken string) {
+					r.Header.Set("DPoP", "not-a-jwt")
+				},
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus: http.StatusBadRequest,
+					wantErrorResponseBody: here.Doc(`
+						{
+							"error":             "invalid_dpop_proof",
+							"error_description": "The DPoP proof is invalid. The DPoP proof could not be parsed as a JWT signed by a supported algorithm."
+						}
+					`),
+				},
+			},
+		},
 		{
 			name: "happy path refresh grant with OIDC upstream with identity transformations which modify the username and group names",
 			idps: testidplister.NewUpstreamIDPListerBuilder().WithOIDC(
@@ -2457,6 +3069,39 @@ func TestRefreshGrant(t *testing.T) {
 				)),
 			},
 		},
+		{
+			name: "refresh grant using dynamic client which requires consent when the end user's consent was revoked",
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithOIDC(
+				upstreamOIDCIdentityProviderBuilder().WithValidatedAndMergedWithUserInfoTokens(&oidctypes.Token{
+					IDToken: &oidctypes.IDToken{
+						Claims: map[string]any{
+							"sub": goodUpstreamSubject,
+						},
+					},
+				}).WithRefreshedTokens(refreshedUpstreamTokensWithIDAndRefreshTokens()).Build()),
+			kubeResources: addDynamicClientWhichRequiresConsentAndSecretToKubeResources,
+			authcodeExchange: authcodeExchangeInputs{
+				customSessionData: initialUpstreamOIDCRefreshTokenCustomSessionData(),
+				modifyAuthRequest: func(r *http.Request) {
+					addDynamicClientIDToFormPostBody(r)
+					r.Form.Set("scope", "openid offline_access username groups")
+				},
+				modifyTokenRequest: modifyAuthcodeTokenRequestWithDynamicClientAuth,
+				want:               withWantDynamicClientID(happyAuthcodeExchangeTokenResponseForOpenIDAndOfflineAccess(initialUpstreamOIDCRefreshTokenCustomSessionData())),
+			},
+			refreshRequest: refreshRequestInputs{
+				modifyTokenRequest: modifyRefreshTokenRequestWithDynamicClientAuth,
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus: http.StatusUnauthorized,
+					wantErrorResponseBody: here.Doc(`
+						{
+							"error":             "error",
+							"error_description": "Error during upstream refresh. The end user's consent for this client was revoked."
+						}
+					`),
+				},
+			},
+		},
 		{
 			name: "happy path refresh grant with openid scope granted (id token returned) using dynamic client which has custom ID token lifetime configured",
 			idps: testidplister.NewUpstreamIDPListerBuilder().WithOIDC(
@@ -2919,6 +3564,79 @@ func TestRefreshGrant(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "happy path refresh grant when the upstream refresh returns new additional claims from LDAP, it updates additionalClaims",
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithLDAP(oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+				WithName(ldapUpstreamName).
+				WithResourceUID(ldapUpstreamResourceUID).
+				WithURL(ldapUpstreamURL).
+				WithPerformRefreshGroups(goodGroups).
+				WithPerformRefreshAdditionalClaims(map[string]any{"email": "new-email@example.com", "types": []string{"type1", "type2"}}).
+				Build(),
+			),
+			authcodeExchange: authcodeExchangeInputs{
+				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access username groups") },
+				modifySession: func(session *psession.PinnipedSession) {
+					session.IDTokenClaims().Extra["additionalClaims"] = map[string]any{"email": "old-email@example.com"}
+				},
+				customSessionData: happyLDAPCustomSessionData,
+				want: func() tokenEndpointResponseExpectedValues {
+					want := happyAuthcodeExchangeTokenResponseForOpenIDAndOfflineAccess(happyLDAPCustomSessionData)
+					want.wantAdditionalClaims = map[string]any{"email": "old-email@example.com"}
+					return want
+				}(),
+			},
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:                  http.StatusOK,
+					wantClientID:                pinnipedCLIClientID,
+					wantSuccessBodyFields:       []string{"refresh_token", "access_token", "id_token", "token_type", "expires_in", "scope"},
+					wantRequestedScopes:         []string{"openid", "offline_access", "username", "groups"},
+					wantGrantedScopes:           []string{"openid", "offline_access", "username", "groups"},
+					wantUsername:                goodUsername,
+					wantGroups:                  goodGroups,
+					wantAdditionalClaims:        map[string]any{"email": "new-email@example.com", "types": []any{"type1", "type2"}},
+					wantLDAPUpstreamRefreshCall: happyLDAPUpstreamRefreshCall(),
+					wantCustomSessionDataStored: happyLDAPCustomSessionData,
+				},
+			},
+		},
+		{
+			name: "happy path refresh grant when the upstream refresh returns no additional claims from LDAP, it removes additionalClaims",
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithLDAP(oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+				WithName(ldapUpstreamName).
+				WithResourceUID(ldapUpstreamResourceUID).
+				WithURL(ldapUpstreamURL).
+				WithPerformRefreshGroups(goodGroups).
+				WithPerformRefreshAdditionalClaims(map[string]any{}).
+				Build(),
+			),
+			authcodeExchange: authcodeExchangeInputs{
+				modifyAuthRequest: func(r *http.Request) { r.Form.Set("scope", "openid offline_access username groups") },
+				modifySession: func(session *psession.PinnipedSession) {
+					session.IDTokenClaims().Extra["additionalClaims"] = map[string]any{"email": "old-email@example.com"}
+				},
+				customSessionData: happyLDAPCustomSessionData,
+				want: func() tokenEndpointResponseExpectedValues {
+					want := happyAuthcodeExchangeTokenResponseForOpenIDAndOfflineAccess(happyLDAPCustomSessionData)
+					want.wantAdditionalClaims = map[string]any{"email": "old-email@example.com"}
+					return want
+				}(),
+			},
+			refreshRequest: refreshRequestInputs{
+				want: tokenEndpointResponseExpectedValues{
+					wantStatus:                  http.StatusOK,
+					wantClientID:                pinnipedCLIClientID,
+					wantSuccessBodyFields:       []string{"refresh_token", "access_token", "id_token", "token_type", "expires_in", "scope"},
+					wantRequestedScopes:         []string{"openid", "offline_access", "username", "groups"},
+					wantGrantedScopes:           []string{"openid", "offline_access", "username", "groups"},
+					wantUsername:                goodUsername,
+					wantGroups:                  goodGroups,
+					wantLDAPUpstreamRefreshCall: happyLDAPUpstreamRefreshCall(),
+					wantCustomSessionDataStored: happyLDAPCustomSessionData,
+				},
+			},
+		},
 		{
 			name: "happy path refresh grant when the upstream refresh returns new group memberships from LDAP, it updates groups, using dynamic client - updates groups without outputting warnings",
 			idps: testidplister.NewUpstreamIDPListerBuilder().WithLDAP(oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
@@ -4912,10 +5630,13 @@ func exchangeAuthcodeForTokens(
 	oauthHelper, authCode, jwtSigningKey = makeHappyOauthHelper(t, authRequest, oauthStore, test.makeJwksSigningKeyAndProvider, test.customSessionData, test.modifySession)
 
 	subject = NewHandler(
+		goodIssuer,
 		idps,
 		oauthHelper,
 		timeoutsConfiguration.OverrideDefaultAccessTokenLifespan,
 		timeoutsConfiguration.OverrideDefaultIDTokenLifespan,
+		consentgrants.New(goodIssuer, secrets, rand.Reader, time.Now),
+		dpop.NewReplayCache(),
 	)
 
 	authorizeEndpointGrantedOpenIDScope := strings.Contains(authRequest.Form.Get("scope"), "openid")
@@ -5035,6 +5756,19 @@ func happyAuthcodeRequestBody(happyAuthCode string) body {
 	}
 }
 
+// addDPoPProof adds a DPoP proof for the token endpoint, signed by the key, to the token request.
+func addDPoPProof(t *testing.T, tokenRequest *http.Request, key *ecdsa.PrivateKey) {
+	proof, err := clientdpop.NewProof(key, http.MethodPost, goodIssuer+oidc.TokenEndpointPath, time.Now())
+	require.NoError(t, err)
+	tokenRequest.Header.Set(clientdpop.HeaderName, proof)
+}
+
+func dpopJWKThumbprint(t *testing.T, key *ecdsa.PrivateKey) string {
+	thumbprint, err := (&jose.JSONWebKey{Key: key.Public()}).Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
 func happyRefreshRequestBody(refreshToken string) body {
 	return map[string][]string{
 		"grant_type":    {"refresh_token"},
@@ -5206,7 +5940,9 @@ func generateJWTSigningKeyAndJWKSProvider(t *testing.T, issuer string) (*ecdsa.P
 
 	jwksProvider := jwks.NewDynamicJWKSProvider()
 	jwksProvider.SetIssuerToJWKSMap(
-		nil, // public JWKS unused
+		map[string]*jose.JSONWebKeySet{
+			issuer: {Keys: []jose.JSONWebKey{{Key: key.Public()}}},
+		},
 		map[string]*jose.JSONWebKey{
 			issuer: {Key: key},
 		},
@@ -5311,7 +6047,11 @@ func requireValidAccessTokenStorage(
 	require.True(t, ok)
 	tokenTypeString, ok := tokenType.(string)
 	require.Truef(t, ok, "wanted token_type to be a string, but got %T", tokenType)
-	require.Equal(t, "bearer", tokenTypeString)
+	if wantCustomSessionData != nil && wantCustomSessionData.DPoPJWKThumbprint != "" {
+		require.Equal(t, "DPoP", tokenTypeString)
+	} else {
+		require.Equal(t, "bearer", tokenTypeString)
+	}
 
 	expiresIn, ok := body["expires_in"]
 	require.True(t, ok)
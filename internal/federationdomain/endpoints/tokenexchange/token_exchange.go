@@ -5,34 +5,62 @@ package tokenexchange
 
 import (
 	"context"
+	"crypto"
+	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
+	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v4/jwt"
 	"github.com/ory/fosite"
+	"github.com/ory/fosite/compose"
 	fositeoauth2 "github.com/ory/fosite/handler/oauth2"
 	"github.com/ory/fosite/handler/openid"
+	fositejwt "github.com/ory/fosite/token/jwt"
 	"github.com/pkg/errors"
 
 	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
+	"go.pinniped.dev/internal/federationdomain/clientregistry"
+	"go.pinniped.dev/internal/federationdomain/downstreamsubject"
+	"go.pinniped.dev/internal/federationdomain/dpop"
+	"go.pinniped.dev/internal/federationdomain/endpoints/jwks"
+	"go.pinniped.dev/internal/federationdomain/jwsalgorithms"
 	"go.pinniped.dev/internal/psession"
 )
 
 const (
 	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token" //nolint:gosec
+	tokenTypeIDToken     = "urn:ietf:params:oauth:token-type:id_token"     //nolint:gosec
 	tokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"          //nolint:gosec
 )
 
 type stsParams struct {
-	subjectAccessToken string
-	requestedAudience  string
+	subjectToken      string
+	subjectTokenType  string
+	actorToken        string
+	requestedAudience string
 }
 
-func HandlerFactory(config fosite.Configurator, storage any, strategy any) any {
-	return &tokenExchangeHandler{
-		idTokenStrategy:     strategy.(openid.OpenIDConnectTokenStrategy),
-		accessTokenStrategy: strategy.(fositeoauth2.AccessTokenStrategy),
-		accessTokenStorage:  storage.(fositeoauth2.AccessTokenStorage),
-		fositeConfig:        config,
+// trustedIssuerIdentity is the identity asserted by a verified JWT from an issuer which a client trusts.
+type trustedIssuerIdentity struct {
+	subject  string
+	username string
+	groups   []string
+}
+
+// NewHandlerFactory returns a fosite factory for the token exchange handler. The jwksProvider is used to verify
+// the signatures of the Supervisor's own ID tokens when they are exchanged.
+func NewHandlerFactory(jwksProvider jwks.DynamicJWKSProvider) compose.Factory {
+	return func(config fosite.Configurator, storage any, strategy any) any {
+		return &tokenExchangeHandler{
+			idTokenStrategy:     strategy.(openid.OpenIDConnectTokenStrategy),
+			accessTokenStrategy: strategy.(fositeoauth2.AccessTokenStrategy),
+			accessTokenStorage:  storage.(fositeoauth2.AccessTokenStorage),
+			jwksProvider:        jwksProvider,
+			fositeConfig:        config,
+		}
 	}
 }
 
@@ -40,9 +68,26 @@ type tokenExchangeHandler struct {
 	idTokenStrategy     openid.OpenIDConnectTokenStrategy
 	accessTokenStrategy fositeoauth2.AccessTokenStrategy
 	accessTokenStorage  fositeoauth2.AccessTokenStorage
+	jwksProvider        jwks.DynamicJWKSProvider
 	fositeConfig        fosite.Configurator
 }
 
+func errInvalidTarget() *fosite.RFC6749Error {
+	return &fosite.RFC6749Error{
+		ErrorField:       "invalid_target",
+		DescriptionField: "The requested audience is invalid, unknown, or malformed.",
+		CodeField:        http.StatusBadRequest,
+	}
+}
+
+func errInvalidSubjectToken() *fosite.RFC6749Error {
+	return fosite.ErrRequestUnauthorized.WithHint("Invalid 'subject_token' parameter value.")
+}
+
+func errInvalidActorToken() *fosite.RFC6749Error {
+	return fosite.ErrRequestUnauthorized.WithHint("Invalid 'actor_token' parameter value.")
+}
+
 var _ fosite.TokenEndpointHandler = (*tokenExchangeHandler)(nil)
 
 func (t *tokenExchangeHandler) HandleTokenEndpointRequest(ctx context.Context, requester fosite.AccessRequester) error {
@@ -64,16 +109,27 @@ func (t *tokenExchangeHandler) PopulateTokenEndpointResponse(ctx context.Context
 		return errors.WithStack(err)
 	}
 
-	// Validate the incoming access token and lookup the information about the original authorize request.
-	originalRequester, err := t.validateAccessToken(ctx, requester, params.subjectAccessToken)
+	// Validate the incoming subject token and lookup or build the information about the identity which it represents.
+	var originalRequester fosite.Requester
+	switch params.subjectTokenType {
+	case tokenTypeIDToken:
+		originalRequester, err = t.validateIDTokenSubject(ctx, requester, params.subjectToken)
+	case tokenTypeJWT:
+		originalRequester, err = t.validateTrustedIssuerJWTSubject(requester, params.subjectToken)
+	default:
+		originalRequester, err = t.validateAccessTokenSubject(ctx, requester, params.subjectToken)
+	}
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	// Check that the currently authenticated client and the client which was originally used to get the access token are the same.
-	if originalRequester.GetClient().GetID() != requester.GetClient().GetID() {
-		// This error message is copied from the similar check in fosite's flow_authorize_code_token.go.
-		return errors.WithStack(fosite.ErrInvalidGrant.WithHint("The OAuth 2.0 Client ID from this request does not match the one from the authorize request."))
+	// Validate the incoming actor token, if any, which identifies who is acting on behalf of the subject.
+	var actor map[string]any
+	if params.actorToken != "" {
+		actor, err = t.validateActorToken(requester, params.actorToken)
+		if err != nil {
+			return errors.WithStack(err)
+		}
 	}
 
 	// Check that the client is allowed to perform this grant type.
@@ -82,12 +138,20 @@ func (t *tokenExchangeHandler) PopulateTokenEndpointResponse(ctx context.Context
 		return errors.WithStack(fosite.ErrUnauthorizedClient.WithHintf(`The OAuth 2.0 Client is not allowed to use token exchange grant "%s".`, oidcapi.GrantTypeTokenExchange))
 	}
 
-	// Require that the incoming access token has the pinniped:request-audience and OpenID scopes.
-	if !originalRequester.GetGrantedScopes().Has(oidcapi.ScopeRequestAudience) {
-		return errors.WithStack(fosite.ErrAccessDenied.WithHintf("Missing the %q scope.", oidcapi.ScopeRequestAudience))
+	// Check that the client is allowed to request this audience.
+	if !clientregistry.TokenExchangeAudienceAllowed(requester.GetClient(), params.requestedAudience) {
+		return errors.WithStack(errInvalidTarget().WithHintf("The OAuth 2.0 Client is not allowed to request the audience %q.", params.requestedAudience))
 	}
-	if !originalRequester.GetGrantedScopes().Has(oidcapi.ScopeOpenID) {
-		return errors.WithStack(fosite.ErrAccessDenied.WithHintf("Missing the %q scope.", oidcapi.ScopeOpenID))
+
+	// Require that an incoming access token has the pinniped:request-audience and OpenID scopes.
+	// Other types of subject tokens do not have scopes.
+	if params.subjectTokenType == tokenTypeAccessToken {
+		if !originalRequester.GetGrantedScopes().Has(oidcapi.ScopeRequestAudience) {
+			return errors.WithStack(fosite.ErrAccessDenied.WithHintf("Missing the %q scope.", oidcapi.ScopeRequestAudience))
+		}
+		if !originalRequester.GetGrantedScopes().Has(oidcapi.ScopeOpenID) {
+			return errors.WithStack(fosite.ErrAccessDenied.WithHintf("Missing the %q scope.", oidcapi.ScopeOpenID))
+		}
 	}
 
 	// Check that the stored session meets the minimum requirements for token exchange.
@@ -96,7 +160,7 @@ func (t *tokenExchangeHandler) PopulateTokenEndpointResponse(ctx context.Context
 	}
 
 	// Use the original authorize request information, along with the requested audience, to mint a new JWT.
-	responseToken, err := t.mintJWT(ctx, originalRequester, params.requestedAudience)
+	responseToken, err := t.mintJWT(ctx, originalRequester, params.requestedAudience, actor)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -108,8 +172,24 @@ func (t *tokenExchangeHandler) PopulateTokenEndpointResponse(ctx context.Context
 	return nil
 }
 
-func (t *tokenExchangeHandler) mintJWT(ctx context.Context, requester fosite.Requester, audience string) (string, error) {
-	downscoped := fosite.NewAccessRequest(requester.GetSession())
+func (t *tokenExchangeHandler) mintJWT(ctx context.Context, requester fosite.Requester, audience string, actor map[string]any) (string, error) {
+	session := requester.GetSession()
+	if actor != nil {
+		// Add the actor claim to a copy of the session, to avoid changing the session of the subject token.
+		session = session.Clone()
+		pSession, ok := session.(*psession.PinnipedSession)
+		if !ok {
+			// This shouldn't really happen.
+			return "", fosite.ErrServerError.WithHint("Invalid session storage.")
+		}
+		claims := pSession.IDTokenClaims()
+		if claims.Extra == nil {
+			claims.Extra = map[string]any{}
+		}
+		claims.Extra[oidcapi.IDTokenClaimActor] = actor
+	}
+
+	downscoped := fosite.NewAccessRequest(session)
 	downscoped.Client.(*fosite.DefaultClient).ID = audience
 
 	// Note: if we wanted to support clients with custom token lifespans, then we would need to call
@@ -135,6 +215,19 @@ func (t *tokenExchangeHandler) validateSession(requester fosite.Requester) error
 	return nil
 }
 
+func (t *tokenExchangeHandler) validateDPoPBinding(ctx context.Context, requester fosite.Requester) error {
+	pSession, ok := requester.GetSession().(*psession.PinnipedSession)
+	if !ok {
+		// This shouldn't really happen.
+		return fosite.ErrServerError.WithHint("Invalid session storage.")
+	}
+	boundThumbprint := ""
+	if pSession.Custom != nil {
+		boundThumbprint = pSession.Custom.DPoPJWKThumbprint
+	}
+	return dpop.CheckBinding(boundThumbprint, dpop.ThumbprintFromContext(ctx))
+}
+
 func (t *tokenExchangeHandler) validateParams(params url.Values) (*stsParams, error) {
 	var result stsParams
 
@@ -143,25 +236,39 @@ func (t *tokenExchangeHandler) validateParams(params url.Values) (*stsParams, er
 	if result.requestedAudience == "" {
 		return nil, fosite.ErrInvalidRequest.WithHint("Missing 'audience' parameter.")
 	}
-	result.subjectAccessToken = params.Get("subject_token")
-	if result.subjectAccessToken == "" {
+	result.subjectToken = params.Get("subject_token")
+	if result.subjectToken == "" {
 		return nil, fosite.ErrInvalidRequest.WithHint("Missing 'subject_token' parameter.")
 	}
 
 	// Validate some parameters with hardcoded values we support.
-	if params.Get("subject_token_type") != tokenTypeAccessToken {
-		return nil, fosite.ErrInvalidRequest.WithHintf("Unsupported 'subject_token_type' parameter value, must be %q.", tokenTypeAccessToken)
+	result.subjectTokenType = params.Get("subject_token_type")
+	switch result.subjectTokenType {
+	case tokenTypeAccessToken, tokenTypeIDToken, tokenTypeJWT:
+	default:
+		return nil, fosite.ErrInvalidRequest.WithHintf("Unsupported 'subject_token_type' parameter value, must be one of %q, %q, or %q.",
+			tokenTypeAccessToken, tokenTypeIDToken, tokenTypeJWT)
 	}
 	if params.Get("requested_token_type") != tokenTypeJWT {
 		return nil, fosite.ErrInvalidRequest.WithHintf("Unsupported 'requested_token_type' parameter value, must be %q.", tokenTypeJWT)
 	}
 
+	// The actor token is optional, but when it is sent then its type must also be sent, as described by RFC8693.
+	result.actorToken = params.Get("actor_token")
+	actorTokenType := params.Get("actor_token_type")
+	switch {
+	case result.actorToken == "" && actorTokenType != "":
+		return nil, fosite.ErrInvalidRequest.WithHint("Missing 'actor_token' parameter.")
+	case result.actorToken != "" && actorTokenType == "":
+		return nil, fosite.ErrInvalidRequest.WithHint("Missing 'actor_token_type' parameter.")
+	case result.actorToken != "" && actorTokenType != tokenTypeJWT:
+		return nil, fosite.ErrInvalidRequest.WithHintf("Unsupported 'actor_token_type' parameter value, must be %q.", tokenTypeJWT)
+	}
+
 	// Validate that none of these unsupported parameters were sent. These are optional and we do not currently support them.
 	for _, param := range []string{
 		"resource",
 		"scope",
-		"actor_token",
-		"actor_token_type",
 	} {
 		if params.Get(param) != "" {
 			return nil, fosite.ErrInvalidRequest.WithHintf("Unsupported parameter %q.", param)
@@ -189,6 +296,216 @@ func (t *tokenExchangeHandler) validateParams(params url.Values) (*stsParams, er
 	return &result, nil
 }
 
+func (t *tokenExchangeHandler) validateAccessTokenSubject(ctx context.Context, requester fosite.AccessRequester, accessToken string) (fosite.Requester, error) {
+	// Validate the incoming access token and lookup the information about the original authorize request.
+	originalRequester, err := t.validateAccessToken(ctx, requester, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check that an access token which was bound to a DPoP key is only exchanged along with a DPoP proof signed by that key.
+	// The token endpoint has already validated the DPoP proof, if any, and put its thumbprint onto the context.
+	if err := t.validateDPoPBinding(ctx, originalRequester); err != nil {
+		return nil, err
+	}
+
+	// Check that the currently authenticated client and the client which was originally used to get the access token are the same.
+	if originalRequester.GetClient().GetID() != requester.GetClient().GetID() {
+		// This error message is copied from the similar check in fosite's flow_authorize_code_token.go.
+		return nil, fosite.ErrInvalidGrant.WithHint("The OAuth 2.0 Client ID from this request does not match the one from the authorize request.")
+	}
+
+	return originalRequester, nil
+}
+
+// validateIDTokenSubject validates an ID token which was issued by this FederationDomain to the currently
+// authenticated client, and builds a new session for the identity which it asserts.
+func (t *tokenExchangeHandler) validateIDTokenSubject(ctx context.Context, requester fosite.AccessRequester, idToken string) (fosite.Requester, error) {
+	client := requester.GetClient()
+
+	// ID tokens are not as closely guarded as access tokens (for example, they are often sent to other services
+	// as proof of identity), so only clients which can authenticate using a secret may exchange them.
+	if client.IsPublic() {
+		return nil, fosite.ErrUnauthorizedClient.WithHintf("Public OAuth 2.0 Clients may not exchange tokens of type %q.", tokenTypeIDToken)
+	}
+
+	issuer := t.fositeConfig.GetIDTokenIssuer(ctx)
+	keySet, _ := t.jwksProvider.GetJWKS(issuer)
+	if keySet == nil {
+		return nil, fosite.ErrTemporarilyUnavailable.WithHint("No signing keys are currently available.")
+	}
+	publicKeys := make([]crypto.PublicKey, 0, len(keySet.Keys))
+	for _, key := range keySet.Keys {
+		publicKeys = append(publicKeys, key.Public().Key)
+	}
+
+	// The audience of the ID token must be the client, so that ID tokens which were issued to other clients,
+	// and tokens which were already minted by a token exchange, cannot be exchanged.
+	verifier := coreosoidc.NewVerifier(issuer, &coreosoidc.StaticKeySet{PublicKeys: publicKeys}, &coreosoidc.Config{
+		ClientID:             client.GetID(),
+		SupportedSigningAlgs: []string{coreosoidc.ES256},
+	})
+	token, err := verifier.Verify(ctx, idToken)
+	if err != nil {
+		return nil, errInvalidSubjectToken().WithWrap(err).WithDebug(err.Error())
+	}
+
+	var times struct {
+		AuthTime    int64 `json:"auth_time"`
+		RequestedAt int64 `json:"rat"`
+	}
+	var claims map[string]any
+	if err := token.Claims(&times); err != nil {
+		return nil, errInvalidSubjectToken().WithWrap(err).WithDebug(err.Error())
+	}
+	if err := token.Claims(&claims); err != nil {
+		return nil, errInvalidSubjectToken().WithWrap(err).WithDebug(err.Error())
+	}
+
+	extra := map[string]any{oidcapi.IDTokenClaimAuthorizedParty: client.GetID()}
+	for _, claimName := range []string{oidcapi.IDTokenClaimUsername, oidcapi.IDTokenClaimGroups, oidcapi.IDTokenClaimAdditionalClaims} {
+		if value, ok := claims[claimName]; ok {
+			extra[claimName] = value
+		}
+	}
+
+	return newSubjectRequester(client, &fositejwt.IDTokenClaims{
+		Subject:     token.Subject,
+		AuthTime:    time.Unix(times.AuthTime, 0).UTC(),
+		RequestedAt: time.Unix(times.RequestedAt, 0).UTC(),
+		Extra:       extra,
+	}), nil
+}
+
+// validateTrustedIssuerJWTSubject validates a JWT which was issued by an external issuer which the currently
+// authenticated client trusts, and builds a new session for the identity which it asserts.
+func (t *tokenExchangeHandler) validateTrustedIssuerJWTSubject(requester fosite.AccessRequester, subjectJWT string) (fosite.Requester, error) {
+	client := requester.GetClient()
+
+	identity, err := verifyTrustedIssuerJWT(client, subjectJWT)
+	if err != nil {
+		return nil, errInvalidSubjectToken().WithWrap(err).WithDebug(err.Error())
+	}
+
+	extra := map[string]any{
+		oidcapi.IDTokenClaimAuthorizedParty: client.GetID(),
+		oidcapi.IDTokenClaimUsername:        identity.username,
+	}
+	if identity.groups != nil {
+		extra[oidcapi.IDTokenClaimGroups] = identity.groups
+	}
+
+	now := time.Now().UTC()
+	return newSubjectRequester(client, &fositejwt.IDTokenClaims{
+		Subject:     identity.subject,
+		AuthTime:    now,
+		RequestedAt: now,
+		Extra:       extra,
+	}), nil
+}
+
+// validateActorToken validates a JWT which was issued by an external issuer which the currently authenticated
+// client trusts, and returns the value of the "act" claim which identifies the actor, as described by RFC8693.
+func (t *tokenExchangeHandler) validateActorToken(requester fosite.AccessRequester, actorJWT string) (map[string]any, error) {
+	identity, err := verifyTrustedIssuerJWT(requester.GetClient(), actorJWT)
+	if err != nil {
+		return nil, errInvalidActorToken().WithWrap(err).WithDebug(err.Error())
+	}
+	return map[string]any{
+		oidcapi.IDTokenClaimSubject:  identity.subject,
+		oidcapi.IDTokenClaimUsername: identity.username,
+	}, nil
+}
+
+// verifyTrustedIssuerJWT verifies a JWT which was issued by an external issuer which the client trusts, and returns
+// the identity which it asserts. The groups are nil when the client does not read groups from the issuer's JWTs.
+func verifyTrustedIssuerJWT(client fosite.Client, token string) (*trustedIssuerIdentity, error) {
+	// Read the issuer before verifying the signature, to decide which keys should verify the signature.
+	parsed, err := jwt.ParseSigned(token, jwsalgorithms.Asymmetric)
+	if err != nil {
+		return nil, err
+	}
+	var unverifiedClaims jwt.Claims
+	if err := parsed.UnsafeClaimsWithoutVerification(&unverifiedClaims); err != nil {
+		return nil, err
+	}
+	trustedIssuer := clientregistry.TrustedIssuerFor(client, unverifiedClaims.Issuer)
+	if trustedIssuer == nil {
+		return nil, fmt.Errorf("issuer %q is not trusted by the client", unverifiedClaims.Issuer)
+	}
+
+	publicKeys := make([]crypto.PublicKey, 0, len(trustedIssuer.Keys.Keys))
+	for _, key := range trustedIssuer.Keys.Keys {
+		publicKeys = append(publicKeys, key.Key)
+	}
+	verifier := coreosoidc.NewVerifier(trustedIssuer.Issuer, &coreosoidc.StaticKeySet{PublicKeys: publicKeys}, &coreosoidc.Config{
+		ClientID:             trustedIssuer.Audience,
+		SupportedSigningAlgs: jwsalgorithms.Names(jwsalgorithms.Asymmetric),
+	})
+	// The context is only used to fetch remote keys, and these keys are static.
+	verified, err := verifier.Verify(context.Background(), token)
+	if err != nil {
+		return nil, err
+	}
+	if verified.Subject == "" {
+		return nil, errors.New("the token has no 'sub' claim")
+	}
+
+	var claims map[string]any
+	if err := verified.Claims(&claims); err != nil {
+		return nil, err
+	}
+	username, ok := claims[trustedIssuer.UsernameClaim].(string)
+	if !ok || username == "" {
+		return nil, fmt.Errorf("the token's %q claim must be a non-empty string", trustedIssuer.UsernameClaim)
+	}
+	identity := &trustedIssuerIdentity{
+		subject:  downstreamsubject.TrustedIssuer(trustedIssuer.Issuer, verified.Subject),
+		username: username,
+	}
+	if trustedIssuer.GroupsClaim != "" {
+		groups, ok := groupsFromClaim(claims[trustedIssuer.GroupsClaim])
+		if !ok {
+			return nil, fmt.Errorf("the token's %q claim must be a string or a list of strings", trustedIssuer.GroupsClaim)
+		}
+		identity.groups = groups
+	}
+	return identity, nil
+}
+
+// groupsFromClaim converts the value of a groups claim, which may be missing, a string, or a list of strings.
+func groupsFromClaim(value any) ([]string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return []string{}, true
+	case string:
+		return []string{v}, true
+	case []any:
+		groups := make([]string, 0, len(v))
+		for _, group := range v {
+			groupString, ok := group.(string)
+			if !ok {
+				return nil, false
+			}
+			groups = append(groups, groupString)
+		}
+		return groups, true
+	default:
+		return nil, false
+	}
+}
+
+// newSubjectRequester returns a requester whose session holds the identity asserted by a subject token which does
+// not have any session storage of its own.
+func newSubjectRequester(client fosite.Client, claims *fositejwt.IDTokenClaims) fosite.Requester {
+	session := psession.NewPinnipedSession()
+	session.Fosite.Claims = claims
+	subjectRequester := fosite.NewRequest()
+	subjectRequester.Client = client
+	subjectRequester.Session = session
+	return subjectRequester
+}
+
 func (t *tokenExchangeHandler) validateAccessToken(ctx context.Context, requester fosite.AccessRequester, accessToken string) (fosite.Requester, error) {
 	// Look up the access token's stored session data.
 	signature := t.accessTokenStrategy.AccessTokenSignature(ctx, accessToken)
@@ -0,0 +1,351 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package totpenroll provides a handler for the self-service page where end users enroll an authenticator app
+// for one-time passwords.
+package totpenroll
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/internal/federationdomain/branding"
+	"go.pinniped.dev/internal/federationdomain/endpoints/totpenroll/totpenrollhtml"
+	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
+	"go.pinniped.dev/internal/federationdomain/loginlimiter"
+	"go.pinniped.dev/internal/federationdomain/oidc"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedldap"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/httputil/securityheader"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/internal/totp"
+)
+
+const (
+	idpParamName             = "idp"
+	usernameParamName        = "username"
+	passwordParamName        = "password"
+	currentTOTPCodeParamName = "current_totp_code"
+	enrollStateParamName     = "enroll_state"
+	totpCodeParamName        = "totp_code"
+
+	// enrollStateLifetime is how long the end user has to add the new secret to their authenticator app and
+	// confirm it with a one-time password after their username and password were accepted.
+	enrollStateLifetime = 10 * time.Minute
+
+	noTOTPIDPsErrorMessage = "please check the server's configuration: no identity providers of this FederationDomain use one-time passwords"
+)
+
+// enrollState is the data that is passed from the page which shows the new secret to the request which confirms it.
+// It is encrypted, so it cannot be read or changed by the end user.
+type enrollState struct {
+	// IDPName is the display name of the identity provider which the end user logged in to.
+	IDPName string `json:"p"`
+	// Username is the upstream username of the end user, which is shown by their authenticator app.
+	Username string `json:"u"`
+	// Subject is the downstream subject of the end user, which is used to store their TOTP enrollment.
+	Subject string `json:"s"`
+	// Secret is the new secret which is being enrolled.
+	Secret string `json:"k"`
+	// IssuedAt is the time at which the username and password were accepted.
+	IssuedAt time.Time `json:"i"`
+}
+
+// NewHandler returns a http.Handler that serves the one-time password enrollment page. The end user logs in
+// to one of the FederationDomain's LDAP or ActiveDirectory identity providers which use one-time passwords,
+// is shown a new secret to add to their authenticator app, and then confirms it with a one-time password
+// generated by the app. When the end user has already enrolled, a current one-time password is also required
+// to replace the enrollment, so someone who only knows the end user's password cannot take over their
+// second factor. The passwords are checked using the same loginLimiter as the login page, so this page cannot
+// be used to avoid its limits.
+func NewHandler(
+	issuerURL string,
+	enrollPath string,
+	upstreamIDPs federationdomainproviders.FederationDomainIdentityProvidersListerFinderI,
+	enrollments *totp.Enrollments,
+	loginLimiter *loginlimiter.Limiter,
+	stateCodec oidc.Codec,
+	rand io.Reader,
+) http.Handler {
+	handler := httperr.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		idpNames := totpIDPNames(upstreamIDPs)
+		if len(idpNames) == 0 {
+			return httperr.New(http.StatusNotFound, noTOTPIDPsErrorMessage)
+		}
+
+		pageData := &totpenrollhtml.PageData{
+			PostPath:          enrollPath,
+			Step:              totpenrollhtml.StepLogin,
+			IdentityProviders: idpNames,
+			SelectedIDP:       idpNames[0],
+			Branding:          branding.FromContext(r.Context()),
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			return totpenrollhtml.Template().Execute(w, pageData)
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				return httperr.New(http.StatusBadRequest, "error parsing request params")
+			}
+			if r.PostForm.Has(enrollStateParamName) {
+				confirmEnrollment(r, issuerURL, enrollments, stateCodec, pageData)
+			} else {
+				startEnrollment(r, issuerURL, upstreamIDPs, enrollments, loginLimiter, stateCodec, rand, pageData)
+			}
+			return totpenrollhtml.Template().Execute(w, pageData)
+		default:
+			return httperr.Newf(http.StatusMethodNotAllowed, "%s (try GET or POST)", r.Method)
+		}
+	})
+
+	return wrapSecurityHeaders(handler)
+}
+
+// startEnrollment checks the end user's username and password, and when they are accepted, updates the
+// page data to show a new secret.
+func startEnrollment(
+	r *http.Request,
+	issuerURL string,
+	upstreamIDPs federationdomainproviders.FederationDomainIdentityProvidersFinderI,
+	enrollments *totp.Enrollments,
+	loginLimiter *loginlimiter.Limiter,
+	stateCodec oidc.Codec,
+	rand io.Reader,
+	pageData *totpenrollhtml.PageData,
+) {
+	idpName := r.PostFormValue(idpParamName)
+	submittedUsername := r.PostFormValue(usernameParamName)
+	submittedPassword := r.PostFormValue(passwordParamName)
+
+	messages := pageData.Branding.Messages
+
+	pageData.SelectedIDP = idpName
+	pageData.Username = submittedUsername
+
+	idp := findTOTPIDP(upstreamIDPs, idpName)
+	if idp == nil {
+		setAlert(pageData, messages.TOTPEnrollUnknownIdentityProvider)
+		return
+	}
+
+	// Treat blank username or password as a bad username/password combination, as opposed to an internal error.
+	if submittedUsername == "" || submittedPassword == "" {
+		setAlert(pageData, messages.IncorrectUsernameOrPassword)
+		return
+	}
+
+	// Refuse the login before contacting the upstream when there were too many recent failed logins.
+	// Otherwise, reserve the attempt, so that concurrent attempts are counted before the password is checked.
+	reservation, wait, err := loginLimiter.Reserve(r.Context(), r, submittedUsername)
+	if err != nil {
+		plog.WarningErr("error checking failed logins", err, "upstreamName", idp.Provider.GetResourceName())
+		setAlert(pageData, messages.InternalError)
+		return
+	}
+	if wait > 0 {
+		plog.Info("refusing totp enrollment login due to too many failed logins",
+			"upstreamName", idp.Provider.GetResourceName(), "retryAfter", wait)
+		setAlert(pageData, messages.TooManyLoginAttempts)
+		return
+	}
+	// Release the reservation when the attempt was neither counted as a failure nor as a success.
+	defer func() {
+		if err := reservation.Release(r.Context()); err != nil {
+			plog.WarningErr("error releasing login attempt", err, "upstreamName", idp.Provider.GetResourceName())
+		}
+	}()
+
+	identity, _, err := idp.Login(r.Context(), submittedUsername, submittedPassword)
+	if err != nil {
+		if loginlimiter.IsFailedLogin(err) {
+			if recordErr := reservation.RecordFailure(r.Context()); recordErr != nil {
+				plog.WarningErr("error recording failed login", recordErr, "upstreamName", idp.Provider.GetResourceName())
+			}
+		}
+		setAlert(pageData, alertMessageForLoginError(err, messages))
+		return
+	}
+	if err := reservation.RecordSuccess(r.Context()); err != nil {
+		plog.WarningErr("error forgetting failed logins", err, "upstreamName", idp.Provider.GetResourceName())
+	}
+
+	enrolled, err := enrollments.IsEnrolled(r.Context(), identity.DownstreamSubject)
+	if err != nil {
+		plog.WarningErr("error reading totp enrollment", err, "upstreamName", idp.Provider.GetResourceName())
+		setAlert(pageData, messages.InternalError)
+		return
+	}
+	if enrolled {
+		accepted, err := enrollments.Verify(r.Context(), identity.DownstreamSubject, r.PostFormValue(currentTOTPCodeParamName))
+		if err != nil {
+			plog.WarningErr("error checking one-time password", err, "upstreamName", idp.Provider.GetResourceName())
+			setAlert(pageData, messages.InternalError)
+			return
+		}
+		if !accepted {
+			setAlert(pageData, messages.TOTPEnrollReplaceRequiresCode)
+			return
+		}
+	}
+
+	secret, err := totp.GenerateSecret(rand)
+	if err != nil {
+		plog.Error("error generating totp secret", err)
+		setAlert(pageData, messages.InternalError)
+		return
+	}
+
+	encodedState, err := stateCodec.Encode(oidc.TOTPEnrollStateEncodingName, &enrollState{
+		IDPName:  idpName,
+		Username: identity.UpstreamUsername,
+		Subject:  identity.DownstreamSubject,
+		Secret:   secret,
+		IssuedAt: time.Now(),
+	})
+	if err != nil {
+		plog.Error("error encoding totp enroll state", err)
+		setAlert(pageData, messages.InternalError)
+		return
+	}
+
+	pageData.Step = totpenrollhtml.StepConfirm
+	pageData.EnrollState = encodedState
+	pageData.Secret = displaySecret(secret)
+	pageData.KeyURI = totp.KeyURI(issuerLabel(issuerURL), identity.UpstreamUsername, secret)
+}
+
+// confirmEnrollment stores the new secret when the end user submitted a one-time password which was generated
+// from it, and updates the page data to show the result.
+func confirmEnrollment(
+	r *http.Request,
+	issuerURL string,
+	enrollments *totp.Enrollments,
+	stateCodec oidc.Codec,
+	pageData *totpenrollhtml.PageData,
+) {
+	messages := pageData.Branding.Messages
+	encodedState := r.PostFormValue(enrollStateParamName)
+
+	var decodedState enrollState
+	if err := stateCodec.Decode(oidc.TOTPEnrollStateEncodingName, encodedState, &decodedState); err != nil {
+		plog.InfoErr("error decoding totp enroll state", err)
+		setAlert(pageData, messages.TOTPEnrollExpired)
+		return
+	}
+	pageData.SelectedIDP = decodedState.IDPName
+	if time.Since(decodedState.IssuedAt) > enrollStateLifetime {
+		setAlert(pageData, messages.TOTPEnrollExpired)
+		return
+	}
+
+	timeStep, accepted := totp.Validate(decodedState.Secret, r.PostFormValue(totpCodeParamName), time.Now(), 0)
+	if !accepted {
+		// Show the same secret again, so the end user can fix their authenticator app and try again.
+		pageData.Step = totpenrollhtml.StepConfirm
+		pageData.EnrollState = encodedState
+		pageData.Secret = displaySecret(decodedState.Secret)
+		pageData.KeyURI = totp.KeyURI(issuerLabel(issuerURL), decodedState.Username, decodedState.Secret)
+		setAlert(pageData, messages.TOTPEnrollIncorrectCode)
+		return
+	}
+
+	if err := enrollments.Enroll(r.Context(), decodedState.Subject, decodedState.Secret, timeStep); err != nil {
+		plog.WarningErr("error storing totp enrollment", err, "upstreamName", decodedState.IDPName)
+		setAlert(pageData, messages.InternalError)
+		return
+	}
+
+	plog.Info("totp enrollment stored", "upstreamName", decodedState.IDPName)
+	pageData.Step = totpenrollhtml.StepDone
+}
+
+// totpIDPNames returns the sorted display names of the identity providers which use one-time passwords.
+func totpIDPNames(upstreamIDPs federationdomainproviders.FederationDomainIdentityProvidersListerI) []string {
+	var names []string
+	for _, idp := range upstreamIDPs.GetIdentityProviders() {
+		if ldapIDP, ok := idp.(*resolvedldap.FederationDomainResolvedLDAPIdentityProvider); ok && ldapIDP.TOTPPolicy != totp.PolicyNone {
+			names = append(names, ldapIDP.GetDisplayName())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// findTOTPIDP returns the identity provider with the display name, or nil when it does not use one-time passwords.
+func findTOTPIDP(
+	upstreamIDPs federationdomainproviders.FederationDomainIdentityProvidersFinderI,
+	displayName string,
+) *resolvedldap.FederationDomainResolvedLDAPIdentityProvider {
+	idp, err := upstreamIDPs.FindUpstreamIDPByDisplayName(displayName)
+	if err != nil {
+		return nil
+	}
+	ldapIDP, ok := idp.(*resolvedldap.FederationDomainResolvedLDAPIdentityProvider)
+	if !ok || ldapIDP.TOTPPolicy == totp.PolicyNone {
+		return nil
+	}
+	return ldapIDP
+}
+
+// alertMessageForLoginError returns the message to show for an error returned by the Login of an LDAP or
+// ActiveDirectory identity provider, in the language of the page.
+func alertMessageForLoginError(err error, messages *branding.Messages) string {
+	switch {
+	case errors.Is(err, resolvedldap.ErrUnexpectedUpstreamLDAPError):
+		return messages.InternalError
+	case err == resolvedldap.ErrAccessDeniedDueToUsernamePasswordNotAccepted:
+		return messages.IncorrectUsernameOrPassword
+	case err == resolvedldap.ErrAccessDeniedDueToPasswordExpired:
+		return messages.PasswordExpired
+	case err == resolvedldap.ErrAccessDeniedDueToPasswordMustChange:
+		return messages.PasswordMustChange
+	case err == resolvedldap.ErrAccessDeniedDueToAccountLocked:
+		return messages.AccountLocked
+	}
+	// Other account status errors have hints which are meant to be shown to the end user.
+	var rfc6749Error *fosite.RFC6749Error
+	if errors.As(err, &rfc6749Error) && rfc6749Error.HintField != "" {
+		return rfc6749Error.HintField
+	}
+	return messages.InternalError
+}
+
+// displaySecret splits the secret into groups of four characters, which makes it easier to type by hand.
+// Authenticator apps ignore the spaces.
+func displaySecret(secret string) string {
+	var groups []string
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+	return strings.Join(append(groups, secret), " ")
+}
+
+// issuerLabel returns the name which authenticator apps will show for the enrollment.
+func issuerLabel(issuerURL string) string {
+	parsedURL, err := url.Parse(issuerURL)
+	if err != nil || parsedURL.Host == "" {
+		return issuerURL
+	}
+	return parsedURL.Host
+}
+
+func setAlert(pageData *totpenrollhtml.PageData, message string) {
+	pageData.HasAlertError = true
+	pageData.AlertMessage = message
+}
+
+func wrapSecurityHeaders(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wrapped := securityheader.WrapWithCustomCSPFunc(handler, totpenrollhtml.ContentSecurityPolicyForRequest)
+		wrapped.ServeHTTP(w, r)
+	})
+}
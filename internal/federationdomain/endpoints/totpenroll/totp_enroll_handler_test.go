@@ -0,0 +1,543 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package totpenroll
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"go.pinniped.dev/internal/authenticators"
+	"go.pinniped.dev/internal/federationdomain/branding"
+	"go.pinniped.dev/internal/federationdomain/endpoints/totpenroll/totpenrollhtml"
+	"go.pinniped.dev/internal/federationdomain/loginlimiter"
+	"go.pinniped.dev/internal/federationdomain/oidc"
+	"go.pinniped.dev/internal/testutil"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+	"go.pinniped.dev/internal/testutil/testidplister"
+	testtotp "go.pinniped.dev/internal/testutil/totp"
+	"go.pinniped.dev/internal/totp"
+	"go.pinniped.dev/internal/upstreamldap"
+)
+
+func TestTOTPEnrollHandler(t *testing.T) {
+	const (
+		testIssuer     = "https://pinniped.dev/issuer"
+		testEnrollPath = "/issuer/totp/enroll"
+
+		ldapUpstreamName        = "some-ldap-idp"
+		ldapUpstreamResourceUID = "ldap-resource-uid"
+		adUpstreamName          = "some-active-directory-idp"
+		noTOTPUpstreamName      = "some-ldap-idp-without-totp"
+		upstreamLDAPURL         = "ldaps://some-ldap-host:123?base=ou%3Dusers%2Cdc%3Dpinniped%2Cdc%3Ddev"
+
+		happyUsername                  = "some-ldap-user"
+		happyUsernameFromAuthenticator = "some-mapped-ldap-username"
+		happyPassword                  = "some-ldap-password" //nolint:gosec // this is not a credential
+		happyUID                       = "some-ldap-uid"
+
+		oldTOTPSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+		// The secret which is generated from the zeros returned by the fake random reader.
+		newTOTPSecret        = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+		newTOTPSecretDisplay = "AAAA AAAA AAAA AAAA AAAA AAAA AAAA AAAA"
+	)
+
+	happySubject := upstreamLDAPURL + "&idpName=" + ldapUpstreamName + "&sub=" + happyUID
+
+	parsedUpstreamLDAPURL, err := url.Parse(upstreamLDAPURL)
+	require.NoError(t, err)
+
+	authenticateFunc := func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+		if username == happyUsername && password == happyPassword {
+			return &authenticators.Response{
+				User: &user.DefaultInfo{Name: happyUsernameFromAuthenticator, UID: happyUID},
+				DN:   "cn=foo,dn=bar",
+			}, true, nil
+		}
+		return nil, false, nil
+	}
+
+	newLDAPIDP := func(name string, policy totp.Policy) *oidctestutil.TestUpstreamLDAPIdentityProvider {
+		return oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+			WithName(name).
+			WithResourceUID(ldapUpstreamResourceUID).
+			WithURL(parsedUpstreamLDAPURL).
+			WithAuthenticateFunc(authenticateFunc).
+			WithTOTPPolicyForFederationDomain(policy).
+			Build()
+	}
+
+	happyIDPs := testidplister.NewUpstreamIDPListerBuilder().
+		WithLDAP(newLDAPIDP(ldapUpstreamName, totp.PolicyRequired)).
+		WithActiveDirectory(newLDAPIDP(adUpstreamName, totp.PolicyWhenEnrolled)).
+		WithLDAP(newLDAPIDP(noTOTPUpstreamName, totp.PolicyNone))
+
+	stateCodec := securecookie.New([]byte("fake-enroll-state-hash-secret"), []byte("0123456789ABCDEF"))
+	stateCodec.SetSerializer(securecookie.JSONEncoder{})
+
+	encodeEnrollState := func(state *enrollState) string {
+		encoded, err := stateCodec.Encode(oidc.TOTPEnrollStateEncodingName, state)
+		require.NoError(t, err)
+		return encoded
+	}
+
+	happyEnrollState := encodeEnrollState(&enrollState{
+		IDPName:  ldapUpstreamName,
+		Username: happyUsernameFromAuthenticator,
+		Subject:  happySubject,
+		Secret:   newTOTPSecret,
+		IssuedAt: time.Now(),
+	})
+
+	codeFor := func(secret string) string {
+		code, _ := testtotp.GenerateOTPCode(t, secret, time.Now())
+		return code
+	}
+
+	happyLoginFormParams := url.Values{
+		"idp":      []string{ldapUpstreamName},
+		"username": []string{happyUsername},
+		"password": []string{happyPassword},
+	}
+
+	withFormParam := func(params url.Values, key, value string) url.Values {
+		copied := url.Values{}
+		for k, v := range params {
+			copied[k] = v
+		}
+		copied.Set(key, value)
+		return copied
+	}
+
+	testBranding, err := branding.Parse(map[string][]byte{
+		branding.LogoKey: []byte("\x89PNG\r\n\x1a\n"),
+		"messages.de.json": []byte(`{"totpEnrollHeading": "Einmalpasswörter einrichten", "username": "Benutzername", ` +
+			`"incorrectUsernameOrPassword": "Benutzername oder Passwort ist falsch."}`),
+	})
+	require.NoError(t, err)
+
+	wantAlert := func(message string) string {
+		return `id="alert">` + message + `</span>`
+	}
+
+	wantConfirmPage := []string{
+		`<input type="hidden" name="enroll_state" id="enroll_state" value="`,
+		`<code id="secret" aria-label="authenticator app key">` + newTOTPSecretDisplay + `</code>`,
+		`<code id="key_uri" aria-label="authenticator app key URI">` +
+			html.EscapeString(totp.KeyURI("pinniped.dev", happyUsernameFromAuthenticator, newTOTPSecret)) + `</code>`,
+	}
+
+	tests := []struct {
+		name string
+
+		method              string
+		idps                *testidplister.UpstreamIDPListerBuilder
+		totpEnrolledSubject string
+		formParams          url.Values
+		branding            *branding.Branding
+		acceptLanguage      string
+		priorFailedLogins   int
+
+		wantStatus           int
+		wantContentType      string
+		wantBodyString       string
+		wantBodyContains     []string
+		wantBodyNotContains  []string
+		wantEnrolledSecret   string
+		wantNoTOTPEnrollment bool
+
+		wantLoginBlockedAfterRequest bool
+	}{
+		{
+			name:            "GET shows the login step with only the identity providers which use one-time passwords",
+			method:          http.MethodGet,
+			idps:            happyIDPs,
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBodyContains: []string{
+				`<form action="` + testEnrollPath + `" method="post">`,
+				// Should be sorted alphabetically by display name, and the first one should be selected.
+				`<option value="` + adUpstreamName + `" selected>` + adUpstreamName + `</option>`,
+				`<option value="` + ldapUpstreamName + `">` + ldapUpstreamName + `</option>`,
+				`name="username"`,
+				`name="password"`,
+				`name="current_totp_code"`,
+			},
+			wantBodyNotContains:  []string{noTOTPUpstreamName, `id="alert"`},
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:                 "GET when no identity providers use one-time passwords",
+			method:               http.MethodGet,
+			idps:                 testidplister.NewUpstreamIDPListerBuilder().WithLDAP(newLDAPIDP(noTOTPUpstreamName, totp.PolicyNone)),
+			wantStatus:           http.StatusNotFound,
+			wantContentType:      "text/plain; charset=utf-8",
+			wantBodyString:       "Not Found: please check the server's configuration: no identity providers of this FederationDomain use one-time passwords\n",
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:                 "PUT is not allowed",
+			method:               http.MethodPut,
+			idps:                 happyIDPs,
+			wantStatus:           http.StatusMethodNotAllowed,
+			wantContentType:      "text/plain; charset=utf-8",
+			wantBodyString:       "Method Not Allowed: PUT (try GET or POST)\n",
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:                 "POST login step for user who has not enrolled shows a new secret",
+			method:               http.MethodPost,
+			idps:                 happyIDPs,
+			formParams:           happyLoginFormParams,
+			wantStatus:           http.StatusOK,
+			wantContentType:      "text/html; charset=utf-8",
+			wantBodyContains:     wantConfirmPage,
+			wantBodyNotContains:  []string{`id="alert"`},
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:                "POST login step for user who has enrolled with a current one-time password shows a new secret",
+			method:              http.MethodPost,
+			idps:                happyIDPs,
+			totpEnrolledSubject: happySubject,
+			formParams:          withFormParam(happyLoginFormParams, "current_totp_code", codeFor(oldTOTPSecret)),
+			wantStatus:          http.StatusOK,
+			wantContentType:     "text/html; charset=utf-8",
+			wantBodyContains:    wantConfirmPage,
+			wantBodyNotContains: []string{`id="alert"`},
+			wantEnrolledSecret:  oldTOTPSecret,
+		},
+		{
+			name:                "POST login step for user who has enrolled without a current one-time password",
+			method:              http.MethodPost,
+			idps:                happyIDPs,
+			totpEnrolledSubject: happySubject,
+			formParams:          happyLoginFormParams,
+			wantStatus:          http.StatusOK,
+			wantContentType:     "text/html; charset=utf-8",
+			wantBodyContains: []string{
+				wantAlert("You have already enrolled an authenticator app. Enter a current one-time password from it to replace the enrollment."),
+				`<input type="text" name="username" id="username" value="` + happyUsername + `"`,
+			},
+			wantBodyNotContains: []string{"enroll_state"},
+			wantEnrolledSecret:  oldTOTPSecret,
+		},
+		{
+			name:                 "POST login step with bad password",
+			method:               http.MethodPost,
+			idps:                 happyIDPs,
+			formParams:           withFormParam(happyLoginFormParams, "password", "wrong!"),
+			wantStatus:           http.StatusOK,
+			wantContentType:      "text/html; charset=utf-8",
+			wantBodyContains:     []string{wantAlert("Incorrect username or password.")},
+			wantBodyNotContains:  []string{"enroll_state"},
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:                 "POST login step with blank username",
+			method:               http.MethodPost,
+			idps:                 happyIDPs,
+			formParams:           withFormParam(happyLoginFormParams, "username", ""),
+			wantStatus:           http.StatusOK,
+			wantContentType:      "text/html; charset=utf-8",
+			wantBodyContains:     []string{wantAlert("Incorrect username or password.")},
+			wantBodyNotContains:  []string{"enroll_state"},
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:                 "POST login step with identity provider which does not use one-time passwords",
+			method:               http.MethodPost,
+			idps:                 happyIDPs,
+			formParams:           withFormParam(happyLoginFormParams, "idp", noTOTPUpstreamName),
+			wantStatus:           http.StatusOK,
+			wantContentType:      "text/html; charset=utf-8",
+			wantBodyContains:     []string{wantAlert("The identity provider does not use one-time passwords.")},
+			wantBodyNotContains:  []string{"enroll_state"},
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:   "POST login step when the account is locked",
+			method: http.MethodPost,
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithLDAP(
+				oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+					WithName(ldapUpstreamName).
+					WithResourceUID(ldapUpstreamResourceUID).
+					WithAuthenticateFunc(func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+						return nil, false, &upstreamldap.AccountStatusError{Reason: upstreamldap.AccountStatusAccountLocked}
+					}).
+					WithTOTPPolicyForFederationDomain(totp.PolicyRequired).
+					Build()),
+			formParams:      happyLoginFormParams,
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBodyContains: []string{
+				wantAlert("Your account is locked. Please try again later, or contact your administrator for help."),
+			},
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:   "POST login step when the upstream returns an unexpected error",
+			method: http.MethodPost,
+			idps: testidplister.NewUpstreamIDPListerBuilder().WithLDAP(
+				oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+					WithName(ldapUpstreamName).
+					WithResourceUID(ldapUpstreamResourceUID).
+					WithAuthenticateFunc(func(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
+						return nil, false, fmt.Errorf("some ldap upstream auth error")
+					}).
+					WithTOTPPolicyForFederationDomain(totp.PolicyRequired).
+					Build()),
+			formParams:      happyLoginFormParams,
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBodyContains: []string{
+				wantAlert("An internal error occurred. Please contact your administrator for help."),
+			},
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:   "POST confirm step with a one-time password generated from the new secret stores the enrollment",
+			method: http.MethodPost,
+			idps:   happyIDPs,
+			formParams: url.Values{
+				"enroll_state": []string{happyEnrollState},
+				"totp_code":    []string{codeFor(newTOTPSecret)},
+			},
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBodyContains: []string{
+				`<span id="done">Your authenticator app is enrolled. You will be asked for a one-time password from it when you log in to ` +
+					ldapUpstreamName + `.</span>`,
+			},
+			wantBodyNotContains: []string{`id="alert"`, "<form"},
+			wantEnrolledSecret:  newTOTPSecret,
+		},
+		{
+			name:                "POST confirm step replaces a previous enrollment",
+			method:              http.MethodPost,
+			idps:                happyIDPs,
+			totpEnrolledSubject: happySubject,
+			formParams: url.Values{
+				"enroll_state": []string{happyEnrollState},
+				"totp_code":    []string{codeFor(newTOTPSecret)},
+			},
+			wantStatus:          http.StatusOK,
+			wantContentType:     "text/html; charset=utf-8",
+			wantBodyContains:    []string{`<span id="done">`},
+			wantBodyNotContains: []string{`id="alert"`},
+			wantEnrolledSecret:  newTOTPSecret,
+		},
+		{
+			name:   "POST confirm step with an incorrect one-time password shows the same secret again",
+			method: http.MethodPost,
+			idps:   happyIDPs,
+			formParams: url.Values{
+				"enroll_state": []string{happyEnrollState},
+				"totp_code":    []string{"000000"},
+			},
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBodyContains: append([]string{
+				wantAlert("Incorrect one-time password. Check that the key was added to your authenticator app and try again."),
+				`<input type="hidden" name="enroll_state" id="enroll_state" value="` + happyEnrollState + `">`,
+			}, wantConfirmPage...),
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:   "POST confirm step with an expired enroll state",
+			method: http.MethodPost,
+			idps:   happyIDPs,
+			formParams: url.Values{
+				"enroll_state": []string{encodeEnrollState(&enrollState{
+					IDPName:  ldapUpstreamName,
+					Username: happyUsernameFromAuthenticator,
+					Subject:  happySubject,
+					Secret:   newTOTPSecret,
+					IssuedAt: time.Now().Add(-11 * time.Minute),
+				})},
+				"totp_code": []string{codeFor(newTOTPSecret)},
+			},
+			wantStatus:           http.StatusOK,
+			wantContentType:      "text/html; charset=utf-8",
+			wantBodyContains:     []string{wantAlert("Your enrollment has expired. Please start again."), `name="password"`},
+			wantBodyNotContains:  []string{"enroll_state"},
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:   "POST confirm step with an enroll state which cannot be decoded",
+			method: http.MethodPost,
+			idps:   happyIDPs,
+			formParams: url.Values{
+				"enroll_state": []string{"this-is-not-a-valid-enroll-state"},
+				"totp_code":    []string{codeFor(newTOTPSecret)},
+			},
+			wantStatus:           http.StatusOK,
+			wantContentType:      "text/html; charset=utf-8",
+			wantBodyContains:     []string{wantAlert("Your enrollment has expired. Please start again.")},
+			wantBodyNotContains:  []string{`<span id="done">`},
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:                         "POST login step when there were too many failed logins",
+			method:                       http.MethodPost,
+			idps:                         happyIDPs,
+			formParams:                   happyLoginFormParams,
+			priorFailedLogins:            3,
+			wantStatus:                   http.StatusOK,
+			wantContentType:              "text/html; charset=utf-8",
+			wantBodyContains:             []string{wantAlert("Too many failed login attempts. Please wait a few minutes and try again.")},
+			wantBodyNotContains:          []string{"enroll_state"},
+			wantNoTOTPEnrollment:         true,
+			wantLoginBlockedAfterRequest: true,
+		},
+		{
+			name:                         "POST login step with a bad password records the failed login",
+			method:                       http.MethodPost,
+			idps:                         happyIDPs,
+			formParams:                   withFormParam(happyLoginFormParams, "password", "wrong-password"),
+			priorFailedLogins:            2,
+			wantStatus:                   http.StatusOK,
+			wantContentType:              "text/html; charset=utf-8",
+			wantBodyContains:             []string{wantAlert("Incorrect username or password.")},
+			wantNoTOTPEnrollment:         true,
+			wantLoginBlockedAfterRequest: true,
+		},
+		{
+			name:              "POST login step with a good password after some failed logins",
+			method:            http.MethodPost,
+			idps:              happyIDPs,
+			formParams:        happyLoginFormParams,
+			priorFailedLogins: 2,
+			wantStatus:        http.StatusOK,
+			wantContentType:   "text/html; charset=utf-8",
+			wantBodyContains:  wantConfirmPage,
+		},
+		{
+			name:            "GET with branding shows the page in the language of the request",
+			method:          http.MethodGet,
+			idps:            happyIDPs,
+			branding:        testBranding,
+			acceptLanguage:  "de",
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBodyContains: []string{
+				`<html lang="de">`,
+				"<h1>Einmalpasswörter einrichten</h1>",
+				`placeholder="Benutzername"`,
+				`<img src="data:image/png;base64,iVBORw0KGgo=" alt="">`,
+			},
+			wantNoTOTPEnrollment: true,
+		},
+		{
+			name:                 "POST login step with branding shows the alert in the language of the request",
+			method:               http.MethodPost,
+			idps:                 happyIDPs,
+			formParams:           withFormParam(happyLoginFormParams, "password", "wrong-password"),
+			branding:             testBranding,
+			acceptLanguage:       "de",
+			wantStatus:           http.StatusOK,
+			wantContentType:      "text/html; charset=utf-8",
+			wantBodyContains:     []string{`<html lang="de">`, wantAlert("Benutzername oder Passwort ist falsch.")},
+			wantNoTOTPEnrollment: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			enrollments := totp.NewEnrollments(testIssuer, fake.NewSimpleClientset().CoreV1().Secrets("some-namespace"),
+				func() []byte { return []byte("0123456789abcdef0123456789abcdef") }, rand.Reader, time.Now)
+			if test.totpEnrolledSubject != "" {
+				require.NoError(t, enrollments.Enroll(context.Background(), test.totpEnrolledSubject, oldTOTPSecret, 0))
+			}
+
+			loginLimiter := loginlimiter.New(testIssuer, fake.NewSimpleClientset().CoreV1().Secrets("some-namespace"),
+				loginlimiter.Config{
+					PerUsername: loginlimiter.Limit{AllowedFailures: 2, InitialBackoff: time.Minute, MaxBackoff: time.Hour},
+					PerSourceIP: loginlimiter.Limit{AllowedFailures: 100, InitialBackoff: time.Minute, MaxBackoff: time.Hour},
+				}, time.Now)
+
+			handler := NewHandler(testIssuer, testEnrollPath, test.idps.BuildFederationDomainIdentityProvidersListerFinder(),
+				enrollments, loginLimiter, stateCodec, bytes.NewReader(make([]byte, 100)))
+			handler = test.branding.Wrap(handler)
+
+			req := httptest.NewRequest(test.method, testEnrollPath, strings.NewReader(test.formParams.Encode()))
+			if test.formParams != nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if test.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", test.acceptLanguage)
+			}
+			submittedUsername := test.formParams.Get("username")
+			for range test.priorFailedLogins {
+				reservation, wait, err := loginLimiter.Reserve(context.Background(), req, submittedUsername)
+				require.NoError(t, err)
+				require.Zero(t, wait)
+				require.NoError(t, reservation.RecordFailure(context.Background()))
+			}
+			rsp := httptest.NewRecorder()
+			handler.ServeHTTP(rsp, req)
+
+			require.Equal(t, test.wantStatus, rsp.Code)
+			testutil.RequireEqualContentType(t, rsp.Header().Get("Content-Type"), test.wantContentType)
+			require.Equal(t, test.branding.PageFor(req).ContentSecurityPolicy(totpenrollhtml.ContentSecurityPolicy()),
+				rsp.Header().Get("Content-Security-Policy"))
+
+			if submittedUsername != "" {
+				// The handler must have released its reservation, so another attempt is only refused when blocked.
+				reservation, wait, err := loginLimiter.Reserve(context.Background(), req, submittedUsername)
+				require.NoError(t, err)
+				require.Equal(t, test.wantLoginBlockedAfterRequest, wait > 0)
+				require.NoError(t, reservation.Release(context.Background()))
+			}
+
+			body := rsp.Body.String()
+			if test.wantBodyString != "" {
+				require.Equal(t, test.wantBodyString, body)
+			}
+			for _, want := range test.wantBodyContains {
+				require.Contains(t, body, want)
+			}
+			for _, notWant := range test.wantBodyNotContains {
+				require.NotContains(t, body, notWant)
+			}
+
+			if test.wantNoTOTPEnrollment {
+				enrolled, err := enrollments.IsEnrolled(context.Background(), happySubject)
+				require.NoError(t, err)
+				require.False(t, enrolled)
+			}
+			if test.wantEnrolledSecret != "" {
+				// Use a code from the next time step, since a code from the current time step might have already
+				// been used to confirm the enrollment.
+				nextCode, _ := testtotp.GenerateOTPCode(t, test.wantEnrolledSecret, time.Now().Add(30*time.Second))
+				accepted, err := enrollments.Verify(context.Background(), happySubject, nextCode)
+				require.NoError(t, err)
+				require.True(t, accepted)
+			}
+		})
+	}
+}
+
+func TestDisplaySecret(t *testing.T) {
+	require.Equal(t, "", displaySecret(""))
+	require.Equal(t, "ABC", displaySecret("ABC"))
+	require.Equal(t, "ABCD", displaySecret("ABCD"))
+	require.Equal(t, "ABCD E", displaySecret("ABCDE"))
+	require.Equal(t, "ABCD EFGH", displaySecret("ABCDEFGH"))
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package totpenrollhtml
+
+import (
+	_ "embed" // Needed to trigger //go:embed directives below.
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/tdewolff/minify/v2/minify"
+
+	"go.pinniped.dev/internal/federationdomain/branding"
+	"go.pinniped.dev/internal/federationdomain/csp"
+)
+
+// These are the steps of the enrollment, which decide what the page shows.
+const (
+	// StepLogin asks the end user to choose an identity provider and to log in to it.
+	StepLogin = "login"
+
+	// StepConfirm shows the new secret and asks the end user for a one-time password generated from it.
+	StepConfirm = "confirm"
+
+	// StepDone tells the end user that the enrollment was stored.
+	StepDone = "done"
+)
+
+//nolint:gochecknoglobals // This package uses globals to ensure that all parsing and minifying happens at init.
+var (
+	//go:embed totp_enroll.css
+	rawCSS      string
+	minifiedCSS = panicOnError(minify.CSS(rawCSS))
+
+	//go:embed totp_enroll.gohtml
+	rawHTMLTemplate string
+
+	// Parse the Go templated HTML and inject functions providing the minified inline CSS.
+	parsedHTMLTemplate = template.Must(template.New("totp_enroll.gohtml").Funcs(template.FuncMap{
+		"minifiedCSS": func() template.CSS { return template.CSS(CSS()) },
+	}).Parse(rawHTMLTemplate))
+
+	// Generate the CSP header value once since it's effectively constant.
+	cspValue = strings.Join([]string{
+		`default-src 'none'`,
+		`style-src '` + csp.Hash(minifiedCSS) + `'`,
+		`img-src data:`,
+		`frame-ancestors 'none'`,
+	}, "; ")
+)
+
+func panicOnError(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// ContentSecurityPolicy returns the Content-Security-Policy header value to make the Template() operate correctly.
+//
+// See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Security-Policy.
+func ContentSecurityPolicy() string { return cspValue }
+
+// ContentSecurityPolicyForRequest returns the ContentSecurityPolicy() with the additional sources needed by the
+// branding of the request's FederationDomain.
+func ContentSecurityPolicyForRequest(r *http.Request) string {
+	return branding.FromContext(r.Context()).ContentSecurityPolicy(cspValue)
+}
+
+// Template returns the html/template.Template for rendering the one-time password enrollment page.
+func Template() *template.Template { return parsedHTMLTemplate }
+
+// CSS returns the minified CSS that will be embedded into the page template.
+func CSS() string { return minifiedCSS }
+
+// PageData represents the inputs to the template.
+type PageData struct {
+	PostPath string
+	Step     string
+
+	// Used by StepLogin.
+	IdentityProviders []string
+	SelectedIDP       string
+	Username          string
+
+	// Used by StepConfirm.
+	EnrollState string
+	Secret      string
+	KeyURI      string
+
+	HasAlertError bool
+	AlertMessage  string
+	Branding      *branding.Page
+}
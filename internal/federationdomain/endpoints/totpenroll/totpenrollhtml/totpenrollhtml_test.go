@@ -0,0 +1,185 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package totpenrollhtml
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/internal/federationdomain/branding"
+	"go.pinniped.dev/internal/federationdomain/csp"
+)
+
+var (
+	testExpectedCSS = `html{height:100%}body{font-family:metropolis-light,Helvetica,sans-serif;display:flex;flex-flow:column wrap;justify-content:flex-start;align-items:center;background:linear-gradient(to top,#f8f8f8,white);min-height:100%}h1{font-size:20px;margin:0}.box{display:flex;flex-direction:column;flex-wrap:nowrap;border-radius:4px;border-color:#ddd;border-width:1px;border-style:solid;width:400px;padding:30px 30px 0;margin:60px 20px 0;background:#fff;font-size:14px}input,select{color:inherit;font:inherit;border:0;margin:0;outline:0;padding:0}.form-field{display:flex;margin-bottom:30px}.form-field input[type=password],.form-field input[type=text],.form-field input[type=submit],.form-field select{width:100%;padding:1em}.form-field input[type=password],.form-field input[type=text],.form-field select{border-radius:3px;border-width:1px;border-style:solid;border-color:#a6a6a6}.form-field input[type=submit]{background-color:#218fcf;color:#eee;font-weight:700;cursor:pointer;transition:all .3s}.form-field input[type=submit]:focus,.form-field input[type=submit]:hover{background-color:#1abfd3}.form-field input[type=submit]:active{transform:scale(.99)}.hidden{border:0;clip:rect(0 0 0 0);height:1px;margin:-1px;overflow:hidden;padding:0;position:absolute;width:1px}.form-field code{width:100%;padding:1em;font-size:16px;letter-spacing:1px;word-break:break-all;background-color:#f8f8f8}.alert{color:crimson}`
+
+	// It's okay if this changes in the future, but this gives us a chance to eyeball the formatting.
+	// Our browser-based integration tests should find any incompatibilities.
+	testExpectedCSP = `default-src 'none'; ` +
+		`style-src 'sha256-0+fuPZtN4TwTRnC14iMlEtipO+2eeiEZoCSaLc/zqHQ='; ` +
+		`img-src data:; ` +
+		`frame-ancestors 'none'`
+)
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		name             string
+		pageData         *PageData
+		wantContains     []string
+		wantNotContained []string
+	}{
+		{
+			name: "login step with an alert",
+			pageData: &PageData{
+				PostPath:          "test-post-path",
+				Step:              StepLogin,
+				IdentityProviders: []string{"test-idp-1", "test-idp-2"},
+				SelectedIDP:       "test-idp-2",
+				Username:          "test-username",
+				HasAlertError:     true,
+				AlertMessage:      "test-alert-message",
+				Branding:          branding.Default(),
+			},
+			wantContains: []string{
+				"<style>" + testExpectedCSS + "</style>",
+				`id="alert">test-alert-message</span>`,
+				`<form action="test-post-path" method="post">`,
+				`<option value="test-idp-1">test-idp-1</option>`,
+				`<option value="test-idp-2" selected>test-idp-2</option>`,
+				`<input type="text" name="username" id="username" value="test-username"`,
+				`name="password"`,
+				`name="current_totp_code"`,
+			},
+			wantNotContained: []string{"enroll_state", `id="done"`},
+		},
+		{
+			name: "confirm step without an alert",
+			pageData: &PageData{
+				PostPath:    "test-post-path",
+				Step:        StepConfirm,
+				EnrollState: "test-enroll-state",
+				Secret:      "TESTSECRET",
+				KeyURI:      "otpauth://totp/test-key-uri",
+				Branding:    branding.Default(),
+			},
+			wantContains: []string{
+				"<style>" + testExpectedCSS + "</style>",
+				`<form action="test-post-path" method="post">`,
+				`<input type="hidden" name="enroll_state" id="enroll_state" value="test-enroll-state">`,
+				`<code id="secret" aria-label="authenticator app key">TESTSECRET</code>`,
+				`<code id="key_uri" aria-label="authenticator app key URI">otpauth://totp/test-key-uri</code>`,
+				`name="totp_code"`,
+				`autocomplete="one-time-code"`,
+			},
+			wantNotContained: []string{`id="alert"`, `name="password"`, `id="done"`},
+		},
+		{
+			name: "done step",
+			pageData: &PageData{
+				Step:        StepDone,
+				SelectedIDP: "test-idp-1",
+				Branding:    branding.Default(),
+			},
+			wantContains: []string{
+				`<span id="done">Your authenticator app is enrolled. You will be asked for a one-time password from it when you log in to test-idp-1.</span>`,
+			},
+			wantNotContained: []string{"<form", `id="alert"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, Template().Execute(&buf, tt.pageData))
+			for _, want := range tt.wantContains {
+				require.Contains(t, buf.String(), want)
+			}
+			for _, notWant := range tt.wantNotContained {
+				require.NotContains(t, buf.String(), notWant)
+			}
+		})
+	}
+}
+
+func TestTemplateWithBranding(t *testing.T) {
+	b, err := branding.Parse(map[string][]byte{
+		branding.LogoKey:         []byte("\x89PNG\r\n\x1a\n"),
+		branding.PrimaryColorKey: []byte("#112233"),
+		branding.SupportURLKey:   []byte("https://help.example.com"),
+		"messages.de.json": []byte(`{"totpEnrollHeading": "Einmalpasswörter einrichten", "username": "Benutzername", ` +
+			`"totpEnrollButton": "Registrieren", "totpEnrollDone": "Ihre App ist für {idp} registriert."}`),
+	})
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Language", "de")
+	page := b.PageFor(r)
+
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		PostPath:          "test-post-path",
+		Step:              StepLogin,
+		IdentityProviders: []string{"test-idp-1"},
+		SelectedIDP:       "test-idp-1",
+		Branding:          page,
+	}))
+	require.Contains(t, buf.String(), `<html lang="de">`)
+	require.Contains(t, buf.String(), "<style>"+testExpectedCSS+"</style>\n    <style>"+string(page.CSS)+"</style>")
+	require.Contains(t, buf.String(), `<div class="form-field logo"><img src="data:image/png;base64,iVBORw0KGgo=" alt=""></div>`)
+	require.Contains(t, buf.String(), "<h1>Einmalpasswörter einrichten</h1>")
+	require.Contains(t, buf.String(), `placeholder="Benutzername"`)
+	require.Contains(t, buf.String(), `placeholder="Password"`)
+	require.Contains(t, buf.String(), `<div class="form-field support"><a href="https://help.example.com">Need help?</a></div>`)
+
+	buf = bytes.Buffer{} // clear previous result from buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		PostPath:    "test-post-path",
+		Step:        StepConfirm,
+		EnrollState: "test-enroll-state",
+		Branding:    page,
+	}))
+	require.Contains(t, buf.String(), `value="Registrieren"/>`)
+
+	buf = bytes.Buffer{} // clear previous result from buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		Step:        StepDone,
+		SelectedIDP: "test-idp-1",
+		Branding:    page,
+	}))
+	require.Contains(t, buf.String(), `<span id="done">Ihre App ist für test-idp-1 registriert.</span>`)
+}
+
+func TestContentSecurityPolicy(t *testing.T) {
+	require.Equal(t, testExpectedCSP, ContentSecurityPolicy())
+}
+
+func TestContentSecurityPolicyForRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.Equal(t, testExpectedCSP, ContentSecurityPolicyForRequest(r))
+
+	b, err := branding.Parse(map[string][]byte{
+		branding.LogoKey:         []byte("\x89PNG\r\n\x1a\n"),
+		branding.PrimaryColorKey: []byte("#112233"),
+	})
+	require.NoError(t, err)
+	page := b.PageFor(r)
+	r = r.WithContext(branding.NewContext(r.Context(), page))
+	require.Equal(t, `default-src 'none'; `+
+		`style-src 'sha256-0+fuPZtN4TwTRnC14iMlEtipO+2eeiEZoCSaLc/zqHQ=' '`+csp.Hash(string(page.CSS))+`'; `+
+		`img-src data:; `+
+		`frame-ancestors 'none'`,
+		ContentSecurityPolicyForRequest(r))
+}
+
+func TestCSS(t *testing.T) {
+	require.Equal(t, testExpectedCSS, CSS())
+}
+
+func TestHelpers(t *testing.T) {
+	require.Equal(t, "test", panicOnError("test", nil))
+	require.PanicsWithError(t, "some error", func() { panicOnError("", fmt.Errorf("some error")) })
+}
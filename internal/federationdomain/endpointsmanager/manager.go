@@ -4,31 +4,41 @@
 package endpointsmanager
 
 import (
+	"crypto/rand"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 
 	"go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/typed/config/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/consentgrants"
 	"go.pinniped.dev/internal/federationdomain/csrftoken"
+	"go.pinniped.dev/internal/federationdomain/dpop"
 	"go.pinniped.dev/internal/federationdomain/dynamiccodec"
 	"go.pinniped.dev/internal/federationdomain/endpoints/auth"
 	"go.pinniped.dev/internal/federationdomain/endpoints/callback"
 	"go.pinniped.dev/internal/federationdomain/endpoints/chooseidp"
+	"go.pinniped.dev/internal/federationdomain/endpoints/consent"
 	"go.pinniped.dev/internal/federationdomain/endpoints/discovery"
 	"go.pinniped.dev/internal/federationdomain/endpoints/idpdiscovery"
 	"go.pinniped.dev/internal/federationdomain/endpoints/jwks"
 	"go.pinniped.dev/internal/federationdomain/endpoints/login"
+	"go.pinniped.dev/internal/federationdomain/endpoints/par"
+	"go.pinniped.dev/internal/federationdomain/endpoints/registration"
 	"go.pinniped.dev/internal/federationdomain/endpoints/token"
+	"go.pinniped.dev/internal/federationdomain/endpoints/totpenroll"
 	"go.pinniped.dev/internal/federationdomain/federationdomainproviders"
 	"go.pinniped.dev/internal/federationdomain/idplister"
+	"go.pinniped.dev/internal/federationdomain/loginlimiter"
 	"go.pinniped.dev/internal/federationdomain/oidc"
 	"go.pinniped.dev/internal/federationdomain/oidcclientvalidator"
 	"go.pinniped.dev/internal/federationdomain/storage"
 	"go.pinniped.dev/internal/httputil/requestutil"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/secret"
+	"go.pinniped.dev/internal/totp"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/pkce"
 )
@@ -46,12 +56,17 @@ type Manager struct {
 	secretCache         *secret.Cache                             // in-memory cache of cryptographic material
 	secretsClient       corev1client.SecretInterface
 	oidcClientsClient   v1alpha1.OIDCClientInterface
+	loginLimiterConfig  *loginlimiter.Config  // nil when failed logins are not limited
+	registrationClients *registration.Clients // nil when dynamic client registration is not possible
+	dpopReplays         *dpop.ReplayCache     // survives changes to the FederationDomains, unlike the handlers
 }
 
 // NewManager returns an empty Manager.
 // nextHandler will be invoked for any requests that could not be handled by this manager's providers.
 // dynamicJWKSProvider will be used as an in-memory cache for per-issuer JWKS data.
 // upstreamIDPs will be used as an in-memory cache of currently configured upstream IDPs.
+// loginLimiterConfig configures the limits of failed password logins, or is nil when they should not be limited.
+// registrationClients are used by the dynamic client registration endpoints, or are nil when it is not possible.
 func NewManager(
 	nextHandler http.Handler,
 	dynamicJWKSProvider jwks.DynamicJWKSProvider,
@@ -59,6 +74,8 @@ func NewManager(
 	secretCache *secret.Cache,
 	secretsClient corev1client.SecretInterface,
 	oidcClientsClient v1alpha1.OIDCClientInterface,
+	loginLimiterConfig *loginlimiter.Config,
+	registrationClients *registration.Clients,
 ) *Manager {
 	return &Manager{
 		providerHandlers:    make(map[string]http.Handler),
@@ -68,6 +85,9 @@ func NewManager(
 		secretCache:         secretCache,
 		secretsClient:       secretsClient,
 		oidcClientsClient:   oidcClientsClient,
+		loginLimiterConfig:  loginLimiterConfig,
+		registrationClients: registrationClients,
+		dpopReplays:         dpop.NewReplayCache(),
 	}
 }
 
@@ -92,6 +112,13 @@ func (m *Manager) SetFederationDomains(federationDomains ...*federationdomainpro
 		func() []byte { return nil },
 	)
 
+	// The last chosen identity provider cookie is signed by the same key as the CSRF cookie, but it is valid for longer.
+	lastIDPCookieEncoder := dynamiccodec.New(
+		oidc.LastIDPCookieLifespan,
+		m.secretCache.GetCSRFCookieEncoderHashKey,
+		func() []byte { return nil },
+	)
+
 	for _, incomingFederationDomain := range federationDomains {
 		issuerURL := incomingFederationDomain.Issuer()
 		issuerHostWithPath := strings.ToLower(incomingFederationDomain.IssuerHost()) + "/" + incomingFederationDomain.IssuerPath()
@@ -101,9 +128,10 @@ func (m *Manager) SetFederationDomains(federationDomains ...*federationdomainpro
 		timeoutsConfiguration := oidc.DefaultOIDCTimeoutsConfiguration()
 
 		// Use NullStorage for the authorize endpoint because we do not actually want to store anything until
-		// the upstream callback endpoint is called later.
+		// the upstream callback endpoint is called later. The NullStorage can also be used to look up clients.
+		nullStorage := storage.NewNullStorage(m.secretsClient, m.oidcClientsClient, timeoutsConfiguration, oidcclientvalidator.DefaultMinBcryptCost)
 		oauthHelperWithNullStorage := oidc.FositeOauth2Helper(
-			storage.NewNullStorage(m.secretsClient, m.oidcClientsClient, oidcclientvalidator.DefaultMinBcryptCost),
+			nullStorage,
 			issuerURL,
 			tokenHMACKeyGetter,
 			nil,
@@ -127,13 +155,50 @@ func (m *Manager) SetFederationDomains(federationDomains ...*federationdomainpro
 
 		idpLister := federationdomainproviders.NewFederationDomainIdentityProvidersListerFinder(incomingFederationDomain, m.upstreamIDPs)
 
-		m.providerHandlers[(issuerHostWithPath + oidc.WellKnownEndpointPath)] = discovery.NewHandler(issuerURL)
+		totpEnrollments := totp.NewEnrollments(
+			issuerURL,
+			m.secretsClient,
+			wrapGetter(incomingFederationDomain.Issuer(), m.secretCache.GetTOTPEncryptionKey),
+			rand.Reader,
+			time.Now,
+		)
+
+		var loginLimiter *loginlimiter.Limiter
+		if m.loginLimiterConfig != nil {
+			loginLimiter = loginlimiter.New(issuerURL, m.secretsClient, *m.loginLimiterConfig, time.Now)
+		}
+
+		consentGrants := consentgrants.New(issuerURL, m.secretsClient, rand.Reader, time.Now)
+
+		registrationEndpoint := ""
+		if clientRegistration := incomingFederationDomain.ClientRegistration(); clientRegistration.Enabled && m.registrationClients != nil {
+			registrationEndpoint = issuerURL + oidc.RegistrationEndpointPath
+			m.providerHandlers[(issuerHostWithPath + oidc.RegistrationEndpointPath)] = registration.NewHandler(
+				issuerURL,
+				m.registrationClients,
+				clientRegistration.InitialAccessTokensSecretName,
+				oidcclientvalidator.DefaultMinBcryptCost,
+				rand.Reader,
+				time.Now,
+			)
+		}
+
+		m.providerHandlers[(issuerHostWithPath + oidc.WellKnownEndpointPath)] = discovery.NewHandler(issuerURL, registrationEndpoint)
 
 		m.providerHandlers[(issuerHostWithPath + oidc.JWKSEndpointPath)] = jwks.NewHandler(issuerURL, m.dynamicJWKSProvider)
 
 		m.providerHandlers[(issuerHostWithPath + oidc.PinnipedIDPsPathV1Alpha1)] = idpdiscovery.NewHandler(idpLister)
 
-		m.providerHandlers[(issuerHostWithPath + oidc.AuthorizationEndpointPath)] = auth.NewHandler(
+		// The login page links to the IDP chooser page when the end user could have chosen another identity provider.
+		chooseIDPURL := ""
+		if len(incomingFederationDomain.IdentityProviders()) > 1 && incomingFederationDomain.DefaultIdentityProvider() == nil {
+			chooseIDPURL = issuerURL + oidc.ChooseIDPEndpointPath
+		}
+
+		// The handlers which render web pages use the FederationDomain's branding.
+		fdBranding := incomingFederationDomain.Branding()
+
+		m.providerHandlers[(issuerHostWithPath + oidc.AuthorizationEndpointPath)] = fdBranding.Wrap(auth.NewHandler(
 			issuerURL,
 			idpLister,
 			oauthHelperWithNullStorage,
@@ -143,34 +208,79 @@ func (m *Manager) SetFederationDomains(federationDomains ...*federationdomainpro
 			nonce.Generate,
 			upstreamStateEncoder,
 			csrfCookieEncoder,
-		)
+			totpEnrollments,
+			loginLimiter,
+			incomingFederationDomain.IdentityProviderChooser(),
+			lastIDPCookieEncoder,
+			nullStorage,
+		))
 
-		m.providerHandlers[(issuerHostWithPath + oidc.CallbackEndpointPath)] = callback.NewHandler(
+		m.providerHandlers[(issuerHostWithPath + oidc.CallbackEndpointPath)] = fdBranding.Wrap(callback.NewHandler(
 			idpLister,
 			oauthHelperWithKubeStorage,
 			upstreamStateEncoder,
 			csrfCookieEncoder,
 			issuerURL+oidc.CallbackEndpointPath,
-		)
+			issuerURL+oidc.ConsentEndpointPath,
+			consentGrants,
+		))
 
-		m.providerHandlers[(issuerHostWithPath + oidc.ChooseIDPEndpointPath)] = chooseidp.NewHandler(
+		m.providerHandlers[(issuerHostWithPath + oidc.SAMLACSEndpointPath)] = fdBranding.Wrap(callback.NewSAMLACSHandler(
+			idpLister,
+			oauthHelperWithKubeStorage,
+			upstreamStateEncoder,
+			csrfCookieEncoder,
+			issuerURL+oidc.SAMLACSEndpointPath,
+			issuerURL+oidc.ConsentEndpointPath,
+			consentGrants,
+		))
+
+		m.providerHandlers[(issuerHostWithPath + oidc.ChooseIDPEndpointPath)] = fdBranding.Wrap(chooseidp.NewHandler(
 			issuerURL+oidc.AuthorizationEndpointPath,
 			idpLister,
-		)
+			nullStorage,
+		))
 
 		m.providerHandlers[(issuerHostWithPath + oidc.TokenEndpointPath)] = token.NewHandler(
+			issuerURL,
 			idpLister,
 			oauthHelperWithKubeStorage,
 			timeoutsConfiguration.OverrideDefaultAccessTokenLifespan,
 			timeoutsConfiguration.OverrideDefaultIDTokenLifespan,
+			consentGrants,
+			m.dpopReplays,
+		)
+
+		m.providerHandlers[(issuerHostWithPath + oidc.PushedAuthorizationRequestEndpointPath)] = par.NewHandler(
+			issuerURL,
+			oauthHelperWithKubeStorage,
 		)
 
-		m.providerHandlers[(issuerHostWithPath + oidc.PinnipedLoginPath)] = login.NewHandler(
+		m.providerHandlers[(issuerHostWithPath + oidc.PinnipedLoginPath)] = fdBranding.Wrap(login.NewHandler(
 			upstreamStateEncoder,
 			csrfCookieEncoder,
-			login.NewGetHandler(incomingFederationDomain.IssuerPath()+oidc.PinnipedLoginPath),
-			login.NewPostHandler(issuerURL, idpLister, oauthHelperWithKubeStorage),
-		)
+			login.NewGetHandler(incomingFederationDomain.IssuerPath()+oidc.PinnipedLoginPath, issuerURL+oidc.PinnipedTOTPEnrollPath,
+				chooseIDPURL),
+			login.NewPostHandler(issuerURL, idpLister, oauthHelperWithKubeStorage, totpEnrollments, upstreamStateEncoder,
+				loginLimiter, consentGrants),
+		))
+
+		m.providerHandlers[(issuerHostWithPath + oidc.ConsentEndpointPath)] = fdBranding.Wrap(consent.NewHandler(
+			incomingFederationDomain.IssuerPath()+oidc.ConsentEndpointPath,
+			oauthHelperWithKubeStorage,
+			csrfCookieEncoder,
+			consentGrants,
+		))
+
+		m.providerHandlers[(issuerHostWithPath + oidc.PinnipedTOTPEnrollPath)] = fdBranding.Wrap(totpenroll.NewHandler(
+			issuerURL,
+			incomingFederationDomain.IssuerPath()+oidc.PinnipedTOTPEnrollPath,
+			idpLister,
+			totpEnrollments,
+			loginLimiter,
+			upstreamStateEncoder,
+			rand.Reader,
+		))
 
 		plog.Debug("oidc provider manager added or updated issuer", "issuer", issuerURL)
 	}
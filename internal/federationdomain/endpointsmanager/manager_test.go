@@ -124,8 +124,11 @@ func TestManager(t *testing.T) {
 				"pinniped_supported_identity_provider_types": [
 					{"type":"activedirectory"},
 					{"type":"github"},
+					{"type":"gitlab"},
 					{"type":"ldap"},
-					{"type":"oidc"}
+					{"type":"oauth2"},
+					{"type":"oidc"},
+					{"type":"saml"}
 				]
 }`, strings.Join(expectedIDPJSONList, ","))
 
@@ -358,7 +361,7 @@ func TestManager(t *testing.T) {
 			cache.SetStateEncoderHashKey(issuer2, []byte("some-state-encoder-hash-key-2"))
 			cache.SetStateEncoderBlockKey(issuer2, []byte("16-bytes-STATE02"))
 
-			subject = NewManager(nextHandler, dynamicJWKSProvider, idpLister, &cache, secretsClient, oidcClientsClient)
+			subject = NewManager(nextHandler, dynamicJWKSProvider, idpLister, &cache, secretsClient, oidcClientsClient, nil, nil)
 		})
 
 		when("given no providers via SetFederationDomains()", func() {
@@ -4,7 +4,9 @@
 package federationdomainproviders
 
 import (
+	"context"
 	"fmt"
+	"slices"
 
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -12,19 +14,41 @@ import (
 	"go.pinniped.dev/internal/federationdomain/idplister"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedgithub"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedgitlab"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedldap"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedoauth2"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedoidc"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedsaml"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
 	"go.pinniped.dev/internal/idtransform"
 	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/totp"
 )
 
 // FederationDomainIdentityProvider represents an identity provider as configured in a FederationDomain's spec.
-// All the fields are required and must be non-zero values. Note that this might be a reference to an IDP
-// which is not currently loaded into the cache of available IDPs, e.g. due to the IDP's CR having validation errors.
+// All the fields are required and must be non-zero values, except for GroupEnrichment and TOTPPolicy which are optional.
+// Note that this might be a reference to an IDP which is not currently loaded into the cache of available IDPs,
+// e.g. due to the IDP's CR having validation errors.
 type FederationDomainIdentityProvider struct {
-	DisplayName string
-	UID         types.UID
-	Transforms  *idtransform.TransformationPipeline
+	DisplayName     string
+	UID             types.UID
+	Transforms      *idtransform.TransformationPipeline
+	GroupEnrichment *GroupEnrichment
+	// TOTPPolicy describes when end users must provide a one-time password. It only applies to LDAP and
+	// ActiveDirectory identity providers.
+	TOTPPolicy totp.Policy
+}
+
+// GroupEnrichment represents the configuration for looking up additional group memberships of users from a
+// secondary LDAP or ActiveDirectory identity provider. Note that the secondary IDP might not be currently loaded
+// into the cache of available IDPs.
+type GroupEnrichment struct {
+	// SourceUID is the UID of the secondary identity provider.
+	SourceUID types.UID
+	// SourceName is the resource name of the secondary identity provider, to be used in error messages.
+	SourceName string
+	// Key computes the value that will be used to find the user in the secondary identity provider.
+	Key idtransform.GroupEnrichmentKey
 }
 
 type FederationDomainIdentityProvidersFinderI interface {
@@ -146,10 +170,14 @@ func (u *FederationDomainIdentityProvidersListerFinder) GetIdentityProviders() [
 	cachedLDAPProviders := u.wrappedLister.GetLDAPIdentityProviders()
 	cachedADProviders := u.wrappedLister.GetActiveDirectoryIdentityProviders()
 	cachedGitHubProviders := u.wrappedLister.GetGitHubIdentityProviders()
+	cachedSAMLProviders := u.wrappedLister.GetSAMLIdentityProviders()
+	cachedGitLabProviders := u.wrappedLister.GetGitLabIdentityProviders()
+	cachedOAuth2Providers := u.wrappedLister.GetOAuth2IdentityProviders()
 	providers := []resolvedprovider.FederationDomainResolvedIdentityProvider{}
 	// Every configured identityProvider on the FederationDomain uses an objetRef to an underlying IDP CR that might
 	// be available as a provider in the wrapped cache. For each configured identityProvider/displayName...
 	for _, idp := range u.configuredIdentityProviders {
+		transforms := transformsWithGroupEnrichment(idp, append(slices.Clone(cachedLDAPProviders), cachedADProviders...))
 		// Check if the IDP used by that displayName is in the cached available OIDC providers.
 		for _, p := range cachedOIDCProviders {
 			if idp.UID == p.GetResourceUID() {
@@ -158,7 +186,7 @@ func (u *FederationDomainIdentityProvidersListerFinder) GetIdentityProviders() [
 					DisplayName:         idp.DisplayName,
 					Provider:            p,
 					SessionProviderType: psession.ProviderTypeOIDC,
-					Transforms:          idp.Transforms,
+					Transforms:          transforms,
 				})
 			}
 		}
@@ -170,7 +198,8 @@ func (u *FederationDomainIdentityProvidersListerFinder) GetIdentityProviders() [
 					DisplayName:         idp.DisplayName,
 					Provider:            p,
 					SessionProviderType: psession.ProviderTypeLDAP,
-					Transforms:          idp.Transforms,
+					Transforms:          transforms,
+					TOTPPolicy:          idp.TOTPPolicy,
 				})
 			}
 		}
@@ -182,7 +211,8 @@ func (u *FederationDomainIdentityProvidersListerFinder) GetIdentityProviders() [
 					DisplayName:         idp.DisplayName,
 					Provider:            p,
 					SessionProviderType: psession.ProviderTypeActiveDirectory,
-					Transforms:          idp.Transforms,
+					Transforms:          transforms,
+					TOTPPolicy:          idp.TOTPPolicy,
 				})
 			}
 		}
@@ -192,10 +222,71 @@ func (u *FederationDomainIdentityProvidersListerFinder) GetIdentityProviders() [
 					DisplayName:         idp.DisplayName,
 					Provider:            p,
 					SessionProviderType: psession.ProviderTypeGitHub,
-					Transforms:          idp.Transforms,
+					Transforms:          transforms,
+				})
+			}
+		}
+		for _, p := range cachedSAMLProviders {
+			if idp.UID == p.GetResourceUID() {
+				providers = append(providers, &resolvedsaml.FederationDomainResolvedSAMLIdentityProvider{
+					DisplayName:         idp.DisplayName,
+					Provider:            p,
+					SessionProviderType: psession.ProviderTypeSAML,
+					Transforms:          transforms,
+				})
+			}
+		}
+		for _, p := range cachedGitLabProviders {
+			if idp.UID == p.GetResourceUID() {
+				providers = append(providers, &resolvedgitlab.FederationDomainResolvedGitLabIdentityProvider{
+					DisplayName:         idp.DisplayName,
+					Provider:            p,
+					SessionProviderType: psession.ProviderTypeGitLab,
+					Transforms:          transforms,
+				})
+			}
+		}
+		for _, p := range cachedOAuth2Providers {
+			if idp.UID == p.GetResourceUID() {
+				providers = append(providers, &resolvedoauth2.FederationDomainResolvedOAuth2IdentityProvider{
+					DisplayName:         idp.DisplayName,
+					Provider:            p,
+					SessionProviderType: psession.ProviderTypeOAuth2,
+					Transforms:          transforms,
 				})
 			}
 		}
 	}
 	return providers
 }
+
+// transformsWithGroupEnrichment returns the transformation pipeline for the IDP. When group enrichment is configured,
+// then the lookup of additional groups is appended to the end of the pipeline, so it happens after all the
+// configured transformations during every authentication and every session refresh.
+func transformsWithGroupEnrichment(
+	idp *FederationDomainIdentityProvider,
+	cachedLDAPAndADProviders []upstreamprovider.UpstreamLDAPIdentityProviderI,
+) *idtransform.TransformationPipeline {
+	if idp.GroupEnrichment == nil {
+		return idp.Transforms
+	}
+
+	var source upstreamprovider.UpstreamLDAPIdentityProviderI
+	for _, p := range cachedLDAPAndADProviders {
+		if p.GetResourceUID() == idp.GroupEnrichment.SourceUID {
+			source = p
+			break
+		}
+	}
+
+	sourceName := idp.GroupEnrichment.SourceName
+	lookup := func(ctx context.Context, key string) ([]string, error) {
+		if source == nil {
+			// Fail closed, so that users do not silently lose the groups which they would usually get from the lookup.
+			return nil, fmt.Errorf("group enrichment identity provider not available: %q", sourceName)
+		}
+		return source.LookupUserGroups(ctx, key)
+	}
+
+	return idp.Transforms.WithTransformation(idtransform.NewGroupEnrichmentTransformation(idp.GroupEnrichment.Key, lookup))
+}
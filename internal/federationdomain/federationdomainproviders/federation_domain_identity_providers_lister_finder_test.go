@@ -4,17 +4,27 @@
 package federationdomainproviders
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
 
+	"go.pinniped.dev/internal/celtransformer"
 	"go.pinniped.dev/internal/federationdomain/idplister"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedgithub"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedgitlab"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedldap"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedoauth2"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedoidc"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedsaml"
+	"go.pinniped.dev/internal/idtransform"
 	"go.pinniped.dev/internal/testutil/oidctestutil"
 	"go.pinniped.dev/internal/testutil/testidplister"
+	"go.pinniped.dev/internal/totp"
 )
 
 func TestFederationDomainIdentityProvidersListerFinder(t *testing.T) {
@@ -61,6 +71,18 @@ func TestFederationDomainIdentityProvidersListerFinder(t *testing.T) {
 		WithName("my-github-idp1").
 		WithResourceUID("my-github-uid-idp1").
 		Build()
+	mySAMLIDP1 := oidctestutil.NewTestUpstreamSAMLIdentityProviderBuilder().
+		WithName("my-saml-idp1").
+		WithResourceUID("my-saml-uid-idp1").
+		Build()
+	myGitLabIDP1 := oidctestutil.NewTestUpstreamGitLabIdentityProviderBuilder().
+		WithName("my-gitlab-idp1").
+		WithResourceUID("my-gitlab-uid-idp1").
+		Build()
+	myOAuth2IDP1 := oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+		WithName("my-oauth2-idp1").
+		WithResourceUID("my-oauth2-uid-idp1").
+		Build()
 
 	// FederationDomainIssuers
 	fakeIssuerURL := "https://www.fakeissuerurl.com"
@@ -103,6 +125,24 @@ func TestFederationDomainIdentityProvidersListerFinder(t *testing.T) {
 	})
 	require.NoError(t, err)
 
+	fdIssuerWithOIDCAndSAMLIDPs, err := NewFederationDomainIssuer(fakeIssuerURL, []*FederationDomainIdentityProvider{
+		{DisplayName: "my-oidc-idp1", UID: "my-oidc-uid-idp1"},
+		{DisplayName: "my-saml-idp1", UID: "my-saml-uid-idp1"},
+	})
+	require.NoError(t, err)
+
+	fdIssuerWithOIDCAndGitLabIDPs, err := NewFederationDomainIssuer(fakeIssuerURL, []*FederationDomainIdentityProvider{
+		{DisplayName: "my-oidc-idp1", UID: "my-oidc-uid-idp1"},
+		{DisplayName: "my-gitlab-idp1", UID: "my-gitlab-uid-idp1"},
+	})
+	require.NoError(t, err)
+
+	fdIssuerWithOIDCAndOAuth2IDPs, err := NewFederationDomainIssuer(fakeIssuerURL, []*FederationDomainIdentityProvider{
+		{DisplayName: "my-oidc-idp1", UID: "my-oidc-uid-idp1"},
+		{DisplayName: "my-oauth2-idp1", UID: "my-oauth2-uid-idp1"},
+	})
+	require.NoError(t, err)
+
 	fdIssuerWithLotsOfIDPs, err := NewFederationDomainIssuer(fakeIssuerURL, []*FederationDomainIdentityProvider{
 		{DisplayName: "my-oidc-idp1", UID: "my-oidc-uid-idp1"},
 		{DisplayName: "my-oidc-idp2", UID: "my-oidc-uid-idp2"},
@@ -155,6 +195,21 @@ func TestFederationDomainIdentityProvidersListerFinder(t *testing.T) {
 		Provider:            myGitHubIDP1,
 		SessionProviderType: "github",
 	}
+	mySAML1Resolved := &resolvedsaml.FederationDomainResolvedSAMLIdentityProvider{
+		DisplayName:         "my-saml-idp1",
+		Provider:            mySAMLIDP1,
+		SessionProviderType: "saml",
+	}
+	myGitLab1Resolved := &resolvedgitlab.FederationDomainResolvedGitLabIdentityProvider{
+		DisplayName:         "my-gitlab-idp1",
+		Provider:            myGitLabIDP1,
+		SessionProviderType: "gitlab",
+	}
+	myOAuth21Resolved := &resolvedoauth2.FederationDomainResolvedOAuth2IdentityProvider{
+		DisplayName:         "my-oauth2-idp1",
+		Provider:            myOAuth2IDP1,
+		SessionProviderType: "oauth2",
+	}
 
 	myDefaultOIDCIDPResolved := &resolvedoidc.FederationDomainResolvedOIDCIdentityProvider{
 		DisplayName:         "my-default-oidc-idp",
@@ -180,6 +235,9 @@ func TestFederationDomainIdentityProvidersListerFinder(t *testing.T) {
 		wantOIDCIDPByDisplayName   *resolvedoidc.FederationDomainResolvedOIDCIdentityProvider
 		wantLDAPIDPByDisplayName   *resolvedldap.FederationDomainResolvedLDAPIdentityProvider
 		wantGitHubIDPByDisplayName *resolvedgithub.FederationDomainResolvedGitHubIdentityProvider
+		wantSAMLIDPByDisplayName   *resolvedsaml.FederationDomainResolvedSAMLIdentityProvider
+		wantGitLabIDPByDisplayName *resolvedgitlab.FederationDomainResolvedGitLabIdentityProvider
+		wantOAuth2IDPByDisplayName *resolvedoauth2.FederationDomainResolvedOAuth2IdentityProvider
 		wantError                  string
 	}{
 		{
@@ -271,6 +329,39 @@ func TestFederationDomainIdentityProvidersListerFinder(t *testing.T) {
 			federationDomainIssuer:     fdIssuerWithOIDCAndLDAPAndADAndGitHubIDPs,
 			wantGitHubIDPByDisplayName: myGitHub1Resolved,
 		},
+		{
+			name:                 "FindUpstreamIDPByDisplayName will find an upstream IDP of type SAML by display name",
+			findIDPByDisplayName: "my-saml-idp1",
+			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(myOIDCIDP1).
+				WithGitHub(myGitHubIDP1).
+				WithSAML(mySAMLIDP1).
+				BuildDynamicUpstreamIDPProvider(),
+			federationDomainIssuer:   fdIssuerWithOIDCAndSAMLIDPs,
+			wantSAMLIDPByDisplayName: mySAML1Resolved,
+		},
+		{
+			name:                 "FindUpstreamIDPByDisplayName will find an upstream IDP of type GitLab by display name",
+			findIDPByDisplayName: "my-gitlab-idp1",
+			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(myOIDCIDP1).
+				WithGitHub(myGitHubIDP1).
+				WithGitLab(myGitLabIDP1).
+				BuildDynamicUpstreamIDPProvider(),
+			federationDomainIssuer:     fdIssuerWithOIDCAndGitLabIDPs,
+			wantGitLabIDPByDisplayName: myGitLab1Resolved,
+		},
+		{
+			name:                 "FindUpstreamIDPByDisplayName will find an upstream IDP of type OAuth2 by display name",
+			findIDPByDisplayName: "my-oauth2-idp1",
+			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(myOIDCIDP1).
+				WithGitHub(myGitHubIDP1).
+				WithOAuth2(myOAuth2IDP1).
+				BuildDynamicUpstreamIDPProvider(),
+			federationDomainIssuer:     fdIssuerWithOIDCAndOAuth2IDPs,
+			wantOAuth2IDPByDisplayName: myOAuth21Resolved,
+		},
 		{
 			name:                 "FindUpstreamIDPByDisplayName will error if IDP by display name is not found - no such display name",
 			findIDPByDisplayName: "i-cant-find-my-idp",
@@ -316,6 +407,15 @@ func TestFederationDomainIdentityProvidersListerFinder(t *testing.T) {
 			if tt.wantGitHubIDPByDisplayName != nil {
 				require.Equal(t, tt.wantGitHubIDPByDisplayName, foundIDP)
 			}
+			if tt.wantSAMLIDPByDisplayName != nil {
+				require.Equal(t, tt.wantSAMLIDPByDisplayName, foundIDP)
+			}
+			if tt.wantGitLabIDPByDisplayName != nil {
+				require.Equal(t, tt.wantGitLabIDPByDisplayName, foundIDP)
+			}
+			if tt.wantOAuth2IDPByDisplayName != nil {
+				require.Equal(t, tt.wantOAuth2IDPByDisplayName, foundIDP)
+			}
 		})
 	}
 
@@ -454,6 +554,45 @@ func TestFederationDomainIdentityProvidersListerFinder(t *testing.T) {
 				myGitHub1Resolved,
 			},
 		},
+		{
+			name: "GetIdentityProviders will list SAML identity providers",
+			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(myOIDCIDP1).
+				WithGitHub(myGitHubIDP1).
+				WithSAML(mySAMLIDP1).
+				BuildDynamicUpstreamIDPProvider(),
+			federationDomainIssuer: fdIssuerWithOIDCAndSAMLIDPs,
+			wantIDPs: []resolvedprovider.FederationDomainResolvedIdentityProvider{
+				myOIDCIDP1Resolved,
+				mySAML1Resolved,
+			},
+		},
+		{
+			name: "GetIdentityProviders will list GitLab identity providers",
+			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(myOIDCIDP1).
+				WithGitHub(myGitHubIDP1).
+				WithGitLab(myGitLabIDP1).
+				BuildDynamicUpstreamIDPProvider(),
+			federationDomainIssuer: fdIssuerWithOIDCAndGitLabIDPs,
+			wantIDPs: []resolvedprovider.FederationDomainResolvedIdentityProvider{
+				myOIDCIDP1Resolved,
+				myGitLab1Resolved,
+			},
+		},
+		{
+			name: "GetIdentityProviders will list OAuth2 identity providers",
+			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(myOIDCIDP1).
+				WithGitHub(myGitHubIDP1).
+				WithOAuth2(myOAuth2IDP1).
+				BuildDynamicUpstreamIDPProvider(),
+			federationDomainIssuer: fdIssuerWithOIDCAndOAuth2IDPs,
+			wantIDPs: []resolvedprovider.FederationDomainResolvedIdentityProvider{
+				myOIDCIDP1Resolved,
+				myOAuth21Resolved,
+			},
+		},
 		{
 			name: "GetIdentityProviders will return empty list if no IDPs are found",
 			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
@@ -588,3 +727,184 @@ func TestFederationDomainIdentityProvidersListerFinder(t *testing.T) {
 		})
 	}
 }
+
+func TestFederationDomainIdentityProvidersListerFinderGroupEnrichment(t *testing.T) {
+	transformer, err := celtransformer.NewCELTransformer(5 * time.Second)
+	require.NoError(t, err)
+	key, err := transformer.CompileGroupEnrichmentKey(&celtransformer.GroupEnrichmentKey{
+		Expression: `username == "skip-me" ? "" : "uid=" + username`,
+	}, nil)
+	require.NoError(t, err)
+
+	oidcIDP := oidctestutil.NewTestUpstreamOIDCIdentityProviderBuilder().
+		WithName("my-oidc-idp").
+		WithResourceUID("my-oidc-uid-idp").
+		Build()
+	enrichmentLDAPIDP := oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+		WithName("my-enrichment-ldap-idp").
+		WithResourceUID("my-enrichment-ldap-uid-idp").
+		WithLookupUserGroupsFunc(func(_ context.Context, key string) ([]string, error) {
+			if key == "uid=broken" {
+				return nil, errors.New("some lookup error")
+			}
+			return []string{"enriched-group", "group1"}, nil
+		}).
+		Build()
+	enrichmentADIDP := oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+		WithName("my-enrichment-ad-idp").
+		WithResourceUID("my-enrichment-ad-uid-idp").
+		WithLookupUserGroupsFunc(func(_ context.Context, _ string) ([]string, error) {
+			return []string{"enriched-ad-group"}, nil
+		}).
+		Build()
+
+	newIssuer := func(t *testing.T, sourceUID types.UID, sourceName string) *FederationDomainIssuer {
+		t.Helper()
+		fdIssuer, err := NewFederationDomainIssuer("https://www.fakeissuerurl.com", []*FederationDomainIdentityProvider{
+			{
+				DisplayName: "my-oidc-idp",
+				UID:         "my-oidc-uid-idp",
+				Transforms:  idtransform.NewTransformationPipeline(),
+				GroupEnrichment: &GroupEnrichment{
+					SourceUID:  sourceUID,
+					SourceName: sourceName,
+					Key:        key,
+				},
+			},
+		})
+		require.NoError(t, err)
+		return fdIssuer
+	}
+
+	tests := []struct {
+		name          string
+		wrappedLister idplister.UpstreamIdentityProvidersLister
+		fdIssuer      *FederationDomainIssuer
+		username      string
+		wantGroups    []string
+		wantErr       string
+		wantLookups   []string
+	}{
+		{
+			name: "adds the groups found in the LDAP source",
+			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(oidcIDP).
+				WithLDAP(enrichmentLDAPIDP).
+				BuildDynamicUpstreamIDPProvider(),
+			fdIssuer:    newIssuer(t, "my-enrichment-ldap-uid-idp", "my-enrichment-ldap-idp"),
+			username:    "ryan",
+			wantGroups:  []string{"enriched-group", "group1", "group2"},
+			wantLookups: []string{"uid=ryan"},
+		},
+		{
+			name: "adds the groups found in the AD source",
+			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(oidcIDP).
+				WithActiveDirectory(enrichmentADIDP).
+				BuildDynamicUpstreamIDPProvider(),
+			fdIssuer:   newIssuer(t, "my-enrichment-ad-uid-idp", "my-enrichment-ad-idp"),
+			username:   "ryan",
+			wantGroups: []string{"enriched-ad-group", "group1", "group2"},
+		},
+		{
+			name: "does not perform a lookup when the key is empty",
+			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(oidcIDP).
+				WithLDAP(enrichmentLDAPIDP).
+				BuildDynamicUpstreamIDPProvider(),
+			fdIssuer:   newIssuer(t, "my-enrichment-ldap-uid-idp", "my-enrichment-ldap-idp"),
+			username:   "skip-me",
+			wantGroups: []string{"group1", "group2"},
+		},
+		{
+			name: "fails when the lookup fails",
+			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(oidcIDP).
+				WithLDAP(enrichmentLDAPIDP).
+				BuildDynamicUpstreamIDPProvider(),
+			fdIssuer:    newIssuer(t, "my-enrichment-ldap-uid-idp", "my-enrichment-ldap-idp"),
+			username:    "broken",
+			wantErr:     "identity transformation at index 0: group enrichment lookup: some lookup error",
+			wantLookups: []string{"uid=broken"},
+		},
+		{
+			name: "fails when the source is not available",
+			wrappedLister: testidplister.NewUpstreamIDPListerBuilder().
+				WithOIDC(oidcIDP).
+				BuildDynamicUpstreamIDPProvider(),
+			fdIssuer: newIssuer(t, "my-enrichment-ldap-uid-idp", "my-enrichment-ldap-idp"),
+			username: "ryan",
+			wantErr:  `identity transformation at index 0: group enrichment lookup: group enrichment identity provider not available: "my-enrichment-ldap-idp"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Not parallel because the test LDAP providers record the lookups.
+			subject := NewFederationDomainIdentityProvidersListerFinder(tt.fdIssuer, tt.wrappedLister)
+
+			idp, err := subject.FindUpstreamIDPByDisplayName("my-oidc-idp")
+			require.NoError(t, err)
+
+			lookupsBefore := len(enrichmentLDAPIDP.LookupUserGroupsArgs())
+			result, err := idp.GetTransforms().Evaluate(context.Background(), tt.username, []string{"group2", "group1"}, nil)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, result)
+			} else {
+				require.NoError(t, err)
+				require.True(t, result.AuthenticationAllowed)
+				require.Equal(t, tt.username, result.Username)
+				require.Equal(t, tt.wantGroups, result.Groups)
+			}
+			require.Equal(t, tt.wantLookups, nilIfEmpty(enrichmentLDAPIDP.LookupUserGroupsArgs()[lookupsBefore:]))
+		})
+	}
+}
+
+func TestFederationDomainIdentityProvidersListerFinderTOTPPolicy(t *testing.T) {
+	ldapIDP := oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+		WithName("my-ldap-idp").
+		WithResourceUID("my-ldap-uid-idp").
+		Build()
+	adIDP := oidctestutil.NewTestUpstreamLDAPIdentityProviderBuilder().
+		WithName("my-ad-idp").
+		WithResourceUID("my-ad-uid-idp").
+		Build()
+
+	fdIssuer, err := NewFederationDomainIssuer("https://www.fakeissuerurl.com", []*FederationDomainIdentityProvider{
+		{
+			DisplayName: "my-ldap-idp",
+			UID:         "my-ldap-uid-idp",
+			Transforms:  idtransform.NewTransformationPipeline(),
+			TOTPPolicy:  totp.PolicyRequired,
+		},
+		{
+			DisplayName: "my-ad-idp",
+			UID:         "my-ad-uid-idp",
+			Transforms:  idtransform.NewTransformationPipeline(),
+			TOTPPolicy:  totp.PolicyWhenEnrolled,
+		},
+	})
+	require.NoError(t, err)
+
+	subject := NewFederationDomainIdentityProvidersListerFinder(fdIssuer, testidplister.NewUpstreamIDPListerBuilder().
+		WithLDAP(ldapIDP).
+		WithActiveDirectory(adIDP).
+		BuildDynamicUpstreamIDPProvider())
+
+	idp, err := subject.FindUpstreamIDPByDisplayName("my-ldap-idp")
+	require.NoError(t, err)
+	require.Equal(t, totp.PolicyRequired, idp.(*resolvedldap.FederationDomainResolvedLDAPIdentityProvider).TOTPPolicy)
+
+	idp, err = subject.FindUpstreamIDPByDisplayName("my-ad-idp")
+	require.NoError(t, err)
+	require.Equal(t, totp.PolicyWhenEnrolled, idp.(*resolvedldap.FederationDomainResolvedLDAPIdentityProvider).TOTPPolicy)
+}
+
+func nilIfEmpty(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
@@ -1,4 +1,4 @@
-// Copyright 2020-2023 the Pinniped contributors. All Rights Reserved.
+// Copyright 2020-2024 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package federationdomainproviders
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/federationdomain/branding"
 )
 
 // FederationDomainIssuer is a parsed FederationDomain representing all the settings for a downstream OIDC provider
@@ -24,6 +25,33 @@ type FederationDomainIssuer struct {
 	// are not explicitly specified in the FederationDomain's spec, and there is exactly one IDP CR defined in the
 	// Supervisor's namespace.
 	defaultIdentityProvider *FederationDomainIdentityProvider
+
+	// branding is nil when the FederationDomain's web pages are not customized.
+	branding *branding.Branding
+
+	identityProviderChooser IdentityProviderChooser
+
+	clientRegistration ClientRegistration
+}
+
+// IdentityProviderChooser configures how end users choose one of the identity providers of a FederationDomain
+// during a browser-based login. The zero value always shows the chooser page.
+type IdentityProviderChooser struct {
+	// RememberLastChosen remembers the identity provider which an end user last chose in a browser cookie.
+	RememberLastChosen bool
+	// AutoRedirect skips the chooser page when the FederationDomain has only one identity provider, or when
+	// an identity provider was remembered.
+	AutoRedirect bool
+}
+
+// ClientRegistration configures the dynamic client registration endpoint of a FederationDomain. The zero value
+// does not serve the endpoint.
+type ClientRegistration struct {
+	// Enabled serves the endpoint.
+	Enabled bool
+	// InitialAccessTokensSecretName is the name of the Secret which holds the accepted initial access tokens,
+	// or empty when only Kubernetes service account tokens are accepted.
+	InitialAccessTokensSecretName string
 }
 
 // NewFederationDomainIssuer returns a FederationDomainIssuer.
@@ -116,3 +144,33 @@ func (p *FederationDomainIssuer) IdentityProviders() []*FederationDomainIdentity
 func (p *FederationDomainIssuer) DefaultIdentityProvider() *FederationDomainIdentityProvider {
 	return p.defaultIdentityProvider
 }
+
+// SetBranding sets the branding of the FederationDomain's web pages.
+func (p *FederationDomainIssuer) SetBranding(b *branding.Branding) {
+	p.branding = b
+}
+
+// Branding will return nil when the FederationDomain's web pages are not customized.
+func (p *FederationDomainIssuer) Branding() *branding.Branding {
+	return p.branding
+}
+
+// SetIdentityProviderChooser sets how end users choose one of the FederationDomain's identity providers.
+func (p *FederationDomainIssuer) SetIdentityProviderChooser(c IdentityProviderChooser) {
+	p.identityProviderChooser = c
+}
+
+// IdentityProviderChooser returns how end users choose one of the FederationDomain's identity providers.
+func (p *FederationDomainIssuer) IdentityProviderChooser() IdentityProviderChooser {
+	return p.identityProviderChooser
+}
+
+// SetClientRegistration sets how the FederationDomain's dynamic client registration endpoint is served.
+func (p *FederationDomainIssuer) SetClientRegistration(c ClientRegistration) {
+	p.clientRegistration = c
+}
+
+// ClientRegistration returns how the FederationDomain's dynamic client registration endpoint is served.
+func (p *FederationDomainIssuer) ClientRegistration() ClientRegistration {
+	return p.clientRegistration
+}
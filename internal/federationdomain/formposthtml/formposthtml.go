@@ -1,4 +1,4 @@
-// Copyright 2021-2023 the Pinniped contributors. All Rights Reserved.
+// Copyright 2021-2024 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 // Package formposthtml defines HTML templates used by the Supervisor.
@@ -7,10 +7,12 @@ package formposthtml
 import (
 	_ "embed" // Needed to trigger //go:embed directives below.
 	"html/template"
+	"net/http"
 	"strings"
 
 	"github.com/tdewolff/minify/v2/minify"
 
+	"go.pinniped.dev/internal/federationdomain/branding"
 	"go.pinniped.dev/internal/federationdomain/csp"
 )
 
@@ -27,12 +29,16 @@ var (
 	//go:embed form_post.gohtml
 	rawHTMLTemplate string
 
-	// Parse the Go templated HTML and inject functions providing the minified inline CSS and JS.
-	parsedHTMLTemplate = template.Must(template.New("form_post.gohtml").Funcs(template.FuncMap{
+	// Parse the Go templated HTML and inject functions providing the minified inline CSS and JS, and the branding.
+	// This template is never executed, so it can be cloned to use other branding.
+	baseHTMLTemplate = template.Must(template.New("form_post.gohtml").Funcs(template.FuncMap{
 		"minifiedCSS": func() template.CSS { return template.CSS(minifiedCSS) },
 		"minifiedJS":  func() template.JS { return template.JS(minifiedJS) }, //nolint:gosec // This is 100% static input, not attacker-controlled.
+		"branding":    branding.Default,
 	}).Parse(rawHTMLTemplate))
 
+	parsedHTMLTemplate = template.Must(baseHTMLTemplate.Clone())
+
 	// Generate the CSP header value once since it's effectively constant.
 	cspValue = strings.Join([]string{
 		`default-src 'none'`,
@@ -56,5 +62,22 @@ func panicOnError(s string, err error) string {
 // See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Security-Policy.
 func ContentSecurityPolicy() string { return cspValue }
 
+// ContentSecurityPolicyForRequest returns the ContentSecurityPolicy() with the additional sources needed by the
+// branding of the request's FederationDomain.
+func ContentSecurityPolicyForRequest(r *http.Request) string {
+	return branding.FromContext(r.Context()).ContentSecurityPolicy(cspValue)
+}
+
 // Template returns the html/template.Template for rendering the response_type=form_post response page.
 func Template() *template.Template { return parsedHTMLTemplate }
+
+// TemplateFor returns the html/template.Template for rendering the response_type=form_post response page with the
+// given branding. Fosite decides the inputs of this template, so the branding cannot be one of them.
+func TemplateFor(page *branding.Page) *template.Template {
+	if page == branding.Default() {
+		return parsedHTMLTemplate
+	}
+	return template.Must(baseHTMLTemplate.Clone()).Funcs(template.FuncMap{
+		"branding": func() *branding.Page { return page },
+	})
+}
@@ -1,4 +1,4 @@
-// Copyright 2021-2023 the Pinniped contributors. All Rights Reserved.
+// Copyright 2021-2024 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package formposthtml
@@ -6,12 +6,15 @@ package formposthtml
 import (
 	"bytes"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 
 	"github.com/ory/fosite"
 	"github.com/stretchr/testify/require"
 
+	"go.pinniped.dev/internal/federationdomain/branding"
 	"go.pinniped.dev/internal/here"
 )
 
@@ -93,8 +96,40 @@ func TestTemplate(t *testing.T) {
 	require.Equal(t, testExpectedFormPostOutput, buf.String())
 }
 
+func TestTemplateFor(t *testing.T) {
+	require.Same(t, Template(), TemplateFor(branding.Default()))
+
+	b, err := branding.Parse(map[string][]byte{
+		branding.LogoKey:         []byte("\x89PNG\r\n\x1a\n"),
+		branding.PrimaryColorKey: []byte("#112233"),
+		branding.SupportURLKey:   []byte("https://help.example.com"),
+		"messages.de.json":       []byte(`{"formPostSuccessTitle": "Anmeldung erfolgreich", "formPostManualText": "Code:"}`),
+	})
+	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Language", "de")
+	page := b.PageFor(r)
+
+	var buf bytes.Buffer
+	fosite.WriteAuthorizeFormPostResponse(testRedirectURL, testResponseParams, TemplateFor(page), &buf)
+	require.Contains(t, buf.String(), `<html lang="de">`)
+	require.Contains(t, buf.String(), "<style>"+string(page.CSS)+"</style>")
+	require.Contains(t, buf.String(), "\n    Code: test-S629KHsCCBYV0PQ6FDSrn6iEXtVImQRBh7NCAk.JezyUSdCiSslYjtUmv7V5VAgiCz3ZkES9mYldg9GhqU\n")
+	require.Contains(t, buf.String(), `<div class="logo"><img src="data:image/png;base64,iVBORw0KGgo=" alt=""></div>`)
+	require.Contains(t, buf.String(), `data-title="Anmeldung erfolgreich" hidden>`)
+	require.Contains(t, buf.String(), `<h1>Anmeldung erfolgreich</h1>`)
+	require.Contains(t, buf.String(), `data-title="Error during login" hidden>`)
+	require.Contains(t, buf.String(), `<p class="support"><a href="https://help.example.com">Need help?</a></p>`)
+
+	// The default template is not affected by other branding.
+	buf = bytes.Buffer{}
+	fosite.WriteAuthorizeFormPostResponse(testRedirectURL, testResponseParams, Template(), &buf)
+	require.Equal(t, testExpectedFormPostOutput, buf.String())
+}
+
 func TestContentSecurityPolicyHashes(t *testing.T) {
 	require.Equal(t, testExpectedCSP, ContentSecurityPolicy())
+	require.Equal(t, testExpectedCSP, ContentSecurityPolicyForRequest(httptest.NewRequest(http.MethodGet, "/", nil)))
 }
 
 func TestHelpers(t *testing.T) {
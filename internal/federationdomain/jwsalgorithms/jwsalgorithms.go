@@ -0,0 +1,28 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package jwsalgorithms defines which JWS algorithms the Supervisor accepts on the JWTs which are signed by clients,
+// such as DPoP proofs and request objects, and by the external issuers which clients trust during token exchange.
+package jwsalgorithms
+
+import (
+	"github.com/go-jose/go-jose/v4"
+)
+
+// Asymmetric are the asymmetric JWS algorithms which may be used to sign the JWTs which the Supervisor verifies.
+// Symmetric algorithms are never accepted, since the Supervisor does not share secret keys with the signers.
+// This list is limited to the algorithms which fosite can use to verify request objects.
+var Asymmetric = []jose.SignatureAlgorithm{ //nolint:gochecknoglobals // This is effectively a constant.
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+}
+
+// Names returns the names of the given algorithms, e.g. for the discovery document.
+func Names(algorithms []jose.SignatureAlgorithm) []string {
+	names := make([]string, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		names = append(names, string(algorithm))
+	}
+	return names
+}
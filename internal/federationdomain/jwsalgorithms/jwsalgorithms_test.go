@@ -0,0 +1,26 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package jwsalgorithms
+
+import (
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsymmetric(t *testing.T) {
+	require.Equal(t,
+		[]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"},
+		Names(Asymmetric),
+	)
+	require.NotContains(t, Asymmetric, jose.HS256)
+	require.NotContains(t, Asymmetric, jose.HS384)
+	require.NotContains(t, Asymmetric, jose.HS512)
+}
+
+func TestNames(t *testing.T) {
+	require.Empty(t, Names(nil))
+	require.Equal(t, []string{"EdDSA", "ES256"}, Names([]jose.SignatureAlgorithm{jose.EdDSA, jose.ES256}))
+}
@@ -0,0 +1,435 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package loginlimiter slows down password guessing at the Supervisor's password login endpoints by delaying
+// further attempts after repeated failed logins, per username and per source IP address. The failed logins are
+// counted in Secrets, so the limits are shared by all the Supervisor's pods. Each login attempt is reserved in the
+// same Secrets before the password is checked, so concurrent attempts cannot get around the limits.
+package loginlimiter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedldap"
+)
+
+const (
+	TypeLabelValue = "login-failures"
+
+	ErrInvalidFailuresVersion = constable.Error("login failures data has wrong version")
+
+	// Version 1 was the initial release of storage.
+	failuresStorageVersion = "1"
+
+	// maxStorageAttempts is how many times a change to the stored failed logins is tried again after another
+	// pod concurrently changed the same counter.
+	maxStorageAttempts = 3
+
+	// reservationLifetime is how long a reserved login attempt is counted as pending. It only matters when a pod
+	// did not release its reservations, e.g. because it was stopped while checking the password.
+	reservationLifetime = time.Minute
+
+	// The source addresses of IPv6 clients are limited per /64 network, since a single client can usually
+	// use any address of its /64 network.
+	ipv6PrefixBits = 64
+)
+
+// Limit configures how failed logins of one username or from one source IP address are delayed.
+type Limit struct {
+	// AllowedFailures is the number of consecutive failed logins which are not delayed.
+	AllowedFailures int
+	// InitialBackoff is the delay after the first failed login beyond the allowed failures. It doubles
+	// with each further failed login.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay. The failed logins are forgotten when there was no failed login for this
+	// long after the most recent delay ended.
+	MaxBackoff time.Duration
+}
+
+// Config configures a Limiter.
+type Config struct {
+	PerUsername Limit
+	PerSourceIP Limit
+	// TrustedProxies are the networks of the proxies in front of the Supervisor which set the
+	// X-Forwarded-For header. When empty, the source IP address is the remote address of the connection.
+	TrustedProxies []*net.IPNet
+}
+
+// Limiter counts the failed logins of a FederationDomain. A nil *Limiter does not limit any logins.
+type Limiter struct {
+	storage crud.Storage
+	issuer  string
+	config  Config
+	clock   func() time.Time
+}
+
+type failures struct {
+	Count        int       `json:"count"`
+	LastFailedAt time.Time `json:"lastFailedAt"`
+	BlockedUntil time.Time `json:"blockedUntil"`
+	// Pending is the number of reserved login attempts which do not have an outcome yet.
+	Pending      int       `json:"pending"`
+	PendingUntil time.Time `json:"pendingUntil"`
+	// ExpiresAt is when the Secret will be garbage collected, which cannot be changed by an update.
+	ExpiresAt time.Time `json:"expiresAt"`
+	Version   string    `json:"version"`
+}
+
+// New returns the Limiter of the FederationDomain with the given issuer.
+func New(issuer string, secrets corev1client.SecretInterface, config Config, clock func() time.Time) *Limiter {
+	return &Limiter{
+		storage: crud.New(TypeLabelValue, secrets, clock),
+		issuer:  issuer,
+		config:  config,
+		clock:   clock,
+	}
+}
+
+// IsFailedLogin returns true when an error returned by the Login of an upstream identity provider should be counted
+// as a failed login, i.e. when the upstream did not accept the username and password. Errors which show that the
+// password was correct, and errors which are not the end user's fault, are not counted.
+func IsFailedLogin(err error) bool {
+	if err == resolvedldap.ErrAccessDeniedDueToPasswordExpired || //nolint:errorlint // these must be compared using ==
+		err == resolvedldap.ErrAccessDeniedDueToPasswordMustChange { //nolint:errorlint // these must be compared using ==
+		return false
+	}
+	return errors.Is(err, fosite.ErrAccessDenied)
+}
+
+// Reservation is a login attempt which was allowed by Reserve. Once the outcome of the attempt is known, exactly
+// one of RecordFailure, RecordSuccess, or Release should be called. Further calls do nothing, so Release may be
+// deferred. A nil *Reservation does not count anything.
+type Reservation struct {
+	limiter           *Limiter
+	keys              []*limitKey
+	usernameSignature string
+	done              bool
+}
+
+// Reserve allows a login attempt with the username from the source address of the request, and counts it as pending
+// until its outcome is recorded. It returns how long the end user must wait instead when there were too many recent
+// failed logins, or when concurrent attempts which have not finished yet could cause a delay.
+func (l *Limiter) Reserve(ctx context.Context, r *http.Request, username string) (*Reservation, time.Duration, error) {
+	if l == nil {
+		return nil, 0, nil
+	}
+
+	keys := l.keys(r, username)
+
+	// Avoid writing to the Secrets when the end user must wait anyway.
+	wait, err := l.check(ctx, keys)
+	if err != nil || wait > 0 {
+		return nil, wait, err
+	}
+
+	res := &Reservation{limiter: l, usernameSignature: keys[0].signature}
+	for _, key := range keys {
+		err = l.change(ctx, key, func(f *failures, now time.Time) bool {
+			wait = 0
+			if now.Before(f.BlockedUntil) {
+				wait = f.BlockedUntil.Sub(now)
+				return false
+			}
+			// While any attempts are pending, only allow more attempts which could not cause a delay, even when
+			// all of them fail. Otherwise, concurrent guesses could be made after the allowed failures.
+			if f.Pending > 0 && f.Count+f.Pending >= key.limit.AllowedFailures {
+				wait = min(f.PendingUntil.Sub(now), key.limit.InitialBackoff)
+				return false
+			}
+			f.Pending++
+			f.PendingUntil = now.Add(reservationLifetime)
+			return true
+		})
+		if err != nil || wait > 0 {
+			if releaseErr := res.Release(ctx); releaseErr != nil && err == nil {
+				err = releaseErr
+			}
+			return nil, wait, err
+		}
+		res.keys = append(res.keys, key)
+	}
+	return res, 0, nil
+}
+
+// RecordFailure counts the reserved login attempt as a failed login.
+func (res *Reservation) RecordFailure(ctx context.Context) error {
+	return res.finish(ctx, func(key *limitKey, f *failures, now time.Time) bool {
+		f.Pending = max(f.Pending-1, 0)
+		f.Count++
+		f.LastFailedAt = now
+		if backoff := key.limit.backoff(f.Count); backoff > 0 {
+			f.BlockedUntil = now.Add(backoff)
+		}
+		return true
+	})
+}
+
+// RecordSuccess forgets the failed logins of the username. The failed logins from the source address of the request
+// are not forgotten, since one successful login should not allow more guessing of other end users' passwords.
+func (res *Reservation) RecordSuccess(ctx context.Context) error {
+	return res.finish(ctx, func(key *limitKey, f *failures, _ time.Time) bool {
+		changed := release(f)
+		if key.signature == res.usernameSignature && f.Count > 0 {
+			f.Count = 0
+			f.LastFailedAt = time.Time{}
+			f.BlockedUntil = time.Time{}
+			changed = true
+		}
+		return changed
+	})
+}
+
+// Release ends the reserved login attempt without counting it, e.g. when the password could not be checked.
+func (res *Reservation) Release(ctx context.Context) error {
+	return res.finish(ctx, func(_ *limitKey, f *failures, _ time.Time) bool {
+		return release(f)
+	})
+}
+
+func (res *Reservation) finish(ctx context.Context, changeFunc func(key *limitKey, f *failures, now time.Time) bool) error {
+	if res == nil || res.done {
+		return nil
+	}
+	res.done = true
+
+	var errs []error
+	for _, key := range res.keys {
+		errs = append(errs, res.limiter.change(ctx, key, func(f *failures, now time.Time) bool {
+			return changeFunc(key, f, now)
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// release returns false when there is no pending attempt to release, e.g. because the reservation expired.
+func release(f *failures) bool {
+	if f.Pending == 0 {
+		return false
+	}
+	f.Pending--
+	return true
+}
+
+// check returns how long the end user must wait because of the failed logins of any of the keys.
+func (l *Limiter) check(ctx context.Context, keys []*limitKey) (time.Duration, error) {
+	now := l.clock()
+	var wait time.Duration
+	for _, key := range keys {
+		stored, _, err := l.get(ctx, key.signature)
+		if err != nil {
+			return 0, err
+		}
+		if stored != nil && now.Before(stored.BlockedUntil) {
+			wait = max(wait, stored.BlockedUntil.Sub(now))
+		}
+	}
+	return wait, nil
+}
+
+// change applies the changeFunc to the stored failures of the key, trying again when another pod concurrently
+// changed them. The changeFunc returns false when the failures should not be stored.
+func (l *Limiter) change(ctx context.Context, key *limitKey, changeFunc func(f *failures, now time.Time) bool) error {
+	var err error
+	for range maxStorageAttempts {
+		var retry bool
+		if retry, err = l.tryChange(ctx, key, changeFunc); !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// tryChange returns true when another pod concurrently changed the same counter, so it should be tried again.
+func (l *Limiter) tryChange(ctx context.Context, key *limitKey, changeFunc func(f *failures, now time.Time) bool) (bool, error) {
+	now := l.clock()
+
+	stored, resourceVersion, err := l.get(ctx, key.signature)
+	if err != nil {
+		return false, err
+	}
+
+	updated := &failures{Version: failuresStorageVersion}
+	if stored != nil {
+		updated.ExpiresAt = stored.ExpiresAt
+		if now.Before(forgetAt(stored, key.limit)) {
+			updated.Count = stored.Count
+			updated.LastFailedAt = stored.LastFailedAt
+			updated.BlockedUntil = stored.BlockedUntil
+		}
+		if now.Before(stored.PendingUntil) {
+			updated.Pending = stored.Pending
+			updated.PendingUntil = stored.PendingUntil
+		}
+	}
+	if !changeFunc(updated, now) {
+		return false, nil
+	}
+
+	// The Secret must live until the failures are forgotten, which is at most two maximum delays from now,
+	// and until the pending attempts expire.
+	if stored != nil && !updated.ExpiresAt.Before(keepUntil(updated, key.limit)) {
+		_, err = l.storage.Update(ctx, key.signature, resourceVersion, updated)
+		if apierrors.IsConflict(err) {
+			return true, err
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to update login failures: %w", err)
+		}
+		return false, nil
+	}
+
+	// Create a new Secret which lives long enough. Its lifetime is generous, so it does not need to be
+	// replaced often.
+	if stored != nil {
+		if err = l.storage.Delete(ctx, key.signature); err != nil && !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to delete login failures: %w", err)
+		}
+	}
+	lifetime := 4 * max(key.limit.MaxBackoff, reservationLifetime)
+	updated.ExpiresAt = now.Add(lifetime)
+	_, err = l.storage.Create(ctx, key.signature, updated, nil, nil, lifetime)
+	if apierrors.IsAlreadyExists(err) {
+		return true, err
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to store login failures: %w", err)
+	}
+	return false, nil
+}
+
+// get returns nil when there are no stored failures.
+func (l *Limiter) get(ctx context.Context, signature string) (*failures, string, error) {
+	stored := &failures{}
+	resourceVersion, err := l.storage.Get(ctx, signature, stored)
+	if apierrors.IsNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get login failures: %w", err)
+	}
+	if stored.Version != failuresStorageVersion {
+		return nil, "", fmt.Errorf("%w: login failures have version %s instead of %s",
+			ErrInvalidFailuresVersion, stored.Version, failuresStorageVersion)
+	}
+	return stored, resourceVersion, nil
+}
+
+// forgetAt returns when the failures are forgotten, which is one maximum delay after the most recent failure
+// or delay ended. The garbage collector will eventually delete the Secret, but the failures are forgotten even
+// when it has not done so yet.
+func forgetAt(f *failures, limit Limit) time.Time {
+	end := f.LastFailedAt
+	if f.BlockedUntil.After(end) {
+		end = f.BlockedUntil
+	}
+	return end.Add(limit.MaxBackoff)
+}
+
+// keepUntil returns when the stored failures are no longer needed.
+func keepUntil(f *failures, limit Limit) time.Time {
+	end := forgetAt(f, limit)
+	if f.PendingUntil.After(end) {
+		end = f.PendingUntil
+	}
+	return end
+}
+
+// backoff returns the delay after the given number of consecutive failed logins.
+func (limit Limit) backoff(count int) time.Duration {
+	if count <= limit.AllowedFailures {
+		return 0
+	}
+	backoff := limit.InitialBackoff
+	for range count - limit.AllowedFailures - 1 {
+		if backoff >= limit.MaxBackoff {
+			break
+		}
+		backoff *= 2
+	}
+	return min(backoff, limit.MaxBackoff)
+}
+
+type limitKey struct {
+	signature string
+	limit     Limit
+}
+
+// keys returns the key of the username first.
+func (l *Limiter) keys(r *http.Request, username string) []*limitKey {
+	return []*limitKey{
+		l.usernameKey(username),
+		{signature: l.signature("ip", SourceIP(r, l.config.TrustedProxies)), limit: l.config.PerSourceIP},
+	}
+}
+
+func (l *Limiter) usernameKey(username string) *limitKey {
+	// Most upstream identity providers ignore the case of usernames, so they are counted together.
+	return &limitKey{
+		signature: l.signature("username", strings.ToLower(strings.TrimSpace(username))),
+		limit:     l.config.PerUsername,
+	}
+}
+
+// signature hashes the issuer and the key, since the key may be too long or contain characters which cannot be
+// used in the name of a Secret, and since it may identify the user.
+func (l *Limiter) signature(keyType string, key string) string {
+	hash := sha256.Sum256([]byte(l.issuer + "\n" + keyType + "\n" + key))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// SourceIP returns the IP address of the client which sent the request. When the request came from one of the
+// trusted proxies, then the X-Forwarded-For header is used to find the first address which is not a trusted proxy.
+// The address of an IPv6 client is returned as its /64 network.
+func SourceIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+
+	if isTrusted(ip, trustedProxies) {
+		forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(forwardedFor) - 1; i >= 0; i-- {
+			forwardedIP := net.ParseIP(strings.TrimSpace(forwardedFor[i]))
+			if forwardedIP == nil {
+				// The header was not written by a trusted proxy, so do not trust any earlier addresses.
+				break
+			}
+			ip = forwardedIP
+			if !isTrusted(forwardedIP, trustedProxies) {
+				break
+			}
+		}
+	}
+
+	if ip.To4() == nil {
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6PrefixBits, 128)), Mask: net.CIDRMask(ipv6PrefixBits, 128)}).String()
+	}
+	return ip.String()
+}
+
+func isTrusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
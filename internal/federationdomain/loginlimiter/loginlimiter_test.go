@@ -0,0 +1,558 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package loginlimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	coretesting "k8s.io/client-go/testing"
+
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedldap"
+)
+
+const (
+	namespace = "test-ns"
+	issuer    = "https://issuer.example.com/some/path"
+)
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	var now time.Time
+
+	config := Config{
+		PerUsername: Limit{AllowedFailures: 2, InitialBackoff: time.Second, MaxBackoff: 8 * time.Second},
+		PerSourceIP: Limit{AllowedFailures: 10, InitialBackoff: time.Minute, MaxBackoff: time.Hour},
+	}
+
+	setup := func(t *testing.T) (*fake.Clientset, *Limiter) {
+		t.Helper()
+		now = start
+		client := fake.NewSimpleClientset()
+		return client, New(issuer, client.CoreV1().Secrets(namespace), config, func() time.Time { return now })
+	}
+
+	requestFrom := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+
+	// requireWait releases the reservation right away, so it does not change the stored failures.
+	requireWait := func(t *testing.T, limiter *Limiter, r *http.Request, username string, want time.Duration) {
+		t.Helper()
+		reservation, wait, err := limiter.Reserve(ctx, r, username)
+		require.NoError(t, err)
+		require.Equal(t, want, wait)
+		require.NoError(t, reservation.Release(ctx))
+	}
+
+	recordFailure := func(t *testing.T, limiter *Limiter, r *http.Request, username string) {
+		t.Helper()
+		reservation, wait, err := limiter.Reserve(ctx, r, username)
+		require.NoError(t, err)
+		require.Zero(t, wait)
+		require.NoError(t, reservation.RecordFailure(ctx))
+	}
+
+	requirePending := func(t *testing.T, limiter *Limiter, key *limitKey, want int) {
+		t.Helper()
+		stored, _, err := limiter.get(ctx, key.signature)
+		require.NoError(t, err)
+		require.NotNil(t, stored)
+		require.Equal(t, want, stored.Pending)
+	}
+
+	listSecrets := func(t *testing.T, client *fake.Clientset) []corev1.Secret {
+		t.Helper()
+		list, err := client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		return list.Items
+	}
+
+	t.Run("a nil limiter does not limit any logins", func(t *testing.T) {
+		var limiter *Limiter
+		r := requestFrom("10.0.0.1:1234")
+		reservation, wait, err := limiter.Reserve(ctx, r, "some-user")
+		require.NoError(t, err)
+		require.Zero(t, wait)
+		require.Nil(t, reservation)
+		require.NoError(t, reservation.RecordFailure(ctx))
+		require.NoError(t, reservation.RecordSuccess(ctx))
+		require.NoError(t, reservation.Release(ctx))
+	})
+
+	t.Run("delays logins of a username after the allowed failures, doubling the delay up to the maximum", func(t *testing.T) {
+		client, limiter := setup(t)
+		r := requestFrom("10.0.0.1:1234")
+
+		for _, wantWait := range []time.Duration{0, 0, 1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second} {
+			recordFailure(t, limiter, r, "some-user")
+			requireWait(t, limiter, r, "some-user", wantWait)
+			// Another username from another address is not delayed.
+			requireWait(t, limiter, requestFrom("10.0.0.2:1234"), "some-other-user", 0)
+			// The delay ends.
+			now = now.Add(wantWait)
+			requireWait(t, limiter, r, "some-user", 0)
+		}
+
+		secrets := listSecrets(t, client)
+		require.Len(t, secrets, 4) // one for each username and one for each source IP
+		for _, secret := range secrets {
+			require.Regexp(t, "^pinniped-storage-login-failures-[a-z0-9]+$", secret.Name)
+			require.NotContains(t, secret.Name, "some-user")
+			require.Equal(t, corev1.SecretType("storage.pinniped.dev/login-failures"), secret.Type)
+			require.Equal(t, map[string]string{"storage.pinniped.dev/type": "login-failures"}, secret.Labels)
+		}
+	})
+
+	t.Run("counts usernames regardless of case and surrounding spaces", func(t *testing.T) {
+		_, limiter := setup(t)
+
+		for range 3 {
+			recordFailure(t, limiter, requestFrom("10.0.0.1:1234"), "Some-User")
+		}
+		requireWait(t, limiter, requestFrom("10.0.0.2:1234"), " some-user ", time.Second)
+	})
+
+	t.Run("counts usernames separately for each issuer", func(t *testing.T) {
+		client, limiter := setup(t)
+		otherLimiter := New("https://other-issuer.example.com", client.CoreV1().Secrets(namespace), config, func() time.Time { return now })
+		r := requestFrom("10.0.0.1:1234")
+
+		for range 3 {
+			recordFailure(t, limiter, r, "some-user")
+		}
+		requireWait(t, limiter, r, "some-user", time.Second)
+		requireWait(t, otherLimiter, r, "some-user", 0)
+	})
+
+	t.Run("delays logins from a source IP address for any username", func(t *testing.T) {
+		_, limiter := setup(t)
+
+		for i := range 11 {
+			recordFailure(t, limiter, requestFrom("10.0.0.1:1234"), "some-user-"+string(rune('a'+i)))
+		}
+		requireWait(t, limiter, requestFrom("10.0.0.1:5678"), "some-other-user", time.Minute)
+		requireWait(t, limiter, requestFrom("10.0.0.2:1234"), "some-other-user", 0)
+	})
+
+	t.Run("a successful login forgets the failed logins of the username, but not of the source IP address", func(t *testing.T) {
+		_, limiter := setup(t)
+		r := requestFrom("10.0.0.1:1234")
+
+		for range 3 {
+			recordFailure(t, limiter, r, "some-user")
+		}
+		for i := range 8 {
+			recordFailure(t, limiter, r, "some-other-user-"+string(rune('a'+i)))
+		}
+		requireWait(t, limiter, requestFrom("10.0.0.2:1234"), "some-user", time.Second)
+		requireWait(t, limiter, r, "some-other-user", time.Minute)
+
+		now = now.Add(time.Second)
+		reservation, wait, err := limiter.Reserve(ctx, requestFrom("10.0.0.2:1234"), "some-user")
+		require.NoError(t, err)
+		require.Zero(t, wait)
+		require.NoError(t, reservation.RecordSuccess(ctx))
+		requireWait(t, limiter, r, "some-other-user", time.Minute-time.Second)
+
+		// The next failure is the first one again.
+		recordFailure(t, limiter, requestFrom("10.0.0.2:1234"), "some-user")
+		requireWait(t, limiter, requestFrom("10.0.0.2:1234"), "some-user", 0)
+
+		// Forgetting when nothing was counted is not an error.
+		reservation, _, err = limiter.Reserve(ctx, requestFrom("10.0.0.3:1234"), "yet-another-user")
+		require.NoError(t, err)
+		require.NoError(t, reservation.RecordSuccess(ctx))
+	})
+
+	t.Run("forgets the failed logins one maximum delay after the most recent delay ended", func(t *testing.T) {
+		_, limiter := setup(t)
+		r := requestFrom("10.0.0.1:1234")
+
+		for range 3 {
+			recordFailure(t, limiter, r, "some-user")
+		}
+		now = now.Add(time.Second)
+		recordFailure(t, limiter, r, "some-user")
+		requireWait(t, limiter, r, "some-user", 2*time.Second)
+
+		// Not forgotten yet, so the next failure doubles the delay.
+		now = now.Add(2*time.Second + 8*time.Second - time.Nanosecond)
+		recordFailure(t, limiter, r, "some-user")
+		requireWait(t, limiter, r, "some-user", 4*time.Second)
+
+		// Forgotten, so the next failure is the first one again.
+		now = now.Add(4*time.Second + 8*time.Second)
+		recordFailure(t, limiter, r, "some-user")
+		requireWait(t, limiter, r, "some-user", 0)
+	})
+
+	t.Run("replaces the Secret when it would be garbage collected before the failed logins are forgotten", func(t *testing.T) {
+		now = start
+		client := fake.NewSimpleClientset()
+		limiter := New(issuer, client.CoreV1().Secrets(namespace), Config{
+			PerUsername: Limit{AllowedFailures: 2, InitialBackoff: 30 * time.Second, MaxBackoff: 2 * time.Minute},
+			PerSourceIP: config.PerSourceIP,
+		}, func() time.Time { return now })
+		r := requestFrom("10.0.0.1:1234")
+
+		// The Secret of the source IP address has a much longer lifetime, so it is always last.
+		garbageCollectAfter := func() []string {
+			t.Helper()
+			var annotations []string
+			for _, secret := range listSecrets(t, client) {
+				annotations = append(annotations, secret.Annotations["storage.pinniped.dev/garbage-collect-after"])
+			}
+			sort.Strings(annotations)
+			return annotations
+		}
+
+		for range 3 {
+			recordFailure(t, limiter, r, "some-user")
+		}
+		require.Equal(t, []string{"2030-01-01T00:08:00Z", "2030-01-01T04:00:00Z"}, garbageCollectAfter())
+
+		// The failures will be forgotten at 00:05:00 and then at 00:08:00, which is not after the Secret is
+		// garbage collected, so it is updated.
+		now = start.Add(2 * time.Minute)
+		recordFailure(t, limiter, r, "some-user")
+		now = start.Add(4 * time.Minute)
+		recordFailure(t, limiter, r, "some-user")
+		require.Equal(t, []string{"2030-01-01T00:08:00Z", "2030-01-01T04:00:00Z"}, garbageCollectAfter())
+
+		// The failures will be forgotten at 00:11:00, which is after the Secret is garbage collected,
+		// so it is replaced, and the count is kept.
+		now = start.Add(7 * time.Minute)
+		recordFailure(t, limiter, r, "some-user")
+		require.Equal(t, []string{"2030-01-01T00:15:00Z", "2030-01-01T04:00:00Z"}, garbageCollectAfter())
+		requireWait(t, limiter, r, "some-user", 2*time.Minute)
+	})
+
+	t.Run("reserves concurrent attempts, so they cannot be made after the allowed failures", func(t *testing.T) {
+		_, limiter := setup(t)
+		r := requestFrom("10.0.0.1:1234")
+		usernameKey := limiter.usernameKey("some-user")
+
+		// Two attempts are allowed at the same time, since both of them may fail without a delay.
+		first, wait, err := limiter.Reserve(ctx, r, "some-user")
+		require.NoError(t, err)
+		require.Zero(t, wait)
+		second, wait, err := limiter.Reserve(ctx, requestFrom("10.0.0.2:1234"), "some-user")
+		require.NoError(t, err)
+		require.Zero(t, wait)
+		requirePending(t, limiter, usernameKey, 2)
+
+		// A third attempt could cause a delay, so it must wait for the others to finish.
+		requireWait(t, limiter, requestFrom("10.0.0.3:1234"), "some-user", time.Second)
+
+		require.NoError(t, first.RecordFailure(ctx))
+		require.NoError(t, second.RecordFailure(ctx))
+		requirePending(t, limiter, usernameKey, 0)
+
+		// After the allowed failures, only one attempt is allowed at a time.
+		third, wait, err := limiter.Reserve(ctx, r, "some-user")
+		require.NoError(t, err)
+		require.Zero(t, wait)
+		requireWait(t, limiter, requestFrom("10.0.0.2:1234"), "some-user", time.Second)
+		require.NoError(t, third.RecordFailure(ctx))
+		requireWait(t, limiter, r, "some-user", time.Second)
+	})
+
+	t.Run("an attempt which is released is not counted, and it is only finished once", func(t *testing.T) {
+		_, limiter := setup(t)
+		r := requestFrom("10.0.0.1:1234")
+		usernameKey := limiter.usernameKey("some-user")
+
+		for range 3 {
+			reservation, wait, err := limiter.Reserve(ctx, r, "some-user")
+			require.NoError(t, err)
+			require.Zero(t, wait)
+			require.NoError(t, reservation.Release(ctx))
+			require.NoError(t, reservation.RecordFailure(ctx))
+			requirePending(t, limiter, usernameKey, 0)
+		}
+		requireWait(t, limiter, r, "some-user", 0)
+
+		reservation, _, err := limiter.Reserve(ctx, r, "some-user")
+		require.NoError(t, err)
+		require.NoError(t, reservation.RecordFailure(ctx))
+		require.NoError(t, reservation.RecordFailure(ctx))
+		require.NoError(t, reservation.Release(ctx))
+		stored, _, err := limiter.get(ctx, usernameKey.signature)
+		require.NoError(t, err)
+		require.Equal(t, 1, stored.Count)
+		require.Zero(t, stored.Pending)
+	})
+
+	t.Run("forgets reserved attempts which were never finished", func(t *testing.T) {
+		_, limiter := setup(t)
+		r := requestFrom("10.0.0.1:1234")
+
+		for range 2 {
+			_, wait, err := limiter.Reserve(ctx, r, "some-user")
+			require.NoError(t, err)
+			require.Zero(t, wait)
+		}
+		requireWait(t, limiter, r, "some-user", time.Second)
+
+		now = now.Add(reservationLifetime)
+		requireWait(t, limiter, r, "some-user", 0)
+	})
+
+	t.Run("releases the reservation of the username when the source IP address must wait", func(t *testing.T) {
+		now = start
+		client := fake.NewSimpleClientset()
+		limiter := New(issuer, client.CoreV1().Secrets(namespace), Config{
+			PerUsername: config.PerUsername,
+			PerSourceIP: Limit{AllowedFailures: 1, InitialBackoff: time.Minute, MaxBackoff: time.Hour},
+		}, func() time.Time { return now })
+		r := requestFrom("10.0.0.1:1234")
+
+		reservation, wait, err := limiter.Reserve(ctx, r, "some-user")
+		require.NoError(t, err)
+		require.Zero(t, wait)
+
+		requireWait(t, limiter, r, "some-other-user", time.Minute)
+		requirePending(t, limiter, limiter.usernameKey("some-other-user"), 0)
+
+		require.NoError(t, reservation.Release(ctx))
+		requireWait(t, limiter, r, "some-other-user", 0)
+	})
+
+	t.Run("concurrent attempts cannot reserve more than the allowed failures", func(t *testing.T) {
+		client, limiter := setup(t)
+		secretsGVR := corev1.SchemeGroupVersion.WithResource("secrets")
+		// Like the Kubernetes API, refuse updates of Secrets which were changed since they were read.
+		client.PrependReactor("update", "secrets", func(action coretesting.Action) (bool, runtime.Object, error) {
+			secret := action.(coretesting.UpdateAction).GetObject().(*corev1.Secret).DeepCopy()
+			current, err := client.Tracker().Get(secretsGVR, namespace, secret.Name)
+			if err != nil {
+				return true, nil, err
+			}
+			if current.(*corev1.Secret).ResourceVersion != secret.ResourceVersion {
+				return true, nil, apierrors.NewConflict(secretsGVR.GroupResource(), secret.Name, errors.New("changed"))
+			}
+			secret.ResourceVersion += "1"
+			return true, secret, client.Tracker().Update(secretsGVR, secret, namespace)
+		})
+		// Create the Secret of the username before the concurrent attempts.
+		requireWait(t, limiter, requestFrom("10.0.0.1:1234"), "some-user", 0)
+
+		var wg sync.WaitGroup
+		var reserved atomic.Int32
+		for i := range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, wait, err := limiter.Reserve(ctx, requestFrom(fmt.Sprintf("10.0.1.%d:1234", i)), "some-user")
+				if err == nil && wait == 0 {
+					reserved.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		require.LessOrEqual(t, reserved.Load(), int32(config.PerUsername.AllowedFailures))
+		requirePending(t, limiter, limiter.usernameKey("some-user"), int(reserved.Load()))
+	})
+
+	t.Run("tries again when another pod changed the same failed logins concurrently", func(t *testing.T) {
+		client, limiter := setup(t)
+		r := requestFrom("10.0.0.1:1234")
+		recordFailure(t, limiter, r, "some-user")
+		recordFailure(t, limiter, r, "some-user")
+
+		conflicts := 0
+		client.PrependReactor("update", "secrets", func(action coretesting.Action) (bool, runtime.Object, error) {
+			if conflicts > 0 {
+				return false, nil, nil
+			}
+			conflicts++
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "secrets"}, "some-name", errors.New("changed"))
+		})
+
+		recordFailure(t, limiter, r, "some-user")
+		require.Equal(t, 1, conflicts)
+		requireWait(t, limiter, r, "some-user", time.Second)
+	})
+
+	t.Run("returns an error when the stored failed logins have the wrong version", func(t *testing.T) {
+		client, limiter := setup(t)
+		r := requestFrom("10.0.0.1:1234")
+		reservation, _, err := limiter.Reserve(ctx, r, "some-user")
+		require.NoError(t, err)
+
+		for _, secret := range listSecrets(t, client) {
+			secret.Data["pinniped-storage-data"] = []byte(`{"count":1,"version":"0"}`)
+			_, err := client.CoreV1().Secrets(namespace).Update(ctx, &secret, metav1.UpdateOptions{})
+			require.NoError(t, err)
+		}
+
+		_, _, err = limiter.Reserve(ctx, r, "some-user")
+		require.EqualError(t, err, "login failures data has wrong version: login failures have version 0 instead of 1")
+		require.ErrorIs(t, reservation.RecordFailure(ctx), ErrInvalidFailuresVersion)
+	})
+
+	t.Run("returns errors from the Kubernetes API", func(t *testing.T) {
+		client, limiter := setup(t)
+		r := requestFrom("10.0.0.1:1234")
+		failedReservation, _, err := limiter.Reserve(ctx, r, "some-user")
+		require.NoError(t, err)
+		successfulReservation, _, err := limiter.Reserve(ctx, r, "some-user")
+		require.NoError(t, err)
+		client.PrependReactor("*", "secrets", func(action coretesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("some api error")
+		})
+
+		_, _, err = limiter.Reserve(ctx, r, "some-user")
+		require.ErrorContains(t, err, "failed to get login failures: failed to get login-failures for signature")
+		require.ErrorContains(t, err, "some api error")
+
+		err = failedReservation.RecordFailure(ctx)
+		require.ErrorContains(t, err, "some api error")
+
+		err = successfulReservation.RecordSuccess(ctx)
+		require.ErrorContains(t, err, "failed to get login failures")
+		require.ErrorContains(t, err, "some api error")
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	limit := Limit{AllowedFailures: 3, InitialBackoff: 5 * time.Second, MaxBackoff: time.Minute}
+
+	tests := []struct {
+		count int
+		want  time.Duration
+	}{
+		{count: 0, want: 0},
+		{count: 3, want: 0},
+		{count: 4, want: 5 * time.Second},
+		{count: 5, want: 10 * time.Second},
+		{count: 6, want: 20 * time.Second},
+		{count: 7, want: 40 * time.Second},
+		{count: 8, want: time.Minute},
+		{count: 1000, want: time.Minute},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, limit.backoff(tt.count), "count %d", tt.count)
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	trustedProxies := []*net.IPNet{mustParseCIDR(t, "10.0.0.0/8"), mustParseCIDR(t, "fd00::/8")}
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   []string
+		trustedProxies []*net.IPNet
+		want           string
+	}{
+		{
+			name:       "IPv4 remote address",
+			remoteAddr: "192.0.2.1:1234",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "IPv6 remote address is limited per /64 network",
+			remoteAddr: "[2001:db8:1:2:3:4:5:6]:1234",
+			want:       "2001:db8:1:2::/64",
+		},
+		{
+			name:         "X-Forwarded-For is ignored without trusted proxies",
+			remoteAddr:   "10.0.0.1:1234",
+			forwardedFor: []string{"192.0.2.1"},
+			want:         "10.0.0.1",
+		},
+		{
+			name:           "X-Forwarded-For is ignored when the remote address is not a trusted proxy",
+			remoteAddr:     "192.0.2.99:1234",
+			forwardedFor:   []string{"192.0.2.1"},
+			trustedProxies: trustedProxies,
+			want:           "192.0.2.99",
+		},
+		{
+			name:           "X-Forwarded-For of a trusted proxy",
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"192.0.2.1"},
+			trustedProxies: trustedProxies,
+			want:           "192.0.2.1",
+		},
+		{
+			name:           "X-Forwarded-For of a chain of trusted proxies uses the first untrusted address from the right",
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"198.51.100.1, 192.0.2.1", "10.0.0.2"},
+			trustedProxies: trustedProxies,
+			want:           "192.0.2.1",
+		},
+		{
+			name:           "X-Forwarded-For with an invalid address stops at the invalid address",
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"192.0.2.1, not-an-ip, 10.0.0.2"},
+			trustedProxies: trustedProxies,
+			want:           "10.0.0.2",
+		},
+		{
+			name:           "X-Forwarded-For with only trusted proxies uses the leftmost address",
+			remoteAddr:     "10.0.0.1:1234",
+			forwardedFor:   []string{"10.0.0.3, 10.0.0.2"},
+			trustedProxies: trustedProxies,
+			want:           "10.0.0.3",
+		},
+		{
+			name:           "X-Forwarded-For with an IPv6 address",
+			remoteAddr:     "[fd00::1]:1234",
+			forwardedFor:   []string{"2001:db8::1"},
+			trustedProxies: trustedProxies,
+			want:           "2001:db8::/64",
+		},
+	}
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			require.Equal(t, tt.want, SourceIP(r, tt.trustedProxies))
+		})
+	}
+}
+
+func TestIsFailedLogin(t *testing.T) {
+	require.True(t, IsFailedLogin(resolvedldap.ErrAccessDeniedDueToUsernamePasswordNotAccepted))
+	require.True(t, IsFailedLogin(resolvedldap.ErrAccessDeniedDueToAccountLocked))
+	require.True(t, IsFailedLogin(fosite.ErrAccessDenied.WithDebug("the upstream did not accept the password")))
+	require.False(t, IsFailedLogin(resolvedldap.ErrAccessDeniedDueToPasswordExpired))
+	require.False(t, IsFailedLogin(resolvedldap.ErrAccessDeniedDueToPasswordMustChange))
+	require.False(t, IsFailedLogin(resolvedldap.ErrUnexpectedUpstreamLDAPError))
+	require.False(t, IsFailedLogin(errors.New("some other error")))
+}
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, network, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	return network
+}
@@ -6,9 +6,11 @@
 package oidc
 
 import (
+	"context"
 	"crypto/subtle"
 	"errors"
 	"fmt"
+	"html/template"
 	"net/http"
 	"reflect"
 	"time"
@@ -19,6 +21,7 @@ import (
 	errorsx "github.com/pkg/errors"
 
 	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
+	"go.pinniped.dev/internal/federationdomain/branding"
 	"go.pinniped.dev/internal/federationdomain/clientregistry"
 	"go.pinniped.dev/internal/federationdomain/csrftoken"
 	"go.pinniped.dev/internal/federationdomain/endpoints/jwks"
@@ -35,16 +38,25 @@ import (
 )
 
 const (
-	WellKnownEndpointPath     = "/.well-known/openid-configuration"
-	AuthorizationEndpointPath = "/oauth2/authorize"
-	TokenEndpointPath         = "/oauth2/token" //nolint:gosec // ignore lint warning that this is a credential
-	CallbackEndpointPath      = "/callback"
-	ChooseIDPEndpointPath     = "/choose_identity_provider"
-	JWKSEndpointPath          = "/jwks.json"
-	PinnipedIDPsPathV1Alpha1  = "/v1alpha1/pinniped_identity_providers"
-	PinnipedLoginPath         = "/login"
+	WellKnownEndpointPath                  = "/.well-known/openid-configuration"
+	AuthorizationEndpointPath              = "/oauth2/authorize"
+	TokenEndpointPath                      = "/oauth2/token" //nolint:gosec // ignore lint warning that this is a credential
+	CallbackEndpointPath                   = "/callback"
+	SAMLACSEndpointPath                    = "/saml/acs"
+	ChooseIDPEndpointPath                  = "/choose_identity_provider"
+	JWKSEndpointPath                       = "/jwks.json"
+	PinnipedIDPsPathV1Alpha1               = "/v1alpha1/pinniped_identity_providers"
+	PinnipedLoginPath                      = "/login"
+	PinnipedTOTPEnrollPath                 = "/totp/enroll"
+	ConsentEndpointPath                    = "/consent"
+	RegistrationEndpointPath               = "/oauth2/register"
+	PushedAuthorizationRequestEndpointPath = "/oauth2/par"
 )
 
+// PushedAuthorizationRequestURIPrefix is the prefix of every request_uri returned by the pushed authorization
+// request endpoint, as suggested by RFC 9126.
+const PushedAuthorizationRequestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
 const (
 	// UpstreamStateParamFormatVersion exists just in case we need to make a breaking change to the format of the
 	// upstream state param, we are including a format version number. This gives the opportunity for a future version
@@ -68,10 +80,32 @@ const (
 	// cookie contents.
 	CSRFCookieEncodingName = "csrf"
 
+	// LastIDPCookieName is the name of the browser cookie which remembers the display name of the identity provider
+	// which the end user last chose. Its path is the FederationDomain's issuer path, so each FederationDomain on
+	// the same host has its own cookie. The `__Secure` prefix has a special meaning. See:
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Cookies#Cookie_prefixes.
+	LastIDPCookieName = "__Secure-pinniped-idp"
+
+	// LastIDPCookieEncodingName is the `name` passed to the encoder for encoding and decoding the last chosen
+	// identity provider cookie contents.
+	LastIDPCookieEncodingName = "idp"
+
+	// TOTPLoginStateEncodingName is the `name` passed to the encoder for encoding and decoding the state of a login
+	// which is waiting for the end user to provide a one-time password.
+	TOTPLoginStateEncodingName = "totp-login"
+
+	// TOTPEnrollStateEncodingName is the `name` passed to the encoder for encoding and decoding the state of a
+	// TOTP enrollment which is waiting for the end user to confirm that their authenticator app works.
+	TOTPEnrollStateEncodingName = "totp-enroll"
+
 	// CSRFCookieLifespan is the length of time that the CSRF cookie is valid. After this time, the
 	// Supervisor's authorization endpoint should give the browser a new CSRF cookie. We set it to
 	// a week so that it is unlikely to expire during a login.
 	CSRFCookieLifespan = time.Hour * 24 * 7
+
+	// LastIDPCookieLifespan is the length of time that the last chosen identity provider is remembered after the
+	// end user's most recent login.
+	LastIDPCookieLifespan = time.Hour * 24 * 90
 )
 
 // Encoder is the encoding side of the securecookie.Codec interface.
@@ -161,6 +195,10 @@ func DefaultOIDCTimeoutsConfiguration() timeouts.Configuration {
 	// experience of logging in once per day to access all their Kubernetes clusters.
 	refreshTokenLifespan := 9 * time.Hour
 
+	// A client should use the request_uri returned by the pushed authorization request
+	// endpoint right away, so this only needs to cover a slow network and a slow browser.
+	pushedAuthorizeRequestLifespan := 5 * time.Minute
+
 	// Give a little extra time for some storage lifetimes, to avoid the possibility
 	// that the storage be garbage collected in the middle of trying to look up the token.
 	storageExtraLifetime := time.Minute
@@ -202,6 +240,8 @@ func DefaultOIDCTimeoutsConfiguration() timeouts.Configuration {
 
 		RefreshTokenLifespan: refreshTokenLifespan,
 
+		PushedAuthorizeRequestLifespan: pushedAuthorizeRequestLifespan,
+
 		AuthorizationCodeSessionStorageLifetime: func(_ fosite.Requester) time.Duration {
 			return authorizationCodeLifespan + refreshTokenLifespan
 		},
@@ -221,6 +261,10 @@ func DefaultOIDCTimeoutsConfiguration() timeouts.Configuration {
 		RefreshTokenSessionStorageLifetime: func(_ fosite.Requester) time.Duration {
 			return refreshTokenLifespan + accessTokenLifespan
 		},
+
+		PushedAuthorizeRequestSessionStorageLifetime: func(_ fosite.Requester) time.Duration {
+			return pushedAuthorizeRequestLifespan + storageExtraLifetime
+		},
 	}
 }
 
@@ -239,6 +283,10 @@ func FositeOauth2Helper(
 		AccessTokenLifespan:   timeoutsConfiguration.AccessTokenLifespan,
 		RefreshTokenLifespan:  timeoutsConfiguration.RefreshTokenLifespan,
 
+		// Pushed authorization requests are optional, unless an OIDCClient requires them.
+		PushedAuthorizeRequestURIPrefix: PushedAuthorizationRequestURIPrefix,
+		PushedAuthorizeContextLifespan:  timeoutsConfiguration.PushedAuthorizeRequestLifespan,
+
 		ScopeStrategy: fosite.ExactScopeStrategy,
 		EnforcePKCE:   true,
 
@@ -277,12 +325,26 @@ func FositeOauth2Helper(
 		// Use a custom factory to allow selective overrides of the ID token lifespan during refresh.
 		idtokenlifespan.OpenIDConnectRefreshFactory,
 		compose.OAuth2PKCEFactory,
-		tokenexchange.HandlerFactory, // handle the "urn:ietf:params:oauth:grant-type:token-exchange" grant type
+		tokenexchange.NewHandlerFactory(jwksProvider), // handle the "urn:ietf:params:oauth:grant-type:token-exchange" grant type
+		compose.PushedAuthorizeHandlerFactory,
 	)
 
+	// The form_post response page uses the branding of each request, so the template must be chosen per request.
+	oAuth2Provider.(*fosite.Fosite).Config = &brandedFormPostConfig{Config: oauthConfig}
+
 	return oAuth2Provider
 }
 
+// brandedFormPostConfig is a fosite.Config which chooses the form_post template using the branding from the
+// context of each request.
+type brandedFormPostConfig struct {
+	*fosite.Config
+}
+
+func (c *brandedFormPostConfig) GetFormPostHTMLTemplate(ctx context.Context) *template.Template {
+	return formposthtml.TemplateFor(branding.FromContext(ctx))
+}
+
 // FositeErrorForLog generates a list of information about the provided Fosite error that can be
 // passed to a plog function (e.g., plog.Info()).
 //
@@ -345,6 +407,18 @@ func ReadStateParamAndValidateCSRFCookie(r *http.Request, cookieDecoder Decoder,
 	return encodedState, decodedState, nil
 }
 
+// ValidateCSRFCookie validates that the request's CSRF cookie holds the given CSRF value.
+func ValidateCSRFCookie(r *http.Request, cookieDecoder Decoder, csrfValue csrftoken.CSRFToken) error {
+	csrfFromCookie, err := readCSRFCookie(r, cookieDecoder)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(csrfValue), []byte(csrfFromCookie)) != 1 {
+		return httperr.New(http.StatusForbidden, "CSRF value does not match")
+	}
+	return nil
+}
+
 func readCSRFCookie(r *http.Request, cookieDecoder Decoder) (csrftoken.CSRFToken, error) {
 	receivedCSRFCookie, err := r.Cookie(CSRFCookieName)
 	if err != nil {
@@ -0,0 +1,53 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidc
+
+import (
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/ory/fosite"
+
+	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
+	"go.pinniped.dev/internal/federationdomain/jwsalgorithms"
+)
+
+// RequestObjectParamName is the name of the authorization request param which holds a signed request object
+// (a JWT-secured authorization request, see RFC 9101).
+const RequestObjectParamName = "request"
+
+// ValidateRequestObject performs the validations of a signed request object which fosite does not perform.
+// It must be called with the value of the request param, which may be empty, after fosite has accepted the
+// authorization request. At that point, fosite has verified the signature of the request object using the
+// client's keys and has copied its claims into the authorization request.
+func ValidateRequestObject(requestObject string, authorizeRequester fosite.AuthorizeRequester, issuer string) error {
+	if requestObject == "" {
+		return nil
+	}
+
+	// Fosite ignores the request object when the openid scope was not also sent outside the request object,
+	// as required by OpenID Connect Core 1.0 section 6.1. Reject the request rather than ignoring the request object.
+	if !ScopeWasRequested(authorizeRequester, oidcapi.ScopeOpenID) {
+		return fosite.ErrInvalidRequest.WithHint("The 'scope' parameter must include 'openid' when a request object is used.")
+	}
+
+	token, err := jwt.ParseSigned(requestObject, jwsalgorithms.Asymmetric)
+	if err != nil {
+		return fosite.ErrInvalidRequestObject.WithHint("Unable to parse the request object.").WithWrap(err).WithDebug(err.Error())
+	}
+
+	// The signature was already verified by fosite.
+	var claims jwt.Claims
+	if err := token.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return fosite.ErrInvalidRequestObject.WithHint("Unable to parse the claims of the request object.").WithWrap(err).WithDebug(err.Error())
+	}
+
+	// See RFC 9101 section 4 and the FAPI 2.0 message signing profile.
+	if claims.Issuer != authorizeRequester.GetClient().GetID() {
+		return fosite.ErrInvalidRequestObject.WithHint("The 'iss' claim of the request object must be the client ID.")
+	}
+	if !claims.Audience.Contains(issuer) {
+		return fosite.ErrInvalidRequestObject.WithHint("The 'aud' claim of the request object must contain the issuer.")
+	}
+
+	return nil
+}
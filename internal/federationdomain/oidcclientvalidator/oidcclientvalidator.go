@@ -4,9 +4,13 @@
 package oidcclientvalidator
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 
+	"github.com/go-jose/go-jose/v3"
 	"golang.org/x/crypto/bcrypt"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -24,12 +28,22 @@ const (
 	allowedGrantTypesValid = "AllowedGrantTypesValid"
 	allowedScopesValid     = "AllowedScopesValid"
 
+	allowedIdentityProvidersValid = "AllowedIdentityProvidersValid"
+	admissionPolicyValid          = "AdmissionPolicyValid"
+	requestObjectsValid           = "RequestObjectsValid"
+	tokenExchangeValid            = "TokenExchangeValid"
+
 	reasonMissingRequiredValue     = "MissingRequiredValue"
+	reasonInvalidValue             = "InvalidValue"
 	reasonNoClientSecretFound      = "NoClientSecretFound"
 	reasonInvalidClientSecretFound = "InvalidClientSecretFound"
 
-	allowedGrantTypesFieldName = "allowedGrantTypes"
-	allowedScopesFieldName     = "allowedScopes"
+	allowedGrantTypesFieldName        = "allowedGrantTypes"
+	allowedScopesFieldName            = "allowedScopes"
+	allowedIdentityProvidersFieldName = "allowedIdentityProviders"
+	admissionPolicyFieldName          = "admissionPolicy"
+	requestObjectsFieldName           = "requestObjects"
+	tokenExchangeFieldName            = "tokenExchange"
 )
 
 // Validate validates the OIDCClient and its corresponding client secret storage Secret.
@@ -38,20 +52,39 @@ const (
 // along with a slice of conditions containing more details, and the list of client secrets in the
 // case that the client was valid.
 func Validate(oidcClient *supervisorconfigv1alpha1.OIDCClient, secret *corev1.Secret, minBcryptCost int) (bool, []*metav1.Condition, []string) {
-	conds := make([]*metav1.Condition, 0, 3)
+	conds := make([]*metav1.Condition, 0, 7)
 
 	conds, clientSecrets := validateSecret(secret, conds, minBcryptCost)
-	conds = validateAllowedGrantTypes(oidcClient, conds)
-	conds = validateAllowedScopes(oidcClient, conds)
+	conds = validateSpec(oidcClient, conds)
+
+	return allConditionsTrue(conds), conds, clientSecrets
+}
+
+// ValidateSpec validates the spec of the OIDCClient without its client secrets, e.g. before the OIDCClient
+// is created. It returns a bool to indicate if the spec is valid, along with a slice of conditions containing
+// more details.
+func ValidateSpec(oidcClient *supervisorconfigv1alpha1.OIDCClient) (bool, []*metav1.Condition) {
+	conds := validateSpec(oidcClient, make([]*metav1.Condition, 0, 6))
+	return allConditionsTrue(conds), conds
+}
 
-	valid := true
-	for _, cond := range conds {
+func validateSpec(oidcClient *supervisorconfigv1alpha1.OIDCClient, conditions []*metav1.Condition) []*metav1.Condition {
+	conditions = validateAllowedGrantTypes(oidcClient, conditions)
+	conditions = validateAllowedScopes(oidcClient, conditions)
+	conditions = validateAllowedIdentityProviders(oidcClient, conditions)
+	conditions = validateAdmissionPolicy(oidcClient, conditions)
+	conditions = validateRequestObjects(oidcClient, conditions)
+	conditions = validateTokenExchange(oidcClient, conditions)
+	return conditions
+}
+
+func allConditionsTrue(conditions []*metav1.Condition) bool {
+	for _, cond := range conditions {
 		if cond.Status != metav1.ConditionTrue {
-			valid = false
-			break
+			return false
 		}
 	}
-	return valid, conds, clientSecrets
+	return true
 }
 
 // validateAllowedScopes checks if allowedScopes is valid on the OIDCClient.
@@ -94,6 +127,147 @@ func validateAllowedScopes(oidcClient *supervisorconfigv1alpha1.OIDCClient, cond
 	return conditions
 }
 
+// validateAllowedIdentityProviders checks if allowedIdentityProviders is valid on the OIDCClient.
+// The names cannot be checked against any FederationDomain here, because an OIDCClient may be used with any
+// FederationDomain, so unknown names are simply never matched at login time.
+func validateAllowedIdentityProviders(oidcClient *supervisorconfigv1alpha1.OIDCClient, conditions []*metav1.Condition) []*metav1.Condition {
+	if slices.Contains(oidcClient.Spec.AllowedIdentityProviders, "") {
+		return append(conditions, &metav1.Condition{
+			Type:    allowedIdentityProvidersValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonInvalidValue,
+			Message: fmt.Sprintf("%q must not contain empty names", allowedIdentityProvidersFieldName),
+		})
+	}
+
+	return append(conditions, &metav1.Condition{
+		Type:    allowedIdentityProvidersValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  conditionsutil.ReasonSuccess,
+		Message: fmt.Sprintf("%q is valid", allowedIdentityProvidersFieldName),
+	})
+}
+
+// validateAdmissionPolicy checks if admissionPolicy is valid on the OIDCClient.
+func validateAdmissionPolicy(oidcClient *supervisorconfigv1alpha1.OIDCClient, conditions []*metav1.Condition) []*metav1.Condition {
+	policy := oidcClient.Spec.AdmissionPolicy
+	m := make([]string, 0, 3)
+	reason := reasonInvalidValue
+
+	if policy != nil {
+		if len(policy.AllowedUsernames) == 0 && len(policy.AllowedGroups) == 0 {
+			// A policy which admits nobody is almost certainly a mistake, so do not allow it.
+			m = append(m, fmt.Sprintf("%q must list at least one of \"allowedUsernames\" or \"allowedGroups\"", admissionPolicyFieldName))
+			reason = reasonMissingRequiredValue
+		}
+		if slices.Contains(policy.AllowedUsernames, "") {
+			m = append(m, fmt.Sprintf("%q must not contain empty usernames", admissionPolicyFieldName+".allowedUsernames"))
+		}
+		if slices.Contains(policy.AllowedGroups, "") {
+			m = append(m, fmt.Sprintf("%q must not contain empty group names", admissionPolicyFieldName+".allowedGroups"))
+		}
+	}
+
+	if len(m) == 0 {
+		conditions = append(conditions, &metav1.Condition{
+			Type:    admissionPolicyValid,
+			Status:  metav1.ConditionTrue,
+			Reason:  conditionsutil.ReasonSuccess,
+			Message: fmt.Sprintf("%q is valid", admissionPolicyFieldName),
+		})
+	} else {
+		conditions = append(conditions, &metav1.Condition{
+			Type:    admissionPolicyValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  reason,
+			Message: strings.Join(m, "; "),
+		})
+	}
+
+	return conditions
+}
+
+// validateRequestObjects checks if requestObjects is valid on the OIDCClient.
+func validateRequestObjects(oidcClient *supervisorconfigv1alpha1.OIDCClient, conditions []*metav1.Condition) []*metav1.Condition {
+	if requestObjects := oidcClient.Spec.RequestObjects; requestObjects != nil {
+		if _, err := ParsePublicSigningKeys(requestObjects.JWKS); err != nil {
+			return append(conditions, &metav1.Condition{
+				Type:    requestObjectsValid,
+				Status:  metav1.ConditionFalse,
+				Reason:  reasonInvalidValue,
+				Message: fmt.Sprintf("%q is invalid: %s", requestObjectsFieldName+".jwks", err.Error()),
+			})
+		}
+	}
+
+	return append(conditions, &metav1.Condition{
+		Type:    requestObjectsValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  conditionsutil.ReasonSuccess,
+		Message: fmt.Sprintf("%q is valid", requestObjectsFieldName),
+	})
+}
+
+// validateTokenExchange checks if tokenExchange is valid on the OIDCClient.
+func validateTokenExchange(oidcClient *supervisorconfigv1alpha1.OIDCClient, conditions []*metav1.Condition) []*metav1.Condition {
+	tokenExchange := oidcClient.Spec.TokenExchange
+	m := make([]string, 0, 3)
+
+	if tokenExchange != nil {
+		if !allowedGrantTypesContains(oidcClient, oidcapi.GrantTypeTokenExchange) {
+			m = append(m, fmt.Sprintf("%q may only be specified when %q is included in %q",
+				tokenExchangeFieldName, oidcapi.GrantTypeTokenExchange, allowedGrantTypesFieldName))
+		}
+		if slices.Contains(tokenExchange.AllowedAudiences, "") {
+			m = append(m, fmt.Sprintf("%q must not contain empty audiences", tokenExchangeFieldName+".allowedAudiences"))
+		}
+		for i, issuer := range tokenExchange.TrustedIssuers {
+			if _, err := ParsePublicSigningKeys(issuer.JWKS); err != nil {
+				m = append(m, fmt.Sprintf("%q is invalid: %s", fmt.Sprintf("%s.trustedIssuers[%d].jwks", tokenExchangeFieldName, i), err.Error()))
+			}
+		}
+	}
+
+	if len(m) == 0 {
+		conditions = append(conditions, &metav1.Condition{
+			Type:    tokenExchangeValid,
+			Status:  metav1.ConditionTrue,
+			Reason:  conditionsutil.ReasonSuccess,
+			Message: fmt.Sprintf("%q is valid", tokenExchangeFieldName),
+		})
+	} else {
+		conditions = append(conditions, &metav1.Condition{
+			Type:    tokenExchangeValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  reasonInvalidValue,
+			Message: strings.Join(m, "; "),
+		})
+	}
+
+	return conditions
+}
+
+// ParsePublicSigningKeys parses a JSON Web Key Set of an OIDCClient, such as the keys which verify the signatures
+// of its request objects. The key set must contain at least one key, and only public signing keys.
+func ParsePublicSigningKeys(jwks string) (*jose.JSONWebKeySet, error) {
+	keySet := &jose.JSONWebKeySet{}
+	if err := json.Unmarshal([]byte(jwks), keySet); err != nil {
+		return nil, fmt.Errorf("could not parse JSON Web Key Set: %w", err)
+	}
+	if len(keySet.Keys) == 0 {
+		return nil, errors.New("JSON Web Key Set must contain at least one key")
+	}
+	for i, key := range keySet.Keys {
+		if !key.Valid() || !key.IsPublic() {
+			return nil, fmt.Errorf("key %d of JSON Web Key Set must be a public key", i)
+		}
+		if key.Use != "" && key.Use != "sig" {
+			return nil, fmt.Errorf("key %d of JSON Web Key Set must be a signing key", i)
+		}
+	}
+	return keySet, nil
+}
+
 // validateAllowedGrantTypes checks if allowedGrantTypes is valid on the OIDCClient.
 func validateAllowedGrantTypes(oidcClient *supervisorconfigv1alpha1.OIDCClient, conditions []*metav1.Condition) []*metav1.Condition {
 	m := make([]string, 0, 3)
@@ -72,6 +72,12 @@ type RefreshedIdentity struct {
 	// group memberships).
 	UpstreamGroups []string
 
+	// The additionalClaims which should replace the additionalClaims in the user's session, mapped from the
+	// refreshed upstream identity. If the identity provider does not refresh additional claims, then set this
+	// to nil, and the user's old additional claims from their session will be used again. Returning an empty
+	// map will remove the additionalClaims from the user's session.
+	DownstreamAdditionalClaims map[string]any
+
 	// The portion of the user's session data which is specific to the upstream identity provider type.
 	// Refer to the fields of psession.CustomSessionData whose types are specific to an identity provider type.
 	// Set this to be the potentially updated IDP-specific session data. If no updates were required, then
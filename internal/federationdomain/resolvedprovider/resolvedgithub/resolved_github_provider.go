@@ -132,7 +132,7 @@ func (p *FederationDomainResolvedGitHubIdentityProvider) LoginFromCallback(
 			},
 		},
 		&resolvedprovider.IdentityLoginExtras{
-			DownstreamAdditionalClaims: nil, // not using this for GitHub
+			DownstreamAdditionalClaims: user.AdditionalClaims,
 			Warnings:                   nil, // not using this for GitHub
 		},
 		nil // no error
@@ -165,9 +165,10 @@ func (p *FederationDomainResolvedGitHubIdentityProvider) UpstreamRefresh(
 	}
 
 	return &resolvedprovider.RefreshedIdentity{
-		UpstreamUsername:       refreshedUserInfo.Username,
-		UpstreamGroups:         refreshedUserInfo.Groups,
-		IDPSpecificSessionData: nil, // nil means that no update to the GitHub-specific portion of the session data is required
+		UpstreamUsername:           refreshedUserInfo.Username,
+		UpstreamGroups:             refreshedUserInfo.Groups,
+		DownstreamAdditionalClaims: refreshedUserInfo.AdditionalClaims,
+		IDPSpecificSessionData:     nil, // nil means that no update to the GitHub-specific portion of the session data is required
 	}, nil
 }
 
@@ -165,6 +165,44 @@ func TestLoginFromCallback(t *testing.T) {
 			},
 			wantExtras: &resolvedprovider.IdentityLoginExtras{},
 		},
+		{
+			name: "happy path with additional claims",
+			provider: oidctestutil.NewTestUpstreamGitHubIdentityProviderBuilder().
+				WithAccessToken("fake-access-token").
+				WithUser(&upstreamprovider.GitHubUser{
+					Username:          "fake-username",
+					Groups:            []string{"fake-org:admin"},
+					DownstreamSubject: "https://fake-downstream-subject",
+					AdditionalClaims:  map[string]any{"email": "fake-user@example.com"},
+				}).
+				Build(),
+			idpDisplayName:           "fake-display-name",
+			authcode:                 "fake-authcode",
+			redirectURI:              "https://fake-redirect-uri",
+			wantExchangeAuthcodeCall: true,
+			wantExchangeAuthcodeArgs: &oidctestutil.ExchangeAuthcodeArgs{
+				Ctx:         uniqueCtx,
+				Authcode:    "fake-authcode",
+				RedirectURI: "https://fake-redirect-uri",
+			},
+			wantGetUserCall: true,
+			wantGetUserArgs: &oidctestutil.GetUserArgs{
+				Ctx:            uniqueCtx,
+				AccessToken:    "fake-access-token",
+				IDPDisplayName: "fake-display-name",
+			},
+			wantIdentity: &resolvedprovider.Identity{
+				UpstreamUsername:  "fake-username",
+				UpstreamGroups:    []string{"fake-org:admin"},
+				DownstreamSubject: "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.GitHubSessionData{
+					UpstreamAccessToken: "fake-access-token",
+				},
+			},
+			wantExtras: &resolvedprovider.IdentityLoginExtras{
+				DownstreamAdditionalClaims: map[string]any{"email": "fake-user@example.com"},
+			},
+		},
 		{
 			name: "error while exchanging authcode",
 			provider: oidctestutil.NewTestUpstreamGitHubIdentityProviderBuilder().
@@ -332,6 +370,36 @@ func TestUpstreamRefresh(t *testing.T) {
 				IDPSpecificSessionData: nil,
 			},
 		},
+		{
+			name: "happy path with additional claims",
+			provider: oidctestutil.NewTestUpstreamGitHubIdentityProviderBuilder().
+				WithUser(&upstreamprovider.GitHubUser{
+					Username:          "refreshed-username",
+					Groups:            []string{"refreshed-org:member"},
+					DownstreamSubject: "https://fake-downstream-subject",
+					AdditionalClaims:  map[string]any{"email": "refreshed-user@example.com"},
+				}).
+				Build(),
+			identity: &resolvedprovider.Identity{
+				UpstreamUsername:       "initial-username",
+				UpstreamGroups:         []string{"initial-org:admin"},
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.GitHubSessionData{UpstreamAccessToken: "fake-access-token"},
+			},
+			idpDisplayName:  "fake-display-name",
+			wantGetUserCall: true,
+			wantGetUserArgs: &oidctestutil.GetUserArgs{
+				Ctx:            uniqueCtx,
+				AccessToken:    "fake-access-token",
+				IDPDisplayName: "fake-display-name",
+			},
+			wantRefreshedIdentity: &resolvedprovider.RefreshedIdentity{
+				UpstreamUsername:           "refreshed-username",
+				UpstreamGroups:             []string{"refreshed-org:member"},
+				DownstreamAdditionalClaims: map[string]any{"email": "refreshed-user@example.com"},
+				IDPSpecificSessionData:     nil,
+			},
+		},
 		{
 			name: "error while getting user info",
 			provider: oidctestutil.NewTestUpstreamGitHubIdentityProviderBuilder().
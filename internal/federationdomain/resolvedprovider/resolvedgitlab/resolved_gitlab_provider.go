@@ -0,0 +1,198 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolvedgitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ory/fosite"
+	"golang.org/x/oauth2"
+
+	"go.pinniped.dev/generated/latest/apis/supervisor/idpdiscovery/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/pkg/oidcclient/nonce"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+// FederationDomainResolvedGitLabIdentityProvider represents a FederationDomainIdentityProvider which has
+// been resolved dynamically based on the currently loaded IDP CRs to include the provider.UpstreamGitLabIdentityProviderI
+// and other metadata about the provider.
+type FederationDomainResolvedGitLabIdentityProvider struct {
+	DisplayName         string
+	Provider            upstreamprovider.UpstreamGitLabIdentityProviderI
+	SessionProviderType psession.ProviderType
+	Transforms          *idtransform.TransformationPipeline
+}
+
+var _ resolvedprovider.FederationDomainResolvedIdentityProvider = (*FederationDomainResolvedGitLabIdentityProvider)(nil)
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) GetDisplayName() string {
+	return p.DisplayName
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) GetProvider() upstreamprovider.UpstreamIdentityProviderI {
+	return p.Provider
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) GetSessionProviderType() psession.ProviderType {
+	return p.SessionProviderType
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) GetIDPDiscoveryType() v1alpha1.IDPType {
+	return v1alpha1.IDPTypeGitLab
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) GetIDPDiscoveryFlows() []v1alpha1.IDPFlow {
+	return []v1alpha1.IDPFlow{v1alpha1.IDPFlowBrowserAuthcode}
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) GetTransforms() *idtransform.TransformationPipeline {
+	return p.Transforms
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) CloneIDPSpecificSessionDataFromSession(session *psession.CustomSessionData) any {
+	if session.GitLab == nil {
+		return nil
+	}
+	return session.GitLab.Clone()
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) ApplyIDPSpecificSessionDataToSession(session *psession.CustomSessionData, idpSpecificSessionData any) {
+	session.GitLab = idpSpecificSessionData.(*psession.GitLabSessionData)
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) UpstreamAuthorizeRedirectURL(
+	state *resolvedprovider.UpstreamAuthorizeRequestState,
+	downstreamIssuerURL string,
+) (string, error) {
+	upstreamOAuthConfig := oauth2.Config{
+		ClientID: p.Provider.GetClientID(),
+		Endpoint: oauth2.Endpoint{
+			AuthURL: p.Provider.GetAuthorizationURL(),
+		},
+		RedirectURL: fmt.Sprintf("%s/callback", downstreamIssuerURL),
+		Scopes:      p.Provider.GetScopes(),
+	}
+	// GitLab supports PKCE, see https://docs.gitlab.com/ee/api/oauth2.html#authorization-code-with-proof-key-for-code-exchange-pkce
+	redirectURL := upstreamOAuthConfig.AuthCodeURL(
+		state.EncodedStateParam,
+		state.PKCE.Challenge(),
+		state.PKCE.Method(),
+	)
+	return redirectURL, nil
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) Login(
+	_ context.Context,
+	_ string,
+	_ string,
+) (*resolvedprovider.Identity, *resolvedprovider.IdentityLoginExtras, error) {
+	return nil, nil, errors.New("function Login not yet implemented for GitLab IDP")
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) LoginFromCallback(
+	ctx context.Context,
+	authCode string,
+	pkce pkce.Code,
+	_ nonce.Nonce, // GitLab is not used as an OIDC provider here, therefore there is no ID token that could contain the "nonce".
+	redirectURI string,
+) (*resolvedprovider.Identity, *resolvedprovider.IdentityLoginExtras, error) {
+	token, err := p.Provider.ExchangeAuthcode(ctx, authCode, pkce, redirectURI)
+	if err != nil {
+		return nil, nil, httperr.Wrap(http.StatusBadGateway,
+			"failed to exchange authcode using GitLab API",
+			err,
+		)
+	}
+
+	user, err := p.Provider.GetUser(ctx, token.AccessToken, p.GetDisplayName())
+
+	if errors.As(err, &upstreamprovider.GitLabLoginDeniedError{}) {
+		// We specifically want errors of type GitLabLoginDeniedError to have a user-displayed message.
+		// Don't wrap the error since we include it in the sprintf here.
+		return nil, nil, httperr.Newf(http.StatusForbidden,
+			"login denied due to configuration on GitLabIdentityProvider with display name %q: %s",
+			p.GetDisplayName(), err)
+	} else if err != nil {
+		return nil, nil, httperr.Wrap(http.StatusUnprocessableEntity,
+			"failed to get user info from GitLab API",
+			err,
+		)
+	}
+
+	return &resolvedprovider.Identity{
+			UpstreamUsername:  user.Username,
+			UpstreamGroups:    user.Groups,
+			DownstreamSubject: user.DownstreamSubject,
+			IDPSpecificSessionData: &psession.GitLabSessionData{
+				UpstreamAccessToken:  token.AccessToken,
+				UpstreamRefreshToken: token.RefreshToken,
+			},
+		},
+		&resolvedprovider.IdentityLoginExtras{
+			DownstreamAdditionalClaims: nil, // not using this for GitLab
+			Warnings:                   nil, // not using this for GitLab
+		},
+		nil // no error
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) UpstreamRefresh(
+	ctx context.Context,
+	identity *resolvedprovider.Identity,
+) (*resolvedprovider.RefreshedIdentity, error) {
+	gitlabSessionData, ok := identity.IDPSpecificSessionData.(*psession.GitLabSessionData)
+	if !ok {
+		// This should not really happen.
+		return nil, p.refreshErr(errors.New("wrong data type found for IDPSpecificSessionData"))
+	}
+	if len(gitlabSessionData.UpstreamRefreshToken) == 0 {
+		// This should not really happen.
+		return nil, p.refreshErr(errors.New("session is missing GitLab refresh token"))
+	}
+
+	// GitLab access tokens expire quickly, so always get new tokens before calling the GitLab API.
+	refreshedToken, err := p.Provider.PerformRefresh(ctx, gitlabSessionData.UpstreamRefreshToken)
+	if err != nil {
+		return nil, p.refreshErr(err)
+	}
+	if len(refreshedToken.AccessToken) == 0 || len(refreshedToken.RefreshToken) == 0 {
+		return nil, p.refreshErr(errors.New("refresh response from GitLab did not include both an access token and a refresh token"))
+	}
+
+	// Get the user's GitLab identity and groups again using the new access token.
+	refreshedUserInfo, err := p.Provider.GetUser(ctx, refreshedToken.AccessToken, p.GetDisplayName())
+	if err != nil {
+		return nil, p.refreshErr(err)
+	}
+
+	if refreshedUserInfo.DownstreamSubject != identity.DownstreamSubject {
+		// The user's upstream identity changed since the initial login in a surprising way.
+		return nil, p.refreshErr(fmt.Errorf("user's calculated downstream subject at initial login was %q but now is %q",
+			identity.DownstreamSubject, refreshedUserInfo.DownstreamSubject))
+	}
+
+	return &resolvedprovider.RefreshedIdentity{
+		UpstreamUsername: refreshedUserInfo.Username,
+		UpstreamGroups:   refreshedUserInfo.Groups,
+		// GitLab rotates refresh tokens upon use, so the new tokens must be saved in the session.
+		IDPSpecificSessionData: &psession.GitLabSessionData{
+			UpstreamAccessToken:  refreshedToken.AccessToken,
+			UpstreamRefreshToken: refreshedToken.RefreshToken,
+		},
+	}, nil
+}
+
+func (p *FederationDomainResolvedGitLabIdentityProvider) refreshErr(err error) *fosite.RFC6749Error {
+	return resolvedprovider.ErrUpstreamRefreshError().
+		WithHint("Upstream refresh failed.").
+		WithTrace(err).
+		WithDebugf("provider name: %q, provider type: %q", p.Provider.GetResourceName(), p.GetSessionProviderType())
+}
@@ -5,6 +5,7 @@ package resolvedldap
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -21,6 +22,8 @@ import (
 	"go.pinniped.dev/internal/idtransform"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/totp"
+	"go.pinniped.dev/internal/upstreamldap"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/pkce"
 )
@@ -33,6 +36,9 @@ type FederationDomainResolvedLDAPIdentityProvider struct {
 	Provider            upstreamprovider.UpstreamLDAPIdentityProviderI
 	SessionProviderType psession.ProviderType
 	Transforms          *idtransform.TransformationPipeline
+
+	// TOTPPolicy describes when end users must provide a one-time password after their username and password.
+	TOTPPolicy totp.Policy
 }
 
 var _ resolvedprovider.FederationDomainResolvedIdentityProvider = (*FederationDomainResolvedLDAPIdentityProvider)(nil)
@@ -76,7 +82,7 @@ func (p *FederationDomainResolvedLDAPIdentityProvider) CloneIDPSpecificSessionDa
 			return nil
 		}
 		return session.ActiveDirectory.Clone()
-	case psession.ProviderTypeOIDC, psession.ProviderTypeGitHub: // this is just here to avoid a lint error about not handling all cases
+	case psession.ProviderTypeOIDC, psession.ProviderTypeGitHub, psession.ProviderTypeSAML, psession.ProviderTypeGitLab, psession.ProviderTypeOAuth2: // this is just here to avoid a lint error about not handling all cases
 		fallthrough
 	default:
 		return nil
@@ -119,6 +125,36 @@ var (
 		HintField:        "Username/password not accepted by LDAP provider.",
 		CodeField:        http.StatusForbidden,
 	}
+
+	// ErrAccessDeniedDueToPasswordExpired is returned by Login when the LDAP provider reported that the user's
+	// password has expired. Due to the way that fosite implements RFC6749Error.Is(), you must use "=="
+	// to compare this error to an error returned from Login().
+	ErrAccessDeniedDueToPasswordExpired = &fosite.RFC6749Error{
+		ErrorField:       "access_denied",
+		DescriptionField: "The resource owner or authorization server denied the request.",
+		HintField:        "Password has expired according to LDAP provider. Change your password and try again.",
+		CodeField:        http.StatusForbidden,
+	}
+
+	// ErrAccessDeniedDueToPasswordMustChange is returned by Login when the LDAP provider reported that the user
+	// must change their password before logging in. Due to the way that fosite implements RFC6749Error.Is(), you
+	// must use "==" to compare this error to an error returned from Login().
+	ErrAccessDeniedDueToPasswordMustChange = &fosite.RFC6749Error{
+		ErrorField:       "access_denied",
+		DescriptionField: "The resource owner or authorization server denied the request.",
+		HintField:        "Password must be changed according to LDAP provider. Change your password and try again.",
+		CodeField:        http.StatusForbidden,
+	}
+
+	// ErrAccessDeniedDueToAccountLocked is returned by Login when the LDAP provider reported that the user's
+	// account is locked. Due to the way that fosite implements RFC6749Error.Is(), you must use "=="
+	// to compare this error to an error returned from Login().
+	ErrAccessDeniedDueToAccountLocked = &fosite.RFC6749Error{
+		ErrorField:       "access_denied",
+		DescriptionField: "The resource owner or authorization server denied the request.",
+		HintField:        "Account is locked according to LDAP provider. Wait and try again later, or contact your administrator.",
+		CodeField:        http.StatusForbidden,
+	}
 )
 
 func (p *FederationDomainResolvedLDAPIdentityProvider) Login(
@@ -127,6 +163,13 @@ func (p *FederationDomainResolvedLDAPIdentityProvider) Login(
 	submittedPassword string,
 ) (*resolvedprovider.Identity, *resolvedprovider.IdentityLoginExtras, error) {
 	authenticateResponse, authenticated, err := p.Provider.AuthenticateUser(ctx, submittedUsername, submittedPassword)
+	accountStatusErr := &upstreamldap.AccountStatusError{}
+	if errors.As(err, &accountStatusErr) {
+		plog.Info("upstream LDAP authentication denied due to account status",
+			"upstreamName", p.Provider.GetResourceName(),
+			"reason", accountStatusErr.Reason)
+		return nil, nil, accessDeniedErrorForAccountStatus(accountStatusErr.Reason)
+	}
 	if err != nil {
 		plog.WarningErr("unexpected error during upstream LDAP authentication", err, "upstreamName", p.Provider.GetResourceName())
 		return nil, nil, ErrUnexpectedUpstreamLDAPError.WithWrap(err)
@@ -135,6 +178,31 @@ func (p *FederationDomainResolvedLDAPIdentityProvider) Login(
 		return nil, nil, ErrAccessDeniedDueToUsernamePasswordNotAccepted
 	}
 
+	return p.identityFromAuthenticateResponse(authenticateResponse)
+}
+
+// LoginWithoutPasswordCheck finds the end user by their username, like Login, but without checking their password.
+// It is used to finish a login which requires a one-time password after the end user's password was already
+// checked by Login, so the end user does not need to submit their password twice. It must not be used otherwise.
+func (p *FederationDomainResolvedLDAPIdentityProvider) LoginWithoutPasswordCheck(
+	ctx context.Context,
+	submittedUsername string,
+) (*resolvedprovider.Identity, *resolvedprovider.IdentityLoginExtras, error) {
+	authenticateResponse, found, err := p.Provider.DryRunAuthenticateUser(ctx, submittedUsername)
+	if err != nil {
+		plog.WarningErr("unexpected error during upstream LDAP authentication", err, "upstreamName", p.Provider.GetResourceName())
+		return nil, nil, ErrUnexpectedUpstreamLDAPError.WithWrap(err)
+	}
+	if !found {
+		return nil, nil, ErrAccessDeniedDueToUsernamePasswordNotAccepted
+	}
+
+	return p.identityFromAuthenticateResponse(authenticateResponse)
+}
+
+func (p *FederationDomainResolvedLDAPIdentityProvider) identityFromAuthenticateResponse(
+	authenticateResponse *authenticators.Response,
+) (*resolvedprovider.Identity, *resolvedprovider.IdentityLoginExtras, error) {
 	subject := downstreamSubjectFromUpstreamLDAP(p.Provider, authenticateResponse, p.GetDisplayName())
 	upstreamUsername := authenticateResponse.User.GetName()
 	upstreamGroups := authenticateResponse.User.GetGroups()
@@ -151,7 +219,7 @@ func (p *FederationDomainResolvedLDAPIdentityProvider) Login(
 			UserDN:                 authenticateResponse.DN,
 			ExtraRefreshAttributes: authenticateResponse.ExtraRefreshAttributes,
 		}
-	case psession.ProviderTypeOIDC, psession.ProviderTypeGitHub: // this is just here to avoid a lint error about not handling all cases
+	case psession.ProviderTypeOIDC, psession.ProviderTypeGitHub, psession.ProviderTypeSAML, psession.ProviderTypeGitLab, psession.ProviderTypeOAuth2: // this is just here to avoid a lint error about not handling all cases
 		fallthrough
 	default:
 		return nil, nil, ErrUnexpectedUpstreamLDAPError.WithWrap(fmt.Errorf("unexpected provider type %q", p.GetSessionProviderType()))
@@ -164,12 +232,26 @@ func (p *FederationDomainResolvedLDAPIdentityProvider) Login(
 			IDPSpecificSessionData: sessionData,
 		},
 		&resolvedprovider.IdentityLoginExtras{
-			DownstreamAdditionalClaims: nil,
+			DownstreamAdditionalClaims: authenticateResponse.AdditionalClaims,
 			Warnings:                   nil,
 		},
 		nil
 }
 
+// accessDeniedErrorForAccountStatus returns the error which Login returns for the account status reason.
+func accessDeniedErrorForAccountStatus(reason upstreamldap.AccountStatusReason) *fosite.RFC6749Error {
+	switch reason {
+	case upstreamldap.AccountStatusPasswordExpired:
+		return ErrAccessDeniedDueToPasswordExpired
+	case upstreamldap.AccountStatusPasswordMustChange:
+		return ErrAccessDeniedDueToPasswordMustChange
+	case upstreamldap.AccountStatusAccountLocked:
+		return ErrAccessDeniedDueToAccountLocked
+	default:
+		return ErrAccessDeniedDueToUsernamePasswordNotAccepted
+	}
+}
+
 func (p *FederationDomainResolvedLDAPIdentityProvider) LoginFromCallback(
 	_ctx context.Context,
 	_authCode string,
@@ -205,7 +287,7 @@ func (p *FederationDomainResolvedLDAPIdentityProvider) UpstreamRefresh(
 		}
 		dn = sessionData.UserDN
 		additionalAttributes = sessionData.ExtraRefreshAttributes
-	case psession.ProviderTypeOIDC, psession.ProviderTypeGitHub: // this is just here to avoid a lint error about not handling all cases
+	case psession.ProviderTypeOIDC, psession.ProviderTypeGitHub, psession.ProviderTypeSAML, psession.ProviderTypeGitLab, psession.ProviderTypeOAuth2: // this is just here to avoid a lint error about not handling all cases
 		fallthrough
 	default:
 		// This shouldn't really happen.
@@ -223,7 +305,7 @@ func (p *FederationDomainResolvedLDAPIdentityProvider) UpstreamRefresh(
 		"identityProviderType", p.GetSessionProviderType(),
 		"identityProviderUID", p.Provider.GetResourceUID())
 
-	refreshedUntransformedGroups, err := p.Provider.PerformRefresh(ctx, upstreamprovider.LDAPRefreshAttributes{
+	refreshedUntransformedGroups, refreshedAdditionalClaims, err := p.Provider.PerformRefresh(ctx, upstreamprovider.LDAPRefreshAttributes{
 		Username:             identity.UpstreamUsername,
 		Subject:              identity.DownstreamSubject,
 		DN:                   dn,
@@ -239,9 +321,10 @@ func (p *FederationDomainResolvedLDAPIdentityProvider) UpstreamRefresh(
 	return &resolvedprovider.RefreshedIdentity{
 		// LDAP PerformRefresh validates that the username did not change during refresh,
 		// so the original upstream username is also the refreshed upstream username.
-		UpstreamUsername:       identity.UpstreamUsername,
-		UpstreamGroups:         refreshedUntransformedGroups,
-		IDPSpecificSessionData: nil,
+		UpstreamUsername:           identity.UpstreamUsername,
+		UpstreamGroups:             refreshedUntransformedGroups,
+		DownstreamAdditionalClaims: refreshedAdditionalClaims,
+		IDPSpecificSessionData:     nil,
 	}, nil
 }
 
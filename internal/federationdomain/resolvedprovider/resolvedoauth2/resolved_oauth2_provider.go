@@ -0,0 +1,198 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolvedoauth2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ory/fosite"
+	"golang.org/x/oauth2"
+
+	"go.pinniped.dev/generated/latest/apis/supervisor/idpdiscovery/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/pkg/oidcclient/nonce"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+// FederationDomainResolvedOAuth2IdentityProvider represents a FederationDomainIdentityProvider which has
+// been resolved dynamically based on the currently loaded IDP CRs to include the provider.UpstreamOAuth2IdentityProviderI
+// and other metadata about the provider.
+type FederationDomainResolvedOAuth2IdentityProvider struct {
+	DisplayName         string
+	Provider            upstreamprovider.UpstreamOAuth2IdentityProviderI
+	SessionProviderType psession.ProviderType
+	Transforms          *idtransform.TransformationPipeline
+}
+
+var _ resolvedprovider.FederationDomainResolvedIdentityProvider = (*FederationDomainResolvedOAuth2IdentityProvider)(nil)
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) GetDisplayName() string {
+	return p.DisplayName
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) GetProvider() upstreamprovider.UpstreamIdentityProviderI {
+	return p.Provider
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) GetSessionProviderType() psession.ProviderType {
+	return p.SessionProviderType
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) GetIDPDiscoveryType() v1alpha1.IDPType {
+	return v1alpha1.IDPTypeOAuth2
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) GetIDPDiscoveryFlows() []v1alpha1.IDPFlow {
+	return []v1alpha1.IDPFlow{v1alpha1.IDPFlowBrowserAuthcode}
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) GetTransforms() *idtransform.TransformationPipeline {
+	return p.Transforms
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) CloneIDPSpecificSessionDataFromSession(session *psession.CustomSessionData) any {
+	if session.OAuth2 == nil {
+		return nil
+	}
+	return session.OAuth2.Clone()
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) ApplyIDPSpecificSessionDataToSession(session *psession.CustomSessionData, idpSpecificSessionData any) {
+	session.OAuth2 = idpSpecificSessionData.(*psession.OAuth2SessionData)
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) UpstreamAuthorizeRedirectURL(
+	state *resolvedprovider.UpstreamAuthorizeRequestState,
+	downstreamIssuerURL string,
+) (string, error) {
+	upstreamOAuthConfig := oauth2.Config{
+		ClientID: p.Provider.GetClientID(),
+		Endpoint: oauth2.Endpoint{
+			AuthURL: p.Provider.GetAuthorizationURL(),
+		},
+		RedirectURL: fmt.Sprintf("%s/callback", downstreamIssuerURL),
+		Scopes:      p.Provider.GetScopes(),
+	}
+	// Always use PKCE. OAuth2 servers which do not support PKCE are required to ignore these unrecognized
+	// request parameters, see https://datatracker.ietf.org/doc/html/rfc6749#section-3.1.
+	redirectURL := upstreamOAuthConfig.AuthCodeURL(
+		state.EncodedStateParam,
+		state.PKCE.Challenge(),
+		state.PKCE.Method(),
+	)
+	return redirectURL, nil
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) Login(
+	_ context.Context,
+	_ string,
+	_ string,
+) (*resolvedprovider.Identity, *resolvedprovider.IdentityLoginExtras, error) {
+	return nil, nil, errors.New("function Login not yet implemented for OAuth2 IDP")
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) LoginFromCallback(
+	ctx context.Context,
+	authCode string,
+	pkce pkce.Code,
+	_ nonce.Nonce, // OAuth2 is not OIDC, therefore there is no ID token that could contain the "nonce".
+	redirectURI string,
+) (*resolvedprovider.Identity, *resolvedprovider.IdentityLoginExtras, error) {
+	token, err := p.Provider.ExchangeAuthcode(ctx, authCode, pkce, redirectURI)
+	if err != nil {
+		return nil, nil, httperr.Wrap(http.StatusBadGateway,
+			"failed to exchange authcode using OAuth2 token endpoint",
+			err,
+		)
+	}
+
+	user, err := p.Provider.GetUser(ctx, token.AccessToken, p.GetDisplayName())
+	if err != nil {
+		return nil, nil, httperr.Wrap(http.StatusUnprocessableEntity,
+			"failed to get user info from OAuth2 user info API",
+			err,
+		)
+	}
+
+	return &resolvedprovider.Identity{
+			UpstreamUsername:  user.Username,
+			UpstreamGroups:    user.Groups,
+			DownstreamSubject: user.DownstreamSubject,
+			IDPSpecificSessionData: &psession.OAuth2SessionData{
+				UpstreamAccessToken:  token.AccessToken,
+				UpstreamRefreshToken: token.RefreshToken,
+			},
+		},
+		&resolvedprovider.IdentityLoginExtras{
+			DownstreamAdditionalClaims: nil, // not using this for OAuth2
+			Warnings:                   nil, // not using this for OAuth2
+		},
+		nil // no error
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) UpstreamRefresh(
+	ctx context.Context,
+	identity *resolvedprovider.Identity,
+) (*resolvedprovider.RefreshedIdentity, error) {
+	oauth2SessionData, ok := identity.IDPSpecificSessionData.(*psession.OAuth2SessionData)
+	if !ok {
+		// This should not really happen.
+		return nil, p.refreshErr(errors.New("wrong data type found for IDPSpecificSessionData"))
+	}
+	if len(oauth2SessionData.UpstreamAccessToken) == 0 {
+		// This should not really happen.
+		return nil, p.refreshErr(errors.New("session is missing OAuth2 access token"))
+	}
+
+	refreshedSessionData := oauth2SessionData.Clone()
+
+	// Not all OAuth2 providers issue refresh tokens. When there is one, use it to get new tokens before calling
+	// the user info APIs. Otherwise, use the access token from the initial login for as long as it remains valid.
+	if len(oauth2SessionData.UpstreamRefreshToken) > 0 {
+		refreshedToken, err := p.Provider.PerformRefresh(ctx, oauth2SessionData.UpstreamRefreshToken)
+		if err != nil {
+			return nil, p.refreshErr(err)
+		}
+		if len(refreshedToken.AccessToken) == 0 {
+			return nil, p.refreshErr(errors.New("refresh response from OAuth2 token endpoint did not include an access token"))
+		}
+		refreshedSessionData.UpstreamAccessToken = refreshedToken.AccessToken
+		// Providers which rotate refresh tokens return a new one, which must be saved in the session.
+		if len(refreshedToken.RefreshToken) > 0 {
+			refreshedSessionData.UpstreamRefreshToken = refreshedToken.RefreshToken
+		}
+	}
+
+	// Get the user's identity and groups again using the (possibly new) access token.
+	refreshedUserInfo, err := p.Provider.GetUser(ctx, refreshedSessionData.UpstreamAccessToken, p.GetDisplayName())
+	if err != nil {
+		return nil, p.refreshErr(err)
+	}
+
+	if refreshedUserInfo.DownstreamSubject != identity.DownstreamSubject {
+		// The user's upstream identity changed since the initial login in a surprising way.
+		return nil, p.refreshErr(fmt.Errorf("user's calculated downstream subject at initial login was %q but now is %q",
+			identity.DownstreamSubject, refreshedUserInfo.DownstreamSubject))
+	}
+
+	return &resolvedprovider.RefreshedIdentity{
+		UpstreamUsername:       refreshedUserInfo.Username,
+		UpstreamGroups:         refreshedUserInfo.Groups,
+		IDPSpecificSessionData: refreshedSessionData,
+	}, nil
+}
+
+func (p *FederationDomainResolvedOAuth2IdentityProvider) refreshErr(err error) *fosite.RFC6749Error {
+	return resolvedprovider.ErrUpstreamRefreshError().
+		WithHint("Upstream refresh failed.").
+		WithTrace(err).
+		WithDebugf("provider name: %q, provider type: %q", p.Provider.GetResourceName(), p.GetSessionProviderType())
+}
@@ -0,0 +1,533 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolvedoauth2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	idpdiscoveryv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idpdiscovery/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+	"go.pinniped.dev/internal/testutil/transformtestutil"
+	"go.pinniped.dev/internal/upstreamoauth2"
+)
+
+func TestFederationDomainResolvedOAuth2IdentityProvider(t *testing.T) {
+	transforms := transformtestutil.NewRejectAllAuthPipeline(t)
+
+	provider := upstreamoauth2.New(upstreamoauth2.ProviderConfig{
+		Name:        "fake-provider-config",
+		ResourceUID: "fake-resource-uid",
+		UserInfoURL: "https://fake-api-host.com/user",
+		HttpClient:  nil, // not needed yet for this test
+		OAuth2Config: &oauth2.Config{
+			ClientID:     "fake-client-id",
+			ClientSecret: "fake-client-secret",
+			Scopes:       []string{"read_user", "read_groups"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   "https://fake-authorization-url",
+				TokenURL:  "https://fake-token-url",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+	})
+
+	subject := FederationDomainResolvedOAuth2IdentityProvider{
+		DisplayName:         "fake-display-name",
+		Provider:            provider,
+		SessionProviderType: psession.ProviderTypeOAuth2,
+		Transforms:          transforms,
+	}
+
+	require.Equal(t, "fake-display-name", subject.GetDisplayName())
+	require.Equal(t, provider, subject.GetProvider())
+	require.Equal(t, psession.ProviderTypeOAuth2, subject.GetSessionProviderType())
+	require.Equal(t, idpdiscoveryv1alpha1.IDPTypeOAuth2, subject.GetIDPDiscoveryType())
+	require.Equal(t, []idpdiscoveryv1alpha1.IDPFlow{idpdiscoveryv1alpha1.IDPFlowBrowserAuthcode}, subject.GetIDPDiscoveryFlows())
+	require.Equal(t, transforms, subject.GetTransforms())
+
+	originalCustomSession := &psession.CustomSessionData{
+		Username:         "fake-username",
+		UpstreamUsername: "fake-upstream-username",
+		OAuth2: &psession.OAuth2SessionData{
+			UpstreamAccessToken:  "fake-upstream-access-token",
+			UpstreamRefreshToken: "fake-upstream-refresh-token",
+		},
+	}
+	clonedCustomSession := subject.CloneIDPSpecificSessionDataFromSession(originalCustomSession)
+	require.Equal(t,
+		&psession.OAuth2SessionData{
+			UpstreamAccessToken:  "fake-upstream-access-token",
+			UpstreamRefreshToken: "fake-upstream-refresh-token",
+		},
+		clonedCustomSession,
+	)
+	require.NotSame(t, originalCustomSession.OAuth2, clonedCustomSession)
+
+	require.Nil(t, subject.CloneIDPSpecificSessionDataFromSession(&psession.CustomSessionData{}))
+
+	customSessionToBeMutated := &psession.CustomSessionData{
+		Username:         "fake-username2",
+		UpstreamUsername: "fake-upstream-username2",
+	}
+	subject.ApplyIDPSpecificSessionDataToSession(customSessionToBeMutated, &psession.OAuth2SessionData{
+		UpstreamAccessToken:  "OTHER-upstream-access-token",
+		UpstreamRefreshToken: "OTHER-upstream-refresh-token",
+	})
+	require.Equal(t, &psession.CustomSessionData{
+		Username:         "fake-username2",
+		UpstreamUsername: "fake-upstream-username2",
+		OAuth2: &psession.OAuth2SessionData{
+			UpstreamAccessToken:  "OTHER-upstream-access-token",
+			UpstreamRefreshToken: "OTHER-upstream-refresh-token",
+		},
+	}, customSessionToBeMutated)
+
+	redirectURL, err := subject.UpstreamAuthorizeRedirectURL(
+		&resolvedprovider.UpstreamAuthorizeRequestState{
+			EncodedStateParam: "encodedStateParam12345",
+			PKCE:              "pkce6789",
+			Nonce:             "nonce1289",
+		},
+		"https://localhost/fake/path",
+	)
+	require.NoError(t, err)
+	require.Equal(t,
+		"https://fake-authorization-url?"+
+			"client_id=fake-client-id&"+
+			"code_challenge=3indzSdebyEpaWs9NNOPrtx0sw3z6VhUM9MPRjji134&"+
+			"code_challenge_method=S256&"+
+			"redirect_uri=https%3A%2F%2Flocalhost%2Ffake%2Fpath%2Fcallback&"+
+			"response_type=code&"+
+			"scope=read_user+read_groups&"+
+			"state=encodedStateParam12345",
+		redirectURL,
+	)
+}
+
+func TestLoginFromCallback(t *testing.T) {
+	uniqueCtx := context.WithValue(context.Background(), "some-unique-key", "some-value") //nolint:staticcheck // okay to use string key for test
+
+	fakeToken := &oauth2.Token{AccessToken: "fake-access-token", RefreshToken: "fake-refresh-token"}
+
+	tests := []struct {
+		name           string
+		provider       *oidctestutil.TestUpstreamOAuth2IdentityProvider
+		idpDisplayName string
+		authcode       string
+		redirectURI    string
+
+		wantExchangeAuthcodeCall bool
+		wantExchangeAuthcodeArgs *oidctestutil.ExchangeOAuth2AuthcodeArgs
+		wantGetUserCall          bool
+		wantGetUserArgs          *oidctestutil.GetUserArgs
+		wantIdentity             *resolvedprovider.Identity
+		wantExtras               *resolvedprovider.IdentityLoginExtras
+		wantErrMsg               string
+		wantErrResponseMsg       string
+		wantErrStatusCode        int
+	}{
+		{
+			name: "happy path",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithToken(fakeToken).
+				WithUser(&upstreamprovider.OAuth2User{
+					Username:          "fake-username",
+					Groups:            []string{"fake-group1", "fake-group2"},
+					DownstreamSubject: "https://fake-downstream-subject",
+				}).
+				Build(),
+			idpDisplayName:           "fake-display-name",
+			authcode:                 "fake-authcode",
+			redirectURI:              "https://fake-redirect-uri",
+			wantExchangeAuthcodeCall: true,
+			wantExchangeAuthcodeArgs: &oidctestutil.ExchangeOAuth2AuthcodeArgs{
+				Ctx:              uniqueCtx,
+				Authcode:         "fake-authcode",
+				PKCECodeVerifier: "fake-pkce",
+				RedirectURI:      "https://fake-redirect-uri",
+			},
+			wantGetUserCall: true,
+			wantGetUserArgs: &oidctestutil.GetUserArgs{
+				Ctx:            uniqueCtx,
+				AccessToken:    "fake-access-token",
+				IDPDisplayName: "fake-display-name",
+			},
+			wantIdentity: &resolvedprovider.Identity{
+				UpstreamUsername:  "fake-username",
+				UpstreamGroups:    []string{"fake-group1", "fake-group2"},
+				DownstreamSubject: "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.OAuth2SessionData{
+					UpstreamAccessToken:  "fake-access-token",
+					UpstreamRefreshToken: "fake-refresh-token",
+				},
+			},
+			wantExtras: &resolvedprovider.IdentityLoginExtras{},
+		},
+		{
+			name: "error while exchanging authcode",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithAuthcodeExchangeError(errors.New("fake authcode exchange error")).
+				Build(),
+			idpDisplayName:           "fake-display-name",
+			authcode:                 "fake-authcode",
+			redirectURI:              "https://fake-redirect-uri",
+			wantExchangeAuthcodeCall: true,
+			wantExchangeAuthcodeArgs: &oidctestutil.ExchangeOAuth2AuthcodeArgs{
+				Ctx:              uniqueCtx,
+				Authcode:         "fake-authcode",
+				PKCECodeVerifier: "fake-pkce",
+				RedirectURI:      "https://fake-redirect-uri",
+			},
+			wantGetUserCall:    false,
+			wantErrMsg:         "failed to exchange authcode using OAuth2 token endpoint: fake authcode exchange error",
+			wantErrResponseMsg: "Bad Gateway: failed to exchange authcode using OAuth2 token endpoint",
+			wantErrStatusCode:  http.StatusBadGateway,
+		},
+		{
+			name: "generic error while getting user info",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithToken(fakeToken).
+				WithGetUserError(errors.New("fake user info error")).
+				Build(),
+			idpDisplayName:           "fake-display-name",
+			authcode:                 "fake-authcode",
+			redirectURI:              "https://fake-redirect-uri",
+			wantExchangeAuthcodeCall: true,
+			wantExchangeAuthcodeArgs: &oidctestutil.ExchangeOAuth2AuthcodeArgs{
+				Ctx:              uniqueCtx,
+				Authcode:         "fake-authcode",
+				PKCECodeVerifier: "fake-pkce",
+				RedirectURI:      "https://fake-redirect-uri",
+			},
+			wantGetUserCall: true,
+			wantGetUserArgs: &oidctestutil.GetUserArgs{
+				Ctx:            uniqueCtx,
+				AccessToken:    "fake-access-token",
+				IDPDisplayName: "fake-display-name",
+			},
+			wantErrMsg:         "failed to get user info from OAuth2 user info API: fake user info error",
+			wantErrResponseMsg: "Unprocessable Entity: failed to get user info from OAuth2 user info API",
+			wantErrStatusCode:  http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject := FederationDomainResolvedOAuth2IdentityProvider{
+				DisplayName:         test.idpDisplayName,
+				Provider:            test.provider,
+				SessionProviderType: psession.ProviderTypeOAuth2,
+				Transforms:          transformtestutil.NewRejectAllAuthPipeline(t),
+			}
+
+			identity, loginExtras, err := subject.LoginFromCallback(uniqueCtx,
+				test.authcode,
+				"fake-pkce",
+				"nonce-will-be-ignored",
+				test.redirectURI,
+			)
+
+			if test.wantExchangeAuthcodeCall {
+				require.Equal(t, 1, test.provider.ExchangeAuthcodeCallCount())
+				require.Equal(t, test.wantExchangeAuthcodeArgs, test.provider.ExchangeAuthcodeArgs(0))
+			} else {
+				require.Zero(t, test.provider.ExchangeAuthcodeCallCount())
+			}
+
+			if test.wantGetUserCall {
+				require.Equal(t, 1, test.provider.GetUserCallCount())
+				require.Equal(t, test.wantGetUserArgs, test.provider.GetUserArgs(0))
+			} else {
+				require.Zero(t, test.provider.GetUserCallCount())
+			}
+
+			if test.wantErrResponseMsg == "" {
+				require.NoError(t, err)
+			} else {
+				require.Implements(t, (*httperr.Responder)(nil), err)
+				errAsResponder := err.(httperr.Responder)
+				rec := httptest.NewRecorder()
+				errAsResponder.Respond(rec)
+				require.Equal(t, test.wantErrStatusCode, rec.Code)
+				require.Equal(t, test.wantErrResponseMsg+"\n", rec.Body.String())
+
+				require.EqualError(t, errAsResponder, test.wantErrMsg)
+			}
+			require.Equal(t, test.wantExtras, loginExtras)
+			require.Equal(t, test.wantIdentity, identity)
+		})
+	}
+}
+
+func TestUpstreamRefresh(t *testing.T) {
+	uniqueCtx := context.WithValue(context.Background(), "some-unique-key", "some-value") //nolint:staticcheck // okay to use string key for test
+
+	initialSessionData := &psession.OAuth2SessionData{
+		UpstreamAccessToken:  "initial-access-token",
+		UpstreamRefreshToken: "initial-refresh-token",
+	}
+	refreshedToken := &oauth2.Token{AccessToken: "refreshed-access-token", RefreshToken: "refreshed-refresh-token"}
+
+	tests := []struct {
+		name           string
+		provider       *oidctestutil.TestUpstreamOAuth2IdentityProvider
+		idpDisplayName string
+		identity       *resolvedprovider.Identity
+
+		wantPerformRefreshCall bool
+		wantGetUserCall        bool
+		wantGetUserArgs        *oidctestutil.GetUserArgs
+		wantRefreshedIdentity  *resolvedprovider.RefreshedIdentity
+		wantWrappedErr         string
+	}{
+		{
+			name: "happy path",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithRefreshedToken(refreshedToken).
+				WithUser(&upstreamprovider.OAuth2User{
+					Username:          "refreshed-username",
+					Groups:            []string{"refreshed-group1", "refreshed-group2"},
+					DownstreamSubject: "https://fake-downstream-subject",
+				}).
+				Build(),
+			identity: &resolvedprovider.Identity{
+				UpstreamUsername:       "initial-username",
+				UpstreamGroups:         []string{"initial-group1", "initial-group2"},
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: initialSessionData,
+			},
+			idpDisplayName:         "fake-display-name",
+			wantPerformRefreshCall: true,
+			wantGetUserCall:        true,
+			wantGetUserArgs: &oidctestutil.GetUserArgs{
+				Ctx:            uniqueCtx,
+				AccessToken:    "refreshed-access-token",
+				IDPDisplayName: "fake-display-name",
+			},
+			wantRefreshedIdentity: &resolvedprovider.RefreshedIdentity{
+				UpstreamUsername: "refreshed-username",
+				UpstreamGroups:   []string{"refreshed-group1", "refreshed-group2"},
+				IDPSpecificSessionData: &psession.OAuth2SessionData{
+					UpstreamAccessToken:  "refreshed-access-token",
+					UpstreamRefreshToken: "refreshed-refresh-token",
+				},
+			},
+		},
+		{
+			name: "error while performing refresh",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithName("fake-provider-name").
+				WithPerformRefreshError(errors.New("fake oauth2 PerformRefresh error message")).
+				Build(),
+			identity: &resolvedprovider.Identity{
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: initialSessionData,
+			},
+			idpDisplayName:         "fake-display-name",
+			wantPerformRefreshCall: true,
+			wantGetUserCall:        false,
+			wantWrappedErr:         "fake oauth2 PerformRefresh error message",
+		},
+		{
+			name: "refresh does not return a new refresh token, so the original refresh token is kept",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithRefreshedToken(&oauth2.Token{AccessToken: "refreshed-access-token"}).
+				WithUser(&upstreamprovider.OAuth2User{
+					Username:          "refreshed-username",
+					DownstreamSubject: "https://fake-downstream-subject",
+				}).
+				Build(),
+			identity: &resolvedprovider.Identity{
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: initialSessionData,
+			},
+			idpDisplayName:         "fake-display-name",
+			wantPerformRefreshCall: true,
+			wantGetUserCall:        true,
+			wantGetUserArgs: &oidctestutil.GetUserArgs{
+				Ctx:            uniqueCtx,
+				AccessToken:    "refreshed-access-token",
+				IDPDisplayName: "fake-display-name",
+			},
+			wantRefreshedIdentity: &resolvedprovider.RefreshedIdentity{
+				UpstreamUsername: "refreshed-username",
+				IDPSpecificSessionData: &psession.OAuth2SessionData{
+					UpstreamAccessToken:  "refreshed-access-token",
+					UpstreamRefreshToken: "initial-refresh-token",
+				},
+			},
+		},
+		{
+			name: "refresh does not return an access token",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithName("fake-provider-name").
+				WithRefreshedToken(&oauth2.Token{RefreshToken: "refreshed-refresh-token"}).
+				Build(),
+			identity: &resolvedprovider.Identity{
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: initialSessionData,
+			},
+			idpDisplayName:         "fake-display-name",
+			wantPerformRefreshCall: true,
+			wantGetUserCall:        false,
+			wantWrappedErr:         "refresh response from OAuth2 token endpoint did not include an access token",
+		},
+		{
+			name: "session has no refresh token, so the original access token is used to get user info",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithUser(&upstreamprovider.OAuth2User{
+					Username:          "refreshed-username",
+					Groups:            []string{"refreshed-group1"},
+					DownstreamSubject: "https://fake-downstream-subject",
+				}).
+				Build(),
+			identity: &resolvedprovider.Identity{
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.OAuth2SessionData{UpstreamAccessToken: "initial-access-token"},
+			},
+			idpDisplayName:         "fake-display-name",
+			wantPerformRefreshCall: false,
+			wantGetUserCall:        true,
+			wantGetUserArgs: &oidctestutil.GetUserArgs{
+				Ctx:            uniqueCtx,
+				AccessToken:    "initial-access-token",
+				IDPDisplayName: "fake-display-name",
+			},
+			wantRefreshedIdentity: &resolvedprovider.RefreshedIdentity{
+				UpstreamUsername: "refreshed-username",
+				UpstreamGroups:   []string{"refreshed-group1"},
+				IDPSpecificSessionData: &psession.OAuth2SessionData{
+					UpstreamAccessToken: "initial-access-token",
+				},
+			},
+		},
+		{
+			name: "error while getting user info",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithName("fake-provider-name").
+				WithRefreshedToken(refreshedToken).
+				WithGetUserError(errors.New("fake oauth2 GetUser error message")).
+				Build(),
+			identity: &resolvedprovider.Identity{
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: initialSessionData,
+			},
+			idpDisplayName:         "fake-display-name",
+			wantPerformRefreshCall: true,
+			wantGetUserCall:        true,
+			wantGetUserArgs: &oidctestutil.GetUserArgs{
+				Ctx:            uniqueCtx,
+				AccessToken:    "refreshed-access-token",
+				IDPDisplayName: "fake-display-name",
+			},
+			wantWrappedErr: "fake oauth2 GetUser error message",
+		},
+		{
+			name: "wrong session data type, which should not really happen",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithName("fake-provider-name").
+				Build(),
+			identity: &resolvedprovider.Identity{
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.LDAPSessionData{}, // wrong type
+			},
+			idpDisplayName: "fake-display-name",
+			wantWrappedErr: "wrong data type found for IDPSpecificSessionData",
+		},
+		{
+			name: "session is missing access token, which should not really happen",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithName("fake-provider-name").
+				Build(),
+			identity: &resolvedprovider.Identity{
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.OAuth2SessionData{}, // missing access token
+			},
+			idpDisplayName: "fake-display-name",
+			wantWrappedErr: "session is missing OAuth2 access token",
+		},
+		{
+			name: "users downstream subject changes based on an unexpected change in the upstream identity",
+			provider: oidctestutil.NewTestUpstreamOAuth2IdentityProviderBuilder().
+				WithName("fake-provider-name").
+				WithRefreshedToken(refreshedToken).
+				WithUser(&upstreamprovider.OAuth2User{
+					Username:          "refreshed-username",
+					DownstreamSubject: "https://unexpected-different-downstream-subject", // unexpected change in calculated subject during refresh
+				}).
+				Build(),
+			identity: &resolvedprovider.Identity{
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: initialSessionData,
+			},
+			idpDisplayName:         "fake-display-name",
+			wantPerformRefreshCall: true,
+			wantGetUserCall:        true,
+			wantGetUserArgs: &oidctestutil.GetUserArgs{
+				Ctx:            uniqueCtx,
+				AccessToken:    "refreshed-access-token",
+				IDPDisplayName: "fake-display-name",
+			},
+			wantWrappedErr: `user's calculated downstream subject at initial login was "https://fake-downstream-subject" ` +
+				`but now is "https://unexpected-different-downstream-subject"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject := FederationDomainResolvedOAuth2IdentityProvider{
+				DisplayName:         test.idpDisplayName,
+				Provider:            test.provider,
+				SessionProviderType: psession.ProviderTypeOAuth2,
+				Transforms:          transformtestutil.NewRejectAllAuthPipeline(t),
+			}
+
+			refreshedIdentity, err := subject.UpstreamRefresh(uniqueCtx, test.identity)
+
+			if test.wantPerformRefreshCall {
+				require.Equal(t, 1, test.provider.PerformRefreshCallCount())
+				require.Equal(t, &oidctestutil.PerformOAuth2RefreshArgs{
+					Ctx:          uniqueCtx,
+					RefreshToken: "initial-refresh-token",
+				}, test.provider.PerformRefreshArgs(0))
+			} else {
+				require.Zero(t, test.provider.PerformRefreshCallCount())
+			}
+
+			if test.wantGetUserCall {
+				require.Equal(t, 1, test.provider.GetUserCallCount())
+				require.Equal(t, test.wantGetUserArgs, test.provider.GetUserArgs(0))
+			} else {
+				require.Zero(t, test.provider.GetUserCallCount())
+			}
+
+			if test.wantWrappedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.NotNil(t, err, "expected to get an error but did not get one")
+				errAsFositeErr, ok := err.(*fosite.RFC6749Error)
+				require.True(t, ok)
+				require.EqualError(t, errAsFositeErr.Unwrap(), test.wantWrappedErr)
+				require.Equal(t, "error", errAsFositeErr.ErrorField)
+				require.Equal(t, "Error during upstream refresh.", errAsFositeErr.DescriptionField)
+				require.Equal(t, http.StatusUnauthorized, errAsFositeErr.CodeField)
+				require.Equal(t, `provider name: "fake-provider-name", provider type: "oauth2"`, errAsFositeErr.DebugField)
+			}
+
+			require.Equal(t, test.wantRefreshedIdentity, refreshedIdentity)
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolvedsaml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ory/fosite"
+
+	"go.pinniped.dev/generated/latest/apis/supervisor/idpdiscovery/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/oidc"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/pkg/oidcclient/nonce"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+// FederationDomainResolvedSAMLIdentityProvider represents a FederationDomainIdentityProvider which has
+// been resolved dynamically based on the currently loaded IDP CRs to include the provider.UpstreamSAMLIdentityProviderI
+// and other metadata about the provider.
+type FederationDomainResolvedSAMLIdentityProvider struct {
+	DisplayName         string
+	Provider            upstreamprovider.UpstreamSAMLIdentityProviderI
+	SessionProviderType psession.ProviderType
+	Transforms          *idtransform.TransformationPipeline
+}
+
+var _ resolvedprovider.FederationDomainResolvedIdentityProvider = (*FederationDomainResolvedSAMLIdentityProvider)(nil)
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) GetDisplayName() string {
+	return p.DisplayName
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) GetProvider() upstreamprovider.UpstreamIdentityProviderI {
+	return p.Provider
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) GetSessionProviderType() psession.ProviderType {
+	return p.SessionProviderType
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) GetIDPDiscoveryType() v1alpha1.IDPType {
+	return v1alpha1.IDPTypeSAML
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) GetIDPDiscoveryFlows() []v1alpha1.IDPFlow {
+	return []v1alpha1.IDPFlow{v1alpha1.IDPFlowBrowserAuthcode}
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) GetTransforms() *idtransform.TransformationPipeline {
+	return p.Transforms
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) CloneIDPSpecificSessionDataFromSession(session *psession.CustomSessionData) any {
+	if session.SAML == nil {
+		return nil
+	}
+	return session.SAML.Clone()
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) ApplyIDPSpecificSessionDataToSession(session *psession.CustomSessionData, idpSpecificSessionData any) {
+	session.SAML = idpSpecificSessionData.(*psession.SAMLSessionData)
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) UpstreamAuthorizeRedirectURL(
+	state *resolvedprovider.UpstreamAuthorizeRequestState,
+	downstreamIssuerURL string,
+) (string, error) {
+	// The state param is sent as the RelayState, which the identity provider will post back to our ACS endpoint
+	// along with the SAMLResponse. SAML does not support PKCE. The nonce is used to create the ID of the AuthnRequest,
+	// and the SAMLResponse must be "InResponseTo" that ID, similar to how OIDC uses the nonce.
+	redirectURL, err := p.Provider.AuthnRequestURL(
+		requestID(state.Nonce),
+		state.EncodedStateParam,
+		downstreamIssuerURL,
+		downstreamIssuerURL+oidc.SAMLACSEndpointPath,
+	)
+	if err != nil {
+		return "", fosite.ErrServerError.WithHint("Error creating SAML authentication request.").
+			WithWrap(err).WithDebug(err.Error())
+	}
+	return redirectURL, nil
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) Login(
+	_ context.Context,
+	_ string,
+	_ string,
+) (*resolvedprovider.Identity, *resolvedprovider.IdentityLoginExtras, error) {
+	return nil, nil, fosite.ErrAccessDenied.WithHint(
+		"Resource owner password credentials grant is not supported for SAML identity providers.")
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) LoginFromCallback(
+	_ context.Context,
+	authCode string, // for SAML, this is the base64-encoded SAMLResponse which was posted to the ACS endpoint
+	_ pkce.Code, // SAML does not support PKCE
+	nonce nonce.Nonce,
+	redirectURI string, // for SAML, this is the ACS URL
+) (*resolvedprovider.Identity, *resolvedprovider.IdentityLoginExtras, error) {
+	downstreamIssuerURL := strings.TrimSuffix(redirectURI, oidc.SAMLACSEndpointPath)
+
+	user, err := p.Provider.ParseResponse(authCode, requestID(nonce), downstreamIssuerURL, redirectURI, p.GetDisplayName())
+	if err != nil {
+		return nil, nil, httperr.Wrap(http.StatusUnprocessableEntity,
+			"failed to validate SAML response",
+			err,
+		)
+	}
+
+	return &resolvedprovider.Identity{
+			UpstreamUsername:  user.Username,
+			UpstreamGroups:    user.Groups,
+			DownstreamSubject: user.DownstreamSubject,
+			IDPSpecificSessionData: &psession.SAMLSessionData{
+				NameID:              user.NameID,
+				SessionNotOnOrAfter: user.SessionNotOnOrAfter,
+			},
+		},
+		&resolvedprovider.IdentityLoginExtras{
+			DownstreamAdditionalClaims: nil, // not using this for SAML
+			Warnings:                   nil, // not using this for SAML
+		},
+		nil // no error
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) UpstreamRefresh(
+	_ context.Context,
+	identity *resolvedprovider.Identity,
+) (*resolvedprovider.RefreshedIdentity, error) {
+	samlSessionData, ok := identity.IDPSpecificSessionData.(*psession.SAMLSessionData)
+	if !ok {
+		// This should not really happen.
+		return nil, p.refreshErr(errors.New("wrong data type found for IDPSpecificSessionData"))
+	}
+	if len(samlSessionData.NameID) == 0 {
+		// This should not really happen.
+		return nil, p.refreshErr(errors.New("session is missing SAML NameID"))
+	}
+
+	// SAML does not offer any way to refresh the user's session with the identity provider without a browser,
+	// so the best we can do is to enforce the session lifetime which was specified by the identity provider, if any.
+	if !samlSessionData.SessionNotOnOrAfter.IsZero() && !time.Now().Before(samlSessionData.SessionNotOnOrAfter) {
+		return nil, p.refreshErr(fmt.Errorf("SAML session expired at %s",
+			samlSessionData.SessionNotOnOrAfter.Format(time.RFC3339)))
+	}
+
+	return &resolvedprovider.RefreshedIdentity{
+		UpstreamUsername:       identity.UpstreamUsername,
+		UpstreamGroups:         nil, // nil means that the user's old groups from their session will be used again
+		IDPSpecificSessionData: nil, // nil means that no update to the SAML-specific portion of the session data is required
+	}, nil
+}
+
+func (p *FederationDomainResolvedSAMLIdentityProvider) refreshErr(err error) *fosite.RFC6749Error {
+	return resolvedprovider.ErrUpstreamRefreshError().
+		WithHint("Upstream refresh failed.").
+		WithTrace(err).
+		WithDebugf("provider name: %q, provider type: %q", p.Provider.GetResourceName(), p.GetSessionProviderType())
+}
+
+// requestID returns the ID of the SAML AuthnRequest for the nonce. SAML IDs must not start with a digit.
+func requestID(n nonce.Nonce) string {
+	return "id-" + n.String()
+}
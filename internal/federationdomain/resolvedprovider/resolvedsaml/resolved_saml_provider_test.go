@@ -0,0 +1,327 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package resolvedsaml
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ory/fosite"
+	"github.com/stretchr/testify/require"
+
+	idpdiscoveryv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idpdiscovery/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/psession"
+	"go.pinniped.dev/internal/testutil/oidctestutil"
+	"go.pinniped.dev/internal/testutil/transformtestutil"
+)
+
+func TestFederationDomainResolvedSAMLIdentityProvider(t *testing.T) {
+	transforms := transformtestutil.NewRejectAllAuthPipeline(t)
+
+	provider := oidctestutil.NewTestUpstreamSAMLIdentityProviderBuilder().
+		WithName("fake-provider-config").
+		WithSSOURL("https://fake-sso-url").
+		Build()
+
+	subject := FederationDomainResolvedSAMLIdentityProvider{
+		DisplayName:         "fake-display-name",
+		Provider:            provider,
+		SessionProviderType: psession.ProviderTypeSAML,
+		Transforms:          transforms,
+	}
+
+	require.Equal(t, "fake-display-name", subject.GetDisplayName())
+	require.Equal(t, provider, subject.GetProvider())
+	require.Equal(t, psession.ProviderTypeSAML, subject.GetSessionProviderType())
+	require.Equal(t, idpdiscoveryv1alpha1.IDPTypeSAML, subject.GetIDPDiscoveryType())
+	require.Equal(t, []idpdiscoveryv1alpha1.IDPFlow{idpdiscoveryv1alpha1.IDPFlowBrowserAuthcode}, subject.GetIDPDiscoveryFlows())
+	require.Equal(t, transforms, subject.GetTransforms())
+
+	originalCustomSession := &psession.CustomSessionData{
+		Username:         "fake-username",
+		UpstreamUsername: "fake-upstream-username",
+		SAML:             &psession.SAMLSessionData{NameID: "fake-name-id"},
+	}
+	clonedCustomSession := subject.CloneIDPSpecificSessionDataFromSession(originalCustomSession)
+	require.Equal(t,
+		&psession.SAMLSessionData{NameID: "fake-name-id"},
+		clonedCustomSession,
+	)
+	require.NotSame(t, originalCustomSession.SAML, clonedCustomSession)
+
+	require.Nil(t, subject.CloneIDPSpecificSessionDataFromSession(&psession.CustomSessionData{}))
+
+	customSessionToBeMutated := &psession.CustomSessionData{
+		Username:         "fake-username2",
+		UpstreamUsername: "fake-upstream-username2",
+	}
+	subject.ApplyIDPSpecificSessionDataToSession(customSessionToBeMutated, &psession.SAMLSessionData{NameID: "OTHER-name-id"})
+	require.Equal(t, &psession.CustomSessionData{
+		Username:         "fake-username2",
+		UpstreamUsername: "fake-upstream-username2",
+		SAML:             &psession.SAMLSessionData{NameID: "OTHER-name-id"},
+	}, customSessionToBeMutated)
+
+	redirectURL, err := subject.UpstreamAuthorizeRedirectURL(
+		&resolvedprovider.UpstreamAuthorizeRequestState{
+			EncodedStateParam: "encodedStateParam12345",
+			PKCE:              "pkce6789",
+			Nonce:             "nonce1289",
+		},
+		"https://localhost/fake/path",
+	)
+	require.NoError(t, err)
+	require.Equal(t,
+		"https://fake-sso-url?"+
+			"RelayState=encodedStateParam12345&"+
+			"SAMLRequest=id-nonce1289",
+		redirectURL,
+	)
+
+	identity, extras, err := subject.Login(context.Background(), "fake-username", "fake-password")
+	require.Nil(t, identity)
+	require.Nil(t, extras)
+	require.EqualError(t, err, "access_denied")
+	require.Equal(t,
+		"Resource owner password credentials grant is not supported for SAML identity providers.",
+		err.(*fosite.RFC6749Error).HintField)
+}
+
+func TestUpstreamAuthorizeRedirectURLError(t *testing.T) {
+	subject := FederationDomainResolvedSAMLIdentityProvider{
+		DisplayName: "fake-display-name",
+		Provider: oidctestutil.NewTestUpstreamSAMLIdentityProviderBuilder().
+			WithAuthnRequestURLError(errors.New("fake authn request error")).
+			Build(),
+		SessionProviderType: psession.ProviderTypeSAML,
+	}
+
+	redirectURL, err := subject.UpstreamAuthorizeRedirectURL(
+		&resolvedprovider.UpstreamAuthorizeRequestState{EncodedStateParam: "encodedStateParam12345", Nonce: "nonce1289"},
+		"https://localhost/fake/path",
+	)
+	require.Empty(t, redirectURL)
+	errAsFositeErr, ok := err.(*fosite.RFC6749Error)
+	require.True(t, ok)
+	require.Equal(t, "server_error", errAsFositeErr.ErrorField)
+	require.Equal(t, "Error creating SAML authentication request.", errAsFositeErr.HintField)
+	require.Equal(t, "fake authn request error", errAsFositeErr.DebugField)
+}
+
+func TestLoginFromCallback(t *testing.T) {
+	sessionNotOnOrAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		provider       *oidctestutil.TestUpstreamSAMLIdentityProvider
+		idpDisplayName string
+		samlResponse   string
+		redirectURI    string
+
+		wantParseResponseArgs *oidctestutil.ParseResponseArgs
+		wantIdentity          *resolvedprovider.Identity
+		wantExtras            *resolvedprovider.IdentityLoginExtras
+		wantErrMsg            string
+		wantErrResponseMsg    string
+		wantErrStatusCode     int
+	}{
+		{
+			name: "happy path",
+			provider: oidctestutil.NewTestUpstreamSAMLIdentityProviderBuilder().
+				WithUser(&upstreamprovider.SAMLUser{
+					Username:            "fake-username",
+					Groups:              []string{"fake-group1", "fake-group2"},
+					NameID:              "fake-name-id",
+					DownstreamSubject:   "https://fake-downstream-subject",
+					SessionNotOnOrAfter: sessionNotOnOrAfter,
+				}).
+				Build(),
+			idpDisplayName: "fake-display-name",
+			samlResponse:   "fake-saml-response",
+			redirectURI:    "https://fake-issuer/path/saml/acs",
+			wantParseResponseArgs: &oidctestutil.ParseResponseArgs{
+				EncodedResponse:     "fake-saml-response",
+				RequestID:           "id-fake-nonce",
+				DownstreamIssuerURL: "https://fake-issuer/path",
+				ACSURL:              "https://fake-issuer/path/saml/acs",
+				IDPDisplayName:      "fake-display-name",
+			},
+			wantIdentity: &resolvedprovider.Identity{
+				UpstreamUsername:  "fake-username",
+				UpstreamGroups:    []string{"fake-group1", "fake-group2"},
+				DownstreamSubject: "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.SAMLSessionData{
+					NameID:              "fake-name-id",
+					SessionNotOnOrAfter: sessionNotOnOrAfter,
+				},
+			},
+			wantExtras: &resolvedprovider.IdentityLoginExtras{},
+		},
+		{
+			name: "error while validating the SAMLResponse",
+			provider: oidctestutil.NewTestUpstreamSAMLIdentityProviderBuilder().
+				WithParseResponseError(errors.New("fake parse error")).
+				Build(),
+			idpDisplayName: "fake-display-name",
+			samlResponse:   "fake-saml-response",
+			redirectURI:    "https://fake-issuer/path/saml/acs",
+			wantParseResponseArgs: &oidctestutil.ParseResponseArgs{
+				EncodedResponse:     "fake-saml-response",
+				RequestID:           "id-fake-nonce",
+				DownstreamIssuerURL: "https://fake-issuer/path",
+				ACSURL:              "https://fake-issuer/path/saml/acs",
+				IDPDisplayName:      "fake-display-name",
+			},
+			wantErrMsg:         "failed to validate SAML response: fake parse error",
+			wantErrResponseMsg: "Unprocessable Entity: failed to validate SAML response",
+			wantErrStatusCode:  http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject := FederationDomainResolvedSAMLIdentityProvider{
+				DisplayName:         test.idpDisplayName,
+				Provider:            test.provider,
+				SessionProviderType: psession.ProviderTypeSAML,
+				Transforms:          transformtestutil.NewRejectAllAuthPipeline(t),
+			}
+
+			identity, loginExtras, err := subject.LoginFromCallback(context.Background(),
+				test.samlResponse,
+				"pkce-will-be-ignored",
+				"fake-nonce",
+				test.redirectURI,
+			)
+
+			require.Equal(t, 1, test.provider.ParseResponseCallCount())
+			require.Equal(t, test.wantParseResponseArgs, test.provider.ParseResponseArgs(0))
+
+			if test.wantErrResponseMsg == "" {
+				require.NoError(t, err)
+			} else {
+				require.Implements(t, (*httperr.Responder)(nil), err)
+				errAsResponder := err.(httperr.Responder)
+				rec := httptest.NewRecorder()
+				errAsResponder.Respond(rec)
+				require.Equal(t, test.wantErrStatusCode, rec.Code)
+				require.Equal(t, test.wantErrResponseMsg+"\n", rec.Body.String())
+
+				require.EqualError(t, errAsResponder, test.wantErrMsg)
+			}
+			require.Equal(t, test.wantExtras, loginExtras)
+			require.Equal(t, test.wantIdentity, identity)
+		})
+	}
+}
+
+func TestUpstreamRefresh(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity *resolvedprovider.Identity
+
+		wantRefreshedIdentity *resolvedprovider.RefreshedIdentity
+		wantWrappedErr        string
+	}{
+		{
+			name: "happy path with a session lifetime in the future",
+			identity: &resolvedprovider.Identity{
+				UpstreamUsername:  "initial-username",
+				UpstreamGroups:    []string{"initial-group1", "initial-group2"},
+				DownstreamSubject: "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.SAMLSessionData{
+					NameID:              "fake-name-id",
+					SessionNotOnOrAfter: time.Now().Add(time.Hour),
+				},
+			},
+			wantRefreshedIdentity: &resolvedprovider.RefreshedIdentity{
+				UpstreamUsername:       "initial-username",
+				UpstreamGroups:         nil,
+				IDPSpecificSessionData: nil,
+			},
+		},
+		{
+			name: "happy path without a session lifetime",
+			identity: &resolvedprovider.Identity{
+				UpstreamUsername:       "initial-username",
+				UpstreamGroups:         []string{"initial-group1", "initial-group2"},
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.SAMLSessionData{NameID: "fake-name-id"},
+			},
+			wantRefreshedIdentity: &resolvedprovider.RefreshedIdentity{
+				UpstreamUsername:       "initial-username",
+				UpstreamGroups:         nil,
+				IDPSpecificSessionData: nil,
+			},
+		},
+		{
+			name: "session lifetime has passed",
+			identity: &resolvedprovider.Identity{
+				UpstreamUsername:  "initial-username",
+				UpstreamGroups:    []string{"initial-group1", "initial-group2"},
+				DownstreamSubject: "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.SAMLSessionData{
+					NameID:              "fake-name-id",
+					SessionNotOnOrAfter: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+				},
+			},
+			wantWrappedErr: "SAML session expired at 2020-01-02T03:04:05Z",
+		},
+		{
+			name: "wrong session data type, which should not really happen",
+			identity: &resolvedprovider.Identity{
+				UpstreamUsername:       "initial-username",
+				UpstreamGroups:         []string{"initial-group1", "initial-group2"},
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.LDAPSessionData{}, // wrong type
+			},
+			wantWrappedErr: "wrong data type found for IDPSpecificSessionData",
+		},
+		{
+			name: "session is missing SAML NameID, which should not really happen",
+			identity: &resolvedprovider.Identity{
+				UpstreamUsername:       "initial-username",
+				UpstreamGroups:         []string{"initial-group1", "initial-group2"},
+				DownstreamSubject:      "https://fake-downstream-subject",
+				IDPSpecificSessionData: &psession.SAMLSessionData{},
+			},
+			wantWrappedErr: "session is missing SAML NameID",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject := FederationDomainResolvedSAMLIdentityProvider{
+				DisplayName:         "fake-display-name",
+				Provider:            oidctestutil.NewTestUpstreamSAMLIdentityProviderBuilder().WithName("fake-provider-name").Build(),
+				SessionProviderType: psession.ProviderTypeSAML,
+				Transforms:          transformtestutil.NewRejectAllAuthPipeline(t),
+			}
+
+			refreshedIdentity, err := subject.UpstreamRefresh(context.Background(), test.identity)
+
+			if test.wantWrappedErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.NotNil(t, err, "expected to get an error but did not get one")
+				errAsFositeErr, ok := err.(*fosite.RFC6749Error)
+				require.True(t, ok)
+				require.EqualError(t, errAsFositeErr.Unwrap(), test.wantWrappedErr)
+				require.Equal(t, "error", errAsFositeErr.ErrorField)
+				require.Equal(t, "Error during upstream refresh.", errAsFositeErr.DescriptionField)
+				require.Equal(t, http.StatusUnauthorized, errAsFositeErr.CodeField)
+				require.Equal(t, `provider name: "fake-provider-name", provider type: "saml"`, errAsFositeErr.DebugField)
+			}
+
+			require.Equal(t, test.wantRefreshedIdentity, refreshedIdentity)
+		})
+	}
+}
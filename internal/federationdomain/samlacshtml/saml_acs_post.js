@@ -0,0 +1,8 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+window.onload = () => {
+    // Post the form back to this same Supervisor endpoint. This request is same-site,
+    // so the browser will include the Supervisor's CSRF cookie.
+    document.forms[0].submit();
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package samlacshtml defines the HTML template used by the Supervisor's SAML assertion consumer service.
+//
+// SAML identity providers return to the Supervisor using a cross-site POST, which does not include the Supervisor's
+// CSRF cookie (because it is a SameSite=Lax cookie). This page re-submits the same form to the same endpoint
+// as a same-site POST, which does include the cookie.
+package samlacshtml
+
+import (
+	_ "embed" // Needed to trigger //go:embed directives below.
+	"html/template"
+	"strings"
+
+	"github.com/tdewolff/minify/v2/minify"
+
+	"go.pinniped.dev/internal/federationdomain/csp"
+)
+
+//nolint:gochecknoglobals // This package uses globals to ensure that all parsing and minifying happens at init.
+var (
+	//go:embed saml_acs_post.js
+	rawJS      string
+	minifiedJS = panicOnError(minify.JS(rawJS))
+
+	//go:embed saml_acs_post.gohtml
+	rawHTMLTemplate string
+
+	// Parse the Go templated HTML and inject functions providing the minified inline JS.
+	parsedHTMLTemplate = template.Must(template.New("saml_acs_post.gohtml").Funcs(template.FuncMap{
+		"minifiedJS": func() template.JS { return template.JS(minifiedJS) }, //nolint:gosec // This is 100% static input, not attacker-controlled.
+	}).Parse(rawHTMLTemplate))
+
+	// Generate the CSP header value once since it's effectively constant.
+	cspValue = strings.Join([]string{
+		`default-src 'none'`,
+		`script-src '` + csp.Hash(minifiedJS) + `'`,
+		`form-action 'self'`,
+		`frame-ancestors 'none'`,
+	}, "; ")
+)
+
+func panicOnError(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// PageData represents the inputs to the template.
+type PageData struct {
+	// ACSURL is the URL of the Supervisor's assertion consumer service, to which the form is posted.
+	ACSURL string
+
+	// SAMLResponse is the SAMLResponse which was posted by the identity provider.
+	SAMLResponse string
+
+	// State is the RelayState which was posted by the identity provider, which is the Supervisor's state param.
+	State string
+}
+
+// ContentSecurityPolicy returns the Content-Security-Policy header value to make the Template() operate correctly.
+//
+// See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Security-Policy.
+func ContentSecurityPolicy() string { return cspValue }
+
+// Template returns the html/template.Template for rendering the page which re-posts the SAMLResponse.
+func Template() *template.Template { return parsedHTMLTemplate }
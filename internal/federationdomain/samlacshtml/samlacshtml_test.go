@@ -0,0 +1,64 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package samlacshtml
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"go.pinniped.dev/internal/here"
+)
+
+var (
+	testExpectedOutput = here.Doc(`
+        <!DOCTYPE html>
+        <html lang="en">
+        <head>
+            <meta charset="UTF-8">
+            <title>Logging in...</title>
+            <script>window.onload=()=>{document.forms[0].submit()}</script>
+        </head>
+        <body>
+        <form method="post" action="https://example.com/some/path/saml/acs">
+            <input type="hidden" name="SAMLResponse" value="PHNhbWxwOlJlc3BvbnNlPi4uLjwvc2FtbHA6UmVzcG9uc2U&#43;"/>
+            <input type="hidden" name="state" value="some-encoded-state&amp;&lt;&gt;"/>
+            <noscript>
+                <p>To finish logging in, please continue.</p>
+                <button type="submit">Continue</button>
+            </noscript>
+        </form>
+        </body>
+        </html>
+	`)
+
+	// It's okay if this changes in the future, but this gives us a chance to eyeball the formatting.
+	testExpectedCSP = `default-src 'none'; ` +
+		`script-src 'sha256-JeMUqBmARWp5J5ylWBPI1uUIgjSom9lq0SW/q8WXk4s='; ` +
+		`form-action 'self'; ` +
+		`frame-ancestors 'none'`
+)
+
+func TestTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Template().Execute(&buf, &PageData{
+		ACSURL:       "https://example.com/some/path/saml/acs",
+		SAMLResponse: "PHNhbWxwOlJlc3BvbnNlPi4uLjwvc2FtbHA6UmVzcG9uc2U+",
+		State:        "some-encoded-state&<>",
+	}))
+
+	// t.Logf("actual value:\n%s", buf.String()) // useful when updating minify library causes new output
+	require.Equal(t, testExpectedOutput, buf.String())
+}
+
+func TestContentSecurityPolicyHashes(t *testing.T) {
+	require.Equal(t, testExpectedCSP, ContentSecurityPolicy())
+}
+
+func TestHelpers(t *testing.T) {
+	require.Equal(t, "test", panicOnError("test", nil))
+	require.PanicsWithError(t, "some error", func() { panicOnError("", fmt.Errorf("some error")) })
+}
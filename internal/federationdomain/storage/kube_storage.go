@@ -20,6 +20,7 @@ import (
 	"go.pinniped.dev/internal/fositestorage/authorizationcode"
 	"go.pinniped.dev/internal/fositestorage/openidconnect"
 	"go.pinniped.dev/internal/fositestorage/pkce"
+	"go.pinniped.dev/internal/fositestorage/pushedauthorization"
 	"go.pinniped.dev/internal/fositestorage/refreshtoken"
 	"go.pinniped.dev/internal/fositestoragei"
 	"go.pinniped.dev/internal/oidcclientsecretstorage"
@@ -32,6 +33,7 @@ type KubeStorage struct {
 	oidcStorage              openid.OpenIDConnectRequestStorage
 	accessTokenStorage       accesstoken.RevocationStorage
 	refreshTokenStorage      refreshtoken.RevocationStorage
+	parStorage               fosite.PARStorage
 }
 
 var _ fositestoragei.AllFositeStorage = &KubeStorage{}
@@ -50,6 +52,7 @@ func NewKubeStorage(
 		oidcStorage:              openidconnect.New(secrets, nowFunc, timeoutsConfiguration.OIDCSessionStorageLifetime),
 		accessTokenStorage:       accesstoken.New(secrets, nowFunc, timeoutsConfiguration.AccessTokenSessionStorageLifetime),
 		refreshTokenStorage:      refreshtoken.New(secrets, nowFunc, timeoutsConfiguration.RefreshTokenSessionStorageLifetime),
+		parStorage:               pushedauthorization.New(secrets, nowFunc, timeoutsConfiguration.PushedAuthorizeRequestSessionStorageLifetime),
 	}
 }
 
@@ -189,6 +192,56 @@ func (k KubeStorage) RevokeRefreshTokenMaybeGracePeriod(ctx context.Context, req
 	return k.refreshTokenStorage.RevokeRefreshTokenMaybeGracePeriod(ctx, requestID, signature)
 }
 
+//
+// Pushed authorization request sessions:
+//
+// These are keyed by the request_uri which was returned by the pushed authorization request endpoint.
+//
+// Fosite will create these in the pushed authorization request endpoint.
+//
+// Fosite will delete these in the authorize endpoint when the request_uri is used. If the client never uses the
+// request_uri, then fosite will never delete these.
+//
+
+func (k KubeStorage) CreatePARSession(ctx context.Context, requestURI string, request fosite.AuthorizeRequester) error {
+	return k.parStorage.CreatePARSession(ctx, requestURI, request)
+}
+
+func (k KubeStorage) GetPARSession(ctx context.Context, requestURI string) (fosite.AuthorizeRequester, error) {
+	return getPARSessionWithCurrentClient(ctx, k.parStorage, k.clientManager, requestURI)
+}
+
+func (k KubeStorage) DeletePARSession(ctx context.Context, requestURI string) error {
+	return k.parStorage.DeletePARSession(ctx, requestURI)
+}
+
+// getPARSessionWithCurrentClient returns the stored pushed authorization request with its client replaced by the
+// current client. The stored client lacks the restrictions of its OIDCClient, which are not saved in storage.
+func getPARSessionWithCurrentClient(
+	ctx context.Context,
+	parStorage fosite.PARStorage,
+	clientManager fosite.ClientManager,
+	requestURI string,
+) (fosite.AuthorizeRequester, error) {
+	request, err := parStorage.GetPARSession(ctx, requestURI)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := clientManager.GetClient(ctx, request.GetClient().GetID())
+	if err != nil {
+		return nil, err
+	}
+
+	authorizeRequest, ok := request.(*fosite.AuthorizeRequest)
+	if !ok {
+		return nil, fosite.ErrServerError.WithDebug("pushed authorization request has an unexpected type")
+	}
+	authorizeRequest.Client = client
+
+	return authorizeRequest, nil
+}
+
 //
 // OAuth client definitions:
 //
@@ -1,10 +1,11 @@
-// Copyright 2020-2023 the Pinniped contributors. All Rights Reserved.
+// Copyright 2020-2024 the Pinniped contributors. All Rights Reserved.
 // SPDX-License-Identifier: Apache-2.0
 
 package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/ory/fosite"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -12,6 +13,8 @@ import (
 	"go.pinniped.dev/generated/latest/client/supervisor/clientset/versioned/typed/config/v1alpha1"
 	"go.pinniped.dev/internal/constable"
 	"go.pinniped.dev/internal/federationdomain/clientregistry"
+	"go.pinniped.dev/internal/federationdomain/timeouts"
+	"go.pinniped.dev/internal/fositestorage/pushedauthorization"
 	"go.pinniped.dev/internal/fositestoragei"
 	"go.pinniped.dev/internal/oidcclientsecretstorage"
 )
@@ -21,6 +24,10 @@ const errNullStorageNotImplemented = constable.Error("NullStorage does not imple
 type NullStorage struct {
 	// The authorization endpoint uses NullStorage to avoid saving any data, but it still needs to perform client lookups.
 	*clientregistry.ClientManager
+
+	// The authorization endpoint must also be able to read and delete pushed authorization requests, which
+	// were saved by the pushed authorization request endpoint.
+	parStorage fosite.PARStorage
 }
 
 var _ fositestoragei.AllFositeStorage = &NullStorage{}
@@ -28,10 +35,12 @@ var _ fositestoragei.AllFositeStorage = &NullStorage{}
 func NewNullStorage(
 	secrets corev1client.SecretInterface,
 	oidcClientsClient v1alpha1.OIDCClientInterface,
+	timeoutsConfiguration timeouts.Configuration,
 	minBcryptCost int,
 ) *NullStorage {
 	return &NullStorage{
 		ClientManager: clientregistry.NewClientManager(oidcClientsClient, oidcclientsecretstorage.New(secrets), minBcryptCost),
+		parStorage:    pushedauthorization.New(secrets, time.Now, timeoutsConfiguration.PushedAuthorizeRequestSessionStorageLifetime),
 	}
 }
 
@@ -106,3 +115,15 @@ func (NullStorage) GetAuthorizeCodeSession(_ context.Context, _ string, _ fosite
 func (NullStorage) InvalidateAuthorizeCodeSession(_ context.Context, _ string) (err error) {
 	return errNullStorageNotImplemented
 }
+
+func (NullStorage) CreatePARSession(_ context.Context, _ string, _ fosite.AuthorizeRequester) error {
+	return errNullStorageNotImplemented
+}
+
+func (n NullStorage) GetPARSession(ctx context.Context, requestURI string) (fosite.AuthorizeRequester, error) {
+	return getPARSessionWithCurrentClient(ctx, n.parStorage, n.ClientManager, requestURI)
+}
+
+func (n NullStorage) DeletePARSession(ctx context.Context, requestURI string) error {
+	return n.parStorage.DeletePARSession(ctx, requestURI)
+}
@@ -62,6 +62,11 @@ type Configuration struct {
 	// in their web browser.
 	RefreshTokenLifespan time.Duration
 
+	// How long a request_uri returned by the pushed authorization request endpoint may be used to start an
+	// authorization request. The client should redirect the end user's browser to the authorization endpoint
+	// immediately after pushing its request, so this can be short.
+	PushedAuthorizeRequestLifespan time.Duration
+
 	// AuthorizationCodeSessionStorageLifetime is the length of time after which an authcode is allowed to be garbage
 	// collected from storage. Authcodes are kept in storage after they are redeemed to allow the system to mark the
 	// authcode as already used, so it can reject any future uses of the same authcode with special case handling which
@@ -99,4 +104,9 @@ type Configuration struct {
 	// when the token does not exist. If this is desirable, then the RefreshTokenSessionStorageLifetime can be made
 	// to be significantly larger than RefreshTokenLifespan, at the cost of slower cleanup.
 	RefreshTokenSessionStorageLifetime StorageLifetime
+
+	// PushedAuthorizeRequestSessionStorageLifetime is the length of time after which a pushed authorization request
+	// is allowed to be garbage collected from storage. The request is explicitly deleted when it is used at the
+	// authorization endpoint. Therefore, this can be just slightly longer than the PushedAuthorizeRequestLifespan.
+	PushedAuthorizeRequestSessionStorageLifetime StorageLifetime
 }
@@ -6,6 +6,7 @@ package upstreamprovider
 import (
 	"context"
 	"net/url"
+	"time"
 
 	"golang.org/x/oauth2"
 	"k8s.io/apimachinery/pkg/types"
@@ -124,14 +125,25 @@ type UpstreamLDAPIdentityProviderI interface {
 	// UserAuthenticator adds an interface method for performing user authentication against the upstream LDAP provider.
 	authenticators.UserAuthenticator
 
-	// PerformRefresh performs a refresh against the upstream LDAP identity provider
-	PerformRefresh(ctx context.Context, storedRefreshAttributes LDAPRefreshAttributes, idpDisplayName string) (groups []string, err error)
+	// PerformRefresh performs a refresh against the upstream LDAP identity provider. It returns the user's groups,
+	// and the user's additional claims, which are nil when the provider does not map any additional claims.
+	PerformRefresh(ctx context.Context, storedRefreshAttributes LDAPRefreshAttributes, idpDisplayName string) (groups []string, additionalClaims map[string]any, err error)
+
+	// LookupUserGroups finds a user by searching for the key in place of a username, using the bind account,
+	// and returns that user's groups. It returns an empty list when no user is found.
+	LookupUserGroups(ctx context.Context, key string) (groups []string, err error)
+
+	// DryRunAuthenticateUser finds a user by their username and returns the same values as AuthenticateUser
+	// would return for the correct password, but without checking their password. It must only be used for
+	// an end user whose password was already checked during the same login.
+	DryRunAuthenticateUser(ctx context.Context, username string) (*authenticators.Response, bool, error)
 }
 
 type GitHubUser struct {
-	Username          string   // could be login name, id, or login:id
-	Groups            []string // could be names or slugs
-	DownstreamSubject string   // the whole downstream subject URI
+	Username          string         // could be login name, id, or login:id
+	Groups            []string       // could be names or slugs, and optionally also org roles
+	DownstreamSubject string         // the whole downstream subject URI
+	AdditionalClaims  map[string]any // nil when there are no additional claim mappings
 }
 
 // GitHubLoginDeniedError can be returned by UpstreamGithubIdentityProviderI GetUser() when a policy
@@ -191,3 +203,137 @@ type UpstreamGithubIdentityProviderI interface {
 	// The IDP display name is passed to aid in building a suitable downstream subject string.
 	GetUser(ctx context.Context, accessToken string, idpDisplayName string) (*GitHubUser, error)
 }
+
+type GitLabUser struct {
+	Username          string   // could be login name, id, or login:id
+	Groups            []string // group full paths
+	DownstreamSubject string   // the whole downstream subject URI
+}
+
+// GitLabLoginDeniedError can be returned by UpstreamGitLabIdentityProviderI GetUser() when a policy
+// configured on GitLabIdentityProvider should prevent this user from completing authentication.
+type GitLabLoginDeniedError struct {
+	message string
+}
+
+func NewGitLabLoginDeniedError(message string) GitLabLoginDeniedError {
+	return GitLabLoginDeniedError{message: message}
+}
+
+func (g GitLabLoginDeniedError) Error() string {
+	return g.message
+}
+
+var _ error = &GitLabLoginDeniedError{}
+
+type UpstreamGitLabIdentityProviderI interface {
+	UpstreamIdentityProviderI
+
+	// GetClientID returns the OAuth client ID registered with the upstream provider to be used in the authorization code flow.
+	GetClientID() string
+
+	// GetScopes returns the scopes to request in authorization flow.
+	GetScopes() []string
+
+	// GetUsernameAttribute returns the attribute from the GitLab API user response to use for the downstream username.
+	// See https://docs.gitlab.com/ee/api/users.html#for-non-administrator-users.
+	// Note that this is a constructed value - do not expect that the result will exactly match one of the JSON fields.
+	GetUsernameAttribute() idpv1alpha1.GitLabUsernameAttribute
+
+	// GetGroupNameAttribute returns the attribute from the GitLab API groups response to use for the downstream group names.
+	// See https://docs.gitlab.com/ee/api/groups.html#list-groups.
+	GetGroupNameAttribute() idpv1alpha1.GitLabGroupNameAttribute
+
+	// GetAllowedGroups returns a list of group full paths configured to allow authentication.
+	// If this list has contents, a user must have membership in at least one of these groups (or any of their
+	// subgroups) to log in, and only these groups and their subgroups should be represented as groups for the
+	// downstream token. If this list is empty, then any user can log in regardless of group membership, and
+	// all observable group memberships should be represented as groups for the downstream token.
+	GetAllowedGroups() []string
+
+	// GetFlattenSubgroups returns true when each subgroup membership should also be represented as membership
+	// in each of the subgroup's ancestor groups.
+	GetFlattenSubgroups() bool
+
+	// GetAuthorizationURL returns the authorization URL for the configured GitLab. This will look like:
+	// https://<spec.gitlabAPI.host>/oauth/authorize
+	// It will not include any query parameters or fragment. Any subdomains or port will come from <spec.gitlabAPI.host>.
+	// It will never include a username or password in the authority section.
+	GetAuthorizationURL() string
+
+	// ExchangeAuthcode performs an upstream GitLab authorization code exchange.
+	// Returns the access token and refresh token.
+	ExchangeAuthcode(ctx context.Context, authcode string, pkceCodeVerifier pkce.Code, redirectURI string) (*oauth2.Token, error)
+
+	// PerformRefresh calls GitLab's token endpoint, performing a refresh token grant.
+	// Returns the new access token and refresh token. GitLab rotates refresh tokens,
+	// so the returned refresh token replaces the one which was used.
+	PerformRefresh(ctx context.Context, refreshToken string) (*oauth2.Token, error)
+
+	// GetUser calls the user and groups APIs of GitLab using the accessToken.
+	// It validates any required group memberships. It returns a User or an error.
+	// The IDP display name is passed to aid in building a suitable downstream subject string.
+	GetUser(ctx context.Context, accessToken string, idpDisplayName string) (*GitLabUser, error)
+}
+
+type OAuth2User struct {
+	Username          string   // from the configured username claim mapping
+	Groups            []string // from the configured groups claim mapping
+	DownstreamSubject string   // the whole downstream subject URI
+}
+
+type UpstreamOAuth2IdentityProviderI interface {
+	UpstreamIdentityProviderI
+
+	// GetClientID returns the OAuth client ID registered with the upstream provider to be used in the authorization code flow.
+	GetClientID() string
+
+	// GetScopes returns the scopes to request in authorization flow.
+	GetScopes() []string
+
+	// GetAuthorizationURL returns the configured authorization endpoint URL of the upstream provider.
+	GetAuthorizationURL() string
+
+	// ExchangeAuthcode performs an upstream OAuth2 authorization code exchange.
+	// Returns the access token and, when the upstream provider issued one, the refresh token.
+	ExchangeAuthcode(ctx context.Context, authcode string, pkceCodeVerifier pkce.Code, redirectURI string) (*oauth2.Token, error)
+
+	// PerformRefresh calls the upstream provider's token endpoint, performing a refresh token grant.
+	// Returns the new access token and, when the upstream provider rotates refresh tokens, the new refresh token.
+	PerformRefresh(ctx context.Context, refreshToken string) (*oauth2.Token, error)
+
+	// GetUser calls the configured user info APIs of the upstream provider using the accessToken,
+	// and applies the configured claim mappings to the responses. It returns a User or an error.
+	// The IDP display name is passed to aid in building a suitable downstream subject string.
+	GetUser(ctx context.Context, accessToken string, idpDisplayName string) (*OAuth2User, error)
+}
+
+type SAMLUser struct {
+	Username            string    // from the configured username attribute, or else the NameID
+	Groups              []string  // from the configured groups attribute
+	NameID              string    // the NameID of the subject of the assertion
+	DownstreamSubject   string    // the whole downstream subject URI
+	SessionNotOnOrAfter time.Time // zero when the upstream provider did not limit the session
+}
+
+type UpstreamSAMLIdentityProviderI interface {
+	UpstreamIdentityProviderI
+
+	// GetIDPEntityID returns the entity ID of the upstream SAML identity provider, as found in its metadata.
+	GetIDPEntityID() string
+
+	// GetServiceProviderEntityID returns the entity ID which should be used by the Supervisor to identify itself
+	// to the upstream SAML identity provider. When this is not configured on the SAMLIdentityProvider, the
+	// downstream issuer URL will be returned.
+	GetServiceProviderEntityID(downstreamIssuerURL string) string
+
+	// AuthnRequestURL returns a URL which sends a signed AuthnRequest to the single sign-on service of the upstream
+	// SAML identity provider using the HTTP-Redirect binding. The requestID will become the ID of the AuthnRequest,
+	// and the relayState will be returned to the Supervisor's assertion consumer service (ACS) by the identity provider.
+	AuthnRequestURL(requestID string, relayState string, downstreamIssuerURL string, acsURL string) (string, error)
+
+	// ParseResponse decodes and validates a base64-encoded SAMLResponse which was posted to the Supervisor's
+	// ACS URL. The response must be in response to the AuthnRequest with the given requestID.
+	// The IDP display name is passed to aid in building a suitable downstream subject string.
+	ParseResponse(encodedResponse string, requestID string, downstreamIssuerURL string, acsURL string, idpDisplayName string) (*SAMLUser, error)
+}
@@ -36,7 +36,11 @@ const (
 	// Version 6 is when we upgraded fosite in Dec 2023.
 	// Version 7 is when OIDCClients were given configurable ID token lifetimes.
 	// Version 8 is when GitHubIdentityProvider was added.
-	accessTokenStorageVersion = "8"
+	// Version 9 is when SAMLIdentityProvider was added.
+	// Version 10 is when GitLabIdentityProvider was added.
+	// Version 11 is when OAuth2IdentityProvider was added.
+	// Version 12 is when DPoP-bound tokens were added.
+	accessTokenStorageVersion = "12"
 )
 
 type RevocationStorage interface {
@@ -37,7 +37,11 @@ const (
 	// Version 6 is when we upgraded fosite in Dec 2023.
 	// Version 7 is when OIDCClients were given configurable ID token lifetimes.
 	// Version 8 is when GitHubIdentityProvider was added.
-	authorizeCodeStorageVersion = "8"
+	// Version 9 is when SAMLIdentityProvider was added.
+	// Version 10 is when GitLabIdentityProvider was added.
+	// Version 11 is when OAuth2IdentityProvider was added.
+	// Version 12 is when DPoP-bound tokens were added.
+	authorizeCodeStorageVersion = "12"
 )
 
 var _ fositeoauth2.AuthorizeCodeStorage = &authorizeCodeStorage{}
@@ -364,39 +368,49 @@ const ExpectedAuthorizeCodeSessionJSONFromFuzzing = `{
 					"觛ǂ焺nŐǛ3}Ü#",
 					"(ý綃ʃʚƟ覣k眐4ĈtC嵽痊w©"
 				],
+				"dpopJWKThumbprint": "紽ǒ|鰽ŋ猊Ia瓕巈環_ɑ彨ƍ蛊ʚ£",
 				"oidc": {
-					"upstreamRefreshToken": "榨Q|ôɵt毇",
-					"upstreamAccessToken": "瓕巈",
-					"upstreamSubject": "鉢緋uƴŤȱʀļÂ?",
-					"upstreamIssuer": "27就伒犘c钡ɏȫ"
+					"upstreamRefreshToken": "設虝27就伒犘c钡ɏȫ齁š%OpK",
+					"upstreamAccessToken": "÷驣7Ʀ澉1æɽ誮",
+					"upstreamSubject": "ʫ繕ȫ",
+					"upstreamIssuer": "ŚB碠k9"
 				},
 				"ldap": {
-					"userDN": "š%OpKȱ藚ɏ¬Ê蒭堜",
+					"userDN": "ʘ赱",
 					"extraRefreshAttributes": {
-						"1飞": "笿0D餹",
-						"誮rʨ鷞aŚB碠k9帴ʘ赱ŕ瑹xȢ~": ")藵睋邔\u0026Ű惫蜀Ģ¡圔鎥墀"
+						"笿0D餹": "0OƉǢIȽ齤士bEǎ儯惝IozŁ",
+						"逳鞪?3)藵睋邔\u0026Ű惫蜀Ģ¡圔鎥墀": "1飞"
 					}
 				},
 				"activedirectory": {
-					"userDN": "êĝ",
+					"userDN": "r",
 					"extraRefreshAttributes": {
-						"IȽ齤士bEǎ": "跞@)¿,ɭS隑ip偶宾儮猷V麹",
-						"ȝƋ鬯犦獢9c5¤.岵": "浛a齙\\蹼偦歛"
+						"p偶宾儮猷V麹Œ颛Ė應": "犦獢9"
 					}
 				},
 				"github": {
-					"upstreamAccessToken": " 皦pSǬŝ社Vƅȭǝ*擦28ǅ"
+					"upstreamAccessToken": "5¤.岵骘胲ƤkǦ闧鸖I¶媁y衑拁Ȃ縅"
+				},
+				"saml": {
+					"nameID": "ķ?吭匞饫Ƽĝ\"zvưã置",
+					"sessionNotOnOrAfter": "2026-12-16T15:32:25.838514199Z"
+				},
+				"gitlab": {
+					"upstreamAccessToken": "抰蛖a³2ʫ承",
+					"upstreamRefreshToken": "ɽ蔒PR}Ųʓl{鼐"
+				},
+				"oauth2": {
+					"upstreamAccessToken": "Ã轘屔挝ʌ鼂",
+					"upstreamRefreshToken": "崓ļ憽-蹐È"
 				}
 			}
 		},
 		"requestedAudience": [
-			"甍 ć\u003cʘ筫",
-			"蛖a³2ʫ承dʬ)ġ,TÀqy_"
+			"¸]fś酷ɂ"
 		],
 		"grantedAudience": [
-			"$+溪ŸȢŒų崓ļ憽",
-			"姧骦:駝重EȫʆɵʮGɃ"
+			"沴Ȃ僒鬎"
 		]
 	},
-	"version": "8"
+	"version": "12"
 }`
@@ -0,0 +1,124 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package pushedauthorization
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ory/fosite"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/crud"
+	"go.pinniped.dev/internal/federationdomain/clientregistry"
+	"go.pinniped.dev/internal/federationdomain/timeouts"
+	"go.pinniped.dev/internal/fositestorage"
+	"go.pinniped.dev/internal/psession"
+)
+
+const (
+	TypeLabelValue = "pushed-authorization-request"
+
+	ErrInvalidPushedAuthorizationRequestVersion = constable.Error("pushed authorization request data has wrong version")
+	ErrInvalidPushedAuthorizationRequestType    = constable.Error("requester must be of type fosite.AuthorizeRequest")
+	ErrExpiredPushedAuthorizationRequest        = constable.Error("pushed authorization request has expired")
+
+	// Version 1 was the initial release of storage.
+	pushedAuthorizationRequestStorageVersion = "1"
+)
+
+var _ fosite.PARStorage = &pushedAuthorizationRequestStorage{}
+
+type pushedAuthorizationRequestStorage struct {
+	storage  crud.Storage
+	clock    func() time.Time
+	lifetime timeouts.StorageLifetime
+}
+
+type session struct {
+	Request *fosite.AuthorizeRequest `json:"request"`
+	Version string                   `json:"version"`
+}
+
+func New(secrets corev1client.SecretInterface, clock func() time.Time, sessionStorageLifetime timeouts.StorageLifetime) fosite.PARStorage {
+	return &pushedAuthorizationRequestStorage{
+		storage:  crud.New(TypeLabelValue, secrets, clock),
+		clock:    clock,
+		lifetime: sessionStorageLifetime,
+	}
+}
+
+func (a *pushedAuthorizationRequestStorage) CreatePARSession(ctx context.Context, requestURI string, requester fosite.AuthorizeRequester) error {
+	request, ok := requester.(*fosite.AuthorizeRequest)
+	if !ok {
+		return ErrInvalidPushedAuthorizationRequestType
+	}
+	// Also validate the client and session types of the embedded request.
+	if _, err := fositestorage.ValidateAndExtractAuthorizeRequest(&request.Request); err != nil {
+		return err
+	}
+
+	_, err := a.storage.Create(ctx,
+		requestURI,
+		&session{Request: request, Version: pushedAuthorizationRequestStorageVersion},
+		nil,
+		nil,
+		a.lifetime(requester),
+	)
+	return err
+}
+
+func (a *pushedAuthorizationRequestStorage) GetPARSession(ctx context.Context, requestURI string) (fosite.AuthorizeRequester, error) {
+	session, err := a.getSession(ctx, requestURI)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fosite does not check the expiration of pushed authorization requests, so check it here.
+	// Expired requests may remain in storage until they are garbage collected.
+	expiresAt := session.Request.GetSession().GetExpiresAt(fosite.PushedAuthorizeRequestContext)
+	if !expiresAt.IsZero() && a.clock().After(expiresAt) {
+		return nil, fosite.ErrNotFound.WithWrap(ErrExpiredPushedAuthorizationRequest).WithDebug(ErrExpiredPushedAuthorizationRequest.Error())
+	}
+
+	return session.Request, nil
+}
+
+func (a *pushedAuthorizationRequestStorage) DeletePARSession(ctx context.Context, requestURI string) error {
+	return a.storage.Delete(ctx, requestURI)
+}
+
+func (a *pushedAuthorizationRequestStorage) getSession(ctx context.Context, requestURI string) (*session, error) {
+	session := newValidEmptyPushedAuthorizationRequestSession()
+	_, err := a.storage.Get(ctx, requestURI, session)
+
+	if apierrors.IsNotFound(err) {
+		return nil, fosite.ErrNotFound.WithWrap(err).WithDebug(err.Error())
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pushed authorization request session: %w", err)
+	}
+
+	if version := session.Version; version != pushedAuthorizationRequestStorageVersion {
+		return nil, fmt.Errorf("%w: pushed authorization request session has version %s instead of %s",
+			ErrInvalidPushedAuthorizationRequestVersion, version, pushedAuthorizationRequestStorageVersion)
+	}
+
+	return session, nil
+}
+
+func newValidEmptyPushedAuthorizationRequestSession() *session {
+	return &session{
+		Request: &fosite.AuthorizeRequest{
+			Request: fosite.Request{
+				Client:  &clientregistry.Client{},
+				Session: &psession.PinnipedSession{},
+			},
+		},
+	}
+}
@@ -18,4 +18,5 @@ type AllFositeStorage interface {
 	fositeoauth2.TokenRevocationStorage
 	openid.OpenIDConnectRequestStorage
 	pkce.PKCERequestStorage
+	fosite.PARStorage
 }
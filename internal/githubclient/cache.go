@@ -0,0 +1,394 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package githubclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/clock"
+
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	// responseFreshFor is how long a cached response is used without asking the GitHub API whether it has changed.
+	// This is short so that changes to a user's org and team memberships are noticed during their next refresh.
+	responseFreshFor = time.Minute
+
+	// responseMaxAge is how long a cached response is kept so that it can be revalidated using its ETag,
+	// which does not count against the rate limit, or used when the rate limit has been exceeded.
+	responseMaxAge = 10 * time.Minute
+
+	// maxCachedResponses limits the memory used by each cache.
+	maxCachedResponses = 5000
+
+	// maxRetryAfterWait is the longest that a request will wait to be retried when the GitHub API asks it to retry
+	// later. Anything longer would likely cause the user's login or refresh to time out, so it is not retried.
+	maxRetryAfterWait = 5 * time.Second
+
+	rateLimitResourceCore = "core"
+)
+
+// RateLimit is the rate limit of an access token, as reported by the GitHub API.
+type RateLimit struct {
+	Limit      int
+	Remaining  int
+	Reset      time.Time
+	ObservedAt time.Time
+}
+
+type cacheKey struct {
+	tokenHash string
+	url       string
+}
+
+type cachedResponse struct {
+	header   http.Header
+	body     []byte
+	storedAt time.Time
+}
+
+// ResponseCache caches the successful responses of the GitHub REST API for each access token, and remembers the
+// rate limits which the GitHub API reported for each access token. Cached responses are revalidated using
+// conditional requests, which do not count against the rate limit, and are served when the rate limit has been
+// exceeded. It is safe for concurrent use.
+type ResponseCache struct {
+	mu         sync.Mutex
+	responses  map[cacheKey]*cachedResponse
+	rateLimits map[string]*RateLimit
+	lastPruned time.Time
+	clock      clock.PassiveClock
+}
+
+func NewResponseCache(clock clock.PassiveClock) *ResponseCache {
+	return &ResponseCache{
+		responses:  map[cacheKey]*cachedResponse{},
+		rateLimits: map[string]*RateLimit{},
+		clock:      clock,
+	}
+}
+
+// HTTPClient returns a copy of the httpClient which uses this cache. The access token is read from the
+// Authorization header of each request, so the returned client may be shared by many access tokens.
+func (c *ResponseCache) HTTPClient(httpClient *http.Client) *http.Client {
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	wrapped := *httpClient
+	wrapped.Transport = &cachingTransport{base: base, cache: c}
+	return &wrapped
+}
+
+// LowestRateLimit returns the rate limit with the fewest remaining requests among the access tokens whose quotas
+// have not been reset since they were observed, or nil when there are none.
+func (c *ResponseCache) LowestRateLimit() *RateLimit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	var lowest *RateLimit
+	for tokenHash, rateLimit := range c.rateLimits {
+		if !now.Before(rateLimit.Reset) {
+			delete(c.rateLimits, tokenHash)
+			continue
+		}
+		if lowest == nil || rateLimit.Remaining < lowest.Remaining ||
+			(rateLimit.Remaining == lowest.Remaining && rateLimit.ObservedAt.After(lowest.ObservedAt)) {
+			lowest = rateLimit
+		}
+	}
+	if lowest == nil {
+		return nil
+	}
+	result := *lowest
+	return &result
+}
+
+func (c *ResponseCache) get(key cacheKey) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, found := c.responses[key]
+	if !found {
+		return nil
+	}
+	if c.clock.Now().Sub(cached.storedAt) >= responseMaxAge {
+		delete(c.responses, key)
+		return nil
+	}
+	return cached
+}
+
+func (c *ResponseCache) put(key cacheKey, cached *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if now.Sub(c.lastPruned) >= responseFreshFor {
+		for k, v := range c.responses {
+			if now.Sub(v.storedAt) >= responseMaxAge {
+				delete(c.responses, k)
+			}
+		}
+		c.lastPruned = now
+	}
+
+	if _, found := c.responses[key]; !found && len(c.responses) >= maxCachedResponses {
+		return
+	}
+	c.responses[key] = cached
+}
+
+// observeRateLimit remembers the rate limit from the headers of a response. See
+// https://docs.github.com/en/rest/using-the-rest-api/rate-limits-for-the-rest-api#checking-the-status-of-your-rate-limit
+func (c *ResponseCache) observeRateLimit(tokenHash string, header http.Header) {
+	if resource := header.Get("X-RateLimit-Resource"); resource != "" && resource != rateLimitResourceCore {
+		// Other resources, such as the GraphQL API, have their own separate quotas.
+		return
+	}
+	limit, limitErr := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	remaining, remainingErr := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	reset, resetErr := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if limitErr != nil || remainingErr != nil || resetErr != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rateLimits[tokenHash] = &RateLimit{
+		Limit:      limit,
+		Remaining:  remaining,
+		Reset:      time.Unix(reset, 0),
+		ObservedAt: c.clock.Now(),
+	}
+}
+
+func (c *ResponseCache) rateLimitExceeded(tokenHash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rateLimit, found := c.rateLimits[tokenHash]
+	return found && rateLimit.Remaining == 0 && c.clock.Now().Before(rateLimit.Reset)
+}
+
+type cachingTransport struct {
+	base  http.RoundTripper
+	cache *ResponseCache
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authorization := req.Header.Get("Authorization")
+	tokenHash := hashAuthorization(authorization)
+
+	if req.Method != http.MethodGet || authorization == "" {
+		resp, err := t.roundTripWithRetry(req)
+		if err != nil {
+			return nil, err
+		}
+		if authorization != "" {
+			t.cache.observeRateLimit(tokenHash, resp.Header)
+		}
+		return resp, nil
+	}
+
+	key := cacheKey{tokenHash: tokenHash, url: req.URL.String()}
+	cached := t.cache.get(key)
+
+	if cached != nil && t.cache.clock.Now().Sub(cached.storedAt) < responseFreshFor {
+		plog.Trace("using cached GitHub API response", "url", key.url)
+		return cached.response(req), nil
+	}
+
+	if cached != nil && t.cache.rateLimitExceeded(tokenHash) {
+		plog.Info("using stale cached GitHub API response because the rate limit of the access token was exceeded",
+			"url", key.url, "age", t.cache.clock.Now().Sub(cached.storedAt))
+		return cached.response(req), nil
+	}
+
+	outgoing := req
+	if etag := cached.etag(); etag != "" {
+		outgoing = req.Clone(req.Context())
+		outgoing.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := t.roundTripWithRetry(outgoing)
+	if err != nil {
+		return nil, err
+	}
+	t.cache.observeRateLimit(tokenHash, resp.Header)
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		discardBody(resp)
+		revalidated := &cachedResponse{header: cached.header, body: cached.body, storedAt: t.cache.clock.Now()}
+		t.cache.put(key, revalidated)
+		plog.Trace("revalidated cached GitHub API response", "url", key.url)
+		return revalidated.response(req), nil
+
+	case resp.StatusCode == http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.cache.put(key, &cachedResponse{header: resp.Header.Clone(), body: body, storedAt: t.cache.clock.Now()})
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+
+	case isRateLimited(resp) && cached != nil:
+		discardBody(resp)
+		plog.Info("using stale cached GitHub API response because the rate limit of the access token was exceeded",
+			"url", key.url, "age", t.cache.clock.Now().Sub(cached.storedAt))
+		return cached.response(req), nil
+
+	default:
+		return resp, nil
+	}
+}
+
+// roundTripWithRetry retries the request once when the GitHub API asks for it to be retried after a short wait.
+// See https://docs.github.com/en/rest/using-the-rest-api/best-practices-for-using-the-rest-api#handle-rate-limit-errors-appropriately
+func (t *cachingTransport) roundTripWithRetry(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	wait, shouldRetry := retryAfter(resp, t.cache.clock.Now())
+	if !shouldRetry || wait > maxRetryAfterWait || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+	discardBody(resp)
+
+	plog.Debug("retrying GitHub API request after the rate limit was exceeded", "url", req.URL.String(), "wait", wait)
+	if err := sleepForRequest(req, wait); err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil { // untested
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return t.base.RoundTrip(retry)
+}
+
+func (c *cachedResponse) etag() string {
+	if c == nil {
+		return ""
+	}
+	return c.header.Get("ETag")
+}
+
+func (c *cachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        c.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(c.body)),
+		ContentLength: int64(len(c.body)),
+		Request:       req,
+	}
+}
+
+// isRateLimited returns true when the response says that the primary or a secondary rate limit was exceeded.
+func isRateLimited(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		return resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"
+	default:
+		return false
+	}
+}
+
+// retryAfter returns how long to wait before retrying a request whose response said that a rate limit was exceeded.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if !isRateLimited(resp) {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		return max(time.Unix(reset, 0).Sub(now), 0), true
+	}
+	return 0, false
+}
+
+func hashAuthorization(authorization string) string {
+	hash := sha256.Sum256([]byte(authorization))
+	return hex.EncodeToString(hash[:])
+}
+
+func discardBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+func sleepForRequest(req *http.Request, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ResponseCaches keeps a ResponseCache for each GitHubIdentityProvider, so the cached responses can still be used
+// after the provider is replaced during a future Sync of its controller. It is safe for concurrent use.
+type ResponseCaches struct {
+	mu          sync.Mutex
+	cachesByUID map[types.UID]*ResponseCache
+	clock       clock.PassiveClock
+}
+
+func NewResponseCaches(clock clock.PassiveClock) *ResponseCaches {
+	return &ResponseCaches{cachesByUID: map[types.UID]*ResponseCache{}, clock: clock}
+}
+
+// CacheFor returns the cache for the GitHubIdentityProvider, creating it when needed.
+func (c *ResponseCaches) CacheFor(uid types.UID) *ResponseCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	responseCache, found := c.cachesByUID[uid]
+	if !found {
+		responseCache = NewResponseCache(c.clock)
+		c.cachesByUID[uid] = responseCache
+	}
+	return responseCache
+}
+
+// ForgetAllExcept forgets the caches of the GitHubIdentityProviders which no longer exist.
+func (c *ResponseCaches) ForgetAllExcept(uids sets.Set[types.UID]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for uid := range c.cachesByUID {
+		if !uids.Has(uid) {
+			delete(c.cachesByUID, uid)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package githubclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+)
+
+type fakeGitHubResponse struct {
+	status int
+	header map[string]string
+	body   string
+}
+
+// fakeGitHubServer responds to each request with the next of its responses, and records the requests.
+type fakeGitHubServer struct {
+	mu        sync.Mutex
+	responses []fakeGitHubResponse
+	requests  []*http.Request
+	bodies    []string
+}
+
+func (s *fakeGitHubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, string(body))
+	if len(s.responses) == 0 {
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+		return
+	}
+	resp := s.responses[0]
+	s.responses = s.responses[1:]
+	for k, v := range resp.header {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(resp.status)
+	_, _ = w.Write([]byte(resp.body))
+}
+
+func TestResponseCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	inOneHour := strconv.FormatInt(now.Add(time.Hour).Unix(), 10)
+
+	okWithETag := func(etag, body, remaining string) fakeGitHubResponse {
+		return fakeGitHubResponse{
+			status: http.StatusOK,
+			header: map[string]string{
+				"ETag":                  etag,
+				"X-RateLimit-Limit":     "5000",
+				"X-RateLimit-Remaining": remaining,
+				"X-RateLimit-Reset":     inOneHour,
+				"X-RateLimit-Resource":  "core",
+			},
+			body: body,
+		}
+	}
+	notModified := func(remaining string) fakeGitHubResponse {
+		return fakeGitHubResponse{
+			status: http.StatusNotModified,
+			header: map[string]string{
+				"X-RateLimit-Limit":     "5000",
+				"X-RateLimit-Remaining": remaining,
+				"X-RateLimit-Reset":     inOneHour,
+			},
+		}
+	}
+	rateLimitExceeded := fakeGitHubResponse{
+		status: http.StatusForbidden,
+		header: map[string]string{
+			"X-RateLimit-Limit":     "5000",
+			"X-RateLimit-Remaining": "0",
+			"X-RateLimit-Reset":     inOneHour,
+		},
+		body: `{"message":"API rate limit exceeded"}`,
+	}
+	retryAfter := func(seconds string) fakeGitHubResponse {
+		return fakeGitHubResponse{
+			status: http.StatusForbidden,
+			header: map[string]string{"Retry-After": seconds},
+			body:   `{"message":"You have exceeded a secondary rate limit"}`,
+		}
+	}
+
+	type request struct {
+		method     string
+		path       string
+		token      string
+		body       string
+		advance    time.Duration
+		wantStatus int
+		wantBody   string
+	}
+
+	tests := []struct {
+		name              string
+		responses         []fakeGitHubResponse
+		requests          []request
+		wantServerHits    int
+		wantIfNoneMatch   []string
+		wantLowestRemains *int
+	}{
+		{
+			name:      "fresh responses are served from the cache",
+			responses: []fakeGitHubResponse{okWithETag(`"etag-1"`, "body-1", "4999")},
+			requests: []request{
+				{path: "/user", token: "token-1", wantStatus: http.StatusOK, wantBody: "body-1"},
+				{path: "/user", token: "token-1", advance: 30 * time.Second, wantStatus: http.StatusOK, wantBody: "body-1"},
+			},
+			wantServerHits:    1,
+			wantIfNoneMatch:   []string{""},
+			wantLowestRemains: ptr.To(4999),
+		},
+		{
+			name: "responses are not shared between access tokens or urls",
+			responses: []fakeGitHubResponse{
+				okWithETag(`"etag-1"`, "body-1", "4999"),
+				okWithETag(`"etag-2"`, "body-2", "10"),
+				okWithETag(`"etag-3"`, "body-3", "4998"),
+			},
+			requests: []request{
+				{path: "/user", token: "token-1", wantStatus: http.StatusOK, wantBody: "body-1"},
+				{path: "/user", token: "token-2", wantStatus: http.StatusOK, wantBody: "body-2"},
+				{path: "/user/orgs?page=2", token: "token-1", wantStatus: http.StatusOK, wantBody: "body-3"},
+			},
+			wantServerHits:    3,
+			wantIfNoneMatch:   []string{"", "", ""},
+			wantLowestRemains: ptr.To(10),
+		},
+		{
+			name: "stale responses are revalidated using their ETags",
+			responses: []fakeGitHubResponse{
+				okWithETag(`"etag-1"`, "body-1", "4999"),
+				notModified("4999"),
+				okWithETag(`"etag-2"`, "body-2", "4998"),
+			},
+			requests: []request{
+				{path: "/user", token: "token-1", wantStatus: http.StatusOK, wantBody: "body-1"},
+				{path: "/user", token: "token-1", advance: 2 * time.Minute, wantStatus: http.StatusOK, wantBody: "body-1"},
+				{path: "/user", token: "token-1", advance: 30 * time.Second, wantStatus: http.StatusOK, wantBody: "body-1"},
+				{path: "/user", token: "token-1", advance: 2 * time.Minute, wantStatus: http.StatusOK, wantBody: "body-2"},
+			},
+			wantServerHits:    3,
+			wantIfNoneMatch:   []string{"", `"etag-1"`, `"etag-1"`},
+			wantLowestRemains: ptr.To(4998),
+		},
+		{
+			name: "responses which are too old are not revalidated",
+			responses: []fakeGitHubResponse{
+				okWithETag(`"etag-1"`, "body-1", "4999"),
+				okWithETag(`"etag-2"`, "body-2", "4998"),
+			},
+			requests: []request{
+				{path: "/user", token: "token-1", wantStatus: http.StatusOK, wantBody: "body-1"},
+				{path: "/user", token: "token-1", advance: 15 * time.Minute, wantStatus: http.StatusOK, wantBody: "body-2"},
+			},
+			wantServerHits:    2,
+			wantIfNoneMatch:   []string{"", ""},
+			wantLowestRemains: ptr.To(4998),
+		},
+		{
+			name: "stale responses are served when the rate limit is exceeded",
+			responses: []fakeGitHubResponse{
+				okWithETag(`"etag-1"`, "body-1", "1"),
+				rateLimitExceeded,
+			},
+			requests: []request{
+				{path: "/user", token: "token-1", wantStatus: http.StatusOK, wantBody: "body-1"},
+				{path: "/user", token: "token-1", advance: 2 * time.Minute, wantStatus: http.StatusOK, wantBody: "body-1"},
+				{path: "/user", token: "token-1", advance: 2 * time.Minute, wantStatus: http.StatusOK, wantBody: "body-1"},
+			},
+			wantServerHits:    2,
+			wantIfNoneMatch:   []string{"", `"etag-1"`},
+			wantLowestRemains: ptr.To(0),
+		},
+		{
+			name:      "rate limit errors are returned when there is no cached response",
+			responses: []fakeGitHubResponse{rateLimitExceeded},
+			requests: []request{
+				{path: "/user", token: "token-1", wantStatus: http.StatusForbidden, wantBody: `{"message":"API rate limit exceeded"}`},
+			},
+			wantServerHits:    1,
+			wantIfNoneMatch:   []string{""},
+			wantLowestRemains: ptr.To(0),
+		},
+		{
+			name: "requests are retried once when asked to retry after a short wait",
+			responses: []fakeGitHubResponse{
+				retryAfter("0"),
+				okWithETag(`"etag-1"`, "body-1", "4999"),
+			},
+			requests: []request{
+				{path: "/user", token: "token-1", wantStatus: http.StatusOK, wantBody: "body-1"},
+			},
+			wantServerHits:    2,
+			wantIfNoneMatch:   []string{"", ""},
+			wantLowestRemains: ptr.To(4999),
+		},
+		{
+			name: "requests are not retried twice",
+			responses: []fakeGitHubResponse{
+				retryAfter("0"),
+				retryAfter("0"),
+			},
+			requests: []request{
+				{path: "/user", token: "token-1", wantStatus: http.StatusForbidden, wantBody: `{"message":"You have exceeded a secondary rate limit"}`},
+			},
+			wantServerHits:  2,
+			wantIfNoneMatch: []string{"", ""},
+		},
+		{
+			name:      "requests are not retried when asked to retry after a long wait",
+			responses: []fakeGitHubResponse{retryAfter("60")},
+			requests: []request{
+				{path: "/user", token: "token-1", wantStatus: http.StatusForbidden, wantBody: `{"message":"You have exceeded a secondary rate limit"}`},
+			},
+			wantServerHits:  1,
+			wantIfNoneMatch: []string{""},
+		},
+		{
+			name: "requests with bodies are retried with the same body, but are never cached",
+			responses: []fakeGitHubResponse{
+				retryAfter("0"),
+				okWithETag(`"etag-1"`, "body-1", "4999"),
+				okWithETag(`"etag-1"`, "body-1", "4998"),
+			},
+			requests: []request{
+				{method: http.MethodPost, path: "/graphql", token: "token-1", body: "query-1", wantStatus: http.StatusOK, wantBody: "body-1"},
+				{method: http.MethodPost, path: "/graphql", token: "token-1", body: "query-1", wantStatus: http.StatusOK, wantBody: "body-1"},
+			},
+			wantServerHits:    3,
+			wantIfNoneMatch:   []string{"", "", ""},
+			wantLowestRemains: ptr.To(4998),
+		},
+		{
+			name: "rate limits of other resources are ignored",
+			responses: []fakeGitHubResponse{{
+				status: http.StatusOK,
+				header: map[string]string{
+					"X-RateLimit-Limit":     "5000",
+					"X-RateLimit-Remaining": "3",
+					"X-RateLimit-Reset":     inOneHour,
+					"X-RateLimit-Resource":  "graphql",
+				},
+				body: "body-1",
+			}},
+			requests: []request{
+				{method: http.MethodPost, path: "/graphql", token: "token-1", body: "query-1", wantStatus: http.StatusOK, wantBody: "body-1"},
+			},
+			wantServerHits:  1,
+			wantIfNoneMatch: []string{""},
+		},
+		{
+			name: "rate limits which have been reset are forgotten",
+			responses: []fakeGitHubResponse{
+				okWithETag(`"etag-1"`, "body-1", "4999"),
+				{status: http.StatusOK, body: "body-2"},
+			},
+			requests: []request{
+				{path: "/user", token: "token-1", wantStatus: http.StatusOK, wantBody: "body-1"},
+				{path: "/user", token: "token-1", advance: 61 * time.Minute, wantStatus: http.StatusOK, wantBody: "body-2"},
+			},
+			wantServerHits:  2,
+			wantIfNoneMatch: []string{"", ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fakeServer := &fakeGitHubServer{responses: tt.responses}
+			server := httptest.NewServer(fakeServer)
+			t.Cleanup(server.Close)
+
+			fakeClock := clocktesting.NewFakeClock(now)
+			subject := NewResponseCache(fakeClock)
+			httpClient := subject.HTTPClient(server.Client())
+
+			for _, r := range tt.requests {
+				fakeClock.Step(r.advance)
+
+				method := r.method
+				if method == "" {
+					method = http.MethodGet
+				}
+				var body io.Reader
+				if r.body != "" {
+					body = strings.NewReader(r.body)
+				}
+				req, err := http.NewRequestWithContext(context.Background(), method, server.URL+r.path, body)
+				require.NoError(t, err)
+				req.Header.Set("Authorization", "Bearer "+r.token)
+
+				resp, err := httpClient.Do(req)
+				require.NoError(t, err)
+				gotBody, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+
+				require.Equal(t, r.wantStatus, resp.StatusCode)
+				require.Equal(t, r.wantBody, string(gotBody))
+			}
+
+			require.Len(t, fakeServer.requests, tt.wantServerHits)
+			gotIfNoneMatch := make([]string, 0, len(fakeServer.requests))
+			for i, req := range fakeServer.requests {
+				gotIfNoneMatch = append(gotIfNoneMatch, req.Header.Get("If-None-Match"))
+				if req.Method == http.MethodPost {
+					require.Equal(t, "query-1", fakeServer.bodies[i])
+				}
+			}
+			require.Equal(t, tt.wantIfNoneMatch, gotIfNoneMatch)
+
+			lowest := subject.LowestRateLimit()
+			if tt.wantLowestRemains == nil {
+				require.Nil(t, lowest)
+				return
+			}
+			require.NotNil(t, lowest)
+			require.Equal(t, 5000, lowest.Limit)
+			require.Equal(t, *tt.wantLowestRemains, lowest.Remaining)
+			require.Equal(t, now.Add(time.Hour).Unix(), lowest.Reset.Unix())
+		})
+	}
+}
+
+func TestResponseCacheWithGitHubClient(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	fakeServer := &fakeGitHubServer{responses: []fakeGitHubResponse{{
+		status: http.StatusOK,
+		header: map[string]string{
+			"ETag":                  `"some-etag"`,
+			"X-RateLimit-Limit":     "5000",
+			"X-RateLimit-Remaining": "4321",
+			"X-RateLimit-Reset":     strconv.FormatInt(now.Add(time.Hour).Unix(), 10),
+		},
+		body: `{"login":"some-login","id":12345}`,
+	}}}
+	server := httptest.NewTLSServer(fakeServer)
+	t.Cleanup(server.Close)
+
+	fakeClock := clocktesting.NewFakeClock(now)
+	subject := NewResponseCache(fakeClock)
+
+	for range 2 {
+		client, err := NewGitHubClient(subject.HTTPClient(server.Client()), server.URL, "some-token")
+		require.NoError(t, err)
+
+		userInfo, err := client.GetUserInfo(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, &UserInfo{Login: "some-login", ID: "12345"}, userInfo)
+	}
+
+	require.Len(t, fakeServer.requests, 1)
+	require.Equal(t, "Bearer some-token", fakeServer.requests[0].Header.Get("Authorization"))
+	require.Equal(t, &RateLimit{
+		Limit:      5000,
+		Remaining:  4321,
+		Reset:      time.Unix(now.Add(time.Hour).Unix(), 0),
+		ObservedAt: now,
+	}, subject.LowestRateLimit())
+}
+
+func TestResponseCaches(t *testing.T) {
+	t.Parallel()
+
+	subject := NewResponseCaches(clocktesting.NewFakeClock(time.Now()))
+
+	cache1 := subject.CacheFor("uid-1")
+	cache2 := subject.CacheFor("uid-2")
+	require.NotSame(t, cache1, cache2)
+	require.Same(t, cache1, subject.CacheFor("uid-1"))
+
+	subject.ForgetAllExcept(sets.New[types.UID]("uid-2"))
+	require.NotSame(t, cache1, subject.CacheFor("uid-1"))
+	require.Same(t, cache2, subject.CacheFor("uid-2"))
+	require.Len(t, subject.cachesByUID, 2)
+}
+
+func ptrTo[T any](v T) *T {
+	return &v
+}
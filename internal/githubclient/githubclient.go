@@ -22,6 +22,14 @@ import (
 const (
 	emptyUserMeansTheAuthenticatedUser = ""
 	pageSize                           = 100
+
+	// OrgRoleAdmin is the role of the owners of an organization.
+	OrgRoleAdmin = "admin"
+	// OrgRoleMember is the role of the members of an organization who are not owners.
+	OrgRoleMember = "member"
+	// OrgRoleOutsideCollaborator is the role of users who are not members of an organization, but who are
+	// collaborators on one or more of the organization's repositories.
+	OrgRoleOutsideCollaborator = "outside_collaborator"
 )
 
 type UserInfo struct {
@@ -35,10 +43,17 @@ type TeamInfo struct {
 	Org  string
 }
 
+type OrgRoleInfo struct {
+	Org  string
+	Role string
+}
+
 type GitHubInterface interface {
 	GetUserInfo(ctx context.Context) (*UserInfo, error)
 	GetOrgMembership(ctx context.Context) ([]string, error)
 	GetTeamMembership(ctx context.Context, allowedOrganizations *setutil.CaseInsensitiveSet) ([]TeamInfo, error)
+	GetOrgRoles(ctx context.Context, allowedOrganizations *setutil.CaseInsensitiveSet) ([]OrgRoleInfo, error)
+	GetSAMLNameID(ctx context.Context, userLogin string, organizations []string) (string, error)
 }
 
 type githubClient struct {
@@ -233,3 +248,154 @@ func (g *githubClient) GetTeamMembership(ctx context.Context, allowedOrganizatio
 	plog.Trace("calculated response from GitHub teams endpoint", "teams", sortedTeams)
 	return sortedTeams, nil
 }
+
+// GetOrgRoles returns the role of the authenticated user in each organization to which they belong, and in each
+// organization which owns a repository on which they are an outside collaborator. These are fetched using the
+// GraphQL API, since the REST API does not offer a way for the authenticated user to discover the latter.
+// If allowedOrganizations is not empty, will filter the results to only those allowed organizations.
+func (g *githubClient) GetOrgRoles(ctx context.Context, allowedOrganizations *setutil.CaseInsensitiveSet) ([]OrgRoleInfo, error) {
+	const errorPrefix = "error fetching organization roles for authenticated user"
+
+	orgRoles := map[string]string{}
+
+	var cursor *string
+	// get all pages of results
+	for {
+		var data struct {
+			Viewer struct {
+				Organizations struct {
+					PageInfo graphQLPageInfo `json:"pageInfo"`
+					Nodes    []struct {
+						Login               string `json:"login"`
+						ViewerCanAdminister bool   `json:"viewerCanAdminister"`
+					} `json:"nodes"`
+				} `json:"organizations"`
+			} `json:"viewer"`
+		}
+		err := g.queryGraphQL(ctx, orgRolesQuery, map[string]any{"first": pageSize, "after": cursor}, &data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errorPrefix, err)
+		}
+		plog.Trace("got raw GitHub GraphQL API org results", "orgs", data.Viewer.Organizations.Nodes,
+			"hasNextPage", data.Viewer.Organizations.PageInfo.HasNextPage)
+
+		for _, organization := range data.Viewer.Organizations.Nodes {
+			if organization.Login == "" {
+				return nil, fmt.Errorf(`%s: one or more organizations is missing the "login" attribute`, errorPrefix)
+			}
+			role := OrgRoleMember
+			if organization.ViewerCanAdminister {
+				role = OrgRoleAdmin
+			}
+			orgRoles[organization.Login] = role
+		}
+		if !data.Viewer.Organizations.PageInfo.HasNextPage {
+			break
+		}
+		cursor = &data.Viewer.Organizations.PageInfo.EndCursor
+	}
+
+	cursor = nil
+	// get all pages of results
+	for {
+		var data struct {
+			Viewer struct {
+				Repositories struct {
+					PageInfo graphQLPageInfo `json:"pageInfo"`
+					Nodes    []struct {
+						Owner struct {
+							TypeName string `json:"__typename"`
+							Login    string `json:"login"`
+						} `json:"owner"`
+					} `json:"nodes"`
+				} `json:"repositories"`
+			} `json:"viewer"`
+		}
+		err := g.queryGraphQL(ctx, collaboratorRepositoriesQuery, map[string]any{"first": pageSize, "after": cursor}, &data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errorPrefix, err)
+		}
+		plog.Trace("got raw GitHub GraphQL API collaborator repository results", "repositories", data.Viewer.Repositories.Nodes,
+			"hasNextPage", data.Viewer.Repositories.PageInfo.HasNextPage)
+
+		for _, repository := range data.Viewer.Repositories.Nodes {
+			// Repositories which are owned by other users do not belong to an organization.
+			if repository.Owner.TypeName != "Organization" {
+				continue
+			}
+			if repository.Owner.Login == "" {
+				return nil, fmt.Errorf(`%s: one or more repositories is missing the owner's "login" attribute`, errorPrefix)
+			}
+			// Members of an organization may also be collaborators on its repositories, but they are not outside collaborators.
+			if _, isMember := orgRoles[repository.Owner.Login]; !isMember {
+				orgRoles[repository.Owner.Login] = OrgRoleOutsideCollaborator
+			}
+		}
+		if !data.Viewer.Repositories.PageInfo.HasNextPage {
+			break
+		}
+		cursor = &data.Viewer.Repositories.PageInfo.EndCursor
+	}
+
+	// Sort by org, just so we always return roles in the same order.
+	sortedOrgRoles := make([]OrgRoleInfo, 0, len(orgRoles))
+	for org, role := range orgRoles {
+		if !isOrgAllowed(allowedOrganizations, org) {
+			continue
+		}
+		sortedOrgRoles = append(sortedOrgRoles, OrgRoleInfo{Org: org, Role: role})
+	}
+	slices.SortStableFunc(sortedOrgRoles, func(a, b OrgRoleInfo) int {
+		return strings.Compare(a.Org, b.Org)
+	})
+
+	plog.Trace("calculated response from GitHub GraphQL org roles", "orgRoles", sortedOrgRoles)
+	return sortedOrgRoles, nil
+}
+
+// GetSAMLNameID returns the NameID of the SAML identity which the user has linked to their GitHub account by using
+// SAML single sign-on for one of the organizations, e.g. their corporate email address. The organizations are tried
+// in the order given, and the NameID from the first organization which has a linked identity is returned.
+// Returns an empty string when none of the organizations have a linked identity which is visible to the authenticated user.
+func (g *githubClient) GetSAMLNameID(ctx context.Context, userLogin string, organizations []string) (string, error) {
+	const errorPrefix = "error fetching SAML identity for authenticated user"
+
+	for _, organization := range organizations {
+		var data struct {
+			Organization *struct {
+				SAMLIdentityProvider *struct {
+					ExternalIdentities struct {
+						Nodes []struct {
+							SAMLIdentity *struct {
+								NameID string `json:"nameId"`
+							} `json:"samlIdentity"`
+						} `json:"nodes"`
+					} `json:"externalIdentities"`
+				} `json:"samlIdentityProvider"`
+			} `json:"organization"`
+		}
+		err := g.queryGraphQL(ctx, samlIdentityQuery, map[string]any{"org": organization, "login": userLogin}, &data)
+		var gqlErr *graphQLErrors
+		if errors.As(err, &gqlErr) && gqlErr.onlyForbidden() {
+			// Only some users, such as organization owners, are allowed to read linked SAML identities.
+			plog.Trace("not allowed to read SAML identity for organization", "org", organization, "err", err)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", errorPrefix, err)
+		}
+		plog.Trace("got raw GitHub GraphQL API SAML identity results", "org", organization, "organization", data.Organization)
+
+		// Organizations which do not use SAML single sign-on have no identity provider.
+		if data.Organization == nil || data.Organization.SAMLIdentityProvider == nil {
+			continue
+		}
+		for _, externalIdentity := range data.Organization.SAMLIdentityProvider.ExternalIdentities.Nodes {
+			if externalIdentity.SAMLIdentity != nil && externalIdentity.SAMLIdentity.NameID != "" {
+				return externalIdentity.SAMLIdentity.NameID, nil
+			}
+		}
+	}
+
+	return "", nil
+}
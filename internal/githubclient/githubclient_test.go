@@ -5,7 +5,10 @@ package githubclient
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -831,3 +834,331 @@ func TestGetTeamMembership(t *testing.T) {
 		})
 	}
 }
+
+var graphQLEndpoint = mock.EndpointPattern{Pattern: "/graphql", Method: http.MethodPost}
+
+// graphQLHandler returns a handler which responds to each kind of GraphQL query with the next of its responses.
+func graphQLHandler(t *testing.T, responses map[string][]string) http.HandlerFunc {
+	t.Helper()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body graphQLRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+
+		var queryName string
+		switch body.Query {
+		case orgRolesQuery:
+			queryName = "orgRoles"
+		case collaboratorRepositoriesQuery:
+			queryName = "collaboratorRepositories"
+		case samlIdentityQuery:
+			queryName = fmt.Sprintf("samlIdentity:%s:%s", body.Variables["org"], body.Variables["login"])
+		default:
+			require.FailNow(t, "unexpected GraphQL query", body.Query)
+		}
+
+		require.NotEmpty(t, responses[queryName], "unexpected GraphQL query %s", queryName)
+		response := responses[queryName][0]
+		responses[queryName] = responses[queryName][1:]
+
+		_, err := w.Write([]byte(response))
+		require.NoError(t, err)
+	}
+}
+
+func TestGraphQLURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		apiBaseURL string
+		want       string
+	}{
+		{
+			name:       "api.github.com",
+			apiBaseURL: "https://api.github.com/",
+			want:       "https://api.github.com/graphql",
+		},
+		{
+			name:       "Enterprise Server",
+			apiBaseURL: "https://fake.enterprise.tld/api/v3/",
+			want:       "https://fake.enterprise.tld/api/graphql",
+		},
+		{
+			name:       "Enterprise Server with port",
+			apiBaseURL: "https://fake.enterprise.tld:8443/api/v3/",
+			want:       "https://fake.enterprise.tld:8443/api/graphql",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			apiBaseURL, err := url.Parse(test.apiBaseURL)
+			require.NoError(t, err)
+			require.Equal(t, test.want, graphQLURL(apiBaseURL))
+		})
+	}
+}
+
+func TestGetOrgRoles(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name                 string
+		responses            map[string][]string
+		allowedOrganizations *setutil.CaseInsensitiveSet
+		ctx                  context.Context
+		wantErr              string
+		wantOrgRoles         []OrgRoleInfo
+	}{
+		{
+			name: "happy path",
+			responses: map[string][]string{
+				"orgRoles": {
+					`{"data":{"viewer":{"organizations":{"pageInfo":{"hasNextPage":false},"nodes":[` +
+						`{"login":"org2","viewerCanAdminister":false},{"login":"org1","viewerCanAdminister":true}]}}}}`,
+				},
+				"collaboratorRepositories": {
+					`{"data":{"viewer":{"repositories":{"pageInfo":{"hasNextPage":false},"nodes":[` +
+						`{"owner":{"__typename":"Organization","login":"org3"}},` +
+						`{"owner":{"__typename":"Organization","login":"org1"}},` +
+						`{"owner":{"__typename":"User","login":"some-other-user"}}]}}}}`,
+				},
+			},
+			wantOrgRoles: []OrgRoleInfo{
+				{Org: "org1", Role: "admin"},
+				{Org: "org2", Role: "member"},
+				{Org: "org3", Role: "outside_collaborator"},
+			},
+		},
+		{
+			name: "happy path with pagination",
+			responses: map[string][]string{
+				"orgRoles": {
+					`{"data":{"viewer":{"organizations":{"pageInfo":{"hasNextPage":true,"endCursor":"cursor1"},"nodes":[` +
+						`{"login":"page1-org1","viewerCanAdminister":false}]}}}}`,
+					`{"data":{"viewer":{"organizations":{"pageInfo":{"hasNextPage":false},"nodes":[` +
+						`{"login":"page2-org1","viewerCanAdminister":true}]}}}}`,
+				},
+				"collaboratorRepositories": {
+					`{"data":{"viewer":{"repositories":{"pageInfo":{"hasNextPage":true,"endCursor":"cursor2"},"nodes":[` +
+						`{"owner":{"__typename":"Organization","login":"page1-org2"}}]}}}}`,
+					`{"data":{"viewer":{"repositories":{"pageInfo":{"hasNextPage":false},"nodes":[` +
+						`{"owner":{"__typename":"Organization","login":"page2-org2"}}]}}}}`,
+				},
+			},
+			wantOrgRoles: []OrgRoleInfo{
+				{Org: "page1-org1", Role: "member"},
+				{Org: "page1-org2", Role: "outside_collaborator"},
+				{Org: "page2-org1", Role: "admin"},
+				{Org: "page2-org2", Role: "outside_collaborator"},
+			},
+		},
+		{
+			name: "filters by allowedOrganizations in a case-insensitive way, but preserves case as returned by GitHub API in the result",
+			responses: map[string][]string{
+				"orgRoles": {
+					`{"data":{"viewer":{"organizations":{"pageInfo":{"hasNextPage":false},"nodes":[` +
+						`{"login":"Allowed-Org1","viewerCanAdminister":false},{"login":"disallowed-org","viewerCanAdminister":true}]}}}}`,
+				},
+				"collaboratorRepositories": {
+					`{"data":{"viewer":{"repositories":{"pageInfo":{"hasNextPage":false},"nodes":[` +
+						`{"owner":{"__typename":"Organization","login":"allowed-org2"}}]}}}}`,
+				},
+			},
+			allowedOrganizations: setutil.NewCaseInsensitiveSet("allowed-org1", "ALLOWED-ORG2"),
+			wantOrgRoles: []OrgRoleInfo{
+				{Org: "Allowed-Org1", Role: "member"},
+				{Org: "allowed-org2", Role: "outside_collaborator"},
+			},
+		},
+		{
+			name: "errors when a login field is empty",
+			responses: map[string][]string{
+				"orgRoles": {
+					`{"data":{"viewer":{"organizations":{"pageInfo":{"hasNextPage":false},"nodes":[{"viewerCanAdminister":false}]}}}}`,
+				},
+			},
+			wantErr: `error fetching organization roles for authenticated user: one or more organizations is missing the "login" attribute`,
+		},
+		{
+			name: "errors when a repository owner's login field is empty",
+			responses: map[string][]string{
+				"orgRoles": {
+					`{"data":{"viewer":{"organizations":{"pageInfo":{"hasNextPage":false},"nodes":[]}}}}`,
+				},
+				"collaboratorRepositories": {
+					`{"data":{"viewer":{"repositories":{"pageInfo":{"hasNextPage":false},"nodes":[{"owner":{"__typename":"Organization"}}]}}}}`,
+				},
+			},
+			wantErr: `error fetching organization roles for authenticated user: one or more repositories is missing the owner's "login" attribute`,
+		},
+		{
+			name: "returns errors from the GraphQL API",
+			responses: map[string][]string{
+				"orgRoles": {
+					`{"data":null,"errors":[{"type":"INSUFFICIENT_SCOPES","message":"some scope error"},{"message":"some other error"}]}`,
+				},
+			},
+			wantErr: "error fetching organization roles for authenticated user: GraphQL API returned errors: some scope error; some other error",
+		},
+		{
+			name: "returns errors from the GraphQL API for collaborator repositories",
+			responses: map[string][]string{
+				"orgRoles": {
+					`{"data":{"viewer":{"organizations":{"pageInfo":{"hasNextPage":false},"nodes":[]}}}}`,
+				},
+				"collaboratorRepositories": {
+					`{"data":null,"errors":[{"message":"some error"}]}`,
+				},
+			},
+			wantErr: "error fetching organization roles for authenticated user: GraphQL API returned errors: some error",
+		},
+		{
+			name: "passes the context parameter into the API call",
+			ctx: func() context.Context {
+				canceledCtx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return canceledCtx
+			}(),
+			wantErr: "error fetching organization roles for authenticated user: context canceled",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			httpClient := mock.NewMockedHTTPClient(
+				mock.WithRequestMatchHandler(graphQLEndpoint, graphQLHandler(t, test.responses)),
+			)
+			githubClient := &githubClient{
+				client: github.NewClient(httpClient).WithAuthToken("some-token"),
+			}
+
+			ctx := context.Background()
+			if test.ctx != nil {
+				ctx = test.ctx
+			}
+
+			allowedOrganizations := test.allowedOrganizations
+			if allowedOrganizations == nil {
+				allowedOrganizations = setutil.NewCaseInsensitiveSet()
+			}
+
+			actual, err := githubClient.GetOrgRoles(ctx, allowedOrganizations)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				require.Nil(t, actual)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.wantOrgRoles, actual)
+		})
+	}
+}
+
+func TestGetSAMLNameID(t *testing.T) {
+	t.Parallel()
+
+	samlIdentity := func(nameID string) string {
+		return `{"data":{"organization":{"samlIdentityProvider":{"externalIdentities":{"nodes":[` +
+			`{"samlIdentity":{"nameId":"` + nameID + `"}}]}}}}}`
+	}
+
+	tests := []struct {
+		name          string
+		responses     map[string][]string
+		organizations []string
+		ctx           context.Context
+		wantErr       string
+		wantNameID    string
+	}{
+		{
+			name:          "happy path returns the NameID from the first organization with a linked identity",
+			organizations: []string{"org1", "org2", "org3"},
+			responses: map[string][]string{
+				"samlIdentity:org1:some-login": {`{"data":{"organization":{"samlIdentityProvider":null}}}`},
+				"samlIdentity:org2:some-login": {samlIdentity("some-user@example.com")},
+			},
+			wantNameID: "some-user@example.com",
+		},
+		{
+			name:          "skips organizations which have no linked identity for the user",
+			organizations: []string{"org1", "org2"},
+			responses: map[string][]string{
+				"samlIdentity:org1:some-login": {`{"data":{"organization":{"samlIdentityProvider":{"externalIdentities":{"nodes":[]}}}}}`},
+				"samlIdentity:org2:some-login": {samlIdentity("some-user@example.com")},
+			},
+			wantNameID: "some-user@example.com",
+		},
+		{
+			name:          "skips organizations whose linked identities the user is not allowed to read",
+			organizations: []string{"org1", "org2"},
+			responses: map[string][]string{
+				"samlIdentity:org1:some-login": {`{"data":{"organization":{"samlIdentityProvider":null}},"errors":[{"type":"FORBIDDEN","message":"some forbidden error"}]}`},
+				"samlIdentity:org2:some-login": {samlIdentity("some-user@example.com")},
+			},
+			wantNameID: "some-user@example.com",
+		},
+		{
+			name:          "returns an empty string when no organization has a linked identity",
+			organizations: []string{"org1"},
+			responses: map[string][]string{
+				"samlIdentity:org1:some-login": {`{"data":{"organization":null}}`},
+			},
+			wantNameID: "",
+		},
+		{
+			name:          "returns an empty string when there are no organizations",
+			organizations: nil,
+			wantNameID:    "",
+		},
+		{
+			name:          "returns other errors from the GraphQL API",
+			organizations: []string{"org1"},
+			responses: map[string][]string{
+				"samlIdentity:org1:some-login": {`{"data":null,"errors":[{"type":"FORBIDDEN","message":"some forbidden error"},{"type":"INSUFFICIENT_SCOPES","message":"some scope error"}]}`},
+			},
+			wantErr: "error fetching SAML identity for authenticated user: GraphQL API returned errors: some forbidden error; some scope error",
+		},
+		{
+			name:          "passes the context parameter into the API call",
+			organizations: []string{"org1"},
+			ctx: func() context.Context {
+				canceledCtx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return canceledCtx
+			}(),
+			wantErr: "error fetching SAML identity for authenticated user: context canceled",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			httpClient := mock.NewMockedHTTPClient(
+				mock.WithRequestMatchHandler(graphQLEndpoint, graphQLHandler(t, test.responses)),
+			)
+			githubClient := &githubClient{
+				client: github.NewClient(httpClient).WithAuthToken("some-token"),
+			}
+
+			ctx := context.Background()
+			if test.ctx != nil {
+				ctx = test.ctx
+			}
+
+			actual, err := githubClient.GetSAMLNameID(ctx, "some-login", test.organizations)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				require.Empty(t, actual)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.wantNameID, actual)
+		})
+	}
+}
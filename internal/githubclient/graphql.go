@@ -0,0 +1,119 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package githubclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	orgRolesQuery = `query($first: Int!, $after: String) {
+  viewer {
+    organizations(first: $first, after: $after) {
+      pageInfo { hasNextPage endCursor }
+      nodes { login viewerCanAdminister }
+    }
+  }
+}`
+
+	collaboratorRepositoriesQuery = `query($first: Int!, $after: String) {
+  viewer {
+    repositories(first: $first, after: $after, affiliations: [COLLABORATOR]) {
+      pageInfo { hasNextPage endCursor }
+      nodes { owner { __typename login } }
+    }
+  }
+}`
+
+	samlIdentityQuery = `query($org: String!, $login: String!) {
+  organization(login: $org) {
+    samlIdentityProvider {
+      externalIdentities(first: 1, login: $login) {
+        nodes { samlIdentity { nameId } }
+      }
+    }
+  }
+}`
+
+	graphQLErrorTypeForbidden = "FORBIDDEN"
+)
+
+type graphQLPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type graphQLRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables,omitempty"`
+}
+
+type graphQLResponse struct {
+	Data   any            `json:"data"`
+	Errors []graphQLError `json:"errors,omitempty"`
+}
+
+type graphQLError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// graphQLErrors holds the errors which were returned by the GraphQL API in a response whose status was successful.
+type graphQLErrors struct {
+	errors []graphQLError
+}
+
+func (e *graphQLErrors) Error() string {
+	messages := make([]string, 0, len(e.errors))
+	for _, err := range e.errors {
+		messages = append(messages, err.Message)
+	}
+	return fmt.Sprintf("GraphQL API returned errors: %s", strings.Join(messages, "; "))
+}
+
+func (e *graphQLErrors) onlyForbidden() bool {
+	for _, err := range e.errors {
+		if err.Type != graphQLErrorTypeForbidden {
+			return false
+		}
+	}
+	return true
+}
+
+// graphQLURL returns the URL of the GraphQL API, which is "https://api.github.com/graphql" for cloud and
+// "https://HOSTNAME/api/graphql" for Enterprise Server, whose REST API is at "https://HOSTNAME/api/v3/".
+func graphQLURL(apiBaseURL *url.URL) string {
+	graphQL := *apiBaseURL
+	if strings.HasSuffix(graphQL.Path, "/api/v3/") {
+		graphQL.Path = strings.TrimSuffix(graphQL.Path, "v3/") + "graphql"
+	} else {
+		graphQL.Path += "graphql"
+	}
+	return graphQL.String()
+}
+
+// queryGraphQL sends the query to the GraphQL API and decodes the data of the response into data.
+func (g *githubClient) queryGraphQL(ctx context.Context, query string, variables map[string]any, data any) error {
+	req, err := g.client.NewRequest(http.MethodPost, graphQLURL(g.client.BaseURL), &graphQLRequest{
+		Query:     query,
+		Variables: variables,
+	})
+	if err != nil {
+		return err
+	}
+
+	response := &graphQLResponse{Data: data}
+	if _, err := g.client.Do(ctx, req, response); err != nil {
+		return err
+	}
+
+	if len(response.Errors) > 0 {
+		return &graphQLErrors{errors: response.Errors}
+	}
+	return nil
+}
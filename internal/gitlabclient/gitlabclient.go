@@ -0,0 +1,195 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package gitlabclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"go.pinniped.dev/internal/plog"
+)
+
+const (
+	pageSize = 100
+
+	// guestAccessLevel is the lowest access level of a group member. Asking for groups with at least this access
+	// level will return all groups in which the user is a direct or inherited member.
+	// See https://docs.gitlab.com/ee/api/members.html#roles.
+	guestAccessLevel = 10
+
+	// nextPageHeader is the pagination header which contains the number of the next page, or is empty on the last page.
+	// See https://docs.gitlab.com/ee/api/rest/index.html#other-pagination-headers.
+	nextPageHeader = "X-Next-Page"
+
+	// maxResponseBytes limits how much of each response body will be read, as a defense against misbehaving servers.
+	maxResponseBytes = 10 * 1024 * 1024
+)
+
+type UserInfo struct {
+	ID       string
+	Username string
+}
+
+type GitLabInterface interface {
+	GetUserInfo(ctx context.Context) (*UserInfo, error)
+	GetGroupMembership(ctx context.Context) ([]string, error)
+}
+
+type gitlabClient struct {
+	httpClient *http.Client
+	baseURL    *url.URL
+	token      string
+}
+
+var _ GitLabInterface = (*gitlabClient)(nil)
+
+func NewGitLabClient(httpClient *http.Client, apiBaseURL, token string) (GitLabInterface, error) {
+	const errorPrefix = "unable to build new gitlab client"
+
+	if httpClient == nil {
+		return nil, fmt.Errorf("%s: httpClient cannot be nil", errorPrefix)
+	}
+
+	parsedURL, err := url.Parse(apiBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errorPrefix, err)
+	}
+
+	if !strings.HasSuffix(parsedURL.Path, "/") {
+		parsedURL.Path += "/"
+	}
+
+	if parsedURL.Scheme != "https" {
+		return nil, fmt.Errorf(`%s: apiBaseURL must use "https" protocol, found %q instead`, errorPrefix, parsedURL.Scheme)
+	}
+
+	if token == "" {
+		return nil, fmt.Errorf("%s: token cannot be empty string", errorPrefix)
+	}
+
+	return &gitlabClient{
+		httpClient: httpClient,
+		baseURL:    parsedURL,
+		token:      token,
+	}, nil
+}
+
+type gitlabUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type gitlabGroup struct {
+	FullPath string `json:"full_path"`
+}
+
+// GetUserInfo returns the "username" and "id" attributes of the logged-in user.
+func (g *gitlabClient) GetUserInfo(ctx context.Context) (*UserInfo, error) {
+	const errorPrefix = "error fetching authenticated user"
+
+	var user gitlabUser
+	if _, err := g.get(ctx, "user", nil, &user); err != nil {
+		return nil, fmt.Errorf("%s: %w", errorPrefix, err)
+	}
+	plog.Trace("got raw GitLab API user results", "user", user)
+
+	userInfo := &UserInfo{
+		Username: user.Username,
+		ID:       strconv.FormatInt(user.ID, 10),
+	}
+	if userInfo.ID == "0" {
+		return nil, fmt.Errorf(`%s: the "id" attribute is missing`, errorPrefix)
+	}
+	if userInfo.Username == "" {
+		return nil, fmt.Errorf(`%s: the "username" attribute is missing`, errorPrefix)
+	}
+
+	plog.Trace("calculated response from GitLab user endpoint", "user", userInfo)
+	return userInfo, nil
+}
+
+// GetGroupMembership returns the sorted "full_path" attributes of all groups and subgroups in which the authenticated
+// user is a member, including groups in which the user's membership is inherited from an ancestor group.
+func (g *gitlabClient) GetGroupMembership(ctx context.Context) ([]string, error) {
+	const errorPrefix = "error fetching group membership for authenticated user"
+
+	fullPaths := sets.New[string]()
+
+	query := url.Values{}
+	query.Set("min_access_level", strconv.Itoa(guestAccessLevel))
+	query.Set("per_page", strconv.Itoa(pageSize))
+	query.Set("page", "1")
+
+	// get all pages of results
+	for {
+		var groups []gitlabGroup
+		nextPage, err := g.get(ctx, "groups", query, &groups)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", errorPrefix, err)
+		}
+		plog.Trace("got raw GitLab API group results", "groups", groups, "nextPage", nextPage)
+
+		for _, group := range groups {
+			if group.FullPath == "" {
+				return nil, fmt.Errorf(`%s: the "full_path" attribute is missing for a group`, errorPrefix)
+			}
+			fullPaths.Insert(group.FullPath)
+		}
+		if nextPage == "" {
+			break
+		}
+		query.Set("page", nextPage)
+	}
+
+	// Sort, just so we always return groups in the same order.
+	sortedGroups := fullPaths.UnsortedList()
+	slices.Sort(sortedGroups)
+
+	plog.Trace("calculated response from GitLab groups endpoint", "groups", sortedGroups)
+	return sortedGroups, nil
+}
+
+// get performs an authenticated GET request for the given API path and decodes the JSON response into result.
+// It returns the value of the next page header from the response, which is empty when there are no more pages.
+func (g *gitlabClient) get(ctx context.Context, path string, query url.Values, result any) (string, error) {
+	requestURL := g.baseURL.JoinPath(path)
+	requestURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	req.Header.Set("Accept", "application/json")
+
+	response, err := g.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: unexpected response status %q", requestURL.Redacted(), response.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxResponseBytes))
+	if err != nil {
+		return "", fmt.Errorf("GET %s: could not read response body: %w", requestURL.Redacted(), err)
+	}
+
+	if err := json.Unmarshal(body, result); err != nil {
+		return "", fmt.Errorf("GET %s: could not decode response body: %w", requestURL.Redacted(), err)
+	}
+
+	return response.Header.Get(nextPageHeader), nil
+}
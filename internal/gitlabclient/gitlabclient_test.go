@@ -0,0 +1,293 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package gitlabclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/util/cert"
+
+	"go.pinniped.dev/internal/net/phttp"
+	"go.pinniped.dev/internal/testutil/tlsserver"
+)
+
+func TestNewGitLabClient(t *testing.T) {
+	t.Parallel()
+
+	t.Run("rejects nil http client", func(t *testing.T) {
+		_, err := NewGitLabClient(nil, "https://gitlab.com/api/v4/", "")
+		require.EqualError(t, err, "unable to build new gitlab client: httpClient cannot be nil")
+	})
+
+	tests := []struct {
+		name        string
+		apiBaseURL  string
+		token       string
+		wantBaseURL string
+		wantErr     string
+	}{
+		{
+			name:        "happy path with https://gitlab.com/api/v4/",
+			apiBaseURL:  "https://gitlab.com/api/v4/",
+			token:       "some-token",
+			wantBaseURL: "https://gitlab.com/api/v4/",
+		},
+		{
+			name:        "adds trailing slash to path for self-managed URL https://fake.gitlab.tld:8443/api/v4",
+			apiBaseURL:  "https://fake.gitlab.tld:8443/api/v4",
+			token:       "some-self-managed-token",
+			wantBaseURL: "https://fake.gitlab.tld:8443/api/v4/",
+		},
+		{
+			name:       "rejects apiBaseURL without https:// scheme",
+			apiBaseURL: "scp://gitlab.com/api/v4",
+			token:      "some-token",
+			wantErr:    `unable to build new gitlab client: apiBaseURL must use "https" protocol, found "scp" instead`,
+		},
+		{
+			name:       "rejects apiBaseURL with empty scheme",
+			apiBaseURL: "gitlab.com",
+			token:      "some-token",
+			wantErr:    `unable to build new gitlab client: apiBaseURL must use "https" protocol, found "" instead`,
+		},
+		{
+			name:       "rejects empty token",
+			apiBaseURL: "https://gitlab.com/api/v4/",
+			wantErr:    "unable to build new gitlab client: token cannot be empty string",
+		},
+		{
+			name:       "returns errors from url.Parse",
+			apiBaseURL: "https:// example.com",
+			token:      "some-token",
+			wantErr:    `unable to build new gitlab client: parse "https:// example.com": invalid character " " in host name`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			actualI, err := NewGitLabClient(&http.Client{}, test.apiBaseURL, test.token)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				require.Nil(t, actualI)
+				return
+			}
+			require.NoError(t, err)
+
+			actual, ok := actualI.(*gitlabClient)
+			require.True(t, ok)
+			require.Equal(t, test.wantBaseURL, actual.baseURL.String())
+			require.Equal(t, test.token, actual.token)
+		})
+	}
+}
+
+func TestGetUserInfo(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		responseCode int
+		responseBody string
+		token        string
+		wantUserInfo *UserInfo
+		wantErr      string
+	}{
+		{
+			name:         "happy path",
+			responseCode: http.StatusOK,
+			responseBody: `{"id": 12345, "username": "some-username", "name": "Some Name"}`,
+			token:        "some-token",
+			wantUserInfo: &UserInfo{ID: "12345", Username: "some-username"},
+		},
+		{
+			name:         "the token is passed to the API",
+			responseCode: http.StatusOK,
+			responseBody: `{"id": 12345, "username": "some-username"}`,
+			token:        "wrong-token",
+			wantErr:      `error fetching authenticated user: GET <server>/api/v4/user: unexpected response status "401 Unauthorized"`,
+		},
+		{
+			name:         "returns error when the user has no id",
+			responseCode: http.StatusOK,
+			responseBody: `{"username": "some-username"}`,
+			token:        "some-token",
+			wantErr:      `error fetching authenticated user: the "id" attribute is missing`,
+		},
+		{
+			name:         "returns error when the user has no username",
+			responseCode: http.StatusOK,
+			responseBody: `{"id": 12345}`,
+			token:        "some-token",
+			wantErr:      `error fetching authenticated user: the "username" attribute is missing`,
+		},
+		{
+			name:         "returns error when the server returns an error status",
+			responseCode: http.StatusInternalServerError,
+			token:        "some-token",
+			wantErr:      `error fetching authenticated user: GET <server>/api/v4/user: unexpected response status "500 Internal Server Error"`,
+		},
+		{
+			name:         "returns error when the response is not JSON",
+			responseCode: http.StatusOK,
+			responseBody: `not json`,
+			token:        "some-token",
+			wantErr:      `error fetching authenticated user: GET <server>/api/v4/user: could not decode response body: invalid character 'o' in literal null (expecting 'u')`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			testServer, testServerCA := tlsserver.TestServerIPv4(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodGet, r.Method)
+				require.Equal(t, "/api/v4/user", r.URL.Path)
+				if r.Header.Get("Authorization") != "Bearer some-token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(test.responseCode)
+				_, _ = w.Write([]byte(test.responseBody))
+			}), nil)
+
+			pool, err := cert.NewPoolFromBytes(testServerCA)
+			require.NoError(t, err)
+
+			client, err := NewGitLabClient(phttp.Default(pool), testServer.URL+"/api/v4", test.token)
+			require.NoError(t, err)
+
+			actual, err := client.GetUserInfo(context.Background())
+			if test.wantErr != "" {
+				require.EqualError(t, err, replaceServer(test.wantErr, testServer.URL))
+				require.Nil(t, actual)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.wantUserInfo, actual)
+		})
+	}
+}
+
+func TestGetGroupMembership(t *testing.T) {
+	t.Parallel()
+
+	type page struct {
+		body     string
+		nextPage string
+	}
+
+	tests := []struct {
+		name         string
+		pages        map[string]page
+		responseCode int
+		wantGroups   []string
+		wantErr      string
+	}{
+		{
+			name: "happy path with a single page",
+			pages: map[string]page{
+				"1": {body: `[{"id": 1, "full_path": "group-b"}, {"id": 2, "full_path": "group-a/subgroup"}, {"id": 3, "full_path": "group-a"}]`},
+			},
+			wantGroups: []string{"group-a", "group-a/subgroup", "group-b"},
+		},
+		{
+			name: "happy path with multiple pages and duplicates",
+			pages: map[string]page{
+				"1": {body: `[{"id": 1, "full_path": "group-b"}]`, nextPage: "2"},
+				"2": {body: `[{"id": 2, "full_path": "group-a/subgroup"}]`, nextPage: "3"},
+				"3": {body: `[{"id": 2, "full_path": "group-a/subgroup"}, {"id": 4, "full_path": "group-c"}]`},
+			},
+			wantGroups: []string{"group-a/subgroup", "group-b", "group-c"},
+		},
+		{
+			name: "happy path with no groups",
+			pages: map[string]page{
+				"1": {body: `[]`},
+			},
+			wantGroups: []string{},
+		},
+		{
+			name: "returns error when a group has no full_path",
+			pages: map[string]page{
+				"1": {body: `[{"id": 1, "full_path": "group-b"}, {"id": 2}]`},
+			},
+			wantErr: `error fetching group membership for authenticated user: the "full_path" attribute is missing for a group`,
+		},
+		{
+			name: "returns error when a later page fails",
+			pages: map[string]page{
+				"1": {body: `[{"id": 1, "full_path": "group-b"}]`, nextPage: "2"},
+			},
+			wantErr: `error fetching group membership for authenticated user: GET <server>/api/v4/groups?min_access_level=10&page=2&per_page=100: unexpected response status "404 Not Found"`,
+		},
+		{
+			name: "returns error when the response is not a list",
+			pages: map[string]page{
+				"1": {body: `{"id": 1, "full_path": "group-b"}`},
+			},
+			wantErr: `error fetching group membership for authenticated user: GET <server>/api/v4/groups?min_access_level=10&page=1&per_page=100: could not decode response body: json: cannot unmarshal object into Go value of type []gitlabclient.gitlabGroup`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			testServer, testServerCA := tlsserver.TestServerIPv4(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodGet, r.Method)
+				require.Equal(t, "/api/v4/groups", r.URL.Path)
+				require.Equal(t, "Bearer some-token", r.Header.Get("Authorization"))
+				require.Equal(t, "10", r.URL.Query().Get("min_access_level"))
+				require.Equal(t, "100", r.URL.Query().Get("per_page"))
+
+				p, ok := test.pages[r.URL.Query().Get("page")]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("X-Next-Page", p.nextPage)
+				_, _ = w.Write([]byte(p.body))
+			}), nil)
+
+			pool, err := cert.NewPoolFromBytes(testServerCA)
+			require.NoError(t, err)
+
+			client, err := NewGitLabClient(phttp.Default(pool), testServer.URL+"/api/v4/", "some-token")
+			require.NoError(t, err)
+
+			actual, err := client.GetGroupMembership(context.Background())
+			if test.wantErr != "" {
+				require.EqualError(t, err, replaceServer(test.wantErr, testServer.URL))
+				require.Nil(t, actual)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.wantGroups, actual)
+		})
+	}
+}
+
+func TestGetErrorsFromHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	testServer, _ := tlsserver.TestServerIPv4(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("the server should not have been called because the client does not trust its CA")
+	}), nil)
+
+	// Use a client which does not trust the test server's CA.
+	client, err := NewGitLabClient(phttp.Default(nil), testServer.URL+"/api/v4/", "some-token")
+	require.NoError(t, err)
+
+	_, err = client.GetUserInfo(context.Background())
+	require.EqualError(t, err, fmt.Sprintf(`error fetching authenticated user: Get "%s/api/v4/user": `+
+		`tls: failed to verify certificate: x509: certificate signed by unknown authority`, testServer.URL))
+}
+
+func replaceServer(s, serverURL string) string {
+	return strings.ReplaceAll(s, "<server>", serverURL)
+}
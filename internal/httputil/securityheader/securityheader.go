@@ -14,9 +14,15 @@ func Wrap(wrapped http.Handler) http.Handler {
 }
 
 func WrapWithCustomCSP(wrapped http.Handler, cspHeader string) http.Handler {
+	return WrapWithCustomCSPFunc(wrapped, func(*http.Request) string { return cspHeader })
+}
+
+// WrapWithCustomCSPFunc is like WrapWithCustomCSP, but cspHeaderFunc decides the Content-Security-Policy header
+// value for each request.
+func WrapWithCustomCSPFunc(wrapped http.Handler, cspHeaderFunc func(r *http.Request) string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		h := w.Header()
-		h.Set("Content-Security-Policy", cspHeader)
+		h.Set("Content-Security-Policy", cspHeaderFunc(r))
 		h.Set("X-Frame-Options", "DENY")
 		h.Set("X-XSS-Protection", "1; mode=block")
 		h.Set("X-Content-Type-Options", "nosniff")
@@ -55,6 +55,25 @@ func TestWrap(t *testing.T) {
 				"Expires":                 []string{"0"},
 			},
 		},
+		{
+			name: "custom CSP func",
+			wrapFunc: func(h http.Handler) http.Handler {
+				return WrapWithCustomCSPFunc(h, func(r *http.Request) string { return "csp-for-" + r.Method })
+			},
+			expectHeaders: http.Header{
+				"X-Test-Header":           []string{"test value"},
+				"Content-Security-Policy": []string{"csp-for-GET"},
+				"Content-Type":            []string{"text/plain; charset=utf-8"},
+				"Referrer-Policy":         []string{"no-referrer"},
+				"X-Content-Type-Options":  []string{"nosniff"},
+				"X-Frame-Options":         []string{"DENY"},
+				"X-Xss-Protection":        []string{"1; mode=block"},
+				"X-Dns-Prefetch-Control":  []string{"off"},
+				"Cache-Control":           []string{"no-cache,no-store,max-age=0,must-revalidate"},
+				"Pragma":                  []string{"no-cache"},
+				"Expires":                 []string{"0"},
+			},
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
 			testServer := httptest.NewServer(tt.wrapFunc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
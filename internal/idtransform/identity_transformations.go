@@ -8,6 +8,7 @@ package idtransform
 import (
 	"context"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 
@@ -23,14 +24,74 @@ type TransformationResult struct {
 }
 
 // IdentityTransformation is an individual identity transformation which can be evaluated.
+// The additionalClaims are the additional claims which the upstream identity provider determined for the identity,
+// and are never nil. They are not changed by transformations.
 type IdentityTransformation interface {
-	Evaluate(ctx context.Context, username string, groups []string) (*TransformationResult, error)
+	Evaluate(ctx context.Context, username string, groups []string, additionalClaims map[string]any) (*TransformationResult, error)
 
 	// Source returns some representation of the original source code of the transformation, which is
 	// useful for tests to be able to check that a compiled transformation came from the right source.
 	Source() any
 }
 
+// GroupEnrichmentKey computes the key which is used to look up additional group memberships for an identity.
+// The additionalClaims are the additional claims which the upstream identity provider determined for the identity,
+// and are never nil.
+type GroupEnrichmentKey interface {
+	Evaluate(ctx context.Context, username string, groups []string, additionalClaims map[string]any) (string, error)
+
+	// Source returns some representation of the original source code of the key, which is useful for tests
+	// to be able to check that a compiled key came from the right source.
+	Source() any
+}
+
+// GroupsLookupFunc returns the group memberships found for a key in some secondary source of group memberships.
+type GroupsLookupFunc func(ctx context.Context, key string) ([]string, error)
+
+// GroupEnrichmentSource is the Source of an IdentityTransformation created by NewGroupEnrichmentTransformation.
+type GroupEnrichmentSource struct {
+	Key any
+}
+
+type groupEnrichmentTransformation struct {
+	key    GroupEnrichmentKey
+	lookup GroupsLookupFunc
+}
+
+// NewGroupEnrichmentTransformation returns an IdentityTransformation which computes a key for the identity, uses
+// the key to look up more group memberships, and adds those groups to the identity's groups. When the key is empty,
+// the identity is returned unchanged without performing a lookup. The key may use the additional claims of the identity.
+func NewGroupEnrichmentTransformation(key GroupEnrichmentKey, lookup GroupsLookupFunc) IdentityTransformation {
+	return &groupEnrichmentTransformation{key: key, lookup: lookup}
+}
+
+func (g *groupEnrichmentTransformation) Evaluate(ctx context.Context, username string, groups []string, additionalClaims map[string]any) (*TransformationResult, error) {
+	key, err := g.key.Evaluate(ctx, username, groups, additionalClaims)
+	if err != nil {
+		return nil, fmt.Errorf("group enrichment key: %w", err)
+	}
+
+	result := &TransformationResult{
+		Username:              username, // username is not modified by group enrichment
+		Groups:                groups,
+		AuthenticationAllowed: true,
+	}
+	if key == "" {
+		return result, nil
+	}
+
+	moreGroups, err := g.lookup(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("group enrichment lookup: %w", err)
+	}
+	result.Groups = sortAndUniq(append(slices.Clone(groups), moreGroups...))
+	return result, nil
+}
+
+func (g *groupEnrichmentTransformation) Source() any {
+	return &GroupEnrichmentSource{Key: g.key.Source()}
+}
+
 // TransformationPipeline is a list of identity transforms, which can be evaluated in order against some given input
 // values.
 type TransformationPipeline struct {
@@ -49,14 +110,28 @@ func (p *TransformationPipeline) AppendTransformation(t IdentityTransformation)
 	p.transforms = append(p.transforms, t)
 }
 
+// WithTransformation returns a new pipeline which contains all the transformations of this pipeline followed by the
+// given transformation. This pipeline is not modified, so this is safe to call while other goroutines are using
+// Evaluate on this pipeline.
+func (p *TransformationPipeline) WithTransformation(t IdentityTransformation) *TransformationPipeline {
+	transforms := []IdentityTransformation{}
+	if p != nil {
+		transforms = append(transforms, p.transforms...)
+	}
+	return &TransformationPipeline{transforms: append(transforms, t)}
+}
+
 // Evaluate runs the transformation pipeline for a given input identity. It returns a potentially transformed or
 // rejected identity, or an error. If any transformation in the list rejects the authentication, then the list is
-// short-circuited but no error is returned. Only unexpected errors are returned as errors. This is safe to call
-// from multiple goroutines.
-func (p *TransformationPipeline) Evaluate(ctx context.Context, username string, groups []string) (*TransformationResult, error) {
+// short-circuited but no error is returned. Only unexpected errors are returned as errors. The additionalClaims
+// may be nil when the upstream identity provider did not determine any. This is safe to call from multiple goroutines.
+func (p *TransformationPipeline) Evaluate(ctx context.Context, username string, groups []string, additionalClaims map[string]any) (*TransformationResult, error) {
 	if groups == nil {
 		groups = []string{}
 	}
+	if additionalClaims == nil {
+		additionalClaims = map[string]any{}
+	}
 
 	accumulatedResult := &TransformationResult{
 		Username:              username,
@@ -66,7 +141,7 @@ func (p *TransformationPipeline) Evaluate(ctx context.Context, username string,
 
 	for i, transform := range p.transforms {
 		var err error
-		accumulatedResult, err = transform.Evaluate(ctx, accumulatedResult.Username, accumulatedResult.Groups)
+		accumulatedResult, err = transform.Evaluate(ctx, accumulatedResult.Username, accumulatedResult.Groups, additionalClaims)
 		if err != nil {
 			// There was an unexpected error evaluating a transformation.
 			return nil, fmt.Errorf("identity transformation at index %d: %w", i, err)
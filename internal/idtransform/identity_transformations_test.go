@@ -13,7 +13,7 @@ import (
 
 type fakeNoopTransformer struct{}
 
-func (a fakeNoopTransformer) Evaluate(_ctx context.Context, username string, groups []string) (*TransformationResult, error) {
+func (a fakeNoopTransformer) Evaluate(_ctx context.Context, username string, groups []string, _additionalClaims map[string]any) (*TransformationResult, error) {
 	return &TransformationResult{
 		Username:                      username,
 		Groups:                        groups,
@@ -28,7 +28,7 @@ func (a fakeNoopTransformer) Source() any {
 
 type fakeNilGroupTransformer struct{}
 
-func (a fakeNilGroupTransformer) Evaluate(_ctx context.Context, username string, _groups []string) (*TransformationResult, error) {
+func (a fakeNilGroupTransformer) Evaluate(_ctx context.Context, username string, _groups []string, _additionalClaims map[string]any) (*TransformationResult, error) {
 	return &TransformationResult{
 		Username:                      username,
 		Groups:                        nil,
@@ -43,7 +43,7 @@ func (a fakeNilGroupTransformer) Source() any {
 
 type fakeAppendStringTransformer struct{}
 
-func (a fakeAppendStringTransformer) Evaluate(_ctx context.Context, username string, groups []string) (*TransformationResult, error) {
+func (a fakeAppendStringTransformer) Evaluate(_ctx context.Context, username string, groups []string, _additionalClaims map[string]any) (*TransformationResult, error) {
 	newGroups := []string{}
 	for _, group := range groups {
 		newGroups = append(newGroups, group+":transformed")
@@ -62,7 +62,7 @@ func (a fakeAppendStringTransformer) Source() any {
 
 type fakeDeleteUsernameAndGroupsTransformer struct{}
 
-func (a fakeDeleteUsernameAndGroupsTransformer) Evaluate(_ctx context.Context, _username string, _groups []string) (*TransformationResult, error) {
+func (a fakeDeleteUsernameAndGroupsTransformer) Evaluate(_ctx context.Context, _username string, _groups []string, _additionalClaims map[string]any) (*TransformationResult, error) {
 	return &TransformationResult{
 		Username:                      "",
 		Groups:                        []string{},
@@ -77,7 +77,7 @@ func (a fakeDeleteUsernameAndGroupsTransformer) Source() any {
 
 type fakeAuthenticationDisallowedTransformer struct{}
 
-func (a fakeAuthenticationDisallowedTransformer) Evaluate(_ctx context.Context, username string, groups []string) (*TransformationResult, error) {
+func (a fakeAuthenticationDisallowedTransformer) Evaluate(_ctx context.Context, username string, groups []string, _additionalClaims map[string]any) (*TransformationResult, error) {
 	newGroups := []string{}
 	for _, group := range groups {
 		newGroups = append(newGroups, group+":disallowed")
@@ -96,7 +96,7 @@ func (a fakeAuthenticationDisallowedTransformer) Source() any {
 
 type fakeErrorTransformer struct{}
 
-func (a fakeErrorTransformer) Evaluate(_ctx context.Context, _username string, _groups []string) (*TransformationResult, error) {
+func (a fakeErrorTransformer) Evaluate(_ctx context.Context, _username string, _groups []string, _additionalClaims map[string]any) (*TransformationResult, error) {
 	return &TransformationResult{}, errors.New("unexpected catastrophic error")
 }
 
@@ -108,7 +108,7 @@ type fakeTransformerWithSource struct {
 	source string
 }
 
-func (a fakeTransformerWithSource) Evaluate(_ctx context.Context, _username string, _groups []string) (*TransformationResult, error) {
+func (a fakeTransformerWithSource) Evaluate(_ctx context.Context, _username string, _groups []string, _additionalClaims map[string]any) (*TransformationResult, error) {
 	return nil, nil // not needed for this test
 }
 
@@ -306,7 +306,7 @@ func TestTransformationPipelineEvaluation(t *testing.T) {
 				pipeline.AppendTransformation(transform)
 			}
 
-			result, err := pipeline.Evaluate(context.Background(), tt.username, tt.groups)
+			result, err := pipeline.Evaluate(context.Background(), tt.username, tt.groups, nil)
 
 			if tt.wantError != "" {
 				require.EqualError(t, err, tt.wantError)
@@ -337,3 +337,139 @@ func TestTransformationSource(t *testing.T) {
 	require.Equal(t, []any{"foo", "bar", "baz"}, pipeline.Source())
 	require.NotEqual(t, []any{"foo", "something-else", "baz"}, pipeline.Source())
 }
+
+type fakeGroupEnrichmentKey struct {
+	key string
+	err error
+
+	// When set, the key is read from this additional claim instead.
+	additionalClaimName string
+}
+
+func (k *fakeGroupEnrichmentKey) Evaluate(_ctx context.Context, username string, _groups []string, additionalClaims map[string]any) (string, error) {
+	if k.err != nil {
+		return "", k.err
+	}
+	if additionalClaims == nil {
+		return "", errors.New("additionalClaims should never be nil")
+	}
+	if k.additionalClaimName != "" {
+		key, _ := additionalClaims[k.additionalClaimName].(string)
+		return key, nil
+	}
+	return k.key, nil
+}
+
+func (k *fakeGroupEnrichmentKey) Source() any {
+	return k.key
+}
+
+func TestGroupEnrichmentTransformation(t *testing.T) {
+	tests := []struct {
+		name             string
+		key              *fakeGroupEnrichmentKey
+		additionalClaims map[string]any
+		lookupGroups     []string
+		lookupErr        error
+		wantLookupKey    string
+		wantGroups       []string
+		wantErr          string
+	}{
+		{
+			name:          "adds the groups found by the lookup to the existing groups, removing duplicates",
+			key:           &fakeGroupEnrichmentKey{key: "ryan@example.com"},
+			lookupGroups:  []string{"ldap-group1", "existing-group"},
+			wantLookupKey: "ryan@example.com",
+			wantGroups:    []string{"existing-group", "ldap-group1"},
+		},
+		{
+			name:             "computes the key from the additional claims in the context",
+			key:              &fakeGroupEnrichmentKey{additionalClaimName: "email"},
+			additionalClaims: map[string]any{"email": "ryan@example.com"},
+			lookupGroups:     []string{"ldap-group1"},
+			wantLookupKey:    "ryan@example.com",
+			wantGroups:       []string{"existing-group", "ldap-group1"},
+		},
+		{
+			name:       "when there are no additional claims in the context",
+			key:        &fakeGroupEnrichmentKey{additionalClaimName: "email"},
+			wantGroups: []string{"existing-group"},
+		},
+		{
+			name:          "when the lookup finds no groups",
+			key:           &fakeGroupEnrichmentKey{key: "ryan@example.com"},
+			lookupGroups:  []string{},
+			wantLookupKey: "ryan@example.com",
+			wantGroups:    []string{"existing-group"},
+		},
+		{
+			name:       "when the key is empty, does not perform a lookup",
+			key:        &fakeGroupEnrichmentKey{key: ""},
+			wantGroups: []string{"existing-group"},
+		},
+		{
+			name:    "when the key cannot be computed",
+			key:     &fakeGroupEnrichmentKey{err: errors.New("some key error")},
+			wantErr: "identity transformation at index 0: group enrichment key: some key error",
+		},
+		{
+			name:          "when the lookup fails",
+			key:           &fakeGroupEnrichmentKey{key: "ryan@example.com"},
+			lookupErr:     errors.New("some lookup error"),
+			wantLookupKey: "ryan@example.com",
+			wantErr:       "identity transformation at index 0: group enrichment lookup: some lookup error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var actualLookupKey string
+			transform := NewGroupEnrichmentTransformation(tt.key, func(_ctx context.Context, key string) ([]string, error) {
+				actualLookupKey = key
+				return tt.lookupGroups, tt.lookupErr
+			})
+
+			pipeline := NewTransformationPipeline().WithTransformation(transform)
+			inputGroups := []string{"existing-group"}
+			result, err := pipeline.Evaluate(context.Background(), "ryan", inputGroups, tt.additionalClaims)
+
+			require.Equal(t, tt.wantLookupKey, actualLookupKey)
+			require.Equal(t, []string{"existing-group"}, inputGroups, "input groups should not be modified")
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, result)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "ryan", result.Username)
+			require.Equal(t, tt.wantGroups, result.Groups)
+			require.True(t, result.AuthenticationAllowed)
+			require.Equal(t, []any{&GroupEnrichmentSource{Key: tt.key.key}}, pipeline.Source())
+		})
+	}
+}
+
+func TestGroupEnrichmentTransformationRemovesDuplicateGroups(t *testing.T) {
+	transform := NewGroupEnrichmentTransformation(&fakeGroupEnrichmentKey{key: "ryan@example.com"}, func(_ context.Context, _ string) ([]string, error) {
+		return []string{"group-b", "group-a", "group-b"}, nil
+	})
+
+	result, err := transform.Evaluate(context.Background(), "ryan", []string{"group-a", "group-c"}, map[string]any{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"group-a", "group-b", "group-c"}, result.Groups)
+}
+
+func TestWithTransformationDoesNotModifyOriginalPipeline(t *testing.T) {
+	pipeline := NewTransformationPipeline()
+	pipeline.AppendTransformation(&fakeTransformerWithSource{source: "foo"})
+
+	newPipeline := pipeline.WithTransformation(&fakeTransformerWithSource{source: "bar"})
+
+	require.Equal(t, []any{"foo"}, pipeline.Source())
+	require.Equal(t, []any{"foo", "bar"}, newPipeline.Source())
+
+	var nilPipeline *TransformationPipeline
+	require.Equal(t, []any{"bar"}, nilPipeline.WithTransformation(&fakeTransformerWithSource{source: "bar"}).Source())
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ldapgroupcache caches the groups of LDAP and Active Directory users in Secrets, so the cached groups
+// can be used by all the Supervisor's pods.
+package ldapgroupcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/crud"
+)
+
+const (
+	TypeLabelValue = "ldap-group-cache"
+
+	ErrInvalidGroupCacheVersion = constable.Error("ldap group cache data has wrong version")
+
+	// Version 1 was the initial release of storage.
+	groupCacheStorageVersion = "1"
+)
+
+// Cache stores the groups of users, keyed by a string which identifies the user and the group search settings.
+type Cache struct {
+	storage crud.Storage
+	clock   func() time.Time
+}
+
+type cachedGroups struct {
+	Groups    []string  `json:"groups"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Version   string    `json:"version"`
+}
+
+func New(secrets corev1client.SecretInterface, clock func() time.Time) *Cache {
+	return &Cache{storage: crud.New(TypeLabelValue, secrets, clock), clock: clock}
+}
+
+// GetGroups returns the cached groups for the key. It returns false when there are no cached groups or when the
+// cached groups have expired. Note that an empty list of groups is also cached, in which case it returns true.
+func (c *Cache) GetGroups(ctx context.Context, key string) ([]string, bool, error) {
+	cached := &cachedGroups{}
+	_, err := c.storage.Get(ctx, signature(key), cached)
+	if apierrors.IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get cached groups: %w", err)
+	}
+
+	if cached.Version != groupCacheStorageVersion {
+		return nil, false, fmt.Errorf("%w: cached groups have version %s instead of %s",
+			ErrInvalidGroupCacheVersion, cached.Version, groupCacheStorageVersion)
+	}
+
+	// The garbage collector will eventually delete the Secret, but it may not have done so yet.
+	if !c.clock().Before(cached.ExpiresAt) {
+		return nil, false, nil
+	}
+
+	if cached.Groups == nil {
+		cached.Groups = []string{}
+	}
+	return cached.Groups, true, nil
+}
+
+// PutGroups caches the groups for the key for the duration of the ttl, replacing any previously cached groups.
+func (c *Cache) PutGroups(ctx context.Context, key string, groups []string, ttl time.Duration) error {
+	if groups == nil {
+		groups = []string{}
+	}
+	cached := &cachedGroups{Groups: groups, ExpiresAt: c.clock().Add(ttl), Version: groupCacheStorageVersion}
+
+	_, err := c.storage.Create(ctx, signature(key), cached, nil, nil, ttl)
+	if apierrors.IsAlreadyExists(err) {
+		// The previously cached groups have expired, or another pod has just cached the groups. Either way,
+		// replace them so the lifetime of the Secret, which cannot be updated, matches the new expiration time.
+		if err = c.storage.Delete(ctx, signature(key)); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete previously cached groups: %w", err)
+		}
+		_, err = c.storage.Create(ctx, signature(key), cached, nil, nil, ttl)
+	}
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to cache groups: %w", err)
+	}
+	return nil
+}
+
+// signature hashes the key, since the key may be too long or contain characters which cannot be used in the
+// name of a Secret, and since it may identify the user.
+func signature(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,134 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package ldapgroupcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	coretesting "k8s.io/client-go/testing"
+)
+
+const namespace = "test-ns"
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ttl := 10 * time.Minute
+
+	setup := func(t *testing.T) (*fake.Clientset, *Cache) {
+		t.Helper()
+		client := fake.NewSimpleClientset()
+		return client, New(client.CoreV1().Secrets(namespace), func() time.Time { return now })
+	}
+
+	listSecrets := func(t *testing.T, client *fake.Clientset) []corev1.Secret {
+		t.Helper()
+		list, err := client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		return list.Items
+	}
+
+	t.Run("returns false when nothing was cached", func(t *testing.T) {
+		_, cache := setup(t)
+		groups, found, err := cache.GetGroups(ctx, "some-key")
+		require.NoError(t, err)
+		require.False(t, found)
+		require.Nil(t, groups)
+	})
+
+	t.Run("caches groups in a Secret which will be garbage collected", func(t *testing.T) {
+		client, cache := setup(t)
+		require.NoError(t, cache.PutGroups(ctx, "some-key", []string{"group1", "group2"}, ttl))
+
+		secrets := listSecrets(t, client)
+		require.Len(t, secrets, 1)
+		require.Regexp(t, "^pinniped-storage-ldap-group-cache-[a-z0-9]+$", secrets[0].Name)
+		require.NotContains(t, secrets[0].Name, "some-key")
+		require.Equal(t, corev1.SecretType("storage.pinniped.dev/ldap-group-cache"), secrets[0].Type)
+		require.Equal(t, map[string]string{"storage.pinniped.dev/type": "ldap-group-cache"}, secrets[0].Labels)
+		require.Equal(t, map[string]string{"storage.pinniped.dev/garbage-collect-after": "2030-01-01T00:10:00Z"}, secrets[0].Annotations)
+		require.JSONEq(t,
+			`{"groups":["group1","group2"],"expiresAt":"2030-01-01T00:10:00Z","version":"1"}`,
+			string(secrets[0].Data["pinniped-storage-data"]))
+
+		groups, found, err := cache.GetGroups(ctx, "some-key")
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, []string{"group1", "group2"}, groups)
+
+		_, found, err = cache.GetGroups(ctx, "some-other-key")
+		require.NoError(t, err)
+		require.False(t, found)
+	})
+
+	t.Run("caches an empty list of groups", func(t *testing.T) {
+		_, cache := setup(t)
+		require.NoError(t, cache.PutGroups(ctx, "some-key", nil, ttl))
+
+		groups, found, err := cache.GetGroups(ctx, "some-key")
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, []string{}, groups)
+	})
+
+	t.Run("returns false after the groups have expired, and replaces them when they are cached again", func(t *testing.T) {
+		client, cache := setup(t)
+		require.NoError(t, cache.PutGroups(ctx, "some-key", []string{"group1"}, ttl))
+
+		now = now.Add(ttl)
+		t.Cleanup(func() { now = now.Add(-ttl) })
+
+		_, found, err := cache.GetGroups(ctx, "some-key")
+		require.NoError(t, err)
+		require.False(t, found)
+
+		require.NoError(t, cache.PutGroups(ctx, "some-key", []string{"group2"}, ttl))
+		groups, found, err := cache.GetGroups(ctx, "some-key")
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, []string{"group2"}, groups)
+
+		secrets := listSecrets(t, client)
+		require.Len(t, secrets, 1)
+		require.Equal(t, map[string]string{"storage.pinniped.dev/garbage-collect-after": "2030-01-01T00:20:00Z"}, secrets[0].Annotations)
+	})
+
+	t.Run("returns an error when the cached groups have the wrong version", func(t *testing.T) {
+		client, cache := setup(t)
+		require.NoError(t, cache.PutGroups(ctx, "some-key", []string{"group1"}, ttl))
+
+		secret := listSecrets(t, client)[0]
+		secret.Data["pinniped-storage-data"] = []byte(`{"groups":["group1"],"expiresAt":"2030-01-01T00:10:00Z","version":"0"}`)
+		_, err := client.CoreV1().Secrets(namespace).Update(ctx, &secret, metav1.UpdateOptions{})
+		require.NoError(t, err)
+
+		_, found, err := cache.GetGroups(ctx, "some-key")
+		require.EqualError(t, err, "ldap group cache data has wrong version: cached groups have version 0 instead of 1")
+		require.False(t, found)
+	})
+
+	t.Run("returns errors from the Kubernetes API", func(t *testing.T) {
+		client, cache := setup(t)
+		client.PrependReactor("*", "secrets", func(action coretesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("some api error")
+		})
+
+		_, found, err := cache.GetGroups(ctx, "some-key")
+		require.ErrorContains(t, err, "failed to get cached groups: failed to get ldap-group-cache for signature")
+		require.ErrorContains(t, err, "some api error")
+		require.False(t, found)
+
+		err = cache.PutGroups(ctx, "some-key", []string{"group1"}, ttl)
+		require.ErrorContains(t, err, "failed to cache groups: failed to create ldap-group-cache for signature")
+		require.ErrorContains(t, err, "some api error")
+	})
+}
@@ -60,6 +60,36 @@ func (mr *MockGitHubInterfaceMockRecorder) GetOrgMembership(arg0 any) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrgMembership", reflect.TypeOf((*MockGitHubInterface)(nil).GetOrgMembership), arg0)
 }
 
+// GetOrgRoles mocks base method.
+func (m *MockGitHubInterface) GetOrgRoles(arg0 context.Context, arg1 *setutil.CaseInsensitiveSet) ([]githubclient.OrgRoleInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrgRoles", arg0, arg1)
+	ret0, _ := ret[0].([]githubclient.OrgRoleInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrgRoles indicates an expected call of GetOrgRoles.
+func (mr *MockGitHubInterfaceMockRecorder) GetOrgRoles(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrgRoles", reflect.TypeOf((*MockGitHubInterface)(nil).GetOrgRoles), arg0, arg1)
+}
+
+// GetSAMLNameID mocks base method.
+func (m *MockGitHubInterface) GetSAMLNameID(arg0 context.Context, arg1 string, arg2 []string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSAMLNameID", arg0, arg1, arg2)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSAMLNameID indicates an expected call of GetSAMLNameID.
+func (mr *MockGitHubInterfaceMockRecorder) GetSAMLNameID(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSAMLNameID", reflect.TypeOf((*MockGitHubInterface)(nil).GetSAMLNameID), arg0, arg1, arg2)
+}
+
 // GetTeamMembership mocks base method.
 func (m *MockGitHubInterface) GetTeamMembership(arg0 context.Context, arg1 *setutil.CaseInsensitiveSet) ([]githubclient.TeamInfo, error) {
 	m.ctrl.T.Helper()
@@ -0,0 +1,6 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package mockgitlabclient
+
+//go:generate go run -v go.uber.org/mock/mockgen  -destination=mockgitlabclient.go -package=mockgitlabclient -copyright_file=../../../hack/header.txt go.pinniped.dev/internal/gitlabclient GitLabInterface
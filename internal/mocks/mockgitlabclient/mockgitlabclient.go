@@ -0,0 +1,75 @@
+// Copyright 2020-2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+//
+
+// Code generated by MockGen. DO NOT EDIT.
+// Source: go.pinniped.dev/internal/gitlabclient (interfaces: GitLabInterface)
+//
+// Generated by this command:
+//
+//	mockgen -destination=mockgitlabclient.go -package=mockgitlabclient -copyright_file=../../../hack/header.txt go.pinniped.dev/internal/gitlabclient GitLabInterface
+//
+
+// Package mockgitlabclient is a generated GoMock package.
+package mockgitlabclient
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gitlabclient "go.pinniped.dev/internal/gitlabclient"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockGitLabInterface is a mock of GitLabInterface interface.
+type MockGitLabInterface struct {
+	ctrl     *gomock.Controller
+	recorder *MockGitLabInterfaceMockRecorder
+}
+
+// MockGitLabInterfaceMockRecorder is the mock recorder for MockGitLabInterface.
+type MockGitLabInterfaceMockRecorder struct {
+	mock *MockGitLabInterface
+}
+
+// NewMockGitLabInterface creates a new mock instance.
+func NewMockGitLabInterface(ctrl *gomock.Controller) *MockGitLabInterface {
+	mock := &MockGitLabInterface{ctrl: ctrl}
+	mock.recorder = &MockGitLabInterfaceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockGitLabInterface) EXPECT() *MockGitLabInterfaceMockRecorder {
+	return m.recorder
+}
+
+// GetGroupMembership mocks base method.
+func (m *MockGitLabInterface) GetGroupMembership(arg0 context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetGroupMembership", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetGroupMembership indicates an expected call of GetGroupMembership.
+func (mr *MockGitLabInterfaceMockRecorder) GetGroupMembership(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGroupMembership", reflect.TypeOf((*MockGitLabInterface)(nil).GetGroupMembership), arg0)
+}
+
+// GetUserInfo mocks base method.
+func (m *MockGitLabInterface) GetUserInfo(arg0 context.Context) (*gitlabclient.UserInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserInfo", arg0)
+	ret0, _ := ret[0].(*gitlabclient.UserInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserInfo indicates an expected call of GetUserInfo.
+func (mr *MockGitLabInterfaceMockRecorder) GetUserInfo(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserInfo", reflect.TypeOf((*MockGitLabInterface)(nil).GetUserInfo), arg0)
+}
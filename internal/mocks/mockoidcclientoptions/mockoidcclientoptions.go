@@ -20,6 +20,7 @@ import (
 
 	v1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idpdiscovery/v1alpha1"
 	oidcclient "go.pinniped.dev/pkg/oidcclient"
+	dpop "go.pinniped.dev/pkg/oidcclient/dpop"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -74,6 +75,20 @@ func (mr *MockOIDCClientOptionsMockRecorder) WithContext(arg0 any) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithContext", reflect.TypeOf((*MockOIDCClientOptions)(nil).WithContext), arg0)
 }
 
+// WithDPoPKeyStore mocks base method.
+func (m *MockOIDCClientOptions) WithDPoPKeyStore(arg0 dpop.KeyStore) oidcclient.Option {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WithDPoPKeyStore", arg0)
+	ret0, _ := ret[0].(oidcclient.Option)
+	return ret0
+}
+
+// WithDPoPKeyStore indicates an expected call of WithDPoPKeyStore.
+func (mr *MockOIDCClientOptionsMockRecorder) WithDPoPKeyStore(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithDPoPKeyStore", reflect.TypeOf((*MockOIDCClientOptions)(nil).WithDPoPKeyStore), arg0)
+}
+
 // WithListenPort mocks base method.
 func (m *MockOIDCClientOptions) WithListenPort(arg0 uint16) oidcclient.Option {
 	m.ctrl.T.Helper()
@@ -4,6 +4,7 @@
 package phttp
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"net/http"
 	"time"
@@ -24,6 +25,16 @@ func Secure(rootCAs *x509.CertPool) *http.Client {
 	return buildClient(ptls.Secure, rootCAs)
 }
 
+// DefaultWithClientCertificate is like Default, but the returned client also presents the given certificate
+// when the server asks for a client certificate during the TLS handshake.
+func DefaultWithClientCertificate(rootCAs *x509.CertPool, clientCertificate tls.Certificate) *http.Client {
+	return buildClient(func(rootCAs *x509.CertPool) *tls.Config {
+		tlsConfig := ptls.Default(rootCAs)
+		tlsConfig.Certificates = []tls.Certificate{clientCertificate}
+		return tlsConfig
+	}, rootCAs)
+}
+
 func buildClient(tlsConfigFunc ptls.ConfigFunc, rootCAs *x509.CertPool) *http.Client {
 	baseRT := defaultTransport()
 	baseRT.TLSClientConfig = tlsConfigFunc(rootCAs)
@@ -8,13 +8,16 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/client-go/util/cert"
 
+	"go.pinniped.dev/internal/certauthority"
 	"go.pinniped.dev/internal/crypto/ptls"
 	"go.pinniped.dev/internal/testutil/tlsserver"
 )
@@ -104,6 +107,49 @@ func TestClient(t *testing.T) {
 	}
 }
 
+func TestDefaultWithClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	clientCA, err := certauthority.New("client-ca", time.Hour)
+	require.NoError(t, err)
+	clientCert, err := clientCA.IssueClientCert("some-client", nil, time.Hour)
+	require.NoError(t, err)
+
+	var sawClientCertificate bool
+	server, serverCA := tlsserver.TestServerIPv4(t, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		tlsserver.AssertTLS(t, r, ptls.Default)
+		assertUserAgent(t, r)
+		// use assert instead of require to not break the http.Handler with a panic
+		if assert.Len(t, r.TLS.PeerCertificates, 1) {
+			assert.Equal(t, "some-client", r.TLS.PeerCertificates[0].Subject.CommonName)
+			sawClientCertificate = true
+		}
+	}), func(server *httptest.Server) {
+		tlsserver.RecordTLSHello(server)
+		server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		server.TLS.ClientCAs = clientCA.Pool()
+	})
+
+	rootCAs, err := cert.NewPoolFromBytes(serverCA)
+	require.NoError(t, err)
+
+	c := DefaultWithClientCertificate(rootCAs, *clientCert)
+
+	tlsConfig, err := net.TLSClientConfig(c.Transport)
+	require.NoError(t, err)
+	require.Equal(t, rootCAs, tlsConfig.RootCAs)
+	require.Len(t, tlsConfig.Certificates, 1)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.True(t, sawClientCertificate)
+}
+
 func assertUserAgent(t *testing.T, r *http.Request) {
 	t.Helper()
 
@@ -66,6 +66,11 @@ type CustomSessionData struct {
 	// These will be RFC 2616-formatted errors with error code 299.
 	Warnings []string `json:"warnings"`
 
+	// DPoPJWKThumbprint is the base64url-encoded SHA-256 JWK thumbprint of the client's public key when the tokens
+	// of this session were bound to that key using DPoP (RFC 9449). When set, the client must send a DPoP proof
+	// signed by the same key when it uses the refresh token or exchanges the access token. Empty when not bound.
+	DPoPJWKThumbprint string `json:"dpopJWKThumbprint,omitempty"`
+
 	// Only used when ProviderType == "oidc".
 	OIDC *OIDCSessionData `json:"oidc,omitempty"`
 
@@ -77,6 +82,15 @@ type CustomSessionData struct {
 
 	// Only used when ProviderType == "github".
 	GitHub *GitHubSessionData `json:"github,omitempty"`
+
+	// Only used when ProviderType == "saml".
+	SAML *SAMLSessionData `json:"saml,omitempty"`
+
+	// Only used when ProviderType == "gitlab".
+	GitLab *GitLabSessionData `json:"gitlab,omitempty"`
+
+	// Only used when ProviderType == "oauth2".
+	OAuth2 *OAuth2SessionData `json:"oauth2,omitempty"`
 }
 
 type ProviderType string
@@ -86,6 +100,9 @@ const (
 	ProviderTypeLDAP            ProviderType = "ldap"
 	ProviderTypeActiveDirectory ProviderType = "activedirectory"
 	ProviderTypeGitHub          ProviderType = "github"
+	ProviderTypeSAML            ProviderType = "saml"
+	ProviderTypeGitLab          ProviderType = "gitlab"
+	ProviderTypeOAuth2          ProviderType = "oauth2"
 )
 
 // OIDCSessionData is the additional data needed by Pinniped when the upstream IDP is an OIDC provider.
@@ -153,6 +170,56 @@ func (s *GitHubSessionData) Clone() *GitHubSessionData {
 	return &dataCopy
 }
 
+// SAMLSessionData is the additional data needed by Pinniped when the upstream IDP is a SAML provider.
+type SAMLSessionData struct {
+	// NameID is the NameID of the subject of the SAML assertion from the user's initial login. We store this
+	// so that we can validate that the user's downstream subject does not change upon refresh.
+	NameID string `json:"nameID"`
+
+	// SessionNotOnOrAfter is the SessionNotOnOrAfter of the authentication statement of the SAML assertion from
+	// the user's initial login, if the upstream provider included one. Downstream refreshes will be rejected
+	// after this time. The zero value means that the upstream provider did not limit the session.
+	SessionNotOnOrAfter time.Time `json:"sessionNotOnOrAfter"`
+}
+
+func (s *SAMLSessionData) Clone() *SAMLSessionData {
+	dataCopy := *s // this shortcut works because all fields in this type are currently strings or time.Time values (no pointers)
+	return &dataCopy
+}
+
+// GitLabSessionData is the additional data needed by Pinniped when the upstream IDP is a GitLab provider.
+type GitLabSessionData struct {
+	// UpstreamAccessToken is the most recent access token returned by GitLab. It is used to call the GitLab API.
+	UpstreamAccessToken string `json:"upstreamAccessToken"`
+
+	// UpstreamRefreshToken is the most recent refresh token returned by GitLab. Unlike GitHub, GitLab access tokens
+	// expire after a short time, so the refresh token is used to get a new access token during downstream refresh.
+	// GitLab rotates refresh tokens upon use, so this value changes after each successful upstream refresh.
+	UpstreamRefreshToken string `json:"upstreamRefreshToken"`
+}
+
+func (s *GitLabSessionData) Clone() *GitLabSessionData {
+	dataCopy := *s // this shortcut works because all fields in this type are currently strings (no pointers)
+	return &dataCopy
+}
+
+// OAuth2SessionData is the additional data needed by Pinniped when the upstream IDP is a generic OAuth2 provider.
+type OAuth2SessionData struct {
+	// UpstreamAccessToken is the most recent access token returned by the upstream provider.
+	// It is used to call the configured user info APIs.
+	UpstreamAccessToken string `json:"upstreamAccessToken"`
+
+	// UpstreamRefreshToken is the most recent refresh token returned by the upstream provider, if any.
+	// Not all OAuth2 providers issue refresh tokens. When it is empty, the access token is used as-is during
+	// downstream refresh, which will fail once the upstream provider considers the access token to be expired.
+	UpstreamRefreshToken string `json:"upstreamRefreshToken,omitempty"`
+}
+
+func (s *OAuth2SessionData) Clone() *OAuth2SessionData {
+	dataCopy := *s // this shortcut works because all fields in this type are currently strings (no pointers)
+	return &dataCopy
+}
+
 // NewPinnipedSession returns a new empty session.
 func NewPinnipedSession() *PinnipedSession {
 	return &PinnipedSession{
@@ -22,6 +22,7 @@ import (
 
 	loginapi "go.pinniped.dev/generated/latest/apis/concierge/login"
 	"go.pinniped.dev/internal/clientcertissuer"
+	"go.pinniped.dev/internal/controller/authenticator"
 )
 
 // clientCertificateTTL is the TTL for short-lived client certificates returned by this API.
@@ -183,7 +184,7 @@ func isUserInfoValid(userInfo user.Info) bool {
 	case userInfo == nil, // must be non-nil
 		len(userInfo.GetName()) == 0,  // must have a username, groups are optional
 		len(userInfo.GetUID()) != 0,   // certs cannot assert UID
-		len(userInfo.GetExtra()) != 0: // certs cannot assert extra
+		len(userInfo.GetExtra()) != 0: // certs cannot assert extra, which includes the identity of an actor
 		return false
 
 	default:
@@ -191,18 +192,37 @@ func isUserInfoValid(userInfo user.Info) bool {
 	}
 }
 
+// actorFromExtra returns the single value of the given actor key of the extra info, or an empty string.
+// Certs cannot assert the actor, so credentials are not issued for tokens with an actor, but the actor is logged.
+func actorFromExtra(extra map[string][]string, key string) string {
+	if values := extra[key]; len(values) == 1 {
+		return values[0]
+	}
+	return ""
+}
+
 func traceSuccess(t *trace.Trace, userInfo user.Info, authenticated bool) {
 	userID := "<none>"
 	hasExtra := false
+	var actorSubject, actorUsername string
 	if userInfo != nil {
 		userID = userInfo.GetUID()
 		hasExtra = len(userInfo.GetExtra()) > 0
+		actorSubject = actorFromExtra(userInfo.GetExtra(), authenticator.ActorSubjectExtraKey)
+		actorUsername = actorFromExtra(userInfo.GetExtra(), authenticator.ActorUsernameExtraKey)
 	}
-	t.Step("success",
-		trace.Field{Key: "userID", Value: userID},
-		trace.Field{Key: "hasExtra", Value: hasExtra},
-		trace.Field{Key: "authenticated", Value: authenticated},
-	)
+	fields := []trace.Field{
+		{Key: "userID", Value: userID},
+		{Key: "hasExtra", Value: hasExtra},
+		{Key: "authenticated", Value: authenticated},
+	}
+	if actorSubject != "" || actorUsername != "" {
+		fields = append(fields,
+			trace.Field{Key: "actorSubject", Value: actorSubject},
+			trace.Field{Key: "actorUsername", Value: actorUsername},
+		)
+	}
+	t.Step("success", fields...)
 }
 
 func traceValidationFailure(t *trace.Trace, msg string) {
@@ -212,6 +212,50 @@ func TestCreate(t *testing.T) {
 			requireOneLogStatement(r, logger, `"success" userID:test-uid,hasExtra:false,authenticated:false`)
 		})
 
+		it("CreateSucceedsWithAnUnauthenticatedStatusWhenTheAuthenticatorReturnsAUserWithAnActor", func() {
+			req := validCredentialRequest()
+
+			requestAuthenticator := mockcredentialrequest.NewMockTokenCredentialRequestAuthenticator(ctrl)
+			requestAuthenticator.EXPECT().AuthenticateTokenCredentialRequest(gomock.Any(), req).
+				Return(&user.DefaultInfo{
+					Name:   "test-user",
+					Groups: []string{"test-group-1", "test-group-2"},
+					Extra: map[string][]string{
+						"authentication.concierge.pinniped.dev/actor-subject":  {"test-actor-subject"},
+						"authentication.concierge.pinniped.dev/actor-username": {"test-actor"},
+					},
+				}, nil)
+
+			// The cert could not identify the actor, so no cert is issued.
+			storage := NewREST(requestAuthenticator, nil, schema.GroupResource{})
+
+			response, err := callCreate(context.Background(), storage, req)
+
+			requireSuccessfulResponseWithAuthenticationFailureMessage(t, err, response)
+			requireOneLogStatement(r, logger, `"success" userID:,hasExtra:true,authenticated:false,actorSubject:test-actor-subject,actorUsername:test-actor`)
+		})
+
+		it("CreateSucceedsWithAnUnauthenticatedStatusWhenWebhookReturnsAUserWithAnActorAndOtherExtra", func() {
+			req := validCredentialRequest()
+
+			requestAuthenticator := mockcredentialrequest.NewMockTokenCredentialRequestAuthenticator(ctrl)
+			requestAuthenticator.EXPECT().AuthenticateTokenCredentialRequest(gomock.Any(), req).
+				Return(&user.DefaultInfo{
+					Name: "test-user",
+					Extra: map[string][]string{
+						"authentication.concierge.pinniped.dev/actor-username": {"test-actor"},
+						"test-key": {"test-val-1"},
+					},
+				}, nil)
+
+			storage := NewREST(requestAuthenticator, nil, schema.GroupResource{})
+
+			response, err := callCreate(context.Background(), storage, req)
+
+			requireSuccessfulResponseWithAuthenticationFailureMessage(t, err, response)
+			requireOneLogStatement(r, logger, `"success" userID:,hasExtra:true,authenticated:false,actorSubject:,actorUsername:test-actor`)
+		})
+
 		it("CreateSucceedsWithAnUnauthenticatedStatusWhenWebhookReturnsAUserWithExtra", func() {
 			req := validCredentialRequest()
 
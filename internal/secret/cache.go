@@ -20,6 +20,7 @@ type federationDomainCache struct {
 	tokenHMACKey         atomic.Value
 	stateEncoderHashKey  atomic.Value
 	stateEncoderBlockKey atomic.Value
+	totpEncryptionKey    atomic.Value
 }
 
 func (c *Cache) GetCSRFCookieEncoderHashKey() []byte {
@@ -54,6 +55,14 @@ func (c *Cache) SetStateEncoderBlockKey(oidcIssuer string, key []byte) {
 	c.getFederationDomainCache(oidcIssuer).stateEncoderBlockKey.Store(key)
 }
 
+func (c *Cache) GetTOTPEncryptionKey(oidcIssuer string) []byte {
+	return bytesOrNil(c.getFederationDomainCache(oidcIssuer).totpEncryptionKey.Load())
+}
+
+func (c *Cache) SetTOTPEncryptionKey(oidcIssuer string, key []byte) {
+	c.getFederationDomainCache(oidcIssuer).totpEncryptionKey.Store(key)
+}
+
 func (c *Cache) getFederationDomainCache(oidcIssuer string) *federationDomainCache {
 	value, ok := c.federationDomainCacheMap.Load(oidcIssuer)
 	if !ok {
@@ -23,6 +23,7 @@ var (
 	stateEncoderHashKey      = []byte("state-encoder-hash-key")
 	otherStateEncoderHashKey = []byte("other-state-encoder-hash-key")
 	stateEncoderBlockKey     = []byte("state-encoder-block-key")
+	totpEncryptionKey        = []byte("totp-encryption-key")
 )
 
 func TestCache(t *testing.T) {
@@ -33,6 +34,7 @@ func TestCache(t *testing.T) {
 	require.Nil(t, c.GetTokenHMACKey(issuer))
 	require.Nil(t, c.GetStateEncoderHashKey(issuer))
 	require.Nil(t, c.GetStateEncoderBlockKey(issuer))
+	require.Nil(t, c.GetTOTPEncryptionKey(issuer))
 
 	// Validate we get some nil and non-nil values when some stuff exists.
 	c.SetCSRFCookieEncoderHashKey(csrfCookieEncoderHashKey)
@@ -47,15 +49,18 @@ func TestCache(t *testing.T) {
 	c.SetTokenHMACKey(issuer, tokenHMACKey)
 	c.SetStateEncoderHashKey(issuer, otherStateEncoderHashKey)
 	c.SetStateEncoderBlockKey(issuer, stateEncoderBlockKey)
+	c.SetTOTPEncryptionKey(issuer, totpEncryptionKey)
 	require.Equal(t, csrfCookieEncoderHashKey, c.GetCSRFCookieEncoderHashKey())
 	require.Equal(t, tokenHMACKey, c.GetTokenHMACKey(issuer))
 	require.Equal(t, otherStateEncoderHashKey, c.GetStateEncoderHashKey(issuer))
 	require.Equal(t, stateEncoderBlockKey, c.GetStateEncoderBlockKey(issuer))
+	require.Equal(t, totpEncryptionKey, c.GetTOTPEncryptionKey(issuer))
 
 	// Validate that stuff is still nil for an unknown issuer.
 	require.Nil(t, c.GetTokenHMACKey(otherIssuer))
 	require.Nil(t, c.GetStateEncoderHashKey(otherIssuer))
 	require.Nil(t, c.GetStateEncoderBlockKey(otherIssuer))
+	require.Nil(t, c.GetTOTPEncryptionKey(otherIssuer))
 }
 
 // TestCacheSynchronized should mimic the behavior of an FederationDomain: multiple goroutines
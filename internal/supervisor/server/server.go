@@ -53,9 +53,12 @@ import (
 	"go.pinniped.dev/internal/controller/supervisorconfig/activedirectoryupstreamwatcher"
 	"go.pinniped.dev/internal/controller/supervisorconfig/generator"
 	"go.pinniped.dev/internal/controller/supervisorconfig/githubupstreamwatcher"
+	"go.pinniped.dev/internal/controller/supervisorconfig/gitlabupstreamwatcher"
 	"go.pinniped.dev/internal/controller/supervisorconfig/ldapupstreamwatcher"
+	"go.pinniped.dev/internal/controller/supervisorconfig/oauth2upstreamwatcher"
 	"go.pinniped.dev/internal/controller/supervisorconfig/oidcclientwatcher"
 	"go.pinniped.dev/internal/controller/supervisorconfig/oidcupstreamwatcher"
+	"go.pinniped.dev/internal/controller/supervisorconfig/samlupstreamwatcher"
 	"go.pinniped.dev/internal/controller/supervisorstorage"
 	"go.pinniped.dev/internal/controllerinit"
 	"go.pinniped.dev/internal/controllerlib"
@@ -66,9 +69,13 @@ import (
 	"go.pinniped.dev/internal/federationdomain/dynamictlscertprovider"
 	"go.pinniped.dev/internal/federationdomain/dynamicupstreamprovider"
 	"go.pinniped.dev/internal/federationdomain/endpoints/jwks"
+	"go.pinniped.dev/internal/federationdomain/endpoints/registration"
 	"go.pinniped.dev/internal/federationdomain/endpointsmanager"
+	"go.pinniped.dev/internal/federationdomain/loginlimiter"
+	"go.pinniped.dev/internal/githubclient"
 	"go.pinniped.dev/internal/groupsuffix"
 	"go.pinniped.dev/internal/kubeclient"
+	"go.pinniped.dev/internal/ldapgroupcache"
 	"go.pinniped.dev/internal/leaderelection"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/pversion"
@@ -149,6 +156,7 @@ func prepareControllers(
 	pinnipedInformers supervisorinformers.SharedInformerFactory,
 	leaderElector controllerinit.RunnerWrapper,
 	podInfo *downward.PodInfo,
+	ldapGroupCache *ldapgroupcache.Cache,
 ) controllerinit.RunnerBuilder {
 	const certificateName string = "pinniped-supervisor-api-tls-serving-certificate"
 	clientSecretSupervisorGroupData := groupsuffix.SupervisorAggregatedGroups(*cfg.APIGroupSuffix)
@@ -181,6 +189,11 @@ func prepareControllers(
 				pinnipedInformers.IDP().V1alpha1().LDAPIdentityProviders(),
 				pinnipedInformers.IDP().V1alpha1().ActiveDirectoryIdentityProviders(),
 				pinnipedInformers.IDP().V1alpha1().GitHubIdentityProviders(),
+				pinnipedInformers.IDP().V1alpha1().SAMLIdentityProviders(),
+				pinnipedInformers.IDP().V1alpha1().GitLabIdentityProviders(),
+				pinnipedInformers.IDP().V1alpha1().OAuth2IdentityProviders(),
+				configMapInformer,
+				secretInformer,
 				controllerlib.WithInformer,
 			),
 			singletonWorker,
@@ -299,6 +312,29 @@ func prepareControllers(
 			),
 			singletonWorker,
 		).
+		WithController(
+			generator.NewFederationDomainSecretsController(
+				generator.NewSymmetricSecretHelper(
+					"pinniped-oidc-provider-totp-encryption-key-",
+					cfg.Labels,
+					rand.Reader,
+					generator.SecretUsageTOTPEncryptionKey,
+					func(federationDomainIssuer string, symmetricKey []byte) {
+						plog.Debug("setting totp encryption key", "issuer", federationDomainIssuer)
+						secretCache.SetTOTPEncryptionKey(federationDomainIssuer, symmetricKey)
+					},
+				),
+				func(fd *supervisorconfigv1alpha1.FederationDomainStatus) *corev1.LocalObjectReference {
+					return &fd.Secrets.TOTPEncryptionKey
+				},
+				kubeClient,
+				pinnipedClient,
+				secretInformer,
+				federationDomainInformer,
+				controllerlib.WithInformer,
+			),
+			singletonWorker,
+		).
 		WithController(
 			oidcupstreamwatcher.New(
 				dynamicUpstreamIDPProvider,
@@ -318,6 +354,7 @@ func prepareControllers(
 				pinnipedInformers.IDP().V1alpha1().LDAPIdentityProviders(),
 				secretInformer,
 				configMapInformer,
+				ldapGroupCache,
 				controllerlib.WithInformer,
 			),
 			singletonWorker).
@@ -328,6 +365,7 @@ func prepareControllers(
 				pinnipedInformers.IDP().V1alpha1().ActiveDirectoryIdentityProviders(),
 				secretInformer,
 				configMapInformer,
+				ldapGroupCache,
 				controllerlib.WithInformer,
 			),
 			singletonWorker).
@@ -344,6 +382,51 @@ func prepareControllers(
 				clock.RealClock{},
 				tls.Dial,
 				cache.NewExpiring(),
+				githubclient.NewResponseCaches(clock.RealClock{}),
+			),
+			singletonWorker).
+		WithController(
+			samlupstreamwatcher.New(
+				podInfo.Namespace,
+				dynamicUpstreamIDPProvider,
+				pinnipedClient,
+				pinnipedInformers.IDP().V1alpha1().SAMLIdentityProviders(),
+				secretInformer,
+				configMapInformer,
+				plog.New(),
+				controllerlib.WithInformer,
+				clock.RealClock{},
+				cache.NewExpiring(),
+			),
+			singletonWorker).
+		WithController(
+			gitlabupstreamwatcher.New(
+				podInfo.Namespace,
+				dynamicUpstreamIDPProvider,
+				pinnipedClient,
+				pinnipedInformers.IDP().V1alpha1().GitLabIdentityProviders(),
+				secretInformer,
+				configMapInformer,
+				plog.New(),
+				controllerlib.WithInformer,
+				clock.RealClock{},
+				tls.Dial,
+				cache.NewExpiring(),
+			),
+			singletonWorker).
+		WithController(
+			oauth2upstreamwatcher.New(
+				podInfo.Namespace,
+				dynamicUpstreamIDPProvider,
+				pinnipedClient,
+				pinnipedInformers.IDP().V1alpha1().OAuth2IdentityProviders(),
+				secretInformer,
+				configMapInformer,
+				plog.New(),
+				controllerlib.WithInformer,
+				clock.RealClock{},
+				tls.Dial,
+				cache.NewExpiring(),
 			),
 			singletonWorker).
 		WithController(
@@ -475,6 +558,23 @@ func runSupervisor(ctx context.Context, podInfo *downward.PodInfo, cfg *supervis
 	dynamicUpstreamIDPProvider := dynamicupstreamprovider.NewDynamicUpstreamIDPProvider()
 	secretCache := secret.Cache{}
 
+	loginLimiterConfig, err := getLoginLimiterConfig(cfg.LoginRateLimits)
+	if err != nil {
+		return fmt.Errorf("could not configure login rate limits: %w", err)
+	}
+
+	// Registrations may be handled by any Supervisor pod, so the registration endpoints use clients which
+	// are allowed to write when this pod is not the leader.
+	oidcClientsAPIGroup, _ := groupsuffix.Replace(supervisorconfigv1alpha1.SchemeGroupVersion.Group, *cfg.APIGroupSuffix)
+	registrationClients := &registration.Clients{
+		OIDCClients:          clientWithoutLeaderElection.PinnipedSupervisor.ConfigV1alpha1().OIDCClients(serverInstallationNamespace),
+		Secrets:              clientWithoutLeaderElection.Kubernetes.CoreV1().Secrets(serverInstallationNamespace),
+		TokenReviews:         clientWithoutLeaderElection.Kubernetes.AuthenticationV1().TokenReviews(),
+		SubjectAccessReviews: clientWithoutLeaderElection.Kubernetes.AuthorizationV1().SubjectAccessReviews(),
+		Namespace:            serverInstallationNamespace,
+		OIDCClientsAPIGroup:  oidcClientsAPIGroup,
+	}
+
 	// OIDC endpoints will be served by the endpoints manager, and any non-OIDC paths will fallback to the healthMux.
 	oidProvidersManager := endpointsmanager.NewManager(
 		healthMux,
@@ -483,6 +583,8 @@ func runSupervisor(ctx context.Context, podInfo *downward.PodInfo, cfg *supervis
 		&secretCache,
 		clientWithoutLeaderElection.Kubernetes.CoreV1().Secrets(serverInstallationNamespace), // writes to kube storage are allowed for non-leaders
 		client.PinnipedSupervisor.ConfigV1alpha1().OIDCClients(serverInstallationNamespace),
+		loginLimiterConfig,
+		registrationClients,
 	)
 
 	// Get the "real" name of the client secret supervisor API group (i.e., the API group name with the
@@ -505,6 +607,8 @@ func runSupervisor(ctx context.Context, podInfo *downward.PodInfo, cfg *supervis
 		pinnipedInformers,
 		leaderElector,
 		podInfo,
+		// Refreshes can be performed by any pod, so writes to the group cache are allowed for non-leaders.
+		ldapgroupcache.New(clientWithoutLeaderElection.Kubernetes.CoreV1().Secrets(serverInstallationNamespace), time.Now),
 	)
 
 	shutdown := &sync.WaitGroup{}
@@ -701,6 +805,35 @@ func getAggregatedAPIServerConfig(
 	return apiServerConfig, nil
 }
 
+// getLoginLimiterConfig returns the limits of failed password logins, or nil when they are disabled.
+func getLoginLimiterConfig(spec supervisor.LoginRateLimitsSpec) (*loginlimiter.Config, error) {
+	if spec.Disabled {
+		return nil, nil
+	}
+
+	limit := func(limitSpec supervisor.LoginRateLimitSpec) loginlimiter.Limit {
+		return loginlimiter.Limit{
+			AllowedFailures: int(*limitSpec.AllowedFailures),
+			InitialBackoff:  time.Duration(*limitSpec.InitialBackoffSeconds) * time.Second,
+			MaxBackoff:      time.Duration(*limitSpec.MaxBackoffSeconds) * time.Second,
+		}
+	}
+
+	config := &loginlimiter.Config{
+		PerUsername: limit(spec.PerUsername),
+		PerSourceIP: limit(spec.PerSourceIP),
+	}
+	for _, cidr := range spec.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		config.TrustedProxies = append(config.TrustedProxies, network)
+	}
+
+	return config, nil
+}
+
 func maybeSetupUnixPerms(endpoint *supervisor.Endpoint, pod *corev1.Pod) func() error {
 	if endpoint.Network != supervisor.NetworkUnix {
 		return func() error { return nil }
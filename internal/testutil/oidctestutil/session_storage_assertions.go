@@ -323,7 +323,8 @@ func castStoredAuthorizeRequest(t *testing.T, storedAuthorizeRequest fosite.Requ
 
 // FilterClientSecretCreateActions ignores any reads made to get a storage secret corresponding to an OIDCClient, since these
 // are normal actions when the request is using a dynamic client's client_id, and we don't need to make assertions
-// about these Secrets since they are not related to session storage.
+// about these Secrets since they are not related to session storage. It also ignores reads of the end user's consent
+// grant, which are made when the dynamic client requires consent.
 func FilterClientSecretCreateActions(actions []kubetesting.Action) []kubetesting.Action {
 	filtered := make([]kubetesting.Action, 0, len(actions))
 	for _, action := range actions {
@@ -332,6 +333,18 @@ func FilterClientSecretCreateActions(actions []kubetesting.Action) []kubetesting
 			if strings.HasPrefix(getAction.GetName(), "pinniped-storage-oidc-client-secret-") {
 				continue // filter out OIDCClient's storage secret reads
 			}
+			if strings.HasPrefix(getAction.GetName(), "pinniped-storage-consent-grant-") {
+				continue // filter out consent grant reads
+			}
+			if strings.HasPrefix(getAction.GetName(), "pinniped-storage-pushed-authorization-request-") {
+				continue // filter out pushed authorization request reads
+			}
+		}
+		if action.Matches("delete", "secrets") {
+			deleteAction := action.(kubetesting.DeleteAction)
+			if strings.HasPrefix(deleteAction.GetName(), "pinniped-storage-pushed-authorization-request-") {
+				continue // filter out the deletion of pushed authorization requests after they are used
+			}
 		}
 		filtered = append(filtered, action) // otherwise include the action
 	}
@@ -0,0 +1,323 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidctestutil
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+	"k8s.io/apimachinery/pkg/types"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/idtransform"
+	oidcpkce "go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+// ExchangeGitLabAuthcodeArgs is used to spy on calls to
+// TestUpstreamGitLabIdentityProvider.ExchangeAuthcodeFunc().
+type ExchangeGitLabAuthcodeArgs struct {
+	Ctx              context.Context
+	Authcode         string
+	PKCECodeVerifier oidcpkce.Code
+	RedirectURI      string
+}
+
+// PerformGitLabRefreshArgs is used to spy on calls to
+// TestUpstreamGitLabIdentityProvider.PerformRefreshFunc().
+type PerformGitLabRefreshArgs struct {
+	Ctx          context.Context
+	RefreshToken string
+}
+
+type TestUpstreamGitLabIdentityProviderBuilder struct {
+	name                           string
+	resourceUID                    types.UID
+	clientID                       string
+	scopes                         []string
+	displayNameForFederationDomain string
+	transformsForFederationDomain  *idtransform.TransformationPipeline
+	usernameAttribute              idpv1alpha1.GitLabUsernameAttribute
+	groupNameAttribute             idpv1alpha1.GitLabGroupNameAttribute
+	allowedGroups                  []string
+	flattenSubgroups               bool
+	authorizationURL               string
+	authcodeExchangeErr            error
+	token                          *oauth2.Token
+	performRefreshErr              error
+	refreshedToken                 *oauth2.Token
+	getUserErr                     error
+	getUserUser                    *upstreamprovider.GitLabUser
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithName(value string) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.name = value
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithResourceUID(value types.UID) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.resourceUID = value
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithClientID(value string) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.clientID = value
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithScopes(value []string) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.scopes = value
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithDisplayNameForFederationDomain(value string) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.displayNameForFederationDomain = value
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithUsernameAttribute(value idpv1alpha1.GitLabUsernameAttribute) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.usernameAttribute = value
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithGroupNameAttribute(value idpv1alpha1.GitLabGroupNameAttribute) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.groupNameAttribute = value
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithAllowedGroups(value []string) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.allowedGroups = value
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithFlattenSubgroups(value bool) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.flattenSubgroups = value
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithAuthorizationURL(value string) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.authorizationURL = value
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithToken(token *oauth2.Token) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.token = token
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithAuthcodeExchangeError(err error) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.authcodeExchangeErr = err
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithRefreshedToken(token *oauth2.Token) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.refreshedToken = token
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithPerformRefreshError(err error) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.performRefreshErr = err
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithUser(user *upstreamprovider.GitLabUser) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.getUserUser = user
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithGetUserError(err error) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.getUserErr = err
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) WithTransformsForFederationDomain(transforms *idtransform.TransformationPipeline) *TestUpstreamGitLabIdentityProviderBuilder {
+	u.transformsForFederationDomain = transforms
+	return u
+}
+
+func (u *TestUpstreamGitLabIdentityProviderBuilder) Build() *TestUpstreamGitLabIdentityProvider {
+	if u.displayNameForFederationDomain == "" {
+		// default it to the CR name
+		u.displayNameForFederationDomain = u.name
+	}
+	if u.transformsForFederationDomain == nil {
+		// default to an empty pipeline
+		u.transformsForFederationDomain = idtransform.NewTransformationPipeline()
+	}
+	return &TestUpstreamGitLabIdentityProvider{
+		Name:                           u.name,
+		ClientID:                       u.clientID,
+		ResourceUID:                    u.resourceUID,
+		Scopes:                         u.scopes,
+		DisplayNameForFederationDomain: u.displayNameForFederationDomain,
+		TransformsForFederationDomain:  u.transformsForFederationDomain,
+		UsernameAttribute:              u.usernameAttribute,
+		GroupNameAttribute:             u.groupNameAttribute,
+		AllowedGroups:                  u.allowedGroups,
+		FlattenSubgroups:               u.flattenSubgroups,
+		AuthorizationURL:               u.authorizationURL,
+		GetUserFunc: func(ctx context.Context, accessToken string) (*upstreamprovider.GitLabUser, error) {
+			if u.getUserErr != nil {
+				return nil, u.getUserErr
+			}
+			return u.getUserUser, nil
+		},
+		ExchangeAuthcodeFunc: func(ctx context.Context, authcode string) (*oauth2.Token, error) {
+			if u.authcodeExchangeErr != nil {
+				return nil, u.authcodeExchangeErr
+			}
+			return u.token, nil
+		},
+		PerformRefreshFunc: func(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+			if u.performRefreshErr != nil {
+				return nil, u.performRefreshErr
+			}
+			return u.refreshedToken, nil
+		},
+	}
+}
+
+func NewTestUpstreamGitLabIdentityProviderBuilder() *TestUpstreamGitLabIdentityProviderBuilder {
+	return &TestUpstreamGitLabIdentityProviderBuilder{}
+}
+
+type TestUpstreamGitLabIdentityProvider struct {
+	Name                           string
+	ClientID                       string
+	ResourceUID                    types.UID
+	Scopes                         []string
+	DisplayNameForFederationDomain string
+	TransformsForFederationDomain  *idtransform.TransformationPipeline
+	UsernameAttribute              idpv1alpha1.GitLabUsernameAttribute
+	GroupNameAttribute             idpv1alpha1.GitLabGroupNameAttribute
+	AllowedGroups                  []string
+	FlattenSubgroups               bool
+	AuthorizationURL               string
+	GetUserFunc                    func(ctx context.Context, accessToken string) (*upstreamprovider.GitLabUser, error)
+	ExchangeAuthcodeFunc           func(ctx context.Context, authcode string) (*oauth2.Token, error)
+	PerformRefreshFunc             func(ctx context.Context, refreshToken string) (*oauth2.Token, error)
+
+	// Fields for tracking actual calls make to mock functions.
+	exchangeAuthcodeCallCount int
+	exchangeAuthcodeArgs      []*ExchangeGitLabAuthcodeArgs
+	performRefreshCallCount   int
+	performRefreshArgs        []*PerformGitLabRefreshArgs
+	getUserCallCount          int
+	getUserArgs               []*GetUserArgs
+}
+
+var _ upstreamprovider.UpstreamGitLabIdentityProviderI = &TestUpstreamGitLabIdentityProvider{}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetResourceUID() types.UID {
+	return u.ResourceUID
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetResourceName() string {
+	return u.Name
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetScopes() []string {
+	return u.Scopes
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetClientID() string {
+	return u.ClientID
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetUsernameAttribute() idpv1alpha1.GitLabUsernameAttribute {
+	return u.UsernameAttribute
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetGroupNameAttribute() idpv1alpha1.GitLabGroupNameAttribute {
+	return u.GroupNameAttribute
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetAllowedGroups() []string {
+	return u.AllowedGroups
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetFlattenSubgroups() bool {
+	return u.FlattenSubgroups
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetAuthorizationURL() string {
+	return u.AuthorizationURL
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) ExchangeAuthcode(
+	ctx context.Context,
+	authcode string,
+	pkceCodeVerifier oidcpkce.Code,
+	redirectURI string,
+) (*oauth2.Token, error) {
+	if u.exchangeAuthcodeArgs == nil {
+		u.exchangeAuthcodeArgs = make([]*ExchangeGitLabAuthcodeArgs, 0)
+	}
+	u.exchangeAuthcodeCallCount++
+	u.exchangeAuthcodeArgs = append(u.exchangeAuthcodeArgs, &ExchangeGitLabAuthcodeArgs{
+		Ctx:              ctx,
+		Authcode:         authcode,
+		PKCECodeVerifier: pkceCodeVerifier,
+		RedirectURI:      redirectURI,
+	})
+	return u.ExchangeAuthcodeFunc(ctx, authcode)
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) ExchangeAuthcodeCallCount() int {
+	return u.exchangeAuthcodeCallCount
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) ExchangeAuthcodeArgs(call int) *ExchangeGitLabAuthcodeArgs {
+	if u.exchangeAuthcodeArgs == nil {
+		u.exchangeAuthcodeArgs = make([]*ExchangeGitLabAuthcodeArgs, 0)
+	}
+	return u.exchangeAuthcodeArgs[call]
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) PerformRefresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	if u.performRefreshArgs == nil {
+		u.performRefreshArgs = make([]*PerformGitLabRefreshArgs, 0)
+	}
+	u.performRefreshCallCount++
+	u.performRefreshArgs = append(u.performRefreshArgs, &PerformGitLabRefreshArgs{
+		Ctx:          ctx,
+		RefreshToken: refreshToken,
+	})
+	return u.PerformRefreshFunc(ctx, refreshToken)
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) PerformRefreshCallCount() int {
+	return u.performRefreshCallCount
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) PerformRefreshArgs(call int) *PerformGitLabRefreshArgs {
+	if u.performRefreshArgs == nil {
+		u.performRefreshArgs = make([]*PerformGitLabRefreshArgs, 0)
+	}
+	return u.performRefreshArgs[call]
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetUser(ctx context.Context, accessToken string, idpDisplayName string) (*upstreamprovider.GitLabUser, error) {
+	if u.getUserArgs == nil {
+		u.getUserArgs = make([]*GetUserArgs, 0)
+	}
+	u.getUserCallCount++
+	u.getUserArgs = append(u.getUserArgs, &GetUserArgs{
+		Ctx:            ctx,
+		AccessToken:    accessToken,
+		IDPDisplayName: idpDisplayName,
+	})
+	return u.GetUserFunc(ctx, accessToken)
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetUserCallCount() int {
+	return u.getUserCallCount
+}
+
+func (u *TestUpstreamGitLabIdentityProvider) GetUserArgs(call int) *GetUserArgs {
+	if u.getUserArgs == nil {
+		u.getUserArgs = make([]*GetUserArgs, 0)
+	}
+	return u.getUserArgs[call]
+}
@@ -5,6 +5,7 @@ package oidctestutil
 
 import (
 	"context"
+	"errors"
 	"net/url"
 
 	"k8s.io/apimachinery/pkg/types"
@@ -12,6 +13,7 @@ import (
 	"go.pinniped.dev/internal/authenticators"
 	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
 	"go.pinniped.dev/internal/idtransform"
+	"go.pinniped.dev/internal/totp"
 )
 
 type PerformLDAPRefreshArgs struct {
@@ -31,8 +33,12 @@ type TestUpstreamLDAPIdentityProviderBuilder struct {
 	authenticateFunc               func(ctx context.Context, username, password string) (*authenticators.Response, bool, error)
 	performRefreshErr              error
 	performRefreshGroups           []string
+	performRefreshAdditionalClaims map[string]any
+	lookupUserGroupsFunc           func(ctx context.Context, key string) ([]string, error)
+	dryRunAuthenticateFunc         func(ctx context.Context, username string) (*authenticators.Response, bool, error)
 	displayNameForFederationDomain string
 	transformsForFederationDomain  *idtransform.TransformationPipeline
+	totpPolicyForFederationDomain  totp.Policy
 }
 
 func (t *TestUpstreamLDAPIdentityProviderBuilder) WithName(name string) *TestUpstreamLDAPIdentityProviderBuilder {
@@ -65,6 +71,21 @@ func (t *TestUpstreamLDAPIdentityProviderBuilder) WithPerformRefreshGroups(group
 	return t
 }
 
+func (t *TestUpstreamLDAPIdentityProviderBuilder) WithPerformRefreshAdditionalClaims(additionalClaims map[string]any) *TestUpstreamLDAPIdentityProviderBuilder {
+	t.performRefreshAdditionalClaims = additionalClaims
+	return t
+}
+
+func (t *TestUpstreamLDAPIdentityProviderBuilder) WithLookupUserGroupsFunc(f func(ctx context.Context, key string) ([]string, error)) *TestUpstreamLDAPIdentityProviderBuilder {
+	t.lookupUserGroupsFunc = f
+	return t
+}
+
+func (t *TestUpstreamLDAPIdentityProviderBuilder) WithDryRunAuthenticateFunc(f func(ctx context.Context, username string) (*authenticators.Response, bool, error)) *TestUpstreamLDAPIdentityProviderBuilder {
+	t.dryRunAuthenticateFunc = f
+	return t
+}
+
 func (t *TestUpstreamLDAPIdentityProviderBuilder) WithDisplayNameForFederationDomain(displayName string) *TestUpstreamLDAPIdentityProviderBuilder {
 	t.displayNameForFederationDomain = displayName
 	return t
@@ -75,6 +96,11 @@ func (t *TestUpstreamLDAPIdentityProviderBuilder) WithTransformsForFederationDom
 	return t
 }
 
+func (t *TestUpstreamLDAPIdentityProviderBuilder) WithTOTPPolicyForFederationDomain(policy totp.Policy) *TestUpstreamLDAPIdentityProviderBuilder {
+	t.totpPolicyForFederationDomain = policy
+	return t
+}
+
 func (t *TestUpstreamLDAPIdentityProviderBuilder) Build() *TestUpstreamLDAPIdentityProvider {
 	if t.displayNameForFederationDomain == "" {
 		// default it to the CR name
@@ -91,8 +117,12 @@ func (t *TestUpstreamLDAPIdentityProviderBuilder) Build() *TestUpstreamLDAPIdent
 		AuthenticateFunc:               t.authenticateFunc,
 		PerformRefreshErr:              t.performRefreshErr,
 		PerformRefreshGroups:           t.performRefreshGroups,
+		PerformRefreshAdditionalClaims: t.performRefreshAdditionalClaims,
+		LookupUserGroupsFunc:           t.lookupUserGroupsFunc,
+		DryRunAuthenticateFunc:         t.dryRunAuthenticateFunc,
 		DisplayNameForFederationDomain: t.displayNameForFederationDomain,
 		TransformsForFederationDomain:  t.transformsForFederationDomain,
+		TOTPPolicyForFederationDomain:  t.totpPolicyForFederationDomain,
 	}
 }
 
@@ -103,12 +133,17 @@ type TestUpstreamLDAPIdentityProvider struct {
 	AuthenticateFunc               func(ctx context.Context, username, password string) (*authenticators.Response, bool, error)
 	PerformRefreshErr              error
 	PerformRefreshGroups           []string
+	PerformRefreshAdditionalClaims map[string]any
+	LookupUserGroupsFunc           func(ctx context.Context, key string) ([]string, error)
+	DryRunAuthenticateFunc         func(ctx context.Context, username string) (*authenticators.Response, bool, error)
 	DisplayNameForFederationDomain string
 	TransformsForFederationDomain  *idtransform.TransformationPipeline
+	TOTPPolicyForFederationDomain  totp.Policy
 
 	// Fields for tracking actual calls make to mock functions.
 	performRefreshCallCount int
 	performRefreshArgs      []*PerformLDAPRefreshArgs
+	lookupUserGroupsArgs    []string
 }
 
 var _ upstreamprovider.UpstreamLDAPIdentityProviderI = &TestUpstreamLDAPIdentityProvider{}
@@ -125,11 +160,18 @@ func (u *TestUpstreamLDAPIdentityProvider) AuthenticateUser(ctx context.Context,
 	return u.AuthenticateFunc(ctx, username, password)
 }
 
+func (u *TestUpstreamLDAPIdentityProvider) DryRunAuthenticateUser(ctx context.Context, username string) (*authenticators.Response, bool, error) {
+	if u.DryRunAuthenticateFunc == nil {
+		return nil, false, errors.New("DryRunAuthenticateUser was called unexpectedly")
+	}
+	return u.DryRunAuthenticateFunc(ctx, username)
+}
+
 func (u *TestUpstreamLDAPIdentityProvider) GetURL() *url.URL {
 	return u.URL
 }
 
-func (u *TestUpstreamLDAPIdentityProvider) PerformRefresh(ctx context.Context, storedRefreshAttributes upstreamprovider.LDAPRefreshAttributes, idpDisplayName string) ([]string, error) {
+func (u *TestUpstreamLDAPIdentityProvider) PerformRefresh(ctx context.Context, storedRefreshAttributes upstreamprovider.LDAPRefreshAttributes, idpDisplayName string) ([]string, map[string]any, error) {
 	if u.performRefreshArgs == nil {
 		u.performRefreshArgs = make([]*PerformLDAPRefreshArgs, 0)
 	}
@@ -140,9 +182,9 @@ func (u *TestUpstreamLDAPIdentityProvider) PerformRefresh(ctx context.Context, s
 		IDPDisplayName:          idpDisplayName,
 	})
 	if u.PerformRefreshErr != nil {
-		return nil, u.PerformRefreshErr
+		return nil, nil, u.PerformRefreshErr
 	}
-	return u.PerformRefreshGroups, nil
+	return u.PerformRefreshGroups, u.PerformRefreshAdditionalClaims, nil
 }
 
 func (u *TestUpstreamLDAPIdentityProvider) PerformRefreshCallCount() int {
@@ -155,3 +197,16 @@ func (u *TestUpstreamLDAPIdentityProvider) PerformRefreshArgs(call int) *Perform
 	}
 	return u.performRefreshArgs[call]
 }
+
+func (u *TestUpstreamLDAPIdentityProvider) LookupUserGroups(ctx context.Context, key string) ([]string, error) {
+	u.lookupUserGroupsArgs = append(u.lookupUserGroupsArgs, key)
+	if u.LookupUserGroupsFunc == nil {
+		return []string{}, nil
+	}
+	return u.LookupUserGroupsFunc(ctx, key)
+}
+
+// LookupUserGroupsArgs returns the keys which were passed to LookupUserGroups, in the order of the calls.
+func (u *TestUpstreamLDAPIdentityProvider) LookupUserGroupsArgs() []string {
+	return u.lookupUserGroupsArgs
+}
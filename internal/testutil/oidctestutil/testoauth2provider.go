@@ -0,0 +1,274 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidctestutil
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+	"k8s.io/apimachinery/pkg/types"
+
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/idtransform"
+	oidcpkce "go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+// ExchangeOAuth2AuthcodeArgs is used to spy on calls to
+// TestUpstreamOAuth2IdentityProvider.ExchangeAuthcodeFunc().
+type ExchangeOAuth2AuthcodeArgs struct {
+	Ctx              context.Context
+	Authcode         string
+	PKCECodeVerifier oidcpkce.Code
+	RedirectURI      string
+}
+
+// PerformOAuth2RefreshArgs is used to spy on calls to
+// TestUpstreamOAuth2IdentityProvider.PerformRefreshFunc().
+type PerformOAuth2RefreshArgs struct {
+	Ctx          context.Context
+	RefreshToken string
+}
+
+type TestUpstreamOAuth2IdentityProviderBuilder struct {
+	name                           string
+	resourceUID                    types.UID
+	clientID                       string
+	scopes                         []string
+	displayNameForFederationDomain string
+	transformsForFederationDomain  *idtransform.TransformationPipeline
+	authorizationURL               string
+	authcodeExchangeErr            error
+	token                          *oauth2.Token
+	performRefreshErr              error
+	refreshedToken                 *oauth2.Token
+	getUserErr                     error
+	getUserUser                    *upstreamprovider.OAuth2User
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithName(value string) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.name = value
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithResourceUID(value types.UID) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.resourceUID = value
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithClientID(value string) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.clientID = value
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithScopes(value []string) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.scopes = value
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithDisplayNameForFederationDomain(value string) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.displayNameForFederationDomain = value
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithAuthorizationURL(value string) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.authorizationURL = value
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithToken(token *oauth2.Token) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.token = token
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithAuthcodeExchangeError(err error) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.authcodeExchangeErr = err
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithRefreshedToken(token *oauth2.Token) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.refreshedToken = token
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithPerformRefreshError(err error) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.performRefreshErr = err
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithUser(user *upstreamprovider.OAuth2User) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.getUserUser = user
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithGetUserError(err error) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.getUserErr = err
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) WithTransformsForFederationDomain(transforms *idtransform.TransformationPipeline) *TestUpstreamOAuth2IdentityProviderBuilder {
+	u.transformsForFederationDomain = transforms
+	return u
+}
+
+func (u *TestUpstreamOAuth2IdentityProviderBuilder) Build() *TestUpstreamOAuth2IdentityProvider {
+	if u.displayNameForFederationDomain == "" {
+		// default it to the CR name
+		u.displayNameForFederationDomain = u.name
+	}
+	if u.transformsForFederationDomain == nil {
+		// default to an empty pipeline
+		u.transformsForFederationDomain = idtransform.NewTransformationPipeline()
+	}
+	return &TestUpstreamOAuth2IdentityProvider{
+		Name:                           u.name,
+		ClientID:                       u.clientID,
+		ResourceUID:                    u.resourceUID,
+		Scopes:                         u.scopes,
+		DisplayNameForFederationDomain: u.displayNameForFederationDomain,
+		TransformsForFederationDomain:  u.transformsForFederationDomain,
+		AuthorizationURL:               u.authorizationURL,
+		GetUserFunc: func(ctx context.Context, accessToken string) (*upstreamprovider.OAuth2User, error) {
+			if u.getUserErr != nil {
+				return nil, u.getUserErr
+			}
+			return u.getUserUser, nil
+		},
+		ExchangeAuthcodeFunc: func(ctx context.Context, authcode string) (*oauth2.Token, error) {
+			if u.authcodeExchangeErr != nil {
+				return nil, u.authcodeExchangeErr
+			}
+			return u.token, nil
+		},
+		PerformRefreshFunc: func(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+			if u.performRefreshErr != nil {
+				return nil, u.performRefreshErr
+			}
+			return u.refreshedToken, nil
+		},
+	}
+}
+
+func NewTestUpstreamOAuth2IdentityProviderBuilder() *TestUpstreamOAuth2IdentityProviderBuilder {
+	return &TestUpstreamOAuth2IdentityProviderBuilder{}
+}
+
+type TestUpstreamOAuth2IdentityProvider struct {
+	Name                           string
+	ClientID                       string
+	ResourceUID                    types.UID
+	Scopes                         []string
+	DisplayNameForFederationDomain string
+	TransformsForFederationDomain  *idtransform.TransformationPipeline
+	AuthorizationURL               string
+	GetUserFunc                    func(ctx context.Context, accessToken string) (*upstreamprovider.OAuth2User, error)
+	ExchangeAuthcodeFunc           func(ctx context.Context, authcode string) (*oauth2.Token, error)
+	PerformRefreshFunc             func(ctx context.Context, refreshToken string) (*oauth2.Token, error)
+
+	// Fields for tracking actual calls make to mock functions.
+	exchangeAuthcodeCallCount int
+	exchangeAuthcodeArgs      []*ExchangeOAuth2AuthcodeArgs
+	performRefreshCallCount   int
+	performRefreshArgs        []*PerformOAuth2RefreshArgs
+	getUserCallCount          int
+	getUserArgs               []*GetUserArgs
+}
+
+var _ upstreamprovider.UpstreamOAuth2IdentityProviderI = &TestUpstreamOAuth2IdentityProvider{}
+
+func (u *TestUpstreamOAuth2IdentityProvider) GetResourceUID() types.UID {
+	return u.ResourceUID
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) GetResourceName() string {
+	return u.Name
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) GetScopes() []string {
+	return u.Scopes
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) GetClientID() string {
+	return u.ClientID
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) GetAuthorizationURL() string {
+	return u.AuthorizationURL
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) ExchangeAuthcode(
+	ctx context.Context,
+	authcode string,
+	pkceCodeVerifier oidcpkce.Code,
+	redirectURI string,
+) (*oauth2.Token, error) {
+	if u.exchangeAuthcodeArgs == nil {
+		u.exchangeAuthcodeArgs = make([]*ExchangeOAuth2AuthcodeArgs, 0)
+	}
+	u.exchangeAuthcodeCallCount++
+	u.exchangeAuthcodeArgs = append(u.exchangeAuthcodeArgs, &ExchangeOAuth2AuthcodeArgs{
+		Ctx:              ctx,
+		Authcode:         authcode,
+		PKCECodeVerifier: pkceCodeVerifier,
+		RedirectURI:      redirectURI,
+	})
+	return u.ExchangeAuthcodeFunc(ctx, authcode)
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) ExchangeAuthcodeCallCount() int {
+	return u.exchangeAuthcodeCallCount
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) ExchangeAuthcodeArgs(call int) *ExchangeOAuth2AuthcodeArgs {
+	if u.exchangeAuthcodeArgs == nil {
+		u.exchangeAuthcodeArgs = make([]*ExchangeOAuth2AuthcodeArgs, 0)
+	}
+	return u.exchangeAuthcodeArgs[call]
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) PerformRefresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	if u.performRefreshArgs == nil {
+		u.performRefreshArgs = make([]*PerformOAuth2RefreshArgs, 0)
+	}
+	u.performRefreshCallCount++
+	u.performRefreshArgs = append(u.performRefreshArgs, &PerformOAuth2RefreshArgs{
+		Ctx:          ctx,
+		RefreshToken: refreshToken,
+	})
+	return u.PerformRefreshFunc(ctx, refreshToken)
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) PerformRefreshCallCount() int {
+	return u.performRefreshCallCount
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) PerformRefreshArgs(call int) *PerformOAuth2RefreshArgs {
+	if u.performRefreshArgs == nil {
+		u.performRefreshArgs = make([]*PerformOAuth2RefreshArgs, 0)
+	}
+	return u.performRefreshArgs[call]
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) GetUser(ctx context.Context, accessToken string, idpDisplayName string) (*upstreamprovider.OAuth2User, error) {
+	if u.getUserArgs == nil {
+		u.getUserArgs = make([]*GetUserArgs, 0)
+	}
+	u.getUserCallCount++
+	u.getUserArgs = append(u.getUserArgs, &GetUserArgs{
+		Ctx:            ctx,
+		AccessToken:    accessToken,
+		IDPDisplayName: idpDisplayName,
+	})
+	return u.GetUserFunc(ctx, accessToken)
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) GetUserCallCount() int {
+	return u.getUserCallCount
+}
+
+func (u *TestUpstreamOAuth2IdentityProvider) GetUserArgs(call int) *GetUserArgs {
+	if u.getUserArgs == nil {
+		u.getUserArgs = make([]*GetUserArgs, 0)
+	}
+	return u.getUserArgs[call]
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package oidctestutil
+
+import (
+	"fmt"
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/idtransform"
+)
+
+// ParseResponseArgs is used to spy on calls to
+// TestUpstreamSAMLIdentityProvider.ParseResponseFunc().
+type ParseResponseArgs struct {
+	EncodedResponse     string
+	RequestID           string
+	DownstreamIssuerURL string
+	ACSURL              string
+	IDPDisplayName      string
+}
+
+type TestUpstreamSAMLIdentityProviderBuilder struct {
+	name                           string
+	resourceUID                    types.UID
+	idpEntityID                    string
+	spEntityID                     string
+	ssoURL                         string
+	displayNameForFederationDomain string
+	transformsForFederationDomain  *idtransform.TransformationPipeline
+	authnRequestURLErr             error
+	parseResponseErr               error
+	parseResponseUser              *upstreamprovider.SAMLUser
+}
+
+func (u *TestUpstreamSAMLIdentityProviderBuilder) WithName(value string) *TestUpstreamSAMLIdentityProviderBuilder {
+	u.name = value
+	return u
+}
+
+func (u *TestUpstreamSAMLIdentityProviderBuilder) WithResourceUID(value types.UID) *TestUpstreamSAMLIdentityProviderBuilder {
+	u.resourceUID = value
+	return u
+}
+
+func (u *TestUpstreamSAMLIdentityProviderBuilder) WithIDPEntityID(value string) *TestUpstreamSAMLIdentityProviderBuilder {
+	u.idpEntityID = value
+	return u
+}
+
+func (u *TestUpstreamSAMLIdentityProviderBuilder) WithServiceProviderEntityID(value string) *TestUpstreamSAMLIdentityProviderBuilder {
+	u.spEntityID = value
+	return u
+}
+
+func (u *TestUpstreamSAMLIdentityProviderBuilder) WithSSOURL(value string) *TestUpstreamSAMLIdentityProviderBuilder {
+	u.ssoURL = value
+	return u
+}
+
+func (u *TestUpstreamSAMLIdentityProviderBuilder) WithDisplayNameForFederationDomain(value string) *TestUpstreamSAMLIdentityProviderBuilder {
+	u.displayNameForFederationDomain = value
+	return u
+}
+
+func (u *TestUpstreamSAMLIdentityProviderBuilder) WithTransformsForFederationDomain(transforms *idtransform.TransformationPipeline) *TestUpstreamSAMLIdentityProviderBuilder {
+	u.transformsForFederationDomain = transforms
+	return u
+}
+
+func (u *TestUpstreamSAMLIdentityProviderBuilder) WithAuthnRequestURLError(err error) *TestUpstreamSAMLIdentityProviderBuilder {
+	u.authnRequestURLErr = err
+	return u
+}
+
+func (u *TestUpstreamSAMLIdentityProviderBuilder) WithUser(user *upstreamprovider.SAMLUser) *TestUpstreamSAMLIdentityProviderBuilder {
+	u.parseResponseUser = user
+	return u
+}
+
+func (u *TestUpstreamSAMLIdentityProviderBuilder) WithParseResponseError(err error) *TestUpstreamSAMLIdentityProviderBuilder {
+	u.parseResponseErr = err
+	return u
+}
+
+func (u *TestUpstreamSAMLIdentityProviderBuilder) Build() *TestUpstreamSAMLIdentityProvider {
+	if u.displayNameForFederationDomain == "" {
+		// default it to the CR name
+		u.displayNameForFederationDomain = u.name
+	}
+	if u.transformsForFederationDomain == nil {
+		// default to an empty pipeline
+		u.transformsForFederationDomain = idtransform.NewTransformationPipeline()
+	}
+	return &TestUpstreamSAMLIdentityProvider{
+		Name:                           u.name,
+		ResourceUID:                    u.resourceUID,
+		IDPEntityID:                    u.idpEntityID,
+		ServiceProviderEntityID:        u.spEntityID,
+		SSOURL:                         u.ssoURL,
+		DisplayNameForFederationDomain: u.displayNameForFederationDomain,
+		TransformsForFederationDomain:  u.transformsForFederationDomain,
+		AuthnRequestURLFunc: func(requestID string, relayState string) (string, error) {
+			if u.authnRequestURLErr != nil {
+				return "", u.authnRequestURLErr
+			}
+			// Not a real AuthnRequest, but enough for tests to check which values were used.
+			return fmt.Sprintf("%s?%s", u.ssoURL, url.Values{
+				"SAMLRequest": []string{requestID},
+				"RelayState":  []string{relayState},
+			}.Encode()), nil
+		},
+		ParseResponseFunc: func(encodedResponse string, requestID string) (*upstreamprovider.SAMLUser, error) {
+			if u.parseResponseErr != nil {
+				return nil, u.parseResponseErr
+			}
+			return u.parseResponseUser, nil
+		},
+	}
+}
+
+func NewTestUpstreamSAMLIdentityProviderBuilder() *TestUpstreamSAMLIdentityProviderBuilder {
+	return &TestUpstreamSAMLIdentityProviderBuilder{}
+}
+
+type TestUpstreamSAMLIdentityProvider struct {
+	Name                           string
+	ResourceUID                    types.UID
+	IDPEntityID                    string
+	ServiceProviderEntityID        string
+	SSOURL                         string
+	DisplayNameForFederationDomain string
+	TransformsForFederationDomain  *idtransform.TransformationPipeline
+	AuthnRequestURLFunc            func(requestID string, relayState string) (string, error)
+	ParseResponseFunc              func(encodedResponse string, requestID string) (*upstreamprovider.SAMLUser, error)
+
+	// Fields for tracking actual calls make to mock functions.
+	parseResponseCallCount int
+	parseResponseArgs      []*ParseResponseArgs
+}
+
+var _ upstreamprovider.UpstreamSAMLIdentityProviderI = &TestUpstreamSAMLIdentityProvider{}
+
+func (u *TestUpstreamSAMLIdentityProvider) GetResourceUID() types.UID {
+	return u.ResourceUID
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) GetResourceName() string {
+	return u.Name
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) GetIDPEntityID() string {
+	return u.IDPEntityID
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) GetServiceProviderEntityID(downstreamIssuerURL string) string {
+	if u.ServiceProviderEntityID != "" {
+		return u.ServiceProviderEntityID
+	}
+	return downstreamIssuerURL
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) AuthnRequestURL(requestID string, relayState string, _ string, _ string) (string, error) {
+	return u.AuthnRequestURLFunc(requestID, relayState)
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) ParseResponse(
+	encodedResponse string,
+	requestID string,
+	downstreamIssuerURL string,
+	acsURL string,
+	idpDisplayName string,
+) (*upstreamprovider.SAMLUser, error) {
+	if u.parseResponseArgs == nil {
+		u.parseResponseArgs = make([]*ParseResponseArgs, 0)
+	}
+	u.parseResponseCallCount++
+	u.parseResponseArgs = append(u.parseResponseArgs, &ParseResponseArgs{
+		EncodedResponse:     encodedResponse,
+		RequestID:           requestID,
+		DownstreamIssuerURL: downstreamIssuerURL,
+		ACSURL:              acsURL,
+		IDPDisplayName:      idpDisplayName,
+	})
+	return u.ParseResponseFunc(encodedResponse, requestID)
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) ParseResponseCallCount() int {
+	return u.parseResponseCallCount
+}
+
+func (u *TestUpstreamSAMLIdentityProvider) ParseResponseArgs(call int) *ParseResponseArgs {
+	if u.parseResponseArgs == nil {
+		u.parseResponseArgs = make([]*ParseResponseArgs, 0)
+	}
+	return u.parseResponseArgs[call]
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/require"
+
+	supervisorconfigv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/config/v1alpha1"
+)
+
+// NewRequestObjectSigningKey returns a new private key for signing the request objects of an OIDCClient,
+// along with the OIDCClientRequestObjects configuration which allows an OIDCClient to use it.
+func NewRequestObjectSigningKey(t *testing.T) (*ecdsa.PrivateKey, *supervisorconfigv1alpha1.OIDCClientRequestObjects) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       key.Public(),
+		KeyID:     "request-object-key",
+		Algorithm: string(jose.ES256),
+		Use:       "sig",
+	}}})
+	require.NoError(t, err)
+
+	return key, &supervisorconfigv1alpha1.OIDCClientRequestObjects{
+		SigningAlgorithm: string(jose.ES256),
+		JWKS:             string(jwks),
+	}
+}
+
+// SignRequestObject returns a request object containing the given claims, signed by a key from NewRequestObjectSigningKey.
+func SignRequestObject(t *testing.T, key *ecdsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: key, KeyID: "request-object-key"}},
+		(&jose.SignerOptions{}).WithType("oauth-authz-req+jwt"),
+	)
+	require.NoError(t, err)
+
+	requestObject, err := jwt.Signed(signer).Claims(claims).Serialize()
+	require.NoError(t, err)
+	return requestObject
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package samltestutil provides a local SAML identity provider stand-in for unit tests.
+package samltestutil
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	IDPMetadataURL = "https://idp.example.com/saml/metadata"
+	IDPSSOURL      = "https://idp.example.com/saml/sso"
+)
+
+// KeyPair is an RSA key and self-signed certificate, as used by SAML identity providers and service providers.
+type KeyPair struct {
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+	KeyPEM      []byte
+	CertPEM     []byte
+}
+
+// NewKeyPair generates an RSA key and a self-signed certificate for it.
+func NewKeyPair(t *testing.T, commonName string) *KeyPair {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certDER)
+	require.NoError(t, err)
+
+	return &KeyPair{
+		Key:         key,
+		Certificate: cert,
+		KeyPEM:      pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		CertPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+	}
+}
+
+// TestIDP is a SAML identity provider which can respond to AuthnRequests from registered service providers.
+type TestIDP struct {
+	t                *testing.T
+	idp              *saml.IdentityProvider
+	serviceProviders map[string]*serviceProvider
+}
+
+type serviceProvider struct {
+	metadata    *saml.EntityDescriptor
+	certificate *x509.Certificate
+}
+
+// NewTestIDP returns a new TestIDP with a newly generated signing key.
+func NewTestIDP(t *testing.T) *TestIDP {
+	t.Helper()
+
+	keyPair := NewKeyPair(t, "test-idp")
+	metadataURL, err := url.Parse(IDPMetadataURL)
+	require.NoError(t, err)
+	ssoURL, err := url.Parse(IDPSSOURL)
+	require.NoError(t, err)
+
+	testIDP := &TestIDP{
+		t:                t,
+		serviceProviders: map[string]*serviceProvider{},
+	}
+	testIDP.idp = &saml.IdentityProvider{
+		Key:                     keyPair.Key,
+		Certificate:             keyPair.Certificate,
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		ServiceProviderProvider: testIDP,
+		AssertionMaker:          saml.DefaultAssertionMaker{},
+	}
+	return testIDP
+}
+
+// GetServiceProvider implements saml.ServiceProviderProvider.
+func (i *TestIDP) GetServiceProvider(_ *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	sp, ok := i.serviceProviders[serviceProviderID]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return sp.metadata, nil
+}
+
+// Metadata returns the parsed metadata of the IDP.
+func (i *TestIDP) Metadata() *saml.EntityDescriptor {
+	return i.idp.Metadata()
+}
+
+// MetadataXML returns the metadata document of the IDP.
+func (i *TestIDP) MetadataXML() []byte {
+	i.t.Helper()
+	metadataXML, err := xml.MarshalIndent(i.idp.Metadata(), "", "  ")
+	require.NoError(i.t, err)
+	return metadataXML
+}
+
+// RegisterServiceProvider allows the IDP to respond to AuthnRequests from the service provider.
+// Responses will be posted to the acsURL. The certificate is used to verify the signatures of AuthnRequests,
+// and to encrypt the assertions in the responses.
+func (i *TestIDP) RegisterServiceProvider(entityID string, acsURL string, spKeyPair *KeyPair) {
+	i.t.Helper()
+	parsedACSURL, err := url.Parse(acsURL)
+	require.NoError(i.t, err)
+	sp := saml.ServiceProvider{
+		EntityID:    entityID,
+		Key:         spKeyPair.Key,
+		Certificate: spKeyPair.Certificate,
+		AcsURL:      *parsedACSURL,
+	}
+	i.serviceProviders[entityID] = &serviceProvider{metadata: sp.Metadata(), certificate: spKeyPair.Certificate}
+}
+
+// Login responds to the AuthnRequest in the authnRequestURL, which must use the HTTP-Redirect binding, as if the
+// user from the session had logged in. The optional editAssertion func can change the assertion before it is signed.
+// Returns the base64-encoded SAMLResponse and RelayState, as they would be posted to the ACS URL by a browser.
+func (i *TestIDP) Login(authnRequestURL string, session *saml.Session, editAssertion func(*saml.Assertion)) (string, string) {
+	i.t.Helper()
+
+	httpReq, err := http.NewRequest(http.MethodGet, authnRequestURL, nil)
+	require.NoError(i.t, err)
+
+	idpReq, err := saml.NewIdpAuthnRequest(i.idp, httpReq)
+	require.NoError(i.t, err)
+	require.NoError(i.t, idpReq.Validate())
+
+	// The library's IDP does not verify the signatures of AuthnRequests, so do it here.
+	i.verifyRedirectSignature(httpReq.URL.RawQuery, i.serviceProviders[idpReq.Request.Issuer.Value].certificate)
+
+	require.NoError(i.t, i.idp.AssertionMaker.MakeAssertion(idpReq, session))
+	if editAssertion != nil {
+		editAssertion(idpReq.Assertion)
+	}
+
+	form, err := idpReq.PostBinding()
+	require.NoError(i.t, err)
+	return form.SAMLResponse, form.RelayState
+}
+
+func (i *TestIDP) verifyRedirectSignature(rawQuery string, spCertificate *x509.Certificate) {
+	i.t.Helper()
+
+	signedQuery, encodedSignature, found := strings.Cut(rawQuery, "&Signature=")
+	require.True(i.t, found, "AuthnRequest was not signed")
+	require.Contains(i.t, signedQuery, "&SigAlg="+url.QueryEscape("http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"))
+
+	unescapedSignature, err := url.QueryUnescape(encodedSignature)
+	require.NoError(i.t, err)
+	signature, err := base64.StdEncoding.DecodeString(unescapedSignature)
+	require.NoError(i.t, err)
+
+	digest := sha256.Sum256([]byte(signedQuery))
+	require.NoError(i.t, rsa.VerifyPKCS1v15(spCertificate.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature))
+}
+
+// EncodedResponse returns an unsigned base64-encoded SAMLResponse containing the given XML, for negative tests.
+func EncodedResponse(responseXML string) string {
+	return base64.StdEncoding.EncodeToString([]byte(responseXML))
+}
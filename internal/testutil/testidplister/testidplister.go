@@ -13,8 +13,11 @@ import (
 	"go.pinniped.dev/internal/federationdomain/dynamicupstreamprovider"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedgithub"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedgitlab"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedldap"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedoauth2"
 	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedoidc"
+	"go.pinniped.dev/internal/federationdomain/resolvedprovider/resolvedsaml"
 	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
 	"go.pinniped.dev/internal/psession"
 	"go.pinniped.dev/internal/testutil/oidctestutil"
@@ -27,6 +30,9 @@ type TestFederationDomainIdentityProvidersListerFinder struct {
 	upstreamLDAPIdentityProviders            []*oidctestutil.TestUpstreamLDAPIdentityProvider
 	upstreamActiveDirectoryIdentityProviders []*oidctestutil.TestUpstreamLDAPIdentityProvider
 	upstreamGitHubIdentityProviders          []*oidctestutil.TestUpstreamGitHubIdentityProvider
+	upstreamSAMLIdentityProviders            []*oidctestutil.TestUpstreamSAMLIdentityProvider
+	upstreamGitLabIdentityProviders          []*oidctestutil.TestUpstreamGitLabIdentityProvider
+	upstreamOAuth2IdentityProviders          []*oidctestutil.TestUpstreamOAuth2IdentityProvider
 	defaultIDPDisplayName                    string
 }
 
@@ -35,12 +41,16 @@ func (t *TestFederationDomainIdentityProvidersListerFinder) HasDefaultIDP() bool
 }
 
 func (t *TestFederationDomainIdentityProvidersListerFinder) IDPCount() int {
-	return len(t.upstreamOIDCIdentityProviders) + len(t.upstreamLDAPIdentityProviders) + len(t.upstreamActiveDirectoryIdentityProviders)
+	return len(t.upstreamOIDCIdentityProviders) + len(t.upstreamLDAPIdentityProviders) + len(t.upstreamActiveDirectoryIdentityProviders) +
+		len(t.upstreamGitHubIdentityProviders) + len(t.upstreamSAMLIdentityProviders) + len(t.upstreamGitLabIdentityProviders) +
+		len(t.upstreamOAuth2IdentityProviders)
 }
 
 func (t *TestFederationDomainIdentityProvidersListerFinder) GetIdentityProviders() []resolvedprovider.FederationDomainResolvedIdentityProvider {
 	fdIDPs := make([]resolvedprovider.FederationDomainResolvedIdentityProvider,
-		len(t.upstreamOIDCIdentityProviders)+len(t.upstreamLDAPIdentityProviders)+len(t.upstreamActiveDirectoryIdentityProviders)+len(t.upstreamGitHubIdentityProviders))
+		len(t.upstreamOIDCIdentityProviders)+len(t.upstreamLDAPIdentityProviders)+len(t.upstreamActiveDirectoryIdentityProviders)+
+			len(t.upstreamGitHubIdentityProviders)+len(t.upstreamSAMLIdentityProviders)+len(t.upstreamGitLabIdentityProviders)+
+			len(t.upstreamOAuth2IdentityProviders))
 	i := 0
 	for _, testIDP := range t.upstreamOIDCIdentityProviders {
 		fdIDP := &resolvedoidc.FederationDomainResolvedOIDCIdentityProvider{
@@ -58,6 +68,7 @@ func (t *TestFederationDomainIdentityProvidersListerFinder) GetIdentityProviders
 			Provider:            testIDP,
 			SessionProviderType: psession.ProviderTypeLDAP,
 			Transforms:          testIDP.TransformsForFederationDomain,
+			TOTPPolicy:          testIDP.TOTPPolicyForFederationDomain,
 		}
 		fdIDPs[i] = fdIDP
 		i++
@@ -68,6 +79,7 @@ func (t *TestFederationDomainIdentityProvidersListerFinder) GetIdentityProviders
 			Provider:            testIDP,
 			SessionProviderType: psession.ProviderTypeActiveDirectory,
 			Transforms:          testIDP.TransformsForFederationDomain,
+			TOTPPolicy:          testIDP.TOTPPolicyForFederationDomain,
 		}
 		fdIDPs[i] = fdIDP
 		i++
@@ -82,6 +94,36 @@ func (t *TestFederationDomainIdentityProvidersListerFinder) GetIdentityProviders
 		fdIDPs[i] = fdIDP
 		i++
 	}
+	for _, testIDP := range t.upstreamSAMLIdentityProviders {
+		fdIDP := &resolvedsaml.FederationDomainResolvedSAMLIdentityProvider{
+			DisplayName:         testIDP.DisplayNameForFederationDomain,
+			Provider:            testIDP,
+			SessionProviderType: psession.ProviderTypeSAML,
+			Transforms:          testIDP.TransformsForFederationDomain,
+		}
+		fdIDPs[i] = fdIDP
+		i++
+	}
+	for _, testIDP := range t.upstreamGitLabIdentityProviders {
+		fdIDP := &resolvedgitlab.FederationDomainResolvedGitLabIdentityProvider{
+			DisplayName:         testIDP.DisplayNameForFederationDomain,
+			Provider:            testIDP,
+			SessionProviderType: psession.ProviderTypeGitLab,
+			Transforms:          testIDP.TransformsForFederationDomain,
+		}
+		fdIDPs[i] = fdIDP
+		i++
+	}
+	for _, testIDP := range t.upstreamOAuth2IdentityProviders {
+		fdIDP := &resolvedoauth2.FederationDomainResolvedOAuth2IdentityProvider{
+			DisplayName:         testIDP.DisplayNameForFederationDomain,
+			Provider:            testIDP,
+			SessionProviderType: psession.ProviderTypeOAuth2,
+			Transforms:          testIDP.TransformsForFederationDomain,
+		}
+		fdIDPs[i] = fdIDP
+		i++
+	}
 	return fdIDPs
 }
 
@@ -110,6 +152,7 @@ func (t *TestFederationDomainIdentityProvidersListerFinder) FindUpstreamIDPByDis
 				Provider:            testIDP,
 				SessionProviderType: psession.ProviderTypeLDAP,
 				Transforms:          testIDP.TransformsForFederationDomain,
+				TOTPPolicy:          testIDP.TOTPPolicyForFederationDomain,
 			}, nil
 		}
 	}
@@ -120,6 +163,7 @@ func (t *TestFederationDomainIdentityProvidersListerFinder) FindUpstreamIDPByDis
 				Provider:            testIDP,
 				SessionProviderType: psession.ProviderTypeActiveDirectory,
 				Transforms:          testIDP.TransformsForFederationDomain,
+				TOTPPolicy:          testIDP.TOTPPolicyForFederationDomain,
 			}, nil
 		}
 	}
@@ -133,6 +177,36 @@ func (t *TestFederationDomainIdentityProvidersListerFinder) FindUpstreamIDPByDis
 			}, nil
 		}
 	}
+	for _, testIDP := range t.upstreamSAMLIdentityProviders {
+		if upstreamIDPDisplayName == testIDP.DisplayNameForFederationDomain {
+			return &resolvedsaml.FederationDomainResolvedSAMLIdentityProvider{
+				DisplayName:         testIDP.DisplayNameForFederationDomain,
+				Provider:            testIDP,
+				SessionProviderType: psession.ProviderTypeSAML,
+				Transforms:          testIDP.TransformsForFederationDomain,
+			}, nil
+		}
+	}
+	for _, testIDP := range t.upstreamGitLabIdentityProviders {
+		if upstreamIDPDisplayName == testIDP.DisplayNameForFederationDomain {
+			return &resolvedgitlab.FederationDomainResolvedGitLabIdentityProvider{
+				DisplayName:         testIDP.DisplayNameForFederationDomain,
+				Provider:            testIDP,
+				SessionProviderType: psession.ProviderTypeGitLab,
+				Transforms:          testIDP.TransformsForFederationDomain,
+			}, nil
+		}
+	}
+	for _, testIDP := range t.upstreamOAuth2IdentityProviders {
+		if upstreamIDPDisplayName == testIDP.DisplayNameForFederationDomain {
+			return &resolvedoauth2.FederationDomainResolvedOAuth2IdentityProvider{
+				DisplayName:         testIDP.DisplayNameForFederationDomain,
+				Provider:            testIDP,
+				SessionProviderType: psession.ProviderTypeOAuth2,
+				Transforms:          testIDP.TransformsForFederationDomain,
+			}, nil
+		}
+	}
 	return nil, fmt.Errorf("did not find IDP with name %q", upstreamIDPDisplayName)
 }
 
@@ -152,6 +226,18 @@ func (t *TestFederationDomainIdentityProvidersListerFinder) SetGitHubIdentityPro
 	t.upstreamGitHubIdentityProviders = providers
 }
 
+func (t *TestFederationDomainIdentityProvidersListerFinder) SetSAMLIdentityProviders(providers []*oidctestutil.TestUpstreamSAMLIdentityProvider) {
+	t.upstreamSAMLIdentityProviders = providers
+}
+
+func (t *TestFederationDomainIdentityProvidersListerFinder) SetGitLabIdentityProviders(providers []*oidctestutil.TestUpstreamGitLabIdentityProvider) {
+	t.upstreamGitLabIdentityProviders = providers
+}
+
+func (t *TestFederationDomainIdentityProvidersListerFinder) SetOAuth2IdentityProviders(providers []*oidctestutil.TestUpstreamOAuth2IdentityProvider) {
+	t.upstreamOAuth2IdentityProviders = providers
+}
+
 // UpstreamIDPListerBuilder can be used to build either a dynamicupstreamprovider.DynamicUpstreamIDPProvider
 // or a FederationDomainIdentityProvidersListerFinderI for testing.
 type UpstreamIDPListerBuilder struct {
@@ -159,6 +245,9 @@ type UpstreamIDPListerBuilder struct {
 	upstreamLDAPIdentityProviders            []*oidctestutil.TestUpstreamLDAPIdentityProvider
 	upstreamActiveDirectoryIdentityProviders []*oidctestutil.TestUpstreamLDAPIdentityProvider
 	upstreamGitHubIdentityProviders          []*oidctestutil.TestUpstreamGitHubIdentityProvider
+	upstreamSAMLIdentityProviders            []*oidctestutil.TestUpstreamSAMLIdentityProvider
+	upstreamGitLabIdentityProviders          []*oidctestutil.TestUpstreamGitLabIdentityProvider
+	upstreamOAuth2IdentityProviders          []*oidctestutil.TestUpstreamOAuth2IdentityProvider
 	defaultIDPDisplayName                    string
 }
 
@@ -182,6 +271,21 @@ func (b *UpstreamIDPListerBuilder) WithGitHub(upstreamGithubIdentityProviders ..
 	return b
 }
 
+func (b *UpstreamIDPListerBuilder) WithSAML(upstreamSAMLIdentityProviders ...*oidctestutil.TestUpstreamSAMLIdentityProvider) *UpstreamIDPListerBuilder {
+	b.upstreamSAMLIdentityProviders = slices.Concat(b.upstreamSAMLIdentityProviders, upstreamSAMLIdentityProviders)
+	return b
+}
+
+func (b *UpstreamIDPListerBuilder) WithGitLab(upstreamGitLabIdentityProviders ...*oidctestutil.TestUpstreamGitLabIdentityProvider) *UpstreamIDPListerBuilder {
+	b.upstreamGitLabIdentityProviders = slices.Concat(b.upstreamGitLabIdentityProviders, upstreamGitLabIdentityProviders)
+	return b
+}
+
+func (b *UpstreamIDPListerBuilder) WithOAuth2(upstreamOAuth2IdentityProviders ...*oidctestutil.TestUpstreamOAuth2IdentityProvider) *UpstreamIDPListerBuilder {
+	b.upstreamOAuth2IdentityProviders = slices.Concat(b.upstreamOAuth2IdentityProviders, upstreamOAuth2IdentityProviders)
+	return b
+}
+
 func (b *UpstreamIDPListerBuilder) WithDefaultIDPDisplayName(defaultIDPDisplayName string) *UpstreamIDPListerBuilder {
 	b.defaultIDPDisplayName = defaultIDPDisplayName
 	return b
@@ -193,6 +297,9 @@ func (b *UpstreamIDPListerBuilder) BuildFederationDomainIdentityProvidersListerF
 		upstreamLDAPIdentityProviders:            b.upstreamLDAPIdentityProviders,
 		upstreamActiveDirectoryIdentityProviders: b.upstreamActiveDirectoryIdentityProviders,
 		upstreamGitHubIdentityProviders:          b.upstreamGitHubIdentityProviders,
+		upstreamSAMLIdentityProviders:            b.upstreamSAMLIdentityProviders,
+		upstreamGitLabIdentityProviders:          b.upstreamGitLabIdentityProviders,
+		upstreamOAuth2IdentityProviders:          b.upstreamOAuth2IdentityProviders,
 		defaultIDPDisplayName:                    b.defaultIDPDisplayName,
 	}
 }
@@ -224,6 +331,24 @@ func (b *UpstreamIDPListerBuilder) BuildDynamicUpstreamIDPProvider() dynamicupst
 	}
 	idpProvider.SetGitHubIdentityProviders(githubUpstreams)
 
+	samlUpstreams := make([]upstreamprovider.UpstreamSAMLIdentityProviderI, len(b.upstreamSAMLIdentityProviders))
+	for i := range b.upstreamSAMLIdentityProviders {
+		samlUpstreams[i] = upstreamprovider.UpstreamSAMLIdentityProviderI(b.upstreamSAMLIdentityProviders[i])
+	}
+	idpProvider.SetSAMLIdentityProviders(samlUpstreams)
+
+	gitlabUpstreams := make([]upstreamprovider.UpstreamGitLabIdentityProviderI, len(b.upstreamGitLabIdentityProviders))
+	for i := range b.upstreamGitLabIdentityProviders {
+		gitlabUpstreams[i] = upstreamprovider.UpstreamGitLabIdentityProviderI(b.upstreamGitLabIdentityProviders[i])
+	}
+	idpProvider.SetGitLabIdentityProviders(gitlabUpstreams)
+
+	oauth2Upstreams := make([]upstreamprovider.UpstreamOAuth2IdentityProviderI, len(b.upstreamOAuth2IdentityProviders))
+	for i := range b.upstreamOAuth2IdentityProviders {
+		oauth2Upstreams[i] = upstreamprovider.UpstreamOAuth2IdentityProviderI(b.upstreamOAuth2IdentityProviders[i])
+	}
+	idpProvider.SetOAuth2IdentityProviders(oauth2Upstreams)
+
 	return idpProvider
 }
 
@@ -316,6 +441,84 @@ func (b *UpstreamIDPListerBuilder) RequireExactlyOneGitHubAuthcodeExchange(
 	require.Equal(t, expectedArgs, actualArgs)
 }
 
+func (b *UpstreamIDPListerBuilder) RequireExactlyOneGitLabAuthcodeExchange(
+	t *testing.T,
+	expectedPerformedByUpstreamName string,
+	expectedArgs *oidctestutil.ExchangeGitLabAuthcodeArgs,
+) {
+	t.Helper()
+	var actualArgs *oidctestutil.ExchangeGitLabAuthcodeArgs
+	var actualNameOfUpstreamWhichMadeCall string
+	actualCallCount := 0
+	for _, upstream := range b.upstreamGitLabIdentityProviders {
+		callCountOnThisUpstream := upstream.ExchangeAuthcodeCallCount()
+		actualCallCount += callCountOnThisUpstream
+		if callCountOnThisUpstream == 1 {
+			actualNameOfUpstreamWhichMadeCall = upstream.Name
+			actualArgs = upstream.ExchangeAuthcodeArgs(0)
+		}
+	}
+	require.Equal(t, 1, actualCallCount,
+		"expected exactly one call to GitLab ExchangeAuthcode()",
+	)
+	require.Equal(t, expectedPerformedByUpstreamName, actualNameOfUpstreamWhichMadeCall,
+		"GitLab ExchangeAuthcode() was called on the wrong upstream name",
+	)
+	require.Equal(t, expectedArgs, actualArgs)
+}
+
+func (b *UpstreamIDPListerBuilder) RequireExactlyOneOAuth2AuthcodeExchange(
+	t *testing.T,
+	expectedPerformedByUpstreamName string,
+	expectedArgs *oidctestutil.ExchangeOAuth2AuthcodeArgs,
+) {
+	t.Helper()
+	var actualArgs *oidctestutil.ExchangeOAuth2AuthcodeArgs
+	var actualNameOfUpstreamWhichMadeCall string
+	actualCallCount := 0
+	for _, upstream := range b.upstreamOAuth2IdentityProviders {
+		callCountOnThisUpstream := upstream.ExchangeAuthcodeCallCount()
+		actualCallCount += callCountOnThisUpstream
+		if callCountOnThisUpstream == 1 {
+			actualNameOfUpstreamWhichMadeCall = upstream.Name
+			actualArgs = upstream.ExchangeAuthcodeArgs(0)
+		}
+	}
+	require.Equal(t, 1, actualCallCount,
+		"expected exactly one call to OAuth2 ExchangeAuthcode()",
+	)
+	require.Equal(t, expectedPerformedByUpstreamName, actualNameOfUpstreamWhichMadeCall,
+		"OAuth2 ExchangeAuthcode() was called on the wrong upstream name",
+	)
+	require.Equal(t, expectedArgs, actualArgs)
+}
+
+func (b *UpstreamIDPListerBuilder) RequireExactlyOneSAMLParseResponse(
+	t *testing.T,
+	expectedPerformedByUpstreamName string,
+	expectedArgs *oidctestutil.ParseResponseArgs,
+) {
+	t.Helper()
+	var actualArgs *oidctestutil.ParseResponseArgs
+	var actualNameOfUpstreamWhichMadeCall string
+	actualCallCount := 0
+	for _, upstream := range b.upstreamSAMLIdentityProviders {
+		callCountOnThisUpstream := upstream.ParseResponseCallCount()
+		actualCallCount += callCountOnThisUpstream
+		if callCountOnThisUpstream == 1 {
+			actualNameOfUpstreamWhichMadeCall = upstream.Name
+			actualArgs = upstream.ParseResponseArgs(0)
+		}
+	}
+	require.Equal(t, 1, actualCallCount,
+		"expected exactly one call to SAML ParseResponse()",
+	)
+	require.Equal(t, expectedPerformedByUpstreamName, actualNameOfUpstreamWhichMadeCall,
+		"SAML ParseResponse() was called on the wrong upstream name",
+	)
+	require.Equal(t, expectedArgs, actualArgs)
+}
+
 func (b *UpstreamIDPListerBuilder) RequireExactlyZeroAuthcodeExchanges(t *testing.T) {
 	t.Helper()
 	actualCallCount := 0
@@ -325,9 +528,18 @@ func (b *UpstreamIDPListerBuilder) RequireExactlyZeroAuthcodeExchanges(t *testin
 	for _, upstreamGitHub := range b.upstreamGitHubIdentityProviders {
 		actualCallCount += upstreamGitHub.ExchangeAuthcodeCallCount()
 	}
+	for _, upstreamSAML := range b.upstreamSAMLIdentityProviders {
+		actualCallCount += upstreamSAML.ParseResponseCallCount()
+	}
+	for _, upstreamGitLab := range b.upstreamGitLabIdentityProviders {
+		actualCallCount += upstreamGitLab.ExchangeAuthcodeCallCount()
+	}
+	for _, upstreamOAuth2 := range b.upstreamOAuth2IdentityProviders {
+		actualCallCount += upstreamOAuth2.ExchangeAuthcodeCallCount()
+	}
 
 	require.Equal(t, 0, actualCallCount,
-		"expected exactly zero calls to OIDC ExchangeAuthcodeAndValidateTokens() or GitHub ExchangeAuthcode()",
+		"expected exactly zero calls to OIDC ExchangeAuthcodeAndValidateTokens(), GitHub ExchangeAuthcode(), SAML ParseResponse(), GitLab ExchangeAuthcode(), or OAuth2 ExchangeAuthcode()",
 	)
 }
 
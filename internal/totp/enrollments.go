@@ -0,0 +1,295 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package totp
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"go.pinniped.dev/internal/constable"
+	"go.pinniped.dev/internal/crud"
+)
+
+const (
+	TypeLabelValue = "totp-enrollment"
+
+	ErrInvalidEnrollmentVersion = constable.Error("totp enrollment data has wrong version")
+	ErrMissingEncryptionKey     = constable.Error("totp encryption key is not available yet")
+
+	// Version 1 was the initial release of storage.
+	enrollmentStorageVersion = "1"
+
+	// maxFailedAttempts is the number of consecutive incorrect codes after which no more codes are accepted for
+	// the end user until the lockout duration has passed since the most recent incorrect code. Without this,
+	// someone who knows the end user's password could try many codes until one happens to be correct.
+	maxFailedAttempts = 5
+	lockoutDuration   = 5 * time.Minute
+
+	// maxStorageAttempts is how many times an incorrect code is counted again after another pod concurrently
+	// changed the same enrollment.
+	maxStorageAttempts = 3
+)
+
+// Enrollments stores the TOTP secrets of end users for a FederationDomain in Secrets, so they can be used by all
+// the Supervisor's pods. The TOTP secrets are encrypted using the FederationDomain's TOTP encryption key.
+// The enrollments do not expire, so they are never deleted by the garbage collector.
+type Enrollments struct {
+	storage crud.Storage
+	issuer  string
+	keyFunc func() []byte
+	rand    io.Reader
+	clock   func() time.Time
+}
+
+type enrollment struct {
+	EncryptedSecret  []byte    `json:"encryptedSecret"`
+	LastUsedTimeStep int64     `json:"lastUsedTimeStep"`
+	FailedAttempts   int       `json:"failedAttempts"`
+	LastFailedAt     time.Time `json:"lastFailedAt"`
+	Version          string    `json:"version"`
+}
+
+// CheckResult is the outcome of checking the one-time password of an end user according to a Policy.
+type CheckResult int
+
+const (
+	// CheckPassed means that the end user may continue to log in.
+	CheckPassed CheckResult = iota
+
+	// CheckCodeRequired means that the end user has enrolled, but did not provide a code.
+	CheckCodeRequired
+
+	// CheckCodeNotAccepted means that the end user provided a code which was not accepted.
+	CheckCodeNotAccepted
+
+	// CheckEnrollmentRequired means that the policy requires a code, but the end user has not enrolled.
+	CheckEnrollmentRequired
+)
+
+// NewEnrollments returns the Enrollments of the FederationDomain with the given issuer. The keyFunc returns the
+// current encryption key of the FederationDomain, or nil when it is not available yet.
+func NewEnrollments(
+	issuer string,
+	secrets corev1client.SecretInterface,
+	keyFunc func() []byte,
+	rand io.Reader,
+	clock func() time.Time,
+) *Enrollments {
+	return &Enrollments{
+		storage: crud.New(TypeLabelValue, secrets, clock),
+		issuer:  issuer,
+		keyFunc: keyFunc,
+		rand:    rand,
+		clock:   clock,
+	}
+}
+
+// IsEnrolled returns true when the end user with the given downstream subject has enrolled.
+func (e *Enrollments) IsEnrolled(ctx context.Context, subject string) (bool, error) {
+	_, _, err := e.get(ctx, subject)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Enroll stores the secret for the end user with the given downstream subject, replacing any previous enrollment.
+// The lastUsedTimeStep is the time step of the code which the end user provided to confirm the enrollment,
+// so that the same code cannot be used again to log in.
+func (e *Enrollments) Enroll(ctx context.Context, subject string, secret string, lastUsedTimeStep int64) error {
+	encryptedSecret, err := e.encrypt(subject, secret)
+	if err != nil {
+		return err
+	}
+	stored := &enrollment{EncryptedSecret: encryptedSecret, LastUsedTimeStep: lastUsedTimeStep, Version: enrollmentStorageVersion}
+
+	// Using a lifetime of zero means that the Secret will not be garbage collected.
+	_, err = e.storage.Create(ctx, e.signature(subject), stored, nil, nil, 0)
+	if apierrors.IsAlreadyExists(err) {
+		if err = e.storage.Delete(ctx, e.signature(subject)); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete previous totp enrollment: %w", err)
+		}
+		_, err = e.storage.Create(ctx, e.signature(subject), stored, nil, nil, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store totp enrollment: %w", err)
+	}
+	return nil
+}
+
+// Check decides whether the end user with the given downstream subject may continue to log in according to the
+// policy. The code is the one-time password provided by the end user, which may be empty when the end user
+// has not provided one yet.
+func (e *Enrollments) Check(ctx context.Context, policy Policy, subject string, code string) (CheckResult, error) {
+	if policy == PolicyNone {
+		return CheckPassed, nil
+	}
+
+	stored, resourceVersion, err := e.get(ctx, subject)
+	if apierrors.IsNotFound(err) {
+		if policy == PolicyWhenEnrolled {
+			return CheckPassed, nil
+		}
+		return CheckEnrollmentRequired, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if code == "" {
+		return CheckCodeRequired, nil
+	}
+
+	verified, err := e.verify(ctx, subject, code, stored, resourceVersion)
+	if err != nil {
+		return 0, err
+	}
+	if !verified {
+		return CheckCodeNotAccepted, nil
+	}
+	return CheckPassed, nil
+}
+
+// Verify returns true when the code is currently valid for the end user with the given downstream subject.
+// Each code is only accepted once, so a code which was observed by someone else cannot be replayed. After too
+// many incorrect codes, no codes are accepted for a while. It returns false when the end user has not enrolled.
+func (e *Enrollments) Verify(ctx context.Context, subject string, code string) (bool, error) {
+	stored, resourceVersion, err := e.get(ctx, subject)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return e.verify(ctx, subject, code, stored, resourceVersion)
+}
+
+func (e *Enrollments) verify(ctx context.Context, subject string, code string, stored *enrollment, resourceVersion string) (bool, error) {
+	now := e.clock()
+	if stored.FailedAttempts >= maxFailedAttempts && now.Before(stored.LastFailedAt.Add(lockoutDuration)) {
+		return false, nil
+	}
+
+	secret, err := e.decrypt(subject, stored.EncryptedSecret)
+	if err != nil {
+		return false, err
+	}
+
+	timeStep, ok := Validate(secret, code, now, stored.LastUsedTimeStep)
+	if !ok {
+		return false, e.recordFailure(ctx, subject, stored, resourceVersion)
+	}
+
+	// Remember that this code was used. When another pod concurrently accepted a code for the same end user,
+	// the update will fail due to the resource version, so only one of them succeeds.
+	stored.LastUsedTimeStep = timeStep
+	stored.FailedAttempts = 0
+	if _, err = e.storage.Update(ctx, e.signature(subject), resourceVersion, stored); err != nil {
+		if apierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to update totp enrollment: %w", err)
+	}
+	return true, nil
+}
+
+// recordFailure counts an incorrect code. When another pod concurrently changed the enrollment, it is read again
+// and the incorrect code is counted again, since otherwise many incorrect codes which are sent at the same time
+// would only be counted once. When the incorrect code cannot be counted, an error is returned so the login fails.
+func (e *Enrollments) recordFailure(ctx context.Context, subject string, stored *enrollment, resourceVersion string) error {
+	for attempt := 1; ; attempt++ {
+		stored.FailedAttempts++
+		stored.LastFailedAt = e.clock()
+		_, err := e.storage.Update(ctx, e.signature(subject), resourceVersion, stored)
+		if err == nil {
+			return nil
+		}
+		if !apierrors.IsConflict(err) || attempt == maxStorageAttempts {
+			return fmt.Errorf("failed to update totp enrollment: %w", err)
+		}
+
+		stored, resourceVersion, err = e.get(ctx, subject)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *Enrollments) get(ctx context.Context, subject string) (*enrollment, string, error) {
+	stored := &enrollment{}
+	resourceVersion, err := e.storage.Get(ctx, e.signature(subject), stored)
+	if apierrors.IsNotFound(err) {
+		return nil, "", err
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get totp enrollment: %w", err)
+	}
+	if stored.Version != enrollmentStorageVersion {
+		return nil, "", fmt.Errorf("%w: enrollment has version %s instead of %s",
+			ErrInvalidEnrollmentVersion, stored.Version, enrollmentStorageVersion)
+	}
+	return stored, resourceVersion, nil
+}
+
+// signature hashes the issuer and subject, since the subject may be too long or contain characters which cannot
+// be used in the name of a Secret, and since it identifies the user.
+func (e *Enrollments) signature(subject string) string {
+	hash := sha256.Sum256([]byte(e.issuer + "\n" + subject))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func (e *Enrollments) aead() (cipher.AEAD, error) {
+	key := e.keyFunc()
+	if len(key) == 0 {
+		return nil, ErrMissingEncryptionKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not use totp encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt encrypts the secret using AES-GCM. The signature is used as additional data, so an encrypted secret
+// cannot be moved to the enrollment of another end user.
+func (e *Enrollments) encrypt(subject string, secret string) ([]byte, error) {
+	aead, err := e.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(e.rand, nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, []byte(secret), []byte(e.signature(subject))), nil
+}
+
+func (e *Enrollments) decrypt(subject string, encryptedSecret []byte) (string, error) {
+	aead, err := e.aead()
+	if err != nil {
+		return "", err
+	}
+	if len(encryptedSecret) < aead.NonceSize() {
+		return "", errors.New("could not decrypt totp enrollment: encrypted secret is too short")
+	}
+	nonce, ciphertext := encryptedSecret[:aead.NonceSize()], encryptedSecret[aead.NonceSize():]
+	secret, err := aead.Open(nil, nonce, ciphertext, []byte(e.signature(subject)))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt totp enrollment: %w", err)
+	}
+	return string(secret), nil
+}
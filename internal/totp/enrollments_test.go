@@ -0,0 +1,488 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package totp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	coretesting "k8s.io/client-go/testing"
+
+	testtotp "go.pinniped.dev/internal/testutil/totp"
+)
+
+const (
+	testIssuer    = "https://issuer.example.com"
+	testNamespace = "test-namespace"
+	testSubject   = "https://upstream.example.com?idpName=some-idp&sub=pinny"
+)
+
+type enrollmentsTestFixture struct {
+	enrollments *Enrollments
+	client      *fake.Clientset
+	secrets     corev1client.SecretInterface
+	now         time.Time
+	key         []byte
+}
+
+func newEnrollmentsTestFixture(t *testing.T) *enrollmentsTestFixture {
+	t.Helper()
+
+	client := fake.NewSimpleClientset()
+	f := &enrollmentsTestFixture{
+		client:  client,
+		secrets: client.CoreV1().Secrets(testNamespace),
+		now:     time.Unix(1234567890, 0),
+		key:     bytes.Repeat([]byte{0x42}, 32),
+	}
+	f.enrollments = NewEnrollments(testIssuer, f.secrets, func() []byte { return f.key }, rand.Reader, func() time.Time { return f.now })
+	return f
+}
+
+func (f *enrollmentsTestFixture) code(t *testing.T) string {
+	t.Helper()
+	code, _ := testtotp.GenerateOTPCode(t, rfc6238Secret, f.now)
+	return code
+}
+
+func (f *enrollmentsTestFixture) wrongCode(t *testing.T) string {
+	t.Helper()
+	if f.code(t) == "123456" {
+		return "654321"
+	}
+	return "123456"
+}
+
+func (f *enrollmentsTestFixture) onlySecret(t *testing.T) *corev1.Secret {
+	t.Helper()
+	list, err := f.secrets.List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, list.Items, 1)
+	return &list.Items[0]
+}
+
+func TestEnrollAndVerify(t *testing.T) {
+	ctx := context.Background()
+	f := newEnrollmentsTestFixture(t)
+
+	enrolled, err := f.enrollments.IsEnrolled(ctx, testSubject)
+	require.NoError(t, err)
+	require.False(t, enrolled)
+
+	ok, err := f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.False(t, ok, "users who have not enrolled do not have valid codes")
+
+	// Enroll using the code from the previous time step.
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, TimeStep(f.now)-1))
+
+	enrolled, err = f.enrollments.IsEnrolled(ctx, testSubject)
+	require.NoError(t, err)
+	require.True(t, enrolled)
+
+	secret := f.onlySecret(t)
+	require.Equal(t, corev1.SecretType("storage.pinniped.dev/"+TypeLabelValue), secret.Type)
+	require.Equal(t, TypeLabelValue, secret.Labels["storage.pinniped.dev/type"])
+	require.NotContains(t, string(secret.Data["pinniped-storage-data"]), rfc6238Secret, "the secret must be encrypted")
+	require.NotContains(t, secret.Name, "pinny", "the name must not reveal the subject")
+
+	ok, err = f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// The same code cannot be used twice.
+	ok, err = f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// The code from the next time step can be used.
+	f.now = f.now.Add(period)
+	ok, err = f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestEnrollReplacesPreviousEnrollment(t *testing.T) {
+	ctx := context.Background()
+	f := newEnrollmentsTestFixture(t)
+
+	otherSecret, err := GenerateSecret(rand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, otherSecret, 0))
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0))
+
+	f.onlySecret(t)
+
+	ok, err := f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestVerifyLocksOutAfterTooManyFailures(t *testing.T) {
+	ctx := context.Background()
+	f := newEnrollmentsTestFixture(t)
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0))
+
+	for range maxFailedAttempts {
+		ok, err := f.enrollments.Verify(ctx, testSubject, f.wrongCode(t))
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+
+	// Even the correct code is not accepted while locked out.
+	ok, err := f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	f.now = f.now.Add(lockoutDuration - time.Second)
+	ok, err = f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// The lockout is lifted once the lockout duration has passed since the most recent incorrect code.
+	f.now = f.now.Add(time.Second)
+	ok, err = f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// The successful code reset the failure count, so a single incorrect code does not lock the user out again.
+	f.now = f.now.Add(period)
+	ok, err = f.enrollments.Verify(ctx, testSubject, f.wrongCode(t))
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestVerifyFewerFailuresThanLimitDoNotLockOut(t *testing.T) {
+	ctx := context.Background()
+	f := newEnrollmentsTestFixture(t)
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0))
+
+	for range maxFailedAttempts - 1 {
+		ok, err := f.enrollments.Verify(ctx, testSubject, f.wrongCode(t))
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+
+	ok, err := f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// racingSecrets emulates the optimistic concurrency of the Kubernetes API, which the fake clientset does not, and
+// makes its first reads wait for each other, so that they all observe the same resource version.
+type racingSecrets struct {
+	corev1client.SecretInterface
+
+	lock         sync.Mutex
+	waitingReads int
+	allRead      chan struct{}
+}
+
+func (s *racingSecrets) Get(ctx context.Context, name string, opts metav1.GetOptions) (*corev1.Secret, error) {
+	s.lock.Lock()
+	wait := s.waitingReads > 0
+	if wait {
+		s.waitingReads--
+		if s.waitingReads == 0 {
+			close(s.allRead)
+		}
+	}
+	s.lock.Unlock()
+
+	secret, err := s.SecretInterface.Get(ctx, name, opts)
+	if wait {
+		<-s.allRead
+	}
+	return secret, err
+}
+
+func (s *racingSecrets) Update(ctx context.Context, secret *corev1.Secret, opts metav1.UpdateOptions) (*corev1.Secret, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current, err := s.SecretInterface.Get(ctx, secret.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if current.ResourceVersion != secret.ResourceVersion {
+		return nil, apierrors.NewConflict(corev1.Resource("secrets"), secret.Name, errors.New("changed"))
+	}
+	resourceVersion, _ := strconv.Atoi(current.ResourceVersion)
+	secret = secret.DeepCopy()
+	secret.ResourceVersion = strconv.Itoa(resourceVersion + 1)
+	return s.SecretInterface.Update(ctx, secret, opts)
+}
+
+func TestVerifyCountsConcurrentIncorrectCodes(t *testing.T) {
+	ctx := context.Background()
+	f := newEnrollmentsTestFixture(t)
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0))
+
+	// Each incorrect code is counted, even when all of them were sent at the same time.
+	// Each storage attempt succeeds for at least one of them, so all of them are eventually counted.
+	const concurrentCodes = maxStorageAttempts
+	secrets := &racingSecrets{SecretInterface: f.secrets, waitingReads: concurrentCodes, allRead: make(chan struct{})}
+	enrollments := NewEnrollments(testIssuer, secrets, func() []byte { return f.key }, rand.Reader, func() time.Time { return f.now })
+
+	wrongCode := f.wrongCode(t)
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrentCodes)
+	for range concurrentCodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := enrollments.Verify(ctx, testSubject, wrongCode)
+			if ok {
+				err = errors.New("an incorrect code was accepted")
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	stored, _, err := f.enrollments.get(ctx, testSubject)
+	require.NoError(t, err)
+	require.Equal(t, concurrentCodes, stored.FailedAttempts)
+
+	for range maxFailedAttempts - concurrentCodes {
+		ok, err := f.enrollments.Verify(ctx, testSubject, f.wrongCode(t))
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+
+	ok, err := f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.False(t, ok, "the end user must be locked out")
+}
+
+func TestVerifyFailsWhenIncorrectCodeCannotBeCounted(t *testing.T) {
+	ctx := context.Background()
+	f := newEnrollmentsTestFixture(t)
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0))
+
+	updates := 0
+	f.client.PrependReactor("update", "secrets", func(action coretesting.Action) (bool, runtime.Object, error) {
+		updates++
+		return true, nil, apierrors.NewConflict(corev1.Resource("secrets"), "some-name", errors.New("changed"))
+	})
+
+	ok, err := f.enrollments.Verify(ctx, testSubject, f.wrongCode(t))
+	require.ErrorContains(t, err, "failed to update totp enrollment: ")
+	require.True(t, apierrors.IsConflict(err))
+	require.False(t, ok)
+	require.Equal(t, maxStorageAttempts, updates)
+}
+
+func TestEncryptedSecretIsBoundToSubject(t *testing.T) {
+	ctx := context.Background()
+	f := newEnrollmentsTestFixture(t)
+	const otherSubject = "https://upstream.example.com?idpName=some-idp&sub=other"
+
+	otherSecret, err := GenerateSecret(rand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, f.enrollments.Enroll(ctx, otherSubject, otherSecret, 0))
+	victimStorage := f.onlySecret(t)
+
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0))
+
+	// Copy the enrollment of testSubject, including its encrypted secret, over the enrollment of otherSubject,
+	// as someone with write access to the Secrets but without the encryption key could try to do.
+	list, err := f.secrets.List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, list.Items, 2)
+	var attackerStorage *corev1.Secret
+	for i := range list.Items {
+		if list.Items[i].Name != victimStorage.Name {
+			attackerStorage = &list.Items[i]
+		}
+	}
+	victimStorage.Data["pinniped-storage-data"] = attackerStorage.Data["pinniped-storage-data"]
+	_, err = f.secrets.Update(ctx, victimStorage, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	ok, err := f.enrollments.Verify(ctx, otherSubject, f.code(t))
+	require.ErrorContains(t, err, "could not decrypt totp enrollment: ")
+	require.False(t, ok)
+
+	// The enrollment of testSubject is unaffected.
+	ok, err = f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestEnrollmentsWithDifferentIssuersAreSeparate(t *testing.T) {
+	ctx := context.Background()
+	f := newEnrollmentsTestFixture(t)
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0))
+
+	other := NewEnrollments("https://other-issuer.example.com", f.secrets, func() []byte { return f.key }, rand.Reader, func() time.Time { return f.now })
+	enrolled, err := other.IsEnrolled(ctx, testSubject)
+	require.NoError(t, err)
+	require.False(t, enrolled)
+}
+
+func TestEnrollmentStorageVersionMismatch(t *testing.T) {
+	ctx := context.Background()
+	f := newEnrollmentsTestFixture(t)
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0))
+
+	secret := f.onlySecret(t)
+	data := string(secret.Data["pinniped-storage-data"])
+	require.Contains(t, data, `"version":"1"`)
+	secret.Data["pinniped-storage-data"] = []byte(strings.Replace(data, `"version":"1"`, `"version":"2"`, 1))
+	_, err := f.secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	_, err = f.enrollments.IsEnrolled(ctx, testSubject)
+	require.ErrorIs(t, err, ErrInvalidEnrollmentVersion)
+	require.EqualError(t, err, "totp enrollment data has wrong version: enrollment has version 2 instead of 1")
+
+	_, err = f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.ErrorIs(t, err, ErrInvalidEnrollmentVersion)
+
+	_, err = f.enrollments.Check(ctx, PolicyWhenEnrolled, testSubject, f.code(t))
+	require.ErrorIs(t, err, ErrInvalidEnrollmentVersion)
+}
+
+func TestMissingEncryptionKey(t *testing.T) {
+	ctx := context.Background()
+	f := newEnrollmentsTestFixture(t)
+	f.key = nil
+
+	err := f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0)
+	require.ErrorIs(t, err, ErrMissingEncryptionKey)
+
+	f.key = bytes.Repeat([]byte{0x42}, 32)
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0))
+
+	f.key = nil
+	_, err = f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.ErrorIs(t, err, ErrMissingEncryptionKey)
+}
+
+func TestRotatedEncryptionKeyCannotDecrypt(t *testing.T) {
+	ctx := context.Background()
+	f := newEnrollmentsTestFixture(t)
+	require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0))
+
+	f.key = bytes.Repeat([]byte{0x43}, 32)
+	_, err := f.enrollments.Verify(ctx, testSubject, f.code(t))
+	require.ErrorContains(t, err, "could not decrypt totp enrollment: ")
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     Policy
+		enrolled   bool
+		code       func(f *enrollmentsTestFixture, t *testing.T) string
+		wantResult CheckResult
+	}{
+		{
+			name:       "no policy, not enrolled",
+			policy:     PolicyNone,
+			wantResult: CheckPassed,
+		},
+		{
+			name:       "no policy, enrolled, no code",
+			policy:     PolicyNone,
+			enrolled:   true,
+			wantResult: CheckPassed,
+		},
+		{
+			name:       "when enrolled policy, not enrolled",
+			policy:     PolicyWhenEnrolled,
+			wantResult: CheckPassed,
+		},
+		{
+			name:       "when enrolled policy, enrolled, no code",
+			policy:     PolicyWhenEnrolled,
+			enrolled:   true,
+			wantResult: CheckCodeRequired,
+		},
+		{
+			name:       "when enrolled policy, enrolled, correct code",
+			policy:     PolicyWhenEnrolled,
+			enrolled:   true,
+			code:       (*enrollmentsTestFixture).code,
+			wantResult: CheckPassed,
+		},
+		{
+			name:       "when enrolled policy, enrolled, wrong code",
+			policy:     PolicyWhenEnrolled,
+			enrolled:   true,
+			code:       (*enrollmentsTestFixture).wrongCode,
+			wantResult: CheckCodeNotAccepted,
+		},
+		{
+			name:       "required policy, not enrolled",
+			policy:     PolicyRequired,
+			wantResult: CheckEnrollmentRequired,
+		},
+		{
+			name:       "required policy, not enrolled, with code",
+			policy:     PolicyRequired,
+			code:       (*enrollmentsTestFixture).code,
+			wantResult: CheckEnrollmentRequired,
+		},
+		{
+			name:       "required policy, enrolled, no code",
+			policy:     PolicyRequired,
+			enrolled:   true,
+			wantResult: CheckCodeRequired,
+		},
+		{
+			name:       "required policy, enrolled, correct code",
+			policy:     PolicyRequired,
+			enrolled:   true,
+			code:       (*enrollmentsTestFixture).code,
+			wantResult: CheckPassed,
+		},
+		{
+			name:       "required policy, enrolled, wrong code",
+			policy:     PolicyRequired,
+			enrolled:   true,
+			code:       (*enrollmentsTestFixture).wrongCode,
+			wantResult: CheckCodeNotAccepted,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newEnrollmentsTestFixture(t)
+			if test.enrolled {
+				require.NoError(t, f.enrollments.Enroll(ctx, testSubject, rfc6238Secret, 0))
+			}
+			code := ""
+			if test.code != nil {
+				code = test.code(f, t)
+			}
+
+			result, err := f.enrollments.Check(ctx, test.policy, testSubject, code)
+			require.NoError(t, err)
+			require.Equal(t, test.wantResult, result)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package totp implements time-based one-time passwords (TOTP), as described by RFC 6238, which the Supervisor
+// can require from end users as a second factor during logins. It also stores the TOTP enrollments of end users.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // RFC 6238 uses HMAC-SHA-1 by default, and authenticator apps expect it
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Policy describes when end users must provide a one-time password during login.
+type Policy string
+
+const (
+	// PolicyNone means that end users do not need to provide a one-time password.
+	PolicyNone Policy = ""
+
+	// PolicyRequired means that all end users must provide a one-time password, so end users who have not enrolled
+	// cannot log in.
+	PolicyRequired Policy = "Required"
+
+	// PolicyWhenEnrolled means that only end users who have enrolled must provide a one-time password.
+	PolicyWhenEnrolled Policy = "WhenEnrolled"
+)
+
+const (
+	// secretSize is the length, in bytes, of generated secrets. RFC 4226 recommends 160 bits.
+	secretSize = 20
+
+	// period is the length of each time step, which is the default from RFC 6238 and is what authenticator apps expect.
+	period = 30 * time.Second
+
+	// digits is the number of digits in each code.
+	digits = 6
+
+	// allowedSkew is the number of time steps before and after the current time step which are also accepted,
+	// to allow for clock drift between the Supervisor and the end user's device, and for slow typists.
+	allowedSkew = 1
+)
+
+//nolint:gochecknoglobals // This is effectively a constant.
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, encoded as base32 without padding so it can be entered into
+// an authenticator app by hand.
+func GenerateSecret(rand io.Reader) (string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := io.ReadFull(rand, secret); err != nil {
+		return "", fmt.Errorf("could not generate totp secret: %w", err)
+	}
+	return secretEncoding.EncodeToString(secret), nil
+}
+
+// KeyURI returns the otpauth URI for the secret, which authenticator apps can import, e.g. from a QR code.
+// See https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+func KeyURI(issuer, accountName, secret string) string {
+	query := url.Values{
+		"secret":    []string{secret},
+		"issuer":    []string{issuer},
+		"algorithm": []string{"SHA1"},
+		"digits":    []string{fmt.Sprintf("%d", digits)},
+		"period":    []string{fmt.Sprintf("%d", int(period.Seconds()))},
+	}
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + accountName,
+		RawQuery: query.Encode(),
+	}).String()
+}
+
+// TimeStep returns the RFC 6238 time step which contains the given time.
+func TimeStep(when time.Time) int64 {
+	return when.Unix() / int64(period.Seconds())
+}
+
+// Validate checks the code against the secret at the given time. Codes from time steps at or before
+// lastUsedTimeStep are not accepted, so each code can only be used once. When the code is accepted,
+// it returns the time step of the code, which should be remembered as the new lastUsedTimeStep.
+func Validate(secret, code string, when time.Time, lastUsedTimeStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != digits {
+		return 0, false
+	}
+
+	secretBytes, err := secretEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	currentTimeStep := TimeStep(when)
+	for timeStep := currentTimeStep - allowedSkew; timeStep <= currentTimeStep+allowedSkew; timeStep++ {
+		if timeStep <= lastUsedTimeStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(generateCode(secretBytes, timeStep)), []byte(code)) == 1 {
+			return timeStep, true
+		}
+	}
+	return 0, false
+}
+
+// generateCode implements the HOTP algorithm from RFC 4226 using the time step as the counter.
+func generateCode(secret []byte, timeStep int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(timeStep))
+
+	mac := hmac.New(sha1.New, secret)
+	_, _ = mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// See https://datatracker.ietf.org/doc/html/rfc4226#section-5.4.
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", digits, value%1_000_000)
+}
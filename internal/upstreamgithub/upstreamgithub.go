@@ -8,6 +8,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
 
 	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
@@ -34,6 +35,13 @@ type ProviderConfig struct {
 	UsernameAttribute  idpv1alpha1.GitHubUsernameAttribute
 	GroupNameAttribute idpv1alpha1.GitHubGroupNameAttribute
 
+	// OrganizationRoleGroups, when true, means to add a group for the user's role in each of their organizations.
+	OrganizationRoleGroups bool
+
+	// AdditionalClaimMappings maps the names of downstream additional claims to the GitHub user attributes
+	// whose values should be used for those claims.
+	AdditionalClaimMappings map[string]idpv1alpha1.GitHubAdditionalClaimAttribute
+
 	// AllowedOrganizations, when empty, means to allow users from all orgs.
 	AllowedOrganizations *setutil.CaseInsensitiveSet
 
@@ -41,6 +49,10 @@ type ProviderConfig struct {
 	// This client should be configured with the user-provided CA bundle and a timeout.
 	HttpClient *http.Client
 
+	// ResponseCache, when not nil, caches the responses of the GitHub API for each access token and remembers
+	// the rate limits which the GitHub API reported for each access token.
+	ResponseCache *githubclient.ResponseCache
+
 	// OAuth2Config contains ClientID, ClientSecret, Scopes, and Endpoint (which contains auth and token endpoint URLs,
 	// and auth style for the token endpoint).
 	// OAuth2Config will not be used to compute the authorize URL because the redirect back to the Supervisor's
@@ -116,7 +128,12 @@ func (p *Provider) ExchangeAuthcode(ctx context.Context, authcode string, redire
 // they will be allowed to log in.
 // Note that errors from the githubclient package already have helpful error prefixes, so there is no need for additional prefixes here.
 func (p *Provider) GetUser(ctx context.Context, accessToken string, idpDisplayName string) (*upstreamprovider.GitHubUser, error) {
-	githubClient, err := p.buildGitHubClient(p.c.HttpClient, p.c.APIBaseURL, accessToken)
+	httpClient := p.c.HttpClient
+	if p.c.ResponseCache != nil {
+		httpClient = p.c.ResponseCache.HTTPClient(httpClient)
+	}
+
+	githubClient, err := p.buildGitHubClient(httpClient, p.c.APIBaseURL, accessToken)
 	if err != nil {
 		return nil, err
 	}
@@ -183,9 +200,91 @@ func (p *Provider) GetUser(ctx context.Context, accessToken string, idpDisplayNa
 		githubUser.Groups = append(githubUser.Groups, downstreamGroup)
 	}
 
+	var orgRoles []githubclient.OrgRoleInfo
+	if p.c.OrganizationRoleGroups || p.hasAdditionalClaimMapping(idpv1alpha1.GitHubAdditionalClaimOrganizationRoles) {
+		orgRoles, err = githubClient.GetOrgRoles(ctx, p.c.AllowedOrganizations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.c.OrganizationRoleGroups {
+		for _, orgRole := range orgRoles {
+			githubUser.Groups = append(githubUser.Groups, fmt.Sprintf("%s:%s", orgRole.Org, orgRole.Role))
+		}
+	}
+
+	githubUser.AdditionalClaims, err = p.additionalClaims(ctx, githubClient, userInfo, orgMembership, orgRoles)
+	if err != nil {
+		return nil, err
+	}
+
 	return &githubUser, nil
 }
 
+func (p *Provider) hasAdditionalClaimMapping(attribute idpv1alpha1.GitHubAdditionalClaimAttribute) bool {
+	for _, mappedAttribute := range p.c.AdditionalClaimMappings {
+		if mappedAttribute == attribute {
+			return true
+		}
+	}
+	return false
+}
+
+// additionalClaims returns the additional claims mapped from the GitHub user's attributes, or nil when there are
+// no AdditionalClaimMappings. Attributes which are not available for the user are skipped.
+func (p *Provider) additionalClaims(
+	ctx context.Context,
+	githubClient githubclient.GitHubInterface,
+	userInfo *githubclient.UserInfo,
+	orgMembership []string,
+	orgRoles []githubclient.OrgRoleInfo,
+) (map[string]any, error) {
+	if len(p.c.AdditionalClaimMappings) == 0 {
+		return nil, nil
+	}
+
+	samlNameID := ""
+	if p.hasAdditionalClaimMapping(idpv1alpha1.GitHubAdditionalClaimSAMLNameID) {
+		// Sort the organizations so the same organization's linked identity is always used.
+		var organizations []string
+		for _, org := range orgMembership {
+			if p.c.AllowedOrganizations.Empty() || p.c.AllowedOrganizations.ContainsIgnoringCase(org) {
+				organizations = append(organizations, org)
+			}
+		}
+		slices.Sort(organizations)
+
+		var err error
+		samlNameID, err = githubClient.GetSAMLNameID(ctx, userInfo.Login, organizations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	additionalClaims := make(map[string]any, len(p.c.AdditionalClaimMappings))
+	for claimName, attribute := range p.c.AdditionalClaimMappings {
+		switch attribute {
+		case idpv1alpha1.GitHubAdditionalClaimSAMLNameID:
+			if samlNameID == "" {
+				plog.Debug("additionalClaims mapping attribute not available for user",
+					"upstreamName", p.GetResourceName(), "claimName", claimName, "attribute", attribute)
+				continue
+			}
+			additionalClaims[claimName] = samlNameID
+		case idpv1alpha1.GitHubAdditionalClaimOrganizationRoles:
+			roles := make(map[string]any, len(orgRoles))
+			for _, orgRole := range orgRoles {
+				roles[orgRole.Org] = orgRole.Role
+			}
+			additionalClaims[claimName] = roles
+		default:
+			return nil, fmt.Errorf("bad configuration: unknown GitHub additional claim attribute: %s", attribute)
+		}
+	}
+	return additionalClaims, nil
+}
+
 // GetConfig returns the config. This is not part of the UpstreamGithubIdentityProviderI interface and is just for testing.
 func (p *Provider) GetConfig() ProviderConfig {
 	return p.c
@@ -18,6 +18,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/util/cert"
+	"k8s.io/utils/clock"
 
 	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
 	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
@@ -228,6 +229,27 @@ func TestGetUser(t *testing.T) {
 				DownstreamSubject: fmt.Sprintf("https://some-url?idpName=%s&login=some-github-login&id=some-github-id", encodedIDPDisplayName),
 			},
 		},
+		{
+			name: "happy path with a response cache",
+			providerConfig: ProviderConfig{
+				APIBaseURL:        "https://some-url",
+				HttpClient:        someHttpClient,
+				ResponseCache:     githubclient.NewResponseCache(clock.RealClock{}),
+				UsernameAttribute: idpv1alpha1.GitHubUsernameLoginAndID,
+			},
+			buildMockResponses: func(mockGitHubInterface *mockgithubclient.MockGitHubInterface) {
+				mockGitHubInterface.EXPECT().GetUserInfo(someContext).Return(&githubclient.UserInfo{
+					Login: "some-github-login",
+					ID:    "some-github-id",
+				}, nil)
+				mockGitHubInterface.EXPECT().GetOrgMembership(someContext).Return(nil, nil)
+				mockGitHubInterface.EXPECT().GetTeamMembership(someContext, gomock.Any()).Return(nil, nil)
+			},
+			wantUser: &upstreamprovider.GitHubUser{
+				Username:          "some-github-login:some-github-id",
+				DownstreamSubject: fmt.Sprintf("https://some-url?idpName=%s&login=some-github-login&id=some-github-id", encodedIDPDisplayName),
+			},
+		},
 		{
 			name: "happy path with username=login",
 			providerConfig: ProviderConfig{
@@ -384,6 +406,100 @@ func TestGetUser(t *testing.T) {
 				DownstreamSubject: fmt.Sprintf("https://some-url?idpName=%s&login=some-github-login&id=some-github-id", encodedIDPDisplayName),
 			},
 		},
+		{
+			name: "happy path with organizationRoleGroups",
+			providerConfig: ProviderConfig{
+				APIBaseURL:             "https://some-url",
+				HttpClient:             someHttpClient,
+				UsernameAttribute:      idpv1alpha1.GitHubUsernameLoginAndID,
+				AllowedOrganizations:   setutil.NewCaseInsensitiveSet("allowed-org1", "allowed-org2"),
+				GroupNameAttribute:     idpv1alpha1.GitHubUseTeamSlugForGroupName,
+				OrganizationRoleGroups: true,
+			},
+			buildMockResponses: func(mockGitHubInterface *mockgithubclient.MockGitHubInterface) {
+				mockGitHubInterface.EXPECT().GetUserInfo(someContext).Return(&githubclient.UserInfo{
+					Login: "some-github-login",
+					ID:    "some-github-id",
+				}, nil)
+				mockGitHubInterface.EXPECT().GetOrgMembership(someContext).Return([]string{"allowed-org2"}, nil)
+				mockGitHubInterface.EXPECT().GetTeamMembership(someContext, setutil.NewCaseInsensitiveSet("allowed-org1", "allowed-org2")).Return([]githubclient.TeamInfo{
+					{
+						Name: "team1-name",
+						Slug: "team1-slug",
+						Org:  "allowed-org2",
+					},
+				}, nil)
+				mockGitHubInterface.EXPECT().GetOrgRoles(someContext, setutil.NewCaseInsensitiveSet("allowed-org1", "allowed-org2")).Return([]githubclient.OrgRoleInfo{
+					{Org: "allowed-org1", Role: githubclient.OrgRoleOutsideCollaborator},
+					{Org: "allowed-org2", Role: githubclient.OrgRoleAdmin},
+				}, nil)
+			},
+			wantUser: &upstreamprovider.GitHubUser{
+				Username:          "some-github-login:some-github-id",
+				Groups:            []string{"allowed-org2/team1-slug", "allowed-org1:outside_collaborator", "allowed-org2:admin"},
+				DownstreamSubject: fmt.Sprintf("https://some-url?idpName=%s&login=some-github-login&id=some-github-id", encodedIDPDisplayName),
+			},
+		},
+		{
+			name: "happy path with additionalClaimMappings",
+			providerConfig: ProviderConfig{
+				APIBaseURL:           "https://some-url",
+				HttpClient:           someHttpClient,
+				UsernameAttribute:    idpv1alpha1.GitHubUsernameLoginAndID,
+				AllowedOrganizations: setutil.NewCaseInsensitiveSet("allowed-org1", "allowed-org2"),
+				AdditionalClaimMappings: map[string]idpv1alpha1.GitHubAdditionalClaimAttribute{
+					"email": idpv1alpha1.GitHubAdditionalClaimSAMLNameID,
+					"roles": idpv1alpha1.GitHubAdditionalClaimOrganizationRoles,
+				},
+			},
+			buildMockResponses: func(mockGitHubInterface *mockgithubclient.MockGitHubInterface) {
+				mockGitHubInterface.EXPECT().GetUserInfo(someContext).Return(&githubclient.UserInfo{
+					Login: "some-github-login",
+					ID:    "some-github-id",
+				}, nil)
+				mockGitHubInterface.EXPECT().GetOrgMembership(someContext).Return([]string{"other-org", "allowed-org2", "Allowed-Org1"}, nil)
+				mockGitHubInterface.EXPECT().GetTeamMembership(someContext, gomock.Any()).Return(nil, nil)
+				mockGitHubInterface.EXPECT().GetOrgRoles(someContext, gomock.Any()).Return([]githubclient.OrgRoleInfo{
+					{Org: "Allowed-Org1", Role: githubclient.OrgRoleMember},
+					{Org: "allowed-org2", Role: githubclient.OrgRoleAdmin},
+				}, nil)
+				mockGitHubInterface.EXPECT().GetSAMLNameID(someContext, "some-github-login", []string{"Allowed-Org1", "allowed-org2"}).
+					Return("some-user@example.com", nil)
+			},
+			wantUser: &upstreamprovider.GitHubUser{
+				Username:          "some-github-login:some-github-id",
+				DownstreamSubject: fmt.Sprintf("https://some-url?idpName=%s&login=some-github-login&id=some-github-id", encodedIDPDisplayName),
+				AdditionalClaims: map[string]any{
+					"email": "some-user@example.com",
+					"roles": map[string]any{"Allowed-Org1": "member", "allowed-org2": "admin"},
+				},
+			},
+		},
+		{
+			name: "happy path with additionalClaimMappings when the user has no linked SAML identity",
+			providerConfig: ProviderConfig{
+				APIBaseURL:        "https://some-url",
+				HttpClient:        someHttpClient,
+				UsernameAttribute: idpv1alpha1.GitHubUsernameLoginAndID,
+				AdditionalClaimMappings: map[string]idpv1alpha1.GitHubAdditionalClaimAttribute{
+					"email": idpv1alpha1.GitHubAdditionalClaimSAMLNameID,
+				},
+			},
+			buildMockResponses: func(mockGitHubInterface *mockgithubclient.MockGitHubInterface) {
+				mockGitHubInterface.EXPECT().GetUserInfo(someContext).Return(&githubclient.UserInfo{
+					Login: "some-github-login",
+					ID:    "some-github-id",
+				}, nil)
+				mockGitHubInterface.EXPECT().GetOrgMembership(someContext).Return([]string{"org2", "org1"}, nil)
+				mockGitHubInterface.EXPECT().GetTeamMembership(someContext, gomock.Any()).Return(nil, nil)
+				mockGitHubInterface.EXPECT().GetSAMLNameID(someContext, "some-github-login", []string{"org1", "org2"}).Return("", nil)
+			},
+			wantUser: &upstreamprovider.GitHubUser{
+				Username:          "some-github-login:some-github-id",
+				DownstreamSubject: fmt.Sprintf("https://some-url?idpName=%s&login=some-github-login&id=some-github-id", encodedIDPDisplayName),
+				AdditionalClaims:  map[string]any{},
+			},
+		},
 		{
 			name: "returns errors from buildGitHubClient()",
 			providerConfig: ProviderConfig{
@@ -431,6 +547,40 @@ func TestGetUser(t *testing.T) {
 			},
 			wantErrMsg: "error from githubClient.GetTeamMembership",
 		},
+		{
+			name: "returns errors from githubClient.GetOrgRoles()",
+			providerConfig: ProviderConfig{
+				APIBaseURL:             "https://some-url",
+				HttpClient:             someHttpClient,
+				UsernameAttribute:      idpv1alpha1.GitHubUsernameLoginAndID,
+				OrganizationRoleGroups: true,
+			},
+			buildMockResponses: func(mockGitHubInterface *mockgithubclient.MockGitHubInterface) {
+				mockGitHubInterface.EXPECT().GetUserInfo(someContext).Return(&githubclient.UserInfo{}, nil)
+				mockGitHubInterface.EXPECT().GetOrgMembership(someContext).Return(nil, nil)
+				mockGitHubInterface.EXPECT().GetTeamMembership(someContext, gomock.Any()).Return(nil, nil)
+				mockGitHubInterface.EXPECT().GetOrgRoles(someContext, gomock.Any()).Return(nil, errors.New("error from githubClient.GetOrgRoles"))
+			},
+			wantErrMsg: "error from githubClient.GetOrgRoles",
+		},
+		{
+			name: "returns errors from githubClient.GetSAMLNameID()",
+			providerConfig: ProviderConfig{
+				APIBaseURL:        "https://some-url",
+				HttpClient:        someHttpClient,
+				UsernameAttribute: idpv1alpha1.GitHubUsernameLoginAndID,
+				AdditionalClaimMappings: map[string]idpv1alpha1.GitHubAdditionalClaimAttribute{
+					"email": idpv1alpha1.GitHubAdditionalClaimSAMLNameID,
+				},
+			},
+			buildMockResponses: func(mockGitHubInterface *mockgithubclient.MockGitHubInterface) {
+				mockGitHubInterface.EXPECT().GetUserInfo(someContext).Return(&githubclient.UserInfo{}, nil)
+				mockGitHubInterface.EXPECT().GetOrgMembership(someContext).Return(nil, nil)
+				mockGitHubInterface.EXPECT().GetTeamMembership(someContext, gomock.Any()).Return(nil, nil)
+				mockGitHubInterface.EXPECT().GetSAMLNameID(someContext, gomock.Any(), gomock.Any()).Return("", errors.New("error from githubClient.GetSAMLNameID"))
+			},
+			wantErrMsg: "error from githubClient.GetSAMLNameID",
+		},
 		{
 			name: "bad configuration: UsernameAttribute",
 			providerConfig: ProviderConfig{
@@ -486,7 +636,12 @@ func TestGetUser(t *testing.T) {
 
 			p := New(test.providerConfig)
 			p.buildGitHubClient = func(httpClient *http.Client, apiBaseURL, token string) (githubclient.GitHubInterface, error) {
-				require.Equal(t, test.providerConfig.HttpClient, httpClient)
+				if test.providerConfig.ResponseCache != nil {
+					require.Equal(t, test.providerConfig.HttpClient.Timeout, httpClient.Timeout)
+					require.NotEqual(t, test.providerConfig.HttpClient.Transport, httpClient.Transport, "should use the response cache")
+				} else {
+					require.Equal(t, test.providerConfig.HttpClient, httpClient)
+				}
 				require.Equal(t, test.providerConfig.APIBaseURL, apiBaseURL)
 				require.Equal(t, accessToken, token)
 
@@ -0,0 +1,255 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package upstreamgitlab implements an abstraction of upstream GitLab provider interactions.
+package upstreamgitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/downstreamsubject"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/gitlabclient"
+	"go.pinniped.dev/internal/plog"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+// ProviderConfig holds the active configuration of an upstream GitLab provider.
+type ProviderConfig struct {
+	Name        string
+	ResourceUID types.UID
+
+	// APIBaseURL is the url of the GitLab REST API, not including the path to a specific API endpoint.
+	// It should look like https://HOSTNAME/api/v4, for both GitLab.com and for self-managed GitLab.
+	APIBaseURL string
+
+	UsernameAttribute  idpv1alpha1.GitLabUsernameAttribute
+	GroupNameAttribute idpv1alpha1.GitLabGroupNameAttribute
+
+	// AllowedGroups, when empty, means to allow users from all groups.
+	// Otherwise, it contains the full paths of the allowed groups.
+	AllowedGroups []string
+
+	// FlattenSubgroups, when true, means that membership in a subgroup should also be treated as membership in
+	// each of the subgroup's ancestor groups.
+	FlattenSubgroups bool
+
+	// HttpClient is a client that can be used to call the GitLab APIs and token endpoint.
+	// This client should be configured with the user-provided CA bundle and a timeout.
+	HttpClient *http.Client
+
+	// OAuth2Config contains ClientID, ClientSecret, Scopes, and Endpoint (which contains auth and token endpoint URLs,
+	// and auth style for the token endpoint).
+	// OAuth2Config will not be used to compute the authorize URL because the redirect back to the Supervisor's
+	// callback must be different per FederationDomain. It holds data that may be useful when calculating the
+	// authorize URL, so that data is exposed by interface methods. However, it can be used to call the token endpoint,
+	// for which there is no RedirectURL needed.
+	OAuth2Config *oauth2.Config
+}
+
+type Provider struct {
+	c                 ProviderConfig
+	buildGitLabClient func(httpClient *http.Client, apiBaseURL, token string) (gitlabclient.GitLabInterface, error)
+}
+
+var _ upstreamprovider.UpstreamGitLabIdentityProviderI = &Provider{}
+
+// New creates a Provider. The config is not a pointer to ensure that a copy of the config is created,
+// making the resulting Provider use an effectively read-only configuration.
+func New(config ProviderConfig) *Provider {
+	return &Provider{
+		c:                 config,
+		buildGitLabClient: gitlabclient.NewGitLabClient,
+	}
+}
+
+func (p *Provider) GetResourceName() string {
+	return p.c.Name
+}
+
+func (p *Provider) GetResourceUID() types.UID {
+	return p.c.ResourceUID
+}
+
+func (p *Provider) GetClientID() string {
+	return p.c.OAuth2Config.ClientID
+}
+
+func (p *Provider) GetScopes() []string {
+	return p.c.OAuth2Config.Scopes
+}
+
+func (p *Provider) GetUsernameAttribute() idpv1alpha1.GitLabUsernameAttribute {
+	return p.c.UsernameAttribute
+}
+
+func (p *Provider) GetGroupNameAttribute() idpv1alpha1.GitLabGroupNameAttribute {
+	return p.c.GroupNameAttribute
+}
+
+func (p *Provider) GetAllowedGroups() []string {
+	return p.c.AllowedGroups
+}
+
+func (p *Provider) GetFlattenSubgroups() bool {
+	return p.c.FlattenSubgroups
+}
+
+func (p *Provider) GetAuthorizationURL() string {
+	return p.c.OAuth2Config.Endpoint.AuthURL
+}
+
+func (p *Provider) ExchangeAuthcode(ctx context.Context, authcode string, pkceCodeVerifier pkce.Code, redirectURI string) (*oauth2.Token, error) {
+	tok, err := p.c.OAuth2Config.Exchange(
+		coreosoidc.ClientContext(ctx, p.c.HttpClient),
+		authcode,
+		pkceCodeVerifier.Verifier(),
+		oauth2.SetAuthURLParam("redirect_uri", redirectURI),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error exchanging authorization code using GitLab API: %w", err)
+	}
+	return tok, nil
+}
+
+func (p *Provider) PerformRefresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	// Use the provided HTTPClient to talk to the token endpoint.
+	httpClientContext := coreosoidc.ClientContext(ctx, p.c.HttpClient)
+
+	// Create a TokenSource without an access token, so it thinks that a refresh is immediately required.
+	// Then ask it for the tokens to cause it to perform the refresh and return the results.
+	tok, err := p.c.OAuth2Config.TokenSource(httpClientContext, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return nil, fmt.Errorf("error refreshing tokens using GitLab API: %w", err)
+	}
+	return tok, nil
+}
+
+// GetUser will use the provided configuration to make HTTPS calls to the GitLab API to get the identity of the
+// authenticated user and to discover their group memberships.
+// If the user's information meets the AllowedGroups criteria specified on the GitLabIdentityProvider,
+// they will be allowed to log in.
+// Note that errors from the gitlabclient package already have helpful error prefixes, so there is no need for additional prefixes here.
+func (p *Provider) GetUser(ctx context.Context, accessToken string, idpDisplayName string) (*upstreamprovider.GitLabUser, error) {
+	gitlabClient, err := p.buildGitLabClient(p.c.HttpClient, p.c.APIBaseURL, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	gitlabUser := upstreamprovider.GitLabUser{}
+
+	userInfo, err := gitlabClient.GetUserInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	gitlabUser.DownstreamSubject = downstreamsubject.GitLab(p.c.APIBaseURL, idpDisplayName, userInfo.Username, userInfo.ID)
+
+	switch p.c.UsernameAttribute {
+	case idpv1alpha1.GitLabUsernameLoginAndID:
+		gitlabUser.Username = fmt.Sprintf("%s:%s", userInfo.Username, userInfo.ID)
+	case idpv1alpha1.GitLabUsernameLogin:
+		gitlabUser.Username = userInfo.Username
+	case idpv1alpha1.GitLabUsernameID:
+		gitlabUser.Username = userInfo.ID
+	default:
+		return nil, fmt.Errorf("bad configuration: unknown GitLab username attribute: %s", p.c.UsernameAttribute)
+	}
+
+	if p.c.GroupNameAttribute != idpv1alpha1.GitLabUseFullPathForGroupName {
+		return nil, fmt.Errorf("bad configuration: unknown GitLab group name attribute: %s", p.c.GroupNameAttribute)
+	}
+
+	groupMembership, err := gitlabClient.GetGroupMembership(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedGroupMembership := filterToAllowedGroups(groupMembership, p.c.AllowedGroups)
+
+	if len(p.c.AllowedGroups) > 0 && len(allowedGroupMembership) == 0 {
+		plog.Warning("user is not allowed to log in due to group membership policy", // do not log username to avoid PII
+			"userBelongsToGroups", groupMembership,
+			"configuredAllowedGroups", p.c.AllowedGroups,
+			"identityProviderDisplayName", idpDisplayName,
+			"identityProviderResourceName", p.GetResourceName())
+		plog.Trace("user is not allowed to log in due to group membership policy", // okay to log PII at trace level
+			"gitlabUsername", userInfo.Username,
+			"gitlabID", userInfo.ID,
+			"calculatedUsername", gitlabUser.Username,
+			"userBelongsToGroups", groupMembership,
+			"configuredAllowedGroups", p.c.AllowedGroups,
+			"identityProviderDisplayName", idpDisplayName,
+			"identityProviderResourceName", p.GetResourceName())
+		return nil, upstreamprovider.NewGitLabLoginDeniedError("user is not allowed to log in due to group membership policy")
+	}
+
+	if p.c.FlattenSubgroups {
+		allowedGroupMembership = flattenSubgroups(allowedGroupMembership, p.c.AllowedGroups)
+	}
+
+	gitlabUser.Groups = allowedGroupMembership
+
+	return &gitlabUser, nil
+}
+
+// filterToAllowedGroups returns only those groups which are one of the allowed groups or a subgroup of
+// one of the allowed groups. When there are no allowed groups, all groups are returned.
+// GitLab group paths are case-insensitive, so the comparisons are also case-insensitive.
+func filterToAllowedGroups(groups []string, allowedGroups []string) []string {
+	if len(allowedGroups) == 0 {
+		return groups
+	}
+	var filtered []string
+	for _, group := range groups {
+		if isSameOrSubgroupOfAny(group, allowedGroups) {
+			filtered = append(filtered, group)
+		}
+	}
+	return filtered
+}
+
+// flattenSubgroups adds the full path of each ancestor of each group to the list of groups.
+// When there are allowed groups, ancestors above the allowed groups are not added,
+// to avoid revealing groups which were not allowed.
+func flattenSubgroups(groups []string, allowedGroups []string) []string {
+	flattened := sets.New[string]()
+	for _, group := range groups {
+		flattened.Insert(group)
+		for i := strings.LastIndex(group, "/"); i > 0; i = strings.LastIndex(group[:i], "/") {
+			ancestor := group[:i]
+			if len(allowedGroups) > 0 && !isSameOrSubgroupOfAny(ancestor, allowedGroups) {
+				break
+			}
+			flattened.Insert(ancestor)
+		}
+	}
+	result := flattened.UnsortedList()
+	slices.Sort(result)
+	return result
+}
+
+func isSameOrSubgroupOfAny(group string, parentGroups []string) bool {
+	for _, parent := range parentGroups {
+		if strings.EqualFold(group, parent) ||
+			(len(group) > len(parent) && group[len(parent)] == '/' && strings.EqualFold(group[:len(parent)], parent)) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConfig returns the config. This is not part of the UpstreamGitLabIdentityProviderI interface and is just for testing.
+func (p *Provider) GetConfig() ProviderConfig {
+	return p.c
+}
@@ -0,0 +1,487 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamgitlab
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"golang.org/x/oauth2"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/util/cert"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/gitlabclient"
+	"go.pinniped.dev/internal/mocks/mockgitlabclient"
+	"go.pinniped.dev/internal/testutil/tlsserver"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+func TestGitLabProvider(t *testing.T) {
+	subject := New(ProviderConfig{
+		Name:               "foo",
+		ResourceUID:        "resource-uid-12345",
+		APIBaseURL:         "https://fake-base-url",
+		UsernameAttribute:  "fake-username-attribute",
+		GroupNameAttribute: "fake-group-name-attribute",
+		OAuth2Config: &oauth2.Config{
+			ClientID:     "fake-client-id",
+			ClientSecret: "fake-client-secret",
+			Scopes:       []string{"scope1", "scope2"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   "https://fake-authorization-url",
+				TokenURL:  "https://fake-token-url",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		AllowedGroups:    []string{"fake-group", "fake-group2/subgroup"},
+		FlattenSubgroups: true,
+		HttpClient: &http.Client{
+			Timeout: 1234509,
+		},
+	})
+
+	require.Equal(t, ProviderConfig{
+		Name:               "foo",
+		ResourceUID:        "resource-uid-12345",
+		APIBaseURL:         "https://fake-base-url",
+		UsernameAttribute:  "fake-username-attribute",
+		GroupNameAttribute: "fake-group-name-attribute",
+		OAuth2Config: &oauth2.Config{
+			ClientID:     "fake-client-id",
+			ClientSecret: "fake-client-secret",
+			Scopes:       []string{"scope1", "scope2"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   "https://fake-authorization-url",
+				TokenURL:  "https://fake-token-url",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		AllowedGroups:    []string{"fake-group", "fake-group2/subgroup"},
+		FlattenSubgroups: true,
+		HttpClient: &http.Client{
+			Timeout: 1234509,
+		},
+	}, subject.GetConfig())
+
+	require.Equal(t, "foo", subject.GetResourceName())
+	require.Equal(t, types.UID("resource-uid-12345"), subject.GetResourceUID())
+	require.Equal(t, "fake-client-id", subject.GetClientID())
+	require.Equal(t, []string{"scope1", "scope2"}, subject.GetScopes())
+	require.Equal(t, idpv1alpha1.GitLabUsernameAttribute("fake-username-attribute"), subject.GetUsernameAttribute())
+	require.Equal(t, idpv1alpha1.GitLabGroupNameAttribute("fake-group-name-attribute"), subject.GetGroupNameAttribute())
+	require.Equal(t, []string{"fake-group", "fake-group2/subgroup"}, subject.GetAllowedGroups())
+	require.True(t, subject.GetFlattenSubgroups())
+	require.Equal(t, "https://fake-authorization-url", subject.GetAuthorizationURL())
+}
+
+func TestExchangeAuthcodeAndPerformRefresh(t *testing.T) {
+	const (
+		fakeGitLabAccessToken     = "fake-gitlab-access-token"      //nolint:gosec // this is not a credential
+		fakeGitLabRefreshToken    = "fake-gitlab-refresh-token"     //nolint:gosec // this is not a credential
+		fakeNewGitLabRefreshToken = "fake-new-gitlab-refresh-token" //nolint:gosec // this is not a credential
+	)
+
+	pkceCode := pkce.Code("fake-pkce-verifier-with-enough-characters-to-be-valid-1234567")
+
+	tests := []struct {
+		name              string
+		tokenEndpointPath string
+		performRefresh    bool
+		wantToken         *oauth2.Token
+		wantErr           string
+	}{
+		{
+			name:              "exchange authcode happy path",
+			tokenEndpointPath: "/token",
+			wantToken:         &oauth2.Token{AccessToken: fakeGitLabAccessToken, RefreshToken: fakeGitLabRefreshToken, TokenType: "Bearer"},
+		},
+		{
+			name:              "exchange authcode when the GitLab token endpoint returns an error",
+			tokenEndpointPath: "/token-error",
+			wantErr:           "error exchanging authorization code using GitLab API: oauth2: cannot fetch token: 401 Unauthorized\nResponse: some gitlab error",
+		},
+		{
+			name:              "perform refresh happy path",
+			tokenEndpointPath: "/token",
+			performRefresh:    true,
+			wantToken:         &oauth2.Token{AccessToken: fakeGitLabAccessToken, RefreshToken: fakeNewGitLabRefreshToken, TokenType: "Bearer"},
+		},
+		{
+			name:              "perform refresh when the GitLab token endpoint returns an error",
+			tokenEndpointPath: "/token-error",
+			performRefresh:    true,
+			wantErr:           "error refreshing tokens using GitLab API: oauth2: cannot fetch token: 401 Unauthorized\nResponse: some gitlab error",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			testServer, testServerCA := tlsserver.TestServerIPv4(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// See documentation at https://docs.gitlab.com/ee/api/oauth2.html
+				require.Equal(t, http.MethodPost, r.Method)
+				require.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+
+				err := r.ParseForm()
+				require.NoError(t, err)
+				params := r.PostForm
+				require.Equal(t, "fake-client-id", params.Get("client_id"))
+				require.Equal(t, "fake-client-secret", params.Get("client_secret"))
+
+				refreshTokenToReturn := fakeGitLabRefreshToken
+				if test.performRefresh {
+					require.Len(t, params, 4)
+					require.Equal(t, "refresh_token", params.Get("grant_type"))
+					require.Equal(t, fakeGitLabRefreshToken, params.Get("refresh_token"))
+					// GitLab rotates the refresh token.
+					refreshTokenToReturn = fakeNewGitLabRefreshToken
+				} else {
+					require.Len(t, params, 6)
+					require.Equal(t, "authorization_code", params.Get("grant_type"))
+					require.Equal(t, "https://fake-redirect-url", params.Get("redirect_uri"))
+					require.Equal(t, "fake-authcode", params.Get("code"))
+					require.Equal(t, string(pkceCode), params.Get("code_verifier"))
+				}
+
+				switch r.URL.Path {
+				case "/token":
+					w.Header().Set("content-type", "application/json")
+					_, err = fmt.Fprintf(w, `{"access_token":%q,"token_type":"Bearer","refresh_token":%q,"scope":"read_api","created_at":1607635748}`,
+						fakeGitLabAccessToken, refreshTokenToReturn)
+					require.NoError(t, err)
+				case "/token-error":
+					w.WriteHeader(http.StatusUnauthorized)
+					_, err = w.Write([]byte("some gitlab error"))
+					require.NoError(t, err)
+				default:
+					t.Fatalf("tried to call provider at unexpected endpoint: %s", r.URL.Path)
+				}
+			}), nil)
+			testServerPool, err := cert.NewPoolFromBytes(testServerCA)
+			require.NoError(t, err)
+
+			subject := New(ProviderConfig{
+				OAuth2Config: &oauth2.Config{
+					ClientID:     "fake-client-id",
+					ClientSecret: "fake-client-secret",
+					Scopes:       []string{"read_api"},
+					Endpoint: oauth2.Endpoint{
+						AuthURL:   "https://fake-auth-url",
+						TokenURL:  testServer.URL + test.tokenEndpointPath,
+						AuthStyle: oauth2.AuthStyleInParams,
+					},
+				},
+				HttpClient: &http.Client{
+					Timeout: 10 * time.Second,
+					Transport: &http.Transport{TLSClientConfig: &tls.Config{
+						MinVersion: tls.VersionTLS12,
+						RootCAs:    testServerPool,
+					}},
+				},
+			})
+
+			var tok *oauth2.Token
+			if test.performRefresh {
+				tok, err = subject.PerformRefresh(context.Background(), fakeGitLabRefreshToken)
+			} else {
+				tok, err = subject.ExchangeAuthcode(context.Background(), "fake-authcode", pkceCode, "https://fake-redirect-url")
+			}
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				require.Nil(t, tok)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.wantToken.AccessToken, tok.AccessToken)
+			require.Equal(t, test.wantToken.RefreshToken, tok.RefreshToken)
+			require.Equal(t, test.wantToken.TokenType, tok.TokenType)
+		})
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	const idpDisplayName = "idp display name 😀"
+	const encodedIDPDisplayName = "idp+display+name+%F0%9F%98%80"
+
+	someContext := context.Background()
+
+	someHttpClient := &http.Client{
+		Timeout: 1234509,
+	}
+
+	someUserInfo := &gitlabclient.UserInfo{
+		Username: "some-gitlab-username",
+		ID:       "some-gitlab-id",
+	}
+
+	wantSubject := fmt.Sprintf("https://some-url?idpName=%s&login=some-gitlab-username&id=some-gitlab-id", encodedIDPDisplayName)
+
+	tests := []struct {
+		name                   string
+		providerConfig         ProviderConfig
+		buildGitLabClientError error
+		buildMockResponses     func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface)
+		wantUser               *upstreamprovider.GitLabUser
+		wantErrMsg             string
+		wantErr                error
+	}{
+		{
+			name: "happy path with username=login:id",
+			providerConfig: ProviderConfig{
+				APIBaseURL:         "https://some-url",
+				HttpClient:         someHttpClient,
+				UsernameAttribute:  idpv1alpha1.GitLabUsernameLoginAndID,
+				GroupNameAttribute: idpv1alpha1.GitLabUseFullPathForGroupName,
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(someUserInfo, nil)
+				mockGitLabInterface.EXPECT().GetGroupMembership(someContext).Return(nil, nil)
+			},
+			wantUser: &upstreamprovider.GitLabUser{
+				Username:          "some-gitlab-username:some-gitlab-id",
+				DownstreamSubject: wantSubject,
+			},
+		},
+		{
+			name: "happy path with username=login",
+			providerConfig: ProviderConfig{
+				APIBaseURL:         "https://some-url",
+				HttpClient:         someHttpClient,
+				UsernameAttribute:  idpv1alpha1.GitLabUsernameLogin,
+				GroupNameAttribute: idpv1alpha1.GitLabUseFullPathForGroupName,
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(someUserInfo, nil)
+				mockGitLabInterface.EXPECT().GetGroupMembership(someContext).Return(nil, nil)
+			},
+			wantUser: &upstreamprovider.GitLabUser{
+				Username:          "some-gitlab-username",
+				DownstreamSubject: wantSubject,
+			},
+		},
+		{
+			name: "happy path with username=id",
+			providerConfig: ProviderConfig{
+				APIBaseURL:         "https://some-url",
+				HttpClient:         someHttpClient,
+				UsernameAttribute:  idpv1alpha1.GitLabUsernameID,
+				GroupNameAttribute: idpv1alpha1.GitLabUseFullPathForGroupName,
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(someUserInfo, nil)
+				mockGitLabInterface.EXPECT().GetGroupMembership(someContext).Return(nil, nil)
+			},
+			wantUser: &upstreamprovider.GitLabUser{
+				Username:          "some-gitlab-id",
+				DownstreamSubject: wantSubject,
+			},
+		},
+		{
+			name: "happy path with all groups allowed",
+			providerConfig: ProviderConfig{
+				APIBaseURL:         "https://some-url",
+				HttpClient:         someHttpClient,
+				UsernameAttribute:  idpv1alpha1.GitLabUsernameLoginAndID,
+				GroupNameAttribute: idpv1alpha1.GitLabUseFullPathForGroupName,
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(someUserInfo, nil)
+				mockGitLabInterface.EXPECT().GetGroupMembership(someContext).Return([]string{"group1", "group1/sub1/sub2", "group2"}, nil)
+			},
+			wantUser: &upstreamprovider.GitLabUser{
+				Username:          "some-gitlab-username:some-gitlab-id",
+				Groups:            []string{"group1", "group1/sub1/sub2", "group2"},
+				DownstreamSubject: wantSubject,
+			},
+		},
+		{
+			name: "happy path with all groups allowed and flattened subgroups",
+			providerConfig: ProviderConfig{
+				APIBaseURL:         "https://some-url",
+				HttpClient:         someHttpClient,
+				UsernameAttribute:  idpv1alpha1.GitLabUsernameLoginAndID,
+				GroupNameAttribute: idpv1alpha1.GitLabUseFullPathForGroupName,
+				FlattenSubgroups:   true,
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(someUserInfo, nil)
+				mockGitLabInterface.EXPECT().GetGroupMembership(someContext).Return([]string{"group1", "group1/sub1/sub2", "group2/sub3"}, nil)
+			},
+			wantUser: &upstreamprovider.GitLabUser{
+				Username:          "some-gitlab-username:some-gitlab-id",
+				Groups:            []string{"group1", "group1/sub1", "group1/sub1/sub2", "group2", "group2/sub3"},
+				DownstreamSubject: wantSubject,
+			},
+		},
+		{
+			name: "happy path with user in allowed groups, which filters the groups case-insensitively",
+			providerConfig: ProviderConfig{
+				APIBaseURL:         "https://some-url",
+				HttpClient:         someHttpClient,
+				UsernameAttribute:  idpv1alpha1.GitLabUsernameLoginAndID,
+				GroupNameAttribute: idpv1alpha1.GitLabUseFullPathForGroupName,
+				AllowedGroups:      []string{"ALLOWED-GROUP1", "allowed-group2/sub1"},
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(someUserInfo, nil)
+				mockGitLabInterface.EXPECT().GetGroupMembership(someContext).Return([]string{
+					"allowed-group1",
+					"allowed-group1/sub1",
+					"allowed-group1-but-not-really",
+					"allowed-group2",
+					"allowed-group2/sub1/sub2",
+					"allowed-group2/sub3",
+					"disallowed-group",
+				}, nil)
+			},
+			wantUser: &upstreamprovider.GitLabUser{
+				Username:          "some-gitlab-username:some-gitlab-id",
+				Groups:            []string{"allowed-group1", "allowed-group1/sub1", "allowed-group2/sub1/sub2"},
+				DownstreamSubject: wantSubject,
+			},
+		},
+		{
+			name: "happy path with user in allowed groups and flattened subgroups, which does not reveal ancestors of the allowed groups",
+			providerConfig: ProviderConfig{
+				APIBaseURL:         "https://some-url",
+				HttpClient:         someHttpClient,
+				UsernameAttribute:  idpv1alpha1.GitLabUsernameLoginAndID,
+				GroupNameAttribute: idpv1alpha1.GitLabUseFullPathForGroupName,
+				AllowedGroups:      []string{"allowed-group1/sub1"},
+				FlattenSubgroups:   true,
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(someUserInfo, nil)
+				mockGitLabInterface.EXPECT().GetGroupMembership(someContext).Return([]string{"allowed-group1/sub1/sub2/sub3"}, nil)
+			},
+			wantUser: &upstreamprovider.GitLabUser{
+				Username:          "some-gitlab-username:some-gitlab-id",
+				Groups:            []string{"allowed-group1/sub1", "allowed-group1/sub1/sub2", "allowed-group1/sub1/sub2/sub3"},
+				DownstreamSubject: wantSubject,
+			},
+		},
+		{
+			name: "returns error when the user does not belong to the allowed groups",
+			providerConfig: ProviderConfig{
+				APIBaseURL:         "https://some-url",
+				HttpClient:         someHttpClient,
+				UsernameAttribute:  idpv1alpha1.GitLabUsernameID,
+				GroupNameAttribute: idpv1alpha1.GitLabUseFullPathForGroupName,
+				AllowedGroups:      []string{"allowed-group/sub1"},
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(someUserInfo, nil)
+				mockGitLabInterface.EXPECT().GetGroupMembership(someContext).Return([]string{"allowed-group", "allowed-group/sub2"}, nil)
+			},
+			wantErr: upstreamprovider.NewGitLabLoginDeniedError("user is not allowed to log in due to group membership policy"),
+		},
+		{
+			name: "returns errors from buildGitLabClient()",
+			providerConfig: ProviderConfig{
+				APIBaseURL: "https://some-url",
+				HttpClient: someHttpClient,
+			},
+			buildGitLabClientError: errors.New("error from building a gitlab client"),
+			wantErrMsg:             "error from building a gitlab client",
+		},
+		{
+			name: "returns errors from gitlabClient.GetUserInfo()",
+			providerConfig: ProviderConfig{
+				APIBaseURL: "https://some-url",
+				HttpClient: someHttpClient,
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(nil, errors.New("error from gitlabClient.GetUserInfo"))
+			},
+			wantErrMsg: "error from gitlabClient.GetUserInfo",
+		},
+		{
+			name: "returns errors from gitlabClient.GetGroupMembership()",
+			providerConfig: ProviderConfig{
+				APIBaseURL:         "https://some-url",
+				HttpClient:         someHttpClient,
+				UsernameAttribute:  idpv1alpha1.GitLabUsernameLoginAndID,
+				GroupNameAttribute: idpv1alpha1.GitLabUseFullPathForGroupName,
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(someUserInfo, nil)
+				mockGitLabInterface.EXPECT().GetGroupMembership(someContext).Return(nil, errors.New("error from gitlabClient.GetGroupMembership"))
+			},
+			wantErrMsg: "error from gitlabClient.GetGroupMembership",
+		},
+		{
+			name: "bad configuration: UsernameAttribute",
+			providerConfig: ProviderConfig{
+				APIBaseURL:        "https://some-url",
+				HttpClient:        someHttpClient,
+				UsernameAttribute: "this-is-not-legal-value-from-the-enum",
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(someUserInfo, nil)
+			},
+			wantErrMsg: "bad configuration: unknown GitLab username attribute: this-is-not-legal-value-from-the-enum",
+		},
+		{
+			name: "bad configuration: GroupNameAttribute",
+			providerConfig: ProviderConfig{
+				APIBaseURL:         "https://some-url",
+				HttpClient:         someHttpClient,
+				UsernameAttribute:  idpv1alpha1.GitLabUsernameLoginAndID,
+				GroupNameAttribute: "this-is-not-legal-value-from-the-enum",
+			},
+			buildMockResponses: func(mockGitLabInterface *mockgitlabclient.MockGitLabInterface) {
+				mockGitLabInterface.EXPECT().GetUserInfo(someContext).Return(someUserInfo, nil)
+			},
+			wantErrMsg: "bad configuration: unknown GitLab group name attribute: this-is-not-legal-value-from-the-enum",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			t.Cleanup(ctrl.Finish)
+
+			accessToken := "some-opaque-gitlab-access-token" + rand.String(8)
+			mockGitLabInterface := mockgitlabclient.NewMockGitLabInterface(ctrl)
+			if test.buildMockResponses != nil {
+				test.buildMockResponses(mockGitLabInterface)
+			}
+
+			p := New(test.providerConfig)
+			p.buildGitLabClient = func(httpClient *http.Client, apiBaseURL, token string) (gitlabclient.GitLabInterface, error) {
+				require.Equal(t, test.providerConfig.HttpClient, httpClient)
+				require.Equal(t, test.providerConfig.APIBaseURL, apiBaseURL)
+				require.Equal(t, accessToken, token)
+
+				return mockGitLabInterface, test.buildGitLabClientError
+			}
+
+			actualUser, actualErr := p.GetUser(context.Background(), accessToken, idpDisplayName)
+
+			switch {
+			case test.wantErrMsg != "":
+				require.EqualError(t, actualErr, test.wantErrMsg)
+				require.Nil(t, actualUser)
+			case test.wantErr != nil:
+				require.Equal(t, test.wantErr, actualErr)
+				require.Nil(t, actualUser)
+			default:
+				require.NoError(t, actualErr)
+				require.Equal(t, test.wantUser, actualUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// AccountStatusReason describes why the LDAP server refused to allow an end user to log in, for reasons other
+// than an incorrect username or password.
+type AccountStatusReason string
+
+const (
+	AccountStatusPasswordExpired    = AccountStatusReason("PasswordExpired")
+	AccountStatusPasswordMustChange = AccountStatusReason("PasswordMustChange")
+	AccountStatusAccountLocked      = AccountStatusReason("AccountLocked")
+)
+
+// AccountStatusError is returned by AuthenticateUser when the LDAP server refused to allow the end user to log in
+// because of the status of their account. These are distinguished from an incorrect username or password so that
+// the end user can be told what they need to do before they try again.
+type AccountStatusError struct {
+	Reason AccountStatusReason
+
+	// err is the error returned by the end user bind, which may be nil when the server accepted the bind
+	// but also indicated that the user must change their password before doing anything else.
+	err error
+}
+
+func (e *AccountStatusError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("end user account status does not allow login: %s", e.Reason)
+	}
+	return fmt.Sprintf("end user account status does not allow login: %s: %s", e.Reason, e.err.Error())
+}
+
+func (e *AccountStatusError) Unwrap() error {
+	return e.err
+}
+
+// activeDirectoryDataCodeRegexp finds the sub-code from the diagnostic message of a failed Active Directory bind,
+// e.g. "80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 775, v4563".
+var activeDirectoryDataCodeRegexp = regexp.MustCompile(`\bdata ([0-9a-fA-F]+)\b`)
+
+// bindAsEndUser binds the connection as the end user, requesting the password policy response control so that
+// servers which support it can explain why the bind was not allowed. It returns an AccountStatusError when the
+// server indicated that the account status does not allow the end user to log in.
+func bindAsEndUser(conn Conn, userDN, password string) error {
+	result, err := conn.SimpleBind(&ldap.SimpleBindRequest{
+		Username: userDN,
+		Password: password,
+		Controls: []ldap.Control{ldap.NewControlBeheraPasswordPolicy()},
+	})
+
+	if result != nil {
+		if ppolicy, ok := ldap.FindControl(result.Controls, ldap.ControlTypeBeheraPasswordPolicy).(*ldap.ControlBeheraPasswordPolicy); ok {
+			if reason := passwordPolicyAccountStatusReason(ppolicy.Error); reason != "" {
+				return &AccountStatusError{Reason: reason, err: err}
+			}
+		}
+	}
+
+	if reason := activeDirectoryAccountStatusReason(err); reason != "" {
+		return &AccountStatusError{Reason: reason, err: err}
+	}
+
+	return err
+}
+
+// activeDirectoryAccountStatusReason decodes the sub-code from an Active Directory invalid credentials error.
+func activeDirectoryAccountStatusReason(err error) AccountStatusReason {
+	ldapErr := &ldap.Error{}
+	if !errors.As(err, &ldapErr) || ldapErr.ResultCode != ldap.LDAPResultInvalidCredentials || ldapErr.Err == nil {
+		return ""
+	}
+	matches := activeDirectoryDataCodeRegexp.FindStringSubmatch(ldapErr.Err.Error())
+	if matches == nil {
+		return ""
+	}
+	switch matches[1] {
+	case "532":
+		return AccountStatusPasswordExpired
+	case "773":
+		return AccountStatusPasswordMustChange
+	case "775":
+		return AccountStatusAccountLocked
+	default:
+		return ""
+	}
+}
+
+// passwordPolicyAccountStatusReason decodes the error from a password policy response control.
+// See https://datatracker.ietf.org/doc/html/draft-behera-ldap-password-policy-10#section-6.2.
+func passwordPolicyAccountStatusReason(ppolicyErr int8) AccountStatusReason {
+	switch ppolicyErr {
+	case 0: // passwordExpired
+		return AccountStatusPasswordExpired
+	case 1: // accountLocked
+		return AccountStatusAccountLocked
+	case 2: // changeAfterReset
+		return AccountStatusPasswordMustChange
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"go.pinniped.dev/internal/mocks/mockldapconn"
+)
+
+func TestBindAsEndUser(t *testing.T) {
+	activeDirectoryErr := func(dataCode string) error {
+		return &ldap.Error{
+			Err:        errors.New("80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data " + dataCode + ", v4563"),
+			ResultCode: ldap.LDAPResultInvalidCredentials,
+		}
+	}
+
+	passwordPolicyResult := func(ppolicyErr int8) *ldap.SimpleBindResult {
+		ppolicy := ldap.NewControlBeheraPasswordPolicy()
+		ppolicy.Error = ppolicyErr
+		return &ldap.SimpleBindResult{Controls: []ldap.Control{ppolicy}}
+	}
+
+	invalidCredentialsErr := &ldap.Error{
+		Err:        errors.New("some bind error"),
+		ResultCode: ldap.LDAPResultInvalidCredentials,
+	}
+
+	tests := []struct {
+		name       string
+		bindResult *ldap.SimpleBindResult
+		bindErr    error
+		wantReason AccountStatusReason
+		wantErr    error
+	}{
+		{
+			name:       "successful bind",
+			bindResult: &ldap.SimpleBindResult{},
+		},
+		{
+			name:       "successful bind with a password policy control which has no error",
+			bindResult: &ldap.SimpleBindResult{Controls: []ldap.Control{ldap.NewControlBeheraPasswordPolicy()}},
+		},
+		{
+			name:    "invalid credentials",
+			bindErr: invalidCredentialsErr,
+			wantErr: invalidCredentialsErr,
+		},
+		{
+			name:       "Active Directory password expired",
+			bindErr:    activeDirectoryErr("532"),
+			wantReason: AccountStatusPasswordExpired,
+		},
+		{
+			name:       "Active Directory password must be changed",
+			bindErr:    activeDirectoryErr("773"),
+			wantReason: AccountStatusPasswordMustChange,
+		},
+		{
+			name:       "Active Directory account locked",
+			bindErr:    activeDirectoryErr("775"),
+			wantReason: AccountStatusAccountLocked,
+		},
+		{
+			name:    "Active Directory invalid credentials with another data code",
+			bindErr: activeDirectoryErr("52e"),
+			wantErr: activeDirectoryErr("52e"),
+		},
+		{
+			name:    "Active Directory data code in an error which is not invalid credentials",
+			bindErr: &ldap.Error{Err: errors.New("data 775"), ResultCode: ldap.LDAPResultOperationsError},
+			wantErr: &ldap.Error{Err: errors.New("data 775"), ResultCode: ldap.LDAPResultOperationsError},
+		},
+		{
+			name:       "password policy password expired",
+			bindResult: passwordPolicyResult(0),
+			bindErr:    invalidCredentialsErr,
+			wantReason: AccountStatusPasswordExpired,
+		},
+		{
+			name:       "password policy account locked",
+			bindResult: passwordPolicyResult(1),
+			bindErr:    invalidCredentialsErr,
+			wantReason: AccountStatusAccountLocked,
+		},
+		{
+			name:       "password policy change after reset on a successful bind",
+			bindResult: passwordPolicyResult(2),
+			wantReason: AccountStatusPasswordMustChange,
+		},
+		{
+			name:       "password policy error which is not about the account status",
+			bindResult: passwordPolicyResult(8),
+			bindErr:    invalidCredentialsErr,
+			wantErr:    invalidCredentialsErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := mockldapconn.NewMockConn(gomock.NewController(t))
+			conn.EXPECT().SimpleBind(expectedEndUserBind("some-dn", "some-password")).Return(tt.bindResult, tt.bindErr).Times(1)
+
+			err := bindAsEndUser(conn, "some-dn", "some-password")
+
+			if tt.wantReason == "" {
+				require.Equal(t, tt.wantErr, err)
+				return
+			}
+
+			accountStatusErr := &AccountStatusError{}
+			require.ErrorAs(t, err, &accountStatusErr)
+			require.Equal(t, tt.wantReason, accountStatusErr.Reason)
+			require.Equal(t, tt.bindErr, accountStatusErr.Unwrap())
+		})
+	}
+}
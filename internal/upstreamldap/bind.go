@@ -0,0 +1,128 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+
+	"go.pinniped.dev/internal/endpointaddr"
+)
+
+type BindMethod string
+
+const (
+	// SimpleBind binds using the BindUsername and BindPassword. This is the default when BindMethod is empty.
+	SimpleBind = BindMethod("Simple")
+
+	// GSSAPIBind binds using SASL GSSAPI (Kerberos), using the keytab of the BindUsername principal.
+	GSSAPIBind = BindMethod("GSSAPI")
+
+	// ExternalBind binds using SASL EXTERNAL, where the server identifies the bind account using the
+	// BindClientCertificate which is presented during the TLS handshake.
+	ExternalBind = BindMethod("External")
+)
+
+// GSSAPIConfig contains the settings for GSSAPIBind.
+type GSSAPIConfig struct {
+	// Keytab is the contents of a keytab file which contains the keys of the BindUsername principal.
+	Keytab []byte
+
+	// Krb5Config is the contents of a krb5.conf file which describes how to find the KDCs of the Realm.
+	Krb5Config string
+
+	// Realm is the realm of the BindUsername principal. Empty means to use the default_realm of the Krb5Config.
+	Realm string
+
+	// ServicePrincipalName is the service principal name of the LDAP server. Empty means to use "ldap/"
+	// followed by the hostname of the server which is being bound.
+	ServicePrincipalName string
+}
+
+// GSSAPIClient is the subset of the gssapi.Client methods which are needed to bind, so that binds can be tested.
+type GSSAPIClient interface {
+	ldap.GSSAPIClient
+	Close() error
+}
+
+// GSSAPIClientFactory creates a GSSAPIClient for the BindUsername principal.
+type GSSAPIClientFactory func(username string, gssapiConfig GSSAPIConfig) (GSSAPIClient, error)
+
+// newGSSAPIClient is the default GSSAPIClientFactory, which uses the keytab to authenticate to the KDC.
+func newGSSAPIClient(username string, gssapiConfig GSSAPIConfig) (GSSAPIClient, error) {
+	kt := keytab.New()
+	if err := kt.Unmarshal(gssapiConfig.Keytab); err != nil {
+		return nil, fmt.Errorf("could not parse keytab: %w", err)
+	}
+
+	krb5Config, err := config.NewFromString(gssapiConfig.Krb5Config)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse krb5.conf: %w", err)
+	}
+
+	realm := gssapiConfig.Realm
+	if realm == "" {
+		realm = krb5Config.LibDefaults.DefaultRealm
+	}
+
+	return &gssapi.Client{Client: client.NewWithKeytab(username, realm, kt, krb5Config, client.DisablePAFXFAST(true))}, nil
+}
+
+// bindAsBindAccount binds the connection to the host as the bind account, using the BindMethod.
+func (p *Provider) bindAsBindAccount(conn Conn, host string) error {
+	switch p.c.BindMethod {
+	case GSSAPIBind:
+		newClient := p.c.GSSAPIClientFactory
+		if newClient == nil {
+			newClient = newGSSAPIClient
+		}
+		gssapiClient, err := newClient(p.c.BindUsername, p.c.GSSAPI)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = gssapiClient.Close() }()
+
+		servicePrincipalName, err := p.servicePrincipalName(host)
+		if err != nil {
+			return err
+		}
+		return conn.GSSAPIBind(gssapiClient, servicePrincipalName, "")
+	case ExternalBind:
+		return conn.ExternalBind()
+	case SimpleBind, "":
+		fallthrough
+	default:
+		return conn.Bind(p.c.BindUsername, p.c.BindPassword)
+	}
+}
+
+// servicePrincipalName returns the configured ServicePrincipalName, or else the default for the host.
+func (p *Provider) servicePrincipalName(host string) (string, error) {
+	if p.c.GSSAPI.ServicePrincipalName != "" {
+		return p.c.GSSAPI.ServicePrincipalName, nil
+	}
+	addr, err := endpointaddr.Parse(host, defaultLDAPSPort)
+	if err != nil {
+		return "", err
+	}
+	return "ldap/" + addr.Host, nil
+}
+
+// bindClientCertificates returns the client certificate which should be presented during the TLS handshake.
+func (p *Provider) bindClientCertificates() ([]tls.Certificate, error) {
+	if p.c.BindMethod != ExternalBind {
+		return nil, nil
+	}
+	cert, err := tls.X509KeyPair(p.c.BindClientCertificate, p.c.BindClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse bind client certificate: %w", err)
+	}
+	return []tls.Certificate{cert}, nil
+}
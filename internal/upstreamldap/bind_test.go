@@ -0,0 +1,213 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"go.pinniped.dev/internal/certauthority"
+	"go.pinniped.dev/internal/endpointaddr"
+	"go.pinniped.dev/internal/mocks/mockldapconn"
+)
+
+type fakeGSSAPIClient struct {
+	ldap.GSSAPIClient
+	closed bool
+}
+
+func (c *fakeGSSAPIClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestBindMethods(t *testing.T) {
+	const testKrb5Config = "[libdefaults]\n  default_realm = EXAMPLE.COM\n"
+
+	ca, err := certauthority.New("test-ca", time.Hour)
+	require.NoError(t, err)
+	clientCertPEM, clientKeyPEM, err := ca.IssueClientCertPEM("some-bind-account", nil, time.Hour)
+	require.NoError(t, err)
+
+	var gssapiClients []*fakeGSSAPIClient
+	newFakeGSSAPIClient := func(username string, gssapiConfig GSSAPIConfig) (GSSAPIClient, error) {
+		require.Equal(t, testBindUsername, username)
+		require.Equal(t, []byte("some-keytab"), gssapiConfig.Keytab)
+		c := &fakeGSSAPIClient{}
+		gssapiClients = append(gssapiClients, c)
+		return c, nil
+	}
+
+	providerConfig := func(conn Conn, editFunc func(p *ProviderConfig)) ProviderConfig {
+		config := ProviderConfig{
+			Name:               "some-provider-name",
+			Host:               testHost,
+			ConnectionProtocol: TLS,
+			BindUsername:       testBindUsername,
+			Dialer: LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+				return conn, nil
+			}),
+		}
+		editFunc(&config)
+		return config
+	}
+
+	t.Run("GSSAPI bind uses the default service principal name for the host", func(t *testing.T) {
+		gssapiClients = nil
+		conn := mockldapconn.NewMockConn(gomock.NewController(t))
+		conn.EXPECT().GSSAPIBind(gomock.Any(), "ldap/ldap.example.com", "").Times(1)
+		conn.EXPECT().Close().Times(1)
+
+		err := New(providerConfig(conn, func(p *ProviderConfig) {
+			p.BindMethod = GSSAPIBind
+			p.GSSAPI = GSSAPIConfig{Keytab: []byte("some-keytab"), Krb5Config: testKrb5Config}
+			p.GSSAPIClientFactory = newFakeGSSAPIClient
+		})).TestConnection(context.Background())
+		require.NoError(t, err)
+
+		require.Len(t, gssapiClients, 1)
+		require.True(t, gssapiClients[0].closed)
+	})
+
+	t.Run("GSSAPI bind uses the configured service principal name", func(t *testing.T) {
+		gssapiClients = nil
+		conn := mockldapconn.NewMockConn(gomock.NewController(t))
+		conn.EXPECT().GSSAPIBind(gomock.Any(), "ldap/dc1.example.com", "").Times(1)
+		conn.EXPECT().Close().Times(1)
+
+		err := New(providerConfig(conn, func(p *ProviderConfig) {
+			p.BindMethod = GSSAPIBind
+			p.GSSAPI = GSSAPIConfig{Keytab: []byte("some-keytab"), Krb5Config: testKrb5Config, ServicePrincipalName: "ldap/dc1.example.com"}
+			p.GSSAPIClientFactory = newFakeGSSAPIClient
+		})).TestConnection(context.Background())
+		require.NoError(t, err)
+	})
+
+	t.Run("GSSAPI bind errors are returned", func(t *testing.T) {
+		gssapiClients = nil
+		conn := mockldapconn.NewMockConn(gomock.NewController(t))
+		conn.EXPECT().GSSAPIBind(gomock.Any(), "ldap/ldap.example.com", "").Return(errors.New("some bind error")).Times(1)
+		conn.EXPECT().Close().Times(1)
+
+		err := New(providerConfig(conn, func(p *ProviderConfig) {
+			p.BindMethod = GSSAPIBind
+			p.GSSAPI = GSSAPIConfig{Keytab: []byte("some-keytab"), Krb5Config: testKrb5Config}
+			p.GSSAPIClientFactory = newFakeGSSAPIClient
+		})).TestConnection(context.Background())
+		require.EqualError(t, err, `error binding as "cn=some-bind-username,dc=pinniped,dc=dev": some bind error`)
+		require.True(t, gssapiClients[0].closed)
+	})
+
+	t.Run("GSSAPI bind requires a keytab and a krb5.conf", func(t *testing.T) {
+		err := New(ProviderConfig{BindMethod: GSSAPIBind, GSSAPI: GSSAPIConfig{Keytab: []byte("some-keytab")}}).TestConnection(context.Background())
+		require.EqualError(t, err, "must specify a keytab and a krb5.conf when using the GSSAPI bind method")
+	})
+
+	t.Run("pooled connections are bound again using GSSAPI with the service principal name of their host", func(t *testing.T) {
+		gssapiClients = nil
+		conn := mockldapconn.NewMockConn(gomock.NewController(t))
+		conn.EXPECT().GSSAPIBind(gomock.Any(), "ldap/ldap.example.com", "").Times(2)
+		conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+		conn.EXPECT().Close().Times(1)
+
+		pool := NewConnectionPool(1)
+		provider := New(providerConfig(conn, func(p *ProviderConfig) {
+			p.BindMethod = GSSAPIBind
+			p.GSSAPI = GSSAPIConfig{Keytab: []byte("some-keytab"), Krb5Config: testKrb5Config}
+			p.GSSAPIClientFactory = newFakeGSSAPIClient
+			p.ConnectionPool = pool
+		}))
+
+		pc, err := provider.getBoundConn(context.Background(), "")
+		require.NoError(t, err)
+		require.NoError(t, pc.conn.Bind(testUserSearchResultDNValue, testUpstreamPassword))
+		pc.boundAsBindAccount = false
+		provider.releaseConn(pc, "testing", nil)
+
+		pc, err = provider.getBoundConn(context.Background(), "")
+		require.NoError(t, err)
+		provider.releaseConn(pc, "testing", nil)
+		pool.Close()
+
+		require.Len(t, gssapiClients, 2)
+	})
+
+	t.Run("External bind presents the client certificate during the TLS handshake", func(t *testing.T) {
+		conn := mockldapconn.NewMockConn(gomock.NewController(t))
+		conn.EXPECT().ExternalBind().Times(1)
+		conn.EXPECT().Close().Times(1)
+
+		provider := New(providerConfig(conn, func(p *ProviderConfig) {
+			p.BindMethod = ExternalBind
+			p.BindClientCertificate = clientCertPEM
+			p.BindClientKey = clientKeyPEM
+		}))
+		require.NoError(t, provider.TestConnection(context.Background()))
+
+		tlsConfig, err := provider.tlsConfig()
+		require.NoError(t, err)
+		require.Len(t, tlsConfig.Certificates, 1)
+	})
+
+	t.Run("External bind requires a client certificate", func(t *testing.T) {
+		err := New(ProviderConfig{BindMethod: ExternalBind, BindClientCertificate: clientCertPEM}).TestConnection(context.Background())
+		require.EqualError(t, err, "must specify a client certificate and key when using the External bind method")
+
+		_, err = New(ProviderConfig{BindMethod: ExternalBind, BindClientCertificate: clientCertPEM, BindClientKey: []byte("bad")}).tlsConfig()
+		require.ErrorContains(t, err, "could not parse bind client certificate")
+	})
+
+	t.Run("Simple bind does not present a client certificate", func(t *testing.T) {
+		tlsConfig, err := New(ProviderConfig{BindClientCertificate: clientCertPEM, BindClientKey: clientKeyPEM}).tlsConfig()
+		require.NoError(t, err)
+		require.Empty(t, tlsConfig.Certificates)
+	})
+
+	t.Run("unsupported bind methods are rejected", func(t *testing.T) {
+		err := New(ProviderConfig{BindMethod: "Other"}).TestConnection(context.Background())
+		require.EqualError(t, err, `unsupported bind method "Other"`)
+	})
+}
+
+func TestNewGSSAPIClient(t *testing.T) {
+	kt := keytab.New()
+	require.NoError(t, kt.AddEntry("bind-account", "EXAMPLE.COM", "some-password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96))
+	keytabBytes, err := kt.Marshal()
+	require.NoError(t, err)
+
+	t.Run("uses the default realm from the krb5.conf", func(t *testing.T) {
+		c, err := newGSSAPIClient("bind-account", GSSAPIConfig{
+			Keytab:     keytabBytes,
+			Krb5Config: "[libdefaults]\n  default_realm = EXAMPLE.COM\n",
+		})
+		require.NoError(t, err)
+		require.Equal(t, "EXAMPLE.COM", c.(*gssapi.Client).Credentials.Realm())
+		require.Equal(t, "bind-account", c.(*gssapi.Client).Credentials.UserName())
+		require.True(t, c.(*gssapi.Client).Credentials.HasKeytab())
+	})
+
+	t.Run("uses the configured realm", func(t *testing.T) {
+		c, err := newGSSAPIClient("bind-account", GSSAPIConfig{
+			Keytab:     keytabBytes,
+			Krb5Config: "[libdefaults]\n  default_realm = EXAMPLE.COM\n",
+			Realm:      "OTHER.EXAMPLE.COM",
+		})
+		require.NoError(t, err)
+		require.Equal(t, "OTHER.EXAMPLE.COM", c.(*gssapi.Client).Credentials.Realm())
+	})
+
+	t.Run("returns an error for an invalid keytab", func(t *testing.T) {
+		_, err := newGSSAPIClient("bind-account", GSSAPIConfig{Keytab: []byte("bad"), Krb5Config: "[libdefaults]\n"})
+		require.ErrorContains(t, err, "could not parse keytab")
+	})
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// hostUnhealthyDuration is how long a host which failed to dial or bind is tried after the other hosts.
+const hostUnhealthyDuration = time.Minute
+
+// SRVResolver looks up DNS SRV records. It is implemented by *net.Resolver.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+var _ SRVResolver = &net.Resolver{}
+
+// HostConnectionResult is the result of testing the connection to one host.
+type HostConnectionResult struct {
+	// Host is the hostname or "hostname:port" of the LDAP server, or the name of the DNS SRV record when the
+	// record could not be looked up.
+	Host string
+
+	// Err is nil when the host could be dialed and the bind account could bind.
+	Err error
+}
+
+// hostHealth remembers which hosts have recently failed, so they can be tried after the hosts which have not.
+type hostHealth struct {
+	lock     sync.Mutex
+	failedAt map[string]time.Time
+	now      func() time.Time
+}
+
+func newHostHealth() *hostHealth {
+	return &hostHealth{failedAt: map[string]time.Time{}, now: time.Now}
+}
+
+func (h *hostHealth) markFailed(host string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.failedAt[host] = h.now()
+}
+
+func (h *hostHealth) markHealthy(host string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.failedAt, host)
+}
+
+// sortByHealth returns the hosts with the healthy hosts first, in their original order, followed by the
+// hosts which have recently failed, starting with the one which failed the longest time ago.
+func (h *hostHealth) sortByHealth(hosts []string) []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := h.now()
+	recentlyFailed := func(host string) (time.Time, bool) {
+		failedAt, found := h.failedAt[host]
+		return failedAt, found && now.Sub(failedAt) < hostUnhealthyDuration
+	}
+
+	sorted := slices.Clone(hosts)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		aFailedAt, aFailed := recentlyFailed(a)
+		bFailedAt, bFailed := recentlyFailed(b)
+		switch {
+		case aFailed && bFailed:
+			return aFailedAt.Compare(bFailedAt)
+		case aFailed:
+			return 1
+		case bFailed:
+			return -1
+		default:
+			return 0
+		}
+	})
+	return sorted
+}
+
+// hosts returns the Host, followed by the AdditionalHosts, followed by the hosts found by looking up the
+// SRVRecordName, without duplicates. An error looking up the SRV record is returned along with the other hosts,
+// so the caller can decide whether to continue without the hosts from the SRV record.
+func (p *Provider) hosts(ctx context.Context) ([]string, error) {
+	hosts := append([]string{p.c.Host}, p.c.AdditionalHosts...)
+
+	var srvErr error
+	if p.c.SRVRecordName != "" {
+		resolver := p.c.SRVResolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		// The records are returned sorted by priority and randomized by weight.
+		_, records, err := resolver.LookupSRV(ctx, "", "", p.c.SRVRecordName)
+		if err != nil {
+			srvErr = fmt.Errorf("error looking up DNS SRV record %q: %w", p.c.SRVRecordName, err)
+		}
+		for _, record := range records {
+			hosts = append(hosts, net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port))))
+		}
+	}
+
+	seen := sets.New[string]()
+	uniqueHosts := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if !seen.Has(host) {
+			seen.Insert(host)
+			uniqueHosts = append(uniqueHosts, host)
+		}
+	}
+	return uniqueHosts, srvErr
+}
+
+// hasMultipleHosts returns true when more hosts than just the Host could be used.
+func (p *Provider) hasMultipleHosts() bool {
+	return len(p.c.AdditionalHosts) > 0 || p.c.SRVRecordName != ""
+}
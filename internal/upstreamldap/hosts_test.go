@@ -0,0 +1,265 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"go.pinniped.dev/internal/endpointaddr"
+	"go.pinniped.dev/internal/mocks/mockldapconn"
+	"go.pinniped.dev/internal/testutil"
+)
+
+type fakeSRVResolver struct {
+	wantName string
+	records  []*net.SRV
+	err      error
+}
+
+func (r *fakeSRVResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if service != "" || proto != "" || name != r.wantName {
+		return "", nil, errors.New("unexpected SRV lookup")
+	}
+	return name, r.records, r.err
+}
+
+func TestMultipleHosts(t *testing.T) {
+	const (
+		testHost2 = "ldap2.example.com:8443"
+		testHost3 = "ldap3.example.com:8443"
+	)
+
+	providerConfig := func(editFunc func(p *ProviderConfig)) ProviderConfig {
+		config := ProviderConfig{
+			Name:               "some-provider-name",
+			Host:               testHost,
+			AdditionalHosts:    []string{testHost2},
+			ConnectionProtocol: TLS,
+			BindUsername:       testBindUsername,
+			BindPassword:       testBindPassword,
+		}
+		if editFunc != nil {
+			editFunc(&config)
+		}
+		return config
+	}
+
+	tests := []struct {
+		name           string
+		providerConfig ProviderConfig
+		dialErrors     map[string]error // by host
+		bindErrors     map[string]error // by host
+		wantDials      []string
+		wantError      testutil.RequireErrorStringFunc
+	}{
+		{
+			name:           "uses the Host when it works",
+			providerConfig: providerConfig(nil),
+			wantDials:      []string{testHost},
+		},
+		{
+			name:           "fails over to an additional host when the Host cannot be dialed",
+			providerConfig: providerConfig(nil),
+			dialErrors:     map[string]error{testHost: errors.New("some dial error")},
+			wantDials:      []string{testHost, testHost2},
+		},
+		{
+			name:           "fails over to an additional host when the bind account cannot bind to the Host",
+			providerConfig: providerConfig(nil),
+			bindErrors:     map[string]error{testHost: errors.New("some bind error")},
+			wantDials:      []string{testHost, testHost2},
+		},
+		{
+			name: "fails over to hosts from the DNS SRV record, without trying duplicate hosts",
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.SRVRecordName = "_ldap._tcp.example.com"
+				p.SRVResolver = &fakeSRVResolver{wantName: "_ldap._tcp.example.com", records: []*net.SRV{
+					{Target: "ldap2.example.com.", Port: 8443},
+					{Target: "ldap3.example.com.", Port: 8443},
+				}}
+			}),
+			dialErrors: map[string]error{testHost: errors.New("some dial error"), testHost2: errors.New("some dial error")},
+			wantDials:  []string{testHost, testHost2, testHost3},
+		},
+		{
+			name: "uses the other hosts when the DNS SRV record cannot be looked up",
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.SRVRecordName = "_ldap._tcp.example.com"
+				p.SRVResolver = &fakeSRVResolver{wantName: "_ldap._tcp.example.com", err: errors.New("some DNS error")}
+			}),
+			dialErrors: map[string]error{testHost: errors.New("some dial error")},
+			wantDials:  []string{testHost, testHost2},
+		},
+		{
+			name:           "when every host fails",
+			providerConfig: providerConfig(nil),
+			dialErrors:     map[string]error{testHost: errors.New("some dial error")},
+			bindErrors:     map[string]error{testHost2: errors.New("some bind error")},
+			wantDials:      []string{testHost, testHost2},
+			wantError: testutil.WantExactErrorString(
+				"could not connect to any of the LDAP hosts: " +
+					`error dialing host "ldap.example.com:8443": some dial error` + "\n" +
+					`error binding as "cn=some-bind-username,dc=pinniped,dc=dev" to host "ldap2.example.com:8443": some bind error`),
+		},
+		{
+			name: "when every host fails and the DNS SRV record cannot be looked up",
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.AdditionalHosts = nil
+				p.SRVRecordName = "_ldap._tcp.example.com"
+				p.SRVResolver = &fakeSRVResolver{wantName: "_ldap._tcp.example.com", err: errors.New("some DNS error")}
+			}),
+			dialErrors: map[string]error{testHost: errors.New("some dial error")},
+			wantDials:  []string{testHost},
+			wantError: testutil.WantExactErrorString(
+				"could not connect to any of the LDAP hosts: " +
+					`error dialing host "ldap.example.com:8443": some dial error` + "\n" +
+					`error looking up DNS SRV record "_ldap._tcp.example.com": some DNS error`),
+		},
+	}
+
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			t.Cleanup(ctrl.Finish)
+
+			var dials []string
+			tt.providerConfig.Dialer = LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+				dials = append(dials, addr.Endpoint())
+				if err := tt.dialErrors[addr.Endpoint()]; err != nil {
+					return nil, err
+				}
+				conn := mockldapconn.NewMockConn(ctrl)
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Return(tt.bindErrors[addr.Endpoint()]).Times(1)
+				conn.EXPECT().Close().Times(1)
+				return conn, nil
+			})
+
+			err := New(tt.providerConfig).TestConnection(context.Background())
+
+			require.Equal(t, tt.wantDials, dials)
+			if tt.wantError != nil {
+				testutil.RequireErrorStringFromErr(t, err, tt.wantError)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMultipleHostsPrefersHealthyHosts(t *testing.T) {
+	const testHost2 = "ldap2.example.com:8443"
+
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+
+	hostIsDown := map[string]bool{testHost: true}
+	var dials []string
+	provider := New(ProviderConfig{
+		Name:               "some-provider-name",
+		Host:               testHost,
+		AdditionalHosts:    []string{testHost2},
+		ConnectionProtocol: TLS,
+		BindUsername:       testBindUsername,
+		BindPassword:       testBindPassword,
+		Dialer: LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+			dials = append(dials, addr.Endpoint())
+			if hostIsDown[addr.Endpoint()] {
+				return nil, errors.New("some dial error")
+			}
+			conn := mockldapconn.NewMockConn(ctrl)
+			conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+			conn.EXPECT().Close().Times(1)
+			return conn, nil
+		}),
+	})
+	now := time.Now()
+	provider.health.now = func() time.Time { return now }
+
+	require.NoError(t, provider.TestConnection(context.Background()))
+	require.Equal(t, []string{testHost, testHost2}, dials)
+
+	// The Host recently failed, so the other host is tried first.
+	dials = nil
+	require.NoError(t, provider.TestConnection(context.Background()))
+	require.Equal(t, []string{testHost2}, dials)
+
+	// After a while, the Host is tried first again, and it is considered to be healthy once it works.
+	now = now.Add(hostUnhealthyDuration)
+	hostIsDown[testHost] = false
+	dials = nil
+	require.NoError(t, provider.TestConnection(context.Background()))
+	require.Equal(t, []string{testHost}, dials)
+}
+
+func TestTestConnectionToEachHost(t *testing.T) {
+	const testHost2 = "ldap2.example.com:8443"
+
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+
+	var dials []string
+	provider := New(ProviderConfig{
+		Name:               "some-provider-name",
+		Host:               testHost,
+		AdditionalHosts:    []string{testHost2},
+		SRVRecordName:      "_ldap._tcp.example.com",
+		SRVResolver:        &fakeSRVResolver{wantName: "_ldap._tcp.example.com", err: errors.New("some DNS error")},
+		ConnectionProtocol: TLS,
+		BindUsername:       testBindUsername,
+		BindPassword:       testBindPassword,
+		Dialer: LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+			dials = append(dials, addr.Endpoint())
+			if addr.Endpoint() == testHost2 {
+				return nil, errors.New("some dial error")
+			}
+			conn := mockldapconn.NewMockConn(ctrl)
+			conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+			conn.EXPECT().Close().Times(1)
+			return conn, nil
+		}),
+	})
+
+	results, err := provider.TestConnectionToEachHost(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{testHost, testHost2}, dials)
+	require.Len(t, results, 3)
+	require.Equal(t, HostConnectionResult{Host: testHost}, results[0])
+	require.Equal(t, testHost2, results[1].Host)
+	require.EqualError(t, results[1].Err, `error dialing host "ldap2.example.com:8443": some dial error`)
+	require.Equal(t, "_ldap._tcp.example.com", results[2].Host)
+	require.EqualError(t, results[2].Err, `error looking up DNS SRV record "_ldap._tcp.example.com": some DNS error`)
+
+	// The host which failed is now tried last.
+	require.Equal(t, []string{testHost, testHost2}, provider.health.sortByHealth([]string{testHost2, testHost}))
+}
+
+func TestHostHealthSortByHealth(t *testing.T) {
+	now := time.Now()
+	h := newHostHealth()
+	h.now = func() time.Time { return now }
+
+	hosts := []string{"a", "b", "c", "d"}
+	require.Equal(t, []string{"a", "b", "c", "d"}, h.sortByHealth(hosts))
+
+	h.markFailed("b")
+	now = now.Add(time.Second)
+	h.markFailed("a")
+	now = now.Add(time.Second)
+	h.markFailed("d")
+	h.markHealthy("d")
+	require.Equal(t, []string{"c", "d", "b", "a"}, h.sortByHealth(hosts))
+	require.Equal(t, []string{"a", "b", "c", "d"}, hosts, "should not modify its argument")
+
+	// Hosts which failed long enough ago are considered to be healthy again.
+	now = now.Add(hostUnhealthyDuration - 1500*time.Millisecond)
+	require.Equal(t, []string{"b", "c", "d", "a"}, h.sortByHealth(hosts))
+}
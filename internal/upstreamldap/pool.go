@@ -0,0 +1,222 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// DefaultConnectionPoolSize is the default maximum number of connections to the LDAP server for one identity provider.
+	DefaultConnectionPoolSize = 10
+
+	// pooledConnectionMaxIdleTime is how long an idle connection is kept. It is shorter than the default idle
+	// timeout of Active Directory (15 minutes), so connections are usually closed before the server closes them.
+	pooledConnectionMaxIdleTime = 5 * time.Minute
+
+	// pooledConnectionHealthCheckAfterIdleTime is how long a connection can be idle before it is health checked
+	// by binding as the bind account again before it is reused.
+	pooledConnectionHealthCheckAfterIdleTime = 30 * time.Second
+)
+
+// ConnectionPool holds connections to the LDAP server which are bound as the bind account, so they can be reused
+// instead of dialing and binding again for each login and each refresh. It limits the number of connections which
+// are used at the same time. One ConnectionPool can be shared by all the Providers which are created for the same
+// identity provider, so the connections can still be used after the Provider is replaced.
+type ConnectionPool struct {
+	lock   sync.Mutex
+	idle   []*pooledConn // the most recently used connection is last
+	slots  chan struct{}
+	closed bool
+	now    func() time.Time
+}
+
+type pooledConn struct {
+	conn Conn
+
+	// host is the host which the connection was dialed to.
+	host string
+
+	// settingsKey identifies the connection settings and the bind account which were used to create the connection.
+	settingsKey string
+
+	// boundAsBindAccount is false when the connection has been bound as an end user since it was bound as the bind account.
+	boundAsBindAccount bool
+
+	idleSince time.Time
+}
+
+// NewConnectionPool returns a ConnectionPool which allows at most size connections to be used at the same time.
+func NewConnectionPool(size int) *ConnectionPool {
+	return &ConnectionPool{slots: make(chan struct{}, size), now: time.Now}
+}
+
+// Close closes the idle connections. Connections which are in use will be closed when they are no longer used.
+func (c *ConnectionPool) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.closed = true
+	for _, pc := range c.idle {
+		closeAndLogError(pc.conn, "closing connection pool")
+	}
+	c.idle = nil
+}
+
+// get waits for a free slot, and then returns an idle connection with the same settingsKey, or nil when there is no
+// such connection, in which case the caller should dial a new connection and must use put or releaseSlot to release
+// the slot. Idle connections which cannot be used anymore are closed. The rebind func binds a connection as the bind
+// account, which is done before a connection is reused when it was bound as an end user, or as a health check when
+// it was idle for a while.
+func (c *ConnectionPool) get(ctx context.Context, settingsKey string, rebind func(pc *pooledConn) error) (*pooledConn, error) {
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out waiting for an available LDAP connection: %w", ctx.Err())
+	}
+
+	for {
+		pc := c.popIdle()
+		if pc == nil {
+			return nil, nil
+		}
+
+		idleTime := c.now().Sub(pc.idleSince)
+		if pc.settingsKey != settingsKey || idleTime >= pooledConnectionMaxIdleTime || isClosing(pc.conn) {
+			closeAndLogError(pc.conn, "discarding pooled connection")
+			continue
+		}
+
+		if !pc.boundAsBindAccount || idleTime >= pooledConnectionHealthCheckAfterIdleTime {
+			if err := rebind(pc); err != nil {
+				// The server may have closed the connection, so try another one.
+				closeAndLogError(pc.conn, "discarding pooled connection which failed to bind")
+				continue
+			}
+			pc.boundAsBindAccount = true
+		}
+
+		return pc, nil
+	}
+}
+
+func (c *ConnectionPool) popIdle() *pooledConn {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.idle) == 0 {
+		return nil
+	}
+	pc := c.idle[len(c.idle)-1]
+	c.idle = c.idle[:len(c.idle)-1]
+	return pc
+}
+
+// put releases the slot of the connection, and keeps the connection for reuse unless it is not reusable
+// or the pool was closed, in which case it closes the connection.
+func (c *ConnectionPool) put(pc *pooledConn, doingWhat string, reusable bool) {
+	defer c.releaseSlot()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !reusable || c.closed {
+		closeAndLogError(pc.conn, doingWhat)
+		return
+	}
+
+	// Close the connections which have been idle for too long, which are the first ones.
+	now := c.now()
+	for len(c.idle) > 0 && now.Sub(c.idle[0].idleSince) >= pooledConnectionMaxIdleTime {
+		closeAndLogError(c.idle[0].conn, "discarding pooled connection")
+		c.idle = c.idle[1:]
+	}
+
+	pc.idleSince = now
+	c.idle = append(c.idle, pc)
+}
+
+func (c *ConnectionPool) releaseSlot() {
+	<-c.slots
+}
+
+// isClosing returns true when the connection knows that it has been closed, e.g. by the server.
+func isClosing(conn Conn) bool {
+	closer, ok := conn.(interface{ IsClosing() bool })
+	return ok && closer.IsClosing()
+}
+
+// isReusable returns false when the connection should not be used again after the operation which returned err.
+func isReusable(conn Conn, err error) bool {
+	if isClosing(conn) {
+		return false
+	}
+	ldapErr := &ldap.Error{}
+	return !errors.As(err, &ldapErr) || ldapErr.ResultCode != ldap.ErrorNetwork
+}
+
+// connectionSettingsKey identifies the settings which were used to create a connection and bind it as the bind account.
+func (p *Provider) connectionSettingsKey() string {
+	h := sha256.New()
+	for _, s := range append([]string{
+		p.c.Host, p.c.SRVRecordName, string(p.c.ConnectionProtocol), string(p.c.CABundle),
+		string(p.c.BindMethod), p.c.BindUsername, p.c.BindPassword,
+		string(p.c.GSSAPI.Keytab), p.c.GSSAPI.Krb5Config, p.c.GSSAPI.Realm, p.c.GSSAPI.ServicePrincipalName,
+		string(p.c.BindClientCertificate), string(p.c.BindClientKey),
+	}, p.c.AdditionalHosts...) {
+		// Prefix each value with its length so different values cannot result in the same key.
+		_, _ = fmt.Fprintf(h, "%d:%s", len(s), s)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// getBoundConn returns a connection which is bound as the bind account. It reuses a pooled connection when the
+// ConnectionPool is configured. The connection must be returned by calling releaseConn.
+func (p *Provider) getBoundConn(ctx context.Context, bindErrorContext string) (*pooledConn, error) {
+	pool := p.c.ConnectionPool
+	if pool == nil {
+		conn, host, err := p.dialAndBind(ctx, bindErrorContext)
+		if err != nil {
+			return nil, err
+		}
+		return &pooledConn{conn: conn, host: host, boundAsBindAccount: true}, nil
+	}
+
+	settingsKey := p.connectionSettingsKey()
+	pc, err := pool.get(ctx, settingsKey, func(pc *pooledConn) error {
+		return p.bindAsBindAccount(pc.conn, pc.host)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pc != nil {
+		return pc, nil
+	}
+
+	conn, host, err := p.dialAndBind(ctx, bindErrorContext)
+	if err != nil {
+		pool.releaseSlot()
+		return nil, err
+	}
+	return &pooledConn{conn: conn, host: host, settingsKey: settingsKey, boundAsBindAccount: true}, nil
+}
+
+// releaseConn returns a connection from getBoundConn to the ConnectionPool, or closes it when there is no
+// ConnectionPool or when it cannot be reused after the operation which returned err.
+func (p *Provider) releaseConn(pc *pooledConn, doingWhat string, err error) {
+	pool := p.c.ConnectionPool
+	if pool == nil {
+		closeAndLogError(pc.conn, doingWhat)
+		return
+	}
+	pool.put(pc, doingWhat, isReusable(pc.conn, err))
+}
@@ -0,0 +1,272 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamldap
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"go.pinniped.dev/internal/endpointaddr"
+	"go.pinniped.dev/internal/mocks/mockldapconn"
+)
+
+func TestConnectionPool(t *testing.T) {
+	expectedUserSearch := &ldap.SearchRequest{
+		BaseDN:       testUserSearchBase,
+		Scope:        ldap.ScopeWholeSubtree,
+		DerefAliases: ldap.NeverDerefAliases,
+		SizeLimit:    2,
+		TimeLimit:    90,
+		Filter:       testUserSearchFilterInterpolated,
+		Attributes:   []string{testUserSearchUsernameAttribute, testUserSearchUIDAttribute},
+	}
+	userSearchResult := &ldap.SearchResult{
+		Entries: []*ldap.Entry{
+			{
+				DN: testUserSearchResultDNValue,
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute(testUserSearchUsernameAttribute, []string{testUserSearchResultUsernameAttributeValue}),
+					ldap.NewEntryAttribute(testUserSearchUIDAttribute, []string{testUserSearchResultUIDAttributeValue}),
+				},
+			},
+		},
+	}
+
+	type testEnv struct {
+		pool     *ConnectionPool
+		provider *Provider
+		conns    []*mockldapconn.MockConn
+		now      *time.Time
+	}
+
+	// setup returns a Provider which uses a new ConnectionPool, and which expects to dial len(setupConns) times.
+	setup := func(t *testing.T, size int, setupConns ...func(conn *mockldapconn.MockConn)) *testEnv {
+		t.Helper()
+
+		ctrl := gomock.NewController(t)
+		t.Cleanup(ctrl.Finish)
+
+		env := &testEnv{pool: NewConnectionPool(size), now: new(time.Time)}
+		*env.now = time.Now()
+		env.pool.now = func() time.Time { return *env.now }
+
+		for _, setupConn := range setupConns {
+			conn := mockldapconn.NewMockConn(ctrl)
+			setupConn(conn)
+			env.conns = append(env.conns, conn)
+		}
+
+		dials := 0
+		env.provider = New(ProviderConfig{
+			Name:               "some-provider-name",
+			Host:               testHost,
+			ConnectionProtocol: TLS,
+			BindUsername:       testBindUsername,
+			BindPassword:       testBindPassword,
+			UserSearch: UserSearchConfig{
+				Base:              testUserSearchBase,
+				Filter:            testUserSearchFilter,
+				UsernameAttribute: testUserSearchUsernameAttribute,
+				UIDAttribute:      testUserSearchUIDAttribute,
+			},
+			ConnectionPool: env.pool,
+			Dialer: LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+				require.Less(t, dials, len(env.conns), "dialed more times than expected")
+				conn := env.conns[dials]
+				dials++
+				return conn, nil
+			}),
+		})
+		t.Cleanup(func() {
+			require.Equal(t, len(env.conns), dials, "dialed fewer times than expected")
+		})
+
+		return env
+	}
+
+	lookupUser := func(t *testing.T, provider *Provider) {
+		t.Helper()
+		groups, err := provider.LookupUserGroups(context.Background(), testUpstreamUsername)
+		require.NoError(t, err)
+		require.Equal(t, []string{}, groups)
+	}
+
+	t.Run("reuses the connection for refreshes and lookups without binding again", func(t *testing.T) {
+		env := setup(t, 2, func(conn *mockldapconn.MockConn) {
+			conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+			conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(3)
+			conn.EXPECT().Close().Times(1)
+		})
+
+		lookupUser(t, env.provider)
+		lookupUser(t, env.provider)
+		lookupUser(t, env.provider)
+
+		env.pool.Close()
+	})
+
+	t.Run("binds as the bind account again before reusing a connection which was bound as an end user", func(t *testing.T) {
+		env := setup(t, 2, func(conn *mockldapconn.MockConn) {
+			gomock.InOrder(
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1),
+				conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(1),
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1),
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1),
+				conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(1),
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1),
+				conn.EXPECT().Close().Times(1),
+			)
+		})
+
+		for range 2 {
+			response, authenticated, err := env.provider.AuthenticateUser(context.Background(), testUpstreamUsername, testUpstreamPassword)
+			require.NoError(t, err)
+			require.True(t, authenticated)
+			require.Equal(t, testUserSearchResultUsernameAttributeValue, response.User.GetName())
+		}
+
+		env.pool.Close()
+	})
+
+	t.Run("health checks connections which were idle for a while, and closes connections which were idle for too long", func(t *testing.T) {
+		env := setup(t, 2,
+			func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(2)
+				conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(2)
+				conn.EXPECT().Close().Times(1)
+			},
+			func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+		)
+
+		lookupUser(t, env.provider)
+		*env.now = env.now.Add(pooledConnectionHealthCheckAfterIdleTime)
+		lookupUser(t, env.provider)
+		*env.now = env.now.Add(pooledConnectionMaxIdleTime)
+		lookupUser(t, env.provider)
+
+		env.pool.Close()
+	})
+
+	t.Run("closes a connection which fails its health check and dials a new connection", func(t *testing.T) {
+		env := setup(t, 2,
+			func(conn *mockldapconn.MockConn) {
+				gomock.InOrder(
+					conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1),
+					conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(1),
+					conn.EXPECT().Bind(testBindUsername, testBindPassword).Return(ldap.NewError(ldap.ErrorNetwork, errors.New("connection closed"))).Times(1),
+					conn.EXPECT().Close().Times(1),
+				)
+			},
+			func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+		)
+
+		lookupUser(t, env.provider)
+		*env.now = env.now.Add(pooledConnectionHealthCheckAfterIdleTime)
+		lookupUser(t, env.provider)
+
+		env.pool.Close()
+	})
+
+	t.Run("closes a connection after a network error instead of reusing it", func(t *testing.T) {
+		env := setup(t, 2,
+			func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch).Return(nil, ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset"))).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+		)
+
+		_, err := env.provider.LookupUserGroups(context.Background(), testUpstreamUsername)
+		require.EqualError(t, err, `error searching for user: LDAP Result Code 200 "Network Error": connection reset`)
+		lookupUser(t, env.provider)
+
+		env.pool.Close()
+	})
+
+	t.Run("does not reuse a connection after the connection settings change", func(t *testing.T) {
+		env := setup(t, 2,
+			func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, "new-bind-password").Times(1)
+				conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+		)
+
+		lookupUser(t, env.provider)
+
+		// Simulate the provider being replaced after the bind secret changed.
+		newConfig := env.provider.GetConfig()
+		newConfig.BindPassword = "new-bind-password"
+		lookupUser(t, New(newConfig))
+
+		env.pool.Close()
+	})
+
+	t.Run("limits the number of connections in use", func(t *testing.T) {
+		env := setup(t, 1, func(conn *mockldapconn.MockConn) {
+			conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+			conn.EXPECT().Close().Times(1)
+		})
+
+		pc, err := env.provider.getBoundConn(context.Background(), "")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = env.provider.getBoundConn(ctx, "")
+		require.EqualError(t, err, "timed out waiting for an available LDAP connection: context deadline exceeded")
+
+		// Connections which are returned after the pool is closed are closed.
+		env.pool.Close()
+		env.provider.releaseConn(pc, "testing", nil)
+
+		// The slot was released.
+		require.Empty(t, env.pool.slots)
+	})
+
+	t.Run("releases the slot when dialing fails", func(t *testing.T) {
+		pool := NewConnectionPool(1)
+		provider := New(ProviderConfig{
+			Name:               "some-provider-name",
+			Host:               testHost,
+			ConnectionProtocol: TLS,
+			BindUsername:       testBindUsername,
+			BindPassword:       testBindPassword,
+			ConnectionPool:     pool,
+			Dialer: LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+				return nil, errors.New("some dial error")
+			}),
+		})
+
+		for range 2 {
+			_, err := provider.getBoundConn(context.Background(), "")
+			require.EqualError(t, err, `error dialing host "ldap.example.com:8443": some dial error`)
+		}
+		require.Empty(t, pool.slots)
+	})
+}
@@ -9,10 +9,13 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -43,6 +46,12 @@ const (
 type Conn interface {
 	Bind(username, password string) error
 
+	SimpleBind(simpleBindRequest *ldap.SimpleBindRequest) (*ldap.SimpleBindResult, error)
+
+	ExternalBind() error
+
+	GSSAPIBind(client ldap.GSSAPIClient, servicePrincipal, authzid string) error
+
 	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
 
 	SearchWithPaging(searchRequest *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error)
@@ -85,21 +94,42 @@ type ProviderConfig struct {
 	ResourceUID types.UID
 
 	// Host is the hostname or "hostname:port" of the LDAP server. When the port is not specified,
-	// the default LDAP port will be used.
+	// the default LDAP port will be used. The Host is also used to identify users, so it does not change when
+	// another host is used to connect.
 	Host string
 
+	// AdditionalHosts are other hostnames or "hostname:port" of servers for the same directory. They are tried in
+	// order when the Host cannot be dialed or the bind account cannot bind, with recently failed hosts tried last.
+	AdditionalHosts []string
+
+	// SRVRecordName is the optional name of a DNS SRV record which is looked up to find more hosts, which are tried
+	// after the Host and the AdditionalHosts.
+	SRVRecordName string
+
 	// ConnectionProtocol determines how to establish the connection to the server. Either StartTLS or TLS.
 	ConnectionProtocol LDAPConnectionProtocol
 
 	// PEM-encoded CA cert bundle to trust when connecting to the LDAP server. Can be nil.
 	CABundle []byte
 
-	// BindUsername is the username to use when performing a bind with the upstream LDAP IDP.
+	// BindMethod determines how to bind as the bind account. Empty means SimpleBind.
+	BindMethod BindMethod
+
+	// BindUsername is the username to use when performing a bind with the upstream LDAP IDP. For GSSAPIBind,
+	// it is the Kerberos principal name. For ExternalBind, it is only used to describe the bind account.
 	BindUsername string
 
-	// BindPassword is the password to use when performing a bind with the upstream LDAP IDP.
+	// BindPassword is the password to use when performing a bind with the upstream LDAP IDP. Only used for SimpleBind.
 	BindPassword string
 
+	// GSSAPI contains the Kerberos settings which are used for GSSAPIBind.
+	GSSAPI GSSAPIConfig
+
+	// BindClientCertificate and BindClientKey are the PEM-encoded client certificate and private key which are
+	// presented during the TLS handshake for ExternalBind.
+	BindClientCertificate []byte
+	BindClientKey         []byte
+
 	// UserSearch contains information about how to search for users in the upstream LDAP IDP.
 	UserSearch UserSearchConfig
 
@@ -109,6 +139,20 @@ type ProviderConfig struct {
 	// Dialer exists to enable testing. When nil, will use a default appropriate for production use.
 	Dialer LDAPDialer
 
+	// SRVResolver exists to enable testing. When nil, will use a default appropriate for production use.
+	SRVResolver SRVResolver
+
+	// GSSAPIClientFactory exists to enable testing. When nil, will use a default appropriate for production use.
+	GSSAPIClientFactory GSSAPIClientFactory
+
+	// ConnectionPool is used to reuse connections for logins and refreshes. When nil, each login and refresh
+	// uses a new connection.
+	ConnectionPool *ConnectionPool
+
+	// GroupCache is used to cache the groups of users during refreshes when GroupSearch.CacheTTL is set.
+	// When nil, the groups are not cached.
+	GroupCache GroupCache
+
 	// UIDAttributeParsingOverrides are mappings between an attribute name and a way to parse it as a UID when
 	// it comes out of LDAP.
 	UIDAttributeParsingOverrides map[string]func(*ldap.Entry) (string, error)
@@ -136,6 +180,10 @@ type UserSearchConfig struct {
 	// UIDAttribute is the attribute in the LDAP entry from which the user's unique ID should be
 	// retrieved.
 	UIDAttribute string
+
+	// AdditionalClaimMappings maps the names of downstream additional claims to the names of the attributes
+	// in the LDAP entry whose values should become the values of those claims. Empty means no additional claims.
+	AdditionalClaimMappings map[string]string
 }
 
 // GroupSearchConfig contains information about how to search for group membership for users in the upstream LDAP IDP.
@@ -159,10 +207,30 @@ type GroupSearchConfig struct {
 	// (every 5 minutes). This can be done if group search is very slow or resource intensive for the LDAP
 	// server.
 	SkipGroupRefresh bool
+
+	// NestedGroupsMaxDepth is the maximum number of levels of nested groups to resolve by searching for the groups
+	// which contain the groups that were found, using the group's DN in the Filter. Zero means to skip resolving
+	// nested groups, in which case users will only belong to the groups which directly contain them.
+	NestedGroupsMaxDepth int
+
+	// CacheTTL is how long the groups of a user which were found during a refresh should be cached and reused
+	// by other refreshes, using the ProviderConfig's GroupCache. Zero means to skip caching, in which case the
+	// groups are searched for during each refresh.
+	CacheTTL time.Duration
+}
+
+// GroupCache caches the groups of users. It is implemented by *ldapgroupcache.Cache.
+type GroupCache interface {
+	// GetGroups returns false when there are no unexpired groups cached for the key.
+	GetGroups(ctx context.Context, key string) ([]string, bool, error)
+
+	// PutGroups caches the groups for the key, which may be an empty list, for the duration of the ttl.
+	PutGroups(ctx context.Context, key string, groups []string, ttl time.Duration) error
 }
 
 type Provider struct {
-	c ProviderConfig
+	c      ProviderConfig
+	health *hostHealth
 }
 
 var _ upstreamprovider.UpstreamLDAPIdentityProviderI = &Provider{}
@@ -171,7 +239,7 @@ var _ authenticators.UserAuthenticator = &Provider{}
 // New creates a Provider. The config is not a pointer to ensure that a copy of the config is created,
 // making the resulting Provider use an effectively read-only configuration.
 func New(config ProviderConfig) *Provider {
-	return &Provider{c: config}
+	return &Provider{c: config, health: newHostHealth()}
 }
 
 // GetConfig is a reader for the config. Returns a copy of the config to keep the underlying config read-only.
@@ -186,86 +254,139 @@ func closeAndLogError(conn Conn, doingWhat string) {
 	}
 }
 
-func (p *Provider) PerformRefresh(ctx context.Context, storedRefreshAttributes upstreamprovider.LDAPRefreshAttributes, idpDisplayName string) ([]string, error) {
+// PerformRefresh validates the user's entry again and returns the user's groups. It also returns the user's
+// additional claims, which is nil when there are no AdditionalClaimMappings.
+func (p *Provider) PerformRefresh(ctx context.Context, storedRefreshAttributes upstreamprovider.LDAPRefreshAttributes, idpDisplayName string) ([]string, map[string]any, error) {
 	t := trace.FromContext(ctx).Nest("slow ldap refresh attempt", trace.Field{Key: "providerName", Value: p.GetResourceName()})
 	defer t.LogIfLong(500 * time.Millisecond) // to help users debug slow LDAP searches
-	userDN := storedRefreshAttributes.DN
 
-	conn, err := p.dial(ctx)
+	pc, err := p.getBoundConn(ctx, "before user search")
 	if err != nil {
-		return nil, fmt.Errorf(`error dialing host %q: %w`, p.c.Host, err)
+		return nil, nil, err
 	}
-	defer closeAndLogError(conn, "refreshing connection")
 
-	err = conn.Bind(p.c.BindUsername, p.c.BindPassword)
-	if err != nil {
-		return nil, fmt.Errorf(`error binding as %q before user search: %w`, p.c.BindUsername, err)
-	}
+	groups, additionalClaims, err := p.performRefresh(ctx, t, pc.conn, storedRefreshAttributes, idpDisplayName)
+	p.releaseConn(pc, "refreshing connection", err)
+	return groups, additionalClaims, err
+}
+
+func (p *Provider) performRefresh(ctx context.Context, t *trace.Trace, conn Conn, storedRefreshAttributes upstreamprovider.LDAPRefreshAttributes, idpDisplayName string) ([]string, map[string]any, error) {
+	userDN := storedRefreshAttributes.DN
 
 	searchResult, err := p.performUserRefreshSearch(conn, userDN)
 	if err != nil {
 		p.traceRefreshFailure(t, err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	// if any more or less than one entry, error.
 	// we don't need to worry about logging this because we know it's a dn.
 	if len(searchResult.Entries) != 1 {
-		return nil, fmt.Errorf(`searching for user %q resulted in %d search results, but expected 1 result`,
+		return nil, nil, fmt.Errorf(`searching for user %q resulted in %d search results, but expected 1 result`,
 			userDN, len(searchResult.Entries),
 		)
 	}
 
 	userEntry := searchResult.Entries[0]
 	if len(userEntry.DN) == 0 {
-		return nil, fmt.Errorf(`searching for user with original DN %q resulted in search result without DN`, userDN)
+		return nil, nil, fmt.Errorf(`searching for user with original DN %q resulted in search result without DN`, userDN)
 	}
 
 	newUsername, err := p.getSearchResultAttributeValue(p.c.UserSearch.UsernameAttribute, userEntry, userDN)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if newUsername != storedRefreshAttributes.Username {
-		return nil, fmt.Errorf(`searching for user %q returned a different username than the previous value. expected: %q, actual: %q`,
+		return nil, nil, fmt.Errorf(`searching for user %q returned a different username than the previous value. expected: %q, actual: %q`,
 			userDN, storedRefreshAttributes.Username, newUsername,
 		)
 	}
 
 	newUID, err := p.getSearchResultAttributeRawValueEncoded(p.c.UserSearch.UIDAttribute, userEntry, userDN)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	newSubject := downstreamsubject.LDAP(newUID, *p.GetURL(), idpDisplayName)
 	if newSubject != storedRefreshAttributes.Subject {
-		return nil, fmt.Errorf(`searching for user %q produced a different subject than the previous value. expected: %q, actual: %q`, userDN, storedRefreshAttributes.Subject, newSubject)
+		return nil, nil, fmt.Errorf(`searching for user %q produced a different subject than the previous value. expected: %q, actual: %q`, userDN, storedRefreshAttributes.Subject, newSubject)
 	}
 	for attribute, validateFunc := range p.c.RefreshAttributeChecks {
 		err = validateFunc(userEntry, storedRefreshAttributes)
 		if err != nil {
-			return nil, fmt.Errorf(`validation for attribute %q failed during upstream refresh: %w`, attribute, err)
+			return nil, nil, fmt.Errorf(`validation for attribute %q failed during upstream refresh: %w`, attribute, err)
 		}
 	}
 
+	additionalClaims := p.additionalClaimsFromEntry(userEntry)
+
 	// If we were configured to always skip group refresh for all users and all sessions, then skip it.
 	if p.c.GroupSearch.SkipGroupRefresh {
-		return storedRefreshAttributes.Groups, nil
+		return storedRefreshAttributes.Groups, additionalClaims, nil
 	}
 
 	var groupSearchUserAttributeForFilterValue string
 	if p.useGroupSearchUserAttributeForFilter() {
 		groupSearchUserAttributeForFilterValue, err = p.getSearchResultAttributeValue(p.c.GroupSearch.UserAttributeForFilter, userEntry, newUsername)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
+	groups, err := p.searchGroupsForUserMembershipWithCache(ctx, conn, userDN, groupSearchUserAttributeForFilterValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	return groups, additionalClaims, nil
+}
+
+// searchGroupsForUserMembershipWithCache returns the cached groups of the user when the groups were cached by
+// a previous refresh, and otherwise searches for the groups and caches them. Failing to use the cache is not
+// fatal, since the groups can still be searched for.
+func (p *Provider) searchGroupsForUserMembershipWithCache(ctx context.Context, conn Conn, userDN string, groupSearchUserAttributeForFilterValue string) ([]string, error) {
+	if p.c.GroupCache == nil || p.c.GroupSearch.CacheTTL <= 0 {
+		return p.searchGroupsForUserMembership(conn, userDN, groupSearchUserAttributeForFilterValue)
+	}
+
+	cacheKey := p.groupCacheKey(userDN, groupSearchUserAttributeForFilterValue)
+	cachedGroups, found, err := p.c.GroupCache.GetGroups(ctx, cacheKey)
+	if err != nil {
+		plog.WarningErr("error getting cached groups for user, will search for groups instead", err,
+			"upstreamName", p.GetResourceName(), "userDN", userDN)
+	}
+	if found {
+		plog.Debug("using cached groups for user during refresh", "upstreamName", p.GetResourceName(), "userDN", userDN)
+		return cachedGroups, nil
+	}
+
 	mappedGroupNames, err := p.searchGroupsForUserMembership(conn, userDN, groupSearchUserAttributeForFilterValue)
 	if err != nil {
 		return nil, err
 	}
+
+	err = p.c.GroupCache.PutGroups(ctx, cacheKey, mappedGroupNames, p.c.GroupSearch.CacheTTL)
+	if err != nil {
+		plog.WarningErr("error caching groups for user", err, "upstreamName", p.GetResourceName(), "userDN", userDN)
+	}
 	return mappedGroupNames, nil
 }
 
+// groupCacheKey identifies the user and the settings which determine the result of searching for the user's groups,
+// so the cached groups are not used after the settings are changed.
+func (p *Provider) groupCacheKey(userDN string, groupSearchUserAttributeForFilterValue string) string {
+	key, _ := json.Marshal([]string{
+		string(p.c.ResourceUID),
+		p.c.Host,
+		p.c.GroupSearch.Base,
+		p.c.GroupSearch.Filter,
+		p.c.GroupSearch.UserAttributeForFilter,
+		p.c.GroupSearch.GroupNameAttribute,
+		strconv.Itoa(p.c.GroupSearch.NestedGroupsMaxDepth),
+		userDN,
+		groupSearchUserAttributeForFilterValue,
+	})
+	return string(key)
+}
+
 func (p *Provider) performUserRefreshSearch(conn Conn, userDN string) (*ldap.SearchResult, error) {
 	search := p.refreshUserSearchRequest(userDN)
 
@@ -277,13 +398,68 @@ func (p *Provider) performUserRefreshSearch(conn Conn, userDN string) (*ldap.Sea
 	return searchResult, nil
 }
 
-func (p *Provider) dial(ctx context.Context) (Conn, error) {
-	tlsAddr, err := endpointaddr.Parse(p.c.Host, defaultLDAPSPort)
+// dialAndBind dials each of the hosts in turn and binds as the bind account, and returns the first connection
+// which was successfully bound. Hosts which have recently failed are tried after the others. The bindErrorContext
+// describes why the bind is happening, for use in error messages. When every host fails, the returned error
+// describes the failure of each host.
+func (p *Provider) dialAndBind(ctx context.Context, bindErrorContext string) (Conn, string, error) {
+	hosts, srvErr := p.hosts(ctx)
+	if srvErr != nil {
+		// Continue with the other hosts, since they may still work.
+		plog.WarningErr("could not look up LDAP hosts from DNS SRV record", srvErr, "providerName", p.GetResourceName())
+	}
+
+	errs := make([]error, 0, len(hosts)+1)
+	for _, host := range p.health.sortByHealth(hosts) {
+		conn, err := p.dialAndBindHost(ctx, host, bindErrorContext)
+		if err != nil {
+			p.health.markFailed(host)
+			errs = append(errs, err)
+			continue
+		}
+		p.health.markHealthy(host)
+		return conn, host, nil
+	}
+
+	if !p.hasMultipleHosts() {
+		return nil, "", errs[0]
+	}
+	if srvErr != nil {
+		errs = append(errs, srvErr)
+	}
+	return nil, "", fmt.Errorf("could not connect to any of the LDAP hosts: %w", errors.Join(errs...))
+}
+
+// dialAndBindHost dials one host and binds as the bind account.
+func (p *Provider) dialAndBindHost(ctx context.Context, host string, bindErrorContext string) (Conn, error) {
+	conn, err := p.dial(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf(`error dialing host %q: %w`, host, err)
+	}
+
+	err = p.bindAsBindAccount(conn, host)
+	if err != nil {
+		closeAndLogError(conn, "binding failed")
+		if p.hasMultipleHosts() {
+			// Say which host failed, since the error would otherwise be ambiguous.
+			bindErrorContext = strings.TrimSpace(fmt.Sprintf("to host %q %s", host, bindErrorContext))
+		}
+		if bindErrorContext != "" {
+			return nil, fmt.Errorf(`error binding as %q %s: %w`, p.c.BindUsername, bindErrorContext, err)
+		}
+		return nil, fmt.Errorf(`error binding as %q: %w`, p.c.BindUsername, err)
+	}
+
+	return conn, nil
+}
+
+func (p *Provider) dial(ctx context.Context, host string) (Conn, error) {
+	tlsAddr, err := endpointaddr.Parse(host, defaultLDAPSPort)
 	if err != nil {
 		return nil, ldap.NewError(ldap.ErrorNetwork, err)
 	}
 
-	startTLSAddr, err := endpointaddr.Parse(p.c.Host, defaultLDAPPort)
+	startTLSAddr, err := endpointaddr.Parse(host, defaultLDAPPort)
 	if err != nil {
 		return nil, ldap.NewError(ldap.ErrorNetwork, err)
 	}
@@ -369,7 +545,13 @@ func (p *Provider) tlsConfig() (*tls.Config, error) {
 			return nil, fmt.Errorf("could not parse CA bundle")
 		}
 	}
-	return ptls.DefaultLDAP(rootCAs), nil
+	tlsConfig := ptls.DefaultLDAP(rootCAs)
+	certificates, err := p.bindClientCertificates()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.Certificates = certificates
+	return tlsConfig, nil
 }
 
 // GetResourceName returns a name for this upstream provider.
@@ -400,18 +582,42 @@ func (p *Provider) TestConnection(ctx context.Context) error {
 		return err
 	}
 
-	conn, err := p.dial(ctx)
+	conn, _, err := p.dialAndBind(ctx, "")
 	if err != nil {
-		return fmt.Errorf(`error dialing host %q: %w`, p.c.Host, err)
+		return err
 	}
-	defer closeAndLogError(conn, "testing connection")
+	closeAndLogError(conn, "testing connection")
 
-	err = conn.Bind(p.c.BindUsername, p.c.BindPassword)
+	return nil
+}
+
+// TestConnectionToEachHost is like TestConnection, except that it performs a dial and bind to each of the hosts
+// instead of stopping at the first host which works. It returns the result for each host, and an error when the config
+// is invalid. When the SRVRecordName could not be looked up, the last result is for the SRVRecordName.
+func (p *Provider) TestConnectionToEachHost(ctx context.Context) ([]HostConnectionResult, error) {
+	err := p.validateConfig()
 	if err != nil {
-		return fmt.Errorf(`error binding as %q: %w`, p.c.BindUsername, err)
+		return nil, err
 	}
 
-	return nil
+	hosts, srvErr := p.hosts(ctx)
+	results := make([]HostConnectionResult, 0, len(hosts)+1)
+	for _, host := range hosts {
+		conn, err := p.dialAndBindHost(ctx, host, "")
+		if err != nil {
+			p.health.markFailed(host)
+			results = append(results, HostConnectionResult{Host: host, Err: err})
+			continue
+		}
+		p.health.markHealthy(host)
+		closeAndLogError(conn, "testing connection")
+		results = append(results, HostConnectionResult{Host: host})
+	}
+	if srvErr != nil {
+		results = append(results, HostConnectionResult{Host: p.c.SRVRecordName, Err: srvErr})
+	}
+
+	return results, nil
 }
 
 // DryRunAuthenticateUser provides a method for testing all the Provider settings in a kind of dry run of
@@ -423,18 +629,39 @@ func (p *Provider) DryRunAuthenticateUser(ctx context.Context, username string)
 		// Act as if the end user bind always succeeds.
 		return nil
 	}
-	return p.authenticateUserImpl(ctx, username, endUserBindFunc)
+	return p.authenticateUserImpl(ctx, username, endUserBindFunc, false)
+}
+
+// LookupUserGroups searches for a user using the configured user search, as if the key were the username typed
+// by that user during login, and returns that user's groups using the configured group search. Like
+// DryRunAuthenticateUser, it does not bind as that user. When no user is found, it returns an empty list.
+func (p *Provider) LookupUserGroups(ctx context.Context, key string) ([]string, error) {
+	response, found, err := p.DryRunAuthenticateUser(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !found || response.User.GetGroups() == nil {
+		return []string{}, nil
+	}
+	return response.User.GetGroups(), nil
 }
 
 // AuthenticateUser authenticates an end user and returns their mapped username, groups, and UID. Implements authenticators.UserAuthenticator.
+// When the LDAP server indicates that the status of the end user's account does not allow them to log in, e.g. because
+// their password has expired, then the returned error will be an *AccountStatusError.
 func (p *Provider) AuthenticateUser(ctx context.Context, username, password string) (*authenticators.Response, bool, error) {
 	endUserBindFunc := func(conn Conn, foundUserDN string) error {
-		return conn.Bind(foundUserDN, password)
+		return bindAsEndUser(conn, foundUserDN, password)
 	}
-	return p.authenticateUserImpl(ctx, username, endUserBindFunc)
+	return p.authenticateUserImpl(ctx, username, endUserBindFunc, true)
 }
 
-func (p *Provider) authenticateUserImpl(ctx context.Context, username string, bindFunc func(conn Conn, foundUserDN string) error) (*authenticators.Response, bool, error) {
+func (p *Provider) authenticateUserImpl(
+	ctx context.Context,
+	username string,
+	bindFunc func(conn Conn, foundUserDN string) error,
+	bindFuncBindsAsEndUser bool,
+) (*authenticators.Response, bool, error) {
 	t := trace.FromContext(ctx).Nest("slow ldap authenticate user attempt", trace.Field{Key: "providerName", Value: p.GetResourceName()})
 	defer t.LogIfLong(500 * time.Millisecond) // to help users debug slow LDAP searches
 
@@ -450,20 +677,20 @@ func (p *Provider) authenticateUserImpl(ctx context.Context, username string, bi
 		return nil, false, nil
 	}
 
-	conn, err := p.dial(ctx)
+	pc, err := p.getBoundConn(ctx, "before user search")
 	if err != nil {
 		p.traceAuthFailure(t, err)
-		return nil, false, fmt.Errorf(`error dialing host %q: %w`, p.c.Host, err)
-	}
-	defer closeAndLogError(conn, "authenticating user")
-
-	err = conn.Bind(p.c.BindUsername, p.c.BindPassword)
-	if err != nil {
-		p.traceAuthFailure(t, err)
-		return nil, false, fmt.Errorf(`error binding as %q before user search: %w`, p.c.BindUsername, err)
+		return nil, false, err
 	}
 
-	response, err := p.searchAndBindUser(conn, username, bindFunc)
+	response, err := p.searchAndBindUser(pc.conn, username, func(conn Conn, foundUserDN string) error {
+		if bindFuncBindsAsEndUser {
+			// The connection will need to be bound as the bind account again before it can be reused.
+			pc.boundAsBindAccount = false
+		}
+		return bindFunc(conn, foundUserDN)
+	})
+	p.releaseConn(pc, "authenticating user", err)
 	if err != nil {
 		p.traceAuthFailure(t, err)
 		return nil, false, err
@@ -488,6 +715,60 @@ func (p *Provider) searchGroupsForUserMembership(conn Conn, userDN string, group
 		return nil, fmt.Errorf(`error searching for group memberships for user with DN %q: %w`, userDN, err)
 	}
 
+	groups, err := p.groupNamesFromSearchResultEntries(searchResult.Entries, userDN)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.c.GroupSearch.NestedGroupsMaxDepth > 0 {
+		nestedGroups, err := p.searchNestedGroups(conn, searchResult.Entries, userDN)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, nestedGroups...)
+	}
+
+	// de-duplicate the list of groups by turning it into a set,
+	// then turn it back into a sorted list.
+	return sets.NewString(groups...).List(), nil
+}
+
+// searchNestedGroups searches for the groups which contain the directGroups, and then for the groups which contain
+// those groups, and so on, up to the configured maximum depth. Each group is only searched once, so cycles of groups
+// which contain each other are not a problem. It returns the names of all the groups which were found.
+func (p *Provider) searchNestedGroups(conn Conn, directGroups []*ldap.Entry, userDN string) ([]string, error) {
+	// DNs are not case-sensitive, so remember them in lower case.
+	searchedGroupDNs := sets.New[string]()
+	groups := []string{}
+	groupsToSearch := directGroups
+
+	for depth := 1; depth <= p.c.GroupSearch.NestedGroupsMaxDepth && len(groupsToSearch) > 0; depth++ {
+		var foundGroups []*ldap.Entry
+		for _, group := range groupsToSearch {
+			if searchedGroupDNs.Has(strings.ToLower(group.DN)) {
+				continue
+			}
+			searchedGroupDNs.Insert(strings.ToLower(group.DN))
+
+			searchResult, err := conn.SearchWithPaging(p.nestedGroupSearchRequest(group.DN), groupSearchPageSize)
+			if err != nil {
+				return nil, fmt.Errorf(`error searching for nested group memberships of group with DN %q: %w`, group.DN, err)
+			}
+
+			names, err := p.groupNamesFromSearchResultEntries(searchResult.Entries, userDN)
+			if err != nil {
+				return nil, err
+			}
+			groups = append(groups, names...)
+			foundGroups = append(foundGroups, searchResult.Entries...)
+		}
+		groupsToSearch = foundGroups
+	}
+
+	return groups, nil
+}
+
+func (p *Provider) groupNamesFromSearchResultEntries(entries []*ldap.Entry, userDN string) ([]string, error) {
 	groupAttributeName := p.c.GroupSearch.GroupNameAttribute
 	if len(groupAttributeName) == 0 {
 		groupAttributeName = distinguishedNameAttributeName
@@ -495,7 +776,7 @@ func (p *Provider) searchGroupsForUserMembership(conn Conn, userDN string, group
 
 	groups := []string{}
 entries:
-	for _, groupEntry := range searchResult.Entries {
+	for _, groupEntry := range entries {
 		if len(groupEntry.DN) == 0 {
 			return nil, fmt.Errorf(`searching for group memberships for user with DN %q resulted in search result without DN`, userDN)
 		}
@@ -514,9 +795,7 @@ entries:
 		}
 		groups = append(groups, mappedGroupName)
 	}
-	// de-duplicate the list of groups by turning it into a set,
-	// then turn it back into a sorted list.
-	return sets.NewString(groups...).List(), nil
+	return groups, nil
 }
 
 func (p *Provider) validateConfig() error {
@@ -524,6 +803,20 @@ func (p *Provider) validateConfig() error {
 		// LDAP search filters do not allow searching by DN, so we would have no reasonable default for Filter.
 		return fmt.Errorf(`must specify UserSearch Filter when UserSearch UsernameAttribute is "dn"`)
 	}
+	switch p.c.BindMethod {
+	case GSSAPIBind:
+		if len(p.c.GSSAPI.Keytab) == 0 || len(p.c.GSSAPI.Krb5Config) == 0 {
+			return fmt.Errorf("must specify a keytab and a krb5.conf when using the GSSAPI bind method")
+		}
+	case ExternalBind:
+		if len(p.c.BindClientCertificate) == 0 || len(p.c.BindClientKey) == 0 {
+			return fmt.Errorf("must specify a client certificate and key when using the External bind method")
+		}
+	case SimpleBind, "":
+		// The BindUsername and BindPassword are checked by the server.
+	default:
+		return fmt.Errorf("unsupported bind method %q", p.c.BindMethod)
+	}
 	return nil
 }
 
@@ -531,19 +824,13 @@ func (p *Provider) SearchForDefaultNamingContext(ctx context.Context) (string, e
 	t := trace.FromContext(ctx).Nest("slow ldap attempt when searching for default naming context", trace.Field{Key: "providerName", Value: p.GetResourceName()})
 	defer t.LogIfLong(500 * time.Millisecond) // to help users debug slow LDAP searches
 
-	conn, err := p.dial(ctx)
+	conn, _, err := p.dialAndBind(ctx, "before querying for defaultNamingContext")
 	if err != nil {
 		p.traceSearchBaseDiscoveryFailure(t, err)
-		return "", fmt.Errorf(`error dialing host %q: %w`, p.c.Host, err)
+		return "", err
 	}
 	defer closeAndLogError(conn, "searching for default naming context")
 
-	err = conn.Bind(p.c.BindUsername, p.c.BindPassword)
-	if err != nil {
-		p.traceSearchBaseDiscoveryFailure(t, err)
-		return "", fmt.Errorf(`error binding as %q before querying for defaultNamingContext: %w`, p.c.BindUsername, err)
-	}
-
 	searchResult, err := conn.Search(p.defaultNamingContextRequest())
 	if err != nil {
 		return "", fmt.Errorf(`error querying RootDSE for defaultNamingContext: %w`, err)
@@ -633,6 +920,10 @@ func (p *Provider) searchAndBindUser(conn Conn, username string, bindFunc func(c
 	if err != nil {
 		plog.DebugErr("error binding for user (if this is not the expected dn for this username, please check the user search configuration)",
 			err, "upstreamName", p.GetResourceName(), "username", username, "dn", userEntry.DN)
+		accountStatusErr := &AccountStatusError{}
+		if errors.As(err, &accountStatusErr) {
+			return nil, accountStatusErr
+		}
 		ldapErr := &ldap.Error{}
 		if errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultInvalidCredentials {
 			return nil, nil
@@ -653,6 +944,7 @@ func (p *Provider) searchAndBindUser(conn Conn, username string, bindFunc func(c
 		},
 		DN:                     userEntry.DN,
 		ExtraRefreshAttributes: mappedRefreshAttributes,
+		AdditionalClaims:       p.additionalClaimsFromEntry(userEntry),
 	}
 
 	return response, nil
@@ -688,6 +980,15 @@ func (p *Provider) userSearchRequest(username string) *ldap.SearchRequest {
 }
 
 func (p *Provider) groupSearchRequest(userDN string, groupSearchUserAttributeForFilterValue string) *ldap.SearchRequest {
+	return p.groupSearchRequestWithFilter(p.groupSearchFilter(userDN, groupSearchUserAttributeForFilterValue))
+}
+
+func (p *Provider) nestedGroupSearchRequest(groupDN string) *ldap.SearchRequest {
+	// Always use the DN of the group, since UserAttributeForFilter is an attribute of users.
+	return p.groupSearchRequestWithFilter(p.groupSearchFilterForValue(groupDN))
+}
+
+func (p *Provider) groupSearchRequestWithFilter(filter string) *ldap.SearchRequest {
 	// See https://ldap.com/the-ldap-search-operation for general documentation of LDAP search options.
 	return &ldap.SearchRequest{
 		BaseDN:       p.c.GroupSearch.Base,
@@ -696,7 +997,7 @@ func (p *Provider) groupSearchRequest(userDN string, groupSearchUserAttributeFor
 		SizeLimit:    0, // unlimited size because we will search with paging
 		TimeLimit:    90,
 		TypesOnly:    false,
-		Filter:       p.groupSearchFilter(userDN, groupSearchUserAttributeForFilterValue),
+		Filter:       filter,
 		Attributes:   p.groupSearchRequestedAttributes(),
 		Controls:     nil, // nil because ldap.SearchWithPaging() will set the appropriate controls for us
 	}
@@ -736,9 +1037,55 @@ func (p *Provider) userSearchRequestedAttributes() []string {
 	for k := range p.c.RefreshAttributeChecks {
 		attributes = append(attributes, k)
 	}
+	for _, attributeName := range p.additionalClaimAttributeNames() {
+		if attributeName != distinguishedNameAttributeName && !slices.Contains(attributes, attributeName) {
+			attributes = append(attributes, attributeName)
+		}
+	}
 	return attributes
 }
 
+// additionalClaimAttributeNames returns the names of the attributes in the AdditionalClaimMappings, sorted by the
+// names of their claims so the user search request is always the same.
+func (p *Provider) additionalClaimAttributeNames() []string {
+	claimNames := sets.List(sets.KeySet(p.c.UserSearch.AdditionalClaimMappings))
+	attributeNames := make([]string, 0, len(claimNames))
+	for _, claimName := range claimNames {
+		attributeNames = append(attributeNames, p.c.UserSearch.AdditionalClaimMappings[claimName])
+	}
+	return attributeNames
+}
+
+// additionalClaimsFromEntry returns the additional claims mapped from the attributes of the user's entry, or nil
+// when there are no AdditionalClaimMappings. An attribute with one value becomes a string claim, and an attribute
+// with several values becomes a list of strings. Attributes which are missing from the entry are skipped.
+func (p *Provider) additionalClaimsFromEntry(userEntry *ldap.Entry) map[string]any {
+	if len(p.c.UserSearch.AdditionalClaimMappings) == 0 {
+		return nil
+	}
+
+	additionalClaims := make(map[string]any, len(p.c.UserSearch.AdditionalClaimMappings))
+	for claimName, attributeName := range p.c.UserSearch.AdditionalClaimMappings {
+		var values []string
+		if attributeName == distinguishedNameAttributeName {
+			values = []string{userEntry.DN}
+		} else {
+			values = userEntry.GetAttributeValues(attributeName)
+		}
+
+		switch len(values) {
+		case 0:
+			plog.Debug("additionalClaims mapping attribute missing from user entry",
+				"upstreamName", p.GetResourceName(), "claimName", claimName, "attributeName", attributeName)
+		case 1:
+			additionalClaims[claimName] = values[0]
+		default:
+			additionalClaims[claimName] = values
+		}
+	}
+	return additionalClaims
+}
+
 func (p *Provider) groupSearchRequestedAttributes() []string {
 	switch p.c.GroupSearch.GroupNameAttribute {
 	case "":
@@ -766,6 +1113,10 @@ func (p *Provider) groupSearchFilter(userDN string, groupSearchUserAttributeForF
 		// Instead of using the DN in placeholder substitution, use the value of the specified attribute.
 		valueToInterpolate = groupSearchUserAttributeForFilterValue
 	}
+	return p.groupSearchFilterForValue(valueToInterpolate)
+}
+
+func (p *Provider) groupSearchFilterForValue(valueToInterpolate string) string {
 	// The value to interpolate can contain characters that are considered special characters by LDAP searches,
 	// so it should be escaped before being included in the search filter to prevent bad search syntax.
 	// E.g. for the DN `CN=My User (Admin),OU=Users,OU=my,DC=my,DC=domain` we must escape the parens.
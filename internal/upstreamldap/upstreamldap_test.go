@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"testing"
 	"time"
 
@@ -65,6 +66,15 @@ var (
 	testGroupSearchFilterInterpolated = fmt.Sprintf(testGroupSearchFilterInterpolationSpec, testUserSearchResultDNValue, testUserSearchResultDNValue)
 )
 
+// expectedEndUserBind returns the bind request which is expected when binding as an end user to test their password.
+func expectedEndUserBind(userDN, password string) *ldap.SimpleBindRequest {
+	return &ldap.SimpleBindRequest{
+		Username: userDN,
+		Password: password,
+		Controls: []ldap.Control{ldap.NewControlBeheraPasswordPolicy()},
+	}
+}
+
 func TestEndUserAuthentication(t *testing.T) {
 	providerConfig := func(editFunc func(p *ProviderConfig)) *ProviderConfig {
 		config := &ProviderConfig{
@@ -200,7 +210,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -219,7 +229,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -238,7 +248,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -259,7 +269,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(func(r *authenticators.Response) {
 				r.User = &user.DefaultInfo{
@@ -282,13 +292,48 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(func(r *authenticators.Response) {
 				info := r.User.(*user.DefaultInfo)
 				info.Groups = []string{}
 			}),
 		},
+		{
+			name:     "when additional claims are mapped from the attributes of the user entry",
+			username: testUpstreamUsername,
+			password: testUpstreamPassword,
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UserSearch.AdditionalClaimMappings = map[string]string{"email": "mail", "types": "employeeType", "missing": "some-missing-attribute"}
+			}),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(func(r *ldap.SearchRequest) {
+					r.Attributes = append(r.Attributes, "mail", "some-missing-attribute", "employeeType")
+				})).Return(&ldap.SearchResult{
+					Entries: []*ldap.Entry{
+						{
+							DN: testUserSearchResultDNValue,
+							Attributes: []*ldap.EntryAttribute{
+								ldap.NewEntryAttribute(testUserSearchUsernameAttribute, []string{testUserSearchResultUsernameAttributeValue}),
+								ldap.NewEntryAttribute(testUserSearchUIDAttribute, []string{testUserSearchResultUIDAttributeValue}),
+								ldap.NewEntryAttribute("mail", []string{"some-email@example.com"}),
+								ldap.NewEntryAttribute("employeeType", []string{"some-type", "some-other-type"}),
+							},
+						},
+					},
+				}, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
+			},
+			wantAuthResponse: expectedAuthResponse(func(r *authenticators.Response) {
+				r.AdditionalClaims = map[string]any{"email": "some-email@example.com", "types": []string{"some-type", "some-other-type"}}
+			}),
+		},
 		{
 			name:     "when the UsernameAttribute is dn and there is a user search filter provided",
 			username: testUpstreamUsername,
@@ -315,7 +360,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(func(r *authenticators.Response) {
 				info := r.User.(*user.DefaultInfo)
@@ -348,7 +393,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(func(r *authenticators.Response) {
 				info := r.User.(*user.DefaultInfo)
@@ -372,7 +417,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(func(r *authenticators.Response) {
 				info := r.User.(*user.DefaultInfo)
@@ -396,7 +441,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(func(r *authenticators.Response) {
 				info := r.User.(*user.DefaultInfo)
@@ -437,7 +482,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -458,7 +503,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -479,7 +524,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -498,7 +543,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -519,7 +564,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -549,7 +594,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserDNWithSpecialChars, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserDNWithSpecialChars, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(func(r *authenticators.Response) {
 				r.DN = testUserDNWithSpecialChars
@@ -582,7 +627,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserDNWithSpecialChars, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserDNWithSpecialChars, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(func(r *authenticators.Response) {
 				r.DN = testUserDNWithSpecialChars
@@ -624,7 +669,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: &authenticators.Response{
 				User: &user.DefaultInfo{
@@ -668,7 +713,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(func(r *authenticators.Response) {
 				r.ExtraRefreshAttributes = map[string]string{"some-attribute-to-check-during-refresh": "c29tZS1hdHRyaWJ1dGUtdmFsdWU"}
@@ -729,7 +774,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -823,7 +868,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -860,7 +905,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -898,7 +943,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Times(1)
 			},
 			wantAuthResponse: expectedAuthResponse(nil),
 		},
@@ -1313,7 +1358,7 @@ func TestEndUserAuthentication(t *testing.T) {
 				conn.EXPECT().Close().Times(1)
 			},
 			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Return(errors.New("some bind error")).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Return(nil, errors.New("some bind error")).Times(1)
 			},
 			skipDryRunAuthenticateUser: true,
 			wantError:                  testutil.WantSprintfErrorString(`error binding for user "%s" using provided password against DN "%s": some bind error`, testUpstreamUsername, testUserSearchResultDNValue),
@@ -1337,8 +1382,51 @@ func TestEndUserAuthentication(t *testing.T) {
 					Err:        errors.New("some bind error"),
 					ResultCode: ldap.LDAPResultInvalidCredentials,
 				}
-				conn.EXPECT().Bind(testUserSearchResultDNValue, testUpstreamPassword).Return(err).Times(1)
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Return(nil, err).Times(1)
+			},
+		},
+		{
+			name:           "when binding as the found user returns an Active Directory locked account error",
+			username:       testUpstreamUsername,
+			password:       testUpstreamPassword,
+			providerConfig: providerConfig(nil),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(nil)).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
+				err := &ldap.Error{
+					Err:        errors.New("80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 775, v4563"),
+					ResultCode: ldap.LDAPResultInvalidCredentials,
+				}
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).Return(nil, err).Times(1)
 			},
+			skipDryRunAuthenticateUser: true,
+			wantError:                  testutil.WantExactErrorString(`end user account status does not allow login: AccountLocked: LDAP Result Code 49 "Invalid Credentials": 80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 775, v4563`),
+		},
+		{
+			name:           "when binding as the found user succeeds but the password policy control says that the password must be changed",
+			username:       testUpstreamUsername,
+			password:       testUpstreamPassword,
+			providerConfig: providerConfig(nil),
+			searchMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(nil)).Return(exampleUserSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).
+					Return(exampleGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			bindEndUserMocks: func(conn *mockldapconn.MockConn) {
+				ppolicy := ldap.NewControlBeheraPasswordPolicy()
+				ppolicy.Error = 2
+				conn.EXPECT().SimpleBind(expectedEndUserBind(testUserSearchResultDNValue, testUpstreamPassword)).
+					Return(&ldap.SimpleBindResult{Controls: []ldap.Control{ppolicy}}, nil).Times(1)
+			},
+			skipDryRunAuthenticateUser: true,
+			wantError:                  testutil.WantExactErrorString(`end user account status does not allow login: PasswordMustChange`),
 		},
 		{
 			name:                "when no username is specified",
@@ -1538,13 +1626,14 @@ func TestUpstreamRefresh(t *testing.T) {
 	}
 
 	tests := []struct {
-		name           string
-		providerConfig *ProviderConfig
-		setupMocks     func(conn *mockldapconn.MockConn)
-		refreshUserDN  string
-		dialError      error
-		wantErr        string
-		wantGroups     []string
+		name                 string
+		providerConfig       *ProviderConfig
+		setupMocks           func(conn *mockldapconn.MockConn)
+		refreshUserDN        string
+		dialError            error
+		wantErr              string
+		wantGroups           []string
+		wantAdditionalClaims map[string]any
 	}{
 		{
 			name: "happy path without group search where searching the dn returns a single entry",
@@ -1569,6 +1658,93 @@ func TestUpstreamRefresh(t *testing.T) {
 			},
 			wantGroups: []string{testGroupSearchResultGroupNameAttributeValue1, testGroupSearchResultGroupNameAttributeValue2},
 		},
+		{
+			name: "happy path where additional claims are mapped from the attributes of the user entry",
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.UserSearch.AdditionalClaimMappings = map[string]string{
+					"email":        "mail",
+					"display":      "displayName",
+					"types":        "employeeType",
+					"missing":      "some-missing-attribute",
+					"dn":           "dn",
+					"same-as-user": testUserSearchUsernameAttribute,
+				}
+			}),
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(func(r *ldap.SearchRequest) {
+					// Sorted by claim name, skipping "dn" and the attributes which are already requested.
+					r.Attributes = append(r.Attributes, "displayName", "mail", "some-missing-attribute", "employeeType")
+				})).Return(&ldap.SearchResult{
+					Entries: []*ldap.Entry{
+						{
+							DN: testUserSearchResultDNValue,
+							Attributes: append(slices.Clone(happyPathUserSearchResult.Entries[0].Attributes),
+								ldap.NewEntryAttribute("mail", []string{"some-email@example.com"}),
+								ldap.NewEntryAttribute("displayName", []string{"Some Display Name"}),
+								ldap.NewEntryAttribute("employeeType", []string{"some-type", "some-other-type"}),
+							),
+						},
+					},
+				}, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).Return(happyPathGroupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantGroups: []string{testGroupSearchResultGroupNameAttributeValue1, testGroupSearchResultGroupNameAttributeValue2},
+			wantAdditionalClaims: map[string]any{
+				"email":        "some-email@example.com",
+				"display":      "Some Display Name",
+				"types":        []string{"some-type", "some-other-type"},
+				"dn":           testUserSearchResultDNValue,
+				"same-as-user": testUserSearchResultUsernameAttributeValue,
+			},
+		},
+		{
+			name: "happy path where additional claims are mapped when skipping group refresh",
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.GroupSearch.SkipGroupRefresh = true
+				p.UserSearch.AdditionalClaimMappings = map[string]string{"missing": "some-missing-attribute"}
+			}),
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(func(r *ldap.SearchRequest) {
+					r.Attributes = append(r.Attributes, "some-missing-attribute")
+				})).Return(happyPathUserSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantAdditionalClaims: map[string]any{},
+		},
+		{
+			name: "happy path where group search returns groups and nested groups",
+			providerConfig: providerConfig(func(p *ProviderConfig) {
+				p.GroupSearch.NestedGroupsMaxDepth = 5
+			}),
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch(nil)).Return(happyPathUserSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(nil), expectedGroupSearchPageSize).Return(happyPathGroupSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(func(r *ldap.SearchRequest) {
+					r.Filter = fmt.Sprintf(testGroupSearchFilterInterpolationSpec, testGroupSearchResultDNValue1, testGroupSearchResultDNValue1)
+				}), expectedGroupSearchPageSize).Return(&ldap.SearchResult{
+					Entries: []*ldap.Entry{
+						{
+							DN: "some-upstream-parent-group-dn",
+							Attributes: []*ldap.EntryAttribute{
+								ldap.NewEntryAttribute(testGroupSearchGroupNameAttribute, []string{"some-upstream-parent-group-name"}),
+							},
+						},
+					},
+				}, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(func(r *ldap.SearchRequest) {
+					r.Filter = fmt.Sprintf(testGroupSearchFilterInterpolationSpec, testGroupSearchResultDNValue2, testGroupSearchResultDNValue2)
+				}), expectedGroupSearchPageSize).Return(&ldap.SearchResult{}, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch(func(r *ldap.SearchRequest) {
+					r.Filter = fmt.Sprintf(testGroupSearchFilterInterpolationSpec, "some-upstream-parent-group-dn", "some-upstream-parent-group-dn")
+				}), expectedGroupSearchPageSize).Return(&ldap.SearchResult{}, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantGroups: []string{testGroupSearchResultGroupNameAttributeValue1, testGroupSearchResultGroupNameAttributeValue2, "some-upstream-parent-group-name"},
+		},
 		{
 			name:           "happy path when the user DN has special LDAP search filter characters then they must be properly escaped in the custom group search filter",
 			providerConfig: providerConfig(nil),
@@ -2201,7 +2377,7 @@ func TestUpstreamRefresh(t *testing.T) {
 				"ldaps://ldap.example.com:8443?base=some-upstream-user-base-dn&idpName=%s&sub=c29tZS11cHN0cmVhbS11aWQtdmFsdWU",
 				testUpstreamName,
 			)
-			groups, err := ldapProvider.PerformRefresh(context.Background(), upstreamprovider.LDAPRefreshAttributes{
+			groups, additionalClaims, err := ldapProvider.PerformRefresh(context.Background(), upstreamprovider.LDAPRefreshAttributes{
 				Username:             testUserSearchResultUsernameAttributeValue,
 				Subject:              subject,
 				DN:                   tt.refreshUserDN,
@@ -2215,8 +2391,214 @@ func TestUpstreamRefresh(t *testing.T) {
 			}
 			require.Equal(t, true, dialWasAttempted)
 			require.Equal(t, tt.wantGroups, groups)
+			require.Equal(t, tt.wantAdditionalClaims, additionalClaims)
+		})
+	}
+}
+
+type fakeGroupCache struct {
+	groups  map[string][]string
+	ttls    map[string]time.Duration
+	getErr  error
+	putErr  error
+	getKeys []string
+}
+
+func (c *fakeGroupCache) GetGroups(_ context.Context, key string) ([]string, bool, error) {
+	c.getKeys = append(c.getKeys, key)
+	if c.getErr != nil {
+		return nil, false, c.getErr
+	}
+	groups, found := c.groups[key]
+	return groups, found, nil
+}
+
+func (c *fakeGroupCache) PutGroups(_ context.Context, key string, groups []string, ttl time.Duration) error {
+	if c.putErr != nil {
+		return c.putErr
+	}
+	c.groups[key] = groups
+	c.ttls[key] = ttl
+	return nil
+}
+
+func TestUpstreamRefreshWithGroupCache(t *testing.T) {
+	const cacheTTL = 5 * time.Minute
+
+	expectedUserSearch := &ldap.SearchRequest{
+		BaseDN:       testUserSearchResultDNValue,
+		Scope:        ldap.ScopeBaseObject,
+		DerefAliases: ldap.NeverDerefAliases,
+		SizeLimit:    2,
+		TimeLimit:    90,
+		Filter:       "(objectClass=*)",
+		Attributes:   []string{testUserSearchUsernameAttribute, testUserSearchUIDAttribute},
+	}
+	userSearchResult := &ldap.SearchResult{
+		Entries: []*ldap.Entry{
+			{
+				DN: testUserSearchResultDNValue,
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute(testUserSearchUsernameAttribute, []string{testUserSearchResultUsernameAttributeValue}),
+					ldap.NewEntryAttribute(testUserSearchUIDAttribute, []string{testUserSearchResultUIDAttributeValue}),
+				},
+			},
+		},
+	}
+	expectedGroupSearch := &ldap.SearchRequest{
+		BaseDN:       testGroupSearchBase,
+		Scope:        ldap.ScopeWholeSubtree,
+		DerefAliases: ldap.NeverDerefAliases,
+		TimeLimit:    90,
+		Filter:       testGroupSearchFilterInterpolated,
+		Attributes:   []string{testGroupSearchGroupNameAttribute},
+	}
+	groupSearchResult := &ldap.SearchResult{
+		Entries: []*ldap.Entry{
+			{
+				DN: testGroupSearchResultDNValue1,
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute(testGroupSearchGroupNameAttribute, []string{testGroupSearchResultGroupNameAttributeValue1}),
+				},
+			},
+		},
+	}
+
+	providerConfig := func(cache GroupCache, editFunc func(p *ProviderConfig)) ProviderConfig {
+		config := ProviderConfig{
+			Name:               "some-provider-name",
+			ResourceUID:        "some-resource-uid",
+			Host:               testHost,
+			ConnectionProtocol: TLS,
+			BindUsername:       testBindUsername,
+			BindPassword:       testBindPassword,
+			UserSearch: UserSearchConfig{
+				Base:              testUserSearchBase,
+				UIDAttribute:      testUserSearchUIDAttribute,
+				UsernameAttribute: testUserSearchUsernameAttribute,
+			},
+			GroupSearch: GroupSearchConfig{
+				Base:               testGroupSearchBase,
+				Filter:             testGroupSearchFilter,
+				GroupNameAttribute: testGroupSearchGroupNameAttribute,
+				CacheTTL:           cacheTTL,
+			},
+			GroupCache: cache,
+		}
+		if editFunc != nil {
+			editFunc(&config)
+		}
+		return config
+	}
+
+	// refresh performs a refresh which expects to search for the user, and to search for groups when wantGroupSearch is true.
+	refresh := func(t *testing.T, config ProviderConfig, wantGroupSearch bool) ([]string, error) {
+		t.Helper()
+
+		ctrl := gomock.NewController(t)
+		t.Cleanup(ctrl.Finish)
+
+		conn := mockldapconn.NewMockConn(ctrl)
+		conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+		conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(1)
+		if wantGroupSearch {
+			conn.EXPECT().SearchWithPaging(expectedGroupSearch, expectedGroupSearchPageSize).Return(groupSearchResult, nil).Times(1)
+		}
+		conn.EXPECT().Close().Times(1)
+
+		config.Dialer = LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+			return conn, nil
 		})
+		subject := fmt.Sprintf(
+			"ldaps://ldap.example.com:8443?base=some-upstream-user-base-dn&idpName=%s&sub=c29tZS11cHN0cmVhbS11aWQtdmFsdWU",
+			testUpstreamName,
+		)
+		groups, _, err := New(config).PerformRefresh(context.Background(), upstreamprovider.LDAPRefreshAttributes{
+			Username: testUserSearchResultUsernameAttributeValue,
+			Subject:  subject,
+			DN:       testUserSearchResultDNValue,
+		}, testUpstreamName)
+		return groups, err
+	}
+
+	newCache := func() *fakeGroupCache {
+		return &fakeGroupCache{groups: map[string][]string{}, ttls: map[string]time.Duration{}}
 	}
+
+	t.Run("searches for groups and caches them, and then uses the cached groups for the next refresh", func(t *testing.T) {
+		cache := newCache()
+
+		groups, err := refresh(t, providerConfig(cache, nil), true)
+		require.NoError(t, err)
+		require.Equal(t, []string{testGroupSearchResultGroupNameAttributeValue1}, groups)
+		require.Len(t, cache.groups, 1)
+		for key := range cache.groups {
+			require.Equal(t, cacheTTL, cache.ttls[key])
+		}
+
+		groups, err = refresh(t, providerConfig(cache, nil), false)
+		require.NoError(t, err)
+		require.Equal(t, []string{testGroupSearchResultGroupNameAttributeValue1}, groups)
+	})
+
+	t.Run("uses a cached empty list of groups", func(t *testing.T) {
+		cache := newCache()
+		provider := New(providerConfig(cache, nil))
+		cache.groups[provider.groupCacheKey(testUserSearchResultDNValue, "")] = []string{}
+
+		groups, err := refresh(t, providerConfig(cache, nil), false)
+		require.NoError(t, err)
+		require.Equal(t, []string{}, groups)
+	})
+
+	t.Run("does not use the cached groups after the identity provider or its group search settings change", func(t *testing.T) {
+		cache := newCache()
+
+		_, err := refresh(t, providerConfig(cache, nil), true)
+		require.NoError(t, err)
+
+		_, err = refresh(t, providerConfig(cache, func(p *ProviderConfig) { p.ResourceUID = "some-other-resource-uid" }), true)
+		require.NoError(t, err)
+		require.Len(t, cache.groups, 2)
+
+		// Each of the settings which affect the result of the group search are part of the key.
+		provider := New(providerConfig(cache, nil))
+		changedProvider := New(providerConfig(cache, func(p *ProviderConfig) { p.GroupSearch.NestedGroupsMaxDepth = 1 }))
+		require.NotEqual(t,
+			provider.groupCacheKey(testUserSearchResultDNValue, ""),
+			changedProvider.groupCacheKey(testUserSearchResultDNValue, ""))
+	})
+
+	t.Run("searches for groups when the cache cannot be read or written", func(t *testing.T) {
+		cache := newCache()
+		cache.getErr = errors.New("some get error")
+		cache.putErr = errors.New("some put error")
+
+		groups, err := refresh(t, providerConfig(cache, nil), true)
+		require.NoError(t, err)
+		require.Equal(t, []string{testGroupSearchResultGroupNameAttributeValue1}, groups)
+	})
+
+	t.Run("does not use the cache when the cache TTL is not set", func(t *testing.T) {
+		cache := newCache()
+
+		for range 2 {
+			groups, err := refresh(t, providerConfig(cache, func(p *ProviderConfig) { p.GroupSearch.CacheTTL = 0 }), true)
+			require.NoError(t, err)
+			require.Equal(t, []string{testGroupSearchResultGroupNameAttributeValue1}, groups)
+		}
+		require.Empty(t, cache.getKeys)
+		require.Empty(t, cache.groups)
+	})
+
+	t.Run("does not use the cache when skipping group refresh", func(t *testing.T) {
+		cache := newCache()
+
+		groups, err := refresh(t, providerConfig(cache, func(p *ProviderConfig) { p.GroupSearch.SkipGroupRefresh = true }), false)
+		require.NoError(t, err)
+		require.Nil(t, groups)
+		require.Empty(t, cache.getKeys)
+	})
 }
 
 func TestTestConnection(t *testing.T) {
@@ -2315,6 +2697,383 @@ func TestTestConnection(t *testing.T) {
 	}
 }
 
+func TestLookupUserGroups(t *testing.T) {
+	providerConfig := &ProviderConfig{
+		Name:               "some-provider-name",
+		Host:               testHost,
+		CABundle:           nil, // this field is only used by the production dialer, which is replaced by a mock for this test
+		ConnectionProtocol: TLS,
+		BindUsername:       testBindUsername,
+		BindPassword:       testBindPassword,
+		UserSearch: UserSearchConfig{
+			Base:              testUserSearchBase,
+			Filter:            testUserSearchFilter,
+			UsernameAttribute: testUserSearchUsernameAttribute,
+			UIDAttribute:      testUserSearchUIDAttribute,
+		},
+		GroupSearch: GroupSearchConfig{
+			Base:               testGroupSearchBase,
+			Filter:             testGroupSearchFilter,
+			GroupNameAttribute: testGroupSearchGroupNameAttribute,
+		},
+	}
+
+	expectedUserSearch := &ldap.SearchRequest{
+		BaseDN:       testUserSearchBase,
+		Scope:        ldap.ScopeWholeSubtree,
+		DerefAliases: ldap.NeverDerefAliases,
+		SizeLimit:    2,
+		TimeLimit:    90,
+		TypesOnly:    false,
+		Filter:       testUserSearchFilterInterpolated,
+		Attributes:   []string{testUserSearchUsernameAttribute, testUserSearchUIDAttribute},
+		Controls:     nil,
+	}
+
+	expectedGroupSearch := &ldap.SearchRequest{
+		BaseDN:       testGroupSearchBase,
+		Scope:        ldap.ScopeWholeSubtree,
+		DerefAliases: ldap.NeverDerefAliases,
+		SizeLimit:    0,
+		TimeLimit:    90,
+		TypesOnly:    false,
+		Filter:       testGroupSearchFilterInterpolated,
+		Attributes:   []string{testGroupSearchGroupNameAttribute},
+		Controls:     nil,
+	}
+
+	userSearchResult := &ldap.SearchResult{
+		Entries: []*ldap.Entry{
+			{
+				DN: testUserSearchResultDNValue,
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute(testUserSearchUsernameAttribute, []string{testUserSearchResultUsernameAttributeValue}),
+					ldap.NewEntryAttribute(testUserSearchUIDAttribute, []string{testUserSearchResultUIDAttributeValue}),
+				},
+			},
+		},
+	}
+
+	groupSearchResult := &ldap.SearchResult{
+		Entries: []*ldap.Entry{
+			{
+				DN: testGroupSearchResultDNValue1,
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute(testGroupSearchGroupNameAttribute, []string{testGroupSearchResultGroupNameAttributeValue1}),
+				},
+			},
+			{
+				DN: testGroupSearchResultDNValue2,
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute(testGroupSearchGroupNameAttribute, []string{testGroupSearchResultGroupNameAttributeValue2}),
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		key            string
+		setupMocks     func(conn *mockldapconn.MockConn)
+		wantError      testutil.RequireErrorStringFunc
+		wantToSkipDial bool
+		wantGroups     []string
+	}{
+		{
+			name: "happy path",
+			key:  testUpstreamUsername,
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch, expectedGroupSearchPageSize).Return(groupSearchResult, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantGroups: []string{testGroupSearchResultGroupNameAttributeValue1, testGroupSearchResultGroupNameAttributeValue2},
+		},
+		{
+			name: "when the user is found but has no groups",
+			key:  testUpstreamUsername,
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch).Return(userSearchResult, nil).Times(1)
+				conn.EXPECT().SearchWithPaging(expectedGroupSearch, expectedGroupSearchPageSize).Return(&ldap.SearchResult{}, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantGroups: []string{},
+		},
+		{
+			name: "when the user is not found",
+			key:  testUpstreamUsername,
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch).Return(&ldap.SearchResult{}, nil).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantGroups: []string{},
+		},
+		{
+			name:           "when the key is empty",
+			key:            "",
+			wantToSkipDial: true,
+			wantGroups:     []string{},
+		},
+		{
+			name: "when the user search fails",
+			key:  testUpstreamUsername,
+			setupMocks: func(conn *mockldapconn.MockConn) {
+				conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+				conn.EXPECT().Search(expectedUserSearch).Return(nil, errors.New("some search error")).Times(1)
+				conn.EXPECT().Close().Times(1)
+			},
+			wantError: testutil.WantExactErrorString("error searching for user: some search error"),
+		},
+	}
+
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			t.Cleanup(ctrl.Finish)
+
+			conn := mockldapconn.NewMockConn(ctrl)
+			if tt.setupMocks != nil {
+				tt.setupMocks(conn)
+			}
+
+			config := *providerConfig
+			dialWasAttempted := false
+			config.Dialer = LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+				dialWasAttempted = true
+				require.Equal(t, config.Host, addr.Endpoint())
+				return conn, nil
+			})
+
+			groups, err := New(config).LookupUserGroups(context.Background(), tt.key)
+
+			require.Equal(t, !tt.wantToSkipDial, dialWasAttempted)
+			if tt.wantError != nil {
+				testutil.RequireErrorStringFromErr(t, err, tt.wantError)
+				require.Nil(t, groups)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantGroups, groups)
+		})
+	}
+}
+
+func TestNestedGroups(t *testing.T) {
+	const (
+		testUserAttributeForFilter      = "some-user-attribute-for-filter"
+		testUserAttributeForFilterValue = "some-user-attribute-for-filter-value"
+	)
+
+	type group struct{ dn, name string }
+
+	// memberOf describes the directory by listing the groups which directly contain each user or group,
+	// keyed by the value which is interpolated into the group search filter.
+	type memberOf map[string][]group
+
+	var (
+		admins     = group{dn: "cn=admins,ou=groups", name: "admins"}
+		developers = group{dn: "cn=developers,ou=groups", name: "developers"}
+		engineers  = group{dn: "cn=engineers,ou=groups", name: "engineers"}
+		employees  = group{dn: "cn=employees,ou=groups", name: "employees"}
+		everyone   = group{dn: "cn=everyone,ou=groups", name: "everyone"}
+	)
+
+	// A user who is in developers and admins, where developers is in engineers, which is in employees, which is in everyone.
+	hierarchy := memberOf{
+		testUserSearchResultDNValue: {developers, admins},
+		developers.dn:               {engineers},
+		engineers.dn:                {employees},
+		employees.dn:                {everyone},
+	}
+
+	tests := []struct {
+		name                   string
+		maxDepth               int
+		userAttributeForFilter string
+		directory              memberOf
+		searchErrors           map[string]error // by the value which is interpolated into the group search filter
+		wantSearches           []string         // the values which were interpolated into the group search filter
+		wantGroups             []string
+		wantError              testutil.RequireErrorStringFunc
+	}{
+		{
+			name:         "when nested groups are not enabled, only the groups which directly contain the user are found",
+			maxDepth:     0,
+			directory:    hierarchy,
+			wantSearches: []string{testUserSearchResultDNValue},
+			wantGroups:   []string{"admins", "developers"},
+		},
+		{
+			name:         "resolves one level of nested groups",
+			maxDepth:     1,
+			directory:    hierarchy,
+			wantSearches: []string{testUserSearchResultDNValue, developers.dn, admins.dn},
+			wantGroups:   []string{"admins", "developers", "engineers"},
+		},
+		{
+			name:         "resolves nested groups up to the max depth",
+			maxDepth:     2,
+			directory:    hierarchy,
+			wantSearches: []string{testUserSearchResultDNValue, developers.dn, admins.dn, engineers.dn},
+			wantGroups:   []string{"admins", "developers", "employees", "engineers"},
+		},
+		{
+			name:         "stops searching when there are no more nested groups",
+			maxDepth:     20,
+			directory:    hierarchy,
+			wantSearches: []string{testUserSearchResultDNValue, developers.dn, admins.dn, engineers.dn, employees.dn, everyone.dn},
+			wantGroups:   []string{"admins", "developers", "employees", "engineers", "everyone"},
+		},
+		{
+			name:     "searches each group only once when groups contain each other",
+			maxDepth: 20,
+			directory: memberOf{
+				testUserSearchResultDNValue: {developers},
+				developers.dn:               {engineers, admins},
+				engineers.dn:                {developers},
+				admins.dn:                   {engineers},
+			},
+			wantSearches: []string{testUserSearchResultDNValue, developers.dn, engineers.dn, admins.dn},
+			wantGroups:   []string{"admins", "developers", "engineers"},
+		},
+		{
+			name:     "compares group DNs case-insensitively to avoid searching the same group twice",
+			maxDepth: 20,
+			directory: memberOf{
+				testUserSearchResultDNValue: {developers},
+				developers.dn:               {{dn: "CN=Developers,OU=Groups", name: "developers"}},
+			},
+			wantSearches: []string{testUserSearchResultDNValue, developers.dn},
+			wantGroups:   []string{"developers"},
+		},
+		{
+			name:                   "uses the group DNs in the filter for nested groups when the user attribute for the filter is configured",
+			maxDepth:               1,
+			userAttributeForFilter: testUserAttributeForFilter,
+			directory: memberOf{
+				testUserAttributeForFilterValue: {developers},
+				developers.dn:                   {engineers},
+			},
+			wantSearches: []string{testUserAttributeForFilterValue, developers.dn},
+			wantGroups:   []string{"developers", "engineers"},
+		},
+		{
+			name:         "when searching for nested groups fails",
+			maxDepth:     20,
+			directory:    hierarchy,
+			searchErrors: map[string]error{engineers.dn: errors.New("some search error")},
+			wantSearches: []string{testUserSearchResultDNValue, developers.dn, admins.dn, engineers.dn},
+			wantError: testutil.WantExactErrorString(
+				`error searching for nested group memberships of group with DN "cn=engineers,ou=groups": some search error`),
+		},
+	}
+
+	for _, test := range tests {
+		tt := test
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			t.Cleanup(ctrl.Finish)
+
+			userEntry := &ldap.Entry{
+				DN: testUserSearchResultDNValue,
+				Attributes: []*ldap.EntryAttribute{
+					ldap.NewEntryAttribute(testUserSearchUsernameAttribute, []string{testUserSearchResultUsernameAttributeValue}),
+					ldap.NewEntryAttribute(testUserSearchUIDAttribute, []string{testUserSearchResultUIDAttributeValue}),
+				},
+			}
+			userSearchAttributes := []string{testUserSearchUsernameAttribute, testUserSearchUIDAttribute}
+			if tt.userAttributeForFilter != "" {
+				userEntry.Attributes = append(userEntry.Attributes,
+					ldap.NewEntryAttribute(tt.userAttributeForFilter, []string{testUserAttributeForFilterValue}))
+				userSearchAttributes = append(userSearchAttributes, tt.userAttributeForFilter)
+			}
+
+			// Every user and group in the directory can be found by interpolating its key or its DN into the filter.
+			valuesByFilter := map[string]string{}
+			for value, groups := range tt.directory {
+				valuesByFilter[fmt.Sprintf(testGroupSearchFilterInterpolationSpec, value, value)] = value
+				for _, g := range groups {
+					valuesByFilter[fmt.Sprintf(testGroupSearchFilterInterpolationSpec, g.dn, g.dn)] = g.dn
+				}
+			}
+
+			var searches []string
+			conn := mockldapconn.NewMockConn(ctrl)
+			conn.EXPECT().Bind(testBindUsername, testBindPassword).Times(1)
+			conn.EXPECT().Search(&ldap.SearchRequest{
+				BaseDN:       testUserSearchBase,
+				Scope:        ldap.ScopeWholeSubtree,
+				DerefAliases: ldap.NeverDerefAliases,
+				SizeLimit:    2,
+				TimeLimit:    90,
+				Filter:       testUserSearchFilterInterpolated,
+				Attributes:   userSearchAttributes,
+			}).Return(&ldap.SearchResult{Entries: []*ldap.Entry{userEntry}}, nil).Times(1)
+			conn.EXPECT().SearchWithPaging(gomock.Any(), expectedGroupSearchPageSize).DoAndReturn(
+				func(request *ldap.SearchRequest, _ uint32) (*ldap.SearchResult, error) {
+					require.Equal(t, testGroupSearchBase, request.BaseDN)
+					require.Equal(t, []string{testGroupSearchGroupNameAttribute}, request.Attributes)
+					value, found := valuesByFilter[request.Filter]
+					require.True(t, found, "unexpected group search filter %q", request.Filter)
+					searches = append(searches, value)
+					if err := tt.searchErrors[value]; err != nil {
+						return nil, err
+					}
+					result := &ldap.SearchResult{}
+					for _, g := range tt.directory[value] {
+						result.Entries = append(result.Entries, &ldap.Entry{
+							DN: g.dn,
+							Attributes: []*ldap.EntryAttribute{
+								ldap.NewEntryAttribute(testGroupSearchGroupNameAttribute, []string{g.name}),
+							},
+						})
+					}
+					return result, nil
+				}).AnyTimes()
+			conn.EXPECT().Close().Times(1)
+
+			provider := New(ProviderConfig{
+				Name:               "some-provider-name",
+				Host:               testHost,
+				ConnectionProtocol: TLS,
+				BindUsername:       testBindUsername,
+				BindPassword:       testBindPassword,
+				UserSearch: UserSearchConfig{
+					Base:              testUserSearchBase,
+					Filter:            testUserSearchFilter,
+					UsernameAttribute: testUserSearchUsernameAttribute,
+					UIDAttribute:      testUserSearchUIDAttribute,
+				},
+				GroupSearch: GroupSearchConfig{
+					Base:                   testGroupSearchBase,
+					Filter:                 testGroupSearchFilter,
+					UserAttributeForFilter: tt.userAttributeForFilter,
+					GroupNameAttribute:     testGroupSearchGroupNameAttribute,
+					NestedGroupsMaxDepth:   tt.maxDepth,
+				},
+				Dialer: LDAPDialerFunc(func(ctx context.Context, addr endpointaddr.HostPort) (Conn, error) {
+					return conn, nil
+				}),
+			})
+
+			groups, err := provider.LookupUserGroups(context.Background(), testUpstreamUsername)
+
+			require.Equal(t, tt.wantSearches, searches)
+			if tt.wantError != nil {
+				testutil.RequireErrorStringFromErr(t, err, tt.wantError)
+				require.Nil(t, groups)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantGroups, groups)
+		})
+	}
+}
+
 func TestGetConfig(t *testing.T) {
 	c := ProviderConfig{
 		Name:         "original-provider-name",
@@ -2490,7 +3249,7 @@ func TestRealTLSDialing(t *testing.T) {
 				ConnectionProtocol: tt.connProto,
 				Dialer:             nil, // this test is for the default (production) TLS dialer
 			})
-			conn, err := provider.dial(tt.context)
+			conn, err := provider.dial(tt.context, tt.host)
 			if conn != nil {
 				defer conn.Close()
 			}
@@ -0,0 +1,269 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamoauth2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/ext"
+	"k8s.io/client-go/util/jsonpath"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+)
+
+const (
+	// responseVariableName is the name of the variable which holds the JSON response body in CEL expressions.
+	responseVariableName = "response"
+
+	// maxExpressionRuntime limits the runtime of a CEL expression to avoid accidental very expensive expressions.
+	maxExpressionRuntime = 5 * time.Second
+)
+
+// ClaimMapping is a compiled OAuth2ClaimMapping, which can be evaluated against a decoded JSON response body.
+// It is stateless and safe for concurrent use.
+type ClaimMapping struct {
+	jsonPath   *jsonpath.JSONPath
+	celProgram cel.Program
+	source     idpv1alpha1.OAuth2ClaimMapping
+}
+
+// CompileClaimMapping parses the JSONPath template or compiles the CEL expression of the claim mapping,
+// whichever is specified. Exactly one of the two must be specified.
+func CompileClaimMapping(mapping idpv1alpha1.OAuth2ClaimMapping) (*ClaimMapping, error) {
+	hasJSONPath := len(strings.TrimSpace(mapping.JSONPath)) > 0
+	hasExpression := len(strings.TrimSpace(mapping.Expression)) > 0
+
+	switch {
+	case hasJSONPath && hasExpression:
+		return nil, errors.New("only one of jsonPath or expression may be specified")
+	case hasJSONPath:
+		jp := jsonpath.New("claim")
+		if err := jp.Parse(mapping.JSONPath); err != nil {
+			return nil, fmt.Errorf("could not parse jsonPath %q: %w", mapping.JSONPath, err)
+		}
+		return &ClaimMapping{jsonPath: jp, source: mapping}, nil
+	case hasExpression:
+		program, err := compileCELProgram(mapping.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("could not compile expression %q: %w", mapping.Expression, err)
+		}
+		return &ClaimMapping{celProgram: program, source: mapping}, nil
+	default:
+		return nil, errors.New("one of jsonPath or expression must be specified")
+	}
+}
+
+// Source returns the claim mapping from which this ClaimMapping was compiled.
+func (c *ClaimMapping) Source() idpv1alpha1.OAuth2ClaimMapping {
+	return c.source
+}
+
+func (c *ClaimMapping) String() string {
+	if c.jsonPath != nil {
+		return fmt.Sprintf("jsonPath %q", c.source.JSONPath)
+	}
+	return fmt.Sprintf("expression %q", c.source.Expression)
+}
+
+// EvaluateSingleString evaluates the claim mapping against the decoded JSON response body.
+// The result must be a single non-empty string or number. Numbers are returned in their decimal string form.
+func (c *ClaimMapping) EvaluateSingleString(ctx context.Context, response any) (string, error) {
+	values, err := c.evaluate(ctx, response)
+	if err != nil {
+		return "", err
+	}
+	if len(values) != 1 {
+		return "", fmt.Errorf("%s should result in exactly one value but resulted in %d values", c, len(values))
+	}
+	value, err := scalarToString(values[0])
+	if err != nil {
+		return "", fmt.Errorf("%s %w", c, err)
+	}
+	if len(value) == 0 {
+		return "", fmt.Errorf("%s resulted in an empty value", c)
+	}
+	return value, nil
+}
+
+// EvaluateStringList evaluates the claim mapping against the decoded JSON response body.
+// The result must be a list of strings, or a single string. When the result is a single list, it is flattened.
+func (c *ClaimMapping) EvaluateStringList(ctx context.Context, response any) ([]string, error) {
+	values, err := c.evaluate(ctx, response)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 1 {
+		if list, ok := values[0].([]any); ok {
+			values = list
+		}
+	}
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s should result in a list of strings but resulted in a list containing a value of type %T", c, value)
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// evaluate returns all values selected by the JSONPath template, or the result of the CEL expression.
+// When the CEL expression results in a list, the list is returned as a single []any value.
+func (c *ClaimMapping) evaluate(ctx context.Context, response any) ([]any, error) {
+	if c.jsonPath != nil {
+		results, err := c.jsonPath.FindResults(response)
+		if err != nil {
+			return nil, fmt.Errorf("error evaluating %s: %w", c, err)
+		}
+		var values []any
+		for _, resultSet := range results {
+			for _, result := range resultSet {
+				values = append(values, result.Interface())
+			}
+		}
+		return values, nil
+	}
+
+	// Limit the runtime of a CEL expression to avoid accidental very expensive expressions.
+	timeoutCtx, cancel := context.WithTimeout(ctx, maxExpressionRuntime)
+	defer cancel()
+
+	val, _, err := c.celProgram.ContextEval(timeoutCtx, map[string]any{responseVariableName: response})
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating %s: %w", c, err)
+	}
+	native, err := celValueToNative(val)
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating %s: %w", c, err)
+	}
+	return []any{native}, nil
+}
+
+// DecodeJSONResponse decodes a JSON response body into generic values for use with ClaimMapping.
+// JSON numbers become int64 when they are integers, and float64 otherwise.
+func DecodeJSONResponse(body []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after top-level JSON value")
+	}
+	return convertNumbers(decoded), nil
+}
+
+func convertNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]any:
+		for key, item := range v {
+			v[key] = convertNumbers(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = convertNumbers(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func scalarToString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("should result in a string or number but resulted in a value of type %T", value)
+	}
+}
+
+func celValueToNative(val any) (any, error) {
+	switch v := val.(type) {
+	case traits.Lister:
+		size, ok := v.Size().(types.Int)
+		if !ok {
+			return nil, errors.New("could not determine size of list result")
+		}
+		list := make([]any, 0, int(size))
+		for i := types.Int(0); i < size; i++ {
+			item, err := celValueToNative(v.Get(i))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case types.Int:
+		return int64(v), nil
+	case types.Uint:
+		return uint64(v), nil
+	case types.Double:
+		return float64(v), nil
+	case types.String:
+		return string(v), nil
+	case *types.Err:
+		return nil, v
+	default:
+		return nil, fmt.Errorf("unsupported expression result type %T", val)
+	}
+}
+
+func compileCELProgram(expr string) (cel.Program, error) {
+	env, err := cel.NewEnv(
+		// The response body can be any JSON value, so its type is not known during type checking.
+		cel.Variable(responseVariableName, cel.DynType),
+
+		// Enable the strings extensions.
+		// See https://github.com/google/cel-go/tree/master/ext#strings
+		ext.Strings(),
+
+		// Check for collisions in declarations now instead of later.
+		cel.EagerlyValidateDeclarations(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("CEL expression compile error: %s", issues.String())
+	}
+
+	program, err := env.Program(ast,
+		cel.InterruptCheckFrequency(100), // Kubernetes uses 100 here, so we'll copy that setting.
+		cel.EvalOptions(cel.OptOptimize), // Optimize certain things now rather than at evaluation time.
+	)
+	if err != nil {
+		return nil, fmt.Errorf("CEL expression program construction error: %w", err)
+	}
+	return program, nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamoauth2
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+)
+
+func TestCompileClaimMapping(t *testing.T) {
+	tests := []struct {
+		name       string
+		mapping    idpv1alpha1.OAuth2ClaimMapping
+		wantErrMsg string
+	}{
+		{
+			name:    "valid jsonPath",
+			mapping: idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.teams[*].slug}"},
+		},
+		{
+			name:    "valid expression",
+			mapping: idpv1alpha1.OAuth2ClaimMapping{Expression: `response.teams.map(t, t.org + "/" + t.slug)`},
+		},
+		{
+			name:       "neither specified",
+			mapping:    idpv1alpha1.OAuth2ClaimMapping{},
+			wantErrMsg: "one of jsonPath or expression must be specified",
+		},
+		{
+			name:       "both specified",
+			mapping:    idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.login}", Expression: "response.login"},
+			wantErrMsg: "only one of jsonPath or expression may be specified",
+		},
+		{
+			name:       "invalid jsonPath",
+			mapping:    idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.login"},
+			wantErrMsg: `could not parse jsonPath "{.login": unclosed action`,
+		},
+		{
+			name:    "invalid expression",
+			mapping: idpv1alpha1.OAuth2ClaimMapping{Expression: "response.login +"},
+			wantErrMsg: `could not compile expression "response.login +": CEL expression compile error: ERROR: <input>:1:17: Syntax error: mismatched input '<EOF>' expecting {'[', '{', '(', '.', '-', '!', 'true', 'false', 'null', NUM_FLOAT, NUM_INT, NUM_UINT, STRING, BYTES, IDENTIFIER}
+ | response.login +
+ | ................^`,
+		},
+		{
+			name:       "expression using an undeclared variable",
+			mapping:    idpv1alpha1.OAuth2ClaimMapping{Expression: "user.login"},
+			wantErrMsg: "could not compile expression \"user.login\": CEL expression compile error: ERROR: <input>:1:1: undeclared reference to 'user' (in container '')\n | user.login\n | ^",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			compiled, err := CompileClaimMapping(test.mapping)
+			if test.wantErrMsg != "" {
+				require.EqualError(t, err, test.wantErrMsg)
+				require.Nil(t, compiled)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.mapping, compiled.Source())
+		})
+	}
+}
+
+func TestEvaluateClaimMapping(t *testing.T) {
+	response, err := DecodeJSONResponse([]byte(`{
+		"login": "some-login",
+		"id": 42,
+		"score": 1.5,
+		"empty": "",
+		"teams": [{"org": "org1", "slug": "team1"}, {"org": "org2", "slug": "team2"}],
+		"groups": ["group1", "group2"],
+		"primaryGroup": "group1",
+		"mixed": ["group1", 2]
+	}`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name             string
+		mapping          idpv1alpha1.OAuth2ClaimMapping
+		wantString       string
+		wantStringErrMsg string
+		wantList         []string
+		wantListErrMsg   string
+	}{
+		{
+			name:           "jsonPath selecting a string",
+			mapping:        idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.login}"},
+			wantString:     "some-login",
+			wantList:       []string{"some-login"},
+			wantListErrMsg: "",
+		},
+		{
+			name:           "jsonPath selecting an integer",
+			mapping:        idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.id}"},
+			wantString:     "42",
+			wantListErrMsg: `jsonPath "{.id}" should result in a list of strings but resulted in a list containing a value of type int64`,
+		},
+		{
+			name:           "jsonPath selecting a float",
+			mapping:        idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.score}"},
+			wantString:     "1.5",
+			wantListErrMsg: `jsonPath "{.score}" should result in a list of strings but resulted in a list containing a value of type float64`,
+		},
+		{
+			name:             "jsonPath selecting an empty string",
+			mapping:          idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.empty}"},
+			wantStringErrMsg: `jsonPath "{.empty}" resulted in an empty value`,
+			wantList:         []string{""},
+		},
+		{
+			name:             "jsonPath selecting a list of strings",
+			mapping:          idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.groups}"},
+			wantStringErrMsg: `jsonPath "{.groups}" should result in a string or number but resulted in a value of type []interface {}`,
+			wantList:         []string{"group1", "group2"},
+		},
+		{
+			name:             "jsonPath selecting many values",
+			mapping:          idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.teams[*].slug}"},
+			wantStringErrMsg: `jsonPath "{.teams[*].slug}" should result in exactly one value but resulted in 2 values`,
+			wantList:         []string{"team1", "team2"},
+		},
+		{
+			name:             "jsonPath selecting a missing key",
+			mapping:          idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.missing}"},
+			wantStringErrMsg: `error evaluating jsonPath "{.missing}": missing is not found`,
+			wantListErrMsg:   `error evaluating jsonPath "{.missing}": missing is not found`,
+		},
+		{
+			name:             "jsonPath selecting a list of mixed types",
+			mapping:          idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.mixed}"},
+			wantStringErrMsg: `jsonPath "{.mixed}" should result in a string or number but resulted in a value of type []interface {}`,
+			wantListErrMsg:   `jsonPath "{.mixed}" should result in a list of strings but resulted in a list containing a value of type int64`,
+		},
+		{
+			name:       "expression resulting in a string",
+			mapping:    idpv1alpha1.OAuth2ClaimMapping{Expression: `response.login.upperAscii()`},
+			wantString: "SOME-LOGIN",
+			wantList:   []string{"SOME-LOGIN"},
+		},
+		{
+			name:           "expression resulting in an integer",
+			mapping:        idpv1alpha1.OAuth2ClaimMapping{Expression: `response.id + 1`},
+			wantString:     "43",
+			wantListErrMsg: `expression "response.id + 1" should result in a list of strings but resulted in a list containing a value of type int64`,
+		},
+		{
+			name:             "expression resulting in a list of strings",
+			mapping:          idpv1alpha1.OAuth2ClaimMapping{Expression: `response.teams.map(t, t.org + "/" + t.slug)`},
+			wantStringErrMsg: `expression "response.teams.map(t, t.org + \"/\" + t.slug)" should result in a string or number but resulted in a value of type []interface {}`,
+			wantList:         []string{"org1/team1", "org2/team2"},
+		},
+		{
+			name:             "expression resulting in an empty list",
+			mapping:          idpv1alpha1.OAuth2ClaimMapping{Expression: `response.groups.filter(g, g == "other")`},
+			wantStringErrMsg: `expression "response.groups.filter(g, g == \"other\")" should result in a string or number but resulted in a value of type []interface {}`,
+			wantList:         []string{},
+		},
+		{
+			name:             "expression resulting in an unsupported type",
+			mapping:          idpv1alpha1.OAuth2ClaimMapping{Expression: `response.login == "some-login"`},
+			wantStringErrMsg: `error evaluating expression "response.login == \"some-login\"": unsupported expression result type types.Bool`,
+			wantListErrMsg:   `error evaluating expression "response.login == \"some-login\"": unsupported expression result type types.Bool`,
+		},
+		{
+			name:             "expression referencing a missing key",
+			mapping:          idpv1alpha1.OAuth2ClaimMapping{Expression: `response.missing`},
+			wantStringErrMsg: `error evaluating expression "response.missing": no such key: missing`,
+			wantListErrMsg:   `error evaluating expression "response.missing": no such key: missing`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			compiled, err := CompileClaimMapping(test.mapping)
+			require.NoError(t, err)
+
+			s, err := compiled.EvaluateSingleString(context.Background(), response)
+			if test.wantStringErrMsg != "" {
+				require.EqualError(t, err, test.wantStringErrMsg)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, test.wantString, s)
+			}
+
+			list, err := compiled.EvaluateStringList(context.Background(), response)
+			if test.wantListErrMsg != "" {
+				require.EqualError(t, err, test.wantListErrMsg)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, test.wantList, list)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONResponse(t *testing.T) {
+	decoded, err := DecodeJSONResponse([]byte(`{"a": 1, "b": [2.5, 9007199254740993], "c": {"d": "e"}}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"a": int64(1),
+		"b": []any{2.5, int64(9007199254740993)},
+		"c": map[string]any{"d": "e"},
+	}, decoded)
+
+	_, err = DecodeJSONResponse([]byte(`{"a": 1} {"b": 2}`))
+	require.EqualError(t, err, "unexpected data after top-level JSON value")
+
+	_, err = DecodeJSONResponse([]byte(``))
+	require.EqualError(t, err, "EOF")
+}
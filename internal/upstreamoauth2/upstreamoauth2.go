@@ -0,0 +1,189 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package upstreamoauth2 implements an abstraction of upstream generic OAuth2 provider interactions.
+package upstreamoauth2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	coreosoidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"k8s.io/apimachinery/pkg/types"
+
+	"go.pinniped.dev/internal/federationdomain/downstreamsubject"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+// maxResponseBodyBytes limits how much of a user info API response will be read.
+const maxResponseBodyBytes = 1024 * 1024
+
+// ProviderConfig holds the active configuration of an upstream generic OAuth2 provider.
+type ProviderConfig struct {
+	Name        string
+	ResourceUID types.UID
+
+	// UserInfoURL is the URL of the JSON API which returns information about the authenticated user.
+	UserInfoURL string
+
+	// GroupsURL is the optional URL of the JSON API which returns the group memberships of the authenticated user.
+	// When empty, the GroupsClaim is evaluated against the response of the UserInfoURL.
+	GroupsURL string
+
+	// UsernameClaim and UIDClaim are required. GroupsClaim is optional, and when nil the user has no groups.
+	UsernameClaim *ClaimMapping
+	UIDClaim      *ClaimMapping
+	GroupsClaim   *ClaimMapping
+
+	// HttpClient is a client that can be used to call the user info APIs and token endpoint.
+	// This client should be configured with the user-provided CA bundle and a timeout.
+	HttpClient *http.Client
+
+	// OAuth2Config contains ClientID, ClientSecret, Scopes, and Endpoint (which contains auth and token endpoint URLs,
+	// and auth style for the token endpoint).
+	// OAuth2Config will not be used to compute the authorize URL because the redirect back to the Supervisor's
+	// callback must be different per FederationDomain. It holds data that may be useful when calculating the
+	// authorize URL, so that data is exposed by interface methods. However, it can be used to call the token endpoint,
+	// for which there is no RedirectURL needed.
+	OAuth2Config *oauth2.Config
+}
+
+type Provider struct {
+	c ProviderConfig
+}
+
+var _ upstreamprovider.UpstreamOAuth2IdentityProviderI = &Provider{}
+
+// New creates a Provider. The config is not a pointer to ensure that a copy of the config is created,
+// making the resulting Provider use an effectively read-only configuration.
+func New(config ProviderConfig) *Provider {
+	return &Provider{c: config}
+}
+
+func (p *Provider) GetResourceName() string {
+	return p.c.Name
+}
+
+func (p *Provider) GetResourceUID() types.UID {
+	return p.c.ResourceUID
+}
+
+func (p *Provider) GetClientID() string {
+	return p.c.OAuth2Config.ClientID
+}
+
+func (p *Provider) GetScopes() []string {
+	return p.c.OAuth2Config.Scopes
+}
+
+func (p *Provider) GetAuthorizationURL() string {
+	return p.c.OAuth2Config.Endpoint.AuthURL
+}
+
+func (p *Provider) ExchangeAuthcode(ctx context.Context, authcode string, pkceCodeVerifier pkce.Code, redirectURI string) (*oauth2.Token, error) {
+	tok, err := p.c.OAuth2Config.Exchange(
+		coreosoidc.ClientContext(ctx, p.c.HttpClient),
+		authcode,
+		pkceCodeVerifier.Verifier(),
+		oauth2.SetAuthURLParam("redirect_uri", redirectURI),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error exchanging authorization code using OAuth2 token endpoint: %w", err)
+	}
+	return tok, nil
+}
+
+func (p *Provider) PerformRefresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	// Use the provided HTTPClient to talk to the token endpoint.
+	httpClientContext := coreosoidc.ClientContext(ctx, p.c.HttpClient)
+
+	// Create a TokenSource without an access token, so it thinks that a refresh is immediately required.
+	// Then ask it for the tokens to cause it to perform the refresh and return the results.
+	tok, err := p.c.OAuth2Config.TokenSource(httpClientContext, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return nil, fmt.Errorf("error refreshing tokens using OAuth2 token endpoint: %w", err)
+	}
+	return tok, nil
+}
+
+// GetUser will use the provided configuration to make HTTPS calls to the configured user info APIs to get
+// the identity of the authenticated user and their group memberships, using the configured claim mappings.
+func (p *Provider) GetUser(ctx context.Context, accessToken string, idpDisplayName string) (*upstreamprovider.OAuth2User, error) {
+	userResponse, err := p.getJSON(ctx, p.c.UserInfoURL, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := p.c.UsernameClaim.EvaluateSingleString(ctx, userResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error determining username from user info response: %w", err)
+	}
+
+	uid, err := p.c.UIDClaim.EvaluateSingleString(ctx, userResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error determining uid from user info response: %w", err)
+	}
+
+	oauth2User := upstreamprovider.OAuth2User{
+		Username:          username,
+		DownstreamSubject: downstreamsubject.OAuth2(p.c.UserInfoURL, idpDisplayName, uid),
+	}
+
+	if p.c.GroupsClaim == nil {
+		return &oauth2User, nil
+	}
+
+	groupsResponse := userResponse
+	if len(p.c.GroupsURL) > 0 {
+		groupsResponse, err = p.getJSON(ctx, p.c.GroupsURL, accessToken)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	oauth2User.Groups, err = p.c.GroupsClaim.EvaluateStringList(ctx, groupsResponse)
+	if err != nil {
+		return nil, fmt.Errorf("error determining groups from groups response: %w", err)
+	}
+
+	return &oauth2User, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, accessToken string) (any, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build request to %q: %w", url, err)
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+
+	response, err := p.c.HttpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("error making request to %q: %w", url, err)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("error making request to %q: unexpected response status %q", url, response.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxResponseBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %q: %w", url, err)
+	}
+
+	decoded, err := DecodeJSONResponse(body)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding JSON response from %q: %w", url, err)
+	}
+	return decoded, nil
+}
+
+// GetConfig returns the config. This is not part of the UpstreamOAuth2IdentityProviderI interface and is just for testing.
+func (p *Provider) GetConfig() ProviderConfig {
+	return p.c
+}
@@ -0,0 +1,347 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamoauth2
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/cert"
+
+	idpv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idp/v1alpha1"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/testutil/tlsserver"
+	"go.pinniped.dev/pkg/oidcclient/pkce"
+)
+
+func TestOAuth2Provider(t *testing.T) {
+	usernameClaim, err := CompileClaimMapping(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.login}"})
+	require.NoError(t, err)
+
+	config := ProviderConfig{
+		Name:          "foo",
+		ResourceUID:   "resource-uid-12345",
+		UserInfoURL:   "https://fake-user-url",
+		GroupsURL:     "https://fake-groups-url",
+		UsernameClaim: usernameClaim,
+		OAuth2Config: &oauth2.Config{
+			ClientID:     "fake-client-id",
+			ClientSecret: "fake-client-secret",
+			Scopes:       []string{"scope1", "scope2"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   "https://fake-authorization-url",
+				TokenURL:  "https://fake-token-url",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		HttpClient: &http.Client{
+			Timeout: 1234509,
+		},
+	}
+
+	subject := New(config)
+
+	require.Equal(t, config, subject.GetConfig())
+	require.Equal(t, "foo", subject.GetResourceName())
+	require.Equal(t, types.UID("resource-uid-12345"), subject.GetResourceUID())
+	require.Equal(t, "fake-client-id", subject.GetClientID())
+	require.Equal(t, []string{"scope1", "scope2"}, subject.GetScopes())
+	require.Equal(t, "https://fake-authorization-url", subject.GetAuthorizationURL())
+}
+
+func TestExchangeAuthcodeAndPerformRefresh(t *testing.T) {
+	const (
+		fakeAccessToken  = "fake-access-token"  //nolint:gosec // this is not a credential
+		fakeRefreshToken = "fake-refresh-token" //nolint:gosec // this is not a credential
+	)
+
+	pkceCode := pkce.Code("fake-pkce-verifier-with-enough-characters-to-be-valid-1234567")
+
+	tests := []struct {
+		name              string
+		tokenEndpointPath string
+		performRefresh    bool
+		wantToken         *oauth2.Token
+		wantErr           string
+	}{
+		{
+			name:              "exchange authcode happy path",
+			tokenEndpointPath: "/token",
+			wantToken:         &oauth2.Token{AccessToken: fakeAccessToken, RefreshToken: fakeRefreshToken, TokenType: "Bearer"},
+		},
+		{
+			name:              "exchange authcode when the token endpoint returns an error",
+			tokenEndpointPath: "/token-error",
+			wantErr:           "error exchanging authorization code using OAuth2 token endpoint: oauth2: cannot fetch token: 401 Unauthorized\nResponse: some error",
+		},
+		{
+			name:              "perform refresh happy path",
+			tokenEndpointPath: "/token",
+			performRefresh:    true,
+			wantToken:         &oauth2.Token{AccessToken: fakeAccessToken, RefreshToken: fakeRefreshToken, TokenType: "Bearer"},
+		},
+		{
+			name:              "perform refresh when the token endpoint returns an error",
+			tokenEndpointPath: "/token-error",
+			performRefresh:    true,
+			wantErr:           "error refreshing tokens using OAuth2 token endpoint: oauth2: cannot fetch token: 401 Unauthorized\nResponse: some error",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			testServer, testServerCA := tlsserver.TestServerIPv4(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+
+				err := r.ParseForm()
+				require.NoError(t, err)
+				params := r.PostForm
+				require.Equal(t, "fake-client-id", params.Get("client_id"))
+				require.Equal(t, "fake-client-secret", params.Get("client_secret"))
+
+				if test.performRefresh {
+					require.Len(t, params, 4)
+					require.Equal(t, "refresh_token", params.Get("grant_type"))
+					require.Equal(t, fakeRefreshToken, params.Get("refresh_token"))
+				} else {
+					require.Len(t, params, 6)
+					require.Equal(t, "authorization_code", params.Get("grant_type"))
+					require.Equal(t, "https://fake-redirect-url", params.Get("redirect_uri"))
+					require.Equal(t, "fake-authcode", params.Get("code"))
+					require.Equal(t, string(pkceCode), params.Get("code_verifier"))
+				}
+
+				switch r.URL.Path {
+				case "/token":
+					w.Header().Set("content-type", "application/json")
+					_, err = fmt.Fprintf(w, `{"access_token":%q,"token_type":"Bearer","refresh_token":%q}`,
+						fakeAccessToken, fakeRefreshToken)
+					require.NoError(t, err)
+				case "/token-error":
+					w.WriteHeader(http.StatusUnauthorized)
+					_, err = w.Write([]byte("some error"))
+					require.NoError(t, err)
+				default:
+					t.Fatalf("tried to call provider at unexpected endpoint: %s", r.URL.Path)
+				}
+			}), nil)
+			testServerPool, err := cert.NewPoolFromBytes(testServerCA)
+			require.NoError(t, err)
+
+			subject := New(ProviderConfig{
+				OAuth2Config: &oauth2.Config{
+					ClientID:     "fake-client-id",
+					ClientSecret: "fake-client-secret",
+					Endpoint: oauth2.Endpoint{
+						AuthURL:   "https://fake-auth-url",
+						TokenURL:  testServer.URL + test.tokenEndpointPath,
+						AuthStyle: oauth2.AuthStyleInParams,
+					},
+				},
+				HttpClient: &http.Client{
+					Timeout: 10 * time.Second,
+					Transport: &http.Transport{TLSClientConfig: &tls.Config{
+						MinVersion: tls.VersionTLS12,
+						RootCAs:    testServerPool,
+					}},
+				},
+			})
+
+			var tok *oauth2.Token
+			if test.performRefresh {
+				tok, err = subject.PerformRefresh(context.Background(), fakeRefreshToken)
+			} else {
+				tok, err = subject.ExchangeAuthcode(context.Background(), "fake-authcode", pkceCode, "https://fake-redirect-url")
+			}
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				require.Nil(t, tok)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.wantToken.AccessToken, tok.AccessToken)
+			require.Equal(t, test.wantToken.RefreshToken, tok.RefreshToken)
+			require.Equal(t, test.wantToken.TokenType, tok.TokenType)
+		})
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	const idpDisplayName = "idp display name 😀"
+	const encodedIDPDisplayName = "idp+display+name+%F0%9F%98%80"
+	const accessToken = "fake-access-token" //nolint:gosec // this is not a credential
+
+	mustCompile := func(mapping idpv1alpha1.OAuth2ClaimMapping) *ClaimMapping {
+		compiled, err := CompileClaimMapping(mapping)
+		require.NoError(t, err)
+		return compiled
+	}
+
+	testServer, testServerCA := tlsserver.TestServerIPv4(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Accept"))
+		require.Equal(t, "Bearer "+accessToken, r.Header.Get("Authorization"))
+
+		w.Header().Set("content-type", "application/json")
+		var body string
+		switch r.URL.Path {
+		case "/user":
+			body = `{"login":"some-login","id":1234567890123,"groups":["group1","group2"]}`
+		case "/groups":
+			body = `[{"name":"team1"},{"name":"team2"}]`
+		case "/not-json":
+			body = `this is not json`
+		case "/error":
+			w.WriteHeader(http.StatusForbidden)
+			body = `{"message":"forbidden"}`
+		default:
+			t.Fatalf("tried to call provider at unexpected endpoint: %s", r.URL.Path)
+		}
+		_, err := w.Write([]byte(body))
+		require.NoError(t, err)
+	}), nil)
+	testServerPool, err := cert.NewPoolFromBytes(testServerCA)
+	require.NoError(t, err)
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			RootCAs:    testServerPool,
+		}},
+	}
+
+	wantSubject := fmt.Sprintf("%s/user?idpName=%s&sub=1234567890123", testServer.URL, encodedIDPDisplayName)
+
+	tests := []struct {
+		name       string
+		config     ProviderConfig
+		wantUser   *upstreamprovider.OAuth2User
+		wantErrMsg string
+	}{
+		{
+			name: "happy path using jsonPath with groups from the user endpoint",
+			config: ProviderConfig{
+				UserInfoURL:   testServer.URL + "/user",
+				UsernameClaim: mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.login}"}),
+				UIDClaim:      mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.id}"}),
+				GroupsClaim:   mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.groups}"}),
+			},
+			wantUser: &upstreamprovider.OAuth2User{
+				Username:          "some-login",
+				Groups:            []string{"group1", "group2"},
+				DownstreamSubject: wantSubject,
+			},
+		},
+		{
+			name: "happy path using expressions with groups from the groups endpoint",
+			config: ProviderConfig{
+				UserInfoURL:   testServer.URL + "/user",
+				GroupsURL:     testServer.URL + "/groups",
+				UsernameClaim: mustCompile(idpv1alpha1.OAuth2ClaimMapping{Expression: `response.login + ":" + string(response.id)`}),
+				UIDClaim:      mustCompile(idpv1alpha1.OAuth2ClaimMapping{Expression: "response.id"}),
+				GroupsClaim:   mustCompile(idpv1alpha1.OAuth2ClaimMapping{Expression: "response.map(g, g.name)"}),
+			},
+			wantUser: &upstreamprovider.OAuth2User{
+				Username:          "some-login:1234567890123",
+				Groups:            []string{"team1", "team2"},
+				DownstreamSubject: wantSubject,
+			},
+		},
+		{
+			name: "happy path without groups",
+			config: ProviderConfig{
+				UserInfoURL:   testServer.URL + "/user",
+				UsernameClaim: mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.login}"}),
+				UIDClaim:      mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.id}"}),
+			},
+			wantUser: &upstreamprovider.OAuth2User{
+				Username:          "some-login",
+				DownstreamSubject: wantSubject,
+			},
+		},
+		{
+			name: "user endpoint returns an error status",
+			config: ProviderConfig{
+				UserInfoURL:   testServer.URL + "/error",
+				UsernameClaim: mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.login}"}),
+				UIDClaim:      mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.id}"}),
+			},
+			wantErrMsg: fmt.Sprintf(`error making request to "%s/error": unexpected response status "403 Forbidden"`, testServer.URL),
+		},
+		{
+			name: "user endpoint returns something other than JSON",
+			config: ProviderConfig{
+				UserInfoURL:   testServer.URL + "/not-json",
+				UsernameClaim: mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.login}"}),
+				UIDClaim:      mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.id}"}),
+			},
+			wantErrMsg: fmt.Sprintf(`error decoding JSON response from "%s/not-json": invalid character 'h' in literal true (expecting 'r')`, testServer.URL),
+		},
+		{
+			name: "groups endpoint returns an error status",
+			config: ProviderConfig{
+				UserInfoURL:   testServer.URL + "/user",
+				GroupsURL:     testServer.URL + "/error",
+				UsernameClaim: mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.login}"}),
+				UIDClaim:      mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.id}"}),
+				GroupsClaim:   mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{[*].name}"}),
+			},
+			wantErrMsg: fmt.Sprintf(`error making request to "%s/error": unexpected response status "403 Forbidden"`, testServer.URL),
+		},
+		{
+			name: "username claim is missing from the response",
+			config: ProviderConfig{
+				UserInfoURL:   testServer.URL + "/user",
+				UsernameClaim: mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.email}"}),
+				UIDClaim:      mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.id}"}),
+			},
+			wantErrMsg: `error determining username from user info response: error evaluating jsonPath "{.email}": email is not found`,
+		},
+		{
+			name: "uid claim results in the wrong type",
+			config: ProviderConfig{
+				UserInfoURL:   testServer.URL + "/user",
+				UsernameClaim: mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.login}"}),
+				UIDClaim:      mustCompile(idpv1alpha1.OAuth2ClaimMapping{Expression: "response.groups"}),
+			},
+			wantErrMsg: `error determining uid from user info response: expression "response.groups" should result in a string or number but resulted in a value of type []interface {}`,
+		},
+		{
+			name: "groups claim results in the wrong type",
+			config: ProviderConfig{
+				UserInfoURL:   testServer.URL + "/user",
+				UsernameClaim: mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.login}"}),
+				UIDClaim:      mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.id}"}),
+				GroupsClaim:   mustCompile(idpv1alpha1.OAuth2ClaimMapping{JSONPath: "{.id}"}),
+			},
+			wantErrMsg: `error determining groups from groups response: jsonPath "{.id}" should result in a list of strings but resulted in a list containing a value of type int64`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.config.HttpClient = httpClient
+			subject := New(test.config)
+
+			user, err := subject.GetUser(context.Background(), accessToken, idpDisplayName)
+			if test.wantErrMsg != "" {
+				require.EqualError(t, err, test.wantErrMsg)
+				require.Nil(t, user)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.wantUser, user)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamoidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // x5t is defined as a SHA-1 thumbprint, and it is only used to identify the certificate
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const (
+	// clientAssertionTypeJWTBearer is defined by https://datatracker.ietf.org/doc/html/rfc7523#section-2.2.
+	clientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// clientAssertionLifetime is how long a client assertion is valid. Each assertion is only used for one request,
+	// so this only needs to allow for some clock skew between the Supervisor and the OIDC provider.
+	clientAssertionLifetime = 5 * time.Minute
+)
+
+// ClientAssertionSigner creates the signed JWTs which are used by the "private_key_jwt" client authentication method,
+// as defined by https://openid.net/specs/openid-connect-core-1_0.html#ClientAuthentication and RFC 7523.
+type ClientAssertionSigner struct {
+	signer   jose.Signer
+	clientID string
+	now      func() time.Time
+}
+
+// NewClientAssertionSigner returns a ClientAssertionSigner which signs with the private key of the certificate.
+// It returns an error when the type of the private key is not supported.
+func NewClientAssertionSigner(clientID string, certificate tls.Certificate) (*ClientAssertionSigner, error) {
+	algorithm, err := signatureAlgorithmForKey(certificate.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := certificate.Leaf
+	if leaf == nil {
+		if len(certificate.Certificate) == 0 {
+			return nil, fmt.Errorf("certificate is missing")
+		}
+		leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse certificate: %w", err)
+		}
+	}
+
+	// Include the thumbprints of the certificate, so the OIDC provider can find the key which was registered
+	// for this client. Some providers, e.g. Azure AD, require the SHA-1 thumbprint.
+	sha1Thumbprint := sha1.Sum(leaf.Raw) //nolint:gosec // see comment on import
+	sha256Thumbprint := sha256.Sum256(leaf.Raw)
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: algorithm, Key: certificate.PrivateKey},
+		(&jose.SignerOptions{}).
+			WithType("JWT").
+			WithHeader("x5t", base64.RawURLEncoding.EncodeToString(sha1Thumbprint[:])).
+			WithHeader("x5t#S256", base64.RawURLEncoding.EncodeToString(sha256Thumbprint[:])),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create signer: %w", err)
+	}
+
+	return &ClientAssertionSigner{signer: signer, clientID: clientID, now: time.Now}, nil
+}
+
+// ClientAssertion returns a new signed client assertion JWT for the audience, which should be the token endpoint URL
+// of the OIDC provider. Each assertion has a unique ID, so it should only be used for a single request.
+func (s *ClientAssertionSigner) ClientAssertion(audience string) (string, error) {
+	var jti [16]byte
+	if _, err := io.ReadFull(rand.Reader, jti[:]); err != nil {
+		return "", fmt.Errorf("could not generate client assertion ID: %w", err)
+	}
+
+	now := s.now()
+	return jwt.Signed(s.signer).Claims(jwt.Claims{
+		Issuer:    s.clientID,
+		Subject:   s.clientID,
+		Audience:  jwt.Audience{audience},
+		ID:        hex.EncodeToString(jti[:]),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	}).Serialize()
+}
+
+func signatureAlgorithmForKey(key any) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+		return "", fmt.Errorf("unsupported ECDSA curve %q", k.Curve.Params().Name)
+	default:
+		return "", fmt.Errorf("unsupported private key type %T (should be RSA or ECDSA)", key)
+	}
+}
+
+// clientAssertionRoundTripper adds a new client assertion to the form body of each request.
+type clientAssertionRoundTripper struct {
+	base     http.RoundTripper
+	signer   *ClientAssertionSigner
+	audience string
+}
+
+func (rt *clientAssertionRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not read request body: %w", err)
+		}
+	}
+
+	params, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse request body: %w", err)
+	}
+
+	assertion, err := rt.signer.ClientAssertion(rt.audience)
+	if err != nil {
+		return nil, err
+	}
+	params.Del("client_secret")
+	params.Set("client_id", rt.signer.clientID)
+	params.Set("client_assertion_type", clientAssertionTypeJWTBearer)
+	params.Set("client_assertion", assertion)
+	encoded := params.Encode()
+
+	// A RoundTripper should not modify the original request.
+	newReq := req.Clone(req.Context())
+	newReq.Body = io.NopCloser(strings.NewReader(encoded))
+	newReq.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(encoded)), nil }
+	newReq.ContentLength = int64(len(encoded))
+	newReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	newReq.Header.Del("Authorization")
+
+	return rt.base.RoundTrip(newReq)
+}
@@ -0,0 +1,251 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamoidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+)
+
+func TestClientAssertionSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	require.NoError(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		certificate   tls.Certificate
+		wantAlgorithm jose.SignatureAlgorithm
+		wantErr       string
+	}{
+		{
+			name:          "RSA key",
+			certificate:   newTestCertificate(t, rsaKey),
+			wantAlgorithm: jose.RS256,
+		},
+		{
+			name:          "ECDSA P-256 key",
+			certificate:   newTestCertificate(t, p256Key),
+			wantAlgorithm: jose.ES256,
+		},
+		{
+			name:          "ECDSA P-384 key",
+			certificate:   newTestCertificate(t, p384Key),
+			wantAlgorithm: jose.ES384,
+		},
+		{
+			name:        "unsupported ECDSA curve",
+			certificate: tls.Certificate{PrivateKey: p224Key},
+			wantErr:     `unsupported ECDSA curve "P-224"`,
+		},
+		{
+			name:        "unsupported key type",
+			certificate: tls.Certificate{PrivateKey: ed25519Key},
+			wantErr:     "unsupported private key type ed25519.PrivateKey (should be RSA or ECDSA)",
+		},
+		{
+			name:        "missing certificate",
+			certificate: tls.Certificate{PrivateKey: rsaKey},
+			wantErr:     "certificate is missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := NewClientAssertionSigner("test-client-id", tt.certificate)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Nil(t, signer)
+				return
+			}
+			require.NoError(t, err)
+
+			now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			signer.now = func() time.Time { return now }
+
+			assertion1, err := signer.ClientAssertion("https://example.com/token")
+			require.NoError(t, err)
+			assertion2, err := signer.ClientAssertion("https://example.com/token")
+			require.NoError(t, err)
+
+			claims1 := requireValidClientAssertion(t, assertion1, tt.certificate, tt.wantAlgorithm)
+			claims2 := requireValidClientAssertion(t, assertion2, tt.certificate, tt.wantAlgorithm)
+
+			require.Equal(t, "test-client-id", claims1.Issuer)
+			require.Equal(t, "test-client-id", claims1.Subject)
+			require.Equal(t, jwt.Audience{"https://example.com/token"}, claims1.Audience)
+			require.Equal(t, jwt.NewNumericDate(now), claims1.IssuedAt)
+			require.Equal(t, jwt.NewNumericDate(now), claims1.NotBefore)
+			require.Equal(t, jwt.NewNumericDate(now.Add(5*time.Minute)), claims1.Expiry)
+			require.Len(t, claims1.ID, 32)
+			require.NotEqual(t, claims1.ID, claims2.ID, "each assertion should have a unique ID")
+		})
+	}
+}
+
+func TestProviderConfigWithClientAssertionSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	certificate := newTestCertificate(t, key)
+
+	// The wantAudience is a pointer because it may be the URL of the server itself, which is not known yet.
+	newServer := func(t *testing.T, wantAudience *string, wantParams url.Values, response string) *httptest.Server {
+		t.Helper()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPost, r.Method)
+			require.Empty(t, r.Header.Get("Authorization"))
+			require.NoError(t, r.ParseForm())
+
+			require.Equal(t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", r.Form.Get("client_assertion_type"))
+			claims := requireValidClientAssertion(t, r.Form.Get("client_assertion"), certificate, jose.ES256)
+			require.Equal(t, jwt.Audience{*wantAudience}, claims.Audience)
+
+			r.Form.Del("client_assertion_type")
+			r.Form.Del("client_assertion")
+			require.Equal(t, wantParams, r.Form)
+
+			w.Header().Set("content-type", "application/json")
+			_, _ = w.Write([]byte(response))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	newProvider := func(t *testing.T, tokenURL string) *ProviderConfig {
+		t.Helper()
+		signer, err := NewClientAssertionSigner("test-client-id", certificate)
+		require.NoError(t, err)
+		return &ProviderConfig{
+			Name: "test-name",
+			Config: &oauth2.Config{
+				ClientID: "test-client-id",
+				Endpoint: oauth2.Endpoint{
+					AuthURL:   "https://example.com/authorize",
+					TokenURL:  tokenURL,
+					AuthStyle: oauth2.AuthStyleInParams,
+				},
+			},
+			Client:                http.DefaultClient,
+			ClientAssertionSigner: signer,
+		}
+	}
+
+	t.Run("PerformRefresh", func(t *testing.T) {
+		var tokenURL string
+		server := newServer(t, &tokenURL, url.Values{
+			"client_id":     {"test-client-id"},
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {"test-initial-refresh-token"},
+		}, `{"access_token":"test-access-token","token_type":"Bearer"}`)
+
+		tokenURL = server.URL
+		p := newProvider(t, tokenURL)
+
+		tok, err := p.PerformRefresh(context.Background(), "test-initial-refresh-token")
+		require.NoError(t, err)
+		require.Equal(t, "test-access-token", tok.AccessToken)
+	})
+
+	t.Run("RevokeToken", func(t *testing.T) {
+		tokenURL := "https://example.com/token"
+		server := newServer(t, &tokenURL, url.Values{
+			"client_id":       {"test-client-id"},
+			"token":           {"test-refresh-token"},
+			"token_type_hint": {"refresh_token"},
+		}, `{}`)
+
+		p := newProvider(t, tokenURL)
+		revocationURL, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		p.RevocationURL = revocationURL
+
+		require.NoError(t, p.RevokeToken(context.Background(), "test-refresh-token", upstreamprovider.RefreshTokenType))
+	})
+
+	t.Run("does not try basic auth when revocation fails with invalid_client", func(t *testing.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			require.Empty(t, r.Header.Get("Authorization"))
+			w.Header().Set("content-type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+		}))
+		t.Cleanup(server.Close)
+
+		p := newProvider(t, "https://example.com/token")
+		revocationURL, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		p.RevocationURL = revocationURL
+
+		err = p.RevokeToken(context.Background(), "test-refresh-token", upstreamprovider.RefreshTokenType)
+		require.EqualError(t, err, `server responded with status 400 with body: {"error":"invalid_client"}`)
+		require.Equal(t, 1, requests)
+	})
+}
+
+func requireValidClientAssertion(
+	t *testing.T,
+	assertion string,
+	certificate tls.Certificate,
+	wantAlgorithm jose.SignatureAlgorithm,
+) *jwt.Claims {
+	t.Helper()
+
+	parsed, err := jwt.ParseSigned(assertion, []jose.SignatureAlgorithm{wantAlgorithm})
+	require.NoError(t, err)
+	require.Len(t, parsed.Headers, 1)
+	require.Equal(t, "JWT", parsed.Headers[0].ExtraHeaders[jose.HeaderType])
+	sha256Thumbprint := sha256.Sum256(certificate.Certificate[0])
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(sha256Thumbprint[:]), parsed.Headers[0].ExtraHeaders["x5t#S256"])
+	require.NotEmpty(t, parsed.Headers[0].ExtraHeaders["x5t"])
+
+	var claims jwt.Claims
+	require.NoError(t, parsed.Claims(certificate.PrivateKey.(crypto.Signer).Public(), &claims))
+	return &claims
+}
+
+func newTestCertificate(t *testing.T, key crypto.Signer) tls.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
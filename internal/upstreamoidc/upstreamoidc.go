@@ -46,7 +46,11 @@ type ProviderConfig struct {
 	AdditionalAuthcodeParams map[string]string
 	AdditionalClaimMappings  map[string]string
 	RevocationURL            *url.URL // will commonly be nil: many providers do not offer this
-	Provider                 interface {
+	// ClientAssertionSigner is only set when the client authenticates using the "private_key_jwt" method.
+	// Otherwise, the client authenticates using the Config.ClientSecret when it is set, or using the TLS
+	// client certificate configured on the Client.
+	ClientAssertionSigner *ClientAssertionSigner
+	Provider              interface {
 		Verifier(*coreosoidc.Config) *coreosoidc.IDTokenVerifier
 		Claims(v any) error
 		UserInfo(ctx context.Context, tokenSource oauth2.TokenSource) (*coreosoidc.UserInfo, error)
@@ -121,7 +125,7 @@ func (p *ProviderConfig) PasswordCredentialsGrantAndValidateTokens(ctx context.C
 
 	// Note that this implicitly uses the scopes from p.Config.Scopes.
 	tok, err := p.Config.PasswordCredentialsToken(
-		coreosoidc.ClientContext(ctx, p.Client),
+		coreosoidc.ClientContext(ctx, p.clientForClientAuthenticatedRequests()),
 		username,
 		password,
 	)
@@ -137,7 +141,7 @@ func (p *ProviderConfig) PasswordCredentialsGrantAndValidateTokens(ctx context.C
 
 func (p *ProviderConfig) ExchangeAuthcodeAndValidateTokens(ctx context.Context, authcode string, pkceCodeVerifier pkce.Code, expectedIDTokenNonce nonce.Nonce, redirectURI string) (*oidctypes.Token, error) {
 	tok, err := p.Config.Exchange(
-		coreosoidc.ClientContext(ctx, p.Client),
+		coreosoidc.ClientContext(ctx, p.clientForClientAuthenticatedRequests()),
 		authcode,
 		pkceCodeVerifier.Verifier(),
 		oauth2.SetAuthURLParam("redirect_uri", redirectURI),
@@ -151,7 +155,7 @@ func (p *ProviderConfig) ExchangeAuthcodeAndValidateTokens(ctx context.Context,
 
 func (p *ProviderConfig) PerformRefresh(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
 	// Use the provided HTTP client to benefit from its CA, proxy, and other settings.
-	httpClientContext := coreosoidc.ClientContext(ctx, p.Client)
+	httpClientContext := coreosoidc.ClientContext(ctx, p.clientForClientAuthenticatedRequests())
 	// Create a TokenSource without an access token, so it thinks that a refresh is immediately required.
 	// Then ask it for the tokens to cause it to perform the refresh and return the results.
 	return p.Config.TokenSource(httpClientContext, &oauth2.Token{RefreshToken: refreshToken}).Token()
@@ -171,7 +175,8 @@ func (p *ProviderConfig) RevokeToken(ctx context.Context, token string, tokenTyp
 	}
 	// First try using client auth in the request params.
 	tryAnotherClientAuthMethod, err := p.tryRevokeToken(ctx, token, tokenType, false)
-	if tryAnotherClientAuthMethod {
+	if tryAnotherClientAuthMethod && p.Config.ClientSecret != "" {
+		// Basic auth is only an alternative when authenticating with a client secret.
 		// Try again using basic auth this time. Overwrite the first client auth error,
 		// which isn't useful anymore when retrying.
 		_, err = p.tryRevokeToken(ctx, token, tokenType, true)
@@ -195,7 +200,7 @@ func (p *ProviderConfig) tryRevokeToken(
 	clientID := p.Config.ClientID
 	clientSecret := p.Config.ClientSecret
 	// Use the provided HTTP client to benefit from its CA, proxy, and other settings.
-	httpClient := p.Client
+	httpClient := p.clientForClientAuthenticatedRequests()
 
 	params := url.Values{
 		"token":           []string{token},
@@ -203,7 +208,9 @@ func (p *ProviderConfig) tryRevokeToken(
 	}
 	if !useBasicAuth {
 		params["client_id"] = []string{clientID}
-		params["client_secret"] = []string{clientSecret}
+		if clientSecret != "" {
+			params["client_secret"] = []string{clientSecret}
+		}
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.RevocationURL.String(), strings.NewReader(params.Encode()))
@@ -279,6 +286,28 @@ func (p *ProviderConfig) tryRevokeToken(
 	}
 }
 
+// clientForClientAuthenticatedRequests returns the HTTP client to use for requests which must include client
+// authentication, i.e. requests to the token endpoint and to the revocation endpoint.
+func (p *ProviderConfig) clientForClientAuthenticatedRequests() *http.Client {
+	if p.ClientAssertionSigner == nil {
+		return p.Client
+	}
+
+	base := http.DefaultTransport
+	client := &http.Client{}
+	if p.Client != nil {
+		client.Timeout = p.Client.Timeout
+		client.Jar = p.Client.Jar
+		client.CheckRedirect = p.Client.CheckRedirect
+		if p.Client.Transport != nil {
+			base = p.Client.Transport
+		}
+	}
+	// The token endpoint is the audience of client assertions, even when they are sent to the revocation endpoint.
+	client.Transport = &clientAssertionRoundTripper{base: base, signer: p.ClientAssertionSigner, audience: p.Config.Endpoint.TokenURL}
+	return client
+}
+
 // ValidateTokenAndMergeWithUserInfo will validate the ID token. It will also merge the claims from the userinfo endpoint response,
 // if the provider offers the userinfo endpoint.
 func (p *ProviderConfig) ValidateTokenAndMergeWithUserInfo(ctx context.Context, tok *oauth2.Token, expectedIDTokenNonce nonce.Nonce, requireIDToken bool, requireUserInfo bool) (*oidctypes.Token, error) {
@@ -0,0 +1,260 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package upstreamsaml implements an abstraction of upstream SAML identity provider interactions.
+package upstreamsaml
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/crewjam/saml"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	dsig "github.com/russellhaering/goxmldsig"
+	"k8s.io/apimachinery/pkg/types"
+
+	"go.pinniped.dev/internal/federationdomain/downstreamsubject"
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+)
+
+// ProviderConfig holds the active configuration of an upstream SAML provider.
+type ProviderConfig struct {
+	Name        string
+	ResourceUID types.UID
+
+	// IDPMetadata is the parsed metadata of the upstream identity provider. It includes the IDP's entity ID,
+	// single sign-on URLs, and signing certificates.
+	IDPMetadata *saml.EntityDescriptor
+
+	// EntityID is the entity ID of the Supervisor as a service provider. When empty, the downstream issuer URL
+	// of the FederationDomain will be used instead.
+	EntityID string
+
+	// UsernameAttribute is the name or friendly name of the assertion attribute to use as the username.
+	// When empty, the NameID of the assertion's subject will be used.
+	UsernameAttribute string
+
+	// GroupsAttribute is the name or friendly name of the assertion attribute to use as the groups.
+	// When empty, no groups will be returned.
+	GroupsAttribute string
+
+	// Key and Certificate are used to sign AuthnRequests and to decrypt encrypted assertions.
+	Key         *rsa.PrivateKey
+	Certificate *x509.Certificate
+}
+
+type Provider struct {
+	c ProviderConfig
+}
+
+var _ upstreamprovider.UpstreamSAMLIdentityProviderI = &Provider{}
+
+// New creates a Provider. The config is not a pointer to ensure that a copy of the config is created,
+// making the resulting Provider use an effectively read-only configuration.
+func New(config ProviderConfig) *Provider {
+	return &Provider{c: config}
+}
+
+// ParseMetadata parses a SAML metadata document, which may be either an EntityDescriptor or an
+// EntitiesDescriptor. In the latter case, the first EntityDescriptor which describes an identity
+// provider will be returned.
+func ParseMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	// Ensure that the XML will be interpreted the same way after round-tripping through encoding/xml.
+	if err := xrv.Validate(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("invalid XML: %w", err)
+	}
+
+	entity := &saml.EntityDescriptor{}
+	err := xml.Unmarshal(data, entity)
+	var unexpectedElementErr xml.UnmarshalError
+	if err != nil && errors.As(err, &unexpectedElementErr) && strings.Contains(err.Error(), "<EntitiesDescriptor>") {
+		entities := &saml.EntitiesDescriptor{}
+		if err := xml.Unmarshal(data, entities); err != nil {
+			return nil, err
+		}
+		for i := range entities.EntityDescriptors {
+			if len(entities.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+				entity = &entities.EntityDescriptors[i]
+				break
+			}
+		}
+		if len(entity.IDPSSODescriptors) == 0 {
+			return nil, errors.New("EntitiesDescriptor does not contain any EntityDescriptor with an IDPSSODescriptor")
+		}
+		return entity, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entity.IDPSSODescriptors) == 0 {
+		return nil, errors.New("EntityDescriptor does not contain an IDPSSODescriptor")
+	}
+	return entity, nil
+}
+
+func (p *Provider) GetResourceName() string {
+	return p.c.Name
+}
+
+func (p *Provider) GetResourceUID() types.UID {
+	return p.c.ResourceUID
+}
+
+func (p *Provider) GetIDPEntityID() string {
+	return p.c.IDPMetadata.EntityID
+}
+
+func (p *Provider) GetServiceProviderEntityID(downstreamIssuerURL string) string {
+	if p.c.EntityID != "" {
+		return p.c.EntityID
+	}
+	return downstreamIssuerURL
+}
+
+// serviceProvider returns a saml.ServiceProvider for a particular FederationDomain. These are cheap to create,
+// so they are created per request since the ACS URL (and maybe the entity ID) depend on the FederationDomain.
+func (p *Provider) serviceProvider(downstreamIssuerURL string, acsURL string) (*saml.ServiceProvider, error) {
+	parsedACSURL, err := url.Parse(acsURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse ACS URL: %w", err)
+	}
+	return &saml.ServiceProvider{
+		EntityID:    p.GetServiceProviderEntityID(downstreamIssuerURL),
+		Key:         p.c.Key,
+		Certificate: p.c.Certificate,
+		AcsURL:      *parsedACSURL,
+		IDPMetadata: p.c.IDPMetadata,
+		// Let the IDP decide which NameID format to use, rather than asking for transient NameIDs.
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+	}, nil
+}
+
+func (p *Provider) AuthnRequestURL(requestID string, relayState string, downstreamIssuerURL string, acsURL string) (string, error) {
+	sp, err := p.serviceProvider(downstreamIssuerURL, acsURL)
+	if err != nil {
+		return "", err
+	}
+
+	ssoURL := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if ssoURL == "" {
+		return "", errors.New("identity provider metadata does not include a single sign-on service with the HTTP-Redirect binding")
+	}
+
+	req, err := sp.MakeAuthenticationRequest(ssoURL, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", fmt.Errorf("could not create AuthnRequest: %w", err)
+	}
+	req.ID = requestID
+
+	// Redirect does not escape the relay state, so escape it here.
+	redirectURL, err := req.Redirect(url.QueryEscape(relayState), sp)
+	if err != nil {
+		return "", fmt.Errorf("could not sign AuthnRequest: %w", err)
+	}
+	return redirectURL.String(), nil
+}
+
+func (p *Provider) ParseResponse(
+	encodedResponse string,
+	requestID string,
+	downstreamIssuerURL string,
+	acsURL string,
+	idpDisplayName string,
+) (*upstreamprovider.SAMLUser, error) {
+	sp, err := p.serviceProvider(downstreamIssuerURL, acsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	decodedResponse, err := base64.StdEncoding.DecodeString(encodedResponse)
+	if err != nil {
+		return nil, fmt.Errorf("could not base64 decode SAMLResponse: %w", err)
+	}
+
+	assertion, err := sp.ParseXMLResponse(decodedResponse, []string{requestID})
+	if err != nil {
+		// The library's errors intentionally hide the details, so unwrap them for our logs.
+		var invalidResponseErr *saml.InvalidResponseError
+		if errors.As(err, &invalidResponseErr) && invalidResponseErr.PrivateErr != nil {
+			return nil, fmt.Errorf("invalid SAMLResponse: %w", invalidResponseErr.PrivateErr)
+		}
+		return nil, fmt.Errorf("invalid SAMLResponse: %w", err)
+	}
+
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, errors.New("SAML assertion does not include a NameID")
+	}
+	nameID := assertion.Subject.NameID.Value
+
+	samlUser := &upstreamprovider.SAMLUser{
+		Username:          nameID,
+		NameID:            nameID,
+		DownstreamSubject: downstreamsubject.SAML(p.GetIDPEntityID(), nameID, idpDisplayName),
+	}
+
+	if p.c.UsernameAttribute != "" {
+		values := attributeValues(assertion, p.c.UsernameAttribute)
+		if len(values) == 0 || values[0] == "" {
+			return nil, fmt.Errorf("SAML assertion does not include the configured username attribute %q", p.c.UsernameAttribute)
+		}
+		samlUser.Username = values[0]
+	}
+
+	if p.c.GroupsAttribute != "" {
+		for _, group := range attributeValues(assertion, p.c.GroupsAttribute) {
+			if group != "" {
+				samlUser.Groups = append(samlUser.Groups, group)
+			}
+		}
+	}
+
+	samlUser.SessionNotOnOrAfter = sessionNotOnOrAfter(assertion)
+
+	return samlUser, nil
+}
+
+// attributeValues returns all values of all attributes in the assertion whose Name or FriendlyName match.
+func attributeValues(assertion *saml.Assertion, name string) []string {
+	var values []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name != name && attribute.FriendlyName != name {
+				continue
+			}
+			for _, value := range attribute.Values {
+				values = append(values, value.Value)
+			}
+		}
+	}
+	return values
+}
+
+// sessionNotOnOrAfter returns the earliest SessionNotOnOrAfter of the assertion's authn statements,
+// or the zero time when there is none.
+func sessionNotOnOrAfter(assertion *saml.Assertion) time.Time {
+	var earliest time.Time
+	for _, statement := range assertion.AuthnStatements {
+		if statement.SessionNotOnOrAfter == nil {
+			continue
+		}
+		if earliest.IsZero() || statement.SessionNotOnOrAfter.Before(earliest) {
+			earliest = *statement.SessionNotOnOrAfter
+		}
+	}
+	return earliest
+}
+
+// GetConfig returns the config. This is not part of the UpstreamSAMLIdentityProviderI interface and is just for testing.
+func (p *Provider) GetConfig() ProviderConfig {
+	return p.c
+}
@@ -0,0 +1,338 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package upstreamsaml
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+
+	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
+	"go.pinniped.dev/internal/testutil/samltestutil"
+)
+
+const (
+	downstreamIssuer = "https://supervisor.example.com/some/path"
+	acsURL           = downstreamIssuer + "/saml/acs"
+	requestID        = "id-some-request-id"
+	idpDisplayName   = "some idp display name"
+)
+
+func TestParseMetadata(t *testing.T) {
+	testIDP := samltestutil.NewTestIDP(t)
+	entityDescriptorXML := string(testIDP.MetadataXML())
+
+	tests := []struct {
+		name         string
+		metadata     string
+		wantEntityID string
+		wantErr      string
+	}{
+		{
+			name:         "EntityDescriptor",
+			metadata:     entityDescriptorXML,
+			wantEntityID: samltestutil.IDPMetadataURL,
+		},
+		{
+			name: "EntitiesDescriptor",
+			metadata: `<EntitiesDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata">` +
+				`<EntityDescriptor entityID="https://sp.example.com"><SPSSODescriptor></SPSSODescriptor></EntityDescriptor>` +
+				entityDescriptorXML +
+				`</EntitiesDescriptor>`,
+			wantEntityID: samltestutil.IDPMetadataURL,
+		},
+		{
+			name: "EntitiesDescriptor without any IDP",
+			metadata: `<EntitiesDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata">` +
+				`<EntityDescriptor entityID="https://sp.example.com"><SPSSODescriptor></SPSSODescriptor></EntityDescriptor>` +
+				`</EntitiesDescriptor>`,
+			wantErr: "EntitiesDescriptor does not contain any EntityDescriptor with an IDPSSODescriptor",
+		},
+		{
+			name:     "EntityDescriptor without an IDP",
+			metadata: `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://sp.example.com"><SPSSODescriptor></SPSSODescriptor></EntityDescriptor>`,
+			wantErr:  "EntityDescriptor does not contain an IDPSSODescriptor",
+		},
+		{
+			name:     "some other document",
+			metadata: `<html></html>`,
+			wantErr:  "expected element type <EntityDescriptor> but have <html>",
+		},
+		{
+			name:     "not XML",
+			metadata: `this is not xml`,
+			wantErr:  "EOF",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entity, err := ParseMetadata([]byte(test.metadata))
+			if test.wantErr != "" {
+				require.ErrorContains(t, err, test.wantErr)
+				require.Nil(t, entity)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.wantEntityID, entity.EntityID)
+		})
+	}
+}
+
+func TestAuthnRequestURL(t *testing.T) {
+	testIDP := samltestutil.NewTestIDP(t)
+	spKeyPair := samltestutil.NewKeyPair(t, "test-sp")
+
+	tests := []struct {
+		name         string
+		entityID     string
+		wantIssuer   string
+		editMetadata func(*saml.EntityDescriptor)
+		wantErr      string
+	}{
+		{
+			name:       "uses the downstream issuer as the entity ID by default",
+			wantIssuer: downstreamIssuer,
+		},
+		{
+			name:       "uses the configured entity ID",
+			entityID:   "urn:some:entity:id",
+			wantIssuer: "urn:some:entity:id",
+		},
+		{
+			name: "IDP does not support the HTTP-Redirect binding",
+			editMetadata: func(metadata *saml.EntityDescriptor) {
+				metadata.IDPSSODescriptors[0].SingleSignOnServices = metadata.IDPSSODescriptors[0].SingleSignOnServices[1:]
+			},
+			wantErr: "identity provider metadata does not include a single sign-on service with the HTTP-Redirect binding",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata := testIDP.Metadata()
+			if test.editMetadata != nil {
+				test.editMetadata(metadata)
+			}
+			p := New(ProviderConfig{
+				Name:        "some-name",
+				IDPMetadata: metadata,
+				EntityID:    test.entityID,
+				Key:         spKeyPair.Key,
+				Certificate: spKeyPair.Certificate,
+			})
+
+			authnRequestURL, err := p.AuthnRequestURL(requestID, "some&relay=state", downstreamIssuer, acsURL)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			parsed, err := url.Parse(authnRequestURL)
+			require.NoError(t, err)
+			require.Equal(t, samltestutil.IDPSSOURL, parsed.Scheme+"://"+parsed.Host+parsed.Path)
+			require.Equal(t, "some&relay=state", parsed.Query().Get("RelayState"))
+
+			// The test IDP validates the request, including its signature, and responds to the ACS URL.
+			testIDP.RegisterServiceProvider(test.wantIssuer, acsURL, spKeyPair)
+			samlResponse, relayState := testIDP.Login(authnRequestURL, &saml.Session{NameID: "some-name-id"}, nil)
+			require.NotEmpty(t, samlResponse)
+			require.Equal(t, "some&relay=state", relayState)
+		})
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	testIDP := samltestutil.NewTestIDP(t)
+	spKeyPair := samltestutil.NewKeyPair(t, "test-sp")
+	otherSPKeyPair := samltestutil.NewKeyPair(t, "other-test-sp")
+	testIDP.RegisterServiceProvider(downstreamIssuer, acsURL, spKeyPair)
+
+	sessionNotOnOrAfter := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+
+	session := &saml.Session{
+		NameID:   "some-name-id",
+		UserName: "some-username",
+		Groups:   []string{"group1", "group2"},
+	}
+
+	tests := []struct {
+		name              string
+		usernameAttribute string
+		groupsAttribute   string
+		spKeyPair         *samltestutil.KeyPair
+		session           *saml.Session
+		editAssertion     func(*saml.Assertion)
+		editResponse      func(string) string
+		parseRequestID    string
+		wantUser          *upstreamprovider.SAMLUser
+		wantErr           string
+	}{
+		{
+			name:    "uses the NameID as the username by default, without groups",
+			session: session,
+			wantUser: &upstreamprovider.SAMLUser{
+				Username:          "some-name-id",
+				NameID:            "some-name-id",
+				DownstreamSubject: "https://idp.example.com/saml/metadata?idpName=some+idp+display+name&sub=some-name-id",
+			},
+		},
+		{
+			name:              "uses the configured attributes by friendly name",
+			usernameAttribute: "uid",
+			groupsAttribute:   "eduPersonAffiliation",
+			session:           session,
+			wantUser: &upstreamprovider.SAMLUser{
+				Username:          "some-username",
+				Groups:            []string{"group1", "group2"},
+				NameID:            "some-name-id",
+				DownstreamSubject: "https://idp.example.com/saml/metadata?idpName=some+idp+display+name&sub=some-name-id",
+			},
+		},
+		{
+			name:              "uses the configured attributes by name",
+			usernameAttribute: "urn:oid:0.9.2342.19200300.100.1.1",
+			groupsAttribute:   "urn:oid:1.3.6.1.4.1.5923.1.1.1.1",
+			session:           session,
+			wantUser: &upstreamprovider.SAMLUser{
+				Username:          "some-username",
+				Groups:            []string{"group1", "group2"},
+				NameID:            "some-name-id",
+				DownstreamSubject: "https://idp.example.com/saml/metadata?idpName=some+idp+display+name&sub=some-name-id",
+			},
+		},
+		{
+			name:            "the groups attribute is missing",
+			groupsAttribute: "eduPersonAffiliation",
+			session:         &saml.Session{NameID: "some-name-id"},
+			wantUser: &upstreamprovider.SAMLUser{
+				Username:          "some-name-id",
+				NameID:            "some-name-id",
+				DownstreamSubject: "https://idp.example.com/saml/metadata?idpName=some+idp+display+name&sub=some-name-id",
+			},
+		},
+		{
+			name:    "includes the session expiration",
+			session: session,
+			editAssertion: func(assertion *saml.Assertion) {
+				assertion.AuthnStatements[0].SessionNotOnOrAfter = &sessionNotOnOrAfter
+			},
+			wantUser: &upstreamprovider.SAMLUser{
+				Username:            "some-name-id",
+				NameID:              "some-name-id",
+				DownstreamSubject:   "https://idp.example.com/saml/metadata?idpName=some+idp+display+name&sub=some-name-id",
+				SessionNotOnOrAfter: sessionNotOnOrAfter,
+			},
+		},
+		{
+			name:              "the username attribute is missing",
+			usernameAttribute: "uid",
+			session:           &saml.Session{NameID: "some-name-id"},
+			wantErr:           `SAML assertion does not include the configured username attribute "uid"`,
+		},
+		{
+			name:    "the NameID is missing",
+			session: &saml.Session{},
+			wantErr: "SAML assertion does not include a NameID",
+		},
+		{
+			name:           "the response is for a different request",
+			session:        session,
+			parseRequestID: "id-some-other-request-id",
+			wantErr:        "invalid SAMLResponse: `InResponseTo` does not match any of the possible request IDs (expected [id-some-other-request-id])",
+		},
+		{
+			name:      "the assertion cannot be decrypted with our key",
+			session:   session,
+			spKeyPair: otherSPKeyPair,
+			wantErr:   "invalid SAMLResponse: failed to decrypt EncryptedAssertion",
+		},
+		{
+			name:    "the assertion is expired",
+			session: session,
+			editAssertion: func(assertion *saml.Assertion) {
+				assertion.Conditions.NotOnOrAfter = time.Now().Add(-time.Hour)
+			},
+			wantErr: "invalid SAMLResponse: assertion Conditions is expired",
+		},
+		{
+			name:    "the response is not base64",
+			session: session,
+			editResponse: func(string) string {
+				return "not base64!"
+			},
+			wantErr: "could not base64 decode SAMLResponse: illegal base64 data at input byte 3",
+		},
+		{
+			name:    "the response is not signed",
+			session: session,
+			editResponse: func(string) string {
+				return samltestutil.EncodedResponse(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="id-1" InResponseTo="` + requestID + `" Version="2.0"></samlp:Response>`)
+			},
+			wantErr: "invalid SAMLResponse:",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyPair := spKeyPair
+			if test.spKeyPair != nil {
+				keyPair = test.spKeyPair
+			}
+			p := New(ProviderConfig{
+				Name:              "some-name",
+				ResourceUID:       types.UID("some-uid"),
+				IDPMetadata:       testIDP.Metadata(),
+				UsernameAttribute: test.usernameAttribute,
+				GroupsAttribute:   test.groupsAttribute,
+				Key:               keyPair.Key,
+				Certificate:       keyPair.Certificate,
+			})
+
+			// Always sign the AuthnRequest with the registered key, since the test IDP checks the signature.
+			requestingProvider := New(ProviderConfig{IDPMetadata: testIDP.Metadata(), Key: spKeyPair.Key, Certificate: spKeyPair.Certificate})
+			authnRequestURL, err := requestingProvider.AuthnRequestURL(requestID, "some-state", downstreamIssuer, acsURL)
+			require.NoError(t, err)
+			samlResponse, _ := testIDP.Login(authnRequestURL, test.session, test.editAssertion)
+			if test.editResponse != nil {
+				samlResponse = test.editResponse(samlResponse)
+			}
+
+			parseRequestID := requestID
+			if test.parseRequestID != "" {
+				parseRequestID = test.parseRequestID
+			}
+
+			samlUser, err := p.ParseResponse(samlResponse, parseRequestID, downstreamIssuer, acsURL, idpDisplayName)
+			if test.wantErr != "" {
+				require.ErrorContains(t, err, test.wantErr)
+				require.Nil(t, samlUser)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.wantUser, samlUser)
+		})
+	}
+}
+
+func TestGetters(t *testing.T) {
+	testIDP := samltestutil.NewTestIDP(t)
+	p := New(ProviderConfig{
+		Name:        "some-name",
+		ResourceUID: types.UID("some-uid"),
+		IDPMetadata: testIDP.Metadata(),
+	})
+	require.Equal(t, "some-name", p.GetResourceName())
+	require.Equal(t, types.UID("some-uid"), p.GetResourceUID())
+	require.Equal(t, samltestutil.IDPMetadataURL, p.GetIDPEntityID())
+	require.Equal(t, downstreamIssuer, p.GetServiceProviderEntityID(downstreamIssuer))
+
+	p = New(ProviderConfig{EntityID: "urn:some:entity:id"})
+	require.Equal(t, "urn:some:entity:id", p.GetServiceProviderEntityID(downstreamIssuer))
+}
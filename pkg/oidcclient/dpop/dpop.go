@@ -0,0 +1,180 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package dpop implements the client side of OAuth 2.0 Demonstrating Proof of Possession (DPoP), as described by
+// RFC 9449. A client which sends DPoP proofs to a token endpoint receives tokens which are bound to its key, so that
+// stolen tokens cannot be used without also stealing the key.
+package dpop
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/gofrs/flock"
+)
+
+const (
+	// HeaderName is the name of the HTTP request header which holds the DPoP proof.
+	HeaderName = "DPoP"
+
+	// SigningAlgorithm is the algorithm used to sign DPoP proofs.
+	SigningAlgorithm = jose.ES256
+
+	proofType = "dpop+jwt"
+
+	pemBlockType = "PRIVATE KEY"
+
+	// defaultFileLockTimeout is how long we will wait trying to acquire the file lock on the key file before timing out.
+	defaultFileLockTimeout = 10 * time.Second
+
+	// defaultFileLockRetryInterval is how often we will poll while waiting for the file lock to become available.
+	defaultFileLockRetryInterval = 10 * time.Millisecond
+)
+
+// KeyStore provides the private key which signs DPoP proofs. Tokens are bound to the key for their whole lifetime,
+// so implementations should persist the key, for example in a file (see NewFileKeyStore) or in an operating
+// system keyring.
+type KeyStore interface {
+	// GetOrCreateKey returns the stored key, or creates and stores a new key when there is none yet.
+	GetOrCreateKey() (*ecdsa.PrivateKey, error)
+}
+
+// NewFileKeyStore returns a KeyStore which stores the key in a PEM-encoded PKCS #8 file at the specified path.
+// The file is created so that only the current user can read it. An existing file which other users could read
+// is rejected, except on Windows, where file permissions are not represented by the file's mode.
+func NewFileKeyStore(path string) KeyStore {
+	return &fileKeyStore{path: path}
+}
+
+type fileKeyStore struct {
+	path string
+}
+
+var _ KeyStore = (*fileKeyStore)(nil)
+
+func (f *fileKeyStore) GetOrCreateKey() (*ecdsa.PrivateKey, error) {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("could not create DPoP key directory: %w", err)
+	}
+
+	// Lock the key file, so concurrent invocations do not each create a different key.
+	lock := flock.New(f.path + ".lock")
+	ctx, cancel := context.WithTimeout(context.Background(), defaultFileLockTimeout)
+	defer cancel()
+	if _, err := lock.TryLockContext(ctx, defaultFileLockRetryInterval); err != nil {
+		return nil, fmt.Errorf("could not lock DPoP key file: %w", err)
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	key, err := f.read()
+	if errors.Is(err, os.ErrNotExist) {
+		return f.create()
+	}
+	return key, err
+}
+
+func (f *fileKeyStore) read() (*ecdsa.PrivateKey, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("DPoP key file %q must not be accessible by other users (mode %#o)", f.path, info.Mode().Perm())
+	}
+
+	keyPEM, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read DPoP key file: %w", err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != pemBlockType {
+		return nil, fmt.Errorf("DPoP key file %q does not contain a PEM-encoded private key", f.path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse DPoP key file %q: %w", f.path, err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("DPoP key file %q must contain an ECDSA P-256 private key", f.path)
+	}
+	return key, nil
+}
+
+func (f *fileKeyStore) create() (*ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate DPoP key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode DPoP key: %w", err)
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not create DPoP key file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+	if err := pem.Encode(file, &pem.Block{Type: pemBlockType, Bytes: der}); err != nil {
+		return nil, fmt.Errorf("could not write DPoP key file: %w", err)
+	}
+	return key, nil
+}
+
+type proofClaims struct {
+	JTI string           `json:"jti"`
+	HTM string           `json:"htm"`
+	HTU string           `json:"htu"`
+	IAT *jwt.NumericDate `json:"iat"`
+}
+
+// NewProof returns a DPoP proof for an HTTP request with the specified method and URL, signed by the key.
+func NewProof(key *ecdsa.PrivateKey, method string, targetURL string, now time.Time) (string, error) {
+	// The htu claim must not contain the query or fragment of the URL.
+	htu, err := url.Parse(targetURL)
+	if err != nil {
+		return "", fmt.Errorf("could not parse URL for DPoP proof: %w", err)
+	}
+	htu.RawQuery = ""
+	htu.Fragment = ""
+	htu.RawFragment = ""
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("could not generate DPoP proof ID: %w", err)
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: SigningAlgorithm, Key: key},
+		(&jose.SignerOptions{EmbedJWK: true}).WithType(proofType),
+	)
+	if err != nil {
+		return "", fmt.Errorf("could not create DPoP proof signer: %w", err)
+	}
+
+	proof, err := jwt.Signed(signer).Claims(proofClaims{
+		JTI: base64.RawURLEncoding.EncodeToString(jti),
+		HTM: method,
+		HTU: htu.String(),
+		IAT: jwt.NewNumericDate(now),
+	}).Serialize()
+	if err != nil {
+		return "", fmt.Errorf("could not sign DPoP proof: %w", err)
+	}
+	return proof, nil
+}
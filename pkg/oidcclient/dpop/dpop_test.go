@@ -0,0 +1,115 @@
+// Copyright 2024 the Pinniped contributors. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileKeyStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("creates a key and then reuses it", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "subdir", "dpop-key.pem")
+
+		key, err := NewFileKeyStore(path).GetOrCreateKey()
+		require.NoError(t, err)
+		require.Equal(t, elliptic.P256(), key.Curve)
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		dirInfo, err := os.Stat(filepath.Dir(path))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0700), dirInfo.Mode().Perm())
+
+		sameKey, err := NewFileKeyStore(path).GetOrCreateKey()
+		require.NoError(t, err)
+		require.True(t, key.Equal(sameKey))
+	})
+
+	t.Run("rejects a key file which other users can read", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "dpop-key.pem")
+		_, err := NewFileKeyStore(path).GetOrCreateKey()
+		require.NoError(t, err)
+		require.NoError(t, os.Chmod(path, 0644))
+
+		_, err = NewFileKeyStore(path).GetOrCreateKey()
+		require.EqualError(t, err, `DPoP key file "`+path+`" must not be accessible by other users (mode 0644)`)
+	})
+
+	t.Run("rejects a key file which is not PEM", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "dpop-key.pem")
+		require.NoError(t, os.WriteFile(path, []byte("not-pem"), 0600))
+
+		_, err := NewFileKeyStore(path).GetOrCreateKey()
+		require.EqualError(t, err, `DPoP key file "`+path+`" does not contain a PEM-encoded private key`)
+	})
+
+	t.Run("rejects a key file which does not contain an ECDSA P-256 key", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "dpop-key.pem")
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+
+		_, err = NewFileKeyStore(path).GetOrCreateKey()
+		require.EqualError(t, err, `DPoP key file "`+path+`" must contain an ECDSA P-256 private key`)
+	})
+}
+
+func TestNewProof(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	now := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	proof, err := NewProof(key, "POST", "https://issuer.example.com/oauth2/token?query=ignored#fragment", now)
+	require.NoError(t, err)
+
+	jws, err := jose.ParseSigned(proof, []jose.SignatureAlgorithm{jose.ES256})
+	require.NoError(t, err)
+	require.Len(t, jws.Signatures, 1)
+	header := jws.Signatures[0].Protected
+	require.Equal(t, "ES256", header.Algorithm)
+	require.Equal(t, "dpop+jwt", header.ExtraHeaders[jose.HeaderType])
+	require.NotNil(t, header.JSONWebKey)
+	require.True(t, header.JSONWebKey.IsPublic())
+	require.True(t, key.PublicKey.Equal(header.JSONWebKey.Key))
+
+	payload, err := jws.Verify(&key.PublicKey)
+	require.NoError(t, err)
+	var claims map[string]any
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	require.NotEmpty(t, claims["jti"])
+	delete(claims, "jti")
+	require.Equal(t, map[string]any{
+		"htm": "POST",
+		"htu": "https://issuer.example.com/oauth2/token",
+		"iat": float64(now.Unix()),
+	}, claims)
+
+	anotherProof, err := NewProof(key, "POST", "https://issuer.example.com/oauth2/token", now)
+	require.NoError(t, err)
+	require.NotEqual(t, proof, anotherProof, "each proof should have a unique jti")
+}
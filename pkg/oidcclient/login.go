@@ -33,10 +33,12 @@ import (
 	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
 	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
 	"go.pinniped.dev/internal/httputil/httperr"
+	"go.pinniped.dev/internal/httputil/roundtripper"
 	"go.pinniped.dev/internal/httputil/securityheader"
 	"go.pinniped.dev/internal/net/phttp"
 	"go.pinniped.dev/internal/plog"
 	"go.pinniped.dev/internal/upstreamoidc"
+	"go.pinniped.dev/pkg/oidcclient/dpop"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/oidctypes"
 	"go.pinniped.dev/pkg/oidcclient/pkce"
@@ -65,12 +67,17 @@ const (
 
 	usernamePrompt = "Username: "
 	passwordPrompt = "Password: "
+	totpCodePrompt = "One-time password: "
 
 	// For CLI-based auth, such as with LDAP upstream identity providers, the user may use these environment variables
 	// to avoid getting interactively prompted for username and password.
 	defaultUsernameEnvVarName = "PINNIPED_USERNAME"
 	defaultPasswordEnvVarName = "PINNIPED_PASSWORD" //nolint:gosec // this is not a credential
 
+	// When the upstream identity provider requires a one-time password as a second factor, the user may use this
+	// environment variable to avoid getting interactively prompted for it.
+	defaultTOTPCodeEnvVarName = "PINNIPED_TOTP_CODE"
+
 	httpLocationHeaderName = "Location"
 )
 
@@ -98,6 +105,7 @@ type handlerState struct {
 	skipPrintLoginURL            bool
 	requestedAudience            string
 	httpClient                   *http.Client
+	dpopKeyStore                 dpop.KeyStore
 
 	// Parameters of the localhost listener.
 	listenAddr   string
@@ -287,6 +295,17 @@ func WithRequestAudience(audience string) Option {
 	}
 }
 
+// WithDPoPKeyStore causes the login flow to bind the access and refresh tokens which it receives to a key from the
+// key store, using OAuth 2.0 Demonstrating Proof of Possession (DPoP, see RFC 9449). A DPoP proof signed by the key
+// is sent with each request to the issuer's token endpoint, but only when the issuer's OIDC discovery document says
+// that it accepts DPoP proofs signed by ES256. Otherwise, this option has no effect.
+func WithDPoPKeyStore(keyStore dpop.KeyStore) Option {
+	return func(h *handlerState) error {
+		h.dpopKeyStore = keyStore
+		return nil
+	}
+}
+
 // WithCLISendingCredentials causes the login flow to use CLI-based prompts for username and password and causes the
 // call to the Issuer's authorize endpoint to be made directly (no web browser) with the username and password on custom
 // HTTP headers. This is only intended to be used when the issuer is a Pinniped Supervisor and the upstream identity
@@ -682,6 +701,48 @@ func (h *handlerState) cliBasedAuth(authorizeOptions *[]oauth2.AuthCodeOption) (
 	// Now that we have a redirect URL, we can build the authorize URL.
 	authorizeURL := h.oauth2Config.AuthCodeURL(h.state.String(), *authorizeOptions...)
 
+	// Send an authorize request, and parse the authcode from the response.
+	authCode, errorCode, err := h.sendCLIBasedAuthorizeRequest(authorizeURL, localhostAddr, username, password, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// When the identity provider requires a one-time password as a second factor, then the server asks for one
+	// after accepting the username and password. Ask the user for it, or get it from an env var, and try again.
+	if errorCode == oidcapi.AuthorizeTOTPCodeRequiredErrorCode {
+		totpCode, err := h.getTOTPCode()
+		if err != nil {
+			return nil, err
+		}
+		authCode, _, err = h.sendCLIBasedAuthorizeRequest(authorizeURL, localhostAddr, username, password, totpCode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Exchange the authorization code for access, ID, and refresh tokens and perform required
+	// validations on the returned ID token.
+	tokenCtx, tokenCtxCancelFunc := context.WithTimeout(h.ctx, httpRequestTimeout)
+	defer tokenCtxCancelFunc()
+	token, err := h.redeemAuthCode(tokenCtx, authCode)
+	if err != nil {
+		return nil, fmt.Errorf("could not complete authorization code exchange: %w", err)
+	}
+
+	return token, nil
+}
+
+// Send a request to the authorize endpoint, including the user's username and password (and optionally their
+// one-time password) on custom http headers. Returns the authcode from the response when the authorization was
+// successful. Returns the error code when the server asked for a one-time password, so the caller may try again.
+// Otherwise, returns an error.
+func (h *handlerState) sendCLIBasedAuthorizeRequest(
+	authorizeURL string,
+	localhostAddr string,
+	username string,
+	password string,
+	totpCode string,
+) (string, string, error) {
 	// Don't follow redirects automatically because we want to handle redirects here.
 	var sawRedirect bool
 	h.httpClient.CheckRedirect = func(_ *http.Request, _via []*http.Request) error {
@@ -694,36 +755,48 @@ func (h *handlerState) cliBasedAuth(authorizeOptions *[]oauth2.AuthCodeOption) (
 	defer authorizeCtxCancelFunc()
 	authReq, err := http.NewRequestWithContext(authCtx, http.MethodGet, authorizeURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("could not build authorize request: %w", err)
+		return "", "", fmt.Errorf("could not build authorize request: %w", err)
 	}
 	authReq.Header.Set(oidcapi.AuthorizeUsernameHeaderName, username)
 	authReq.Header.Set(oidcapi.AuthorizePasswordHeaderName, password)
+	if totpCode != "" {
+		authReq.Header.Set(oidcapi.AuthorizeTOTPCodeHeaderName, totpCode)
+	}
 	authRes, err := h.httpClient.Do(authReq)
 	if err != nil {
-		return nil, fmt.Errorf("authorization response error: %w", err)
+		return "", "", fmt.Errorf("authorization response error: %w", err)
 	}
 	_ = authRes.Body.Close() // don't need the response body, and okay if it fails to close
 
+	// The Supervisor refuses logins for a while after too many failed logins.
+	if authRes.StatusCode == http.StatusTooManyRequests {
+		if retryAfter := authRes.Header.Get("Retry-After"); retryAfter != "" {
+			return "", "", fmt.Errorf(
+				"error getting authorization: too many failed login attempts, try again in %s seconds", retryAfter)
+		}
+		return "", "", errors.New("error getting authorization: too many failed login attempts, try again later")
+	}
+
 	// A successful authorization always results in a redirect (we are flexible on the exact status code).
 	if !sawRedirect {
-		return nil, fmt.Errorf(
+		return "", "", fmt.Errorf(
 			"error getting authorization: expected to be redirected, but response status was %s", authRes.Status)
 	}
 	rawLocation := authRes.Header.Get(httpLocationHeaderName)
 	location, err := url.Parse(rawLocation)
 	if err != nil {
 		// This shouldn't be possible in practice because httpClient.Do() already parses the Location header.
-		return nil, fmt.Errorf("error getting authorization: could not parse redirect location: %w", err)
+		return "", "", fmt.Errorf("error getting authorization: could not parse redirect location: %w", err)
 	}
 
 	// Check that the redirect was to the expected location.
 	if location.Scheme != "http" || location.Host != localhostAddr || location.Path != h.callbackPath {
-		return nil, fmt.Errorf("error getting authorization: redirected to the wrong location: %s", rawLocation)
+		return "", "", fmt.Errorf("error getting authorization: redirected to the wrong location: %s", rawLocation)
 	}
 
 	// Validate OAuth2 state and fail if it's incorrect (to block CSRF).
 	if err := h.state.Validate(location.Query().Get("state")); err != nil {
-		return nil, fmt.Errorf("missing or invalid state parameter in authorization response: %s", rawLocation)
+		return "", "", fmt.Errorf("missing or invalid state parameter in authorization response: %s", rawLocation)
 	}
 
 	// Get the auth code or return the error from the server.
@@ -731,23 +804,17 @@ func (h *handlerState) cliBasedAuth(authorizeOptions *[]oauth2.AuthCodeOption) (
 	if authCode == "" {
 		// Check for error response parameters. See https://openid.net/specs/openid-connect-core-1_0.html#AuthError.
 		requiredErrorCode := location.Query().Get("error")
+		if requiredErrorCode == oidcapi.AuthorizeTOTPCodeRequiredErrorCode && totpCode == "" {
+			return "", requiredErrorCode, nil
+		}
 		optionalErrorDescription := location.Query().Get("error_description")
 		if optionalErrorDescription == "" {
-			return nil, fmt.Errorf("login failed with code %q", requiredErrorCode)
+			return "", "", fmt.Errorf("login failed with code %q", requiredErrorCode)
 		}
-		return nil, fmt.Errorf("login failed with code %q: %s", requiredErrorCode, optionalErrorDescription)
-	}
-
-	// Exchange the authorization code for access, ID, and refresh tokens and perform required
-	// validations on the returned ID token.
-	tokenCtx, tokenCtxCancelFunc := context.WithTimeout(h.ctx, httpRequestTimeout)
-	defer tokenCtxCancelFunc()
-	token, err := h.redeemAuthCode(tokenCtx, authCode)
-	if err != nil {
-		return nil, fmt.Errorf("could not complete authorization code exchange: %w", err)
+		return "", "", fmt.Errorf("login failed with code %q: %s", requiredErrorCode, optionalErrorDescription)
 	}
 
-	return token, nil
+	return authCode, "", nil
 }
 
 // Prompt for the user's username and password, or read them from env vars if they are available.
@@ -781,6 +848,21 @@ func (h *handlerState) getUsernameAndPassword() (string, string, error) {
 	return username, password, nil
 }
 
+// Prompt for the user's one-time password, or read it from an env var if it is available.
+func (h *handlerState) getTOTPCode() (string, error) {
+	totpCode := h.getEnv(defaultTOTPCodeEnvVarName)
+	if totpCode != "" {
+		h.logger.Info("Pinniped: Read one-time password from environment variable", "name", defaultTOTPCodeEnvVarName)
+		return totpCode, nil
+	}
+
+	totpCode, err := h.promptForValue(h.ctx, totpCodePrompt, h.out)
+	if err != nil {
+		return "", fmt.Errorf("error prompting for one-time password: %w", err)
+	}
+	return totpCode, nil
+}
+
 // Open a web browser, or ask the user to open a web browser, to visit the authorize endpoint.
 // Create a localhost callback listener which exchanges the authcode for tokens. Return the tokens or an error.
 func (h *handlerState) webBrowserBasedAuth(authorizeOptions *[]oauth2.AuthCodeOption) (*oidctypes.Token, error) {
@@ -1004,16 +1086,62 @@ func (h *handlerState) initOIDCDiscovery() error {
 
 	// Use response_mode=form_post if the provider supports it.
 	var discoveryClaims struct {
-		ResponseModesSupported []string `json:"response_modes_supported"`
+		ResponseModesSupported        []string `json:"response_modes_supported"`
+		DPoPSigningAlgValuesSupported []string `json:"dpop_signing_alg_values_supported"`
 	}
 	if err := h.provider.Claims(&discoveryClaims); err != nil {
 		return fmt.Errorf("could not decode response_modes_supported in OIDC discovery from %q: %w", h.issuer, err)
 	}
 	h.useFormPost = slices.Contains(discoveryClaims.ResponseModesSupported, "form_post")
 
+	// Bind the tokens to our DPoP key if the provider supports it.
+	if err := h.maybeUseDPoP(discoveryClaims.DPoPSigningAlgValuesSupported); err != nil {
+		return err
+	}
+
 	return h.maybePerformPinnipedSupervisorIDPDiscovery()
 }
 
+func (h *handlerState) maybeUseDPoP(supportedSigningAlgs []string) error {
+	if h.dpopKeyStore == nil || !slices.Contains(supportedSigningAlgs, string(dpop.SigningAlgorithm)) {
+		return nil
+	}
+
+	key, err := h.dpopKeyStore.GetOrCreateKey()
+	if err != nil {
+		return fmt.Errorf("could not get DPoP key: %w", err)
+	}
+
+	tokenURL, err := url.Parse(h.provider.Endpoint().TokenURL)
+	if err != nil {
+		return fmt.Errorf("could not parse discovered token URL from issuer: %w", err)
+	}
+
+	// Add a DPoP proof to every request to the token endpoint, which covers the authcode exchange, refreshes, and
+	// RFC8693 token exchanges. Wrap the transport of a copy of the client, so the client which was provided by
+	// the caller is never changed, and point h.ctx at the copy so the oauth2 library will also use it.
+	dpopClient := *h.httpClient
+	rt := dpopClient.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	dpopClient.Transport = roundtripper.WrapFunc(rt, func(req *http.Request) (*http.Response, error) {
+		if req.Method != http.MethodPost || req.URL.Scheme != tokenURL.Scheme || req.URL.Host != tokenURL.Host || req.URL.Path != tokenURL.Path {
+			return rt.RoundTrip(req)
+		}
+		proof, err := dpop.NewProof(key, req.Method, tokenURL.String(), time.Now())
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context()) // round trippers must not modify the original request
+		req.Header.Set(dpop.HeaderName, proof)
+		return rt.RoundTrip(req)
+	})
+	h.httpClient = &dpopClient
+	h.ctx = coreosoidc.ClientContext(h.ctx, h.httpClient)
+	return nil
+}
+
 func (h *handlerState) maybePerformPinnipedSupervisorIDPDiscovery() error {
 	// If this OIDC IDP is a Pinniped Supervisor, it will have a reference to the IDP discovery document.
 	// Go to that document and retrieve the IDPs.
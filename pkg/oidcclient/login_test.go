@@ -6,6 +6,7 @@ package oidcclient
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
@@ -16,6 +17,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -32,6 +34,7 @@ import (
 
 	idpdiscoveryv1alpha1 "go.pinniped.dev/generated/latest/apis/supervisor/idpdiscovery/v1alpha1"
 	oidcapi "go.pinniped.dev/generated/latest/apis/supervisor/oidc"
+	serverdpop "go.pinniped.dev/internal/federationdomain/dpop"
 	"go.pinniped.dev/internal/federationdomain/endpoints/discovery"
 	federationdomainoidc "go.pinniped.dev/internal/federationdomain/oidc"
 	"go.pinniped.dev/internal/federationdomain/upstreamprovider"
@@ -44,6 +47,7 @@ import (
 	"go.pinniped.dev/internal/testutil/testlogger"
 	"go.pinniped.dev/internal/testutil/tlsserver"
 	"go.pinniped.dev/internal/upstreamoidc"
+	"go.pinniped.dev/pkg/oidcclient/dpop"
 	"go.pinniped.dev/pkg/oidcclient/nonce"
 	"go.pinniped.dev/pkg/oidcclient/oidctypes"
 	"go.pinniped.dev/pkg/oidcclient/pkce"
@@ -71,6 +75,16 @@ func (m *mockSessionCache) PutToken(key SessionCacheKey, token *oidctypes.Token)
 	m.sawPutTokens = append(m.sawPutTokens, token)
 }
 
+// mockDPoPKeyStore returns an error from GetOrCreateKey and counts how many times it was called.
+type mockDPoPKeyStore struct {
+	calls int
+}
+
+func (m *mockDPoPKeyStore) GetOrCreateKey() (*ecdsa.PrivateKey, error) {
+	m.calls++
+	return nil, fmt.Errorf("some key store error")
+}
+
 func buildHTTPClientForPEM(pemData []byte) *http.Client {
 	pool := x509.NewCertPool()
 	pool.AppendCertsFromPEM(pemData)
@@ -258,7 +272,7 @@ func TestLogin(t *testing.T) { //nolint:gocyclo
 		_, _ = fmt.Fprint(w, "not real json")
 	})
 
-	discoveryHandler := func(server *httptest.Server, responseModes []string) http.HandlerFunc {
+	discoveryHandler := func(server *httptest.Server, responseModes []string, dpopSigningAlgs []string) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			if r.Method != http.MethodGet {
 				http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
@@ -271,6 +285,7 @@ func TestLogin(t *testing.T) { //nolint:gocyclo
 				TokenURL               string                                                `json:"token_endpoint"`
 				JWKSURL                string                                                `json:"jwks_uri"`
 				ResponseModesSupported []string                                              `json:"response_modes_supported,omitempty"`
+				DPoPSigningAlgs        []string                                              `json:"dpop_signing_alg_values_supported,omitempty"`
 				SupervisorDiscovery    idpdiscoveryv1alpha1.OIDCDiscoveryResponseIDPEndpoint `json:"discovery.supervisor.pinniped.dev/v1alpha1"`
 			}{
 				Issuer:                 server.URL,
@@ -278,6 +293,7 @@ func TestLogin(t *testing.T) { //nolint:gocyclo
 				TokenURL:               server.URL + "/token",
 				JWKSURL:                server.URL + "/keys",
 				ResponseModesSupported: responseModes,
+				DPoPSigningAlgs:        dpopSigningAlgs,
 				SupervisorDiscovery: idpdiscoveryv1alpha1.OIDCDiscoveryResponseIDPEndpoint{
 					PinnipedIDPsEndpoint: server.URL + federationdomainoidc.PinnipedIDPsPathV1Alpha1,
 				},
@@ -405,17 +421,32 @@ func TestLogin(t *testing.T) { //nolint:gocyclo
 	// Start a test server that returns a real discovery document and answers refresh requests.
 	providerMux := http.NewServeMux()
 	successServer, successServerCA := tlsserver.TestServerIPv4(t, providerMux, nil)
-	providerMux.HandleFunc("/.well-known/openid-configuration", discoveryHandler(successServer, nil))
+	providerMux.HandleFunc("/.well-known/openid-configuration", discoveryHandler(successServer, nil, nil))
 	providerMux.HandleFunc(federationdomainoidc.PinnipedIDPsPathV1Alpha1, idpDiscoveryHandler(successServer))
 	providerMux.HandleFunc("/token", tokenHandler)
 
 	// Start a test server that returns a real discovery document and answers refresh requests, _and_ supports form_mode=post.
 	formPostProviderMux := http.NewServeMux()
 	formPostSuccessServer, formPostSuccessServerCA := tlsserver.TestServerIPv4(t, formPostProviderMux, nil)
-	formPostProviderMux.HandleFunc("/.well-known/openid-configuration", discoveryHandler(formPostSuccessServer, []string{"query", "form_post"}))
+	formPostProviderMux.HandleFunc("/.well-known/openid-configuration", discoveryHandler(formPostSuccessServer, []string{"query", "form_post"}, nil))
 	formPostProviderMux.HandleFunc(federationdomainoidc.PinnipedIDPsPathV1Alpha1, idpDiscoveryHandler(formPostSuccessServer))
 	formPostProviderMux.HandleFunc("/token", tokenHandler)
 
+	// Start a test server that returns a real discovery document and answers refresh requests, _and_ requires DPoP proofs.
+	dpopProviderMux := http.NewServeMux()
+	dpopReplays := serverdpop.NewReplayCache()
+	dpopSuccessServer, dpopSuccessServerCA := tlsserver.TestServerIPv4(t, dpopProviderMux, nil)
+	dpopProviderMux.HandleFunc("/.well-known/openid-configuration", discoveryHandler(dpopSuccessServer, nil, []string{"RS256", "ES256"}))
+	dpopProviderMux.HandleFunc(federationdomainoidc.PinnipedIDPsPathV1Alpha1, idpDiscoveryHandler(dpopSuccessServer))
+	dpopProviderMux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		thumbprint, err := serverdpop.ValidateProof(r, dpopSuccessServer.URL+"/token", time.Now(), dpopReplays)
+		if err != nil || thumbprint == "" {
+			http.Error(w, "expected a valid DPoP proof", http.StatusBadRequest)
+			return
+		}
+		tokenHandler(w, r)
+	})
+
 	// TODO: Use a test server without federationdomainoidc.PinnipedIDPsPathV1Alpha1 (e.g. a non-Supervisor server)
 
 	defaultDiscoveryResponse := func(req *http.Request) (*http.Response, error) {
@@ -704,6 +735,56 @@ func TestLogin(t *testing.T) { //nolint:gocyclo
 			},
 			wantToken: &testToken,
 		},
+		{
+			name:     "without request audience, session cache hit with expired ID token which is refreshable using a DPoP proof",
+			issuer:   dpopSuccessServer.URL,
+			clientID: "test-client-id",
+			opt: func(t *testing.T) Option {
+				return func(h *handlerState) error {
+					client := buildHTTPClientForPEM(dpopSuccessServerCA)
+					originalTransport := client.Transport
+					t.Cleanup(func() {
+						// The client provided by the caller should not be changed to add DPoP proofs.
+						require.Same(t, originalTransport, client.Transport)
+					})
+					require.NoError(t, WithClient(client)(h))
+					require.NoError(t, WithDPoPKeyStore(dpop.NewFileKeyStore(filepath.Join(t.TempDir(), "dpop-key.pem")))(h))
+
+					h.getProvider = func(config *oauth2.Config, provider *coreosoidc.Provider, client *http.Client) upstreamprovider.UpstreamOIDCIdentityProviderI {
+						mock := mockUpstream(t)
+						mock.EXPECT().
+							ValidateTokenAndMergeWithUserInfo(gomock.Any(), HasAccessToken(testToken.AccessToken.Token), nonce.Nonce(""), true, false).
+							Return(&testToken, nil)
+						mock.EXPECT().
+							PerformRefresh(gomock.Any(), testToken.RefreshToken.Token).
+							DoAndReturn(func(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+								// Call the real production code to perform a refresh.
+								return upstreamoidc.New(config, provider, client).PerformRefresh(ctx, refreshToken)
+							})
+						return mock
+					}
+
+					cache := &mockSessionCache{t: t, getReturnsToken: &oidctypes.Token{
+						IDToken: &oidctypes.IDToken{
+							Token:  "expired-test-id-token",
+							Expiry: metav1.NewTime(time.Now().Add(9 * time.Minute)), // less than Now() + minIDTokenValidity
+						},
+						RefreshToken: &oidctypes.RefreshToken{Token: "test-refresh-token"},
+					}}
+					t.Cleanup(func() {
+						require.Len(t, cache.sawPutTokens, 1)
+						require.Equal(t, testToken.IDToken.Token, cache.sawPutTokens[0].IDToken.Token)
+					})
+					h.cache = cache
+					return nil
+				}
+			},
+			wantLogs: []string{
+				`"level"=4 "msg"="Pinniped: Performing OIDC discovery"  "issuer"="` + dpopSuccessServer.URL + `"`,
+				`"level"=4 "msg"="Pinniped: Refreshing cached tokens."`,
+			},
+			wantToken: &testToken,
+		},
 		{
 			name:     "session cache hit but refresh returns invalid token",
 			issuer:   successServer.URL,
@@ -1576,6 +1657,23 @@ func TestLogin(t *testing.T) { //nolint:gocyclo
 			wantStdErr: "^\nLog in to upstream-idp-name-with-cli-password-flow-first\n\n$",
 			wantErr:    `error getting authorization: expected to be redirected, but response status was 502 Bad Gateway`,
 		},
+		{
+			name:     "ldap login when the OIDC provider authorization endpoint refuses the login after too many failed logins",
+			clientID: "test-client-id",
+			opt: func(t *testing.T) Option {
+				return func(h *handlerState) error {
+					return defaultLDAPTestOpts(t, h, &http.Response{
+						StatusCode: http.StatusTooManyRequests,
+						Status:     "429 Too Many Requests",
+						Header:     http.Header{"Retry-After": {"60"}},
+					}, nil)
+				}
+			},
+			issuer:     successServer.URL,
+			wantLogs:   []string{`"level"=4 "msg"="Pinniped: Performing OIDC discovery"  "issuer"="` + successServer.URL + `"`},
+			wantStdErr: "^\nLog in to upstream-idp-name-with-cli-password-flow-first\n\n$",
+			wantErr:    `error getting authorization: too many failed login attempts, try again in 60 seconds`,
+		},
 		{
 			name:     "ldap login when the OIDC provider authorization endpoint redirect has an error and error description",
 			clientID: "test-client-id",
@@ -1775,6 +1873,186 @@ func TestLogin(t *testing.T) { //nolint:gocyclo
 			wantStdErr: "^\nLog in to upstream-idp-name-with-cli-password-flow-first\n\n$",
 			wantToken:  &testToken,
 		},
+		{
+			name:     "successful ldap login with prompts for username, password, and one-time password",
+			clientID: "test-client-id",
+			opt: func(t *testing.T) Option {
+				return func(h *handlerState) error {
+					fakeAuthCode := "test-authcode-value"
+
+					h.getProvider = func(_ *oauth2.Config, _ *coreosoidc.Provider, _ *http.Client) upstreamprovider.UpstreamOIDCIdentityProviderI {
+						mock := mockUpstream(t)
+						mock.EXPECT().
+							ExchangeAuthcodeAndValidateTokens(
+								gomock.Any(), fakeAuthCode, pkce.Code("test-pkce"), nonce.Nonce("test-nonce"), "http://127.0.0.1:0/callback").
+							Return(&testToken, nil)
+						return mock
+					}
+
+					h.generateState = func() (state.State, error) { return "test-state", nil }
+					h.generatePKCE = func() (pkce.Code, error) { return "test-pkce", nil }
+					h.generateNonce = func() (nonce.Nonce, error) { return "test-nonce", nil }
+					h.getEnv = func(key string) string {
+						return "" // asking for any env var returns empty as if it were unset
+					}
+					h.promptForValue = func(_ context.Context, promptLabel string, _ io.Writer) (string, error) {
+						switch promptLabel {
+						case "Username: ":
+							return "some-upstream-username", nil
+						case "One-time password: ":
+							return "123456", nil
+						default:
+							require.FailNow(t, fmt.Sprintf("unexpected prompt: %s", promptLabel))
+							return "", nil
+						}
+					}
+					h.promptForSecret = func(promptLabel string, _ io.Writer) (string, error) {
+						require.Equal(t, "Password: ", promptLabel)
+						return "some-upstream-password", nil
+					}
+
+					require.NoError(t, WithLoginFlow(idpdiscoveryv1alpha1.IDPFlowCLIPassword, "flowSource")(h))
+					require.NoError(t, WithUpstreamIdentityProvider("upstream-idp-name-with-cli-password-flow-first", "upstream-idp-type-with-cli-password-flow-first")(h))
+
+					authorizeRequestCount := 0
+					t.Cleanup(func() {
+						require.Equal(t, 2, authorizeRequestCount, "should have made two authorize requests")
+					})
+
+					client := buildHTTPClientForPEM(successServerCA)
+					client.Transport = roundtripper.Func(func(req *http.Request) (*http.Response, error) {
+						switch req.URL.Scheme + "://" + req.URL.Host + req.URL.Path {
+						case "https://" + successServer.Listener.Addr().String() + "/.well-known/openid-configuration":
+							return defaultDiscoveryResponse(req)
+						case "https://" + successServer.Listener.Addr().String() + federationdomainoidc.PinnipedIDPsPathV1Alpha1:
+							return defaultDiscoveryResponse(req)
+						case "https://" + successServer.Listener.Addr().String() + "/authorize":
+							authorizeRequestCount++
+							require.Equal(t, "some-upstream-username", req.Header.Get("Pinniped-Username"))
+							require.Equal(t, "some-upstream-password", req.Header.Get("Pinniped-Password"))
+							if authorizeRequestCount == 1 {
+								require.Empty(t, req.Header.Get("Pinniped-TOTP-Code"))
+								return &http.Response{
+									StatusCode: http.StatusFound,
+									Header: http.Header{"Location": []string{
+										"http://127.0.0.1:0/callback?error=interaction_required&error_description=some-description&state=test-state",
+									}},
+								}, nil
+							}
+							require.Equal(t, "123456", req.Header.Get("Pinniped-TOTP-Code"))
+							return &http.Response{
+								StatusCode: http.StatusFound,
+								Header: http.Header{"Location": []string{
+									fmt.Sprintf("http://127.0.0.1:0/callback?code=%s&state=test-state", fakeAuthCode),
+								}},
+							}, nil
+						default:
+							// Note that "/token" requests should not be made. They are mocked by mocking calls to ExchangeAuthcodeAndValidateTokens().
+							require.FailNow(t, fmt.Sprintf("saw unexpected http call from the CLI: %s", req.URL.String()))
+							return nil, nil
+						}
+					})
+					require.NoError(t, WithClient(client)(h))
+					return nil
+				}
+			},
+			issuer: successServer.URL,
+			wantLogs: []string{
+				`"level"=4 "msg"="Pinniped: Performing OIDC discovery"  "issuer"="` + successServer.URL + `"`,
+			},
+			wantStdErr: "^\nLog in to upstream-idp-name-with-cli-password-flow-first\n\n$",
+			wantToken:  &testToken,
+		},
+		{
+			name:     "ldap login with env var for one-time password when the one-time password is not accepted",
+			clientID: "test-client-id",
+			opt: func(t *testing.T) Option {
+				return func(h *handlerState) error {
+					fakeAuthCode := "test-authcode-value"
+
+					h.getProvider = func(_ *oauth2.Config, _ *coreosoidc.Provider, _ *http.Client) upstreamprovider.UpstreamOIDCIdentityProviderI {
+						mock := mockUpstream(t)
+						_ = fakeAuthCode
+						return mock
+					}
+
+					h.generateState = func() (state.State, error) { return "test-state", nil }
+					h.generatePKCE = func() (pkce.Code, error) { return "test-pkce", nil }
+					h.generateNonce = func() (nonce.Nonce, error) { return "test-nonce", nil }
+					h.getEnv = func(key string) string {
+						if key == "PINNIPED_TOTP_CODE" {
+							return "123456"
+						}
+						return ""
+					}
+					h.promptForValue = func(_ context.Context, promptLabel string, _ io.Writer) (string, error) {
+						switch promptLabel {
+						case "Username: ":
+							return "some-upstream-username", nil
+
+						default:
+							require.FailNow(t, fmt.Sprintf("unexpected prompt: %s", promptLabel))
+							return "", nil
+						}
+					}
+					h.promptForSecret = func(promptLabel string, _ io.Writer) (string, error) {
+						require.Equal(t, "Password: ", promptLabel)
+						return "some-upstream-password", nil
+					}
+
+					require.NoError(t, WithLoginFlow(idpdiscoveryv1alpha1.IDPFlowCLIPassword, "flowSource")(h))
+					require.NoError(t, WithUpstreamIdentityProvider("upstream-idp-name-with-cli-password-flow-first", "upstream-idp-type-with-cli-password-flow-first")(h))
+
+					authorizeRequestCount := 0
+					t.Cleanup(func() {
+						require.Equal(t, 2, authorizeRequestCount, "should have made two authorize requests")
+					})
+
+					client := buildHTTPClientForPEM(successServerCA)
+					client.Transport = roundtripper.Func(func(req *http.Request) (*http.Response, error) {
+						switch req.URL.Scheme + "://" + req.URL.Host + req.URL.Path {
+						case "https://" + successServer.Listener.Addr().String() + "/.well-known/openid-configuration":
+							return defaultDiscoveryResponse(req)
+						case "https://" + successServer.Listener.Addr().String() + federationdomainoidc.PinnipedIDPsPathV1Alpha1:
+							return defaultDiscoveryResponse(req)
+						case "https://" + successServer.Listener.Addr().String() + "/authorize":
+							authorizeRequestCount++
+							require.Equal(t, "some-upstream-username", req.Header.Get("Pinniped-Username"))
+							require.Equal(t, "some-upstream-password", req.Header.Get("Pinniped-Password"))
+							if authorizeRequestCount == 1 {
+								require.Empty(t, req.Header.Get("Pinniped-TOTP-Code"))
+								return &http.Response{
+									StatusCode: http.StatusFound,
+									Header: http.Header{"Location": []string{
+										"http://127.0.0.1:0/callback?error=interaction_required&error_description=some-description&state=test-state",
+									}},
+								}, nil
+							}
+							require.Equal(t, "123456", req.Header.Get("Pinniped-TOTP-Code"))
+							return &http.Response{
+								StatusCode: http.StatusFound,
+								Header: http.Header{"Location": []string{
+									"http://127.0.0.1:0/callback?error=access_denied&error_description=One-time+password+not+accepted.&state=test-state",
+								}},
+							}, nil
+						default:
+							// Note that "/token" requests should not be made. They are mocked by mocking calls to ExchangeAuthcodeAndValidateTokens().
+							require.FailNow(t, fmt.Sprintf("saw unexpected http call from the CLI: %s", req.URL.String()))
+							return nil, nil
+						}
+					})
+					require.NoError(t, WithClient(client)(h))
+					return nil
+				}
+			},
+			issuer: successServer.URL,
+			wantLogs: []string{
+				`"level"=4 "msg"="Pinniped: Performing OIDC discovery"  "issuer"="` + successServer.URL + `"`,
+				`"level"=4 "msg"="Pinniped: Read one-time password from environment variable"  "name"="PINNIPED_TOTP_CODE"`,
+			},
+			wantStdErr: "^\nLog in to upstream-idp-name-with-cli-password-flow-first\n\n$",
+			wantErr:    `login failed with code "access_denied": One-time password not accepted.`,
+		},
 		{
 			name:     "unable to login with unknown IDP, when Supervisor provides its supported IDP types",
 			clientID: "test-client-id",
@@ -2619,6 +2897,94 @@ func TestLogin(t *testing.T) { //nolint:gocyclo
 			},
 			wantToken: &testExchangedToken,
 		},
+		{
+			name:     "with requested audience, session cache hit with valid access token, and token exchange request with a DPoP proof succeeds",
+			issuer:   dpopSuccessServer.URL,
+			clientID: "test-client-id",
+			opt: func(t *testing.T) Option {
+				return func(h *handlerState) error {
+					cache := &mockSessionCache{t: t, getReturnsToken: &testToken}
+					t.Cleanup(func() {
+						require.Empty(t, cache.sawPutTokens)
+					})
+					require.NoError(t, WithClient(buildHTTPClientForPEM(dpopSuccessServerCA))(h))
+					require.NoError(t, WithSessionCache(cache)(h))
+					require.NoError(t, WithRequestAudience("test-audience")(h))
+					require.NoError(t, WithDPoPKeyStore(dpop.NewFileKeyStore(filepath.Join(t.TempDir(), "dpop-key.pem")))(h))
+
+					h.validateIDToken = func(ctx context.Context, provider *coreosoidc.Provider, audience string, token string) (*coreosoidc.IDToken, error) {
+						require.Equal(t, "test-audience", audience)
+						require.Equal(t, "test-id-token-with-requested-audience", token)
+						return &coreosoidc.IDToken{Expiry: testExchangedToken.IDToken.Expiry.Time}, nil
+					}
+					return nil
+				}
+			},
+			wantLogs: []string{
+				`"level"=4 "msg"="Pinniped: Found unexpired cached token."  "type"="access_token"`,
+				`"level"=4 "msg"="Pinniped: Performing RFC8693 token exchange"  "requestedAudience"="test-audience"`,
+				`"level"=4 "msg"="Pinniped: Performing OIDC discovery"  "issuer"="` + dpopSuccessServer.URL + `"`,
+			},
+			wantToken: &testExchangedToken,
+		},
+		{
+			name:     "with requested audience, session cache hit with valid access token, but DPoP key store fails",
+			issuer:   dpopSuccessServer.URL,
+			clientID: "test-client-id",
+			opt: func(t *testing.T) Option {
+				return func(h *handlerState) error {
+					keyStore := &mockDPoPKeyStore{}
+					cache := &mockSessionCache{t: t, getReturnsToken: &testToken}
+					t.Cleanup(func() {
+						require.Empty(t, cache.sawPutTokens)
+						require.Equal(t, 1, keyStore.calls)
+					})
+					require.NoError(t, WithClient(buildHTTPClientForPEM(dpopSuccessServerCA))(h))
+					require.NoError(t, WithSessionCache(cache)(h))
+					require.NoError(t, WithRequestAudience("test-audience")(h))
+					require.NoError(t, WithDPoPKeyStore(keyStore)(h))
+					return nil
+				}
+			},
+			wantLogs: []string{
+				`"level"=4 "msg"="Pinniped: Found unexpired cached token."  "type"="access_token"`,
+				`"level"=4 "msg"="Pinniped: Performing RFC8693 token exchange"  "requestedAudience"="test-audience"`,
+				`"level"=4 "msg"="Pinniped: Performing OIDC discovery"  "issuer"="` + dpopSuccessServer.URL + `"`,
+			},
+			wantErr: `failed to exchange token: could not get DPoP key: some key store error`,
+		},
+		{
+			name:     "with requested audience, session cache hit with valid access token, and issuer does not support DPoP, so the DPoP key store is not used",
+			issuer:   successServer.URL,
+			clientID: "test-client-id",
+			opt: func(t *testing.T) Option {
+				return func(h *handlerState) error {
+					keyStore := &mockDPoPKeyStore{}
+					cache := &mockSessionCache{t: t, getReturnsToken: &testToken}
+					t.Cleanup(func() {
+						require.Empty(t, cache.sawPutTokens)
+						require.Zero(t, keyStore.calls)
+					})
+					require.NoError(t, WithClient(buildHTTPClientForPEM(successServerCA))(h))
+					require.NoError(t, WithSessionCache(cache)(h))
+					require.NoError(t, WithRequestAudience("test-audience")(h))
+					require.NoError(t, WithDPoPKeyStore(keyStore)(h))
+
+					h.validateIDToken = func(ctx context.Context, provider *coreosoidc.Provider, audience string, token string) (*coreosoidc.IDToken, error) {
+						require.Equal(t, "test-audience", audience)
+						require.Equal(t, "test-id-token-with-requested-audience", token)
+						return &coreosoidc.IDToken{Expiry: testExchangedToken.IDToken.Expiry.Time}, nil
+					}
+					return nil
+				}
+			},
+			wantLogs: []string{
+				`"level"=4 "msg"="Pinniped: Found unexpired cached token."  "type"="access_token"`,
+				`"level"=4 "msg"="Pinniped: Performing RFC8693 token exchange"  "requestedAudience"="test-audience"`,
+				`"level"=4 "msg"="Pinniped: Performing OIDC discovery"  "issuer"="` + successServer.URL + `"`,
+			},
+			wantToken: &testExchangedToken,
+		},
 		{
 			name:     "with requested audience, session cache hit with valid access token, and valid ID token already has the requested audience, returns cached tokens without any exchange or refresh",
 			issuer:   successServer.URL,
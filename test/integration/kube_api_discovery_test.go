@@ -262,6 +262,48 @@ func TestGetAPIResourceList(t *testing.T) { //nolint:gocyclo // each t.Run is pr
 						Kind:       "GitHubIdentityProvider",
 						Verbs:      []string{"get", "patch", "update"},
 					},
+					{
+						Name:         "gitlabidentityproviders",
+						SingularName: "gitlabidentityprovider",
+						Namespaced:   true,
+						Kind:         "GitLabIdentityProvider",
+						Verbs:        []string{"delete", "deletecollection", "get", "list", "patch", "create", "update", "watch"},
+						Categories:   []string{"pinniped", "pinniped-idp", "pinniped-idps"},
+					},
+					{
+						Name:       "gitlabidentityproviders/status",
+						Namespaced: true,
+						Kind:       "GitLabIdentityProvider",
+						Verbs:      []string{"get", "patch", "update"},
+					},
+					{
+						Name:         "oauth2identityproviders",
+						SingularName: "oauth2identityprovider",
+						Namespaced:   true,
+						Kind:         "OAuth2IdentityProvider",
+						Verbs:        []string{"delete", "deletecollection", "get", "list", "patch", "create", "update", "watch"},
+						Categories:   []string{"pinniped", "pinniped-idp", "pinniped-idps"},
+					},
+					{
+						Name:       "oauth2identityproviders/status",
+						Namespaced: true,
+						Kind:       "OAuth2IdentityProvider",
+						Verbs:      []string{"get", "patch", "update"},
+					},
+					{
+						Name:         "samlidentityproviders",
+						SingularName: "samlidentityprovider",
+						Namespaced:   true,
+						Kind:         "SAMLIdentityProvider",
+						Verbs:        []string{"delete", "deletecollection", "get", "list", "patch", "create", "update", "watch"},
+						Categories:   []string{"pinniped", "pinniped-idp", "pinniped-idps"},
+					},
+					{
+						Name:       "samlidentityproviders/status",
+						Namespaced: true,
+						Kind:       "SAMLIdentityProvider",
+						Verbs:      []string{"get", "patch", "update"},
+					},
 				},
 			},
 		},
@@ -599,6 +641,27 @@ func TestCRDAdditionalPrinterColumns_Parallel(t *testing.T) {
 				{Name: "Age", Type: "date", JSONPath: ".metadata.creationTimestamp"},
 			},
 		},
+		addSuffix("gitlabidentityproviders.idp.supervisor"): {
+			"v1alpha1": []apiextensionsv1.CustomResourceColumnDefinition{
+				{Name: "Host", Type: "string", JSONPath: ".spec.gitlabAPI.host"},
+				{Name: "Status", Type: "string", JSONPath: ".status.phase"},
+				{Name: "Age", Type: "date", JSONPath: ".metadata.creationTimestamp"},
+			},
+		},
+		addSuffix("oauth2identityproviders.idp.supervisor"): {
+			"v1alpha1": []apiextensionsv1.CustomResourceColumnDefinition{
+				{Name: "Authorization Endpoint", Type: "string", JSONPath: ".spec.authorizationEndpoint"},
+				{Name: "Status", Type: "string", JSONPath: ".status.phase"},
+				{Name: "Age", Type: "date", JSONPath: ".metadata.creationTimestamp"},
+			},
+		},
+		addSuffix("samlidentityproviders.idp.supervisor"): {
+			"v1alpha1": []apiextensionsv1.CustomResourceColumnDefinition{
+				{Name: "Metadata URL", Type: "string", JSONPath: ".spec.idpMetadata.url"},
+				{Name: "Status", Type: "string", JSONPath: ".status.phase"},
+				{Name: "Age", Type: "date", JSONPath: ".metadata.creationTimestamp"},
+			},
+		},
 		addSuffix("oidcclients.config.supervisor"): {
 			"v1alpha1": []apiextensionsv1.CustomResourceColumnDefinition{
 				{Name: "Privileged Scopes", Type: "string", JSONPath: `.spec.allowedScopes[?(@ == "pinniped:request-audience")]`},
@@ -615,10 +678,13 @@ func TestCRDAdditionalPrinterColumns_Parallel(t *testing.T) {
 		"credentialissuers.config.concierge." + env.APIGroupSuffix,
 		"federationdomains.config.supervisor." + env.APIGroupSuffix,
 		"githubidentityproviders.idp.supervisor." + env.APIGroupSuffix,
+		"gitlabidentityproviders.idp.supervisor." + env.APIGroupSuffix,
 		"jwtauthenticators.authentication.concierge." + env.APIGroupSuffix,
 		"ldapidentityproviders.idp.supervisor." + env.APIGroupSuffix,
+		"oauth2identityproviders.idp.supervisor." + env.APIGroupSuffix,
 		"oidcclients.config.supervisor." + env.APIGroupSuffix,
 		"oidcidentityproviders.idp.supervisor." + env.APIGroupSuffix,
+		"samlidentityproviders.idp.supervisor." + env.APIGroupSuffix,
 		"webhookauthenticators.authentication.concierge." + env.APIGroupSuffix,
 	}
 
@@ -547,9 +547,14 @@ func requireWellKnownEndpointIsWorking(t *testing.T, supervisorScheme, superviso
       "claims_supported": ["username", "groups", "additionalClaims"],
       "discovery.supervisor.pinniped.dev/v1alpha1": {"pinniped_identity_providers_endpoint": "%s/v1alpha1/pinniped_identity_providers"},
       "subject_types_supported": ["public"],
-      "id_token_signing_alg_values_supported": ["ES256"]
+      "id_token_signing_alg_values_supported": ["ES256"],
+      "pushed_authorization_request_endpoint": "%s/oauth2/par",
+      "require_pushed_authorization_requests": false,
+      "request_parameter_supported": true,
+      "request_object_signing_alg_values_supported": ["RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"],
+      "dpop_signing_alg_values_supported": ["RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"]
     }`)
-	expectedJSON := fmt.Sprintf(expectedResultTemplate, issuerName, issuerName, issuerName, issuerName, issuerName)
+	expectedJSON := fmt.Sprintf(expectedResultTemplate, issuerName, issuerName, issuerName, issuerName, issuerName, issuerName)
 
 	require.Equal(t, "application/json", response.Header.Get("content-type"))
 	require.JSONEq(t, expectedJSON, responseBody)
@@ -675,6 +680,7 @@ func withAllSuccessfulConditions() map[string]metav1.ConditionStatus {
 		"IdentityProvidersObjectRefKindValid":           metav1.ConditionTrue,
 		"IdentityProvidersObjectRefAPIGroupSuffixValid": metav1.ConditionTrue,
 		"IdentityProvidersDisplayNamesUnique":           metav1.ConditionTrue,
+		"IdentityProvidersGroupEnrichmentValid":         metav1.ConditionTrue,
 		"TransformsExpressionsValid":                    metav1.ConditionTrue,
 		"TransformsExamplesPassed":                      metav1.ConditionTrue,
 	}
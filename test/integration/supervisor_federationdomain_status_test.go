@@ -989,6 +989,10 @@ func allSuccessfulFederationDomainConditions(federationDomainSpec supervisorconf
 			Type: "IdentityProvidersFound", Status: "True", Reason: "Success",
 			Message: "the resources specified by .spec.identityProviders[].objectRef were found",
 		},
+		{
+			Type: "IdentityProvidersGroupEnrichmentValid", Status: "True", Reason: "Success",
+			Message: "the group enrichment configurations specified by .spec.identityProviders[].groupEnrichment are valid",
+		},
 		{
 			Type: "IdentityProvidersObjectRefAPIGroupSuffixValid", Status: "True", Reason: "Success",
 			Message: "the API groups specified by .spec.identityProviders[].objectRef.apiGroup are recognized",
@@ -590,12 +590,24 @@ func TestOIDCClientControllerValidations_Parallel(t *testing.T) {
 			},
 			wantPhase: "Error",
 			wantConditions: []metav1.Condition{
+				{
+					Type:    "AdmissionPolicyValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"admissionPolicy" is valid`,
+				},
 				{
 					Type:    "AllowedGrantTypesValid",
 					Status:  "False",
 					Reason:  "MissingRequiredValue",
 					Message: `"authorization_code" must always be included in "allowedGrantTypes"`,
 				},
+				{
+					Type:    "AllowedIdentityProvidersValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"allowedIdentityProviders" is valid`,
+				},
 				{
 					Type:    "AllowedScopesValid",
 					Status:  "False",
@@ -608,6 +620,18 @@ func TestOIDCClientControllerValidations_Parallel(t *testing.T) {
 					Reason:  "NoClientSecretFound",
 					Message: `no client secret found (no Secret storage found)`,
 				},
+				{
+					Type:    "RequestObjectsValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"requestObjects" is valid`,
+				},
+				{
+					Type:    "TokenExchangeValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"tokenExchange" is valid`,
+				},
 			},
 		},
 		{
@@ -625,12 +649,24 @@ func TestOIDCClientControllerValidations_Parallel(t *testing.T) {
 			secret:    testutil.OIDCClientSecretStorageSecretWithoutName(t, env.SupervisorNamespace, []string{}),
 			wantPhase: "Error",
 			wantConditions: []metav1.Condition{
+				{
+					Type:    "AdmissionPolicyValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"admissionPolicy" is valid`,
+				},
 				{
 					Type:    "AllowedGrantTypesValid",
 					Status:  "True",
 					Reason:  "Success",
 					Message: `"allowedGrantTypes" is valid`,
 				},
+				{
+					Type:    "AllowedIdentityProvidersValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"allowedIdentityProviders" is valid`,
+				},
 				{
 					Type:    "AllowedScopesValid",
 					Status:  "True",
@@ -643,6 +679,18 @@ func TestOIDCClientControllerValidations_Parallel(t *testing.T) {
 					Reason:  "NoClientSecretFound",
 					Message: `no client secret found (empty list in storage)`,
 				},
+				{
+					Type:    "RequestObjectsValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"requestObjects" is valid`,
+				},
+				{
+					Type:    "TokenExchangeValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"tokenExchange" is valid`,
+				},
 			},
 		},
 		{
@@ -660,12 +708,24 @@ func TestOIDCClientControllerValidations_Parallel(t *testing.T) {
 			secret:    testutil.OIDCClientSecretStorageSecretWithoutName(t, env.SupervisorNamespace, []string{testutil.HashedPassword1AtSupervisorMinCost}),
 			wantPhase: "Ready",
 			wantConditions: []metav1.Condition{
+				{
+					Type:    "AdmissionPolicyValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"admissionPolicy" is valid`,
+				},
 				{
 					Type:    "AllowedGrantTypesValid",
 					Status:  "True",
 					Reason:  "Success",
 					Message: `"allowedGrantTypes" is valid`,
 				},
+				{
+					Type:    "AllowedIdentityProvidersValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"allowedIdentityProviders" is valid`,
+				},
 				{
 					Type:    "AllowedScopesValid",
 					Status:  "True",
@@ -678,6 +738,18 @@ func TestOIDCClientControllerValidations_Parallel(t *testing.T) {
 					Reason:  "Success",
 					Message: `1 client secret(s) found`,
 				},
+				{
+					Type:    "RequestObjectsValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"requestObjects" is valid`,
+				},
+				{
+					Type:    "TokenExchangeValid",
+					Status:  "True",
+					Reason:  "Success",
+					Message: `"tokenExchange" is valid`,
+				},
 			},
 		},
 		// Note: there are many more possible combinations of these settings, but they are covered by the controller's